@@ -0,0 +1,121 @@
+//go:build !windows
+
+/*
+The brgnet utility is a single multiplexed binary bundling brgaddwg,
+brgaddawg, brgsetwg and brggetwg.
+
+Dispatch:
+  - Subcommands: `brgnet add|addawg|set|get [flags]`.
+  - `brgnet selftest` builds a loopback tunnel and reports pass or fail
+    per stage, see the selftest package.
+  - Busybox-style: when invoked through a symlink named after one of the
+    utilities (e.g. brgsetwg -> brgnet), that utility runs directly.
+*/
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/AlexKira/brgnetuse/internal/app/brgaddawg"
+	"github.com/AlexKira/brgnetuse/internal/app/brgaddwg"
+	"github.com/AlexKira/brgnetuse/internal/app/brggetwg"
+	"github.com/AlexKira/brgnetuse/internal/app/brgsetwg"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/selftest"
+)
+
+// Utilities bundled into brgnet, keyed by their standalone binary name.
+var applets = map[string]func(){
+	"brgaddwg":  brgaddwg.Main,
+	"brgaddawg": brgaddawg.Main,
+	"brgsetwg":  brgsetwg.Main,
+	"brggetwg":  brggetwg.Main,
+}
+
+// Subcommand names mapped to the utility they run.
+var subcommands = map[string]string{
+	"add":    "brgaddwg",
+	"addawg": "brgaddawg",
+	"set":    "brgsetwg",
+	"get":    "brggetwg",
+}
+
+// Subcommand running the self-test, it is not a standalone utility.
+const selftestCommand = "selftest"
+
+// Main entry point.
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == selftestCommand {
+		runSelftest(os.Args[2:])
+		return
+	}
+
+	name, args, ok := resolve(os.Args)
+	if !ok && len(os.Args) > 1 && help.IsVersionFlag(os.Args[1]) {
+		help.PrintVersion("brgnet")
+		return
+	}
+
+	if !ok {
+		help.BridgeNetHelp()
+		if len(os.Args) > 1 && os.Args[1] != help.HelpFlag {
+			help.ErrorExitMessage(os.Args[1], help.DefaultErrorMessage)
+			os.Exit(help.ExitSetupFailed)
+		}
+		return
+	}
+
+	// The utilities read os.Args, so present them the same argument list
+	// the standalone binary would have received.
+	os.Args = args
+	applets[name]()
+}
+
+// Function resolves which utility to run from the process arguments.
+// The basename of args[0] takes precedence (busybox-style symlinks),
+// otherwise args[1] is looked up as a subcommand.
+// It returns the utility name and the argument list rewritten into the
+// standalone layout, with the utility name as args[0].
+func resolve(args []string) (string, []string, bool) {
+	if len(args) == 0 {
+		return "", nil, false
+	}
+
+	if base := filepath.Base(args[0]); applets[base] != nil {
+		return base, args, true
+	}
+
+	if len(args) < 2 {
+		return "", nil, false
+	}
+
+	name, ok := subcommands[args[1]]
+	if !ok {
+		if applets[args[1]] == nil {
+			return "", nil, false
+		}
+		name = args[1]
+	}
+
+	return name, append([]string{name}, args[2:]...), true
+}
+
+// Function runs the self-test and writes its report, the exit status is
+// non-zero when a stage failed. It takes no arguments.
+func runSelftest(args []string) {
+	if len(args) > 0 {
+		help.BridgeNetHelp()
+		if args[0] != help.HelpFlag {
+			help.ErrorExitMessage(args[0], help.DefaultErrorMessage)
+			os.Exit(help.ExitSetupFailed)
+		}
+		return
+	}
+
+	report := selftest.Run(selftest.Options{})
+	report.Write(os.Stdout)
+	if !report.Passed() {
+		os.Exit(help.ExitSetupFailed)
+	}
+}
@@ -0,0 +1,220 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Environment variable selecting the entry point the test binary runs,
+// with the arguments of the invocation in envArgs, one per line.
+const (
+	envEntry = "BRGNET_TEST_ENTRY"
+	envArgs  = "BRGNET_TEST_ARGS"
+)
+
+// Function runs an entry point of the utilities when envEntry is set,
+// otherwise it runs the tests.
+func TestMain(m *testing.M) {
+	entry := os.Getenv(envEntry)
+	if entry == "" {
+		os.Exit(m.Run())
+	}
+
+	os.Args = strings.Split(os.Getenv(envArgs), "\n")
+	switch entry {
+	case "brgnet":
+		main()
+	case "standalone":
+		applets[os.Args[0]]()
+	}
+	os.Exit(0)
+}
+
+// Result of an entry point run in the test binary.
+type entryResult struct {
+	stdout, stderr string
+	status         int
+}
+
+// Function runs the test binary as the entry point with the arguments and
+// the standard input, and returns what it printed and its exit status.
+func runEntry(t *testing.T, entry string, args []string, stdin string) entryResult {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), envEntry+"="+entry, envArgs+"="+strings.Join(args, "\n"))
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	var result entryResult
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Fatalf("error: failed to run the %s entry point: %v", entry, err)
+		}
+		result.status = exitErr.ExitCode()
+	}
+	result.stdout, result.stderr = stdout.String(), stderr.String()
+	return result
+}
+
+// Testing that a subcommand invocation of brgnet resolves to the same utility
+// and argument list as the standalone binary and a busybox-style symlink.
+func TestResolveEntryPoints(t *testing.T) {
+	type testCase struct {
+		standalone []string
+		brgnet     []string
+		symlink    []string
+	}
+
+	tests := []testCase{
+		{
+			standalone: []string{"brgsetwg", "-i", "wg0", "-up"},
+			brgnet:     []string{"brgnet", "set", "-i", "wg0", "-up"},
+			symlink:    []string{"/usr/local/bin/brgsetwg", "-i", "wg0", "-up"},
+		},
+		{
+			standalone: []string{"brgaddwg", "-i", "wg0", "-m", "1420"},
+			brgnet:     []string{"brgnet", "add", "-i", "wg0", "-m", "1420"},
+			symlink:    []string{"/usr/local/bin/brgaddwg", "-i", "wg0", "-m", "1420"},
+		},
+		{
+			standalone: []string{"brgaddawg", "-i", "awg0"},
+			brgnet:     []string{"/opt/brgnet", "addawg", "-i", "awg0"},
+			symlink:    []string{"./brgaddawg", "-i", "awg0"},
+		},
+		{
+			standalone: []string{"brggetwg", "-i", "wg0", "-pr"},
+			brgnet:     []string{"brgnet", "get", "-i", "wg0", "-pr"},
+			symlink:    []string{"brggetwg", "-i", "wg0", "-pr"},
+		},
+		{
+			standalone: []string{"brggetwg"},
+			brgnet:     []string{"brgnet", "brggetwg"},
+			symlink:    []string{"/bin/brggetwg"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.standalone[0], func(t *testing.T) {
+			wantName, wantArgs, ok := resolve(tc.standalone)
+			if !ok {
+				t.Fatalf("error: standalone %q not resolved", tc.standalone)
+			}
+
+			for _, args := range [][]string{tc.brgnet, tc.symlink} {
+				name, got, ok := resolve(args)
+				if !ok {
+					t.Fatalf("error: %q not resolved", args)
+				}
+				if name != wantName {
+					t.Errorf("error: %q resolved to %q, want %q", args, name, wantName)
+				}
+				if !slices.Equal(got[1:], wantArgs[1:]) {
+					t.Errorf("error: %q resolved args %q, want %q", args, got[1:], wantArgs[1:])
+				}
+			}
+		})
+	}
+}
+
+// Testing that unknown subcommands and bare invocations are not resolved.
+func TestResolveUnknown(t *testing.T) {
+	tests := [][]string{
+		{},
+		{"brgnet"},
+		{"brgnet", "-h"},
+		{"brgnet", "remove", "-i", "wg0"},
+	}
+
+	for _, args := range tests {
+		if name, _, ok := resolve(args); ok {
+			t.Errorf("error: %q unexpectedly resolved to %q", args, name)
+		}
+	}
+}
+
+// Testing that the subcommands run as `brgnet get` and `brgnet set` print
+// and exit the same as the standalone binaries.
+func TestEntryPoints(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A network interface which does not exist.
+	const missing = "brgtest9"
+
+	type testCase struct {
+		name       string
+		subcommand string
+		args       []string
+		stdin      string
+		wantStdout string
+		wantOutput string // Printed to stdout or stderr.
+		wantStatus int
+	}
+
+	tests := []testCase{
+		{
+			name:       "get public key",
+			subcommand: "get",
+			args:       []string{"-pub", "-"},
+			stdin:      key.String() + "\n",
+			wantStdout: key.PublicKey().String() + "\n",
+		},
+		{name: "get invalid key", subcommand: "get", args: []string{"-pub", "not-a-key"}, wantStatus: 1},
+		{name: "get unknown flag", subcommand: "get", args: []string{"-bogus"}, wantStatus: 1},
+		{
+			name:       "set up missing interface",
+			subcommand: "set",
+			args:       []string{"-i", missing, "-up"},
+			wantOutput: "network interface '" + missing + "' not found",
+			wantStatus: 1,
+		},
+		{
+			name:       "set bad arguments",
+			subcommand: "set",
+			args:       []string{"-i"},
+			wantOutput: "arguments passed incorrectly",
+			wantStatus: 1,
+		},
+		{
+			name:       "set help",
+			subcommand: "set",
+			args:       []string{"-h"},
+			wantOutput: "Help using the utility: brgsetwg.",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tool := subcommands[tc.subcommand]
+			standalone := runEntry(t, "standalone", append([]string{tool}, tc.args...), tc.stdin)
+			brgnet := runEntry(t, "brgnet", append([]string{"brgnet", tc.subcommand}, tc.args...), tc.stdin)
+
+			if standalone.status != tc.wantStatus {
+				t.Errorf("error: standalone exited with %d, want %d: %s", standalone.status, tc.wantStatus, standalone.stderr)
+			}
+			if tc.wantStdout != "" && standalone.stdout != tc.wantStdout {
+				t.Errorf("error: standalone printed %q, want %q", standalone.stdout, tc.wantStdout)
+			}
+			if output := standalone.stdout + standalone.stderr; !strings.Contains(output, tc.wantOutput) {
+				t.Errorf("error: standalone printed %q, want %q", output, tc.wantOutput)
+			}
+			if brgnet != standalone {
+				t.Errorf("error: brgnet %s gave %+v, standalone gave %+v", tc.subcommand, brgnet, standalone)
+			}
+		})
+	}
+}
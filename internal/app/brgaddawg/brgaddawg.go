@@ -0,0 +1,126 @@
+//go:build !windows
+
+/*
+Package brgaddawg provides a utility to configure AmneziaWG network interfaces.
+
+Key Features:
+- Facilitates the creation and setup of AmneziaWG (obfuscated WireGuard) network interfaces.
+- Offers configurable logging with 'Debug' or 'Error' levels.
+- Supports both plain string and JSON log output formats.
+- Generates a dedicated log file per interface, named after the interface.
+- Optionally removes the rules and addresses recorded by brgsetwg on shutdown.
+- Recreates an existing interface of this tool on request (-force).
+- Sets the network interface alias shown by monitoring tools (-alias).
+- Restarts the device process when it exits, e.g., killed by the OOM killer (-supervise).
+- Listens on a given UDP port or the lowest free one of a range (-p auto), opened with -fr.
+
+This utility leverages components derived from:
+- https://github.com/amnezia-vpn/amneziawg-go (AmneziaWG Go implementation)
+
+For detailed information on AmneziaWG, refer to:
+- https://docs.amnezia.org/documentation/amnezia-wg
+*/
+
+package brgaddawg
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/launcher"
+	"github.com/AlexKira/brgnetuse/internal/middleware"
+	"github.com/AlexKira/brgnetuse/internal/startup"
+	"github.com/AlexKira/brgnetuse/src/add"
+	"github.com/AlexKira/brgnetuse/src/add/awg"
+	"github.com/amnezia-vpn/amneziawg-go/device"
+	"golang.org/x/sys/unix"
+)
+
+// Utility is the brgaddawg command line, parsed and run by the launcher
+// package shared with the other add utility.
+var Utility = launcher.Utility{
+	Name:      "brgaddawg",
+	Type:      help.Env_Awg_Type,
+	NewDevice: NewDevice,
+}
+
+// Main runs the utility with the process arguments.
+func Main() {
+	Utility.Main()
+}
+
+// Function sets up and starts a new AmneziaWG interface.
+// It initializes the logger, TUN device, UAPI socket,
+// and manages the device lifecycle.
+func NewDevice(p *launcher.Config) error {
+
+	var logger *device.Logger
+	var records *slog.Logger
+
+	// Configure logger: choose between JSON (via middleware) or plain text.
+	// Note: Type conversion `(*device.Logger)` is needed for middleware's output
+	// as it returns an original WireGuard logger type.
+	if p.LoggingJSON {
+		logging := middleware.LoggingStruct{
+			LogLevel:   p.LogLevel,
+			FuncName:   p.LoggerName,
+			Pid:        os.Getpid(),
+			MainThread: syscall.Gettid(),
+			Dedup:      p.LogDedup,
+			Events:     p.LogEvents,
+		}
+		logger = (*device.Logger)(logging.WgJsonLoggerMiddleware(p.InterfaceName))
+		records = logging.WgJsonRecordLogger(p.InterfaceName)
+	} else {
+		logger = device.NewLogger(
+			p.LogLevel,
+			fmt.Sprintf(
+				"[%s] %s %d %d ",
+				p.InterfaceName,
+				p.LoggerName,
+				os.Getpid(),
+				syscall.Gettid(),
+			),
+		)
+		records = middleware.TextRecordLogger(logger.Verbosef)
+	}
+
+	// The device is started by the awg package, shared with host programs
+	// embedding the devices.
+	dev, err := awg.Start(awg.Options{
+		InterfaceName: p.InterfaceName,
+		MTU:           p.MTU,
+		ForceMTU:      p.ForceMTU,
+		ListenPort:    p.ListenPort,
+		Alias:         p.Alias,
+		Cleanup:       p.Cleanup,
+		Logger:        logger,
+		Records:       records,
+	})
+	if err != nil {
+		return err
+	}
+	p.InterfaceName = dev.Name
+
+	startup.Ready()
+
+	// Wait for program to terminate
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, unix.SIGTERM)
+	signal.Notify(term, os.Interrupt)
+
+	select {
+	case <-term:
+	case <-dev.Wait():
+	}
+
+	launcher.LogCleanup(add.CleanupErrors(dev.Stop()), p.Cleanup, logger.Verbosef, logger.Errorf)
+
+	logger.Verbosef("Shutting down")
+
+	return nil
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+
+package brgaddawg
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/launcher"
+	"github.com/AlexKira/brgnetuse/internal/startup"
+)
+
+// Environment variable passing the interface name to the test binary
+// started as the background process.
+const envTestInterface = "BRGADDAWG_TEST_INTERFACE"
+
+// Function runs the test binary as the background process of Execute when
+// it is started with the foreground tag, otherwise it runs the tests.
+func TestMain(m *testing.M) {
+	if os.Getenv(help.Env_Field_Foreground) != "1" {
+		os.Exit(m.Run())
+	}
+
+	handlers.AwgSocketDir = os.TempDir()
+	awg := launcher.Config{InterfaceName: os.Getenv(envTestInterface)}
+	if err := Utility.Execute(os.Args, awg); err != nil {
+		os.Exit(help.ExitSetupFailed)
+	}
+	os.Exit(0)
+}
+
+// Testing that Execute fails when the background process cannot create
+// its device, with and without a log file.
+func TestExecuteTunFailure(t *testing.T) {
+	prev := startup.Timeout
+	startup.Timeout = 10 * time.Second
+	t.Cleanup(func() { startup.Timeout = prev })
+
+	// The name exceeds the kernel limit, the device is never created.
+	const iface = "brgtest-name-too-long0"
+	t.Setenv(envTestInterface, iface)
+
+	for _, logDir := range []string{"", t.TempDir()} {
+		awg := launcher.Config{InterfaceName: iface, PathLogDir: logDir}
+
+		err := Utility.Execute([]string{"brgaddawg", "-test.run=^$"}, awg)
+		if err == nil {
+			t.Fatalf("error: expected an error with log dir %q, got nil", logDir)
+		}
+
+		want := "error: network interface '" + iface + "' did not come up, error: network interface name"
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error: got %q, want it to contain %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+//go:build !windows
+
+/*
+The brgaddwg utility is designed to add WireGuard network interfaces.
+
+Features:
+- Configures a WireGuard network interface.
+- Enables and disables logging. The level can be: Debug or Error.
+- Provides two types of logging: String or JSON.
+- Creates a log file, based on the interface name.
+- Optionally removes the rules and addresses recorded by brgsetwg on shutdown.
+- Recreates an existing interface of this tool on request (-force).
+- Sets the network interface alias shown by monitoring tools (-alias).
+- Restarts the device process when it exits, e.g., killed by the OOM killer (-supervise).
+- Follows the peers whose endpoint host name resolves to a new address (-refresh-endpoints).
+- Listens on a given UDP port or the lowest free one of a range (-p auto), opened with -fr.
+
+This utility was developed based on:
+- https://github.com/WireGuard/wireguard-go/tree/master
+
+For detailed information on AmneziaWG, refer to:
+- https://www.wireguard.com
+*/
+
+package brgaddwg
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/launcher"
+	"github.com/AlexKira/brgnetuse/internal/middleware"
+	"github.com/AlexKira/brgnetuse/internal/startup"
+	"github.com/AlexKira/brgnetuse/src/add"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// Utility is the brgaddwg command line, parsed and run by the launcher
+// package shared with the other add utility.
+var Utility = launcher.Utility{
+	Name:      "brgaddwg",
+	Type:      help.Env_Wg_Type,
+	NewDevice: NewDevice,
+}
+
+// Main runs the utility with the process arguments.
+func Main() {
+	Utility.Main()
+}
+
+// Function sets up and starts a new WireGuard-Go interface.
+// It initializes the logger, TUN device, UAPI socket,
+// and manages the device lifecycle.
+func NewDevice(p *launcher.Config) error {
+
+	var logger *device.Logger
+	var records *slog.Logger
+
+	// Configure logger: choose between JSON (via middleware) or plain text.
+	// No type conversion is needed here, as middleware returns the original
+	// WireGuard device.Logger type.
+	if p.LoggingJSON {
+		logging := middleware.LoggingStruct{
+			LogLevel:   p.LogLevel,
+			FuncName:   p.LoggerName,
+			Pid:        os.Getpid(),
+			MainThread: syscall.Gettid(),
+			Dedup:      p.LogDedup,
+			Events:     p.LogEvents,
+		}
+		logger = logging.WgJsonLoggerMiddleware(p.InterfaceName)
+		records = logging.WgJsonRecordLogger(p.InterfaceName)
+	} else {
+		logger = device.NewLogger(
+			p.LogLevel,
+			fmt.Sprintf(
+				"[%s] %s %d %d ",
+				p.InterfaceName,
+				p.LoggerName,
+				os.Getpid(),
+				syscall.Gettid(),
+			),
+		)
+		records = middleware.TextRecordLogger(logger.Verbosef)
+	}
+
+	// The device is started by the add package, shared with host programs
+	// embedding the devices.
+	dev, err := add.Start(add.Options{
+		InterfaceName: p.InterfaceName,
+		MTU:           p.MTU,
+		ForceMTU:      p.ForceMTU,
+		ListenPort:    p.ListenPort,
+		Alias:         p.Alias,
+		Cleanup:       p.Cleanup,
+		Logger:        logger,
+		Records:       records,
+	})
+	if err != nil {
+		return err
+	}
+	p.InterfaceName = dev.Name
+
+	startup.Ready()
+
+	// Re-resolve the endpoint host names of the peers until shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if p.RefreshEndpoints > 0 {
+		go launcher.RefreshEndpoints(ctx, p.InterfaceName, p.RefreshEndpoints, logger.Verbosef, logger.Errorf)
+	}
+
+	// Wait for program to terminate
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, unix.SIGTERM)
+	signal.Notify(term, os.Interrupt)
+
+	select {
+	case <-term:
+	case <-dev.Wait():
+	}
+	cancel()
+
+	launcher.LogCleanup(add.CleanupErrors(dev.Stop()), p.Cleanup, logger.Verbosef, logger.Errorf)
+
+	logger.Verbosef("Shutting down")
+
+	return nil
+}
@@ -0,0 +1,224 @@
+//go:build !windows
+
+package brgaddwg
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/launcher"
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/internal/startup"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/proc"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Environment variable passing the interface name to the test binary
+// started as the background process.
+const envTestInterface = "BRGADDWG_TEST_INTERFACE"
+
+// Environment variable passing the spec file to the test binary started as
+// the background process. Its UAPI socket is then kept in the default
+// directory, where the spec is applied.
+const envTestSpec = "BRGADDWG_TEST_SPEC"
+
+// Function runs the test binary as the background process of Execute when
+// it is started with the foreground tag, otherwise it runs the tests.
+func TestMain(m *testing.M) {
+	if os.Getenv(help.Env_Field_Foreground) != "1" {
+		os.Exit(m.Run())
+	}
+
+	wg := launcher.Config{InterfaceName: os.Getenv(envTestInterface)}
+	if path := os.Getenv(envTestSpec); path != "" {
+		var err error
+		if wg, err = Utility.ParseArgs([]string{"brgaddwg", "-spec", path}); err != nil {
+			os.Exit(help.ExitSetupFailed)
+		}
+	} else {
+		handlers.WgSocketDir = os.TempDir()
+	}
+	if err := Utility.Execute(os.Args, wg); err != nil {
+		os.Exit(help.ExitSetupFailed)
+	}
+	os.Exit(0)
+}
+
+// Testing that Execute fails when the background process cannot create
+// its device, with and without a log file.
+func TestExecuteTunFailure(t *testing.T) {
+	prev := startup.Timeout
+	startup.Timeout = 10 * time.Second
+	t.Cleanup(func() { startup.Timeout = prev })
+
+	// The name exceeds the kernel limit, the device is never created.
+	const iface = "brgtest-name-too-long0"
+	t.Setenv(envTestInterface, iface)
+
+	for _, logDir := range []string{"", t.TempDir()} {
+		wg := launcher.Config{InterfaceName: iface, PathLogDir: logDir}
+
+		err := Utility.Execute([]string{"brgaddwg", "-test.run=^$"}, wg)
+		if err == nil {
+			t.Fatalf("error: expected an error with log dir %q, got nil", logDir)
+		}
+
+		want := "error: network interface '" + iface + "' did not come up, error: network interface name"
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error: got %q, want it to contain %q", err, want)
+		}
+	}
+}
+
+// Testing that Execute moves the network interface of the device into the
+// network namespace of -to-netns once it is up, and that it is gone from
+// the current one. The namespace and the TUN device require root.
+func TestExecuteToNetns(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating a network namespace requires root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("the ip command is not installed")
+	}
+
+	ns := "brgnetuse-test-" + strconv.Itoa(os.Getpid())
+	if out, err := exec.Command("ip", "netns", "add", ns).CombinedOutput(); err != nil {
+		t.Skipf("failed to create a network namespace: %v, %s", err, out)
+	}
+	t.Cleanup(func() { exec.Command("ip", "netns", "del", ns).Run() })
+
+	prevMeta := peermeta.Dir
+	peermeta.Dir = t.TempDir()
+	t.Cleanup(func() { peermeta.Dir = prevMeta })
+
+	const iface = "brgmove0"
+	t.Setenv(envTestInterface, iface)
+
+	wg := launcher.Config{InterfaceName: iface, ToNetns: ns}
+	err := Utility.Execute([]string{"brgaddwg", "-test.run=^$"}, wg)
+	t.Cleanup(func() {
+		if pid, _, err := proc.FindProcess(iface); err == nil && pid != 0 {
+			syscall.Kill(pid, syscall.SIGTERM)
+		}
+	})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if out, err := exec.Command("ip", "-n", ns, "link", "show", iface).CombinedOutput(); err != nil {
+		t.Errorf("error: %s not found in network namespace %s: %v, %s", iface, ns, err, out)
+	}
+	if exists, err := get.GetExistInterface(iface); err != nil || exists {
+		t.Errorf("error: %s is still in the current network namespace (%v)", iface, err)
+	}
+}
+
+// Testing that Execute brings up a full interface from a spec file: the
+// key, listen port, address, forwarding, NAT rules and peer of the spec
+// are in place once it returns. It runs inside a network namespace, which
+// like the TUN device requires root.
+func TestExecuteSpec(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating a network namespace requires root")
+	}
+	for _, cmd := range []string{"ip", "iptables"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			t.Skipf("the %s command is not installed", cmd)
+		}
+	}
+
+	ns := "brgnetuse-spec-" + strconv.Itoa(os.Getpid())
+	if out, err := exec.Command("ip", "netns", "add", ns).CombinedOutput(); err != nil {
+		t.Skipf("failed to create a network namespace: %v, %s", err, out)
+	}
+	t.Cleanup(func() { exec.Command("ip", "netns", "del", ns).Run() })
+
+	prevMeta, prevRunner, prevClient, prevNetns := peermeta.Dir, shell.DefaultRunner, handlers.NewWgClient, netns.Name
+	peermeta.Dir = t.TempDir()
+	t.Cleanup(func() {
+		peermeta.Dir, shell.DefaultRunner, handlers.NewWgClient, netns.Name = prevMeta, prevRunner, prevClient, prevNetns
+	})
+	if err := shell.UseNamespace(ns); err != nil {
+		t.Fatal(err)
+	}
+
+	const iface = "brgspec0"
+
+	dir := t.TempDir()
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "wg.key"), []byte(key.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	data := fmt.Sprintf(`{
+  "name": %q,
+  "listen_port": 51899,
+  "private_key_file": "wg.key",
+  "addresses": ["10.99.99.254/24"],
+  "forwarding": true,
+  "nat": [{"subnet": "10.99.99.0/24", "out_interface": "eth0"}],
+  "peers": [{"public_key": %q, "allowed_ips": ["10.99.99.2/32"], "persistent_keepalive": 25}]
+}`, iface, peer.PublicKey().String())
+	path := filepath.Join(dir, "wg0.json")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envTestSpec, path)
+
+	wg, err := Utility.ParseArgs([]string{"brgaddwg", "-spec", path})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	err = Utility.Execute([]string{"brgaddwg", "-test.run=^$"}, wg)
+	t.Cleanup(func() {
+		if pid, _, err := proc.FindProcess(iface); err == nil && pid != 0 {
+			syscall.Kill(pid, syscall.SIGTERM)
+		}
+	})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	client, err := handlers.InitWgCtlClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	device, err := client.Device(iface)
+	if err != nil {
+		t.Fatalf("error: failed to read the device: %v", err)
+	}
+	if device.PublicKey != key.PublicKey() || device.ListenPort != 51899 {
+		t.Errorf("error: got public key %s and port %d", device.PublicKey, device.ListenPort)
+	}
+	if len(device.Peers) != 1 || device.Peers[0].PublicKey != peer.PublicKey() {
+		t.Errorf("error: got peers %v", device.Peers)
+	}
+
+	out, err := exec.Command("ip", "-n", ns, "addr", "show", iface).CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "10.99.99.254/24") {
+		t.Errorf("error: address not set (%v): %s", err, out)
+	}
+	out, err = exec.Command("ip", "netns", "exec", ns, "iptables", "-t", "nat", "-S", "POSTROUTING").CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "10.99.99.0/24") {
+		t.Errorf("error: NAT rule not added (%v): %s", err, out)
+	}
+}
@@ -0,0 +1,68 @@
+//go:build !windows
+
+package brggetwg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/backup"
+)
+
+// Function writes an encrypted backup of every WireGuard and AmneziaWG
+// network interface (see backup.Capture).
+// Expected format: `-backup [file] [-key-file path] [-force]`. Without
+// -key-file the passphrase is asked twice on the terminal; an existing file
+// is only overwritten with -force.
+func BackupCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) (string, error) {
+	if len(args) < 2 || args[0] != help.BackupFlag {
+		return help.BackupFlag, errors.New(help.DefaultErrorMessage)
+	}
+	path := args[1]
+
+	keyFile := ""
+	force := false
+	for i := 2; i < len(args); i++ {
+		switch {
+		case args[i] == help.KeyFileFlag && i+1 < len(args) && keyFile == "":
+			keyFile = args[i+1]
+			i++
+		case args[i] == help.ForceFlag && !force:
+			force = true
+		default:
+			return args[i], errors.New(help.DefaultErrorMessage)
+		}
+	}
+
+	if err := handlers.CheckPrivileged(); err != nil {
+		return help.BackupFlag, err
+	}
+	if _, err := os.Lstat(path); err == nil && !force {
+		return help.BackupFlag, help.Errorf(help.CodeConflict, "error: file '%s' already exists, pass -force to overwrite it", path)
+	}
+
+	bundle, err := backup.Capture()
+	if err != nil {
+		return help.BackupFlag, err
+	}
+
+	passphrase, err := handlers.ReadPassphrase(keyFile, stdin, stderr, true)
+	if err != nil {
+		return help.KeyFileFlag, err
+	}
+	defer passphrase.Zero()
+
+	if err := backup.Write(path, bundle, passphrase); err != nil {
+		return help.BackupFlag, err
+	}
+
+	fmt.Fprintf(stdout, "backup: %s\n", path)
+	for _, iface := range bundle.Interfaces {
+		fmt.Fprintf(stdout, "  %s: %s, %d peers\n", iface.Name, iface.Type, len(iface.Peers))
+	}
+	return help.BackupFlag, nil
+}
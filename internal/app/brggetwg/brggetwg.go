@@ -0,0 +1,1399 @@
+//go:build !windows
+
+/*
+The brggetwg utility is designed to retrieve information about the current state of the server's internal network configuration.
+
+Capabilities:
+- Retrieve the current IP configuration of network interfaces (IP addresses, subnet masks, etc.).
+- Retrieve detailed information about WireGuard interface and peer configurations.
+- Retrieve information about NAT and Firewall rules.
+- Retrieve the status of IPv4 and IPv6 forwarding.
+- Retrieve the DNS servers of network interfaces.
+- Retrieve when the configuration of a network interface last changed (time, operation, user ID).
+- Generate Base64-encoded private and public keys for WireGuard peer configuration.
+- Render client configurations (wg-quick, NetworkManager, MikroTik or a custom template).
+- Write an encrypted backup of the interface keys, peers and peer metadata.
+*/
+package brggetwg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/privs"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/get"
+)
+
+const (
+	Reset  = "\x1b[0m"
+	Green  = "\x1b[32m"
+	Bold   = "\x1b[1m"
+	Yellow = "\x1b[33m"
+	Cyan   = "\x1b[36m"
+	Red    = "\x1b[31m"
+)
+
+// Main runs the utility with the process arguments.
+func Main() {
+	// The global -netns flag may be given at any position.
+	args, err := help.ApplyNetnsFlag(os.Args)
+	if err != nil {
+		help.ErrorExit(help.NetnsFlag, err)
+		os.Exit(help.ExitSetupFailed)
+	}
+	os.Args = args
+
+	// So may the global -strict flag, see readPrivileged.
+	os.Args, strict = stripStrictFlag(os.Args)
+
+	if len(os.Args) < 2 || os.Args[1] == help.HelpFlag {
+		help.BridgeGetWgHelp()
+		return
+	}
+
+	if help.IsVersionFlag(os.Args[1]) {
+		help.PrintVersion("brggetwg")
+		return
+	}
+
+	os.Args = ParseWithEnv(os.Args, help.Environ())
+	lenghtArgs := len(os.Args) - 1
+
+	if isGroupArgs(os.Args) {
+		status, currentFlag, err := GroupCommand(os.Args[1:], os.Stdout, os.Stderr)
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+		os.Exit(status)
+	}
+
+	if os.Args[1] == help.PrivateKeyFlag && lenghtArgs > 1 {
+		currentFlag, err := KeyPairCommand(os.Args[1:], os.Stdout)
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+		return
+	}
+
+	if os.Args[1] == help.BackupFlag {
+		currentFlag, err := BackupCommand(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+		return
+	}
+
+	if lenghtArgs > 2 && os.Args[2] == help.CountersFlag {
+		currentFlag, err := CountersCommand(os.Args[1:], os.Stdout)
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+		return
+	}
+
+	if lenghtArgs == 3 && os.Args[2] == help.WatchFlag {
+		currentFlag, err := WatchCommand(os.Args[1:])
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+		return
+	}
+
+	if (os.Args[1] == help.FirewallFlag || os.Args[1] == help.NatFlag) && os.Args[lenghtArgs] == help.LogTypeFlag {
+		currentFlag, err := RulesJSONCommand(os.Args[1:], os.Stdout)
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+		return
+	}
+
+	if (lenghtArgs == 3 && (os.Args[2] == help.WgInterfaceFlag || os.Args[2] == help.SourceFlag)) ||
+		((os.Args[1] == help.FirewallFlag || os.Args[1] == help.NatFlag) && lenghtArgs > 1) {
+		currentFlag, err := RulesCommand(os.Args[1:])
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+		return
+	}
+
+	if os.Args[1] == help.OwnerFlag {
+		currentFlag, err := OwnerCommand(os.Args[1:], os.Stdout)
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+		return
+	}
+
+	if os.Args[1] == help.WgInterfaceFlag && lenghtArgs > 2 && os.Args[3] == help.CheckFlag {
+		status, currentFlag, err := HealthCommand(os.Args[1:], os.Stdout)
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+		os.Exit(status)
+	}
+
+	if os.Args[1] == help.WgInterfaceFlag && lenghtArgs > 2 && os.Args[3] == help.ClientFlag {
+		currentFlag, err := ClientConfigCommand(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+		return
+	}
+
+	if os.Args[1] == help.WgInterfaceFlag && lenghtArgs > 2 && os.Args[3] == help.ProbeFlag {
+		currentFlag, err := ProbeCommand(os.Args[1:], os.Stdout)
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+		return
+	}
+
+	if os.Args[1] == help.WgInterfaceFlag && lenghtArgs > 2 && os.Args[3] == help.WatchEventsFlag {
+		currentFlag, err := WatchEventsCommand(os.Args[1:], os.Stdout, os.Stderr)
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+		return
+	}
+
+	if os.Args[1] == help.WgInterfaceFlag && lenghtArgs > 3 {
+		currentFlag, err := GetInterfaceCommnd(os.Args[1:])
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+		return
+	}
+
+	switch lenghtArgs {
+	case 3:
+		currentFlag, err := GetInterfaceCommnd(os.Args[1:])
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+	case 2:
+		currentFlag, err := PublicKeyCommand(os.Args[1:], os.Stdin, os.Stdout)
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+	case 1:
+		currentFlag, err := SingleCommand(os.Args[1])
+		if err != nil {
+			help.ErrorExit(currentFlag, err)
+			os.Exit(help.ExitSetupFailed)
+		}
+
+	default:
+		help.ErrorExitMessage(
+			os.Args[lenghtArgs],
+			help.DefaultErrorMessage,
+		)
+		os.Exit(help.ExitSetupFailed)
+	}
+
+}
+
+// Enables standard output for shell commands.
+const ShellStd bool = true
+
+// Function applies the environment defaults to the arguments: `-i [name]`
+// with BRGNETUSE_INTERFACE for `-dns`, `-check`, `-watch-events` and
+// `-client` given without -i. The `-ip` and `-pr` commands keep listing every network
+// interface. It returns the arguments to run.
+func ParseWithEnv(args []string, env map[string]string) []string {
+	if len(args) < 2 {
+		return args
+	}
+	return slices.Concat(args[:1], help.EnvInterfaceArgs(args[1:], env, help.DNSFlag, help.CheckFlag, help.WatchEventsFlag, help.ClientFlag, help.StatusFlag))
+}
+
+// Function processes commands requiring an interface name and a sub-flag.
+// Expected format: `[main_flag] [interface_name] [sub_flag] [options]`.
+// It validates arguments, confirms interface existence, and then performs actions
+// like displaying peers, IP addresses or DNS servers based on the sub-flag.
+// The peer sub-flag accepts the `-k [key|prefix]` and `-e [ip[:port]]` filters
+// and the `-sort [handshake|rx|tx|ip|key] [-r]` ordering, `-limit [n]` and
+// `-offset [n]` show a page of the peers, and `-w [seconds]` refreshes the
+// view periodically. `-k` alone shows the peer in detail.
+// Returns the main flag string for error context or an error if validation/execution fails.
+func GetInterfaceCommnd(args []string) (string, error) {
+
+	var iFaceName string
+
+	if len(args) < 3 {
+		return help.WgInterfaceFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	opts := peerOptions{}
+	if len(args) > 3 {
+		if args[2] != help.PeerFlag {
+			return help.WgInterfaceFlag, errors.New(help.DefaultErrorMessage)
+		}
+
+		currentFlag, err := parsePeerOptions(args[3:], &opts)
+		if err != nil {
+			return currentFlag, help.WithDefaultCode(help.CodeInvalidValue, err)
+		}
+	}
+
+	iFaceName = args[1]
+
+	iface, err := get.GetExistInterface(iFaceName)
+	if err != nil {
+		return help.WgInterfaceFlag, err
+	}
+	if !iface {
+		return help.WgInterfaceFlag, &get.InterfaceNotFoundError{Name: iFaceName}
+	}
+
+	switch args[2] {
+	case help.PeerFlag:
+		backend, err := get.GetInterfaceBackend(iFaceName)
+		if err != nil {
+			return help.PeerFlag, err
+		}
+
+		if backend.AmneziaWG() {
+			if !opts.IsZero() {
+				return help.PeerFlag, help.Errorf(
+					help.CodeUnsupported,
+					"error: peer filters, sorting and JSON are not supported for AmneziaWG interface `%s`",
+					iFaceName,
+				)
+			}
+
+			fmt.Printf(Bold+"backend: "+Reset+"%s\n", backend)
+			if implementation, err := get.GetInterfaceImplementation(iFaceName); err == nil && implementation != "" {
+				fmt.Printf(Bold+"implementation: "+Reset+"%s\n", implementation)
+			}
+			err := readPrivileged(subsystemPeers, func() error {
+				return shell.DefaultRunner.Run(shell.FormatCmdAwgShow(iFaceName), ShellStd)
+			})
+			if err != nil {
+				return help.PeerFlag, err
+			}
+
+		} else {
+			if opts.Watch > 0 {
+				err := readPrivileged(subsystemPeers, func() error {
+					return watchWgInterface(iFaceName, opts)
+				})
+				if err != nil {
+					return help.WatchFlag, err
+				}
+			} else if err := printWgInterface(iFaceName, opts); err != nil {
+				return help.PeerFlag, err
+			}
+		}
+	case help.IpAddressFlag:
+		if err := printIP(iFaceName); err != nil {
+			return help.IpAddressFlag, err
+		}
+	case help.DNSFlag:
+		dns, err := get.GetInterfaceDNS(iFaceName)
+		if err != nil {
+			return help.DNSFlag, err
+		}
+		printDNS(os.Stdout, dns)
+	case help.StatusFlag:
+		change, err := get.GetInterfaceChange(iFaceName)
+		if err != nil {
+			return help.StatusFlag, err
+		}
+		implementation, err := get.GetInterfaceImplementation(iFaceName)
+		if err != nil {
+			return help.StatusFlag, err
+		}
+		printStatus(os.Stdout, iFaceName, implementation, change)
+	default:
+		return help.WgInterfaceFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	return help.WgInterfaceFlag, nil
+}
+
+// Peer listing options of the `-pr` sub-flag.
+type peerOptions struct {
+	Filter  get.PeerFilter
+	Sort    string
+	Reverse bool
+	Page    get.Page
+	Watch   time.Duration
+	JSON    bool
+}
+
+// Method reports whether the listing is a single unfiltered, unsorted
+// sample, printed as text.
+func (o peerOptions) IsZero() bool {
+	return o.Filter.IsZero() && o.Sort == "" && o.Page.IsZero() && o.Watch == 0 && !o.JSON
+}
+
+// Method reports whether a single peer is selected by its key alone, which
+// shows the peer in detail instead of the listing.
+func (o peerOptions) Detail() bool {
+	return o.Filter.Key != "" && o.Filter.Endpoint == "" && o.Sort == "" && o.Page.IsZero() && o.Watch == 0 && !o.JSON
+}
+
+// Function parses the peer listing options `-k [key|prefix]`,
+// `-e [ip[:port]]`, `-sort [key] [-r]`, `-limit [n]`, `-offset [n]`,
+// `-w [seconds]` and `-js`. Each option may be given once, `-r` requires
+// `-sort`, and `-js` cannot be combined with `-w`.
+func parsePeerOptions(args []string, opts *peerOptions) (string, error) {
+	args, page, currentFlag, err := cutPageFlags(args)
+	if err != nil {
+		return currentFlag, err
+	}
+	opts.Page = page
+
+	for i := 0; i < len(args); i++ {
+		if args[i] == help.ReverseFlag {
+			if opts.Reverse {
+				return args[i], errors.New(help.DefaultErrorMessage)
+			}
+			opts.Reverse = true
+			continue
+		}
+
+		if args[i] == help.LogTypeFlag {
+			if opts.JSON {
+				return args[i], errors.New(help.DefaultErrorMessage)
+			}
+			opts.JSON = true
+			continue
+		}
+
+		if i+1 >= len(args) || args[i+1] == "" {
+			return args[i], errors.New(help.DefaultErrorMessage)
+		}
+
+		if args[i] == help.WatchFlag {
+			if opts.Watch != 0 {
+				return args[i], errors.New(help.DefaultErrorMessage)
+			}
+			interval, err := parseInterval(args[i+1])
+			if err != nil {
+				return args[i], err
+			}
+			opts.Watch = interval
+			i++
+			continue
+		}
+
+		var field *string
+		switch args[i] {
+		case help.PeerKeyFlag:
+			field = &opts.Filter.Key
+		case help.PeerEndpointFlag:
+			field = &opts.Filter.Endpoint
+		case help.SortFlag:
+			field = &opts.Sort
+		default:
+			return args[i], errors.New(help.DefaultErrorMessage)
+		}
+
+		if *field != "" {
+			return args[i], errors.New(help.DefaultErrorMessage)
+		}
+		*field = args[i+1]
+		i++
+	}
+
+	if opts.Reverse && opts.Sort == "" {
+		return help.ReverseFlag, errors.New(help.DefaultErrorMessage)
+	}
+	if opts.JSON && opts.Watch != 0 {
+		return help.LogTypeFlag, help.Errorf(
+			help.CodeInvalidArguments,
+			"error: '%s' cannot be combined with '%s'", help.LogTypeFlag, help.WatchFlag,
+		)
+	}
+
+	return help.PeerFlag, nil
+}
+
+// Function removes the pagination options `-limit [n]` and `-offset [n]`
+// from the arguments, at any position, and returns the page they select.
+// Each option may be given once.
+func cutPageFlags(args []string) ([]string, get.Page, string, error) {
+	var page get.Page
+	seen := make(map[string]bool)
+	rest := make([]string, 0, len(args))
+
+	for i := 0; i < len(args); i++ {
+		flag := args[i]
+		if flag != help.LimitFlag && flag != help.OffsetFlag {
+			rest = append(rest, flag)
+			continue
+		}
+		if seen[flag] || i+1 >= len(args) {
+			return nil, get.Page{}, flag, errors.New(help.DefaultErrorMessage)
+		}
+		seen[flag] = true
+
+		value, err := strconv.Atoi(args[i+1])
+		if flag == help.LimitFlag {
+			if err != nil || value <= 0 {
+				return nil, get.Page{}, flag, help.Errorf(
+					help.CodeInvalidValue,
+					"error: invalid limit '%s', expected a positive number", args[i+1],
+				)
+			}
+			page.Limit = value
+		} else {
+			if err != nil || value < 0 {
+				return nil, get.Page{}, flag, help.Errorf(
+					help.CodeInvalidValue,
+					"error: invalid offset '%s', expected a number of items to skip", args[i+1],
+				)
+			}
+			page.Offset = value
+		}
+		i++
+	}
+
+	return rest, page, "", nil
+}
+
+// Function prints the footer of a paginated listing.
+func printPageFooter(w io.Writer, count get.PageCount, noun string) {
+	fmt.Fprintln(w, count.Format(noun))
+}
+
+// Function parses the watch interval in whole seconds.
+func parseInterval(value string) (time.Duration, error) {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, help.Errorf(help.CodeInvalidValue, "error: invalid watch interval '%s', expected a positive number of seconds", value)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// Function refreshes a view periodically until Ctrl-C.
+// Expected format: `[-pr|-fr|-n] -w [seconds]`. The peer view shows the
+// WireGuard devices read through wgctrl, see watchWgInterface.
+func WatchCommand(args []string) (string, error) {
+	if len(args) != 3 || args[1] != help.WatchFlag {
+		return help.WatchFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	interval, err := parseInterval(args[2])
+	if err != nil {
+		return help.WatchFlag, err
+	}
+
+	switch args[0] {
+	case help.PeerFlag:
+		err = watchWgInterface("", peerOptions{Watch: interval})
+	case help.FirewallFlag:
+		err = watchRules(false, interval)
+	case help.NatFlag:
+		err = watchRules(true, interval)
+	default:
+		return args[0], errors.New(help.DefaultErrorMessage)
+	}
+	if err != nil {
+		return args[0], err
+	}
+
+	return help.WatchFlag, nil
+}
+
+// Function displays the firewall or NAT table rules, all or those of an
+// interface or a source subnet. `-limit [n]` and `-offset [n]` show a page
+// of the rules, counted across the chains, followed by a "showing X of Y"
+// footer.
+// Expected format: `[-fr|-n] [-i name | -s subnet] [-limit n] [-offset n]`.
+func RulesCommand(args []string) (string, error) {
+	args, page, currentFlag, err := cutPageFlags(args)
+	if err != nil {
+		return currentFlag, err
+	}
+	if len(args) == 0 {
+		return help.FirewallFlag, errors.New(help.DefaultErrorMessage)
+	}
+	if (len(args) != 1 && len(args) != 3) || (args[0] != help.FirewallFlag && args[0] != help.NatFlag) {
+		return args[0], errors.New(help.DefaultErrorMessage)
+	}
+
+	result, err := getRules(args[0] == help.NatFlag)
+	if err != nil {
+		return args[0], err
+	}
+
+	if len(args) == 3 {
+		result, currentFlag, err = filterRuleSet(result, args[1], args[2])
+		if err != nil {
+			return currentFlag, err
+		}
+	}
+
+	result, count := get.PageRules(result, page)
+	printRuleSet(result, nil)
+	if !page.IsZero() {
+		printPageFooter(os.Stdout, count, "rules")
+	}
+	printParseWarnings(os.Stderr, result.Warnings)
+	return args[0], nil
+}
+
+// Function writes the firewall or NAT table rules as JSON, with the lines
+// the parser skipped in the warnings array, so the view can be checked for
+// completeness. The chains and rules keep the order of the get layer, see
+// get.SortChains, and `-limit [n]` and `-offset [n]` select a page of them.
+// Expected format: `[-fr|-n] [-i name | -s subnet] [-limit n] [-offset n] -js`.
+func RulesJSONCommand(args []string, stdout io.Writer) (string, error) {
+	args, page, currentFlag, err := cutPageFlags(args)
+	if err != nil {
+		return currentFlag, err
+	}
+	if (len(args) != 2 && len(args) != 4) || args[len(args)-1] != help.LogTypeFlag ||
+		(args[0] != help.FirewallFlag && args[0] != help.NatFlag) {
+		return help.LogTypeFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	result, err := getRules(args[0] == help.NatFlag)
+	if err != nil {
+		return args[0], err
+	}
+
+	if len(args) == 4 {
+		result, currentFlag, err = filterRuleSet(result, args[1], args[2])
+		if err != nil {
+			return currentFlag, err
+		}
+	}
+
+	result, _ = get.PageRules(result, page)
+	if err := writeRulesJSON(stdout, result); err != nil {
+		return help.LogTypeFlag, err
+	}
+	return args[0], nil
+}
+
+// Function encodes the rules as indented JSON. The chains and warnings are
+// arrays, also when empty.
+func writeRulesJSON(w io.Writer, result get.IptablesOutput) error {
+	if result.Chains == nil {
+		result.Chains = []get.IptablesChain{}
+	}
+	if result.Warnings == nil {
+		result.Warnings = []get.ParseWarning{}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		return fmt.Errorf("error: failed to encode the rules: %v", err)
+	}
+	return nil
+}
+
+// Function prints the lines of the listing the parser skipped, the shown
+// rules are then incomplete.
+func printParseWarnings(w io.Writer, warnings []get.ParseWarning) {
+	for _, warning := range warnings {
+		fmt.Fprintln(w, Yellow+"warning: skipped iptables "+warning.String()+Reset)
+	}
+}
+
+// Function reduces the rules to those of an interface (-i) or of a source
+// subnet (-s). The interface is matched as input or output, since FORWARD
+// rules carry both while MASQUERADE rules only carry the output interface.
+func filterRuleSet(rules get.IptablesOutput, flag, value string) (get.IptablesOutput, string, error) {
+	filter := get.FilterIptablesOutput{Rule: rules}
+
+	if value == "" {
+		return get.IptablesOutput{}, flag, errors.New(help.DefaultErrorMessage)
+	}
+
+	switch flag {
+	case help.WgInterfaceFlag:
+		return filter.FilterByInterface(value, value), flag, nil
+	case help.SourceFlag:
+		result, err := filter.FilterBySource(value)
+		return result, flag, err
+	}
+
+	return get.IptablesOutput{}, flag, errors.New(help.DefaultErrorMessage)
+}
+
+// Function checks the health of a WireGuard interface.
+// Expected format: `-i [name] -check [-max-handshake sec] [-min-peers n] [-ignore-new]`.
+// One line is printed per failed check, and the returned status is the exit
+// code: 0 healthy, 1 degraded, 2 critical. A collection error is critical.
+func HealthCommand(args []string, stdout io.Writer) (int, string, error) {
+	if len(args) < 3 || args[0] != help.WgInterfaceFlag || args[2] != help.CheckFlag {
+		return 0, help.CheckFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	opts := get.HealthOptions{
+		Interface:    args[1],
+		MaxHandshake: get.DefaultMaxHandshake,
+		MinPeers:     get.DefaultMinPeers,
+	}
+
+	for i := 3; i < len(args); i++ {
+		switch args[i] {
+		case help.IgnoreNewFlag:
+			opts.IgnoreNew = true
+			continue
+		case help.MaxHandshakeFlag, help.MinPeersFlag:
+		default:
+			return 0, args[i], errors.New(help.DefaultErrorMessage)
+		}
+
+		if i+1 >= len(args) {
+			return 0, args[i], errors.New(help.DefaultErrorMessage)
+		}
+		value, err := strconv.Atoi(args[i+1])
+		if err != nil || value < 0 {
+			return 0, args[i], help.Errorf(help.CodeInvalidValue, "error: invalid value '%s', expected a non-negative number", args[i+1])
+		}
+
+		if args[i] == help.MaxHandshakeFlag {
+			opts.MaxHandshake = time.Duration(value) * time.Second
+		} else {
+			opts.MinPeers = value
+		}
+		i++
+	}
+
+	report, err := get.CheckHealth(opts)
+	if err != nil {
+		fmt.Fprintf(stdout, "%s: %s\n", get.Critical, err)
+		return int(get.Critical), help.CheckFlag, nil
+	}
+
+	printHealth(stdout, report)
+	return int(report.Status), help.CheckFlag, nil
+}
+
+// Function prints one line per failed check, or a single status line
+// for a healthy interface.
+func printHealth(stdout io.Writer, report get.Report) {
+	if len(report.Failures) == 0 {
+		fmt.Fprintf(stdout, "%s: %s\n", report.Interface, get.Healthy)
+		return
+	}
+
+	for _, failure := range report.Failures {
+		fmt.Fprintln(stdout, failure)
+	}
+}
+
+// Function handles single-flag operations that do not require additional
+// arguments. It dispatches to specific helper functions based on the provided
+// flag. Examples include displaying all IP addresses, generating keys, or showing
+// firewall rules. Returns the processed flag string (for error context)
+// or an error if an operation fails.
+func SingleCommand(flag string) (string, error) {
+
+	switch flag {
+	case help.IpAddressFlag:
+		if err := printIP(""); err != nil {
+			return help.IpAddressFlag, err
+		}
+	case help.PeerFlag:
+
+		err := readPrivileged(subsystemPeers, func() error {
+			return shell.DefaultRunner.Run(shell.FormatCmdAwgShow(""), ShellStd)
+		})
+		if err != nil {
+			return help.PeerFlag, err
+		}
+
+		if err := printWgInterface("", peerOptions{}); err != nil {
+			return help.PeerFlag, err
+		}
+
+	case help.ForwardingFlag:
+		resultMap, err := get.GetIPvForwarding()
+		if err != nil {
+			return help.ForwardingFlag, err
+		}
+
+		printFw(resultMap)
+
+	case help.FirewallFlag:
+		if err := printRules(false); err != nil {
+			return help.FirewallFlag, err
+		}
+
+	case help.NatFlag:
+		if err := printRules(true); err != nil {
+			return help.NatFlag, err
+		}
+	case help.SocketsFlag:
+		sockets, err := handlers.ListSockets()
+		if err != nil {
+			return help.SocketsFlag, err
+		}
+
+		printSockets(os.Stdout, sockets)
+
+	case help.CapsFlag:
+		set, err := privs.Effective()
+		if err != nil {
+			return help.CapsFlag, err
+		}
+
+		printCapabilities(os.Stdout, set)
+
+	case help.PrivateKeyFlag:
+		keys, err := get.GenerateKeyPair()
+		if err != nil {
+			return help.PrivateKeyFlag, err
+		}
+		defer keys.Zero()
+
+		printWgKey(os.Stdout, keys)
+
+	default:
+		return flag, errors.New(help.DefaultErrorMessage)
+
+	}
+
+	return flag, nil
+}
+
+// Function writes a generated key pair to files.
+// Expected format: `-pk -o [dir] [-force]`. Only the public key is printed,
+// so the private key never reaches the terminal or shell scrollback.
+func KeyPairCommand(args []string, stdout io.Writer) (string, error) {
+	if len(args) < 3 || len(args) > 4 || args[1] != help.OutDirFlag {
+		return help.PrivateKeyFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	force := false
+	if len(args) == 4 {
+		if args[3] != help.ForceFlag {
+			return args[3], errors.New(help.DefaultErrorMessage)
+		}
+		force = true
+	}
+
+	var keys get.KeyPair
+	var err error
+	if force {
+		keys, err = get.WriteKeyPairForce(args[2])
+	} else {
+		keys, err = get.WriteKeyPair(args[2])
+	}
+	if err != nil {
+		return help.OutDirFlag, err
+	}
+
+	keys.Zero()
+
+	fmt.Fprintln(stdout, keys.Public.String())
+	return help.PrivateKeyFlag, nil
+}
+
+// Function prints the network interfaces holding an address.
+// Expected format: `-owner [ip] [-contains]`, with -contains the interfaces
+// whose prefix contains the address are printed too, the most specific first.
+// Every match is printed, the same address may be set on several interfaces.
+func OwnerCommand(args []string, stdout io.Writer) (string, error) {
+	if len(args) < 2 || len(args) > 3 || args[0] != help.OwnerFlag {
+		return help.OwnerFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	contains := false
+	if len(args) == 3 {
+		if args[2] != help.ContainsFlag {
+			return args[2], errors.New(help.DefaultErrorMessage)
+		}
+		contains = true
+	}
+
+	owners, err := get.FindInterfacesByIP(args[1], contains)
+	if err != nil {
+		return help.OwnerFlag, err
+	}
+
+	for _, owner := range owners {
+		fmt.Fprintf(stdout, "name: %s\n  address: %s\n", owner.Interface, owner.Prefix.String())
+	}
+	return help.OwnerFlag, nil
+}
+
+// Function prints the public key derived from a private key.
+// Expected format: `-pub [private_key|-]`, where '-' reads the key from stdin
+// (see handlers.ReadKey), so `brggetwg -pk | brggetwg -pub -` works.
+// Only the public key is printed, so the output can be used in scripts.
+// The private key is never included in the returned error.
+func PublicKeyCommand(args []string, stdin io.Reader, stdout io.Writer) (string, error) {
+	if len(args) != 2 || args[0] != help.PublicKeyFlag {
+		return args[0], errors.New(help.DefaultErrorMessage)
+	}
+
+	var value handlers.Secret
+	if args[1] == help.StdinValue {
+		key, err := handlers.ReadKey(stdin)
+		if err != nil {
+			return help.PublicKeyFlag, err
+		}
+		value = key
+	} else {
+		value = handlers.NewSecret(args[1])
+	}
+	defer value.Zero()
+
+	key, err := handlers.CheckPrivateKey(value)
+	if err != nil {
+		return help.PublicKeyFlag, err
+	}
+	defer clear(key[:])
+
+	fmt.Fprintln(stdout, key.PublicKey().String())
+	return help.PublicKeyFlag, nil
+}
+
+// Function prints the DNS servers of a network interface.
+func printDNS(w io.Writer, dns get.InterfaceDNS) {
+	servers := "none"
+	if len(dns.Servers) > 0 {
+		servers = strings.Join(dns.Servers, ", ")
+	}
+	fmt.Fprintf(w, "name: %s\n  backend: %s\n  dns: %s\n", dns.Interface, dns.Backend, servers)
+}
+
+// Function prints the status of a network interface: the implementation
+// serving it, "unknown" when it cannot be told, and the last change of its
+// configuration, "never" when none is recorded.
+func printStatus(w io.Writer, iface, implementation string, change get.InterfaceChange) {
+	if implementation == "" {
+		implementation = "unknown"
+	}
+	fmt.Fprintf(w, "name: %s\n  implementation: %s\n  last modified: %s\n", iface, implementation, change)
+}
+
+// Function to show network interface data.
+func printIP(name string) error {
+	var result []get.IpInterfaceStructure
+	if name == "" {
+		resNet, err := get.GetIp()
+		if err != nil {
+			return err
+		}
+		result = resNet
+	} else {
+		resNet, err := get.GetIpShow(name)
+		if err != nil {
+			return err
+		}
+		result = resNet
+	}
+
+	interfaceFormat := `
+name: %s
+  index: %d
+  flags: %s
+  mtu: %d
+  qdisc: %s
+  operstate: %s
+  group: %s
+  txqlen: %d
+  link_type: %s
+  address: %s
+  broadcast: %s
+  alias: %s
+
+`
+	addressFormat := `
+addr_info: 
+  family: %s
+  local: %s,
+  prefixlen: %d
+  scope: %s
+  dynamic: %t
+  label: %s
+  valid_life_time: %d
+  preferred_life_time: %d
+
+`
+
+	for _, iface := range result {
+		fmt.Printf(
+			interfaceFormat,
+			iface.IfName,
+			iface.IfIndex,
+			iface.Flags,
+			iface.MTU,
+			iface.Qdisc,
+			iface.OperState,
+			iface.Group,
+			iface.TxQLen,
+			iface.LinkType,
+			iface.Address,
+			iface.Broadcast,
+			iface.IfAlias,
+		)
+		for _, addrInfo := range iface.AddrInfo {
+			fmt.Printf(
+				addressFormat,
+				addrInfo.Family,
+				addrInfo.Local,
+				addrInfo.Prefixlen,
+				addrInfo.Scope,
+				addrInfo.Dynamic,
+				addrInfo.Label,
+				addrInfo.ValidLifeTime,
+				addrInfo.PreferredLifeTime,
+			)
+		}
+	}
+	return nil
+}
+
+// Function to display WireGuard network interface information.
+// Only the peers selected by the filter are shown, in the requested order.
+func printWgInterface(name string, opts peerOptions) error {
+
+	var devices []get.DeviceInfo
+	err := readPrivileged(subsystemPeers, func() error {
+		var err error
+		devices, err = get.GetPeerInfo(name)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	devices, count, err := selectPeers(devices, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Detail() {
+		now := time.Now()
+		for _, d := range devices {
+			for _, p := range d.Peers {
+				fmt.Print(formatPeerDetail(d.Name, p, now))
+			}
+		}
+		return nil
+	}
+
+	if opts.JSON {
+		return stdoutError(writePeersJSON(os.Stdout, devices))
+	}
+
+	if err := writeDevices(os.Stdout, devices, nil); err != nil {
+		return stdoutError(err)
+	}
+	if !opts.Page.IsZero() {
+		printPageFooter(os.Stdout, count, "peers")
+	}
+	return nil
+}
+
+// Function refreshes the WireGuard interface information every opts.Watch
+// until Ctrl-C, reusing a single wgctrl client. Handshake updates and
+// counter increments since the previous sample are highlighted.
+func watchWgInterface(name string, opts peerOptions) error {
+	sampler, err := get.NewSampler()
+	if err != nil {
+		return err
+	}
+	defer sampler.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var prev []get.DeviceInfo
+	err = get.Watch(ctx, opts.Watch, func(context.Context) error {
+		devices, err := sampler.PeerInfo(name)
+		if err != nil {
+			return err
+		}
+
+		devices, count, err := selectPeers(devices, opts)
+		if err != nil {
+			return err
+		}
+
+		printWatchHeader(opts.Watch)
+		if err := writeDevices(os.Stdout, devices, get.PeerDeltas(prev, devices)); err != nil {
+			return err
+		}
+		if !opts.Page.IsZero() {
+			printPageFooter(os.Stdout, count, "peers")
+		}
+		prev = devices
+		return nil
+	})
+
+	// A reader going away ends the watch like Ctrl-C.
+	if errors.Is(err, syscall.EPIPE) {
+		return nil
+	}
+	return err
+}
+
+// Function applies the peer filter, sort order and page of the options.
+// Without -sort the peers keep the order of the get layer, by public key
+// (see get.SortDevices). It returns the peers shown out of those selected.
+func selectPeers(devices []get.DeviceInfo, opts peerOptions) ([]get.DeviceInfo, get.PageCount, error) {
+	devices, err := get.FilterPeers(devices, opts.Filter)
+	if err != nil {
+		return nil, get.PageCount{}, err
+	}
+
+	if opts.Sort != "" {
+		for _, d := range devices {
+			if err := get.SortPeers(d.Peers, opts.Sort, opts.Reverse); err != nil {
+				return nil, get.PageCount{}, err
+			}
+		}
+	}
+
+	devices, count := get.PagePeers(devices, opts.Page)
+	return devices, count, nil
+}
+
+// Function starts a new watch frame: the screen is cleared on a terminal,
+// a separator line is printed otherwise (e.g., output piped to a file).
+func printWatchHeader(interval time.Duration) {
+	now := time.Now().Format(time.DateTime)
+
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Printf("\x1b[H\x1b[2J"+Bold+"Every %s: %s"+Reset+"    %s\n",
+			interval, strings.Join(os.Args[1:], " "), now)
+		return
+	}
+
+	fmt.Printf("--- %s ---\n", now)
+}
+
+// Function formats byte counts into human-readable strings (B, KiB, MiB, GiB)
+// with units colored in Cyan.
+func formatBytes(bytes int64) string {
+	return string(appendBytes(nil, bytes))
+}
+
+// Function appends the byte count formatted as by formatBytes to dst, so
+// the peer listing formats its counters without a string per value.
+func appendBytes(dst []byte, bytes int64) []byte {
+	const (
+		_   = iota
+		KiB = 1 << (10 * iota) // 1 KiB = 1024 bytes
+		MiB = 1 << (10 * iota) // 1 MiB = 1024 KiB
+		GiB = 1 << (10 * iota)
+	)
+
+	fBytes := float64(bytes)
+	switch {
+	case fBytes >= GiB:
+		dst = strconv.AppendFloat(dst, fBytes/GiB, 'f', 2, 64)
+		return append(dst, " "+Cyan+"GiB"+Reset...)
+	case fBytes >= MiB:
+		dst = strconv.AppendFloat(dst, fBytes/MiB, 'f', 2, 64)
+		return append(dst, " "+Cyan+"MiB"+Reset...)
+	case fBytes >= KiB:
+		dst = strconv.AppendFloat(dst, fBytes/KiB, 'f', 2, 64)
+		return append(dst, " "+Cyan+"KiB"+Reset...)
+	default:
+		dst = strconv.AppendInt(dst, bytes, 10)
+		return append(dst, " "+Cyan+"B"+Reset...)
+	}
+}
+
+// Function formats the quota of a peer: the usage, the limit and the
+// percentage, yellow from 80% and red over the limit, and the period.
+func formatQuota(q get.QuotaInfo) string {
+	percent := fmt.Sprintf("%.1f%%", q.Percent)
+	switch {
+	case q.Used > q.Limit:
+		percent = Red + percent + Reset
+	case q.Percent >= 80:
+		percent = Yellow + percent + Reset
+	}
+
+	period := "never reset"
+	if q.Period > 0 {
+		period = "every " + (time.Duration(q.Period) * time.Second).String()
+		if q.Period%86400 == 0 {
+			period = fmt.Sprintf("every %dd", q.Period/86400)
+		}
+	}
+
+	return fmt.Sprintf(
+		"%s of %s (%s), %s",
+		formatBytes(int64(min(q.Used, math.MaxInt64))),
+		formatBytes(int64(min(q.Limit, math.MaxInt64))),
+		percent, period,
+	)
+}
+
+// Function formats the latest handshake of a peer (RFC 3339) with its age
+// relative to now, "(none)" for a peer which never completed one.
+func formatHandshake(handshake string, now time.Time) string {
+	t, err := time.Parse(time.RFC3339, handshake)
+	if err != nil {
+		return "(none)"
+	}
+	age := max(now.Sub(t), 0).Truncate(time.Second)
+	return fmt.Sprintf("%s (%s ago)", t.UTC().Format(time.DateTime+" MST"), age)
+}
+
+// Function formats every field of a single peer for the detail view of
+// `-pr -k [key]`: the preshared key presence (never the key), the protocol
+// version, the latest handshake time with its age relative to now, and the
+// allowed IPs one per line. The name, note, expiry and quota are shown
+// when the peer metadata sets them.
+func formatPeerDetail(iface string, p get.PeerInfo, now time.Time) string {
+	var b strings.Builder
+	field := func(name, format string, args ...any) {
+		fmt.Fprintf(&b, Bold+"  %s: "+Reset+format+"\n", append([]any{name}, args...)...)
+	}
+
+	fmt.Fprintf(&b, "\n"+Bold+Yellow+"peer: "+Reset+Yellow+"%s"+Reset+"\n", p.PublicKey)
+	field("interface", "%s", iface)
+	if p.Name != "" {
+		field("name", "%s", p.Name)
+	}
+
+	presharedKey := "not set"
+	if p.PresharedKey {
+		presharedKey = Green + "set" + Reset
+	}
+	field("preshared key", "%s", presharedKey)
+	field("protocol version", "%d", p.ProtocolVersion)
+
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = "<nil>"
+	}
+	field("endpoint", "%s", endpoint)
+
+	field("latest handshake", "%s", formatHandshake(p.LastHandshake, now))
+
+	field("transfer", "%s received, %s sent", formatBytes(p.ReceiveBytes), formatBytes(p.TransmitBytes))
+
+	keepalive := "off"
+	if p.PersistentKeepalive > 0 {
+		keepalive = fmt.Sprintf("every %d "+Cyan+"seconds"+Reset, p.PersistentKeepalive)
+	}
+	field("persistent keepalive", "%s", keepalive)
+
+	b.WriteString(Bold + "  allowed ips:" + Reset + "\n")
+	for _, ip := range p.AllowedIPs {
+		fmt.Fprintf(&b, "    %s\n", strings.ReplaceAll(ip, "/", Cyan+"/"+Reset))
+	}
+
+	if p.Note != "" {
+		field("note", "%s", p.Note)
+	}
+	if p.Expires != "" {
+		expires := p.Expires
+		if t, err := time.Parse(time.RFC3339, p.Expires); err == nil && !now.Before(t) {
+			expires = Red + expires + " (expired)" + Reset
+		}
+		field("expires", "%s", expires)
+	}
+	if p.Quota != nil {
+		field("quota", "%s", formatQuota(*p.Quota))
+	}
+
+	return b.String()
+}
+
+// Function to display IPv4 and IPv6 network forwarding information.
+func printFw(p map[string]int) {
+	fmt.Printf(`
+net.ipv4.ip_forward: %d
+net.ipv6.conf.all.forwarding: %d
+
+`,
+		p["ipv4"],
+		p["ipv6"],
+	)
+}
+
+// Function displays the UAPI sockets and the state of their owning processes.
+func printSockets(stdout io.Writer, sockets []handlers.UAPISocket) {
+	if len(sockets) == 0 {
+		fmt.Fprintln(stdout, "no UAPI sockets found")
+		return
+	}
+
+	for _, socket := range sockets {
+		var state string
+		switch {
+		case socket.Pid != 0 && socket.Alive:
+			state = fmt.Sprintf("alive, process %d", socket.Pid)
+		case socket.Pid != 0:
+			state = fmt.Sprintf("not responding, process %d", socket.Pid)
+		case socket.Alive:
+			state = "alive, untracked process"
+		default:
+			state = "stale, no process"
+		}
+
+		fmt.Fprintf(stdout, "%-15s %-4s %-40s %s\n", socket.Interface, socket.Type, socket.Path, state)
+	}
+}
+
+// Function to display firewall and NAT table rules.
+func printRules(nat bool) error {
+	result, err := getRules(nat)
+	if err != nil {
+		return err
+	}
+
+	printRuleSet(result, nil)
+	printParseWarnings(os.Stderr, result.Warnings)
+	return nil
+}
+
+// Function reads the firewall or NAT table rules. Without root the table
+// is skipped and no rule is returned, see readPrivileged.
+func getRules(nat bool) (get.IptablesOutput, error) {
+	subsystem, list := subsystemFirewall, get.GetIptablesFirewall
+	if nat {
+		subsystem, list = subsystemNat, get.GetIptablesNAT
+	}
+
+	var result get.IptablesOutput
+	err := readPrivileged(subsystem, func() error {
+		var err error
+		result, err = list()
+		return err
+	})
+	return result, err
+}
+
+// Function refreshes the firewall or NAT table rules every interval until
+// Ctrl-C. Counters that changed since the previous sample are highlighted.
+func watchRules(nat bool, interval time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var prev *get.IptablesOutput
+	return get.Watch(ctx, interval, func(context.Context) error {
+		result, err := getRules(nat)
+		if err != nil {
+			return err
+		}
+
+		printWatchHeader(interval)
+		printRuleSet(result, prev)
+		prev = &result
+		return nil
+	})
+}
+
+// Function prints the chains and rules. With a previous sample (watch mode)
+// the counters that changed are highlighted.
+func printRuleSet(result get.IptablesOutput, prev *get.IptablesOutput) {
+	// Function highlights a counter that differs from the previous sample.
+	counter := func(value int, old int, ok bool) string {
+		if prev != nil && (!ok || value != old) {
+			return fmt.Sprintf(Green+"%d"+Reset, value)
+		}
+		return strconv.Itoa(value)
+	}
+
+	prevChains := make(map[string]get.IptablesChain)
+	if prev != nil {
+		for _, c := range prev.Chains {
+			prevChains[c.Name] = c
+		}
+	}
+
+	chainsFormat := `
+name: %s
+policy: %s
+packets: %s
+bytes: %s
+`
+	rulesFormat := "Rules: %d, Pkts: %s, Bytes: %s, Target: %s, " +
+		"Prot: %s, Opt: %s, In: %s, Out: %s, Source: %s, " +
+		"Destination: %s, Options: %s\n"
+
+	for _, val := range result.Chains {
+		old, okChain := prevChains[val.Name]
+		fmt.Printf(
+			chainsFormat,
+			val.Name,
+			val.Policy,
+			counter(val.Packets, old.Packets, okChain),
+			counter(val.Bytes, old.Bytes, okChain),
+		)
+
+		prevRules := make(map[uint64]get.IptablesRule, len(old.Rules))
+		for _, r := range old.Rules {
+			prevRules[r.Id] = r
+		}
+
+		if len(val.Rules) == 0 {
+			fmt.Println("Rules: none")
+		} else {
+			for _, val := range val.Rules {
+
+				if val.Options == "" {
+					val.Options = "none"
+				}
+
+				oldRule, okRule := prevRules[val.Id]
+				fmt.Printf(
+					rulesFormat,
+					val.Id,
+					counter(val.Pkts, oldRule.Pkts, okRule),
+					counter(val.Bytes, oldRule.Bytes, okRule),
+					val.Target,
+					val.Prot,
+					val.Opt,
+					val.In,
+					val.Out,
+					val.Source,
+					val.Destination,
+					val.Options,
+				)
+			}
+		}
+
+	}
+	fmt.Println()
+}
+
+// Function to display Private and Public keys.
+func printWgKey(w io.Writer, keys get.KeyPair) {
+	fmt.Fprintf(w, `
+private_key: %s
+public_key: %s
+
+`,
+		keys.Private,
+		keys.Public,
+	)
+}
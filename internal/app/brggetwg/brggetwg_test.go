@@ -0,0 +1,1587 @@
+package brggetwg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/privs"
+	"github.com/AlexKira/brgnetuse/internal/probe"
+	"github.com/AlexKira/brgnetuse/internal/shellmock"
+	"github.com/AlexKira/brgnetuse/internal/wgmock"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Testing the PublicKeyCommand function.
+func TestPublicKeyCommand(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("error: failed to generate key: %v", err)
+	}
+
+	type testCase struct {
+		name      string
+		args      []string
+		stdin     string
+		want      string
+		wantError string
+	}
+
+	tests := []testCase{
+		{name: "argument", args: []string{"-pub", key.String()}, want: key.PublicKey().String()},
+		{name: "stdin", args: []string{"-pub", "-"}, stdin: key.String() + "\n", want: key.PublicKey().String()},
+		{
+			name: "stdin key file", args: []string{"-pub", "-"},
+			stdin: "\nprivate_key: " + key.String() + "\npublic_key: " + key.PublicKey().String() + "\n\n",
+			want:  key.PublicKey().String(),
+		},
+		{name: "not base64", args: []string{"-pub", "-"}, stdin: "secret!key", wantError: "not valid base64"},
+		{name: "short key", args: []string{"-pub", "c2VjcmV0a2V5"}, wantError: "decodes to 9 bytes"},
+		{name: "zero key", args: []string{"-pub", wgtypes.Key{}.String()}, wantError: "all zeros"},
+		{name: "empty stdin", args: []string{"-pub", "-"}, wantError: "empty"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+
+			_, err := PublicKeyCommand(tc.args, strings.NewReader(tc.stdin), &stdout)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got error %v, want %q", err, tc.wantError)
+				}
+				if tc.stdin != "" && strings.Contains(err.Error(), strings.TrimSpace(tc.stdin)) {
+					t.Errorf("error: key material leaked into the error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			if got := strings.TrimSpace(stdout.String()); got != tc.want {
+				t.Errorf("error: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the OwnerCommand function with the loopback address.
+func TestOwnerCommand(t *testing.T) {
+	type testCase struct {
+		name      string
+		args      []string
+		want      string
+		wantError string
+	}
+
+	tests := []testCase{
+		{name: "exact", args: []string{"-owner", "127.0.0.1"}, want: "name: lo\n  address: 127.0.0.1/8\n"},
+		{name: "contains", args: []string{"-owner", "127.0.0.9", "-contains"}, want: "name: lo\n  address: 127.0.0.1/8\n"},
+		{name: "not exact", args: []string{"-owner", "127.0.0.9"}, wantError: "no network interface has the address '127.0.0.9'"},
+		{name: "invalid address", args: []string{"-owner", "10.10.10"}, wantError: "invalid IP address"},
+		{name: "unknown flag", args: []string{"-owner", "127.0.0.1", "-x"}, wantError: "arguments passed incorrectly"},
+		{name: "missing address", args: []string{"-owner"}, wantError: "arguments passed incorrectly"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+
+			_, err := OwnerCommand(tc.args, &stdout)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got error %v, want %q", err, tc.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got := stdout.String(); got != tc.want {
+				t.Errorf("error: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the KeyPairCommand function.
+func TestKeyPairCommand(t *testing.T) {
+	dir := t.TempDir()
+	var stdout bytes.Buffer
+
+	if _, err := KeyPairCommand([]string{"-pk", "-o", dir}, &stdout); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "publickey"))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if stdout.String() != string(data) {
+		t.Errorf("error: got output %q, want only the public key %q", stdout.String(), data)
+	}
+
+	if _, err := KeyPairCommand([]string{"-pk", "-o", dir}, &stdout); err == nil {
+		t.Error("error: expected error for existing key files, got none")
+	}
+	if _, err := KeyPairCommand([]string{"-pk", "-o", dir, "-force"}, &stdout); err != nil {
+		t.Errorf("error: unexpected error: %v", err)
+	}
+	if _, err := KeyPairCommand([]string{"-pk", "-o", dir, "-f"}, &stdout); err == nil {
+		t.Error("error: expected error for an unknown flag, got none")
+	}
+}
+
+// Testing the key pair printed by `-pk`.
+func TestPrintWgKey(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x01}, wgtypes.KeyLen)
+	private := wgtypes.Key(seed)
+	private[0], private[31] = 0x00, 0x41
+
+	var out bytes.Buffer
+	printWgKey(&out, get.KeyPair{Private: private, Public: private.PublicKey()})
+	want := "\nprivate_key: " + private.String() + "\npublic_key: " + private.PublicKey().String() + "\n\n"
+	if out.String() != want {
+		t.Errorf("error: got %q, want %q", out.String(), want)
+	}
+}
+
+// Testing the parsePeerOptions function.
+func TestParsePeerOptions(t *testing.T) {
+	type testCase struct {
+		name      string
+		args      []string
+		want      peerOptions
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "key", args: []string{"-k", "xTIB"}, want: peerOptions{Filter: get.PeerFilter{Key: "xTIB"}}},
+		{
+			name: "endpoint",
+			args: []string{"-e", "192.0.2.1"},
+			want: peerOptions{Filter: get.PeerFilter{Endpoint: "192.0.2.1"}},
+		},
+		{
+			name: "both",
+			args: []string{"-e", "192.0.2.1:51820", "-k", "xTIB"},
+			want: peerOptions{Filter: get.PeerFilter{Key: "xTIB", Endpoint: "192.0.2.1:51820"}},
+		},
+		{name: "sort", args: []string{"-sort", "rx"}, want: peerOptions{Sort: "rx"}},
+		{
+			name: "sort_reverse",
+			args: []string{"-r", "-sort", "handshake", "-k", "xTIB"},
+			want: peerOptions{Filter: get.PeerFilter{Key: "xTIB"}, Sort: "handshake", Reverse: true},
+		},
+		{name: "watch", args: []string{"-w", "2", "-k", "xTIB"}, want: peerOptions{Filter: get.PeerFilter{Key: "xTIB"}, Watch: 2 * time.Second}},
+		{
+			name: "page",
+			args: []string{"-limit", "50", "-sort", "ip", "-offset", "100"},
+			want: peerOptions{Sort: "ip", Page: get.Page{Limit: 50, Offset: 100}},
+		},
+		{name: "limit_zero", args: []string{"-limit", "0"}, wantError: true},
+		{name: "offset_negative", args: []string{"-offset", "-1"}, wantError: true},
+		{name: "repeated_limit", args: []string{"-limit", "1", "-limit", "2"}, wantError: true},
+		{name: "limit_missing_value", args: []string{"-limit"}, wantError: true},
+		{name: "watch_invalid", args: []string{"-w", "0"}, wantError: true},
+		{name: "watch_not_number", args: []string{"-w", "fast"}, wantError: true},
+		{name: "reverse_without_sort", args: []string{"-r"}, wantError: true},
+		{name: "missing_value", args: []string{"-k"}, wantError: true},
+		{name: "repeated", args: []string{"-k", "a", "-k", "b"}, wantError: true},
+		{name: "repeated_reverse", args: []string{"-sort", "ip", "-r", "-r"}, wantError: true},
+		{name: "unknown", args: []string{"-x", "a"}, wantError: true},
+		{name: "json", args: []string{"-sort", "rx", "-js"}, want: peerOptions{Sort: "rx", JSON: true}},
+		{name: "repeated_json", args: []string{"-js", "-js"}, wantError: true},
+		{name: "json_watch", args: []string{"-js", "-w", "2"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got peerOptions
+			_, err := parsePeerOptions(tc.args, &got)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error for %v", tc.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the argument validation of the WatchCommand function.
+func TestWatchCommandArgs(t *testing.T) {
+	type testCase struct {
+		name string
+		args []string
+	}
+
+	tests := []testCase{
+		{name: "missing_interval", args: []string{"-fr", "-w"}},
+		{name: "invalid_interval", args: []string{"-fr", "-w", "-1"}},
+		{name: "unsupported_view", args: []string{"-ip", "-w", "2"}},
+		{name: "wrong_flag", args: []string{"-fr", "-x", "2"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := WatchCommand(tc.args); err == nil {
+				t.Errorf("error: expected error for %v", tc.args)
+			}
+		})
+	}
+}
+
+// Testing the argument validation of the HealthCommand function.
+func TestHealthCommandArgs(t *testing.T) {
+	type testCase struct {
+		name string
+		args []string
+	}
+
+	tests := []testCase{
+		{name: "missing_value", args: []string{"-i", "wg0", "-check", "-min-peers"}},
+		{name: "negative", args: []string{"-i", "wg0", "-check", "-max-handshake", "-5"}},
+		{name: "not_number", args: []string{"-i", "wg0", "-check", "-min-peers", "many"}},
+		{name: "unknown", args: []string{"-i", "wg0", "-check", "-x"}},
+		{name: "wrong_flag", args: []string{"-i", "wg0", "-pr"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := HealthCommand(tc.args, io.Discard); err == nil {
+				t.Errorf("error: expected error for %v", tc.args)
+			}
+		})
+	}
+
+	var stdout bytes.Buffer
+	status, _, err := HealthCommand([]string{"-i", "brgnetuse-missing0", "-check"}, &stdout)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if status != 2 || !strings.HasPrefix(stdout.String(), "critical: interface:") {
+		t.Errorf("error: got status %d and output %q for a missing interface", status, stdout.String())
+	}
+}
+
+// Testing the filterRuleSet function of the `-fr` and `-n` listings.
+func TestFilterRuleSet(t *testing.T) {
+	rules := get.IptablesOutput{Chains: []get.IptablesChain{
+		{Name: "FORWARD", Policy: "DROP", Rules: []get.IptablesRule{
+			{Id: 1, In: "wg0", Out: "eth0", Source: "0.0.0.0/0"},
+			{Id: 2, In: "eth0", Out: "wg0", Source: "0.0.0.0/0"},
+		}},
+		{Name: "POSTROUTING", Policy: "ACCEPT", Rules: []get.IptablesRule{
+			{Id: 3, Target: "MASQUERADE", In: "*", Out: "eth0", Source: "10.10.10.0/24"},
+		}},
+	}}
+
+	type testCase struct {
+		name      string
+		flag      string
+		value     string
+		want      []uint64
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "interface", flag: "-i", value: "wg0", want: []uint64{1, 2}},
+		{name: "masquerade_out", flag: "-i", value: "eth0", want: []uint64{1, 2, 3}},
+		{name: "source", flag: "-s", value: "10.10.10.0/24", want: []uint64{3}},
+		{name: "invalid_source", flag: "-s", value: "10.10.10.300/24", wantError: true},
+		{name: "empty_value", flag: "-i", value: "", wantError: true},
+		{name: "unknown_flag", flag: "-x", value: "wg0", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, _, err := filterRuleSet(rules, tc.flag, tc.value)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error for %s %q", tc.flag, tc.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if len(result.Chains) != len(rules.Chains) {
+				t.Fatalf("error: got %d chains, want %d", len(result.Chains), len(rules.Chains))
+			}
+
+			var got []uint64
+			for _, chain := range result.Chains {
+				for _, rule := range chain.Rules {
+					got = append(got, rule.Id)
+				}
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("error: got rules %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	for _, args := range [][]string{
+		{"-ip", "-i", "wg0"},
+		{"-fr", "-i"},
+		{"-limit", "5"},
+		{"-fr", "-limit", "-1"},
+	} {
+		if _, err := RulesCommand(args); err == nil {
+			t.Errorf("error: expected error for %v", args)
+		}
+	}
+}
+
+// Testing the formatPeerDetail function with a fully populated peer.
+func TestFormatPeerDetail(t *testing.T) {
+	public, _ := wgtypes.ParseKey("xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=")
+	preshared, _ := wgtypes.GenerateKey()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	peer := wgtypes.Peer{
+		PublicKey:                   public,
+		PresharedKey:                preshared,
+		Endpoint:                    &net.UDPAddr{IP: net.ParseIP("89.89.89.1"), Port: 51820},
+		PersistentKeepaliveInterval: 25 * time.Second,
+		LastHandshakeTime:           now.Add(-3*time.Minute - 25*time.Second - 300*time.Millisecond),
+		ReceiveBytes:                1536,
+		TransmitBytes:               512,
+		AllowedIPs: []net.IPNet{
+			{IP: net.ParseIP("10.10.10.2").To4(), Mask: net.CIDRMask(32, 32)},
+			{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(128, 128)},
+		},
+		ProtocolVersion: 1,
+	}
+	info := get.FromWgDevice(&wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{peer}}).Peers[0]
+	info.Name = "laptop"
+	info.Note = "office"
+	info.Expires = "2026-10-01T00:00:00Z"
+
+	got := formatPeerDetail("wg0", info, now)
+	if strings.Contains(got, preshared.String()) {
+		t.Fatalf("error: the preshared key is printed")
+	}
+
+	want := strings.Join([]string{
+		"",
+		"peer: xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+		"  interface: wg0",
+		"  name: laptop",
+		"  preshared key: set",
+		"  protocol version: 1",
+		"  endpoint: 89.89.89.1:51820",
+		"  latest handshake: 2026-10-16 11:56:34 UTC (3m26s ago)",
+		"  transfer: 1.50 KiB received, 512 B sent",
+		"  persistent keepalive: every 25 seconds",
+		"  allowed ips:",
+		"    10.10.10.2/32",
+		"    fd00::2/128",
+		"  note: office",
+		"  expires: 2026-10-01T00:00:00Z (expired)",
+		"",
+	}, "\n")
+	if plain := ansi.ReplaceAllString(got, ""); plain != want {
+		t.Errorf("error: got\n%s\nwant\n%s", plain, want)
+	}
+
+	// A peer without the optional fields.
+	bare := get.PeerInfo{PublicKey: info.PublicKey}
+	plain := ansi.ReplaceAllString(formatPeerDetail("wg0", bare, now), "")
+	for _, line := range []string{"preshared key: not set", "endpoint: <nil>", "latest handshake: (none)", "persistent keepalive: off"} {
+		if !strings.Contains(plain, line) {
+			t.Errorf("error: missing %q in\n%s", line, plain)
+		}
+	}
+	if strings.Contains(plain, "name:") || strings.Contains(plain, "expires:") {
+		t.Errorf("error: unset metadata shown in\n%s", plain)
+	}
+}
+
+// Escape sequences of the colors, stripped to compare the output.
+var ansi = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// Function returns a device with n synthetic peers, each with two allowed
+// IPs, an endpoint and transfer counters.
+func syntheticDevice(n int) get.DeviceInfo {
+	d := get.DeviceInfo{Name: "wg0", PublicKey: "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", ListenPort: 51820}
+	d.Peers = make([]get.PeerInfo, n)
+	for i := range d.Peers {
+		d.Peers[i] = get.PeerInfo{
+			PublicKey:           fmt.Sprintf("%043d=", i),
+			Endpoint:            fmt.Sprintf("192.0.2.%d:51820", i%250+1),
+			PersistentKeepalive: 25,
+			ReceiveBytes:        int64(i) * 1536,
+			TransmitBytes:       int64(i) * 512,
+			AllowedIPs:          []string{fmt.Sprintf("10.%d.%d.2/32", i/250, i%250), "fd00::2/128"},
+		}
+	}
+	return d
+}
+
+// Testing the text peer listing of writeDevices, with and without the
+// deltas of the watch mode.
+func TestWriteDevices(t *testing.T) {
+	d := syntheticDevice(2)
+	d.Backend = "kernel WireGuard"
+	d.Peers[1].Name = "laptop"
+	d.Peers[1].Note = "office"
+	d.Peers[1].Expires = "2020-01-01T00:00:00Z"
+
+	var out bytes.Buffer
+	if err := writeDevices(&out, []get.DeviceInfo{d}, nil); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"",
+		"interface: wg0 ",
+		"  public key: xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg= ",
+		"  private key: (hidden)",
+		"  listening port: 51820",
+		"  backend: kernel WireGuard",
+		"",
+		"peer: " + d.Peers[0].PublicKey,
+		"  endpoint: 192.0.2.1:51820",
+		"  allowed ips: 10.0.0.2/32, fd00::2/128",
+		"  transfer: 0 B received, 0 B sent",
+		"  persistent keepalive: every 25 seconds",
+		"",
+		"peer: " + d.Peers[1].PublicKey + " (laptop)",
+		"  endpoint: 192.0.2.2:51820",
+		"  allowed ips: 10.0.1.2/32, fd00::2/128",
+		"  transfer: 1.50 KiB received, 512 B sent",
+		"  persistent keepalive: every 25 seconds",
+		"  note: office",
+		"  expires: 2020-01-01T00:00:00Z (expired)",
+		"",
+	}, "\n")
+	if plain := ansi.ReplaceAllString(out.String(), ""); plain != want {
+		t.Errorf("error: got\n%s\nwant\n%s", plain, want)
+	}
+
+	deltas := map[get.PeerID]get.PeerDelta{
+		{Interface: "wg0", PublicKey: d.Peers[0].PublicKey}: {New: true, ReceiveBytes: 10, TransmitBytes: 20},
+	}
+	out.Reset()
+	if err := writeDevices(&out, []get.DeviceInfo{d}, deltas); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	plain := ansi.ReplaceAllString(out.String(), "")
+	for _, line := range []string{" (new)\n", "sent (+10 B, +20 B)\n", "  latest handshake: (none)\n"} {
+		if !strings.Contains(plain, line) {
+			t.Errorf("error: missing %q in\n%s", line, plain)
+		}
+	}
+}
+
+// Writer failing every write with err once limit bytes were written.
+type failingWriter struct {
+	limit  int
+	err    error
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if len(p) > w.limit {
+		return 0, w.err
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+// Testing that the listing stops at the first failed write, and that a
+// reader going away (EPIPE) is not reported as an error.
+func TestWriteDevicesClosed(t *testing.T) {
+	devices := []get.DeviceInfo{syntheticDevice(10000)}
+
+	for _, write := range []func(io.Writer) error{
+		func(w io.Writer) error { return writeDevices(w, devices, nil) },
+		func(w io.Writer) error { return writePeersJSON(w, devices) },
+	} {
+		w := &failingWriter{limit: peerBufferSize, err: syscall.EPIPE}
+		err := write(w)
+		if !errors.Is(err, syscall.EPIPE) {
+			t.Fatalf("error: got %v, want EPIPE", err)
+		}
+		if w.writes != 2 {
+			t.Errorf("error: got %d writes, want the listing stopped at the failed one", w.writes)
+		}
+		if err := stdoutError(err); err != nil {
+			t.Errorf("error: got %v for a closed stdout, want nil", err)
+		}
+	}
+
+	if err := stdoutError(syscall.ENOSPC); err == nil || !strings.Contains(err.Error(), "failed to write the peers") {
+		t.Errorf("error: got %v, want the write error", err)
+	}
+}
+
+// Testing the JSON peer listing of writePeersJSON: an array of the peers
+// with their interface, and an empty array without peers.
+func TestWritePeersJSON(t *testing.T) {
+	devices := []get.DeviceInfo{syntheticDevice(3), {Name: "wg1"}}
+
+	var out bytes.Buffer
+	if err := writePeersJSON(&out, devices); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	var got []peerJSON
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("error: invalid JSON: %v\n%s", err, out.String())
+	}
+	if len(got) != 3 || got[2].Interface != "wg0" || !reflect.DeepEqual(got[2].PeerInfo, devices[0].Peers[2]) {
+		t.Errorf("error: got %+v", got)
+	}
+	if !strings.HasPrefix(out.String(), "[\n  {\n    \"interface\": \"wg0\",\n") || !strings.HasSuffix(out.String(), "  }\n]\n") {
+		t.Errorf("error: got unindented output\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := writePeersJSON(&out, []get.DeviceInfo{{Name: "wg1"}}); err != nil || out.String() != "[]\n" {
+		t.Errorf("error: got %q (%v), want an empty array", out.String(), err)
+	}
+}
+
+// Benchmarking the text and JSON peer listings of 10k synthetic peers.
+func BenchmarkWriteDevices(b *testing.B) {
+	devices := []get.DeviceInfo{syntheticDevice(10000)}
+
+	b.Run("text", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if err := writeDevices(io.Discard, devices, nil); err != nil {
+				b.Fatalf("error: unexpected error: %v", err)
+			}
+		}
+	})
+	b.Run("json", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if err := writePeersJSON(io.Discard, devices); err != nil {
+				b.Fatalf("error: unexpected error: %v", err)
+			}
+		}
+	})
+}
+
+// Testing the environment defaults of ParseWithEnv.
+func TestParseWithEnv(t *testing.T) {
+	env := map[string]string{help.Env_Default_Interface: "wg0"}
+
+	type testCase struct {
+		name string
+		args []string
+		want []string
+	}
+
+	tests := []testCase{
+		{name: "check", args: []string{"-check"}, want: []string{"-i", "wg0", "-check"}},
+		{name: "flag wins", args: []string{"-i", "wg1", "-check"}, want: []string{"-i", "wg1", "-check"}},
+		{name: "list", args: []string{"-ip"}, want: []string{"-ip"}},
+		{name: "watch_events", args: []string{"-watch-events", "-w", "2"}, want: []string{"-i", "wg0", "-watch-events", "-w", "2"}},
+		{name: "status", args: []string{"-st"}, want: []string{"-i", "wg0", "-st"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ParseWithEnv(append([]string{"brggetwg"}, tc.args...), env)
+			if !slices.Equal(got[1:], tc.want) {
+				t.Errorf("error: got %q, want %q", got[1:], tc.want)
+			}
+		})
+	}
+}
+
+// Testing the options of the `-watch-events` sub-flag.
+func TestParseEventOptions(t *testing.T) {
+	dir := t.TempDir()
+	hook := filepath.Join(dir, "hook.sh")
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "plain.txt")
+	if err := os.WriteFile(plain, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, _, err := parseEventOptions([]string{
+		"-i", "wg0", "-watch-events", "-exec", hook, "-w", "2", "-down-after", "120", "-debounce", "10",
+	})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	want := eventOptions{
+		Interface: "wg0",
+		Exec:      hook,
+		Interval:  2 * time.Second,
+		DownAfter: 120 * time.Second,
+		Debounce:  10 * time.Second,
+	}
+	if opts != want {
+		t.Errorf("error: got %+v, want %+v", opts, want)
+	}
+
+	opts, _, err = parseEventOptions([]string{"-i", "wg0", "-watch-events"})
+	if err != nil || opts.Interval != defaultEventInterval || opts.Exec != "" {
+		t.Errorf("error: got %+v, %v for the defaults", opts, err)
+	}
+
+	type testCase struct {
+		name     string
+		args     []string
+		wantFlag string
+	}
+
+	tests := []testCase{
+		{name: "missing_value", args: []string{"-i", "wg0", "-watch-events", "-exec"}, wantFlag: "-exec"},
+		{name: "not_executable", args: []string{"-i", "wg0", "-watch-events", "-exec", plain}, wantFlag: "-exec"},
+		{name: "missing_hook", args: []string{"-i", "wg0", "-watch-events", "-exec", filepath.Join(dir, "none")}, wantFlag: "-exec"},
+		{name: "directory", args: []string{"-i", "wg0", "-watch-events", "-exec", dir}, wantFlag: "-exec"},
+		{name: "zero_interval", args: []string{"-i", "wg0", "-watch-events", "-w", "0"}, wantFlag: "-w"},
+		{name: "negative", args: []string{"-i", "wg0", "-watch-events", "-debounce", "-1"}, wantFlag: "-debounce"},
+		{name: "not_number", args: []string{"-i", "wg0", "-watch-events", "-down-after", "soon"}, wantFlag: "-down-after"},
+		{name: "repeated", args: []string{"-i", "wg0", "-watch-events", "-w", "1", "-w", "2"}, wantFlag: "-w"},
+		{name: "unknown", args: []string{"-i", "wg0", "-watch-events", "-x"}, wantFlag: "-x"},
+		{name: "wrong_flag", args: []string{"-i", "wg0", "-check"}, wantFlag: "-watch-events"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, flag, err := parseEventOptions(tc.args)
+			if err == nil || flag != tc.wantFlag {
+				t.Errorf("error: got (%q, %v), want an error for %q", flag, err, tc.wantFlag)
+			}
+		})
+	}
+}
+
+// Testing that every event is printed and passed to the hook, and that a
+// failing hook is reported without stopping the others.
+func TestReportEvents(t *testing.T) {
+	var calls [][]string
+	prev := runHook
+	runHook = func(path string, env []string) error {
+		calls = append(calls, env)
+		if len(calls) == 1 {
+			return errors.New("exit status 1")
+		}
+		return nil
+	}
+	t.Cleanup(func() { runHook = prev })
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	events := []get.PeerEvent{
+		{PeerID: get.PeerID{Interface: "wg0", PublicKey: "A="}, Type: get.PeerUp, AllowedIPs: []string{"10.0.0.2/32", "fd00::2/128"}, Time: now},
+		{PeerID: get.PeerID{Interface: "wg0", PublicKey: "B="}, Type: get.PeerDown, Time: now},
+	}
+
+	var stdout, stderr bytes.Buffer
+	reportEvents(events, "/etc/wireguard/hook.sh", &stdout, &stderr)
+
+	wantOut := "2024-05-01T12:00:00Z wg0 peer A= up\n2024-05-01T12:00:00Z wg0 peer B= down\n"
+	if stdout.String() != wantOut {
+		t.Errorf("error: got stdout %q, want %q", stdout.String(), wantOut)
+	}
+	if !strings.Contains(stderr.String(), "warning: hook '/etc/wireguard/hook.sh' failed: exit status 1") {
+		t.Errorf("error: got stderr %q", stderr.String())
+	}
+
+	wantEnv := [][]string{
+		{"BRG_PEER_KEY=A=", "BRG_PEER_IP=10.0.0.2", "BRG_EVENT=up", "BRG_IFACE=wg0"},
+		{"BRG_PEER_KEY=B=", "BRG_PEER_IP=", "BRG_EVENT=down", "BRG_IFACE=wg0"},
+	}
+	if len(calls) != len(wantEnv) {
+		t.Fatalf("error: got %d hook calls, want %d", len(calls), len(wantEnv))
+	}
+	for i, env := range calls {
+		if got := env[len(env)-4:]; !slices.Equal(got, wantEnv[i]) {
+			t.Errorf("error: call %d: got %q, want %q", i, got, wantEnv[i])
+		}
+	}
+
+	calls = nil
+	stdout.Reset()
+	reportEvents(events, "", &stdout, &stderr)
+	if len(calls) != 0 || strings.Count(stdout.String(), "\n") != 2 {
+		t.Errorf("error: got %d hook calls and %q without a hook", len(calls), stdout.String())
+	}
+}
+
+// Testing the argument parsing of the -counters sub-flag.
+func TestParseCountersOptions(t *testing.T) {
+	type testCase struct {
+		args      []string
+		want      countersOptions
+		wantError bool
+	}
+
+	tests := []testCase{
+		{args: []string{"-n", "-counters", "10.10.10.0/24"}, want: countersOptions{Nat: true, Target: "10.10.10.0/24"}},
+		{args: []string{"-n", "-counters", "10.10.10.1/24", "-w", "5"}, want: countersOptions{Nat: true, Target: "10.10.10.0/24", Interval: 5 * time.Second}},
+		{args: []string{"-fr", "-counters", "-i", "wg0"}, want: countersOptions{Target: "wg0"}},
+		{args: []string{"-fr", "-counters", "-i", "wg0", "-w", "2"}, want: countersOptions{Target: "wg0", Interval: 2 * time.Second}},
+		{args: []string{"-n", "-counters"}, wantError: true},
+		{args: []string{"-n", "-counters", "10.10.10.1"}, wantError: true},
+		{args: []string{"-n", "-counters", "fd00::/64"}, wantError: true},
+		{args: []string{"-n", "-counters", "10.10.10.0/24", "-w"}, wantError: true},
+		{args: []string{"-n", "-counters", "10.10.10.0/24", "-w", "0"}, wantError: true},
+		{args: []string{"-n", "-counters", "10.10.10.0/24", "-x", "2"}, wantError: true},
+		{args: []string{"-fr", "-counters", "wg0"}, wantError: true},
+		{args: []string{"-fr", "-counters", "-i", "wg/0"}, wantError: true},
+		{args: []string{"-pr", "-counters", "-i", "wg0"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			opts, _, err := parseCountersOptions(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error for %v", tc.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if opts != tc.want {
+				t.Errorf("error: got %+v, want %+v", opts, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the counter lines: the rates when measured and the highlight of
+// the rules without traffic.
+func TestPrintCounters(t *testing.T) {
+	masquerade := get.RuleCounter{Chain: "POSTROUTING", Rule: get.IptablesRule{
+		Pkts: 12, Bytes: 2048, Target: "MASQUERADE", Out: "eth0", Source: "10.10.10.0/24", Comment: "brgnetuse:wg0",
+	}}
+	forward := get.RuleCounter{Chain: "FORWARD", Rule: get.IptablesRule{
+		Target: "ACCEPT", In: "wg0", Out: "eth0",
+	}}
+
+	type testCase struct {
+		name     string
+		rates    []get.RuleRate
+		want     []string
+		wantIdle bool
+	}
+
+	tests := []testCase{
+		{
+			name:  "counters",
+			rates: []get.RuleRate{{RuleCounter: masquerade}, {RuleCounter: forward}},
+			want: []string{
+				"POSTROUTING MASQUERADE 10.10.10.0/24 -> eth0 [brgnetuse:wg0]: 12 pkts, 2.00 " + Cyan + "KiB" + Reset,
+				"FORWARD ACCEPT wg0 -> eth0: 0 pkts, 0 " + Cyan + "B" + Reset + Yellow + " (no traffic)" + Reset,
+			},
+		},
+		{
+			name:  "rates",
+			rates: []get.RuleRate{{RuleCounter: masquerade, Delta: true, Packets: 2.5, Bytes: 512}},
+			want: []string{
+				"POSTROUTING MASQUERADE 10.10.10.0/24 -> eth0 [brgnetuse:wg0]: 12 pkts, 2.00 " + Cyan + "KiB" + Reset +
+					", 2.5 pkts/s, 512 " + Cyan + "B" + Reset + "/s",
+			},
+		},
+		{
+			name:  "idle rates",
+			rates: []get.RuleRate{{RuleCounter: masquerade, Delta: true}},
+			want: []string{
+				"POSTROUTING MASQUERADE 10.10.10.0/24 -> eth0 [brgnetuse:wg0]: 12 pkts, 2.00 " + Cyan + "KiB" + Reset +
+					", 0.0 pkts/s, 0 " + Cyan + "B" + Reset + "/s" + Yellow + " (no traffic)" + Reset,
+			},
+			wantIdle: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			idle := printCounters(&out, tc.rates)
+			if got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"); !slices.Equal(got, tc.want) {
+				t.Errorf("error: got\n%q\nwant\n%q", got, tc.want)
+			}
+			if idle != tc.wantIdle {
+				t.Errorf("error: got idle %v, want %v", idle, tc.wantIdle)
+			}
+		})
+	}
+}
+
+// Testing the hint of the rules without traffic.
+func TestIdleHint(t *testing.T) {
+	if got := idleHint(map[string]int{"ipv4": 0}, nil); !strings.Contains(got, "net.ipv4.ip_forward is disabled") {
+		t.Errorf("error: got hint %q with forwarding disabled", got)
+	}
+	for _, got := range []string{
+		idleHint(map[string]int{"ipv4": 1}, nil),
+		idleHint(nil, errors.New("error: sysctl failed")),
+	} {
+		if !strings.Contains(got, "check the forwarding sysctls") {
+			t.Errorf("error: got hint %q", got)
+		}
+	}
+}
+
+// Testing the JSON rules: the warnings surface in the output, and the
+// chains and warnings are arrays also when empty.
+func TestWriteRulesJSON(t *testing.T) {
+	type testCase struct {
+		name   string
+		result get.IptablesOutput
+		want   string
+	}
+
+	tests := []testCase{
+		{name: "empty", result: get.IptablesOutput{}, want: `{"chains":[],"warnings":[]}`},
+		{
+			name: "warnings",
+			result: get.IptablesOutput{
+				Chains:   []get.IptablesChain{{Name: "FORWARD", Policy: "DROP"}},
+				Warnings: []get.ParseWarning{{Line: 4, Text: "1 60 ACCEPT", Reason: "too few fields"}},
+			},
+			want: `{"chains":[{"name":"FORWARD","policy":"DROP","packets":0,"bytes":0,"references":0,"rules":null}],` +
+				`"warnings":[{"line":4,"text":"1 60 ACCEPT","reason":"too few fields"}]}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeRulesJSON(&out, tc.result); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, out.Bytes()); err != nil {
+				t.Fatalf("error: invalid JSON %q: %v", out.String(), err)
+			}
+			if compact.String() != tc.want {
+				t.Errorf("error: got\n%s\nwant\n%s", compact.String(), tc.want)
+			}
+		})
+	}
+}
+
+// Testing the argument validation of the RulesJSONCommand function.
+func TestRulesJSONCommandArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{"-fr"},
+		{"-fr", "-i", "-js"},
+		{"-pr", "-js"},
+		{"-n", "-js", "-i", "wg0"},
+		{"-n", "-i", "wg0", "-w", "-js"},
+		{"-fr", "-limit", "0", "-js"},
+		{"-fr", "-offset", "x", "-js"},
+		{"-fr", "-limit", "5", "-limit", "5", "-js"},
+	} {
+		if _, err := RulesJSONCommand(args, io.Discard); err == nil {
+			t.Errorf("error: expected error for %v", args)
+		}
+	}
+}
+
+// Testing the warnings printed after the rules of the text view.
+func TestPrintParseWarnings(t *testing.T) {
+	var out strings.Builder
+	printParseWarnings(&out, []get.ParseWarning{{Line: 2, Text: "Chain", Reason: "invalid chain header"}})
+
+	want := Yellow + "warning: skipped iptables line 2: invalid chain header: Chain" + Reset + "\n"
+	if out.String() != want {
+		t.Errorf("error: got %q, want %q", out.String(), want)
+	}
+}
+
+// Testing the status of a network interface, with and without a recorded
+// change and a known implementation.
+func TestPrintStatus(t *testing.T) {
+	var out strings.Builder
+	printStatus(&out, "wg0", "", get.InterfaceChange{})
+	if want := "name: wg0\n  implementation: unknown\n  last modified: never\n"; out.String() != want {
+		t.Errorf("error: got %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	printStatus(&out, "wg0", "kernel module wireguard 1.0.0", get.InterfaceChange{
+		Time:      time.Date(2024, 6, 2, 14, 11, 0, 0, time.Local),
+		Operation: "peer-add",
+	})
+	if want := "name: wg0\n  implementation: kernel module wireguard 1.0.0\n  last modified: 2024-06-02 14:11 by uid 0 (peer-add)\n"; out.String() != want {
+		t.Errorf("error: got %q, want %q", out.String(), want)
+	}
+}
+
+// Function replaces the device lookups of the groups and the command run
+// on each interface, which fails with the error of its interface.
+func useGroup(t *testing.T, devices, links []string, status map[string]int, errs map[string]error) *[][]string {
+	t.Helper()
+
+	var calls [][]string
+	prevDevices, prevLinks, prevRun := groupDevices, groupLinks, runInterface
+	groupDevices = func() ([]string, error) { return devices, nil }
+	groupLinks = func() ([]string, error) { return slices.Concat(devices, links), nil }
+	runInterface = func(args []string, stdout io.Writer) (int, error) {
+		calls = append(calls, args)
+		return status[args[1]], errs[args[1]]
+	}
+	t.Cleanup(func() { groupDevices, groupLinks, runInterface = prevDevices, prevLinks, prevRun })
+	return &calls
+}
+
+// Testing a command run on a group of interfaces: the WireGuard devices
+// only, past the failures, with the table and the exit status.
+func TestGroupCommand(t *testing.T) {
+	devices := []string{"wg-cust2", "wg-cust1", "wg0"}
+	calls := useGroup(t, devices, []string{"wg-custbr", "eth0"}, nil, map[string]error{
+		"wg-cust2": errors.New("error: network interface `wg-cust2` not found"),
+	})
+
+	var stdout, stderr bytes.Buffer
+	status, _, err := GroupCommand([]string{"-g", "wg-cust", "-pr", "-sort", "rx"}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if status != help.ExitSetupFailed {
+		t.Errorf("error: got status %d, want %d", status, help.ExitSetupFailed)
+	}
+
+	want := [][]string{
+		{"-i", "wg-cust1", "-pr", "-sort", "rx"},
+		{"-i", "wg-cust2", "-pr", "-sort", "rx"},
+	}
+	if !slices.EqualFunc(*calls, want, slices.Equal) {
+		t.Errorf("error: got calls %q, want %q", *calls, want)
+	}
+	if !strings.Contains(stdout.String(), "wg-cust2   failed  error: network interface `wg-cust2` not found") ||
+		!strings.Contains(stdout.String(), "2 network interfaces, 1 failed") {
+		t.Errorf("error: got output %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "'wg-custbr' is not a WireGuard device") {
+		t.Errorf("error: got warnings %q", stderr.String())
+	}
+}
+
+// Testing the exit status of `-check` on a group, the worst of the group.
+func TestGroupCommandCheck(t *testing.T) {
+	useGroup(t, []string{"wg1", "wg2", "wg3"}, nil, map[string]int{"wg2": int(get.Degraded)}, nil)
+
+	var stdout bytes.Buffer
+	status, _, err := GroupCommand([]string{"-i", "wg[13]", "-check"}, &stdout, io.Discard)
+	if err != nil || status != int(get.Healthy) {
+		t.Fatalf("error: got (%d, %v), want healthy", status, err)
+	}
+
+	stdout.Reset()
+	status, _, err = GroupCommand([]string{"-i", "wg*", "-check"}, &stdout, io.Discard)
+	if err != nil || status != int(get.Degraded) {
+		t.Fatalf("error: got (%d, %v), want degraded", status, err)
+	}
+	if !strings.Contains(stdout.String(), "wg2        failed  error: degraded") {
+		t.Errorf("error: got output %q", stdout.String())
+	}
+}
+
+// Testing the groups refused before running any command.
+func TestGroupCommandErrors(t *testing.T) {
+	calls := useGroup(t, []string{"wg0"}, []string{"eth0"}, nil, nil)
+
+	tests := [][]string{
+		{"-i", "eth*", "-pr"},
+		{"-i", "wg[", "-pr"},
+		{"-g", "", "-pr"},
+		{"-g", "wg", "-pr", "-w", "2"},
+		{"-g", "wg", "-watch-events"},
+		{"-g", "wg"},
+	}
+
+	for _, args := range tests {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			if _, _, err := GroupCommand(args, io.Discard, io.Discard); err == nil {
+				t.Error("error: expected error, got none")
+			}
+		})
+	}
+	if len(*calls) != 0 {
+		t.Errorf("error: got calls %q, want none", *calls)
+	}
+}
+
+// Function replaces the server device of the client configurations.
+func useClientDevice(t *testing.T, device get.DeviceInfo) {
+	t.Helper()
+
+	prev := clientDevice
+	clientDevice = func(iface string) (get.DeviceInfo, error) {
+		if iface != device.Name {
+			return get.DeviceInfo{}, errors.New("error: network interface `" + iface + "` not found")
+		}
+		return device, nil
+	}
+	t.Cleanup(func() { clientDevice = prev })
+}
+
+// Testing the argument parsing of the -client sub-flag.
+func TestParseClientOptions(t *testing.T) {
+	type testCase struct {
+		name        string
+		args        []string
+		want        clientOptions
+		wantCurrent string
+		wantError   bool
+	}
+
+	tests := []testCase{
+		{
+			name: "defaults",
+			args: []string{"-i", "wg0", "-client", "10.10.10.2/32", "-eh", "vpn.example.com"},
+			want: clientOptions{
+				Iface: "wg0", Name: "wg0", Address: []string{"10.10.10.2/32"}, Endpoint: "vpn.example.com",
+				DNS: []string{}, AllowedIPs: []string{"0.0.0.0/0", "::/0"}, Format: "wgquick",
+			},
+		},
+		{
+			name: "all_options",
+			args: []string{
+				"-i", "wg0", "-client", "10.10.10.2/32,fd00::2/128", "-eh", "203.0.113.1:51821",
+				"-dns", "10.10.10.1", "-allowed", "10.10.10.0/24", "-kp", "25", "-name", "office",
+				"-format", "nm", "-qr",
+			},
+			want: clientOptions{
+				Iface: "wg0", Name: "office", Address: []string{"10.10.10.2/32", "fd00::2/128"},
+				Endpoint: "203.0.113.1:51821", DNS: []string{"10.10.10.1"}, AllowedIPs: []string{"10.10.10.0/24"},
+				Keepalive: 25, Format: "nm", QR: true,
+			},
+		},
+		{
+			name:        "invalid_address",
+			args:        []string{"-i", "wg0", "-client", "10.10.10.2", "-eh", "vpn.example.com"},
+			wantCurrent: "-client",
+			wantError:   true,
+		},
+		{
+			name:        "missing_endpoint",
+			args:        []string{"-i", "wg0", "-client", "10.10.10.2/32"},
+			wantCurrent: "-eh",
+			wantError:   true,
+		},
+		{
+			name:        "missing_value",
+			args:        []string{"-i", "wg0", "-client", "10.10.10.2/32", "-eh"},
+			wantCurrent: "-eh",
+			wantError:   true,
+		},
+		{
+			name:        "invalid_allowed",
+			args:        []string{"-i", "wg0", "-client", "10.10.10.2/32", "-eh", "vpn.example.com", "-allowed", "all"},
+			wantCurrent: "-allowed",
+			wantError:   true,
+		},
+		{
+			name:        "invalid_keepalive",
+			args:        []string{"-i", "wg0", "-client", "10.10.10.2/32", "-eh", "vpn.example.com", "-kp", "-1"},
+			wantCurrent: "-kp",
+			wantError:   true,
+		},
+		{
+			name: "format_and_template",
+			args: []string{
+				"-i", "wg0", "-client", "10.10.10.2/32", "-eh", "vpn.example.com",
+				"-format", "nm", "-template", "client.tmpl",
+			},
+			wantCurrent: "-template",
+			wantError:   true,
+		},
+		{
+			name:        "unknown_flag",
+			args:        []string{"-i", "wg0", "-client", "10.10.10.2/32", "-eh", "vpn.example.com", "-x", "1"},
+			wantCurrent: "-x",
+			wantError:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, current, err := parseClientOptions(tc.args, strings.NewReader(""))
+			if tc.wantError {
+				if err == nil || current != tc.wantCurrent {
+					t.Fatalf("error: got (%q, %v), want an error of %s", current, err, tc.wantCurrent)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("error: got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the rendered client configurations: the port of the endpoint
+// defaults to the listen port, a generated key pair is announced on
+// stderr, and -qr passes the rendered text to qrencode.
+func TestClientConfigCommand(t *testing.T) {
+	server, _ := wgtypes.GeneratePrivateKey()
+	client, _ := wgtypes.GeneratePrivateKey()
+	useClientDevice(t, get.DeviceInfo{Name: "wg0", PublicKey: server.PublicKey().String(), ListenPort: 51820})
+
+	args := []string{"-i", "wg0", "-client", "10.10.10.2/32", "-eh", "vpn.example.com", "-dns", "10.10.10.1"}
+
+	var stdout, stderr bytes.Buffer
+	if _, err := ClientConfigCommand(slices.Concat(args, []string{"-pk", "-"}),
+		strings.NewReader(client.String()+"\n"), &stdout, &stderr); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	want := "[Interface]\n" +
+		"PrivateKey = " + client.String() + "\n" +
+		"Address = 10.10.10.2/32\n" +
+		"DNS = 10.10.10.1\n" +
+		"\n" +
+		"[Peer]\n" +
+		"PublicKey = " + server.PublicKey().String() + "\n" +
+		"Endpoint = vpn.example.com:51820\n" +
+		"AllowedIPs = 0.0.0.0/0, ::/0\n"
+	if stdout.String() != want || stderr.Len() != 0 {
+		t.Errorf("error: got\n%s\nstderr %q, want\n%s", stdout.String(), stderr.String(), want)
+	}
+
+	// Generated key pair, custom template.
+	tmpl := filepath.Join(t.TempDir(), "client.tmpl")
+	if err := os.WriteFile(tmpl, []byte("{{.PublicKey}} {{.Endpoint}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	stderr.Reset()
+	if _, err := ClientConfigCommand(slices.Concat(args, []string{"-eh", "[fd00::1]:51821", "-template", tmpl}),
+		nil, &stdout, &stderr); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	publicKey := strings.TrimPrefix(strings.TrimSpace(stderr.String()), "client public key: ")
+	if stdout.String() != publicKey+" [fd00::1]:51821\n" {
+		t.Errorf("error: got %q with stderr %q", stdout.String(), stderr.String())
+	}
+
+	// QR code of a NetworkManager keyfile.
+	fake := shellmock.Install(t)
+	fake.Outputs["qrencode -t ansiutf8"] = "\u2588\u2588\n"
+	var qrText string
+	fake.Hook = func(cmd string) {
+		data, _ := os.ReadFile(strings.TrimPrefix(cmd, "qrencode -t ansiutf8 -o - -r "))
+		qrText = string(data)
+	}
+	stdout.Reset()
+	if _, err := ClientConfigCommand(slices.Concat(args, []string{"-format", "nm", "-qr"}),
+		nil, &stdout, io.Discard); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(fake.Commands) != 1 || !strings.HasPrefix(qrText, "[connection]\nid=wg0\n") || stdout.String() != "\u2588\u2588\n" {
+		t.Errorf("error: got commands %q with text %q and output %q", fake.Commands, qrText, stdout.String())
+	}
+	if _, err := os.Stat(strings.TrimPrefix(fake.Commands[0], "qrencode -t ansiutf8 -o - -r ")); !os.IsNotExist(err) {
+		t.Errorf("error: temporary file kept: %v", err)
+	}
+}
+
+// Testing the failures of the client configurations.
+func TestClientConfigCommandErrors(t *testing.T) {
+	server, _ := wgtypes.GeneratePrivateKey()
+	useClientDevice(t, get.DeviceInfo{Name: "wg0", PublicKey: server.PublicKey().String(), ListenPort: 51820})
+
+	broken := filepath.Join(t.TempDir(), "broken.tmpl")
+	if err := os.WriteFile(broken, []byte("[Interface]\nMTU = {{.MTU}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		name        string
+		args        []string
+		wantCurrent string
+		wantError   string
+	}
+
+	tests := []testCase{
+		{name: "unknown_format", args: []string{"-format", "ini"}, wantCurrent: "-format", wantError: "unknown client configuration format"},
+		{name: "template_line", args: []string{"-template", broken}, wantCurrent: "-template", wantError: "line 2"},
+		{name: "invalid_key", args: []string{"-pk", "AAAA"}, wantCurrent: "-pk", wantError: "error:"},
+		{name: "invalid_host", args: []string{"-eh", "vpn_example:51820"}, wantCurrent: "-eh", wantError: "invalid endpoint host name"},
+		{name: "invalid_port", args: []string{"-eh", "vpn.example.com:0"}, wantCurrent: "-eh", wantError: "invalid endpoint port"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			args := slices.Concat([]string{"-i", "wg0", "-client", "10.10.10.2/32", "-eh", "vpn.example.com"}, tc.args)
+			current, err := ClientConfigCommand(args, nil, io.Discard, io.Discard)
+			if err == nil || current != tc.wantCurrent || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("error: got (%q, %v), want %q of %s", current, err, tc.wantError, tc.wantCurrent)
+			}
+		})
+	}
+
+	args := []string{"-i", "wg1", "-client", "10.10.10.2/32", "-eh", "vpn.example.com"}
+	if current, err := ClientConfigCommand(args, nil, io.Discard, io.Discard); err == nil || current != "-i" {
+		t.Errorf("error: got (%q, %v), want the missing interface", current, err)
+	}
+}
+
+// Function simulates a privileged or unprivileged process for the test and
+// returns the output of the notices.
+func usePrivileged(t *testing.T, privileged, strictFlag bool) *strings.Builder {
+	t.Helper()
+
+	prevPrivileged, prevStrict, prevOut, prevNoticed := handlers.Privileged, strict, noticeOut, noticed
+	t.Cleanup(func() {
+		handlers.Privileged, strict, noticeOut, noticed = prevPrivileged, prevStrict, prevOut, prevNoticed
+	})
+
+	var notices strings.Builder
+	handlers.Privileged = func() bool { return privileged }
+	strict, noticeOut, noticed = strictFlag, &notices, map[string]bool{}
+	return &notices
+}
+
+// Testing that the views requiring root are skipped with a single notice
+// per subsystem without privileges, or fail with -strict.
+func TestReadPrivileged(t *testing.T) {
+	type testCase struct {
+		name        string
+		privileged  bool
+		strict      bool
+		setup       func(fake *shellmock.Runner, mock *wgmock.Client)
+		run         func() error
+		wantNotices []string
+		wantError   string
+		wantListed  bool
+	}
+
+	rulesJSON := func(flag string) func() error {
+		return func() error {
+			_, err := RulesJSONCommand([]string{flag, help.LogTypeFlag}, io.Discard)
+			return err
+		}
+	}
+	single := func(flags ...string) func() error {
+		return func() error {
+			for _, flag := range flags {
+				if _, err := SingleCommand(flag); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	tests := []testCase{
+		{
+			name:        "rules unprivileged",
+			run:         single(help.FirewallFlag, help.FirewallFlag, help.NatFlag),
+			wantNotices: []string{"firewall rules require root — skipped", "NAT rules require root — skipped"},
+		},
+		{
+			name:        "rules json unprivileged",
+			run:         rulesJSON(help.NatFlag),
+			wantNotices: []string{"NAT rules require root — skipped"},
+		},
+		{
+			name:      "rules strict",
+			strict:    true,
+			run:       single(help.FirewallFlag),
+			wantError: "firewall rules require root (or CAP_NET_ADMIN)",
+		},
+		{
+			name:       "rules permission denied",
+			privileged: true,
+			setup: func(fake *shellmock.Runner, _ *wgmock.Client) {
+				fake.Errors[firewall.CmdList] = errors.New(
+					"runtime error: iptables v1.8.7 (nf_tables): Could not fetch rule set generation id: Permission denied (you must be root), exit status 4",
+				)
+			},
+			run:         single(help.FirewallFlag),
+			wantNotices: []string{"firewall rules require root — skipped"},
+			wantListed:  true,
+		},
+		{
+			name:       "rules other error",
+			privileged: true,
+			setup: func(fake *shellmock.Runner, _ *wgmock.Client) {
+				fake.Errors[firewall.CmdList] = errors.New("runtime error: iptables: Resource temporarily unavailable")
+			},
+			run:        single(help.FirewallFlag),
+			wantError:  "Resource temporarily unavailable",
+			wantListed: true,
+		},
+		{
+			name:        "peers unprivileged",
+			run:         single(help.PeerFlag),
+			wantNotices: []string{"WireGuard peers require root — skipped"},
+		},
+		{
+			name:       "peers permission denied",
+			privileged: true,
+			setup: func(_ *shellmock.Runner, mock *wgmock.Client) {
+				mock.OpenErr = syscall.EPERM
+			},
+			run:         single(help.PeerFlag),
+			wantNotices: []string{"WireGuard peers require root — skipped"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			notices := usePrivileged(t, tc.privileged, tc.strict)
+			fake := shellmock.Install(t)
+			mock := wgmock.Install(t)
+			if tc.setup != nil {
+				tc.setup(fake, mock)
+			}
+
+			err := tc.run()
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got error %v, want %q", err, tc.wantError)
+				}
+			} else if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			got := strings.Split(strings.TrimSpace(ansi.ReplaceAllString(notices.String(), "")), "\n")
+			var want []string
+			for _, notice := range tc.wantNotices {
+				want = append(want, "notice: "+notice)
+			}
+			if len(want) == 0 {
+				want = []string{""}
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("error: got notices %q, want %q", got, want)
+			}
+
+			if listed := len(fake.Matching("iptables")) > 0; listed != tc.wantListed {
+				t.Errorf("error: got iptables listed %v, want %v: %q", listed, tc.wantListed, fake.Commands)
+			}
+		})
+	}
+}
+
+// Testing the removal of the global -strict flag.
+func TestStripStrictFlag(t *testing.T) {
+	args, found := stripStrictFlag([]string{"brggetwg", "-fr", "-strict", "-js"})
+	if !found || !slices.Equal(args, []string{"brggetwg", "-fr", "-js"}) {
+		t.Errorf("error: got %q, %v", args, found)
+	}
+
+	args, found = stripStrictFlag([]string{"brggetwg", "-pr"})
+	if found || !slices.Equal(args, []string{"brggetwg", "-pr"}) {
+		t.Errorf("error: got %q, %v", args, found)
+	}
+}
+
+// Testing the capabilities view for the subsystems they allow.
+func TestPrintCapabilities(t *testing.T) {
+	type testCase struct {
+		name string
+		set  privs.Set
+		want []string
+	}
+
+	tests := []testCase{
+		{
+			name: "net admin and raw",
+			set:  1<<privs.NetAdmin | 1<<privs.NetRaw,
+			want: []string{"CAP_NET_ADMIN: present", "CAP_NET_RAW: present", "firewall and NAT rules (iptables-legacy)   available"},
+		},
+		{
+			name: "net admin only",
+			set:  1 << privs.NetAdmin,
+			want: []string{"CAP_NET_RAW: missing", "WireGuard interfaces and peers (wgctrl)    available", "firewall and NAT rules (iptables-legacy)   requires CAP_NET_RAW", "ICMP probes (raw sockets)                  requires CAP_NET_RAW"},
+		},
+		{
+			name: "none",
+			want: []string{"CAP_NET_ADMIN: missing", "links and addresses (ip)                   requires CAP_NET_ADMIN", "(iptables-legacy)   requires CAP_NET_ADMIN, CAP_NET_RAW"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			printCapabilities(&out, tc.set)
+
+			got := ansi.ReplaceAllString(out.String(), "")
+			for _, want := range tc.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("error: missing %q in\n%s", want, got)
+				}
+			}
+		})
+	}
+}
+
+// Testing the parsing of the `-probe` options.
+func TestParseProbeOptions(t *testing.T) {
+	type testCase struct {
+		name        string
+		args        []string
+		want        probeOptions
+		wantCurrent string
+		wantError   string
+	}
+
+	tests := []testCase{
+		{name: "default", args: []string{"-k", "xTIB"}, want: probeOptions{Iface: "wg0", Key: "xTIB", Count: 3}},
+		{name: "udp_count", args: []string{"-udp", "-k", "xTIB", "-c", "5"}, want: probeOptions{Iface: "wg0", Key: "xTIB", Count: 5, UDP: true}},
+		{name: "missing_key", args: []string{"-c", "5"}, wantCurrent: "-k", wantError: "requires the peer"},
+		{name: "invalid_count", args: []string{"-k", "xTIB", "-c", "0"}, wantCurrent: "-c", wantError: "invalid number of probes"},
+		{name: "count_too_large", args: []string{"-k", "xTIB", "-c", "101"}, wantCurrent: "-c", wantError: "expected 1 to 100"},
+		{name: "duplicate", args: []string{"-k", "xTIB", "-k", "AAAA"}, wantCurrent: "-k", wantError: help.DefaultErrorMessage},
+		{name: "missing_value", args: []string{"-k"}, wantCurrent: "-k", wantError: help.DefaultErrorMessage},
+		{name: "unknown", args: []string{"-k", "xTIB", "-tcp"}, wantCurrent: "-tcp", wantError: help.DefaultErrorMessage},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, current, err := parseProbeOptions(slices.Concat([]string{"-i", "wg0", "-probe"}, tc.args))
+			if tc.wantError != "" {
+				if err == nil || current != tc.wantCurrent || !strings.Contains(err.Error(), tc.wantError) {
+					t.Errorf("error: got (%q, %v), want %q of %s", current, err, tc.wantError, tc.wantCurrent)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("error: got %+v, %v, want %+v", got, err, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the probes of the endpoint of a peer and their report.
+func TestProbeCommand(t *testing.T) {
+	prevPeer, prevAddr := probePeer, probeAddr
+	t.Cleanup(func() { probePeer, probeAddr = prevPeer, prevAddr })
+
+	handshake := time.Now().Add(-90 * time.Second).UTC().Format(time.RFC3339)
+	peers := map[string]get.PeerInfo{
+		"xTIB": {PublicKey: "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", Name: "laptop", Endpoint: "203.0.113.7:51820", LastHandshake: handshake},
+		"HIgo": {PublicKey: "HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=", Endpoint: "[2001:db8::7]:51820"},
+		"TrMv": {PublicKey: "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="},
+	}
+	probePeer = func(iface, key string) (get.PeerInfo, error) {
+		if p, ok := peers[key]; ok {
+			return p, nil
+		}
+		return get.PeerInfo{}, get.ErrNoPeerMatch
+	}
+
+	type testCase struct {
+		name        string
+		args        []string
+		result      probe.Result
+		probeErr    error
+		wantUDP     bool
+		wantTarget  string
+		wantLines   []string
+		wantCurrent string
+		wantError   error
+	}
+
+	tests := []testCase{
+		{
+			name:       "icmp",
+			args:       []string{"-k", "xTIB", "-c", "4"},
+			result:     probe.Result{Method: probe.MethodICMP, Sent: 4, Received: 3, Min: 1200 * time.Microsecond, Avg: 1500 * time.Microsecond, Max: 2 * time.Millisecond},
+			wantTarget: "203.0.113.7",
+			wantLines: []string{
+				"peer: xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg= (laptop)",
+				"  endpoint: 203.0.113.7:51820",
+				"ago)",
+				"  probes (icmp): 4 sent, 3 received, 25% loss",
+				"  rtt min/avg/max: 1.200/1.500/2.000 ms",
+			},
+		},
+		{
+			name:       "no_answer",
+			args:       []string{"-k", "HIgo"},
+			result:     probe.Result{Method: probe.MethodICMP, Sent: 3},
+			wantTarget: "2001:db8::7",
+			wantLines:  []string{"latest handshake: (none)", "100% loss", "  rtt: no answer", "hint: ICMP may be filtered on the path, probe with '-udp'"},
+		},
+		{
+			name:       "udp",
+			args:       []string{"-k", "HIgo", "-udp"},
+			result:     probe.Result{Method: probe.MethodUDP, Sent: 3},
+			wantUDP:    true,
+			wantTarget: "2001:db8::7",
+			wantLines:  []string{"probes (udp): 3 sent, 0 received, 100% loss"},
+		},
+		{name: "no_endpoint", args: []string{"-k", "TrMv"}, wantCurrent: "-probe", wantError: errors.New("has no endpoint to probe")},
+		{name: "no_peer", args: []string{"-k", "AAAA"}, wantCurrent: "-k", wantError: get.ErrNoPeerMatch},
+		{name: "no_icmp", args: []string{"-k", "xTIB"}, probeErr: probe.ErrNoICMP, wantCurrent: "-probe", wantError: os.ErrPermission},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var target string
+			var udp bool
+			probeAddr = func(addr netip.Addr, u bool, opts probe.Options) (probe.Result, error) {
+				target, udp = addr.String(), u
+				return tc.result, tc.probeErr
+			}
+
+			var stdout strings.Builder
+			current, err := ProbeCommand(slices.Concat([]string{"-i", "wg0", "-probe"}, tc.args), &stdout)
+			if tc.wantError != nil {
+				if err == nil || current != tc.wantCurrent ||
+					!(errors.Is(err, tc.wantError) || strings.Contains(err.Error(), tc.wantError.Error())) {
+					t.Errorf("error: got (%q, %v), want %v of %s", current, err, tc.wantError, tc.wantCurrent)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			if target != tc.wantTarget || udp != tc.wantUDP {
+				t.Errorf("error: probed %s (udp %v), want %s (udp %v)", target, udp, tc.wantTarget, tc.wantUDP)
+			}
+			plain := ansi.ReplaceAllString(stdout.String(), "")
+			for _, line := range tc.wantLines {
+				if !strings.Contains(plain, line) {
+					t.Errorf("error: missing %q in\n%s", line, plain)
+				}
+			}
+		})
+	}
+
+	// The code of the missing ICMP sockets.
+	if code := help.CodeOf(probe.ErrNoICMP); code != help.CodePermission {
+		t.Errorf("error: got code %s, want %s", code, help.CodePermission)
+	}
+}
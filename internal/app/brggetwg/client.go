@@ -0,0 +1,285 @@
+//go:build !windows
+
+package brggetwg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/render"
+	"github.com/AlexKira/brgnetuse/src/validate"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Networks routed through the tunnel by default: all the traffic.
+const defaultClientAllowedIPs string = "0.0.0.0/0,::/0"
+
+// Function returns the server side of a client configuration: the
+// WireGuard device of the network interface. Replaced in tests.
+var clientDevice = func(iface string) (get.DeviceInfo, error) {
+	backend, err := get.GetInterfaceBackend(iface)
+	if err != nil {
+		return get.DeviceInfo{}, err
+	}
+	if backend.AmneziaWG() {
+		return get.DeviceInfo{}, help.Errorf(
+			help.CodeUnsupported,
+			"error: client configuration is not supported for AmneziaWG interface `%s`", iface,
+		)
+	}
+
+	devices, err := get.GetPeerInfo(iface)
+	if err != nil {
+		return get.DeviceInfo{}, err
+	}
+	if len(devices) != 1 {
+		return get.DeviceInfo{}, &get.InterfaceNotFoundError{Name: iface}
+	}
+	return devices[0], nil
+}
+
+// Options of the `-client` sub-flag.
+type clientOptions struct {
+	Iface      string
+	Name       string
+	Address    []string
+	Endpoint   string
+	DNS        []string
+	AllowedIPs []string
+	Keepalive  int
+	PrivateKey handlers.Secret
+	Format     string
+	Template   string
+	QR         bool
+}
+
+// Function parses `-i [name] -client [address[,address]] -eh [host[:port]]
+// [-dns servers] [-allowed cidr[,cidr]] [-kp seconds] [-pk key|-]
+// [-name name] [-format wgquick|nm|mikrotik | -template path] [-qr]`.
+// The private key `-` is read from stdin, see handlers.ReadKey.
+func parseClientOptions(args []string, stdin io.Reader) (clientOptions, string, error) {
+	opts := clientOptions{Format: render.FormatWgQuick}
+	if len(args) < 4 || args[0] != help.WgInterfaceFlag || args[2] != help.ClientFlag {
+		return opts, help.ClientFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	opts.Iface = args[1]
+	if err := validate.CheckInterfaceName(opts.Iface); err != nil {
+		return opts, help.WgInterfaceFlag, err
+	}
+	opts.Name = opts.Iface
+
+	for _, value := range strings.Split(args[3], ",") {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(value))
+		if err != nil {
+			return opts, help.ClientFlag, help.Errorf(
+				help.CodeInvalidValue,
+				"error: invalid client address '%s', expected CIDR notation (e.g., 10.10.10.2/32)", value,
+			)
+		}
+		opts.Address = append(opts.Address, prefix.String())
+	}
+
+	allowed := defaultClientAllowedIPs
+	formatGiven := false
+	for indx := 4; indx < len(args); indx++ {
+		flag := args[indx]
+		if flag == help.QRFlag {
+			if opts.QR {
+				return opts, flag, errors.New(help.DefaultErrorMessage)
+			}
+			opts.QR = true
+			continue
+		}
+
+		indx++
+		if indx >= len(args) {
+			return opts, flag, errors.New(help.DefaultErrorMessage)
+		}
+		value := args[indx]
+
+		switch flag {
+		case help.EndPointHostFlag:
+			opts.Endpoint = value
+		case help.DNSFlag:
+			servers, err := validate.CheckDNSServers(value)
+			if err != nil {
+				return opts, flag, err
+			}
+			opts.DNS = servers
+		case help.AllowedIPsFlag:
+			allowed = value
+		case help.KeepaliveFlag:
+			keepalive, err := validate.CheckKeepalive(value)
+			if err != nil {
+				return opts, flag, err
+			}
+			opts.Keepalive = int(keepalive.Seconds())
+		case help.PrivateKeyFlag:
+			if value == help.StdinValue {
+				key, err := handlers.ReadKey(stdin)
+				if err != nil {
+					return opts, flag, err
+				}
+				opts.PrivateKey = key
+			} else {
+				opts.PrivateKey = handlers.NewSecret(value)
+			}
+		case help.PeerNameFlag:
+			if err := validate.CheckInterfaceName(value); err != nil {
+				return opts, flag, err
+			}
+			opts.Name = value
+		case help.FormatFlag:
+			formatGiven = true
+			opts.Format = value
+		case help.TemplateFlag:
+			opts.Template = value
+		default:
+			return opts, flag, errors.New(help.DefaultErrorMessage)
+		}
+	}
+
+	if opts.Endpoint == "" {
+		return opts, help.EndPointHostFlag, help.Errorf(
+			help.CodeInvalidArguments,
+			"error: the client configuration requires the server endpoint, pass '%s [host[:port]]'",
+			help.EndPointHostFlag,
+		)
+	}
+	if formatGiven && opts.Template != "" {
+		return opts, help.TemplateFlag, help.Errorf(
+			help.CodeInvalidArguments,
+			"error: '%s' and '%s' cannot be used together", help.FormatFlag, help.TemplateFlag,
+		)
+	}
+
+	networks, err := validate.CheckAllowedIPs(strings.Split(allowed, ","))
+	if err != nil {
+		return opts, help.AllowedIPsFlag, err
+	}
+	for _, network := range networks {
+		opts.AllowedIPs = append(opts.AllowedIPs, network.String())
+	}
+	if opts.DNS == nil {
+		opts.DNS = []string{}
+	}
+
+	return opts, help.ClientFlag, nil
+}
+
+// Function returns the endpoint of the client configuration, `host:port`:
+// the port defaults to the listen port of the server.
+func clientEndpoint(value string, listenPort int) (string, error) {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+		port = strconv.Itoa(listenPort)
+	}
+
+	number, err := validate.CheckPort(port)
+	if err == nil && (number < 1 || number > 65535) {
+		err = help.Errorf(help.CodeInvalidValue, "error: invalid endpoint port %d in '%s', expected range 1-65535", number, value)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := net.JoinHostPort(host, port)
+	if _, err := netip.ParseAddr(host); err != nil {
+		if _, _, err := validate.CheckEndpointHost(endpoint); err != nil {
+			return "", err
+		}
+	}
+	return endpoint, nil
+}
+
+// Function prints the configuration of a client of the network interface,
+// rendered with a built-in template (-format, wg-quick by default) or a
+// custom one (-template), see the render package. Without -pk a key pair
+// is generated, and its public key is printed on stderr for adding the
+// peer. With -qr the rendered text is printed as a QR code (qrencode).
+// Expected format: see parseClientOptions.
+func ClientConfigCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) (string, error) {
+	opts, currentFlag, err := parseClientOptions(args, stdin)
+	if err != nil {
+		return currentFlag, help.WithDefaultCode(help.CodeInvalidValue, err)
+	}
+	defer opts.PrivateKey.Zero()
+
+	var tmpl *template.Template
+	if opts.Template != "" {
+		tmpl, err = render.ParseFile(opts.Template)
+		currentFlag = help.TemplateFlag
+	} else {
+		tmpl, err = render.Builtin(opts.Format)
+		currentFlag = help.FormatFlag
+	}
+	if err != nil {
+		return currentFlag, err
+	}
+
+	device, err := clientDevice(opts.Iface)
+	if err != nil {
+		return help.WgInterfaceFlag, err
+	}
+
+	endpoint, err := clientEndpoint(opts.Endpoint, device.ListenPort)
+	if err != nil {
+		return help.EndPointHostFlag, err
+	}
+
+	var key wgtypes.Key
+	generated := opts.PrivateKey.IsEmpty()
+	if generated {
+		var keys get.KeyPair
+		keys, err = get.GenerateKeyPair()
+		key = keys.Private
+		keys.Zero()
+	} else {
+		key, err = handlers.CheckPrivateKey(opts.PrivateKey)
+	}
+	if err != nil {
+		return help.PrivateKeyFlag, err
+	}
+	defer clear(key[:])
+
+	text, err := render.Render(tmpl, render.Client{
+		Name:                opts.Name,
+		ServerPublicKey:     device.PublicKey,
+		Endpoint:            endpoint,
+		PrivateKey:          key.String(),
+		PublicKey:           key.PublicKey().String(),
+		Address:             opts.Address,
+		DNS:                 opts.DNS,
+		AllowedIPs:          opts.AllowedIPs,
+		PersistentKeepalive: opts.Keepalive,
+	})
+	if err != nil {
+		return currentFlag, err
+	}
+
+	if generated {
+		fmt.Fprintf(stderr, "client public key: %s\n", key.PublicKey())
+	}
+
+	if opts.QR {
+		qr, err := render.QRCode(text, render.QRTerminal)
+		if err != nil {
+			return help.QRFlag, err
+		}
+		stdout.Write(qr)
+		return help.ClientFlag, nil
+	}
+	fmt.Fprint(stdout, text)
+	return help.ClientFlag, nil
+}
@@ -0,0 +1,210 @@
+//go:build !windows
+
+package brggetwg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/validate"
+)
+
+// Options of the `-counters` sub-flag.
+type countersOptions struct {
+	// Nat selects the MASQUERADE rules of the subnet Target (-n), or the
+	// FORWARD rules of the network interface Target (-fr).
+	Nat    bool
+	Target string
+
+	// Interval between the two samples of the rates, 0 for one sample.
+	Interval time.Duration
+}
+
+// Function parses `-n -counters [subnet] [-w sec]` and
+// `-fr -counters -i [name] [-w sec]`.
+func parseCountersOptions(args []string) (countersOptions, string, error) {
+	if len(args) < 3 || args[1] != help.CountersFlag {
+		return countersOptions{}, help.CountersFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	var opts countersOptions
+	var rest []string
+
+	switch args[0] {
+	case help.NatFlag:
+		prefix, err := netip.ParsePrefix(args[2])
+		if err != nil || !prefix.Addr().Is4() {
+			return opts, help.CountersFlag, help.Errorf(help.CodeInvalidValue, "error: invalid IPv4 subnet '%s'", args[2])
+		}
+		opts = countersOptions{Nat: true, Target: prefix.Masked().String()}
+		rest = args[3:]
+
+	case help.FirewallFlag:
+		if len(args) < 4 || args[2] != help.WgInterfaceFlag {
+			return opts, help.CountersFlag, errors.New(help.DefaultErrorMessage)
+		}
+		if err := validate.CheckInterfaceName(args[3]); err != nil {
+			return opts, help.WgInterfaceFlag, err
+		}
+		opts = countersOptions{Target: args[3]}
+		rest = args[4:]
+
+	default:
+		return opts, args[0], errors.New(help.DefaultErrorMessage)
+	}
+
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && rest[0] == help.WatchFlag:
+		interval, err := parseInterval(rest[1])
+		if err != nil {
+			return opts, help.WatchFlag, err
+		}
+		opts.Interval = interval
+	default:
+		return opts, rest[0], errors.New(help.DefaultErrorMessage)
+	}
+
+	return opts, help.CountersFlag, nil
+}
+
+// Function prints the packet and byte counters of the rules managed for a
+// subnet (-n) or a network interface (-fr). With -w, the rules are sampled
+// twice an interval apart and the rates are printed too. Rules without
+// traffic are highlighted, and a hint follows when none has any.
+// Expected format: `-n -counters [subnet] [-w sec]` or
+// `-fr -counters -i [name] [-w sec]`.
+func CountersCommand(args []string, stdout io.Writer) (string, error) {
+	opts, currentFlag, err := parseCountersOptions(args)
+	if err != nil {
+		return currentFlag, help.WithDefaultCode(help.CodeInvalidValue, err)
+	}
+
+	// Function reads the counters of the managed rules.
+	sample := func() ([]get.RuleCounter, error) {
+		rules, err := getRules(opts.Nat)
+		if err != nil {
+			return nil, err
+		}
+
+		var counters []get.RuleCounter
+		if opts.Nat {
+			counters, err = get.NatCounters(rules, opts.Target)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			counters = get.ForwardCounters(rules, opts.Target)
+		}
+
+		if len(counters) == 0 && opts.Nat {
+			return nil, help.Errorf(help.CodeNotFound, "error: no MASQUERADE rule found for subnet '%s'", opts.Target)
+		}
+		if len(counters) == 0 {
+			return nil, help.Errorf(help.CodeNotFound, "error: no FORWARD rule found for network interface '%s'", opts.Target)
+		}
+		return counters, nil
+	}
+
+	var rates []get.RuleRate
+	if opts.Interval == 0 {
+		counters, err := sample()
+		if err != nil {
+			return args[0], err
+		}
+		rates = get.RuleRates(nil, counters, 0)
+	} else {
+		rates, err = sampleRates(opts.Interval, sample)
+		if err != nil {
+			return args[0], err
+		}
+	}
+
+	if printCounters(stdout, rates) {
+		forwarding, err := get.GetIPvForwarding()
+		fmt.Fprintln(stdout, Yellow+idleHint(forwarding, err)+Reset)
+	}
+	return help.CountersFlag, nil
+}
+
+// Function samples the counters twice, an interval apart, with the
+// sampling of the watch modes (see get.Watch), and returns their rates.
+// Interrupted before the second sample, the rates are left unmeasured.
+func sampleRates(interval time.Duration, sample func() ([]get.RuleCounter, error)) ([]get.RuleRate, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var samples [][]get.RuleCounter
+	var times []time.Time
+	err := get.Watch(ctx, interval, func(context.Context) error {
+		counters, err := sample()
+		if err != nil {
+			return err
+		}
+		samples = append(samples, counters)
+		times = append(times, time.Now())
+		if len(samples) == 2 {
+			stop()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(samples) < 2 {
+		return get.RuleRates(nil, samples[0], 0), nil
+	}
+	return get.RuleRates(samples[0], samples[1], times[1].Sub(times[0])), nil
+}
+
+// Function prints one line per rule: the chain, target and traffic
+// direction, the counters and, when measured, the rates. It reports
+// whether no rule had any traffic: no packet counted, or none during the
+// sampling when the rates were measured.
+func printCounters(w io.Writer, rates []get.RuleRate) bool {
+	idle := true
+	for _, r := range rates {
+		rule := r.Rule
+
+		from := rule.In
+		if r.Chain == "POSTROUTING" {
+			from = rule.Source
+		}
+		line := fmt.Sprintf("%s %s %s -> %s", r.Chain, rule.Target, from, rule.Out)
+		if rule.Comment != "" {
+			line += fmt.Sprintf(" [%s]", rule.Comment)
+		}
+		line += fmt.Sprintf(": %d pkts, %s", rule.Pkts, formatBytes(int64(rule.Bytes)))
+		if r.Delta {
+			line += fmt.Sprintf(", %.1f pkts/s, %s/s", r.Packets, formatBytes(int64(r.Bytes)))
+		}
+
+		if rule.Pkts == 0 || (r.Delta && r.Packets == 0) {
+			line += Yellow + " (no traffic)" + Reset
+		} else {
+			idle = false
+		}
+		fmt.Fprintln(w, line)
+	}
+	return idle
+}
+
+// Function returns the hint printed when the rules have no traffic: the
+// usual cause is IPv4 forwarding left disabled.
+func idleHint(forwarding map[string]int, err error) string {
+	const prefix = "hint: no traffic through the rules, "
+	if err == nil && forwarding["ipv4"] != 1 {
+		return prefix + "net.ipv4.ip_forward is disabled (brgsetwg -fw4 -a)"
+	}
+	return prefix + "check the forwarding sysctls (brggetwg -fw) and the routes of the peers"
+}
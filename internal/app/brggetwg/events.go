@@ -0,0 +1,179 @@
+//go:build !windows
+
+package brggetwg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/get"
+)
+
+// Default sampling interval of `-watch-events`.
+const defaultEventInterval = 5 * time.Second
+
+// Environment variables of the peer event hook.
+const (
+	EnvHookPeerKey   string = "BRG_PEER_KEY"
+	EnvHookPeerIP    string = "BRG_PEER_IP"
+	EnvHookEvent     string = "BRG_EVENT"
+	EnvHookInterface string = "BRG_IFACE"
+)
+
+// Options of the `-watch-events` sub-flag.
+type eventOptions struct {
+	Interface string
+	Exec      string
+	Interval  time.Duration
+	DownAfter time.Duration
+	Debounce  time.Duration
+}
+
+// Function parses `-i [name] -watch-events [-exec path] [-w sec]
+// [-down-after sec] [-debounce sec]`. The hook must be an executable file.
+func parseEventOptions(args []string) (eventOptions, string, error) {
+	if len(args) < 3 || args[0] != help.WgInterfaceFlag || args[2] != help.WatchEventsFlag {
+		return eventOptions{}, help.WatchEventsFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	opts := eventOptions{Interface: args[1], Interval: defaultEventInterval}
+	seen := make(map[string]bool)
+
+	for i := 3; i < len(args); i++ {
+		flag := args[i]
+		switch flag {
+		case help.ExecFlag, help.WatchFlag, help.DownAfterFlag, help.DebounceFlag:
+		default:
+			return opts, flag, errors.New(help.DefaultErrorMessage)
+		}
+		if seen[flag] || i+1 >= len(args) || args[i+1] == "" {
+			return opts, flag, errors.New(help.DefaultErrorMessage)
+		}
+		seen[flag] = true
+		value := args[i+1]
+		i++
+
+		if flag == help.ExecFlag {
+			info, err := os.Stat(value)
+			if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+				return opts, flag, help.Errorf(help.CodeInvalidValue, "error: hook '%s' is not an executable file", value)
+			}
+			opts.Exec = value
+			continue
+		}
+
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 || (seconds == 0 && flag == help.WatchFlag) {
+			return opts, flag, help.Errorf(help.CodeInvalidValue, "error: invalid value '%s', expected a number of seconds", value)
+		}
+		duration := time.Duration(seconds) * time.Second
+
+		switch flag {
+		case help.WatchFlag:
+			opts.Interval = duration
+		case help.DownAfterFlag:
+			opts.DownAfter = duration
+		case help.DebounceFlag:
+			opts.Debounce = duration
+		}
+	}
+
+	return opts, help.WatchEventsFlag, nil
+}
+
+// Function watches the peers of a WireGuard interface until Ctrl-C and
+// reports every peer going up or down (see get.PeerTracker): one line per
+// event on stdout, and the hook of `-exec` run with the event in its
+// environment. A failing hook is reported and the watch goes on.
+func WatchEventsCommand(args []string, stdout, stderr io.Writer) (string, error) {
+	opts, currentFlag, err := parseEventOptions(args)
+	if err != nil {
+		return currentFlag, help.WithDefaultCode(help.CodeInvalidValue, err)
+	}
+
+	exists, err := get.GetExistInterface(opts.Interface)
+	if err != nil {
+		return help.WgInterfaceFlag, err
+	}
+	if !exists {
+		return help.WgInterfaceFlag, &get.InterfaceNotFoundError{Name: opts.Interface}
+	}
+
+	sampler, err := get.NewSampler()
+	if err != nil {
+		return help.WatchEventsFlag, err
+	}
+	defer sampler.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	tracker := get.NewPeerTracker(opts.DownAfter, opts.Debounce)
+	err = get.Watch(ctx, opts.Interval, func(context.Context) error {
+		devices, err := sampler.PeerInfo(opts.Interface)
+		if err != nil {
+			return err
+		}
+
+		reportEvents(tracker.Update(devices, time.Now()), opts.Exec, stdout, stderr)
+		return nil
+	})
+	if err != nil {
+		return help.WatchEventsFlag, err
+	}
+
+	return help.WatchEventsFlag, nil
+}
+
+// Function prints the events and runs the hook, when set, on each of them.
+// A failing hook is printed as a warning on stderr.
+func reportEvents(events []get.PeerEvent, hook string, stdout, stderr io.Writer) {
+	for _, event := range events {
+		fmt.Fprintf(stdout, "%s %s peer %s %s\n",
+			event.Time.Format(time.RFC3339), event.Interface, event.PublicKey, event.Type)
+
+		if hook == "" {
+			continue
+		}
+		if err := runHook(hook, hookEnv(event)); err != nil {
+			fmt.Fprintf(stderr, Yellow+"warning: hook '%s' failed: %v"+Reset+"\n", hook, err)
+		}
+	}
+}
+
+// Function returns the environment of the hook: the process environment
+// with the event variables.
+func hookEnv(event get.PeerEvent) []string {
+	var ip string
+	if len(event.AllowedIPs) > 0 {
+		ip, _, _ = strings.Cut(event.AllowedIPs[0], "/")
+	}
+
+	return append(
+		os.Environ(),
+		EnvHookPeerKey+"="+event.PublicKey,
+		EnvHookPeerIP+"="+ip,
+		EnvHookEvent+"="+string(event.Type),
+		EnvHookInterface+"="+event.Interface,
+	)
+}
+
+// runHook runs the hook with the environment, attached to the standard
+// output and error. Tests replace it to record the calls.
+var runHook = func(path string, env []string) error {
+	cmd := exec.Command(path)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,108 @@
+//go:build !windows
+
+package brggetwg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/AlexKira/brgnetuse/internal/group"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/get"
+)
+
+// Lookups of the WireGuard devices and of all the network interfaces a
+// group is matched against, replaced in tests.
+var (
+	groupDevices = get.GetWireGuardDevices
+	groupLinks   = group.Links
+)
+
+// Function runs the command of a single network interface of a group
+// (`-i name ...`) and returns the health status of `-check`, 0 for the
+// other commands. Replaced in tests.
+var runInterface = func(args []string, stdout io.Writer) (int, error) {
+	if args[2] == help.CheckFlag {
+		status, _, err := HealthCommand(args, stdout)
+		return status, err
+	}
+	_, err := GetInterfaceCommnd(args)
+	return 0, err
+}
+
+// Function reports whether the command line (os.Args) runs a network
+// interface command on a group of interfaces: `-g prefix` or `-i` with a
+// glob pattern (e.g., `-i 'wg-cust*'`).
+func isGroupArgs(args []string) bool {
+	if len(args) < 3 {
+		return false
+	}
+	return args[1] == help.GroupFlag ||
+		(args[1] == help.WgInterfaceFlag && group.IsPattern(args[2]))
+}
+
+// Function runs a network interface command on each WireGuard device of a
+// group, one after the other, then prints the table of the outcomes.
+// Expected format: `-g [prefix]|-i [pattern] [sub_flag] [options]`, e.g.,
+// `-g wg-cust -pr` or `-i 'wg-cust*' -check`. The network interfaces
+// matching the group which are not WireGuard devices are reported on
+// stderr and skipped.
+//
+// The returned status is the exit code: with -check the worst health
+// status of the group, otherwise 1 when an interface failed.
+func GroupCommand(args []string, stdout, stderr io.Writer) (int, string, error) {
+	if len(args) < 3 {
+		return 0, args[0], errors.New(help.DefaultErrorMessage)
+	}
+
+	pattern, err := group.Pattern(args[0], args[1])
+	if err != nil {
+		return 0, args[0], err
+	}
+
+	rest := args[2:]
+	if rest[0] == help.WatchEventsFlag || slices.Contains(rest, help.WatchFlag) {
+		return 0, rest[0], help.Errorf(help.CodeUnsupported, "error: a group of network interfaces cannot be watched")
+	}
+
+	devices, err := groupDevices()
+	if err != nil {
+		return 0, args[1], err
+	}
+	links, err := groupLinks()
+	if err != nil {
+		return 0, args[1], err
+	}
+
+	ifaces, ignored, err := group.Expand(pattern, devices, links)
+	for _, name := range ignored {
+		fmt.Fprintln(stderr, Yellow+"warning: network interface '"+name+"' is not a WireGuard device, skipped"+Reset)
+	}
+	if err != nil {
+		return 0, args[1], err
+	}
+
+	worst := 0
+	statuses := group.Run(ifaces, 1, func(indx int) error {
+		fmt.Fprintf(stdout, Bold+"%s:"+Reset+"\n", ifaces[indx])
+		defer fmt.Fprintln(stdout)
+
+		status, err := runInterface(slices.Concat([]string{help.WgInterfaceFlag, ifaces[indx]}, rest), stdout)
+		worst = max(worst, status)
+		if err == nil && status != 0 {
+			err = errors.New("error: " + get.Severity(status).String())
+		}
+		return err
+	})
+
+	failures := group.WriteTable(stdout, statuses)
+	if rest[0] == help.CheckFlag {
+		return worst, "", nil
+	}
+	if failures > 0 {
+		return help.ExitSetupFailed, "", nil
+	}
+	return 0, "", nil
+}
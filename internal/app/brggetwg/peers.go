@@ -0,0 +1,253 @@
+//go:build !windows
+
+package brggetwg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/get"
+)
+
+// Size of the buffer the peer listing is written through.
+const peerBufferSize = 64 << 10
+
+// Writer of the peer listing. The devices and peers are written to a
+// buffered writer as they are formatted, the numbers in a scratch buffer
+// reused for every peer, so a listing of thousands of peers is neither
+// held in memory nor formatted through a string per value. The first
+// failed write, e.g., stdout closed by `| head`, stops the listing.
+type peerWriter struct {
+	w       *bufio.Writer
+	scratch []byte
+	now     time.Time
+	err     error
+}
+
+// Function returns a writer of the peer listing to w.
+func newPeerWriter(w io.Writer) *peerWriter {
+	return &peerWriter{
+		w:       bufio.NewWriterSize(w, peerBufferSize),
+		scratch: make([]byte, 0, 64),
+		now:     time.Now(),
+	}
+}
+
+// Method writes the strings, nothing after a failed write.
+func (pw *peerWriter) str(values ...string) {
+	for _, value := range values {
+		if pw.err != nil {
+			return
+		}
+		_, pw.err = pw.w.WriteString(value)
+	}
+}
+
+// Method writes the scratch buffer, nothing after a failed write.
+func (pw *peerWriter) flushScratch() {
+	if pw.err == nil {
+		_, pw.err = pw.w.Write(pw.scratch)
+	}
+	pw.scratch = pw.scratch[:0]
+}
+
+// Method writes the remaining buffered output and returns the first
+// failed write.
+func (pw *peerWriter) close() error {
+	if pw.err == nil {
+		pw.err = pw.w.Flush()
+	}
+	return pw.err
+}
+
+// Function writes the devices and their peers to w. With deltas (watch
+// mode) the latest handshake is shown and changes are highlighted. It
+// stops at the first failed write and returns it, see stdoutError.
+func writeDevices(w io.Writer, devices []get.DeviceInfo, deltas map[get.PeerID]get.PeerDelta) error {
+	pw := newPeerWriter(w)
+	for _, d := range devices {
+		pw.device(d)
+		for _, p := range d.Peers {
+			if deltas == nil {
+				pw.peer(p, nil)
+			} else {
+				delta := deltas[get.PeerID{Interface: d.Name, PublicKey: p.PublicKey}]
+				pw.peer(p, &delta)
+			}
+			if pw.err != nil {
+				return pw.err
+			}
+		}
+	}
+	return pw.close()
+}
+
+// Method writes the WireGuard device information.
+// The backend (e.g., "kernel WireGuard") and its implementation are shown
+// when detected, and the last change of the configuration when recorded.
+func (pw *peerWriter) device(d get.DeviceInfo) {
+	pw.str(
+		"\n"+Green+Bold+"interface: "+Reset+Green, d.Name, " "+Reset+"\n",
+		Bold+"  public key: "+Reset, d.PublicKey, " \n",
+		Bold+"  private key: "+Reset+"(hidden)\n",
+		Bold+"  listening port: "+Reset,
+	)
+	pw.scratch = strconv.AppendInt(pw.scratch, int64(d.ListenPort), 10)
+	pw.scratch = append(pw.scratch, '\n')
+	pw.flushScratch()
+
+	if d.Backend != "" {
+		pw.str(Bold+"  backend: "+Reset, d.Backend, "\n")
+	}
+	if d.Implementation != "" {
+		pw.str(Bold+"  implementation: "+Reset, d.Implementation, "\n")
+	}
+	if d.LastChange != nil {
+		pw.str(Bold+"  last modified: "+Reset, d.LastChange.String(), "\n")
+	}
+}
+
+// Method writes the WireGuard peer information.
+// The peer name and note from the peer metadata are shown when set.
+// A non-nil delta (watch mode) adds the latest handshake and highlights
+// the values that changed since the previous sample.
+func (pw *peerWriter) peer(p get.PeerInfo, delta *get.PeerDelta) {
+	pw.str("\n"+Bold+Yellow+"peer: "+Reset+Yellow, p.PublicKey, Reset)
+	if p.Name != "" {
+		pw.str(" (", p.Name, ")")
+	}
+	if delta != nil && delta.New {
+		pw.str(Green + " (new)" + Reset)
+	}
+
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = "<nil>"
+	}
+	pw.str("\n"+Bold+"  endpoint: "+Reset, endpoint, "\n"+Bold+"  allowed ips: "+Reset)
+
+	// The allowed IPs are written one by one, with the slash colored,
+	// instead of joined and rewritten.
+	for i, ip := range p.AllowedIPs {
+		if i > 0 {
+			pw.str(", ")
+		}
+		for {
+			slash := strings.IndexByte(ip, '/')
+			if slash < 0 {
+				pw.str(ip)
+				break
+			}
+			pw.str(ip[:slash], Cyan+"/"+Reset)
+			ip = ip[slash+1:]
+		}
+	}
+
+	pw.str("\n" + Bold + "  transfer: " + Reset)
+	pw.scratch = appendBytes(pw.scratch, p.ReceiveBytes)
+	pw.scratch = append(pw.scratch, " received, "...)
+	pw.scratch = appendBytes(pw.scratch, p.TransmitBytes)
+	pw.scratch = append(pw.scratch, " sent"...)
+	if delta != nil && (delta.ReceiveBytes != 0 || delta.TransmitBytes != 0) {
+		pw.scratch = append(pw.scratch, Green+" (+"...)
+		pw.scratch = strconv.AppendInt(pw.scratch, delta.ReceiveBytes, 10)
+		pw.scratch = append(pw.scratch, " B, +"...)
+		pw.scratch = strconv.AppendInt(pw.scratch, delta.TransmitBytes, 10)
+		pw.scratch = append(pw.scratch, " B)"+Reset...)
+	}
+	pw.scratch = append(pw.scratch, "\n"+Bold+"  persistent keepalive: "+Reset+"every "...)
+	pw.scratch = strconv.AppendInt(pw.scratch, int64(p.PersistentKeepalive), 10)
+	pw.scratch = append(pw.scratch, " "+Cyan+"seconds"+Reset+"\n"...)
+	pw.flushScratch()
+
+	if delta != nil {
+		handshake := "(none)"
+		if p.LastHandshake != "" {
+			handshake = p.LastHandshake
+		}
+		if delta.Handshake {
+			pw.str(Bold+"  latest handshake: "+Reset+Green, handshake, Reset+"\n")
+		} else {
+			pw.str(Bold+"  latest handshake: "+Reset, handshake, "\n")
+		}
+	}
+
+	if p.Note != "" {
+		pw.str(Bold+"  note: "+Reset, p.Note, "\n")
+	}
+
+	if p.Expires != "" {
+		if t, err := time.Parse(time.RFC3339, p.Expires); err == nil && !pw.now.Before(t) {
+			pw.str(Bold+"  expires: "+Reset+Red, p.Expires, " (expired)"+Reset+"\n")
+		} else {
+			pw.str(Bold+"  expires: "+Reset, p.Expires, "\n")
+		}
+	}
+
+	if p.Quota != nil {
+		pw.str(Bold+"  quota: "+Reset, formatQuota(*p.Quota), "\n")
+	}
+}
+
+// A peer of the JSON listing, with the name of its interface.
+type peerJSON struct {
+	Interface string `json:"interface"`
+	get.PeerInfo
+}
+
+// Function writes the peers of the devices to w as an indented JSON array,
+// each peer encoded on its own into a reused buffer and written out, so
+// the document is never held whole. An empty listing is an empty array.
+func writePeersJSON(w io.Writer, devices []get.DeviceInfo) error {
+	out := bufio.NewWriterSize(w, peerBufferSize)
+
+	var element bytes.Buffer
+	encoder := json.NewEncoder(&element)
+	encoder.SetIndent("  ", "  ")
+
+	separator := "[\n  "
+	for _, d := range devices {
+		for _, p := range d.Peers {
+			element.Reset()
+			if err := encoder.Encode(peerJSON{Interface: d.Name, PeerInfo: p}); err != nil {
+				return fmt.Errorf("error: failed to encode the peers: %v", err)
+			}
+
+			if _, err := out.WriteString(separator); err != nil {
+				return err
+			}
+			if _, err := out.Write(bytes.TrimSuffix(element.Bytes(), []byte("\n"))); err != nil {
+				return err
+			}
+			separator = ",\n  "
+		}
+	}
+
+	end := "\n]\n"
+	if separator == "[\n  " {
+		end = "[]\n"
+	}
+	if _, err := out.WriteString(end); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// Function returns the error of a failed write of a listing to stdout,
+// nil when the reader went away (EPIPE, e.g., `brggetwg -pr | head`):
+// the listing is only cut short, as the reader asked.
+func stdoutError(err error) error {
+	if err == nil || errors.Is(err, syscall.EPIPE) {
+		return nil
+	}
+	return help.Errorf(help.CodeFileFailed, "error: failed to write the peers: %v", err)
+}
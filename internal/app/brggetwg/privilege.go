@@ -0,0 +1,107 @@
+//go:build !windows
+
+package brggetwg
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/privs"
+)
+
+// Subsystems read by brggetwg which require CAP_NET_ADMIN (see privs). The
+// interfaces, addresses, forwarding and sockets are readable unprivileged.
+const (
+	subsystemPeers    = "WireGuard peers"
+	subsystemFirewall = "firewall rules"
+	subsystemNat      = "NAT rules"
+)
+
+var (
+	// With the global -strict flag, a view requiring root fails instead of
+	// being skipped, see readPrivileged.
+	strict bool
+
+	// Output of the notices of the skipped views.
+	noticeOut io.Writer = os.Stderr
+
+	// Subsystems already noticed, so a notice is printed once per run.
+	noticed = map[string]bool{}
+)
+
+// Function removes the global -strict flag, given at any position, and
+// reports whether it was present.
+func stripStrictFlag(args []string) ([]string, bool) {
+	found := false
+	rest := make([]string, 0, len(args))
+	for indx, arg := range args {
+		if indx > 0 && arg == help.StrictFlag {
+			found = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, found
+}
+
+// Function reads a subsystem requiring root with read. Without root, or
+// when read fails on a permission error, the subsystem is skipped with a
+// single notice and nil is returned, so the rest of the view is still
+// rendered; with -strict the command fails instead.
+func readPrivileged(subsystem string, read func() error) error {
+	if !handlers.Privileged() {
+		return skipUnprivileged(subsystem)
+	}
+
+	err := read()
+	if handlers.IsPermission(err) {
+		return skipUnprivileged(subsystem)
+	}
+	return err
+}
+
+// Function reports the subsystem skipped for lack of privileges, see
+// readPrivileged.
+func skipUnprivileged(subsystem string) error {
+	if strict {
+		return help.Errorf(help.CodePermission, "error: %s require root (or CAP_NET_ADMIN)", subsystem)
+	}
+
+	if !noticed[subsystem] {
+		noticed[subsystem] = true
+		fmt.Fprintf(noticeOut, Yellow+"notice: %s require root — skipped"+Reset+"\n", subsystem)
+	}
+	return nil
+}
+
+// Function prints the capabilities of the process and, for each subsystem
+// of privs.Requirements, whether they allow it.
+func printCapabilities(w io.Writer, set privs.Set) {
+	fmt.Fprintf(w, Bold+"uid: "+Reset+"%d\n", os.Geteuid())
+	for _, c := range []privs.Capability{privs.NetAdmin, privs.NetRaw} {
+		state := Red + "missing" + Reset
+		if set.Has(c) {
+			state = Green + "present" + Reset
+		}
+		fmt.Fprintf(w, Bold+"%s: "+Reset+"%s\n", c, state)
+	}
+
+	fmt.Fprintln(w)
+	for _, req := range privs.Requirements {
+		missing := set.Missing(req.Capabilities...)
+		if len(missing) == 0 {
+			fmt.Fprintf(w, "%-42s "+Green+"available"+Reset+"\n", req.Subsystem)
+			continue
+		}
+
+		names := make([]string, 0, len(missing))
+		for _, c := range missing {
+			names = append(names, c.String())
+		}
+		fmt.Fprintf(w, "%-42s "+Red+"requires %s"+Reset+"\n", req.Subsystem, strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,184 @@
+//go:build !windows
+
+package brggetwg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/probe"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/validate"
+)
+
+// Largest number of probes of `-probe -c`.
+const maxProbeCount = 100
+
+// Function returns the peer of the network interface selected by its
+// public key or a unique prefix. Replaced in tests.
+var probePeer = func(iface, key string) (get.PeerInfo, error) {
+	if !handlers.Privileged() {
+		return get.PeerInfo{}, help.Errorf(help.CodePermission, "error: %s require root (or CAP_NET_ADMIN)", subsystemPeers)
+	}
+
+	devices, err := get.GetPeerInfo(iface)
+	if err != nil {
+		return get.PeerInfo{}, err
+	}
+	if len(devices) != 1 {
+		return get.PeerInfo{}, &get.InterfaceNotFoundError{Name: iface}
+	}
+
+	devices, err = get.FilterPeers(devices, get.PeerFilter{Key: key})
+	if err != nil {
+		return get.PeerInfo{}, err
+	}
+	return devices[0].Peers[0], nil
+}
+
+// Function probes the address, with ICMP echo requests or UDP probes.
+// Replaced in tests.
+var probeAddr = func(addr netip.Addr, udp bool, opts probe.Options) (probe.Result, error) {
+	if udp {
+		return probe.UDP(addr, opts)
+	}
+	return probe.ICMP(addr, opts)
+}
+
+// Options of the `-probe` sub-flag.
+type probeOptions struct {
+	Iface string
+	Key   string
+	Count int
+	UDP   bool
+}
+
+// Function parses `-i [name] -probe -k [key|prefix] [-c n] [-udp]`. Each
+// option may be given once.
+func parseProbeOptions(args []string) (probeOptions, string, error) {
+	opts := probeOptions{Count: probe.DefaultCount}
+	if len(args) < 3 || args[0] != help.WgInterfaceFlag || args[2] != help.ProbeFlag {
+		return opts, help.ProbeFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	opts.Iface = args[1]
+	if err := validate.CheckInterfaceName(opts.Iface); err != nil {
+		return opts, help.WgInterfaceFlag, err
+	}
+
+	seen := make(map[string]bool)
+	rest := args[3:]
+	for i := 0; i < len(rest); i++ {
+		flag := rest[i]
+		if seen[flag] {
+			return opts, flag, errors.New(help.DefaultErrorMessage)
+		}
+		seen[flag] = true
+
+		if flag == help.UDPFlag {
+			opts.UDP = true
+			continue
+		}
+		if i+1 >= len(rest) || rest[i+1] == "" {
+			return opts, flag, errors.New(help.DefaultErrorMessage)
+		}
+
+		switch flag {
+		case help.PeerKeyFlag:
+			opts.Key = rest[i+1]
+		case help.CountFlag:
+			count, err := strconv.Atoi(rest[i+1])
+			if err != nil || count < 1 || count > maxProbeCount {
+				return opts, flag, help.Errorf(
+					help.CodeInvalidValue,
+					"error: invalid number of probes '%s', expected 1 to %d", rest[i+1], maxProbeCount,
+				)
+			}
+			opts.Count = count
+		default:
+			return opts, flag, errors.New(help.DefaultErrorMessage)
+		}
+		i++
+	}
+
+	if opts.Key == "" {
+		return opts, help.PeerKeyFlag, help.Errorf(help.CodeInvalidArguments, "error: '%s' requires the peer '%s [key]'", help.ProbeFlag, help.PeerKeyFlag)
+	}
+	return opts, help.ProbeFlag, nil
+}
+
+// Function measures the latency to the endpoint of a peer: a few ICMP echo
+// requests, or UDP probes to a closed port with -udp when ping is blocked
+// on the path. It prints the minimum, average and maximum round-trip times
+// and the loss, with the latest handshake of the peer. The endpoint port is
+// not probed: WireGuard drops what it cannot authenticate, without an
+// answer.
+// Expected format: `-i [name] -probe -k [key|prefix] [-c n] [-udp]`.
+func ProbeCommand(args []string, stdout io.Writer) (string, error) {
+	opts, currentFlag, err := parseProbeOptions(args)
+	if err != nil {
+		return currentFlag, help.WithDefaultCode(help.CodeInvalidValue, err)
+	}
+
+	peer, err := probePeer(opts.Iface, opts.Key)
+	if err != nil {
+		return help.PeerKeyFlag, err
+	}
+	if peer.Endpoint == "" {
+		return help.ProbeFlag, help.Errorf(help.CodeInvalidValue, "error: peer '%s' has no endpoint to probe", peer.PublicKey)
+	}
+	endpoint, err := netip.ParseAddrPort(peer.Endpoint)
+	if err != nil {
+		return help.ProbeFlag, help.Errorf(help.CodeInvalidValue, "error: invalid endpoint '%s' of peer '%s'", peer.Endpoint, peer.PublicKey)
+	}
+
+	result, err := probeAddr(endpoint.Addr(), opts.UDP, probe.Options{
+		Count:    opts.Count,
+		Interval: probe.DefaultInterval,
+	})
+	if err != nil {
+		return help.ProbeFlag, err
+	}
+
+	fmt.Fprint(stdout, formatProbe(peer, result, time.Now()))
+	return help.ProbeFlag, nil
+}
+
+// Function formats the result of the probes of a peer. Without an answer
+// to the ICMP echo requests, a hint suggests -udp, as ICMP may be filtered
+// on the path.
+func formatProbe(p get.PeerInfo, result probe.Result, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n"+Bold+Yellow+"peer: "+Reset+Yellow+"%s"+Reset, p.PublicKey)
+	if p.Name != "" {
+		fmt.Fprintf(&b, " (%s)", p.Name)
+	}
+	b.WriteString("\n")
+
+	fmt.Fprintf(&b, Bold+"  endpoint: "+Reset+"%s\n", p.Endpoint)
+	fmt.Fprintf(&b, Bold+"  latest handshake: "+Reset+"%s\n", formatHandshake(p.LastHandshake, now))
+	fmt.Fprintf(&b, Bold+"  probes (%s): "+Reset+"%d sent, %d received, %.0f%% loss\n",
+		result.Method, result.Sent, result.Received, result.Loss())
+
+	if result.Received == 0 {
+		b.WriteString(Bold + "  rtt: " + Reset + Red + "no answer" + Reset + "\n")
+		if result.Method == probe.MethodICMP {
+			fmt.Fprintf(&b, "hint: ICMP may be filtered on the path, probe with '%s'\n", help.UDPFlag)
+		}
+		return b.String()
+	}
+
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 3, 64)
+	}
+	fmt.Fprintf(&b, Bold+"  rtt min/avg/max: "+Reset+"%s/%s/%s "+Cyan+"ms"+Reset+"\n",
+		ms(result.Min), ms(result.Avg), ms(result.Max))
+	return b.String()
+}
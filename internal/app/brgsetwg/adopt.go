@@ -0,0 +1,123 @@
+//go:build !windows
+
+package brgsetwg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/validate"
+)
+
+// AdoptCommand encapsulates the data and logic for adopting a network
+// interface created by another tool (e.g., wg-quick), see set.Adopt.
+type AdoptCommand struct {
+	opLock
+
+	Iface string
+	Dry   bool
+}
+
+// Method parses the command-line arguments for the adopt command.
+// Expected format: `[interface_name] -adopt [-dry]`.
+func (p *AdoptCommand) ParseArgs(args []string) (string, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return help.AdoptFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	p.Iface = args[0]
+	if err := validate.CheckInterfaceName(p.Iface); err != nil {
+		return help.WgInterfaceFlag, err
+	}
+
+	if len(args) == 3 {
+		if args[2] != help.DryFlag {
+			return args[2], errors.New(help.DefaultErrorMessage)
+		}
+		p.Dry = true
+	}
+
+	return help.AdoptFlag, nil
+}
+
+// Method reports whether the command only prints the plan (-dry).
+func (p *AdoptCommand) ReadOnly() bool {
+	return p.Dry
+}
+
+// Method returns the changed network interface.
+func (p *AdoptCommand) ChangedInterface() string {
+	return p.Iface
+}
+
+// Method adopts the interface, see adoptInterface.
+func (p *AdoptCommand) Execute() ([]Result, error) {
+	return adoptInterface(p.Iface, p.Dry, os.Stdout, p.lock)
+}
+
+// Function adopts the running network interface: its attributed iptables
+// rules are tagged in place and its addresses, rules and backend recorded
+// in its metadata, so a purge or a reconcile manages it like an interface
+// created by brgsetwg. The peers stay in the device, a foreign interface
+// has no peer metadata (names, notes, expiry) to record.
+//
+// The rules not in the form of a rule of this tool are only listed, for
+// the administrator to confirm, see set.PlanAdoption. With dry set, the
+// plan is only printed, and reported as skipped.
+func adoptInterface(iface string, dry bool, out io.Writer, held ...*oplock.Lock) ([]Result, error) {
+	exists, err := interfaceExists(iface)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, &get.InterfaceNotFoundError{Name: iface}
+	}
+
+	plan, err := set.PlanAdoption(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(out, "network interface %s: %s, %s, %s\n",
+		iface, plan.Backend, plural(plan.Peers, "peer"), plural(len(plan.Addresses), "address"))
+
+	var results []Result
+	for _, rule := range plan.Managed {
+		fmt.Fprintf(out, "already managed %s %s rule '%s'\n", rule.Table, rule.Chain, rule.Spec)
+	}
+	for _, rule := range plan.Foreign {
+		fmt.Fprintf(out, "not adopted %s, confirm it by hand: tag it with the comment '%s' to have it managed\n",
+			rule, firewall.RuleTag(iface))
+		results = append(results, skipped("rule-adopt", iface, rule.String()+", needs manual confirmation"))
+	}
+
+	if dry {
+		for _, rule := range plan.Rules {
+			fmt.Fprintf(out, "would tag %s\n", rule)
+			results = append(results, skipped("rule-adopt", iface, rule.String()+" (dry run)"))
+		}
+		fmt.Fprintf(out, "adopt %s: %s to tag (dry run)\n", iface, plural(len(plan.Rules), "rule"))
+		return append(results, skipped("interface-adopt", iface, plan.Backend+" (dry run)")), nil
+	}
+
+	if err := set.Adopt(plan, held...); err != nil {
+		return results, err
+	}
+
+	for _, rule := range plan.Rules {
+		fmt.Fprintf(out, "tagged %s\n", rule)
+		results = append(results, applied("rule-adopt", iface, rule.String()))
+	}
+	fmt.Fprintf(out, "adopt %s: %s tagged, %s left to confirm\n",
+		iface, plural(len(plan.Rules), "rule"), plural(len(plan.Foreign), "rule"))
+
+	// The last change of the interface is the adoption, see recordChange.
+	return append([]Result{applied("interface-adopt", iface, plan.Backend)}, results...), nil
+}
@@ -0,0 +1,76 @@
+//go:build !windows
+
+package brgsetwg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/backup"
+)
+
+// RestoreBackupCommand encapsulates the data and logic for restoring the
+// network interfaces of a backup file written by `brggetwg -backup`.
+type RestoreBackupCommand struct {
+	opLock
+
+	Path    string
+	KeyFile string
+	Force   bool
+}
+
+// Method parses the command-line arguments for the restore command.
+// Expected format: `[file] [-key-file path] [-force]`. Without -key-file
+// the passphrase is asked on the terminal.
+func (p *RestoreBackupCommand) ParseArgs(args []string) (string, error) {
+	if len(args) < 1 {
+		return help.RestoreBackupFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	p.Path = args[0]
+	for i := 1; i < len(args); i++ {
+		switch {
+		case args[i] == help.KeyFileFlag && i+1 < len(args) && p.KeyFile == "":
+			p.KeyFile = args[i+1]
+			i++
+		case args[i] == help.ForceFlag && !p.Force:
+			p.Force = true
+		default:
+			return args[i], errors.New(help.DefaultErrorMessage)
+		}
+	}
+
+	return help.RestoreBackupFlag, nil
+}
+
+// Method decrypts the backup file and recreates its network interfaces
+// (see backup.Restore). An existing interface with another key is only
+// overwritten with -force.
+func (p *RestoreBackupCommand) Execute() ([]Result, error) {
+	passphrase, err := handlers.ReadPassphrase(p.KeyFile, handlers.Stdin, os.Stderr, false)
+	if err != nil {
+		return nil, err
+	}
+	defer passphrase.Zero()
+
+	bundle, err := backup.Read(p.Path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := backup.Restore(bundle, p.Force, os.Stdout, p.lock); err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	for _, iface := range bundle.Interfaces {
+		results = append(results, applied(
+			"restore-backup", iface.Name,
+			fmt.Sprintf("%s, %d peers", iface.Type, len(iface.Peers)),
+		))
+	}
+	return results, nil
+}
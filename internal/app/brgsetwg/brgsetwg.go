@@ -0,0 +1,3233 @@
+//go:build !windows
+
+/*
+The brgsetwg utility is designed to install and update the server's network rules.
+
+Capabilities:
+- Configure IP settings for network interfaces (IP addresses, subnet masks, etc.).
+- Add or remove WireGuard peer configurations.
+- Add or remove NAT and firewall rules (e.g., iptables rules).
+- Save the brgnetuse firewall rules to a file and restore them at boot.
+- Enable or disable IPv4 and IPv6 forwarding.
+- Set or remove the DNS servers of network interfaces (systemd-resolved, resolvconf).
+- Reconcile the system with a desired state file (interfaces, addresses, peers, NAT, forwarding).
+- Validate state files, peer lists and wg-quick configurations offline (e.g., in CI).
+- Restore the interfaces of an encrypted backup written by brggetwg.
+- Modify or delete Base64-encoded private and public keys for WireGuard configurations and peers.
+*/
+
+package brgsetwg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/internal/uapi"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/validate"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Main runs the utility with the process arguments.
+func Main() {
+	// The global -y flag may be given at any position.
+	os.Args, assumeYes = stripYesFlag(os.Args)
+
+	// So may the global -netns flag.
+	args, err := help.ApplyNetnsFlag(os.Args)
+	if err != nil {
+		help.ErrorExit(help.NetnsFlag, err)
+		os.Exit(help.ExitSetupFailed)
+	}
+	os.Args = args
+
+	if len(os.Args) < 2 || os.Args[1] == help.HelpFlag {
+		help.BridgeSetWgHelp()
+		return
+	}
+
+	if help.IsVersionFlag(os.Args[1]) {
+		help.PrintVersion("brgsetwg")
+		return
+	}
+
+	args, err = ParseWithEnv(os.Args, help.Environ())
+	if err != nil {
+		help.ErrorExit(help.LogTypeFlag, help.WithDefaultCode(help.CodeInvalidValue, err))
+		os.Exit(help.ExitSetupFailed)
+	}
+	os.Args = args
+
+	// With -js, the results are printed as JSON on stdout and the human
+	// readable output goes to stderr.
+	var resultOut io.Writer
+	if os.Args[1] == help.LogTypeFlag {
+		resultOut = os.Stdout
+		os.Stdout = os.Stderr
+		noteOut = os.Stderr
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		if len(os.Args) < 2 {
+			help.ErrorExitMessage(help.LogTypeFlag, help.DefaultErrorMessage)
+			os.Exit(help.ExitSetupFailed)
+		}
+	}
+
+	lenghtArgs := len(os.Args) - 1
+
+	// Function reports the error, as a failed result with -js, and exits.
+	fail := func(curArgs string, err error) {
+		if resultOut != nil {
+			writeResults(resultOut, []Result{failed(os.Args[1:], err)})
+		}
+		help.ErrorExit(curArgs, err)
+		os.Exit(help.ExitSetupFailed)
+	}
+
+	// A network interface command on a group of interfaces (`-g prefix` or
+	// `-i pattern`) runs on each of them and reports a table.
+	if isGroupArgs(os.Args) {
+		if err := handlers.CheckPrivileged(); err != nil {
+			fail(os.Args[1], err)
+		}
+
+		results, failures, curArgs, err := runGroup(os.Args[1:], os.Stdout)
+		if err != nil {
+			fail(curArgs, err)
+		}
+		if resultOut != nil {
+			writeResults(resultOut, append(results, warnings...))
+		}
+		if failures > 0 {
+			os.Exit(help.ExitSetupFailed)
+		}
+		return
+	}
+
+	flag, data := dispatch(os.Args)
+
+	obj, ok := СommandMap[flag]
+	if !ok {
+		fail(os.Args[lenghtArgs], errors.New(help.DefaultErrorMessage))
+	}
+
+	cmd := obj()
+
+	// The errors of the parser report the values it checks, unless they
+	// carry a code of their own (e.g., DefaultErrorMessage).
+	curArgs, err := cmd.ParseArgs(data)
+	if err != nil {
+		fail(curArgs, help.WithDefaultCode(help.CodeInvalidValue, err))
+	}
+
+	if err := requireRoot(cmd); err != nil {
+		fail(curArgs, err)
+	}
+
+	if err := confirm(cmd); err != nil {
+		fail(curArgs, err)
+	}
+
+	results, err := execute(cmd)
+	results = append(results, warnings...)
+	if err != nil {
+		if resultOut != nil {
+			results = append(results, failed(os.Args[1:], err))
+			writeResults(resultOut, results)
+		}
+		help.ErrorExit(curArgs, err)
+		os.Exit(help.ExitSetupFailed)
+	}
+
+	if resultOut != nil {
+		writeResults(resultOut, results)
+	}
+}
+
+// Function returns the key of the command in СommandMap and the arguments
+// given to its ParseArgs, from the command line (os.Args).
+func dispatch(args []string) (string, []string) {
+	lenghtArgs := len(args) - 1
+	flag := args[1]
+
+	var data []string
+
+	if args[1] == help.ReconcileFlag || args[1] == help.ValidateFlag ||
+		args[1] == help.RestoreBackupFlag {
+		// The file is followed by optional flags.
+		data = args[2:]
+	} else if args[1] == help.FirewallFlag && lenghtArgs >= 2 &&
+		(args[2] == help.SaveFlag || args[2] == help.RestoreFlag) {
+		// The path and the flags are optional.
+		flag = args[1] + args[2]
+		data = args[2:]
+	} else if (args[1] == help.ForwIpv4Flag || args[1] == help.ForwIpv6Flag) &&
+		lenghtArgs == 3 {
+		// The forwarding state is followed by -strict.
+		flag = args[1] + args[2]
+		data = args[1:]
+	} else if lenghtArgs >= 3 {
+		flag = args[1] + args[3]
+		data = args[2:]
+	} else if lenghtArgs == 2 {
+		flag = args[1] + args[2]
+		data = args[1:]
+	}
+
+	return flag, data
+}
+
+// Enables standard output for shell commands.
+const ShellStd bool = true
+
+// Sub-flags of the network interface commands, preceded by `-i [name]`.
+var interfaceSubFlags = []string{
+	help.DelFlag, help.EnableWgInterfaceFlag, help.DisableWgInterfaceFlag,
+	help.RenameFlag, help.AliasFlag, help.UpdateFlag, help.PeerFlag,
+	help.PruneFlag, help.PurgeFlag, help.DNSFlag, help.IpAddressFlag,
+	help.RefreshEndpointsFlag, help.ToNetnsFlag, help.EnforceQuotasFlag, help.AdoptFlag,
+	help.ProvisionFlag,
+}
+
+// Function applies the environment defaults to the arguments: -js with
+// BRGNETUSE_JSON, and `-i [name]` with BRGNETUSE_INTERFACE for a network
+// interface command given without -i (e.g., `brgsetwg -u -p 51820`).
+// Flags given on the command line win. It returns the arguments to run.
+func ParseWithEnv(args []string, env map[string]string) ([]string, error) {
+	if len(args) < 2 {
+		return args, nil
+	}
+
+	jsonOut, err := help.EnvBool(env, help.Env_Default_JSON)
+	if err != nil {
+		return args, err
+	}
+
+	prefix, rest := args[:1], args[1:]
+	if rest[0] == help.LogTypeFlag {
+		prefix, rest = args[:2], args[2:]
+	} else if jsonOut {
+		prefix = []string{args[0], help.LogTypeFlag}
+	}
+
+	return slices.Concat(prefix, help.EnvInterfaceArgs(rest, env, interfaceSubFlags...)), nil
+}
+
+// Main command management interface. Execute returns the results of the
+// changes made, see Result, and those made before an error.
+type Command interface {
+	ParseArgs(args []string) (string, error)
+	Execute() ([]Result, error)
+}
+
+// Optional interface of the commands which may only read the system state.
+type readOnlyCommand interface {
+	ReadOnly() bool
+}
+
+// Function fails a command changing the system before it starts, unless
+// the process runs as root or holds CAP_NET_ADMIN. Read-only commands run
+// unprivileged.
+func requireRoot(cmd Command) error {
+	if ro, ok := cmd.(readOnlyCommand); ok && ro.ReadOnly() {
+		return nil
+	}
+	return handlers.CheckPrivileged()
+}
+
+// Function runs the command under the operation lock (see oplock), so
+// concurrent invocations never interleave their changes. Read-only
+// commands run without it.
+func execute(cmd Command) ([]Result, error) {
+	return executeLocked(cmd, oplock.Do)
+}
+
+// Function runs the command under the lock taken by lock (e.g.,
+// oplock.Do), see execute. The lock is handed to the command (see
+// lockedCommand), so it is taken once.
+func executeLocked(cmd Command, lock func(fn func(lock *oplock.Lock) error) error) ([]Result, error) {
+	if ro, ok := cmd.(readOnlyCommand); ok && ro.ReadOnly() {
+		return cmd.Execute()
+	}
+
+	var results []Result
+	err := lock(func(held *oplock.Lock) error {
+		if lc, ok := cmd.(lockedCommand); ok {
+			lc.hold(held)
+		}
+
+		var err error
+		results, err = cmd.Execute()
+		recordChange(cmd, results)
+		return err
+	})
+	return results, err
+}
+
+// Optional interface of the commands calling the functions which take the
+// operation lock on their own (e.g., of the set package): they are handed
+// the lock of the command, to pass on to these functions.
+type lockedCommand interface {
+	hold(lock *oplock.Lock)
+}
+
+// Lock of a command, see lockedCommand. It is nil for a command run without
+// executeLocked (e.g., in tests): the functions then take the lock on their
+// own.
+type opLock struct {
+	lock *oplock.Lock
+}
+
+// Method keeps the lock of the command.
+func (o *opLock) hold(lock *oplock.Lock) {
+	o.lock = lock
+}
+
+// Optional interface of the commands which change the configuration of a
+// single network interface. ChangedInterface returns its name, empty when
+// no change is recorded (e.g., the interface is deleted).
+type interfaceCommand interface {
+	ChangedInterface() string
+}
+
+// Function records the first applied result of the command as the last
+// change of its network interface (see peermeta.RecordChange), also when
+// the command failed past it. A failure to record is only a warning, the
+// change itself is made.
+func recordChange(cmd Command, results []Result) {
+	ic, ok := cmd.(interfaceCommand)
+	if !ok || ic.ChangedInterface() == "" {
+		return
+	}
+
+	for _, result := range results {
+		if result.Status != StatusApplied {
+			continue
+		}
+		if err := peermeta.RecordChange(ic.ChangedInterface(), result.Action); err != nil {
+			warn(fmt.Sprintf(
+				"failed to record the change of network interface '%s': %s",
+				ic.ChangedInterface(), strings.TrimPrefix(err.Error(), "error: "),
+			))
+		}
+		return
+	}
+}
+
+type CommandRegistry map[string]func() Command
+
+var СommandMap = CommandRegistry{
+	// Flag: [-i].
+	help.WgInterfaceFlag + help.DelFlag:                func() Command { return &InterfaceCommand{} },
+	help.WgInterfaceFlag + help.DisableWgInterfaceFlag: func() Command { return &InterfaceCommand{} },
+	help.WgInterfaceFlag + help.EnableWgInterfaceFlag:  func() Command { return &InterfaceCommand{} },
+
+	// Flag: [-i -rn].
+	help.WgInterfaceFlag + help.RenameFlag: func() Command { return &RenameInterfaceCommand{} },
+
+	// Flag: [-i -alias].
+	help.WgInterfaceFlag + help.AliasFlag: func() Command { return &AliasInterfaceCommand{} },
+
+	// Flag: [-i -to-netns].
+	help.WgInterfaceFlag + help.ToNetnsFlag: func() Command { return &ToNetnsCommand{} },
+
+	// Flag: [-i -u].
+	help.WgInterfaceFlag + help.UpdateFlag: func() Command { return &UpdateInterfaceCommand{} },
+
+	// Flag: [-i -pr].
+	help.WgInterfaceFlag + help.PeerFlag: func() Command { return &PeerCommand{} },
+
+	// Flag: [-i -prune].
+	help.WgInterfaceFlag + help.PruneFlag: func() Command { return &PruneCommand{} },
+
+	// Flag: [-i -enforce-quotas].
+	help.WgInterfaceFlag + help.EnforceQuotasFlag: func() Command { return &EnforceQuotasCommand{} },
+
+	// Flag: [-i -refresh-endpoints].
+	help.WgInterfaceFlag + help.RefreshEndpointsFlag: func() Command { return &RefreshEndpointsCommand{} },
+
+	// Flag: [-i -purge].
+	help.WgInterfaceFlag + help.PurgeFlag: func() Command { return &PurgeCommand{} },
+
+	// Flag: [-i -adopt].
+	help.WgInterfaceFlag + help.AdoptFlag: func() Command { return &AdoptCommand{} },
+
+	// Flag: [-i -provision].
+	help.WgInterfaceFlag + help.ProvisionFlag: func() Command { return &ProvisionCommand{} },
+
+	// Flag: [-i -dns].
+	help.WgInterfaceFlag + help.DNSFlag: func() Command { return &DnsCommand{} },
+
+	// Flag: [-i -ip].
+	help.WgInterfaceFlag + help.IpAddressFlag: func() Command { return &IpIntertfaceCommand{} },
+
+	// Flag: [-i -hairpin].
+	help.WgInterfaceFlag + help.HairpinFlag: func() Command { return &HairpinCommand{} },
+
+	// Flag: [-fw4 -a|-d ].
+	help.ForwIpv4Flag + help.AddFlag: func() Command { return &IpForwardingCommand{} },
+	help.ForwIpv4Flag + help.DelFlag: func() Command { return &IpForwardingCommand{} },
+
+	// Flag: [-fw6 -a|-d ].
+	help.ForwIpv6Flag + help.AddFlag: func() Command { return &IpForwardingCommand{} },
+	help.ForwIpv6Flag + help.DelFlag: func() Command { return &IpForwardingCommand{} },
+
+	// Flag: [-reconcile].
+	help.ReconcileFlag: func() Command { return &ReconcileCommand{} },
+
+	// Flag: [-validate].
+	help.ValidateFlag: func() Command { return &ValidateCommand{} },
+
+	// Flag: [-restore-backup].
+	help.RestoreBackupFlag: func() Command { return &RestoreBackupCommand{} },
+
+	// Flag: [-fr -u -a|-d].
+	help.FirewallFlag + help.AddFlag: func() Command { return &FirewallPortCommand{} },
+	help.FirewallFlag + help.DelFlag: func() Command { return &FirewallPortCommand{} },
+
+	// Flag: [-fr -save|-restore].
+	help.FirewallFlag + help.SaveFlag:    func() Command { return &PersistCommand{} },
+	help.FirewallFlag + help.RestoreFlag: func() Command { return &PersistCommand{} },
+}
+
+// InterfaceCommand encapsulates the 'interface' command's data and logic.
+// It holds the interface's name and the action to perform on it.
+type InterfaceCommand struct {
+	Iface  string
+	Action string
+	Strict bool
+	Sure   bool // Delete or bring down the interface carrying the SSH session.
+}
+
+// Method parses the command-line arguments for the interface command.
+// Expected format: `[interface_name] [-up | -dw [-yes-i-am-sure] |
+// -d [-strict] [-yes-i-am-sure]]`.
+func (p *InterfaceCommand) ParseArgs(args []string) (string, error) {
+
+	if err := validate.CheckInterfaceName(args[0]); err != nil {
+		return args[1], err
+	}
+
+	p.Iface = args[0]
+	p.Action = args[1]
+
+	for _, arg := range args[2:] {
+		switch {
+		case arg == help.StrictFlag && p.Action == help.DelFlag && !p.Strict:
+			p.Strict = true
+		case arg == help.YesIAmSureFlag && p.Action != help.EnableWgInterfaceFlag && !p.Sure:
+			p.Sure = true
+		default:
+			return arg, errors.New(help.DefaultErrorMessage)
+		}
+	}
+
+	return help.WgInterfaceFlag, nil
+}
+
+// Method brings the interface up or down, or deletes it. The current state
+// is checked first: a transition to the state the interface is already in
+// only prints a note, and a missing interface is reported with
+// get.InterfaceNotFoundError. Deleting a missing interface succeeds with a
+// note, unless -strict is given.
+func (p *InterfaceCommand) Execute() ([]Result, error) {
+	action := map[string]string{
+		help.EnableWgInterfaceFlag:  "interface-up",
+		help.DisableWgInterfaceFlag: "interface-down",
+		help.DelFlag:                "interface-delete",
+	}[p.Action]
+
+	exist, err := interfaceExists(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exist {
+		if p.Action == help.DelFlag && !p.Strict {
+			fmt.Fprintf(noteOut, "network interface '%s' does not exist, nothing to delete\n", p.Iface)
+			return []Result{skipped(action, p.Iface, "does not exist")}, nil
+		}
+
+		devices, err := get.GetWireGuardDevices()
+		if err != nil {
+			return nil, err
+		}
+		return nil, &get.InterfaceNotFoundError{Name: p.Iface, Devices: devices}
+	}
+
+	if p.Action == help.DelFlag {
+		if err := p.checkSession(); err != nil {
+			return nil, err
+		}
+		if err := shell.DefaultRunner.Run(shell.FormatCmdIpLinkDelete(p.Iface), ShellStd); err != nil {
+			return nil, err
+		}
+		return []Result{applied(action, p.Iface, "")}, nil
+	}
+
+	show, err := get.GetIpShow(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	up := len(show) > 0 && slices.Contains(show[0].Flags, "UP")
+
+	state := shell.IpUp
+	if p.Action == help.DisableWgInterfaceFlag {
+		state = shell.IpDown
+	}
+	if up == (state == shell.IpUp) {
+		fmt.Fprintf(noteOut, "network interface '%s' is already %s\n", p.Iface, state)
+		return []Result{skipped(action, p.Iface, fmt.Sprintf("already %s", state))}, nil
+	}
+
+	if state == shell.IpDown {
+		if err := p.checkSession(); err != nil {
+			return nil, err
+		}
+	}
+	if err := shell.DefaultRunner.Run(shell.FormatCmdIpLinkSet(p.Iface, state), ShellStd); err != nil {
+		return nil, err
+	}
+	return []Result{applied(action, p.Iface, "")}, nil
+}
+
+// Method returns the changed network interface, none when it is deleted.
+func (p *InterfaceCommand) ChangedInterface() string {
+	if p.Action == help.DelFlag {
+		return ""
+	}
+	return p.Iface
+}
+
+// Method refuses to delete or bring down the interface carrying the SSH
+// session of the caller, which would cut it off, unless -yes-i-am-sure is
+// given; the change then goes on with a warning. A session which cannot be
+// checked is not refused.
+func (p *InterfaceCommand) checkSession() error {
+	env := sessionEnv()
+	if _, ok := validate.SSHClientAddr(env); !ok {
+		return nil
+	}
+
+	networks, err := interfaceNetworks(p.Iface)
+	if err != nil {
+		return nil
+	}
+	client, routed := validate.SSHSessionRouted(env, networks)
+	if !routed {
+		return nil
+	}
+
+	if !p.Sure {
+		verb := "bring down"
+		if p.Action == help.DelFlag {
+			verb = "delete"
+		}
+		return help.Errorf(
+			help.CodeConflict,
+			"error: the SSH session from %s is carried by network interface '%s', "+
+				"pass '%s' to %s it anyway",
+			client, p.Iface, help.YesIAmSureFlag, verb,
+		)
+	}
+	warn(fmt.Sprintf("the SSH session from %s is carried by network interface '%s' and may drop", client, p.Iface))
+	return nil
+}
+
+// Function returns the networks reached through the network interface:
+// the allowed IPs of its peers and the prefixes of its addresses.
+func networksOf(iface string) ([]netip.Prefix, error) {
+	device, err := deviceOf(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	var networks []netip.Prefix
+	for _, peer := range device.Peers {
+		for _, allowed := range peer.AllowedIPs {
+			if prefix, err := netip.ParsePrefix(allowed); err == nil {
+				networks = append(networks, prefix.Masked())
+			}
+		}
+	}
+
+	show, err := get.GetIpShow(iface)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range show {
+		for _, info := range link.AddrInfo {
+			addr, err := netip.ParseAddr(info.Local)
+			if err != nil {
+				continue
+			}
+			if prefix, err := addr.Prefix(info.Prefixlen); err == nil {
+				networks = append(networks, prefix)
+			}
+		}
+	}
+	return networks, nil
+}
+
+// Function returns the device of the WireGuard or AmneziaWG network
+// interface with its peers.
+func deviceOf(iface string) (get.DeviceInfo, error) {
+	backend, err := interfaceBackend(iface)
+	if err != nil {
+		return get.DeviceInfo{}, err
+	}
+
+	if backend.AmneziaWG() {
+		return get.GetAwgPeerInfo(iface)
+	}
+
+	info, err := get.GetPeerInfo(iface)
+	if err != nil || len(info) == 0 {
+		return get.DeviceInfo{}, err
+	}
+	return info[0], nil
+}
+
+// Lookups used by the commands, replaced in tests.
+var (
+	interfaceExists   = get.GetExistInterface
+	interfaceBackend  = get.GetInterfaceBackend
+	interfaceNetworks = networksOf
+	interfaceDevice   = deviceOf
+	interfaceFamilies = familiesOf
+)
+
+// Environment of the SSH session of the caller, replaced in tests.
+var sessionEnv = help.Environ
+
+// Lookup of the UAPI socket of an AmneziaWG interface served by brgaddawg,
+// replaced in tests.
+var awgSocket = func(iface string) (string, bool) {
+	return uapi.Owned(handlers.AwgSocketDir, iface)
+}
+
+// Outputs of the notes and warnings of the commands, replaced in tests.
+var (
+	noteOut io.Writer = os.Stdout
+	warnOut io.Writer = os.Stderr
+)
+
+// Escape sequences of the warnings printed by warn.
+const (
+	yellow = "\x1b[33m"
+	reset  = "\x1b[0m"
+)
+
+// Function prints a warning which does not fail the command as a yellow
+// notice.
+func warn(message string) {
+	fmt.Fprintf(warnOut, yellow+"warning: %s"+reset+"\n", message)
+}
+
+// Warnings of the set package reported while the command ran, added to
+// its results by Main.
+var warnings []Result
+
+// Function prints a warning of the set package like warn, with its code,
+// and keeps it as a result of the command (see StatusWarning).
+func reportWarning(w set.Warning) {
+	fmt.Fprintf(warnOut, yellow+"warning: %s [%s]"+reset+"\n", w.Message, w.Code)
+	warnings = append(warnings, Result{
+		Action: "warning",
+		Target: w.Interface,
+		Status: StatusWarning,
+		Detail: w.Message,
+		Code:   w.Code,
+	})
+}
+
+// Unique local IPv6 addresses (RFC 4193).
+var ula = netip.MustParsePrefix("fc00::/7")
+
+// RenameInterfaceCommand encapsulates the data and logic for renaming
+// a network interface.
+type RenameInterfaceCommand struct {
+	Iface   string
+	NewName string
+}
+
+// Method parses the command-line arguments for the rename command.
+// Expected format: `[interface_name] -rn [new_name]`.
+func (p *RenameInterfaceCommand) ParseArgs(args []string) (string, error) {
+	if len(args) != 3 {
+		return help.RenameFlag, help.Errorf(
+			help.CodeInvalidArguments,
+			"error: invalid command arguments, please specify the new interface name",
+		)
+	}
+
+	p.Iface = args[0]
+	p.NewName = args[2]
+
+	if err := validate.CheckInterfaceName(p.Iface); err != nil {
+		return help.WgInterfaceFlag, err
+	}
+
+	if err := validate.CheckInterfaceName(p.NewName); err != nil {
+		return help.RenameFlag, err
+	}
+
+	if p.Iface == p.NewName {
+		return help.RenameFlag, fmt.Errorf(
+			"error: network interface is already named '%s'", p.NewName,
+		)
+	}
+
+	return help.RenameFlag, nil
+}
+
+// Method returns the changed network interface, by its new name.
+func (p *RenameInterfaceCommand) ChangedInterface() string {
+	return p.NewName
+}
+
+// Method renames the interface: the link is set down (if it was up),
+// renamed and brought back to its previous state, then any address lost
+// on the way is re-added from the snapshot taken before the change. The
+// metadata of the interface (peers, quotas, DNS servers, last change)
+// follows the new name. If a step fails, the previous name, state and
+// metadata are restored.
+//
+// Userspace devices (brgaddwg, brgaddawg) are refused: their UAPI socket
+// and process tag are bound to the name the device was created with. So
+// is an interface matched by name by iptables rules (e.g., the FORWARD
+// and MASQUERADE rules of brgnetuse), which would stop matching it.
+func (p *RenameInterfaceCommand) Execute() ([]Result, error) {
+	exist, err := interfaceExists(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, &get.InterfaceNotFoundError{Name: p.Iface}
+	}
+
+	exist, err = interfaceExists(p.NewName)
+	if err != nil {
+		return nil, err
+	}
+	if exist {
+		return nil, help.Errorf(
+			help.CodeInterfaceExists,
+			"error: network interface name '%s' already exists", p.NewName,
+		)
+	}
+
+	backend, err := interfaceBackend(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	if backend.Userspace() {
+		return nil, help.Errorf(
+			help.CodeUnsupported,
+			"error: network interface '%s' is served by a %s "+
+				"process and cannot be renamed, its UAPI socket is bound "+
+				"to the current name, recreate the interface as '%s' instead",
+			p.Iface, backend, p.NewName,
+		)
+	}
+
+	rules, err := nameBoundRules(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) > 0 {
+		return nil, help.Errorf(
+			help.CodeConflict,
+			"error: network interface '%s' cannot be renamed, iptables rules "+
+				"match it by name: %s; remove them first, then add them again for '%s'",
+			p.Iface, strings.Join(rules, ", "), p.NewName,
+		)
+	}
+
+	for _, path := range []string{peermeta.Path(p.NewName), peermeta.InterfacePath(p.NewName), peermeta.ChangePath(p.NewName)} {
+		if _, err := os.Stat(path); err == nil {
+			return nil, help.Errorf(
+				help.CodeConflict,
+				"error: metadata '%s' of network interface '%s' already exists, "+
+					"remove it first with '%s'",
+				path, p.NewName, help.PurgeFlag,
+			)
+		}
+	}
+
+	snapshot, err := get.GetIpShow(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	up := slices.ContainsFunc(snapshot, func(iface get.IpInterfaceStructure) bool {
+		return slices.Contains(iface.Flags, "UP")
+	})
+
+	if err := peermeta.Rename(p.Iface, p.NewName); err != nil {
+		return nil, err
+	}
+
+	// Function brings the interface back to its previous name, state and
+	// metadata, after a failed step. The renamed link is named name.
+	rollback := func(name string, err error) ([]Result, error) {
+		if name != p.Iface {
+			shell.DefaultRunner.Run(shell.FormatCmdIpLinkSet(name, shell.IpDown), ShellStd)
+			shell.DefaultRunner.Run(shell.FormatCmdIpLinkRename(name, p.Iface), ShellStd)
+		}
+		if up {
+			shell.DefaultRunner.Run(shell.FormatCmdIpLinkSet(p.Iface, shell.IpUp), ShellStd)
+		}
+		if name != p.Iface {
+			restoreAddresses(p.Iface, snapshot)
+		}
+		peermeta.Rename(p.NewName, p.Iface)
+		return nil, err
+	}
+
+	if up {
+		if err := shell.DefaultRunner.Run(
+			shell.FormatCmdIpLinkSet(p.Iface, shell.IpDown), ShellStd); err != nil {
+			return rollback(p.Iface, err)
+		}
+	}
+
+	if err := shell.DefaultRunner.Run(
+		shell.FormatCmdIpLinkRename(p.Iface, p.NewName), ShellStd); err != nil {
+		return rollback(p.Iface, err)
+	}
+
+	if up {
+		if err := shell.DefaultRunner.Run(
+			shell.FormatCmdIpLinkSet(p.NewName, shell.IpUp), ShellStd); err != nil {
+			return rollback(p.NewName, err)
+		}
+	}
+
+	if err := restoreAddresses(p.NewName, snapshot); err != nil {
+		return rollback(p.NewName, err)
+	}
+
+	return []Result{applied("interface-rename", p.Iface, p.NewName)}, nil
+}
+
+// Function re-adds to the network interface the addresses of the snapshot
+// it lost, except the link-local ones regenerated by the kernel.
+func restoreAddresses(iface string, snapshot []get.IpInterfaceStructure) error {
+	current, err := get.GetIpShow(iface)
+	if err != nil {
+		return err
+	}
+
+	present := make(map[string]bool)
+	for _, link := range current {
+		for _, addr := range link.AddrInfo {
+			present[fmt.Sprintf("%s/%d", addr.Local, addr.Prefixlen)] = true
+		}
+	}
+
+	for _, link := range snapshot {
+		for _, addr := range link.AddrInfo {
+			if addr.Scope == "link" {
+				continue
+			}
+
+			cidr := fmt.Sprintf("%s/%d", addr.Local, addr.Prefixlen)
+			if present[cidr] {
+				continue
+			}
+
+			cmd := shell.FormatCmdIpAddrDev(iface, cidr, shell.IpAdd)
+			if err := shell.DefaultRunner.Run(cmd, ShellStd); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Function returns the descriptions of the iptables rules matching the
+// network interface by name: by their input or output interface, or by
+// the tag of the interface (see firewall.RuleTag).
+func nameBoundRules(iface string) ([]string, error) {
+	var rules []string
+
+	for _, list := range []struct {
+		table string
+		load  func() (get.IptablesOutput, error)
+	}{
+		{table: "nat", load: get.GetIptablesNAT},
+		{table: "filter", load: get.GetIptablesFirewall},
+	} {
+		output, err := list.load()
+		if err != nil {
+			return nil, err
+		}
+		for _, chain := range output.Chains {
+			for _, rule := range chain.Rules {
+				owner, _ := rule.Owner()
+				if rule.In == iface || rule.Out == iface || owner == iface {
+					rules = append(rules, iptablesAction(list.table, chain.Name, rule).Desc)
+				}
+			}
+		}
+	}
+	return rules, nil
+}
+
+// AliasInterfaceCommand encapsulates the data and logic for setting the
+// alias (description) of a network interface.
+type AliasInterfaceCommand struct {
+	opLock
+
+	Iface string
+	Alias string
+}
+
+// Method parses the command-line arguments for the alias command.
+// Expected format: `[interface_name] -alias [text]`, an empty text clears
+// the alias.
+func (p *AliasInterfaceCommand) ParseArgs(args []string) (string, error) {
+	if len(args) != 3 {
+		return help.AliasFlag, help.Errorf(
+			help.CodeInvalidArguments,
+			"error: invalid command arguments, please specify the alias, or '' to clear it",
+		)
+	}
+
+	p.Iface = args[0]
+	if err := validate.CheckInterfaceName(p.Iface); err != nil {
+		return help.WgInterfaceFlag, err
+	}
+
+	alias, err := validate.CheckAlias(args[2])
+	if err != nil {
+		return help.AliasFlag, err
+	}
+	p.Alias = alias
+
+	return help.AliasFlag, nil
+}
+
+// Method returns the changed network interface.
+func (p *AliasInterfaceCommand) ChangedInterface() string {
+	return p.Iface
+}
+
+// Method sets the alias of the network interface, see set.SetInterfaceAlias.
+func (p *AliasInterfaceCommand) Execute() ([]Result, error) {
+	exist, err := interfaceExists(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, &get.InterfaceNotFoundError{Name: p.Iface}
+	}
+
+	if err := set.SetInterfaceAlias(p.Iface, p.Alias, p.lock); err != nil {
+		return nil, err
+	}
+	return []Result{applied("interface-alias", p.Iface, p.Alias)}, nil
+}
+
+// ToNetnsCommand encapsulates the data and logic for moving a network
+// interface into another network namespace.
+type ToNetnsCommand struct {
+	opLock
+
+	Iface  string
+	Target string
+}
+
+// Method parses the command-line arguments for the move command.
+// Expected format: `[interface_name] -to-netns [name|pid]`.
+func (p *ToNetnsCommand) ParseArgs(args []string) (string, error) {
+	if len(args) != 3 {
+		return help.ToNetnsFlag, help.Errorf(
+			help.CodeInvalidArguments,
+			"error: invalid command arguments, please specify the network namespace name or process ID",
+		)
+	}
+
+	p.Iface = args[0]
+	p.Target = args[2]
+
+	if err := validate.CheckInterfaceName(p.Iface); err != nil {
+		return help.WgInterfaceFlag, err
+	}
+	if err := netns.CheckTarget(p.Target); err != nil {
+		return help.ToNetnsFlag, err
+	}
+
+	return help.ToNetnsFlag, nil
+}
+
+// Method moves the network interface into the network namespace, see
+// set.MoveInterface. The change is not recorded: the metadata of the
+// interface left the current namespace with it.
+//
+// Userspace devices (brgaddwg, brgaddawg) are refused: a supervised
+// device is recreated in the namespace of its process, they are moved at
+// creation with -to-netns instead.
+func (p *ToNetnsCommand) Execute() ([]Result, error) {
+	exist, err := interfaceExists(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, &get.InterfaceNotFoundError{Name: p.Iface}
+	}
+
+	backend, err := interfaceBackend(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	if backend.Userspace() {
+		return nil, help.Errorf(
+			help.CodeUnsupported,
+			"error: network interface '%s' is served by a %s process and cannot be moved, "+
+				"recreate it with '%s %s'",
+			p.Iface, backend, help.ToNetnsFlag, p.Target,
+		)
+	}
+
+	if err := set.MoveInterface(p.Iface, p.Target, p.lock); err != nil {
+		return nil, err
+	}
+	return []Result{applied("interface-netns", p.Iface, p.Target)}, nil
+}
+
+// UpdateInterface holds parameters for updating a network or system interface.
+type UpdateInterfaceCommand struct {
+	opLock
+
+	Iface      string
+	Value      string
+	FwMark     string
+	Force      bool
+	Strict     bool // Fail if the port or the key is already set.
+	PrivateKey handlers.Secret
+	Rotate     bool
+	OutPath    string
+	FlagCmd    string
+	PortRule   bool // Open the listen port in the INPUT chain (-fr).
+}
+
+// Method to parse arguments for updating the interface. The private key
+// `-` is read from stdin, see handlers.ReadKey.
+func (p *UpdateInterfaceCommand) ParseArgs(args []string) (string, error) {
+
+	if len(args) < 3 {
+		return help.UpdateFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	p.Iface = args[0]
+
+	for indx := 2; indx < len(args); indx++ {
+		switch args[indx] {
+		case help.PrivateKeyFlag:
+			p.FlagCmd = help.PrivateKeyFlag
+
+			indx++
+			if indx >= len(args) {
+				break
+			}
+			if args[indx] == help.StrictFlag {
+				// No key given, one is generated.
+				indx--
+				break
+			}
+			if args[indx] == help.StdinValue {
+				key, err := handlers.ReadKey(handlers.Stdin)
+				if err != nil {
+					return help.PrivateKeyFlag, err
+				}
+				p.PrivateKey = key
+				break
+			}
+			if args[indx] != help.RotateFlag {
+				p.PrivateKey = handlers.NewSecret(args[indx])
+				break
+			}
+			p.Rotate = true
+
+			indx++
+			if indx < len(args) {
+				if args[indx] != help.OutFlag || indx+1 >= len(args) {
+					return help.RotateFlag, errors.New(help.DefaultErrorMessage)
+				}
+				indx++
+				p.OutPath = args[indx]
+			}
+
+		case help.PortFlag:
+			indx++
+			if indx < len(args) {
+				p.FlagCmd = help.PortFlag
+				p.Value = args[indx]
+			} else {
+				return help.PortFlag, errors.New(help.DefaultErrorMessage)
+			}
+
+		case help.FwMarkFlag:
+			indx++
+			if indx >= len(args) || p.FwMark != "" {
+				return help.FwMarkFlag, errors.New(help.DefaultErrorMessage)
+			}
+			mark, err := strconv.ParseUint(args[indx], 0, 32)
+			if err != nil {
+				return help.FwMarkFlag, fmt.Errorf(
+					"error: invalid firewall mark '%s', expected a number in range 0-4294967295",
+					args[indx],
+				)
+			}
+			p.FwMark = strconv.FormatUint(mark, 10)
+			if p.FlagCmd == "" {
+				p.FlagCmd = help.FwMarkFlag
+			}
+
+		case help.ForceFlag:
+			if p.Force {
+				return help.ForceFlag, errors.New(help.DefaultErrorMessage)
+			}
+			p.Force = true
+
+		case help.StrictFlag:
+			if p.Strict {
+				return help.StrictFlag, errors.New(help.DefaultErrorMessage)
+			}
+			p.Strict = true
+
+		case help.FirewallFlag:
+			if p.PortRule {
+				return help.FirewallFlag, errors.New(help.DefaultErrorMessage)
+			}
+			p.PortRule = true
+
+		default:
+			return help.UpdateFlag, errors.New(help.DefaultErrorMessage)
+		}
+	}
+
+	// The port and the firewall mark may be updated together, the private
+	// key only on its own.
+	if p.FlagCmd == help.PrivateKeyFlag && p.FwMark != "" {
+		return help.FwMarkFlag, errors.New(help.DefaultErrorMessage)
+	}
+	if p.Force && p.Value == "" {
+		return help.ForceFlag, errors.New(help.DefaultErrorMessage)
+	}
+	if p.PortRule && (p.Value == "" || p.Value == "0") {
+		return help.FirewallFlag, errors.New(help.DefaultErrorMessage)
+	}
+	// A rotated or generated key always changes.
+	if p.Strict && p.Value == "" && p.PrivateKey.IsEmpty() {
+		return help.StrictFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	return help.UpdateFlag, nil
+}
+
+// Method returns the changed network interface.
+func (p *UpdateInterfaceCommand) ChangedInterface() string {
+	return p.Iface
+}
+
+// Method to execute a command for updating the interface.
+func (p *UpdateInterfaceCommand) Execute() ([]Result, error) {
+	exist, err := interfaceExists(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, &get.InterfaceNotFoundError{Name: p.Iface}
+	}
+
+	backend, err := interfaceBackend(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	typeAwg := backend.AmneziaWG()
+
+	var results []Result
+
+	switch p.FlagCmd {
+	case help.PortFlag, help.FwMarkFlag:
+
+		auto := p.Value == help.AutoPortValue
+		if auto {
+			release, err := oplock.AcquirePorts()
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+
+			port, err := p.autoPort(typeAwg)
+			if err != nil {
+				return nil, err
+			}
+			p.Value = strconv.Itoa(port)
+		}
+
+		if p.Value != "" && p.PortRule {
+			moved, err := p.movePort(typeAwg)
+			results = append(results, moved...)
+			if err != nil {
+				return results, err
+			}
+		} else if p.Value != "" {
+			changed := true
+			if typeAwg {
+				port, err := validate.CheckPort(p.Value)
+				if err != nil {
+					return nil, err
+				}
+
+				if current, err := awgListenPort(p.Iface); err == nil && current == port && port != 0 {
+					changed = false
+				} else {
+					if !p.Force {
+						if err := awgCheckListenPort(p.Iface, p.Value); err != nil {
+							return nil, err
+						}
+					}
+
+					config := wgtypes.Config{ListenPort: &port}
+					if err := awgSet(p.Iface, config, shell.FormatCmdAwgUpdatePort(p.Iface, p.Value)); err != nil {
+						return nil, err
+					}
+				}
+
+			} else {
+				changed, err = set.EnsurePortWarn(p.Iface, p.Value, p.Force, reportWarning, p.lock)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if changed {
+				if auto {
+					fmt.Fprintf(noteOut, "network interface '%s' listens on port %s\n", p.Iface, p.Value)
+				}
+				results = append(results, applied("listen-port", p.Iface, p.Value))
+			} else {
+				result, err := unchanged(p.Strict, "listen-port", p.Iface, fmt.Sprintf(
+					"network interface '%s' already listens on port %s", p.Iface, p.Value,
+				))
+				if err != nil {
+					return nil, err
+				}
+				results = append(results, result)
+			}
+		}
+
+		if p.FwMark != "" {
+			mark, err := strconv.ParseUint(p.FwMark, 10, 32)
+			if err != nil {
+				return results, err
+			}
+
+			if typeAwg {
+				fwMark := int(mark)
+				config := wgtypes.Config{FirewallMark: &fwMark}
+				if err := awgSet(p.Iface, config, shell.FormatCmdAwgUpdateFwMark(p.Iface, p.FwMark)); err != nil {
+					return results, err
+				}
+
+			} else {
+				if err := set.UpdateFwMark(p.Iface, uint32(mark), p.lock); err != nil {
+					return results, err
+				}
+			}
+			results = append(results, applied("fwmark", p.Iface, p.FwMark))
+		}
+
+	case help.PrivateKeyFlag:
+
+		if p.Rotate {
+			return p.rotate(typeAwg)
+		}
+
+		defer p.PrivateKey.Zero()
+
+		var privKey wgtypes.Key
+		defer clear(privKey[:])
+
+		if p.PrivateKey.IsEmpty() {
+			keys, err := get.GenerateKeyPair()
+			if err != nil {
+				return nil, err
+			}
+			privKey = keys.Private
+			keys.Zero()
+		} else {
+			// Validate up front, wgctrl and awg fail opaquely on bad keys.
+			key, err := handlers.CheckPrivateKey(p.PrivateKey)
+			if err != nil {
+				return nil, err
+			}
+			privKey = key
+		}
+
+		secret := handlers.NewSecretKey(privKey)
+		defer secret.Zero()
+
+		changed := true
+		if typeAwg {
+			current, err := awgPublicKey(p.Iface)
+			if err == nil && !p.PrivateKey.IsEmpty() && current == privKey.PublicKey() {
+				changed = false
+			} else if err := updateAwgPrivateKey(p.Iface, secret); err != nil {
+				return nil, err
+			}
+
+		} else {
+			changed, err = set.EnsurePrivateKey(set.UpdatePrivateKeyStructure{
+				InterfaceName: p.Iface,
+				Secret:        secret,
+				Verify:        true,
+				Warn:          reportWarning,
+			}, p.lock)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !changed {
+			result, err := unchanged(p.Strict, "private-key", p.Iface, fmt.Sprintf(
+				"network interface '%s' already has the private key of public key %s",
+				p.Iface, privKey.PublicKey(),
+			))
+			if err != nil {
+				return nil, err
+			}
+			return append(results, result), nil
+		}
+
+		fmt.Printf("public key: %s\n", privKey.PublicKey())
+		results = append(results, applied("private-key", p.Iface, "public key "+privKey.PublicKey().String()))
+
+	}
+
+	return results, nil
+}
+
+// Method rotates the private key of the interface, prints the new public
+// key and optionally writes the new private key to OutPath. The change is
+// confirmed by reading the key back from the device.
+func (p *UpdateInterfaceCommand) rotate(typeAwg bool) ([]Result, error) {
+	var oldKey, newKey wgtypes.Key
+	var privKey wgtypes.Key
+	defer clear(privKey[:])
+
+	if typeAwg {
+		var err error
+		oldKey, err = awgPublicKey(p.Iface)
+		if err != nil {
+			return nil, &get.DeviceError{Interface: p.Iface, Op: "read", Err: err}
+		}
+
+		keys, err := get.GenerateKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		privKey = keys.Private
+		keys.Zero()
+
+		secret := handlers.NewSecretKey(privKey)
+		defer secret.Zero()
+
+		if err := updateAwgPrivateKey(p.Iface, secret); err != nil {
+			return nil, err
+		}
+
+		confirmed, err := awgPublicKey(p.Iface)
+		if err != nil || confirmed != keys.Public {
+			return nil, help.Errorf(
+				help.CodeVerification,
+				"error: failed to confirm the new private key of network interface '%s'",
+				p.Iface,
+			)
+		}
+		newKey = keys.Public
+
+		if err := set.RecordKeyRotation(p.Iface, oldKey, newKey, p.lock); err != nil {
+			return nil, err
+		}
+
+	} else {
+		var err error
+		oldKey, newKey, err = set.RotatePrivateKey(p.Iface, p.lock)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.OutPath != "" {
+			devices, err := get.GetPeer(p.Iface)
+			if err != nil {
+				return nil, err
+			}
+			privKey = devices[0].PrivateKey
+		}
+	}
+
+	if p.OutPath != "" {
+		if err := set.WritePrivateKey(p.OutPath, privKey); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Printf("old public key: %s\n", oldKey)
+	fmt.Printf("new public key: %s\n", newKey)
+
+	detail := fmt.Sprintf("public key %s -> %s", oldKey, newKey)
+	return []Result{applied("private-key-rotate", p.Iface, detail)}, nil
+}
+
+// Function configures an AmneziaWG interface over its UAPI socket when
+// brgaddawg serves it, so the awg tool is not needed. The fallback awg
+// command is run for the other interfaces (e.g., created by awg-quick).
+func awgSet(iface string, config wgtypes.Config, fallback string) error {
+	if path, ok := awgSocket(iface); ok {
+		return uapi.Configure(path, config)
+	}
+	return shell.DefaultRunner.Run(fallback, ShellStd)
+}
+
+// Function reads the public key of an AmneziaWG interface over its UAPI
+// socket, or with the awg tool, see awgSet.
+func awgPublicKey(iface string) (wgtypes.Key, error) {
+	if path, ok := awgSocket(iface); ok {
+		return uapi.PublicKey(path)
+	}
+
+	out, err := shell.DefaultRunner.Output(shell.FormatCmdAwgShowPublicKey(iface))
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+	return wgtypes.ParseKey(strings.TrimSpace(out.String()))
+}
+
+// Function removes a peer of an AmneziaWG interface, see awgSet.
+func awgRemovePeer(iface, publicKey string) error {
+	key, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return help.Errorf(help.CodeInvalidValue, "error: %v", err)
+	}
+	config := wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: key, Remove: true}}}
+	return awgSet(iface, config, shell.FormatCmdAwgDeletePeer(iface, publicKey))
+}
+
+// Function sets the private key of an AmneziaWG interface, see awgSet.
+// With the awg tool the key is part of the command line, so any error is
+// redacted.
+func updateAwgPrivateKey(iface string, key handlers.Secret) error {
+	if path, ok := awgSocket(iface); ok {
+		privKey, err := handlers.CheckPrivateKey(key)
+		if err != nil {
+			return err
+		}
+		defer clear(privKey[:])
+		return uapi.Configure(path, wgtypes.Config{PrivateKey: &privKey})
+	}
+
+	cmd := shell.FormatCmdAwgUpdatePrivateKey(iface, key.Reveal())
+	if err := shell.DefaultRunner.Run(cmd, ShellStd); err != nil {
+		return errors.New(handlers.Redact(err.Error(), key))
+	}
+	return nil
+}
+
+// PeerCommand encapsulates the data and logic for managing WireGuard peers.
+// It holds all necessary parameters for adding or deleting a peer, such as
+// interface name, public key, allowed IPs, keep-alive settings, and endpoint.
+type PeerCommand struct {
+	opLock
+
+	Iface        string
+	Publickey    string
+	AllowIps     []string // Allowed IP addresses (CIDR), validated by ParseArgs.
+	KeepAlive    string
+	EndPointHost string
+	Name         string
+	Expires      string
+	Limit        string // Rate limit, help.LimitOffValue removes it.
+	Rate         uint64 // Parsed rate limit in bits per second.
+	Quota        string // Transfer quota, help.LimitOffValue removes it.
+	QuotaLimit   uint64 // Parsed transfer quota in bytes.
+	QuotaPeriod  time.Duration
+	Force        bool // Add the peer even if its allowed IPs conflict.
+	Strict       bool // Fail if the peer is already configured.
+	FlagCmd      string
+}
+
+// Method parses the command-line arguments for the peer management command.
+// Expected format: `[interface_name] -pr [pub_key]` followed, in any order,
+// by `-a [address ...]` or `-d` and the optional `-kp`, `-eh`, `-name`,
+// `-expires`, `-limit`, `-quota`, `-quota-period`, `-force` and `-strict`
+// flags. The allowed IP addresses of -a are separate or comma separated
+// arguments, -a may be repeated; each one is validated as CIDR here. Without
+// -a and -d only -limit and -quota are accepted. It returns the main command
+// flag (help.PeerFlag), or the offending argument and an error if parsing
+// fails.
+func (p *PeerCommand) ParseArgs(args []string) (string, error) {
+
+	if len(args) <= 3 {
+		errMsg := "error: invalid command arguments, please provide private " +
+			"key and subnet address"
+		return help.PeerFlag, help.WithCode(help.CodeInvalidArguments, errors.New(errMsg))
+	}
+
+	p.Iface = args[0]
+	p.Publickey = args[2]
+	if strings.HasPrefix(p.Publickey, "-") {
+		return p.Publickey, help.Errorf(
+			help.CodeInvalidArguments,
+			"error: missing public key, got '%s'", p.Publickey,
+		)
+	}
+
+	seen := make(map[string]bool)
+	for indx := 3; indx < len(args); indx++ {
+		flag := args[indx]
+		// -a may be repeated, each occurrence adding its addresses.
+		if seen[flag] && flag != help.AddFlag {
+			return flag, help.Errorf(help.CodeInvalidArguments, "error: '%s' is given more than once", flag)
+		}
+		seen[flag] = true
+
+		switch flag {
+		case help.AddFlag:
+			// Addresses follow as separate or comma separated arguments.
+			count := len(p.AllowIps)
+			for indx+1 < len(args) && !strings.HasPrefix(args[indx+1], "-") {
+				indx++
+				for _, value := range strings.Split(args[indx], ",") {
+					value = strings.TrimSpace(value)
+					if value == "" {
+						continue
+					}
+					if _, err := validate.CheckAllowedIPs([]string{value}); err != nil {
+						return value, err
+					}
+					if slices.Contains(p.AllowIps, value) {
+						return value, help.Errorf(help.CodeInvalidValue, "error: allowed IP address '%s' is given more than once", value)
+					}
+					p.AllowIps = append(p.AllowIps, value)
+				}
+			}
+			if len(p.AllowIps) == count {
+				return help.AddFlag, help.Errorf(
+					help.CodeInvalidArguments,
+					"error: '%s' requires an allowed IP address, example: 10.10.10.1/32",
+					help.AddFlag,
+				)
+			}
+
+		case help.DelFlag:
+
+		case help.ForceFlag:
+			p.Force = true
+
+		case help.StrictFlag:
+			p.Strict = true
+
+		case help.KeepaliveFlag, help.EndPointHostFlag, help.PeerNameFlag,
+			help.PeerExpiresFlag, help.LimitFlag, help.QuotaFlag, help.QuotaPeriodFlag:
+
+			indx++
+			if indx >= len(args) || args[indx] == "" || isFlag(flag, args[indx]) {
+				return flag, errors.New(help.DefaultErrorMessage)
+			}
+			if err := p.parseOption(flag, args[indx]); err != nil {
+				return flag, err
+			}
+
+		default:
+			return flag, help.Errorf(help.CodeInvalidArguments, "error: unknown argument '%s'", flag)
+		}
+	}
+
+	switch {
+	case seen[help.AddFlag] && seen[help.DelFlag]:
+		return help.DelFlag, help.Errorf(
+			help.CodeInvalidArguments,
+			"error: '%s' cannot be combined with '%s'", help.DelFlag, help.AddFlag,
+		)
+	case seen[help.AddFlag]:
+		p.FlagCmd = help.AddFlag
+	case seen[help.DelFlag]:
+		p.FlagCmd = help.DelFlag
+	}
+
+	if p.Limit != "" && p.FlagCmd == help.DelFlag {
+		return help.LimitFlag, help.Errorf(
+			help.CodeInvalidArguments,
+			"error: '%s' cannot be combined with '%s', the limit of a deleted peer is removed",
+			help.LimitFlag, help.DelFlag,
+		)
+	}
+
+	if p.Quota != "" && p.FlagCmd == help.DelFlag {
+		return help.QuotaFlag, help.Errorf(
+			help.CodeInvalidArguments,
+			"error: '%s' cannot be combined with '%s', the quota of a deleted peer is removed",
+			help.QuotaFlag, help.DelFlag,
+		)
+	}
+	if seen[help.QuotaPeriodFlag] && (p.Quota == "" || p.Quota == help.LimitOffValue) {
+		return help.QuotaPeriodFlag, help.Errorf(help.CodeInvalidArguments, "error: '%s' requires '%s'", help.QuotaPeriodFlag, help.QuotaFlag)
+	}
+
+	// The peer settings only apply to an added peer.
+	for _, flag := range []string{
+		help.KeepaliveFlag, help.EndPointHostFlag, help.PeerNameFlag,
+		help.PeerExpiresFlag, help.ForceFlag, help.StrictFlag,
+	} {
+		if seen[flag] && p.FlagCmd != help.AddFlag {
+			return flag, help.Errorf(help.CodeInvalidArguments, "error: '%s' requires '%s'", flag, help.AddFlag)
+		}
+	}
+
+	if p.FlagCmd == "" && p.Limit == "" && p.Quota == "" {
+		return help.PeerFlag, help.Errorf(
+			help.CodeInvalidArguments,
+			"error: invalid command arguments, specify action: [%s | %s | %s | %s]",
+			help.AddFlag, help.DelFlag, help.LimitFlag, help.QuotaFlag,
+		)
+	}
+
+	return help.PeerFlag, nil
+}
+
+// Function reports whether the argument following the option is a flag
+// rather than its value. A negative number is a (rejected) keepalive value.
+func isFlag(option, arg string) bool {
+	if !strings.HasPrefix(arg, "-") {
+		return false
+	}
+	if option == help.KeepaliveFlag {
+		if _, err := strconv.Atoi(arg); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Method validates and stores the value of a peer command option.
+func (p *PeerCommand) parseOption(flag, value string) error {
+	switch flag {
+	case help.KeepaliveFlag:
+		interval, err := validate.CheckKeepalive(value)
+		if err != nil {
+			return fmt.Errorf(
+				"error: invalid value '%s' for '%s', expected seconds in range 0-%d (0 disables it)",
+				value, help.KeepaliveFlag, validate.MaxKeepalive,
+			)
+		}
+		p.KeepAlive = strconv.Itoa(int(interval.Seconds()))
+
+	case help.EndPointHostFlag:
+		p.EndPointHost = value
+
+	case help.PeerNameFlag:
+		p.Name = value
+
+	case help.PeerExpiresFlag:
+		if _, err := validate.CheckExpiry(value); err != nil {
+			return err
+		}
+		p.Expires = value
+
+	case help.LimitFlag:
+		if value != help.LimitOffValue {
+			rate, err := validate.CheckRate(value)
+			if err != nil {
+				return err
+			}
+			p.Rate = rate
+		}
+		p.Limit = value
+
+	case help.QuotaFlag:
+		if value != help.LimitOffValue {
+			limit, err := validate.CheckSize(value)
+			if err != nil {
+				return err
+			}
+			p.QuotaLimit = limit
+		}
+		p.Quota = value
+
+	case help.QuotaPeriodFlag:
+		period, err := validate.CheckPeriod(value)
+		if err != nil {
+			return err
+		}
+		p.QuotaPeriod = period
+	}
+	return nil
+}
+
+// Method returns the changed network interface.
+func (p *PeerCommand) ChangedInterface() string {
+	return p.Iface
+}
+
+// Method performs the peer management operation (add or delete) based on the parsed arguments.
+// It constructs a SinglePeerStructure and calls the appropriate method (AddPeer or RemovePeer)
+// to apply the changes to the WireGuard configuration.
+func (p *PeerCommand) Execute() ([]Result, error) {
+
+	backend, err := interfaceBackend(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	typeAwg := backend.AmneziaWG()
+
+	var results []Result
+	obj := set.SinglePeerStructure{Verify: true}
+	switch p.FlagCmd {
+	case help.AddFlag:
+
+		var changed bool
+		if typeAwg {
+			changed, err = p.ensureAwgPeer()
+			if err != nil {
+				return nil, err
+			}
+
+		} else {
+			obj.InterfaceName = p.Iface
+			obj.PublicKey = p.Publickey
+			obj.AllowedIPs = p.AllowIps
+			obj.PersistentKeepaliveInterval = p.KeepAlive
+			obj.EndpointHost = p.EndPointHost
+			obj.Name = p.Name
+			obj.Expires = p.Expires
+			obj.AllowConflicts = p.Force
+			obj.Warn = reportWarning
+			changed, err = obj.EnsurePeer(p.lock)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !changed {
+			result, err := unchanged(p.Strict, "peer-add", p.Publickey, fmt.Sprintf(
+				"peer '%s' is already configured on network interface '%s'",
+				p.Publickey, p.Iface,
+			))
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+			break
+		}
+		results = append(results, applied("peer-add", p.Publickey, strings.Join(p.AllowIps, ",")))
+
+	case help.DelFlag:
+
+		// The allowed IPs identify the rate limit of the peer, read them
+		// before the peer is gone.
+		allowedIPs, lookupErr := peerAllowedIPs(p.Iface, p.Publickey, typeAwg)
+
+		if typeAwg {
+			if err := awgRemovePeer(p.Iface, p.Publickey); err != nil {
+				return nil, err
+			}
+
+			if err := set.RemovePeerMeta(p.Iface, p.Publickey, p.lock); err != nil {
+				return nil, err
+			}
+
+		} else {
+			obj.InterfaceName = p.Iface
+			obj.PublicKey = p.Publickey
+
+			if err := obj.RemovePeer(p.lock); err != nil {
+				return nil, err
+			}
+		}
+
+		if lookupErr == nil {
+			if err := set.RemovePeerLimit(p.Iface, allowedIPs, p.lock); err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, applied("peer-delete", p.Publickey, ""))
+
+	}
+
+	if p.Limit != "" {
+		if err := p.applyLimit(typeAwg); err != nil {
+			return results, err
+		}
+		results = append(results, applied("peer-limit", p.Publickey, p.Limit))
+	}
+
+	if p.Quota != "" {
+		if p.FlagCmd == "" {
+			// The quota of a peer which is not configured would never be
+			// sampled.
+			if _, err := peerAllowedIPs(p.Iface, p.Publickey, typeAwg); err != nil {
+				return results, err
+			}
+		}
+		if err := set.SetPeerQuota(p.Iface, p.Publickey, p.QuotaLimit, p.QuotaPeriod, p.lock); err != nil {
+			return results, err
+		}
+		results = append(results, applied("peer-quota", p.Publickey, p.Quota))
+	}
+	return results, nil
+}
+
+// Method installs, updates or, for help.LimitOffValue, removes the rate
+// limit of the peer. The limit follows the allowed IPs of the peer.
+func (p *PeerCommand) applyLimit(typeAwg bool) error {
+	allowedIPs, err := peerAllowedIPs(p.Iface, p.Publickey, typeAwg)
+	if err != nil {
+		return err
+	}
+
+	if p.Limit == help.LimitOffValue {
+		return set.RemovePeerLimit(p.Iface, allowedIPs, p.lock)
+	}
+	return set.SetPeerLimit(p.Iface, allowedIPs, p.Rate, p.lock)
+}
+
+// Function returns the allowed IPs of a peer of the network interface.
+func peerAllowedIPs(iface, publicKey string, typeAwg bool) ([]string, error) {
+	var device get.DeviceInfo
+	if typeAwg {
+		info, err := get.GetAwgPeerInfo(iface)
+		if err != nil {
+			return nil, err
+		}
+		device = info
+	} else {
+		info, err := get.GetPeerInfo(iface)
+		if err != nil {
+			return nil, err
+		}
+		if len(info) > 0 {
+			device = info[0]
+		}
+	}
+
+	for _, peer := range device.Peers {
+		if peer.PublicKey == publicKey {
+			return peer.AllowedIPs, nil
+		}
+	}
+	return nil, &get.PeerNotFoundError{Interface: iface, Peer: publicKey}
+}
+
+// Method resolves the listen port `auto`: the current port of the interface
+// when it lies in the range of BRGNETUSE_PORT_RANGE (51820-51999 by
+// default), otherwise the lowest free port of the range, see
+// handlers.FindFreeUDPPort. The caller holds oplock.AcquirePorts until
+// the port is set.
+func (p *UpdateInterfaceCommand) autoPort(typeAwg bool) (int, error) {
+	start, end, err := handlers.ParsePortRange(help.Environ()[help.Env_Default_PortRange])
+	if err != nil {
+		return 0, err
+	}
+
+	current := 0
+	if typeAwg {
+		current, _ = awgListenPort(p.Iface)
+	} else if device, err := interfaceDevice(p.Iface); err == nil {
+		current = device.ListenPort
+	}
+	if current >= start && current <= end {
+		return current, nil
+	}
+
+	return handlers.FindFreeUDPPort(start, end)
+}
+
+// Method changes the listen port with its INPUT rule (-fr): the rule of the
+// new port is added first, on the input interface (-in) of the rule of the
+// old port, and the rule of the old port, when tagged by this tool, is
+// removed last, see set.MovePortRule. A failed step rolls back the
+// previous ones.
+func (p *UpdateInterfaceCommand) movePort(typeAwg bool) ([]Result, error) {
+	port, err := validate.CheckPort(p.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var current int
+	if typeAwg {
+		current, err = awgListenPort(p.Iface)
+	} else {
+		var device get.DeviceInfo
+		device, err = interfaceDevice(p.Iface)
+		current = device.ListenPort
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	if current == port && p.Strict {
+		_, err := unchanged(p.Strict, "listen-port", p.Iface, fmt.Sprintf(
+			"network interface '%s' already listens on port %s", p.Iface, p.Value,
+		))
+		return nil, err
+	}
+
+	var update set.PortRuleUpdate
+	if typeAwg {
+		if !p.Force {
+			if err := awgCheckListenPort(p.Iface, p.Value); err != nil {
+				return nil, err
+			}
+		}
+		update, err = set.MovePortRule(current, port, func(port int) error {
+			config := wgtypes.Config{ListenPort: &port}
+			return awgSet(p.Iface, config, shell.FormatCmdAwgUpdatePort(p.Iface, strconv.Itoa(port)))
+		}, p.lock)
+	} else {
+		update, err = set.UpdatePortWithFirewall(p.Iface, p.Value, p.Force, p.lock)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range update.Warnings {
+		reportWarning(w)
+	}
+
+	if update.RuleAdded {
+		results = append(results, applied("port-rule-add", p.Value, "udp"))
+	} else {
+		// The rule found in place by a move is reported by its warning.
+		if !slices.ContainsFunc(update.Warnings, func(w set.Warning) bool { return w.Code == set.WarnRuleExists }) {
+			fmt.Fprintf(noteOut, "port %s is already open, unchanged\n", p.Value)
+		}
+		results = append(results, skipped("port-rule-add", p.Value, "unchanged"))
+	}
+
+	if update.PortChanged {
+		results = append(results, applied("listen-port", p.Iface, p.Value))
+	} else {
+		fmt.Fprintf(noteOut, "network interface '%s' already listens on port %s, unchanged\n", p.Iface, p.Value)
+		results = append(results, skipped("listen-port", p.Iface, "unchanged"))
+	}
+
+	if update.OldRuleRemoved {
+		results = append(results, applied("port-rule-delete", strconv.Itoa(update.OldPort), "udp"))
+	}
+	return results, nil
+}
+
+// Function checks that the listening port is free for the AmneziaWG
+// interface, see set.CheckListenPort. The interface is not known to wgctrl
+// while its own socket is, so its current port is accepted first.
+func awgCheckListenPort(iface, value string) error {
+	port, err := validate.CheckPort(value)
+	if err != nil {
+		return err
+	}
+
+	if current, err := awgListenPort(iface); err == nil && current == port {
+		return nil
+	}
+	return set.CheckListenPort(iface, port)
+}
+
+// Function reads the listening port of an AmneziaWG interface over its
+// UAPI socket, or with the awg tool, see awgSet.
+func awgListenPort(iface string) (int, error) {
+	if path, ok := awgSocket(iface); ok {
+		return uapi.ListenPort(path)
+	}
+
+	device, err := get.GetAwgPeerInfo(iface)
+	if err != nil {
+		return 0, err
+	}
+	return device.ListenPort, nil
+}
+
+// Method adds the peer to the AmneziaWG interface unless it is already
+// configured the same way (see set.PeerConfigured), reports whether it
+// changed anything.
+func (p *PeerCommand) ensureAwgPeer() (bool, error) {
+	peer := set.SinglePeerStructure{
+		PublicKey:                   p.Publickey,
+		AllowedIPs:                  p.AllowIps,
+		PersistentKeepaliveInterval: p.KeepAlive,
+		EndpointHost:                p.EndPointHost,
+	}
+	peerConfig, err := peer.PeerConfig()
+	if err != nil {
+		return false, err
+	}
+	if p.awgPeerConfigured(peerConfig) {
+		return false, nil
+	}
+
+	if !p.Force {
+		if err := awgConflicts(p.Iface, p.Publickey, p.AllowIps); err != nil {
+			return false, err
+		}
+	}
+
+	cmd := shell.FormatCmdAwgAddPeer(
+		p.Iface, p.Publickey,
+		strings.Join(p.AllowIps, ", "),
+		p.KeepAlive, p.EndPointHost)
+	config := wgtypes.Config{Peers: []wgtypes.PeerConfig{peerConfig}}
+	if err := awgSet(p.Iface, config, cmd); err != nil {
+		return false, err
+	}
+
+	if p.Name != "" {
+		if err := set.SetPeerMeta(p.Iface, p.Publickey, p.Name, "", p.lock); err != nil {
+			return false, err
+		}
+	}
+	if p.Expires != "" {
+		if err := set.SetPeerExpiry(p.Iface, p.Publickey, p.Expires, p.lock); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Method reports whether the AmneziaWG interface already has the peer
+// configuration and the name and expiry of the command. Any error reading
+// the interface counts as not configured.
+func (p *PeerCommand) awgPeerConfigured(peerConfig wgtypes.PeerConfig) bool {
+	info, err := get.GetAwgPeerInfo(p.Iface)
+	if err != nil {
+		return false
+	}
+
+	device := &wgtypes.Device{}
+	for _, peerInfo := range info.Peers {
+		if peerInfo.PublicKey != p.Publickey {
+			continue
+		}
+		if p.Name != "" && peerInfo.Name != p.Name {
+			return false
+		}
+		if p.Expires != "" {
+			expires, err := validate.CheckExpiry(p.Expires)
+			if err != nil || peerInfo.Expires != expires.Format(time.RFC3339) {
+				return false
+			}
+		}
+
+		current := wgtypes.Peer{
+			PublicKey:                   peerConfig.PublicKey,
+			PersistentKeepaliveInterval: time.Duration(peerInfo.PersistentKeepalive) * time.Second,
+		}
+		if peerInfo.Endpoint != "" {
+			if endpoint, err := net.ResolveUDPAddr("udp", peerInfo.Endpoint); err == nil {
+				current.Endpoint = endpoint
+			}
+		}
+		for _, allowedIP := range peerInfo.AllowedIPs {
+			if _, ipNet, err := net.ParseCIDR(allowedIP); err == nil {
+				current.AllowedIPs = append(current.AllowedIPs, *ipNet)
+			}
+		}
+		device.Peers = append(device.Peers, current)
+	}
+	return set.PeerConfigured(device, peerConfig)
+}
+
+// Function checks that the allowed IPs of the AmneziaWG peer overlap no
+// allowed IP of another peer of the interface, see set.SinglePeerStructure.
+func awgConflicts(iface, publicKey string, allowIps []string) error {
+	prefixes, err := validate.CheckAllowedIPs(allowIps)
+	if err != nil {
+		return err
+	}
+
+	device, err := get.GetAwgPeerInfo(iface)
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range device.Peers {
+		if peer.PublicKey == publicKey {
+			continue
+		}
+		taken, err := validate.CheckAllowedIPs(peer.AllowedIPs)
+		if err != nil {
+			return err
+		}
+		for _, prefix := range prefixes {
+			for _, other := range taken {
+				if validate.PrefixesOverlap(prefix, other) {
+					return help.Errorf(
+						help.CodeConflict,
+						"error: allowed IP '%s' of peer '%s' conflicts with '%s' of peer '%s', use force to add it anyway",
+						prefix.String(), publicKey, other.String(), peer.PublicKey,
+					)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// PruneCommand removes the peers of a network interface whose expiry,
+// recorded in the peer metadata, has passed.
+type PruneCommand struct {
+	opLock
+
+	Iface string
+}
+
+// Method parses the command-line arguments for the prune command.
+func (p *PruneCommand) ParseArgs(args []string) (string, error) {
+	if len(args) != 2 {
+		return help.PruneFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	if err := validate.CheckInterfaceName(args[0]); err != nil {
+		return help.WgInterfaceFlag, err
+	}
+
+	p.Iface = args[0]
+	return help.PruneFlag, nil
+}
+
+// Method returns the changed network interface.
+func (p *PruneCommand) ChangedInterface() string {
+	return p.Iface
+}
+
+// Method removes the expired peers and reports each removed peer.
+// Running it again once the peers are gone is a no-op.
+func (p *PruneCommand) Execute() ([]Result, error) {
+	iface, err := interfaceExists(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	if !iface {
+		return nil, &get.InterfaceNotFoundError{Name: p.Iface}
+	}
+
+	backend, err := interfaceBackend(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	typeAwg := backend.AmneziaWG()
+
+	meta, err := get.GetPeerMeta(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	if typeAwg {
+		removed, err = set.ExpiredPeers(p.Iface, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range removed {
+			if err := awgRemovePeer(p.Iface, key); err != nil {
+				return nil, err
+			}
+			if err := set.RemovePeerMeta(p.Iface, key, p.lock); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		removed, err = set.PruneExpiredPeers(p.Iface, p.lock)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var results []Result
+	for _, key := range removed {
+		entry := meta[key]
+		name := ""
+		if entry.Name != "" {
+			name = " (" + entry.Name + ")"
+		}
+		fmt.Printf(
+			"removed peer %s%s, expired %s\n",
+			key, name, entry.Expires.Format(time.RFC3339),
+		)
+		results = append(results, applied("peer-prune", key, "expired "+entry.Expires.Format(time.RFC3339)))
+	}
+	if len(removed) == 0 {
+		fmt.Printf("no expired peers on %s\n", p.Iface)
+	}
+
+	return results, nil
+}
+
+// EnforceQuotasCommand samples the transfer counters of the peers of a
+// network interface into their quotas, recorded in the peer metadata, and
+// removes the peers over their quota. It is meant to run periodically
+// (e.g., from cron or a systemd timer), the quota is enforced at that
+// interval.
+type EnforceQuotasCommand struct {
+	opLock
+
+	Iface string
+}
+
+// Method parses the command-line arguments for the quota command.
+func (p *EnforceQuotasCommand) ParseArgs(args []string) (string, error) {
+	if len(args) != 2 {
+		return help.EnforceQuotasFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	if err := validate.CheckInterfaceName(args[0]); err != nil {
+		return help.WgInterfaceFlag, err
+	}
+
+	p.Iface = args[0]
+	return help.EnforceQuotasFlag, nil
+}
+
+// Method returns the changed network interface.
+func (p *EnforceQuotasCommand) ChangedInterface() string {
+	return p.Iface
+}
+
+// Method samples the quotas and reports each removed peer.
+func (p *EnforceQuotasCommand) Execute() ([]Result, error) {
+	iface, err := interfaceExists(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	if !iface {
+		return nil, &get.InterfaceNotFoundError{Name: p.Iface}
+	}
+
+	backend, err := interfaceBackend(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := get.GetPeerMeta(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	if backend.AmneziaWG() {
+		info, err := get.GetAwgPeerInfo(p.Iface)
+		if err != nil {
+			return nil, err
+		}
+		removed, err = set.SampleQuotas(p.Iface, info.Peers, time.Now(), p.lock)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range removed {
+			if err := awgRemovePeer(p.Iface, key); err != nil {
+				return nil, err
+			}
+			if err := set.RemovePeerMeta(p.Iface, key, p.lock); err != nil {
+				return nil, err
+			}
+			for _, peer := range info.Peers {
+				if peer.PublicKey != key {
+					continue
+				}
+				if err := set.RemovePeerLimit(p.Iface, peer.AllowedIPs, p.lock); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else {
+		removed, err = set.EnforceQuotas(p.Iface, p.lock)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var results []Result
+	for _, key := range removed {
+		entry := meta[key]
+		name := ""
+		if entry.Name != "" {
+			name = " (" + entry.Name + ")"
+		}
+		detail := fmt.Sprintf("quota of %s exceeded", validate.FormatSize(entry.Quota.Limit))
+		fmt.Printf("removed peer %s%s, %s\n", key, name, detail)
+		results = append(results, applied("peer-quota-exceeded", key, detail))
+	}
+	if len(removed) == 0 {
+		fmt.Printf("no peer over its quota on %s\n", p.Iface)
+	}
+
+	return results, nil
+}
+
+// RefreshEndpointsCommand re-resolves the endpoint host names of the peers
+// of a network interface and updates the endpoints whose address changed.
+type RefreshEndpointsCommand struct {
+	opLock
+
+	Iface string
+}
+
+// Method parses the command-line arguments for the refresh command.
+func (p *RefreshEndpointsCommand) ParseArgs(args []string) (string, error) {
+	if len(args) != 2 {
+		return help.RefreshEndpointsFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	if err := validate.CheckInterfaceName(args[0]); err != nil {
+		return help.WgInterfaceFlag, err
+	}
+
+	p.Iface = args[0]
+	return help.RefreshEndpointsFlag, nil
+}
+
+// Method returns the changed network interface.
+func (p *RefreshEndpointsCommand) ChangedInterface() string {
+	return p.Iface
+}
+
+// Method updates the endpoints and reports each updated peer. A peer whose
+// host name does not resolve keeps its endpoint and is reported as a
+// warning, the command does not fail.
+func (p *RefreshEndpointsCommand) Execute() ([]Result, error) {
+	iface, err := interfaceExists(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	if !iface {
+		return nil, &get.InterfaceNotFoundError{Name: p.Iface}
+	}
+
+	backend, err := interfaceBackend(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	if backend.AmneziaWG() {
+		return nil, help.Errorf(
+			help.CodeUnsupported,
+			"error: '%s' is only supported by WireGuard interfaces",
+			help.RefreshEndpointsFlag,
+		)
+	}
+
+	updates, err := set.RefreshEndpoints(context.Background(), p.Iface, set.DefaultResolver, p.lock)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	changed := 0
+	for _, update := range updates {
+		switch {
+		case update.Err != nil:
+			warn(fmt.Sprintf("endpoint of peer %s kept: %v", update.PublicKey, update.Err))
+			results = append(results, Result{
+				Action: "peer-endpoint", Target: update.PublicKey,
+				Status: StatusFailed, Detail: update.Err.Error(),
+				Code: string(help.CodeOf(update.Err)),
+			})
+		case update.Changed():
+			changed++
+			fmt.Fprintf(noteOut,
+				"updated endpoint of peer %s (%s) from %s to %s\n",
+				update.PublicKey, update.Host, update.Previous, update.Current,
+			)
+			results = append(results, applied("peer-endpoint", update.PublicKey, update.Host+" -> "+update.Current))
+		default:
+			results = append(results, skipped("peer-endpoint", update.PublicKey, update.Host+" unchanged"))
+		}
+	}
+	if changed == 0 {
+		fmt.Fprintf(noteOut, "no endpoint changed on %s\n", p.Iface)
+	}
+
+	return results, nil
+}
+
+// IpIntertfaceCommand encapsulates the data and logic for managing IP addresses
+// and associated firewall/NAT rules on network interfaces.
+type IpIntertfaceCommand struct {
+	opLock
+
+	InIface   string
+	SubNets   []string
+	OutIfaces []string
+	Strict    bool // Fail if an added address is already present.
+	Force     bool // Add the rules through an interface without an address of their family.
+	FlagCmd   string
+
+	// The firewall and NAT tables listed by the command, see RuleSnapshot.
+	rules RuleSnapshot
+}
+
+// Method parses the command-line arguments for the IP interface command.
+// Expected format: `[interface_name] -ip [address[,address]] [-a | -d]
+// [-n | -fr] [out_interface[,out_interface] | all] [-force]`, the addresses
+// may mix IPv4 and IPv6. The addresses are added with `-a -strict` to fail
+// if one is already present, the rules with `-force` even through an
+// interface without an address of their family, see checkFamilies.
+// It returns the main command flag (help.IpAddressFlag) and an error if parsing fails.
+func (p *IpIntertfaceCommand) ParseArgs(args []string) (string, error) {
+	if len(args) > 5 && args[len(args)-1] == help.ForceFlag && args[3] == help.AddFlag {
+		p.Force = true
+		args = args[:len(args)-1]
+	}
+
+	if len(args) < 4 || len(args) > 6 {
+		errMsg := fmt.Sprintf(
+			"error: invalid command arguments, specify action: [%s | %s]",
+			help.AddFlag,
+			help.DelFlag,
+		)
+		return help.IpAddressFlag, help.WithCode(help.CodeInvalidArguments, errors.New(errMsg))
+	}
+
+	p.InIface = args[0]
+	if err := validate.CheckInterfaceName(p.InIface); err != nil {
+		return help.WgInterfaceFlag, err
+	}
+
+	subnets, err := parseAddressList(args[2])
+	if err != nil {
+		return help.IpAddressFlag, err
+	}
+	p.SubNets = subnets
+
+	switch args[3] {
+	case help.AddFlag, help.DelFlag:
+		p.FlagCmd = args[3]
+	default:
+		return args[3], errors.New(help.DefaultErrorMessage)
+	}
+
+	if len(args) == 5 && args[3] == help.AddFlag && args[4] == help.StrictFlag {
+		p.Strict = true
+		return help.IpAddressFlag, nil
+	}
+
+	// Check args: Firewall, NAT
+	if len(args) > 4 {
+		switch args[4] {
+		case help.NatFlag, help.FirewallFlag:
+			p.FlagCmd = p.FlagCmd + args[4]
+		default:
+			errMsg := fmt.Sprintf(
+				"error: invalid command arguments, specify action: [%s | %s]",
+				help.NatFlag,
+				help.FirewallFlag,
+			)
+			return help.IpAddressFlag, help.WithCode(help.CodeInvalidArguments, errors.New(errMsg))
+		}
+
+		if len(args) == 6 {
+			outIfaces, err := parseOutIfaces(args[5])
+			if err != nil {
+				return help.NatFlag, err
+			}
+			p.OutIfaces = outIfaces
+		}
+	}
+
+	return help.IpAddressFlag, nil
+}
+
+// Function parses a comma separated list of outgoing network interfaces
+// (e.g., `eth0,wwan0`) or the `all` value, which is kept as is and resolved
+// by resolveOutIfaces.
+func parseOutIfaces(value string) ([]string, error) {
+	if value == help.NatAllValue {
+		return []string{help.NatAllValue}, nil
+	}
+
+	var outIfaces []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == help.NatAllValue {
+			return nil, help.Errorf(help.CodeInvalidArguments, "error: '%s' cannot be combined with network interface names", field)
+		}
+		if err := validate.CheckInterfaceName(field); err != nil {
+			return nil, err
+		}
+		if slices.Contains(outIfaces, field) {
+			return nil, help.Errorf(help.CodeInvalidValue, "error: network interface '%s' is listed twice", field)
+		}
+		outIfaces = append(outIfaces, field)
+	}
+	return outIfaces, nil
+}
+
+// Function returns the outgoing network interfaces of the rules: the
+// interface of the default route when none is given, the uplinks for
+// `all`, see uplinkInterfaces.
+func resolveOutIfaces(outIfaces []string) ([]string, error) {
+	switch {
+	case len(outIfaces) == 0:
+		outIface, err := shell.GetNetInterfaceNameLinux()
+		if err != nil {
+			return nil, err
+		}
+		return []string{outIface}, nil
+
+	case len(outIfaces) == 1 && outIfaces[0] == help.NatAllValue:
+		return uplinkInterfaces()
+	}
+	return outIfaces, nil
+}
+
+// Function returns the up, non-loopback network interfaces carrying a
+// default route, the WireGuard and AmneziaWG devices excluded.
+func uplinkInterfaces() ([]string, error) {
+	candidates, err := shell.GetDefaultRouteInterfacesLinux()
+	if err != nil {
+		return nil, err
+	}
+
+	wgDevices, err := get.GetWireGuardDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	var uplinks []string
+	for _, name := range candidates {
+		var iface *net.Interface
+		err := netns.Do(func() (err error) {
+			iface, err = net.InterfaceByName(name)
+			return err
+		})
+		if err != nil || iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if slices.Contains(wgDevices, name) {
+			continue
+		}
+		uplinks = append(uplinks, name)
+	}
+
+	if len(uplinks) == 0 {
+		return nil, help.Errorf(
+			help.CodeNotFound,
+			"error: no uplink network interface found, specify the interface names (e.g., -n eth0,wwan0)",
+		)
+	}
+	return uplinks, nil
+}
+
+// Function parses a comma separated list of addresses in CIDR notation
+// (e.g., `10.10.10.1/24,fd00::1/64`). Each entry is validated for its
+// family; IPv4-mapped IPv6 addresses and duplicates are rejected.
+func parseAddressList(value string) ([]string, error) {
+	var subnets []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			return nil, fmt.Errorf("error: empty entry in address list '%s'", value)
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"error: invalid IP address format '%s' example: 10.10.10.1/24, fd00::1/64",
+				field,
+			)
+		}
+		if prefix.Addr().Is4In6() {
+			return nil, fmt.Errorf(
+				"error: IPv4-mapped address '%s', use the IPv4 form (e.g., 10.10.10.1/24)",
+				field,
+			)
+		}
+
+		subnet := prefix.String()
+		if slices.Contains(subnets, subnet) {
+			return nil, help.Errorf(help.CodeInvalidValue, "error: address '%s' is listed twice", field)
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+// Method returns the changed network interface.
+func (p *IpIntertfaceCommand) ChangedInterface() string {
+	return p.InIface
+}
+
+// Method execute performs the IP address and/or firewall/NAT operations based on the parsed arguments.
+// It constructs and executes shell commands using 'ip' or 'iptables'.
+func (p *IpIntertfaceCommand) Execute() ([]Result, error) {
+	// Only the firewall and NAT rules use the outgoing network interfaces.
+	if p.FlagCmd != help.AddFlag && p.FlagCmd != help.DelFlag {
+		outIfaces, err := resolveOutIfaces(p.OutIfaces)
+		if err != nil {
+			return nil, err
+		}
+		p.OutIfaces = outIfaces
+	}
+
+	if p.FlagCmd == help.AddFlag+help.NatFlag || p.FlagCmd == help.AddFlag+help.FirewallFlag {
+		if err := checkFamilies(p.InIface, "NAT rule", p.SubNets, p.OutIfaces, p.Force); err != nil {
+			return nil, err
+		}
+	}
+
+	switch p.FlagCmd {
+	case help.AddFlag:
+		return p.addAddresses()
+
+	case help.DelFlag:
+		return p.deleteAddresses()
+
+	case help.AddFlag + help.NatFlag, help.AddFlag + help.FirewallFlag:
+		return p.addRules()
+
+	case help.DelFlag + help.NatFlag:
+		// The states of the rules are read before the first one is deleted.
+		var plan []plannedRules
+		for _, outIface := range p.OutIfaces {
+			for _, subnet := range p.ipv4Subnets() {
+				_, natState, err := getRules(&p.rules, p.InIface, outIface, subnet, "nat")
+				if err != nil {
+					return nil, err
+				}
+				plan = append(plan, plannedRules{outIface: outIface, subnet: subnet, natState: natState})
+			}
+		}
+
+		var results []Result
+		defer p.rules.Invalidate()
+		for _, step := range plan {
+			rules := []peermeta.Rule{set.NATRule(step.outIface, step.subnet, p.InIface)}
+			if err := deleteRules(step.natState, rules); err != nil {
+				return results, err
+			}
+
+			rules = append(rules, set.UntaggedRule(rules[0]))
+			if err := set.ForgetApplied(p.InIface, rules, nil, p.lock); err != nil {
+				return results, err
+			}
+			results = append(results, applied("nat-rule-delete", p.InIface, step.subnet+" -> "+step.outIface))
+		}
+		return results, nil
+
+	case help.DelFlag + help.FirewallFlag:
+		subnets := p.ipv4Subnets()
+		if len(subnets) == 0 {
+			return nil, nil
+		}
+
+		var plan []plannedRules
+		for _, outIface := range p.OutIfaces {
+			fwState, _, err := getRules(&p.rules, p.InIface, outIface, subnets[0], "fr")
+			if err != nil {
+				return nil, err
+			}
+			plan = append(plan, plannedRules{outIface: outIface, subnet: subnets[0], fwState: fwState})
+		}
+
+		var results []Result
+		defer p.rules.Invalidate()
+		for _, step := range plan {
+			rules := set.ForwardRules(step.outIface, p.InIface)
+			if err := deleteRules(step.fwState, rules); err != nil {
+				return results, err
+			}
+
+			for _, rule := range slices.Clone(rules) {
+				rules = append(rules, set.UntaggedRule(rule))
+			}
+			if err := set.ForgetApplied(p.InIface, rules, nil, p.lock); err != nil {
+				return results, err
+			}
+			results = append(results, applied("forward-rule-delete", p.InIface, step.outIface))
+		}
+		return results, nil
+
+	}
+
+	return nil, nil
+}
+
+// Method adds the addresses to the interface. The addresses already
+// present are left unchanged, see unchanged. If an address fails, the
+// addresses added before it are removed again.
+func (p *IpIntertfaceCommand) addAddresses() ([]Result, error) {
+	present := p.presentAddresses()
+
+	var results []Result
+	var added []string
+	for _, subnet := range p.SubNets {
+		if present[subnet] {
+			result, err := unchanged(p.Strict, "address-add", p.InIface, fmt.Sprintf(
+				"address '%s' is already present on network interface '%s'", subnet, p.InIface,
+			))
+			if err != nil {
+				for _, prev := range slices.Backward(added) {
+					shell.DefaultRunner.Run(shell.FormatCmdIpAddrDev(p.InIface, prev, shell.IpDel), ShellStd)
+				}
+				return nil, err
+			}
+			results = append(results, result)
+			continue
+		}
+
+		cmd := shell.FormatCmdIpAddrDev(p.InIface, subnet, shell.IpAdd)
+		if err := shell.DefaultRunner.Run(cmd, ShellStd); err != nil {
+			for _, prev := range slices.Backward(added) {
+				shell.DefaultRunner.Run(shell.FormatCmdIpAddrDev(p.InIface, prev, shell.IpDel), ShellStd)
+			}
+			return nil, err
+		}
+		added = append(added, subnet)
+		results = append(results, applied("address-add", p.InIface, subnet))
+	}
+
+	return results, set.RecordApplied(p.InIface, nil, added, p.lock)
+}
+
+// Method returns the addresses of the interface in CIDR notation, none if
+// they cannot be read (the 'ip' command then reports the error).
+func (p *IpIntertfaceCommand) presentAddresses() map[string]bool {
+	present := make(map[string]bool)
+	ifaces, err := get.GetIpShow(p.InIface)
+	if err != nil {
+		return present
+	}
+	for _, iface := range ifaces {
+		for _, addr := range iface.AddrInfo {
+			prefix, err := netip.ParsePrefix(fmt.Sprintf("%s/%d", addr.Local, addr.Prefixlen))
+			if err == nil {
+				present[prefix.String()] = true
+			}
+		}
+	}
+	return present
+}
+
+// Method deletes the addresses from the interface. The addresses deleted
+// before a failure are forgotten from the interface metadata.
+func (p *IpIntertfaceCommand) deleteAddresses() ([]Result, error) {
+	var results []Result
+	var deleted []string
+	var err error
+	for _, subnet := range p.SubNets {
+		cmd := shell.FormatCmdIpAddrDev(p.InIface, subnet, shell.IpDel)
+		if err = shell.DefaultRunner.Run(cmd, ShellStd); err != nil {
+			break
+		}
+		deleted = append(deleted, subnet)
+		results = append(results, applied("address-delete", p.InIface, subnet))
+	}
+
+	if forgetErr := set.ForgetApplied(p.InIface, nil, deleted, p.lock); err == nil {
+		err = forgetErr
+	}
+	return results, err
+}
+
+// Rules of an outgoing network interface and subnet, with their states
+// read before any rule is changed.
+type plannedRules struct {
+	outIface string
+	subnet   string
+	forward  bool // The FORWARD rules are handled with the first subnet.
+	fwState  ruleState
+	natState ruleState
+}
+
+// Method adds, for each outgoing network interface, the FORWARD rules
+// (once) and the NAT rule of each IPv4 subnet. IPv6 subnets are skipped with a warning, as only iptables
+// (IPv4) rules are managed. The tables are listed once for every check,
+// then once more to verify the added rules, see verifyRules. If a rule
+// fails, the rules added before it are removed again.
+func (p *IpIntertfaceCommand) addRules() ([]Result, error) {
+	subnets := p.ipv4Subnets()
+	if len(subnets) == 0 {
+		return nil, nil
+	}
+
+	var plan []plannedRules
+	for _, outIface := range p.OutIfaces {
+		for indx, subnet := range subnets {
+			fwState, natState, err := getRules(&p.rules, p.InIface, outIface, subnet, "all")
+			if err != nil {
+				return nil, err
+			}
+			plan = append(plan, plannedRules{
+				outIface: outIface,
+				subnet:   subnet,
+				forward:  indx == 0,
+				fwState:  fwState,
+				natState: natState,
+			})
+		}
+	}
+
+	var results []Result
+	var rules []peermeta.Rule
+	var undo []string
+	rollback := func(err error) ([]Result, error) {
+		for _, cmd := range slices.Backward(undo) {
+			shell.DefaultRunner.Run(cmd, ShellStd)
+		}
+		return nil, err
+	}
+
+	// Function returns the result of a rule, skipped when it exists.
+	result := func(state ruleState, action, detail string) Result {
+		if state == ruleMissing {
+			return applied(action, p.InIface, detail)
+		}
+		return skipped(action, p.InIface, detail)
+	}
+
+	// Function returns the rule in the form it exists, an untagged rule
+	// added before the rules were tagged is recorded as such.
+	recorded := func(state ruleState, rule peermeta.Rule) peermeta.Rule {
+		if state == ruleUntagged {
+			return set.UntaggedRule(rule)
+		}
+		return rule
+	}
+
+	for _, step := range plan {
+		// The FORWARD rules do not depend on the subnet.
+		if step.forward {
+			if step.fwState == ruleMissing {
+				cmd := firewall.FormatCmdForward(firewall.Append, step.outIface, p.InIface)
+				if err := shell.DefaultRunner.Run(cmd, ShellStd); err != nil {
+					return rollback(err)
+				}
+				undo = append(undo, firewall.FormatCmdForward(firewall.Delete, step.outIface, p.InIface))
+			}
+			for _, rule := range set.ForwardRules(step.outIface, p.InIface) {
+				rules = append(rules, recorded(step.fwState, rule))
+			}
+			results = append(results, result(step.fwState, "forward-rule-add", step.outIface))
+		}
+
+		if step.natState == ruleMissing {
+			cmd := firewall.FormatCmdNat(firewall.Append, step.outIface, step.subnet, p.InIface)
+			if err := shell.DefaultRunner.Run(cmd, ShellStd); err != nil {
+				return rollback(err)
+			}
+			undo = append(undo, firewall.FormatCmdNat(firewall.Delete, step.outIface, step.subnet, p.InIface))
+		}
+		rules = append(rules, recorded(step.natState, set.NATRule(step.outIface, step.subnet, p.InIface)))
+		results = append(results, result(step.natState, "nat-rule-add", step.subnet+" -> "+step.outIface))
+	}
+
+	if len(undo) > 0 {
+		p.rules.Invalidate()
+		if err := p.verifyRules(plan); err != nil {
+			return rollback(err)
+		}
+	}
+
+	return results, set.RecordApplied(p.InIface, rules, nil, p.lock)
+}
+
+// Method checks that the rules added by addRules are listed by iptables.
+// A rule iptables accepted but does not list (e.g., the tables restored
+// meanwhile by another tool) would be recorded as applied while missing,
+// so it fails the command.
+func (p *IpIntertfaceCommand) verifyRules(plan []plannedRules) error {
+	for _, step := range plan {
+		if step.forward && step.fwState == ruleMissing {
+			rules, err := p.rules.Firewall()
+			if err != nil {
+				return err
+			}
+			state, err := existingRule(rules, "FORWARD", "ACCEPT", p.InIface, step.outIface, step.subnet)
+			if err != nil {
+				return err
+			}
+			if state != ruleTagged {
+				return help.Errorf(
+					help.CodeRuleNotListed,
+					"error: iptables accepted the FORWARD rules of network interface '%s' through '%s', but they are not listed",
+					p.InIface, step.outIface,
+				)
+			}
+		}
+
+		if step.natState == ruleMissing {
+			rules, err := p.rules.NAT()
+			if err != nil {
+				return err
+			}
+			state, err := existingRule(rules, "POSTROUTING", "MASQUERADE", p.InIface, step.outIface, step.subnet)
+			if err != nil {
+				return err
+			}
+			if state != ruleTagged {
+				return help.Errorf(
+					help.CodeRuleNotListed,
+					"error: iptables accepted the NAT rule of '%s' through '%s' of network interface '%s', but it is not listed",
+					step.subnet, step.outIface, p.InIface,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// Method returns the IPv4 subnets (masked) of the addresses and warns
+// about the skipped IPv6 ones.
+func (p *IpIntertfaceCommand) ipv4Subnets() []string {
+	var subnets []string
+	for _, value := range p.SubNets {
+		prefix := netip.MustParsePrefix(value).Masked()
+		if prefix.Addr().Is6() {
+			kind := "IPv6"
+			if ula.Contains(prefix.Addr()) {
+				kind = "unique local IPv6"
+			}
+			fmt.Fprintf(warnOut,
+				"warning: %s prefix '%s' skipped, firewall and NAT rules are managed for IPv4 (iptables) only\n",
+				kind, prefix,
+			)
+			continue
+		}
+		if subnet := prefix.String(); !slices.Contains(subnets, subnet) {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets
+}
+
+// State of an iptables rule of the interface, see getRules.
+type ruleState int
+
+const (
+	// No matching rule exists.
+	ruleMissing ruleState = iota
+
+	// The rule exists with the tag of the interface (see firewall.RuleTag).
+	ruleTagged
+
+	// The rule exists untagged, as added before the rules were tagged.
+	ruleUntagged
+)
+
+// Function checks for the existence of specified iptables firewall and/or NAT rules.
+// It queries the system for existing rules and filters them based on interface names and IP network.
+// The rules tagged for the interface are preferred; untagged rules are
+// matched as a fallback, rules tagged for other interfaces are ignored.
+//
+// Parameters:
+//
+//	snapshot: The tables listed by the command, see RuleSnapshot.
+//	inIface: The input network interface name.
+//	outIface: The output network interface name.
+//	ipNet: The IP network string (e.g., "10.0.0.0/24").
+//	rule: Specifies which type of rule to check: "fr" for firewall, "nat" for NAT, or "all" for both.
+//
+// Returns:
+//
+//	fwState: The state of the matching firewall rule.
+//	natState: The state of the matching NAT rule.
+//	error: An error if an invalid interface is detected or rule retrieval fails.
+func getRules(snapshot *RuleSnapshot, inIface, outIface, ipNet, rule string) (ruleState, ruleState, error) {
+
+	var fwState, natState ruleState
+
+	isExistIface, err := get.GetExistInterface(outIface)
+	if err != nil {
+		return ruleMissing, ruleMissing, err
+	}
+
+	if !isExistIface {
+		return ruleMissing, ruleMissing, &get.InterfaceNotFoundError{Name: outIface}
+	}
+
+	if rule == "fr" || rule == "all" {
+		getFw, err := snapshot.Firewall()
+		if err != nil {
+			return ruleMissing, ruleMissing, err
+		}
+
+		fwState, err = existingRule(getFw, "FORWARD", "ACCEPT", inIface, outIface, ipNet)
+		if err != nil {
+			return ruleMissing, ruleMissing, err
+		}
+
+	}
+
+	if rule == "nat" || rule == "all" {
+		getNat, err := snapshot.NAT()
+		if err != nil {
+			return ruleMissing, ruleMissing, err
+		}
+
+		natState, err = existingRule(getNat, "POSTROUTING", "MASQUERADE", inIface, outIface, ipNet)
+		if err != nil {
+			return ruleMissing, ruleMissing, err
+		}
+	}
+
+	return fwState, natState, nil
+}
+
+// Function checks for a rule of the interfaces and subnet among the rules
+// of the chain with the target: first among the rules tagged for inIface,
+// then among the untagged ones. A missing chain holds no rule.
+func existingRule(rules get.IptablesOutput, chainName, target, inIface, outIface, ipNet string) (ruleState, error) {
+	filter := get.FilterIptablesOutput{Rule: rules}
+	chain, err := filter.GetChain(chainName)
+	if err != nil {
+		chain = get.IptablesChain{Name: chainName}
+	}
+
+	filter = get.FilterIptablesOutput{Rule: get.IptablesOutput{Chains: []get.IptablesChain{chain}}}
+	filter = get.FilterIptablesOutput{Rule: filter.FilterByTarget(target)}
+
+	tagged := get.FilterIptablesOutput{Rule: filter.FilterByComment(firewall.RuleTag(inIface))}
+	exists, err := tagged.GetExistingRules(inIface, outIface, ipNet)
+	if err != nil || exists {
+		return ruleTagged, err
+	}
+
+	untagged := get.FilterIptablesOutput{Rule: filter.FilterByComment("")}
+	exists, err = untagged.GetExistingRules(inIface, outIface, ipNet)
+	if err != nil || !exists {
+		return ruleMissing, err
+	}
+	return ruleUntagged, nil
+}
+
+// Function removes the rules in the form of their state: tagged rules with
+// the tag, untagged rules without it.
+func deleteRules(state ruleState, rules []peermeta.Rule) error {
+	for _, rule := range rules {
+		if state == ruleUntagged {
+			rule = set.UntaggedRule(rule)
+		}
+		cmd := firewall.FormatCmdDelete(rule.Table, rule.Chain, rule.Spec)
+		if err := shell.DefaultRunner.Run(cmd, ShellStd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IpForwardingCommand encapsulates the data and logic for managing
+// IP packet forwarding (IPv4 and IPv6) at the system kernel level.
+type IpForwardingCommand struct {
+	Cmd    string
+	Family string // "ipv4" or "ipv6".
+	State  string // "enabled" or "disabled".
+	Strict bool   // Fail if forwarding is already in the state.
+}
+
+// Method parses the command-line arguments for the IP forwarding command.
+// It determines which sysctl command to execute for enabling or disabling
+// IPv4 or IPv6 forwarding based on the provided arguments.
+//
+// The optional trailing `-strict` flag fails the command if forwarding is
+// already in the requested state.
+//
+// It returns a string flag indicating the type of IP forwarding operation (IPv4/IPv6),
+// and an error if parsing fails.
+func (p *IpForwardingCommand) ParseArgs(args []string) (string, error) {
+
+	flag := fmt.Sprintf("%s | %s", help.ForwIpv4Flag, help.ForwIpv6Flag)
+	if len(args) == 3 && args[2] == help.StrictFlag {
+		p.Strict = true
+		args = args[:2]
+	}
+	if len(args) == 0 {
+		return flag, errors.New(help.DefaultErrorMessage)
+	}
+
+	cmdMap := map[string]string{
+		// IPv4
+		help.ForwIpv4Flag + help.AddFlag: shell.SysctlIpv4Up,
+		help.ForwIpv4Flag + help.DelFlag: shell.SysctlIpv4Down,
+
+		// IPv6
+		help.ForwIpv6Flag + help.AddFlag: shell.SysctlIpv6Up,
+		help.ForwIpv6Flag + help.DelFlag: shell.SysctlIpv6Down,
+	}
+
+	cmd, ok := cmdMap[strings.Join(args, "")]
+	if !ok {
+		return flag, errors.New("internal error: unrecognized forwarding key argument")
+	}
+
+	p.Cmd = cmd
+	p.Family = map[string]string{help.ForwIpv4Flag: "ipv4", help.ForwIpv6Flag: "ipv6"}[args[0]]
+	p.State = "disabled"
+	if args[len(args)-1] == help.AddFlag {
+		p.State = "enabled"
+	}
+
+	return flag, nil
+}
+
+// Method execute runs the configured sysctl command to manage IP forwarding
+// and then applies the sysctl rules. Forwarding already in the requested
+// state is left unchanged, see unchanged.
+func (p *IpForwardingCommand) Execute() ([]Result, error) {
+
+	want := 0
+	if p.State == "enabled" {
+		want = 1
+	}
+	if current, err := get.GetIPvForwarding(); err == nil {
+		if value, ok := current[p.Family]; ok && value == want {
+			result, err := unchanged(p.Strict, "forwarding", p.Family, fmt.Sprintf(
+				"%s forwarding is already %s", p.Family, p.State,
+			))
+			if err != nil {
+				return nil, err
+			}
+			return []Result{result}, nil
+		}
+	}
+
+	if err := shell.DefaultRunner.Run(p.Cmd, ShellStd); err != nil {
+		return nil, err
+	}
+
+	if err := shell.DefaultRunner.Run(shell.SysctlRules, ShellStd); err != nil {
+		return nil, err
+	}
+
+	return []Result{applied("forwarding", p.Family, p.State)}, nil
+}
+
+type FirewallPortCommand struct {
+	Cmd  string
+	Port string
+	In   string // Input interface of the rule, any when empty (-in).
+	Flag firewall.Action
+}
+
+// Method parses `-u -a|-d [port] [-in iface]`. With -in the rule only
+// accepts the port on that interface, see firewall.FormatCmdPortIn.
+func (p *FirewallPortCommand) ParseArgs(args []string) (string, error) {
+
+	if len(args) != 3 && len(args) != 5 {
+		errMsg := "error: invalid command arguments, please specify a port number"
+		return help.FirewallFlag, help.WithCode(help.CodeInvalidArguments, errors.New(errMsg))
+	}
+
+	if len(args) == 5 {
+		if args[3] != help.InInterfaceFlag {
+			return args[3], errors.New(help.DefaultErrorMessage)
+		}
+		if err := validate.CheckInterfaceName(args[4]); err != nil {
+			return help.InInterfaceFlag, err
+		}
+		p.In = args[4]
+	}
+
+	cmdMap := map[string]firewall.Action{
+		// Type: UDP
+		help.UpdateFlag + help.AddFlag: firewall.Append,
+		help.UpdateFlag + help.DelFlag: firewall.Delete,
+	}
+
+	port := args[2]
+	cmd, ok := cmdMap[args[0]+args[1]]
+	if !ok {
+		return fmt.Sprintf(
+			"%s %s %s",
+			help.FirewallFlag,
+			args[0],
+			args[1],
+		), errors.New("internal error: unrecognized firewall key argument")
+	}
+
+	_, err := validate.CheckPort(port)
+	if err != nil {
+		return help.FirewallFlag, err
+	}
+
+	p.Cmd = firewall.FormatCmdPortIn(cmd, port, p.In)
+	p.Port = port
+	p.Flag = cmd
+
+	return help.FirewallFlag, nil
+}
+
+// Method adds or deletes the port rule. An untagged rule, added before the
+// rules were tagged, is deleted when no tagged rule exists. The rule of
+// -in is told apart from the one of any interface for the same port.
+func (p *FirewallPortCommand) Execute() ([]Result, error) {
+	action := "port-rule-add"
+	if p.Flag == firewall.Delete {
+		action = "port-rule-delete"
+
+		state, err := portRule(p.Port, p.In)
+		if err != nil {
+			return nil, err
+		}
+		if state == ruleUntagged {
+			scope := ""
+			if p.In != "" {
+				scope = "-i " + p.In + " "
+			}
+			p.Cmd = firewall.FormatCmdDelete("filter", "INPUT", fmt.Sprintf("%s-p udp --dport %s -j ACCEPT", scope, p.Port))
+		}
+	}
+
+	if err := shell.DefaultRunner.Run(p.Cmd, ShellStd); err != nil {
+		return nil, err
+	}
+
+	subject := "udp"
+	if p.In != "" {
+		subject = "udp on " + p.In
+	}
+	return []Result{applied(action, p.Port, subject)}, nil
+}
+
+// Function returns the state of the INPUT rule accepting UDP traffic on the
+// port arriving on the input interface in, any when empty, see
+// firewall.FormatCmdPortIn.
+func portRule(port, in string) (ruleState, error) {
+	rules, err := get.GetIptablesFirewall()
+	if err != nil {
+		return ruleMissing, err
+	}
+
+	filter := get.FilterIptablesOutput{Rule: rules}
+	matching, err := filter.GetPortRules(port, in)
+	if err != nil {
+		return ruleMissing, err
+	}
+
+	state := ruleMissing
+	for _, rule := range matching {
+		switch rule.Comment {
+		case firewall.RuleTag(""):
+			return ruleTagged, nil
+		case "":
+			state = ruleUntagged
+		}
+	}
+	return state, nil
+}
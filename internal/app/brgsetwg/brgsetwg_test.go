@@ -0,0 +1,4629 @@
+package brgsetwg
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/internal/shellmock"
+	"github.com/AlexKira/brgnetuse/internal/uapi"
+	"github.com/AlexKira/brgnetuse/internal/uapimock"
+	"github.com/AlexKira/brgnetuse/internal/wgmock"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/proc"
+	"github.com/AlexKira/brgnetuse/src/provision"
+	"github.com/AlexKira/brgnetuse/src/reconcile"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/validate"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Function points the operation lock and the metadata directory at a
+// temporary directory for the tests.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "brgnetuse-lock-")
+	if err != nil {
+		panic(err)
+	}
+	oplock.Path = filepath.Join(dir, "brgnetuse.lock")
+	peermeta.Dir = filepath.Join(dir, "state")
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Function replaces the interface and backend lookups for the test. The
+// interfaces of userspace ("wg", "awg") are userspace devices, the others
+// kernel WireGuard devices. The caller is not in an SSH session.
+func stubLookups(t *testing.T, existing []string, userspace map[string]string) {
+	t.Helper()
+
+	prevExists, prevBackend, prevSocket := interfaceExists, interfaceBackend, awgSocket
+	prevNetworks, prevEnv, prevFamilies := interfaceNetworks, sessionEnv, interfaceFamilies
+	interfaceExists = func(name string) (bool, error) {
+		return slices.Contains(existing, name), nil
+	}
+	interfaceBackend = func(name string) (get.Backend, error) {
+		switch userspace[name] {
+		case help.Env_Awg_Type:
+			return get.BackendUserspaceAWG, nil
+		case help.Env_Wg_Type:
+			return get.BackendUserspaceWG, nil
+		}
+		return get.BackendKernelWG, nil
+	}
+	awgSocket = func(string) (string, bool) {
+		return "", false
+	}
+	interfaceNetworks = func(string) ([]netip.Prefix, error) {
+		return nil, nil
+	}
+	sessionEnv = func() map[string]string {
+		return map[string]string{}
+	}
+	interfaceFamilies = func(string) (addressFamilies, error) {
+		return addressFamilies{IPv4: true, IPv6: true}, nil
+	}
+	t.Cleanup(func() {
+		interfaceExists, interfaceBackend, awgSocket = prevExists, prevBackend, prevSocket
+		interfaceNetworks, sessionEnv, interfaceFamilies = prevNetworks, prevEnv, prevFamilies
+	})
+}
+
+// Function serves the UAPI socket of the AmneziaWG interface in-process,
+// as brgaddawg does. Call it after stubLookups.
+func useAwgSocket(t *testing.T, iface string) *uapimock.Server {
+	t.Helper()
+
+	server := uapimock.Serve(t, filepath.Join(t.TempDir(), iface+".sock"))
+	awgSocket = func(name string) (string, bool) {
+		return server.Path, name == iface
+	}
+	return server
+}
+
+// Function replaces the stdin read for the '-' arguments for the test.
+func useStdin(t *testing.T, content string) {
+	t.Helper()
+
+	prev := handlers.Stdin
+	handlers.Stdin = strings.NewReader(content)
+	t.Cleanup(func() { handlers.Stdin = prev })
+}
+
+// Function points the peer metadata at a temporary directory for the test.
+func useMetaDir(t *testing.T) {
+	t.Helper()
+
+	prev := peermeta.Dir
+	peermeta.Dir = t.TempDir()
+	t.Cleanup(func() { peermeta.Dir = prev })
+}
+
+// Function stubs the address families of the network interfaces, both
+// families for the interfaces not listed.
+func useFamilies(t *testing.T, families map[string]addressFamilies) {
+	t.Helper()
+
+	prev := interfaceFamilies
+	interfaceFamilies = func(name string) (addressFamilies, error) {
+		if f, ok := families[name]; ok {
+			return f, nil
+		}
+		return addressFamilies{IPv4: true, IPv6: true}, nil
+	}
+	t.Cleanup(func() { interfaceFamilies = prev })
+}
+
+const ipShowWg0 = `[{"ifindex":5,"ifname":"wg0","flags":["POINTOPOINT","NOARP","UP"],` +
+	`"addr_info":[{"family":"inet","local":"10.10.10.1","prefixlen":24,"scope":"global"},` +
+	`{"family":"inet6","local":"fd00::1","prefixlen":64,"scope":"global"}]}]`
+
+const ipShowRenamed = `[{"ifindex":5,"ifname":"wg-office","flags":["POINTOPOINT","NOARP","UP"],` +
+	`"addr_info":[{"family":"inet6","local":"fd00::1","prefixlen":64,"scope":"global"}]}]`
+
+// Testing the argument parsing of the rename command.
+func TestRenameParseArgs(t *testing.T) {
+	type testCase struct {
+		args      []string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{args: []string{"wg0", "-rn", "wg-office"}, wantError: false},
+		{args: []string{"wg0", "-rn", "wg1"}, wantError: false},
+		{args: []string{"wg0", "-rn"}, wantError: true},
+		{args: []string{"wg0", "-rn", "wg0"}, wantError: true},
+		{args: []string{"wg0", "-rn", "wg/office"}, wantError: true},
+		{args: []string{"wg0", "-rn", "wg office"}, wantError: true},
+		{args: []string{"wg0", "-rn", "a-very-long-interface"}, wantError: true},
+		{args: []string{"wg0", "-rn", ".."}, wantError: true},
+		{args: []string{"wg0", "-rn", "wg0@ns"}, wantError: true},
+		{args: []string{"wg-office", "-rn", "wg1"}, wantError: false},
+		{args: []string{"wg;0", "-rn", "wg1"}, wantError: true},
+		{args: []string{"wg0", "-rn", "wg1", "wg2"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := RenameInterfaceCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError != (err != nil) {
+				t.Errorf("error: args %q, got error %v, want error %t", tc.args, err, tc.wantError)
+			}
+		})
+	}
+}
+
+const ipShowWg0Down = `[{"ifindex":5,"ifname":"wg0","flags":["POINTOPOINT","NOARP"],` +
+	`"addr_info":[{"family":"inet","local":"10.10.10.1","prefixlen":24,"scope":"global"}]}]`
+
+const ipShowRenamedDown = `[{"ifindex":5,"ifname":"wg-office","flags":["POINTOPOINT","NOARP"],` +
+	`"addr_info":[{"family":"inet","local":"10.10.10.1","prefixlen":24,"scope":"global"}]}]`
+
+// Function prepares a rename of wg0: the lookups, an empty metadata
+// directory with peer metadata and a last change of wg0, and a fake runner
+// serving the ip listings of wg0 (up) and of the renamed link.
+func useRenameEnv(t *testing.T) *shellmock.Runner {
+	t.Helper()
+
+	stubLookups(t, []string{"wg0"}, nil)
+	useMetaDir(t)
+	if err := peermeta.Set("wg0", "AAAA=", peermeta.Meta{Name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := peermeta.RecordChange("wg0", "peer-add"); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := shellmock.Install(t)
+	fake.Outputs["ip -j addr show wg0"] = ipShowWg0
+	fake.Outputs["ip -j addr show wg-office"] = ipShowRenamed
+	return fake
+}
+
+// Function reports whether the peer metadata and the last change are
+// those of the network interface, and none are left under the other name.
+func metadataOf(t *testing.T, iface, other string) bool {
+	t.Helper()
+
+	metas, err := peermeta.Load(iface)
+	if err != nil || metas["AAAA="].Name != "alice" {
+		return false
+	}
+	if change, err := peermeta.LoadChange(iface); err != nil || change.Operation != "peer-add" {
+		return false
+	}
+	for _, path := range []string{peermeta.Path(other), peermeta.ChangePath(other)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			return false
+		}
+	}
+	return true
+}
+
+// Testing the down/rename/up/re-add sequence of the rename command, and
+// that the metadata follows the new name.
+func TestRenameExecute(t *testing.T) {
+	type testCase struct {
+		name   string
+		before string
+		after  string
+		want   []string
+	}
+
+	tests := []testCase{
+		{
+			name:   "up",
+			before: ipShowWg0,
+			after:  ipShowRenamed,
+			want: []string{
+				"ip link set wg0 down",
+				"ip link set wg0 name wg-office",
+				"ip link set wg-office up",
+				"ip addr add 10.10.10.1/24 dev wg-office",
+			},
+		},
+		{
+			name:   "down",
+			before: ipShowWg0Down,
+			after:  ipShowRenamedDown,
+			want:   []string{"ip link set wg0 name wg-office"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := useRenameEnv(t)
+			fake.Outputs["ip -j addr show wg0"] = tc.before
+			fake.Outputs["ip -j addr show wg-office"] = tc.after
+
+			cmd := RenameInterfaceCommand{Iface: "wg0", NewName: "wg-office"}
+			if _, err := cmd.Execute(); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			if got := purgeCommands(fake); !slices.Equal(got, tc.want) {
+				t.Errorf("error: got commands %q, want %q", got, tc.want)
+			}
+			if !metadataOf(t, "wg-office", "wg0") {
+				t.Error("error: the metadata did not follow the new name")
+			}
+		})
+	}
+}
+
+// Testing that a failed rename restores the name, the state and the
+// metadata of the interface.
+func TestRenameRollback(t *testing.T) {
+	type testCase struct {
+		name   string
+		failOn string
+		want   []string
+	}
+
+	tests := []testCase{
+		{
+			name:   "rename",
+			failOn: "ip link set wg0 name",
+			want: []string{
+				"ip link set wg0 down",
+				"ip link set wg0 name wg-office",
+				"ip link set wg0 up",
+			},
+		},
+		{
+			name:   "address",
+			failOn: "ip addr add 10.10.10.1/24 dev wg-office",
+			want: []string{
+				"ip link set wg0 down",
+				"ip link set wg0 name wg-office",
+				"ip link set wg-office up",
+				"ip addr add 10.10.10.1/24 dev wg-office",
+				"ip link set wg-office down",
+				"ip link set wg-office name wg0",
+				"ip link set wg0 up",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := useRenameEnv(t)
+			fake.Errors[tc.failOn] = errors.New("runtime error: " + tc.name + " failed")
+
+			cmd := RenameInterfaceCommand{Iface: "wg0", NewName: "wg-office"}
+			if _, err := cmd.Execute(); err == nil {
+				t.Fatal("error: expected error, got none")
+			}
+
+			if got := purgeCommands(fake); !slices.Equal(got, tc.want) {
+				t.Errorf("error: got commands %q, want %q", got, tc.want)
+			}
+			if !metadataOf(t, "wg0", "wg-office") {
+				t.Error("error: the metadata was not restored to the old name")
+			}
+		})
+	}
+}
+
+// Testing the refusal cases of the rename command, none may change the system.
+func TestRenameRefused(t *testing.T) {
+	type testCase struct {
+		name      string
+		existing  []string
+		userspace map[string]string
+		setup     func(t *testing.T, fake *shellmock.Runner)
+		wantCode  help.Code
+	}
+
+	tests := []testCase{
+		{name: "missing interface", existing: nil, wantCode: help.CodeInterfaceNotFound},
+		{name: "name taken", existing: []string{"wg0", "wg-office"}, wantCode: help.CodeInterfaceExists},
+		{name: "userspace wg", existing: []string{"wg0"}, userspace: map[string]string{"wg0": "wg"}, wantCode: help.CodeUnsupported},
+		{name: "userspace awg", existing: []string{"wg0"}, userspace: map[string]string{"wg0": "awg"}, wantCode: help.CodeUnsupported},
+		{
+			name:     "forward rules",
+			existing: []string{"wg0"},
+			setup: func(t *testing.T, fake *shellmock.Runner) {
+				fake.Outputs[firewall.CmdList] = iptablesFilterWg0
+			},
+			wantCode: help.CodeConflict,
+		},
+		{
+			name:     "masquerade rule",
+			existing: []string{"wg0"},
+			setup: func(t *testing.T, fake *shellmock.Runner) {
+				fake.Outputs[firewall.CmdListNat] = iptablesNatWg0
+			},
+			wantCode: help.CodeConflict,
+		},
+		{
+			name:     "metadata of the new name",
+			existing: []string{"wg0"},
+			setup: func(t *testing.T, fake *shellmock.Runner) {
+				if err := peermeta.RecordChange("wg-office", "peer-add"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantCode: help.CodeConflict,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stubLookups(t, tc.existing, tc.userspace)
+			useMetaDir(t)
+			fake := shellmock.Install(t)
+			if tc.setup != nil {
+				tc.setup(t, fake)
+			}
+
+			cmd := RenameInterfaceCommand{Iface: "wg0", NewName: "wg-office"}
+			_, err := cmd.Execute()
+			if code := help.CodeOf(err); code != tc.wantCode {
+				t.Fatalf("error: got code %q (%v), want %q", code, err, tc.wantCode)
+			}
+			if got := purgeCommands(fake); len(got) != 0 {
+				t.Errorf("error: unexpected commands %q", got)
+			}
+		})
+	}
+}
+
+// Testing that the commands report a missing network interface with the
+// code of help.CodeInterfaceNotFound, before touching the system.
+func TestMissingInterfaceCode(t *testing.T) {
+	type testCase struct {
+		name string
+		cmd  Command
+	}
+
+	tests := []testCase{
+		{name: "port auto", cmd: &UpdateInterfaceCommand{Iface: "wg0", Value: "auto", PortRule: true, FlagCmd: help.PortFlag}},
+		{name: "rename", cmd: &RenameInterfaceCommand{Iface: "wg0", NewName: "wg-office"}},
+		{name: "dns", cmd: &DnsCommand{Iface: "wg0", Servers: []string{"1.1.1.1"}, FlagCmd: help.AddFlag}},
+		{name: "prune", cmd: &PruneCommand{Iface: "wg0"}},
+		{name: "hairpin", cmd: &HairpinCommand{InIface: "wg0", SubNets: []string{"10.10.10.0/24"}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stubLookups(t, nil, nil)
+			fake := shellmock.Install(t)
+
+			_, err := tc.cmd.Execute()
+			if code := help.CodeOf(err); code != help.CodeInterfaceNotFound {
+				t.Errorf("error: got code %q (%v), want %q", code, err, help.CodeInterfaceNotFound)
+			}
+			if len(fake.Commands) != 0 {
+				t.Errorf("error: unexpected commands %q", fake.Commands)
+			}
+		})
+	}
+}
+
+// Testing the extraction of the peer name and expiry from the peer command arguments.
+func TestPeerParseName(t *testing.T) {
+	type testCase struct {
+		args      []string
+		name      string
+		allowIps  []string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{
+			args:     []string{"wg0", "-pr", "AAAA=", "-a", "10.0.0.1/32", "-name", "alice-laptop"},
+			name:     "alice-laptop",
+			allowIps: []string{"10.0.0.1/32"},
+		},
+		{
+			args:     []string{"wg0", "-pr", "AAAA=", "-a", "10.0.0.1/32", "-name", "alice", "-kp", "10"},
+			name:     "alice",
+			allowIps: []string{"10.0.0.1/32"},
+		},
+		{
+			args:     []string{"wg0", "-pr", "AAAA=", "-a", "10.0.0.1/32"},
+			allowIps: []string{"10.0.0.1/32"},
+		},
+		{
+			args:      []string{"wg0", "-pr", "AAAA=", "-a", "10.0.0.1/32", "-name"},
+			wantError: true,
+		},
+		{
+			args:     []string{"wg0", "-pr", "AAAA=", "-a", "10.0.0.1/32", "-expires", "2025-07-01", "-name", "bob"},
+			name:     "bob",
+			allowIps: []string{"10.0.0.1/32"},
+		},
+		{
+			args:      []string{"wg0", "-pr", "AAAA=", "-a", "10.0.0.1/32", "-expires", "July"},
+			wantError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := PeerCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if cmd.Name != tc.name || !slices.Equal(cmd.AllowIps, tc.allowIps) {
+				t.Errorf("error: got name %q, allowed ips %q", cmd.Name, cmd.AllowIps)
+			}
+		})
+	}
+}
+
+// Testing the extraction of the rate limit from the peer command arguments.
+func TestPeerParseLimit(t *testing.T) {
+	type testCase struct {
+		args      []string
+		limit     string
+		rate      uint64
+		flagCmd   string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{
+			args:  []string{"wg0", "-pr", "AAAA=", "-limit", "10mbit"},
+			limit: "10mbit",
+			rate:  10_000_000,
+		},
+		{
+			args:  []string{"wg0", "-pr", "AAAA=", "-limit", "off"},
+			limit: "off",
+		},
+		{
+			args:    []string{"wg0", "-pr", "AAAA=", "-a", "10.0.0.1/32", "-limit", "512kbit"},
+			limit:   "512kbit",
+			rate:    512_000,
+			flagCmd: help.AddFlag,
+		},
+		{
+			args:      []string{"wg0", "-pr", "AAAA=", "-limit", "fast"},
+			wantError: true,
+		},
+		{
+			args:      []string{"wg0", "-pr", "AAAA=", "-limit"},
+			wantError: true,
+		},
+		{
+			args:      []string{"wg0", "-pr", "AAAA=", "-d", "-limit", "10mbit"},
+			wantError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := PeerCommand{}
+			flag, err := cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				if flag != help.LimitFlag {
+					t.Errorf("error: got flag %q, want %q", flag, help.LimitFlag)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if cmd.Limit != tc.limit || cmd.Rate != tc.rate || cmd.FlagCmd != tc.flagCmd {
+				t.Errorf("error: got limit %q, rate %d, command %q", cmd.Limit, cmd.Rate, cmd.FlagCmd)
+			}
+		})
+	}
+}
+
+// Testing the argument parsing of the key rotation.
+func TestUpdateParseRotate(t *testing.T) {
+	type testCase struct {
+		args      []string
+		rotate    bool
+		out       string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{args: []string{"wg0", "-u", "-pk", "-rotate"}, rotate: true},
+		{args: []string{"wg0", "-u", "-pk", "-rotate", "-out", "/tmp/wg0.key"}, rotate: true, out: "/tmp/wg0.key"},
+		{args: []string{"wg0", "-u", "-pk", "-rotate", "-out"}, wantError: true},
+		{args: []string{"wg0", "-u", "-pk", "-rotate", "/tmp/wg0.key"}, wantError: true},
+		{args: []string{"wg0", "-u", "-pk"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := UpdateInterfaceCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if cmd.Rotate != tc.rotate || cmd.OutPath != tc.out || !cmd.PrivateKey.IsEmpty() {
+				t.Errorf("error: got %+v", cmd)
+			}
+		})
+	}
+}
+
+// Testing the argument parsing of the port and firewall mark updates.
+func TestUpdateParsePortFwMark(t *testing.T) {
+	type testCase struct {
+		args      []string
+		want      UpdateInterfaceCommand
+		wantError bool
+	}
+
+	tests := []testCase{
+		{
+			args: []string{"wg0", "-u", "-p", "51821"},
+			want: UpdateInterfaceCommand{Iface: "wg0", Value: "51821", FlagCmd: help.PortFlag},
+		},
+		{
+			args: []string{"wg0", "-u", "-p", "51821", "-force"},
+			want: UpdateInterfaceCommand{Iface: "wg0", Value: "51821", Force: true, FlagCmd: help.PortFlag},
+		},
+		{
+			args: []string{"wg0", "-u", "-fwmark", "51820"},
+			want: UpdateInterfaceCommand{Iface: "wg0", FwMark: "51820", FlagCmd: help.FwMarkFlag},
+		},
+		{
+			args: []string{"wg0", "-u", "-fwmark", "0xca6c", "-p", "51821"},
+			want: UpdateInterfaceCommand{Iface: "wg0", Value: "51821", FwMark: "51820", FlagCmd: help.PortFlag},
+		},
+		{
+			args: []string{"wg0", "-u", "-p", "auto", "-fr"},
+			want: UpdateInterfaceCommand{Iface: "wg0", Value: "auto", PortRule: true, FlagCmd: help.PortFlag},
+		},
+		{args: []string{"wg0", "-u", "-fr"}, wantError: true},
+		{args: []string{"wg0", "-u", "-p", "0", "-fr"}, wantError: true},
+		{args: []string{"wg0", "-u", "-p", "auto", "-fr", "-fr"}, wantError: true},
+		{args: []string{"wg0", "-u", "-fwmark"}, wantError: true},
+		{args: []string{"wg0", "-u", "-fwmark", "-1"}, wantError: true},
+		{args: []string{"wg0", "-u", "-fwmark", "4294967296"}, wantError: true},
+		{args: []string{"wg0", "-u", "-fwmark", "1", "-fwmark", "2"}, wantError: true},
+		{args: []string{"wg0", "-u", "-pk", "AAAA=", "-fwmark", "1"}, wantError: true},
+		{args: []string{"wg0", "-u", "-fwmark", "1", "-force"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := UpdateInterfaceCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error, got %+v", cmd)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(cmd, tc.want) {
+				t.Errorf("error: got %+v, want %+v", cmd, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the port and firewall mark updates of an AmneziaWG interface.
+func TestUpdatePortFwMarkAwg(t *testing.T) {
+	stubLookups(t, []string{"awg0"}, map[string]string{"awg0": help.Env_Awg_Type})
+
+	fake := shellmock.Install(t)
+	cmd := UpdateInterfaceCommand{Iface: "awg0", Value: "51821", FwMark: "51820", Force: true, FlagCmd: help.PortFlag}
+	if _, err := cmd.Execute(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	want := []string{
+		shell.FormatCmdAwgShowDump("awg0"),
+		shell.FormatCmdAwgUpdatePort("awg0", "51821"),
+		shell.FormatCmdAwgUpdateFwMark("awg0", "51820"),
+	}
+	if !slices.Equal(fake.Commands, want) {
+		t.Errorf("error: got commands %q, want %q", fake.Commands, want)
+	}
+}
+
+// Testing that a port update with -fr moves the INPUT rule with the port,
+// and rolls it back when the port is refused.
+func TestUpdatePortRule(t *testing.T) {
+	type testCase struct {
+		name        string
+		iface       string
+		portErr     bool
+		wantResults []string
+		wantPorts   []string // Open ports after the command.
+		wantError   bool
+	}
+
+	tests := []testCase{
+		{
+			name: "wireguard", iface: "wg0",
+			wantResults: []string{"port-rule-add 51900 applied", "listen-port wg0 applied", "port-rule-delete 51820 applied"},
+			wantPorts:   []string{"51900"},
+		},
+		{
+			name: "amneziawg", iface: "awg0",
+			wantResults: []string{"port-rule-add 51900 applied", "listen-port awg0 applied", "port-rule-delete 51820 applied"},
+			wantPorts:   []string{"51900"},
+		},
+		{name: "amneziawg refused", iface: "awg0", portErr: true, wantPorts: []string{"51820"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stubLookups(t, []string{tc.iface}, map[string]string{"awg0": help.Env_Awg_Type})
+			wgmock.Install(t, &wgtypes.Device{Name: "wg0", ListenPort: 51820})
+			prevNote := noteOut
+			noteOut = io.Discard
+			t.Cleanup(func() { noteOut = prevNote })
+
+			fake := shellmock.Install(t)
+			useIptablesState(fake)
+			fake.Hook(firewall.FormatCmdPort(firewall.Append, "51820"))
+			fake.Outputs[shell.FormatCmdAwgShowDump("awg0")] = "priv\tpub\t51820\toff\n"
+			if tc.portErr {
+				fake.Errors[shell.FormatCmdAwgUpdatePort("awg0", "51900")] = errors.New("exit status 1")
+			}
+
+			cmd := UpdateInterfaceCommand{Iface: tc.iface, Value: "51900", PortRule: true, FlagCmd: help.PortFlag}
+			results, err := cmd.Execute()
+			if tc.wantError != (err != nil) {
+				t.Fatalf("error: got error %v, want error %v", err, tc.wantError)
+			}
+
+			var got []string
+			for _, result := range results {
+				got = append(got, result.Action+" "+result.Target+" "+result.Status)
+			}
+			if !slices.Equal(got, tc.wantResults) {
+				t.Errorf("error: got results %q, want %q", got, tc.wantResults)
+			}
+
+			var open []string
+			for _, port := range []string{"51820", "51900"} {
+				if strings.Contains(fake.Outputs[firewall.CmdList], "dpt:"+port+" ") {
+					open = append(open, port)
+				}
+			}
+			if !slices.Equal(open, tc.wantPorts) {
+				t.Errorf("error: got open ports %v, want %v\n%s", open, tc.wantPorts, fake.Outputs[firewall.CmdList])
+			}
+		})
+	}
+}
+
+// Testing the key rotation of a kernel WireGuard interface.
+func TestUpdateRotate(t *testing.T) {
+	stubLookups(t, []string{"wg0"}, nil)
+	useMetaDir(t)
+
+	oldKey, _ := wgtypes.GeneratePrivateKey()
+	mock := wgmock.Install(t, &wgtypes.Device{
+		Name:       "wg0",
+		PrivateKey: oldKey,
+		PublicKey:  oldKey.PublicKey(),
+	})
+
+	out := filepath.Join(t.TempDir(), "wg0.key")
+	cmd := UpdateInterfaceCommand{Iface: "wg0", FlagCmd: help.PrivateKeyFlag, Rotate: true, OutPath: out}
+	if _, err := cmd.Execute(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	device, _ := mock.Device("wg0")
+	if device.PrivateKey == oldKey {
+		t.Fatal("error: private key was not rotated")
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != device.PrivateKey.String() {
+		t.Errorf("error: key file does not hold the new private key")
+	}
+	if info, _ := os.Stat(out); info.Mode().Perm() != 0o600 {
+		t.Errorf("error: got key file mode %v, want 0600", info.Mode().Perm())
+	}
+
+	meta, _ := peermeta.LoadInterface("wg0")
+	if meta.KeyRotated.IsZero() || meta.PreviousPublicKey != oldKey.PublicKey().String() {
+		t.Errorf("error: rotation was not recorded: %+v", meta)
+	}
+}
+
+// Testing that the rotation is refused when the device cannot be read or
+// silently ignores the new key.
+func TestUpdateRotateUnconfirmed(t *testing.T) {
+	stubLookups(t, []string{"wg0"}, nil)
+	useMetaDir(t)
+
+	mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+	mock.Ignore = true
+
+	cmd := UpdateInterfaceCommand{Iface: "wg0", FlagCmd: help.PrivateKeyFlag, Rotate: true}
+	if _, err := cmd.Execute(); err == nil {
+		t.Fatal("error: expected error for an unconfirmed key, got none")
+	}
+
+	mock.Ignore = false
+	mock.DeviceErr = errors.New("device unavailable")
+	if _, err := cmd.Execute(); err == nil {
+		t.Fatal("error: expected error for an unreadable device, got none")
+	}
+	if len(mock.Calls) != 1 {
+		t.Errorf("error: got %d configure calls, want 1", len(mock.Calls))
+	}
+}
+
+// Testing that the CLI verifies the private key and the peers it applies
+// on a device which silently ignores the changes.
+func TestVerifyApplied(t *testing.T) {
+	peerKey, _ := wgtypes.GeneratePrivateKey()
+
+	type testCase struct {
+		name string
+		cmd  Command
+	}
+
+	tests := []testCase{
+		{name: "private key", cmd: &UpdateInterfaceCommand{Iface: "wg0", FlagCmd: help.PrivateKeyFlag}},
+		{name: "peer add", cmd: &PeerCommand{Iface: "wg0", Publickey: peerKey.PublicKey().String(), AllowIps: []string{"10.10.10.2/32"}, FlagCmd: help.AddFlag}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stubLookups(t, []string{"wg0"}, nil)
+			useMetaDir(t)
+
+			mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+			mock.Ignore = true
+
+			_, err := tc.cmd.Execute()
+			var verifyErr *set.VerificationError
+			if !errors.As(err, &verifyErr) {
+				t.Fatalf("error: got error %v, want a verification error", err)
+			}
+		})
+	}
+}
+
+// Testing the key rotation of an AmneziaWG interface.
+func TestUpdateRotateAwg(t *testing.T) {
+	stubLookups(t, []string{"wg0"}, map[string]string{"wg0": "awg"})
+	useMetaDir(t)
+
+	oldKey, _ := wgtypes.GeneratePrivateKey()
+	fake := shellmock.Install(t)
+	fake.Outputs["awg show wg0 public-key"] = oldKey.PublicKey().String() + "\n"
+
+	// The fake device applies the new key, so the read back reports it.
+	fake.Hook = func(cmd string) {
+		if key, ok := strings.CutPrefix(cmd, "awg set wg0 private-key <(echo '"); ok {
+			priv, _ := wgtypes.ParseKey(strings.TrimSuffix(key, "')"))
+			fake.Outputs["awg show wg0 public-key"] = priv.PublicKey().String()
+		}
+	}
+
+	cmd := UpdateInterfaceCommand{Iface: "wg0", FlagCmd: help.PrivateKeyFlag, Rotate: true}
+	if _, err := cmd.Execute(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if len(fake.Matching("awg set wg0 private-key")) != 1 {
+		t.Errorf("error: got commands %q", fake.Commands)
+	}
+
+	meta, _ := peermeta.LoadInterface("wg0")
+	if meta.PreviousPublicKey != oldKey.PublicKey().String() || meta.PublicKey == meta.PreviousPublicKey {
+		t.Errorf("error: rotation was not recorded: %+v", meta)
+	}
+}
+
+// Testing that an invalid private key is rejected before touching the device.
+func TestUpdateInvalidPrivateKey(t *testing.T) {
+	stubLookups(t, []string{"wg0"}, nil)
+	mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+
+	for _, value := range []string{"qwerty", "c2VjcmV0a2V5", "!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!="} {
+		cmd := UpdateInterfaceCommand{Iface: "wg0", FlagCmd: help.PrivateKeyFlag, PrivateKey: handlers.NewSecret(value)}
+		_, err := cmd.Execute()
+		if err == nil {
+			t.Fatalf("error: expected error for %q, got none", value)
+		}
+		if strings.Contains(err.Error(), value) {
+			t.Errorf("error: key material leaked into the error: %v", err)
+		}
+	}
+
+	if len(mock.Calls) != 0 {
+		t.Errorf("error: got %d configure calls, want 0", len(mock.Calls))
+	}
+}
+
+// Testing that failures along the key update paths never return the key.
+func TestUpdatePrivateKeyRedaction(t *testing.T) {
+	key, _ := wgtypes.GeneratePrivateKey()
+
+	type testCase struct {
+		name      string
+		userspace map[string]string
+		setup     func(t *testing.T)
+	}
+
+	tests := []testCase{
+		{
+			name: "wgctrl",
+			setup: func(t *testing.T) {
+				mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+				mock.ConfigureErr = errors.New("rejected private-key " + key.String())
+			},
+		},
+		{
+			name:      "awg",
+			userspace: map[string]string{"wg0": "awg"},
+			setup: func(t *testing.T) {
+				fake := shellmock.Install(t)
+				fake.Errors["awg set wg0 private-key"] = errors.New(
+					"runtime error: [awg set wg0 private-key <(echo '" + key.String() + "')], exit status 1")
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stubLookups(t, []string{"wg0"}, tc.userspace)
+			tc.setup(t)
+
+			cmd := UpdateInterfaceCommand{
+				Iface:      "wg0",
+				FlagCmd:    help.PrivateKeyFlag,
+				PrivateKey: handlers.NewSecret(key.String()),
+			}
+			_, err := cmd.Execute()
+			if err == nil {
+				t.Fatal("error: expected error, got none")
+			}
+			if strings.Contains(err.Error(), key.String()) {
+				t.Errorf("error: key material leaked: %v", err)
+			}
+			if !slices.Equal(cmd.PrivateKey.Bytes(), make([]byte, len(cmd.PrivateKey.Bytes()))) {
+				t.Error("error: private key buffer was not scrubbed")
+			}
+		})
+	}
+}
+
+const iptablesFilterWg0 = `Chain INPUT (policy ACCEPT 0 packets, 0 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    0     0 ACCEPT     udp  --  *      *       0.0.0.0/0            0.0.0.0/0            udp dpt:51820 /* brgnetuse */
+    0     0 ACCEPT     tcp  --  *      *       0.0.0.0/0            0.0.0.0/0            tcp dpt:22
+
+Chain FORWARD (policy DROP 0 packets, 0 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    0     0 ACCEPT     all  --  eth0   wg0     0.0.0.0/0            0.0.0.0/0            /* brgnetuse:wg0 */
+    0     0 ACCEPT     all  --  wg0    eth0    0.0.0.0/0            0.0.0.0/0            /* brgnetuse:wg0 */
+    0     0 ACCEPT     all  --  eth0   wg1     0.0.0.0/0            0.0.0.0/0            /* brgnetuse:wg1 */
+    0     0 ACCEPT     all  --  wg0    eth1    0.0.0.0/0            0.0.0.0/0
+`
+
+const iptablesNatWg0 = `Chain POSTROUTING (policy ACCEPT 0 packets, 0 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    0     0 MASQUERADE  all  --  any    eth0    10.10.10.0/24        anywhere             /* brgnetuse:wg0 */
+    0     0 MASQUERADE  all  --  any    eth0    10.20.0.0/24         anywhere
+`
+
+// Function prepares a purge scenario: a fake runner serving the ip and
+// iptables listings, temporary proc, socket and metadata directories,
+// and an optional userspace process serving wg0.
+func usePurgeEnv(t *testing.T, existing []string, pid int) (*shellmock.Runner, string) {
+	t.Helper()
+
+	stubLookups(t, existing, nil)
+	useMetaDir(t)
+
+	prevProc, prevDirs, prevWait := proc.ProcDir, uapiSocketDirs, purgeLinkWait
+	proc.ProcDir = t.TempDir()
+	socketDir := t.TempDir()
+	uapiSocketDirs = []string{socketDir}
+	purgeLinkWait = 0
+	t.Cleanup(func() {
+		proc.ProcDir, uapiSocketDirs, purgeLinkWait = prevProc, prevDirs, prevWait
+	})
+
+	if pid != 0 {
+		dir := filepath.Join(proc.ProcDir, strconv.Itoa(pid))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		env := proc.EnvFieldTag + "=wg0\x00" + proc.EnvFieldType + "=wg\x00"
+		if err := os.WriteFile(filepath.Join(dir, "environ"), []byte(env), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.FormatCmdIpShowJSON("wg0")] = ipShowWg0
+	fake.Outputs[firewall.CmdList] = iptablesFilterWg0
+	fake.Outputs[firewall.CmdListNat] = iptablesNatWg0
+
+	return fake, socketDir
+}
+
+// Function returns the commands changing the system state, without the listings.
+func purgeCommands(fake *shellmock.Runner) []string {
+	var result []string
+	for _, cmd := range fake.Commands {
+		if strings.HasPrefix(cmd, "ip -j") || strings.Contains(cmd, " -L ") {
+			continue
+		}
+		result = append(result, cmd)
+	}
+	return result
+}
+
+// Testing the purge of an interface with its complete state.
+func TestPurgeComplete(t *testing.T) {
+	fake, socketDir := usePurgeEnv(t, []string{"wg0"}, 4242)
+	wgmock.Install(t, &wgtypes.Device{Name: "wg0", ListenPort: 51820})
+
+	socket := filepath.Join(socketDir, "wg0.sock")
+	if err := os.WriteFile(socket, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := peermeta.Set("wg0", "AAAA=", peermeta.Meta{Name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if _, err := purgeInterface("wg0", false, &out); err != nil {
+		t.Fatalf("error: unexpected error: %v\n%s", err, out.String())
+	}
+
+	want := []string{
+		`iptables -t nat -D POSTROUTING -s 10.10.10.0/24 -o eth0 -m comment --comment "brgnetuse:wg0" -j MASQUERADE`,
+		`iptables -D INPUT -p udp --dport 51820 -m comment --comment "brgnetuse" -j ACCEPT`,
+		`iptables -D FORWARD -i eth0 -o wg0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`,
+		`iptables -D FORWARD -i wg0 -o eth0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`,
+		"ip addr del 10.10.10.1/24 dev wg0",
+		"ip addr del fd00::1/64 dev wg0",
+		"kill -TERM 4242",
+		"ip link delete wg0",
+	}
+	if got := purgeCommands(fake); !slices.Equal(got, want) {
+		t.Errorf("error: got commands\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("error: socket was not removed: %v", err)
+	}
+	if _, err := os.Stat(peermeta.Path("wg0")); !os.IsNotExist(err) {
+		t.Errorf("error: metadata was not removed: %v", err)
+	}
+	if !strings.Contains(out.String(), "purge wg0: 10 removed, 0 failed") {
+		t.Errorf("error: unexpected summary:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "keeping filter FORWARD rule '-i wg0 -o eth1 -j ACCEPT', not created by brgnetuse") {
+		t.Errorf("error: the untagged rule was not reported as kept:\n%s", out.String())
+	}
+}
+
+// Testing the purge of leftovers after the link is already gone.
+func TestPurgePartial(t *testing.T) {
+	fake, socketDir := usePurgeEnv(t, nil, 0)
+
+	socket := filepath.Join(socketDir, "wg0.sock")
+	if err := os.WriteFile(socket, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if _, err := purgeInterface("wg0", false, &out); err != nil {
+		t.Fatalf("error: unexpected error: %v\n%s", err, out.String())
+	}
+
+	// The tag finds the NAT rule without the addresses of the link.
+	want := []string{
+		`iptables -t nat -D POSTROUTING -s 10.10.10.0/24 -o eth0 -m comment --comment "brgnetuse:wg0" -j MASQUERADE`,
+		`iptables -D FORWARD -i eth0 -o wg0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`,
+		`iptables -D FORWARD -i wg0 -o eth0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`,
+	}
+	if got := purgeCommands(fake); !slices.Equal(got, want) {
+		t.Errorf("error: got commands %q, want %q", got, want)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("error: stale socket was not removed: %v", err)
+	}
+}
+
+// Testing that the purge continues past a failed action.
+func TestPurgeContinuesOnFailure(t *testing.T) {
+	fake, _ := usePurgeEnv(t, nil, 0)
+	fake.Errors["iptables -D FORWARD -i eth0 -o wg0"] = errors.New("runtime error: Bad rule")
+
+	var out strings.Builder
+	_, err := purgeInterface("wg0", false, &out)
+	if err == nil {
+		t.Fatal("error: expected error for a failed action")
+	}
+
+	if len(fake.Matching("iptables -D FORWARD -i wg0 -o eth0")) != 1 {
+		t.Errorf("error: the purge stopped after the failed action")
+	}
+	if !strings.Contains(out.String(), "purge wg0: 2 removed, 1 failed") {
+		t.Errorf("error: unexpected summary:\n%s", out.String())
+	}
+}
+
+// Testing that the untagged rules are only removed when recorded in the
+// interface metadata, as added by brgsetwg before the rules were tagged.
+func TestPurgeUntaggedRules(t *testing.T) {
+	fake, _ := usePurgeEnv(t, nil, 0)
+	fake.Outputs[firewall.CmdList] = `Chain FORWARD (policy DROP 0 packets, 0 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    0     0 ACCEPT     all  --  eth0   wg0     0.0.0.0/0            0.0.0.0/0
+    0     0 ACCEPT     all  --  wg0    eth0    0.0.0.0/0            0.0.0.0/0
+    0     0 ACCEPT     all  --  wg0    eth1    0.0.0.0/0            0.0.0.0/0            /* admin */
+`
+	fake.Outputs[firewall.CmdListNat] = ""
+
+	rules := set.ForwardRules("eth0", "wg0")
+	if err := set.RecordApplied("wg0", []peermeta.Rule{set.UntaggedRule(rules[0])}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if _, err := purgeInterface("wg0", false, &out); err != nil {
+		t.Fatalf("error: unexpected error: %v\n%s", err, out.String())
+	}
+
+	want := []string{"iptables -D FORWARD -i eth0 -o wg0 -j ACCEPT"}
+	if got := purgeCommands(fake); !slices.Equal(got, want) {
+		t.Errorf("error: got commands %q, want %q", got, want)
+	}
+	for _, kept := range []string{"'-i wg0 -o eth0 -j ACCEPT'", `'-i wg0 -o eth1 -m comment --comment "admin" -j ACCEPT'`} {
+		if !strings.Contains(out.String(), "keeping filter FORWARD rule "+kept) {
+			t.Errorf("error: output misses the kept rule %s:\n%s", kept, out.String())
+		}
+	}
+}
+
+// Testing that a dry run only lists the actions.
+func TestPurgeDryRun(t *testing.T) {
+	fake, socketDir := usePurgeEnv(t, []string{"wg0"}, 4242)
+	wgmock.Install(t, &wgtypes.Device{Name: "wg0", ListenPort: 51820})
+
+	socket := filepath.Join(socketDir, "wg0.sock")
+	if err := os.WriteFile(socket, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if _, err := purgeInterface("wg0", true, &out); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if got := purgeCommands(fake); len(got) != 0 {
+		t.Errorf("error: dry run executed %q", got)
+	}
+	if _, err := os.Stat(socket); err != nil {
+		t.Errorf("error: dry run removed the socket: %v", err)
+	}
+	for _, want := range []string{"would remove wg process 4242", "would remove link wg0", "(dry run)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("error: output misses %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// Testing the argument parsing of the purge command.
+func TestPurgeParseArgs(t *testing.T) {
+	type testCase struct {
+		args      []string
+		wantDry   bool
+		wantError bool
+	}
+
+	tests := []testCase{
+		{args: []string{"wg0", "-purge"}},
+		{args: []string{"wg0", "-purge", "-dry"}, wantDry: true},
+		{args: []string{"wg0", "-purge", "-force"}, wantError: true},
+		{args: []string{"wg$", "-purge"}, wantError: true},
+		{args: []string{"wg0"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := PurgeCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError != (err != nil) {
+				t.Fatalf("error: got error %v, want error %t", err, tc.wantError)
+			}
+			if cmd.Dry != tc.wantDry {
+				t.Errorf("error: got dry %t, want %t", cmd.Dry, tc.wantDry)
+			}
+		})
+	}
+}
+
+// Testing the argument parsing of the adopt command.
+func TestAdoptParseArgs(t *testing.T) {
+	type testCase struct {
+		args      []string
+		wantDry   bool
+		wantError bool
+	}
+
+	tests := []testCase{
+		{args: []string{"wg0", "-adopt"}},
+		{args: []string{"wg0", "-adopt", "-dry"}, wantDry: true},
+		{args: []string{"wg0", "-adopt", "-force"}, wantError: true},
+		{args: []string{"wg$", "-adopt"}, wantError: true},
+		{args: []string{"wg0", "-adopt", "-dry", "-dry"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := AdoptCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError != (err != nil) {
+				t.Fatalf("error: got error %v, want error %t", err, tc.wantError)
+			}
+			if cmd.Dry != tc.wantDry || cmd.ReadOnly() != tc.wantDry {
+				t.Errorf("error: got dry %t, want %t", cmd.Dry, tc.wantDry)
+			}
+		})
+	}
+}
+
+// Testing that a dry run of the adoption only lists the rules to tag and
+// the rules to confirm, and that a missing interface is reported.
+func TestAdoptDryRun(t *testing.T) {
+	stubLookups(t, []string{"wg0"}, nil)
+	useMetaDir(t)
+	wgmock.Install(t, &wgtypes.Device{Name: "wg0", Type: wgtypes.LinuxKernel, ListenPort: 51820})
+
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.FormatCmdIpShowJSON("wg0")] = ipShowWg0
+	fake.Outputs[firewall.CmdSave] = "*filter\n" +
+		"-A FORWARD -i wg0 -j ACCEPT\n" +
+		"-A FORWARD -i wg0 -o eth0 -j ACCEPT\n" +
+		"COMMIT\n"
+
+	var out strings.Builder
+	results, err := adoptInterface("wg0", true, &out)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if got := fake.Matching("iptables-restore"); len(got) != 0 {
+		t.Errorf("error: dry run executed %q", got)
+	}
+	for _, result := range results {
+		if result.Status != StatusSkipped {
+			t.Errorf("error: dry run got result %+v", result)
+		}
+	}
+	for _, want := range []string{
+		"would tag filter FORWARD rule '-i wg0 -o eth0 -j ACCEPT'",
+		"not adopted filter FORWARD rule '-i wg0 -j ACCEPT'",
+		"brgnetuse:wg0",
+		"(dry run)",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("error: output misses %q:\n%s", want, out.String())
+		}
+	}
+
+	var notFound *get.InterfaceNotFoundError
+	if _, err := adoptInterface("wg1", true, &out); !errors.As(err, &notFound) {
+		t.Errorf("error: got %v, want interface not found", err)
+	}
+}
+
+// Testing that the addresses and rules applied for an interface are
+// recorded for the -cleanup shutdown of brgaddwg and forgotten on removal.
+func TestIpInterfaceRecordsApplied(t *testing.T) {
+	useMetaDir(t)
+	useFamilies(t, nil)
+	fake := shellmock.Install(t)
+	fake.Outputs[firewall.CmdList] = ""
+	fake.Outputs[firewall.CmdListNat] = ""
+	useIptablesState(fake)
+
+	run := func(flagCmd string) {
+		t.Helper()
+		cmd := IpIntertfaceCommand{InIface: "wg9", SubNets: []string{"10.10.9.254/24"}, OutIfaces: []string{"lo"}, FlagCmd: flagCmd}
+		if _, err := cmd.Execute(); err != nil {
+			t.Fatalf("error: %s: unexpected error: %v", flagCmd, err)
+		}
+	}
+
+	run(help.AddFlag)
+	run(help.AddFlag + help.NatFlag)
+
+	meta, err := peermeta.LoadInterface("wg9")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	wantRules := append(set.ForwardRules("lo", "wg9"), set.NATRule("lo", "10.10.9.0/24", "wg9"))
+	if !slices.Equal(meta.Rules, wantRules) || !slices.Equal(meta.Addresses, []string{"10.10.9.254/24"}) {
+		t.Fatalf("error: got %+v, want rules %+v and the address", meta, wantRules)
+	}
+
+	// The listing reports the rules, so the removal commands are executed.
+	run(help.DelFlag + help.NatFlag)
+	run(help.DelFlag)
+
+	if !slices.Contains(fake.Commands, firewall.FormatCmdNat(firewall.Delete, "lo", "10.10.9.0/24", "wg9")) {
+		t.Fatalf("error: NAT rule was not removed: %q", fake.Commands)
+	}
+
+	meta, _ = peermeta.LoadInterface("wg9")
+	if !slices.Equal(meta.Rules, wantRules[:2]) || len(meta.Addresses) != 0 {
+		t.Errorf("error: got %+v, want only the FORWARD rules", meta)
+	}
+}
+
+// Testing that the commands changing the system require root, while the
+// read-only ones run unprivileged.
+func TestRequireRoot(t *testing.T) {
+	prev := handlers.Privileged
+	t.Cleanup(func() { handlers.Privileged = prev })
+	handlers.Privileged = func() bool { return false }
+
+	type testCase struct {
+		name      string
+		cmd       Command
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "interface", cmd: &IpIntertfaceCommand{InIface: "wg0", FlagCmd: help.AddFlag}, wantError: true},
+		{name: "purge", cmd: &PurgeCommand{}, wantError: true},
+		{name: "purge dry run", cmd: &PurgeCommand{Dry: true}},
+		{name: "validate", cmd: &ValidateCommand{Path: "peers.csv", Format: ValidateFormatCSV}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := requireRoot(tc.cmd)
+			if tc.wantError {
+				if err == nil || !strings.Contains(err.Error(), "requires CAP_NET_ADMIN") {
+					t.Fatalf("error: got error %v, want the requires root error", err)
+				}
+			} else if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// Testing the argument parsing of the DNS command.
+func TestDnsParseArgs(t *testing.T) {
+	type testCase struct {
+		args      []string
+		servers   []string
+		flagCmd   string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{
+			args:    []string{"wg0", "-dns", "10.10.10.1,10.10.10.2", "-a"},
+			servers: []string{"10.10.10.1", "10.10.10.2"},
+			flagCmd: help.AddFlag,
+		},
+		{
+			args:    []string{"wg0", "-dns", "fd00::1", "-d"},
+			servers: []string{"fd00::1"},
+			flagCmd: help.DelFlag,
+		},
+		{args: []string{"wg0", "-dns", "10.10.10.1"}, wantError: true},
+		{args: []string{"wg0", "-dns", "dns.example.com", "-a"}, wantError: true},
+		{args: []string{"wg0", "-dns", "10.10.10.1", "-x"}, wantError: true},
+		{args: []string{"wg0!", "-dns", "10.10.10.1", "-a"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := DnsCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !slices.Equal(cmd.Servers, tc.servers) || cmd.FlagCmd != tc.flagCmd {
+				t.Errorf("error: got servers %q, command %q", cmd.Servers, cmd.FlagCmd)
+			}
+		})
+	}
+}
+
+// Testing the parsing of the reconcile command arguments.
+func TestReconcileParseArgs(t *testing.T) {
+	type testCase struct {
+		args      []string
+		apply     bool
+		json      bool
+		wantError bool
+	}
+
+	tests := []testCase{
+		{args: []string{"state.json"}},
+		{args: []string{"state.json", "-apply"}, apply: true},
+		{args: []string{"state.json", "-js", "-apply"}, apply: true, json: true},
+		{args: []string{"-", "-apply"}, apply: true},
+		{args: []string{}, wantError: true},
+		{args: []string{"state.json", "-apply", "-apply"}, wantError: true},
+		{args: []string{"state.json", "-dry"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := ReconcileCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if cmd.Path != tc.args[0] || cmd.Apply != tc.apply || cmd.JSON != tc.json {
+				t.Errorf("error: got path %q, apply %v, json %v", cmd.Path, cmd.Apply, cmd.JSON)
+			}
+		})
+	}
+}
+
+// Testing the validate command arguments and the format of the file.
+func TestValidateParseArgs(t *testing.T) {
+	type testCase struct {
+		args       []string
+		wantFormat string
+		wantError  bool
+	}
+
+	tests := []testCase{
+		{args: []string{"peers.csv"}, wantFormat: "csv"},
+		{args: []string{"/etc/wireguard/wg0.conf"}, wantFormat: "conf"},
+		{args: []string{"state.json"}, wantFormat: "json"},
+		{args: []string{"-", "-format", "csv"}, wantFormat: "csv"},
+		{args: []string{"peers.txt", "-format", "conf"}, wantFormat: "conf"},
+		{args: []string{"peers.txt"}, wantError: true},
+		{args: []string{"-"}, wantError: true},
+		{args: []string{"peers.csv", "-format", "yaml"}, wantError: true},
+		{args: []string{"peers.csv", "-apply", "csv"}, wantError: true},
+		{args: []string{"peers.csv", "-format"}, wantError: true},
+		{args: []string{}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := ValidateCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if cmd.Format != tc.wantFormat {
+				t.Errorf("error: got format %q, want %q", cmd.Format, tc.wantFormat)
+			}
+		})
+	}
+}
+
+// Testing that the validate command reports every problem of a file, and
+// never reaches the system: no shell command, no wgctrl call.
+func TestValidateCheck(t *testing.T) {
+	fake := shellmock.Install(t)
+	mock := wgmock.Install(t)
+
+	key, _ := wgtypes.GeneratePrivateKey()
+	type testCase struct {
+		name      string
+		format    string
+		data      string
+		wantLines []string
+	}
+
+	tests := []testCase{
+		{
+			name:   "csv",
+			format: ValidateFormatCSV,
+			data:   "abc,10.10.10.2/32\n" + key.PublicKey().String() + ",10.10.10.300/32,,,,2025-13-01\n",
+			wantLines: []string{
+				"f:1: invalid public key 'abc'",
+				"f:2: peer '" + key.PublicKey().String() + "' has an invalid allowed IP '10.10.10.300/32'",
+				"f:2: invalid expiry '2025-13-01'",
+			},
+		},
+		{
+			name:   "conf",
+			format: ValidateFormatConf,
+			data:   "[Interface]\nPrivateKey = " + key.String() + "\nListenPort = 0\n[Peer]\nEndpoint = 1.2.3.4:5\n",
+			wantLines: []string{
+				"f:3: invalid listen port 0",
+				"f:4: invalid public key ''",
+				"f:4: peer '' has no allowed IPs",
+			},
+		},
+		{
+			name:      "json",
+			format:    ValidateFormatJSON,
+			data:      `{"interfaces": [{"name": "wg0", "addresses": ["10.10.10.254"], "type": "ipsec"}]}`,
+			wantLines: []string{"f:1: interface 'wg0' has an invalid type 'ipsec'", "f:1: interface 'wg0' has an invalid address"},
+		},
+		{name: "valid", format: ValidateFormatCSV, data: key.PublicKey().String() + ",10.10.10.2/32\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			cmd := ValidateCommand{Path: "f", Format: tc.format}
+			results, err := cmd.check([]byte(tc.data), &out)
+
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if len(tc.wantLines) == 0 {
+				if err != nil || out.Len() != 0 || len(results) != 1 || results[0].Status != StatusSkipped {
+					t.Fatalf("error: got %v, output %q, results %+v, want valid", err, out.String(), results)
+				}
+			} else {
+				if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%d problems", len(tc.wantLines))) {
+					t.Errorf("error: got %v, want %d problems", err, len(tc.wantLines))
+				}
+				if len(lines) != len(tc.wantLines) || len(results) != len(tc.wantLines) {
+					t.Fatalf("error: got output %q and %d results, want %d problems", out.String(), len(results), len(tc.wantLines))
+				}
+				for indx, want := range tc.wantLines {
+					if !strings.HasPrefix(lines[indx], want) || results[indx].Status != StatusFailed {
+						t.Errorf("error: got %q (%s), want %q", lines[indx], results[indx].Status, want)
+					}
+				}
+			}
+
+			if len(fake.Commands) != 0 || len(mock.Calls) != 0 || shell.DefaultRunner != fake {
+				t.Errorf("error: got commands %q and %d wgctrl calls", fake.Commands, len(mock.Calls))
+			}
+		})
+	}
+}
+
+// Testing that a check reaching the system during a validation is refused
+// and reported.
+func TestValidateOffline(t *testing.T) {
+	fake := shellmock.Install(t)
+	wgmock.Install(t)
+
+	validateFormats["leak"] = func([]byte) []validate.Problem {
+		shell.DefaultRunner.Run("ip link set wg0 up", false)
+		if client, err := handlers.InitWgCtlClient(); err == nil {
+			client.Close()
+		}
+		return nil
+	}
+	t.Cleanup(func() { delete(validateFormats, "leak") })
+
+	cmd := ValidateCommand{Path: "f", Format: "leak"}
+	_, err := cmd.check(nil, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "ran commands: ip link set wg0 up; wgctrl") {
+		t.Errorf("error: got %v, want the commands refused", err)
+	}
+	if len(fake.Commands) != 0 {
+		t.Errorf("error: got commands %q, want none", fake.Commands)
+	}
+}
+
+// Testing the output of the reconcile command without -apply.
+func TestReconcileOutput(t *testing.T) {
+	changes := []reconcile.Change{
+		{Kind: reconcile.KindAddress, Action: reconcile.ActionAdd, Interface: "wg0", Target: "10.10.10.254/24"},
+	}
+
+	type testCase struct {
+		name    string
+		cmd     ReconcileCommand
+		changes []reconcile.Change
+		want    string
+	}
+
+	tests := []testCase{
+		{name: "text", changes: changes, want: "+ address wg0 10.10.10.254/24\n"},
+		{name: "in sync", want: "no changes, the system matches the state file\n"},
+		{
+			name:    "json",
+			cmd:     ReconcileCommand{JSON: true},
+			changes: changes,
+			want: `{
+  "changes": [
+    {
+      "kind": "address",
+      "action": "add",
+      "interface": "wg0",
+      "target": "10.10.10.254/24"
+    }
+  ],
+  "applied": false
+}
+`,
+		},
+		{name: "json in sync", cmd: ReconcileCommand{JSON: true}, want: "{\n  \"changes\": [],\n  \"applied\": false\n}\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := shellmock.Install(t)
+
+			var out strings.Builder
+			if err := tc.cmd.run(reconcile.State{}, tc.changes, &out); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if out.String() != tc.want {
+				t.Errorf("error: got output\n%s\nwant\n%s", out.String(), tc.want)
+			}
+			if len(fake.Commands) != 0 {
+				t.Errorf("error: commands executed without -apply: %v", fake.Commands)
+			}
+		})
+	}
+}
+
+// Testing the parsing of the alias command arguments.
+func TestAliasParseArgs(t *testing.T) {
+	type testCase struct {
+		args      []string
+		alias     string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{args: []string{"wg0", "-alias", "office vpn"}, alias: "office vpn"},
+		{args: []string{"wg0", "-alias", ""}, alias: ""},
+		{args: []string{"wg0", "-alias", "office\nvpn"}, alias: "officevpn"},
+		{args: []string{"wg0", "-alias"}, wantError: true},
+		{args: []string{"wg0", "-alias", strings.Repeat("a", 256)}, wantError: true},
+		{args: []string{"wg0!", "-alias", "office"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := AliasInterfaceCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if cmd.Iface != tc.args[0] || cmd.Alias != tc.alias {
+				t.Errorf("error: got interface %q, alias %q", cmd.Iface, cmd.Alias)
+			}
+		})
+	}
+}
+
+// Testing the parsing of the move command arguments: the target namespace
+// is given by name or process ID and must exist.
+func TestToNetnsParseArgs(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	type testCase struct {
+		args      []string
+		wantFlag  string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{args: []string{"wg0", "-to-netns", pid}, wantFlag: "-to-netns"},
+		{args: []string{"wg0", "-to-netns", "brgnetuse-missing"}, wantFlag: "-to-netns", wantError: true},
+		{args: []string{"wg0", "-to-netns", "a/b"}, wantFlag: "-to-netns", wantError: true},
+		{args: []string{"wg0", "-to-netns"}, wantFlag: "-to-netns", wantError: true},
+		{args: []string{"wg0!", "-to-netns", pid}, wantFlag: "-i", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := ToNetnsCommand{}
+			flag, err := cmd.ParseArgs(tc.args)
+			if flag != tc.wantFlag {
+				t.Errorf("error: got flag %q, want %q", flag, tc.wantFlag)
+			}
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if cmd.Iface != "wg0" || cmd.Target != pid {
+				t.Errorf("error: got interface %q, target %q", cmd.Iface, cmd.Target)
+			}
+		})
+	}
+}
+
+// Testing the parsing of the IP command arguments with address lists.
+func TestIpParseArgs(t *testing.T) {
+	type testCase struct {
+		args      []string
+		subnets   []string
+		flagCmd   string
+		outIfaces []string
+		force     bool
+		wantError bool
+	}
+
+	tests := []testCase{
+		{
+			args:    []string{"wg0", "-ip", "10.10.10.1/24", "-a"},
+			subnets: []string{"10.10.10.1/24"},
+			flagCmd: help.AddFlag,
+		},
+		{
+			args:      []string{"wg0", "-ip", "10.10.10.1/24,fd00::1/64", "-a", "-n", "eth0"},
+			subnets:   []string{"10.10.10.1/24", "fd00::1/64"},
+			flagCmd:   help.AddFlag + help.NatFlag,
+			outIfaces: []string{"eth0"},
+		},
+		{
+			args:      []string{"wg0", "-ip", "10.10.10.1/24", "-a", "-n", "eth0, wwan0"},
+			subnets:   []string{"10.10.10.1/24"},
+			flagCmd:   help.AddFlag + help.NatFlag,
+			outIfaces: []string{"eth0", "wwan0"},
+		},
+		{
+			args:      []string{"wg0", "-ip", "10.10.10.1/24", "-d", "-n", "all"},
+			subnets:   []string{"10.10.10.1/24"},
+			flagCmd:   help.DelFlag + help.NatFlag,
+			outIfaces: []string{help.NatAllValue},
+		},
+		{
+			args:      []string{"wg0", "-ip", "fd00::1/64", "-a", "-n", "eth0", "-force"},
+			subnets:   []string{"fd00::1/64"},
+			flagCmd:   help.AddFlag + help.NatFlag,
+			outIfaces: []string{"eth0"},
+			force:     true,
+		},
+		{
+			args:    []string{"wg0", "-ip", "10.10.10.1/24", "-a", "-fr", "-force"},
+			subnets: []string{"10.10.10.1/24"},
+			flagCmd: help.AddFlag + help.FirewallFlag,
+			force:   true,
+		},
+		{
+			args:    []string{"wg0", "-ip", "fd00:0::1/64, 10.10.20.1/24", "-d", "-fr"},
+			subnets: []string{"fd00::1/64", "10.10.20.1/24"},
+			flagCmd: help.DelFlag + help.FirewallFlag,
+		},
+		{args: []string{"wg0", "-ip", "10.10.10.1/24"}, wantError: true},
+		{args: []string{"wg0", "-ip", "10.10.10.1/24,", "-a"}, wantError: true},
+		{args: []string{"wg0", "-ip", "10.10.10.1", "-a"}, wantError: true},
+		{args: []string{"wg0", "-ip", "10.10.10.1/33", "-a"}, wantError: true},
+		{args: []string{"wg0", "-ip", "fd00::1/129", "-a"}, wantError: true},
+		{args: []string{"wg0", "-ip", "fe80::1%wg0/64", "-a"}, wantError: true},
+		{args: []string{"wg0", "-ip", "::ffff:10.10.10.1/120", "-a"}, wantError: true},
+		{args: []string{"wg0", "-ip", "10.10.10.1/24,10.10.10.1/24", "-a"}, wantError: true},
+		{args: []string{"wg0", "-ip", "10.10.10.1/24", "-x"}, wantError: true},
+		{args: []string{"wg0", "-ip", "10.10.10.1/24", "-a", "-x"}, wantError: true},
+		{args: []string{"wg0", "-ip", "10.10.10.1/24", "-a", "-n", "eth0", "extra"}, wantError: true},
+		{args: []string{"wg0", "-ip", "10.10.10.1/24", "-a", "-n", "eth0,eth0"}, wantError: true},
+		{args: []string{"wg0", "-ip", "10.10.10.1/24", "-d", "-n", "eth0", "-force"}, wantError: true},
+		{args: []string{"wg0", "-ip", "10.10.10.1/24", "-a", "-force"}, wantError: true},
+		{args: []string{"wg0", "-ip", "10.10.10.1/24", "-a", "-n", "eth0,"}, wantError: true},
+		{args: []string{"wg0", "-ip", "10.10.10.1/24", "-a", "-n", "eth0,all"}, wantError: true},
+		{args: []string{"wg0", "-ip", "10.10.10.1/24", "-a", "-n", "eth0,wwan/0"}, wantError: true},
+		{args: []string{"wg0!", "-ip", "10.10.10.1/24", "-a"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := IpIntertfaceCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error, got %+v", cmd)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !slices.Equal(cmd.SubNets, tc.subnets) || cmd.FlagCmd != tc.flagCmd ||
+				!slices.Equal(cmd.OutIfaces, tc.outIfaces) || cmd.Force != tc.force {
+				t.Errorf("error: got %+v", cmd)
+			}
+		})
+	}
+}
+
+// Testing that a failing address rolls back the addresses added before it.
+func TestIpAddressesRollback(t *testing.T) {
+	useMetaDir(t)
+	fake := shellmock.Install(t)
+	fake.Errors[shell.FormatCmdIpAddrDev("wg9", "fd00::1/64", shell.IpAdd)] = errors.New("RTNETLINK answers: Permission denied")
+
+	cmd := IpIntertfaceCommand{
+		InIface:   "wg9",
+		SubNets:   []string{"10.10.9.254/24", "10.10.19.254/24", "fd00::1/64"},
+		OutIfaces: []string{"lo"},
+		FlagCmd:   help.AddFlag,
+	}
+	if _, err := cmd.Execute(); err == nil {
+		t.Fatal("error: expected error, got none")
+	}
+
+	want := []string{
+		shell.FormatCmdIpShowJSON("wg9"),
+		shell.FormatCmdIpAddrDev("wg9", "10.10.9.254/24", shell.IpAdd),
+		shell.FormatCmdIpAddrDev("wg9", "10.10.19.254/24", shell.IpAdd),
+		shell.FormatCmdIpAddrDev("wg9", "fd00::1/64", shell.IpAdd),
+		shell.FormatCmdIpAddrDev("wg9", "10.10.19.254/24", shell.IpDel),
+		shell.FormatCmdIpAddrDev("wg9", "10.10.9.254/24", shell.IpDel),
+	}
+	if !slices.Equal(fake.Commands, want) {
+		t.Errorf("error: got commands\n%s\nwant\n%s", strings.Join(fake.Commands, "\n"), strings.Join(want, "\n"))
+	}
+
+	meta, _ := peermeta.LoadInterface("wg9")
+	if len(meta.Addresses) != 0 {
+		t.Errorf("error: got recorded addresses %v, want none", meta.Addresses)
+	}
+}
+
+// Testing the firewall and NAT rules of a mixed-family address list.
+func TestIpRulesMixedFamily(t *testing.T) {
+	useMetaDir(t)
+	useFamilies(t, nil)
+
+	var warnings strings.Builder
+	prevWarn := warnOut
+	warnOut = &warnings
+	t.Cleanup(func() { warnOut = prevWarn })
+
+	fake := shellmock.Install(t)
+	fake.Outputs[firewall.CmdList] = ""
+	fake.Outputs[firewall.CmdListNat] = ""
+	useIptablesState(fake)
+
+	cmd := IpIntertfaceCommand{
+		InIface:   "wg9",
+		SubNets:   []string{"10.10.9.254/24", "fd00::1/64", "10.10.19.254/24"},
+		OutIfaces: []string{"lo"},
+		FlagCmd:   help.AddFlag + help.NatFlag,
+	}
+	if _, err := cmd.Execute(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, c := range fake.Commands {
+		if !strings.HasPrefix(c, "iptables -L") && !strings.HasPrefix(c, "iptables -t nat -L") {
+			got = append(got, c)
+		}
+	}
+	want := []string{
+		firewall.FormatCmdForward(firewall.Append, "lo", "wg9"),
+		firewall.FormatCmdNat(firewall.Append, "lo", "10.10.9.0/24", "wg9"),
+		firewall.FormatCmdNat(firewall.Append, "lo", "10.10.19.0/24", "wg9"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("error: got commands\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if !strings.Contains(warnings.String(), "unique local IPv6 prefix 'fd00::/64' skipped") {
+		t.Errorf("error: got warnings %q", warnings.String())
+	}
+
+	meta, _ := peermeta.LoadInterface("wg9")
+	wantRules := append(set.ForwardRules("lo", "wg9"), set.NATRule("lo", "10.10.9.0/24", "wg9"), set.NATRule("lo", "10.10.19.0/24", "wg9"))
+	if !slices.Equal(meta.Rules, wantRules) {
+		t.Errorf("error: got recorded rules %+v, want %+v", meta.Rules, wantRules)
+	}
+}
+
+// Testing the address family check of the NAT rules: a subnet through an
+// outgoing interface without an address of its family fails, or is only
+// reported with -force.
+func TestIpRulesFamilies(t *testing.T) {
+	type testCase struct {
+		name      string
+		subnet    string
+		families  addressFamilies
+		force     bool
+		wantCode  help.Code
+		wantRules int
+	}
+
+	ipv4Only := addressFamilies{IPv4: true}
+	ipv6Only := addressFamilies{IPv6: true}
+
+	tests := []testCase{
+		{name: "IPv4 through IPv4", subnet: "10.10.9.254/24", families: ipv4Only, wantRules: 3},
+		{name: "IPv4 through IPv6-only", subnet: "10.10.9.254/24", families: ipv6Only, wantCode: help.CodeFamilyMismatch},
+		{name: "IPv6 through IPv6", subnet: "fd00::1/64", families: ipv6Only},
+		{name: "IPv6 through IPv4-only", subnet: "fd00::1/64", families: ipv4Only, wantCode: help.CodeFamilyMismatch},
+		{name: "IPv4 through IPv6-only forced", subnet: "10.10.9.254/24", families: ipv6Only, force: true, wantRules: 3},
+		{name: "IPv6 through IPv4-only forced", subnet: "fd00::1/64", families: ipv4Only, force: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useMetaDir(t)
+			useFamilies(t, map[string]addressFamilies{"eth0": tc.families})
+			warnings = nil
+			t.Cleanup(func() { warnings = nil })
+
+			var warned strings.Builder
+			prevWarn := warnOut
+			warnOut = &warned
+			t.Cleanup(func() { warnOut = prevWarn })
+
+			fake := shellmock.Install(t)
+			fake.Outputs[firewall.CmdList] = ""
+			fake.Outputs[firewall.CmdListNat] = ""
+			useIptablesState(fake)
+
+			cmd := IpIntertfaceCommand{
+				InIface:   "wg9",
+				SubNets:   []string{tc.subnet},
+				OutIfaces: []string{"eth0"},
+				Force:     tc.force,
+				FlagCmd:   help.AddFlag + help.NatFlag,
+			}
+			_, err := cmd.Execute()
+			if tc.wantCode != "" {
+				if help.CodeOf(err) != tc.wantCode || !strings.Contains(err.Error(), "network interface 'eth0' has no") {
+					t.Fatalf("error: got %v, want a %s error", err, tc.wantCode)
+				}
+				if got := fake.Matching("iptables -A"); len(got) != 0 {
+					t.Errorf("error: rejected command added %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			meta, _ := peermeta.LoadInterface("wg9")
+			if len(meta.Rules) != tc.wantRules {
+				t.Errorf("error: got recorded rules %+v, want %d", meta.Rules, tc.wantRules)
+			}
+			mismatch := slices.ContainsFunc(warnings, func(r Result) bool { return r.Code == set.WarnFamilyMismatch })
+			if mismatch != tc.force {
+				t.Errorf("error: got warnings %+v, output %q", warnings, warned.String())
+			}
+		})
+	}
+}
+
+// Testing that the hairpin NAT rule of an interface without an IPv4
+// address is refused, and added with -force.
+func TestHairpinFamilies(t *testing.T) {
+	useMetaDir(t)
+	stubLookups(t, []string{"wg9"}, nil)
+	useFamilies(t, map[string]addressFamilies{"wg9": {IPv6: true}})
+	warnings = nil
+	t.Cleanup(func() { warnings = nil })
+
+	var warned strings.Builder
+	prevWarn := warnOut
+	warnOut = &warned
+	t.Cleanup(func() { warnOut = prevWarn })
+
+	addCmd := firewall.FormatCmdHairpin(firewall.Append, "10.10.9.0/24", "wg9")
+	fake := shellmock.Install(t)
+	fake.Outputs[firewall.CmdListNat] = ""
+	fake.Hook = func(cmd string) {
+		if cmd == addCmd {
+			fake.Outputs[firewall.CmdListNat] = "Chain POSTROUTING (policy ACCEPT 0 packets, 0 bytes)\n" +
+				" pkts bytes target     prot opt in     out     source               destination\n" +
+				"    0     0 MASQUERADE  all  --  any    wg9     10.10.9.0/24         10.10.9.0/24         /* brgnetuse:wg9 */\n"
+		}
+	}
+
+	cmd := HairpinCommand{InIface: "wg9", SubNets: []string{"10.10.9.0/24"}, Flag: firewall.Append}
+	if _, err := cmd.Execute(); help.CodeOf(err) != help.CodeFamilyMismatch {
+		t.Fatalf("error: got %v, want a %s error", err, help.CodeFamilyMismatch)
+	}
+
+	cmd = HairpinCommand{InIface: "wg9", SubNets: []string{"10.10.9.0/24"}, Flag: firewall.Append, Force: true}
+	if _, err := cmd.Execute(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(fake.Matching(addCmd)) != 1 || len(warnings) != 1 {
+		t.Errorf("error: got commands %q, warnings %+v", fake.Commands, warnings)
+	}
+}
+
+// Testing the address families read from the addresses of an interface.
+func TestFamiliesOf(t *testing.T) {
+	tests := map[string]addressFamilies{
+		`[{"ifname":"eth0","addr_info":[{"family":"inet","local":"192.0.2.1","prefixlen":24,"scope":"global"},` +
+			`{"family":"inet6","local":"fe80::1","prefixlen":64,"scope":"link"}]}]`: {IPv4: true},
+		`[{"ifname":"eth0","addr_info":[{"family":"inet6","local":"2001:db8::1","prefixlen":64,"scope":"global"}]}]`: {IPv6: true},
+		ipShowWg0:                            {IPv4: true, IPv6: true},
+		`[{"ifname":"eth0","addr_info":[]}]`: {},
+	}
+
+	for show, want := range tests {
+		fake := shellmock.Install(t)
+		fake.Outputs[shell.FormatCmdIpShowJSON("eth0")] = show
+		got, err := familiesOf("eth0")
+		if err != nil || got != want {
+			t.Errorf("error: got %+v, %v for %s, want %+v", got, err, show, want)
+		}
+	}
+}
+
+// Testing that a failing NAT rule rolls back the rules added before it.
+func TestIpRulesRollback(t *testing.T) {
+	useMetaDir(t)
+	useFamilies(t, nil)
+	fake := shellmock.Install(t)
+	fake.Outputs[firewall.CmdList] = ""
+	fake.Outputs[firewall.CmdListNat] = ""
+	fake.Errors[firewall.FormatCmdNat(firewall.Append, "lo", "10.10.19.0/24", "wg9")] = errors.New("iptables: Resource temporarily unavailable")
+
+	cmd := IpIntertfaceCommand{
+		InIface:   "wg9",
+		SubNets:   []string{"10.10.9.254/24", "10.10.19.254/24"},
+		OutIfaces: []string{"lo"},
+		FlagCmd:   help.AddFlag + help.NatFlag,
+	}
+	if _, err := cmd.Execute(); err == nil {
+		t.Fatal("error: expected error, got none")
+	}
+
+	for _, undo := range []string{
+		firewall.FormatCmdNat(firewall.Delete, "lo", "10.10.9.0/24", "wg9"),
+		firewall.FormatCmdForward(firewall.Delete, "lo", "wg9"),
+	} {
+		if !slices.Contains(fake.Commands, undo) {
+			t.Errorf("error: rule not rolled back, missing %q in %q", undo, fake.Commands)
+		}
+	}
+
+	meta, _ := peermeta.LoadInterface("wg9")
+	if len(meta.Rules) != 0 {
+		t.Errorf("error: got recorded rules %+v, want none", meta.Rules)
+	}
+}
+
+// Testing the arguments of `-i [iface] -hairpin -a|-d [subnets]`.
+func TestHairpinParseArgs(t *testing.T) {
+	type testCase struct {
+		args        []string
+		wantSubnets []string
+		wantForce   bool
+		wantError   bool
+	}
+
+	tests := []testCase{
+		{args: []string{"wg0", "-hairpin", "-a", "10.10.10.0/24"}, wantSubnets: []string{"10.10.10.0/24"}},
+		{args: []string{"wg0", "-hairpin", "-a", "10.10.10.0/24", "-force"}, wantSubnets: []string{"10.10.10.0/24"}, wantForce: true},
+		{args: []string{"wg0", "-hairpin", "-d", "10.10.10.0/24", "-force"}, wantError: true},
+		{args: []string{"wg0", "-hairpin", "-d", "10.10.10.254/24,10.10.10.0/24,10.20.0.1/16"}, wantSubnets: []string{"10.10.10.0/24", "10.20.0.0/16"}},
+		{args: []string{"wg0", "-hairpin", "-a", "fd00::/64"}, wantError: true},
+		{args: []string{"wg0", "-hairpin", "-x", "10.10.10.0/24"}, wantError: true},
+		{args: []string{"wg0", "-hairpin", "-a"}, wantError: true},
+		{args: []string{"wg/0", "-hairpin", "-a", "10.10.10.0/24"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := HairpinCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error for %v", tc.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !slices.Equal(cmd.SubNets, tc.wantSubnets) || cmd.Force != tc.wantForce {
+				t.Errorf("error: got subnets %v, force %t", cmd.SubNets, cmd.Force)
+			}
+		})
+	}
+}
+
+// Testing that the hairpin NAT rule is added, found again on a re-run,
+// deleted and recorded in the interface metadata.
+func TestHairpin(t *testing.T) {
+	useMetaDir(t)
+	stubLookups(t, []string{"wg9"}, nil)
+
+	const header = "Chain POSTROUTING (policy ACCEPT 0 packets, 0 bytes)\n" +
+		" pkts bytes target     prot opt in     out     source               destination\n"
+	const listed = "    0     0 MASQUERADE  all  --  any    wg9     10.10.9.0/24         10.10.9.0/24         /* brgnetuse:wg9 */\n"
+	addCmd := `iptables -t nat -A POSTROUTING -s 10.10.9.0/24 -d 10.10.9.0/24 -o wg9 -m comment --comment "brgnetuse:wg9" -j MASQUERADE`
+	delCmd := `iptables -t nat -D POSTROUTING -s 10.10.9.0/24 -d 10.10.9.0/24 -o wg9 -m comment --comment "brgnetuse:wg9" -j MASQUERADE`
+
+	fake := shellmock.Install(t)
+	// A NAT rule of the subnet through the uplink is not the hairpin rule.
+	fake.Outputs[firewall.CmdListNat] = header +
+		"    0     0 MASQUERADE  all  --  any    eth0    10.10.9.0/24         anywhere             /* brgnetuse:wg9 */\n"
+	fake.Hook = func(cmd string) {
+		switch cmd {
+		case addCmd:
+			fake.Outputs[firewall.CmdListNat] += listed
+		case delCmd:
+			fake.Outputs[firewall.CmdListNat] = strings.Replace(fake.Outputs[firewall.CmdListNat], listed, "", 1)
+		}
+	}
+
+	run := func(action string) []Result {
+		t.Helper()
+		fake.Commands = nil
+		cmd := HairpinCommand{}
+		if _, err := cmd.ParseArgs([]string{"wg9", "-hairpin", action, "10.10.9.254/24"}); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		results, err := cmd.Execute()
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("error: got results %+v, want one", results)
+		}
+		return results
+	}
+	changes := func() []string {
+		var cmds []string
+		for _, cmd := range fake.Commands {
+			if cmd != firewall.CmdListNat {
+				cmds = append(cmds, cmd)
+			}
+		}
+		return cmds
+	}
+
+	if results := run(help.AddFlag); results[0].Status != StatusApplied || !slices.Equal(changes(), []string{addCmd}) {
+		t.Errorf("error: got %+v and commands %q, want the rule added", results, changes())
+	}
+	meta, _ := peermeta.LoadInterface("wg9")
+	if want := []peermeta.Rule{set.HairpinRule("10.10.9.0/24", "wg9")}; !slices.Equal(meta.Rules, want) {
+		t.Errorf("error: got recorded rules %+v, want %+v", meta.Rules, want)
+	}
+
+	if results := run(help.AddFlag); results[0].Status != StatusSkipped || len(changes()) != 0 {
+		t.Errorf("error: got %+v and commands %q on the re-run, want it skipped", results, changes())
+	}
+
+	if results := run(help.DelFlag); results[0].Status != StatusApplied || !slices.Equal(changes(), []string{delCmd}) {
+		t.Errorf("error: got %+v and commands %q, want the rule deleted", results, changes())
+	}
+	meta, _ = peermeta.LoadInterface("wg9")
+	if len(meta.Rules) != 0 {
+		t.Errorf("error: got recorded rules %+v after the delete", meta.Rules)
+	}
+
+	if results := run(help.DelFlag); results[0].Status != StatusSkipped || len(changes()) != 0 {
+		t.Errorf("error: got %+v and commands %q on the re-run, want it skipped", results, changes())
+	}
+}
+
+// Function returns an up, non-loopback network interface of the host, the
+// test is skipped without one.
+func uplinkForTest(t *testing.T) string {
+	t.Helper()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 {
+			return iface.Name
+		}
+	}
+	t.Skip("no up, non-loopback network interface")
+	return ""
+}
+
+// Testing that the rules are added on and deleted from every outgoing
+// network interface of the list.
+func TestIpRulesMultiUplink(t *testing.T) {
+	useMetaDir(t)
+	useFamilies(t, nil)
+	uplink := uplinkForTest(t)
+	fake := shellmock.Install(t)
+	fake.Outputs[firewall.CmdList] = ""
+	fake.Outputs[firewall.CmdListNat] = ""
+	useIptablesState(fake)
+
+	cmd := IpIntertfaceCommand{
+		InIface:   "wg9",
+		SubNets:   []string{"10.10.9.254/24"},
+		OutIfaces: []string{"lo", uplink},
+		FlagCmd:   help.AddFlag + help.NatFlag,
+	}
+	if _, err := cmd.Execute(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	want := []string{
+		firewall.FormatCmdForward(firewall.Append, "lo", "wg9"),
+		firewall.FormatCmdNat(firewall.Append, "lo", "10.10.9.0/24", "wg9"),
+		firewall.FormatCmdForward(firewall.Append, uplink, "wg9"),
+		firewall.FormatCmdNat(firewall.Append, uplink, "10.10.9.0/24", "wg9"),
+	}
+	var got []string
+	for _, cmd := range fake.Commands {
+		if slices.Contains(want, cmd) {
+			got = append(got, cmd)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("error: got rules added %q, want %q", got, want)
+	}
+
+	meta, err := peermeta.LoadInterface("wg9")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	wantRules := append(set.ForwardRules("lo", "wg9"), set.NATRule("lo", "10.10.9.0/24", "wg9"))
+	wantRules = append(wantRules, set.ForwardRules(uplink, "wg9")...)
+	wantRules = append(wantRules, set.NATRule(uplink, "10.10.9.0/24", "wg9"))
+	if !slices.Equal(meta.Rules, wantRules) {
+		t.Fatalf("error: got rules %+v, want %+v", meta.Rules, wantRules)
+	}
+
+	// The deletion iterates the same interfaces.
+	cmd = IpIntertfaceCommand{
+		InIface:   "wg9",
+		SubNets:   []string{"10.10.9.254/24"},
+		OutIfaces: []string{"lo", uplink},
+		FlagCmd:   help.DelFlag + help.NatFlag,
+	}
+	if _, err := cmd.Execute(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	for _, outIface := range []string{"lo", uplink} {
+		del := firewall.FormatCmdNat(firewall.Delete, outIface, "10.10.9.0/24", "wg9")
+		if !slices.Contains(fake.Commands, del) {
+			t.Errorf("error: missing %q in %q", del, fake.Commands)
+		}
+	}
+}
+
+// Function makes the fake runner keep the firewall and NAT tables: the
+// rules appended and deleted by the iptables commands, but the ones with a
+// scripted error, change the listings of Outputs.
+func useIptablesState(fake *shellmock.Runner) {
+	fake.Hook = func(cmd string) {
+		if _, failed := fake.Errors[cmd]; failed {
+			return
+		}
+
+		for _, part := range strings.Split(cmd, " && ") {
+			fields := strings.Fields(part)
+			if len(fields) < 3 || fields[0] != "iptables" {
+				continue
+			}
+
+			list := firewall.CmdList
+			fields = fields[1:]
+			if fields[0] == "-t" && fields[1] == "nat" {
+				list = firewall.CmdListNat
+				fields = fields[2:]
+			}
+			if len(fields) < 2 || (fields[0] != "-A" && fields[0] != "-D") {
+				continue
+			}
+			fake.Outputs[list] = editListing(fake.Outputs[list], fields[0], fields[1], listingLine(fields[2:]))
+		}
+	}
+}
+
+// Function formats the rule given by the options of an iptables command
+// as a line of its listing.
+func listingLine(options []string) string {
+	in, out, source, target, comment := "*", "*", "0.0.0.0/0", "", ""
+	prot, match := "all", ""
+	for i := 0; i+1 < len(options); i++ {
+		switch options[i] {
+		case "-p":
+			prot = options[i+1]
+		case "--dport":
+			match = " " + prot + " dpt:" + options[i+1]
+		case "-i":
+			in = options[i+1]
+		case "-o":
+			out = options[i+1]
+		case "-s":
+			source = options[i+1]
+		case "-j":
+			target = options[i+1]
+		case "--comment":
+			comment = " /* " + strings.Trim(options[i+1], `"`) + " */"
+		}
+	}
+	return fmt.Sprintf("    0     0 %-10s %-4s --  %-6s %-6s %-20s 0.0.0.0/0%s%s", target, prot, in, out, source, match, comment)
+}
+
+// Function appends the line to (-A) or deletes it from (-D) the chain of
+// the listing.
+func editListing(listing, action, chain, line string) string {
+	var lines []string
+	if listing != "" {
+		lines = strings.Split(strings.TrimSuffix(listing, "\n"), "\n")
+	}
+
+	// The fields of a line, with the names listing any network interface
+	// and address made the same.
+	normalize := func(line string) string {
+		fields := strings.Fields(line)
+		for i := 5; i < len(fields) && i < 9; i++ {
+			switch fields[i] {
+			case "any":
+				fields[i] = "*"
+			case "anywhere":
+				fields[i] = "0.0.0.0/0"
+			}
+		}
+		return strings.Join(fields[min(2, len(fields)):], " ")
+	}
+
+	switch action {
+	case "-A":
+		header := slices.IndexFunc(lines, func(l string) bool { return strings.HasPrefix(l, "Chain "+chain+" ") })
+		if header < 0 {
+			lines = append(lines,
+				"Chain "+chain+" (policy ACCEPT 0 packets, 0 bytes)",
+				" pkts bytes target     prot opt in     out     source               destination",
+			)
+			header = len(lines) - 2
+		}
+		end := header + 1
+		for end < len(lines) && !strings.HasPrefix(lines[end], "Chain ") {
+			end++
+		}
+		lines = slices.Insert(lines, end, line)
+	case "-D":
+		if i := slices.IndexFunc(lines, func(l string) bool { return normalize(l) == normalize(line) }); i >= 0 {
+			lines = slices.Delete(lines, i, i+1)
+		}
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Testing that each table is listed once for the checks of a command,
+// whatever the number of subnets and rules, and once more to verify the
+// added rules.
+func TestIpRulesListedOnce(t *testing.T) {
+	useMetaDir(t)
+	useFamilies(t, nil)
+	fake := shellmock.Install(t)
+	fake.Outputs[firewall.CmdList] = ""
+	fake.Outputs[firewall.CmdListNat] = ""
+	useIptablesState(fake)
+
+	subnets := []string{"10.10.9.254/24", "10.10.19.254/24", "10.10.29.254/24"}
+	run := func(flagCmd string, wantFirewall, wantNat int) {
+		t.Helper()
+		fake.Commands = nil
+
+		cmd := IpIntertfaceCommand{InIface: "wg9", SubNets: subnets, OutIfaces: []string{"lo"}, FlagCmd: flagCmd}
+		if _, err := cmd.Execute(); err != nil {
+			t.Fatalf("error: %s: unexpected error: %v", flagCmd, err)
+		}
+
+		if got := len(fake.Matching(firewall.CmdList)); got != wantFirewall {
+			t.Errorf("error: %s: got %d firewall listings, want %d", flagCmd, got, wantFirewall)
+		}
+		if got := len(fake.Matching(firewall.CmdListNat)); got != wantNat {
+			t.Errorf("error: %s: got %d NAT listings, want %d", flagCmd, got, wantNat)
+		}
+	}
+
+	// The checks, then the verification of the added rules.
+	run(help.AddFlag+help.NatFlag, 2, 2)
+	// Every rule exists, nothing to verify.
+	run(help.AddFlag+help.NatFlag, 1, 1)
+	run(help.DelFlag+help.NatFlag, 0, 1)
+	run(help.DelFlag+help.FirewallFlag, 1, 0)
+
+	if listing := fake.Outputs[firewall.CmdList] + fake.Outputs[firewall.CmdListNat]; strings.Contains(listing, "brgnetuse:wg9") {
+		t.Errorf("error: rules left after the removal:\n%s", listing)
+	}
+}
+
+// Testing that a rule accepted by iptables but not listed afterwards fails
+// the command and rolls back the added rules.
+func TestIpRulesNotListed(t *testing.T) {
+	useMetaDir(t)
+	useFamilies(t, nil)
+	fake := shellmock.Install(t)
+	fake.Outputs[firewall.CmdList] = ""
+	fake.Outputs[firewall.CmdListNat] = ""
+	useIptablesState(fake)
+
+	// The NAT rule is lost, e.g., the table restored meanwhile.
+	natRule := firewall.FormatCmdNat(firewall.Append, "lo", "10.10.9.0/24", "wg9")
+	keep := fake.Hook
+	fake.Hook = func(cmd string) {
+		if cmd != natRule {
+			keep(cmd)
+		}
+	}
+
+	cmd := IpIntertfaceCommand{InIface: "wg9", SubNets: []string{"10.10.9.254/24"}, OutIfaces: []string{"lo"}, FlagCmd: help.AddFlag + help.NatFlag}
+	_, err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "not listed") {
+		t.Fatalf("error: got error %v, want the NAT rule not listed", err)
+	}
+
+	for _, undo := range []string{
+		firewall.FormatCmdNat(firewall.Delete, "lo", "10.10.9.0/24", "wg9"),
+		firewall.FormatCmdForward(firewall.Delete, "lo", "wg9"),
+	} {
+		if !slices.Contains(fake.Commands, undo) {
+			t.Errorf("error: rule not rolled back, missing %q in %q", undo, fake.Commands)
+		}
+	}
+
+	meta, _ := peermeta.LoadInterface("wg9")
+	if len(meta.Rules) != 0 {
+		t.Errorf("error: got recorded rules %+v, want none", meta.Rules)
+	}
+}
+
+// Testing the discovery of the uplinks for `-n all` on a synthetic routing
+// table.
+func TestUplinkInterfaces(t *testing.T) {
+	uplink := uplinkForTest(t)
+	fake := shellmock.Install(t)
+
+	prev := shell.RouteFile
+	shell.RouteFile = filepath.Join(t.TempDir(), "route")
+	t.Cleanup(func() { shell.RouteFile = prev })
+
+	table := "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		"lo\t00000000\t0100007F\t0003\t0\t0\t50\t00000000\t0\t0\t0\n" +
+		"brgmissing0\t00000000\t0100A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n" +
+		uplink + "\t00000000\t0100A8C0\t0003\t0\t0\t200\t00000000\t0\t0\t0\n"
+	if err := os.WriteFile(shell.RouteFile, []byte(table), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.Outputs[shell.IpLinkDetailJSON] = "[]"
+	got, err := resolveOutIfaces([]string{help.NatAllValue})
+	if err != nil || !slices.Equal(got, []string{uplink}) {
+		t.Errorf("error: got %q %v, want %q", got, err, uplink)
+	}
+
+	// A WireGuard device is not an uplink.
+	fake.Outputs[shell.IpLinkDetailJSON] = `[{"ifname":"` + uplink + `","linkinfo":{"info_kind":"wireguard"}}]`
+	if got, err := resolveOutIfaces([]string{help.NatAllValue}); err == nil {
+		t.Errorf("error: got %q, want an error", got)
+	}
+
+	// The names given are used as is.
+	got, err = resolveOutIfaces([]string{"eth0", "wwan0"})
+	if err != nil || !slices.Equal(got, []string{"eth0", "wwan0"}) {
+		t.Errorf("error: got %q %v", got, err)
+	}
+}
+
+// Testing the parsing of the interface command arguments.
+func TestInterfaceParseArgs(t *testing.T) {
+	type testCase struct {
+		args      []string
+		strict    bool
+		sure      bool
+		wantError bool
+	}
+
+	tests := []testCase{
+		{args: []string{"wg0", "-up"}},
+		{args: []string{"wg0", "-dw"}},
+		{args: []string{"wg0", "-d"}},
+		{args: []string{"wg0", "-d", "-strict"}, strict: true},
+		{args: []string{"wg0", "-up", "-strict"}, wantError: true},
+		{args: []string{"wg0", "-d", "-force"}, wantError: true},
+		{args: []string{"wg0", "-d", "-strict", "-strict"}, wantError: true},
+		{args: []string{"wg0", "-d", "-yes-i-am-sure", "-strict"}, strict: true, sure: true},
+		{args: []string{"wg0", "-dw", "-yes-i-am-sure"}, sure: true},
+		{args: []string{"wg0", "-up", "-yes-i-am-sure"}, wantError: true},
+		{args: []string{"wg0", "-dw", "-strict"}, wantError: true},
+		{args: []string{"wg0!", "-up"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := InterfaceCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if cmd.Iface != tc.args[0] || cmd.Action != tc.args[1] || cmd.Strict != tc.strict || cmd.Sure != tc.sure {
+				t.Errorf("error: got %+v", cmd)
+			}
+		})
+	}
+}
+
+// Testing the state transitions of the interface command: only genuine
+// transitions execute commands.
+func TestInterfaceTransitions(t *testing.T) {
+	const upLink = `[{"ifname":"wg0","flags":["POINTOPOINT","NOARP","UP","LOWER_UP"]}]`
+	const downLink = `[{"ifname":"wg0","flags":["POINTOPOINT","NOARP"]}]`
+	const links = `[{"ifname":"lo","linkinfo":{}},{"ifname":"wg1","linkinfo":{"info_kind":"wireguard"}},` +
+		`{"ifname":"awg0","linkinfo":{"info_kind":"amneziawg"}}]`
+
+	type testCase struct {
+		name      string
+		action    string
+		strict    bool
+		exists    bool
+		link      string
+		wantCmds  []string
+		wantNote  string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{
+			name: "up when down", action: help.EnableWgInterfaceFlag, exists: true, link: downLink,
+			wantCmds: []string{shell.FormatCmdIpLinkSet("wg0", shell.IpUp)},
+		},
+		{
+			name: "up when up", action: help.EnableWgInterfaceFlag, exists: true, link: upLink,
+			wantNote: "network interface 'wg0' is already up\n",
+		},
+		{
+			name: "down when up", action: help.DisableWgInterfaceFlag, exists: true, link: upLink,
+			wantCmds: []string{shell.FormatCmdIpLinkSet("wg0", shell.IpDown)},
+		},
+		{
+			name: "down when down", action: help.DisableWgInterfaceFlag, exists: true, link: downLink,
+			wantNote: "network interface 'wg0' is already down\n",
+		},
+		{name: "up when missing", action: help.EnableWgInterfaceFlag, wantError: true},
+		{name: "down when missing", action: help.DisableWgInterfaceFlag, wantError: true},
+		{
+			name: "delete existing", action: help.DelFlag, exists: true,
+			wantCmds: []string{shell.FormatCmdIpLinkDelete("wg0")},
+		},
+		{
+			name: "delete existing strict", action: help.DelFlag, strict: true, exists: true,
+			wantCmds: []string{shell.FormatCmdIpLinkDelete("wg0")},
+		},
+		{
+			name: "delete missing", action: help.DelFlag,
+			wantNote: "network interface 'wg0' does not exist, nothing to delete\n",
+		},
+		{name: "delete missing strict", action: help.DelFlag, strict: true, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var existing []string
+			if tc.exists {
+				existing = []string{"wg0"}
+			}
+			stubLookups(t, existing, nil)
+
+			var note strings.Builder
+			prevNote := noteOut
+			noteOut = &note
+			t.Cleanup(func() { noteOut = prevNote })
+
+			fake := shellmock.Install(t)
+			fake.Outputs[shell.FormatCmdIpShowJSON("wg0")] = tc.link
+			fake.Outputs[shell.IpLinkDetailJSON] = links
+
+			cmd := InterfaceCommand{Iface: "wg0", Action: tc.action, Strict: tc.strict}
+			_, err := cmd.Execute()
+			if tc.wantError {
+				var notFound *get.InterfaceNotFoundError
+				if !errors.Is(err, get.ErrInterfaceNotFound) || !errors.As(err, &notFound) {
+					t.Fatalf("error: got %v, want ErrInterfaceNotFound", err)
+				}
+				if !slices.Equal(notFound.Devices, []string{"wg1", "awg0"}) {
+					t.Errorf("error: got devices %v, want wg1 and awg0", notFound.Devices)
+				}
+			} else if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			var changes []string
+			for _, c := range fake.Commands {
+				if c != shell.FormatCmdIpShowJSON("wg0") && c != shell.IpLinkDetailJSON {
+					changes = append(changes, c)
+				}
+			}
+			if !slices.Equal(changes, tc.wantCmds) {
+				t.Errorf("error: got commands %q, want %q", changes, tc.wantCmds)
+			}
+			if note.String() != tc.wantNote {
+				t.Errorf("error: got note %q, want %q", note.String(), tc.wantNote)
+			}
+		})
+	}
+}
+
+// Testing the guard of the SSH session: deleting or bringing down the
+// network interface carrying it is refused without -yes-i-am-sure.
+func TestInterfaceSessionGuard(t *testing.T) {
+	const upLink = `[{"ifname":"wg0","flags":["POINTOPOINT","NOARP","UP","LOWER_UP"]}]`
+
+	type testCase struct {
+		name      string
+		action    string
+		sure      bool
+		session   string
+		wantCmds  []string
+		wantWarn  bool
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "delete routed", action: help.DelFlag, session: "10.0.0.2 53122 10.0.0.1 22", wantError: true},
+		{name: "down routed", action: help.DisableWgInterfaceFlag, session: "10.0.0.2 53122 10.0.0.1 22", wantError: true},
+		{
+			name: "delete routed sure", action: help.DelFlag, sure: true, session: "10.0.0.2 53122 10.0.0.1 22",
+			wantCmds: []string{shell.FormatCmdIpLinkDelete("wg0")}, wantWarn: true,
+		},
+		{
+			name: "down routed sure", action: help.DisableWgInterfaceFlag, sure: true, session: "10.0.0.2 53122 10.0.0.1 22",
+			wantCmds: []string{shell.FormatCmdIpLinkSet("wg0", shell.IpDown)}, wantWarn: true,
+		},
+		{
+			name: "delete not routed", action: help.DelFlag, session: "192.0.2.7 53122 198.51.100.1 22",
+			wantCmds: []string{shell.FormatCmdIpLinkDelete("wg0")},
+		},
+		{name: "delete without session", action: help.DelFlag, wantCmds: []string{shell.FormatCmdIpLinkDelete("wg0")}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stubLookups(t, []string{"wg0"}, nil)
+			interfaceNetworks = func(iface string) ([]netip.Prefix, error) {
+				if iface != "wg0" {
+					t.Errorf("error: got interface %q, want wg0", iface)
+				}
+				return []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}, nil
+			}
+			sessionEnv = func() map[string]string {
+				if tc.session == "" {
+					return map[string]string{}
+				}
+				return map[string]string{"SSH_CONNECTION": tc.session}
+			}
+
+			var warnings strings.Builder
+			prevWarn := warnOut
+			warnOut = &warnings
+			t.Cleanup(func() { warnOut = prevWarn })
+
+			fake := shellmock.Install(t)
+			fake.Outputs[shell.FormatCmdIpShowJSON("wg0")] = upLink
+
+			cmd := InterfaceCommand{Iface: "wg0", Action: tc.action, Sure: tc.sure}
+			_, err := cmd.Execute()
+			if tc.wantError {
+				if err == nil || !strings.Contains(err.Error(), help.YesIAmSureFlag) {
+					t.Fatalf("error: got %v, want refusal naming %s", err, help.YesIAmSureFlag)
+				}
+			} else if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			var changes []string
+			for _, c := range fake.Commands {
+				if c != shell.FormatCmdIpShowJSON("wg0") {
+					changes = append(changes, c)
+				}
+			}
+			if !slices.Equal(changes, tc.wantCmds) {
+				t.Errorf("error: got commands %q, want %q", changes, tc.wantCmds)
+			}
+			if got := strings.Contains(warnings.String(), "SSH session from 10.0.0.2"); got != tc.wantWarn {
+				t.Errorf("error: got warnings %q", warnings.String())
+			}
+		})
+	}
+}
+
+// Testing the existingRule function checking for rules of this tool: the
+// rules tagged for the interface are preferred over the untagged ones, and
+// the rules of other owners are ignored.
+func TestExistingRule(t *testing.T) {
+	fw := get.IptablesOutput{Chains: []get.IptablesChain{
+		{Name: "INPUT", Rules: []get.IptablesRule{
+			{Id: 1, Target: "ACCEPT", In: "wg1", Out: "eth0", Source: "0.0.0.0/0"},
+		}},
+		{Name: "FORWARD", Rules: []get.IptablesRule{
+			{Id: 2, Target: "ACCEPT", In: "wg0", Out: "eth0", Source: "0.0.0.0/0"},
+			{Id: 3, Target: "DROP", In: "wg2", Out: "eth0", Source: "0.0.0.0/0"},
+			{Id: 4, Target: "ACCEPT", In: "wg3", Out: "eth0", Source: "0.0.0.0/0", Comment: "brgnetuse:wg3"},
+			{Id: 5, Target: "ACCEPT", In: "wg3", Out: "eth0", Source: "0.0.0.0/0"},
+			{Id: 6, Target: "ACCEPT", In: "wg4", Out: "eth0", Source: "0.0.0.0/0", Comment: "brgnetuse:wg5"},
+			{Id: 7, Target: "ACCEPT", In: "wg6", Out: "eth0", Source: "0.0.0.0/0", Comment: "admin"},
+		}},
+	}}
+	nat := get.IptablesOutput{Chains: []get.IptablesChain{
+		{Name: "POSTROUTING", Rules: []get.IptablesRule{
+			{Id: 1, Target: "MASQUERADE", In: "*", Out: "eth0", Source: "10.10.10.0/24"},
+			{Id: 2, Target: "SNAT", In: "*", Out: "eth0", Source: "10.20.0.0/24"},
+			{Id: 3, Target: "MASQUERADE", In: "*", Out: "eth0", Source: "10.30.0.0/24", Comment: "brgnetuse:wg0"},
+			{Id: 4, Target: "MASQUERADE", In: "*", Out: "eth0", Source: "10.40.0.0/24", Comment: "brgnetuse:wg1"},
+		}},
+	}}
+
+	type testCase struct {
+		name      string
+		rules     get.IptablesOutput
+		chain     string
+		target    string
+		in        string
+		subnet    string
+		want      ruleState
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "forward_untagged", rules: fw, chain: "FORWARD", target: "ACCEPT", in: "wg0", subnet: "10.10.10.0/24", want: ruleUntagged},
+		{name: "forward_tagged_first", rules: fw, chain: "FORWARD", target: "ACCEPT", in: "wg3", subnet: "10.10.10.0/24", want: ruleTagged},
+		{name: "forward_other_owner", rules: fw, chain: "FORWARD", target: "ACCEPT", in: "wg4", subnet: "10.10.10.0/24", want: ruleMissing},
+		{name: "forward_other_comment", rules: fw, chain: "FORWARD", target: "ACCEPT", in: "wg6", subnet: "10.10.10.0/24", want: ruleMissing},
+		{name: "other_chain", rules: fw, chain: "FORWARD", target: "ACCEPT", in: "wg1", subnet: "10.10.10.0/24", want: ruleMissing},
+		{name: "other_target", rules: fw, chain: "FORWARD", target: "ACCEPT", in: "wg2", subnet: "10.10.10.0/24", want: ruleMissing},
+		{name: "masquerade_untagged", rules: nat, chain: "POSTROUTING", target: "MASQUERADE", in: "wg0", subnet: "10.10.10.0/24", want: ruleUntagged},
+		{name: "masquerade_tagged", rules: nat, chain: "POSTROUTING", target: "MASQUERADE", in: "wg0", subnet: "10.30.0.0/24", want: ruleTagged},
+		{name: "masquerade_other_owner", rules: nat, chain: "POSTROUTING", target: "MASQUERADE", in: "wg0", subnet: "10.40.0.0/24", want: ruleMissing},
+		{name: "snat", rules: nat, chain: "POSTROUTING", target: "MASQUERADE", in: "wg0", subnet: "10.20.0.0/24", want: ruleMissing},
+		{name: "missing_chain", rules: get.IptablesOutput{}, chain: "FORWARD", target: "ACCEPT", in: "wg0", subnet: "10.10.10.0/24", want: ruleMissing},
+		{name: "invalid_subnet", rules: fw, chain: "FORWARD", target: "ACCEPT", in: "wg0", subnet: "10.10.10.0", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := existingRule(tc.rules, tc.chain, tc.target, tc.in, "eth0", tc.subnet)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error for %q", tc.subnet)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got state %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing that the untagged rules, added before the rules were tagged, are
+// not added again, are recorded as such and are removed without the tag.
+func TestIpRulesUntaggedFallback(t *testing.T) {
+	useMetaDir(t)
+	useFamilies(t, nil)
+	fake := shellmock.Install(t)
+	fake.Outputs[firewall.CmdList] = `Chain FORWARD (policy DROP 0 packets, 0 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    0     0 ACCEPT     all  --  lo     wg9     0.0.0.0/0            0.0.0.0/0
+    0     0 ACCEPT     all  --  wg9    lo      0.0.0.0/0            0.0.0.0/0
+`
+	fake.Outputs[firewall.CmdListNat] = `Chain POSTROUTING (policy ACCEPT 0 packets, 0 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    0     0 MASQUERADE  all  --  any    lo      10.10.9.0/24         anywhere
+    0     0 MASQUERADE  all  --  any    lo      10.10.19.0/24        anywhere             /* brgnetuse:wg8 */
+`
+	useIptablesState(fake)
+
+	run := func(flagCmd string) {
+		t.Helper()
+		cmd := IpIntertfaceCommand{InIface: "wg9", SubNets: []string{"10.10.9.254/24", "10.10.19.254/24"}, OutIfaces: []string{"lo"}, FlagCmd: flagCmd}
+		if _, err := cmd.Execute(); err != nil {
+			t.Fatalf("error: %s: unexpected error: %v", flagCmd, err)
+		}
+	}
+
+	run(help.AddFlag + help.NatFlag)
+
+	// The rule of wg8 does not count for wg9.
+	var got []string
+	for _, c := range fake.Commands {
+		if !strings.Contains(c, " -L ") {
+			got = append(got, c)
+		}
+	}
+	want := []string{firewall.FormatCmdNat(firewall.Append, "lo", "10.10.19.0/24", "wg9")}
+	if !slices.Equal(got, want) {
+		t.Errorf("error: got commands %q, want %q", got, want)
+	}
+
+	forward := set.ForwardRules("lo", "wg9")
+	wantRules := []peermeta.Rule{
+		set.UntaggedRule(forward[0]),
+		set.UntaggedRule(forward[1]),
+		set.UntaggedRule(set.NATRule("lo", "10.10.9.0/24", "wg9")),
+		set.NATRule("lo", "10.10.19.0/24", "wg9"),
+	}
+	meta, _ := peermeta.LoadInterface("wg9")
+	if !slices.Equal(meta.Rules, wantRules) {
+		t.Errorf("error: got recorded rules %+v, want %+v", meta.Rules, wantRules)
+	}
+
+	fake.Commands = nil
+	run(help.DelFlag + help.FirewallFlag)
+	for _, cmd := range []string{
+		"iptables -D FORWARD -i lo -o wg9 -j ACCEPT",
+		"iptables -D FORWARD -i wg9 -o lo -j ACCEPT",
+	} {
+		if !slices.Contains(fake.Commands, cmd) {
+			t.Errorf("error: missing %q in %q", cmd, fake.Commands)
+		}
+	}
+
+	meta, _ = peermeta.LoadInterface("wg9")
+	if !slices.Equal(meta.Rules, wantRules[2:]) {
+		t.Errorf("error: got recorded rules %+v, want the NAT rules", meta.Rules)
+	}
+}
+
+// Testing that the port rule is deleted in the form it exists, the rule of
+// an input interface (-in) told apart from the one of any interface.
+func TestFirewallPortDelete(t *testing.T) {
+	listing := func(rules ...string) string {
+		out := "Chain INPUT (policy ACCEPT 0 packets, 0 bytes)\n" +
+			" pkts bytes target     prot opt in     out     source               destination\n"
+		for _, rule := range rules {
+			in, options, _ := strings.Cut(rule, " ")
+			out += fmt.Sprintf("    0     0 ACCEPT     udp  --  %-6s *       0.0.0.0/0            0.0.0.0/0            %s\n", in, options)
+		}
+		return out
+	}
+
+	type testCase struct {
+		name    string
+		listing string
+		in      string
+		want    string
+	}
+
+	tests := []testCase{
+		{name: "tagged", listing: listing("* udp dpt:51820 /* brgnetuse */"), want: firewall.FormatCmdPort(firewall.Delete, "51820")},
+		{name: "untagged", listing: listing("* udp dpt:51820"), want: "iptables -D INPUT -p udp --dport 51820 -j ACCEPT"},
+		{name: "other_comment", listing: listing("* udp dpt:51820 /* admin */"), want: firewall.FormatCmdPort(firewall.Delete, "51820")},
+		{name: "other_port", listing: listing("* udp dpt:51821"), want: firewall.FormatCmdPort(firewall.Delete, "51820")},
+		{
+			name:    "in_tagged",
+			listing: listing("* udp dpt:51820", "eth0 udp dpt:51820 /* brgnetuse */"),
+			in:      "eth0",
+			want:    firewall.FormatCmdPortIn(firewall.Delete, "51820", "eth0"),
+		},
+		{
+			name:    "in_untagged",
+			listing: listing("* udp dpt:51820 /* brgnetuse */", "eth0 udp dpt:51820"),
+			in:      "eth0",
+			want:    "iptables -D INPUT -i eth0 -p udp --dport 51820 -j ACCEPT",
+		},
+		{
+			name:    "any_beside_in",
+			listing: listing("eth0 udp dpt:51820", "* udp dpt:51820 /* brgnetuse */"),
+			want:    firewall.FormatCmdPort(firewall.Delete, "51820"),
+		},
+		{
+			name:    "any_untagged_beside_in",
+			listing: listing("eth0 udp dpt:51820 /* brgnetuse */", "* udp dpt:51820"),
+			want:    "iptables -D INPUT -p udp --dport 51820 -j ACCEPT",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := shellmock.Install(t)
+			fake.Outputs[firewall.CmdList] = tc.listing
+
+			args := []string{"-u", "-d", "51820"}
+			if tc.in != "" {
+				args = append(args, "-in", tc.in)
+			}
+			cmd := FirewallPortCommand{}
+			if _, err := cmd.ParseArgs(args); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if _, err := cmd.Execute(); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if last := fake.Commands[len(fake.Commands)-1]; last != tc.want {
+				t.Errorf("error: got command %q, want %q", last, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the arguments of `-fr -u -a|-d [port] [-in iface]`.
+func TestFirewallPortParseArgs(t *testing.T) {
+	type testCase struct {
+		args      []string
+		want      string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{args: []string{"-u", "-a", "51820"}, want: firewall.FormatCmdPort(firewall.Append, "51820")},
+		{args: []string{"-u", "-a", "51820", "-in", "eth0"}, want: firewall.FormatCmdPortIn(firewall.Append, "51820", "eth0")},
+		{args: []string{"-u", "-d", "51820", "-in", "eth0"}, want: firewall.FormatCmdPortIn(firewall.Delete, "51820", "eth0")},
+		{args: []string{"-u", "-a", "51820", "-in"}, wantError: true},
+		{args: []string{"-u", "-a", "51820", "-i", "eth0"}, wantError: true},
+		{args: []string{"-u", "-a", "51820", "-in", "eth/0"}, wantError: true},
+		{args: []string{"-u", "-a", "port", "-in", "eth0"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := FirewallPortCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error for %v", tc.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if cmd.Cmd != tc.want {
+				t.Errorf("error: got command %q, want %q", cmd.Cmd, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the argument parsing of the persist command.
+func TestPersistParseArgs(t *testing.T) {
+	type testCase struct {
+		args      []string
+		wantPath  string
+		wantForce bool
+		wantUnit  bool
+		wantError bool
+	}
+
+	tests := []testCase{
+		{args: []string{"-save"}, wantPath: set.DefaultRulesPath},
+		{args: []string{"-save", "/tmp/wg.rules"}, wantPath: "/tmp/wg.rules"},
+		{args: []string{"-save", "-force", "-unit"}, wantPath: set.DefaultRulesPath, wantForce: true, wantUnit: true},
+		{args: []string{"-save", "/tmp/wg.rules", "-unit"}, wantPath: "/tmp/wg.rules", wantUnit: true},
+		{args: []string{"-restore"}, wantPath: set.DefaultRulesPath},
+		{args: []string{"-restore", "/tmp/wg.rules"}, wantPath: "/tmp/wg.rules"},
+		{args: []string{"-restore", "-"}, wantPath: "-"},
+		{args: []string{"-save", "-"}, wantError: true},
+		{args: []string{"-restore", "-force"}, wantError: true},
+		{args: []string{"-restore", "-unit"}, wantError: true},
+		{args: []string{"-save", "/tmp/a", "/tmp/b"}, wantError: true},
+		{args: []string{"-save", "-force", "-force"}, wantError: true},
+		{args: []string{"-save", "-a"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := PersistCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError != (err != nil) {
+				t.Fatalf("error: args %q, got error %v, want error %t", tc.args, err, tc.wantError)
+			}
+			if tc.wantError {
+				return
+			}
+			if cmd.Path != tc.wantPath || cmd.Force != tc.wantForce || cmd.Unit != tc.wantUnit {
+				t.Errorf("error: got %+v", cmd)
+			}
+		})
+	}
+}
+
+// Testing the save command writing the restore unit next to the rules.
+func TestPersistSaveUnit(t *testing.T) {
+	fake := shellmock.Install(t)
+	fake.Outputs[firewall.CmdSave] = "*filter\n" +
+		"-A INPUT -p udp -m udp --dport 51820 -m comment --comment brgnetuse -j ACCEPT\nCOMMIT\n"
+
+	dir := t.TempDir()
+	prevUnit, prevNote := restoreUnitPath, noteOut
+	restoreUnitPath = filepath.Join(dir, "brgnetuse-restore.service")
+	var note strings.Builder
+	noteOut = &note
+	t.Cleanup(func() { restoreUnitPath, noteOut = prevUnit, prevNote })
+
+	cmd := PersistCommand{}
+	if _, err := cmd.ParseArgs([]string{"-save", filepath.Join(dir, "brgnetuse.rules"), "-unit"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if _, err := cmd.Execute(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	unit, err := os.ReadFile(restoreUnitPath)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !strings.Contains(string(unit), " -fr -restore "+filepath.Join(dir, "brgnetuse.rules")) {
+		t.Errorf("error: got unit\n%s", unit)
+	}
+	if !strings.Contains(note.String(), "saved 1 rule(s)") || !strings.Contains(note.String(), "systemctl enable brgnetuse-restore.service") {
+		t.Errorf("error: got output %q", note.String())
+	}
+}
+
+// Testing the -force flag of the peer command.
+func TestPeerParseForce(t *testing.T) {
+	type testCase struct {
+		args      []string
+		force     bool
+		wantError bool
+	}
+
+	tests := []testCase{
+		{args: []string{"wg0", "-pr", "AAAA=", "-a", "10.0.0.0/24", "-force"}, force: true},
+		{args: []string{"wg0", "-pr", "AAAA=", "-a", "10.0.0.0/24", "-force", "-name", "office"}, force: true},
+		{args: []string{"wg0", "-pr", "AAAA=", "-a", "10.0.0.0/24"}},
+		{args: []string{"wg0", "-pr", "AAAA=", "-d", "-force"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := PeerCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError != (err != nil) {
+				t.Fatalf("error: args %q, got error %v, want error %t", tc.args, err, tc.wantError)
+			}
+			if err == nil && (cmd.Force != tc.force || !slices.Equal(cmd.AllowIps, []string{"10.0.0.0/24"})) {
+				t.Errorf("error: got force %t, allowed ips %q", cmd.Force, cmd.AllowIps)
+			}
+		})
+	}
+}
+
+// Testing the allowed IP conflict check of AmneziaWG peers.
+func TestAwgConflicts(t *testing.T) {
+	fake := shellmock.Install(t)
+	fake.Outputs["awg show wg0 dump"] = "priv\tpub\t51820\toff\n" +
+		"BBBB=\t(none)\t(none)\t10.0.0.2/32,fd00::2/128\t0\t0\t0\toff\n" +
+		"CCCC=\t(none)\t(none)\t(none)\t0\t0\t0\toff\n"
+
+	type testCase struct {
+		key       string
+		allowIps  []string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{key: "AAAA=", allowIps: []string{"10.0.0.3/32"}},
+		{key: "AAAA=", allowIps: []string{"10.0.0.0/24"}, wantError: true},
+		{key: "AAAA=", allowIps: []string{"10.0.0.3/32", "fd00::/64"}, wantError: true},
+		{key: "BBBB=", allowIps: []string{"10.0.0.0/24"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.allowIps, " "), func(t *testing.T) {
+			err := awgConflicts("wg0", tc.key, tc.allowIps)
+			if tc.wantError {
+				if err == nil || !strings.Contains(err.Error(), "'BBBB='") {
+					t.Fatalf("error: got %v, want the conflict with BBBB=", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// Testing the peer command parsing with the flags in any order, and the
+// rejection of malformed arguments.
+func TestPeerParseArgs(t *testing.T) {
+	type testCase struct {
+		args      []string
+		want      PeerCommand
+		wantFlag  string
+		wantError bool
+	}
+
+	peer := func(flagCmd string, allowIps ...string) PeerCommand {
+		return PeerCommand{Iface: "wg0", Publickey: "AAAA=", FlagCmd: flagCmd, AllowIps: allowIps}
+	}
+	with := func(cmd PeerCommand, update func(*PeerCommand)) PeerCommand {
+		update(&cmd)
+		return cmd
+	}
+	base := []string{"wg0", "-pr", "AAAA="}
+	args := func(rest ...string) []string {
+		return append(slices.Clone(base), rest...)
+	}
+
+	full := with(peer(help.AddFlag, "10.0.0.1/32"), func(p *PeerCommand) {
+		p.KeepAlive = "10"
+		p.EndPointHost = "172.168.85.1:65535"
+	})
+
+	tests := []testCase{
+		// Documented orderings.
+		{args: args("-a", "10.0.0.1/32"), want: peer(help.AddFlag, "10.0.0.1/32")},
+		{args: args("-a", "10.0.0.1/32", "-kp", "10", "-eh", "172.168.85.1:65535"), want: full},
+		{args: args("-d"), want: peer(help.DelFlag)},
+		{args: args("-limit", "10mbit"), want: with(peer(""), func(p *PeerCommand) { p.Limit, p.Rate = "10mbit", 10_000_000 })},
+		{args: args("-quota", "50gb", "-quota-period", "30d"), want: with(peer(""), func(p *PeerCommand) {
+			p.Quota, p.QuotaLimit, p.QuotaPeriod = "50gb", 50_000_000_000, 30*24*time.Hour
+		})},
+		{args: args("-quota", "off"), want: with(peer(""), func(p *PeerCommand) { p.Quota = "off" })},
+		{args: args("-a", "10.0.0.1/32", "-quota", "1gib"), want: with(peer(help.AddFlag, "10.0.0.1/32"), func(p *PeerCommand) {
+			p.Quota, p.QuotaLimit = "1gib", 1<<30
+		})},
+
+		// Any order of the flags.
+		{args: args("-a", "10.0.0.1/32", "-eh", "172.168.85.1:65535", "-kp", "10"), want: full},
+		{args: args("-kp", "10", "-a", "10.0.0.1/32", "-eh", "172.168.85.1:65535"), want: full},
+		{args: args("-eh", "172.168.85.1:65535", "-kp", "10", "-a", "10.0.0.1/32"), want: full},
+		{args: args("-kp", "10", "-eh", "172.168.85.1:65535", "-a", "10.0.0.1/32"), want: full},
+		{args: args("-name", "alice", "-a", "10.0.0.1/32"), want: with(peer(help.AddFlag, "10.0.0.1/32"), func(p *PeerCommand) { p.Name = "alice" })},
+		{args: args("-force", "-a", "10.0.0.0/24"), want: with(peer(help.AddFlag, "10.0.0.0/24"), func(p *PeerCommand) { p.Force = true })},
+		{args: args("-limit", "off", "-a", "10.0.0.1/32"), want: with(peer(help.AddFlag, "10.0.0.1/32"), func(p *PeerCommand) { p.Limit = "off" })},
+
+		// Allowed IP lists.
+		{args: args("-a", "10.0.0.1/32,fd00::1/128"), want: peer(help.AddFlag, "10.0.0.1/32", "fd00::1/128")},
+		{args: args("-a", "10.0.0.1/32", "fd00::1/128", "-kp", "5"), want: with(peer(help.AddFlag, "10.0.0.1/32", "fd00::1/128"), func(p *PeerCommand) { p.KeepAlive = "5" })},
+		{args: args("-a", "10.0.0.1/32,", "10.0.0.2/32"), want: peer(help.AddFlag, "10.0.0.1/32", "10.0.0.2/32")},
+		{args: args("-a", "10.0.0.2/32", "10.0.0.0/24", "-kp", "25"), want: with(peer(help.AddFlag, "10.0.0.2/32", "10.0.0.0/24"), func(p *PeerCommand) { p.KeepAlive = "25" })},
+		{args: args("-kp", "25", "-a", "10.0.0.2/32", "10.0.0.0/24"), want: with(peer(help.AddFlag, "10.0.0.2/32", "10.0.0.0/24"), func(p *PeerCommand) { p.KeepAlive = "25" })},
+
+		// Repeated -a, alone and mixed with lists and other flags.
+		{args: args("-a", "10.0.0.2/32", "-a", "10.0.0.0/24"), want: peer(help.AddFlag, "10.0.0.2/32", "10.0.0.0/24")},
+		{args: args("-a", "10.0.0.2/32", "-kp", "25", "-a", "10.0.0.0/24"), want: with(peer(help.AddFlag, "10.0.0.2/32", "10.0.0.0/24"), func(p *PeerCommand) { p.KeepAlive = "25" })},
+		{args: args("-a", "10.0.0.2/32,fd00::2/128", "-a", "10.0.0.0/24"), want: peer(help.AddFlag, "10.0.0.2/32", "fd00::2/128", "10.0.0.0/24")},
+		{args: args("-a", "10.0.0.2/32", "10.0.0.3/32", "-a", "10.0.1.0/24,10.0.2.0/24", "-name", "alice"), want: with(peer(help.AddFlag, "10.0.0.2/32", "10.0.0.3/32", "10.0.1.0/24", "10.0.2.0/24"), func(p *PeerCommand) { p.Name = "alice" })},
+		{args: args("-a", " 10.0.0.2/32 , 10.0.0.3/32 "), want: peer(help.AddFlag, "10.0.0.2/32", "10.0.0.3/32")},
+		{args: args("-a", "10.0.0.2/32", "-a"), wantFlag: help.AddFlag, wantError: true},
+		{args: args("-a", "10.0.0.2/32", "-a", ","), wantFlag: help.AddFlag, wantError: true},
+		{args: args("-a", "10.0.0.2/32", "-a", "10.0.0.300/32"), wantFlag: "10.0.0.300/32", wantError: true},
+		{args: args("-a", "10.0.0.2/32,bad"), wantFlag: "bad", wantError: true},
+		{args: args("-a", "10.0.0.2/32", "-a", "10.0.0.2/32"), wantFlag: "10.0.0.2/32", wantError: true},
+		{args: args("-a", "10.0.0.2/32,10.0.0.2/32"), wantFlag: "10.0.0.2/32", wantError: true},
+		{args: args("-a", "10.0.0.2/32", "-d", "-a", "10.0.0.3/32"), wantFlag: help.DelFlag, wantError: true},
+
+		// Missing or invalid values.
+		{args: args("-a"), wantFlag: help.AddFlag, wantError: true},
+		{args: args("-a", "-kp", "10"), wantFlag: help.AddFlag, wantError: true},
+		{args: args("-a", "10.0.0.1"), wantFlag: "10.0.0.1", wantError: true},
+		{args: args("-a", "10.0.0.1/32", "wg1"), wantFlag: "wg1", wantError: true},
+		{args: args("-a", "10.0.0.1/32", "-kp"), wantFlag: help.KeepaliveFlag, wantError: true},
+		{args: args("-a", "10.0.0.1/32", "-kp", "often"), wantFlag: help.KeepaliveFlag, wantError: true},
+		{args: args("-a", "10.0.0.1/32", "-kp", "-eh", "172.168.85.1:65535"), wantFlag: help.KeepaliveFlag, wantError: true},
+		{args: args("-a", "10.0.0.1/32", "-eh"), wantFlag: help.EndPointHostFlag, wantError: true},
+		{args: args("-a", "10.0.0.1/32", "-name", ""), wantFlag: help.PeerNameFlag, wantError: true},
+		{args: args("-a", "10.0.0.1/32", "-expires", "July"), wantFlag: help.PeerExpiresFlag, wantError: true},
+
+		// Unknown and repeated flags.
+		{args: args("-a", "10.0.0.1/32", "-x"), wantFlag: "-x", wantError: true},
+		{args: args("-a", "10.0.0.1/32", "-eh", "172.168.85.1:65535", "extra"), wantFlag: "extra", wantError: true},
+		{args: args("extra", "-a", "10.0.0.1/32"), wantFlag: "extra", wantError: true},
+		{args: args("-d", "-d"), wantFlag: help.DelFlag, wantError: true},
+		{args: []string{"wg0", "-pr", "-a", "10.0.0.1/32"}, wantFlag: help.AddFlag, wantError: true},
+
+		// Exactly one action.
+		{args: args("-a", "10.0.0.1/32", "-d"), wantFlag: help.DelFlag, wantError: true},
+		{args: args("-d", "-a", "10.0.0.1/32"), wantFlag: help.DelFlag, wantError: true},
+		{args: args("-kp", "10"), wantFlag: help.KeepaliveFlag, wantError: true},
+		{args: args("-force"), wantFlag: help.ForceFlag, wantError: true},
+		{args: args("-d", "-name", "alice"), wantFlag: help.PeerNameFlag, wantError: true},
+		{args: args("-d", "-limit", "10mbit"), wantFlag: help.LimitFlag, wantError: true},
+		{args: args("-d", "-quota", "50gb"), wantFlag: help.QuotaFlag, wantError: true},
+		{args: args("-quota", "lots"), wantFlag: help.QuotaFlag, wantError: true},
+		{args: args("-quota-period", "30d"), wantFlag: help.QuotaPeriodFlag, wantError: true},
+		{args: args("-quota", "off", "-quota-period", "30d"), wantFlag: help.QuotaPeriodFlag, wantError: true},
+		{args: args("-quota", "50gb", "-quota-period", "monthly"), wantFlag: help.QuotaPeriodFlag, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := PeerCommand{}
+			flag, err := cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error, got %+v", cmd)
+				}
+				if flag != tc.wantFlag {
+					t.Errorf("error: got flag %q, want %q (%v)", flag, tc.wantFlag, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if flag != help.PeerFlag {
+				t.Errorf("error: got flag %q, want %q", flag, help.PeerFlag)
+			}
+
+			got, want := cmd, tc.want
+			if !slices.Equal(got.AllowIps, want.AllowIps) {
+				t.Errorf("error: got allowed ips %q, want %q", got.AllowIps, want.AllowIps)
+			}
+			got.AllowIps, want.AllowIps = nil, nil
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("error: got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// Testing the keepalive validation of the peer command.
+func TestPeerParseKeepalive(t *testing.T) {
+	type testCase struct {
+		value     string
+		want      string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{value: "0", want: "0"},
+		{value: "25", want: "25"},
+		{value: "025", want: "25"},
+		{value: "65535", want: "65535"},
+		{value: "65536", wantError: true},
+		{value: "999999", wantError: true},
+		{value: "-1", wantError: true},
+		{value: "abc", wantError: true},
+		{value: "10s", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			cmd := PeerCommand{}
+			flag, err := cmd.ParseArgs([]string{"wg0", "-pr", "AAAA=", "-a", "10.0.0.1/32", "-kp", tc.value})
+			if tc.wantError {
+				if err == nil || flag != help.KeepaliveFlag {
+					t.Fatalf("error: got %q, %v, want the keepalive error", flag, err)
+				}
+				if !strings.Contains(err.Error(), "'-kp'") || !strings.Contains(err.Error(), "0-65535") {
+					t.Errorf("error: got %q, want the flag and the range", err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if cmd.KeepAlive != tc.want {
+				t.Errorf("error: got keepalive %q, want %q", cmd.KeepAlive, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the warn function: the notice goes to warnOut in yellow.
+func TestWarn(t *testing.T) {
+	var warnings strings.Builder
+	prevWarn := warnOut
+	warnOut = &warnings
+	t.Cleanup(func() { warnOut = prevWarn })
+
+	warn("endpoint '89.89.89.1:51820' of peer 'A' is already used by peer 'B'")
+
+	want := "\x1b[33mwarning: endpoint '89.89.89.1:51820' of peer 'A' is already used by peer 'B'\x1b[0m\n"
+	if warnings.String() != want {
+		t.Errorf("error: got %q, want %q", warnings.String(), want)
+	}
+}
+
+// Testing the reportWarning function: the warning of the set package is
+// printed with its code and kept as a result of the JSON output.
+func TestReportWarning(t *testing.T) {
+	var out strings.Builder
+	prevWarn, prevWarnings := warnOut, warnings
+	warnOut, warnings = &out, nil
+	t.Cleanup(func() { warnOut, warnings = prevWarn, prevWarnings })
+
+	reportWarning(set.Warning{
+		Code:      set.WarnAllowedIPCovered,
+		Message:   "allowed IP '10.10.10.2/32' of peer 'A' is covered by its allowed IP '10.10.10.0/24'",
+		Interface: "wg0",
+	})
+
+	want := "\x1b[33mwarning: allowed IP '10.10.10.2/32' of peer 'A' is covered by its allowed IP '10.10.10.0/24' [BRG-W002]\x1b[0m\n"
+	if out.String() != want {
+		t.Errorf("error: got %q, want %q", out.String(), want)
+	}
+
+	var results strings.Builder
+	if err := writeResults(&results, append([]Result{applied("peer-add", "A", "")}, warnings...)); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"status": "warning"`, `"code": "BRG-W002"`, `"target": "wg0"`, `"action": "warning"`} {
+		if !strings.Contains(results.String(), field) {
+			t.Errorf("error: results %s miss %s", results.String(), field)
+		}
+	}
+}
+
+// Testing the AmneziaWG port, firewall mark and peer operations over the
+// UAPI socket served by brgaddawg: the awg tool is never run.
+func TestAwgUAPI(t *testing.T) {
+	stubLookups(t, []string{"awg0"}, map[string]string{"awg0": help.Env_Awg_Type})
+	useMetaDir(t)
+	server := useAwgSocket(t, "awg0")
+	fake := shellmock.Install(t)
+
+	peer, _ := wgtypes.GenerateKey()
+	peerHex := hex.EncodeToString(peer[:])
+
+	update := UpdateInterfaceCommand{Iface: "awg0", Value: "51821", FwMark: "51820", Force: true, FlagCmd: help.PortFlag}
+	if _, err := update.Execute(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	add := PeerCommand{
+		Iface:        "awg0",
+		Publickey:    peer.String(),
+		AllowIps:     []string{"10.10.10.2/32", "fd00::2/128"},
+		KeepAlive:    "25",
+		EndPointHost: "89.89.89.1:51820",
+		Force:        true,
+		FlagCmd:      help.AddFlag,
+	}
+	if _, err := add.Execute(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	del := PeerCommand{Iface: "awg0", Publickey: peer.String(), FlagCmd: help.DelFlag}
+	if _, err := del.Execute(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	want := []string{
+		"set=1\nlisten_port=51821\n",
+		"set=1\nfwmark=51820\n",
+		"set=1\npublic_key=" + peerHex + "\nendpoint=89.89.89.1:51820\npersistent_keepalive_interval=25\n" +
+			"allowed_ip=10.10.10.2/32\nallowed_ip=fd00::2/128\n",
+		"set=1\npublic_key=" + peerHex + "\nremove=true\n",
+	}
+	if got := server.Requests(); !slices.Equal(got, want) {
+		t.Errorf("error: got requests\n%q\nwant\n%q", got, want)
+	}
+	if awg := fake.Matching("awg set"); len(awg) != 0 {
+		t.Errorf("error: got awg commands %q", awg)
+	}
+
+	// A refused request fails the command.
+	server.SetErrno(22)
+	if _, err := update.Execute(); err == nil || !strings.Contains(err.Error(), "errno=22") {
+		t.Errorf("error: got %v, want the errno", err)
+	}
+}
+
+// Testing the port check of an AmneziaWG interface: its current port,
+// read over the UAPI socket, is not a conflict.
+func TestAwgCheckListenPortUAPI(t *testing.T) {
+	stubLookups(t, []string{"awg0"}, map[string]string{"awg0": help.Env_Awg_Type})
+	server := useAwgSocket(t, "awg0")
+	wgmock.Install(t, &wgtypes.Device{Name: "wg0", ListenPort: 51822})
+
+	// The socket of awg0 on port 51821 (0xCA6D).
+	prev := proc.ProcDir
+	proc.ProcDir = t.TempDir()
+	t.Cleanup(func() { proc.ProcDir = prev })
+	if err := os.MkdirAll(filepath.Join(proc.ProcDir, "net"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	udp := "  sl  local_address rem_address   st\n  0: 00000000:CA6D 00000000:0000 07\n"
+	if err := os.WriteFile(filepath.Join(proc.ProcDir, "net", "udp"), []byte(udp), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	port := 51821
+	if err := uapi.Configure(server.Path, wgtypes.Config{ListenPort: &port}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := awgCheckListenPort("awg0", "51821"); err != nil {
+		t.Errorf("error: current port refused: %v", err)
+	}
+	if err := awgCheckListenPort("awg0", "51822"); err == nil || !strings.Contains(err.Error(), "'wg0'") {
+		t.Errorf("error: got %v, want the conflict with wg0", err)
+	}
+	if err := awgCheckListenPort("awg0", "51823"); err != nil {
+		t.Errorf("error: free port refused: %v", err)
+	}
+}
+
+// Testing the listen port `auto`: the current port is kept when in the
+// range, otherwise the lowest port neither used by a device nor bound by a
+// socket is set, and opened with -fr.
+func TestUpdateAutoPort(t *testing.T) {
+	prev := proc.ProcDir
+	proc.ProcDir = t.TempDir()
+	t.Cleanup(func() { proc.ProcDir = prev })
+	if err := os.MkdirAll(filepath.Join(proc.ProcDir, "net"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// A socket bound to 51821 (0xCA6D).
+	udp := "  sl  local_address rem_address   st\n  0: 00000000:CA6D 00000000:0000 07\n"
+	if err := os.WriteFile(filepath.Join(proc.ProcDir, "net", "udp"), []byte(udp), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		name       string
+		current    int
+		portRange  string
+		wantPort   string
+		wantStatus []string
+		wantError  bool
+	}
+
+	tests := []testCase{
+		{name: "lowest_free", current: 40000, wantPort: "51822", wantStatus: []string{StatusApplied, StatusApplied}},
+		{name: "current_in_range", current: 51830, wantPort: "51830", wantStatus: []string{StatusApplied, StatusSkipped}},
+		{name: "custom_range", current: 0, portRange: "52000-52010", wantPort: "52000", wantStatus: []string{StatusApplied, StatusApplied}},
+		{name: "range_full", current: 0, portRange: "51820-51821", wantError: true},
+		{name: "invalid_range", current: 0, portRange: "51999-51820", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(help.Env_Default_PortRange, tc.portRange)
+			stubLookups(t, []string{"wg0", "wg1"}, nil)
+			wgmock.Install(t,
+				&wgtypes.Device{Name: "wg0", ListenPort: tc.current},
+				&wgtypes.Device{Name: "wg1", ListenPort: 51820},
+			)
+			fake := shellmock.Install(t)
+			prevNote := noteOut
+			noteOut = io.Discard
+			t.Cleanup(func() { noteOut = prevNote })
+
+			cmd := UpdateInterfaceCommand{Iface: "wg0", Value: help.AutoPortValue, PortRule: true, FlagCmd: help.PortFlag}
+			results, err := cmd.Execute()
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error, got %+v", results)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			if cmd.Value != tc.wantPort {
+				t.Errorf("error: got port %s, want %s", cmd.Value, tc.wantPort)
+			}
+			var statuses []string
+			for _, result := range results {
+				statuses = append(statuses, result.Status)
+			}
+			if !reflect.DeepEqual(statuses, tc.wantStatus) {
+				t.Errorf("error: got statuses %q, want %q", statuses, tc.wantStatus)
+			}
+			if rule := firewall.FormatCmdPort(firewall.Append, tc.wantPort); len(fake.Matching(rule)) != 1 {
+				t.Errorf("error: got commands %q, want %q", fake.Commands, rule)
+			}
+		})
+	}
+}
+
+// Testing the key rotation of an AmneziaWG interface over the UAPI socket.
+func TestUpdateRotateAwgUAPI(t *testing.T) {
+	stubLookups(t, []string{"awg0"}, map[string]string{"awg0": help.Env_Awg_Type})
+	useMetaDir(t)
+	server := useAwgSocket(t, "awg0")
+	fake := shellmock.Install(t)
+
+	oldKey, _ := wgtypes.GeneratePrivateKey()
+	server.SetPrivateKey(oldKey)
+
+	cmd := UpdateInterfaceCommand{Iface: "awg0", FlagCmd: help.PrivateKeyFlag, Rotate: true}
+	if _, err := cmd.Execute(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(fake.Commands) != 0 {
+		t.Errorf("error: got commands %q", fake.Commands)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 1 || !strings.HasPrefix(requests[0], "set=1\nprivate_key=") {
+		t.Fatalf("error: got requests %q", requests)
+	}
+
+	meta, _ := peermeta.LoadInterface("awg0")
+	if meta.PreviousPublicKey != oldKey.PublicKey().String() || meta.PublicKey == meta.PreviousPublicKey {
+		t.Errorf("error: got rotation %q -> %q", meta.PreviousPublicKey, meta.PublicKey)
+	}
+}
+
+// Testing the JSON results (-js) of representative commands, the golden
+// outputs define the schema.
+func TestResultsJSON(t *testing.T) {
+	natRule := "Chain POSTROUTING (policy ACCEPT 0 packets, 0 bytes)\n" +
+		" pkts bytes target     prot opt in     out     source               destination\n" +
+		"    0     0 MASQUERADE  all  --  any    lo      10.10.9.0/24         anywhere             /* brgnetuse:wg9 */\n"
+
+	type testCase struct {
+		name  string
+		nat   string
+		build func(t *testing.T) Command
+		want  string
+	}
+
+	tests := []testCase{
+		{
+			name: "nat added",
+			build: func(t *testing.T) Command {
+				return &IpIntertfaceCommand{
+					InIface: "wg9", SubNets: []string{"10.10.9.254/24"},
+					OutIfaces: []string{"lo"}, FlagCmd: help.AddFlag + help.NatFlag,
+				}
+			},
+			want: `[
+  {
+    "action": "forward-rule-add",
+    "target": "wg9",
+    "status": "applied",
+    "detail": "lo"
+  },
+  {
+    "action": "nat-rule-add",
+    "target": "wg9",
+    "status": "applied",
+    "detail": "10.10.9.0/24 -> lo"
+  }
+]
+`,
+		},
+		{
+			name: "nat present",
+			nat:  natRule,
+			build: func(t *testing.T) Command {
+				return &IpIntertfaceCommand{
+					InIface: "wg9", SubNets: []string{"10.10.9.254/24"},
+					OutIfaces: []string{"lo"}, FlagCmd: help.AddFlag + help.NatFlag,
+				}
+			},
+			want: `[
+  {
+    "action": "forward-rule-add",
+    "target": "wg9",
+    "status": "applied",
+    "detail": "lo"
+  },
+  {
+    "action": "nat-rule-add",
+    "target": "wg9",
+    "status": "skipped",
+    "detail": "10.10.9.0/24 -> lo"
+  }
+]
+`,
+		},
+		{
+			name: "forwarding",
+			build: func(t *testing.T) Command {
+				cmd := &IpForwardingCommand{}
+				if _, err := cmd.ParseArgs([]string{help.ForwIpv4Flag, help.AddFlag}); err != nil {
+					t.Fatal(err)
+				}
+				return cmd
+			},
+			want: `[
+  {
+    "action": "forwarding",
+    "target": "ipv4",
+    "status": "applied",
+    "detail": "enabled"
+  }
+]
+`,
+		},
+		{
+			name: "addresses",
+			build: func(t *testing.T) Command {
+				return &IpIntertfaceCommand{
+					InIface: "wg9", SubNets: []string{"10.10.9.254/24", "fd00::1/64"}, FlagCmd: help.AddFlag,
+				}
+			},
+			want: `[
+  {
+    "action": "address-add",
+    "target": "wg9",
+    "status": "applied",
+    "detail": "10.10.9.254/24"
+  },
+  {
+    "action": "address-add",
+    "target": "wg9",
+    "status": "applied",
+    "detail": "fd00::1/64"
+  }
+]
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useMetaDir(t)
+			useFamilies(t, nil)
+			fake := shellmock.Install(t)
+			fake.Outputs[firewall.CmdList] = ""
+			fake.Outputs[firewall.CmdListNat] = tc.nat
+			useIptablesState(fake)
+
+			results, err := tc.build(t).Execute()
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			var out strings.Builder
+			if err := writeResults(&out, results); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if out.String() != tc.want {
+				t.Errorf("error: got output\n%s\nwant\n%s", out.String(), tc.want)
+			}
+		})
+	}
+}
+
+// Testing the JSON result of a failed command and of a command without
+// changes.
+func TestResultsJSONFailed(t *testing.T) {
+	var out strings.Builder
+	err := &get.InterfaceNotFoundError{Name: "wg9"}
+	if err := writeResults(&out, []Result{failed([]string{"-i", "wg9", "-ip", "10.10.9.254/24", "-a", "-n"}, err)}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	want := `[
+  {
+    "action": "-i -ip -a -n",
+    "target": "wg9",
+    "status": "failed",
+    "detail": "error: network interface 'wg9' not found",
+    "code": "BRG-E003"
+  }
+]
+`
+	if out.String() != want {
+		t.Errorf("error: got output\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := writeResults(&out, nil); err != nil || out.String() != "[]\n" {
+		t.Errorf("error: got %q %v, want an empty array", out.String(), err)
+	}
+}
+
+// Command returning fixed results, for the last change record.
+type changeCommand struct {
+	iface   string
+	results []Result
+	err     error
+}
+
+func (c *changeCommand) ParseArgs([]string) (string, error) { return "", nil }
+func (c *changeCommand) Execute() ([]Result, error)         { return c.results, c.err }
+func (c *changeCommand) ChangedInterface() string           { return c.iface }
+
+// Testing that the first applied change of an interface command is
+// recorded as the last change of the interface.
+func TestRecordChange(t *testing.T) {
+	type testCase struct {
+		name   string
+		cmd    *changeCommand
+		wantOp string
+	}
+
+	tests := []testCase{
+		{
+			name: "applied",
+			cmd: &changeCommand{iface: "wg0", results: []Result{
+				skipped("port-rule-add", "51820", "unchanged"),
+				applied("peer-add", "AAAA=", ""),
+				applied("port-rule-add", "51821", ""),
+			}},
+			wantOp: "peer-add",
+		},
+		{
+			name: "failed_past_change",
+			cmd: &changeCommand{iface: "wg0", results: []Result{applied("listen-port", "wg0", "")},
+				err: errors.New("error: failed")},
+			wantOp: "listen-port",
+		},
+		{
+			name: "unchanged",
+			cmd:  &changeCommand{iface: "wg0", results: []Result{skipped("peer-add", "AAAA=", "unchanged")}},
+		},
+		{
+			name: "deleted",
+			cmd:  &changeCommand{results: []Result{applied("interface-delete", "wg0", "")}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useMetaDir(t)
+
+			if _, err := execute(tc.cmd); (err != nil) != (tc.cmd.err != nil) {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			change, err := peermeta.LoadChange("wg0")
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if change.Operation != tc.wantOp || change.IsZero() != (tc.wantOp == "") {
+				t.Errorf("error: got change %+v, want operation %q", change, tc.wantOp)
+			}
+		})
+	}
+
+	if iface := (&InterfaceCommand{Iface: "wg0", Action: help.DelFlag}).ChangedInterface(); iface != "" {
+		t.Errorf("error: got %q for a deleted interface, want none", iface)
+	}
+}
+
+// Testing that the changes already in place are reported as unchanged
+// (skipped), or fail with strict.
+func TestIdempotent(t *testing.T) {
+	privKey, _ := wgtypes.GeneratePrivateKey()
+	peerKey, _ := wgtypes.GeneratePrivateKey()
+
+	type testCase struct {
+		name       string
+		setup      func(t *testing.T, fake *shellmock.Runner)
+		build      func() Command
+		wantStatus []string
+		wantError  bool
+	}
+
+	wgDevice := func(t *testing.T) {
+		stubLookups(t, []string{"wg0"}, nil)
+		wgmock.Install(t, &wgtypes.Device{
+			Name:       "wg0",
+			ListenPort: 51820,
+			PrivateKey: privKey,
+			PublicKey:  privKey.PublicKey(),
+			Peers: []wgtypes.Peer{{
+				PublicKey:  peerKey.PublicKey(),
+				AllowedIPs: []net.IPNet{{IP: net.IPv4(10, 10, 10, 2).To4(), Mask: net.CIDRMask(32, 32)}},
+			}},
+		})
+	}
+	forwarding := func(t *testing.T, fake *shellmock.Runner) {
+		fake.Outputs[shell.SysctlIpv4Check] = "net.ipv4.ip_forward = 1"
+		fake.Outputs[shell.SysctlIpv6Check] = "net.ipv6.conf.all.forwarding = 0"
+	}
+	secret := handlers.NewSecretKey(privKey)
+
+	tests := []testCase{
+		{
+			name:       "port unchanged",
+			setup:      func(t *testing.T, _ *shellmock.Runner) { wgDevice(t) },
+			build:      func() Command { return &UpdateInterfaceCommand{Iface: "wg0", Value: "51820", FlagCmd: help.PortFlag} },
+			wantStatus: []string{StatusSkipped},
+		},
+		{
+			name:  "port unchanged strict",
+			setup: func(t *testing.T, _ *shellmock.Runner) { wgDevice(t) },
+			build: func() Command {
+				return &UpdateInterfaceCommand{Iface: "wg0", Value: "51820", Strict: true, FlagCmd: help.PortFlag}
+			},
+			wantError: true,
+		},
+		{
+			name:  "port changed",
+			setup: func(t *testing.T, _ *shellmock.Runner) { wgDevice(t) },
+			build: func() Command {
+				return &UpdateInterfaceCommand{Iface: "wg0", Value: "51821", Force: true, FlagCmd: help.PortFlag}
+			},
+			wantStatus: []string{StatusApplied},
+		},
+		{
+			name:  "private key unchanged",
+			setup: func(t *testing.T, _ *shellmock.Runner) { wgDevice(t) },
+			build: func() Command {
+				return &UpdateInterfaceCommand{Iface: "wg0", PrivateKey: secret, FlagCmd: help.PrivateKeyFlag}
+			},
+			wantStatus: []string{StatusSkipped},
+		},
+		{
+			name:  "private key unchanged strict",
+			setup: func(t *testing.T, _ *shellmock.Runner) { wgDevice(t) },
+			build: func() Command {
+				return &UpdateInterfaceCommand{Iface: "wg0", PrivateKey: secret, Strict: true, FlagCmd: help.PrivateKeyFlag}
+			},
+			wantError: true,
+		},
+		{
+			name:  "peer unchanged",
+			setup: func(t *testing.T, _ *shellmock.Runner) { wgDevice(t) },
+			build: func() Command {
+				return &PeerCommand{
+					Iface: "wg0", Publickey: peerKey.PublicKey().String(),
+					AllowIps: []string{"10.10.10.2/32"}, FlagCmd: help.AddFlag,
+				}
+			},
+			wantStatus: []string{StatusSkipped},
+		},
+		{
+			name:  "peer unchanged strict",
+			setup: func(t *testing.T, _ *shellmock.Runner) { wgDevice(t) },
+			build: func() Command {
+				return &PeerCommand{
+					Iface: "wg0", Publickey: peerKey.PublicKey().String(),
+					AllowIps: []string{"10.10.10.2/32"}, Strict: true, FlagCmd: help.AddFlag,
+				}
+			},
+			wantError: true,
+		},
+		{
+			name:  "peer changed",
+			setup: func(t *testing.T, _ *shellmock.Runner) { wgDevice(t) },
+			build: func() Command {
+				return &PeerCommand{
+					Iface: "wg0", Publickey: peerKey.PublicKey().String(),
+					AllowIps: []string{"10.10.10.3/32"}, FlagCmd: help.AddFlag,
+				}
+			},
+			wantStatus: []string{StatusApplied},
+		},
+		{
+			name: "address present",
+			setup: func(t *testing.T, fake *shellmock.Runner) {
+				fake.Outputs[shell.FormatCmdIpShowJSON("wg0")] = ipShowWg0
+			},
+			build: func() Command {
+				return &IpIntertfaceCommand{
+					InIface: "wg0", SubNets: []string{"10.10.10.1/24", "10.10.11.1/24"}, FlagCmd: help.AddFlag,
+				}
+			},
+			wantStatus: []string{StatusSkipped, StatusApplied},
+		},
+		{
+			name: "address present strict",
+			setup: func(t *testing.T, fake *shellmock.Runner) {
+				fake.Outputs[shell.FormatCmdIpShowJSON("wg0")] = ipShowWg0
+			},
+			build: func() Command {
+				return &IpIntertfaceCommand{
+					InIface: "wg0", SubNets: []string{"10.10.11.1/24", "fd00::1/64"}, Strict: true, FlagCmd: help.AddFlag,
+				}
+			},
+			wantError: true,
+		},
+		{
+			name:  "forwarding unchanged",
+			setup: forwarding,
+			build: func() Command {
+				return &IpForwardingCommand{Cmd: shell.SysctlIpv4Up, Family: "ipv4", State: "enabled"}
+			},
+			wantStatus: []string{StatusSkipped},
+		},
+		{
+			name:  "forwarding unchanged strict",
+			setup: forwarding,
+			build: func() Command {
+				return &IpForwardingCommand{Cmd: shell.SysctlIpv4Up, Family: "ipv4", State: "enabled", Strict: true}
+			},
+			wantError: true,
+		},
+		{
+			name:  "forwarding changed",
+			setup: forwarding,
+			build: func() Command {
+				return &IpForwardingCommand{Cmd: shell.SysctlIpv6Up, Family: "ipv6", State: "enabled"}
+			},
+			wantStatus: []string{StatusApplied},
+		},
+	}
+
+	prevTimeout := oplock.Timeout
+	oplock.Timeout = 0
+	t.Cleanup(func() { oplock.Timeout = prevTimeout })
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useMetaDir(t)
+			fake := shellmock.Install(t)
+			tc.setup(t, fake)
+
+			var note strings.Builder
+			prevNote := noteOut
+			noteOut = &note
+			t.Cleanup(func() { noteOut = prevNote })
+
+			// The command runs under the lock, which fails at once if
+			// taken again rather than handed on (see lockedCommand).
+			results, err := execute(tc.build())
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error, got results %+v", results)
+				}
+				// A strict failure rolls back the addresses added before it.
+				if adds, dels := fake.Matching("ip addr add"), fake.Matching("ip addr del"); len(adds) != len(dels) {
+					t.Errorf("error: got commands %q, want the added addresses removed", fake.Commands)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			var statuses []string
+			for _, result := range results {
+				statuses = append(statuses, result.Status)
+				if result.Status == StatusSkipped && result.Detail != "unchanged" {
+					t.Errorf("error: got detail %q, want \"unchanged\"", result.Detail)
+				}
+			}
+			if !slices.Equal(statuses, tc.wantStatus) {
+				t.Errorf("error: got statuses %v, want %v", statuses, tc.wantStatus)
+			}
+			if slices.Contains(tc.wantStatus, StatusSkipped) && !strings.Contains(note.String(), ", unchanged") {
+				t.Errorf("error: got note %q, want the unchanged state", note.String())
+			}
+		})
+	}
+}
+
+// Testing the parsing of the -strict flag.
+func TestStrictParseArgs(t *testing.T) {
+	type testCase struct {
+		name      string
+		cmd       Command
+		args      []string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "port", cmd: &UpdateInterfaceCommand{}, args: []string{"wg0", "-u", "-p", "51820", "-strict"}},
+		{name: "generated key", cmd: &UpdateInterfaceCommand{}, args: []string{"wg0", "-u", "-pk", "-strict"}, wantError: true},
+		{name: "peer add", cmd: &PeerCommand{}, args: []string{"wg0", "-pr", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "-a", "10.0.0.2/32", "-strict"}},
+		{name: "peer delete", cmd: &PeerCommand{}, args: []string{"wg0", "-pr", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "-d", "-strict"}, wantError: true},
+		{name: "address add", cmd: &IpIntertfaceCommand{}, args: []string{"wg0", "-ip", "10.10.10.1/24", "-a", "-strict"}},
+		{name: "address delete", cmd: &IpIntertfaceCommand{}, args: []string{"wg0", "-ip", "10.10.10.1/24", "-d", "-strict"}, wantError: true},
+		{name: "forwarding", cmd: &IpForwardingCommand{}, args: []string{"-fw4", "-a", "-strict"}},
+		{name: "forwarding unknown", cmd: &IpForwardingCommand{}, args: []string{"-fw4", "-a", "-force"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error, got %+v", tc.cmd)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			strict := false
+			switch cmd := tc.cmd.(type) {
+			case *UpdateInterfaceCommand:
+				strict = cmd.Strict
+			case *PeerCommand:
+				strict = cmd.Strict
+			case *IpIntertfaceCommand:
+				strict = cmd.Strict
+			case *IpForwardingCommand:
+				strict = cmd.Strict
+			}
+			if !strict {
+				t.Errorf("error: got %+v, want strict", tc.cmd)
+			}
+		})
+	}
+}
+
+// Testing the '-' arguments reading the private key, the state and the
+// rules from stdin.
+func TestStdinArguments(t *testing.T) {
+	key, _ := wgtypes.GeneratePrivateKey()
+
+	t.Run("private key", func(t *testing.T) {
+		stubLookups(t, []string{"wg0"}, nil)
+		mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+		useStdin(t, fmt.Sprintf("\nprivate_key: %s\npublic_key: %s\n\n", key, key.PublicKey()))
+
+		cmd := UpdateInterfaceCommand{}
+		if _, err := cmd.ParseArgs([]string{"wg0", "-u", "-pk", "-"}); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if _, err := cmd.Execute(); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if device, _ := mock.Device("wg0"); device.PrivateKey != key {
+			t.Error("error: private key was not set from stdin")
+		}
+	})
+
+	t.Run("invalid private key", func(t *testing.T) {
+		stubLookups(t, []string{"wg0"}, nil)
+		wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+		useStdin(t, "private_key: secret!key\n")
+
+		cmd := UpdateInterfaceCommand{}
+		_, err := cmd.ParseArgs([]string{"wg0", "-u", "-pk", "-"})
+		if err == nil {
+			_, err = cmd.Execute()
+		}
+		if err == nil {
+			t.Fatal("error: expected error, got none")
+		}
+		if strings.Contains(err.Error(), "secret!key") {
+			t.Errorf("error: stdin leaked into the error: %v", err)
+		}
+	})
+
+	t.Run("state", func(t *testing.T) {
+		useStdin(t, `{"interfaces": [{"name": "wg0", "addresses": ["10.10.10.254/24"]}]}`)
+
+		cmd := ReconcileCommand{}
+		if _, err := cmd.ParseArgs([]string{"-"}); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		state, err := cmd.load()
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if len(state.Interfaces) != 1 || state.Interfaces[0].Name != "wg0" {
+			t.Errorf("error: got state %+v", state)
+		}
+	})
+
+	t.Run("rules", func(t *testing.T) {
+		shellmock.Install(t)
+		useStdin(t, "*filter\n-A INPUT -j ACCEPT\n")
+
+		cmd := PersistCommand{}
+		if _, err := cmd.ParseArgs([]string{"-restore", "-"}); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		_, err := cmd.Execute()
+		if err == nil || !strings.Contains(err.Error(), "rules file 'stdin'") {
+			t.Errorf("error: got error %v, want the rules of stdin refused", err)
+		}
+	})
+}
+
+// Testing the environment defaults of ParseWithEnv.
+func TestParseWithEnv(t *testing.T) {
+	env := map[string]string{help.Env_Default_Interface: "wg0", help.Env_Default_JSON: "true"}
+
+	type testCase struct {
+		name      string
+		args      []string
+		env       map[string]string
+		want      []string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "defaults", args: []string{"-u", "-p", "51820"}, env: env, want: []string{"-js", "-i", "wg0", "-u", "-p", "51820"}},
+		{name: "flags win", args: []string{"-js", "-i", "wg1", "-u"}, env: env, want: []string{"-js", "-i", "wg1", "-u"}},
+		{name: "other command", args: []string{"-fw4", "-a"}, env: map[string]string{help.Env_Default_Interface: "wg0"}, want: []string{"-fw4", "-a"}},
+		{name: "unset", args: []string{"-u", "-p", "51820"}, want: []string{"-u", "-p", "51820"}},
+		{name: "invalid json", args: []string{"-u"}, env: map[string]string{help.Env_Default_JSON: "maybe"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseWithEnv(append([]string{"brgsetwg"}, tc.args...), tc.env)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !slices.Equal(got[1:], tc.want) {
+				t.Errorf("error: got %q, want %q", got[1:], tc.want)
+			}
+		})
+	}
+}
+
+// resolverFunc resolves the endpoint host names of the tests.
+type resolverFunc func(host string) ([]netip.Addr, error)
+
+func (f resolverFunc) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	return f(host)
+}
+
+// Testing the -refresh-endpoints command: the moved endpoint is updated,
+// a resolution failure is a warning keeping the endpoint.
+func TestRefreshEndpoints(t *testing.T) {
+	stubLookups(t, []string{"wg0", "awg0"}, map[string]string{"awg0": help.Env_Awg_Type})
+	useMetaDir(t)
+
+	movedKey, _ := wgtypes.GeneratePrivateKey()
+	downKey, _ := wgtypes.GeneratePrivateKey()
+	moved, down := movedKey.PublicKey(), downKey.PublicKey()
+	mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{
+		{PublicKey: moved, Endpoint: &net.UDPAddr{IP: net.ParseIP("89.89.89.1"), Port: 51820}},
+		{PublicKey: down, Endpoint: &net.UDPAddr{IP: net.ParseIP("89.89.89.2"), Port: 51820}},
+	}})
+	peermeta.Set("wg0", moved.String(), peermeta.Meta{Endpoint: "moved.example.com:51820"})
+	peermeta.Set("wg0", down.String(), peermeta.Meta{Endpoint: "down.example.com:51820"})
+
+	prevResolver := set.DefaultResolver
+	set.DefaultResolver = resolverFunc(func(host string) ([]netip.Addr, error) {
+		if host == "moved.example.com" {
+			return []netip.Addr{netip.MustParseAddr("89.89.89.10")}, nil
+		}
+		return nil, errors.New("no such host")
+	})
+	t.Cleanup(func() { set.DefaultResolver = prevResolver })
+
+	var note, warnings strings.Builder
+	prevNote, prevWarn := noteOut, warnOut
+	noteOut, warnOut = &note, &warnings
+	t.Cleanup(func() { noteOut, warnOut = prevNote, prevWarn })
+
+	cmd := RefreshEndpointsCommand{}
+	if _, err := cmd.ParseArgs([]string{"wg0", "-refresh-endpoints"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	results, err := cmd.Execute()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	want := fmt.Sprintf("updated endpoint of peer %s (moved.example.com:51820) from 89.89.89.1:51820 to 89.89.89.10:51820\n", moved)
+	if note.String() != want {
+		t.Errorf("error: got %q, want %q", note.String(), want)
+	}
+	if !strings.Contains(warnings.String(), "endpoint of peer "+down.String()+" kept") {
+		t.Errorf("error: got warnings %q", warnings.String())
+	}
+	if len(results) != 2 || results[0].Status != StatusApplied || results[1].Status != StatusFailed {
+		t.Errorf("error: got results %+v", results)
+	}
+	if len(mock.Calls) != 1 {
+		t.Errorf("error: got %d calls, want 1", len(mock.Calls))
+	}
+
+	// AmneziaWG devices are not configured through wgctrl.
+	awg := RefreshEndpointsCommand{Iface: "awg0"}
+	if _, err := awg.Execute(); err == nil || !strings.Contains(err.Error(), "only supported by WireGuard") {
+		t.Errorf("error: got %v for an AmneziaWG interface", err)
+	}
+}
+
+// Testing the removal of the global -y and -yes flags at any position.
+func TestStripYesFlag(t *testing.T) {
+	type testCase struct {
+		args     []string
+		wantArgs []string
+		wantYes  bool
+	}
+
+	tests := []testCase{
+		{args: []string{"brgsetwg", "-i", "wg0", "-d"}, wantArgs: []string{"brgsetwg", "-i", "wg0", "-d"}},
+		{args: []string{"brgsetwg", "-y", "-i", "wg0", "-d"}, wantArgs: []string{"brgsetwg", "-i", "wg0", "-d"}, wantYes: true},
+		{args: []string{"brgsetwg", "-js", "-i", "wg0", "-purge", "-yes"}, wantArgs: []string{"brgsetwg", "-js", "-i", "wg0", "-purge"}, wantYes: true},
+		{args: []string{"brgsetwg", "-i", "wg0", "-d", "-yes-i-am-sure"}, wantArgs: []string{"brgsetwg", "-i", "wg0", "-d", "-yes-i-am-sure"}},
+		{args: []string{"brgsetwg", "-y"}, wantArgs: []string{"brgsetwg"}, wantYes: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			args, yes := stripYesFlag(tc.args)
+			if !slices.Equal(args, tc.wantArgs) || yes != tc.wantYes {
+				t.Errorf("error: got (%q, %v), want (%q, %v)", args, yes, tc.wantArgs, tc.wantYes)
+			}
+		})
+	}
+}
+
+// Function replaces the confirmation prompt with the answer and returns
+// the questions asked.
+func useConfirmPrompt(t *testing.T, answer bool) *[]string {
+	t.Helper()
+
+	var questions []string
+	prevPrompt, prevYes := confirmPrompt, assumeYes
+	confirmPrompt = func(question string) (bool, error) {
+		questions = append(questions, question)
+		return answer, nil
+	}
+	assumeYes = false
+	t.Cleanup(func() { confirmPrompt, assumeYes = prevPrompt, prevYes })
+	return &questions
+}
+
+// Testing the confirmation questions of the destructive commands and of
+// the commands not confirmed.
+func TestConfirmQuestions(t *testing.T) {
+	key := func() wgtypes.Peer {
+		private, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wgtypes.Peer{PublicKey: private.PublicKey()}
+	}
+
+	type testCase struct {
+		name string
+		cmd  Command
+		want string
+	}
+
+	tests := []testCase{
+		{
+			name: "delete", cmd: &InterfaceCommand{Iface: "wg0", Action: help.DelFlag},
+			want: "delete network interface 'wg0' with 2 peers?",
+		},
+		{name: "delete missing", cmd: &InterfaceCommand{Iface: "wg9", Action: help.DelFlag}},
+		{name: "down", cmd: &InterfaceCommand{Iface: "wg0", Action: help.DisableWgInterfaceFlag}},
+		{
+			name: "purge", cmd: &PurgeCommand{Iface: "wg0"},
+			want: "purge network interface 'wg0': 2 peers, 4 iptables rules, 7 actions in all?",
+		},
+		{name: "purge dry", cmd: &PurgeCommand{Iface: "wg0", Dry: true}},
+		{name: "prune nothing", cmd: &PruneCommand{Iface: "wg0"}},
+		{
+			name: "nat rules", want: "delete 4 NAT rules of network interface 'wg0' through eth0,wwan0?",
+			cmd: &IpIntertfaceCommand{
+				InIface: "wg0", SubNets: []string{"10.0.0.1/24", "10.1.0.1/24", "fd00::1/64"},
+				OutIfaces: []string{"eth0", "wwan0"}, FlagCmd: help.DelFlag + help.NatFlag,
+			},
+		},
+		{
+			name: "firewall rules", want: "delete 2 firewall rules of network interface 'wg0' through eth0?",
+			cmd: &IpIntertfaceCommand{
+				InIface: "wg0", SubNets: []string{"10.0.0.1/24"},
+				OutIfaces: []string{"eth0"}, FlagCmd: help.DelFlag + help.FirewallFlag,
+			},
+		},
+		{
+			name: "ipv6 rules",
+			cmd: &IpIntertfaceCommand{
+				InIface: "wg0", SubNets: []string{"fd00::1/64"},
+				OutIfaces: []string{"eth0"}, FlagCmd: help.DelFlag + help.NatFlag,
+			},
+		},
+		{
+			name: "address delete",
+			cmd:  &IpIntertfaceCommand{InIface: "wg0", SubNets: []string{"10.0.0.1/24"}, FlagCmd: help.DelFlag},
+		},
+		{name: "not destructive", cmd: &AliasInterfaceCommand{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			usePurgeEnv(t, []string{"wg0"}, 0)
+			wgmock.Install(t, &wgtypes.Device{Name: "wg0", ListenPort: 51820, Peers: []wgtypes.Peer{key(), key()}})
+			questions := useConfirmPrompt(t, true)
+
+			if err := confirm(tc.cmd); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			var want []string
+			if tc.want != "" {
+				want = []string{tc.want}
+			}
+			if !slices.Equal(*questions, want) {
+				t.Errorf("error: got questions %q, want %q", *questions, want)
+			}
+		})
+	}
+}
+
+// Testing the expired peers count of the prune confirmation.
+func TestConfirmPrune(t *testing.T) {
+	useMetaDir(t)
+	questions := useConfirmPrompt(t, true)
+
+	past := time.Now().Add(-time.Hour)
+	for _, key := range []string{"AAAA=", "BBBB="} {
+		if err := peermeta.Set("wg0", key, peermeta.Meta{Expires: past}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := confirm(&PruneCommand{Iface: "wg0"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	want := []string{"delete 2 expired peers of network interface 'wg0'?"}
+	if !slices.Equal(*questions, want) {
+		t.Errorf("error: got questions %q, want %q", *questions, want)
+	}
+}
+
+// Testing that a declined confirmation fails and that -y skips it.
+func TestConfirmDeclined(t *testing.T) {
+	stubLookups(t, []string{"wg0"}, nil)
+	wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+	questions := useConfirmPrompt(t, false)
+
+	cmd := &InterfaceCommand{Iface: "wg0", Action: help.DelFlag}
+	if err := confirm(cmd); err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("error: got %v, want cancelled", err)
+	}
+
+	assumeYes = true
+	if err := confirm(cmd); err != nil {
+		t.Fatalf("error: unexpected error with -y: %v", err)
+	}
+	if len(*questions) != 1 {
+		t.Errorf("error: got questions %q, want one", *questions)
+	}
+}
+
+// Function replaces the device lookups of the groups with the devices of
+// the mock, followed by the extra devices, and the network interfaces of
+// the system with the devices and the links.
+func useGroupDevices(t *testing.T, mock *wgmock.Client, extra []string, links ...string) {
+	t.Helper()
+
+	prevDevices, prevLinks := groupDevices, groupLinks
+	groupDevices = func() ([]string, error) {
+		devices, err := mock.Devices()
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, d := range devices {
+			names = append(names, d.Name)
+		}
+		return append(names, extra...), nil
+	}
+	groupLinks = func() ([]string, error) {
+		names, _ := groupDevices()
+		return append(names, links...), nil
+	}
+	t.Cleanup(func() { groupDevices, groupLinks = prevDevices, prevLinks })
+}
+
+// Testing the detection of the commands run on a group of interfaces.
+func TestIsGroupArgs(t *testing.T) {
+	type testCase struct {
+		args []string
+		want bool
+	}
+
+	tests := []testCase{
+		{args: []string{"brgsetwg", "-i", "wg-cust*", "-dw"}, want: true},
+		{args: []string{"brgsetwg", "-i", "wg[12]", "-dw"}, want: true},
+		{args: []string{"brgsetwg", "-g", "wg-cust", "-dw"}, want: true},
+		{args: []string{"brgsetwg", "-i", "wg0", "-dw"}, want: false},
+		{args: []string{"brgsetwg", "-fw4", "-a"}, want: false},
+		{args: []string{"brgsetwg", "-g"}, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args[1:], " "), func(t *testing.T) {
+			if got := isGroupArgs(tc.args); got != tc.want {
+				t.Errorf("error: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the key rotation of a group: the matching WireGuard devices are
+// rotated past the failure of one of them, the other interfaces skipped.
+func TestRunGroup(t *testing.T) {
+	stubLookups(t, []string{"wg-cust1", "wg-cust2", "wg-cust3", "wg0"}, nil)
+	useMetaDir(t)
+
+	var warnings strings.Builder
+	prevWarn := warnOut
+	warnOut = &warnings
+	t.Cleanup(func() { warnOut = prevWarn })
+
+	keys := map[string]wgtypes.Key{}
+	var devices []*wgtypes.Device
+	for _, name := range []string{"wg-cust1", "wg-cust2", "wg0"} {
+		key, _ := wgtypes.GeneratePrivateKey()
+		keys[name] = key
+		devices = append(devices, &wgtypes.Device{Name: name, PrivateKey: key, PublicKey: key.PublicKey()})
+	}
+	mock := wgmock.Install(t, devices...)
+
+	// wg-cust3 is listed but cannot be read.
+	useGroupDevices(t, mock, []string{"wg-cust3"}, "eth0", "wg-custbr")
+
+	var out strings.Builder
+	results, failures, _, err := runGroup([]string{"-i", "wg-cust*", "-u", "-pk", "-rotate", "-parallel", "2"}, &out)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if failures != 1 {
+		t.Errorf("error: got %d failures, want 1", failures)
+	}
+
+	for name, key := range keys {
+		device, _ := mock.Device(name)
+		if rotated := device.PrivateKey != key; rotated != (name != "wg0") {
+			t.Errorf("error: got %s rotated %v", name, rotated)
+		}
+	}
+
+	table := out.String()
+	for _, want := range []string{"wg-cust1   ok", "wg-cust2   ok", "wg-cust3   failed  error:", "3 network interfaces, 1 failed"} {
+		if !strings.Contains(table, want) {
+			t.Errorf("error: table %q does not contain %q", table, want)
+		}
+	}
+	if !strings.Contains(warnings.String(), "'wg-custbr' is not a WireGuard device") ||
+		strings.Contains(warnings.String(), "eth0") {
+		t.Errorf("error: got warnings %q", warnings.String())
+	}
+
+	var statuses []string
+	for _, r := range results {
+		statuses = append(statuses, r.Target+":"+r.Status)
+	}
+	if !slices.Contains(statuses, "wg-cust3:"+StatusFailed) || len(results) < 3 {
+		t.Errorf("error: got results %q", statuses)
+	}
+}
+
+// Testing the groups refused before any interface is changed.
+func TestRunGroupErrors(t *testing.T) {
+	stubLookups(t, []string{"wg-cust1", "wg-cust2"}, nil)
+	mock := wgmock.Install(t, &wgtypes.Device{Name: "wg-cust1"}, &wgtypes.Device{Name: "wg-cust2"})
+	useGroupDevices(t, mock, nil, "eth0")
+
+	prevWarn := warnOut
+	warnOut = io.Discard
+	t.Cleanup(func() { warnOut = prevWarn })
+
+	type testCase struct {
+		name     string
+		args     []string
+		wantFlag string
+	}
+
+	tests := []testCase{
+		{name: "no_match", args: []string{"-i", "eth*", "-dw"}, wantFlag: "eth*"},
+		{name: "bad_pattern", args: []string{"-i", "wg[", "-dw"}, wantFlag: "-i"},
+		{name: "bad_prefix", args: []string{"-g", "wg*", "-dw"}, wantFlag: "-g"},
+		{name: "rename", args: []string{"-g", "wg-cust", "-rn", "wg1"}, wantFlag: "-rn"},
+		{name: "unknown", args: []string{"-g", "wg-cust", "-n"}, wantFlag: "-n"},
+		{name: "parallel", args: []string{"-g", "wg-cust", "-dw", "-parallel", "0"}, wantFlag: "-parallel"},
+		{name: "missing_value", args: []string{"-g", "wg-cust", "-u", "-p"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			_, _, flag, err := runGroup(tc.args, &out)
+			if err == nil {
+				t.Fatal("error: expected error, got none")
+			}
+			if tc.wantFlag != "" && flag != tc.wantFlag {
+				t.Errorf("error: got flag %q, want %q", flag, tc.wantFlag)
+			}
+			if out.Len() != 0 || len(mock.Calls) != 0 {
+				t.Errorf("error: got table %q and %d calls, want nothing run", out.String(), len(mock.Calls))
+			}
+		})
+	}
+}
+
+// Testing that the destructive commands of a group are confirmed once.
+func TestRunGroupConfirm(t *testing.T) {
+	stubLookups(t, []string{"wg-cust1", "wg-cust2"}, nil)
+	mock := wgmock.Install(t, &wgtypes.Device{Name: "wg-cust1"}, &wgtypes.Device{Name: "wg-cust2"})
+	useGroupDevices(t, mock, nil)
+	fake := shellmock.Install(t)
+	questions := useConfirmPrompt(t, false)
+
+	var out strings.Builder
+	_, _, _, err := runGroup([]string{"-g", "wg-cust", "-d"}, &out)
+	if err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("error: got %v, want cancelled", err)
+	}
+	if len(*questions) != 1 || !strings.Contains((*questions)[0], "proceed on 2 network interfaces?") {
+		t.Errorf("error: got questions %q", *questions)
+	}
+	if len(fake.Commands) != 0 {
+		t.Errorf("error: got commands %q, want none", fake.Commands)
+	}
+}
+
+// Testing the parsing of the provision command.
+func TestProvisionParseArgs(t *testing.T) {
+	type testCase struct {
+		args      []string
+		want      provision.ProvisionOptions
+		wantOut   string
+		wantQR    string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{
+			args: []string{"wg0", "-provision", "-name", "alice", "-eh", "vpn.example.com"},
+			want: provision.ProvisionOptions{Name: "alice", Endpoint: "vpn.example.com"},
+		},
+		{
+			args: []string{"wg0", "-provision", "-name", "alice", "-eh", "vpn.example.com:443",
+				"-dns", "10.10.10.1", "-kp", "0", "-expires", "2030-01-01", "-out", "alice.conf", "-qr", "alice.png"},
+			want: provision.ProvisionOptions{
+				Name: "alice", Endpoint: "vpn.example.com:443", DNS: []string{"10.10.10.1"},
+				Keepalive: -1, Expires: "2030-01-01", QR: true,
+			},
+			wantOut: "alice.conf",
+			wantQR:  "alice.png",
+		},
+		{
+			args: []string{"wg0", "-provision", "-eh", "vpn.example.com", "-kp", "10", "-name", "bob"},
+			want: provision.ProvisionOptions{Name: "bob", Endpoint: "vpn.example.com", Keepalive: 10},
+		},
+		{args: []string{"wg0", "-provision"}, wantError: true},
+		{args: []string{"wg0", "-provision", "-name", "alice"}, wantError: true},
+		{args: []string{"wg0", "-provision", "-eh", "vpn.example.com"}, wantError: true},
+		{args: []string{"wg0", "-provision", "-name", "alice", "-eh"}, wantError: true},
+		{args: []string{"wg0", "-provision", "-name", "-eh", "vpn.example.com"}, wantError: true},
+		{args: []string{"wg0", "-provision", "-name", "a", "-name", "b", "-eh", "vpn.example.com"}, wantError: true},
+		{args: []string{"wg0", "-provision", "-name", "a", "-eh", "vpn.example.com", "-kp", "70000"}, wantError: true},
+		{args: []string{"wg0", "-provision", "-name", "a", "-eh", "vpn.example.com", "-expires", "soon"}, wantError: true},
+		{args: []string{"wg0", "-provision", "-name", "a", "-eh", "vpn.example.com", "-dns", "dns.example.com"}, wantError: true},
+		{args: []string{"wg0", "-provision", "-name", "a", "-eh", "vpn.example.com", "-force", "x"}, wantError: true},
+		{args: []string{"wg0", "-provision", "-name", "a", "-eh", "vpn.example.com", "-out", "a", "-qr", "a"}, wantError: true},
+		{args: []string{"wg$", "-provision", "-name", "a", "-eh", "vpn.example.com"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd := ProvisionCommand{}
+			_, err := cmd.ParseArgs(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected an error, got %+v", cmd)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(cmd.Options, tc.want) || cmd.OutPath != tc.wantOut || cmd.QRPath != tc.wantQR {
+				t.Errorf("error: got %+v, want options %+v", cmd, tc.want)
+			}
+		})
+	}
+}
+
+// Testing that the configuration and the QR code are written to new 0600
+// files, and that the files are removed when the client cannot be added.
+func TestProvisionClient(t *testing.T) {
+	prev := provisionPeer
+	t.Cleanup(func() { provisionPeer = prev })
+
+	dir := t.TempDir()
+	cmd := ProvisionCommand{
+		Iface:   "wg0",
+		Options: provision.ProvisionOptions{Name: "alice", Endpoint: "vpn.example.com", QR: true},
+		OutPath: filepath.Join(dir, "alice.conf"),
+		QRPath:  filepath.Join(dir, "alice.png"),
+	}
+
+	provisionPeer = func(iface string, opts provision.ProvisionOptions, _ ...*oplock.Lock) (provision.ProvisionResult, error) {
+		return provision.ProvisionResult{
+			Config: "[Interface]\n", QR: []byte("\x89PNG"), Address: "10.10.10.3/32", PublicKey: "KEY=",
+		}, nil
+	}
+
+	var out strings.Builder
+	results, err := cmd.provisionClient(&out)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("error: configuration printed with -out: %q", out.String())
+	}
+	if len(results) != 1 || results[0].Action != "peer-provision" || results[0].Target != "KEY=" || results[0].Detail != "alice 10.10.10.3/32" {
+		t.Errorf("error: got results %+v", results)
+	}
+	for path, want := range map[string]string{cmd.OutPath: "[Interface]\n", cmd.QRPath: "\x89PNG"} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(path)
+		if info.Mode().Perm() != 0o600 || string(data) != want {
+			t.Errorf("error: %s: got mode %v and %q", path, info.Mode().Perm(), data)
+		}
+	}
+
+	// The files exist now, they are never overwritten.
+	if _, err := cmd.provisionClient(&out); err == nil {
+		t.Errorf("error: expected an error for an existing file")
+	}
+
+	cmd.OutPath = filepath.Join(dir, "bob.conf")
+	cmd.QRPath = filepath.Join(dir, "bob.png")
+	provisionPeer = func(iface string, opts provision.ProvisionOptions, _ ...*oplock.Lock) (provision.ProvisionResult, error) {
+		return provision.ProvisionResult{}, errors.New("error: no free address")
+	}
+	if _, err := cmd.provisionClient(&out); err == nil {
+		t.Fatalf("error: expected an error")
+	}
+	for _, path := range []string{cmd.OutPath, cmd.QRPath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("error: %s kept after the failure", path)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+//go:build !windows
+
+package brgsetwg
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/set"
+)
+
+// Optional interface of the destructive commands (deleting an interface,
+// purging it, pruning its peers, deleting its rules), confirmed by the user
+// before they run unless -y is given.
+type destructiveCommand interface {
+	// Confirmation returns the question summarizing what the command
+	// removes, an empty string when there is nothing to confirm.
+	Confirmation() (string, error)
+}
+
+// Skips the confirmation of the destructive commands, set by -y or -yes.
+var assumeYes bool
+
+// Prompt of the confirmation, replaced in tests. The user is only asked
+// when stdin is a terminal, see handlers.Confirm.
+var confirmPrompt = func(question string) (bool, error) {
+	return handlers.Confirm(handlers.Stdin, os.Stderr, question)
+}
+
+// Function removes the global -y and -yes flags from the arguments, given
+// at any position, and reports whether one was given.
+func stripYesFlag(args []string) ([]string, bool) {
+	yes := false
+	rest := make([]string, 0, len(args))
+	for indx, arg := range args {
+		if indx > 0 && (arg == help.YesFlag || arg == help.YesLongFlag) {
+			yes = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, yes
+}
+
+// Function asks the user to confirm a destructive command, see
+// destructiveCommand. A declined command fails without any change.
+func confirm(cmd Command) error {
+	dc, ok := cmd.(destructiveCommand)
+	if !ok || assumeYes {
+		return nil
+	}
+
+	question, err := dc.Confirmation()
+	if err != nil || question == "" {
+		return err
+	}
+	return confirmQuestion(question)
+}
+
+// Function asks the question and returns an error when it is declined.
+func confirmQuestion(question string) error {
+	ok, err := confirmPrompt(question)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return help.Errorf(help.CodeCancelled, "error: cancelled, nothing was changed")
+	}
+	return nil
+}
+
+// Function returns the number of peers of the network interface, false
+// when the device cannot be read.
+func peerCount(iface string) (int, bool) {
+	device, err := interfaceDevice(iface)
+	if err != nil {
+		return 0, false
+	}
+	return len(device.Peers), true
+}
+
+// Function formats a count with the singular or plural noun
+// (e.g., "1 peer", "3 peers").
+func plural(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, noun)
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
+
+// Method confirms the deletion of the interface with the number of its
+// peers. Bringing it up or down is not confirmed, nor deleting a missing
+// interface.
+func (p *InterfaceCommand) Confirmation() (string, error) {
+	if p.Action != help.DelFlag {
+		return "", nil
+	}
+
+	exists, err := interfaceExists(p.Iface)
+	if err != nil || !exists {
+		return "", err
+	}
+
+	if peers, ok := peerCount(p.Iface); ok {
+		return fmt.Sprintf("delete network interface '%s' with %s?", p.Iface, plural(peers, "peer")), nil
+	}
+	return fmt.Sprintf("delete network interface '%s'?", p.Iface), nil
+}
+
+// Method confirms the purge with the number of peers, iptables rules and
+// actions of its plan, see planPurge. A dry run is not confirmed.
+func (p *PurgeCommand) Confirmation() (string, error) {
+	if p.Dry {
+		return "", nil
+	}
+
+	actions, _, err := planPurge(p.Iface)
+	if err != nil || len(actions) == 0 {
+		return "", err
+	}
+
+	var summary []string
+	if exists, err := interfaceExists(p.Iface); err == nil && exists {
+		if peers, ok := peerCount(p.Iface); ok {
+			summary = append(summary, plural(peers, "peer"))
+		}
+	}
+	rules := 0
+	for _, action := range actions {
+		if action.Rule {
+			rules++
+		}
+	}
+	summary = append(summary, plural(rules, "iptables rule"), plural(len(actions), "action")+" in all")
+
+	return fmt.Sprintf("purge network interface '%s': %s?", p.Iface, strings.Join(summary, ", ")), nil
+}
+
+// Method confirms the removal of the expired peers with their number.
+// Nothing to prune is not confirmed.
+func (p *PruneCommand) Confirmation() (string, error) {
+	expired, err := set.ExpiredPeers(p.Iface, time.Now())
+	if err != nil || len(expired) == 0 {
+		return "", err
+	}
+	return fmt.Sprintf(
+		"delete %s of network interface '%s'?",
+		plural(len(expired), "expired peer"), p.Iface,
+	), nil
+}
+
+// Method confirms the deletion of the NAT or firewall rules with their
+// number. The outgoing interfaces are resolved once, for the command too.
+// Adding rules or addresses and deleting addresses are not confirmed.
+func (p *IpIntertfaceCommand) Confirmation() (string, error) {
+	var kind string
+	switch p.FlagCmd {
+	case help.DelFlag + help.NatFlag:
+		kind = "NAT"
+	case help.DelFlag + help.FirewallFlag:
+		kind = "firewall"
+	default:
+		return "", nil
+	}
+
+	outIfaces, err := resolveOutIfaces(p.OutIfaces)
+	if err != nil {
+		return "", err
+	}
+	p.OutIfaces = outIfaces
+
+	// The IPv4 subnets of the addresses, see ipv4Subnets.
+	var subnets []netip.Prefix
+	for _, value := range p.SubNets {
+		prefix := netip.MustParsePrefix(value).Masked()
+		if prefix.Addr().Is4() && !slices.Contains(subnets, prefix) {
+			subnets = append(subnets, prefix)
+		}
+	}
+	if len(subnets) == 0 {
+		return "", nil
+	}
+
+	// A NAT rule per subnet and uplink, two FORWARD rules per uplink.
+	rules := len(subnets) * len(outIfaces)
+	if kind == "firewall" {
+		rules = 2 * len(outIfaces)
+	}
+	return fmt.Sprintf(
+		"delete %s of network interface '%s' through %s?",
+		plural(rules, kind+" rule"), p.InIface, strings.Join(outIfaces, ","),
+	), nil
+}
@@ -0,0 +1,84 @@
+//go:build !windows
+
+package brgsetwg
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/validate"
+)
+
+// DnsCommand encapsulates the data and logic for setting and removing the
+// DNS servers of a network interface.
+type DnsCommand struct {
+	opLock
+
+	Iface   string
+	Servers []string
+	FlagCmd string
+}
+
+// Method parses the command-line arguments for the DNS command.
+// Expected format: `[interface_name] -dns [server[,server]] [-a | -d]`.
+func (p *DnsCommand) ParseArgs(args []string) (string, error) {
+	if len(args) != 4 {
+		return help.DNSFlag, help.Errorf(
+			help.CodeInvalidArguments,
+			"error: invalid command arguments, specify DNS servers and action: [%s | %s]",
+			help.AddFlag, help.DelFlag,
+		)
+	}
+
+	p.Iface = args[0]
+	if err := validate.CheckInterfaceName(p.Iface); err != nil {
+		return help.WgInterfaceFlag, err
+	}
+
+	servers, err := validate.CheckDNSServers(args[2])
+	if err != nil {
+		return help.DNSFlag, err
+	}
+	p.Servers = servers
+
+	switch args[3] {
+	case help.AddFlag, help.DelFlag:
+		p.FlagCmd = args[3]
+	default:
+		return args[3], errors.New(help.DefaultErrorMessage)
+	}
+
+	return help.DNSFlag, nil
+}
+
+// Method returns the changed network interface.
+func (p *DnsCommand) ChangedInterface() string {
+	return p.Iface
+}
+
+// Method sets or removes the DNS servers of the network interface, see
+// set.SetInterfaceDNS and set.RemoveInterfaceDNS.
+func (p *DnsCommand) Execute() ([]Result, error) {
+	iface, err := interfaceExists(p.Iface)
+	if err != nil {
+		return nil, err
+	}
+	if !iface {
+		return nil, &get.InterfaceNotFoundError{Name: p.Iface}
+	}
+
+	if p.FlagCmd == help.DelFlag {
+		if err := set.RemoveInterfaceDNS(p.Iface, p.Servers, p.lock); err != nil {
+			return nil, err
+		}
+		return []Result{applied("dns-remove", p.Iface, strings.Join(p.Servers, ","))}, nil
+	}
+
+	if err := set.SetInterfaceDNS(p.Iface, p.Servers, p.lock); err != nil {
+		return nil, err
+	}
+	return []Result{applied("dns-set", p.Iface, strings.Join(p.Servers, ","))}, nil
+}
@@ -0,0 +1,102 @@
+//go:build !windows
+
+package brgsetwg
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/set"
+)
+
+// Address families of a network interface, see familiesOf.
+type addressFamilies struct {
+	IPv4 bool
+	IPv6 bool
+}
+
+// Method reports whether the families include the one of the prefix.
+func (f addressFamilies) carries(prefix netip.Prefix) bool {
+	if prefix.Addr().Is4() {
+		return f.IPv4
+	}
+	return f.IPv6
+}
+
+// Function returns the address families of the network interface. The
+// link-local addresses are not counted: every IPv6 link has one, it does
+// not route a subnet.
+func familiesOf(iface string) (addressFamilies, error) {
+	show, err := get.GetIpShow(iface)
+	if err != nil {
+		return addressFamilies{}, err
+	}
+
+	var families addressFamilies
+	for _, link := range show {
+		for _, info := range link.AddrInfo {
+			addr, err := netip.ParseAddr(info.Local)
+			if err != nil || info.Scope == "link" || addr.IsLinkLocalUnicast() {
+				continue
+			}
+			if addr.Unmap().Is4() {
+				families.IPv4 = true
+			} else {
+				families.IPv6 = true
+			}
+		}
+	}
+	return families, nil
+}
+
+// Function returns the name of the family of the prefix.
+func familyName(prefix netip.Prefix) string {
+	if prefix.Addr().Is4() {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// Function checks that each outgoing network interface has an address of
+// the family of each subnet: a rule passing IPv6 traffic through an
+// interface without IPv6 connectivity (or IPv4 through an IPv6-only one)
+// can never match. The mismatch fails the command, with force it is only
+// reported as a warning (see set.WarnFamilyMismatch) and the rules are
+// added anyway. An interface which cannot be read fails the command too,
+// unless forced.
+func checkFamilies(iface, rule string, subnets, outIfaces []string, force bool) error {
+	for _, outIface := range outIfaces {
+		families, err := interfaceFamilies(outIface)
+		if err != nil {
+			if force {
+				continue
+			}
+			return err
+		}
+
+		for _, value := range subnets {
+			prefix := netip.MustParsePrefix(value).Masked()
+			if families.carries(prefix) {
+				continue
+			}
+
+			message := fmt.Sprintf(
+				"network interface '%s' has no %s address, the %s of '%s' through it can never match",
+				outIface, familyName(prefix), rule, prefix,
+			)
+			if !force {
+				return help.Errorf(help.CodeFamilyMismatch,
+					"error: %s, pass '%s' to add it anyway", message, help.ForceFlag)
+			}
+			reportWarning(set.Warning{
+				Code:      set.WarnFamilyMismatch,
+				Message:   message,
+				Interface: iface,
+				Fields:    map[string]string{"out": outIface, "subnet": prefix.String()},
+			})
+		}
+	}
+	return nil
+}
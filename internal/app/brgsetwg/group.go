@@ -0,0 +1,149 @@
+//go:build !windows
+
+package brgsetwg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/group"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/src/get"
+)
+
+// Lookups of the WireGuard devices and of all the network interfaces a
+// group is matched against, replaced in tests.
+var (
+	groupDevices = get.GetWireGuardDevices
+	groupLinks   = group.Links
+)
+
+// Function reports whether the command line (os.Args) runs a network
+// interface command on a group of interfaces: `-g prefix` or `-i` with a
+// glob pattern (e.g., `-i 'wg-cust*'`).
+func isGroupArgs(args []string) bool {
+	if len(args) < 3 {
+		return false
+	}
+	return args[1] == help.GroupFlag ||
+		(args[1] == help.WgInterfaceFlag && group.IsPattern(args[2]))
+}
+
+// Function runs a network interface command on each WireGuard device of
+// the group given by the arguments (`-g prefix ...` or `-i pattern ...`,
+// optionally with `-parallel N`) and writes the table of the outcomes to
+// out. The commands are parsed and confirmed for all the interfaces before
+// any of them runs; a failure of one interface does not stop the others.
+// Each interface runs under its own lock (see oplock.AcquireInterface).
+//
+// It returns the results of all the interfaces, a failed result for each
+// failure, and the number of failed interfaces. The error reports a group
+// which could not run at all, with the flag to show.
+func runGroup(args []string, out io.Writer) ([]Result, int, string, error) {
+	if len(args) < 3 {
+		return nil, 0, args[len(args)-1], errors.New(help.DefaultErrorMessage)
+	}
+
+	pattern, err := group.Pattern(args[0], args[1])
+	if err != nil {
+		return nil, 0, args[0], err
+	}
+
+	rest, parallel, err := group.ParseParallel(args[2:])
+	if err != nil {
+		return nil, 0, help.ParallelFlag, err
+	}
+	if len(rest) == 0 || !slices.Contains(interfaceSubFlags, rest[0]) {
+		return nil, 0, args[len(args)-1], errors.New(help.DefaultErrorMessage)
+	}
+	if rest[0] == help.RenameFlag {
+		return nil, 0, rest[0], help.Errorf(help.CodeUnsupported, "error: a group of network interfaces cannot be renamed")
+	}
+
+	devices, err := groupDevices()
+	if err != nil {
+		return nil, 0, args[1], err
+	}
+	links, err := groupLinks()
+	if err != nil {
+		return nil, 0, args[1], err
+	}
+
+	ifaces, ignored, err := group.Expand(pattern, devices, links)
+	for _, name := range ignored {
+		warn(fmt.Sprintf("network interface '%s' is not a WireGuard device, skipped", name))
+	}
+	if err != nil {
+		return nil, 0, args[1], err
+	}
+
+	cmds := make([]Command, len(ifaces))
+	for indx, iface := range ifaces {
+		flag, data := dispatch(slices.Concat([]string{"", help.WgInterfaceFlag, iface}, rest))
+		obj, ok := СommandMap[flag]
+		if !ok {
+			return nil, 0, args[len(args)-1], errors.New(help.DefaultErrorMessage)
+		}
+		cmds[indx] = obj()
+		if curArgs, err := cmds[indx].ParseArgs(data); err != nil {
+			return nil, 0, curArgs, help.WithDefaultCode(help.CodeInvalidValue, err)
+		}
+	}
+
+	if err := confirmGroup(cmds); err != nil {
+		return nil, 0, args[0], err
+	}
+
+	results := make([][]Result, len(ifaces))
+	statuses := group.Run(ifaces, parallel, func(indx int) error {
+		iface := ifaces[indx]
+		res, err := executeLocked(cmds[indx], func(fn func(lock *oplock.Lock) error) error {
+			return oplock.DoInterface(iface, fn)
+		})
+		if err != nil {
+			res = append(res, failed(slices.Concat([]string{help.WgInterfaceFlag, iface}, rest), err))
+		}
+		results[indx] = res
+		return err
+	})
+
+	failures := group.WriteTable(out, statuses)
+	return slices.Concat(results...), failures, "", nil
+}
+
+// Function asks once for the confirmation of the destructive commands of a
+// group, listing the question of each interface (see confirm).
+func confirmGroup(cmds []Command) error {
+	if assumeYes {
+		return nil
+	}
+
+	var questions []string
+	for _, cmd := range cmds {
+		dc, ok := cmd.(destructiveCommand)
+		if !ok {
+			continue
+		}
+		question, err := dc.Confirmation()
+		if err != nil {
+			return err
+		}
+		if question != "" {
+			questions = append(questions, question)
+		}
+	}
+
+	switch len(questions) {
+	case 0:
+		return nil
+	case 1:
+		return confirmQuestion(questions[0])
+	}
+	return confirmQuestion(fmt.Sprintf(
+		"%s\nproceed on %d network interfaces?", strings.Join(questions, "\n"), len(questions),
+	))
+}
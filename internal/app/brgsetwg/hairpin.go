@@ -0,0 +1,226 @@
+//go:build !windows
+
+package brgsetwg
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/validate"
+)
+
+// HairpinCommand encapsulates the data and logic for managing the hairpin
+// NAT rules of a network interface, see firewall.FormatCmdHairpin.
+//
+// The rule only rewrites the source of the traffic re-entering the
+// interface. The traffic must still be forwarded from the interface to
+// itself: a FORWARD policy or rule dropping the traffic between the peers
+// of the interface (peer isolation) blocks it, and no FORWARD rule is
+// added for it.
+type HairpinCommand struct {
+	opLock
+
+	InIface string
+	SubNets []string
+	Flag    firewall.Action
+	Force   bool // Add the rules while the interface has no IPv4 address.
+
+	// The NAT table listed by the command, see RuleSnapshot.
+	rules RuleSnapshot
+}
+
+// Method parses the command-line arguments for the hairpin command.
+// Expected format: `[interface_name] -hairpin [-a | -d] [subnet[,subnet]]
+// [-force]`, IPv4 subnets only, as iptables manages the IPv4 rules. With
+// `-force`, the rules are added even if the interface has no IPv4 address.
+func (p *HairpinCommand) ParseArgs(args []string) (string, error) {
+	if len(args) == 5 && args[2] == help.AddFlag && args[4] == help.ForceFlag {
+		p.Force = true
+		args = args[:4]
+	}
+	if len(args) != 4 {
+		return help.HairpinFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	p.InIface = args[0]
+	if err := validate.CheckInterfaceName(p.InIface); err != nil {
+		return help.WgInterfaceFlag, err
+	}
+
+	switch args[2] {
+	case help.AddFlag:
+		p.Flag = firewall.Append
+	case help.DelFlag:
+		p.Flag = firewall.Delete
+	default:
+		return args[2], errors.New(help.DefaultErrorMessage)
+	}
+
+	subnets, err := parseAddressList(args[3])
+	if err != nil {
+		return help.HairpinFlag, err
+	}
+	for _, value := range subnets {
+		prefix := netip.MustParsePrefix(value).Masked()
+		if prefix.Addr().Is6() {
+			return help.HairpinFlag, fmt.Errorf(
+				"error: IPv6 prefix '%s', hairpin NAT rules are managed for IPv4 (iptables) only", prefix,
+			)
+		}
+		if subnet := prefix.String(); !slices.Contains(p.SubNets, subnet) {
+			p.SubNets = append(p.SubNets, subnet)
+		}
+	}
+
+	return help.HairpinFlag, nil
+}
+
+// Method returns the changed network interface.
+func (p *HairpinCommand) ChangedInterface() string {
+	return p.InIface
+}
+
+// Method adds or deletes the hairpin NAT rules of the subnets. The rules
+// already in place are skipped when added and the missing ones when
+// deleted, so the command can be run again. The rules are recorded in the
+// interface metadata, so they are removed with the interface.
+func (p *HairpinCommand) Execute() ([]Result, error) {
+	exists, err := interfaceExists(p.InIface)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, &get.InterfaceNotFoundError{Name: p.InIface}
+	}
+
+	if p.Flag == firewall.Append {
+		if err := checkFamilies(p.InIface, "hairpin NAT rule", p.SubNets, []string{p.InIface}, p.Force); err != nil {
+			return nil, err
+		}
+	}
+
+	// The states of the rules are read before the first change.
+	listed := make([]bool, len(p.SubNets))
+	for indx, subnet := range p.SubNets {
+		listed[indx], err = p.listed(subnet)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Flag == firewall.Delete {
+		return p.deleteRules(listed)
+	}
+	return p.addRules(listed)
+}
+
+// Method adds the hairpin NAT rules not listed yet. If a rule fails, the
+// rules added before it are removed again.
+func (p *HairpinCommand) addRules(listed []bool) ([]Result, error) {
+	var results []Result
+	var rules []peermeta.Rule
+	var undo []string
+	rollback := func(err error) ([]Result, error) {
+		for _, cmd := range slices.Backward(undo) {
+			shell.DefaultRunner.Run(cmd, ShellStd)
+		}
+		return nil, err
+	}
+
+	for indx, subnet := range p.SubNets {
+		rules = append(rules, set.HairpinRule(subnet, p.InIface))
+		if listed[indx] {
+			results = append(results, skipped("hairpin-rule-add", p.InIface, subnet))
+			continue
+		}
+
+		if err := shell.DefaultRunner.Run(firewall.FormatCmdHairpin(firewall.Append, subnet, p.InIface), ShellStd); err != nil {
+			return rollback(err)
+		}
+		undo = append(undo, firewall.FormatCmdHairpin(firewall.Delete, subnet, p.InIface))
+		results = append(results, applied("hairpin-rule-add", p.InIface, subnet))
+	}
+
+	// A rule iptables accepted but does not list would be recorded as
+	// applied while missing, see IpIntertfaceCommand.verifyRules.
+	if len(undo) > 0 {
+		p.rules.Invalidate()
+		for indx, subnet := range p.SubNets {
+			if listed[indx] {
+				continue
+			}
+			ok, err := p.listed(subnet)
+			if err != nil {
+				return rollback(err)
+			}
+			if !ok {
+				return rollback(help.Errorf(
+					help.CodeRuleNotListed,
+					"error: iptables accepted the hairpin NAT rule of '%s' of network interface '%s', but it is not listed",
+					subnet, p.InIface,
+				))
+			}
+		}
+	}
+
+	return results, set.RecordApplied(p.InIface, rules, nil, p.lock)
+}
+
+// Method deletes the listed hairpin NAT rules and forgets them.
+func (p *HairpinCommand) deleteRules(listed []bool) ([]Result, error) {
+	var results []Result
+	defer p.rules.Invalidate()
+
+	for indx, subnet := range p.SubNets {
+		if listed[indx] {
+			if err := shell.DefaultRunner.Run(firewall.FormatCmdHairpin(firewall.Delete, subnet, p.InIface), ShellStd); err != nil {
+				return results, err
+			}
+			results = append(results, applied("hairpin-rule-delete", p.InIface, subnet))
+		} else {
+			results = append(results, skipped("hairpin-rule-delete", p.InIface, subnet))
+		}
+
+		if err := set.ForgetApplied(p.InIface, []peermeta.Rule{set.HairpinRule(subnet, p.InIface)}, nil, p.lock); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// Method reports whether the NAT table lists the hairpin rule of the
+// subnet, tagged with the interface.
+func (p *HairpinCommand) listed(subnet string) (bool, error) {
+	rules, err := p.rules.NAT()
+	if err != nil {
+		return false, err
+	}
+	return hairpinListed(rules, p.InIface, subnet), nil
+}
+
+// Function reports whether the POSTROUTING chain holds the hairpin rule of
+// firewall.FormatCmdHairpin: MASQUERADE of the subnet to the subnet leaving
+// through the interface, tagged with it.
+func hairpinListed(rules get.IptablesOutput, iface, subnet string) bool {
+	filter := get.FilterIptablesOutput{Rule: rules}
+	chain, err := filter.GetChain("POSTROUTING")
+	if err != nil {
+		return false
+	}
+
+	for _, rule := range chain.Rules {
+		if rule.Target == "MASQUERADE" && rule.Out == iface && rule.Comment == firewall.RuleTag(iface) &&
+			rule.Source == subnet && rule.Destination == subnet {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+//go:build !windows
+
+package brgsetwg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/set"
+)
+
+// Path of the systemd unit written by `-fr -save -unit`, replaced in tests.
+var restoreUnitPath = set.DefaultRestoreUnit
+
+// PersistCommand encapsulates the data and logic for saving the iptables
+// rules tagged by brgnetuse to a file and restoring them.
+type PersistCommand struct {
+	opLock
+
+	Action string
+	Path   string
+	Force  bool
+	Unit   bool
+}
+
+// Method parses the command-line arguments for the persist command.
+// Expected format: `-save [path] [-force] [-unit]` or `-restore [path|-]`,
+// where '-' reads the rules from stdin.
+func (p *PersistCommand) ParseArgs(args []string) (string, error) {
+	p.Action = args[0]
+	p.Path = set.DefaultRulesPath
+
+	var path bool
+	for _, arg := range args[1:] {
+		switch {
+		case arg == help.ForceFlag && p.Action == help.SaveFlag && !p.Force:
+			p.Force = true
+		case arg == help.UnitFlag && p.Action == help.SaveFlag && !p.Unit:
+			p.Unit = true
+		case arg == help.StdinValue && p.Action == help.RestoreFlag && !path:
+			p.Path = arg
+			path = true
+		case !strings.HasPrefix(arg, "-") && !path:
+			p.Path = arg
+			path = true
+		default:
+			return arg, errors.New(help.DefaultErrorMessage)
+		}
+	}
+
+	return help.FirewallFlag, nil
+}
+
+// Method saves the rules with set.SaveRules, and writes the systemd unit
+// restoring them at boot with -unit, or restores them with
+// set.RestoreRules.
+func (p *PersistCommand) Execute() ([]Result, error) {
+	if p.Action == help.RestoreFlag {
+		var count int
+		var err error
+		if p.Path == help.StdinValue {
+			var content []byte
+			content, err = handlers.ReadInput(handlers.Stdin)
+			if err != nil {
+				return nil, err
+			}
+			count, err = set.RestoreRulesContent(content, "stdin", p.lock)
+		} else {
+			count, err = set.RestoreRules(p.Path, p.lock)
+		}
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(noteOut, "restored %d rule(s) from '%s'\n", count, p.Path)
+		return []Result{applied("rules-restore", p.Path, fmt.Sprintf("%d rule(s)", count))}, nil
+	}
+
+	count, err := set.SaveRules(p.Path, p.Force, p.lock)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(noteOut, "saved %d rule(s) to '%s'\n", count, p.Path)
+	results := []Result{applied("rules-save", p.Path, fmt.Sprintf("%d rule(s)", count))}
+
+	if !p.Unit {
+		return results, nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return results, fmt.Errorf("error: failed to locate the executable: %v", err)
+	}
+	path, err := filepath.Abs(p.Path)
+	if err != nil {
+		return results, help.Errorf(help.CodeInvalidValue, "error: invalid path '%s': %v", p.Path, err)
+	}
+	if err := set.WriteRestoreUnit(restoreUnitPath, path, exe, p.Force); err != nil {
+		return results, err
+	}
+	fmt.Fprintf(
+		noteOut, "wrote '%s', enable it with: systemctl enable %s\n",
+		restoreUnitPath, filepath.Base(restoreUnitPath),
+	)
+	return append(results, applied("restore-unit", restoreUnitPath, "")), nil
+}
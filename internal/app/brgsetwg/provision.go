@@ -0,0 +1,174 @@
+//go:build !windows
+
+package brgsetwg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/provision"
+	"github.com/AlexKira/brgnetuse/src/validate"
+)
+
+// Function adds a client to the network interface, replaced in tests.
+var provisionPeer = provision.CreatePeerWithAccess
+
+// ProvisionCommand encapsulates the data and logic for adding a client to
+// a network interface with its configuration, see
+// provision.CreatePeerWithAccess.
+type ProvisionCommand struct {
+	opLock
+
+	Iface   string
+	Options provision.ProvisionOptions
+
+	// OutPath receives the client configuration (0600), stdout when empty.
+	OutPath string
+
+	// QRPath receives the QR code of the configuration as a PNG image.
+	QRPath string
+}
+
+// Method parses the command-line arguments for the provision command.
+// Expected format: `[interface_name] -provision -name [name] -eh [host[:port]]
+// [-dns servers] [-kp seconds] [-expires date] [-out path] [-qr path]`.
+// Each option may be given once, -kp 0 disables the keepalive.
+func (p *ProvisionCommand) ParseArgs(args []string) (string, error) {
+	if len(args) < 2 {
+		return help.ProvisionFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	p.Iface = args[0]
+	if err := validate.CheckInterfaceName(p.Iface); err != nil {
+		return help.WgInterfaceFlag, err
+	}
+
+	seen := make(map[string]bool)
+	for indx := 2; indx < len(args); indx++ {
+		flag := args[indx]
+		if seen[flag] {
+			return flag, errors.New(help.DefaultErrorMessage)
+		}
+		seen[flag] = true
+
+		indx++
+		if indx >= len(args) || args[indx] == "" || isFlag(flag, args[indx]) {
+			return flag, errors.New(help.DefaultErrorMessage)
+		}
+		value := args[indx]
+
+		switch flag {
+		case help.PeerNameFlag:
+			p.Options.Name = value
+		case help.EndPointHostFlag:
+			p.Options.Endpoint = value
+		case help.DNSFlag:
+			servers, err := validate.CheckDNSServers(value)
+			if err != nil {
+				return flag, err
+			}
+			p.Options.DNS = servers
+		case help.KeepaliveFlag:
+			interval, err := validate.CheckKeepalive(value)
+			if err != nil {
+				return flag, err
+			}
+			p.Options.Keepalive = int(interval.Seconds())
+			if p.Options.Keepalive == 0 {
+				p.Options.Keepalive = -1
+			}
+		case help.PeerExpiresFlag:
+			if _, err := validate.CheckExpiry(value); err != nil {
+				return flag, err
+			}
+			p.Options.Expires = value
+		case help.OutFlag:
+			p.OutPath = value
+		case help.QRFlag:
+			p.QRPath = value
+			p.Options.QR = true
+		default:
+			return flag, errors.New(help.DefaultErrorMessage)
+		}
+	}
+
+	if p.Options.Name == "" {
+		return help.PeerNameFlag, fmt.Errorf(
+			"error: '%s' requires the client name, pass '%s [name]'", help.ProvisionFlag, help.PeerNameFlag,
+		)
+	}
+	if p.Options.Endpoint == "" {
+		return help.EndPointHostFlag, fmt.Errorf(
+			"error: the client configuration requires the server endpoint, pass '%s [host[:port]]'",
+			help.EndPointHostFlag,
+		)
+	}
+	if p.OutPath != "" && p.OutPath == p.QRPath {
+		return help.QRFlag, fmt.Errorf("error: '%s' and '%s' name the same file", help.OutFlag, help.QRFlag)
+	}
+	return help.ProvisionFlag, nil
+}
+
+// Method returns the changed network interface.
+func (p *ProvisionCommand) ChangedInterface() string {
+	return p.Iface
+}
+
+// Method adds the client, see provisionClient.
+func (p *ProvisionCommand) Execute() ([]Result, error) {
+	return p.provisionClient(os.Stdout)
+}
+
+// Method adds the client and writes its configuration to OutPath, or out,
+// and its QR code to QRPath. The files are created (0600, never
+// overwritten) before the peer is added, so a file which cannot be written
+// does not leave a peer whose private key is lost; they are removed again
+// if the peer cannot be added.
+func (p *ProvisionCommand) provisionClient(out io.Writer) ([]Result, error) {
+	var files []*os.File
+	closeAll := func(remove bool) {
+		for _, file := range files {
+			file.Close()
+			if remove {
+				os.Remove(file.Name())
+			}
+		}
+	}
+	for _, path := range []string{p.OutPath, p.QRPath} {
+		if path == "" {
+			continue
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			closeAll(true)
+			return nil, help.Errorf(help.CodeFileFailed, "error: failed to create '%s': %v", path, err)
+		}
+		files = append(files, file)
+	}
+
+	result, err := provisionPeer(p.Iface, p.Options, p.lock)
+	if err != nil {
+		closeAll(true)
+		return nil, err
+	}
+	defer closeAll(false)
+
+	configOut := out
+	if p.OutPath != "" {
+		configOut = files[0]
+	}
+	if _, err := io.WriteString(configOut, result.Config); err != nil {
+		return nil, help.Errorf(help.CodeFileFailed, "error: peer '%s' added, failed to write its configuration: %v", result.PublicKey, err)
+	}
+	if p.QRPath != "" {
+		if _, err := files[len(files)-1].Write(result.QR); err != nil {
+			return nil, help.Errorf(help.CodeFileFailed, "error: peer '%s' added, failed to write its QR code: %v", result.PublicKey, err)
+		}
+	}
+
+	fmt.Fprintf(noteOut, "client %s: address %s, public key %s\n", p.Options.Name, result.Address, result.PublicKey)
+	return []Result{applied("peer-provision", result.PublicKey, p.Options.Name+" "+result.Address)}, nil
+}
@@ -0,0 +1,400 @@
+//go:build !windows
+
+package brgsetwg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/proc"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/validate"
+)
+
+// Directories of the UAPI sockets of the userspace devices
+// (wireguard-go, amneziawg-go), replaced in tests.
+var uapiSocketDirs = []string{handlers.WgSocketDir, handlers.AwgSocketDir}
+
+// Time given to a terminated userspace process to remove its TUN device
+// before the link is deleted explicitly, replaced in tests.
+var purgeLinkWait = 3 * time.Second
+
+// PurgeCommand encapsulates the data and logic for removing everything
+// associated with a network interface.
+type PurgeCommand struct {
+	opLock
+
+	Iface string
+	Dry   bool
+}
+
+// Method parses the command-line arguments for the purge command.
+// Expected format: `[interface_name] -purge [-dry]`.
+func (p *PurgeCommand) ParseArgs(args []string) (string, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return help.PurgeFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	p.Iface = args[0]
+	if err := validate.CheckInterfaceName(p.Iface); err != nil {
+		return help.WgInterfaceFlag, err
+	}
+
+	if len(args) == 3 {
+		if args[2] != help.DryFlag {
+			return args[2], errors.New(help.DefaultErrorMessage)
+		}
+		p.Dry = true
+	}
+
+	return help.PurgeFlag, nil
+}
+
+// Method reports whether the command only prints the plan (-dry).
+func (p *PurgeCommand) ReadOnly() bool {
+	return p.Dry
+}
+
+// Method discovers the state attached to the interface and removes it,
+// see purgeInterface.
+func (p *PurgeCommand) Execute() ([]Result, error) {
+	return purgeInterface(p.Iface, p.Dry, os.Stdout, p.lock)
+}
+
+// purgeAction is a single removal step of purgeInterface.
+type purgeAction struct {
+	// Desc describes the removed object (e.g., "address 10.0.0.1/24").
+	Desc string
+
+	// Run performs the removal.
+	Run func() error
+
+	// Rule reports an iptables rule.
+	Rule bool
+}
+
+// Function removes everything associated with the network interface, in
+// order: NAT rules, firewall rules, the INPUT port rule, addresses, DNS
+// servers, the userspace process, the link, the UAPI socket and the peer metadata.
+//
+// Only the iptables rules created by this tool are removed: the rules
+// tagged for the interface (see firewall.RuleTag) and the untagged ones
+// recorded in its metadata, added before the rules were tagged. The other
+// matching rules are listed as kept.
+//
+// Each action is printed, and a failed action does not stop the following
+// ones; a summary is printed at the end and an error is returned if any
+// action failed. With dry set, the actions are only listed, and reported
+// as skipped.
+func purgeInterface(iface string, dry bool, out io.Writer, held ...*oplock.Lock) ([]Result, error) {
+	actions, kept, err := planPurge(iface, held...)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	for _, desc := range kept {
+		fmt.Fprintf(out, "keeping %s, not created by brgnetuse\n", desc)
+		results = append(results, skipped("purge", iface, desc+", not created by brgnetuse"))
+	}
+
+	if len(actions) == 0 {
+		fmt.Fprintf(out, "nothing to remove for %s\n", iface)
+		return results, nil
+	}
+
+	if dry {
+		for _, action := range actions {
+			fmt.Fprintf(out, "would remove %s\n", action.Desc)
+			results = append(results, skipped("purge", iface, action.Desc+" (dry run)"))
+		}
+		fmt.Fprintf(out, "purge %s: %d actions (dry run)\n", iface, len(actions))
+		return results, nil
+	}
+
+	failed := 0
+	for _, action := range actions {
+		if err := action.Run(); err != nil {
+			failed++
+			fmt.Fprintf(out, "failed to remove %s: %v\n", action.Desc, err)
+			results = append(results, Result{
+				Action: "purge", Target: iface, Status: StatusFailed,
+				Detail: fmt.Sprintf("%s: %v", action.Desc, err),
+				Code:   string(help.CodeOf(err)),
+			})
+			continue
+		}
+		fmt.Fprintf(out, "removed %s\n", action.Desc)
+		results = append(results, applied("purge", iface, action.Desc))
+	}
+
+	fmt.Fprintf(out, "purge %s: %d removed, %d failed\n", iface, len(actions)-failed, failed)
+	if failed > 0 {
+		return results, fmt.Errorf(
+			"error: purge of '%s' incomplete, %d of %d actions failed",
+			iface, failed, len(actions),
+		)
+	}
+	return results, nil
+}
+
+// Function discovers the state attached to the interface and returns the
+// actions removing it in order, and the descriptions of the matching rules
+// not created by this tool.
+func planPurge(iface string, held ...*oplock.Lock) ([]purgeAction, []string, error) {
+	var actions []purgeAction
+	var kept []string
+
+	exists, err := interfaceExists(iface)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pid, wgType, err := proc.FindProcess(iface)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta, err := peermeta.LoadInterface(iface)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Function sorts a matching rule into the actions or the kept rules.
+	// The port rule is not bound to an interface, its tag has no name.
+	addRule := func(table, chain string, rule get.IptablesRule, port bool) {
+		action := iptablesAction(table, chain, rule)
+
+		owner, tagged := rule.Owner()
+		recorded := rule.Comment == "" &&
+			slices.Contains(meta.Rules, peermeta.Rule{Table: table, Chain: chain, Spec: ruleSpec(rule)})
+		if (tagged && owner == iface) || (tagged && port && owner == "") || recorded {
+			actions = append(actions, action)
+			return
+		}
+		kept = append(kept, action.Desc)
+	}
+
+	var subnets, addrs []string
+	if exists {
+		show, err := get.GetIpShow(iface)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, link := range show {
+			for _, addr := range link.AddrInfo {
+				// Link-local addresses are removed with the link.
+				if addr.Scope == "link" {
+					continue
+				}
+				cidr := fmt.Sprintf("%s/%d", addr.Local, addr.Prefixlen)
+				addrs = append(addrs, cidr)
+				if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+					subnets = append(subnets, ipNet.String())
+				}
+			}
+		}
+	}
+
+	nat, err := get.GetIptablesNAT()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, chain := range nat.Chains {
+		for _, rule := range chain.Rules {
+			masquerade := chain.Name == "POSTROUTING" && rule.Target == "MASQUERADE" &&
+				slices.Contains(subnets, rule.Source)
+			owner, _ := rule.Owner()
+			if masquerade || rule.In == iface || rule.Out == iface || owner == iface {
+				addRule("nat", chain.Name, rule, false)
+			}
+		}
+	}
+
+	filter, err := get.GetIptablesFirewall()
+	if err != nil {
+		return nil, nil, err
+	}
+	port := listenPort(iface, exists, wgType)
+	for _, chain := range filter.Chains {
+		for _, rule := range chain.Rules {
+			portRule := chain.Name == "INPUT" && port != "" && rule.Prot == "udp" &&
+				slices.Contains(strings.Fields(rule.Options), "dpt:"+port)
+			owner, _ := rule.Owner()
+			if portRule || rule.In == iface || rule.Out == iface || owner == iface {
+				addRule("filter", chain.Name, rule, portRule)
+			}
+		}
+	}
+
+	for _, cidr := range addrs {
+		actions = append(actions, purgeAction{
+			Desc: "address " + cidr,
+			Run: func() error {
+				return shell.DefaultRunner.Run(shell.FormatCmdIpAddrDev(iface, cidr, shell.IpDel), ShellStd)
+			},
+		})
+	}
+
+	if len(meta.DNS) > 0 {
+		servers := meta.DNS
+		actions = append(actions, purgeAction{
+			Desc: "dns servers " + strings.Join(servers, ","),
+			Run:  func() error { return set.RemoveInterfaceDNS(iface, servers, held...) },
+		})
+	}
+
+	if pid != 0 {
+		actions = append(actions, purgeAction{
+			Desc: fmt.Sprintf("%s process %d", wgType, pid),
+			Run: func() error {
+				return shell.DefaultRunner.Run(shell.FormatCmdKill(pid), ShellStd)
+			},
+		})
+	}
+
+	if exists {
+		actions = append(actions, purgeAction{
+			Desc: "link " + iface,
+			Run: func() error {
+				// A terminated userspace process removes its TUN device.
+				if pid != 0 && waitLinkGone(iface) {
+					return nil
+				}
+				return shell.DefaultRunner.Run(shell.FormatCmdIpLinkDelete(iface), ShellStd)
+			},
+		})
+	}
+
+	for _, dir := range uapiSocketDirs {
+		path := handlers.SocketPath(dir, iface)
+		if _, err := os.Lstat(path); err != nil {
+			continue
+		}
+		actions = append(actions, purgeAction{
+			Desc: "socket " + path,
+			Run: func() error {
+				if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+				return nil
+			},
+		})
+	}
+
+	for _, path := range []string{peermeta.Path(iface), peermeta.InterfacePath(iface), peermeta.ChangePath(iface)} {
+		if _, err := os.Stat(path); err == nil {
+			actions = append(actions, purgeAction{
+				Desc: "metadata " + filepath.Base(path),
+				Run:  func() error { return peermeta.Purge(iface) },
+			})
+			break
+		}
+	}
+
+	return actions, kept, nil
+}
+
+// Function returns the action deleting an iptables rule by its specification.
+func iptablesAction(table, chain string, rule get.IptablesRule) purgeAction {
+	spec := ruleSpec(rule)
+	return purgeAction{
+		Desc: fmt.Sprintf("%s %s rule '%s'", table, chain, spec),
+		Run: func() error {
+			return shell.DefaultRunner.Run(firewall.FormatCmdDelete(table, chain, spec), ShellStd)
+		},
+		Rule: true,
+	}
+}
+
+// Function rebuilds the iptables specification of a listed rule
+// (e.g., "-s 10.0.0.0/24 -o eth0 -j MASQUERADE"), in the order of the
+// specifications of set.ForwardRules and set.NATRule.
+func ruleSpec(rule get.IptablesRule) string {
+	isAny := func(value string) bool {
+		return value == "" || value == "*" || value == "any" ||
+			value == "anywhere" || value == "0.0.0.0/0"
+	}
+
+	var spec []string
+	if rule.Prot != "" && rule.Prot != "all" && rule.Prot != "0" {
+		spec = append(spec, "-p", rule.Prot)
+	}
+	if !isAny(rule.Source) {
+		spec = append(spec, "-s", rule.Source)
+	}
+	if !isAny(rule.Destination) {
+		spec = append(spec, "-d", rule.Destination)
+	}
+	if !isAny(rule.In) {
+		spec = append(spec, "-i", rule.In)
+	}
+	if !isAny(rule.Out) {
+		spec = append(spec, "-o", rule.Out)
+	}
+	for _, option := range strings.Fields(rule.Options) {
+		if port, ok := strings.CutPrefix(option, "dpt:"); ok {
+			spec = append(spec, "--dport", port)
+		} else if port, ok := strings.CutPrefix(option, "spt:"); ok {
+			spec = append(spec, "--sport", port)
+		}
+	}
+	if rule.Comment != "" {
+		spec = append(spec, "-m", "comment", "--comment", fmt.Sprintf("%q", rule.Comment))
+	}
+	spec = append(spec, "-j", rule.Target)
+
+	return strings.Join(spec, " ")
+}
+
+// Function returns the listen port of the interface, or an empty string
+// when the device cannot be read.
+func listenPort(iface string, exists bool, wgType string) string {
+	if !exists {
+		return ""
+	}
+
+	if wgType == help.Env_Awg_Type {
+		info, err := get.GetAwgPeerInfo(iface)
+		if err != nil || info.ListenPort == 0 {
+			return ""
+		}
+		return strconv.Itoa(info.ListenPort)
+	}
+
+	devices, err := get.GetPeer(iface)
+	if err != nil || devices[0].ListenPort == 0 {
+		return ""
+	}
+	return strconv.Itoa(devices[0].ListenPort)
+}
+
+// Function waits up to purgeLinkWait for the link to disappear.
+func waitLinkGone(iface string) bool {
+	deadline := time.Now().Add(purgeLinkWait)
+	for {
+		exists, err := interfaceExists(iface)
+		if err == nil && !exists {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,139 @@
+//go:build !windows
+
+package brgsetwg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/reconcile"
+)
+
+// ReconcileCommand encapsulates the data and logic for reconciling the
+// system with a desired state file.
+type ReconcileCommand struct {
+	opLock
+
+	Path  string
+	Apply bool
+	JSON  bool
+}
+
+// reconcileReport is the output of the reconcile command in JSON format.
+type reconcileReport struct {
+	Changes []reconcile.Change `json:"changes"`
+	Applied bool               `json:"applied"`
+}
+
+// Method parses the command-line arguments for the reconcile command.
+// Expected format: `[path|-] [-apply] [-js]`, where '-' reads the state
+// from stdin.
+func (p *ReconcileCommand) ParseArgs(args []string) (string, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return help.ReconcileFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	p.Path = args[0]
+	for _, arg := range args[1:] {
+		switch {
+		case arg == help.ApplyFlag && !p.Apply:
+			p.Apply = true
+		case arg == help.LogTypeFlag && !p.JSON:
+			p.JSON = true
+		default:
+			return arg, errors.New(help.DefaultErrorMessage)
+		}
+	}
+
+	return help.ReconcileFlag, nil
+}
+
+// Method reports whether the command only prints the changes (no -apply).
+func (p *ReconcileCommand) ReadOnly() bool {
+	return !p.Apply
+}
+
+// Method reads the desired state from the state file, or from stdin
+// for '-'.
+func (p *ReconcileCommand) load() (reconcile.State, error) {
+	if p.Path != help.StdinValue {
+		return reconcile.Load(p.Path)
+	}
+
+	data, err := handlers.ReadInput(handlers.Stdin)
+	if err != nil {
+		return reconcile.State{}, err
+	}
+	return reconcile.Parse(data)
+}
+
+// Method compares the state file with the system and prints the changes,
+// applying them with -apply.
+func (p *ReconcileCommand) Execute() ([]Result, error) {
+	desired, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := reconcile.Inspect(desired)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := reconcile.Diff(desired, current)
+	if err := p.run(desired, changes, os.Stdout); err != nil {
+		return nil, err
+	}
+
+	// Without -apply the changes are only listed.
+	var results []Result
+	for _, change := range changes {
+		target := change.Interface
+		if target == "" {
+			target = change.Target
+		}
+		result := skipped(change.Kind+"-"+change.Action, target, change.String())
+		if p.Apply {
+			result.Status = StatusApplied
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Method prints the changes, or applies them with -apply.
+func (p *ReconcileCommand) run(desired reconcile.State, changes []reconcile.Change, out io.Writer) error {
+	if p.JSON {
+		report := reconcileReport{Changes: changes, Applied: p.Apply && len(changes) > 0}
+		if report.Changes == nil {
+			report.Changes = []reconcile.Change{}
+		}
+		if p.Apply {
+			if err := reconcile.Apply(desired, changes, io.Discard, p.lock); err != nil {
+				return err
+			}
+		}
+
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "no changes, the system matches the state file")
+		return nil
+	}
+
+	if p.Apply {
+		return reconcile.Apply(desired, changes, out, p.lock)
+	}
+	for _, change := range changes {
+		fmt.Fprintln(out, change)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+//go:build !windows
+
+package brgsetwg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+)
+
+// Statuses of a Result.
+const (
+	// The change was made.
+	StatusApplied = "applied"
+
+	// The change was not needed (e.g., the rule exists) or only planned.
+	StatusSkipped = "skipped"
+
+	// The change failed, Detail holds the error.
+	StatusFailed = "failed"
+
+	// A condition which did not fail the command (see set.Warning), Code
+	// holds its code.
+	StatusWarning = "warning"
+)
+
+// Result describes a single change made by a command. With -js the
+// results of the command are printed as a JSON array on stdout, the
+// human readable output goes to stderr.
+type Result struct {
+	// Action names the change (e.g., "nat-rule-add", "peer-delete").
+	Action string `json:"action"`
+
+	// Target is the changed object: a network interface, a public key,
+	// a port or a path.
+	Target string `json:"target"`
+
+	// Status is StatusApplied, StatusSkipped, StatusFailed or StatusWarning.
+	Status string `json:"status"`
+
+	// Detail describes the change (e.g., the subnet of a NAT rule).
+	Detail string `json:"detail,omitempty"`
+
+	// Code is the stable code of the error of a failed change (e.g.,
+	// "BRG-E003") or of a warning (e.g., "BRG-W001"), see help.Code.
+	Code string `json:"code,omitempty"`
+}
+
+// Function returns the result of a change made by a command.
+func applied(action, target, detail string) Result {
+	return Result{Action: action, Target: target, Status: StatusApplied, Detail: detail}
+}
+
+// Function returns the result of a change a command did not need to make.
+func skipped(action, target, detail string) Result {
+	return Result{Action: action, Target: target, Status: StatusSkipped, Detail: detail}
+}
+
+// Function reports a change found already in place: a note and a skipped
+// result with the detail "unchanged", or with strict (-strict) an error.
+// The state describes what is in place (e.g., "network interface 'wg0'
+// already listens on port 51820").
+func unchanged(strict bool, action, target, state string) (Result, error) {
+	if strict {
+		return Result{}, help.WithCode(help.CodeUnchanged, errors.New("error: "+state))
+	}
+	fmt.Fprintf(noteOut, "%s, unchanged\n", state)
+	return skipped(action, target, "unchanged"), nil
+}
+
+// Function returns the result reporting the error of a command. The
+// action is made of the flags of the command line (e.g., "-i -ip -a -n"),
+// the target is its first value (e.g., the network interface).
+func failed(args []string, err error) Result {
+	var flags []string
+	target := ""
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "-"):
+			flags = append(flags, arg)
+		case target == "":
+			target = arg
+		}
+	}
+	return Result{
+		Action: strings.Join(flags, " "),
+		Target: target,
+		Status: StatusFailed,
+		Detail: err.Error(),
+		Code:   string(help.CodeOf(err)),
+	}
+}
+
+// Function writes the results as an indented JSON array, an empty array
+// when there are none. The details are not HTML escaped (e.g., "->").
+func writeResults(out io.Writer, results []Result) error {
+	if results == nil {
+		results = []Result{}
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
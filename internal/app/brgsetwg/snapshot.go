@@ -0,0 +1,48 @@
+//go:build !windows
+
+package brgsetwg
+
+import (
+	"github.com/AlexKira/brgnetuse/src/get"
+)
+
+// RuleSnapshot holds the firewall and NAT tables listed during a command,
+// so each table is listed and parsed once for all the existence checks of
+// the command (see getRules) instead of once per check. A command changing
+// the rules invalidates the snapshot, the next check lists the table again
+// (e.g., to verify the rules were added).
+type RuleSnapshot struct {
+	firewall *get.IptablesOutput
+	nat      *get.IptablesOutput
+}
+
+// Method returns the rules of the firewall (filter) table, listed on the
+// first call.
+func (s *RuleSnapshot) Firewall() (get.IptablesOutput, error) {
+	return s.table(&s.firewall, get.GetIptablesFirewall)
+}
+
+// Method returns the rules of the NAT table, listed on the first call.
+func (s *RuleSnapshot) NAT() (get.IptablesOutput, error) {
+	return s.table(&s.nat, get.GetIptablesNAT)
+}
+
+// Method forgets the listed tables after the rules changed.
+func (s *RuleSnapshot) Invalidate() {
+	s.firewall, s.nat = nil, nil
+}
+
+// Method returns the cached table, listing it with list when missing. A
+// failed listing is not cached.
+func (s *RuleSnapshot) table(cached **get.IptablesOutput, list func() (get.IptablesOutput, error)) (get.IptablesOutput, error) {
+	if *cached != nil {
+		return **cached, nil
+	}
+
+	rules, err := list()
+	if err != nil {
+		return get.IptablesOutput{}, err
+	}
+	*cached = &rules
+	return rules, nil
+}
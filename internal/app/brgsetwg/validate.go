@@ -0,0 +1,151 @@
+//go:build !windows
+
+package brgsetwg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/command"
+	"github.com/AlexKira/brgnetuse/src/reconcile"
+	"github.com/AlexKira/brgnetuse/src/validate"
+)
+
+// Formats of the files checked by `-validate`.
+const (
+	// State file of `-reconcile`, see reconcile.State.
+	ValidateFormatJSON = "json"
+
+	// Peer list, see validate.CheckPeerList.
+	ValidateFormatCSV = "csv"
+
+	// wg-quick configuration, see validate.CheckWgQuick.
+	ValidateFormatConf = "conf"
+)
+
+// Function checks the data of a file in the format and returns every
+// problem found. The checks are those of the apply path: reconcile.Check
+// is the validation of reconcile.Load, and the peers are checked with
+// validate.CheckPeers.
+var validateFormats = map[string]func([]byte) []validate.Problem{
+	ValidateFormatJSON: reconcile.Check,
+	ValidateFormatCSV:  validate.CheckPeerList,
+	ValidateFormatConf: validate.CheckWgQuick,
+}
+
+// ValidateCommand encapsulates the data and logic for validating a file
+// offline, e.g., in a CI pipeline: nothing is read from nor changed on the
+// system.
+type ValidateCommand struct {
+	Path   string
+	Format string
+}
+
+// Method parses the command-line arguments for the validate command.
+// Expected format: `[path|-] [-format json|csv|conf]`, where '-' reads the
+// file from stdin. The format defaults to the extension of the file.
+func (p *ValidateCommand) ParseArgs(args []string) (string, error) {
+	if len(args) != 1 && len(args) != 3 {
+		return help.ValidateFlag, errors.New(help.DefaultErrorMessage)
+	}
+
+	p.Path = args[0]
+	if len(args) == 3 {
+		if args[1] != help.FormatFlag {
+			return args[1], errors.New(help.DefaultErrorMessage)
+		}
+		p.Format = args[2]
+	} else {
+		p.Format = strings.TrimPrefix(filepath.Ext(p.Path), ".")
+	}
+
+	if _, ok := validateFormats[p.Format]; !ok {
+		formats := []string{ValidateFormatJSON, ValidateFormatCSV, ValidateFormatConf}
+		return help.FormatFlag, fmt.Errorf(
+			"error: unknown format of '%s', expected one of: %s (pass '%s')",
+			p.Path, strings.Join(formats, ", "), help.FormatFlag,
+		)
+	}
+	return help.ValidateFlag, nil
+}
+
+// Method reports that the command never changes the system.
+func (p *ValidateCommand) ReadOnly() bool {
+	return true
+}
+
+// Method reads the file and checks it, see check.
+func (p *ValidateCommand) Execute() ([]Result, error) {
+	var data []byte
+	var err error
+	if p.Path == help.StdinValue {
+		data, err = handlers.ReadInput(handlers.Stdin)
+	} else {
+		data, err = os.ReadFile(p.Path)
+		if err != nil {
+			err = help.Errorf(help.CodeFileFailed, "error: failed to read '%s': %v", p.Path, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p.check(data, os.Stdout)
+}
+
+// Method checks the data of the file and prints every problem found,
+// `file:line: message`. The checks run offline (see offline): a check
+// reaching the system is a bug reported as an error. With problems the
+// command fails, each problem is a failed result.
+func (p *ValidateCommand) check(data []byte, out io.Writer) ([]Result, error) {
+	runner, restore := offline()
+	problems := validateFormats[p.Format](data)
+	restore()
+
+	if len(runner.Refused) > 0 {
+		return nil, fmt.Errorf(
+			"error: the validation of '%s' ran commands: %s", p.Path, strings.Join(runner.Refused, "; "),
+		)
+	}
+
+	var results []Result
+	for _, problem := range problems {
+		fmt.Fprintln(out, problem.Format(p.Path))
+		results = append(results, Result{
+			Action: "validate",
+			Target: p.Path,
+			Status: StatusFailed,
+			Detail: problem.Format(p.Path),
+		})
+	}
+	if len(problems) > 0 {
+		return results, help.Errorf(help.CodeInvalidFile, "error: %d problems found in '%s'", len(problems), p.Path)
+	}
+
+	fmt.Fprintf(noteOut, "'%s' is valid\n", p.Path)
+	return []Result{skipped("validate", p.Path, "valid")}, nil
+}
+
+// Function cuts the system off until the returned function is called: the
+// shell commands are refused (see command.OfflineRunner) and so is the
+// wgctrl client, so a validation can neither read nor change the system.
+func offline() (*command.OfflineRunner, func()) {
+	runner := &command.OfflineRunner{}
+	prevRunner, prevClient := shell.DefaultRunner, handlers.NewWgClient
+
+	shell.DefaultRunner = runner
+	handlers.NewWgClient = func() (handlers.WgClient, error) {
+		runner.Refused = append(runner.Refused, "wgctrl")
+		return nil, command.ErrOffline
+	}
+
+	return runner, func() {
+		shell.DefaultRunner, handlers.NewWgClient = prevRunner, prevClient
+	}
+}
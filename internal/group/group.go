@@ -0,0 +1,206 @@
+// Package runs a command on a group of WireGuard network interfaces.
+//
+// A group is given by a glob pattern of the interface name (`-i
+// 'wg-cust*'`) or by a name prefix (`-g wg-cust`). It is expanded to the
+// WireGuard and AmneziaWG devices matching it, the other network interfaces
+// are never part of a group. The command runs on each interface, past the
+// failures of the others, and the outcome is reported as a table:
+//
+//	INTERFACE  STATUS  DETAIL
+//	wg-cust1   ok
+//	wg-cust2   failed  error: ...
+package group
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/netns"
+)
+
+// Characters making a network interface name a glob pattern.
+const patternChars string = "*?["
+
+// Status of an interface in the table of WriteTable.
+const (
+	StatusOk     string = "ok"
+	StatusFailed string = "failed"
+)
+
+// Status is the outcome of the command on a network interface.
+type Status struct {
+	// Interface is the network interface name.
+	Interface string
+
+	// Err is the failure of the command, nil on success.
+	Err error
+}
+
+// Function returns the names of the network interfaces of the system, the
+// links a group is matched against besides the WireGuard devices.
+func Links() ([]string, error) {
+	var ifaces []net.Interface
+	err := netns.Do(func() (err error) {
+		ifaces, err = net.Interfaces()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error: failed to list network interfaces: %v", err)
+	}
+
+	names := make([]string, 0, len(ifaces))
+	for _, iface := range ifaces {
+		names = append(names, iface.Name)
+	}
+	return names, nil
+}
+
+// Function reports whether the network interface name is a glob pattern
+// (e.g., "wg-cust*").
+func IsPattern(name string) bool {
+	return strings.ContainsAny(name, patternChars)
+}
+
+// Function returns the glob pattern of a group: the value of `-i` as is,
+// or the prefix of `-g` followed by "*".
+//
+// Usage example:
+//
+//	pattern, err := group.Pattern(help.GroupFlag, "wg-cust") // "wg-cust*"
+func Pattern(flag, value string) (string, error) {
+	if flag == help.GroupFlag {
+		if value == "" || strings.HasPrefix(value, "-") || IsPattern(value) {
+			return "", help.Errorf(help.CodeInvalidValue, "error: invalid network interface prefix '%s'", value)
+		}
+		return value + "*", nil
+	}
+
+	if value == "" || strings.ContainsRune(value, '/') {
+		return "", help.Errorf(help.CodeInvalidValue, "error: invalid network interface pattern '%s'", value)
+	}
+	if _, err := path.Match(value, ""); err != nil {
+		return "", help.Errorf(help.CodeInvalidValue, "error: invalid network interface pattern '%s': %v", value, err)
+	}
+	return value, nil
+}
+
+// Function returns the WireGuard devices matching the pattern, sorted, and
+// the other network interfaces (links) matching it, which are ignored.
+// A pattern matching no WireGuard device is an error.
+//
+// Usage example:
+//
+//	matched, ignored, err := group.Expand("wg-cust*", devices, links)
+func Expand(pattern string, devices, links []string) ([]string, []string, error) {
+	var matched, ignored []string
+	for _, name := range devices {
+		if ok, _ := path.Match(pattern, name); ok && !slices.Contains(matched, name) {
+			matched = append(matched, name)
+		}
+	}
+	for _, name := range links {
+		if ok, _ := path.Match(pattern, name); ok && !slices.Contains(devices, name) {
+			ignored = append(ignored, name)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, ignored, help.Errorf(help.CodeInterfaceNotFound, "error: no WireGuard network interface matches '%s'", pattern)
+	}
+	slices.Sort(matched)
+	slices.Sort(ignored)
+	return matched, ignored, nil
+}
+
+// Function removes `-parallel N` from the arguments and returns the
+// remaining ones and N, 1 without the flag.
+func ParseParallel(args []string) ([]string, int, error) {
+	parallel := 0
+	rest := make([]string, 0, len(args))
+	for indx := 0; indx < len(args); indx++ {
+		if args[indx] != help.ParallelFlag {
+			rest = append(rest, args[indx])
+			continue
+		}
+		if parallel != 0 {
+			return nil, 0, help.Errorf(help.CodeInvalidArguments, "error: -parallel is given twice")
+		}
+		if indx+1 >= len(args) {
+			return nil, 0, help.Errorf(help.CodeInvalidArguments, "error: -parallel requires the number of interfaces")
+		}
+		indx++
+		value, err := strconv.Atoi(args[indx])
+		if err != nil || value < 1 {
+			return nil, 0, help.Errorf(help.CodeInvalidValue, "error: invalid -parallel value '%s', a positive number is expected", args[indx])
+		}
+		parallel = value
+	}
+
+	if parallel == 0 {
+		parallel = 1
+	}
+	return rest, parallel, nil
+}
+
+// Function runs fn on each network interface, at most parallel at a time,
+// and returns their statuses in the order of the interfaces. A failure
+// does not stop the others. fn is given the index of the interface.
+func Run(ifaces []string, parallel int, fn func(indx int) error) []Status {
+	statuses := make([]Status, len(ifaces))
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for indx, iface := range ifaces {
+		statuses[indx].Interface = iface
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			statuses[indx].Err = fn(indx)
+		}()
+	}
+	wg.Wait()
+
+	return statuses
+}
+
+// Function writes the table of the statuses, followed by a summary line,
+// and returns the number of failed interfaces. The detail of a failure is
+// the first line of its error.
+func WriteTable(w io.Writer, statuses []Status) int {
+	width := len("INTERFACE")
+	for _, s := range statuses {
+		width = max(width, len(s.Interface))
+	}
+
+	failures := 0
+	fmt.Fprintf(w, "%-*s  %-6s  %s\n", width, "INTERFACE", "STATUS", "DETAIL")
+	for _, s := range statuses {
+		if s.Err == nil {
+			fmt.Fprintf(w, "%-*s  %s\n", width, s.Interface, StatusOk)
+			continue
+		}
+		failures++
+		detail, _, _ := strings.Cut(s.Err.Error(), "\n")
+		fmt.Fprintf(w, "%-*s  %-6s  %s\n", width, s.Interface, StatusFailed, detail)
+	}
+
+	if failures == 0 {
+		fmt.Fprintf(w, "%d network interfaces, all succeeded\n", len(statuses))
+	} else {
+		fmt.Fprintf(w, "%d network interfaces, %d failed\n", len(statuses), failures)
+	}
+	return failures
+}
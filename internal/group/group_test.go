@@ -0,0 +1,158 @@
+package group
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+)
+
+// Testing the glob pattern of `-i` and `-g`.
+func TestPattern(t *testing.T) {
+	type testCase struct {
+		name      string
+		flag      string
+		value     string
+		want      string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "glob", flag: help.WgInterfaceFlag, value: "wg-cust*", want: "wg-cust*"},
+		{name: "class", flag: help.WgInterfaceFlag, value: "wg[0-3]", want: "wg[0-3]"},
+		{name: "prefix", flag: help.GroupFlag, value: "wg-cust", want: "wg-cust*"},
+		{name: "bad_glob", flag: help.WgInterfaceFlag, value: "wg[", wantError: true},
+		{name: "slash", flag: help.WgInterfaceFlag, value: "wg/*", wantError: true},
+		{name: "empty_prefix", flag: help.GroupFlag, value: "", wantError: true},
+		{name: "glob_prefix", flag: help.GroupFlag, value: "wg*", wantError: true},
+		{name: "flag_prefix", flag: help.GroupFlag, value: "-u", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Pattern(tc.flag, tc.value)
+			if (err != nil) != tc.wantError {
+				t.Fatalf("error: got error %v, want error %v", err, tc.wantError)
+			}
+			if got != tc.want {
+				t.Errorf("error: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing that the expansion keeps the WireGuard devices only.
+func TestExpand(t *testing.T) {
+	devices := []string{"wg-cust2", "wg0", "wg-cust10", "awg-cust1"}
+	links := []string{"lo", "eth0", "wg-cust2", "wg-cust10", "wg-custbr", "wg0", "awg-cust1"}
+
+	matched, ignored, err := Expand("wg-cust*", devices, links)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if want := []string{"wg-cust10", "wg-cust2"}; !reflect.DeepEqual(matched, want) {
+		t.Errorf("error: got matched %q, want %q", matched, want)
+	}
+	if want := []string{"wg-custbr"}; !reflect.DeepEqual(ignored, want) {
+		t.Errorf("error: got ignored %q, want %q", ignored, want)
+	}
+
+	if _, ignored, err := Expand("eth*", devices, links); err == nil || len(ignored) != 1 {
+		t.Errorf("error: got %v with ignored %q, want an error naming eth0", err, ignored)
+	}
+}
+
+// Testing the removal of `-parallel N` from the arguments.
+func TestParseParallel(t *testing.T) {
+	type testCase struct {
+		name      string
+		args      []string
+		wantArgs  []string
+		want      int
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "default", args: []string{"-u", "-pk", "-rotate"}, wantArgs: []string{"-u", "-pk", "-rotate"}, want: 1},
+		{name: "last", args: []string{"-u", "-pk", "-rotate", "-parallel", "4"}, wantArgs: []string{"-u", "-pk", "-rotate"}, want: 4},
+		{name: "first", args: []string{"-parallel", "2", "-dw"}, wantArgs: []string{"-dw"}, want: 2},
+		{name: "missing", args: []string{"-dw", "-parallel"}, wantError: true},
+		{name: "zero", args: []string{"-dw", "-parallel", "0"}, wantError: true},
+		{name: "not_number", args: []string{"-dw", "-parallel", "all"}, wantError: true},
+		{name: "twice", args: []string{"-parallel", "2", "-dw", "-parallel", "3"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			args, got, err := ParseParallel(tc.args)
+			if (err != nil) != tc.wantError {
+				t.Fatalf("error: got error %v, want error %v", err, tc.wantError)
+			}
+			if err != nil {
+				return
+			}
+			if got != tc.want || !reflect.DeepEqual(args, tc.wantArgs) {
+				t.Errorf("error: got (%q, %d), want (%q, %d)", args, got, tc.wantArgs, tc.want)
+			}
+		})
+	}
+}
+
+// Testing that Run continues past the failures, keeps the order of the
+// interfaces and bounds the concurrency.
+func TestRun(t *testing.T) {
+	ifaces := []string{"wg-cust1", "wg-cust2", "wg-cust3", "wg-cust4", "wg-cust5"}
+
+	var running, peak atomic.Int32
+	statuses := Run(ifaces, 2, func(indx int) error {
+		current := running.Add(1)
+		defer running.Add(-1)
+		for {
+			seen := peak.Load()
+			if current <= seen || peak.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		if indx == 1 {
+			return errors.New("error: device unavailable")
+		}
+		return nil
+	})
+
+	if peak.Load() > 2 {
+		t.Errorf("error: got %d concurrent runs, want at most 2", peak.Load())
+	}
+	for indx, s := range statuses {
+		if s.Interface != ifaces[indx] {
+			t.Errorf("error: got interface %q at %d, want %q", s.Interface, indx, ifaces[indx])
+		}
+		if (s.Err != nil) != (indx == 1) {
+			t.Errorf("error: got %v for %s", s.Err, s.Interface)
+		}
+	}
+}
+
+// Testing the table of the statuses.
+func TestWriteTable(t *testing.T) {
+	var out bytes.Buffer
+	failures := WriteTable(&out, []Status{
+		{Interface: "wg-cust1"},
+		{Interface: "wg-cust10", Err: errors.New("error: device unavailable\ndetails")},
+	})
+
+	want := "INTERFACE  STATUS  DETAIL\n" +
+		"wg-cust1   ok\n" +
+		"wg-cust10  failed  error: device unavailable\n" +
+		"2 network interfaces, 1 failed\n"
+	if out.String() != want {
+		t.Errorf("error: got\n%s\nwant\n%s", out.String(), want)
+	}
+	if failures != 1 {
+		t.Errorf("error: got %d failures, want 1", failures)
+	}
+}
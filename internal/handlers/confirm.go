@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Function asks the question on w and reads a "y/N" answer from r (e.g.,
+// handlers.Stdin). Only "y" and "yes" confirm; an empty answer or the end
+// of the input declines. A stdin which is not a terminal (a script, a
+// pipe) is not asked and confirms, so a script never waits for an answer.
+//
+// Usage example:
+//
+//	ok, err := handlers.Confirm(handlers.Stdin, os.Stderr, "delete network interface 'wg0'?")
+//	if err != nil || !ok {
+//	    // Handle error
+//	}
+func Confirm(r io.Reader, w io.Writer, question string) (bool, error) {
+	if file, ok := r.(*os.File); ok && !isTerminal(file) {
+		return true, nil
+	}
+
+	fmt.Fprintf(w, "%s [y/N] ", question)
+
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, errors.New("error: failed to read the confirmation")
+	}
+	if errors.Is(err, io.EOF) {
+		// The answer line was not ended by the user.
+		fmt.Fprintln(w)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Testing the Confirm function answers, only "y" and "yes" confirm.
+func TestConfirm(t *testing.T) {
+	type testCase struct {
+		answer string
+		want   bool
+	}
+
+	tests := []testCase{
+		{answer: "y\n", want: true},
+		{answer: "Y\n", want: true},
+		{answer: " yes \n", want: true},
+		{answer: "YES", want: true},
+		{answer: "\n"},
+		{answer: "n\n"},
+		{answer: "no\n"},
+		{answer: "yep\n"},
+		{answer: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.answer, func(t *testing.T) {
+			var prompt strings.Builder
+			got, err := Confirm(strings.NewReader(tc.answer), &prompt, "delete network interface 'wg0'?")
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got %v, want %v", got, tc.want)
+			}
+			if !strings.HasPrefix(prompt.String(), "delete network interface 'wg0'? [y/N] ") {
+				t.Errorf("error: got prompt %q", prompt.String())
+			}
+		})
+	}
+}
+
+// Testing that a stdin which is not a terminal confirms without a prompt.
+func TestConfirmNotTerminal(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var prompt strings.Builder
+	got, err := Confirm(file, &prompt, "delete network interface 'wg0'?")
+	if err != nil || !got {
+		t.Fatalf("error: got (%v, %v), want confirmed", got, err)
+	}
+	if prompt.Len() != 0 {
+		t.Errorf("error: got prompt %q, want none", prompt.String())
+	}
+}
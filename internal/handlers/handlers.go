@@ -2,84 +2,78 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
-	"net"
-	"strconv"
-	"strings"
 
 	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
-// Function for initializing the wgctrl client.
-func InitWgCtlClient() (*wgctrl.Client, error) {
+// WgClient is the subset of the wgctrl client API used by this module.
+// It is satisfied by *wgctrl.Client and allows the wgctrl layer to be
+// replaced by a mock in tests.
+type WgClient interface {
+	Devices() ([]*wgtypes.Device, error)
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+	Close() error
+}
+
+// NewWgClient opens the client returned by InitWgCtlClient.
+// Tests replace it to serve devices from memory (see internal/wgmock).
+var NewWgClient = func() (WgClient, error) {
 	client, err := wgctrl.New()
 	if err != nil {
-		return nil, fmt.Errorf("error: invalid configuration: %v", err)
+		return nil, err
 	}
-
 	return client, nil
 }
 
-// Function converts a port string to an integer.
-// It returns an error if the string is not a valid number.
-func CheckPort(port string) (int, error) {
-
-	portInt, err := strconv.Atoi(port)
+// Function for initializing the wgctrl client.
+func InitWgCtlClient() (WgClient, error) {
+	client, err := NewWgClient()
 	if err != nil {
-		return 0, fmt.Errorf(
-			"error: invalid port value, port must be a valid number, %w",
-			err,
-		)
+		return nil, fmt.Errorf("error: invalid configuration: %v", err)
 	}
 
-	return portInt, nil
+	return client, nil
 }
 
-// Function to check the endpoint IP address.
-func CheckEndPoint(host string) (*net.UDPAddr, error) {
-	data := strings.Split(host, ":")
-
-	if len(data) != 2 {
-		return nil, fmt.Errorf(
-			"error: invalid endpoint format '%s', expected format: "+
-				"`IP-address:port` (e.g., `89.89.89.1:51820`",
-			host,
-		)
+// Function validates a private key (base64 encoded) and returns it parsed.
+// The error names the exact problem and never contains the key itself.
+// The decoding buffer is scrubbed before returning.
+func CheckPrivateKey(value Secret) (wgtypes.Key, error) {
+	encoded := bytes.TrimSpace(value.Bytes())
+	if len(encoded) == 0 {
+		return wgtypes.Key{}, fmt.Errorf("error: invalid private key, the key is empty")
 	}
 
-	port, err := CheckPort(data[1])
+	raw := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	defer clear(raw)
+
+	n, err := base64.StdEncoding.Decode(raw, encoded)
 	if err != nil {
-		return nil, err
+		return wgtypes.Key{}, fmt.Errorf(
+			"error: invalid private key, not valid base64 "+
+				"(expected %d characters ending with '=')",
+			base64.StdEncoding.EncodedLen(wgtypes.KeyLen),
+		)
 	}
 
-	ip := net.ParseIP(data[0])
-	if ip == nil {
-		return nil, fmt.Errorf(
-			"error: invalid IPv4 address: '%s' "+
-				"example: `192.168.1.1`", data[0])
+	if n != wgtypes.KeyLen {
+		return wgtypes.Key{}, fmt.Errorf(
+			"error: invalid private key, decodes to %d bytes, expected %d",
+			n, wgtypes.KeyLen,
+		)
 	}
 
-	return &net.UDPAddr{
-		IP:   ip,
-		Port: port,
-	}, nil
-}
-
-// Function to check allowed IP addresses.
-func CheckAllowedIPs(ipAddr []string) ([]net.IPNet, error) {
-	allowIps := make([]net.IPNet, 0, len(ipAddr))
+	var key wgtypes.Key
+	copy(key[:], raw[:n])
 
-	for _, ips := range ipAddr {
-		_, ipnet, err := net.ParseCIDR(ips)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"error: invalid CIDR format for allowed IP address '%s' "+
-					"example: 10.10.10.1/32",
-				ips,
-			)
-		}
-		allowIps = append(allowIps, *ipnet)
+	if key == (wgtypes.Key{}) {
+		return wgtypes.Key{}, fmt.Errorf("error: invalid private key, the key is all zeros")
 	}
 
-	return allowIps, nil
+	return key, nil
 }
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Function reads the passphrase of a backup file: the content of keyFile
+// when one is given (the trailing newline removed), otherwise a line typed
+// on the terminal r without echo, asked twice when confirm is set (on
+// backup, so a typo does not lock the file). A stdin which is not a
+// terminal is refused without keyFile. The passphrase is never echoed nor
+// part of the returned error.
+//
+// Usage example:
+//
+//	passphrase, err := handlers.ReadPassphrase("", handlers.Stdin, os.Stderr, true)
+//	if err != nil {
+//	    // Handle error
+//	}
+//	defer passphrase.Zero()
+func ReadPassphrase(keyFile string, r io.Reader, w io.Writer, confirm bool) (Secret, error) {
+	if keyFile != "" {
+		return readPassphraseFile(keyFile)
+	}
+
+	prompts := []string{"Passphrase: "}
+	if confirm {
+		prompts = append(prompts, "Repeat the passphrase: ")
+	}
+
+	reader := bufio.NewReader(r)
+	var lines [][]byte
+	defer func() {
+		for _, line := range lines {
+			clear(line)
+		}
+	}()
+
+	for _, prompt := range prompts {
+		fmt.Fprint(w, prompt)
+		line, err := readSecretLine(r, reader)
+		fmt.Fprintln(w)
+		if err != nil {
+			return Secret{}, err
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines[0]) == 0 {
+		return Secret{}, errors.New("error: the passphrase is empty")
+	}
+	if confirm && !bytes.Equal(lines[0], lines[1]) {
+		return Secret{}, errors.New("error: the passphrases do not match")
+	}
+	return NewSecretBytes(lines[0]), nil
+}
+
+// Function reads the passphrase from a file, see ReadPassphrase.
+func readPassphraseFile(path string) (Secret, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Secret{}, fmt.Errorf("error: failed to read key file '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, MaxInputSize+1))
+	defer clear(data)
+	if err != nil {
+		return Secret{}, fmt.Errorf("error: failed to read key file '%s'", path)
+	}
+	if len(data) > MaxInputSize {
+		return Secret{}, fmt.Errorf("error: key file '%s' exceeds %d bytes", path, MaxInputSize)
+	}
+
+	passphrase := bytes.TrimRight(data, "\r\n")
+	if len(passphrase) == 0 {
+		return Secret{}, fmt.Errorf("error: key file '%s' is empty", path)
+	}
+	return NewSecretBytes(passphrase), nil
+}
+
+// Function reads a line of r, with the echo of the terminal turned off
+// when r is one (see noEcho). Any other file is refused.
+func readSecretLine(r io.Reader, reader *bufio.Reader) ([]byte, error) {
+	if file, ok := r.(*os.File); ok {
+		if !isTerminal(file) {
+			return nil, errors.New("error: stdin is not a terminal, give the passphrase with -key-file")
+		}
+		restore, err := noEcho(file)
+		if err != nil {
+			return nil, err
+		}
+		defer restore()
+	}
+
+	line, err := reader.ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		clear(line)
+		return nil, errors.New("error: failed to read the passphrase")
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Testing the ReadPassphrase function with a prompt and a key file.
+func TestReadPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte("correct horse battery\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(dir, "empty")
+	if err := os.WriteFile(emptyFile, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		name      string
+		keyFile   string
+		input     string
+		confirm   bool
+		want      string
+		wantError string
+	}
+
+	tests := []testCase{
+		{name: "prompt", input: "correct horse battery\n", want: "correct horse battery"},
+		{name: "prompt without newline", input: "secret", want: "secret"},
+		{name: "prompt confirmed", input: "secret\nsecret\n", confirm: true, want: "secret"},
+		{name: "prompt mismatch", input: "secret\nsecrets\n", confirm: true, wantError: "do not match"},
+		{name: "prompt empty", input: "\n", wantError: "passphrase is empty"},
+		{name: "key file", keyFile: keyFile, input: "ignored\n", confirm: true, want: "correct horse battery"},
+		{name: "key file empty", keyFile: emptyFile, wantError: "is empty"},
+		{name: "key file missing", keyFile: filepath.Join(dir, "missing"), wantError: "failed to read key file"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var prompt bytes.Buffer
+			secret, err := ReadPassphrase(tc.keyFile, strings.NewReader(tc.input), &prompt, tc.confirm)
+
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got error %v, want %q", err, tc.wantError)
+				}
+				if strings.Contains(err.Error(), "secret") {
+					t.Errorf("error: the passphrase leaked into the error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if secret.Reveal() != tc.want {
+				t.Errorf("error: got passphrase %q, want %q", secret.Reveal(), tc.want)
+			}
+			if strings.Contains(prompt.String(), tc.want) {
+				t.Errorf("error: the passphrase was echoed: %q", prompt.String())
+			}
+		})
+	}
+}
+
+// Testing that ReadPassphrase refuses a stdin which is not a terminal.
+func TestReadPassphraseNotTerminal(t *testing.T) {
+	file, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err := ReadPassphrase("", file, &bytes.Buffer{}, false); err == nil || !strings.Contains(err.Error(), "-key-file") {
+		t.Errorf("error: got error %v, want a refusal", err)
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/privs"
+)
+
+// Privileged reports whether the process may read and change the network
+// configuration: it holds CAP_NET_ADMIN in its effective set, whatever its
+// uid (see internal/privs). Tests replace it to simulate an unprivileged
+// process.
+var Privileged = func() bool {
+	set, err := privs.Effective()
+	return err == nil && set.Has(privs.NetAdmin)
+}
+
+// ErrUnprivileged is returned by CheckPrivileged when the process lacks
+// CAP_NET_ADMIN.
+var ErrUnprivileged = errors.New("error: this operation requires CAP_NET_ADMIN, which the process lacks (run as root or grant the capability)")
+
+// Function returns ErrUnprivileged for an operation run without
+// privileges, nil when the process is privileged, see Privileged.
+//
+// Usage example:
+//
+//	if err := handlers.CheckPrivileged(); err != nil {
+//	    // Fail before changing anything
+//	}
+func CheckPrivileged() error {
+	if Privileged() {
+		return nil
+	}
+	return ErrUnprivileged
+}
+
+// Function reports whether the error is a permission failure: EPERM or
+// EACCES from a system call, also when only its message was kept (e.g.,
+// the wgctrl errors), or a command reporting it (e.g., iptables "Permission
+// denied (you must be root)").
+func IsPermission(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return true
+	}
+
+	message := strings.ToLower(err.Error())
+	for _, reason := range []string{"permission denied", "operation not permitted", "you must be root"} {
+		if strings.Contains(message, reason) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// Testing the detection of the permission failures.
+func TestIsPermission(t *testing.T) {
+	type testCase struct {
+		name string
+		err  error
+		want bool
+	}
+
+	tests := []testCase{
+		{name: "nil", err: nil, want: false},
+		{name: "eperm", err: fmt.Errorf("error: %w", syscall.EPERM), want: true},
+		{name: "eacces", err: &os.PathError{Op: "open", Path: "/proc/1/environ", Err: syscall.EACCES}, want: true},
+		{name: "message only", err: fmt.Errorf("error: invalid configuration: %v", syscall.EPERM), want: true},
+		{name: "iptables", err: errors.New("runtime error: iptables v1.8.7 (nf_tables): Could not fetch rule set generation id: Permission denied (you must be root), exit status 4"), want: true},
+		{name: "other", err: errors.New("error: network interface 'wg0' not found"), want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := IsPermission(tc.err); got != tc.want {
+				t.Errorf("error: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the error of an unprivileged operation.
+func TestCheckPrivileged(t *testing.T) {
+	prev := Privileged
+	t.Cleanup(func() { Privileged = prev })
+
+	Privileged = func() bool { return false }
+	if err := CheckPrivileged(); err == nil || !strings.Contains(err.Error(), "requires CAP_NET_ADMIN") {
+		t.Errorf("error: got %v, want the requires root error", err)
+	}
+
+	Privileged = func() bool { return true }
+	if err := CheckPrivileged(); err != nil {
+		t.Errorf("error: unexpected error: %v", err)
+	}
+}
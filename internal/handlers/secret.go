@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Placeholder printed instead of key material.
+const Redacted string = "[redacted]"
+
+// Secret holds key material (e.g., a base64 encoded private or preshared key).
+//
+// Every formatting path (fmt verbs, JSON, slog) prints Redacted, so a Secret
+// can be passed around and wrapped in errors without leaking. The content is
+// only available through the explicit Reveal and Bytes methods, and Zero
+// scrubs the buffer once it is no longer needed.
+type Secret struct {
+	value []byte
+}
+
+// Function creates a Secret holding a copy of the string.
+func NewSecret(value string) Secret {
+	return Secret{value: []byte(value)}
+}
+
+// Function creates a Secret holding a copy of the bytes.
+func NewSecretBytes(value []byte) Secret {
+	return Secret{value: bytes.Clone(value)}
+}
+
+// Function creates a Secret holding the key in base64 encoding.
+func NewSecretKey(key wgtypes.Key) Secret {
+	value := make([]byte, base64.StdEncoding.EncodedLen(wgtypes.KeyLen))
+	base64.StdEncoding.Encode(value, key[:])
+	return Secret{value: value}
+}
+
+// Method returns the content as a string. Strings cannot be scrubbed,
+// use Bytes where possible.
+func (s Secret) Reveal() string {
+	return string(s.value)
+}
+
+// Method returns the underlying buffer. It is scrubbed by Zero.
+func (s Secret) Bytes() []byte {
+	return s.value
+}
+
+// Method reports whether the Secret holds no content.
+func (s Secret) IsEmpty() bool {
+	return len(bytes.TrimSpace(s.value)) == 0
+}
+
+// Method overwrites the content with zeros.
+func (s Secret) Zero() {
+	clear(s.value)
+}
+
+// Method implements fmt.Stringer.
+func (s Secret) String() string {
+	return Redacted
+}
+
+// Method implements fmt.GoStringer.
+func (s Secret) GoString() string {
+	return Redacted
+}
+
+// Method implements fmt.Formatter, so no verb (%x, %q, ...) reveals the content.
+func (s Secret) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, Redacted)
+}
+
+// Method implements encoding.TextMarshaler.
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(Redacted), nil
+}
+
+// Method implements slog.LogValuer.
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(Redacted)
+}
+
+// Key material following a key keyword, as found in awg/wg command lines
+// (`private-key <(echo 'KEY')`, `preshared-key KEY`) and the UAPI
+// protocol (`private_key=HEX`).
+var keyPattern = regexp.MustCompile(
+	`(?i)((?:private|preshared)[-_ ]?key['"]?\s*[:=]?\s*(?:<\(\s*echo\s+)?['"]?)` +
+		`([0-9a-f]{64}|[A-Za-z0-9+/]{42,43}={0,2})`,
+)
+
+// Function replaces key material in a message (command line, error or log
+// line) with Redacted. Keys following a private/preshared key keyword are
+// always removed, the given secrets are removed wherever they appear.
+func Redact(msg string, secrets ...Secret) string {
+	msg = keyPattern.ReplaceAllString(msg, "${1}"+Redacted)
+
+	for _, secret := range secrets {
+		if value := strings.TrimSpace(string(secret.value)); value != "" {
+			msg = strings.ReplaceAll(msg, value, Redacted)
+		}
+	}
+	return msg
+}
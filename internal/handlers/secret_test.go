@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Function generates a private key for the tests.
+func newKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("error: failed to generate key: %v", err)
+	}
+	return key
+}
+
+// Testing that no formatting path reveals the Secret content.
+func TestSecretFormatting(t *testing.T) {
+	key := newKey(t)
+	secret := NewSecretKey(key)
+
+	jsonData, _ := json.Marshal(struct{ Key Secret }{secret})
+
+	var logged bytes.Buffer
+	slog.New(slog.NewTextHandler(&logged, nil)).Info("key", "value", secret)
+
+	outputs := map[string]string{
+		"%v":   fmt.Sprintf("%v", secret),
+		"%s":   fmt.Sprintf("%s", secret),
+		"%q":   fmt.Sprintf("%q", secret),
+		"%x":   fmt.Sprintf("%x", secret),
+		"%#v":  fmt.Sprintf("%#v", secret),
+		"%+v":  fmt.Sprintf("%+v", struct{ Key Secret }{secret}),
+		"json": string(jsonData),
+		"slog": logged.String(),
+	}
+
+	for name, output := range outputs {
+		t.Run(name, func(t *testing.T) {
+			if strings.Contains(output, key.String()) || strings.Contains(output, fmt.Sprintf("%x", key.String())) {
+				t.Errorf("error: key material leaked: %s", output)
+			}
+			if !strings.Contains(output, Redacted) {
+				t.Errorf("error: got %q, want %s", output, Redacted)
+			}
+		})
+	}
+
+	if secret.Reveal() != key.String() {
+		t.Error("error: Reveal does not return the content")
+	}
+
+	secret.Zero()
+	if !bytes.Equal(secret.Bytes(), make([]byte, len(secret.Bytes()))) {
+		t.Error("error: Zero did not scrub the buffer")
+	}
+}
+
+// Testing the Redact function.
+func TestRedact(t *testing.T) {
+	key := newKey(t)
+	b64 := key.String()
+	hexKey := hex.EncodeToString(key[:])
+
+	type testCase struct {
+		name    string
+		msg     string
+		secrets []Secret
+	}
+
+	tests := []testCase{
+		{name: "awg private key", msg: fmt.Sprintf("runtime error: [awg set wg0 private-key <(echo '%s')], exit status 1", b64)},
+		{name: "wg preshared key", msg: fmt.Sprintf("wg set wg0 peer X preshared-key %s", b64)},
+		{name: "uapi", msg: fmt.Sprintf("private_key=%s\nlisten_port=51820", hexKey)},
+		{name: "json", msg: fmt.Sprintf(`{"private_key": "%s"}`, b64)},
+		{name: "secret anywhere", msg: fmt.Sprintf("failed on %s", b64), secrets: []Secret{NewSecret(b64)}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Redact(tc.msg, tc.secrets...)
+			if strings.Contains(got, b64) || strings.Contains(got, hexKey) {
+				t.Errorf("error: key material leaked: %s", got)
+			}
+			if !strings.Contains(got, Redacted) {
+				t.Errorf("error: got %q, want %s", got, Redacted)
+			}
+		})
+	}
+
+	// Public keys outside a key keyword are kept for diagnostics.
+	pub := key.PublicKey().String()
+	if got := Redact("awg set wg0 peer '" + pub + "' remove"); !strings.Contains(got, pub) {
+		t.Errorf("error: public key was redacted: %s", got)
+	}
+}
+
+// Testing that CheckPrivateKey errors never contain the key.
+func TestCheckPrivateKeyErrors(t *testing.T) {
+	for _, value := range []string{"", "not-base64-at-all!", "c2VjcmV0a2V5", strings.Repeat("A", 43) + "="} {
+		_, err := CheckPrivateKey(NewSecret(value))
+		if err == nil {
+			t.Fatalf("error: expected error for %q, got none", value)
+		}
+		if value != "" && strings.Contains(err.Error(), value) {
+			t.Errorf("error: key material leaked: %v", err)
+		}
+	}
+}
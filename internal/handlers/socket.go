@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/src/proc"
+)
+
+// Directories of the UAPI sockets of the userspace devices (wireguard-go,
+// amneziawg-go). Tests replace them with temporary runtime directories.
+var (
+	WgSocketDir  = "/var/run/wireguard"
+	AwgSocketDir = "/var/run/amneziawg"
+)
+
+// UAPISocket describes the UAPI socket file of a network interface.
+type UAPISocket struct {
+	// Interface is the network interface name.
+	Interface string
+
+	// Path is the socket file path.
+	Path string
+
+	// Type is the device type ("wg", "awg") of the socket directory.
+	Type string
+
+	// Pid is the userspace process tagged with the interface, 0 when none runs.
+	Pid int
+
+	// Alive reports whether the socket accepts connections.
+	Alive bool
+}
+
+// Function returns the UAPI socket path of the network interface in dir.
+func SocketPath(dir, iface string) string {
+	return filepath.Join(dir, iface+".sock")
+}
+
+// Function inspects the UAPI socket of the network interface in dir.
+// The calling process and its parent are not reported as the owner, so a
+// starting device can inspect its own socket, also when it is restarted by
+// its supervisor.
+func InspectSocket(dir, iface string) (UAPISocket, error) {
+	socket := UAPISocket{
+		Interface: iface,
+		Path:      SocketPath(dir, iface),
+		Type:      "wg",
+	}
+	if dir == AwgSocketDir {
+		socket.Type = "awg"
+	}
+
+	pid, _, err := proc.FindProcess(iface, os.Getpid(), os.Getppid())
+	if err != nil {
+		return socket, err
+	}
+	socket.Pid = pid
+
+	conn, err := net.DialTimeout("unix", socket.Path, time.Second)
+	if err == nil {
+		conn.Close()
+		socket.Alive = true
+	}
+
+	return socket, nil
+}
+
+// Function lists the UAPI sockets of both socket directories, sorted by
+// interface name. Missing directories are not an error.
+func ListSockets() ([]UAPISocket, error) {
+	var sockets []UAPISocket
+
+	for _, dir := range []string{WgSocketDir, AwgSocketDir} {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error: could not read directory %s: %w", dir, err)
+		}
+
+		for _, entry := range entries {
+			iface, ok := strings.CutSuffix(entry.Name(), ".sock")
+			if !ok {
+				continue
+			}
+			socket, err := InspectSocket(dir, iface)
+			if err != nil {
+				return nil, err
+			}
+			sockets = append(sockets, socket)
+		}
+	}
+
+	slices.SortStableFunc(sockets, func(a, b UAPISocket) int {
+		return strings.Compare(a.Interface, b.Interface)
+	})
+	return sockets, nil
+}
+
+// Function removes a stale UAPI socket of the network interface in dir,
+// left behind by a killed userspace process, and reports whether it did.
+//
+// The socket is stale when no process is tagged with the interface and
+// it does not accept connections. Otherwise an error naming the owning
+// process is returned, so a second device does not steal the interface.
+func RemoveStaleSocket(dir, iface string) (bool, error) {
+	path := SocketPath(dir, iface)
+	if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	socket, err := InspectSocket(dir, iface)
+	if err != nil {
+		return false, err
+	}
+
+	if socket.Pid != 0 {
+		return false, fmt.Errorf(
+			"error: network interface '%s' is already served by process %d (%s)",
+			iface, socket.Pid, path,
+		)
+	}
+	if socket.Alive {
+		return false, fmt.Errorf(
+			"error: UAPI socket '%s' is in use by a process outside brgnetuse",
+			path,
+		)
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("error: failed to remove stale UAPI socket '%s': %v", path, err)
+	}
+	return true, nil
+}
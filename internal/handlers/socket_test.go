@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/AlexKira/brgnetuse/src/proc"
+)
+
+// Function points the socket and proc directories at temporary runtime
+// directories for the test, tagging a process with each of the interfaces.
+func useSocketDirs(t *testing.T, tagged map[int]string) {
+	t.Helper()
+
+	prevWg, prevAwg, prevProc := WgSocketDir, AwgSocketDir, proc.ProcDir
+	WgSocketDir, AwgSocketDir, proc.ProcDir = t.TempDir(), t.TempDir(), t.TempDir()
+	t.Cleanup(func() { WgSocketDir, AwgSocketDir, proc.ProcDir = prevWg, prevAwg, prevProc })
+
+	for pid, iface := range tagged {
+		dir := filepath.Join(proc.ProcDir, strconv.Itoa(pid))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		env := proc.EnvFieldTag + "=" + iface + "\x00" + proc.EnvFieldType + "=wg\x00"
+		if err := os.WriteFile(filepath.Join(dir, "environ"), []byte(env), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Function creates a UAPI socket of the interface in dir. A live socket
+// keeps accepting connections, a stale one is left behind like by a
+// killed process.
+func createSocket(t *testing.T, dir, iface string, live bool) {
+	t.Helper()
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: SocketPath(dir, iface), Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live {
+		t.Cleanup(func() { listener.Close() })
+		return
+	}
+	listener.SetUnlinkOnClose(false)
+	listener.Close()
+}
+
+// Testing the RemoveStaleSocket function on stale, live and missing sockets.
+func TestRemoveStaleSocket(t *testing.T) {
+	type testCase struct {
+		name        string
+		iface       string
+		wantRemoved bool
+		wantError   string
+	}
+
+	tests := []testCase{
+		{name: "stale", iface: "wg0", wantRemoved: true},
+		{name: "missing", iface: "wg9"},
+		{name: "tagged process", iface: "wg1", wantError: "process 4242"},
+		{name: "untracked process", iface: "wg2", wantError: "outside brgnetuse"},
+	}
+
+	useSocketDirs(t, map[int]string{4242: "wg1"})
+	createSocket(t, WgSocketDir, "wg0", false)
+	createSocket(t, WgSocketDir, "wg1", true)
+	createSocket(t, WgSocketDir, "wg2", true)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			removed, err := RemoveStaleSocket(WgSocketDir, tc.iface)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got %v, want error containing %q", err, tc.wantError)
+				}
+				if _, err := os.Lstat(SocketPath(WgSocketDir, tc.iface)); err != nil {
+					t.Errorf("error: socket of a live process was removed: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if removed != tc.wantRemoved {
+				t.Errorf("error: got removed %v, want %v", removed, tc.wantRemoved)
+			}
+			if _, err := os.Lstat(SocketPath(WgSocketDir, tc.iface)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("error: socket still exists: %v", err)
+			}
+		})
+	}
+}
+
+// Testing the ListSockets function across both socket directories.
+func TestListSockets(t *testing.T) {
+	useSocketDirs(t, map[int]string{4242: "wg1", os.Getpid(): "awg0"})
+	createSocket(t, WgSocketDir, "wg1", true)
+	createSocket(t, WgSocketDir, "wg0", false)
+	createSocket(t, AwgSocketDir, "awg0", true)
+	if err := os.WriteFile(filepath.Join(WgSocketDir, "README"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sockets, err := ListSockets()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	want := []UAPISocket{
+		{Interface: "awg0", Path: SocketPath(AwgSocketDir, "awg0"), Type: "awg", Alive: true},
+		{Interface: "wg0", Path: SocketPath(WgSocketDir, "wg0"), Type: "wg"},
+		{Interface: "wg1", Path: SocketPath(WgSocketDir, "wg1"), Type: "wg", Pid: 4242, Alive: true},
+	}
+	if len(sockets) != len(want) {
+		t.Fatalf("error: got %+v, want %+v", sockets, want)
+	}
+	for i := range want {
+		if sockets[i] != want[i] {
+			t.Errorf("error: got %+v, want %+v", sockets[i], want[i])
+		}
+	}
+
+	WgSocketDir = filepath.Join(WgSocketDir, "missing")
+	AwgSocketDir = filepath.Join(AwgSocketDir, "missing")
+	if sockets, err := ListSockets(); err != nil || len(sockets) != 0 {
+		t.Errorf("error: got %v, %v for missing directories", sockets, err)
+	}
+}
+
+// Testing that InspectSocket reports only the owner of the same namespace.
+func TestInspectSocketNamespace(t *testing.T) {
+	useSocketDirs(t, map[int]string{4300: "wg0@prod"})
+	createSocket(t, WgSocketDir, "wg0", true)
+
+	prev := proc.Namespace
+	t.Cleanup(func() { proc.Namespace = prev })
+
+	for namespace, wantPid := range map[string]int{"": 0, "prod": 4300, "test": 0} {
+		proc.Namespace = namespace
+
+		socket, err := InspectSocket(WgSocketDir, "wg0")
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if socket.Pid != wantPid || !socket.Alive {
+			t.Errorf("error: namespace %q got pid %d, alive %v, want %d, true",
+				namespace, socket.Pid, socket.Alive, wantPid)
+		}
+	}
+}
+
+// Testing that InspectSocket skips the parent of the caller, e.g., the
+// supervisor starting its device process.
+func TestInspectSocketSkipsParent(t *testing.T) {
+	useSocketDirs(t, map[int]string{os.Getppid(): "wg1"})
+
+	socket, err := InspectSocket(WgSocketDir, "wg1")
+	if err != nil || socket.Pid != 0 {
+		t.Errorf("error: got pid %d, %v, want the parent skipped", socket.Pid, err)
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Stdin is read for the '-' file and key arguments, replaced in tests.
+var Stdin io.Reader = os.Stdin
+
+// Maximum size of the input read from stdin, in bytes.
+const MaxInputSize = 1 << 20
+
+// Prefix of the private key line printed by `brggetwg -pk`.
+const privateKeyLine = "private_key:"
+
+// Function reads the whole input given as '-' (e.g., `-reconcile -`).
+// A terminal is refused, so a mistyped '-' does not wait for input forever.
+// The content is never part of the returned error.
+//
+// Usage example:
+//
+//	data, err := handlers.ReadInput(handlers.Stdin)
+//	if err != nil {
+//	    // Handle error
+//	}
+func ReadInput(r io.Reader) ([]byte, error) {
+	if file, ok := r.(*os.File); ok && isTerminal(file) {
+		return nil, errors.New(
+			"error: stdin is a terminal, pipe the input or give a file instead of '-'",
+		)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, MaxInputSize+1))
+	if err != nil {
+		clear(data)
+		return nil, errors.New("error: failed to read stdin")
+	}
+	if len(data) > MaxInputSize {
+		clear(data)
+		return nil, fmt.Errorf("error: stdin exceeds %d bytes", MaxInputSize)
+	}
+	return data, nil
+}
+
+// Function reads a private key given as '-': the value of the
+// `private_key:` line (the output of `brggetwg -pk`) or the raw base64
+// key. The key is checked by CheckPrivateKey, which never echoes it.
+func ReadKey(r io.Reader) (Secret, error) {
+	data, err := ReadInput(r)
+	defer clear(data)
+	if err != nil {
+		return Secret{}, err
+	}
+	return NewSecretBytes(keyInput(data)), nil
+}
+
+// Function returns the value of the `private_key:` line of the input, or
+// the whole input when it has none.
+func keyInput(data []byte) []byte {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if value, ok := bytes.CutPrefix(line, []byte(privateKeyLine)); ok {
+			return bytes.TrimSpace(value)
+		}
+	}
+	return bytes.TrimSpace(data)
+}
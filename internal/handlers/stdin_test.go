@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Testing the ReadKey function with the raw key and the output of brggetwg -pk.
+func TestReadKey(t *testing.T) {
+	key := newKey(t)
+
+	type testCase struct {
+		name      string
+		stdin     string
+		wantError string
+	}
+
+	tests := []testCase{
+		{name: "raw", stdin: key.String()},
+		{name: "raw newline", stdin: key.String() + "\n"},
+		{name: "key file", stdin: fmt.Sprintf("\nprivate_key: %s\npublic_key: %s\n\n", key, key.PublicKey())},
+		{name: "empty", stdin: "", wantError: "the key is empty"},
+		{name: "invalid key line", stdin: "private_key: secret!key\n", wantError: "not valid base64"},
+		{name: "public key only", stdin: fmt.Sprintf("public_key: %s\n", key.PublicKey()), wantError: "not valid base64"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			secret, err := ReadKey(bytes.NewBufferString(tc.stdin))
+			if err == nil {
+				_, err = CheckPrivateKey(secret)
+			}
+
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got error %v, want %q", err, tc.wantError)
+				}
+				for _, line := range strings.Split(tc.stdin, "\n") {
+					if value := strings.TrimSpace(strings.TrimPrefix(line, "private_key:")); value != "" && strings.Contains(err.Error(), value) {
+						t.Errorf("error: stdin leaked into the error: %v", err)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if secret.Reveal() != key.String() {
+				t.Errorf("error: got a different key")
+			}
+		})
+	}
+}
+
+// Testing the ReadInput function limits and non-terminal files.
+func TestReadInput(t *testing.T) {
+	data, err := ReadInput(bytes.NewReader(make([]byte, MaxInputSize)))
+	if err != nil || len(data) != MaxInputSize {
+		t.Errorf("error: got %d bytes, %v, want %d bytes", len(data), err, MaxInputSize)
+	}
+
+	if _, err := ReadInput(bytes.NewReader(make([]byte, MaxInputSize+1))); err == nil {
+		t.Error("error: expected error for oversized input, got none")
+	}
+
+	// A character device which is not a terminal is read.
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	defer null.Close()
+	if data, err := ReadInput(null); err != nil || len(data) != 0 {
+		t.Errorf("error: got %q, %v, want empty input", data, err)
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Function reports whether the file is a terminal. Character devices such
+// as /dev/null are not.
+func isTerminal(file *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(file.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// Function turns off the echo of the terminal and returns the function
+// which turns it on again.
+func noEcho(file *os.File) (func(), error) {
+	fd := int(file.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, errors.New("error: failed to read the terminal settings")
+	}
+
+	silent := *termios
+	silent.Lflag &^= unix.ECHO
+	silent.Lflag |= unix.ICANON | unix.ISIG
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &silent); err != nil {
+		return nil, errors.New("error: failed to turn off the terminal echo")
+	}
+	return func() { unix.IoctlSetTermios(fd, unix.TCSETS, termios) }, nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package handlers
+
+import (
+	"errors"
+	"os"
+)
+
+// Function reports whether the file is a terminal.
+// On this platform any character device counts as a terminal.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Function turns off the echo of the terminal.
+// Not supported on this platform, the passphrase is given with a key file.
+func noEcho(file *os.File) (func(), error) {
+	return nil, errors.New("error: the passphrase prompt is not supported on this platform, use -key-file")
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/src/proc"
+)
+
+// Default range of the listen ports assigned by FindFreeUDPPort (`-p auto`).
+const (
+	DefaultPortRangeStart int = 51820
+	DefaultPortRangeEnd   int = 51999
+)
+
+// Function reports whether a UDP socket, IPv4 or IPv6, is bound to the port.
+// The sockets are read from the net/udp and net/udp6 tables of
+// proc.ProcDir; a missing table (e.g., IPv6 disabled) is skipped.
+func UDPPortInUse(port int) (bool, error) {
+	ports, err := boundUDPPorts()
+	if err != nil {
+		return false, err
+	}
+	return ports[port], nil
+}
+
+// Function returns the lowest port of the range (both ends included) which
+// is neither the listen port of a WireGuard device known to wgctrl nor
+// bound by a UDP socket (see UDPPortInUse). The port is only free until
+// bound: callers hold the operation lock (see oplock) until the device
+// listens on it, so two invocations never choose the same port.
+//
+// Usage example:
+//
+//	port, err := handlers.FindFreeUDPPort(handlers.DefaultPortRangeStart, handlers.DefaultPortRangeEnd)
+//	if err != nil {
+//	    // Handle error
+//	}
+func FindFreeUDPPort(rangeStart, rangeEnd int) (int, error) {
+	if rangeStart < 1 || rangeEnd > 65535 || rangeStart > rangeEnd {
+		return 0, fmt.Errorf("error: invalid port range %d-%d", rangeStart, rangeEnd)
+	}
+
+	used, err := boundUDPPorts()
+	if err != nil {
+		return 0, err
+	}
+
+	client, err := InitWgCtlClient()
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	devices, err := client.Devices()
+	if err != nil {
+		return 0, fmt.Errorf("error: failed to list WireGuard interfaces: %v", err)
+	}
+	for _, device := range devices {
+		used[device.ListenPort] = true
+	}
+
+	return lowestFreePort(rangeStart, rangeEnd, used)
+}
+
+// Function returns the lowest port of the range missing from the used ones.
+func lowestFreePort(rangeStart, rangeEnd int, used map[int]bool) (int, error) {
+	for port := rangeStart; port <= rangeEnd; port++ {
+		if !used[port] {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("error: no free UDP port in range %d-%d", rangeStart, rangeEnd)
+}
+
+// Function parses a port range given as `start-end` (e.g., "51820-51999"),
+// the default range when empty.
+func ParsePortRange(value string) (int, int, error) {
+	if value == "" {
+		return DefaultPortRangeStart, DefaultPortRangeEnd, nil
+	}
+
+	first, last, ok := strings.Cut(value, "-")
+	start, errStart := strconv.Atoi(first)
+	end, errEnd := strconv.Atoi(last)
+	if !ok || errStart != nil || errEnd != nil || start < 1 || end > 65535 || start > end {
+		return 0, 0, fmt.Errorf(
+			"error: invalid port range '%s', expected start-end in range 1-65535 (e.g., '51820-51999')",
+			value,
+		)
+	}
+	return start, end, nil
+}
+
+// Function returns the ports bound by the UDP sockets of the net/udp and
+// net/udp6 tables of proc.ProcDir.
+func boundUDPPorts() (map[int]bool, error) {
+	ports := make(map[int]bool)
+	err := netns.Do(func() error {
+		for _, table := range []string{"udp", "udp6"} {
+			if err := udpTablePorts(netns.ProcPath(filepath.Join(proc.ProcDir, "net", table)), ports); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return ports, err
+}
+
+// Function scans a /proc/net/udp table, whose local addresses are
+// `ADDRESS:PORT` with the port in hexadecimal, and adds its ports.
+func udpTablePorts(path string, ports map[int]bool) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error: could not read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // Header line.
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		_, hexPort, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		local, err := strconv.ParseUint(hexPort, 16, 16)
+		if err == nil {
+			ports[int(local)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error: could not read %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/AlexKira/brgnetuse/src/proc"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Testing the UDPPortInUse function against synthetic /proc/net tables.
+func TestUDPPortInUse(t *testing.T) {
+	prev := proc.ProcDir
+	proc.ProcDir = t.TempDir()
+	t.Cleanup(func() { proc.ProcDir = prev })
+
+	tables := map[string]string{
+		"udp": "   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n" +
+			"  123: 00000000:CA6C 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 0 2 0000000000000000 0\n" +
+			"  456: 0100007F:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 21034 2 0000000000000000 0\n",
+		"udp6": "  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n" +
+			"  789: 00000000000000000000000000000000:CA6D 00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 0 2 0000000000000000 0\n",
+	}
+	if err := os.MkdirAll(filepath.Join(proc.ProcDir, "net"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range tables {
+		if err := os.WriteFile(filepath.Join(proc.ProcDir, "net", name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type testCase struct {
+		port int
+		want bool
+	}
+
+	tests := []testCase{
+		{port: 51820, want: true},
+		{port: 51821, want: true},
+		{port: 53, want: true},
+		{port: 51822, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(strconv.Itoa(tc.port), func(t *testing.T) {
+			got, err := UDPPortInUse(tc.port)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	// A missing table is skipped.
+	if err := os.Remove(filepath.Join(proc.ProcDir, "net", "udp6")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := UDPPortInUse(51821); err != nil || got {
+		t.Errorf("error: got %v, %v without the udp6 table, want false", got, err)
+	}
+}
+
+// fakeWgClient serves the devices of the tests from memory.
+type fakeWgClient struct {
+	devices []*wgtypes.Device
+}
+
+func (c fakeWgClient) Devices() ([]*wgtypes.Device, error) { return c.devices, nil }
+
+func (c fakeWgClient) Device(name string) (*wgtypes.Device, error) {
+	return nil, os.ErrNotExist
+}
+
+func (c fakeWgClient) ConfigureDevice(name string, cfg wgtypes.Config) error { return nil }
+
+func (c fakeWgClient) Close() error { return nil }
+
+// Testing the selection of the lowest free port against synthetic
+// occupancy: WireGuard devices and bound UDP sockets.
+func TestFindFreeUDPPort(t *testing.T) {
+	prevDir, prevClient := proc.ProcDir, NewWgClient
+	proc.ProcDir = t.TempDir()
+	t.Cleanup(func() { proc.ProcDir, NewWgClient = prevDir, prevClient })
+
+	// 51820 (CA6C) and 51822 (CA6E) are bound, 51821 listened on by a
+	// device which is down (no socket).
+	table := "   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n" +
+		"  123: 00000000:CA6C 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 0 2 0000000000000000 0\n" +
+		"  124: 00000000:CA6E 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 0 2 0000000000000000 0\n"
+	if err := os.MkdirAll(filepath.Join(proc.ProcDir, "net"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(proc.ProcDir, "net", "udp"), []byte(table), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	NewWgClient = func() (WgClient, error) {
+		return fakeWgClient{devices: []*wgtypes.Device{{Name: "wg0", ListenPort: 51821}}}, nil
+	}
+
+	type testCase struct {
+		name      string
+		start     int
+		end       int
+		want      int
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "default", start: DefaultPortRangeStart, end: DefaultPortRangeEnd, want: 51823},
+		{name: "free_start", start: 51823, end: 51830, want: 51823},
+		{name: "single", start: 51821, end: 51821, wantError: true},
+		{name: "full", start: 51820, end: 51822, wantError: true},
+		{name: "reversed", start: 51830, end: 51820, wantError: true},
+		{name: "out_of_range", start: 65530, end: 65536, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := FindFreeUDPPort(tc.start, tc.end)
+			if (err != nil) != tc.wantError {
+				t.Fatalf("error: got error %v, want error %v", err, tc.wantError)
+			}
+			if got != tc.want {
+				t.Errorf("error: got port %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the parsing of the port ranges.
+func TestParsePortRange(t *testing.T) {
+	type testCase struct {
+		value     string
+		wantStart int
+		wantEnd   int
+		wantError bool
+	}
+
+	tests := []testCase{
+		{value: "51820-51999", wantStart: 51820, wantEnd: 51999},
+		{value: "", wantStart: DefaultPortRangeStart, wantEnd: DefaultPortRangeEnd},
+		{value: "40000-40000", wantStart: 40000, wantEnd: 40000},
+		{value: "51999-51820", wantError: true},
+		{value: "0-10", wantError: true},
+		{value: "1-65536", wantError: true},
+		{value: "51820", wantError: true},
+		{value: "low-high", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			start, end, err := ParsePortRange(tc.value)
+			if (err != nil) != tc.wantError {
+				t.Fatalf("error: got error %v, want error %v", err, tc.wantError)
+			}
+			if start != tc.wantStart || end != tc.wantEnd {
+				t.Errorf("error: got %d-%d, want %d-%d", start, end, tc.wantStart, tc.wantEnd)
+			}
+		})
+	}
+}
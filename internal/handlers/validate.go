@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/src/validate"
+)
+
+// Validation helpers moved to the public package src/validate, kept here
+// for one release.
+
+// Deprecated: use validate.CheckPort.
+func CheckPort(port string) (int, error) {
+	return validate.CheckPort(port)
+}
+
+// Deprecated: use validate.MaxKeepalive.
+const MaxKeepalive = validate.MaxKeepalive
+
+// Deprecated: use validate.CheckKeepalive.
+func CheckKeepalive(value string) (time.Duration, error) {
+	return validate.CheckKeepalive(value)
+}
+
+// Deprecated: use validate.CheckEndPoint.
+func CheckEndPoint(host string) (*net.UDPAddr, error) {
+	return validate.CheckEndPoint(host)
+}
+
+// Deprecated: use validate.CheckAllowedIPs.
+func CheckAllowedIPs(ipAddr []string) ([]net.IPNet, error) {
+	return validate.CheckAllowedIPs(ipAddr)
+}
+
+// Deprecated: use validate.PrefixesOverlap.
+func PrefixesOverlap(a, b net.IPNet) bool {
+	return validate.PrefixesOverlap(a, b)
+}
+
+// Deprecated: use validate.CheckExpiry.
+func CheckExpiry(value string) (time.Time, error) {
+	return validate.CheckExpiry(value)
+}
+
+// Deprecated: use validate.CheckRate.
+func CheckRate(value string) (uint64, error) {
+	return validate.CheckRate(value)
+}
+
+// Deprecated: use validate.FormatRate.
+func FormatRate(bits uint64) string {
+	return validate.FormatRate(bits)
+}
+
+// Deprecated: use validate.CheckDNSServers.
+func CheckDNSServers(value string) ([]string, error) {
+	return validate.CheckDNSServers(value)
+}
+
+// Deprecated: use validate.InterfaceNameMaxLength.
+const InterfaceNameMaxLength = validate.InterfaceNameMaxLength
+
+// Deprecated: use validate.CheckInterfaceName.
+func CheckInterfaceName(name string) error {
+	return validate.CheckInterfaceName(name)
+}
+
+// Deprecated: use validate.AliasMaxLength.
+const AliasMaxLength = validate.AliasMaxLength
+
+// Deprecated: use validate.CheckAlias.
+func CheckAlias(value string) (string, error) {
+	return validate.CheckAlias(value)
+}
+
+// Deprecated: use the MTU range of the validate package.
+const (
+	MinMTU       = validate.MinMTU
+	MaxMTU       = validate.MaxMTU
+	MinForcedMTU = validate.MinForcedMTU
+	MaxForcedMTU = validate.MaxForcedMTU
+)
+
+// Deprecated: use validate.CheckMTU.
+func CheckMTU(mtu int, force bool) error {
+	return validate.CheckMTU(mtu, force)
+}
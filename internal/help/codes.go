@@ -0,0 +1,232 @@
+package help
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/src/get"
+)
+
+// Code is the stable identifier of a user-facing error (e.g., "BRG-E004"),
+// printed alongside the message. Scripts match the code, the wording of
+// the messages may change. A code is never reused for another error.
+type Code string
+
+// Codes of the user-facing errors. New codes are appended, with an entry in
+// Catalogue; the typed errors of the other packages return the same code
+// from their ErrorCode method (see codes_test.go).
+const (
+	// An error without a more specific code.
+	CodeUnknown Code = "BRG-E000"
+
+	// The arguments are missing, unknown or in the wrong order.
+	CodeInvalidArguments Code = "BRG-E001"
+
+	// A flag value is invalid: a name, a port, an address or a key.
+	CodeInvalidValue Code = "BRG-E002"
+
+	// The network interface does not exist (get.InterfaceNotFoundError).
+	CodeInterfaceNotFound Code = "BRG-E003"
+
+	// The network interface name is already taken.
+	CodeInterfaceExists Code = "BRG-E004"
+
+	// The process lacks CAP_NET_ADMIN, or a system call was denied.
+	CodePermission Code = "BRG-E005"
+
+	// A command (ip, iptables, awg) ran and failed (command.CommandError).
+	CodeCommandFailed Code = "BRG-E006"
+
+	// The device, read back, does not hold the configuration
+	// (set.VerificationError).
+	CodeVerification Code = "BRG-E007"
+
+	// iptables accepted a rule which is not listed afterwards.
+	CodeRuleNotListed Code = "BRG-E008"
+
+	// Another brgnetuse operation holds the lock (oplock.LockedError).
+	CodeLocked Code = "BRG-E009"
+
+	// The change is already in place and -strict was given.
+	CodeUnchanged Code = "BRG-E010"
+
+	// The rule would pass an address family (IPv4, IPv6) through a network
+	// interface without an address of that family.
+	CodeFamilyMismatch Code = "BRG-E011"
+
+	// The peer is not on the network interface (get.PeerNotFoundError,
+	// get.ErrNoPeerMatch).
+	CodePeerNotFound Code = "BRG-E012"
+
+	// The operation is not supported by the interface type (AmneziaWG) or
+	// by the platform (errors.ErrUnsupported).
+	CodeUnsupported Code = "BRG-E013"
+
+	// A port, an allowed IP, a file or a network interface is in use or
+	// held by another owner (get.ConflictError).
+	CodeConflict Code = "BRG-E014"
+
+	// The WireGuard device could not be read or configured
+	// (get.DeviceError).
+	CodeDeviceFailed Code = "BRG-E015"
+
+	// A file (key, backup, log, metadata) could not be read or written.
+	CodeFileFailed Code = "BRG-E016"
+
+	// The content of an input file (spec, state, backup, rules) is invalid.
+	CodeInvalidFile Code = "BRG-E017"
+
+	// A firewall rule, an address, a network namespace or a route is not
+	// found (netns.NotFoundError, get.ErrNotFound).
+	CodeNotFound Code = "BRG-E018"
+
+	// The confirmation prompt was answered no.
+	CodeCancelled Code = "BRG-E019"
+)
+
+// Codes of the warnings, conditions reported without failing the command
+// (set.Warning, see codes_test.go). New codes are appended.
+const (
+	// The endpoint of a peer is already used by another peer.
+	CodeWarnDuplicateEndpoint Code = "BRG-W001"
+
+	// An allowed IP of a peer is covered by a broader one of the same peer.
+	CodeWarnAllowedIPCovered Code = "BRG-W002"
+
+	// The listen port was set with -force while in use.
+	CodeWarnPortInUse Code = "BRG-W003"
+
+	// The firewall rule to add was already in place.
+	CodeWarnRuleExists Code = "BRG-W004"
+
+	// The private key changed while the interface has peers.
+	CodeWarnPeersKeepOldKey Code = "BRG-W005"
+
+	// The rule was added with -force through a network interface without
+	// an address of its family.
+	CodeWarnFamilyMismatch Code = "BRG-W006"
+)
+
+// CatalogueEntry documents a code.
+type CatalogueEntry struct {
+	Code    Code
+	Summary string
+}
+
+// Catalogue lists every code with its meaning, in order.
+var Catalogue = []CatalogueEntry{
+	{CodeUnknown, "unclassified error"},
+	{CodeInvalidArguments, "invalid arguments"},
+	{CodeInvalidValue, "invalid flag value"},
+	{CodeInterfaceNotFound, "network interface not found"},
+	{CodeInterfaceExists, "network interface already exists"},
+	{CodePermission, "insufficient privileges"},
+	{CodeCommandFailed, "external command failed"},
+	{CodeVerification, "configuration not applied by the device"},
+	{CodeRuleNotListed, "iptables rule not listed after being added"},
+	{CodeLocked, "another operation in progress"},
+	{CodeUnchanged, "change already in place (-strict)"},
+	{CodeFamilyMismatch, "address family not carried by the network interface"},
+	{CodePeerNotFound, "peer not found"},
+	{CodeUnsupported, "operation not supported by the interface type or the platform"},
+	{CodeConflict, "resource in use or held by another owner"},
+	{CodeDeviceFailed, "WireGuard device could not be read or configured"},
+	{CodeFileFailed, "file could not be read or written"},
+	{CodeInvalidFile, "invalid input file content"},
+	{CodeNotFound, "firewall rule, address, network namespace or route not found"},
+	{CodeCancelled, "cancelled at the confirmation prompt"},
+	{CodeWarnDuplicateEndpoint, "warning: endpoint already used by another peer"},
+	{CodeWarnAllowedIPCovered, "warning: allowed IP covered by another of the same peer"},
+	{CodeWarnPortInUse, "warning: listen port in use, set with force"},
+	{CodeWarnRuleExists, "warning: firewall rule already in place, kept"},
+	{CodeWarnPeersKeepOldKey, "warning: private key changed, peers keep the old public key"},
+	{CodeWarnFamilyMismatch, "warning: address family not carried by the network interface, added with force"},
+}
+
+// CodedError is an error with its code, for the errors which have no type
+// of their own.
+type CodedError struct {
+	Code Code
+	Err  error
+}
+
+// Method returns the message of the wrapped error.
+func (e *CodedError) Error() string {
+	return e.Err.Error()
+}
+
+// Method returns the wrapped error.
+func (e *CodedError) Unwrap() error {
+	return e.Err
+}
+
+// Method returns the code of the error.
+func (e *CodedError) ErrorCode() string {
+	return string(e.Code)
+}
+
+// Function returns the error with the code, nil for a nil error.
+func WithCode(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CodedError{Code: code, Err: err}
+}
+
+// Function returns the error with the code when it has none of its own
+// (CodeOf returns CodeUnknown), otherwise the error unchanged. The parsers
+// of the arguments use it to code the errors of the values they check.
+func WithDefaultCode(code Code, err error) error {
+	if err == nil || CodeOf(err) != CodeUnknown {
+		return err
+	}
+	return WithCode(code, err)
+}
+
+// Function formats an error with the code, as fmt.Errorf.
+//
+// Usage example:
+//
+//	return help.Errorf(help.CodeInterfaceExists,
+//	    "error: network interface name '%s' already exists", name)
+func Errorf(code Code, format string, a ...any) error {
+	return &CodedError{Code: code, Err: fmt.Errorf(format, a...)}
+}
+
+// Function returns the code of the error: the one it carries (an ErrorCode
+// method in its chain), CodePermission for a permission failure, the code
+// of a sentinel error of the get package or of errors.ErrUnsupported,
+// CodeFileFailed for a failed file operation (fs.PathError), and
+// CodeInvalidArguments for DefaultErrorMessage, otherwise CodeUnknown.
+// An empty code is returned for a nil error.
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+
+	var coded interface{ ErrorCode() string }
+	if errors.As(err, &coded) {
+		return Code(coded.ErrorCode())
+	}
+
+	var pathErr *fs.PathError
+	switch {
+	case errors.Is(err, handlers.ErrUnprivileged), handlers.IsPermission(err):
+		return CodePermission
+	case errors.Is(err, get.ErrNoPeerMatch):
+		return CodePeerNotFound
+	case errors.Is(err, get.ErrAmbiguousPeer):
+		return CodeInvalidValue
+	case errors.Is(err, get.ErrNotFound):
+		return CodeNotFound
+	case errors.Is(err, errors.ErrUnsupported):
+		return CodeUnsupported
+	case errors.As(err, &pathErr):
+		return CodeFileFailed
+	case err.Error() == DefaultErrorMessage:
+		return CodeInvalidArguments
+	}
+	return CodeUnknown
+}
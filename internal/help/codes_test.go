@@ -0,0 +1,148 @@
+package help
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/src/command"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/set"
+)
+
+// Testing the catalogue: every code is well formed and unique.
+func TestCatalogue(t *testing.T) {
+	format := regexp.MustCompile(`^BRG-[EW]\d{3}$`)
+	seen := map[Code]bool{}
+	for _, entry := range Catalogue {
+		if !format.MatchString(string(entry.Code)) {
+			t.Errorf("error: code %q does not match %s", entry.Code, format)
+		}
+		if seen[entry.Code] {
+			t.Errorf("error: code %q is listed twice", entry.Code)
+		}
+		if entry.Summary == "" {
+			t.Errorf("error: code %q has no summary", entry.Code)
+		}
+		seen[entry.Code] = true
+	}
+}
+
+// Testing the CodeOf function on the typed and the coded errors.
+func TestCodeOf(t *testing.T) {
+	type testCase struct {
+		name string
+		err  error
+		want Code
+	}
+
+	tests := []testCase{
+		{name: "nil", err: nil, want: ""},
+		{name: "plain", err: errors.New("error: something"), want: CodeUnknown},
+		{name: "default message", err: errors.New(DefaultErrorMessage), want: CodeInvalidArguments},
+		{name: "coded", err: Errorf(CodeInterfaceExists, "error: network interface name '%s' already exists", "wg0"), want: CodeInterfaceExists},
+		{name: "wrapped coded", err: fmt.Errorf("context: %w", WithCode(CodeUnchanged, errors.New("error: unchanged"))), want: CodeUnchanged},
+		{name: "unprivileged", err: handlers.ErrUnprivileged, want: CodePermission},
+		{name: "permission", err: fmt.Errorf("error: %w", os.ErrPermission), want: CodePermission},
+		{name: "interface not found", err: &get.InterfaceNotFoundError{Name: "wg9"}, want: CodeInterfaceNotFound},
+		{name: "command", err: &command.CommandError{Output: "RTNETLINK answers: File exists", ExitCode: 2}, want: CodeCommandFailed},
+		{name: "verification", err: &set.VerificationError{Interface: "wg0", Field: "listen port"}, want: CodeVerification},
+		{name: "locked", err: &oplock.LockedError{Pid: 42}, want: CodeLocked},
+		{name: "peer not found", err: &get.PeerNotFoundError{Interface: "wg0", Peer: "laptop"}, want: CodePeerNotFound},
+		{name: "no peer match", err: fmt.Errorf("error: %w", get.ErrNoPeerMatch), want: CodePeerNotFound},
+		{name: "missing device", err: &get.DeviceError{Interface: "wg0", Op: "read", Err: os.ErrNotExist}, want: CodeInterfaceNotFound},
+		{name: "device failed", err: &get.DeviceError{Interface: "wg0", Op: "update", Err: errors.New("invalid argument")}, want: CodeDeviceFailed},
+		{name: "conflict", err: &get.ConflictError{Err: errors.New("error: UDP port 51820 is in use")}, want: CodeConflict},
+		{name: "unsupported", err: fmt.Errorf("error: %w", errors.ErrUnsupported), want: CodeUnsupported},
+		{name: "file", err: fmt.Errorf("error: %w", &fs.PathError{Op: "open", Path: "/tmp/x", Err: fs.ErrInvalid}), want: CodeFileFailed},
+		{name: "namespace not found", err: &netns.NotFoundError{Target: "blue"}, want: CodeNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := CodeOf(tc.err); got != tc.want {
+				t.Errorf("error: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing that the codes of the typed errors are in the catalogue.
+func TestTypedErrorCodes(t *testing.T) {
+	listed := map[Code]bool{}
+	for _, entry := range Catalogue {
+		listed[entry.Code] = true
+	}
+
+	for _, err := range []interface{ ErrorCode() string }{
+		&get.InterfaceNotFoundError{},
+		&command.CommandError{},
+		&set.VerificationError{},
+		&oplock.LockedError{},
+		&get.PeerNotFoundError{},
+		&get.DeviceError{Err: errors.New("invalid argument")},
+		&get.ConflictError{Err: errors.New("in use")},
+		&netns.NotFoundError{},
+	} {
+		if code := Code(err.ErrorCode()); !listed[code] || code == CodeUnknown {
+			t.Errorf("error: %T returns the code %q, not in the catalogue", err, code)
+		}
+	}
+}
+
+// Testing that WithDefaultCode codes only the errors without a code.
+func TestWithDefaultCode(t *testing.T) {
+	type testCase struct {
+		name string
+		err  error
+		want Code
+	}
+
+	tests := []testCase{
+		{name: "nil", err: nil, want: ""},
+		{name: "plain", err: errors.New("error: invalid value"), want: CodeInvalidValue},
+		{name: "coded", err: WithCode(CodeUnsupported, errors.New("error: unsupported")), want: CodeUnsupported},
+		{name: "typed", err: &get.InterfaceNotFoundError{Name: "wg9"}, want: CodeInterfaceNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := WithDefaultCode(CodeInvalidValue, tc.err)
+			if got := CodeOf(err); got != tc.want {
+				t.Errorf("error: got %q, want %q", got, tc.want)
+			}
+			if tc.err != nil && err.Error() != tc.err.Error() {
+				t.Errorf("error: message %q, want %q", err.Error(), tc.err.Error())
+			}
+		})
+	}
+}
+
+// Testing that the codes of the warnings of the set package are the ones
+// of the catalogue.
+func TestWarningCodes(t *testing.T) {
+	tests := map[string]Code{
+		set.WarnDuplicateEndpoint: CodeWarnDuplicateEndpoint,
+		set.WarnAllowedIPCovered:  CodeWarnAllowedIPCovered,
+		set.WarnPortInUse:         CodeWarnPortInUse,
+		set.WarnRuleExists:        CodeWarnRuleExists,
+		set.WarnPeersKeepOldKey:   CodeWarnPeersKeepOldKey,
+		set.WarnFamilyMismatch:    CodeWarnFamilyMismatch,
+	}
+
+	listed := map[Code]bool{}
+	for _, entry := range Catalogue {
+		listed[entry.Code] = true
+	}
+	for code, want := range tests {
+		if Code(code) != want || !listed[want] {
+			t.Errorf("error: set warning code %q, want %q in the catalogue", code, want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package help
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// Environment variables holding the defaults of the common flags, e.g.,
+// set once for a container. A flag given on the command line always wins.
+const (
+	// Network interface name, the default of -i.
+	Env_Default_Interface string = "BRGNETUSE_INTERFACE"
+
+	// Log directory, the default of -l.
+	Env_Default_LogDir string = "BRGNETUSE_LOG_DIR"
+
+	// Log level with BRGNETUSE_LOG_DIR: debug (-ld) or error (-le).
+	Env_Default_LogLevel string = "BRGNETUSE_LOG_LEVEL"
+
+	// JSON output or logging (-js): 1/true/yes, 0/false/no.
+	Env_Default_JSON string = "BRGNETUSE_JSON"
+
+	// Range of the listen ports assigned with `-p auto` (e.g., "51820-51999").
+	Env_Default_PortRange string = "BRGNETUSE_PORT_RANGE"
+)
+
+// Prefix of the environment variables read by Environ.
+const envPrefix = "BRGNETUSE_"
+
+// Function returns the BRGNETUSE_ variables of the process environment,
+// the env argument of the ParseWithEnv functions of the utilities.
+func Environ() map[string]string {
+	env := make(map[string]string)
+	for _, entry := range os.Environ() {
+		name, value, ok := strings.Cut(entry, "=")
+		if ok && strings.HasPrefix(name, envPrefix) {
+			env[name] = value
+		}
+	}
+	return env
+}
+
+// Function parses a boolean environment variable: 1, true and yes enable
+// it, 0, false, no and an unset or empty variable disable it.
+func EnvBool(env map[string]string, name string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(env[name])) {
+	case "1", "true", "yes":
+		return true, nil
+	case "", "0", "false", "no":
+		return false, nil
+	}
+	return false, Errorf(
+		CodeInvalidValue,
+		"error: invalid value '%s' for %s, expected 1/true/yes or 0/false/no",
+		env[name], name,
+	)
+}
+
+// Function returns the log level flag of BRGNETUSE_LOG_LEVEL: debug is
+// -ld, error is -le. Any other value is returned as is, so it is refused
+// as an invalid log level flag would be.
+func EnvLogLevelFlag(value string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return LogInfoFlag
+	case "error":
+		return LogErrorFlag
+	}
+	return value
+}
+
+// Function inserts the network interface of BRGNETUSE_INTERFACE as
+// `-i [name]` before the command arguments (the program name excluded)
+// when they start with one of the sub-flags (e.g., `-u -p 51820`). Other
+// arguments are returned unchanged.
+func EnvInterfaceArgs(args []string, env map[string]string, subFlags ...string) []string {
+	iface := env[Env_Default_Interface]
+	if iface == "" || len(args) == 0 || !slices.Contains(subFlags, args[0]) {
+		return args
+	}
+	return slices.Concat([]string{WgInterfaceFlag, iface}, args)
+}
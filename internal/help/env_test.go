@@ -0,0 +1,90 @@
+package help
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Testing the boolean values of the environment variables.
+func TestEnvBool(t *testing.T) {
+	type testCase struct {
+		value     string
+		want      bool
+		wantError bool
+	}
+
+	tests := []testCase{
+		{value: "1", want: true},
+		{value: "true", want: true},
+		{value: "YES", want: true},
+		{value: " yes ", want: true},
+		{value: ""},
+		{value: "0"},
+		{value: "false"},
+		{value: "no"},
+		{value: "on", wantError: true},
+		{value: "2", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := EnvBool(map[string]string{Env_Default_JSON: tc.value}, Env_Default_JSON)
+			if tc.wantError {
+				if err == nil || !strings.Contains(err.Error(), Env_Default_JSON) {
+					t.Fatalf("error: got %v, want an error naming %s", err, Env_Default_JSON)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the log level flags of BRGNETUSE_LOG_LEVEL.
+func TestEnvLogLevelFlag(t *testing.T) {
+	for value, want := range map[string]string{
+		"debug":   LogInfoFlag,
+		"Error":   LogErrorFlag,
+		"-ld":     "-ld",
+		"verbose": "verbose",
+	} {
+		if got := EnvLogLevelFlag(value); got != want {
+			t.Errorf("error: got %q for %q, want %q", got, value, want)
+		}
+	}
+}
+
+// Testing the insertion of `-i [name]` from BRGNETUSE_INTERFACE.
+func TestEnvInterfaceArgs(t *testing.T) {
+	env := map[string]string{Env_Default_Interface: "wg0"}
+
+	type testCase struct {
+		name string
+		args []string
+		env  map[string]string
+		want []string
+	}
+
+	tests := []testCase{
+		{name: "sub-flag", args: []string{"-u", "-p", "51820"}, env: env, want: []string{"-i", "wg0", "-u", "-p", "51820"}},
+		{name: "flag wins", args: []string{"-i", "wg1", "-u"}, env: env, want: []string{"-i", "wg1", "-u"}},
+		{name: "other command", args: []string{"-fw4", "-a"}, env: env, want: []string{"-fw4", "-a"}},
+		{name: "unset", args: []string{"-u", "-p", "51820"}, want: []string{"-u", "-p", "51820"}},
+		{name: "empty", args: []string{}, env: env, want: []string{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := EnvInterfaceArgs(tc.args, tc.env, UpdateFlag, PeerFlag)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("error: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
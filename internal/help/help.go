@@ -2,22 +2,23 @@
 package help
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"regexp"
-	"strconv"
 	"strings"
 
-	"github.com/AlexKira/brgnetuse/internal/handlers"
 	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/proc"
+	"github.com/AlexKira/brgnetuse/src/validate"
 )
 
 const RegexSymbols = `!@#$%^&*()_+-=}{][|'~?`
 
 const Env_Field_Foreground = "WG_PROCESS_FOREGROUND"
-const Env_Field_Type = "ENV_PROTOCOL_TYPE"
-const Env_Field_Tag = "ENV_PROTOCOL_TAG"
+const Env_Field_Type = proc.EnvFieldType
+const Env_Field_Tag = proc.EnvFieldTag
 
 const Env_Awg_Type string = "awg"
 const Env_Wg_Type string = "wg"
@@ -27,34 +28,117 @@ const ExitSetupFailed int = 1
 const (
 	// Default flag.
 	HelpFlag        string = "-h"
+	VersionFlag     string = "-V"
+	VersionLongFlag string = "-version"
 	WgInterfaceFlag string = "-i"
 	AddFlag         string = "-a"
 	DelFlag         string = "-d"
 	PortFlag        string = "-p"
 	UpdateFlag      string = "-u"
 	LogTypeFlag     string = "-js"
+	ForceFlag       string = "-force"
+	StrictFlag      string = "-strict"
+	YesIAmSureFlag  string = "-yes-i-am-sure"
+	YesFlag         string = "-y"
+	YesLongFlag     string = "-yes"
+	GroupFlag       string = "-g"
+	ParallelFlag    string = "-parallel"
+	NetnsFlag       string = "-netns"
+	ToNetnsFlag     string = "-to-netns"
+	AutoPortValue   string = "auto"
 
 	// Utility brgaddwg.
 	PathLogDirFlag string = "-l"
 	LogInfoFlag    string = "-ld"
 	LogErrorFlag   string = "-le"
 	MTUFlag        string = "-m"
+	CleanupFlag    string = "-cleanup"
+	AliasFlag      string = "-alias"
+	SuperviseFlag  string = "-supervise"
+	LogModeFlag    string = "-log-mode"
+	LogChownFlag   string = "-log-chown"
+	ForceMTUFlag   string = "-force-mtu"
+	LogDedupFlag   string = "-log-dedup"
+	LogEventsFlag  string = "-log-events"
+	SpecFlag       string = "-spec"
 
 	// Utility brgsetwg.
 	IpAddressFlag          string = "-ip"
 	EnableWgInterfaceFlag  string = "-up"
 	DisableWgInterfaceFlag string = "-dw"
+	RenameFlag             string = "-rn"
 	NatFlag                string = "-n"
+	NatAllValue            string = "all"
 	ForwIpv4Flag           string = "-fw4"
 	ForwIpv6Flag           string = "-fw6"
 	PrivateKeyFlag         string = "-pk"
 	PeerFlag               string = "-pr"
 	KeepaliveFlag          string = "-kp"
 	EndPointHostFlag       string = "-eh"
+	PeerNameFlag           string = "-name"
+	PeerExpiresFlag        string = "-expires"
+	LimitFlag              string = "-limit"
+	LimitOffValue          string = "off"
+	DNSFlag                string = "-dns"
+	PruneFlag              string = "-prune"
+	RefreshEndpointsFlag   string = "-refresh-endpoints"
+	RotateFlag             string = "-rotate"
+	OutFlag                string = "-out"
+	PurgeFlag              string = "-purge"
+	DryFlag                string = "-dry"
+	ReconcileFlag          string = "-reconcile"
+	ValidateFlag           string = "-validate"
+	ApplyFlag              string = "-apply"
+	SaveFlag               string = "-save"
+	RestoreFlag            string = "-restore"
+	UnitFlag               string = "-unit"
+	FwMarkFlag             string = "-fwmark"
+	RestoreBackupFlag      string = "-restore-backup"
+	QuotaFlag              string = "-quota"
+	QuotaPeriodFlag        string = "-quota-period"
+	EnforceQuotasFlag      string = "-enforce-quotas"
+	InInterfaceFlag        string = "-in"
+	HairpinFlag            string = "-hairpin"
+	AdoptFlag              string = "-adopt"
+	ProvisionFlag          string = "-provision"
 
 	// Utility brggetwg.
-	ForwardingFlag string = "-fw"
-	FirewallFlag   string = "-fr"
+	ForwardingFlag   string = "-fw"
+	FirewallFlag     string = "-fr"
+	PublicKeyFlag    string = "-pub"
+	StdinValue       string = "-"
+	OutDirFlag       string = "-o"
+	PeerKeyFlag      string = "-k"
+	PeerEndpointFlag string = "-e"
+	SortFlag         string = "-sort"
+	ReverseFlag      string = "-r"
+	OffsetFlag       string = "-offset"
+	WatchFlag        string = "-w"
+	CheckFlag        string = "-check"
+	MaxHandshakeFlag string = "-max-handshake"
+	MinPeersFlag     string = "-min-peers"
+	IgnoreNewFlag    string = "-ignore-new"
+	SocketsFlag      string = "-sockets"
+	SourceFlag       string = "-s"
+	OwnerFlag        string = "-owner"
+	ContainsFlag     string = "-contains"
+	WatchEventsFlag  string = "-watch-events"
+	ExecFlag         string = "-exec"
+	DownAfterFlag    string = "-down-after"
+	DebounceFlag     string = "-debounce"
+	BackupFlag       string = "-backup"
+	KeyFileFlag      string = "-key-file"
+	CountersFlag     string = "-counters"
+	ClientFlag       string = "-client"
+	AllowedIPsFlag   string = "-allowed"
+	FormatFlag       string = "-format"
+	TemplateFlag     string = "-template"
+	QRFlag           string = "-qr"
+	StatusFlag       string = "-st"
+	CapsFlag         string = "-caps"
+	ProbeFlag        string = "-probe"
+	CountFlag        string = "-c"
+	UDPFlag          string = "-udp"
 )
 
 // Function prints a formatted help message to the console for the utility.
@@ -66,12 +150,38 @@ func BridgeAddHelp(utility string) {
 	fmt.Fprintln(os.Stderr, "|  ______________________________________________________________    |")
 	fmt.Fprintln(os.Stderr, "│                                                                    │")
 	fmt.Fprintln(os.Stderr, "│    [-h]           Help.                                            │")
+	fmt.Fprintln(os.Stderr, "│    [-V]           Version and build info.                          │")
+	fmt.Fprintln(os.Stderr, "│    [-netns][name] Run in the network namespace (any position).     │")
 	fmt.Fprintln(os.Stderr, "│    |_[-i][name]   Add a network interface name.                    │")
-	fmt.Fprintln(os.Stderr, "│    |_[-m][number] Add MTU size.                                    │")
+	fmt.Fprintln(os.Stderr, "│    |_[-m][number] Add MTU size (1280-9000).                        │")
+	fmt.Fprintln(os.Stderr, "│    |_[-force-mtu] Allow an MTU of 68-65535 (exotic links).         │")
 	fmt.Fprintln(os.Stderr, "│    |_[-l][path]   Add path to log file directory.                  │")
 	fmt.Fprintln(os.Stderr, "│        |_[-ld]    Logging level: Debug.                            │")
 	fmt.Fprintln(os.Stderr, "│        |_[-le]    Logging level: Error.                            │")
 	fmt.Fprintln(os.Stderr, "│        |_[-js]    Logging type JSON. Defailt: String.              │")
+	fmt.Fprintln(os.Stderr, "│    |_[-cleanup]   Remove rules and addresses on shutdown.          │")
+	fmt.Fprintln(os.Stderr, "│    |_[-force]     Recreate an existing interface of this tool.     │")
+	fmt.Fprintln(os.Stderr, "│    |_[-alias]     Add a network interface alias (text).            │")
+	fmt.Fprintln(os.Stderr, "│    |_[-to-netns][name|pid] Move the interface, once up, into the   │")
+	fmt.Fprintln(os.Stderr, "│                   network namespace; its UDP socket stays here.    │")
+	fmt.Fprintln(os.Stderr, "│    |_[-p][port]   Listen port, 'auto': lowest free of the range.   │")
+	fmt.Fprintln(os.Stderr, "│        |_[-fr]    Open the listen port in the INPUT chain.         │")
+	fmt.Fprintln(os.Stderr, "│    |_[-supervise] Restart the device process when it exits.        │")
+	fmt.Fprintln(os.Stderr, "│    |_[-log-mode]  Log file mode, octal. Default: 0640.             │")
+	fmt.Fprintln(os.Stderr, "│    |_[-log-chown] Give the log file to the user running sudo.      │")
+	fmt.Fprintln(os.Stderr, "│    |_[-log-dedup][seconds] Collapse repeated JSON log messages.    │")
+	fmt.Fprintln(os.Stderr, "│    |_[-log-events] Add event and peer fields to JSON log records.  │")
+	fmt.Fprintln(os.Stderr, "│    |_[-refresh-endpoints][seconds] Re-resolve peer endpoint host   │")
+	fmt.Fprintln(os.Stderr, "│                   names periodically (WireGuard only).             │")
+	fmt.Fprintln(os.Stderr, "│    |_[-spec][path] Bring the interface up from a spec file: name,  │")
+	fmt.Fprintln(os.Stderr, "│                   MTU, port, key, addresses, NAT, peers (JSON).    │")
+	fmt.Fprintln(os.Stderr, "│                                                                    │")
+	fmt.Fprintln(os.Stderr, "│  Environment, defaults of the flags (a flag given wins):           │")
+	fmt.Fprintln(os.Stderr, "│    BRGNETUSE_INTERFACE   Network interface name (-i).              │")
+	fmt.Fprintln(os.Stderr, "│    BRGNETUSE_LOG_DIR     Log file directory (-l).                  │")
+	fmt.Fprintln(os.Stderr, "│    BRGNETUSE_LOG_LEVEL   debug or error (-ld, -le), with LOG_DIR.  │")
+	fmt.Fprintln(os.Stderr, "│    BRGNETUSE_JSON        1/true/yes: logging type JSON (-js).      │")
+	fmt.Fprintln(os.Stderr, "│    BRGNETUSE_PORT_RANGE  Ports of -p auto. Default: 51820-51999.   │")
 	fmt.Fprintln(os.Stderr, "│                                                                    │")
 	fmt.Fprintln(os.Stderr, "│  Example:                                                          │")
 	fmt.Fprintln(os.Stderr, "|  ______________________________________________________________    |")
@@ -81,11 +191,43 @@ func BridgeAddHelp(utility string) {
 	fmt.Fprintln(os.Stderr, "│                                                                    │")
 	fmt.Fprintln(os.Stderr, "│   Add MTU size:                                                    │")
 	fmt.Fprintf(os.Stderr, "│    %s -i wg0 -m 1340                                        │\n", utility)
+	fmt.Fprintf(os.Stderr, "│     %s -i wg0 -m 8920                                       │\n", utility)
+	fmt.Fprintln(os.Stderr, "│                                                                    │")
+	fmt.Fprintln(os.Stderr, "│   Add an MTU outside the default range:                            │")
+	fmt.Fprintf(os.Stderr, "│     %s -i wg0 -m 1000 -force-mtu                            │\n", utility)
+	fmt.Fprintln(os.Stderr, "│                                                                    │")
+	fmt.Fprintln(os.Stderr, "│   Add a network interface in a network namespace:                  │")
+	fmt.Fprintf(os.Stderr, "│     %s -i wg0 -netns blue                                   │\n", utility)
 	fmt.Fprintln(os.Stderr, "│                                                                    │")
 	fmt.Fprintln(os.Stderr, "│   Add path to log file directory:                                  │")
 	fmt.Fprintf(os.Stderr, "│     %s -i wg0 -l /var/log -ld                               │\n", utility)
 	fmt.Fprintf(os.Stderr, "│     %s -i wg0 -l /var/log -le -js                           │\n", utility)
 	fmt.Fprintf(os.Stderr, "│     %s -i wg0 -m 1340 -l /var/log -ld -js                   │\n", utility)
+	fmt.Fprintf(os.Stderr, "│     %s -i wg0 -l /var/log/brg -le -log-mode 0600            │\n", utility)
+	fmt.Fprintln(os.Stderr, "│                                                                    │")
+	fmt.Fprintln(os.Stderr, "│   Remove recorded rules and addresses on shutdown:                 │")
+	fmt.Fprintf(os.Stderr, "│     %s -i wg0 -cleanup -l /var/log -le                      │\n", utility)
+	fmt.Fprintln(os.Stderr, "│                                                                    │")
+	fmt.Fprintln(os.Stderr, "│   Add a network interface alias (description):                     │")
+	fmt.Fprintf(os.Stderr, "│     %s -i wg0 -alias 'office vpn'                           │\n", utility)
+	fmt.Fprintln(os.Stderr, "│                                                                    │")
+	fmt.Fprintln(os.Stderr, "│   Move the network interface into a container namespace:           │")
+	fmt.Fprintf(os.Stderr, "│     %s -i wg0 -to-netns blue                                │\n", utility)
+	fmt.Fprintln(os.Stderr, "│                                                                    │")
+	fmt.Fprintln(os.Stderr, "│   Listen on the lowest free port, open it in the firewall:         │")
+	fmt.Fprintf(os.Stderr, "│     %s -i wg0 -p auto -fr                                   │\n", utility)
+	fmt.Fprintln(os.Stderr, "│                                                                    │")
+	fmt.Fprintln(os.Stderr, "│   Recreate an existing network interface:                          │")
+	fmt.Fprintf(os.Stderr, "│     %s -i wg0 -force                                        │\n", utility)
+	fmt.Fprintln(os.Stderr, "│                                                                    │")
+	fmt.Fprintln(os.Stderr, "│   Restart the device process after a crash:                        │")
+	fmt.Fprintf(os.Stderr, "│     %s -i wg0 -supervise -l /var/log -le                    │\n", utility)
+	fmt.Fprintln(os.Stderr, "│                                                                    │")
+	fmt.Fprintln(os.Stderr, "│   Follow the peers of dynamic DNS endpoints, every 5 minutes:      │")
+	fmt.Fprintf(os.Stderr, "│     %s -i wg0 -refresh-endpoints 300 -l /var/log -ld        │\n", utility)
+	fmt.Fprintln(os.Stderr, "│                                                                    │")
+	fmt.Fprintln(os.Stderr, "│   Bring up an interface described by a spec file:                  │")
+	fmt.Fprintf(os.Stderr, "│     %s -spec /etc/brgnetuse/wg0.json -l /var/log -le        │\n", utility)
 	fmt.Fprintln(os.Stderr, "│                                                                    │")
 	fmt.Fprintln(os.Stderr, "└────────────────────────────────────────────────────────────────────┘")
 }
@@ -104,54 +246,164 @@ func BridgeSetWgHelp() {
 	fmt.Fprintln(os.Stderr, "│        iptables, ip, and awg.                                                         │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│    [-h]                          Help.                                                │")
+	fmt.Fprintln(os.Stderr, "│    [-V]                          Version and build info.                              │")
+	fmt.Fprintln(os.Stderr, "│    [-y] or [-yes]                Do not ask to confirm deletions (any position).      │")
+	fmt.Fprintln(os.Stderr, "│                                  Asked only when stdin is a terminal.                 │")
+	fmt.Fprintln(os.Stderr, "│    [-netns][name]                Run in the network namespace (any position), e.g.,   │")
+	fmt.Fprintln(os.Stderr, "│                                  one created with `ip netns add blue`.                │")
+	fmt.Fprintln(os.Stderr, "│    [-js]                         Print the results as JSON (first argument).          │")
+	fmt.Fprintln(os.Stderr, "│    [-g][prefix]                  Run an [-i] command on each WireGuard interface      │")
+	fmt.Fprintln(os.Stderr, "│                                  named prefix*, or give [-i] a pattern ('wg-cust*').  │")
+	fmt.Fprintln(os.Stderr, "│        |_[-parallel][n]          Interfaces changed at the same time, def. 1.         │")
 	fmt.Fprintln(os.Stderr, "│    |_[-i][name]                  Wireguard network interface name.                    │")
 	fmt.Fprintln(os.Stderr, "│    |   |_[-d]                    Remove Wireguard Network Interface.                  │")
+	fmt.Fprintln(os.Stderr, "│    |   |   |_[-strict]           Fail if the network interface does not exist.        │")
+	fmt.Fprintln(os.Stderr, "│    |   |   |_[-yes-i-am-sure]    Also when it carries this SSH session.               │")
 	fmt.Fprintln(os.Stderr, "│    |   |_[-up]                   Enable network interface.                            │")
 	fmt.Fprintln(os.Stderr, "│    |   |_[-dw]                   Disable network interface.                           │")
+	fmt.Fprintln(os.Stderr, "│    |   |   |_[-yes-i-am-sure]    Also when it carries this SSH session.               │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-rn][name]             Rename network interface.                            │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-alias][text]          Network interface alias, '' clears it.               │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-to-netns][name|pid]   Move a kernel network interface into the namespace,  │")
+	fmt.Fprintln(os.Stderr, "│    |   |                         its addresses and up state are restored there.       │")
 	fmt.Fprintln(os.Stderr, "│    |   |                                                                              │")
 	fmt.Fprintln(os.Stderr, "│    |   |_[-u]                                                                         │")
-	fmt.Fprintln(os.Stderr, "│    |   |   |_[-p][number]        Update port.                                         │")
+	fmt.Fprintln(os.Stderr, "│    |   |   |_[-p][number|auto]   Update port, refused if already in use. 'auto' keeps │")
+	fmt.Fprintln(os.Stderr, "│    |   |   |   |                 the port in BRGNETUSE_PORT_RANGE or picks the lowest │")
+	fmt.Fprintln(os.Stderr, "│    |   |   |   |                 free one.                                            │")
+	fmt.Fprintln(os.Stderr, "│    |   |   |   |_[-force]        Update the port even if it is in use.                │")
+	fmt.Fprintln(os.Stderr, "│    |   |   |   |_[-strict]       Fail if the port is already set.                     │")
+	fmt.Fprintln(os.Stderr, "│    |   |   |   |_[-fr]           Open the port in the INPUT chain (udp) before the    │")
+	fmt.Fprintln(os.Stderr, "│    |   |   |   |                 update, close the old tagged one after it.           │")
+	fmt.Fprintln(os.Stderr, "│    |   |   |_[-fwmark][number]   Update firewall mark, 0 clears it.                   │")
 	fmt.Fprintln(os.Stderr, "│    |   |   |_[-pk]               Update private key Wireguard network interface.      │")
-	fmt.Fprintln(os.Stderr, "│    |   |        |_[key]          Your private key in base64 encoding.                 │")
+	fmt.Fprintln(os.Stderr, "│    |   |        |_[key]          Your private key in base64 encoding, '-' stdin.      │")
+	fmt.Fprintln(os.Stderr, "│    |   |        |   |_[-strict]  Fail if the key is already set.                      │")
+	fmt.Fprintln(os.Stderr, "│    |   |        |_[-rotate]      Rotate the key and print the new public key.         │")
+	fmt.Fprintln(os.Stderr, "│    |   |             |_[-out][path]  Write the new private key to a 0600 file.        │")
 	fmt.Fprintln(os.Stderr, "│    |   |                                                                              │")
 	fmt.Fprintln(os.Stderr, "│    |   |_[-pr][pub_key]          Add peer for the Wireguard network interface.        │")
-	fmt.Fprintln(os.Stderr, "│    |   |    |_[-a][address]      Allowed IP address in CIDR notation.                 │")
-	fmt.Fprintln(os.Stderr, "│    |   |    |_[-kp][number]      Persistent keepalive interval in seconds.            │")
-	fmt.Fprintln(os.Stderr, "│    |   |    |_[-eh][address]     Endpoint host.                                       │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-a][address]      Allowed IP address in CIDR notation, repeat [-a] or  │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |                    separate several with commas.                        │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-kp][number]      Persistent keepalive interval, 0-65535 seconds.      │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-eh][address]     Endpoint, IP address or host name and port.          │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-name][name]      Peer name shown next to the public key.              │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-expires][date]   Peer expiry, RFC3339 or YYYY-MM-DD.                  │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-force]           Add even if allowed IPs overlap another peer.        │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-strict]          Fail if the peer is already configured.              │")
 	fmt.Fprintln(os.Stderr, "│    |   |                                                                              │")
 	fmt.Fprintln(os.Stderr, "│    |   |_[-pr][pub_key][-d]      Delete peer for the Wireguard network interface.     │")
 	fmt.Fprintln(os.Stderr, "│    |   |                                                                              │")
-	fmt.Fprintln(os.Stderr, "│    |   |_[-ip][address]          IP address in CIDR notation.                         │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-pr][pub_key][-limit]  Limit the peer bandwidth, each direction.            │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[rate]             Rate (e.g., 10mbit, 512kbit), 'off' to remove.       │")
+	fmt.Fprintln(os.Stderr, "│    |   |                                                                              │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-pr][pub_key][-quota]  Transfer quota (rx+tx), see -enforce-quotas.         │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[size]             Size (e.g., 50gb, 512mib), 'off' to remove.          │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-quota-period][p] Reset every period (e.g., 30d, 12h). Default: never. │")
+	fmt.Fprintln(os.Stderr, "│    |   |                                                                              │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-prune]                Delete peers whose expiry has passed.                │")
+	fmt.Fprintln(os.Stderr, "│    |   |                                                                              │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-enforce-quotas]       Sample the transfer, delete peers over their quota.  │")
+	fmt.Fprintln(os.Stderr, "│    |   |                                                                              │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-refresh-endpoints]    Re-resolve the endpoint host names, update changed.  │")
+	fmt.Fprintln(os.Stderr, "│    |   |                                                                              │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-purge]                Remove addresses, rules, process, link and metadata. │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-dry]             List what would be removed.                          │")
+	fmt.Fprintln(os.Stderr, "│    |   |                                                                              │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-adopt]                Tag the rules and record the metadata of an          │")
+	fmt.Fprintln(os.Stderr, "│    |   |                         interface created by another tool (e.g., wg-quick).  │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-dry]             List the rules to tag and to confirm by hand.        │")
+	fmt.Fprintln(os.Stderr, "│    |   |                                                                              │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-provision]            Add a client with a free address, print its config.  │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-name][name]      Client name (required).                              │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-eh][host[:port]] Server endpoint (required), port of the interface.   │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-dns][server,...] DNS servers of the client.                           │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-kp][seconds]     Keepalive. Default: 25, 0 to disable.                │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-expires][date]   Client expiry, RFC3339 or YYYY-MM-DD.                │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-out][path]       Write the configuration to a new file (0600).        │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-qr][path]        Write the configuration as a QR code (PNG).          │")
+	fmt.Fprintln(os.Stderr, "│    |   |                                                                              │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-dns][server,...]      DNS servers (systemd-resolved or resolvconf).        │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-a]               Set DNS servers for network interface.               │")
+	fmt.Fprintln(os.Stderr, "│    |   |    |_[-d]               Remove DNS servers, restore the previous ones.       │")
+	fmt.Fprintln(os.Stderr, "│    |   |                                                                              │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-ip][address,...]      IP addresses in CIDR notation (IPv4, IPv6).          │")
+	fmt.Fprintln(os.Stderr, "│    |   |                         NAT rules are added for IPv4 only.                   │")
 	fmt.Fprintln(os.Stderr, "│    |        |_[-a]               Add IP address for network interface.                │")
+	fmt.Fprintln(os.Stderr, "│    |        |   |_[-strict]      Fail if an address is already present.               │")
 	fmt.Fprintln(os.Stderr, "│    |        |   |                                                                     │")
 	fmt.Fprintln(os.Stderr, "│    |        |   |_[-n] or [-fr]  Automatically add NAT rules.                         │")
-	fmt.Fprintln(os.Stderr, "│    |        |          |_[name]  Network interface name.                              │")
+	fmt.Fprintln(os.Stderr, "│    |        |          |_[name]  Network interface name or list (e.g., eth0,wwan0),   │")
+	fmt.Fprintln(os.Stderr, "│    |        |                    'all' for the uplinks with a default route.          │")
+	fmt.Fprintln(os.Stderr, "│    |        |          |_[-force]                                                     │")
+	fmt.Fprintln(os.Stderr, "│    |        |                    Also through an interface without an address of      │")
+	fmt.Fprintln(os.Stderr, "│    |        |                    the family (IPv4, IPv6) of the address.              │")
 	fmt.Fprintln(os.Stderr, "│    |        |                                                                         │")
 	fmt.Fprintln(os.Stderr, "│    |        |_[-d]               Delete IP address of network interface.              │")
 	fmt.Fprintln(os.Stderr, "│    |            |_[-n]           Delete NAT rules.                                    │")
-	fmt.Fprintln(os.Stderr, "│    |            |   |_[name]     Network interface name.                              │")
+	fmt.Fprintln(os.Stderr, "│    |            |   |_[name]     Network interface name or list, or 'all'.            │")
 	fmt.Fprintln(os.Stderr, "│    |            |                                                                     │")
 	fmt.Fprintln(os.Stderr, "│    |            |_[-fr]          Delete Firewall rules.                               │")
-	fmt.Fprintln(os.Stderr, "│    |                |_[name]     Network interface name.                              │")
+	fmt.Fprintln(os.Stderr, "│    |                |_[name]     Network interface name or list, or 'all'.            │")
+	fmt.Fprintln(os.Stderr, "│    |                                                                                  │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-hairpin]              Hairpin NAT: clients reach a service of another      │")
+	fmt.Fprintln(os.Stderr, "│    |        |                    client through the public address. The traffic       │")
+	fmt.Fprintln(os.Stderr, "│    |        |                    wg0 to wg0 must pass FORWARD (no peer isolation).    │")
+	fmt.Fprintln(os.Stderr, "│    |        |_[-a][subnet,...]   Add the MASQUERADE rule of the subnet (IPv4).        │")
+	fmt.Fprintln(os.Stderr, "│    |        |    |_[-force]      Also when the interface has no IPv4 address.         │")
+	fmt.Fprintln(os.Stderr, "│    |        |_[-d][subnet,...]   Delete it.                                           │")
 	fmt.Fprintln(os.Stderr, "│    |                                                                                  │")
 	fmt.Fprintln(os.Stderr, "│    |_[-fw4]                      Forwarding `IPV4` between network interfaces.        │")
 	fmt.Fprintln(os.Stderr, "│    |    |_[-a]                   Enable.                                              │")
 	fmt.Fprintln(os.Stderr, "│    |    |_[-d]                   Disable.                                             │")
+	fmt.Fprintln(os.Stderr, "│    |    |_[-strict]              Fail if already enabled or disabled.                 │")
 	fmt.Fprintln(os.Stderr, "│    |                                                                                  │")
 	fmt.Fprintln(os.Stderr, "│    |_[-fw6]                      Forwarding `IPV6` between network interfaces.        │")
 	fmt.Fprintln(os.Stderr, "│    |    |_[-a]                   Enable.                                              │")
 	fmt.Fprintln(os.Stderr, "│    |    |_[-d]                   Disable.                                             │")
+	fmt.Fprintln(os.Stderr, "│    |    |_[-strict]              Fail if already enabled or disabled.                 │")
+	fmt.Fprintln(os.Stderr, "│    |                                                                                  │")
+	fmt.Fprintln(os.Stderr, "│    |_[-reconcile][path]          Compare the state file (JSON) with the system.       │")
+	fmt.Fprintln(os.Stderr, "│    |    |                        '-' reads the state from stdin.                      │")
+	fmt.Fprintln(os.Stderr, "│    |    |_[-apply]               Apply the changes (interface, address, peer, rules). │")
+	fmt.Fprintln(os.Stderr, "│    |    |_[-js]                  Output type JSON. Default: String.                   │")
+	fmt.Fprintln(os.Stderr, "│    |                                                                                  │")
+	fmt.Fprintln(os.Stderr, "│    |_[-validate][path]           Check a file offline, report every problem.          │")
+	fmt.Fprintln(os.Stderr, "│    |    |                        State file (.json), peers (.csv), wg-quick (.conf).  │")
+	fmt.Fprintln(os.Stderr, "│    |    |                        CSV: key,allowed_ips,endpoint,keepalive,name,expires.│")
+	fmt.Fprintln(os.Stderr, "│    |    |                        '-' reads the file from stdin.                       │")
+	fmt.Fprintln(os.Stderr, "│    |    |_[-format][type]        json, csv or conf. Default: the file extension.      │")
+	fmt.Fprintln(os.Stderr, "│    |                                                                                  │")
+	fmt.Fprintln(os.Stderr, "│    |_[-restore-backup][file]     Recreate the interfaces of `brggetwg -backup`.       │")
+	fmt.Fprintln(os.Stderr, "│    |    |_[-key-file][path]      Passphrase file. Default: prompt.                    │")
+	fmt.Fprintln(os.Stderr, "│    |    |_[-force]               Overwrite the interfaces with another key.           │")
 	fmt.Fprintln(os.Stderr, "│    |                                                                                  │")
 	fmt.Fprintln(os.Stderr, "│    |_[-fr]                       Additional Firewall Commands.                        │")
 	fmt.Fprintln(os.Stderr, "│         |_[-u]                   Type: UDP.                                           │")
-	fmt.Fprintln(os.Stderr, "│             |_[-a][number]       Add port number to table.                            │")
-	fmt.Fprintln(os.Stderr, "│             |_[-d][number]       Delete port number from table.                       │")
+	fmt.Fprintln(os.Stderr, "│         |    |_[-a][number]      Add port number to table.                            │")
+	fmt.Fprintln(os.Stderr, "│         |    |_[-d][number]      Delete port number from table.                       │")
+	fmt.Fprintln(os.Stderr, "│         |    |_[-in][name]       Only on the input interface (-a and -d).             │")
+	fmt.Fprintln(os.Stderr, "│         |                                                                             │")
+	fmt.Fprintln(os.Stderr, "│         |_[-save][path]          Save the brgnetuse rules to a file.                  │")
+	fmt.Fprintln(os.Stderr, "│         |    |_[-force]          Overwrite a file not written by brgnetuse.           │")
+	fmt.Fprintln(os.Stderr, "│         |    |_[-unit]           Write a systemd unit restoring the rules at boot.    │")
+	fmt.Fprintln(os.Stderr, "│         |_[-restore][path]       Restore the saved rules, keeping the others.         │")
+	fmt.Fprintln(os.Stderr, "│                                 '-' reads the rules from stdin.                       │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│  Environment, defaults of the flags (a flag given wins):                              │")
+	fmt.Fprintln(os.Stderr, "│    BRGNETUSE_INTERFACE   Network interface name of the [-i] commands, e.g.,           │")
+	fmt.Fprintln(os.Stderr, "│                          `brgsetwg -u -p 51820`.                                      │")
+	fmt.Fprintln(os.Stderr, "│    BRGNETUSE_JSON        1/true/yes: print the results as JSON (-js).                 │")
+	fmt.Fprintln(os.Stderr, "│    BRGNETUSE_PORT_RANGE  Ports of `-p auto`, start-end. Default: 51820-51999.         │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│  Example:                                                                             │")
 	fmt.Fprintln(os.Stderr, "|  ___________________________________________________________________________________  |")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│   Remove Wireguard Network Interface:                                                 │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -d                                                                │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -d -strict                                                        │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -d -yes-i-am-sure                                                 │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -y -i wg0 -d                                                             │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│   Enable network interface:                                                           │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -up                                                               │")
@@ -159,32 +411,116 @@ func BridgeSetWgHelp() {
 	fmt.Fprintln(os.Stderr, "│   Disable network interface:                                                          │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -dw                                                               │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
-	fmt.Fprintln(os.Stderr, "│   Update port:                                                                        │")
+	fmt.Fprintln(os.Stderr, "│   Rotate the private key of every network interface of a group:                       │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i 'wg-cust*' -u -pk -rotate                                             │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -g wg-cust -u -pk -rotate -parallel 4                                    │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Rename network interface:                                                           │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -rn wg-office                                                     │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Set or clear the alias (description) of network interface:                          │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -alias 'office vpn'                                               │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -alias ''                                                         │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Move network interface into a network namespace (by name or process ID):            │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -to-netns blue                                                    │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -to-netns 4242                                                    │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Update port and firewall mark:                                                      │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -u -p 51855                                                       │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -u -p 51855 -fwmark 51820                                         │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -u -p auto -fr                                                    │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│   Update private key Wireguard network interface:                                     │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -u -pk                                                            │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -u -pk AAAAAAAAAAAAA=                                             │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -u -pk -rotate -out /etc/brgnetuse/wg0.key                        │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -pk | brgsetwg -i wg0 -u -pk -                                           │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│   Add peer for the Wireguard network interface:                                       │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -pr AAAAAAAAAAAAA= -a 10.0.0.1/32                                 │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -pr AAAAAAAAAAAAA= -a 10.0.0.1/32 -kp 10 -eh 172.168.85.1:65535   │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -pr AAAAAAAAAAAAA= -a 10.0.0.2/32 -a 10.0.0.0/24                  │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -pr AAAAAAAAAAAAA= -a 10.0.0.2/32,fd00::2/128                     │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -pr AAAAAAAAAAAAA= -a 10.0.0.1/32 -name alice-laptop              │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -pr AAAAAAAAAAAAA= -a 10.0.0.1/32 -expires 2025-07-01             │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -pr AAAAAAAAAAAAA= -a 10.0.0.0/24 -force                          │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -netns blue -i wg0 -pr AAAAAAAAAAAAA= -a 10.0.0.1/32                     │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│   Delete peer for the Wireguard network interface:                                    │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -pr AAAAAAAAAAAAA= -d                                             │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Limit the bandwidth of a peer (tc), or remove the limit:                            │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -pr AAAAAAAAAAAAA= -limit 10mbit                                  │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -pr AAAAAAAAAAAAA= -limit off                                     │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Give a peer 50 GB a month, then enforce the quotas (e.g., from cron every 5 min):   │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -pr AAAAAAAAAAAAA= -quota 50gb -quota-period 30d                  │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -enforce-quotas                                                   │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Delete expired peers (e.g., from cron):                                             │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -prune                                                            │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Update the peers whose endpoint host name resolves to a new address:                │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -pr AAAAAAAAAAAAA= -a 10.0.0.1/32 -eh vpn.example.com:51820       │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -refresh-endpoints                                                │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Remove everything associated with a network interface:                              │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -purge -dry                                                       │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -purge                                                            │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Adopt a network interface brought up by wg-quick:                                   │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -adopt -dry                                                       │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -adopt                                                            │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Add a client and write its configuration and QR code:                               │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -provision -name alice -eh vpn.example.com -out alice.conf        │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -provision -name bob -eh vpn.example.com -qr bob.png              │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Set or remove DNS servers of network interface:                                     │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -dns 10.10.10.1,10.10.10.2 -a                                     │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -dns 10.10.10.1,10.10.10.2 -d                                     │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│   Add IP address for network interface:                                               │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -ip 10.10.10.254/24 -a                                            │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -ip 10.10.10.254/24,fd00::1/64 -a                                 │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│   Delete IP address of network interface:                                             │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -ip 10.10.10.254/24 -d                                            │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Compare the state file with the system, then apply the changes:                     │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -reconcile /etc/brgnetuse/state.json                                     │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -reconcile /etc/brgnetuse/state.json -apply                              │")
+	fmt.Fprintln(os.Stderr, "│     gen-state | brgsetwg -reconcile - -apply                                          │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Check files offline (nothing runs on the system), e.g., in CI:                      │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -validate peers.csv                                                      │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -validate /etc/wireguard/wg0.conf                                        │")
+	fmt.Fprintln(os.Stderr, "│     gen-state | brgsetwg -validate - -format json                                     │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Restore a backup on a new host, then set the preshared keys again:                  │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -restore-backup /root/vpn.backup                                         │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -restore-backup /root/vpn.backup -key-file /root/backup.pass -force      │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Report the results as JSON on stdout, for automation:                               │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -js -i wg0 -ip 10.10.10.0/24 -a -n                                       │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Changes already in place are reported as unchanged, -strict fails instead:          │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -ip 10.10.10.254/24 -a -strict                                    │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -fw4 -a -strict                                                          │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│   Adding NAT rules to the active default network interface:                           │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -ip 10.10.10.0/24 -a -n                                           │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│   Adding NAT rules by network interface name:                                         │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -ip 10.10.10.0/24 -a -n enp0s3                                    │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Adding NAT rules to several uplinks (e.g., fibre and LTE failover):                 │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -ip 10.10.10.0/24 -a -n eth0,wwan0                                │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Adding NAT rules to every uplink with a default route:                              │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -ip 10.10.10.0/24 -a -n all                                       │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│   Delete NAT rules for the active default network interface:                          │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -ip 10.10.10.0/24 -d -n                                           │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
@@ -197,6 +533,10 @@ func BridgeSetWgHelp() {
 	fmt.Fprintln(os.Stderr, "│   Delete Firewall rules by network interface name:                                    │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -ip 10.10.10.0/24 -d -fr enp0s3                                   │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Hairpin NAT of the subnet of the clients:                                           │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -hairpin -a 10.10.10.0/24                                         │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -i wg0 -hairpin -d 10.10.10.0/24                                         │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│   Forwarding `IPV4` between network interfaces:                                       │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -fw4 -a                                                                  │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -fw4 -d                                                                  │")
@@ -207,10 +547,16 @@ func BridgeSetWgHelp() {
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│   Command to add a UDP port rule to the firewall:                                     │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -fr -u -a 51820                                                          │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -fr -u -a 51820 -in eth0                                                 │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│   Command to drop a UDP port rule in the firewall:                                    │")
 	fmt.Fprintln(os.Stderr, "│     brgsetwg -fr -u -d 51820                                                          │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
+	fmt.Fprintln(os.Stderr, "│   Save the rules (default /etc/iptables/brgnetuse.rules), restore them at boot:       │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -fr -save -unit                                                          │")
+	fmt.Fprintln(os.Stderr, "│     brgsetwg -fr -restore                                                             │")
+	fmt.Fprintln(os.Stderr, "│     cat brgnetuse.rules | brgsetwg -fr -restore -                                     │")
+	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│                                                                                       │")
 	fmt.Fprintln(os.Stderr, "│  Useful commands:                                                                     │")
 	fmt.Fprintln(os.Stderr, "|  ___________________________________________________________________________________  |")
@@ -270,17 +616,87 @@ func BridgeGetWgHelp() {
 	fmt.Fprintln(os.Stderr, "│        iptables, ip, and awg.                                        │")
 	fmt.Fprintln(os.Stderr, "│                                                                      │")
 	fmt.Fprintln(os.Stderr, "│    [-h]           Help.                                              │")
+	fmt.Fprintln(os.Stderr, "│    [-V]           Version and build info.                            │")
+	fmt.Fprintln(os.Stderr, "│    [-netns][name] Run in the network namespace (any position).       │")
+	fmt.Fprintln(os.Stderr, "│    [-strict]      Fail on the views requiring root (peers, rules)    │")
+	fmt.Fprintln(os.Stderr, "│                   instead of skipping them unprivileged.             │")
+	fmt.Fprintln(os.Stderr, "│    [-g][prefix]   Run an [-i] command on each WireGuard interface    │")
+	fmt.Fprintln(os.Stderr, "│                   named prefix*, or give [-i] a pattern ('wg-*').    │")
 	fmt.Fprintln(os.Stderr, "│    |_[-i][name]   Wireguard network interface name.                  │")
 	fmt.Fprintln(os.Stderr, "│    |   |_[-ip]    Get IP settings for a network interface.           │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-dns]   Get DNS servers and backend of a network interface.│")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-st]    Get the implementation and last change of an       │")
+	fmt.Fprintln(os.Stderr, "│    |   |          interface.                                         │")
 	fmt.Fprintln(os.Stderr, "│    |   |_[-pr]    Get peer settings for a network interface.         │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-k][key]   Filter by public key or unique prefix.      │")
+	fmt.Fprintln(os.Stderr, "│    |       |             Alone, show every detail of the peer.       │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-e][addr]  Filter by endpoint (ip or ip:port).         │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-sort][by] Sort: handshake, rx, tx, ip or key.         │")
+	fmt.Fprintln(os.Stderr, "│    |           |_[-r]    Reverse the sort order.                     │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-limit][n]  Show at most n peers, by key unless -sort. │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-offset][n] Skip the first n peers.                    │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-w][sec]   Refresh every sec seconds until Ctrl-C.     │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-js]       JSON array of the peers, not with -w.       │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-check] Health check, exit 0 ok, 1 degraded, 2 critical.   │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-max-handshake][sec] Max handshake age, def. 180.      │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-min-peers][n]       Minimum peers, def. 1.            │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-ignore-new]         Skip never handshaked peers.      │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-watch-events] Report peers going up or down until Ctrl-C. │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-exec][path]      Run a hook on each event, with       │")
+	fmt.Fprintln(os.Stderr, "│    |       |                    BRG_EVENT (up|down), BRG_PEER_KEY,   │")
+	fmt.Fprintln(os.Stderr, "│    |       |                    BRG_PEER_IP and BRG_IFACE.           │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-w][sec]          Sampling interval, def. 5.           │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-down-after][sec] Down after sec without handshake,    │")
+	fmt.Fprintln(os.Stderr, "│    |       |                    def. 3 keepalives or 180.            │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-debounce][sec]   Time a change must hold, def. 0.     │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-client][cidr] Client configuration of an address.         │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-eh][host[:port]]  Server endpoint, def. listen port.  │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-dns][servers]     DNS servers, comma separated.       │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-allowed][cidrs]   Routed networks, def. all traffic.  │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-kp][sec]          Persistent keepalive.               │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-pk][key]          Client key, '-' stdin, def. new.    │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-name][name]       Client interface name.              │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-format][fmt]      wgquick (def.), nm or mikrotik.     │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-template][path]   Custom text/template file.          │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-qr]               Print as a QR code (qrencode).      │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-probe] RTT and loss to the endpoint of a peer (ICMP).     │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-k][key]   Public key or unique prefix of the peer.    │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-c][n]     Number of probes, def. 3.                   │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-udp]      UDP to a closed port, when ping is blocked. │")
 	fmt.Fprintln(os.Stderr, "│    |                                                                 │")
 	fmt.Fprintln(os.Stderr, "│    |_[-ip]        Get all IP settings for all network interfaces.    │")
 	fmt.Fprintln(os.Stderr, "│    |_[-pr]        Get all peer settings for all network interfaces.  │")
 	fmt.Fprintln(os.Stderr, "│    [_[-fw]        Get IPv4 and IPv6 forwarding settings.             │")
 	fmt.Fprintln(os.Stderr, "│    |_[-fr]        Get all firewall rules.                            │")
 	fmt.Fprintln(os.Stderr, "│    |_[-n]         Get all NAT rules.                                 │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-i][name]   Only rules of an interface (-fr or -n).        │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-s][subnet] Only rules of a source subnet (-fr or -n).     │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-limit][n]  Show at most n rules, with a 'showing' footer. │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-offset][n] Skip the first n rules.                        │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-w][sec]  Refresh -pr, -fr or -n every sec seconds.        │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-js]        JSON, with the lines the parser skipped.       │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-counters]  Traffic counters of the managed rules:         │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[subnet]     MASQUERADE rules of a subnet (-n).         │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-i][name]   FORWARD rules of an interface (-fr).       │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-w][sec]    Sample twice sec apart, print the rates.   │")
 	fmt.Fprintln(os.Stderr, "│    |                                                                 │")
 	fmt.Fprintln(os.Stderr, "│    |_[-pk]        Generate Public and Private Keys (Base64 encoded). │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-o][dir]     Write keys to files, print the public key.    │")
+	fmt.Fprintln(os.Stderr, "│    |       |_[-force]  Overwrite existing key files.                 │")
+	fmt.Fprintln(os.Stderr, "│    |_[-pub][key]  Print the public key of a private key, '-' stdin.  │")
+	fmt.Fprintln(os.Stderr, "│    |_[-sockets]   List UAPI sockets and their owning processes.      │")
+	fmt.Fprintln(os.Stderr, "│    |_[-caps]      Show the capabilities of the process and the views │")
+	fmt.Fprintln(os.Stderr, "│                   they allow (CAP_NET_ADMIN, CAP_NET_RAW).           │")
+	fmt.Fprintln(os.Stderr, "│    |_[-owner][ip] Find the network interfaces holding an address.    │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-contains] Also the interfaces whose prefix contains it.   │")
+	fmt.Fprintln(os.Stderr, "│    |_[-backup][file] Encrypted backup of the keys, peers, metadata.  │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-key-file][path] Passphrase file. Default: prompt, twice.  │")
+	fmt.Fprintln(os.Stderr, "│    |   |_[-force]  Overwrite an existing file.                       │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│  Environment, defaults of the flags (a flag given wins):             │")
+	fmt.Fprintln(os.Stderr, "│    BRGNETUSE_INTERFACE  Network interface of -dns, -st, -check,      │")
+	fmt.Fprintln(os.Stderr, "│                         -watch-events and -client,                   │")
+	fmt.Fprintln(os.Stderr, "│                         e.g., `brggetwg -check`.                     │")
 	fmt.Fprintln(os.Stderr, "│                                                                      │")
 	fmt.Fprintln(os.Stderr, "│  Example:                                                            │")
 	fmt.Fprintln(os.Stderr, "|  __________________________________________________________________  |")
@@ -290,6 +706,39 @@ func BridgeGetWgHelp() {
 	fmt.Fprintln(os.Stderr, "│                                                                      │")
 	fmt.Fprintln(os.Stderr, "│   Get peer settings for a network interface:                         │")
 	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -pr                                              │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -pr -k xTIBA5rb                                  │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -pr -e 192.0.2.1:51820                           │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -pr -sort handshake -r                           │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -pr -limit 50 -offset 100                        │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -pr -w 2                                         │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -pr -sort rx -js                                 │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -netns blue -i wg0 -pr                                  │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Fail instead of skipping the rules when not run as root:           │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -fr -strict                                             │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Get DNS servers of a network interface:                            │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -dns                                             │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Show the implementation and last change of a network interface:    │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -st                                              │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Check the health of a network interface:                           │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -check -max-handshake 300 -ignore-new            │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -g wg-cust -check                                       │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Run a hook when a peer goes up or down:                            │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -watch-events -exec /etc/wireguard/peer-event.sh │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -watch-events -down-after 120 -debounce 10       │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Render the configuration of a client, with a new key pair:         │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -client 10.10.10.2/32 -eh vpn.example.com        │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -client 10.10.10.3/32 -eh 203.0.113.1 -format nm │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -client 10.10.10.4/32 -eh 203.0.113.1 -kp 25 -qr │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Measure the latency to the endpoint of a peer:                     │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -probe -k xTIBA5rb -c 5                          │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -i wg0 -probe -k xTIBA5rb -udp                          │")
 	fmt.Fprintln(os.Stderr, "│                                                                      │")
 	fmt.Fprintln(os.Stderr, "│   Get all IP settings for all network interfaces:                    │")
 	fmt.Fprintln(os.Stderr, "│     brggetwg -ip                                                     │")
@@ -303,11 +752,79 @@ func BridgeGetWgHelp() {
 	fmt.Fprintln(os.Stderr, "│   Get all firewall rules:                                            │")
 	fmt.Fprintln(os.Stderr, "│     brggetwg -fr                                                     │")
 	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Find the network interface holding an address:                     │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -owner 10.10.10.254                                     │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -owner 10.10.10.9 -contains                             │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Is traffic NATed for a subnet, through an interface:               │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -n -counters 10.10.10.0/24 -w 5                         │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -fr -counters -i wg0                                    │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
 	fmt.Fprintln(os.Stderr, "│   Get all NAT rules:                                                 │")
 	fmt.Fprintln(os.Stderr, "│     brggetwg -n                                                      │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -n -w 5                                                 │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -n -i wg0                                               │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -n -s 10.10.10.0/24                                     │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -n -i wg0 -js                                           │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -fr -i wg0                                              │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -fr -limit 20 -offset 40                                │")
 	fmt.Fprintln(os.Stderr, "│                                                                      │")
 	fmt.Fprintln(os.Stderr, "│   Generate Public and Private Keys (Base64 encoded):                 │")
 	fmt.Fprintln(os.Stderr, "│     brggetwg -pk                                                     │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -pk -o /etc/wireguard/wg0                               │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Derive the public key from a private key:                          │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -pub AAAAAAAAAAAAA=                                     │")
+	fmt.Fprintln(os.Stderr, "│     cat wg0.key | brggetwg -pub -                                    │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Back up the interfaces, restore them with brgsetwg:                │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -backup /root/vpn.backup                                │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -backup /root/vpn.backup -key-file /root/backup.pass    │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   List UAPI sockets and their owning processes:                      │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -sockets                                                │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Show the capabilities of the process (e.g., in a container):       │")
+	fmt.Fprintln(os.Stderr, "│     brggetwg -caps                                                   │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "└──────────────────────────────────────────────────────────────────────┘")
+}
+
+// Function prints a help message to the console for the `brgnet` utility.
+// It lists the subcommands of the multiplexed binary and the utilities
+// they dispatch to.
+func BridgeNetHelp() {
+	fmt.Fprintln(os.Stderr, "┌──────────────────────────────────────────────────────────────────────┐")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│  Help using the utility: brgnet.                                     │")
+	fmt.Fprintln(os.Stderr, "|  __________________________________________________________________  |")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│  NOTE: Single binary bundling all brgnetuse utilities.               │")
+	fmt.Fprintln(os.Stderr, "│        A symlink named after a utility runs it directly.             │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│    [-h]           Help.                                              │")
+	fmt.Fprintln(os.Stderr, "│    [-V]           Version and build info.                            │")
+	fmt.Fprintln(os.Stderr, "│    |_[add]        Run brgaddwg.                                      │")
+	fmt.Fprintln(os.Stderr, "│    |_[addawg]     Run brgaddawg.                                     │")
+	fmt.Fprintln(os.Stderr, "│    |_[set]        Run brgsetwg.                                      │")
+	fmt.Fprintln(os.Stderr, "│    |_[get]        Run brggetwg.                                      │")
+	fmt.Fprintln(os.Stderr, "│    |_[selftest]   Loopback tunnel test of the whole stack, scratch   │")
+	fmt.Fprintln(os.Stderr, "│                   brgst0, brgst1 and netns brgselftest (root).       │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│  Example:                                                            │")
+	fmt.Fprintln(os.Stderr, "|  __________________________________________________________________  |")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Add a network interface name:                                      │")
+	fmt.Fprintln(os.Stderr, "│     brgnet add -i wg0                                                │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Enable network interface:                                          │")
+	fmt.Fprintln(os.Stderr, "│     brgnet set -i wg0 -up                                            │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Check the installation:                                            │")
+	fmt.Fprintln(os.Stderr, "│     brgnet selftest                                                  │")
+	fmt.Fprintln(os.Stderr, "│                                                                      │")
+	fmt.Fprintln(os.Stderr, "│   Busybox-style dispatch:                                            │")
+	fmt.Fprintln(os.Stderr, "│     ln -s /usr/local/bin/brgnet /usr/local/bin/brgsetwg              │")
 	fmt.Fprintln(os.Stderr, "│                                                                      │")
 	fmt.Fprintln(os.Stderr, "└──────────────────────────────────────────────────────────────────────┘")
 }
@@ -321,94 +838,88 @@ var DefaultErrorMessage string = fmt.Sprintf(
 
 // Function for outputting error information to the console.
 func ErrorExitMessage(flag, msg string) {
+	ErrorExit(flag, errors.New(msg))
+}
+
+// Function for outputting an error to the console, followed by its code
+// (see CodeOf), e.g., "error: network interface 'wg9' not found [BRG-E003]".
+func ErrorExit(flag string, err error) {
 	if flag != "" {
 		fmt.Printf("error: invalid input parameter: '%s'\n", flag)
 	}
-	fmt.Printf("%s\n", msg)
+	fmt.Printf("%s [%s]\n", err, CodeOf(err))
 }
 
-// Function to check for a valid WireGuard interface name.
-func WgInterfaceNameValid(flag, name string) string {
-	var msg string
-
-	if strings.ContainsAny(name, RegexSymbols) {
-		msg = fmt.Sprintf(
-			"error: invalid character in interface name '%s'. Example: wg0, wg1",
+// Function checks that a network interface name is free for a new device.
+//
+// An existing interface is refused with its type in the message. With
+// force, an existing WireGuard or AmneziaWG device managed by brgnetuse
+// is accepted and returned, so the caller can recreate it
+// (see set.RemoveInterface); a foreign interface is still refused.
+func WgInterfaceAvailable(name string, force bool) (get.InterfaceOwner, error) {
+	owner, err := get.GetInterfaceOwner(name)
+	if err != nil {
+		return owner, fmt.Errorf(
+			"error: failed getting network interfaces '%s', %w",
 			name,
+			err,
 		)
-		ErrorExitMessage(flag, msg)
-		os.Exit(ExitSetupFailed)
 	}
 
-	result, err := get.GetExistInterface(name)
-	if err != nil {
-		ErrorExitMessage(
-			EnableWgInterfaceFlag,
-			fmt.Sprintf(
-				"error: failed getting network interfaces '%s', %v",
-				name,
-				err,
-			))
-		os.Exit(ExitSetupFailed)
-
-	}
-	if result {
-		ErrorExitMessage(
-			WgInterfaceFlag,
-			fmt.Sprintf(
-				"error: network interface name '%s' already exists",
-				name,
-			),
+	switch {
+	case !owner.Exists:
+		return owner, nil
+	case !owner.Managed():
+		return owner, Errorf(
+			CodeInterfaceExists,
+			"error: network interface name '%s' already exists (%s), "+
+				"it is not managed by brgnetuse and cannot be recreated",
+			name,
+			owner.Describe(),
+		)
+	case !force:
+		return owner, Errorf(
+			CodeInterfaceExists,
+			"error: network interface name '%s' already exists (%s), "+
+				"pass '%s' to recreate it",
+			name,
+			owner.Describe(),
+			ForceFlag,
 		)
-		os.Exit(ExitSetupFailed)
 	}
-	return name
+
+	return owner, nil
 }
 
 // Function to check for a valid WireGuard interface name.
 func PortValid(flag, port string) string {
 	re := regexp.MustCompile(`^\d+$`)
 	if strings.ContainsAny(port, RegexSymbols) || !re.MatchString(port) {
-		msg := fmt.Sprintf(
+		ErrorExit(flag, Errorf(
+			CodeInvalidValue,
 			"error: port must not contain symbols '%s', example: 51820, 51821",
 			port,
-		)
-		ErrorExitMessage(flag, msg)
+		))
 		os.Exit(ExitSetupFailed)
 	}
 
-	_, err := handlers.CheckPort(port)
+	_, err := validate.CheckPort(port)
 	if err != nil {
-		ErrorExitMessage(flag, err.Error())
+		ErrorExit(flag, WithCode(CodeInvalidValue, err))
 		os.Exit(ExitSetupFailed)
 	}
 	return port
 }
 
-// Function for checking the validity of WireGuard port range.
-func PathLogDirValid(flag, path string) string {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		msg := fmt.Sprintf(
-			"error: `%s` does not exist",
-			path,
-		)
-		ErrorExitMessage(flag, msg)
-		os.Exit(ExitSetupFailed)
-	}
-	return path
-}
-
 // Function to check IP address.
 func IpAddressValid(flag, address string) (net.IP, *net.IPNet) {
 	ip, ipnet, err := net.ParseCIDR(address)
 	if err != nil {
-		ErrorExitMessage(
-			flag,
-			fmt.Sprintf(
-				"error: invalid IP address format '%s' example: 10.10.10.1/24",
-				address,
-			),
-		)
+		ErrorExit(flag, Errorf(
+			CodeInvalidValue,
+			"error: invalid IP address format '%s' example: 10.10.10.1/24",
+			address,
+		))
 		os.Exit(ExitSetupFailed)
 	}
 
@@ -416,38 +927,14 @@ func IpAddressValid(flag, address string) (net.IP, *net.IPNet) {
 }
 
 // Function scans all running processes to determine if any process
-// has a specific environment variable (tag) set to a given value.
-// It returns true if such a process is found, otherwise false.
+// serves the network interface tag with the given type.
+// Processes of another namespace (see proc.EnvNamespace) are ignored.
 // An error is returned only if there's a problem reading the /proc directory.
 func CheckProcessTagExists(tag, wgType string) (bool, error) {
-
-	valueTag := fmt.Sprintf("%s=%s", Env_Field_Tag, tag)
-	valueType := fmt.Sprintf("%s=%s", Env_Field_Type, wgType)
-
-	dirs, err := os.ReadDir("/proc")
+	pid, processType, err := proc.FindProcess(tag)
 	if err != nil {
-		return false, fmt.Errorf("error: could not read directory /proc: %w", err)
-	}
-
-	for _, subdir := range dirs {
-		pid, err := strconv.Atoi(subdir.Name())
-		if err != nil {
-			continue
-		}
-
-		fmtEnvPath := fmt.Sprintf("/proc/%d/environ", pid)
-		environContent, err := os.ReadFile(fmtEnvPath)
-		if err != nil {
-			continue
-		}
-
-		envStr := string(environContent)
-
-		if strings.Contains(envStr, valueTag) && strings.Contains(envStr, valueType) {
-			return true, nil
-		}
-
+		return false, err
 	}
 
-	return false, nil
+	return pid != 0 && processType == wgType, nil
 }
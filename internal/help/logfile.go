@@ -0,0 +1,131 @@
+package help
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Permissions of the log files and directories of brgaddwg and brgaddawg.
+// Log files may contain peer endpoints and traffic metadata, so they are
+// not readable by other users by default.
+const (
+	DefaultLogFileMode os.FileMode = 0o640
+	DefaultLogDirMode  os.FileMode = 0o750
+)
+
+// Environment variables set by sudo to the user who invoked it.
+const (
+	envSudoUid string = "SUDO_UID"
+	envSudoGid string = "SUDO_GID"
+)
+
+// Function parses the octal permissions of the log file (e.g., "0600").
+func ParseLogFileMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, Errorf(
+			CodeInvalidValue,
+			"error: invalid log file mode '%s', expected octal permissions (e.g. 0640)",
+			value,
+		)
+	}
+	return os.FileMode(mode), nil
+}
+
+// Function checks that path is a directory the log files can be written
+// to, creating a missing one with DefaultLogDirMode (subject to the umask).
+// Write access is checked by creating and removing a temporary file, so a
+// background process does not fail later on a read-only directory.
+func CheckLogDir(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if err := os.MkdirAll(path, DefaultLogDirMode); err != nil {
+			return Errorf(
+				CodeFileFailed,
+				"error: log directory `%s` does not exist and could not be created, %v",
+				path,
+				err,
+			)
+		}
+		info, err = os.Stat(path)
+	}
+	if err != nil {
+		return Errorf(CodeFileFailed, "error: failed to check log directory `%s`, %v", path, err)
+	}
+
+	if !info.IsDir() {
+		return Errorf(CodeFileFailed, "error: log directory `%s` is not a directory", path)
+	}
+
+	probe, err := os.CreateTemp(path, ".brgnetuse-write-check-*")
+	if err != nil {
+		return Errorf(CodeFileFailed, "error: log directory `%s` is not writable, %v", path, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	return nil
+}
+
+// Function opens the log file of the network interface in dir for appending.
+//
+// With mode 0, a new file is created with DefaultLogFileMode, subject to the
+// umask, and an existing world-writable file loses that permission.
+// Otherwise the mode is applied as is, also to an existing file.
+// With chown, the file is given to the user who invoked the tool through sudo.
+func OpenLogFile(dir, iface string, mode os.FileMode, chown bool) (*os.File, error) {
+	path := filepath.Join(dir, iface+".log")
+
+	createMode := mode
+	if createMode == 0 {
+		createMode = DefaultLogFileMode
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, createMode)
+	if err != nil {
+		return nil, Errorf(CodeFileFailed, "error: failed to create logfile, %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, Errorf(CodeFileFailed, "error: failed to create logfile, %v", err)
+	}
+
+	perm := info.Mode().Perm()
+	switch {
+	case mode != 0 && perm != mode:
+		err = file.Chmod(mode)
+	case mode == 0 && perm&0o002 != 0:
+		err = file.Chmod(perm &^ 0o002)
+	}
+	if err != nil {
+		file.Close()
+		return nil, Errorf(CodeFileFailed, "error: failed to set logfile mode, %v", err)
+	}
+
+	if chown {
+		if err := chownSudoUser(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+// Function gives the file to the user who invoked the tool through sudo.
+// Without sudo, the file keeps its owner.
+func chownSudoUser(file *os.File) error {
+	uid, errUid := strconv.Atoi(os.Getenv(envSudoUid))
+	gid, errGid := strconv.Atoi(os.Getenv(envSudoGid))
+	if errUid != nil || errGid != nil || os.Geteuid() != 0 {
+		return nil
+	}
+
+	if err := file.Chown(uid, gid); err != nil {
+		return Errorf(CodeFileFailed, "error: failed to give logfile to user %d, %v", uid, err)
+	}
+	return nil
+}
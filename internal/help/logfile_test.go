@@ -0,0 +1,215 @@
+//go:build !windows
+
+package help
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// Function sets the process umask for the test.
+func useUmask(t *testing.T, mask int) {
+	t.Helper()
+
+	prev := syscall.Umask(mask)
+	t.Cleanup(func() { syscall.Umask(prev) })
+}
+
+// Testing the permission bits of the log files opened by OpenLogFile.
+func TestOpenLogFile(t *testing.T) {
+	type testCase struct {
+		name     string
+		umask    int
+		existing os.FileMode
+		mode     os.FileMode
+		want     os.FileMode
+	}
+
+	tests := []testCase{
+		{name: "default", umask: 0o022, want: 0o640},
+		{name: "default strict umask", umask: 0o077, want: 0o600},
+		{name: "default loose umask", umask: 0o000, want: 0o640},
+		{name: "existing world-writable", umask: 0o022, existing: 0o666, want: 0o664},
+		{name: "existing kept", umask: 0o022, existing: 0o644, want: 0o644},
+		{name: "override", umask: 0o022, mode: 0o600, want: 0o600},
+		{name: "override ignores umask", umask: 0o077, mode: 0o644, want: 0o644},
+		{name: "override existing", umask: 0o022, existing: 0o666, mode: 0o600, want: 0o600},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useUmask(t, tc.umask)
+			dir := t.TempDir()
+
+			if tc.existing != 0 {
+				path := filepath.Join(dir, "wg0.log")
+				if err := os.WriteFile(path, nil, 0o600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chmod(path, tc.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			file, err := OpenLogFile(dir, "wg0", tc.mode, false)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			defer file.Close()
+
+			info, err := os.Stat(filepath.Join(dir, "wg0.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode().Perm(); got != tc.want {
+				t.Errorf("error: got mode %04o, want %04o", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing that the log file is given to the user running sudo.
+func TestOpenLogFileChown(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the file owner requires root")
+	}
+
+	t.Setenv(envSudoUid, "65534")
+	t.Setenv(envSudoGid, "65533")
+
+	file, err := OpenLogFile(t.TempDir(), "wg0", 0, true)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	if stat.Uid != 65534 || stat.Gid != 65533 {
+		t.Errorf("error: got owner %d:%d, want 65534:65533", stat.Uid, stat.Gid)
+	}
+
+	// Without sudo, the owner is kept.
+	t.Setenv(envSudoUid, "")
+	other, err := OpenLogFile(t.TempDir(), "wg1", 0, true)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	defer other.Close()
+
+	info, err = other.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := info.Sys().(*syscall.Stat_t).Uid; int(uid) != os.Geteuid() {
+		t.Errorf("error: got owner %d, want %d", uid, os.Geteuid())
+	}
+}
+
+// Testing the ParseLogFileMode function.
+func TestParseLogFileMode(t *testing.T) {
+	type testCase struct {
+		value     string
+		want      os.FileMode
+		wantError bool
+	}
+
+	tests := []testCase{
+		{value: "0640", want: 0o640},
+		{value: "600", want: 0o600},
+		{value: "0", want: 0},
+		{value: "0777", want: 0o777},
+		{value: "1777", wantError: true},
+		{value: "0680", wantError: true},
+		{value: "rw-r-----", wantError: true},
+		{value: "", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := ParseLogFileMode(tc.value)
+			if tc.wantError {
+				if err == nil {
+					t.Errorf("error: expected an error for %q, got mode %04o", tc.value, got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("error: got %04o, %v, want %04o", got, err, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the CheckLogDir function on missing, invalid and read-only
+// directories.
+func TestCheckLogDir(t *testing.T) {
+	useUmask(t, 0o022)
+	root := t.TempDir()
+
+	file := filepath.Join(root, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	readOnly := filepath.Join(root, "read-only")
+	if err := os.Mkdir(readOnly, 0o555); err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		name      string
+		path      string
+		needUser  bool
+		wantError string
+	}
+
+	tests := []testCase{
+		{name: "existing", path: root},
+		{name: "missing", path: filepath.Join(root, "log", "brgnetuse")},
+		{
+			name:      "missing not creatable",
+			path:      filepath.Join(file, "log"),
+			wantError: "does not exist and could not be created",
+		},
+		{name: "not a directory", path: file, wantError: "is not a directory"},
+		{name: "not writable", path: readOnly, needUser: true, wantError: "is not writable"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.needUser && os.Geteuid() == 0 {
+				t.Skip("root bypasses directory permissions")
+			}
+
+			err := CheckLogDir(tc.path)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Errorf("error: got %v, want an error containing %q", err, tc.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			info, err := os.Stat(tc.path)
+			if err != nil || !info.IsDir() {
+				t.Fatalf("error: log directory missing: %v", err)
+			}
+			if tc.path != root && info.Mode().Perm() != 0o750 {
+				t.Errorf("error: got mode %04o, want 0750", info.Mode().Perm())
+			}
+
+			entries, err := os.ReadDir(tc.path)
+			if err != nil || len(entries) != 0 && tc.path != root {
+				t.Errorf("error: write check left %d entries behind, %v", len(entries), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package help
+
+import (
+	"errors"
+
+	"github.com/AlexKira/brgnetuse/internal/shell"
+)
+
+// Function removes the global `-netns name` flag from the arguments, given
+// at any position, and returns the network namespace, empty without it.
+func StripNetnsFlag(args []string) ([]string, string, error) {
+	name := ""
+	rest := make([]string, 0, len(args))
+	for indx := 0; indx < len(args); indx++ {
+		if indx == 0 || args[indx] != NetnsFlag {
+			rest = append(rest, args[indx])
+			continue
+		}
+		if indx+1 >= len(args) {
+			return args, "", Errorf(
+				CodeInvalidArguments,
+				"error: please provide the network namespace name (e.g. '%s blue')", NetnsFlag,
+			)
+		}
+		if name != "" {
+			return args, "", errors.New(DefaultErrorMessage)
+		}
+		indx++
+		name = args[indx]
+	}
+	return rest, name, nil
+}
+
+// Function applies the global -netns flag of the arguments: every
+// operation of the utility runs inside the network namespace, see
+// shell.UseNamespace. It returns the arguments without the flag.
+func ApplyNetnsFlag(args []string) ([]string, error) {
+	rest, name, err := StripNetnsFlag(args)
+	if err != nil || name == "" {
+		return rest, err
+	}
+	return rest, WithDefaultCode(CodeInvalidValue, shell.UseNamespace(name))
+}
@@ -0,0 +1,65 @@
+package help
+
+import (
+	"slices"
+	"testing"
+)
+
+// Testing that the global -netns flag is removed at any position.
+func TestStripNetnsFlag(t *testing.T) {
+	type testCase struct {
+		name      string
+		args      []string
+		want      []string
+		wantName  string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{
+			name: "absent",
+			args: []string{"brggetwg", "-i", "wg0", "-pr"},
+			want: []string{"brggetwg", "-i", "wg0", "-pr"},
+		},
+		{
+			name:     "first",
+			args:     []string{"brgsetwg", "-netns", "blue", "-i", "wg0", "-up"},
+			want:     []string{"brgsetwg", "-i", "wg0", "-up"},
+			wantName: "blue",
+		},
+		{
+			name:     "last",
+			args:     []string{"brgaddwg", "-i", "wg0", "-l", "/var/log", "-ld", "-netns", "blue"},
+			want:     []string{"brgaddwg", "-i", "wg0", "-l", "/var/log", "-ld"},
+			wantName: "blue",
+		},
+		{
+			name:      "missing name",
+			args:      []string{"brggetwg", "-i", "wg0", "-netns"},
+			wantError: true,
+		},
+		{
+			name:      "given twice",
+			args:      []string{"brggetwg", "-netns", "blue", "-netns", "red", "-i", "wg0"},
+			wantError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, name, err := StripNetnsFlag(tc.args)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tc.want) || name != tc.wantName {
+				t.Errorf("error: got %v and %q, want %v and %q", got, name, tc.want, tc.wantName)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package help
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/version"
+	"github.com/AlexKira/brgnetuse/src/command"
+)
+
+// Module paths of the runtime dependencies reported by the version flag.
+const (
+	modWireguardGo  string = "golang.zx2c4.com/wireguard"
+	modAmneziawgGo  string = "github.com/amnezia-vpn/amneziawg-go"
+	modWgctrl       string = "golang.zx2c4.com/wireguard/wgctrl"
+	iptablesVersion string = "iptables --version"
+)
+
+// BuildInfo describes the build of a utility and the versions of the
+// runtime dependencies it was linked with.
+type BuildInfo struct {
+	Utility     string
+	Version     string
+	Commit      string
+	BuildDate   string
+	GoVersion   string
+	WireguardGo string
+	AmneziawgGo string
+	Wgctrl      string
+	Iptables    string
+}
+
+// Function collects the build information of the running binary
+// (see version.Get) and the versions of its runtime dependencies, reported
+// as "none" when not linked.
+func ReadBuildInfo(utility string) BuildInfo {
+	build := version.Get()
+	info := BuildInfo{
+		Utility:     utility,
+		Version:     build.Version,
+		Commit:      build.Commit,
+		BuildDate:   build.BuildDate,
+		GoVersion:   build.GoVersion,
+		WireguardGo: "none",
+		AmneziawgGo: "none",
+		Wgctrl:      "none",
+		Iptables:    "none",
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range bi.Deps {
+			switch dep.Path {
+			case modWireguardGo:
+				info.WireguardGo = dep.Version
+			case modAmneziawgGo:
+				info.AmneziawgGo = dep.Version
+			case modWgctrl:
+				info.Wgctrl = dep.Version
+			}
+		}
+	}
+
+	if output, err := command.Output(iptablesVersion); err == nil {
+		info.Iptables = strings.TrimSpace(output.String())
+	}
+
+	return info
+}
+
+// Method writes the build information as `key: value` lines.
+// The keys and their order are stable and safe to parse from scripts.
+func (p BuildInfo) Write(w io.Writer) error {
+	lines := [][2]string{
+		{"utility", p.Utility},
+		{"version", p.Version},
+		{"commit", p.Commit},
+		{"build_date", p.BuildDate},
+		{"go", p.GoVersion},
+		{"wireguard_go", p.WireguardGo},
+		{"amneziawg_go", p.AmneziawgGo},
+		{"wgctrl", p.Wgctrl},
+		{"iptables", p.Iptables},
+	}
+
+	for _, line := range lines {
+		value := strings.Join(strings.Fields(line[1]), " ")
+		if value == "" {
+			value = "none"
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", line[0], value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Function reports whether the argument requests the version information.
+func IsVersionFlag(arg string) bool {
+	return arg == VersionFlag || arg == VersionLongFlag
+}
+
+// Function prints the build information of the utility to stdout.
+func PrintVersion(utility string) {
+	if err := ReadBuildInfo(utility).Write(os.Stdout); err != nil {
+		ErrorExitMessage(VersionFlag, fmt.Sprintf("error: %v", err))
+		os.Exit(ExitSetupFailed)
+	}
+}
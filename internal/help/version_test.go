@@ -0,0 +1,83 @@
+package help
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Testing that the version output keeps its stable `key: value` format.
+func TestBuildInfoWrite(t *testing.T) {
+	type testCase struct {
+		name string
+		info BuildInfo
+		want string
+	}
+
+	tests := []testCase{
+		{
+			name: "full",
+			info: BuildInfo{
+				Utility:     "brgaddwg",
+				Version:     "1.2.0",
+				Commit:      "4f2c1e0",
+				BuildDate:   "2025-06-01T10:00:00Z",
+				GoVersion:   "go1.24.4",
+				WireguardGo: "v0.0.0-20250521234502-f333402bd9cb",
+				AmneziawgGo: "none",
+				Wgctrl:      "v0.0.0-20241231184526-a9ab2273dd10",
+				Iptables:    "iptables v1.8.7 (nf_tables)",
+			},
+			want: "utility: brgaddwg\n" +
+				"version: 1.2.0\n" +
+				"commit: 4f2c1e0\n" +
+				"build_date: 2025-06-01T10:00:00Z\n" +
+				"go: go1.24.4\n" +
+				"wireguard_go: v0.0.0-20250521234502-f333402bd9cb\n" +
+				"amneziawg_go: none\n" +
+				"wgctrl: v0.0.0-20241231184526-a9ab2273dd10\n" +
+				"iptables: iptables v1.8.7 (nf_tables)\n",
+		},
+		{
+			name: "empty and multiline values",
+			info: BuildInfo{Utility: "brggetwg", Iptables: "iptables v1.8.7\n(legacy)\n"},
+			want: "utility: brggetwg\n" +
+				"version: none\n" +
+				"commit: none\n" +
+				"build_date: none\n" +
+				"go: none\n" +
+				"wireguard_go: none\n" +
+				"amneziawg_go: none\n" +
+				"wgctrl: none\n" +
+				"iptables: iptables v1.8.7 (legacy)\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tc.info.Write(&buf); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if buf.String() != tc.want {
+				t.Errorf("error: got output:\n%s\nwant:\n%s", buf.String(), tc.want)
+			}
+		})
+	}
+}
+
+// Testing that ReadBuildInfo never leaves fields empty.
+func TestReadBuildInfo(t *testing.T) {
+	var buf bytes.Buffer
+	if err := ReadBuildInfo("brgsetwg").Write(&buf); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	re := regexp.MustCompile(`^[a-z_]+: \S.*$`)
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if !re.MatchString(line) {
+			t.Errorf("error: line %q is not in `key: value` format", line)
+		}
+	}
+}
@@ -0,0 +1,737 @@
+//go:build !windows
+
+// Package launcher implements the command line shared by brgaddwg and
+// brgaddawg: argument parsing, the background process of the device with
+// its log file, and the -supervise and -force handling. The utilities only
+// differ by their protocol type and the device they start.
+package launcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/middleware"
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/startup"
+	"github.com/AlexKira/brgnetuse/internal/supervise"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/proc"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/spec"
+	"github.com/AlexKira/brgnetuse/src/validate"
+	"golang.org/x/sys/unix"
+)
+
+// Time given to the process of a recreated interface to remove its TUN
+// device before the link is deleted explicitly.
+const recreateLinkWait = 3 * time.Second
+
+// Utility describes an add utility (brgaddwg or brgaddawg).
+type Utility struct {
+	// Name of the utility, also the logger name of its devices.
+	Name string
+
+	// Type of the devices (help.Env_Wg_Type or help.Env_Awg_Type).
+	Type string
+
+	// NewDevice starts the device of the configuration and runs it until
+	// the process is told to stop.
+	NewDevice func(cfg *Config) error
+}
+
+// Config represents the device's configuration and operational parameters.
+// It includes interface details, logging settings, and argument parsing context.
+type Config struct {
+	InterfaceName string // Network interface name.
+	LoggerName    string // Logger name.
+	LogLevel      int    // Logging level (0-NULL, 1-ERROR, 2-DEBUG).
+	LoggingJSON   bool   // Flag indicating whether to use JSON format for logging.
+	MTU           int
+	ForceMTU      bool          // Accept an MTU outside the default range.
+	Cleanup       bool          // Remove the recorded rules and addresses on shutdown.
+	Force         bool          // Recreate an existing interface managed by brgnetuse.
+	Alias         string        // Network interface alias (ip link ... alias).
+	Supervise     bool          // Restart the device process when it exits.
+	LogMode       os.FileMode   // Log file permissions, 0 for the default.
+	LogChown      bool          // Give the log file to the user running sudo.
+	LogDedup      time.Duration // Window collapsing repeated JSON log messages.
+	LogEvents     bool          // Classify the JSON log messages by event.
+
+	// Interval of the re-resolution of the peer endpoint host names, 0
+	// disables it (WireGuard only).
+	RefreshEndpoints time.Duration
+
+	// Network namespace the interface is moved into once up (-to-netns),
+	// by name or process ID, see set.MoveInterface.
+	ToNetns string
+
+	ListenPort int  // UDP listen port (-p), a random one when 0.
+	AutoPort   bool // Listen port chosen when the device starts (-p auto).
+	PortRule   bool // Open the listen port in the INPUT chain (-fr).
+
+	// Spec of the interface (-spec), its addresses, forwarding, NAT rules
+	// and peers are applied once the device is up, see Utility.applySpec.
+	Spec *spec.Spec
+
+	PathLogDir  string
+	CurrentFlag string
+	Existing    get.InterfaceOwner // Existing interface recreated with -force.
+}
+
+// Method runs the utility with the process arguments.
+func (u Utility) Main() {
+
+	if len(os.Args) < 2 || os.Args[1] == help.HelpFlag {
+		help.BridgeAddHelp(fmt.Sprintf("%-9s", u.Name))
+		return
+	}
+
+	if help.IsVersionFlag(os.Args[1]) {
+		help.PrintVersion(u.Name)
+		return
+	}
+
+	// The global -netns flag may be given at any position.
+	args, err := help.ApplyNetnsFlag(os.Args)
+	if err != nil {
+		help.ErrorExit(help.NetnsFlag, err)
+		os.Exit(help.ExitSetupFailed)
+	}
+
+	cfg, args, err := u.ParseWithEnv(args, help.Environ())
+	if err != nil {
+		help.ErrorExit(cfg.CurrentFlag, help.WithDefaultCode(help.CodeInvalidValue, err))
+
+		os.Exit(help.ExitSetupFailed)
+	}
+
+	if err := u.Execute(args, cfg); err != nil {
+		help.ErrorExit("", err)
+
+		os.Exit(help.ExitSetupFailed)
+	}
+}
+
+// Method applies the environment defaults to the arguments and parses
+// them with ParseArgs, so a variable is validated as its flag. A flag given
+// on the command line wins: BRGNETUSE_INTERFACE applies without -i or
+// -spec, and BRGNETUSE_LOG_DIR with BRGNETUSE_LOG_LEVEL and BRGNETUSE_JSON
+// without -l. It also returns the arguments with the defaults, they are passed on
+// to the device process.
+func (u Utility) ParseWithEnv(args []string, env map[string]string) (Config, []string, error) {
+	jsonLog, err := help.EnvBool(env, help.Env_Default_JSON)
+	if err != nil {
+		return Config{CurrentFlag: help.LogTypeFlag}, args, err
+	}
+
+	merged := slices.Clone(args)
+	if iface := env[help.Env_Default_Interface]; iface != "" && !slices.Contains(args[1:], help.WgInterfaceFlag) &&
+		!slices.Contains(args[1:], help.SpecFlag) {
+		merged = slices.Concat(merged[:1], []string{help.WgInterfaceFlag, iface}, merged[1:])
+	}
+
+	// The log flags come last, the flags following the level are limited.
+	if dir := env[help.Env_Default_LogDir]; dir != "" && !slices.Contains(args[1:], help.PathLogDirFlag) {
+		merged = append(merged, help.PathLogDirFlag, dir)
+		if level := env[help.Env_Default_LogLevel]; level != "" {
+			merged = append(merged, help.EnvLogLevelFlag(level))
+			if jsonLog {
+				merged = append(merged, help.LogTypeFlag)
+			}
+		}
+	}
+
+	cfg, err := u.ParseArgs(merged)
+	return cfg, merged, err
+}
+
+// Method parses command-line arguments into a Config struct,
+// validating flags and their values, and returns errors for invalid input.
+// args[0] is the program name. Only args is read, never os.Args, and an
+// invalid value is returned with its flag in CurrentFlag, never exits.
+func (u Utility) ParseArgs(args []string) (Config, error) {
+
+	var cfg Config
+	var loggingMap = map[string]int{
+		help.LogInfoFlag:  middleware.LogInfo,
+		help.LogErrorFlag: middleware.LogError,
+	}
+
+	for indx := 1; indx < len(args); indx++ {
+
+		switch args[indx] {
+		case help.WgInterfaceFlag:
+			indx++
+			if indx < len(args) {
+				if err := validate.CheckInterfaceName(args[indx]); err != nil {
+					cfg.CurrentFlag = help.WgInterfaceFlag
+					return cfg, help.WithCode(help.CodeInvalidValue, err)
+				}
+				cfg.InterfaceName = args[indx]
+			} else {
+				cfg.CurrentFlag = help.WgInterfaceFlag
+				return cfg, help.Errorf(
+					help.CodeInvalidArguments,
+					"error: invalid argument passed, pass '%s', "+
+						"followed by a valid WireGuard interface name "+
+						"(e.g. '%s wg0', etc.)",
+					help.WgInterfaceFlag,
+					help.WgInterfaceFlag,
+				)
+			}
+		case help.MTUFlag:
+			indx++
+			if indx < len(args) {
+				mtu, err := strconv.Atoi(args[indx])
+				if err != nil {
+					cfg.CurrentFlag = help.MTUFlag
+					return cfg, fmt.Errorf(
+						"error: invalid MTU number format: '%s'",
+						args[indx],
+					)
+				}
+
+				// The range is checked once -force-mtu may have been seen.
+				cfg.MTU = mtu
+
+			} else {
+				cfg.CurrentFlag = help.MTUFlag
+				return cfg, help.Errorf(
+					help.CodeInvalidArguments,
+					"error: please provide a valid MTU value",
+				)
+			}
+
+		case help.PathLogDirFlag:
+			indx++
+			if indx < len(args) {
+				if err := help.CheckLogDir(args[indx]); err != nil {
+					cfg.CurrentFlag = help.PathLogDirFlag
+					return cfg, help.WithCode(help.CodeInvalidValue, err)
+				}
+				cfg.PathLogDir = args[indx]
+
+				indx++
+				if indx < len(args) {
+					isLogLevel := loggingMap[args[indx]]
+					if isLogLevel == 0 {
+						cfg.CurrentFlag = help.PathLogDirFlag
+
+						return cfg, help.Errorf(
+							help.CodeInvalidValue,
+							"error: logging level not found")
+					}
+
+					cfg.LoggerName = u.Name
+					cfg.LogLevel = isLogLevel
+
+					indx++
+					if indx < len(args) {
+						switch args[indx] {
+						case help.LogTypeFlag:
+							cfg.LoggingJSON = true
+						case help.CleanupFlag, help.ForceFlag, help.AliasFlag, help.SuperviseFlag,
+							help.LogModeFlag, help.LogChownFlag, help.ForceMTUFlag, help.LogDedupFlag, help.LogEventsFlag,
+							help.RefreshEndpointsFlag, help.PortFlag, help.FirewallFlag, help.ToNetnsFlag, help.SpecFlag:
+							// Handled by the next iteration.
+							indx--
+						default:
+							cfg.CurrentFlag = help.LogTypeFlag
+							return cfg, help.Errorf(
+								help.CodeInvalidArguments,
+								"error: logging type is missing",
+							)
+						}
+					}
+				}
+			} else {
+				cfg.CurrentFlag = help.PathLogDirFlag
+				return cfg, help.Errorf(
+					help.CodeInvalidArguments,
+					"error: please provide the path to the log folder",
+				)
+			}
+		case help.CleanupFlag:
+			cfg.Cleanup = true
+		case help.ForceMTUFlag:
+			cfg.ForceMTU = true
+		case help.SuperviseFlag:
+			cfg.Supervise = true
+		case help.LogModeFlag:
+			indx++
+			if indx >= len(args) {
+				cfg.CurrentFlag = help.LogModeFlag
+				return cfg, help.Errorf(
+					help.CodeInvalidArguments,
+					"error: please provide the log file mode (e.g. '-log-mode 0600')",
+				)
+			}
+
+			mode, err := help.ParseLogFileMode(args[indx])
+			if err != nil {
+				cfg.CurrentFlag = help.LogModeFlag
+				return cfg, err
+			}
+			cfg.LogMode = mode
+		case help.LogChownFlag:
+			cfg.LogChown = true
+		case help.LogDedupFlag:
+			indx++
+			if indx >= len(args) {
+				cfg.CurrentFlag = help.LogDedupFlag
+				return cfg, help.Errorf(
+					help.CodeInvalidArguments,
+					"error: please provide the deduplication window in seconds (e.g. '-log-dedup 60')",
+				)
+			}
+
+			seconds, err := strconv.Atoi(args[indx])
+			if err != nil || seconds < 1 {
+				cfg.CurrentFlag = help.LogDedupFlag
+				return cfg, fmt.Errorf(
+					"error: invalid deduplication window '%s', expected a positive number of seconds",
+					args[indx],
+				)
+			}
+			cfg.LogDedup = time.Duration(seconds) * time.Second
+		case help.LogEventsFlag:
+			cfg.LogEvents = true
+		case help.RefreshEndpointsFlag:
+			indx++
+			if indx >= len(args) {
+				cfg.CurrentFlag = help.RefreshEndpointsFlag
+				return cfg, help.Errorf(
+					help.CodeInvalidArguments,
+					"error: please provide the refresh interval in seconds (e.g. '-refresh-endpoints 300')",
+				)
+			}
+
+			seconds, err := strconv.Atoi(args[indx])
+			if err != nil || seconds < 1 {
+				cfg.CurrentFlag = help.RefreshEndpointsFlag
+				return cfg, fmt.Errorf(
+					"error: invalid refresh interval '%s', expected a positive number of seconds",
+					args[indx],
+				)
+			}
+			if u.Type != help.Env_Wg_Type {
+				cfg.CurrentFlag = help.RefreshEndpointsFlag
+				return cfg, help.Errorf(
+					help.CodeUnsupported,
+					"error: '%s' is only supported by WireGuard interfaces",
+					help.RefreshEndpointsFlag,
+				)
+			}
+			cfg.RefreshEndpoints = time.Duration(seconds) * time.Second
+		case help.ForceFlag:
+			cfg.Force = true
+		case help.AliasFlag:
+			indx++
+			if indx >= len(args) {
+				cfg.CurrentFlag = help.AliasFlag
+				return cfg, help.Errorf(
+					help.CodeInvalidArguments,
+					"error: please provide the network interface alias",
+				)
+			}
+
+			alias, err := validate.CheckAlias(args[indx])
+			if err != nil {
+				cfg.CurrentFlag = help.AliasFlag
+				return cfg, err
+			}
+			cfg.Alias = alias
+		case help.PortFlag:
+			indx++
+			if indx >= len(args) {
+				cfg.CurrentFlag = help.PortFlag
+				return cfg, help.Errorf(
+					help.CodeInvalidArguments,
+					"error: please provide the listen port or '%s' (e.g. '-p 51820')",
+					help.AutoPortValue,
+				)
+			}
+
+			if args[indx] == help.AutoPortValue {
+				cfg.AutoPort = true
+				break
+			}
+			port, err := validate.CheckPort(args[indx])
+			if err == nil && (port < 1 || port > 65535) {
+				err = fmt.Errorf("error: port %d is out of valid range (1-65535)", port)
+			}
+			if err != nil {
+				cfg.CurrentFlag = help.PortFlag
+				return cfg, err
+			}
+			cfg.ListenPort = port
+		case help.FirewallFlag:
+			cfg.PortRule = true
+		case help.ToNetnsFlag:
+			indx++
+			if indx >= len(args) {
+				cfg.CurrentFlag = help.ToNetnsFlag
+				return cfg, help.Errorf(
+					help.CodeInvalidArguments,
+					"error: please provide the network namespace name or process ID (e.g. '-to-netns blue')",
+				)
+			}
+
+			if err := netns.CheckTarget(args[indx]); err != nil {
+				cfg.CurrentFlag = help.ToNetnsFlag
+				return cfg, err
+			}
+			cfg.ToNetns = args[indx]
+		case help.SpecFlag:
+			indx++
+			if indx >= len(args) {
+				cfg.CurrentFlag = help.SpecFlag
+				return cfg, help.Errorf(
+					help.CodeInvalidArguments,
+					"error: please provide the path to the spec file (e.g. '-spec wg0.json')",
+				)
+			}
+
+			if u.Type != help.Env_Wg_Type {
+				cfg.CurrentFlag = help.SpecFlag
+				return cfg, help.Errorf(
+					help.CodeUnsupported,
+					"error: '%s' is only supported by WireGuard interfaces",
+					help.SpecFlag,
+				)
+			}
+			s, err := spec.Load(args[indx])
+			if err != nil {
+				cfg.CurrentFlag = help.SpecFlag
+				return cfg, err
+			}
+			cfg.Spec = &s
+		default:
+			cfg.CurrentFlag = args[indx]
+			return cfg, errors.New(help.DefaultErrorMessage)
+		}
+	}
+
+	if err := cfg.mergeSpec(); err != nil {
+		cfg.CurrentFlag = help.SpecFlag
+		return cfg, err
+	}
+
+	if cfg.MTU != 0 {
+		if err := validate.CheckMTU(cfg.MTU, cfg.ForceMTU); err != nil {
+			cfg.CurrentFlag = help.MTUFlag
+			if !cfg.ForceMTU && validate.CheckMTU(cfg.MTU, true) == nil {
+				return cfg, fmt.Errorf("%w, pass '%s' to use it", err, help.ForceMTUFlag)
+			}
+			return cfg, err
+		}
+	}
+
+	if cfg.PortRule && cfg.ListenPort == 0 && !cfg.AutoPort {
+		cfg.CurrentFlag = help.FirewallFlag
+		return cfg, help.Errorf(
+			help.CodeInvalidArguments,
+			"error: '%s' requires the listen port, pass '%s [port|%s]'",
+			help.FirewallFlag, help.PortFlag, help.AutoPortValue,
+		)
+	}
+
+	owner, err := help.WgInterfaceAvailable(cfg.InterfaceName, cfg.Force)
+	if err != nil {
+		cfg.CurrentFlag = help.WgInterfaceFlag
+		return cfg, err
+	}
+	cfg.Existing = owner
+
+	return cfg, nil
+}
+
+// Method starts the device process with given arguments and configuration,
+// optionally redirecting output to a log file and managing background execution.
+// It returns once the background process reports its device up, or with the
+// error of the process when the device never came up.
+func (u Utility) Execute(args []string, cfg Config) error {
+
+	// Checking a running background process.
+	if os.Getenv(help.Env_Field_Foreground) == "1" {
+		if err := u.NewDevice(&cfg); err != nil {
+			startup.Fail(err)
+			return err
+		}
+
+		os.Exit(0)
+	}
+
+	// Supervisor re-executed by -supervise: keep the device process running.
+	if supervise.IsSupervisor() {
+		return u.supervise(args, cfg)
+	}
+
+	// Recreate: stop the process serving the interface and delete the link.
+	if cfg.Existing.Exists {
+		if err := set.RemoveInterface(cfg.Existing, recreateLinkWait); err != nil {
+			return err
+		}
+		fmt.Printf(
+			"removed existing network interface '%s' (%s)\n",
+			cfg.InterfaceName,
+			cfg.Existing.Describe(),
+		)
+	}
+
+	// The calling process stays resident as the supervisor of the device,
+	// the device processes it starts are in the network namespace.
+	if cfg.Supervise {
+		if netns.Name != "" {
+			args = append(slices.Clone(args), help.NetnsFlag, netns.Name)
+		}
+		return supervise.Exec(args, u.Type, cfg.InterfaceName)
+	}
+
+	release := func() {}
+	if cfg.AutoPort {
+		var err error
+		args, release, err = u.resolvePort(args, &cfg)
+		if err != nil {
+			return err
+		}
+	}
+	defer release()
+
+	if _, err := u.startDevice(args, cfg); err != nil {
+		return err
+	}
+	release()
+
+	if err := u.applySpec(cfg); err != nil {
+		return err
+	}
+
+	if err := u.devicePort(cfg); err != nil {
+		return err
+	}
+	return u.moveDevice(cfg)
+}
+
+// Method resolves the listen port `auto` (-p auto): the lowest free port
+// of the range of BRGNETUSE_PORT_RANGE (51820-51999 by default), see
+// handlers.FindFreeUDPPort. The port replaces `auto` in the returned
+// arguments, so the device process and its restarts listen on it. The
+// returned function releases the lock of the port selection (see
+// oplock.AcquirePorts), to call once the device listens.
+func (u Utility) resolvePort(args []string, cfg *Config) ([]string, func(), error) {
+	release, err := oplock.AcquirePorts()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	start, end, err := handlers.ParsePortRange(help.Environ()[help.Env_Default_PortRange])
+	if err == nil {
+		cfg.ListenPort, err = handlers.FindFreeUDPPort(start, end)
+	}
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	resolved := slices.Clone(args)
+	for indx := 1; indx+1 < len(resolved); indx++ {
+		if resolved[indx] == help.PortFlag && resolved[indx+1] == help.AutoPortValue {
+			resolved[indx+1] = strconv.Itoa(cfg.ListenPort)
+		}
+	}
+	return resolved, sync.OnceFunc(release), nil
+}
+
+// Method completes the first start of the device: it prints the listen
+// port chosen by `-p auto`, so provisioning can record it, and opens the
+// listen port in the INPUT chain with -fr.
+func (u Utility) devicePort(cfg Config) error {
+	if cfg.AutoPort {
+		fmt.Printf("network interface '%s' listens on port %d\n", cfg.InterfaceName, cfg.ListenPort)
+	}
+	if !cfg.PortRule {
+		return nil
+	}
+
+	added, err := set.EnsurePortRule(cfg.ListenPort)
+	if err != nil {
+		return err
+	}
+	if added {
+		fmt.Printf("port %d opened in the INPUT chain\n", cfg.ListenPort)
+	}
+	return nil
+}
+
+// Method moves the network interface of a device which came up into the
+// network namespace of -to-netns, see set.MoveInterface. The listen port
+// is opened before, the UDP socket stays in the current namespace.
+func (u Utility) moveDevice(cfg Config) error {
+	if cfg.ToNetns == "" {
+		return nil
+	}
+
+	if err := set.MoveInterface(cfg.InterfaceName, cfg.ToNetns); err != nil {
+		return fmt.Errorf("%w, the device process keeps running", err)
+	}
+	fmt.Printf("network interface '%s' moved to network namespace '%s'\n", cfg.InterfaceName, cfg.ToNetns)
+	return nil
+}
+
+// Method starts the background process of the device with the arguments
+// of the utility and waits for it to come up.
+func (u Utility) startDevice(args []string, cfg Config) (*exec.Cmd, error) {
+	env := append(
+		proc.ProcessEnv(u.Type, cfg.InterfaceName),
+		fmt.Sprintf("%s=1", help.Env_Field_Foreground),
+	)
+
+	// Re-launch the running executable rather than resolving args[0], so the
+	// child keeps the same argv[0] and is dispatched to the same utility when
+	// started through the multiplexed brgnet binary.
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("error: failed to locate executable, %v", err)
+	}
+
+	newSliceArgs := args[1:]
+	cmd := exec.Command(executable, newSliceArgs...)
+	cmd.Args[0] = args[0]
+	cmd.Env = env
+
+	if cfg.PathLogDir != "" {
+		openFile, err := help.OpenLogFile(cfg.PathLogDir, cfg.InterfaceName, cfg.LogMode, cfg.LogChown)
+		if err != nil {
+			return nil, err
+		}
+
+		cmd.Stdout = openFile
+		cmd.Stderr = openFile
+
+		defer openFile.Close()
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	// Wait for the device to come up, without a log file the early output
+	// of the process is the only trace of its error. Started inside the
+	// network namespace of -netns, the process and its TUN device live
+	// there, see netns.Do.
+	return cmd, netns.Do(func() error {
+		return startup.Start(cmd, cfg.InterfaceName, cfg.PathLogDir == "")
+	})
+}
+
+// Method runs the supervisor of the device process: the process is
+// restarted whenever it exits, SIGTERM or an interrupt stops both.
+// Restarts are logged to the log file, or standard error without one.
+func (u Utility) supervise(args []string, cfg Config) error {
+	var out io.Writer = os.Stderr
+	if cfg.PathLogDir != "" {
+		openFile, err := help.OpenLogFile(cfg.PathLogDir, cfg.InterfaceName, cfg.LogMode, cfg.LogChown)
+		if err != nil {
+			return err
+		}
+		defer openFile.Close()
+		out = openFile
+	}
+
+	logf := func(format string, a ...any) {
+		fmt.Fprintf(
+			out,
+			"[%s] %s supervisor %d: %s\n",
+			cfg.InterfaceName,
+			u.Name,
+			os.Getpid(),
+			fmt.Sprintf(format, a...),
+		)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, unix.SIGTERM, os.Interrupt)
+
+	// The restarts keep the listen port chosen for the first start.
+	release := func() {}
+	if cfg.AutoPort {
+		var err error
+		args, release, err = u.resolvePort(args, &cfg)
+		if err != nil {
+			return err
+		}
+	}
+	defer release()
+
+	// Each restart creates the device in the current namespace, it is
+	// moved again.
+	started := false
+	start := func() (*exec.Cmd, error) {
+		cmd, err := u.startDevice(args, cfg)
+		if err != nil {
+			return cmd, err
+		}
+		if !started {
+			started = true
+			release()
+			if err := u.devicePort(cfg); err != nil {
+				logf("%v", err)
+			}
+		}
+		if err := u.moveDevice(cfg); err != nil {
+			logf("%v", err)
+		}
+		return cmd, nil
+	}
+	return supervise.New(start, logf).Run(stop)
+}
+
+// Function re-resolves the endpoint host names of the peers of the network
+// interface every interval until the context is done (see
+// set.RefreshEndpoints). Each rewritten endpoint is logged with logf, each
+// failure with errorf; a failure never clears an endpoint.
+func RefreshEndpoints(ctx context.Context, iface string, interval time.Duration, logf, errorf func(format string, args ...any)) {
+	get.Watch(ctx, interval, func(ctx context.Context) error {
+		updates, err := set.RefreshEndpoints(ctx, iface, set.DefaultResolver)
+		if err != nil {
+			errorf("Endpoint refresh: %v", err)
+		}
+		for _, update := range updates {
+			switch {
+			case update.Err != nil:
+				errorf("Endpoint refresh: %v", update.Err)
+			case update.Changed():
+				logf(
+					"Endpoint of peer %s (%s) updated from %s to %s",
+					update.PublicKey, update.Host, update.Previous, update.Current,
+				)
+			}
+		}
+		return nil
+	})
+}
+
+// Function logs the cleanup of a stopped device (see add.CleanupErrors):
+// each rule or address that was not removed with errorf, and with cleanup
+// set and nothing left behind, the removal with logf. Cleanup failures are
+// logged only, they must not block the shutdown.
+func LogCleanup(errs []error, cleanup bool, logf, errorf func(format string, args ...any)) {
+	for _, err := range errs {
+		errorf("Cleanup: %v", err)
+	}
+	if cleanup && len(errs) == 0 {
+		logf("Recorded rules and addresses removed")
+	}
+}
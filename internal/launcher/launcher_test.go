@@ -0,0 +1,574 @@
+//go:build !windows
+
+package launcher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/middleware"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/wgmock"
+	"github.com/AlexKira/brgnetuse/src/proc"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Utilities sharing the parser, both must parse the arguments alike.
+var utilities = []Utility{
+	{Name: "brgaddwg", Type: help.Env_Wg_Type},
+	{Name: "brgaddawg", Type: help.Env_Awg_Type},
+}
+
+// Testing the ParseArgs method of both add utilities with one table.
+func TestParseArgs(t *testing.T) {
+	logDir := t.TempDir()
+	logFile := filepath.Join(logDir, "brgparse0.log")
+	if err := os.WriteFile(logFile, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The interface does not exist, so it is available.
+	const iface = "brgparse0"
+
+	// The arguments of the process must not be read, only the given ones.
+	prevArgs := os.Args
+	os.Args = []string{"brgaddwg", "-i", "wg$", "-m", "big"}
+	t.Cleanup(func() { os.Args = prevArgs })
+
+	type testCase struct {
+		name        string
+		args        []string
+		want        Config
+		wantError   string
+		wantCurrent string
+	}
+
+	tests := []testCase{
+		{
+			name: "interface",
+			args: []string{"-i", iface},
+			want: Config{InterfaceName: iface},
+		},
+		{
+			name: "mtu",
+			args: []string{"-i", iface, "-m", "1420"},
+			want: Config{InterfaceName: iface, MTU: 1420},
+		},
+		{
+			name: "logging json",
+			args: []string{"-i", iface, "-l", logDir, "-ld", "-js"},
+			want: Config{
+				InterfaceName: iface,
+				PathLogDir:    logDir,
+				LogLevel:      middleware.LogInfo,
+				LoggingJSON:   true,
+			},
+		},
+		{
+			name: "logging followed by flags",
+			args: []string{"-i", iface, "-l", logDir, "-le", "-cleanup", "-log-mode", "0600", "-log-chown"},
+			want: Config{
+				InterfaceName: iface,
+				PathLogDir:    logDir,
+				LogLevel:      middleware.LogError,
+				Cleanup:       true,
+				LogMode:       0o600,
+				LogChown:      true,
+			},
+		},
+		{
+			name: "supervise force alias",
+			args: []string{"-i", iface, "-supervise", "-force", "-alias", "office"},
+			want: Config{InterfaceName: iface, Supervise: true, Force: true, Alias: "office"},
+		},
+		{
+			name: "jumbo mtu",
+			args: []string{"-i", iface, "-m", "9000"},
+			want: Config{InterfaceName: iface, MTU: 9000},
+		},
+		{
+			name: "minimum mtu",
+			args: []string{"-i", iface, "-m", "1280"},
+			want: Config{InterfaceName: iface, MTU: 1280},
+		},
+		{
+			name: "forced mtu",
+			args: []string{"-i", iface, "-m", "576", "-force-mtu"},
+			want: Config{InterfaceName: iface, MTU: 576, ForceMTU: true},
+		},
+		{
+			name: "forced mtu before mtu",
+			args: []string{"-i", iface, "-l", logDir, "-ld", "-force-mtu", "-m", "65535"},
+			want: Config{
+				InterfaceName: iface,
+				PathLogDir:    logDir,
+				LogLevel:      middleware.LogInfo,
+				MTU:           65535,
+				ForceMTU:      true,
+			},
+		},
+		{
+			name: "log dedup and events after logging",
+			args: []string{"-i", iface, "-l", logDir, "-le", "-log-dedup", "60", "-log-events"},
+			want: Config{
+				InterfaceName: iface,
+				PathLogDir:    logDir,
+				LogLevel:      middleware.LogError,
+				LogDedup:      time.Minute,
+				LogEvents:     true,
+			},
+		},
+		{
+			name: "listen port",
+			args: []string{"-i", iface, "-p", "51820", "-fr"},
+			want: Config{InterfaceName: iface, ListenPort: 51820, PortRule: true},
+		},
+		{
+			name: "auto listen port after logging",
+			args: []string{"-i", iface, "-l", logDir, "-le", "-p", "auto", "-fr"},
+			want: Config{
+				InterfaceName: iface,
+				PathLogDir:    logDir,
+				LogLevel:      middleware.LogError,
+				AutoPort:      true,
+				PortRule:      true,
+			},
+		},
+		{
+			name:        "invalid listen port",
+			args:        []string{"-i", iface, "-p", "65536"},
+			wantError:   "out of valid range",
+			wantCurrent: "-p",
+		},
+		{
+			name:        "missing listen port",
+			args:        []string{"-i", iface, "-p"},
+			wantError:   "please provide the listen port",
+			wantCurrent: "-p",
+		},
+		{
+			name:        "port rule without port",
+			args:        []string{"-i", iface, "-fr"},
+			wantError:   "'-fr' requires the listen port",
+			wantCurrent: "-fr",
+		},
+		{
+			name:        "missing interface name",
+			args:        []string{"-i"},
+			wantError:   "followed by a valid WireGuard interface name",
+			wantCurrent: "-i",
+		},
+		{
+			name:        "invalid mtu",
+			args:        []string{"-i", iface, "-m", "big"},
+			wantError:   "invalid MTU number format",
+			wantCurrent: "-m",
+		},
+		{
+			name:        "mtu below range",
+			args:        []string{"-i", iface, "-m", "1279"},
+			wantError:   "out of valid range (1280-9000), pass '-force-mtu' to use it",
+			wantCurrent: "-m",
+		},
+		{
+			name:        "mtu above range",
+			args:        []string{"-i", iface, "-m", "9001"},
+			wantError:   "out of valid range (1280-9000), pass '-force-mtu' to use it",
+			wantCurrent: "-m",
+		},
+		{
+			name:        "forced mtu out of range",
+			args:        []string{"-i", iface, "-m", "65536", "-force-mtu"},
+			wantError:   "out of valid range (68-65535)",
+			wantCurrent: "-m",
+		},
+		{
+			name:        "missing mtu",
+			args:        []string{"-i", iface, "-m"},
+			wantError:   "please provide a valid MTU value",
+			wantCurrent: "-m",
+		},
+		{
+			name:        "unknown logging level",
+			args:        []string{"-i", iface, "-l", logDir, "-lx"},
+			wantError:   "logging level not found",
+			wantCurrent: "-l",
+		},
+		{
+			name:        "unknown logging type",
+			args:        []string{"-i", iface, "-l", logDir, "-ld", "-xml"},
+			wantError:   "logging type is missing",
+			wantCurrent: "-js",
+		},
+		{
+			name:        "log dir not a directory",
+			args:        []string{"-i", iface, "-l", logFile},
+			wantError:   "not a directory",
+			wantCurrent: "-l",
+		},
+		{
+			name:        "missing log dir",
+			args:        []string{"-i", iface, "-l"},
+			wantError:   "please provide the path to the log folder",
+			wantCurrent: "-l",
+		},
+		{
+			name:        "invalid log mode",
+			args:        []string{"-i", iface, "-log-mode", "rw"},
+			wantError:   "invalid log file mode",
+			wantCurrent: "-log-mode",
+		},
+		{
+			name:        "invalid log dedup",
+			args:        []string{"-i", iface, "-log-dedup", "0"},
+			wantError:   "invalid deduplication window",
+			wantCurrent: "-log-dedup",
+		},
+		{
+			name:        "missing log dedup",
+			args:        []string{"-i", iface, "-log-dedup"},
+			wantError:   "please provide the deduplication window",
+			wantCurrent: "-log-dedup",
+		},
+		{
+			name:        "missing alias",
+			args:        []string{"-i", iface, "-alias"},
+			wantError:   "please provide the network interface alias",
+			wantCurrent: "-alias",
+		},
+		{
+			name: "to netns of a process",
+			args: []string{"-i", iface, "-to-netns", strconv.Itoa(os.Getpid())},
+			want: Config{InterfaceName: iface, ToNetns: strconv.Itoa(os.Getpid())},
+		},
+		{
+			name:        "missing to netns",
+			args:        []string{"-i", iface, "-to-netns"},
+			wantError:   "please provide the network namespace name or process ID",
+			wantCurrent: "-to-netns",
+		},
+		{
+			name:        "to netns not found",
+			args:        []string{"-i", iface, "-to-netns", "brgnetuse-missing"},
+			wantError:   "network namespace 'brgnetuse-missing' not found",
+			wantCurrent: "-to-netns",
+		},
+		{
+			name:        "invalid interface name",
+			args:        []string{"-i", "wg$"},
+			wantError:   "wg$",
+			wantCurrent: "-i",
+		},
+		{
+			name:        "stray trailing flag",
+			args:        []string{"-i", iface, "-js"},
+			wantError:   help.DefaultErrorMessage,
+			wantCurrent: "-js",
+		},
+		{
+			name:        "stray log level",
+			args:        []string{"-i", iface, "-m", "1420", "-ld"},
+			wantError:   help.DefaultErrorMessage,
+			wantCurrent: "-ld",
+		},
+		{
+			name:        "unknown flag",
+			args:        []string{"-i", iface, "-x"},
+			wantError:   help.DefaultErrorMessage,
+			wantCurrent: "-x",
+		},
+	}
+
+	for _, u := range utilities {
+		for _, tc := range tests {
+			t.Run(u.Name+"/"+tc.name, func(t *testing.T) {
+				got, err := u.ParseArgs(append([]string{u.Name}, tc.args...))
+
+				if tc.wantError != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+						t.Fatalf("error: got %v, want an error containing %q", err, tc.wantError)
+					}
+					if got.CurrentFlag != tc.wantCurrent {
+						t.Errorf("error: got current flag %q, want %q", got.CurrentFlag, tc.wantCurrent)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+
+				want := tc.want
+				if want.LogLevel != 0 {
+					want.LoggerName = u.Name
+				}
+				got.Existing = want.Existing
+				if got != want {
+					t.Errorf("error: got %+v, want %+v", got, want)
+				}
+			})
+		}
+	}
+}
+
+// Testing the -refresh-endpoints interval, refused by the AmneziaWG
+// utility.
+func TestParseArgsRefreshEndpoints(t *testing.T) {
+	const iface = "brgparse0"
+	wg, awg := utilities[0], utilities[1]
+
+	got, err := wg.ParseArgs([]string{wg.Name, "-i", iface, "-l", t.TempDir(), "-le", "-refresh-endpoints", "300"})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if got.RefreshEndpoints != 5*time.Minute {
+		t.Errorf("error: got interval %s, want 5m0s", got.RefreshEndpoints)
+	}
+
+	type testCase struct {
+		name      string
+		utility   Utility
+		args      []string
+		wantError string
+	}
+
+	tests := []testCase{
+		{name: "zero", utility: wg, args: []string{"-refresh-endpoints", "0"}, wantError: "invalid refresh interval"},
+		{name: "not number", utility: wg, args: []string{"-refresh-endpoints", "5m"}, wantError: "invalid refresh interval"},
+		{name: "missing", utility: wg, args: []string{"-refresh-endpoints"}, wantError: "please provide the refresh interval"},
+		{name: "amneziawg", utility: awg, args: []string{"-refresh-endpoints", "300"}, wantError: "only supported by WireGuard"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.utility.ParseArgs(slices.Concat([]string{tc.utility.Name, "-i", iface}, tc.args))
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Fatalf("error: got %v, want an error containing %q", err, tc.wantError)
+			}
+			if got.CurrentFlag != help.RefreshEndpointsFlag {
+				t.Errorf("error: got current flag %q", got.CurrentFlag)
+			}
+		})
+	}
+}
+
+// Testing the environment defaults of ParseWithEnv: a flag given wins and
+// an invalid variable is refused as its flag would be.
+func TestParseWithEnv(t *testing.T) {
+	logDir := t.TempDir()
+	otherDir := t.TempDir()
+
+	const iface = "brgparse0"
+
+	type testCase struct {
+		name        string
+		args        []string
+		env         map[string]string
+		want        Config
+		wantArgs    []string
+		wantError   string
+		wantCurrent string
+	}
+
+	tests := []testCase{
+		{
+			name:     "interface",
+			env:      map[string]string{help.Env_Default_Interface: iface},
+			want:     Config{InterfaceName: iface},
+			wantArgs: []string{"-i", iface},
+		},
+		{
+			name:     "interface flag wins",
+			args:     []string{"-i", "brgparse1"},
+			env:      map[string]string{help.Env_Default_Interface: iface},
+			want:     Config{InterfaceName: "brgparse1"},
+			wantArgs: []string{"-i", "brgparse1"},
+		},
+		{
+			name: "logging",
+			args: []string{"-i", iface, "-m", "1420"},
+			env: map[string]string{
+				help.Env_Default_LogDir:   logDir,
+				help.Env_Default_LogLevel: "debug",
+				help.Env_Default_JSON:     "yes",
+			},
+			want: Config{
+				InterfaceName: iface,
+				MTU:           1420,
+				PathLogDir:    logDir,
+				LogLevel:      middleware.LogInfo,
+				LoggingJSON:   true,
+			},
+			wantArgs: []string{"-i", iface, "-m", "1420", "-l", logDir, "-ld", "-js"},
+		},
+		{
+			name: "log dir flag wins",
+			args: []string{"-i", iface, "-l", otherDir, "-le"},
+			env: map[string]string{
+				help.Env_Default_LogDir:   logDir,
+				help.Env_Default_LogLevel: "debug",
+				help.Env_Default_JSON:     "1",
+			},
+			want: Config{
+				InterfaceName: iface,
+				PathLogDir:    otherDir,
+				LogLevel:      middleware.LogError,
+			},
+			wantArgs: []string{"-i", iface, "-l", otherDir, "-le"},
+		},
+		{
+			name: "json false",
+			args: []string{"-i", iface},
+			env: map[string]string{
+				help.Env_Default_LogDir:   logDir,
+				help.Env_Default_LogLevel: "error",
+				help.Env_Default_JSON:     "no",
+			},
+			want: Config{
+				InterfaceName: iface,
+				PathLogDir:    logDir,
+				LogLevel:      middleware.LogError,
+			},
+			wantArgs: []string{"-i", iface, "-l", logDir, "-le"},
+		},
+		{
+			name: "invalid log level",
+			args: []string{"-i", iface},
+			env: map[string]string{
+				help.Env_Default_LogDir:   logDir,
+				help.Env_Default_LogLevel: "verbose",
+			},
+			wantError:   "logging level not found",
+			wantCurrent: "-l",
+		},
+		{
+			name:        "invalid json",
+			args:        []string{"-i", iface},
+			env:         map[string]string{help.Env_Default_JSON: "on"},
+			wantError:   help.Env_Default_JSON,
+			wantCurrent: "-js",
+		},
+	}
+
+	for _, u := range utilities {
+		for _, tc := range tests {
+			t.Run(u.Name+"/"+tc.name, func(t *testing.T) {
+				got, args, err := u.ParseWithEnv(append([]string{u.Name}, tc.args...), tc.env)
+
+				if tc.wantError != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+						t.Fatalf("error: got %v, want an error containing %q", err, tc.wantError)
+					}
+					if got.CurrentFlag != tc.wantCurrent {
+						t.Errorf("error: got current flag %q, want %q", got.CurrentFlag, tc.wantCurrent)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+
+				if !slices.Equal(args[1:], tc.wantArgs) {
+					t.Errorf("error: got arguments %q, want %q", args[1:], tc.wantArgs)
+				}
+
+				want := tc.want
+				if want.LogLevel != 0 {
+					want.LoggerName = u.Name
+				}
+				got.Existing = want.Existing
+				if got != want {
+					t.Errorf("error: got %+v, want %+v", got, want)
+				}
+			})
+		}
+	}
+}
+
+// Testing that `-p auto` is replaced by the lowest port neither used by a
+// device nor bound by a socket.
+func TestResolvePort(t *testing.T) {
+	prevLock, prevProc := oplock.Path, proc.ProcDir
+	oplock.Path = filepath.Join(t.TempDir(), "brgnetuse.lock")
+	proc.ProcDir = t.TempDir()
+	t.Cleanup(func() { oplock.Path, proc.ProcDir = prevLock, prevProc })
+
+	// A socket bound to 51821 (0xCA6D).
+	if err := os.MkdirAll(filepath.Join(proc.ProcDir, "net"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	udp := "  sl  local_address rem_address   st\n  0: 00000000:CA6D 00000000:0000 07\n"
+	if err := os.WriteFile(filepath.Join(proc.ProcDir, "net", "udp"), []byte(udp), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wgmock.Install(t, &wgtypes.Device{Name: "wg0", ListenPort: 51820})
+
+	u := utilities[0]
+	args := []string{u.Name, "-i", "wg1", "-p", "auto", "-fr"}
+	cfg := Config{InterfaceName: "wg1", AutoPort: true, PortRule: true}
+
+	resolved, release, err := u.resolvePort(args, &cfg)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	release()
+	release()
+
+	if want := []string{u.Name, "-i", "wg1", "-p", "51822", "-fr"}; !slices.Equal(resolved, want) {
+		t.Errorf("error: got args %q, want %q", resolved, want)
+	}
+	if cfg.ListenPort != 51822 || args[4] != "auto" {
+		t.Errorf("error: got port %d with args %q", cfg.ListenPort, args)
+	}
+
+	t.Setenv(help.Env_Default_PortRange, "51820-51821")
+	if _, _, err := u.resolvePort(args, &cfg); err == nil || !strings.Contains(err.Error(), "no free UDP port") {
+		t.Errorf("error: got %v, want no free port in the range", err)
+	}
+}
+
+// Testing that LogCleanup reports the removal only when nothing was left
+// behind, and every cleanup failure otherwise.
+func TestLogCleanup(t *testing.T) {
+	type testCase struct {
+		name       string
+		errs       []error
+		cleanup    bool
+		wantLogs   []string
+		wantErrors []string
+	}
+
+	tests := []testCase{
+		{name: "removed", cleanup: true, wantLogs: []string{"Recorded rules and addresses removed"}},
+		{name: "disabled", cleanup: false},
+		{
+			name:       "failed",
+			errs:       []error{errors.New("error: rule left"), errors.New("error: address left")},
+			cleanup:    true,
+			wantErrors: []string{"Cleanup: error: rule left", "Cleanup: error: address left"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs, errs []string
+			logf := func(format string, args ...any) { logs = append(logs, fmt.Sprintf(format, args...)) }
+			errorf := func(format string, args ...any) { errs = append(errs, fmt.Sprintf(format, args...)) }
+
+			LogCleanup(tc.errs, tc.cleanup, logf, errorf)
+			if !slices.Equal(logs, tc.wantLogs) {
+				t.Errorf("error: got logs %q, want %q", logs, tc.wantLogs)
+			}
+			if !slices.Equal(errs, tc.wantErrors) {
+				t.Errorf("error: got errors %q, want %q", errs, tc.wantErrors)
+			}
+		})
+	}
+}
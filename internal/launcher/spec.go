@@ -0,0 +1,129 @@
+//go:build !windows
+
+package launcher
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/reconcile"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/spec"
+)
+
+// Function reading the live state the spec is applied to, replaced in
+// tests.
+var inspectState = reconcile.Inspect
+
+// Function removing the device of a spec that failed to apply, replaced in
+// tests.
+var removeDevice = func(name string) error {
+	owner, err := get.GetInterfaceOwner(name)
+	if err != nil {
+		return err
+	}
+	if !owner.Exists {
+		return nil
+	}
+	return set.RemoveInterface(owner, recreateLinkWait)
+}
+
+// Method completes the configuration with the spec of -spec: the name, the
+// MTU and the listen port not given by flags, and the INPUT rule of
+// open_port. The private key file is read, so a missing key fails before
+// the device is started.
+func (c *Config) mergeSpec() error {
+	if c.Spec == nil {
+		return nil
+	}
+	s := c.Spec
+
+	switch {
+	case c.InterfaceName == "":
+		c.InterfaceName = s.Name
+	case c.InterfaceName != s.Name:
+		return help.Errorf(
+			help.CodeInvalidValue,
+			"error: network interface '%s' differs from '%s' of the spec file",
+			c.InterfaceName, s.Name,
+		)
+	}
+	if c.MTU == 0 {
+		c.MTU = s.MTU
+	}
+	if c.ListenPort == 0 && !c.AutoPort {
+		c.ListenPort = s.ListenPort
+	}
+	c.PortRule = c.PortRule || s.OpenPort
+
+	// The spec is applied once, by the process starting the device.
+	if c.Supervise {
+		return help.Errorf(help.CodeInvalidArguments, "error: '%s' cannot be combined with '%s'", help.SpecFlag, help.SuperviseFlag)
+	}
+	if c.ToNetns != "" {
+		return help.Errorf(help.CodeInvalidArguments, "error: '%s' cannot be combined with '%s'", help.SpecFlag, help.ToNetnsFlag)
+	}
+
+	key, _, err := s.PrivateKey()
+	clear(key[:])
+	return err
+}
+
+// Method applies the spec of -spec to the device which came up: the
+// private key, then the forwarding, addresses, peers and NAT rules, in
+// the order of reconcile.Diff. A failing stage rolls the device back: the
+// rules and addresses recorded so far are removed and the device is
+// stopped. The forwarding, a system setting, is left as is.
+func (u Utility) applySpec(cfg Config) error {
+	if cfg.Spec == nil {
+		return nil
+	}
+
+	err := applySpec(*cfg.Spec, os.Stdout)
+	if err == nil {
+		return nil
+	}
+
+	errs := set.CleanupInterface(cfg.InterfaceName)
+	if removeErr := removeDevice(cfg.InterfaceName); removeErr != nil {
+		errs = append(errs, removeErr)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w, rollback failed: %v", err, errors.Join(errs...))
+	}
+	return fmt.Errorf("%w, network interface '%s' removed", err, cfg.InterfaceName)
+}
+
+// Function sets the private key of the spec on its device and applies the
+// rest of the spec, reporting each applied change to out.
+func applySpec(s spec.Spec, out io.Writer) error {
+	key, ok, err := s.PrivateKey()
+	if err != nil {
+		return err
+	}
+	if ok {
+		secret := set.NewSecret(key.String())
+		clear(key[:])
+
+		err := set.UpdatePrivateKey(set.UpdatePrivateKeyStructure{
+			InterfaceName: s.Name,
+			Secret:        secret,
+			Verify:        true,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "applied private key of %s\n", s.Name)
+	}
+
+	desired := reconcile.FromSpec(s)
+	current, err := inspectState(desired)
+	if err != nil {
+		return err
+	}
+	return reconcile.Apply(desired, reconcile.Diff(desired, current), out)
+}
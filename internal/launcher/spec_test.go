@@ -0,0 +1,235 @@
+//go:build !windows
+
+package launcher
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/internal/shellmock"
+	"github.com/AlexKira/brgnetuse/internal/wgmock"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/reconcile"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/spec"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Public key of the peer of the test specs.
+var specPeerKey = strings.Repeat("B", 42) + "A="
+
+// Function writes a spec of the interface, with its private key file, and
+// returns the path of the spec and the key.
+func writeSpec(t *testing.T, iface string) (string, wgtypes.Key) {
+	t.Helper()
+
+	dir := t.TempDir()
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key"), []byte(key.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	data := fmt.Sprintf(`{
+  "name": %q,
+  "mtu": 1420,
+  "listen_port": 51820,
+  "private_key_file": "key",
+  "addresses": ["10.10.10.254/24"],
+  "forwarding": true,
+  "open_port": true,
+  "nat": [{"subnet": "10.10.10.0/24", "out_interface": "eth0"}],
+  "peers": [{"public_key": %q, "allowed_ips": ["10.10.10.2/32"]}]
+}`, iface, specPeerKey)
+
+	path := filepath.Join(dir, "spec.json")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path, key
+}
+
+// Testing that -spec fills the configuration from the spec file, and the
+// flags refused with it.
+func TestParseArgsSpec(t *testing.T) {
+	const iface = "brgspec0"
+	path, _ := writeSpec(t, iface)
+	wg, awg := utilities[0], utilities[1]
+
+	cfg, err := wg.ParseArgs([]string{wg.Name, "-spec", path})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if cfg.InterfaceName != iface || cfg.MTU != 1420 || cfg.ListenPort != 51820 || !cfg.PortRule || cfg.Spec == nil {
+		t.Errorf("error: got %+v, want the settings of the spec", cfg)
+	}
+
+	// A flag wins over the spec.
+	cfg, err = wg.ParseArgs([]string{wg.Name, "-i", iface, "-m", "1380", "-spec", path})
+	if err != nil || cfg.MTU != 1380 {
+		t.Errorf("error: got MTU %d (%v), want the one of -m", cfg.MTU, err)
+	}
+
+	missingKey := filepath.Join(t.TempDir(), "spec.json")
+	if err := os.WriteFile(missingKey, []byte(`{"name": "brgspec0", "private_key_file": "none"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		u         Utility
+		args      []string
+		wantError string
+	}{
+		{name: "other interface", u: wg, args: []string{"-i", "brgspec1", "-spec", path}, wantError: "differs from 'brgspec0'"},
+		{name: "supervise", u: wg, args: []string{"-spec", path, "-supervise"}, wantError: "cannot be combined with '-supervise'"},
+		{name: "missing file", u: wg, args: []string{"-spec", filepath.Join(t.TempDir(), "none.json")}, wantError: "failed to read spec file"},
+		{name: "missing key file", u: wg, args: []string{"-spec", missingKey}, wantError: "failed to read private key file"},
+		{name: "missing path", u: wg, args: []string{"-spec"}, wantError: "please provide the path to the spec file"},
+		{name: "amneziawg", u: awg, args: []string{"-spec", path}, wantError: "only supported by WireGuard interfaces"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := tc.u.ParseArgs(append([]string{tc.u.Name}, tc.args...))
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Fatalf("error: got %v, want an error containing %q", err, tc.wantError)
+			}
+			if cfg.CurrentFlag != "-spec" {
+				t.Errorf("error: got current flag %q, want -spec", cfg.CurrentFlag)
+			}
+		})
+	}
+}
+
+// Function installs the mocks applying a spec of the interface: the
+// wgctrl device, the shell, the metadata and the live state, an existing
+// interface without addresses, peers or rules.
+func installSpecMocks(t *testing.T, iface string) (*wgmock.Client, *shellmock.Runner) {
+	t.Helper()
+
+	prevLock, prevMeta, prevInspect := oplock.Path, peermeta.Dir, inspectState
+	oplock.Path = filepath.Join(t.TempDir(), "brgnetuse.lock")
+	peermeta.Dir = t.TempDir()
+	inspectState = func(desired reconcile.State) (reconcile.Current, error) {
+		return reconcile.Current{
+			Forwarding: map[string]int{"ipv4": 0},
+			Interfaces: map[string]reconcile.CurrentInterface{iface: {}},
+		}, nil
+	}
+	t.Cleanup(func() { oplock.Path, peermeta.Dir, inspectState = prevLock, prevMeta, prevInspect })
+
+	mock := wgmock.Install(t, &wgtypes.Device{Name: iface})
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.FormatCmdTcQdiscShowJSON(iface)] = "[]"
+	return mock, fake
+}
+
+// Testing that applySpec sets the key and applies the forwarding, address,
+// peer and rules of the spec, each recorded for the cleanup.
+func TestApplySpec(t *testing.T) {
+	const iface = "brgspec0"
+	path, key := writeSpec(t, iface)
+	mock, fake := installSpecMocks(t, iface)
+
+	s, err := spec.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := applySpec(s, &out); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	device, err := mock.Device(iface)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.PublicKey != key.PublicKey() {
+		t.Error("error: the private key of the spec is not set")
+	}
+	if len(device.Peers) != 1 || device.Peers[0].PublicKey.String() != specPeerKey {
+		t.Errorf("error: got peers %v, want %s", device.Peers, specPeerKey)
+	}
+
+	wantCmds := []string{
+		shell.SysctlIpv4Up,
+		shell.FormatCmdIpAddrDev(iface, "10.10.10.254/24", shell.IpAdd),
+		firewall.FormatCmdAppend("filter", "FORWARD", set.ForwardRule("eth0", iface, iface).Spec),
+		firewall.FormatCmdAppend("filter", "FORWARD", set.ForwardRule(iface, "eth0", iface).Spec),
+		firewall.FormatCmdAppend("nat", "POSTROUTING", set.NATRule("eth0", "10.10.10.0/24", iface).Spec),
+	}
+	var gotCmds []string
+	for _, cmd := range fake.Commands {
+		if !strings.HasPrefix(cmd, "tc ") {
+			gotCmds = append(gotCmds, cmd)
+		}
+	}
+	if !slices.Equal(gotCmds, wantCmds) {
+		t.Errorf("error: got commands\n%s\nwant\n%s", strings.Join(gotCmds, "\n"), strings.Join(wantCmds, "\n"))
+	}
+
+	meta, err := peermeta.LoadInterface(iface)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Rules) != 3 || len(meta.Addresses) != 1 {
+		t.Errorf("error: got recorded rules %v and addresses %v", meta.Rules, meta.Addresses)
+	}
+	if !strings.HasPrefix(out.String(), "applied private key of "+iface+"\n") {
+		t.Errorf("error: got output %q", out.String())
+	}
+}
+
+// Testing that a failing stage rolls the device back: the rules and
+// addresses applied before are removed and the device is stopped.
+func TestApplySpecRollback(t *testing.T) {
+	const iface = "brgspec0"
+	path, _ := writeSpec(t, iface)
+	_, fake := installSpecMocks(t, iface)
+
+	natRule := set.NATRule("eth0", "10.10.10.0/24", iface)
+	fake.Errors[firewall.FormatCmdAppend("nat", "POSTROUTING", natRule.Spec)] = errors.New("iptables failed")
+
+	removed := ""
+	prevRemove := removeDevice
+	removeDevice = func(name string) error { removed = name; return nil }
+	t.Cleanup(func() { removeDevice = prevRemove })
+
+	s, err := spec.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{InterfaceName: iface, Spec: &s}
+
+	err = Utility{}.applySpec(cfg)
+	if err == nil || !strings.Contains(err.Error(), "iptables failed") || !strings.Contains(err.Error(), "network interface 'brgspec0' removed") {
+		t.Fatalf("error: got %v, want the failure and the rollback", err)
+	}
+	if removed != iface {
+		t.Errorf("error: got removed device %q, want %q", removed, iface)
+	}
+
+	for _, rule := range set.ForwardRules("eth0", iface) {
+		if cmd := firewall.FormatCmdDelete(rule.Table, rule.Chain, rule.Spec); !slices.Contains(fake.Commands, cmd) {
+			t.Errorf("error: rule not removed by the rollback: %s", cmd)
+		}
+	}
+	meta, err := peermeta.LoadInterface(iface)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Rules) != 0 || len(meta.Addresses) != 0 {
+		t.Errorf("error: got recorded rules %v and addresses %v after the rollback", meta.Rules, meta.Addresses)
+	}
+}
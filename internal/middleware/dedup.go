@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Field of the record collapsing the repeats of a message.
+const repeatCountKey = "repeat_count"
+
+// dedupLogger collapses the identical messages logged within a window.
+// The first message of a window is logged at once; its repeats are counted
+// and logged as one record with a repeat_count field when the window closes
+// or a different message arrives. It is safe for concurrent use, the device
+// logs from several goroutines.
+type dedupLogger struct {
+	logger *slog.Logger
+	window time.Duration
+
+	mu      sync.Mutex
+	level   slog.Level
+	message string
+	attrs   []slog.Attr
+	repeats int
+	timer   *time.Timer
+	open    uint64 // Number of the current window, 0 when closed.
+	windows uint64
+}
+
+// Function returns a logger collapsing the repeats of logger within window.
+func newDedupLogger(logger *slog.Logger, window time.Duration) *dedupLogger {
+	return &dedupLogger{logger: logger, window: window}
+}
+
+// Method logs the message at level with its fields, or counts it when it
+// repeats the message of the current window.
+func (d *dedupLogger) log(level slog.Level, message string, attrs ...slog.Attr) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.open != 0 && level == d.level && message == d.message {
+		d.repeats++
+		return
+	}
+
+	d.flushLocked()
+	d.level, d.message, d.attrs = level, message, attrs
+	d.logger.LogAttrs(context.Background(), level, message, attrs...)
+
+	d.windows++
+	window := d.windows
+	d.open = window
+	d.timer = time.AfterFunc(d.window, func() { d.expire(window) })
+}
+
+// Method closes the window, unless a different message already closed it.
+func (d *dedupLogger) expire(window uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.open == window {
+		d.flushLocked()
+	}
+}
+
+// Method logs the repeats of the current window and closes it. The mutex
+// must be held.
+func (d *dedupLogger) flushLocked() {
+	if d.open == 0 {
+		return
+	}
+	d.timer.Stop()
+	d.open = 0
+
+	if d.repeats > 0 {
+		attrs := append(d.attrs[:len(d.attrs):len(d.attrs)], slog.Int(repeatCountKey, d.repeats))
+		d.logger.LogAttrs(context.Background(), d.level, d.message, attrs...)
+	}
+	d.repeats = 0
+}
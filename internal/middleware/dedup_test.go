@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a buffer written by the timers of the logger while the
+// test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Method returns the JSON records written so far.
+func (b *syncBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var records []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(b.buf.Bytes()), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("error: invalid JSON line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// Function returns the repeat_count of the record, 0 without one.
+func repeatCount(record map[string]any) int {
+	count, _ := record[repeatCountKey].(float64)
+	return int(count)
+}
+
+// Testing that concurrent repeats are collapsed into one counted record,
+// flushed by a different message.
+func TestDedupConcurrent(t *testing.T) {
+	const goroutines, repeats = 16, 500
+
+	var output syncBuffer
+	param := LoggingStruct{LogLevel: LogInfo, FuncName: "test", Output: &output, Dedup: time.Hour}
+	logger := param.WgJsonLoggerMiddleware("wg0")
+
+	var wg sync.WaitGroup
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range repeats {
+				logger.Errorf("peer(%s) - handshake did not complete", "abc")
+			}
+		}()
+	}
+	wg.Wait()
+	logger.Verbosef("peer(%s) - receiving keepalive", "abc")
+
+	records := output.records(t)
+	if len(records) != 3 {
+		t.Fatalf("error: got %d records, want 3: %v", len(records), records)
+	}
+
+	want := []struct {
+		msg   string
+		level string
+		count int
+	}{
+		{msg: "peer(abc) - handshake did not complete", level: "ERROR"},
+		{msg: "peer(abc) - handshake did not complete", level: "ERROR", count: goroutines*repeats - 1},
+		{msg: "peer(abc) - receiving keepalive", level: "DEBUG"},
+	}
+	for i, w := range want {
+		if records[i]["msg"] != w.msg || records[i]["level"] != w.level || repeatCount(records[i]) != w.count {
+			t.Errorf("error: record %d: got %v, want %+v", i, records[i], w)
+		}
+	}
+}
+
+// Testing that the repeats are flushed when the window closes and that a
+// message after the window is logged again.
+func TestDedupWindow(t *testing.T) {
+	var output syncBuffer
+	param := LoggingStruct{LogLevel: LogError, FuncName: "test", Output: &output, Dedup: 20 * time.Millisecond}
+	logger := param.WgJsonLoggerMiddleware("wg0")
+
+	for range 3 {
+		logger.Errorf("failed to send packet")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(output.records(t)) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	records := output.records(t)
+	if len(records) != 2 || repeatCount(records[0]) != 0 || repeatCount(records[1]) != 2 {
+		t.Fatalf("error: got %v, want the message and 2 repeats", records)
+	}
+
+	logger.Errorf("failed to send packet")
+	records = output.records(t)
+	if len(records) != 3 || repeatCount(records[2]) != 0 {
+		t.Errorf("error: got %v, want the message logged again", records)
+	}
+}
+
+// Testing that without Dedup every message is logged.
+func TestDedupOff(t *testing.T) {
+	var output syncBuffer
+	param := LoggingStruct{LogLevel: LogError, FuncName: "test", Output: &output}
+	logger := param.WgJsonLoggerMiddleware("wg0")
+
+	for range 5 {
+		logger.Errorf("failed to send packet")
+	}
+
+	if records := output.records(t); len(records) != 5 {
+		t.Errorf("error: got %d records, want 5", len(records))
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"log/slog"
+	"regexp"
+)
+
+// Event types of the device log messages, the event field of the records.
+const (
+	EventHandshakeInit     string = "handshake_init"
+	EventHandshakeComplete string = "handshake_complete"
+	EventKeypairRotate     string = "keypair_rotate"
+	EventPeerAdded         string = "peer_added"
+	EventUAPIError         string = "uapi_error"
+	EventShutdown          string = "shutdown"
+	EventUnknown           string = "unknown"
+)
+
+// Fields of the classified records.
+const (
+	eventKey = "event"
+	peerKey  = "peer"
+)
+
+// eventPattern maps a message family of wireguard-go and amneziawg-go to
+// its event type.
+type eventPattern struct {
+	event   string
+	pattern *regexp.Regexp
+}
+
+// Message families of the device loggers, the first match wins. The
+// messages of amneziawg-go are those of wireguard-go for these families.
+var eventPatterns = []eventPattern{
+	{EventHandshakeInit, regexp.MustCompile(`- (Sending|Received) handshake initiation$`)},
+	{EventHandshakeInit, regexp.MustCompile(`- Handshake did not complete after \d+ seconds, retrying`)},
+	{EventHandshakeInit, regexp.MustCompile(`- Retrying handshake because we stopped hearing back`)},
+	{EventHandshakeComplete, regexp.MustCompile(`- (Sending|Received) handshake response$`)},
+	{EventKeypairRotate, regexp.MustCompile(`^UAPI: Updating private key$`)},
+	{EventKeypairRotate, regexp.MustCompile(`- Removing all keys, since we haven't received a new one`)},
+	{EventPeerAdded, regexp.MustCompile(`- UAPI: Created$`)},
+	{EventUAPIError, regexp.MustCompile(`^IPC error -?\d+: `)},
+	{EventUAPIError, regexp.MustCompile(`^invalid UAPI operation: `)},
+	{EventShutdown, regexp.MustCompile(`^Device clos(ing|ed)$`)},
+}
+
+// Abbreviated public key of a peer in the messages (e.g., `peer(AbCd…wXyZ)`).
+var peerPattern = regexp.MustCompile(`peer\(([A-Za-z0-9+/]{4}…[A-Za-z0-9+/=]{4})\)`)
+
+// Function classifies a device log message: it returns its event type,
+// EventUnknown for the other messages, and the abbreviated public key of
+// the peer it names, an empty string without one.
+func ClassifyMessage(message string) (event, peer string) {
+	event = EventUnknown
+	for _, p := range eventPatterns {
+		if p.pattern.MatchString(message) {
+			event = p.event
+			break
+		}
+	}
+
+	if match := peerPattern.FindStringSubmatch(message); match != nil {
+		peer = match[1]
+	}
+	return event, peer
+}
+
+// Function returns the event and peer fields of the message.
+func eventAttrs(message string) []slog.Attr {
+	event, peer := ClassifyMessage(message)
+	attrs := []slog.Attr{slog.String(eventKey, event)}
+	if peer != "" {
+		attrs = append(attrs, slog.String(peerKey, peer))
+	}
+	return attrs
+}
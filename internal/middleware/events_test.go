@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Testing the classification of log lines captured from wireguard-go and
+// amneziawg-go devices.
+func TestClassifyMessage(t *testing.T) {
+	type testCase struct {
+		message   string
+		wantEvent string
+		wantPeer  string
+	}
+
+	tests := []testCase{
+		// wireguard-go.
+		{message: "peer(Ux6y…Wu0A) - Sending handshake initiation", wantEvent: EventHandshakeInit, wantPeer: "Ux6y…Wu0A"},
+		{message: "peer(Ux6y…Wu0A) - Received handshake initiation", wantEvent: EventHandshakeInit, wantPeer: "Ux6y…Wu0A"},
+		{message: "peer(Ux6y…Wu0A) - Handshake did not complete after 5 seconds, retrying (try 2)", wantEvent: EventHandshakeInit, wantPeer: "Ux6y…Wu0A"},
+		{message: "peer(Ux6y…Wu0A) - Retrying handshake because we stopped hearing back after 15 seconds", wantEvent: EventHandshakeInit, wantPeer: "Ux6y…Wu0A"},
+		{message: "peer(Ux6y…Wu0A) - Received handshake response", wantEvent: EventHandshakeComplete, wantPeer: "Ux6y…Wu0A"},
+		{message: "peer(Ux6y…Wu0A) - Sending handshake response", wantEvent: EventHandshakeComplete, wantPeer: "Ux6y…Wu0A"},
+		{message: "UAPI: Updating private key", wantEvent: EventKeypairRotate},
+		{message: "peer(Ux6y…Wu0A) - Removing all keys, since we haven't received a new one in 540 seconds", wantEvent: EventKeypairRotate, wantPeer: "Ux6y…Wu0A"},
+		{message: "peer(+aB/…9z+Q) - UAPI: Created", wantEvent: EventPeerAdded, wantPeer: "+aB/…9z+Q"},
+		{message: "IPC error -22: failed to set private_key: [REDACTED]", wantEvent: EventUAPIError},
+		{message: "IPC error -5: failed to read input: EOF", wantEvent: EventUAPIError},
+		{message: "invalid UAPI operation: foo", wantEvent: EventUAPIError},
+		{message: "Device closing", wantEvent: EventShutdown},
+		{message: "Device closed", wantEvent: EventShutdown},
+		{message: "peer(Ux6y…Wu0A) - Receiving keepalive packet", wantEvent: EventUnknown, wantPeer: "Ux6y…Wu0A"},
+		{message: "Routine: TUN reader - started", wantEvent: EventUnknown},
+		{message: "Interface up requested", wantEvent: EventUnknown},
+		{message: "UAPI: Updating listen port", wantEvent: EventUnknown},
+
+		// amneziawg-go.
+		{message: "peer(Ux6y…Wu0A) - Sending handshake initiation", wantEvent: EventHandshakeInit, wantPeer: "Ux6y…Wu0A"},
+		{message: "peer(Ux6y…Wu0A) - Special junks sent", wantEvent: EventUnknown, wantPeer: "Ux6y…Wu0A"},
+		{message: "peer(Ux6y…Wu0A) - Failed to send junk packets: write udp: connection refused", wantEvent: EventUnknown, wantPeer: "Ux6y…Wu0A"},
+		{message: "UAPI: Updating junk_packet_count", wantEvent: EventUnknown},
+		{message: "aSec: Received message with unknown type: 7", wantEvent: EventUnknown},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.message, func(t *testing.T) {
+			event, peer := ClassifyMessage(tc.message)
+			if event != tc.wantEvent || peer != tc.wantPeer {
+				t.Errorf("error: got (%q, %q), want (%q, %q)", event, peer, tc.wantEvent, tc.wantPeer)
+			}
+		})
+	}
+}
+
+// Testing the event fields of the JSON records, also on the record of the
+// collapsed repeats.
+func TestLoggerEvents(t *testing.T) {
+	var output bytes.Buffer
+	param := LoggingStruct{LogLevel: LogInfo, FuncName: "test", Output: &output, Events: true, Dedup: time.Hour}
+	logger := param.WgJsonLoggerMiddleware("wg0")
+
+	logger.Verbosef("%s - Sending handshake initiation", "peer(Ux6y…Wu0A)")
+	logger.Verbosef("%s - Sending handshake initiation", "peer(Ux6y…Wu0A)")
+	logger.Verbosef("Routine: TUN reader - started")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("error: got log output %q", output.String())
+	}
+
+	want := []map[string]any{
+		{"msg": "peer(Ux6y…Wu0A) - Sending handshake initiation", "event": EventHandshakeInit, "peer": "Ux6y…Wu0A"},
+		{"msg": "peer(Ux6y…Wu0A) - Sending handshake initiation", "event": EventHandshakeInit, "peer": "Ux6y…Wu0A", "repeat_count": float64(1)},
+		{"msg": "Routine: TUN reader - started", "event": EventUnknown},
+	}
+	for i, line := range lines {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("error: invalid JSON line %q: %v", line, err)
+		}
+		for key, value := range want[i] {
+			if record[key] != value {
+				t.Errorf("error: record %d: got %s=%v, want %v", i, key, record[key], value)
+			}
+		}
+		if _, ok := record["peer"]; ok != (want[i]["peer"] != nil) {
+			t.Errorf("error: record %d: unexpected peer field: %v", i, record)
+		}
+	}
+}
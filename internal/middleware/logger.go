@@ -2,10 +2,14 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"time"
 
+	"github.com/AlexKira/brgnetuse/internal/handlers"
 	"golang.zx2c4.com/wireguard/device"
 )
 
@@ -21,21 +25,43 @@ type LoggingStruct struct {
 	FuncName   string
 	Pid        int
 	MainThread int
+
+	// Output receives the JSON lines, os.Stdout when nil.
+	Output io.Writer
+
+	// Dedup is the window collapsing identical messages into one record
+	// with a repeat_count field, 0 logs every message.
+	Dedup time.Duration
+
+	// Events adds the event type of the messages and the peer they name
+	// as fields (see ClassifyMessage).
+	Events bool
 }
 
 // Function to convert logger string format to JSON.
+// Key material in the messages is redacted (see handlers.Redact), with
+// Dedup the repeated messages are collapsed and with Events they are
+// classified.
 func (param *LoggingStruct) WgJsonLoggerMiddleware(interfaceName string) *device.Logger {
 
 	loglevel := param.LogLevel
-	cfg := &slog.HandlerOptions{Level: slog.LevelDebug}
-	jsonHandler := slog.NewJSONHandler(os.Stdout, cfg)
+	logger := param.jsonLogger(interfaceName)
 
-	logger := slog.New(jsonHandler).With(
-		slog.String("func", param.FuncName),
-		slog.Int("pid", param.Pid),
-		slog.Int("main_thread", param.MainThread),
-		slog.String("interface", interfaceName),
-	)
+	emit := func(level slog.Level, message string, attrs ...slog.Attr) {
+		logger.LogAttrs(context.Background(), level, message, attrs...)
+	}
+	if param.Dedup > 0 {
+		emit = newDedupLogger(logger, param.Dedup).log
+	}
+
+	logf := func(level slog.Level, msg string, args ...any) {
+		message := handlers.Redact(fmt.Sprintf(msg, args...))
+		if param.Events {
+			emit(level, message, eventAttrs(message)...)
+			return
+		}
+		emit(level, message)
+	}
 
 	newDeviceLogger := &device.Logger{
 		Verbosef: device.DiscardLogf,
@@ -44,13 +70,30 @@ func (param *LoggingStruct) WgJsonLoggerMiddleware(interfaceName string) *device
 
 	if loglevel >= device.LogLevelVerbose {
 		newDeviceLogger.Verbosef = func(msg string, args ...any) {
-			logger.Debug(fmt.Sprintf(msg, args...))
+			logf(slog.LevelDebug, msg, args...)
 		}
 	}
 	if loglevel >= device.LogLevelError {
 		newDeviceLogger.Errorf = func(msg string, args ...any) {
-			logger.Error(fmt.Sprintf(msg, args...))
+			logf(slog.LevelError, msg, args...)
 		}
 	}
 	return newDeviceLogger
 }
+
+// Method returns the JSON logger with the basic fields of the device process.
+func (param *LoggingStruct) jsonLogger(interfaceName string) *slog.Logger {
+	cfg := &slog.HandlerOptions{Level: slog.LevelDebug}
+	output := param.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	jsonHandler := slog.NewJSONHandler(output, cfg)
+
+	return slog.New(jsonHandler).With(
+		slog.String("func", param.FuncName),
+		slog.Int("pid", param.Pid),
+		slog.Int("main_thread", param.MainThread),
+		slog.String("interface", interfaceName),
+	)
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Testing that key material never reaches the captured log output.
+func TestLoggerRedaction(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("error: failed to generate key: %v", err)
+	}
+	hexKey := hex.EncodeToString(key[:])
+
+	var output bytes.Buffer
+	param := LoggingStruct{LogLevel: LogInfo, FuncName: "test", Output: &output}
+	logger := param.WgJsonLoggerMiddleware("wg0")
+
+	logger.Verbosef("UAPI: set private_key=%s", hexKey)
+	logger.Errorf("failed: awg set wg0 private-key <(echo '%s')", key.String())
+
+	got := output.String()
+	if strings.Count(got, "\n") != 2 {
+		t.Fatalf("error: got log output %q", got)
+	}
+	if strings.Contains(got, hexKey) || strings.Contains(got, key.String()) {
+		t.Errorf("error: key material leaked into the log: %s", got)
+	}
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Method returns the logger of structured records (e.g., the startup record
+// of the device) matching WgJsonLoggerMiddleware: the records are JSON
+// lines with their fields, logged only at the LogInfo level.
+func (param *LoggingStruct) WgJsonRecordLogger(interfaceName string) *slog.Logger {
+	if param.LogLevel < device.LogLevelVerbose {
+		return slog.New(slog.DiscardHandler)
+	}
+	return param.jsonLogger(interfaceName)
+}
+
+// Function returns the logger of structured records for a plain text
+// device logger: each record is written through verbosef (the Verbosef of
+// the device logger) as the message followed by `key=value` pairs.
+func TextRecordLogger(verbosef func(format string, args ...any)) *slog.Logger {
+	return slog.New(&textRecordHandler{verbosef: verbosef})
+}
+
+// textRecordHandler formats the records for a plain text device logger.
+type textRecordHandler struct {
+	verbosef func(format string, args ...any)
+	attrs    []slog.Attr
+}
+
+// Method reports that every level is handled, the device logger discards
+// the records below its own level.
+func (h *textRecordHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// Method writes the record as one line.
+func (h *textRecordHandler) Handle(_ context.Context, record slog.Record) error {
+	var line strings.Builder
+	line.WriteString(record.Message)
+
+	write := func(attr slog.Attr) bool {
+		value := attr.Value.Resolve().String()
+		if value == "" || strings.ContainsAny(value, " \t\"") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&line, " %s=%s", attr.Key, value)
+		return true
+	}
+	for _, attr := range h.attrs {
+		write(attr)
+	}
+	record.Attrs(write)
+
+	h.verbosef("%s", line.String())
+	return nil
+}
+
+// Method returns a handler adding the fields to every record.
+func (h *textRecordHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &textRecordHandler{
+		verbosef: h.verbosef,
+		attrs:    append(append([]slog.Attr{}, h.attrs...), attrs...),
+	}
+}
+
+// Method returns the handler unchanged, groups are not used by the records.
+func (h *textRecordHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// KeyLogger logs the public key of a device at debug level whenever its
+// private key changes (e.g., set through the UAPI socket by brgsetwg).
+type KeyLogger struct {
+	records *slog.Logger
+	ipcGet  func(w io.Writer) error
+
+	mu        sync.Mutex
+	publicKey string
+}
+
+// Function returns a key logger of the device, ipcGet is the
+// IpcGetOperation method of the device.
+func NewKeyLogger(records *slog.Logger, ipcGet func(w io.Writer) error) *KeyLogger {
+	return &KeyLogger{records: records, ipcGet: ipcGet}
+}
+
+// Method reads the private key of the device and logs its public key when
+// it changed since the last call. A device without a private key logs
+// nothing.
+func (k *KeyLogger) Check() {
+	var buf bytes.Buffer
+	defer func() { clear(buf.Bytes()) }()
+
+	if err := k.ipcGet(&buf); err != nil {
+		return
+	}
+
+	publicKey := publicKeyOf(buf.Bytes())
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if publicKey == k.publicKey {
+		return
+	}
+	k.publicKey = publicKey
+	if publicKey != "" {
+		k.records.Debug("private key set", slog.String("public_key", publicKey))
+	}
+}
+
+// Function returns the public key (base64 encoded) of the private key in
+// the UAPI get response, an empty string without one.
+func publicKeyOf(response []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(response))
+	for scanner.Scan() {
+		value, ok := bytes.CutPrefix(scanner.Bytes(), []byte("private_key="))
+		if !ok {
+			continue
+		}
+
+		var key wgtypes.Key
+		if n, err := hex.Decode(key[:], value); err != nil || n != wgtypes.KeyLen {
+			return ""
+		}
+		defer clear(key[:])
+
+		if key == (wgtypes.Key{}) {
+			return ""
+		}
+		return key.PublicKey().String()
+	}
+	return ""
+}
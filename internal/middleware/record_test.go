@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Testing that the JSON records carry their values as fields.
+func TestWgJsonRecordLogger(t *testing.T) {
+	type testCase struct {
+		name     string
+		logLevel int
+		wantLine bool
+	}
+
+	tests := []testCase{
+		{name: "debug", logLevel: LogInfo, wantLine: true},
+		{name: "error", logLevel: LogError},
+		{name: "silent", logLevel: LogNull},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var output bytes.Buffer
+			param := LoggingStruct{LogLevel: tc.logLevel, FuncName: "brgaddwg", Pid: 42, Output: &output}
+
+			param.WgJsonRecordLogger("wg0").Info(
+				"device starting",
+				slog.String("version", "1.2.0"),
+				slog.Int("mtu", 1420),
+				slog.String("backend", "wg"),
+				slog.String("uapi_socket", "/var/run/wireguard/wg0.sock"),
+			)
+
+			if !tc.wantLine {
+				if output.Len() != 0 {
+					t.Errorf("error: got %q, want no output", output.String())
+				}
+				return
+			}
+
+			var record map[string]any
+			if err := json.Unmarshal(output.Bytes(), &record); err != nil {
+				t.Fatalf("error: invalid JSON line %q: %v", output.String(), err)
+			}
+			want := map[string]any{
+				"msg":         "device starting",
+				"version":     "1.2.0",
+				"mtu":         float64(1420),
+				"backend":     "wg",
+				"uapi_socket": "/var/run/wireguard/wg0.sock",
+				"interface":   "wg0",
+				"pid":         float64(42),
+			}
+			for key, value := range want {
+				if record[key] != value {
+					t.Errorf("error: field %q got %v, want %v", key, record[key], value)
+				}
+			}
+		})
+	}
+}
+
+// Testing the text format of the records.
+func TestTextRecordLogger(t *testing.T) {
+	var lines []string
+	verbosef := func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	records := TextRecordLogger(verbosef).With(slog.String("backend", "awg"))
+	records.Info("device starting", slog.Int("mtu", 1420), slog.String("alias", "office vpn"), slog.String("empty", ""))
+	records.Debug("private key set", slog.String("public_key", "abc="))
+
+	want := []string{
+		`device starting backend=awg mtu=1420 alias="office vpn" empty=""`,
+		`private key set backend=awg public_key=abc=`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("error: got %q, want %q", lines, want)
+	}
+}
+
+// Testing that the public key is logged once per private key, and that
+// the private key never reaches the log.
+func TestKeyLogger(t *testing.T) {
+	first, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var privateKey *wgtypes.Key
+	ipcGet := func(w io.Writer) error {
+		fmt.Fprintf(w, "listen_port=51820\n")
+		if privateKey != nil {
+			fmt.Fprintf(w, "private_key=%s\n", hex.EncodeToString(privateKey[:]))
+		}
+		return nil
+	}
+
+	var output bytes.Buffer
+	param := LoggingStruct{LogLevel: LogInfo, Output: &output}
+	keys := NewKeyLogger(param.WgJsonRecordLogger("wg0"), ipcGet)
+
+	keys.Check()
+	privateKey = &first
+	keys.Check()
+	keys.Check()
+	privateKey = &second
+	keys.Check()
+
+	got := output.String()
+	if strings.Count(got, "\n") != 2 {
+		t.Fatalf("error: got %q, want 2 records", got)
+	}
+	for _, key := range []wgtypes.Key{first, second} {
+		if !strings.Contains(got, `"public_key":"`+key.PublicKey().String()+`"`) {
+			t.Errorf("error: public key %s not logged: %s", key.PublicKey(), got)
+		}
+		if strings.Contains(got, key.String()) || strings.Contains(got, hex.EncodeToString(key[:])) {
+			t.Errorf("error: private key leaked into the log: %s", got)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+package netns
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// Network namespace of the calling thread.
+const threadNetns = "/proc/thread-self/ns/net"
+
+// Function runs fn inside the network namespace Name, on an OS thread of
+// its own locked for the call; without Name fn runs directly. Only the
+// thread is moved: fn must not hand its work to other goroutines. The
+// sockets opened by fn (e.g., a wgctrl client) stay in the namespace, and
+// so do the processes it starts.
+//
+// Usage example:
+//
+//	err := netns.Do(func() error {
+//	    ifaces, err = net.Interfaces()
+//	    return err
+//	})
+func Do(fn func() error) error {
+	if Name == "" {
+		return fn()
+	}
+
+	target, err := os.Open(filepath.Join(Dir, Name))
+	if err != nil {
+		return fmt.Errorf("error: failed to open network namespace '%s', %v", Name, err)
+	}
+	defer target.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		origin, err := os.Open(threadNetns)
+		if err != nil {
+			runtime.UnlockOSThread()
+			result <- fmt.Errorf("error: failed to open the current network namespace, %v", err)
+			return
+		}
+		defer origin.Close()
+
+		if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			result <- fmt.Errorf("error: failed to enter network namespace '%s', %v", Name, err)
+			return
+		}
+
+		fnErr := fn()
+
+		// A thread left in the namespace stays locked: it exits with the
+		// goroutine instead of running other goroutines there.
+		if err := unix.Setns(int(origin.Fd()), unix.CLONE_NEWNET); err != nil {
+			result <- errors.Join(fnErr, fmt.Errorf(
+				"error: failed to leave network namespace '%s', %v", Name, err,
+			))
+			return
+		}
+		runtime.UnlockOSThread()
+		result <- fnErr
+	}()
+	return <-result
+}
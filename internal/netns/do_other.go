@@ -0,0 +1,13 @@
+//go:build !linux
+
+package netns
+
+import "fmt"
+
+// Function runs fn, network namespaces are only supported on Linux.
+func Do(fn func() error) error {
+	if Name != "" {
+		return fmt.Errorf("error: network namespace '%s' is only supported on Linux", Name)
+	}
+	return fn()
+}
@@ -0,0 +1,128 @@
+// Package netns runs the operations of the utilities inside a named
+// network namespace (`ip netns add blue`), selected by the global -netns
+// flag. The in-process operations (wgctrl, the net package lookups, the
+// /proc/net tables) enter the namespace on a locked OS thread, see Do; the
+// external commands are prefixed with `ip netns exec`, see
+// shell.UseNamespace.
+package netns
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Dir is the directory of the named network namespaces of `ip netns`.
+var Dir = "/var/run/netns"
+
+// Name is the network namespace of the operations, empty for the namespace
+// of the process. Set by shell.UseNamespace.
+var Name string
+
+// NotFoundError reports a missing network namespace, given by name or by
+// the ID of a process in it.
+type NotFoundError struct {
+	// Target is the network namespace name or the process ID.
+	Target string
+}
+
+// Method describes the missing namespace.
+func (e *NotFoundError) Error() string {
+	if IsPid(e.Target) {
+		return fmt.Sprintf(
+			"error: process %s not found, expected a network namespace name or a process ID", e.Target,
+		)
+	}
+	return fmt.Sprintf(
+		"error: network namespace '%s' not found, create it with `ip netns add %s`", e.Target, e.Target,
+	)
+}
+
+// Method returns the stable code of the error, see help.CodeNotFound.
+func (e *NotFoundError) ErrorCode() string {
+	return "BRG-E018"
+}
+
+// Function checks a network namespace name and that the namespace exists.
+func Check(name string) error {
+	if err := CheckName(name); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(filepath.Join(Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return &NotFoundError{Target: name}
+	}
+	if err != nil {
+		return fmt.Errorf("error: failed to check network namespace '%s', %v", name, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("error: invalid network namespace '%s', %s is a directory", name, filepath.Join(Dir, name))
+	}
+	return nil
+}
+
+// Function checks a network namespace name, a file name of Dir.
+func CheckName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00") || len(name) > 255 {
+		return fmt.Errorf("error: invalid network namespace name '%s'", name)
+	}
+	return nil
+}
+
+// Function reports whether the target namespace is given by the ID of a
+// process in it (e.g., a container) rather than by name.
+func IsPid(target string) bool {
+	if target == "" {
+		return false
+	}
+	for _, r := range target {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Function returns the file of a target namespace, given by name (see Dir)
+// or by process ID (see IsPid).
+func TargetPath(target string) string {
+	if IsPid(target) {
+		return filepath.Join("/proc", target, "ns", "net")
+	}
+	return filepath.Join(Dir, target)
+}
+
+// Function checks a target namespace given by name (see Check) or by the
+// ID of a process in it, and that it exists and may be entered.
+func CheckTarget(target string) error {
+	if !IsPid(target) {
+		return Check(target)
+	}
+
+	_, err := os.Stat(TargetPath(target))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return &NotFoundError{Target: target}
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Errorf(
+			"error: permission denied to the network namespace of process %s, run as root", target,
+		)
+	case err != nil:
+		return fmt.Errorf("error: failed to check the network namespace of process %s, %v", target, err)
+	}
+	return nil
+}
+
+// Function returns the path of a /proc/net table (e.g., "/proc/net/route")
+// for the threads of Do: /proc/net follows the main thread of the process,
+// so inside the namespace the table of the calling thread is read. Other
+// paths are returned unchanged.
+func ProcPath(path string) string {
+	if rest, ok := strings.CutPrefix(path, "/proc/net/"); ok && Name != "" {
+		return "/proc/thread-self/net/" + rest
+	}
+	return path
+}
@@ -0,0 +1,129 @@
+package netns
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Function replaces Dir and Name for the duration of the test.
+func useDir(t *testing.T, dir string) {
+	t.Helper()
+	prevDir, prevName := Dir, Name
+	Dir = dir
+	t.Cleanup(func() { Dir, Name = prevDir, prevName })
+}
+
+// Testing the network namespace names and their existence.
+func TestCheck(t *testing.T) {
+	useDir(t, t.TempDir())
+	if err := os.WriteFile(filepath.Join(Dir, "blue"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(Dir, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		name    string
+		wantErr string
+	}
+
+	tests := []testCase{
+		{name: "blue"},
+		{name: "red", wantErr: "not found, create it with `ip netns add red`"},
+		{name: "", wantErr: "invalid network namespace name"},
+		{name: "..", wantErr: "invalid network namespace name"},
+		{name: "a/b", wantErr: "invalid network namespace name"},
+		{name: "dir", wantErr: "is a directory"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Check(tc.name)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error: got %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// Testing that the /proc/net tables are read from the calling thread
+// inside a network namespace only.
+func TestProcPath(t *testing.T) {
+	useDir(t, Dir)
+
+	if got := ProcPath("/proc/net/route"); got != "/proc/net/route" {
+		t.Errorf("error: got %s without a namespace", got)
+	}
+
+	Name = "blue"
+	for path, want := range map[string]string{
+		"/proc/net/route":   "/proc/thread-self/net/route",
+		"/proc/net/udp6":    "/proc/thread-self/net/udp6",
+		"/tmp/proc/net/udp": "/tmp/proc/net/udp",
+	} {
+		if got := ProcPath(path); got != want {
+			t.Errorf("error: got %s for %s, want %s", got, path, want)
+		}
+	}
+}
+
+// Testing that Do moves the thread into the network namespace and back.
+// The namespace is created with `ip netns add`, which requires root.
+func TestDo(t *testing.T) {
+	useDir(t, Dir)
+
+	// Function returns the network namespace of the thread, "net:[inode]".
+	current := func() (string, error) {
+		return os.Readlink("/proc/thread-self/ns/net")
+	}
+
+	host, err := current()
+	if err != nil {
+		t.Skipf("network namespaces are not available: %v", err)
+	}
+
+	ran := false
+	if err := Do(func() error { ran = true; return nil }); err != nil || !ran {
+		t.Fatalf("error: without a namespace got %v, ran %t", err, ran)
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skip("creating a network namespace requires root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("the ip command is not installed")
+	}
+
+	Name = "brgnetuse-test-" + strconv.Itoa(os.Getpid())
+	if out, err := exec.Command("ip", "netns", "add", Name).CombinedOutput(); err != nil {
+		t.Skipf("failed to create a network namespace: %v, %s", err, out)
+	}
+	t.Cleanup(func() { exec.Command("ip", "netns", "del", Name).Run() })
+
+	var inside string
+	if err := Do(func() (err error) {
+		inside, err = current()
+		return err
+	}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if inside == "" || inside == host {
+		t.Errorf("error: fn ran in network namespace %s, the host one is %s", inside, host)
+	}
+
+	after, err := current()
+	if err != nil || after != host {
+		t.Errorf("error: the calling thread is in network namespace %s (%v), want %s", after, err, host)
+	}
+}
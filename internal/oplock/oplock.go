@@ -0,0 +1,308 @@
+// Package serializes the operations changing the system state.
+//
+// Two brgsetwg invocations running at the same time (e.g., parallel
+// Ansible tasks) can both find a rule missing and add it twice, or
+// interleave their ip and iptables calls. Every mutating operation runs
+// under an advisory lock (flock) of a single file:
+//
+//	/run/brgnetuse.lock
+//
+// The lock is released by the kernel when the process exits, so a crashed
+// invocation never leaves it behind. Each hold opens the file on its own,
+// so another goroutine of the process waits for the lock like another
+// process does. The lock is not reentrant: a brgsetwg command takes it once
+// and hands the Lock to the set package functions it calls, which take the
+// lock themselves only when called without one (see Enter).
+//
+// A command run on a group of interfaces locks each interface on its own
+// (see AcquireInterface): it holds the file above shared, and the file of
+// the interface exclusive:
+//
+//	/run/brgnetuse.lock.wg0
+//
+// Commands on different interfaces thus run at the same time, while a
+// command taking the whole lock waits for all of them. The Lock of an
+// interface only covers the operations on it: a function handed it for
+// anything else fails rather than run under a narrower lock.
+package oplock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default path of the lock file.
+const DefaultPath string = "/run/brgnetuse.lock"
+
+// Environment variables overriding the lock file and the wait timeout
+// (a duration, e.g., "30s"; "0" fails at once when the lock is held).
+const (
+	EnvPath    string = "BRGNETUSE_LOCK_FILE"
+	EnvTimeout string = "BRGNETUSE_LOCK_TIMEOUT"
+)
+
+// Default time to wait for the lock held by another process.
+const DefaultTimeout = 10 * time.Second
+
+// Path of the lock file and the wait timeout. They are initialized from
+// EnvPath and EnvTimeout and may be replaced by callers and tests.
+var (
+	Path    = defaultPath()
+	Timeout = defaultTimeout()
+)
+
+// Interval between the attempts to take the lock held by another process.
+var retryInterval = 20 * time.Millisecond
+
+// ErrLocked is returned when the lock is still held by another process
+// after the wait timeout.
+var ErrLocked = errors.New("another brgnetuse operation is in progress")
+
+// LockedError is returned by Acquire when the wait timeout expired.
+type LockedError struct {
+	// Pid is the process holding the lock, 0 if unknown.
+	Pid int
+}
+
+// Method describes the lock holder.
+func (e *LockedError) Error() string {
+	if e.Pid == 0 {
+		return "error: " + ErrLocked.Error()
+	}
+	return fmt.Sprintf("error: %s (pid %d)", ErrLocked, e.Pid)
+}
+
+// Method makes errors.Is(err, ErrLocked) match.
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// Method returns the stable code of the error, see help.CodeLocked.
+func (e *LockedError) ErrorCode() string {
+	return "BRG-E009"
+}
+
+// Lock is a hold of the operation lock, taken by Acquire or
+// AcquireInterface. It is not reentrant: the holder hands it down the call
+// chain to the functions run under it (e.g., the set package functions),
+// which pass it to Enter instead of taking the lock again.
+type Lock struct {
+	// Network interface of the lock taken by AcquireInterface, empty for
+	// the whole lock.
+	iface string
+
+	release func()
+	once    sync.Once
+}
+
+// Method releases the lock. Only the first call releases it, and the hold
+// returned by Enter for a covered operation releases nothing.
+func (l *Lock) Release() {
+	l.once.Do(func() {
+		if l.release != nil {
+			l.release()
+		}
+	})
+}
+
+// Method reports whether the lock covers an operation on the network
+// interface, the whole system for an empty name: the whole lock covers
+// any operation, the lock of an interface the operations on it only.
+func (l *Lock) Covers(iface string) bool {
+	return l.iface == "" || l.iface == iface
+}
+
+// Function returns the lock file of a network interface, see
+// AcquireInterface.
+func InterfacePath(name string) string {
+	return Path + "." + name
+}
+
+// Function takes the whole lock exclusive, waiting up to Timeout for
+// another process or goroutine holding it. The lock is not reentrant: a
+// function called under it is handed the Lock (see Enter).
+//
+// Usage example:
+//
+//	lock, err := oplock.Acquire()
+//	if err != nil {
+//	    return err
+//	}
+//	defer lock.Release()
+func Acquire() (*Lock, error) {
+	release, err := lockFile(Path, Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Lock{release: release}, nil
+}
+
+// Function takes the lock of an operation on a single network interface:
+// the lock file shared with the operations on the other interfaces, and
+// the lock file of the interface (see InterfacePath) exclusive. The
+// returned Lock releases both, and covers the operations on the interface
+// only (see Enter).
+//
+// Usage example:
+//
+//	lock, err := oplock.AcquireInterface("wg0")
+//	if err != nil {
+//	    return err
+//	}
+//	defer lock.Release()
+func AcquireInterface(name string) (*Lock, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("error: invalid network interface name '%s'", name)
+	}
+
+	// The shared lock is released when the interface lock is refused.
+	releaseShared, err := lockFileMode(Path, Timeout, true)
+	if err != nil {
+		return nil, err
+	}
+
+	releaseIface, err := lockFile(InterfacePath(name), Timeout)
+	if err != nil {
+		releaseShared()
+		return nil, err
+	}
+
+	return &Lock{iface: name, release: func() {
+		releaseIface()
+		releaseShared()
+	}}, nil
+}
+
+// Function returns the lock of an operation on the network interface (the
+// whole system for an empty name) run by a function which may be handed
+// the Lock of its caller. When it is handed one, the operation runs under
+// it: the returned Lock releases nothing, and the hold of another
+// interface is an error rather than a silently narrower lock. Otherwise
+// the whole lock is taken by Acquire.
+//
+// Usage example:
+//
+//	func SetPeerMeta(interfaceName string, ..., held ...*oplock.Lock) error {
+//	    lock, err := oplock.Enter(interfaceName, held...)
+//	    if err != nil {
+//	        return err
+//	    }
+//	    defer lock.Release()
+//	    ...
+//	}
+func Enter(iface string, held ...*Lock) (*Lock, error) {
+	if len(held) == 0 || held[0] == nil {
+		return Acquire()
+	}
+
+	if !held[0].Covers(iface) {
+		if iface == "" {
+			return nil, fmt.Errorf(
+				"error: the operation needs the whole lock, held for network interface '%s' only",
+				held[0].iface,
+			)
+		}
+		return nil, fmt.Errorf(
+			"error: the operation on network interface '%s' runs under the lock of '%s'",
+			iface, held[0].iface,
+		)
+	}
+	return &Lock{iface: held[0].iface}, nil
+}
+
+// Function is Enter for an operation covered by the lock of any network
+// interface, e.g., the rule of a listen port, which belongs to the
+// interface listening on it (the port selection is serialized on its own,
+// see AcquirePorts).
+func EnterAny(held ...*Lock) (*Lock, error) {
+	if len(held) == 0 || held[0] == nil {
+		return Acquire()
+	}
+	return &Lock{iface: held[0].iface}, nil
+}
+
+// Function takes the lock of the listen port selection (`-p auto`), to
+// hold until the chosen port is bound. Commands on a group of interfaces
+// only hold the lock file shared (see AcquireInterface), so two of them
+// could otherwise choose the same free port. The lock file is the lock
+// file followed by "-ports".
+func AcquirePorts() (func(), error) {
+	return lockFile(Path+"-ports", Timeout)
+}
+
+// Function runs fn while holding the whole lock, handed to fn.
+func Do(fn func(lock *Lock) error) error {
+	lock, err := Acquire()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	return fn(lock)
+}
+
+// Function runs fn while holding the lock of the network interface, handed
+// to fn, see AcquireInterface.
+func DoInterface(name string, fn func(lock *Lock) error) error {
+	lock, err := AcquireInterface(name)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	return fn(lock)
+}
+
+// Function takes the exclusive flock of a file guarding a resource of its
+// own (e.g., a state file of the store package), waiting up to timeout.
+// It is independent of the operation lock and not reentrant.
+func LockFile(path string, timeout time.Duration) (func(), error) {
+	return lockFile(path, timeout)
+}
+
+// Function takes the exclusive flock of the file, see lockFileMode.
+func lockFile(path string, timeout time.Duration) (func(), error) {
+	return lockFileMode(path, timeout, false)
+}
+
+// Function returns the process id written to the lock file by its holder.
+func holderPid(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// Function returns the lock file path from EnvPath or DefaultPath.
+func defaultPath() string {
+	if path := os.Getenv(EnvPath); path != "" {
+		return path
+	}
+	return DefaultPath
+}
+
+// Function returns the wait timeout from EnvTimeout or DefaultTimeout.
+// An invalid value falls back to DefaultTimeout.
+func defaultTimeout() time.Duration {
+	value := os.Getenv(EnvTimeout)
+	if value == "" {
+		return DefaultTimeout
+	}
+	if value == "0" {
+		return 0
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return DefaultTimeout
+	}
+	return timeout
+}
@@ -0,0 +1,57 @@
+package oplock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Function takes the flock of the file, exclusive or shared, retrying
+// until the timeout, and writes the process id to it. Each call opens its
+// own file description, so two calls exclude each other even within a
+// process.
+func lockFileMode(path string, timeout time.Duration, shared bool) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("error: failed to create lock directory: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error: failed to open lock file '%s': %v", path, err)
+	}
+
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) && !errors.Is(err, syscall.EINTR) {
+			file.Close()
+			return nil, fmt.Errorf("error: failed to lock '%s': %v", path, err)
+		}
+		if !time.Now().Before(deadline) {
+			file.Close()
+			return nil, &LockedError{Pid: holderPid(path)}
+		}
+		time.Sleep(retryInterval)
+	}
+
+	// The file is never removed: another process may be waiting on it.
+	if err := file.Truncate(0); err == nil {
+		fmt.Fprintf(file, "%d\n", os.Getpid())
+	}
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,291 @@
+package oplock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Function points the lock at a temporary file for the test.
+func useLockPath(t *testing.T) string {
+	t.Helper()
+
+	prev := Path
+	Path = filepath.Join(t.TempDir(), "run", "brgnetuse.lock")
+	t.Cleanup(func() { Path = prev })
+	return Path
+}
+
+// Testing that competing lock holders never overlap.
+func TestLockFileExclusive(t *testing.T) {
+	path := useLockPath(t)
+
+	var inside, overlaps, total atomic.Int32
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 5 {
+				release, err := lockFile(path, 5*time.Second)
+				if err != nil {
+					t.Errorf("error: unexpected error: %v", err)
+					return
+				}
+				if inside.Add(1) != 1 {
+					overlaps.Add(1)
+				}
+				time.Sleep(time.Millisecond)
+				total.Add(1)
+				inside.Add(-1)
+				release()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if overlaps.Load() != 0 {
+		t.Errorf("error: %d overlapping lock holders", overlaps.Load())
+	}
+	if total.Load() != 40 {
+		t.Errorf("error: got %d critical sections, want 40", total.Load())
+	}
+}
+
+// Testing the timeout error naming the lock holder.
+func TestLockFileTimeout(t *testing.T) {
+	path := useLockPath(t)
+
+	release, err := lockFile(path, 0)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	_, err = lockFile(path, 100*time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("error: gave up after %v, want the timeout", elapsed)
+	}
+
+	var locked *LockedError
+	if !errors.As(err, &locked) || !errors.Is(err, ErrLocked) {
+		t.Fatalf("error: got %v, want LockedError", err)
+	}
+	want := fmt.Sprintf("error: another brgnetuse operation is in progress (pid %d)", os.Getpid())
+	if err.Error() != want {
+		t.Errorf("error: got %q, want %q", err.Error(), want)
+	}
+
+	// The waiting holder gets the lock once it is released.
+	done := make(chan error)
+	go func() {
+		release, err := lockFile(path, 5*time.Second)
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	release()
+
+	if err := <-done; err != nil {
+		t.Errorf("error: unexpected error: %v", err)
+	}
+}
+
+// Testing that Acquire is not reentrant, and that Enter runs under the
+// Lock handed to it or takes the lock itself.
+func TestEnter(t *testing.T) {
+	path := useLockPath(t)
+
+	prevTimeout := Timeout
+	Timeout = 0
+	t.Cleanup(func() { Timeout = prevTimeout })
+
+	outer, err := Acquire()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if _, err := Acquire(); !errors.Is(err, ErrLocked) {
+		t.Errorf("error: got %v, want the nested Acquire refused", err)
+	}
+
+	inner, err := Enter("wg0", outer)
+	if err != nil {
+		t.Fatalf("error: got %v, want the operation covered", err)
+	}
+	inner.Release()
+	if _, err := lockFile(path, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("error: got %v, want the lock still held", err)
+	}
+
+	outer.Release()
+	outer.Release()
+
+	taken, err := Enter("wg0")
+	if err != nil {
+		t.Fatalf("error: got %v, want the lock taken", err)
+	}
+	if _, err := lockFile(path, 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("error: got %v, want the lock held by Enter", err)
+	}
+	taken.Release()
+
+	release, err := lockFile(path, 0)
+	if err != nil {
+		t.Fatalf("error: got %v, want the lock released", err)
+	}
+	defer release()
+
+	calls := 0
+	err = Do(func(*Lock) error {
+		calls++
+		return nil
+	})
+	if !errors.Is(err, ErrLocked) || calls != 0 {
+		t.Errorf("error: got %v with %d calls, want the lock refused", err, calls)
+	}
+}
+
+// Testing that the lock held by a goroutine excludes the other goroutines
+// of the process, which take it once it is released.
+func TestAcquireGoroutines(t *testing.T) {
+	useLockPath(t)
+
+	prevTimeout := Timeout
+	Timeout = 0
+	t.Cleanup(func() { Timeout = prevTimeout })
+
+	lock, err := Acquire()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	refused := make(chan error)
+	go func() {
+		_, err := Acquire()
+		refused <- err
+	}()
+	if err := <-refused; !errors.Is(err, ErrLocked) {
+		t.Errorf("error: got %v, want the lock refused to another goroutine", err)
+	}
+	lock.Release()
+
+	granted := make(chan error)
+	go func() {
+		lock, err := Acquire()
+		if err == nil {
+			lock.Release()
+		}
+		granted <- err
+	}()
+	if err := <-granted; err != nil {
+		t.Errorf("error: got %v, want the released lock taken", err)
+	}
+}
+
+// Testing that the lock of an interface excludes the whole lock and the
+// same interface only, and covers the operations on the interface only.
+func TestAcquireInterface(t *testing.T) {
+	path := useLockPath(t)
+
+	prevTimeout := Timeout
+	Timeout = 0
+	t.Cleanup(func() { Timeout = prevTimeout })
+
+	lock, err := AcquireInterface("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	nested, err := Enter("wg0", lock)
+	if err != nil {
+		t.Fatalf("error: got %v, want the operation covered", err)
+	}
+	nested.Release()
+	if _, err := Enter("wg1", lock); err == nil {
+		t.Error("error: expected error for another interface, got none")
+	}
+	if _, err := Enter("", lock); err == nil {
+		t.Error("error: expected error for an operation needing the whole lock, got none")
+	}
+
+	if _, err := lockFile(path, 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("error: got %v, want the whole lock refused", err)
+	}
+	if _, err := lockFile(InterfacePath("wg0"), 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("error: got %v, want the interface lock refused", err)
+	}
+
+	other, err := lockFile(InterfacePath("wg1"), 0)
+	if err != nil {
+		t.Errorf("error: got %v, want another interface locked", err)
+	} else {
+		other()
+	}
+	shared, err := lockFileMode(path, 0, true)
+	if err != nil {
+		t.Errorf("error: got %v, want the lock shared", err)
+	} else {
+		shared()
+	}
+
+	lock.Release()
+	lock.Release()
+
+	whole, err := lockFile(path, 0)
+	if err != nil {
+		t.Fatalf("error: got %v, want the lock released", err)
+	}
+	whole()
+
+	// A refused interface lock releases the shared lock.
+	busy, err := lockFile(InterfacePath("wg0"), 0)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	defer busy()
+
+	if _, err := AcquireInterface("wg0"); !errors.Is(err, ErrLocked) {
+		t.Errorf("error: got %v, want the interface lock refused", err)
+	}
+	whole, err = lockFile(path, 0)
+	if err != nil {
+		t.Fatalf("error: got %v, want the shared lock released", err)
+	}
+	whole()
+
+	if _, err := AcquireInterface("../wg0"); err == nil {
+		t.Error("error: expected error for an invalid name, got none")
+	}
+}
+
+// Testing the wait timeout read from the environment.
+func TestDefaultTimeout(t *testing.T) {
+	type testCase struct {
+		value string
+		want  time.Duration
+	}
+
+	tests := []testCase{
+		{value: "", want: DefaultTimeout},
+		{value: "30s", want: 30 * time.Second},
+		{value: "0", want: 0},
+		{value: "-1s", want: DefaultTimeout},
+		{value: "soon", want: DefaultTimeout},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			t.Setenv(EnvTimeout, tc.value)
+			if got := defaultTimeout(); got != tc.want {
+				t.Errorf("error: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
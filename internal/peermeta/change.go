@@ -0,0 +1,72 @@
+package peermeta
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/store"
+)
+
+// Change records the last change made to the configuration of a network
+// interface, for change management (e.g., `brggetwg -i wg0 -st`).
+type Change struct {
+	// Time is the moment (UTC) of the change.
+	Time time.Time `json:"time"`
+
+	// Operation is the class of the change (e.g., "peer-add", "listen-port").
+	Operation string `json:"operation"`
+
+	// UID is the user ID of the process which made the change.
+	UID int `json:"uid"`
+}
+
+// Method reports whether no change is recorded.
+func (c Change) IsZero() bool {
+	return c.Time.IsZero()
+}
+
+// Method formats the change in local time, e.g.,
+// "2024-06-02 14:11 by uid 0 (peer-add)", or "never" when none is recorded.
+func (c Change) String() string {
+	if c.IsZero() {
+		return "never"
+	}
+	return fmt.Sprintf("%s by uid %d (%s)", c.Time.Local().Format("2006-01-02 15:04"), c.UID, c.Operation)
+}
+
+// Function returns the last change file path of the network interface.
+func ChangePath(iface string) string {
+	return filepath.Join(Dir, iface+"-change.json")
+}
+
+// Function returns the state file of the last change of the network
+// interface.
+func changeFile(iface string) store.File[Change] {
+	return store.File[Change]{Path: ChangePath(iface), Name: "last change", Schema: schema}
+}
+
+// Function reads the last change of the network interface. A missing file
+// (no change recorded yet) is not an error, the zero Change is returned.
+// A damaged file without a backup is reported and replaced by the next
+// RecordChange.
+func LoadChange(iface string) (Change, error) {
+	change, err := changeFile(iface).Load()
+	if err != nil {
+		return Change{}, err
+	}
+	return change, nil
+}
+
+// Function records a change of the operation class made now by the
+// current user as the last change of the network interface. The previous
+// record is replaced without being read, so a damaged one does not stop it.
+func RecordChange(iface, operation string) error {
+	change := Change{
+		Time:      time.Now().UTC(),
+		Operation: operation,
+		UID:       os.Getuid(),
+	}
+	return changeFile(iface).Replace(change)
+}
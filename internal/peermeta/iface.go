@@ -0,0 +1,161 @@
+package peermeta
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/store"
+)
+
+// InterfaceMeta is the metadata attached to the network interface itself.
+type InterfaceMeta struct {
+	// KeyRotated is the moment (UTC) of the last private key rotation.
+	KeyRotated time.Time `json:"key_rotated,omitzero"`
+
+	// PublicKey is the public key installed by the last rotation.
+	PublicKey string `json:"public_key,omitempty"`
+
+	// PreviousPublicKey is the public key replaced by the last rotation.
+	PreviousPublicKey string `json:"previous_public_key,omitempty"`
+
+	// Rules lists the iptables rules applied for the interface by brgsetwg.
+	Rules []Rule `json:"rules,omitempty"`
+
+	// Addresses lists the IP addresses (CIDR) assigned by brgsetwg.
+	Addresses []string `json:"addresses,omitempty"`
+
+	// DNS lists the DNS servers set by brgsetwg, in order.
+	DNS []string `json:"dns,omitempty"`
+
+	// PreviousDNS lists the DNS servers of the interface before brgsetwg
+	// set its own, restored when they are removed.
+	PreviousDNS []string `json:"previous_dns,omitempty"`
+
+	// Backend describes the implementation of an interface adopted by
+	// brgsetwg (e.g., "kernel WireGuard").
+	Backend string `json:"backend,omitempty"`
+
+	// Adopted is the moment (UTC) an interface created by another tool
+	// (e.g., wg-quick) was adopted by brgsetwg.
+	Adopted time.Time `json:"adopted,omitzero"`
+}
+
+// Rule is an iptables rule applied for the network interface.
+type Rule struct {
+	// Table is the iptables table (e.g., "filter", "nat").
+	Table string `json:"table"`
+
+	// Chain is the chain of the rule (e.g., "FORWARD", "POSTROUTING").
+	Chain string `json:"chain"`
+
+	// Spec is the rule specification (e.g., "-s 10.0.0.0/24 -o eth0 -j MASQUERADE").
+	Spec string `json:"spec"`
+}
+
+// Method records the rule, a rule already recorded is not duplicated.
+func (m *InterfaceMeta) AddRule(rule Rule) {
+	if !slices.Contains(m.Rules, rule) {
+		m.Rules = append(m.Rules, rule)
+	}
+}
+
+// Method forgets the rule.
+func (m *InterfaceMeta) RemoveRule(rule Rule) {
+	m.Rules = slices.DeleteFunc(m.Rules, func(r Rule) bool { return r == rule })
+}
+
+// Method records the address, an address already recorded is not duplicated.
+func (m *InterfaceMeta) AddAddress(cidr string) {
+	if !slices.Contains(m.Addresses, cidr) {
+		m.Addresses = append(m.Addresses, cidr)
+	}
+}
+
+// Method forgets the address.
+func (m *InterfaceMeta) RemoveAddress(cidr string) {
+	m.Addresses = slices.DeleteFunc(m.Addresses, func(a string) bool { return a == cidr })
+}
+
+// Function returns the interface metadata file path of the network interface.
+func InterfacePath(iface string) string {
+	return filepath.Join(Dir, iface+"-interface.json")
+}
+
+// Function returns the state file of the interface metadata of the
+// network interface.
+func interfaceFile(iface string) store.File[InterfaceMeta] {
+	return store.File[InterfaceMeta]{Path: InterfacePath(iface), Name: "interface metadata", Schema: schema}
+}
+
+// Function reads the interface metadata of the network interface.
+// A missing file is not an error, empty metadata is returned.
+func LoadInterface(iface string) (InterfaceMeta, error) {
+	return interfaceFile(iface).Load()
+}
+
+// Function applies fn to the interface metadata under the lock file and
+// saves the result.
+func UpdateInterface(iface string, fn func(*InterfaceMeta)) error {
+	return interfaceFile(iface).Update(func(meta *InterfaceMeta) (bool, error) {
+		fn(meta)
+		return true, nil
+	})
+}
+
+// Function moves every metadata file of the network interface with their
+// backups to the directory (e.g., a NamespaceDir once the interface moved
+// there). Missing files are not an error.
+func MoveTo(iface, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("error: failed to create metadata directory '%s': %w", dir, err)
+	}
+	for _, path := range []string{Path(iface), InterfacePath(iface), ChangePath(iface)} {
+		if err := store.Move(path, dir); err != nil {
+			return fmt.Errorf("error: failed to move metadata '%s': %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Function renames every metadata file of the network interface with their
+// backups to the files of the new name, once the interface is renamed. The
+// metadata of the new name must not exist. On failure, the files already
+// renamed are renamed back. Missing files are not an error.
+func Rename(iface, name string) error {
+	pairs := [][2]string{
+		{Path(iface), Path(name)},
+		{InterfacePath(iface), InterfacePath(name)},
+		{ChangePath(iface), ChangePath(name)},
+	}
+
+	for _, pair := range pairs {
+		if _, err := os.Stat(pair[1]); err == nil {
+			return fmt.Errorf("error: metadata '%s' of network interface '%s' already exists", pair[1], name)
+		}
+	}
+
+	for i, pair := range pairs {
+		if err := store.Rename(pair[0], pair[1]); err != nil {
+			for _, done := range pairs[:i] {
+				store.Rename(done[1], done[0])
+			}
+			return fmt.Errorf("error: failed to rename metadata '%s': %w", pair[0], err)
+		}
+	}
+	return nil
+}
+
+// Function removes every metadata file of the network interface (peer and
+// interface metadata, last change) with their backups. Missing files are
+// not an error.
+func Purge(iface string) error {
+	for _, path := range []string{Path(iface), InterfacePath(iface), ChangePath(iface)} {
+		if err := store.Remove(path); err != nil {
+			return fmt.Errorf("error: failed to remove metadata '%s': %w", path, err)
+		}
+	}
+	return nil
+}
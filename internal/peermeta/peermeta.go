@@ -0,0 +1,223 @@
+// Package stores metadata (name, note, expiry, endpoint host name, transfer
+// quota) for WireGuard peers.
+//
+// wgtypes has no notion of a peer name, so the metadata is kept next to the
+// device in a JSON file per network interface:
+//
+//	/var/lib/brgnetuse/<iface>-peers.json
+//
+// Metadata of the interface itself (e.g., the last key rotation) is kept
+// in <iface>-interface.json in the same directory, and the last change of
+// its configuration in <iface>-change.json. The files of the interfaces of
+// a network namespace (-netns) are kept in netns/<name>/.
+//
+// The files are state files of the store package: every change is a
+// load-modify-save under their lock, written atomically, with a backup of
+// the previous content, so concurrent invocations (e.g., the daemon and a
+// scheduled prune) neither corrupt them nor lose an update.
+package peermeta
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/internal/store"
+)
+
+// Default directory of the metadata files.
+const DefaultDir string = "/var/lib/brgnetuse"
+
+// Environment variable overriding the metadata directory.
+const EnvDir string = "BRGNETUSE_STATE_DIR"
+
+// Dir is the directory of the metadata files. It is initialized from
+// EnvDir (or DefaultDir) and may be replaced by callers and tests.
+var Dir = defaultDir()
+
+// Format version of the metadata files, see store.Schema. The files of
+// version 0, written before the envelope, hold the same data.
+var schema = store.Schema{Version: 1}
+
+// Meta is the metadata attached to a single peer.
+type Meta struct {
+	// Name is a short human readable peer name (e.g., "alice-laptop").
+	Name string `json:"name,omitempty"`
+
+	// Note is a free-form comment.
+	Note string `json:"note,omitempty"`
+
+	// Expires is the moment (UTC) after which the peer is pruned.
+	// The zero value means the peer never expires.
+	Expires time.Time `json:"expires,omitzero"`
+
+	// Endpoint is the endpoint the peer was configured with when given by
+	// host name (e.g., "vpn.example.com:51820"), re-resolved when its
+	// address changes. Empty for an endpoint given by IP address.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Quota is the transfer quota of the peer with its usage, see Quota.
+	// The zero value means no quota.
+	Quota Quota `json:"quota,omitzero"`
+}
+
+// Method reports whether the peer expiry has passed at the given moment.
+func (m Meta) Expired(now time.Time) bool {
+	return !m.Expires.IsZero() && !now.Before(m.Expires)
+}
+
+// Method reports whether the metadata holds no information.
+func (m Meta) IsZero() bool {
+	return m.Name == "" && m.Note == "" && m.Expires.IsZero() && m.Endpoint == "" &&
+		m.Quota.IsZero()
+}
+
+// Method reports whether two metadata values are the same.
+func (m Meta) Equal(o Meta) bool {
+	return m.Name == o.Name && m.Note == o.Note && m.Expires.Equal(o.Expires) &&
+		m.Endpoint == o.Endpoint && m.Quota.Equal(o.Quota)
+}
+
+// Store maps a peer public key (base64 encoded) to its metadata.
+type Store map[string]Meta
+
+// Function returns the metadata directory from the environment.
+func defaultDir() string {
+	if dir := os.Getenv(EnvDir); dir != "" {
+		return dir
+	}
+	return DefaultDir
+}
+
+// Function returns the metadata directory of the interfaces of the network
+// namespace, netns/<name>/ in the directory of the host ones. Dir is that
+// of the current namespace (see netns.Name).
+func NamespaceDir(name string) string {
+	host := Dir
+	if current := netns.Name; current != "" {
+		host = strings.TrimSuffix(filepath.Clean(Dir), string(filepath.Separator)+filepath.Join("netns", current))
+	}
+	return filepath.Join(host, "netns", name)
+}
+
+// Function returns the metadata file path of the network interface.
+func Path(iface string) string {
+	return filepath.Join(Dir, iface+"-peers.json")
+}
+
+// Function returns the state file of the peer metadata of the network
+// interface.
+func peersFile(iface string) store.File[Store] {
+	return store.File[Store]{Path: Path(iface), Name: "peer metadata", Schema: schema}
+}
+
+// Function reads the metadata of the network interface.
+// A missing file is not an error, an empty store is returned.
+func Load(iface string) (Store, error) {
+	metas, err := peersFile(iface).Load()
+	if err != nil {
+		return nil, err
+	}
+	if metas == nil {
+		metas = Store{}
+	}
+	return metas, nil
+}
+
+// Function applies fn to the metadata of the network interface under the
+// lock file and saves the result. The store is written only when fn
+// reports a change.
+func Update(iface string, fn func(Store) bool) error {
+	return peersFile(iface).Update(func(metas *Store) (bool, error) {
+		if *metas == nil {
+			*metas = Store{}
+		}
+		return fn(*metas), nil
+	})
+}
+
+// Function sets the metadata of a peer. Empty metadata removes the entry.
+func Set(iface, pubKey string, meta Meta) error {
+	return Modify(iface, pubKey, func(m *Meta) { *m = meta })
+}
+
+// Function applies fn to the metadata of a single peer. An entry left
+// empty by fn is removed, and nothing is created for a peer without
+// metadata when fn leaves it empty.
+func Modify(iface, pubKey string, fn func(*Meta)) error {
+	probe := Meta{}
+	fn(&probe)
+	if probe.IsZero() && !exists(iface) {
+		return nil
+	}
+
+	return Update(iface, func(s Store) bool {
+		prev, ok := s[pubKey]
+		meta := prev
+		fn(&meta)
+
+		switch {
+		case meta.IsZero():
+			if !ok {
+				return false
+			}
+			delete(s, pubKey)
+		case ok && meta.Equal(prev):
+			return false
+		default:
+			s[pubKey] = meta
+		}
+		return true
+	})
+}
+
+// Function removes the metadata of the given peers.
+// Nothing is created when the interface has no metadata file.
+func Remove(iface string, pubKeys ...string) error {
+	if !exists(iface) {
+		return nil
+	}
+
+	return Update(iface, func(s Store) bool {
+		changed := false
+		for _, key := range pubKeys {
+			if _, ok := s[key]; ok {
+				delete(s, key)
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// Function removes the metadata of every peer not listed in keep.
+// Nothing is created when the interface has no metadata file.
+func Retain(iface string, keep ...string) error {
+	if !exists(iface) {
+		return nil
+	}
+
+	keepSet := make(map[string]struct{}, len(keep))
+	for _, key := range keep {
+		keepSet[key] = struct{}{}
+	}
+
+	return Update(iface, func(s Store) bool {
+		changed := false
+		for key := range s {
+			if _, ok := keepSet[key]; !ok {
+				delete(s, key)
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// Function checks whether the metadata file of the interface exists.
+func exists(iface string) bool {
+	_, err := os.Stat(Path(iface))
+	return err == nil
+}
@@ -0,0 +1,454 @@
+package peermeta
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Function points the store at a temporary directory for the test.
+func useTempDir(t *testing.T) {
+	t.Helper()
+
+	prev := Dir
+	Dir = t.TempDir()
+	t.Cleanup(func() { Dir = prev })
+}
+
+// Testing the Set, Load and Remove functions.
+func TestSetLoadRemove(t *testing.T) {
+	useTempDir(t)
+
+	store, err := Load("wg0")
+	if err != nil || len(store) != 0 {
+		t.Fatalf("error: got %v, %v for a missing file", store, err)
+	}
+
+	if err := Set("wg0", "AAAA=", Meta{Name: "alice-laptop", Note: "office"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := Set("wg0", "BBBB=", Meta{Name: "bob-phone"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	store, err = Load("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if store["AAAA="].Name != "alice-laptop" || store["AAAA="].Note != "office" {
+		t.Errorf("error: got %+v", store["AAAA="])
+	}
+
+	if err := Remove("wg0", "AAAA="); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := Set("wg0", "BBBB=", Meta{}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	store, _ = Load("wg0")
+	if len(store) != 0 {
+		t.Errorf("error: got %v, want empty store", store)
+	}
+}
+
+// Testing that removal does not create a metadata file.
+func TestRemoveWithoutFile(t *testing.T) {
+	useTempDir(t)
+
+	if err := Remove("wg0", "AAAA="); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := Retain("wg0"); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if _, err := os.Stat(Path("wg0")); !os.IsNotExist(err) {
+		t.Errorf("error: metadata file was created: %v", err)
+	}
+}
+
+// Testing the Retain function.
+func TestRetain(t *testing.T) {
+	useTempDir(t)
+
+	for _, key := range []string{"AAAA=", "BBBB=", "CCCC="} {
+		if err := Set("wg0", key, Meta{Name: key}); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	}
+
+	if err := Retain("wg0", "BBBB="); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	store, _ := Load("wg0")
+	if len(store) != 1 || store["BBBB="].Name != "BBBB=" {
+		t.Errorf("error: got %v", store)
+	}
+}
+
+// Testing that concurrent updates do not lose entries.
+func TestConcurrentSet(t *testing.T) {
+	useTempDir(t)
+
+	const count = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, count)
+
+	for i := range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Set("wg0", fmt.Sprintf("key-%d", i), Meta{Name: fmt.Sprintf("peer-%d", i)})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	}
+
+	store, err := Load("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(store) != count {
+		t.Errorf("error: got %d entries, want %d", len(store), count)
+	}
+}
+
+// Testing that a lock file left by a crashed process does not block the
+// store: the lock is a flock released by the kernel, not the file.
+func TestStaleLock(t *testing.T) {
+	useTempDir(t)
+
+	if err := os.WriteFile(Path("wg0")+".lock", []byte("4242\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Set("wg0", "AAAA=", Meta{Name: "alice"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if store, err := Load("wg0"); err != nil || store["AAAA="].Name != "alice" {
+		t.Errorf("error: got %v, %v", store, err)
+	}
+}
+
+// Testing that a metadata file written before the versioned envelope is
+// read and rewritten in it.
+func TestLegacyFile(t *testing.T) {
+	useTempDir(t)
+
+	legacy := `{"AAAA=": {"name": "alice"}}`
+	if err := os.WriteFile(Path("wg0"), []byte(legacy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if store, err := Load("wg0"); err != nil || store["AAAA="].Name != "alice" {
+		t.Fatalf("error: got %v, %v", store, err)
+	}
+	if err := Set("wg0", "BBBB=", Meta{Name: "bob"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(Path("wg0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "{\n  \"schema\": 1,") {
+		t.Errorf("error: got file %s, want the versioned envelope", data)
+	}
+	if store, err := Load("wg0"); err != nil || store["AAAA="].Name != "alice" || store["BBBB="].Name != "bob" {
+		t.Errorf("error: got %v, %v", store, err)
+	}
+}
+
+// Testing the RecordChange and LoadChange round trip.
+func TestRecordChange(t *testing.T) {
+	useTempDir(t)
+
+	change, err := LoadChange("wg0")
+	if err != nil || !change.IsZero() || change.String() != "never" {
+		t.Fatalf("error: got %+v, %v, want no change recorded", change, err)
+	}
+
+	before := time.Now().Add(-time.Second)
+	if err := RecordChange("wg0", "peer-add"); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := RecordChange("wg0", "listen-port"); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	change, err = LoadChange("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if change.Operation != "listen-port" || change.UID != os.Getuid() ||
+		change.Time.Before(before) || change.Time.Location() != time.UTC {
+		t.Errorf("error: got %+v", change)
+	}
+
+	change = Change{Time: time.Date(2024, 6, 2, 14, 11, 30, 0, time.Local), Operation: "peer-add"}
+	if got, want := change.String(), "2024-06-02 14:11 by uid 0 (peer-add)"; got != want {
+		t.Errorf("error: got %q, want %q", got, want)
+	}
+}
+
+// Testing that a change file truncated mid-write is reported, then
+// replaced by the next change.
+func TestRecordChangeTruncated(t *testing.T) {
+	useTempDir(t)
+
+	if err := RecordChange("wg0", "peer-add"); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	info, err := os.Stat(ChangePath("wg0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(ChangePath("wg0"), info.Size()/2); err != nil {
+		t.Fatal(err)
+	}
+
+	if change, err := LoadChange("wg0"); err == nil || !change.IsZero() {
+		t.Fatalf("error: got %+v, %v, want parse error", change, err)
+	}
+
+	if err := RecordChange("wg0", "peer-delete"); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	change, err := LoadChange("wg0")
+	if err != nil || change.Operation != "peer-delete" {
+		t.Errorf("error: got %+v, %v, want peer-delete", change, err)
+	}
+	if err := Purge("wg0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ChangePath("wg0")); !os.IsNotExist(err) {
+		t.Errorf("error: change file was not purged: %v", err)
+	}
+}
+
+// Testing that MoveTo carries the metadata of the network interface into
+// the directory of a network namespace.
+func TestMoveTo(t *testing.T) {
+	useTempDir(t)
+	host := Dir
+
+	if err := Set("wg0", "key", Meta{Name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordChange("wg0", "peer-add"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := NamespaceDir("blue")
+	if dir != filepath.Join(host, "netns", "blue") {
+		t.Fatalf("error: got namespace directory %s", dir)
+	}
+	if err := MoveTo("wg0", dir); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if metas, err := Load("wg0"); err != nil || len(metas) != 0 {
+		t.Errorf("error: the metadata stayed in the host directory: %v, %v", metas, err)
+	}
+
+	Dir = dir
+	metas, err := Load("wg0")
+	if err != nil || metas["key"].Name != "alice" {
+		t.Errorf("error: got %v, %v in the namespace directory", metas, err)
+	}
+	if change, err := LoadChange("wg0"); err != nil || change.Operation != "peer-add" {
+		t.Errorf("error: got change %+v, %v in the namespace directory", change, err)
+	}
+}
+
+// Testing that Rename carries the metadata to the new name of the network
+// interface, and refuses to overwrite the metadata of that name.
+func TestRename(t *testing.T) {
+	useTempDir(t)
+
+	if err := Set("wg0", "key", Meta{Name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordChange("wg0", "peer-add"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Rename("wg0", "wg1"); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if metas, err := Load("wg0"); err != nil || len(metas) != 0 {
+		t.Errorf("error: the metadata stayed under the old name: %v, %v", metas, err)
+	}
+	if metas, err := Load("wg1"); err != nil || metas["key"].Name != "alice" {
+		t.Errorf("error: got %v, %v under the new name", metas, err)
+	}
+	if change, err := LoadChange("wg1"); err != nil || change.Operation != "peer-add" {
+		t.Errorf("error: got change %+v, %v under the new name", change, err)
+	}
+
+	if err := RecordChange("wg2", "peer-add"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rename("wg1", "wg2"); err == nil {
+		t.Error("error: expected error renaming over existing metadata, got none")
+	}
+	if metas, err := Load("wg1"); err != nil || metas["key"].Name != "alice" {
+		t.Errorf("error: a refused rename moved the metadata: %v, %v", metas, err)
+	}
+}
+
+// Testing the Quota.Sample method with counter resets and period rollovers.
+func TestQuotaSample(t *testing.T) {
+	start := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	type sample struct {
+		rx, tx uint64
+		at     time.Duration // Since start.
+	}
+
+	type testCase struct {
+		name      string
+		period    time.Duration
+		samples   []sample
+		wantUsed  uint64
+		wantReset time.Duration
+	}
+
+	tests := []testCase{
+		{
+			name:     "baseline not counted",
+			samples:  []sample{{rx: 1000, tx: 500}},
+			wantUsed: 0,
+		},
+		{
+			name:     "deltas accumulated",
+			samples:  []sample{{rx: 1000, tx: 500}, {rx: 1500, tx: 700, at: time.Hour}, {rx: 1600, tx: 900, at: 2 * time.Hour}},
+			wantUsed: 600 + 400,
+		},
+		{
+			name: "interface restart",
+			// The counters start again from 0, the 300 bytes after the
+			// restart are new traffic.
+			samples:  []sample{{rx: 1000, tx: 500}, {rx: 1200, tx: 600, at: time.Hour}, {rx: 200, tx: 100, at: 2 * time.Hour}},
+			wantUsed: 300 + 300,
+		},
+		{
+			name: "single counter reset",
+			// The receive counter was reset, the transmit one grew.
+			samples:  []sample{{rx: 1000, tx: 500}, {rx: 100, tx: 800, at: time.Hour}},
+			wantUsed: 100 + 300,
+		},
+		{
+			name:     "restart to zero",
+			samples:  []sample{{rx: 1000, tx: 500}, {rx: 0, tx: 0, at: time.Hour}, {rx: 50, tx: 50, at: 2 * time.Hour}},
+			wantUsed: 100,
+		},
+		{
+			name:   "period rollover",
+			period: day,
+			// The usage of the first day is dropped, the traffic since the
+			// last sample counts in the new period.
+			samples:   []sample{{rx: 0, tx: 0}, {rx: 5000, tx: 0, at: 12 * time.Hour}, {rx: 5100, tx: 0, at: day + time.Hour}},
+			wantUsed:  100,
+			wantReset: day,
+		},
+		{
+			name:   "several periods elapsed",
+			period: day,
+			// The period stays aligned on the first sample.
+			samples:   []sample{{rx: 0, tx: 0}, {rx: 100, tx: 0, at: 3*day + 5*time.Hour}},
+			wantUsed:  100,
+			wantReset: 3 * day,
+		},
+		{
+			name:      "rollover with a restart",
+			period:    day,
+			samples:   []sample{{rx: 0, tx: 0}, {rx: 9000, tx: 0, at: time.Hour}, {rx: 40, tx: 2, at: day + time.Hour}},
+			wantUsed:  42,
+			wantReset: day,
+		},
+		{
+			name:      "no reset without a period",
+			samples:   []sample{{rx: 0, tx: 0}, {rx: 100, tx: 0, at: 400 * day}},
+			wantUsed:  100,
+			wantReset: 0,
+		},
+		{
+			name:     "saturated",
+			samples:  []sample{{rx: 0, tx: 0}, {rx: ^uint64(0), tx: ^uint64(0), at: time.Hour}},
+			wantUsed: ^uint64(0),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			q := Quota{Limit: 1, Period: int64(tc.period / time.Second)}
+			for _, s := range tc.samples {
+				q.Sample(s.rx, s.tx, start.Add(s.at))
+			}
+
+			if q.Used != tc.wantUsed {
+				t.Errorf("error: got used %d, want %d", q.Used, tc.wantUsed)
+			}
+			if want := start.Add(tc.wantReset); !q.Reset.Equal(want) {
+				t.Errorf("error: got reset %s, want %s", q.Reset, want)
+			}
+			last := tc.samples[len(tc.samples)-1]
+			if q.Rx != last.rx || q.Tx != last.tx || !q.Sampled.Equal(start.Add(last.at)) {
+				t.Errorf("error: got last sample %d/%d at %s", q.Rx, q.Tx, q.Sampled)
+			}
+		})
+	}
+}
+
+// Testing the Quota.Over and Quota.Percent methods and the storage of a
+// quota in the metadata.
+func TestQuotaStore(t *testing.T) {
+	useTempDir(t)
+
+	q := Quota{Limit: 1000, Used: 1000}
+	if q.Over() || q.Percent() != 100 {
+		t.Errorf("error: got over %v, percent %.1f at the limit", q.Over(), q.Percent())
+	}
+	q.Used++
+	if !q.Over() {
+		t.Errorf("error: a usage over the limit is not over")
+	}
+	if (Quota{}).Over() || (Quota{}).Percent() != 0 {
+		t.Errorf("error: an empty quota is over")
+	}
+
+	key := "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	sampled := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	q.Sampled, q.Reset = sampled, sampled
+	if err := Set("wg0", key, Meta{Quota: q}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	store, err := Load("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !store[key].Quota.Equal(q) {
+		t.Errorf("error: got quota %+v, want %+v", store[key].Quota, q)
+	}
+
+	// A quota without a limit is removed with the entry.
+	if err := Modify("wg0", key, func(m *Meta) { m.Quota = Quota{} }); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if store, _ := Load("wg0"); len(store) != 0 {
+		t.Errorf("error: got store %v, want it empty", store)
+	}
+}
@@ -0,0 +1,109 @@
+package peermeta
+
+import (
+	"time"
+)
+
+// Quota is the transfer quota of a peer: the bytes it may transfer
+// (received and sent) per period, and the usage accumulated from the
+// samples of its transfer counters (see Sample).
+type Quota struct {
+	// Limit is the number of bytes allowed per period, 0 means no quota.
+	Limit uint64 `json:"limit"`
+
+	// Period is the length of a period in seconds, 0 means the quota is
+	// never reset.
+	Period int64 `json:"period,omitempty"`
+
+	// Used is the number of bytes transferred in the current period.
+	Used uint64 `json:"used"`
+
+	// Reset is the start (UTC) of the current period.
+	Reset time.Time `json:"reset,omitzero"`
+
+	// Rx and Tx are the transfer counters of the last sample, Sampled its
+	// moment (UTC); the zero Sampled means no sample was taken yet.
+	Rx      uint64    `json:"rx,omitempty"`
+	Tx      uint64    `json:"tx,omitempty"`
+	Sampled time.Time `json:"sampled,omitzero"`
+}
+
+// Method reports whether the peer has a quota.
+func (q Quota) IsZero() bool {
+	return q.Limit == 0
+}
+
+// Method reports whether two quotas are the same.
+func (q Quota) Equal(o Quota) bool {
+	return q.Limit == o.Limit && q.Period == o.Period && q.Used == o.Used &&
+		q.Reset.Equal(o.Reset) && q.Rx == o.Rx && q.Tx == o.Tx &&
+		q.Sampled.Equal(o.Sampled)
+}
+
+// Method returns the length of a period, 0 when the quota is never reset.
+func (q Quota) PeriodDuration() time.Duration {
+	return time.Duration(q.Period) * time.Second
+}
+
+// Method reports whether the usage is over the limit.
+func (q Quota) Over() bool {
+	return q.Limit > 0 && q.Used > q.Limit
+}
+
+// Method returns the usage in percent of the limit, 0 without a quota.
+func (q Quota) Percent() float64 {
+	if q.Limit == 0 {
+		return 0
+	}
+	return float64(q.Used) * 100 / float64(q.Limit)
+}
+
+// Method accumulates a sample of the transfer counters of the peer (rx and
+// tx in bytes, as read from the device) taken at now:
+//
+//   - the first sample is the baseline, the traffic before the quota was
+//     set is not counted;
+//   - a counter lower than in the last sample was reset (the interface was
+//     restarted or the peer re-added), its whole value is new traffic;
+//   - once the period has elapsed, the usage starts again from 0 at the
+//     start of the current period (a multiple of the period after Reset),
+//     with the traffic since the last sample counted in the new period.
+//
+// Traffic made between the last sample and a reset of the counters is
+// lost, so the quota is enforced at the sampling interval.
+func (q *Quota) Sample(rx, tx uint64, now time.Time) {
+	now = now.UTC()
+	if q.Reset.IsZero() {
+		q.Reset = now
+	}
+
+	if period := q.PeriodDuration(); period > 0 && !now.Before(q.Reset.Add(period)) {
+		elapsed := now.Sub(q.Reset) / period
+		q.Reset = q.Reset.Add(elapsed * period)
+		q.Used = 0
+	}
+
+	if !q.Sampled.IsZero() {
+		q.Used = addSaturated(q.Used, counterDelta(q.Rx, rx))
+		q.Used = addSaturated(q.Used, counterDelta(q.Tx, tx))
+	}
+
+	q.Rx, q.Tx, q.Sampled = rx, tx, now
+}
+
+// Function returns the bytes counted since the last sample, the whole
+// counter when it was reset since.
+func counterDelta(last, current uint64) uint64 {
+	if current < last {
+		return current
+	}
+	return current - last
+}
+
+// Function adds without wrapping around.
+func addSaturated(a, b uint64) uint64 {
+	if a > ^uint64(0)-b {
+		return ^uint64(0)
+	}
+	return a + b
+}
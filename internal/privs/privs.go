@@ -0,0 +1,121 @@
+// Package privs reports the capabilities of the process, read from the
+// effective set of /proc/self/status (CapEff). The uid alone says little:
+// a container may grant CAP_NET_ADMIN to an unprivileged user, and a root
+// process may run without it.
+package privs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// StatusPath is the status file of the process read by Effective.
+var StatusPath = "/proc/self/status"
+
+// Capability is a Linux capability, given by its number.
+type Capability uint
+
+// Capabilities used by the utilities.
+const (
+	// Netlink configuration: WireGuard devices (wgctrl), links and
+	// addresses (ip), netfilter tables (iptables).
+	NetAdmin Capability = 12
+
+	// Raw sockets, opened by iptables-legacy to read and change the tables,
+	// and by the ICMP probes when ping sockets are not allowed.
+	NetRaw Capability = 13
+)
+
+// Names of the capabilities, as in capabilities(7).
+var names = map[Capability]string{
+	NetAdmin: "CAP_NET_ADMIN",
+	NetRaw:   "CAP_NET_RAW",
+}
+
+// Method returns the name of the capability, e.g., "CAP_NET_ADMIN".
+func (c Capability) String() string {
+	if name, ok := names[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("CAP_%d", uint(c))
+}
+
+// Set is a capability set, one bit per capability.
+type Set uint64
+
+// Method reports whether the set holds the capability.
+func (s Set) Has(c Capability) bool {
+	return c < 64 && s&(1<<c) != 0
+}
+
+// Method returns the capabilities of caps the set lacks.
+func (s Set) Missing(caps ...Capability) []Capability {
+	var missing []Capability
+	for _, c := range caps {
+		if !s.Has(c) {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+// Requirement is a subsystem of the utilities and the capabilities it
+// needs.
+type Requirement struct {
+	Subsystem    string
+	Capabilities []Capability
+}
+
+// Requirements lists the subsystems needing a capability. The others
+// (interface listing, forwarding state, sockets) are readable without.
+var Requirements = []Requirement{
+	{Subsystem: "WireGuard interfaces and peers (wgctrl)", Capabilities: []Capability{NetAdmin}},
+	{Subsystem: "links and addresses (ip)", Capabilities: []Capability{NetAdmin}},
+	{Subsystem: "firewall and NAT rules (iptables-nft)", Capabilities: []Capability{NetAdmin}},
+	{Subsystem: "firewall and NAT rules (iptables-legacy)", Capabilities: []Capability{NetAdmin, NetRaw}},
+	{Subsystem: "ICMP probes (raw sockets)", Capabilities: []Capability{NetRaw}},
+}
+
+// Function returns the effective capability set of the process.
+//
+// Usage example:
+//
+//	set, err := privs.Effective()
+//	if err == nil && set.Has(privs.NetAdmin) {
+//	    // Configure the device
+//	}
+func Effective() (Set, error) {
+	file, err := os.Open(StatusPath)
+	if err != nil {
+		return 0, fmt.Errorf("error: failed to read the capabilities of the process: %v", err)
+	}
+	defer file.Close()
+
+	return ParseStatus(file)
+}
+
+// Function returns the effective capability set (CapEff) of a process
+// status file, e.g., "CapEff:\t000001ffffffffff".
+func ParseStatus(status io.Reader) (Set, error) {
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+
+		set, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("error: invalid effective capability set '%s'", strings.TrimSpace(value))
+		}
+		return Set(set), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("error: failed to read the capabilities of the process: %v", err)
+	}
+	return 0, fmt.Errorf("error: no effective capability set in the process status")
+}
@@ -0,0 +1,106 @@
+package privs
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Testing the ParseStatus function on captured status files.
+func TestParseStatus(t *testing.T) {
+	type testCase struct {
+		name        string
+		status      string
+		wantAdmin   bool
+		wantRaw     bool
+		wantError   bool
+		wantMissing []Capability
+	}
+
+	tests := []testCase{
+		{
+			// Root on the host, the full bounding set.
+			name:      "root",
+			status:    "Name:\tbrgsetwg\nUid:\t0\t0\t0\t0\nCapInh:\t0000000000000000\nCapPrm:\t000001ffffffffff\nCapEff:\t000001ffffffffff\n",
+			wantAdmin: true, wantRaw: true,
+		},
+		{
+			// Docker default: root without CAP_NET_ADMIN, with CAP_NET_RAW.
+			name:        "container root",
+			status:      "Uid:\t0\t0\t0\t0\nCapPrm:\t00000000a80425fb\nCapEff:\t00000000a80425fb\n",
+			wantRaw:     true,
+			wantMissing: []Capability{NetAdmin},
+		},
+		{
+			// `--cap-add NET_ADMIN` granted to an unprivileged user (ambient).
+			name:        "user with net admin",
+			status:      "Uid:\t1000\t1000\t1000\t1000\nCapAmb:\t0000000000001000\nCapEff:\t0000000000001000\n",
+			wantAdmin:   true,
+			wantMissing: []Capability{NetRaw},
+		},
+		{
+			// Permitted but not effective (not raised).
+			name:        "permitted only",
+			status:      "Uid:\t1000\t1000\t1000\t1000\nCapPrm:\t0000000000003000\nCapEff:\t0000000000000000\n",
+			wantMissing: []Capability{NetAdmin, NetRaw},
+		},
+		{name: "malformed", status: "CapEff:\tnot-hex\n", wantError: true},
+		{name: "missing", status: "Name:\tbrgsetwg\n", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			set, err := ParseStatus(strings.NewReader(tc.status))
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			if set.Has(NetAdmin) != tc.wantAdmin || set.Has(NetRaw) != tc.wantRaw {
+				t.Errorf("error: got NET_ADMIN %v, NET_RAW %v, want %v, %v",
+					set.Has(NetAdmin), set.Has(NetRaw), tc.wantAdmin, tc.wantRaw)
+			}
+			if got := set.Missing(NetAdmin, NetRaw); !slices.Equal(got, tc.wantMissing) {
+				t.Errorf("error: got missing %v, want %v", got, tc.wantMissing)
+			}
+		})
+	}
+}
+
+// Testing the Effective function on a status file.
+func TestEffective(t *testing.T) {
+	prev := StatusPath
+	StatusPath = filepath.Join(t.TempDir(), "status")
+	t.Cleanup(func() { StatusPath = prev })
+
+	if _, err := Effective(); err == nil {
+		t.Fatal("error: expected error for a missing status file, got none")
+	}
+
+	if err := os.WriteFile(StatusPath, []byte("CapEff:\t0000000000001000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	set, err := Effective()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !set.Has(NetAdmin) || set.Has(NetRaw) {
+		t.Errorf("error: got set %x, want CAP_NET_ADMIN only", uint64(set))
+	}
+}
+
+// Testing the names of the capabilities.
+func TestCapabilityString(t *testing.T) {
+	for c, want := range map[Capability]string{NetAdmin: "CAP_NET_ADMIN", NetRaw: "CAP_NET_RAW", 21: "CAP_21"} {
+		if got := c.String(); got != want {
+			t.Errorf("error: got %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+//go:build !windows
+
+// Package probe measures the round-trip time to a host with ICMP echo
+// requests or, when ICMP is blocked, with UDP probes to a closed port
+// answered by an ICMP port unreachable (as traceroute does). The
+// connections are opened through package variables, replaced in tests.
+package probe
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/privs"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Defaults of the options.
+const (
+	DefaultCount    = 3
+	DefaultTimeout  = time.Second
+	DefaultInterval = 200 * time.Millisecond
+
+	// First port of the traceroute range, unlikely to be listened on. A
+	// WireGuard port is not probed: it drops what it cannot authenticate,
+	// without an answer.
+	DefaultUDPPort = 33434
+)
+
+// Protocol numbers of the ICMP messages, see icmp.ParseMessage.
+const (
+	protocolICMP   = 1
+	protocolICMPv6 = 58
+)
+
+// Methods of the probes.
+const (
+	MethodICMP = "icmp"
+	MethodUDP  = "udp"
+)
+
+// ErrNoICMP is returned when neither an unprivileged ping socket nor a raw
+// socket can be opened. It matches os.ErrPermission.
+var ErrNoICMP error = noICMPError{}
+
+// Error of the missing ICMP sockets, see ErrNoICMP.
+type noICMPError struct{}
+
+// Method returns the message of the error.
+func (noICMPError) Error() string {
+	return "error: ICMP echo requests require CAP_NET_RAW or ping sockets allowed for the group of the process (sysctl net.ipv4.ping_group_range), probe with UDP instead"
+}
+
+// Method reports the error as a permission failure.
+func (noICMPError) Is(target error) bool {
+	return target == os.ErrPermission
+}
+
+// Options of a probe. Zero Count and Timeout select the defaults, a zero
+// Interval sends the probes back to back.
+type Options struct {
+	// Number of probes sent.
+	Count int
+
+	// Time waited for the answer of each probe.
+	Timeout time.Duration
+
+	// Minimal time between the start of two probes.
+	Interval time.Duration
+
+	// Destination port of the UDP probes, DefaultUDPPort when zero.
+	Port int
+}
+
+// Method returns the options with the defaults of the zero fields.
+func (o Options) withDefaults() Options {
+	if o.Count <= 0 {
+		o.Count = DefaultCount
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if o.Port == 0 {
+		o.Port = DefaultUDPPort
+	}
+	return o
+}
+
+// Result holds the round-trip times of the answered probes.
+type Result struct {
+	Method   string
+	Target   netip.Addr
+	Sent     int
+	Received int
+	Min      time.Duration
+	Avg      time.Duration
+	Max      time.Duration
+}
+
+// Method returns the percentage of the probes left unanswered.
+func (r Result) Loss() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Sent-r.Received) * 100 / float64(r.Sent)
+}
+
+// Method adds the round-trip time of an answered probe.
+func (r *Result) add(rtt time.Duration, total *time.Duration) {
+	if r.Received == 0 || rtt < r.Min {
+		r.Min = rtt
+	}
+	if rtt > r.Max {
+		r.Max = rtt
+	}
+	r.Received++
+	*total += rtt
+	r.Avg = *total / time.Duration(r.Received)
+}
+
+// ListenICMP opens the connection of the ICMP echo requests to the address
+// and returns it with the destination to write to. An unprivileged ping
+// socket is tried first, then a raw socket, which requires CAP_NET_RAW
+// (see privs). ErrNoICMP is returned when neither is allowed.
+// Tests replace it with a fake connection.
+var ListenICMP = func(addr netip.Addr) (net.PacketConn, net.Addr, error) {
+	ping, raw, wildcard := "udp4", "ip4:icmp", "0.0.0.0"
+	if addr.Is6() {
+		ping, raw, wildcard = "udp6", "ip6:ipv6-icmp", "::"
+	}
+
+	if conn, err := icmp.ListenPacket(ping, wildcard); err == nil {
+		return conn, &net.UDPAddr{IP: addr.AsSlice(), Zone: addr.Zone()}, nil
+	}
+
+	if set, err := privs.Effective(); err == nil && !set.Has(privs.NetRaw) {
+		return nil, nil, ErrNoICMP
+	}
+	conn, err := icmp.ListenPacket(raw, wildcard)
+	if errors.Is(err, os.ErrPermission) {
+		return nil, nil, ErrNoICMP
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error: failed to open the ICMP socket: %v", err)
+	}
+	return conn, &net.IPAddr{IP: addr.AsSlice(), Zone: addr.Zone()}, nil
+}
+
+// DialUDP opens the connected UDP socket of the UDP probes. Tests replace
+// it with a fake connection.
+var DialUDP = func(addr netip.AddrPort) (net.Conn, error) {
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(addr))
+	if err != nil {
+		return nil, fmt.Errorf("error: failed to open the UDP socket: %v", err)
+	}
+	return conn, nil
+}
+
+// Function sends opts.Count ICMP echo requests to the address and returns
+// the round-trip times of the replies. The replies are matched by their
+// sequence number and a random payload: a ping socket replaces the
+// identifier of the requests with its own.
+//
+// Usage example:
+//
+//	result, err := probe.ICMP(netip.MustParseAddr("203.0.113.7"), probe.Options{})
+//	if errors.Is(err, probe.ErrNoICMP) {
+//	    // Probe with UDP instead
+//	}
+func ICMP(addr netip.Addr, opts Options) (Result, error) {
+	opts = opts.withDefaults()
+	addr = addr.Unmap()
+
+	conn, dst, err := ListenICMP(addr)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	var request icmp.Type = ipv4.ICMPTypeEcho
+	var reply icmp.Type = ipv4.ICMPTypeEchoReply
+	protocol := protocolICMP
+	if addr.Is6() {
+		request, reply, protocol = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply, protocolICMPv6
+	}
+
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return Result{}, fmt.Errorf("error: failed to generate the probe payload: %v", err)
+	}
+
+	result := Result{Method: MethodICMP, Target: addr}
+	var total time.Duration
+	buf := make([]byte, 1500)
+
+	for seq := 1; seq <= opts.Count; seq++ {
+		message := icmp.Message{Type: request, Body: &icmp.Echo{
+			ID: os.Getpid() & 0xffff, Seq: seq, Data: token,
+		}}
+		packet, err := message.Marshal(nil)
+		if err != nil {
+			return result, fmt.Errorf("error: failed to encode the echo request: %v", err)
+		}
+
+		start := time.Now()
+		if _, err := conn.WriteTo(packet, dst); err != nil {
+			return result, fmt.Errorf("error: failed to send the echo request to '%s': %v", addr, err)
+		}
+		result.Sent++
+
+		deadline := start.Add(opts.Timeout)
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return result, fmt.Errorf("error: failed to set the probe timeout: %v", err)
+		}
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				return result, fmt.Errorf("error: failed to read the echo reply from '%s': %v", addr, err)
+			}
+
+			answer, err := icmp.ParseMessage(protocol, buf[:n])
+			if err != nil || answer.Type != reply {
+				continue
+			}
+			if echo, ok := answer.Body.(*icmp.Echo); ok && echo.Seq == seq && bytes.Equal(echo.Data, token) {
+				result.add(time.Since(start), &total)
+				break
+			}
+		}
+
+		if seq < opts.Count {
+			time.Sleep(time.Until(start.Add(opts.Interval)))
+		}
+	}
+	return result, nil
+}
+
+// Function sends opts.Count UDP datagrams to a closed port of the address
+// and returns the round-trip times of the answers: the ICMP port
+// unreachable, reported as ECONNREFUSED on the connected socket, or a
+// datagram when the port is open. A firewall dropping the probes leaves
+// them unanswered.
+func UDP(addr netip.Addr, opts Options) (Result, error) {
+	opts = opts.withDefaults()
+	addr = addr.Unmap()
+
+	conn, err := DialUDP(netip.AddrPortFrom(addr, uint16(opts.Port)))
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	result := Result{Method: MethodUDP, Target: addr}
+	var total time.Duration
+	buf := make([]byte, 1500)
+
+	for seq := 1; seq <= opts.Count; seq++ {
+		start := time.Now()
+		if _, err := conn.Write([]byte("brgnetuse probe")); err != nil && !errors.Is(err, syscall.ECONNREFUSED) {
+			return result, fmt.Errorf("error: failed to send the UDP probe to '%s': %v", addr, err)
+		}
+		result.Sent++
+
+		if err := conn.SetReadDeadline(start.Add(opts.Timeout)); err != nil {
+			return result, fmt.Errorf("error: failed to set the probe timeout: %v", err)
+		}
+		_, err := conn.Read(buf)
+		switch {
+		case err == nil, errors.Is(err, syscall.ECONNREFUSED):
+			result.add(time.Since(start), &total)
+		case errors.Is(err, os.ErrDeadlineExceeded):
+		default:
+			return result, fmt.Errorf("error: failed to read the answer of the UDP probe from '%s': %v", addr, err)
+		}
+
+		if seq < opts.Count {
+			time.Sleep(time.Until(start.Add(opts.Interval)))
+		}
+	}
+	return result, nil
+}
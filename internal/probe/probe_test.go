@@ -0,0 +1,154 @@
+//go:build !windows
+
+package probe
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// Fake ICMP connection answering the echo requests, except the dropped
+// sequence numbers. A stray reply with another payload precedes each one.
+type fakeConn struct {
+	drop     map[int]bool
+	replies  chan []byte
+	deadline time.Time
+	written  net.Addr
+}
+
+func (c *fakeConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.written = addr
+	message, err := icmp.ParseMessage(protocolICMP, b)
+	if err != nil {
+		return 0, err
+	}
+	echo := message.Body.(*icmp.Echo)
+	if c.drop[echo.Seq] {
+		return len(b), nil
+	}
+
+	for _, data := range [][]byte{[]byte("stray"), echo.Data} {
+		reply := icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 1, Seq: echo.Seq, Data: data}}
+		packet, _ := reply.Marshal(nil)
+		c.replies <- packet
+	}
+	return len(b), nil
+}
+
+func (c *fakeConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case packet := <-c.replies:
+		return copy(b, packet), nil, nil
+	case <-time.After(time.Until(c.deadline)):
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *fakeConn) SetReadDeadline(t time.Time) error  { c.deadline = t; return nil }
+func (c *fakeConn) Close() error                       { return nil }
+func (c *fakeConn) LocalAddr() net.Addr                { return nil }
+func (c *fakeConn) SetDeadline(t time.Time) error      { return nil }
+func (c *fakeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// Testing the echo requests answered, dropped and refused.
+func TestICMP(t *testing.T) {
+	type testCase struct {
+		name         string
+		drop         map[int]bool
+		listenErr    error
+		wantReceived int
+		wantLoss     float64
+		wantError    error
+	}
+
+	tests := []testCase{
+		{name: "all answered", wantReceived: 3},
+		{name: "one lost", drop: map[int]bool{2: true}, wantReceived: 2, wantLoss: 100.0 / 3},
+		{name: "all lost", drop: map[int]bool{1: true, 2: true, 3: true}, wantLoss: 100},
+		{name: "no socket", listenErr: ErrNoICMP, wantError: os.ErrPermission},
+	}
+
+	target := netip.MustParseAddr("192.0.2.1")
+	prev := ListenICMP
+	t.Cleanup(func() { ListenICMP = prev })
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &fakeConn{drop: tc.drop, replies: make(chan []byte, 8)}
+			ListenICMP = func(addr netip.Addr) (net.PacketConn, net.Addr, error) {
+				if tc.listenErr != nil {
+					return nil, nil, tc.listenErr
+				}
+				return conn, &net.UDPAddr{IP: addr.AsSlice()}, nil
+			}
+
+			result, err := ICMP(target, Options{Timeout: 50 * time.Millisecond})
+			if tc.wantError != nil {
+				if !errors.Is(err, tc.wantError) {
+					t.Fatalf("error: got %v, want %v", err, tc.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			if result.Method != MethodICMP || result.Target != target || result.Sent != DefaultCount {
+				t.Errorf("error: got %+v", result)
+			}
+			if result.Received != tc.wantReceived || result.Loss() != tc.wantLoss {
+				t.Errorf("error: got %d received, %.1f%% loss, want %d, %.1f%%",
+					result.Received, result.Loss(), tc.wantReceived, tc.wantLoss)
+			}
+			if result.Received > 0 && (result.Min > result.Avg || result.Avg > result.Max) {
+				t.Errorf("error: got min %v, avg %v, max %v", result.Min, result.Avg, result.Max)
+			}
+			if conn.written.String() != "192.0.2.1:0" {
+				t.Errorf("error: got destination %v", conn.written)
+			}
+		})
+	}
+}
+
+// Testing the UDP probes to a closed port of the loopback, answered by a
+// port unreachable.
+func TestUDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	port := listener.LocalAddr().(*net.UDPAddr).Port
+	listener.Close()
+
+	result, err := UDP(netip.MustParseAddr("127.0.0.1"), Options{Count: 2, Timeout: time.Second, Port: port})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if result.Method != MethodUDP || result.Sent != 2 || result.Received != 2 || result.Loss() != 0 {
+		t.Errorf("error: got %+v", result)
+	}
+}
+
+// Testing the loss of the results.
+func TestResultLoss(t *testing.T) {
+	for _, tc := range []struct {
+		result Result
+		want   float64
+	}{
+		{Result{}, 0},
+		{Result{Sent: 4, Received: 4}, 0},
+		{Result{Sent: 4, Received: 1}, 75},
+		{Result{Sent: 2}, 100},
+	} {
+		if got := tc.result.Loss(); got != tc.want {
+			t.Errorf("error: got %v for %+v, want %v", got, tc.result, tc.want)
+		}
+	}
+}
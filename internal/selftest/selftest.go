@@ -0,0 +1,436 @@
+//go:build !windows
+
+// Package selftest proves that the utilities work end to end on a host: it
+// builds a loopback tunnel between two userspace WireGuard devices, the
+// second one moved into a scratch network namespace, pings across it,
+// checks the handshake and the transfer counters, and tears everything
+// down. It exercises the add, set and get packages and the shell layer
+// together, and reports pass or fail per stage (`brgnet selftest`).
+package selftest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/add"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/validate"
+)
+
+// Default scratch names of the test, refused when already in use.
+const (
+	DefaultInterface = "brgst0"
+	DefaultPeer      = "brgst1"
+	DefaultNamespace = "brgselftest"
+)
+
+// Tunnel addresses of the devices, a /30 of the reserved 10.0.0.0/8.
+const (
+	localAddr = "10.254.254.1/30"
+	peerAddr  = "10.254.254.2/30"
+)
+
+// Options configures a self-test run, the defaults apply to empty fields.
+type Options struct {
+	// Interface is the device of the host namespace, DefaultInterface when
+	// empty.
+	Interface string
+
+	// Peer is the device moved into Namespace, DefaultPeer when empty.
+	Peer string
+
+	// Namespace is the scratch network namespace, DefaultNamespace when
+	// empty. It is created by the test and deleted afterwards.
+	Namespace string
+
+	// Pings is the number of echo requests sent across the tunnel, 3 when 0.
+	Pings int
+}
+
+// Method fills the defaults of the options.
+func (o *Options) defaults() {
+	if o.Interface == "" {
+		o.Interface = DefaultInterface
+	}
+	if o.Peer == "" {
+		o.Peer = DefaultPeer
+	}
+	if o.Namespace == "" {
+		o.Namespace = DefaultNamespace
+	}
+	if o.Pings == 0 {
+		o.Pings = 3
+	}
+}
+
+// StageResult is the outcome of one stage of the test.
+type StageResult struct {
+	Name    string
+	Err     error
+	Elapsed time.Duration
+}
+
+// Report lists the stages run, in order. The cleanup stage is always last.
+type Report struct {
+	Stages []StageResult
+}
+
+// Method reports whether every stage passed.
+func (r Report) Passed() bool {
+	return !slices.ContainsFunc(r.Stages, func(s StageResult) bool { return s.Err != nil })
+}
+
+// Method writes one line per stage and the verdict, the error of a failed
+// stage indented below it.
+func (r Report) Write(w io.Writer) error {
+	var b strings.Builder
+	for _, s := range r.Stages {
+		status := "PASS"
+		if s.Err != nil {
+			status = "FAIL"
+		}
+		fmt.Fprintf(&b, "%s  %-10s %s\n", status, s.Name, s.Elapsed.Round(time.Millisecond))
+		if s.Err != nil {
+			for _, line := range strings.Split(s.Err.Error(), "\n") {
+				fmt.Fprintf(&b, "      %s\n", line)
+			}
+		}
+	}
+	if r.Passed() {
+		b.WriteString("selftest passed\n")
+	} else {
+		b.WriteString("selftest failed\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// A stage of the test. It registers the undo of every change it makes
+// before moving on, see run.undo.
+type stage struct {
+	name string
+	run  func(*run) error
+}
+
+// Stages of the test, in order. Replaced by the tests.
+var stages = []stage{
+	{name: "preflight", run: (*run).preflight},
+	{name: "namespace", run: (*run).namespace},
+	{name: "devices", run: (*run).devices},
+	{name: "addresses", run: (*run).addresses},
+	{name: "peers", run: (*run).peers},
+	{name: "ping", run: (*run).ping},
+	{name: "counters", run: (*run).counters},
+}
+
+// Lookups of the preflight stage. Replaced by the tests.
+var (
+	interfaceExists = get.GetExistInterface
+	namespaceExists = func(name string) bool {
+		_, err := os.Stat(filepath.Join(netns.Dir, name))
+		return err == nil
+	}
+)
+
+// An undo registered by a stage.
+type undo struct {
+	name string
+	fn   func() error
+}
+
+// State of a self-test run.
+type run struct {
+	opts  Options
+	undos []undo
+
+	// Whether the preflight found the names free, see cleanup.
+	claimed bool
+}
+
+// Function runs the self-test and returns its report. The stages run in
+// order until one fails; the changes made so far are then undone in
+// reverse order, also after a failed or panicking stage, so a run leaves
+// no residue. The test refuses to start when an interface or the network
+// namespace of the options already exists.
+//
+// Usage example:
+//
+//	report := selftest.Run(selftest.Options{})
+//	report.Write(os.Stdout)
+//	if !report.Passed() {
+//	    os.Exit(1)
+//	}
+func Run(opts Options) (report Report) {
+	opts.defaults()
+	r := &run{opts: opts}
+
+	// Deferred, so the cleanup runs whatever happens to the stages.
+	defer func() {
+		report.Stages = append(report.Stages, r.timed("cleanup", (*run).cleanup))
+	}()
+
+	for _, s := range stages {
+		result := r.timed(s.name, s.run)
+		report.Stages = append(report.Stages, result)
+		if result.Err != nil {
+			break
+		}
+	}
+	return report
+}
+
+// Method runs a stage and times it. A panic of the stage is returned as
+// its error, so the following undos still run.
+func (r *run) timed(name string, fn func(*run) error) (result StageResult) {
+	start := time.Now()
+	defer func() {
+		if v := recover(); v != nil {
+			result.Err = fmt.Errorf("error: stage '%s' panicked: %v", name, v)
+		}
+		result.Name, result.Elapsed = name, time.Since(start)
+	}()
+
+	return StageResult{Err: fn(r)}
+}
+
+// Method registers the undo of a change, run by cleanup.
+func (r *run) undo(name string, fn func() error) {
+	r.undos = append(r.undos, undo{name: name, fn: fn})
+}
+
+// Method runs the registered undos in reverse order, each one even if
+// another failed or panicked, and returns their errors. Once the preflight
+// passed, the interfaces must be gone afterwards.
+func (r *run) cleanup() error {
+	var errs []error
+	for _, u := range slices.Backward(r.undos) {
+		if err := runUndo(u); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	r.undos = nil
+
+	if !r.claimed {
+		return errors.Join(errs...)
+	}
+	for _, name := range []string{r.opts.Interface, r.opts.Peer} {
+		if exists, err := interfaceExists(name); err != nil || exists {
+			errs = append(errs, fmt.Errorf("error: network interface '%s' left behind", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Function runs an undo, a panic is returned as its error.
+func runUndo(u undo) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("error: failed to %s: %v", u.name, v)
+		}
+	}()
+	if err := u.fn(); err != nil {
+		return fmt.Errorf("error: failed to %s: %v", u.name, err)
+	}
+	return nil
+}
+
+// Method checks the scratch names and refuses the ones in use: the test
+// would otherwise reconfigure, then remove, an interface it did not create.
+func (r *run) preflight() error {
+	for _, name := range []string{r.opts.Interface, r.opts.Peer} {
+		if err := validate.CheckInterfaceName(name); err != nil {
+			return err
+		}
+		exists, err := interfaceExists(name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("error: network interface '%s' already exists, remove it or choose another name", name)
+		}
+	}
+	if r.opts.Interface == r.opts.Peer {
+		return fmt.Errorf("error: the interface and the peer share the name '%s'", r.opts.Interface)
+	}
+
+	if err := netns.CheckName(r.opts.Namespace); err != nil {
+		return err
+	}
+	if namespaceExists(r.opts.Namespace) {
+		return fmt.Errorf("error: network namespace '%s' already exists, remove it or choose another name", r.opts.Namespace)
+	}
+	r.claimed = true
+	return nil
+}
+
+// Method creates the scratch network namespace of the peer device.
+func (r *run) namespace() error {
+	if err := shell.DefaultRunner.Run(shell.FormatCmdNetnsAdd(r.opts.Namespace), false); err != nil {
+		return err
+	}
+	r.undo("delete network namespace '"+r.opts.Namespace+"'", func() error {
+		return shell.DefaultRunner.Run(shell.FormatCmdNetnsDelete(r.opts.Namespace), false)
+	})
+	return nil
+}
+
+// Method starts both userspace devices with fresh keys, then moves the
+// peer device into the namespace. Their UDP sockets stay in the host
+// namespace, so the tunnel runs over 127.0.0.1.
+func (r *run) devices() error {
+	for _, name := range []string{r.opts.Interface, r.opts.Peer} {
+		if err := r.start(name); err != nil {
+			return err
+		}
+	}
+
+	// The device is stopped before the namespace is deleted, see cleanup.
+	return shell.DefaultRunner.Run(shell.FormatCmdIpLinkNetns(r.opts.Peer, r.opts.Namespace), false)
+}
+
+// Method starts a device with a fresh key and registers its stop, which
+// removes its link and metadata.
+func (r *run) start(name string) error {
+	keys, err := get.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	defer keys.Zero()
+
+	d, err := add.Start(add.Options{InterfaceName: name, PrivateKey: &keys.Private, Cleanup: true})
+	if err != nil {
+		return err
+	}
+	r.undo("stop network interface '"+name+"'", func() error {
+		return errors.Join(d.Stop(), peermeta.Purge(name))
+	})
+	return nil
+}
+
+// Method sets the tunnel addresses and brings both links up, the peer
+// one inside the namespace.
+func (r *run) addresses() error {
+	inside := shell.NamespaceRunner{Name: r.opts.Namespace, Runner: shell.DefaultRunner}
+	for _, link := range []struct {
+		runner shell.Runner
+		name   string
+		addr   string
+	}{
+		{runner: shell.DefaultRunner, name: r.opts.Interface, addr: localAddr},
+		{runner: inside, name: r.opts.Peer, addr: peerAddr},
+	} {
+		if err := link.runner.Run(shell.FormatCmdIpAddrDev(link.name, link.addr, shell.IpAdd), false); err != nil {
+			return err
+		}
+		if err := link.runner.Run(shell.FormatCmdIpLinkSet(link.name, shell.IpUp), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Method configures each device as the peer of the other, its endpoint the
+// listen port of the other on 127.0.0.1.
+func (r *run) peers() error {
+	local, err := device(r.opts.Interface)
+	if err != nil {
+		return err
+	}
+	peer, err := device(r.opts.Peer)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range []set.SinglePeerStructure{
+		{
+			InterfaceName: local.Name,
+			PublicKey:     peer.PublicKey,
+			AllowedIPs:    []string{hostPrefix(peerAddr)},
+			EndpointHost:  "127.0.0.1:" + strconv.Itoa(peer.ListenPort),
+		},
+		{
+			InterfaceName: peer.Name,
+			PublicKey:     local.PublicKey,
+			AllowedIPs:    []string{hostPrefix(localAddr)},
+			EndpointHost:  "127.0.0.1:" + strconv.Itoa(local.ListenPort),
+		},
+	} {
+		if err := p.AddPeer(false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Method pings the peer address across the tunnel.
+func (r *run) ping() error {
+	cmd := shell.FormatCmdPing(r.opts.Interface, addrOf(peerAddr), r.opts.Pings)
+	if err := shell.DefaultRunner.Run(cmd, false); err != nil {
+		return fmt.Errorf("error: no reply across the tunnel: %v", err)
+	}
+	return nil
+}
+
+// Method checks that both devices completed a handshake and moved data
+// both ways.
+func (r *run) counters() error {
+	var errs []error
+	for _, name := range []string{r.opts.Interface, r.opts.Peer} {
+		d, err := device(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(d.Peers) != 1 {
+			errs = append(errs, fmt.Errorf("error: network interface '%s' has %d peers, want 1", name, len(d.Peers)))
+			continue
+		}
+
+		p := d.Peers[0]
+		if p.LastHandshake == "" {
+			errs = append(errs, fmt.Errorf("error: network interface '%s' completed no handshake", name))
+		}
+		if p.ReceiveBytes == 0 || p.TransmitBytes == 0 {
+			errs = append(errs, fmt.Errorf(
+				"error: network interface '%s' received %d and sent %d bytes, want both moving",
+				name, p.ReceiveBytes, p.TransmitBytes,
+			))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Function reads a device through wgctrl, which reaches a userspace device
+// through its UAPI socket whatever its namespace.
+func device(name string) (get.DeviceInfo, error) {
+	devices, err := get.GetPeerInfo(name)
+	if err != nil {
+		return get.DeviceInfo{}, err
+	}
+	if len(devices) != 1 {
+		return get.DeviceInfo{}, fmt.Errorf("error: failed to get device '%s'", name)
+	}
+	return devices[0], nil
+}
+
+// Function returns the address of an interface address in CIDR notation.
+func addrOf(cidr string) string {
+	addr, _, _ := strings.Cut(cidr, "/")
+	return addr
+}
+
+// Function returns the host prefix (/32) of an interface address.
+func hostPrefix(cidr string) string {
+	return addrOf(cidr) + "/32"
+}
@@ -0,0 +1,189 @@
+//go:build !windows
+
+package selftest
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Function replaces the stages and the lookups for the duration of the test.
+func useStages(t *testing.T, existing []string, replaced ...stage) {
+	t.Helper()
+	prevStages, prevIface, prevNetns := stages, interfaceExists, namespaceExists
+	stages = replaced
+	interfaceExists = func(name string) (bool, error) { return slices.Contains(existing, name), nil }
+	namespaceExists = func(name string) bool { return slices.Contains(existing, name) }
+	t.Cleanup(func() { stages, interfaceExists, namespaceExists = prevStages, prevIface, prevNetns })
+}
+
+// Function returns the names of the stages of the report and their status.
+func stageNames(report Report) []string {
+	var names []string
+	for _, s := range report.Stages {
+		if s.Err != nil {
+			names = append(names, s.Name+":fail")
+		} else {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
+
+// Testing that the preflight refuses scratch names already in use, and
+// that nothing is undone for them.
+func TestPreflight(t *testing.T) {
+	type testCase struct {
+		name      string
+		opts      Options
+		existing  []string
+		wantError string
+	}
+
+	tests := []testCase{
+		{name: "free"},
+		{name: "interface", existing: []string{DefaultInterface}, wantError: "network interface 'brgst0' already exists"},
+		{name: "peer", existing: []string{DefaultPeer}, wantError: "network interface 'brgst1' already exists"},
+		{name: "namespace", existing: []string{DefaultNamespace}, wantError: "network namespace 'brgselftest' already exists"},
+		{name: "same names", opts: Options{Interface: "brgst9", Peer: "brgst9"}, wantError: "share the name 'brgst9'"},
+		{name: "invalid namespace", opts: Options{Namespace: "a/b"}, wantError: "invalid network namespace name"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useStages(t, tc.existing, stage{name: "preflight", run: (*run).preflight})
+
+			report := Run(tc.opts)
+			if got := stageNames(report); tc.wantError == "" && !slices.Equal(got, []string{"preflight", "cleanup"}) {
+				t.Fatalf("error: got stages %v", got)
+			}
+			if tc.wantError == "" {
+				return
+			}
+
+			err := report.Stages[0].Err
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Fatalf("error: got %v, want %q", err, tc.wantError)
+			}
+			// The names in use are not checked for residue.
+			if report.Stages[1].Err != nil {
+				t.Errorf("error: unexpected cleanup error: %v", report.Stages[1].Err)
+			}
+		})
+	}
+}
+
+// Testing that a failing or panicking stage stops the run, and that the
+// undos registered so far run in reverse order, even a panicking one.
+func TestRunCleanup(t *testing.T) {
+	type testCase struct {
+		name       string
+		fail       func() error
+		wantStages []string
+		wantError  string
+	}
+
+	tests := []testCase{
+		{
+			name:       "pass",
+			fail:       func() error { return nil },
+			wantStages: []string{"preflight", "first", "second", "third", "cleanup:fail"},
+		},
+		{
+			name:       "error",
+			fail:       func() error { return errors.New("error: no reply") },
+			wantStages: []string{"preflight", "first", "second:fail", "cleanup:fail"},
+			wantError:  "error: no reply",
+		},
+		{
+			name:       "panic",
+			fail:       func() error { panic("boom") },
+			wantStages: []string{"preflight", "first", "second:fail", "cleanup:fail"},
+			wantError:  "stage 'second' panicked: boom",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var undone []string
+			useStages(t, nil,
+				stage{name: "preflight", run: (*run).preflight},
+				stage{name: "first", run: func(r *run) error {
+					r.undo("undo first", func() error { undone = append(undone, "first"); return nil })
+					r.undo("undo panic", func() error { panic("undo") })
+					return nil
+				}},
+				stage{name: "second", run: func(r *run) error {
+					r.undo("undo second", func() error { undone = append(undone, "second"); return nil })
+					return tc.fail()
+				}},
+				stage{name: "third", run: func(r *run) error { return nil }},
+			)
+
+			report := Run(Options{})
+			if got := stageNames(report); !slices.Equal(got, tc.wantStages) {
+				t.Errorf("error: got stages %v, want %v", got, tc.wantStages)
+			}
+			if !slices.Equal(undone, []string{"second", "first"}) {
+				t.Errorf("error: got undos %v, want second then first", undone)
+			}
+
+			cleanup := report.Stages[len(report.Stages)-1].Err
+			if cleanup == nil || !strings.Contains(cleanup.Error(), "failed to undo panic: undo") {
+				t.Errorf("error: got cleanup error %v, want the panicking undo", cleanup)
+			}
+			if report.Passed() {
+				t.Error("error: report passed with a failed cleanup")
+			}
+
+			if tc.wantError != "" {
+				if err := report.Stages[2].Err; err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Errorf("error: got %v, want %q", err, tc.wantError)
+				}
+			}
+		})
+	}
+}
+
+// Testing that the cleanup reports the interfaces left behind.
+func TestRunResidue(t *testing.T) {
+	useStages(t, nil,
+		stage{name: "preflight", run: (*run).preflight},
+		stage{name: "devices", run: func(r *run) error {
+			interfaceExists = func(name string) (bool, error) { return name == DefaultPeer, nil }
+			return nil
+		}},
+	)
+
+	report := Run(Options{})
+	err := report.Stages[len(report.Stages)-1].Err
+	if err == nil || !strings.Contains(err.Error(), "network interface 'brgst1' left behind") {
+		t.Errorf("error: got %v, want the residue", err)
+	}
+}
+
+// Testing the report written for a failed run.
+func TestReportWrite(t *testing.T) {
+	report := Report{Stages: []StageResult{
+		{Name: "preflight"},
+		{Name: "ping", Err: errors.New("error: no reply\nsecond line")},
+		{Name: "cleanup"},
+	}}
+
+	var out bytes.Buffer
+	if err := report.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := "PASS  preflight  0s\n" +
+		"FAIL  ping       0s\n" +
+		"      error: no reply\n" +
+		"      second line\n" +
+		"PASS  cleanup    0s\n" +
+		"selftest failed\n"
+	if out.String() != want {
+		t.Errorf("error: got\n%s\nwant\n%s", out.String(), want)
+	}
+}
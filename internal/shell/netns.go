@@ -0,0 +1,83 @@
+package shell
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/src/command"
+)
+
+// NamespaceRunner runs the commands of Runner inside the network namespace
+// Name, given by name or process ID, see FormatCmdNetnsExec.
+type NamespaceRunner struct {
+	Name   string
+	Runner Runner
+}
+
+// Method executes the command inside the network namespace.
+func (p NamespaceRunner) Run(cmd string, shell bool) error {
+	return p.Runner.Run(FormatCmdNetnsExec(p.Name, cmd), shell)
+}
+
+// Method executes the command inside the network namespace and returns
+// its output.
+func (p NamespaceRunner) Output(cmd string) (*bytes.Buffer, error) {
+	return p.Runner.Output(FormatCmdNetnsExec(p.Name, cmd))
+}
+
+// Function generates the `ip netns exec` command running a shell command,
+// pipes included, inside the network namespace. A namespace given by
+// process ID (see netns.IsPid) is entered with nsenter.
+func FormatCmdNetnsExec(name, cmd string) string {
+	if netns.IsPid(name) {
+		return fmt.Sprintf("nsenter --net=%s /bin/bash -c %s", netns.TargetPath(name), command.Quote(cmd))
+	}
+	return fmt.Sprintf("ip netns exec %s /bin/bash -c %s", command.Quote(name), command.Quote(cmd))
+}
+
+// Function generates the `ip` command moving a network interface into the
+// network namespace given by name or process ID.
+func FormatCmdIpLinkNetns(iface, target string) string {
+	return fmt.Sprintf("ip link set dev %s netns %s", iface, command.Quote(target))
+}
+
+// Function generates the `ip` command adding a named network namespace.
+func FormatCmdNetnsAdd(name string) string {
+	return fmt.Sprintf("ip netns add %s", command.Quote(name))
+}
+
+// Function generates the `ip` command deleting a named network namespace.
+func FormatCmdNetnsDelete(name string) string {
+	return fmt.Sprintf("ip netns delete %s", command.Quote(name))
+}
+
+// Function makes every operation of the utility run inside the network
+// namespace: the external commands of DefaultRunner (see NamespaceRunner),
+// the wgctrl clients of handlers.NewWgClient and the in-process lookups
+// (see netns.Do). The namespace must exist. Its interfaces may share the
+// names of the host ones, so their metadata is kept apart, in the netns
+// directory of peermeta.Dir.
+func UseNamespace(name string) error {
+	if err := netns.Check(name); err != nil {
+		return err
+	}
+	peermeta.Dir = peermeta.NamespaceDir(name)
+	netns.Name = name
+
+	DefaultRunner = NamespaceRunner{Name: name, Runner: DefaultRunner}
+
+	newClient := handlers.NewWgClient
+	handlers.NewWgClient = func() (handlers.WgClient, error) {
+		var client handlers.WgClient
+		err := netns.Do(func() error {
+			var err error
+			client, err = newClient()
+			return err
+		})
+		return client, err
+	}
+	return nil
+}
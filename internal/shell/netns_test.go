@@ -0,0 +1,70 @@
+package shell_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/internal/shellmock"
+)
+
+// Testing that the commands of NamespaceRunner run inside the network
+// namespace, pipes and quotes included.
+func TestNamespaceRunner(t *testing.T) {
+	fake := shellmock.New()
+	runner := shell.NamespaceRunner{Name: "blue", Runner: fake}
+
+	runner.Run("ip link set dev wg0 up", false)
+	runner.Output("iptables -S | grep 'wg0'")
+
+	want := []string{
+		`ip netns exec 'blue' /bin/bash -c 'ip link set dev wg0 up'`,
+		`ip netns exec 'blue' /bin/bash -c 'iptables -S | grep '\''wg0'\'''`,
+	}
+	if !slices.Equal(fake.Commands, want) {
+		t.Errorf("error: got %q, want %q", fake.Commands, want)
+	}
+}
+
+// Testing that UseNamespace wraps the runner and keeps the metadata of the
+// namespace apart, and refuses a missing namespace.
+func TestUseNamespace(t *testing.T) {
+	prevDir, prevName, prevMeta := netns.Dir, netns.Name, peermeta.Dir
+	prevRunner, prevClient := shell.DefaultRunner, handlers.NewWgClient
+	t.Cleanup(func() {
+		netns.Dir, netns.Name, peermeta.Dir = prevDir, prevName, prevMeta
+		shell.DefaultRunner, handlers.NewWgClient = prevRunner, prevClient
+	})
+
+	netns.Dir = t.TempDir()
+	peermeta.Dir = "/var/lib/brgnetuse"
+	if err := os.WriteFile(filepath.Join(netns.Dir, "blue"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := shell.UseNamespace("red"); err == nil {
+		t.Fatal("error: a missing namespace was accepted")
+	}
+	if netns.Name != "" || shell.DefaultRunner != prevRunner {
+		t.Fatal("error: a missing namespace was applied")
+	}
+
+	fake := shellmock.New()
+	shell.DefaultRunner = fake
+	if err := shell.UseNamespace("blue"); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if netns.Name != "blue" || peermeta.Dir != "/var/lib/brgnetuse/netns/blue" {
+		t.Errorf("error: got namespace %q, metadata directory %s", netns.Name, peermeta.Dir)
+	}
+
+	shell.DefaultRunner.Run("ip link show", false)
+	if want := []string{`ip netns exec 'blue' /bin/bash -c 'ip link show'`}; !slices.Equal(fake.Commands, want) {
+		t.Errorf("error: got %q, want %q", fake.Commands, want)
+	}
+}
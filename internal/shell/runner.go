@@ -0,0 +1,18 @@
+package shell
+
+import (
+	"github.com/AlexKira/brgnetuse/src/command"
+)
+
+// Runner executes commands in the system shell, see command.Runner.
+//
+// The utilities and the get package run every external command through
+// DefaultRunner, so tests can replace it with a shellmock.Runner and assert
+// the exact command sequence without touching the system.
+type Runner = command.Runner
+
+// Deprecated: use command.SystemRunner.
+type SystemRunner = command.SystemRunner
+
+// DefaultRunner is the Runner used for all external commands.
+var DefaultRunner Runner = command.SystemRunner{}
@@ -3,71 +3,119 @@ package shell
 
 import (
 	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
 	"net"
 	"os"
-	"os/exec"
+	"slices"
+	"strconv"
 	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/src/command"
+	"github.com/AlexKira/brgnetuse/src/firewall"
 )
 
-// Function of executing commands in the system shell.
+// Deprecated: use command.Run.
 func ShellCommand(cmd string, shell bool) error {
-	_, err := exec.LookPath(strings.Fields(cmd)[0])
-	if err != nil {
-		return fmt.Errorf("runtime error: [%s], %v", cmd, err)
-	}
+	return command.Run(cmd, shell)
+}
 
-	run := exec.Command("/bin/bash", "-c", cmd)
+// Deprecated: use command.CommandError.
+type CommandError = command.CommandError
 
-	if shell {
-		run.Stdout = os.Stdout
-		run.Stderr = os.Stderr
-	}
+// Deprecated: use command.Output.
+func ShellCommandOutput(cmd string) (*bytes.Buffer, error) {
+	return command.Output(cmd)
+}
 
-	err = run.Start()
-	if err != nil {
-		return fmt.Errorf("runtime error: [%s], %v", cmd, err)
+// RouteFile is the IPv4 routing table read by GetNetInterfaceNameLinux.
+// Tests point it at a file with synthetic content.
+var RouteFile = "/proc/net/route"
+
+// Function returns the network interface carrying the default route with
+// the lowest metric, read from RouteFile. Without a default route, the
+// first active interface with a known name prefix (e.g., eth, enp, wlp)
+// is returned. Returns an error if neither is found.
+func GetNetInterfaceNameLinux() (string, error) {
+	if ifaces, err := GetDefaultRouteInterfacesLinux(); err == nil && len(ifaces) > 0 {
+		return ifaces[0], nil
 	}
 
-	err = run.Wait()
-	if err != nil {
-		return fmt.Errorf("runtime error: [%s], %v", cmd, err)
+	if iface := guessNetInterfaceName(); iface != "" {
+		return iface, nil
 	}
 
-	return nil
+	return "", errors.New(
+		"error: no default route found, specify the outgoing network interface (e.g., -n eth0)",
+	)
 }
 
-// Function executes a command in the system shell and returns the
-// combined stdout and stderr output.
-// Returns the output of the command as a *bytes.Buffer and an error, if any.
-func ShellCommandOutput(cmd string) (*bytes.Buffer, error) {
-	_, err := exec.LookPath(strings.Fields(cmd)[0])
+// Function returns the network interfaces carrying an active default route,
+// read from RouteFile, the lowest metric first. An interface is listed once.
+func GetDefaultRouteInterfacesLinux() ([]string, error) {
+	var data []byte
+	err := netns.Do(func() (err error) {
+		data, err = os.ReadFile(netns.ProcPath(RouteFile))
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf(
-			"runtime error: command '%s' not found: %v", strings.Fields(cmd)[0],
-			err,
-		)
+		return nil, fmt.Errorf("error: failed to read routing table: %v", err)
 	}
+	return defaultRouteInterfaces(string(data)), nil
+}
 
-	output, err := exec.Command("/bin/bash", "-c", cmd).CombinedOutput()
-	if err != nil {
-		replacer := strings.NewReplacer("\n", "", ".", "")
-		return nil, fmt.Errorf(
-			"runtime error: %s", replacer.Replace(
-				fmt.Sprintf(
-					"%s, %v",
-					output,
-					err,
-				),
-			),
-		)
+// Function parses the content of /proc/net/route and returns the interfaces
+// of the active default routes, sorted by their metric.
+func defaultRouteInterfaces(table string) []string {
+	const rtfUp = 0x1
+
+	type route struct {
+		iface  string
+		metric uint64
 	}
 
-	return bytes.NewBuffer(output), nil
+	var routes []route
+	for i, line := range strings.Split(table, "\n") {
+		fields := strings.Fields(line)
+		// Skip the header and malformed lines.
+		if i == 0 || len(fields) < 8 {
+			continue
+		}
+
+		if fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil || flags&rtfUp == 0 {
+			continue
+		}
+
+		metric, err := strconv.ParseUint(fields[6], 10, 32)
+		if err != nil {
+			continue
+		}
+
+		routes = append(routes, route{iface: fields[0], metric: metric})
+	}
+
+	slices.SortStableFunc(routes, func(a, b route) int {
+		return cmp.Compare(a.metric, b.metric)
+	})
+
+	var ifaces []string
+	for _, r := range routes {
+		if !slices.Contains(ifaces, r.iface) {
+			ifaces = append(ifaces, r.iface)
+		}
+	}
+	return ifaces
 }
 
-// Function to get active Linux network interface.
-func GetNetInterfaceNameLinux() string {
+// Function guesses the active network interface by its name prefix.
+func guessNetInterfaceName() string {
 	schemaInterfaceNameLinux := map[string]int{
 		// Ethernet
 		"eth": 1,
@@ -83,19 +131,24 @@ func GetNetInterfaceNameLinux() string {
 		"vet": 1,
 	}
 
-	netIfaces, _ := net.Interfaces()
-	for _, iface := range netIfaces {
-		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 {
-			ipSlice, _ := iface.Addrs()
-			if len(iface.Name) >= 3 && len(ipSlice) > 0 {
-				if schemaInterfaceNameLinux[iface.Name[:3]] == 1 {
-					return iface.Name
+	name := ""
+	netns.Do(func() error {
+		netIfaces, _ := net.Interfaces()
+		for _, iface := range netIfaces {
+			if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 {
+				ipSlice, _ := iface.Addrs()
+				if len(iface.Name) >= 3 && len(ipSlice) > 0 {
+					if schemaInterfaceNameLinux[iface.Name[:3]] == 1 {
+						name = iface.Name
+						return nil
+					}
 				}
 			}
 		}
-	}
+		return nil
+	})
 
-	return ""
+	return name
 }
 
 // Function generate the `ip` command when deleting.
@@ -103,11 +156,28 @@ func FormatCmdIpLinkDelete(iface string) string {
 	return fmt.Sprintf("ip link delete %s", iface)
 }
 
+// Function generates the `ip` command showing the link details (e.g., its
+// kind) of the network interface in JSON format.
+func FormatCmdIpLinkDetailJSON(iface string) string {
+	return fmt.Sprintf("ip -d -j link show %s", iface)
+}
+
 // Function generates the `ip` command to control the status of the network interface.
 func FormatCmdIpLinkSet(iface string, flag IpFlagString) string {
 	return fmt.Sprintf("ip link set %s %s", iface, flag)
 }
 
+// Function generates the `ip` command to rename a network interface.
+func FormatCmdIpLinkRename(iface, newName string) string {
+	return fmt.Sprintf("ip link set %s name %s", iface, newName)
+}
+
+// Function generates the `ip` command to set the alias of a network
+// interface, an empty alias clears it.
+func FormatCmdIpLinkAlias(iface, alias string) string {
+	return fmt.Sprintf("ip link set dev %s alias %s", iface, quote(alias))
+}
+
 // Function generates the `ip` command to add or remove an IP address.
 func FormatCmdIpAddrDev(iface, ip string, flag IpFlagString) string {
 	return fmt.Sprintf(
@@ -118,41 +188,188 @@ func FormatCmdIpAddrDev(iface, ip string, flag IpFlagString) string {
 	)
 }
 
-// Function generates an iptables command to manage (add/remove) an INGRESS
-// rule for UDP traffic on the specified destination port.
+// Function generates the `ping` command sending count echo requests to the
+// address through the network interface, waiting up to a second for each.
+func FormatCmdPing(iface, addr string, count int) string {
+	return fmt.Sprintf("ping -c %d -W 1 -I %s %s", count, iface, addr)
+}
+
+// Deprecated: use firewall.RuleTagPrefix.
+const RuleTagPrefix = firewall.RuleTagPrefix
+
+// Deprecated: use firewall.RuleTag.
+func RuleTag(owner string) string {
+	return firewall.RuleTag(owner)
+}
+
+// Deprecated: use firewall.FormatRuleComment.
+func FormatRuleComment(owner string) string {
+	return firewall.FormatRuleComment(owner)
+}
+
+// Deprecated: use firewall.FormatCmdPort.
 func FormatCmdIptablesFirewallPort(flag IpFlagString, dport string) string {
+	return firewall.FormatCmdPort(firewall.Action(flag), dport)
+}
 
-	cmd := fmt.Sprintf(
-		"iptables -%s INPUT -p udp --dport %s -j ACCEPT",
-		flag, dport,
+// Deprecated: use firewall.FormatCmdForward.
+func FormatCmdIptablesFirewall(flag IpFlagString, osIface, wgIface string) string {
+	return firewall.FormatCmdForward(firewall.Action(flag), osIface, wgIface)
+}
+
+// Deprecated: use firewall.FormatCmdNat.
+func FormatCmdIptablesNat(flag IpFlagString, osIface, subnet, wgIface string) string {
+	return firewall.FormatCmdNat(firewall.Action(flag), osIface, subnet, wgIface)
+}
+
+// Deprecated: use firewall.FormatCmdAppend.
+func FormatCmdIptablesAppend(table, chain, spec string) string {
+	return firewall.FormatCmdAppend(table, chain, spec)
+}
+
+// Deprecated: use firewall.FormatCmdDelete.
+func FormatCmdIptablesDelete(table, chain, spec string) string {
+	return firewall.FormatCmdDelete(table, chain, spec)
+}
+
+// Deprecated: use firewall.FormatCmdRestore.
+func FormatCmdIptablesRestore(path string) string {
+	return firewall.FormatCmdRestore(path)
+}
+
+// Function generates the command starting a userspace device with brgaddwg
+// or, for AmneziaWG, brgaddawg.
+func FormatCmdAddInterface(iface string, awg bool) string {
+	if awg {
+		return fmt.Sprintf("brgaddawg -i %s", iface)
+	}
+	return fmt.Sprintf("brgaddwg -i %s", iface)
+}
+
+// Function generates the `kill` command terminating a process gracefully.
+func FormatCmdKill(pid int) string {
+	return fmt.Sprintf("kill -TERM %d", pid)
+}
+
+// Function generates the `tc` command listing the qdiscs of the network
+// interface in JSON format.
+func FormatCmdTcQdiscShowJSON(iface string) string {
+	return fmt.Sprintf("tc -j qdisc show dev %s", iface)
+}
+
+// Function generates the `tc` command listing the classes of the network
+// interface. tc has no JSON output for classes.
+func FormatCmdTcClassShow(iface string) string {
+	return fmt.Sprintf("tc class show dev %s", iface)
+}
+
+// Function generates the `tc` command listing the filters attached to the
+// parent (e.g., TcRootHandle) of the network interface in JSON format.
+func FormatCmdTcFilterShowJSON(iface, parent string) string {
+	return fmt.Sprintf("tc -j filter show dev %s parent %s", iface, parent)
+}
+
+// Function generates the `tc` command installing the htb root qdisc of the
+// rate limiting. With replace, the default root qdisc is replaced.
+func FormatCmdTcRootAdd(iface string, replace bool) string {
+	action := "add"
+	if replace {
+		action = "replace"
+	}
+	return fmt.Sprintf("tc qdisc %s dev %s root handle %s htb", action, iface, TcRootHandle)
+}
+
+// Function generates the `tc` command installing the ingress qdisc.
+func FormatCmdTcIngressAdd(iface string) string {
+	return fmt.Sprintf("tc qdisc add dev %s handle %s ingress", iface, TcIngressHandle)
+}
+
+// Function generates the `tc` command deleting the root or ingress qdisc
+// (parent "root", "ingress") with its classes and filters.
+func FormatCmdTcQdiscDelete(iface, parent string) string {
+	return fmt.Sprintf("tc qdisc del dev %s %s", iface, parent)
+}
+
+// Function generates the `tc` command adding or changing (action "add",
+// "change") an htb class limited to the rate in bits per second.
+func FormatCmdTcClass(action, iface, classID string, rate uint64) string {
+	return fmt.Sprintf(
+		"tc class %s dev %s parent %s classid %s htb rate %dbit ceil %dbit",
+		action, iface, TcRootHandle, classID, rate, rate,
 	)
+}
 
-	return cmd
+// Function generates the `tc` command deleting an htb class.
+func FormatCmdTcClassDelete(iface, classID string) string {
+	return fmt.Sprintf("tc class del dev %s classid %s", iface, classID)
 }
 
-// Function generates the `iptables` command to manage the firewall rules.
-func FormatCmdIptablesFirewall(flag IpFlagString, osIface, wgIface string) string {
+// Function generates the `tc` command steering the egress traffic to the
+// prefix (traffic sent to the peer) into the class.
+func FormatCmdTcFilterEgress(iface, protocol string, prio int, prefix, classID string) string {
+	return fmt.Sprintf(
+		"tc filter add dev %s parent %s protocol %s prio %d flower dst_ip %s classid %s",
+		iface, TcRootHandle, protocol, prio, prefix, classID,
+	)
+}
 
-	in := fmt.Sprintf(
-		"iptables -%s FORWARD -i %s -o %s -j ACCEPT",
-		flag, osIface, wgIface,
+// Function generates the `tc` command policing the ingress traffic from the
+// prefix (traffic sent by the peer) to the rate in bits per second. The
+// class is recorded on the filter to tie it to the peer.
+func FormatCmdTcFilterIngress(iface, protocol string, prio int, prefix, classID string, rate uint64) string {
+	// Allow bursts of 100ms at the rate, at least one full packet.
+	burst := max(rate/80, 1600)
+	return fmt.Sprintf(
+		"tc filter add dev %s parent %s protocol %s prio %d flower src_ip %s classid %s "+
+			"action police rate %dbit burst %d drop",
+		iface, TcIngressHandle, protocol, prio, prefix, classID, rate, burst,
 	)
+}
 
-	out := fmt.Sprintf(
-		"iptables -%s FORWARD -i %s -o %s -j ACCEPT",
-		flag, wgIface, osIface,
+// Function generates the `tc` command deleting a single flower filter.
+func FormatCmdTcFilterDelete(iface, parent, protocol string, prio int, handle uint32) string {
+	return fmt.Sprintf(
+		"tc filter del dev %s parent %s protocol %s prio %d handle 0x%x flower",
+		iface, parent, protocol, prio, handle,
 	)
-	cmd := fmt.Sprintf("%s && %s", in, out)
-	return cmd
 }
 
-// Function generates the `iptables` command to manage the NAT rules.
-func FormatCmdIptablesNat(flag IpFlagString, osIface, subnet string) string {
-	cmd := fmt.Sprintf(
-		"iptables -t nat -%s POSTROUTING -s %s -o %s -j MASQUERADE",
-		flag, subnet, osIface,
+// Function generates the `resolvectl` command setting the DNS servers of the
+// network interface, or printing them when servers is empty.
+func FormatCmdResolvectlDNS(iface string, servers []string) string {
+	return strings.TrimSpace(fmt.Sprintf("resolvectl dns %s %s", iface, strings.Join(servers, " ")))
+}
+
+// Function generates the `resolvectl` command resetting the DNS settings of
+// the network interface.
+func FormatCmdResolvectlRevert(iface string) string {
+	return fmt.Sprintf("resolvectl revert %s", iface)
+}
+
+// Function generates the `resolvconf` command registering the DNS servers of
+// the network interface, in the form used by wg-quick.
+func FormatCmdResolvconfAdd(iface string, servers []string) string {
+	return fmt.Sprintf(
+		"printf 'nameserver %%s\\n' %s | resolvconf -a %s -m 0 -x",
+		strings.Join(servers, " "), iface,
 	)
-	return cmd
+}
+
+// Function generates the `resolvconf` command removing the DNS servers of
+// the network interface.
+func FormatCmdResolvconfDelete(iface string) string {
+	return fmt.Sprintf("resolvconf -d %s -f", iface)
+}
+
+// Function generates the command locating an executable in PATH.
+func FormatCmdWhich(name string) string {
+	return fmt.Sprintf("which %s", name)
+}
+
+// Function generates the `qrencode` command printing the content of a
+// file as a QR code of the image type (e.g., "PNG", "ansiutf8") on stdout.
+func FormatCmdQrencode(imageType, path string) string {
+	return fmt.Sprintf("qrencode -t %s -o - -r %s", imageType, path)
 }
 
 // Function constructs the 'ip link show' command for a given interface.
@@ -166,12 +383,36 @@ func FormatCmdAwgShow(iface string) string {
 	return fmt.Sprintf("awg show %s", iface)
 }
 
+// Function creates the 'awg show <interface> dump' command string.
+// This command prints the interface and its peers in tab separated form.
+func FormatCmdAwgShowDump(iface string) string {
+	return fmt.Sprintf("awg show %s dump", iface)
+}
+
+// Function creates the 'awg show <interface> public-key' command string.
+// This command prints the public key of a specific WireGuard interface.
+func FormatCmdAwgShowPublicKey(iface string) string {
+	return fmt.Sprintf("awg show %s public-key", iface)
+}
+
+// Function creates the 'awg show <interface> private-key' command string.
+// This command prints the private key of a specific WireGuard interface.
+func FormatCmdAwgShowPrivateKey(iface string) string {
+	return fmt.Sprintf("awg show %s private-key", iface)
+}
+
 // Function creates the 'awg set <interface> listen-port <port>' command string.
 // This command is used to update the listening port of a specific WireGuard interface.
 func FormatCmdAwgUpdatePort(iface, port string) string {
 	return fmt.Sprintf("awg set %s listen-port %s", iface, port)
 }
 
+// Function creates the 'awg set <interface> fwmark <mark>' command string.
+// This command is used to update the firewall mark of a specific WireGuard interface.
+func FormatCmdAwgUpdateFwMark(iface, mark string) string {
+	return fmt.Sprintf("awg set %s fwmark %s", iface, mark)
+}
+
 // Function creates the 'awg set <interface> private-key <(echo '<privateKey>')' command string.
 // This command is used to set the private key for a specific WireGuard interface using a secure shell redirection.
 func FormatCmdAwgUpdatePrivateKey(iface, pk string) string {
@@ -200,3 +441,8 @@ func FormatCmdAwgAddPeer(iface, pk, aips, kp, epoint string) string {
 func FormatCmdAwgDeletePeer(iface, pk string) string {
 	return fmt.Sprintf("awg set %s peer '%s' remove", iface, pk)
 }
+
+// Function quotes the value as a single shell word.
+func quote(value string) string {
+	return command.Quote(value)
+}
@@ -0,0 +1,102 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/AlexKira/brgnetuse/src/command"
+)
+
+// Testing that the alias reaches the `ip` command as a single word.
+func TestFormatCmdIpLinkAlias(t *testing.T) {
+	for _, alias := range []string{"office vpn", "", "it's $HOME; `id`"} {
+		t.Run(alias, func(t *testing.T) {
+			cmd := FormatCmdIpLinkAlias("wg0", alias)
+			if !strings.HasPrefix(cmd, "ip link set dev wg0 alias ") {
+				t.Fatalf("error: got %q", cmd)
+			}
+
+			// Print the arguments the shell passes after "alias".
+			out, err := command.Output(`printf '%s|' ` + strings.TrimPrefix(cmd, "ip link set dev wg0 alias "))
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got := out.String(); got != alias+"|" {
+				t.Errorf("error: got arguments %q, want %q", got, alias+"|")
+			}
+		})
+	}
+}
+
+// Testing the default route lookup on synthetic routing tables.
+func TestDefaultRouteInterface(t *testing.T) {
+	const header = "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n"
+
+	type testCase struct {
+		name  string
+		table string
+		want  []string
+	}
+
+	tests := []testCase{
+		{
+			name: "bond",
+			table: header +
+				"bond0\t00000000\t0100A8C0\t0003\t0\t0\t0\t00000000\t0\t0\t0\n" +
+				"bond0\t0000A8C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0\n",
+			want: []string{"bond0"},
+		},
+		{
+			name: "sorted by metric",
+			table: header +
+				"wlp2s0\t00000000\t0100A8C0\t0003\t0\t0\t600\t00000000\t0\t0\t0\n" +
+				"eth0.100\t00000000\t01000A0A\t0003\t0\t0\t100\t00000000\t0\t0\t0\n" +
+				"br0\t00000000\t0101A8C0\t0003\t0\t0\t425\t00000000\t0\t0\t0\n" +
+				"eth0.100\t00000000\t02000A0A\t0003\t0\t0\t700\t00000000\t0\t0\t0\n",
+			want: []string{"eth0.100", "br0", "wlp2s0"},
+		},
+		{
+			name: "route down",
+			table: header +
+				"enx0011223344\t00000000\t0100A8C0\t0002\t0\t0\t0\t00000000\t0\t0\t0\n" +
+				"br0\t00000000\t0101A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n",
+			want: []string{"br0"},
+		},
+		{
+			name: "no default route",
+			table: header +
+				"eth0\t0000A8C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0\n",
+		},
+		{name: "malformed", table: header + "eth0\t00000000\n"},
+		{name: "empty"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := defaultRouteInterfaces(tc.table); !slices.Equal(got, tc.want) {
+				t.Errorf("error: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing that GetNetInterfaceNameLinux reads the routing table file.
+func TestGetNetInterfaceNameLinux(t *testing.T) {
+	prev := RouteFile
+	RouteFile = filepath.Join(t.TempDir(), "route")
+	t.Cleanup(func() { RouteFile = prev })
+
+	table := "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		"enx0011223344\t00000000\t0100A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n"
+	if err := os.WriteFile(RouteFile, []byte(table), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	iface, err := GetNetInterfaceNameLinux()
+	if err != nil || iface != "enx0011223344" {
+		t.Errorf("error: got %q %v, want %q", iface, err, "enx0011223344")
+	}
+}
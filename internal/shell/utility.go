@@ -1,6 +1,10 @@
 // Package describes the operation of the server's internal utilities.
 package shell
 
+import (
+	"github.com/AlexKira/brgnetuse/src/firewall"
+)
+
 type IpFlagString string
 
 const (
@@ -8,8 +12,8 @@ const (
 	IpDown      IpFlagString = "down"
 	IpAdd       IpFlagString = "add"
 	IpDel       IpFlagString = "del"
-	IpTablesAdd IpFlagString = "A"
-	IpTablesDel IpFlagString = "D"
+	IpTablesAdd IpFlagString = IpFlagString(firewall.Append)
+	IpTablesDel IpFlagString = IpFlagString(firewall.Delete)
 )
 
 const (
@@ -31,7 +35,16 @@ const (
 	IpJSON      string = "ip -j addr"
 	IpBriefJSON string = "ip -j -br addr"
 
-	// Command: iptables.
-	IptablesFirewall string = "iptables -L -v -n"
-	IptablesNat      string = "iptables -t nat -L -v"
+	// Command: ip, link details (e.g., the kind) of all network interfaces.
+	IpLinkDetailJSON string = "ip -d -j link show"
+
+	// Deprecated: use firewall.CmdList, firewall.CmdListNat and
+	// firewall.CmdSave.
+	IptablesFirewall string = firewall.CmdList
+	IptablesNat      string = firewall.CmdListNat
+	IptablesSave     string = firewall.CmdSave
+
+	// Command: tc, handles of the per-peer rate limiting qdiscs.
+	TcRootHandle    string = "1:"
+	TcIngressHandle string = "ffff:"
 )
@@ -0,0 +1,120 @@
+// Package provides a replacement of the shell command runner for tests.
+//
+// The runner records every command instead of executing it and returns
+// scripted outputs and errors, so code under test can be checked for the
+// exact command sequence without root privileges or touching the system.
+package shellmock
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+
+	"github.com/AlexKira/brgnetuse/internal/shell"
+)
+
+// TB is the part of testing.TB the package uses, so that it does not pull
+// the testing package in.
+type TB interface {
+	Helper()
+	Cleanup(func())
+}
+
+// Runner is a shell.Runner that records commands instead of executing them.
+// Outputs and errors are scripted per command; the longest matching
+// prefix wins, so a single entry can cover a family of commands.
+type Runner struct {
+	mu sync.Mutex
+
+	// Commands lists every executed command in order.
+	Commands []string
+
+	// Outputs maps a command (or command prefix) to the output returned by Output.
+	Outputs map[string]string
+
+	// Errors maps a command (or command prefix) to the error it fails with.
+	Errors map[string]error
+
+	// Hook, when set, is called for every command before it is recorded;
+	// it can mutate Outputs to simulate state changes.
+	Hook func(cmd string)
+}
+
+// Function creates an empty Runner.
+func New() *Runner {
+	return &Runner{
+		Outputs: make(map[string]string),
+		Errors:  make(map[string]error),
+	}
+}
+
+// Function creates a Runner and installs it as shell.DefaultRunner for the
+// duration of the test.
+func Install(t TB) *Runner {
+	t.Helper()
+
+	fake := New()
+	prev := shell.DefaultRunner
+	shell.DefaultRunner = fake
+	t.Cleanup(func() { shell.DefaultRunner = prev })
+
+	return fake
+}
+
+// Method records the command and returns the scripted error.
+func (p *Runner) Run(cmd string, shell bool) error {
+	_, err := p.exec(cmd)
+	return err
+}
+
+// Method records the command and returns the scripted output and error.
+func (p *Runner) Output(cmd string) (*bytes.Buffer, error) {
+	output, err := p.exec(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBufferString(output), nil
+}
+
+// Method returns the recorded commands that start with the prefix.
+func (p *Runner) Matching(prefix string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var result []string
+	for _, cmd := range p.Commands {
+		if strings.HasPrefix(cmd, prefix) {
+			result = append(result, cmd)
+		}
+	}
+	return result
+}
+
+// Method records the command and looks up its scripted result.
+func (p *Runner) exec(cmd string) (string, error) {
+	if p.Hook != nil {
+		p.Hook(cmd)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Commands = append(p.Commands, cmd)
+
+	if err := lookup(p.Errors, cmd); err != nil {
+		return "", err
+	}
+	return lookup(p.Outputs, cmd), nil
+}
+
+// Function returns the value stored under the longest key that prefixes cmd.
+func lookup[T any](values map[string]T, cmd string) T {
+	var result T
+	best := -1
+	for key, value := range values {
+		if strings.HasPrefix(cmd, key) && len(key) > best {
+			result, best = value, len(key)
+		}
+	}
+	return result
+}
@@ -0,0 +1,213 @@
+//go:build !windows
+
+// Package startup implements the readiness handshake between brgaddwg,
+// brgaddawg and the background process they start: the process reports
+// through a status pipe whether its device came up, so the utility does not
+// exit cleanly while the device failed a moment after the start.
+package startup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"golang.org/x/sys/unix"
+)
+
+// Environment variable passing the status pipe descriptor to the
+// background process.
+const EnvStatusFd string = "WG_PROCESS_STATUS_FD"
+
+// Environment variable overriding the wait timeout (a duration, e.g., "30s").
+const EnvTimeout string = "BRGNETUSE_START_TIMEOUT"
+
+// Default time given to the background process to bring its device up.
+const DefaultTimeout = 10 * time.Second
+
+// Timeout is the time given to the background process to bring its device
+// up. It is initialized from EnvTimeout and may be replaced by tests.
+var Timeout = defaultTimeout()
+
+// Status lines written by the background process to the status pipe.
+const (
+	readyLine    = "brgnetuse-status: ready"
+	failedPrefix = "brgnetuse-status: failed: "
+)
+
+// Maximum number of bytes of the early output kept for the error message.
+const maxOutput = 64 << 10
+
+// Function returns the wait timeout from EnvTimeout or DefaultTimeout.
+// An invalid value falls back to DefaultTimeout.
+func defaultTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv(EnvTimeout))
+	if err != nil || timeout <= 0 {
+		return DefaultTimeout
+	}
+	return timeout
+}
+
+// Function starts the background process serving the network interface and
+// waits until it reports the device up, reports an error, exits or the
+// timeout elapses. With captureOutput, the standard output and error of the
+// process are captured through the status pipe, so its early errors are not
+// lost without a log file. A process not ready in time is stopped.
+func Start(cmd *exec.Cmd, iface string, captureOutput bool) error {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("error: failed to create status pipe, %v", err)
+	}
+	defer reader.Close()
+
+	// The status pipe is the first extra file, descriptor 3 of the process.
+	cmd.ExtraFiles = append(cmd.ExtraFiles, writer)
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", EnvStatusFd, 2+len(cmd.ExtraFiles)))
+	if captureOutput {
+		cmd.Stdout = writer
+		cmd.Stderr = writer
+	}
+
+	err = cmd.Start()
+	writer.Close()
+	if err != nil {
+		return help.Errorf(help.CodeDeviceFailed, "error: failed starting background process, %v", err)
+	}
+
+	output, err := readStatus(reader, Timeout)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		cmd.Process.Signal(syscall.SIGTERM)
+		cmd.Wait()
+		return help.Errorf(
+			help.CodeDeviceFailed,
+			"error: network interface '%s' did not come up within %s, "+
+				"the background process was stopped",
+			iface,
+			Timeout,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("error: failed to read status pipe, %v", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	for _, line := range lines {
+		if line == readyLine {
+			return nil
+		}
+	}
+
+	waitErr := cmd.Wait()
+
+	for _, line := range lines {
+		if message, ok := strings.CutPrefix(line, failedPrefix); ok {
+			return help.Errorf(help.CodeDeviceFailed, "error: network interface '%s' did not come up, %s", iface, message)
+		}
+	}
+
+	detail := "the background process exited"
+	if waitErr != nil {
+		detail = fmt.Sprintf("the background process exited (%v)", waitErr)
+	}
+	if text := strings.TrimSpace(string(output)); text != "" {
+		detail += ":\n" + text
+	}
+	return help.Errorf(help.CodeDeviceFailed, "error: network interface '%s' did not come up, %s", iface, detail)
+}
+
+// Function reads the status pipe until every writer closed it or the
+// timeout elapses, keeping the last maxOutput bytes.
+func readStatus(reader *os.File, timeout time.Duration) ([]byte, error) {
+	if err := reader.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	var output []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := reader.Read(buf)
+		output = append(output, buf[:n]...)
+		if len(output) > maxOutput {
+			output = output[len(output)-maxOutput:]
+		}
+		if err != nil {
+			if err == io.EOF {
+				return output, nil
+			}
+			return output, err
+		}
+	}
+}
+
+var (
+	statusOnce sync.Once
+	statusFile *os.File
+)
+
+// Function returns the status pipe passed by the utility, nil when the
+// process was not started with one (e.g., run in the foreground by hand).
+func status() *os.File {
+	statusOnce.Do(func() {
+		fd, err := strconv.Atoi(os.Getenv(EnvStatusFd))
+		if err != nil || fd < 3 {
+			return
+		}
+		// Commands run by the process must not hold the pipe open.
+		unix.CloseOnExec(fd)
+		statusFile = os.NewFile(uintptr(fd), "status")
+	})
+	return statusFile
+}
+
+// Function reports the device of the background process up. The standard
+// output and error captured through the status pipe are redirected to
+// /dev/null first, so later writes do not fail once the utility exited.
+func Ready() {
+	file := status()
+	if file == nil {
+		return
+	}
+	detachOutput(file)
+	fmt.Fprintln(file, readyLine)
+	file.Close()
+}
+
+// Function reports the error that stopped the device of the background
+// process.
+func Fail(err error) {
+	file := status()
+	if file == nil {
+		return
+	}
+	message := strings.ReplaceAll(err.Error(), "\n", " ")
+	fmt.Fprintln(file, failedPrefix+message)
+	file.Close()
+}
+
+// Function redirects the standard output and error sharing the status pipe
+// to /dev/null.
+func detachOutput(file *os.File) {
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer null.Close()
+
+	for _, std := range []*os.File{os.Stdout, os.Stderr} {
+		if stdInfo, err := std.Stat(); err == nil && os.SameFile(info, stdInfo) {
+			unix.Dup2(int(null.Fd()), int(std.Fd()))
+		}
+	}
+}
@@ -0,0 +1,155 @@
+//go:build !windows
+
+package startup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Environment variable selecting the behaviour of the test binary started
+// as the background process.
+const envHelper = "STARTUP_TEST_HELPER"
+
+// Function runs the test binary as a background process when envHelper is
+// set, otherwise it runs the tests.
+func TestMain(m *testing.M) {
+	switch os.Getenv(envHelper) {
+	case "":
+		os.Exit(m.Run())
+	case "ready":
+		fmt.Println("starting device")
+		Ready()
+		// The utility may have exited, writes must not kill the process.
+		time.Sleep(100 * time.Millisecond)
+		fmt.Println("device running")
+		os.Exit(0)
+	case "fail":
+		fmt.Println("starting device")
+		Fail(errors.New("failed to create TUN device: operation not permitted"))
+		fmt.Println("error: failed to create TUN device: operation not permitted")
+		os.Exit(1)
+	case "crash":
+		fmt.Fprintln(os.Stderr, "panic: runtime error: invalid memory address")
+		os.Exit(2)
+	case "hang":
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	}
+}
+
+// Function returns a command starting the test binary with the helper
+// behaviour.
+func helperCommand(behaviour string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), envHelper+"="+behaviour)
+	return cmd
+}
+
+// Testing the Start function against background processes that come up,
+// fail, crash and hang.
+func TestStart(t *testing.T) {
+	prev := Timeout
+	Timeout = 2 * time.Second
+	t.Cleanup(func() { Timeout = prev })
+
+	type testCase struct {
+		behaviour     string
+		captureOutput bool
+		timeout       time.Duration
+		wantError     []string
+		wantNoError   []string
+	}
+
+	tests := []testCase{
+		{behaviour: "ready", captureOutput: true},
+		{behaviour: "ready", captureOutput: false},
+		{
+			behaviour:     "fail",
+			captureOutput: true,
+			wantError: []string{
+				"error: network interface 'wg0' did not come up, " +
+					"failed to create TUN device: operation not permitted",
+			},
+			wantNoError: []string{"starting device"},
+		},
+		{
+			behaviour:     "fail",
+			captureOutput: false,
+			wantError:     []string{"failed to create TUN device: operation not permitted"},
+		},
+		{
+			behaviour:     "crash",
+			captureOutput: true,
+			wantError: []string{
+				"error: network interface 'wg0' did not come up, the background process exited (exit status 2)",
+				"panic: runtime error: invalid memory address",
+			},
+		},
+		{
+			behaviour:     "crash",
+			captureOutput: false,
+			wantError:     []string{"the background process exited (exit status 2)"},
+			wantNoError:   []string{"panic"},
+		},
+		{
+			behaviour:     "hang",
+			captureOutput: true,
+			timeout:       200 * time.Millisecond,
+			wantError: []string{
+				"error: network interface 'wg0' did not come up within 200ms, " +
+					"the background process was stopped",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("%s/capture=%v", tc.behaviour, tc.captureOutput), func(t *testing.T) {
+			if tc.timeout != 0 {
+				Timeout = tc.timeout
+				t.Cleanup(func() { Timeout = 2 * time.Second })
+			}
+
+			err := Start(helperCommand(tc.behaviour), "wg0", tc.captureOutput)
+
+			if len(tc.wantError) == 0 {
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("error: expected an error, got nil")
+			}
+			for _, want := range tc.wantError {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error: got %q, want it to contain %q", err, want)
+				}
+			}
+			for _, unwanted := range tc.wantNoError {
+				if strings.Contains(err.Error(), unwanted) {
+					t.Errorf("error: got %q, want it not to contain %q", err, unwanted)
+				}
+			}
+		})
+	}
+}
+
+// Testing that Ready and Fail do nothing without a status pipe.
+func TestStatusWithoutPipe(t *testing.T) {
+	if os.Getenv(EnvStatusFd) != "" {
+		t.Skip("status pipe inherited from the environment")
+	}
+
+	Ready()
+	Fail(errors.New("failed"))
+
+	if status() != nil {
+		t.Errorf("error: got a status pipe without %s", EnvStatusFd)
+	}
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Members of the envelope of a state file.
+const (
+	memberSchema = "schema"
+	memberData   = "data"
+)
+
+// document is the parsed content of a state file.
+type document struct {
+	// raw is the content of the state file, nil when it was missing,
+	// damaged or replaced by its backup.
+	raw []byte
+
+	// version is the format version of the file, data its data migrated
+	// to the current version, nil when the file was missing.
+	version int
+	data    json.RawMessage
+
+	// extra holds the members of the envelope other than the schema and
+	// the data, kept on save.
+	extra map[string]json.RawMessage
+}
+
+// Function parses the content of a state file. A content other than an
+// envelope is the data of version 0, written before the envelope.
+func parseDocument(raw []byte, schema Schema) (document, error) {
+	var doc document
+
+	var members map[string]json.RawMessage
+	if json.Unmarshal(raw, &members) == nil && members[memberSchema] != nil && members[memberData] != nil {
+		if err := json.Unmarshal(members[memberSchema], &doc.version); err != nil || doc.version < 0 {
+			return document{}, fmt.Errorf("invalid schema version %s", members[memberSchema])
+		}
+		doc.data = members[memberData]
+		delete(members, memberSchema)
+		delete(members, memberData)
+		if len(members) > 0 {
+			doc.extra = members
+		}
+	} else {
+		var probe any
+		if err := json.Unmarshal(raw, &probe); err != nil {
+			return document{}, err
+		}
+		doc.data = raw
+	}
+
+	if doc.version < schema.Version && schema.Migrate != nil {
+		data, err := schema.Migrate(doc.version, doc.data)
+		if err != nil {
+			return document{}, fmt.Errorf("failed to migrate from version %d: %v", doc.version, err)
+		}
+		doc.data = data
+	}
+	return doc, nil
+}
+
+// Method encodes the data in the envelope of the document. The version of
+// a file written by a later release is kept.
+func (d document) encode(data []byte, schema Schema) ([]byte, error) {
+	version := max(d.version, schema.Version)
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"` + memberSchema + `":` + strconv.Itoa(version) + `,"` + memberData + `":`)
+	buf.Write(data)
+	for _, name := range slices.Sorted(maps.Keys(d.extra)) {
+		key, _ := json.Marshal(name)
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(d.extra[name])
+	}
+	buf.WriteByte('}')
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+// Type of the values encoding themselves, merged as a whole.
+var marshalerType = reflect.TypeFor[json.Marshaler]()
+
+// Function adds to the encoded data the members of the original data
+// unknown to its type t (written by a later release), in the objects of the
+// structures and maps of t. The encoded data is returned as is when there
+// is none.
+func preserve(original, encoded []byte, t reflect.Type) []byte {
+	var before, after any
+	if decode(original, &before) != nil || decode(encoded, &after) != nil {
+		return encoded
+	}
+
+	merged, added := merge(before, after, t)
+	if !added {
+		return encoded
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return encoded
+	}
+	return data
+}
+
+// Function decodes JSON data keeping the numbers as written.
+func decode(data []byte, v *any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// Function merges into the encoded value the members of the original one
+// unknown to the type t, and reports whether any was added. The members
+// removed from a map and the values of the known members are those of the
+// encoded value.
+func merge(original, encoded any, t reflect.Type) (any, bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+		return encoded, false
+	}
+
+	before, ok := original.(map[string]any)
+	after, ok2 := encoded.(map[string]any)
+	if !ok || !ok2 {
+		return encoded, false
+	}
+
+	added := false
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := knownFields(t)
+		for name, value := range before {
+			field, known := fields[name]
+			if !known {
+				after[name] = value
+				added = true
+				continue
+			}
+			if current, ok := after[name]; ok {
+				var nested bool
+				after[name], nested = merge(value, current, field)
+				added = added || nested
+			}
+		}
+	case reflect.Map:
+		for key, value := range before {
+			if current, ok := after[key]; ok {
+				var nested bool
+				after[key], nested = merge(value, current, t.Elem())
+				added = added || nested
+			}
+		}
+	}
+	return after, added
+}
+
+// Function returns the JSON member names of the structure type with the
+// types of their fields, those of the embedded structures included.
+func knownFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				maps.Copy(fields, knownFields(embedded))
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
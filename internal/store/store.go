@@ -0,0 +1,297 @@
+// Package store persists the state files of brgnetuse (e.g., the peer
+// metadata) shared by the daemon, the scheduled jobs (prune, quotas) and
+// the command line utilities running at the same time.
+//
+// A state file holds a JSON document in a versioned envelope:
+//
+//	{"schema": 1, "data": ...}
+//
+// Every change is a load-modify-save under the flock of the lock file
+// next to it (<file>.lock), written to a temporary file renamed over the
+// state file, so readers never observe a partially written file and no
+// update is lost. The previous content is kept in <file>.bak and read, with
+// a warning, when the state file is found damaged.
+//
+// The members of a document unknown to the running release (written by a
+// later one) are kept on save, so a newer daemon and an older utility can
+// share the files.
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+)
+
+// Time to wait for the lock of a state file held by another process or
+// goroutine.
+var lockTimeout = 10 * time.Second
+
+// Warnings receives the warnings of the recovery from a damaged state file.
+// It may be replaced by callers and tests.
+var Warnings io.Writer = os.Stderr
+
+// Schema describes the format of a state file.
+type Schema struct {
+	// Version is the current format version, written with the data.
+	Version int
+
+	// Migrate converts the data of an earlier version to Version; 0 is the
+	// version of the files written before the envelope. Nil reads the data
+	// of every earlier version as is.
+	Migrate func(version int, data json.RawMessage) (json.RawMessage, error)
+}
+
+// File is a state file holding a value of type T.
+type File[T any] struct {
+	// Path is the path of the state file.
+	Path string
+
+	// Name describes the content in the errors (e.g., "peer metadata").
+	Name string
+
+	// Schema is the format of the file.
+	Schema Schema
+}
+
+// Function returns the backup path of a state file, see File.
+func BackupPath(path string) string {
+	return path + ".bak"
+}
+
+// Function returns the lock file path of a state file, see File.
+func LockPath(path string) string {
+	return path + ".lock"
+}
+
+// Method reads the value of the file. A missing or empty file is not an
+// error, the zero value is returned. A damaged file is replaced by its
+// backup when the backup can be read.
+func (f File[T]) Load() (T, error) {
+	var value T
+	doc, err := f.read()
+	if err != nil {
+		return value, err
+	}
+	return value, f.decode(doc, &value)
+}
+
+// Method applies fn to the value of the file under its lock and saves the
+// result. The file is written only when fn reports a change, an error of
+// fn is returned as is. The members of the file unknown to T are kept.
+//
+// Usage example:
+//
+//	file := store.File[map[string]int]{Path: "/var/lib/brgnetuse/counts.json", Name: "counts"}
+//	err := file.Update(func(counts *map[string]int) (bool, error) {
+//	    if *counts == nil {
+//	        *counts = map[string]int{}
+//	    }
+//	    (*counts)["wg0"]++
+//	    return true, nil
+//	})
+func (f File[T]) Update(fn func(*T) (bool, error)) error {
+	return f.locked(func() error {
+		doc, err := f.read()
+		if err != nil {
+			return err
+		}
+
+		var value T
+		if err := f.decode(doc, &value); err != nil {
+			return err
+		}
+
+		changed, err := fn(&value)
+		if err != nil || !changed {
+			return err
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("error: failed to encode %s: %v", f.Name, err)
+		}
+		if doc.data != nil {
+			data = preserve(doc.data, data, reflect.TypeFor[T]())
+		}
+		return f.write(doc, data)
+	})
+}
+
+// Method replaces the value of the file under its lock without reading it
+// (e.g., a record overwritten on every change), so a damaged file does not
+// stop it.
+func (f File[T]) Replace(value T) error {
+	return f.locked(func() error {
+		doc, err := f.read()
+		if err != nil {
+			doc = document{}
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("error: failed to encode %s: %v", f.Name, err)
+		}
+		doc.data = nil
+		return f.write(doc, data)
+	})
+}
+
+// Function removes a state file and its backup. Missing files are not an
+// error. The lock file is kept, another process may be waiting on it.
+func Remove(path string) error {
+	for _, name := range []string{path, BackupPath(path)} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function moves a state file and its backup to the directory. Missing
+// files are not an error.
+func Move(path, dir string) error {
+	for _, name := range []string{path, BackupPath(path)} {
+		target := filepath.Join(dir, filepath.Base(name))
+		if err := os.Rename(name, target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function renames a state file and its backup to the target path, which
+// must not exist. Missing files are not an error.
+func Rename(path, target string) error {
+	for _, name := range []string{target, BackupPath(target)} {
+		if _, err := os.Lstat(name); err == nil {
+			return fmt.Errorf("error: state file '%s' already exists", name)
+		}
+	}
+
+	for _, pair := range [][2]string{{path, target}, {BackupPath(path), BackupPath(target)}} {
+		if err := os.Rename(pair[0], pair[1]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Method runs fn while holding the lock of the file.
+func (f File[T]) locked(fn func() error) error {
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0o755); err != nil {
+		return fmt.Errorf("error: failed to create %s directory: %w", f.Name, err)
+	}
+
+	release, err := oplock.LockFile(LockPath(f.Path), lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return fn()
+}
+
+// Method reads the document of the file, the backup when the file is
+// damaged. The document of a missing or empty file has no data.
+func (f File[T]) read() (document, error) {
+	raw, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(raw) == 0) {
+		return document{}, nil
+	}
+	if err != nil {
+		return document{}, fmt.Errorf("error: failed to read %s: %w", f.Name, err)
+	}
+
+	doc, parseErr := f.parse(raw)
+	if parseErr == nil {
+		doc.raw = raw
+		return doc, nil
+	}
+
+	backup, err := os.ReadFile(BackupPath(f.Path))
+	if err == nil && len(backup) > 0 {
+		if doc, err := f.parse(backup); err == nil {
+			fmt.Fprintf(Warnings, "warning: %s '%s' is damaged (%v), using its backup '%s'\n",
+				f.Name, f.Path, parseErr, BackupPath(f.Path))
+			return doc, nil
+		}
+	}
+	return document{}, fmt.Errorf("error: failed to parse %s '%s': %v", f.Name, f.Path, parseErr)
+}
+
+// Method parses the content of the file into its document and checks that
+// the data decodes into T.
+func (f File[T]) parse(raw []byte) (document, error) {
+	doc, err := parseDocument(raw, f.Schema)
+	if err != nil {
+		return document{}, err
+	}
+	var value T
+	if err := json.Unmarshal(doc.data, &value); err != nil {
+		return document{}, err
+	}
+	return doc, nil
+}
+
+// Method decodes the data of the document into value, left untouched for a
+// document without data.
+func (f File[T]) decode(doc document, value *T) error {
+	if doc.data == nil {
+		return nil
+	}
+	if err := json.Unmarshal(doc.data, value); err != nil {
+		return fmt.Errorf("error: failed to parse %s '%s': %v", f.Name, f.Path, err)
+	}
+	return nil
+}
+
+// Method writes the data in the envelope of the document to a temporary
+// file renamed over the file. The content read from the file, when it was
+// not damaged, becomes the backup.
+func (f File[T]) write(doc document, data []byte) error {
+	content, err := doc.encode(data, f.Schema)
+	if err != nil {
+		return fmt.Errorf("error: failed to encode %s: %v", f.Name, err)
+	}
+
+	if doc.raw != nil {
+		if err := writeAtomic(BackupPath(f.Path), doc.raw); err != nil {
+			return fmt.Errorf("error: failed to write %s backup: %w", f.Name, err)
+		}
+	}
+	if err := writeAtomic(f.Path, content); err != nil {
+		return fmt.Errorf("error: failed to save %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+// Function writes the content to a temporary file synced to disk and
+// renames it over the path.
+func writeAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
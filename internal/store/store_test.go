@@ -0,0 +1,325 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// counter is the state file value of the tests.
+type counter struct {
+	Counts map[string]int `json:"counts,omitempty"`
+	Note   string         `json:"note,omitempty"`
+}
+
+// Function returns a state file of the tests in a temporary directory, with
+// its warnings captured.
+func useFile(t *testing.T, schema Schema) (File[counter], *strings.Builder) {
+	t.Helper()
+
+	var warnings strings.Builder
+	prev := Warnings
+	Warnings = &warnings
+	t.Cleanup(func() { Warnings = prev })
+
+	path := filepath.Join(t.TempDir(), "wg0-counter.json")
+	return File[counter]{Path: path, Name: "counter", Schema: schema}, &warnings
+}
+
+// Function increments the count of the key.
+func increment(key string) func(*counter) (bool, error) {
+	return func(c *counter) (bool, error) {
+		if c.Counts == nil {
+			c.Counts = map[string]int{}
+		}
+		c.Counts[key]++
+		return true, nil
+	}
+}
+
+// Testing that many goroutines updating the same file lose no update, while
+// the file always parses for the readers.
+func TestConcurrentUpdate(t *testing.T) {
+	file, warnings := useFile(t, Schema{Version: 1})
+
+	const workers, updates = 16, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*updates+1)
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := file.Load(); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range updates {
+				if err := file.Update(increment(fmt.Sprintf("worker-%d", i))); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	readers.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	value, err := file.Load()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for i := range workers {
+		if got := value.Counts[fmt.Sprintf("worker-%d", i)]; got != updates {
+			t.Errorf("error: worker %d got %d updates, want %d", i, got, updates)
+		}
+	}
+	if warnings.Len() != 0 {
+		t.Errorf("error: unexpected warnings %q", warnings.String())
+	}
+}
+
+// Testing that a damaged file is replaced by its backup with a warning,
+// and reported when there is no backup.
+func TestRecovery(t *testing.T) {
+	file, warnings := useFile(t, Schema{Version: 1})
+
+	if err := file.Update(increment("wg0")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(BackupPath(file.Path)); !os.IsNotExist(err) {
+		t.Fatalf("error: backup written without a previous content: %v", err)
+	}
+	if err := file.Update(increment("wg0")); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(file.Path, info.Size()/2); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := file.Load()
+	if err != nil || value.Counts["wg0"] != 1 {
+		t.Fatalf("error: got %+v, %v, want the backup", value, err)
+	}
+	if !strings.Contains(warnings.String(), "warning: counter") || !strings.Contains(warnings.String(), ".bak") {
+		t.Errorf("error: got warnings %q", warnings.String())
+	}
+
+	// The damaged content does not replace the backup.
+	if err := file.Update(increment("wg0")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := file.Load(); err != nil || value.Counts["wg0"] != 2 {
+		t.Errorf("error: got %+v, %v after repair", value, err)
+	}
+
+	for _, path := range []string{file.Path, BackupPath(file.Path)} {
+		if err := os.WriteFile(path, []byte(`{"schema": 1, "data": {"counts": `), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := file.Load(); err == nil || !strings.HasPrefix(err.Error(), "error: failed to parse counter") {
+		t.Errorf("error: got %v, want parse error", err)
+	}
+	if err := file.Update(increment("wg0")); err == nil {
+		t.Error("error: expected error updating a damaged file without backup, got none")
+	}
+	if err := file.Replace(counter{Note: "reset"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if value, err := file.Load(); err != nil || value.Note != "reset" {
+		t.Errorf("error: got %+v, %v after replace", value, err)
+	}
+}
+
+// Testing that the members unknown to the value, written by a later release,
+// survive an update.
+func TestPreserveUnknown(t *testing.T) {
+	file, _ := useFile(t, Schema{Version: 1})
+
+	later := `{
+  "schema": 2,
+  "data": {
+    "counts": {"wg0": 1, "wg1": 5},
+    "note": "old",
+    "labels": {"site": "paris"}
+  },
+  "written_by": "brgnetuse 9.0"
+}`
+	if err := os.WriteFile(file.Path, []byte(later), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := file.Update(func(c *counter) (bool, error) {
+		c.Counts["wg0"]++
+		delete(c.Counts, "wg1")
+		c.Note = ""
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Schema    int             `json:"schema"`
+		Data      json.RawMessage `json:"data"`
+		WrittenBy string          `json:"written_by"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("error: file does not parse: %v", err)
+	}
+	if got.Schema != 2 || got.WrittenBy != "brgnetuse 9.0" {
+		t.Errorf("error: got envelope %s", data)
+	}
+
+	var members map[string]any
+	if err := json.Unmarshal(got.Data, &members); err != nil {
+		t.Fatal(err)
+	}
+	counts, _ := members["counts"].(map[string]any)
+	if counts["wg0"] != 2.0 || counts["wg1"] != nil {
+		t.Errorf("error: got counts %v", members["counts"])
+	}
+	if _, ok := members["note"]; ok {
+		t.Errorf("error: cleared note was kept: %v", members)
+	}
+	if labels, _ := members["labels"].(map[string]any); labels["site"] != "paris" {
+		t.Errorf("error: unknown member lost: %s", got.Data)
+	}
+
+	if backup, err := os.ReadFile(BackupPath(file.Path)); err != nil || string(backup) != later {
+		t.Errorf("error: got backup %q, %v, want the previous content", backup, err)
+	}
+}
+
+// Testing that the data of an earlier version is migrated.
+func TestMigrate(t *testing.T) {
+	schema := Schema{
+		Version: 2,
+		Migrate: func(version int, data json.RawMessage) (json.RawMessage, error) {
+			if version == 0 {
+				// Version 0 was a bare map of the counts.
+				return json.Marshal(map[string]json.RawMessage{"counts": data})
+			}
+			return data, nil
+		},
+	}
+	file, _ := useFile(t, schema)
+
+	if err := os.WriteFile(file.Path, []byte(`{"wg0": 3}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	value, err := file.Load()
+	if err != nil || value.Counts["wg0"] != 3 {
+		t.Fatalf("error: got %+v, %v", value, err)
+	}
+
+	if err := file.Update(increment("wg0")); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "{\n  \"schema\": 2,") {
+		t.Errorf("error: got file %s", data)
+	}
+	if value, err := file.Load(); err != nil || value.Counts["wg0"] != 4 {
+		t.Errorf("error: got %+v, %v", value, err)
+	}
+}
+
+// Testing that Remove and Move handle the backup with the file.
+func TestRemoveMove(t *testing.T) {
+	file, _ := useFile(t, Schema{Version: 1})
+	for range 2 {
+		if err := file.Update(increment("wg0")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := Move(file.Path, dir); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	moved := filepath.Join(dir, filepath.Base(file.Path))
+	for _, path := range []string{moved, BackupPath(moved)} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("error: %s was not moved: %v", path, err)
+		}
+	}
+
+	if err := Remove(moved); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := Remove(moved); err != nil {
+		t.Fatalf("error: unexpected error removing missing files: %v", err)
+	}
+	for _, path := range []string{moved, BackupPath(moved)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("error: %s was not removed: %v", path, err)
+		}
+	}
+}
+
+// Testing that the lock of a file held by another holder times out.
+func TestLockTimeout(t *testing.T) {
+	file, _ := useFile(t, Schema{Version: 1})
+
+	prev := lockTimeout
+	lockTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { lockTimeout = prev })
+
+	hold, held, done := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		done <- file.Update(func(*counter) (bool, error) {
+			close(held)
+			<-hold
+			return false, nil
+		})
+	}()
+	<-held
+
+	err := file.Update(increment("wg0"))
+	close(hold)
+	if err := <-done; err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err == nil {
+		t.Error("error: expected error while the lock is held, got none")
+	}
+}
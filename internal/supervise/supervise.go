@@ -0,0 +1,154 @@
+//go:build !windows
+
+// Package supervise keeps the process of a userspace device (brgaddwg,
+// brgaddawg -supervise) running: the process is restarted with exponential
+// backoff whenever it exits, until the supervisor is told to stop.
+package supervise
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/src/proc"
+)
+
+// Default supervision settings.
+const (
+	DefaultMinBackoff  = time.Second
+	DefaultMaxBackoff  = time.Minute
+	DefaultStableAfter = time.Minute
+	DefaultStopTimeout = 10 * time.Second
+)
+
+// Supervisor restarts a process when it exits.
+type Supervisor struct {
+	// Start starts a new process and returns once it is ready.
+	Start func() (*exec.Cmd, error)
+
+	// Logf logs the exits and restarts of the process.
+	Logf func(format string, args ...any)
+
+	// MinBackoff is the delay before the first restart, doubled for each
+	// following restart up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// StableAfter is the run time after which the process is considered
+	// healthy again: its next restart waits MinBackoff.
+	StableAfter time.Duration
+
+	// StopTimeout is the time given to the process to exit on stop, before
+	// it is killed.
+	StopTimeout time.Duration
+}
+
+// Function returns a supervisor with the default settings.
+func New(start func() (*exec.Cmd, error), logf func(format string, args ...any)) *Supervisor {
+	return &Supervisor{
+		Start:       start,
+		Logf:        logf,
+		MinBackoff:  DefaultMinBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		StableAfter: DefaultStableAfter,
+		StopTimeout: DefaultStopTimeout,
+	}
+}
+
+// Method starts the process and restarts it whenever it exits, until a
+// signal is received on stop: the signal is then forwarded to the process
+// and Run returns once it exited. An error is returned only if the first
+// start fails, a configuration error must not be retried forever.
+func (s *Supervisor) Run(stop <-chan os.Signal) error {
+	cmd, err := s.Start()
+	if err != nil {
+		return err
+	}
+	s.Logf("process %d started", cmd.Process.Pid)
+
+	backoff := s.MinBackoff
+	for {
+		started := time.Now()
+		done := make(chan error, 1)
+		go func(cmd *exec.Cmd) { done <- cmd.Wait() }(cmd)
+
+		select {
+		case sig := <-stop:
+			s.stopProcess(cmd, done, sig)
+			return nil
+		case err := <-done:
+			if time.Since(started) >= s.StableAfter {
+				backoff = s.MinBackoff
+			}
+			s.Logf("process %d exited (%s), restarting in %s", cmd.Process.Pid, exitReason(err), backoff)
+		}
+
+		for {
+			select {
+			case <-stop:
+				return nil
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, s.MaxBackoff)
+
+			cmd, err = s.Start()
+			if err == nil {
+				s.Logf("process %d started", cmd.Process.Pid)
+				break
+			}
+			s.Logf("restart failed, %v, retrying in %s", err, backoff)
+		}
+	}
+}
+
+// Method forwards the stop signal to the process and waits for it to exit,
+// killing it after StopTimeout.
+func (s *Supervisor) stopProcess(cmd *exec.Cmd, done <-chan error, sig os.Signal) {
+	cmd.Process.Signal(sig)
+
+	select {
+	case <-done:
+	case <-time.After(s.StopTimeout):
+		s.Logf("process %d did not stop within %s, killing it", cmd.Process.Pid, s.StopTimeout)
+		cmd.Process.Kill()
+		<-done
+	}
+}
+
+// Function describes how the process exited (e.g., "exit status 2",
+// "signal: killed").
+func exitReason(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+// Function replaces the calling process with the supervisor of the network
+// interface: the running executable is re-executed with the same arguments,
+// tagged as the supervisor, so process discovery and the stop commands find
+// it rather than its device process.
+func Exec(args []string, wgType, iface string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error: failed to locate executable, %v", err)
+	}
+
+	env := append(
+		proc.ProcessEnv(wgType, iface),
+		fmt.Sprintf("%s=1", proc.EnvFieldSupervisor),
+	)
+
+	if err := syscall.Exec(executable, args, env); err != nil {
+		return fmt.Errorf("error: failed to start supervisor, %v", err)
+	}
+	return nil
+}
+
+// Function reports whether the calling process is a supervisor started
+// by Exec.
+func IsSupervisor() bool {
+	return os.Getenv(proc.EnvFieldSupervisor) == "1"
+}
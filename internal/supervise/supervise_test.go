@@ -0,0 +1,248 @@
+//go:build !windows
+
+package supervise
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Environment variable selecting the behaviour of the test binary started
+// as the supervised process.
+const envHelper = "SUPERVISE_TEST_HELPER"
+
+// Function runs the test binary as the supervised process when envHelper
+// is set, otherwise it runs the tests.
+func TestMain(m *testing.M) {
+	switch os.Getenv(envHelper) {
+	case "":
+		os.Exit(m.Run())
+	case "crash":
+		fmt.Println("ready")
+		os.Exit(2)
+	case "run":
+		term := make(chan os.Signal, 1)
+		signal.Notify(term, syscall.SIGTERM)
+		fmt.Println("ready")
+		<-term
+		os.Exit(0)
+	case "ignore":
+		signal.Ignore(syscall.SIGTERM)
+		fmt.Println("ready")
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	}
+}
+
+// fakeProcesses starts the test binary with the listed behaviours, one per
+// start, and records the started commands and the log of the supervisor.
+type fakeProcesses struct {
+	mu         sync.Mutex
+	behaviours []string
+	failStarts map[int]bool
+	cmds       []*exec.Cmd
+	logs       []string
+	running    chan *exec.Cmd
+}
+
+// Function returns fake processes with the behaviours, the last one is
+// repeated for the following starts.
+func newFakeProcesses(behaviours ...string) *fakeProcesses {
+	return &fakeProcesses{
+		behaviours: behaviours,
+		failStarts: map[int]bool{},
+		running:    make(chan *exec.Cmd, 16),
+	}
+}
+
+// Method starts the next fake process and returns once it is ready.
+func (f *fakeProcesses) start() (*exec.Cmd, error) {
+	f.mu.Lock()
+	n := len(f.cmds)
+	fail := f.failStarts[n]
+	f.cmds = append(f.cmds, nil)
+	behaviour := f.behaviours[min(n, len(f.behaviours)-1)]
+	f.mu.Unlock()
+
+	if fail {
+		return nil, errors.New("error: failed to create TUN device")
+	}
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), envHelper+"="+behaviour)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	if _, err := bufio.NewReader(stdout).ReadString('\n'); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.cmds[n] = cmd
+	f.mu.Unlock()
+
+	if behaviour != "crash" {
+		f.running <- cmd
+	}
+	return cmd, nil
+}
+
+// Method records a log line of the supervisor.
+func (f *fakeProcesses) logf(format string, args ...any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logs = append(f.logs, fmt.Sprintf(format, args...))
+}
+
+// Method returns the log lines of the supervisor containing substr.
+func (f *fakeProcesses) logsWith(substr string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var lines []string
+	for _, line := range f.logs {
+		if strings.Contains(line, substr) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Function returns a supervisor of the fake processes with short delays.
+func newTestSupervisor(f *fakeProcesses) *Supervisor {
+	s := New(f.start, f.logf)
+	s.MinBackoff = 10 * time.Millisecond
+	s.MaxBackoff = 30 * time.Millisecond
+	s.StopTimeout = 5 * time.Second
+	return s
+}
+
+// Function runs the supervisor until a process keeps running, then stops it
+// and returns that process.
+func runUntilRunning(t *testing.T, s *Supervisor, f *fakeProcesses) *exec.Cmd {
+	t.Helper()
+
+	stop := make(chan os.Signal, 1)
+	result := make(chan error, 1)
+	go func() { result <- s.Run(stop) }()
+
+	var cmd *exec.Cmd
+	select {
+	case cmd = <-f.running:
+	case <-time.After(10 * time.Second):
+		t.Fatal("error: no process kept running")
+	}
+
+	stop <- syscall.SIGTERM
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("error: the supervisor did not stop")
+	}
+	return cmd
+}
+
+// Testing that crashed processes are restarted with exponential backoff,
+// reset once a process ran for StableAfter.
+func TestRunRestarts(t *testing.T) {
+	type testCase struct {
+		name        string
+		stableAfter time.Duration
+		wantBackoff []string
+	}
+
+	tests := []testCase{
+		{name: "backoff", stableAfter: time.Hour, wantBackoff: []string{"10ms", "20ms", "30ms"}},
+		{name: "stable", stableAfter: 0, wantBackoff: []string{"10ms", "10ms", "10ms"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakeProcesses("crash", "crash", "crash", "run")
+			s := newTestSupervisor(f)
+			s.StableAfter = tc.stableAfter
+
+			cmd := runUntilRunning(t, s, f)
+
+			if len(f.cmds) != 4 {
+				t.Errorf("error: got %d starts, want 4", len(f.cmds))
+			}
+
+			exits := f.logsWith("exited (exit status 2)")
+			if len(exits) != len(tc.wantBackoff) {
+				t.Fatalf("error: got exit logs %q, want %d", exits, len(tc.wantBackoff))
+			}
+			for i, want := range tc.wantBackoff {
+				if !strings.HasSuffix(exits[i], "restarting in "+want) {
+					t.Errorf("error: got %q, want a restart in %s", exits[i], want)
+				}
+			}
+
+			if cmd.ProcessState == nil || !cmd.ProcessState.Success() {
+				t.Errorf("error: the running process was not stopped by SIGTERM: %v", cmd.ProcessState)
+			}
+		})
+	}
+}
+
+// Testing that a failed first start is returned rather than retried.
+func TestRunFirstStartFails(t *testing.T) {
+	f := newFakeProcesses("run")
+	f.failStarts[0] = true
+
+	err := newTestSupervisor(f).Run(make(chan os.Signal))
+	if err == nil || !strings.Contains(err.Error(), "failed to create TUN device") {
+		t.Fatalf("error: got %v, want the start error", err)
+	}
+	if len(f.cmds) != 1 {
+		t.Errorf("error: got %d starts, want 1", len(f.cmds))
+	}
+}
+
+// Testing that a failed restart is retried.
+func TestRunRestartFails(t *testing.T) {
+	f := newFakeProcesses("crash", "run")
+	f.failStarts[1] = true
+
+	runUntilRunning(t, newTestSupervisor(f), f)
+
+	if failed := f.logsWith("restart failed"); len(failed) != 1 {
+		t.Errorf("error: got restart failure logs %q, want 1", failed)
+	}
+	if len(f.cmds) != 3 {
+		t.Errorf("error: got %d starts, want 3", len(f.cmds))
+	}
+}
+
+// Testing that a process ignoring the stop signal is killed.
+func TestRunStopKills(t *testing.T) {
+	f := newFakeProcesses("ignore")
+	s := newTestSupervisor(f)
+	s.StopTimeout = 100 * time.Millisecond
+
+	cmd := runUntilRunning(t, s, f)
+
+	if killed := f.logsWith("killing it"); len(killed) != 1 {
+		t.Errorf("error: got kill logs %q, want 1", killed)
+	}
+	status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() || status.Signal() != syscall.SIGKILL {
+		t.Errorf("error: got %v, want the process killed", cmd.ProcessState)
+	}
+}
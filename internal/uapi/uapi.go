@@ -0,0 +1,212 @@
+// Package configures the userspace devices through their UAPI socket,
+// the protocol behind `wg set` and `awg set`. A request is a list of
+// `key=value` lines ended by an empty line:
+//
+//	set=1
+//	listen_port=51820
+//	public_key=<hex>
+//	allowed_ip=10.10.10.2/32
+//
+// and the device answers with `errno=0` on success. The brgaddawg devices
+// embed amneziawg-go and serve this socket, so brgsetwg configures them
+// without the awg tool installed.
+package uapi
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Time allowed for a request and its response.
+var Timeout = 5 * time.Second
+
+// Function returns the UAPI socket of the network interface in dir when
+// a brgnetuse process (brgaddwg, brgaddawg) serves it and it accepts
+// connections. A socket of any other process is not used.
+func Owned(dir, iface string) (string, bool) {
+	socket, err := handlers.InspectSocket(dir, iface)
+	if err != nil || socket.Pid == 0 || !socket.Alive {
+		return "", false
+	}
+	return socket.Path, true
+}
+
+// Function applies the configuration to the device of the UAPI socket with
+// the same semantics as wgctrl: nil fields are left unchanged.
+//
+// Usage example:
+//
+//	port := 51821
+//	err := uapi.Configure("/var/run/amneziawg/awg0.sock", wgtypes.Config{ListenPort: &port})
+//	if err != nil {
+//	    // Handle error
+//	}
+func Configure(path string, config wgtypes.Config) error {
+	var request strings.Builder
+	request.WriteString("set=1\n")
+	writeConfig(&request, config)
+	request.WriteString("\n")
+
+	_, err := exchange(path, request.String())
+	return err
+}
+
+// Function reads the public key of the device of the UAPI socket, derived
+// from its private key.
+func PublicKey(path string) (wgtypes.Key, error) {
+	fields, err := deviceFields(path)
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+
+	value, ok := fields["private_key"]
+	if !ok {
+		return wgtypes.Key{}, help.Errorf(help.CodeDeviceFailed, "error: device of UAPI socket '%s' has no private key", path)
+	}
+	key, err := parseKey(value)
+	if err != nil {
+		return wgtypes.Key{}, help.Errorf(help.CodeDeviceFailed, "error: invalid private key from UAPI socket '%s'", path)
+	}
+	defer clear(key[:])
+	return key.PublicKey(), nil
+}
+
+// Function reads the listening port of the device of the UAPI socket.
+func ListenPort(path string) (int, error) {
+	fields, err := deviceFields(path)
+	if err != nil {
+		return 0, err
+	}
+
+	port, err := strconv.Atoi(fields["listen_port"])
+	if err != nil {
+		return 0, help.Errorf(help.CodeDeviceFailed, "error: invalid listen port from UAPI socket '%s'", path)
+	}
+	return port, nil
+}
+
+// Function reads the device fields of the UAPI socket with `get=1`, the
+// lines before the first peer.
+func deviceFields(path string) (map[string]string, error) {
+	lines, err := exchange(path, "get=1\n\n")
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if key == "public_key" {
+			break
+		}
+		fields[key] = value
+	}
+	return fields, nil
+}
+
+// Function writes the configuration as UAPI `set` lines, keys hex encoded.
+func writeConfig(w io.Writer, config wgtypes.Config) {
+	if config.PrivateKey != nil {
+		fmt.Fprintf(w, "private_key=%s\n", hex.EncodeToString(config.PrivateKey[:]))
+	}
+	if config.ListenPort != nil {
+		fmt.Fprintf(w, "listen_port=%d\n", *config.ListenPort)
+	}
+	if config.FirewallMark != nil {
+		fmt.Fprintf(w, "fwmark=%d\n", *config.FirewallMark)
+	}
+	if config.ReplacePeers {
+		fmt.Fprintln(w, "replace_peers=true")
+	}
+
+	for _, peer := range config.Peers {
+		fmt.Fprintf(w, "public_key=%s\n", hex.EncodeToString(peer.PublicKey[:]))
+		if peer.Remove {
+			fmt.Fprintln(w, "remove=true")
+			continue
+		}
+		if peer.UpdateOnly {
+			fmt.Fprintln(w, "update_only=true")
+		}
+		if peer.PresharedKey != nil {
+			fmt.Fprintf(w, "preshared_key=%s\n", hex.EncodeToString(peer.PresharedKey[:]))
+		}
+		if peer.Endpoint != nil {
+			fmt.Fprintf(w, "endpoint=%s\n", peer.Endpoint.String())
+		}
+		if peer.PersistentKeepaliveInterval != nil {
+			fmt.Fprintf(w, "persistent_keepalive_interval=%d\n", int(peer.PersistentKeepaliveInterval.Seconds()))
+		}
+		if peer.ReplaceAllowedIPs {
+			fmt.Fprintln(w, "replace_allowed_ips=true")
+		}
+		for _, ip := range peer.AllowedIPs {
+			fmt.Fprintf(w, "allowed_ip=%s\n", ip.String())
+		}
+	}
+}
+
+// Function sends the request to the UAPI socket and returns the response
+// lines before the errno line. A non-zero errno is an error; the error
+// never contains the request, which may hold a private key.
+func exchange(path, request string) ([]string, error) {
+	conn, err := net.DialTimeout("unix", path, Timeout)
+	if err != nil {
+		return nil, help.Errorf(help.CodeDeviceFailed, "error: failed to connect to UAPI socket '%s': %v", path, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(Timeout)); err != nil {
+		return nil, help.Errorf(help.CodeDeviceFailed, "error: failed to use UAPI socket '%s': %v", path, err)
+	}
+	if _, err := io.WriteString(conn, request); err != nil {
+		return nil, help.Errorf(help.CodeDeviceFailed, "error: failed to write to UAPI socket '%s': %v", path, err)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		errno, ok := strings.CutPrefix(line, "errno=")
+		if !ok {
+			lines = append(lines, line)
+			continue
+		}
+		if errno != "0" {
+			return nil, help.Errorf(help.CodeDeviceFailed, "error: UAPI socket '%s' refused the configuration, errno=%s", path, errno)
+		}
+		return lines, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, help.Errorf(help.CodeDeviceFailed, "error: failed to read from UAPI socket '%s': %v", path, err)
+	}
+	return nil, help.Errorf(help.CodeDeviceFailed, "error: UAPI socket '%s' closed without a response", path)
+}
+
+// Function parses a hex encoded key.
+func parseKey(value string) (wgtypes.Key, error) {
+	var key wgtypes.Key
+	raw, err := hex.DecodeString(value)
+	if err != nil || len(raw) != len(key) {
+		return key, fmt.Errorf("error: invalid key")
+	}
+	copy(key[:], raw)
+	clear(raw)
+	return key, nil
+}
@@ -0,0 +1,188 @@
+package uapi
+
+import (
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/uapimock"
+	"github.com/AlexKira/brgnetuse/src/proc"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Testing the Configure function: the wire format of every field.
+func TestConfigure(t *testing.T) {
+	server := uapimock.Serve(t, filepath.Join(t.TempDir(), "awg0.sock"))
+
+	private, _ := wgtypes.GeneratePrivateKey()
+	peer, _ := wgtypes.GenerateKey()
+	preshared, _ := wgtypes.GenerateKey()
+	removed, _ := wgtypes.GenerateKey()
+	port, mark := 51821, 51820
+	keepalive := 25 * time.Second
+	_, v4, _ := net.ParseCIDR("10.10.10.2/32")
+	_, v6, _ := net.ParseCIDR("fd00::2/128")
+
+	type testCase struct {
+		name   string
+		config wgtypes.Config
+		want   []string
+	}
+
+	tests := []testCase{
+		{
+			name:   "device",
+			config: wgtypes.Config{PrivateKey: &private, ListenPort: &port, FirewallMark: &mark},
+			want: []string{
+				"set=1",
+				"private_key=" + hex.EncodeToString(private[:]),
+				"listen_port=51821",
+				"fwmark=51820",
+			},
+		},
+		{
+			name: "add_peer",
+			config: wgtypes.Config{Peers: []wgtypes.PeerConfig{{
+				PublicKey:                   peer,
+				PresharedKey:                &preshared,
+				Endpoint:                    &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 51820},
+				PersistentKeepaliveInterval: &keepalive,
+				ReplaceAllowedIPs:           true,
+				AllowedIPs:                  []net.IPNet{*v4, *v6},
+			}}},
+			want: []string{
+				"set=1",
+				"public_key=" + hex.EncodeToString(peer[:]),
+				"preshared_key=" + hex.EncodeToString(preshared[:]),
+				"endpoint=[2001:db8::1]:51820",
+				"persistent_keepalive_interval=25",
+				"replace_allowed_ips=true",
+				"allowed_ip=10.10.10.2/32",
+				"allowed_ip=fd00::2/128",
+			},
+		},
+		{
+			name: "remove_and_update_peers",
+			config: wgtypes.Config{ReplacePeers: true, Peers: []wgtypes.PeerConfig{
+				{PublicKey: removed, Remove: true, AllowedIPs: []net.IPNet{*v4}},
+				{PublicKey: peer, UpdateOnly: true},
+			}},
+			want: []string{
+				"set=1",
+				"replace_peers=true",
+				"public_key=" + hex.EncodeToString(removed[:]),
+				"remove=true",
+				"public_key=" + hex.EncodeToString(peer[:]),
+				"update_only=true",
+			},
+		},
+	}
+
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := Configure(server.Path, tc.config); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			requests := server.Requests()
+			if len(requests) != i+1 {
+				t.Fatalf("error: got %d requests, want %d", len(requests), i+1)
+			}
+			if want := strings.Join(tc.want, "\n") + "\n"; requests[i] != want {
+				t.Errorf("error: got\n%s\nwant\n%s", requests[i], want)
+			}
+		})
+	}
+}
+
+// Testing the Configure function with a refused request and a missing socket.
+func TestConfigureErrors(t *testing.T) {
+	server := uapimock.Serve(t, filepath.Join(t.TempDir(), "awg0.sock"))
+	server.SetErrno(22)
+
+	private, _ := wgtypes.GeneratePrivateKey()
+	err := Configure(server.Path, wgtypes.Config{PrivateKey: &private})
+	if err == nil || !strings.Contains(err.Error(), "errno=22") {
+		t.Fatalf("error: got %v, want the errno", err)
+	}
+	if strings.Contains(err.Error(), hex.EncodeToString(private[:])) {
+		t.Errorf("error: the private key is in the error")
+	}
+
+	if err := Configure(filepath.Join(t.TempDir(), "missing.sock"), wgtypes.Config{}); err == nil {
+		t.Errorf("error: expected error for a missing socket, got none")
+	}
+}
+
+// Testing the PublicKey function: the key is derived from the private key
+// read with `get=1`.
+func TestPublicKey(t *testing.T) {
+	server := uapimock.Serve(t, filepath.Join(t.TempDir(), "awg0.sock"))
+
+	if _, err := PublicKey(server.Path); err == nil {
+		t.Fatalf("error: expected error without a private key, got none")
+	}
+
+	private, _ := wgtypes.GeneratePrivateKey()
+	if err := Configure(server.Path, wgtypes.Config{PrivateKey: &private}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	got, err := PublicKey(server.Path)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if got != private.PublicKey() {
+		t.Errorf("error: got %s, want %s", got, private.PublicKey())
+	}
+}
+
+// Testing the ListenPort function.
+func TestListenPort(t *testing.T) {
+	server := uapimock.Serve(t, filepath.Join(t.TempDir(), "awg0.sock"))
+
+	port := 51821
+	if err := Configure(server.Path, wgtypes.Config{ListenPort: &port}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	got, err := ListenPort(server.Path)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if got != port {
+		t.Errorf("error: got %d, want %d", got, port)
+	}
+}
+
+// Testing the Owned function: only a socket served by a tagged process is used.
+func TestOwned(t *testing.T) {
+	prevProc, prevDir := proc.ProcDir, handlers.AwgSocketDir
+	proc.ProcDir, handlers.AwgSocketDir = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { proc.ProcDir, handlers.AwgSocketDir = prevProc, prevDir })
+
+	uapimock.Serve(t, handlers.SocketPath(handlers.AwgSocketDir, "awg0"))
+	uapimock.Serve(t, handlers.SocketPath(handlers.AwgSocketDir, "awg1"))
+
+	// Only awg0 is served by a brgnetuse process.
+	dir := filepath.Join(proc.ProcDir, "4242")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	environ := proc.EnvFieldTag + "=awg0\x00" + proc.EnvFieldType + "=awg\x00"
+	if err := os.WriteFile(filepath.Join(dir, "environ"), []byte(environ), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if path, ok := Owned(handlers.AwgSocketDir, "awg0"); !ok || path != handlers.SocketPath(handlers.AwgSocketDir, "awg0") {
+		t.Errorf("error: got %q, %v for awg0, want its socket", path, ok)
+	}
+	if _, ok := Owned(handlers.AwgSocketDir, "awg1"); ok {
+		t.Errorf("error: foreign socket of awg1 is owned")
+	}
+	if _, ok := Owned(handlers.AwgSocketDir, "awg2"); ok {
+		t.Errorf("error: missing socket of awg2 is owned")
+	}
+}
@@ -0,0 +1,126 @@
+// Package serves a UAPI socket in-process for tests.
+//
+// The server speaks the protocol of amneziawg-go and wireguard-go: it
+// records every `set=1` request verbatim, keeps the private key and the
+// listening port it sets and answers `get=1` with them, so code configuring
+// a userspace device can be tested without a real interface or the awg tool.
+package uapimock
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Server is an in-process UAPI socket.
+type Server struct {
+	// Path is the socket file path.
+	Path string
+
+	mu         sync.Mutex
+	requests   []string
+	privateKey string
+	listenPort int
+	errno      int
+	listener   net.Listener
+}
+
+// Function listens on the socket path until the test ends.
+func Serve(t testing.TB, path string) *Server {
+	t.Helper()
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("error: failed to listen on '%s': %v", path, err)
+	}
+	s := &Server{Path: path, listener: listener}
+
+	go s.accept()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+// Method returns the `set=1` requests received, without the final empty line.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Method sets the private key answered to `get=1`.
+func (s *Server) SetPrivateKey(key wgtypes.Key) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.privateKey = hex.EncodeToString(key[:])
+}
+
+// Method makes the server refuse the next requests with the errno.
+func (s *Server) SetErrno(errno int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errno = errno
+}
+
+// Method serves the connections until the listener is closed.
+func (s *Server) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		go s.serve(conn)
+	}
+}
+
+// Method answers the requests of a connection.
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		operation := scanner.Text()
+
+		var lines []string
+		for scanner.Scan() && scanner.Text() != "" {
+			lines = append(lines, scanner.Text())
+		}
+
+		s.mu.Lock()
+		errno := s.errno
+		switch operation {
+		case "set=1":
+			s.requests = append(s.requests, strings.Join(append([]string{operation}, lines...), "\n")+"\n")
+			for _, line := range lines {
+				if errno != 0 {
+					break
+				}
+				if key, ok := strings.CutPrefix(line, "private_key="); ok {
+					s.privateKey = key
+				}
+				if port, ok := strings.CutPrefix(line, "listen_port="); ok {
+					s.listenPort, _ = strconv.Atoi(port)
+				}
+			}
+			fmt.Fprintf(conn, "errno=%d\n\n", errno)
+		case "get=1":
+			if s.privateKey != "" {
+				fmt.Fprintf(conn, "private_key=%s\n", s.privateKey)
+			}
+			fmt.Fprintf(conn, "listen_port=%d\nerrno=%d\n\n", s.listenPort, errno)
+		default:
+			fmt.Fprint(conn, "errno=1\n\n")
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,73 @@
+// Package version holds the build information shared by all brgnetuse
+// utilities, so every binary and its logs report the same version.
+package version
+
+import "runtime/debug"
+
+// Build information injected at link time, for example:
+//
+//	go build -ldflags "-X github.com/AlexKira/brgnetuse/internal/version.Version=1.2.0 \
+//	    -X github.com/AlexKira/brgnetuse/internal/version.Commit=$(git rev-parse HEAD) \
+//	    -X github.com/AlexKira/brgnetuse/internal/version.BuildDate=$(date -u +%FT%TZ)"
+//
+// Empty values are filled from runtime/debug.ReadBuildInfo, falling back to "devel".
+var (
+	Version   string
+	Commit    string
+	BuildDate string
+)
+
+const develValue string = "devel"
+
+// Info describes the version of the utilities and the build they come from.
+type Info struct {
+	Version   string
+	Commit    string
+	BuildDate string
+	GoVersion string
+}
+
+// Function returns the build information of the running binary.
+// Link-time values take precedence over the module build info, missing
+// values are reported as "devel".
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.GoVersion = bi.GoVersion
+
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			}
+		}
+	}
+
+	for _, field := range []*string{&info.Version, &info.Commit, &info.BuildDate, &info.GoVersion} {
+		if *field == "" {
+			*field = develValue
+		}
+	}
+
+	return info
+}
+
+// Function returns the version of the utilities (e.g., "1.2.0", "devel").
+func String() string {
+	return Get().Version
+}
@@ -0,0 +1,27 @@
+package version
+
+import "testing"
+
+// Testing that link-time values take precedence and missing ones are filled.
+func TestGet(t *testing.T) {
+	prevVersion, prevCommit, prevDate := Version, Commit, BuildDate
+	t.Cleanup(func() { Version, Commit, BuildDate = prevVersion, prevCommit, prevDate })
+
+	Version, Commit, BuildDate = "1.2.0", "4f2c1e0", "2025-06-01T10:00:00Z"
+	info := Get()
+	if info.Version != "1.2.0" || info.Commit != "4f2c1e0" || info.BuildDate != "2025-06-01T10:00:00Z" {
+		t.Errorf("error: got %+v, want the link-time values", info)
+	}
+	if String() != "1.2.0" {
+		t.Errorf("error: got version %q, want \"1.2.0\"", String())
+	}
+
+	Version, Commit, BuildDate = "", "", ""
+	for name, value := range map[string]string{
+		"version": Get().Version, "commit": Get().Commit, "build date": Get().BuildDate, "go": Get().GoVersion,
+	} {
+		if value == "" {
+			t.Errorf("error: got an empty %s, want a value or %q", name, develValue)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Package provides an in-memory replacement of the wgctrl client for tests.
+//
+// The mock applies wgtypes.Config values with the same semantics as the
+// kernel and wireguard-go implementations, so code under test can configure
+// a device and read it back without root privileges or a real interface.
+package wgmock
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Client is an in-memory implementation of handlers.WgClient.
+type Client struct {
+	mu      sync.Mutex
+	devices map[string]*wgtypes.Device
+	order   []string
+
+	// Calls records every successful ConfigureDevice call in order.
+	Calls []Call
+
+	// OpenErr, when set, is returned when the client is opened.
+	OpenErr error
+
+	// DeviceErr, when set, is returned by Device and Devices.
+	DeviceErr error
+
+	// ConfigureErr, when set, is returned by ConfigureDevice.
+	ConfigureErr error
+
+	// Ignore, when set, makes ConfigureDevice report success without
+	// applying the configuration (a device that silently drops changes).
+	Ignore bool
+
+	// Filter, when set, rewrites every configuration before it is applied
+	// (a device that silently drops part of it, e.g., an allowed IP).
+	// Calls records the configuration as given.
+	Filter func(cfg wgtypes.Config) wgtypes.Config
+
+	// Opens and Closes count how many times the installed client was
+	// opened and closed.
+	Opens  int
+	Closes int
+}
+
+// Call is a single recorded ConfigureDevice invocation.
+type Call struct {
+	Name   string
+	Config wgtypes.Config
+}
+
+// Function creates a mock client serving the given devices.
+func New(devices ...*wgtypes.Device) *Client {
+	m := &Client{devices: make(map[string]*wgtypes.Device)}
+	for _, d := range devices {
+		m.Add(d)
+	}
+	return m
+}
+
+// Function creates a mock client serving the given devices and installs
+// it as handlers.NewWgClient for the duration of the test.
+func Install(t testing.TB, devices ...*wgtypes.Device) *Client {
+	t.Helper()
+
+	m := New(devices...)
+	prev := handlers.NewWgClient
+	handlers.NewWgClient = func() (handlers.WgClient, error) {
+		if m.OpenErr != nil {
+			return nil, m.OpenErr
+		}
+		m.mu.Lock()
+		m.Opens++
+		m.mu.Unlock()
+		return m, nil
+	}
+	t.Cleanup(func() { handlers.NewWgClient = prev })
+
+	return m
+}
+
+// Method adds (or replaces) a device served by the mock.
+func (m *Client) Add(d *wgtypes.Device) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.devices[d.Name]; !ok {
+		m.order = append(m.order, d.Name)
+	}
+	m.devices[d.Name] = copyDevice(d)
+}
+
+// Method returns all devices in insertion order.
+func (m *Client) Devices() ([]*wgtypes.Device, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.DeviceErr != nil {
+		return nil, m.DeviceErr
+	}
+
+	devices := make([]*wgtypes.Device, 0, len(m.order))
+	for _, name := range m.order {
+		devices = append(devices, copyDevice(m.devices[name]))
+	}
+	return devices, nil
+}
+
+// Method returns a copy of the named device.
+func (m *Client) Device(name string) (*wgtypes.Device, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.DeviceErr != nil {
+		return nil, m.DeviceErr
+	}
+
+	d, ok := m.devices[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return copyDevice(d), nil
+}
+
+// Method applies the configuration to the named device.
+func (m *Client) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ConfigureErr != nil {
+		return m.ConfigureErr
+	}
+
+	d, ok := m.devices[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	m.Calls = append(m.Calls, Call{Name: name, Config: cfg})
+	if m.Ignore {
+		return nil
+	}
+	if m.Filter != nil {
+		cfg = m.Filter(cfg)
+	}
+
+	if cfg.PrivateKey != nil {
+		d.PrivateKey = *cfg.PrivateKey
+		d.PublicKey = cfg.PrivateKey.PublicKey()
+	}
+	if cfg.ListenPort != nil {
+		d.ListenPort = *cfg.ListenPort
+	}
+	if cfg.FirewallMark != nil {
+		d.FirewallMark = *cfg.FirewallMark
+	}
+	if cfg.ReplacePeers {
+		d.Peers = nil
+	}
+
+	owners := make(map[string]wgtypes.Key)
+	for _, p := range d.Peers {
+		for _, aip := range p.AllowedIPs {
+			owners[aip.String()] = p.PublicKey
+		}
+	}
+
+	for _, pc := range cfg.Peers {
+		if err := applyPeer(d, pc, owners); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Method counts the call; the mock stays usable after being closed.
+func (m *Client) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Closes++
+	return nil
+}
+
+// Function applies a single peer configuration to the device. The owners
+// map the allowed IPs of the device to their peer, kept up to date.
+func applyPeer(d *wgtypes.Device, pc wgtypes.PeerConfig, owners map[string]wgtypes.Key) error {
+	indx := slices.IndexFunc(d.Peers, func(p wgtypes.Peer) bool {
+		return p.PublicKey == pc.PublicKey
+	})
+
+	if pc.Remove {
+		if indx >= 0 {
+			for _, aip := range d.Peers[indx].AllowedIPs {
+				delete(owners, aip.String())
+			}
+			d.Peers = slices.Delete(d.Peers, indx, indx+1)
+		}
+		return nil
+	}
+
+	if indx < 0 {
+		if pc.UpdateOnly {
+			return nil
+		}
+		d.Peers = append(d.Peers, wgtypes.Peer{PublicKey: pc.PublicKey})
+		indx = len(d.Peers) - 1
+	}
+
+	peer := &d.Peers[indx]
+
+	if pc.PresharedKey != nil {
+		peer.PresharedKey = *pc.PresharedKey
+	}
+	if pc.Endpoint != nil {
+		endpoint := *pc.Endpoint
+		peer.Endpoint = &endpoint
+	}
+	if pc.PersistentKeepaliveInterval != nil {
+		if *pc.PersistentKeepaliveInterval < 0 {
+			return fmt.Errorf("invalid persistent keepalive interval")
+		}
+		peer.PersistentKeepaliveInterval = *pc.PersistentKeepaliveInterval
+	}
+	if pc.ReplaceAllowedIPs {
+		for _, aip := range peer.AllowedIPs {
+			delete(owners, aip.String())
+		}
+		peer.AllowedIPs = nil
+	}
+
+	for _, aip := range pc.AllowedIPs {
+		network := aip.String()
+		owner, owned := owners[network]
+		if owned && owner == pc.PublicKey {
+			continue
+		}
+
+		// An allowed IP belongs to a single peer: claiming it moves it
+		// away from any other peer, as WireGuard does.
+		if owned {
+			other := slices.IndexFunc(d.Peers, func(p wgtypes.Peer) bool {
+				return p.PublicKey == owner
+			})
+			if other >= 0 {
+				d.Peers[other].AllowedIPs = slices.DeleteFunc(d.Peers[other].AllowedIPs, func(n net.IPNet) bool {
+					return n.String() == network
+				})
+			}
+		}
+		peer.AllowedIPs = append(peer.AllowedIPs, aip)
+		owners[network] = pc.PublicKey
+	}
+
+	return nil
+}
+
+// Function returns a deep copy of the device so callers cannot mutate
+// the mock state through returned values.
+func copyDevice(d *wgtypes.Device) *wgtypes.Device {
+	c := *d
+	c.Peers = make([]wgtypes.Peer, len(d.Peers))
+	for i, p := range d.Peers {
+		c.Peers[i] = p
+		c.Peers[i].AllowedIPs = slices.Clone(p.AllowedIPs)
+		if p.Endpoint != nil {
+			endpoint := *p.Endpoint
+			c.Peers[i].Endpoint = &endpoint
+		}
+	}
+	return &c
+}
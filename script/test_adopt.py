@@ -0,0 +1,111 @@
+import sys
+import subprocess
+
+# Integration test of `brgsetwg -i <iface> -adopt`, run as root inside a
+# throwaway network namespace with the wg tool installed:
+#
+#   sudo python3 script/test_adopt.py
+
+NETNS: str = "brgadopt"
+IFACE: str = "wg9"
+UPLINK: str = "dum9"
+PORT: int = 51820
+SUBNET: str = "10.99.0.0/24"
+
+# The rules of a wg-quick PostUp, in their chain order. The first FORWARD
+# rule is not in the form of a rule of brgsetwg, it is left as it is.
+FOREIGN_RULE: str = f"-A FORWARD -i {IFACE} -j ACCEPT"
+POSTUP: list[str] = [
+    f"iptables -A INPUT -p udp --dport {PORT} -j ACCEPT",
+    f"iptables {FOREIGN_RULE}",
+    f"iptables -A FORWARD -i {UPLINK} -o {IFACE} -j ACCEPT",
+    f"iptables -A FORWARD -i {IFACE} -o {UPLINK} -j ACCEPT",
+    f"iptables -t nat -A POSTROUTING -s {SUBNET} -o {UPLINK} -j MASQUERADE",
+]
+
+# The rules once adopted, at the positions of the untagged ones.
+ADOPTED_FORWARD: list[str] = [
+    FOREIGN_RULE,
+    f'-A FORWARD -i {UPLINK} -o {IFACE} -m comment --comment "brgnetuse:{IFACE}" -j ACCEPT',
+    f'-A FORWARD -i {IFACE} -o {UPLINK} -m comment --comment "brgnetuse:{IFACE}" -j ACCEPT',
+]
+
+
+def run_command(cmd: str, check: bool = True) -> subprocess.CompletedProcess:
+    reply = subprocess.run(
+        f"ip netns exec {NETNS} {cmd}",
+        shell=True, capture_output=True, text=True,
+    )
+    if check and reply.returncode != 0:
+        raise RuntimeError(f"{cmd}: {reply.stdout.strip()} {reply.stderr.strip()}")
+
+    print(f"ok: {cmd}")
+    return reply
+
+
+def expect_output(cmd: str, message: str) -> None:
+    reply = run_command(cmd)
+    if message not in reply.stdout:
+        raise RuntimeError(f"{cmd}: expected '{message}', got {reply.stdout.strip()}")
+
+
+def chain_rules(chain: str, table: str = "filter") -> list[str]:
+    reply = run_command(f"iptables -t {table} -S {chain}")
+    return [line for line in reply.stdout.splitlines() if line.startswith("-A ")]
+
+
+def main() -> None:
+
+    subprocess.run(f"ip netns add {NETNS}", shell=True, check=True)
+
+    try:
+        # A network interface brought up like wg-quick does.
+        run_command(f"ip link add {UPLINK} type dummy")
+        run_command(f"ip link set {UPLINK} up")
+        run_command(f"ip link add {IFACE} type wireguard")
+        run_command(f"sh -c 'wg genkey > /tmp/{NETNS}.key'")
+        run_command(f"wg set {IFACE} listen-port {PORT} private-key /tmp/{NETNS}.key")
+        run_command(f"ip address add 10.99.0.1/24 dev {IFACE}")
+        run_command(f"ip link set {IFACE} up")
+        for cmd in POSTUP:
+            run_command(cmd)
+
+        # The dry run changes nothing.
+        before = chain_rules("FORWARD")
+        expect_output(f"brgsetwg -i {IFACE} -adopt -dry", "4 rules to tag (dry run)")
+        if chain_rules("FORWARD") != before:
+            raise RuntimeError("the dry run changed the FORWARD chain")
+
+        expect_output(f"brgsetwg -i {IFACE} -adopt", "4 rules tagged, 1 rule left to confirm")
+
+        # Tagged in place, the foreign rule untouched.
+        if chain_rules("FORWARD") != ADOPTED_FORWARD:
+            raise RuntimeError(f"unexpected FORWARD chain {chain_rules('FORWARD')}")
+        nat = chain_rules("POSTROUTING", "nat")
+        if len(nat) != 1 or f'--comment "brgnetuse:{IFACE}"' not in nat[0]:
+            raise RuntimeError(f"unexpected POSTROUTING chain {nat}")
+        port = [rule for rule in chain_rules("INPUT") if f"--dport {PORT}" in rule]
+        if len(port) != 1 or '--comment "brgnetuse"' not in port[0]:
+            raise RuntimeError(f"unexpected INPUT chain {port}")
+
+        # Adopting again finds the rules managed.
+        expect_output(f"brgsetwg -i {IFACE} -adopt", "0 rules tagged")
+
+        # The purge removes the adopted rules, never the foreign one.
+        run_command(f"brgsetwg -i {IFACE} -purge")
+        if chain_rules("FORWARD") != [FOREIGN_RULE]:
+            raise RuntimeError(f"unexpected FORWARD chain after purge {chain_rules('FORWARD')}")
+
+        print("ok: interface adopted")
+
+    except Exception as err:
+        print(f"error: {err}")
+        sys.exit(1)
+
+    finally:
+        subprocess.run(f"rm -f /tmp/{NETNS}.key", shell=True)
+        subprocess.run(f"ip netns delete {NETNS}", shell=True)
+
+
+if __name__ == "__main__":
+    main()
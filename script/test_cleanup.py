@@ -0,0 +1,87 @@
+import sys
+import time
+import subprocess
+
+# Integration test of `brgaddwg -cleanup`, run as root inside a throwaway
+# network namespace so the host firewall is not touched:
+#
+#   sudo python3 script/test_cleanup.py
+
+NETNS: str = "brgcleanup"
+IFACE: str = "wg9"
+OUT_IFACE: str = "out9"
+SUBNET: str = "10.10.9.0/24"
+
+
+def run_command(cmd: str, check: bool = True) -> str:
+    reply = subprocess.run(
+        f"ip netns exec {NETNS} {cmd}",
+        shell=True, capture_output=True, text=True,
+    )
+    if check and reply.returncode != 0:
+        raise RuntimeError(f"{cmd}: {reply.stderr.strip()}")
+
+    print(f"ok: {cmd}")
+    return reply.stdout
+
+
+def wait_link(present: bool) -> None:
+    for _ in range(50):
+        reply = subprocess.run(
+            f"ip netns exec {NETNS} ip link show {IFACE}",
+            shell=True, capture_output=True,
+        )
+        if (reply.returncode == 0) == present:
+            return
+        time.sleep(0.1)
+
+    raise RuntimeError(f"{IFACE}: link present is not {present}")
+
+
+def rules() -> str:
+    return run_command("iptables -S FORWARD") + \
+        run_command("iptables -t nat -S POSTROUTING")
+
+
+def main() -> None:
+
+    subprocess.run(f"ip netns add {NETNS}", shell=True, check=True)
+
+    try:
+        run_command(f"ip link add {OUT_IFACE} type dummy")
+        run_command(f"ip link set {OUT_IFACE} up")
+
+        run_command(f"brgaddwg -i {IFACE} -cleanup")
+        wait_link(True)
+
+        run_command(f"brgsetwg -i {IFACE} -ip 10.10.9.254/24 -a")
+        run_command(f"brgsetwg -i {IFACE} -ip {SUBNET} -a -n {OUT_IFACE}")
+
+        if IFACE not in rules():
+            raise RuntimeError("rules were not applied")
+
+        run_command(f"pkill -TERM -f 'brgaddwg -i {IFACE} -cleanup'")
+        wait_link(False)
+
+        # The rules are removed after device.Close(), give it a moment.
+        time.sleep(1)
+
+        leftover = [
+            line for line in rules().splitlines()
+            if IFACE in line or SUBNET in line
+        ]
+        if leftover:
+            raise RuntimeError(f"rules left after shutdown: {leftover}")
+
+        print("ok: iptables tables are clean")
+
+    except Exception as err:
+        print(f"error: {err}")
+        sys.exit(1)
+
+    finally:
+        subprocess.run(f"ip netns delete {NETNS}", shell=True)
+
+
+if __name__ == "__main__":
+    main()
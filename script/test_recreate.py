@@ -0,0 +1,81 @@
+import sys
+import time
+import subprocess
+
+# Integration test of `brgaddwg -force`, run as root inside a throwaway
+# network namespace:
+#
+#   sudo python3 script/test_recreate.py
+
+NETNS: str = "brgrecreate"
+IFACE: str = "wg8"
+FOREIGN: str = "dum8"
+
+
+def run_command(cmd: str, check: bool = True) -> subprocess.CompletedProcess:
+    reply = subprocess.run(
+        f"ip netns exec {NETNS} {cmd}",
+        shell=True, capture_output=True, text=True,
+    )
+    if check and reply.returncode != 0:
+        raise RuntimeError(f"{cmd}: {reply.stdout.strip()} {reply.stderr.strip()}")
+
+    print(f"ok: {cmd}")
+    return reply
+
+
+def expect_failure(cmd: str, message: str) -> None:
+    reply = run_command(cmd, check=False)
+    if reply.returncode == 0 or message not in reply.stdout:
+        raise RuntimeError(f"{cmd}: expected failure with '{message}', got {reply.stdout.strip()}")
+
+
+def wait_process(present: bool) -> int:
+    for _ in range(50):
+        reply = subprocess.run(
+            f"pgrep -f 'brgaddwg -i {IFACE}'",
+            shell=True, capture_output=True, text=True,
+        )
+        pids = reply.stdout.split()
+        if bool(pids) == present:
+            return int(pids[0]) if pids else 0
+        time.sleep(0.1)
+
+    raise RuntimeError(f"{IFACE}: process present is not {present}")
+
+
+def main() -> None:
+
+    subprocess.run(f"ip netns add {NETNS}", shell=True, check=True)
+
+    try:
+        run_command(f"brgaddwg -i {IFACE}")
+        old_pid = wait_process(True)
+
+        # Without -force the existing interface is refused, naming its type.
+        expect_failure(f"brgaddwg -i {IFACE}", "userspace WireGuard device")
+
+        run_command(f"brgaddwg -i {IFACE} -force")
+        time.sleep(1)
+        new_pid = wait_process(True)
+        if new_pid == old_pid:
+            raise RuntimeError(f"process {old_pid} was not replaced")
+        run_command(f"ip link show {IFACE}")
+
+        # A foreign interface is refused even with -force.
+        run_command(f"ip link add {FOREIGN} type dummy")
+        expect_failure(f"brgaddwg -i {FOREIGN} -force", "not managed by brgnetuse")
+
+        print("ok: interface recreated")
+
+    except Exception as err:
+        print(f"error: {err}")
+        sys.exit(1)
+
+    finally:
+        subprocess.run(f"pkill -TERM -f 'brgaddwg -i {IFACE}'", shell=True)
+        subprocess.run(f"ip netns delete {NETNS}", shell=True)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,123 @@
+//go:build !windows
+
+package add
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Testing the validation of the options.
+func TestStartOptions(t *testing.T) {
+	type testCase struct {
+		name      string
+		opts      Options
+		wantError string
+	}
+
+	tests := []testCase{
+		{name: "missing name", opts: Options{}, wantError: "network interface name is missing"},
+		{name: "invalid name", opts: Options{InterfaceName: "wg/0"}, wantError: "invalid character '/'"},
+		{name: "long name", opts: Options{InterfaceName: "wireguard-office0"}, wantError: "the maximum is 15"},
+		{name: "mtu too small", opts: Options{InterfaceName: "wg0", MTU: 1279}, wantError: "out of valid range (1280-9000)"},
+		{name: "mtu too large", opts: Options{InterfaceName: "wg0", MTU: 9001}, wantError: "out of valid range (1280-9000)"},
+		{
+			name:      "forced mtu too large",
+			opts:      Options{InterfaceName: "wg0", MTU: 65536, ForceMTU: true},
+			wantError: "out of valid range (68-65535)",
+		},
+		{name: "negative port", opts: Options{InterfaceName: "wg0", ListenPort: -1}, wantError: "out of valid range (0-65535)"},
+		{name: "port too large", opts: Options{InterfaceName: "wg0", ListenPort: 65536}, wantError: "out of valid range (0-65535)"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dev, err := Start(tc.opts)
+			if err == nil {
+				dev.Stop()
+				t.Fatalf("error: expected an error containing %q", tc.wantError)
+			}
+			if !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("error: got %v, want an error containing %q", err, tc.wantError)
+			}
+		})
+	}
+}
+
+// Testing the lifecycle of a device on a test TUN: it is configured with the
+// options, and Stop closes it once.
+func TestStartStop(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dev, err := Start(Options{
+		InterfaceName: "brgtest0",
+		MTU:           1000,
+		ForceMTU:      true,
+		PrivateKey:    &key,
+		TUN:           tuntest.NewChannelTUN().TUN(),
+		NoUAPI:        true,
+	})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if dev.Name != "brgtest0" {
+		t.Errorf("error: got name %q, want %q", dev.Name, "brgtest0")
+	}
+
+	config, err := dev.IpcGet()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !strings.Contains(config, "private_key="+hex.EncodeToString(key[:])) {
+		t.Error("error: the private key of the options is not set")
+	}
+
+	if err := dev.IpcSet("listen_port=0\n"); err != nil {
+		t.Errorf("error: unexpected error: %v", err)
+	}
+
+	if err := dev.Stop(); err != nil {
+		t.Errorf("error: unexpected error: %v", err)
+	}
+	select {
+	case <-dev.Wait():
+	case <-time.After(5 * time.Second):
+		t.Fatal("error: Wait is not closed after Stop")
+	}
+
+	// A second Stop returns at once.
+	if err := dev.Stop(); err != nil {
+		t.Errorf("error: unexpected error: %v", err)
+	}
+}
+
+// Testing that a device closed on its own is stopped.
+func TestStopOnClose(t *testing.T) {
+	dev, err := Start(Options{
+		TUN:    tuntest.NewChannelTUN().TUN(),
+		NoUAPI: true,
+	})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if dev.Name == "" {
+		t.Error("error: the name of the test TUN is not used")
+	}
+
+	dev.device.Close()
+
+	select {
+	case <-dev.Wait():
+	case <-time.After(5 * time.Second):
+		t.Fatal("error: Wait is not closed after the device closed")
+	}
+}
@@ -0,0 +1,453 @@
+//go:build !windows
+
+// Package awg starts userspace AmneziaWG devices (amneziawg-go) from a host
+// program, the way brgaddawg does. It mirrors the add package: the device
+// serves its UAPI socket, so it can be configured with brgsetwg, and it runs
+// until the host stops it.
+package awg
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/middleware"
+	"github.com/AlexKira/brgnetuse/internal/version"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/validate"
+	"github.com/amnezia-vpn/amneziawg-go/conn"
+	"github.com/amnezia-vpn/amneziawg-go/device"
+	"github.com/amnezia-vpn/amneziawg-go/ipc"
+	"github.com/amnezia-vpn/amneziawg-go/tun"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Sizes of the handshake messages and the largest UDP datagram, the junk
+// added to a handshake message must keep it below the datagram size.
+const (
+	initiationSize int = device.MessageInitiationSize
+	responseSize   int = device.MessageResponseSize
+	maxSegmentSize int = device.MaxSegmentSize
+)
+
+// Obfuscation holds the AmneziaWG obfuscation parameters of a device, the
+// zero value disables the obfuscation. Both ends of a tunnel must use the
+// same parameters.
+type Obfuscation struct {
+	// Jc is the number of junk packets sent before a handshake.
+	Jc int
+
+	// Jmin and Jmax are the size range of the junk packets.
+	Jmin int
+	Jmax int
+
+	// S1 and S2 are the junk sizes added to the handshake initiation and
+	// response messages.
+	S1 int
+	S2 int
+
+	// H1, H2, H3 and H4 are the message types of the initiation, response,
+	// cookie reply and transport messages, the default types when 0.
+	H1 uint32
+	H2 uint32
+	H3 uint32
+	H4 uint32
+}
+
+// Options configures a device started by Start.
+type Options struct {
+	// InterfaceName is the network interface name (e.g., "awg0").
+	InterfaceName string
+
+	// MTU of the TUN device, device.DefaultMTU when 0. It must be within
+	// validate.MinMTU and MaxMTU, or MinForcedMTU and MaxForcedMTU with
+	// ForceMTU.
+	MTU int
+
+	// ForceMTU accepts an MTU outside the default range (e.g., for exotic
+	// links).
+	ForceMTU bool
+
+	// PrivateKey of the device, a generated one when nil.
+	PrivateKey *wgtypes.Key
+
+	// ListenPort is the UDP port of the device, a random one when 0.
+	ListenPort int
+
+	// Obfuscation parameters of the device.
+	Obfuscation Obfuscation
+
+	// Alias is the network interface alias shown by monitoring tools.
+	Alias string
+
+	// Cleanup removes the rules and addresses recorded by brgsetwg on Stop.
+	Cleanup bool
+
+	// Logger of the device, silent when nil.
+	Logger *device.Logger
+
+	// Records receives the structured records of the device (e.g., the
+	// startup record), discarded when nil (see middleware.WgJsonRecordLogger).
+	Records *slog.Logger
+
+	// TUN is a TUN device created by the host program (e.g., a test TUN),
+	// the TUN device is created from InterfaceName when nil.
+	TUN tun.Device
+
+	// NoUAPI disables the UAPI socket, the device is then configured only
+	// through Device.IpcSet.
+	NoUAPI bool
+}
+
+// Device is a running userspace AmneziaWG device.
+type Device struct {
+	// Name is the network interface name of the device.
+	Name string
+
+	opts   Options
+	device *device.Device
+	uapi   net.Listener
+	keys   *middleware.KeyLogger
+
+	stopOnce sync.Once
+	stopErr  error
+	done     chan struct{}
+}
+
+// Method checks the obfuscation parameters against the limits of
+// amneziawg-go, which otherwise rejects them only once the device runs.
+func (o Obfuscation) Validate() error {
+	switch {
+	case o.Jc < 0:
+		return help.Errorf(help.CodeInvalidValue, "error: junk packet count (jc) %d must not be negative", o.Jc)
+	case o.Jmin < 0:
+		return help.Errorf(help.CodeInvalidValue, "error: junk packet minimum size (jmin) %d must not be negative", o.Jmin)
+	case o.Jmax < o.Jmin:
+		return help.Errorf(
+			help.CodeInvalidValue,
+			"error: junk packet maximum size (jmax) %d is smaller than the minimum size (jmin) %d",
+			o.Jmax,
+			o.Jmin,
+		)
+	case o.Jmax >= maxSegmentSize:
+		return help.Errorf(
+			help.CodeInvalidValue,
+			"error: junk packet maximum size (jmax) %d must be smaller than %d",
+			o.Jmax,
+			maxSegmentSize,
+		)
+	case o.S1 < 0 || initiationSize+o.S1 >= maxSegmentSize:
+		return help.Errorf(
+			help.CodeInvalidValue,
+			"error: init packet junk size (s1) %d is out of valid range (0-%d)",
+			o.S1,
+			maxSegmentSize-initiationSize-1,
+		)
+	case o.S2 < 0 || responseSize+o.S2 >= maxSegmentSize:
+		return help.Errorf(
+			help.CodeInvalidValue,
+			"error: response packet junk size (s2) %d is out of valid range (0-%d)",
+			o.S2,
+			maxSegmentSize-responseSize-1,
+		)
+	case initiationSize+o.S1 == responseSize+o.S2:
+		return help.Errorf(
+			help.CodeInvalidValue,
+			"error: init and response packets have the same size with s1 %d and s2 %d",
+			o.S1,
+			o.S2,
+		)
+	}
+
+	// Headers up to 4 select the default message types (1 to 4).
+	headers := []uint32{o.H1, o.H2, o.H3, o.H4}
+	seen := make(map[uint32]bool, len(headers))
+	for i, header := range headers {
+		if header <= 4 {
+			header = uint32(i + 1)
+		}
+		if seen[header] {
+			return help.Errorf(
+				help.CodeInvalidValue,
+				"error: magic headers must differ, got h1 %d, h2 %d, h3 %d, h4 %d",
+				o.H1,
+				o.H2,
+				o.H3,
+				o.H4,
+			)
+		}
+		seen[header] = true
+	}
+	return nil
+}
+
+// Method returns the UAPI configuration of the obfuscation parameters.
+func (o Obfuscation) uapi() string {
+	return fmt.Sprintf(
+		"jc=%d\njmin=%d\njmax=%d\ns1=%d\ns2=%d\nh1=%d\nh2=%d\nh3=%d\nh4=%d\n",
+		o.Jc, o.Jmin, o.Jmax, o.S1, o.S2, o.H1, o.H2, o.H3, o.H4,
+	)
+}
+
+// Method checks the options, filling the defaults.
+func (o *Options) check() error {
+	if o.InterfaceName == "" && o.TUN == nil {
+		return help.Errorf(help.CodeInvalidArguments, "error: network interface name is missing")
+	}
+	if o.InterfaceName != "" {
+		if err := validate.CheckInterfaceName(o.InterfaceName); err != nil {
+			return err
+		}
+	}
+	if o.MTU == 0 {
+		o.MTU = device.DefaultMTU
+	}
+	if err := validate.CheckMTU(o.MTU, o.ForceMTU); err != nil {
+		return err
+	}
+	if o.ListenPort < 0 || o.ListenPort > 65535 {
+		return help.Errorf(help.CodeInvalidValue, "error: listen port %d is out of valid range (0-65535)", o.ListenPort)
+	}
+	if err := o.Obfuscation.Validate(); err != nil {
+		return err
+	}
+	if o.Logger == nil {
+		o.Logger = device.NewLogger(device.LogLevelSilent, "")
+	}
+	if o.Records == nil {
+		o.Records = slog.New(slog.DiscardHandler)
+	}
+	return nil
+}
+
+// Function starts a device with the options and returns once it serves its
+// UAPI socket. The device runs until Stop is called or it is closed (e.g.,
+// its UAPI socket fails).
+func Start(opts Options) (*Device, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+	logger := opts.Logger
+
+	// A killed process leaves its UAPI socket behind, remove it, but fail
+	// fast if another process still serves the interface.
+	if !opts.NoUAPI {
+		removed, err := handlers.RemoveStaleSocket(handlers.AwgSocketDir, opts.InterfaceName)
+		if err != nil {
+			return nil, err
+		}
+		if removed {
+			logger.Verbosef(
+				"Removed stale UAPI socket %s",
+				handlers.SocketPath(handlers.AwgSocketDir, opts.InterfaceName),
+			)
+		}
+	}
+
+	tdev := opts.TUN
+	if tdev == nil {
+		var err error
+		tdev, err = tun.CreateTUN(opts.InterfaceName, opts.MTU)
+		if err != nil {
+			return nil, help.Errorf(help.CodeDeviceFailed, "failed to create TUN device: %v", err)
+		}
+	}
+	if name, err := tdev.Name(); err == nil && (opts.TUN == nil || opts.InterfaceName == "") {
+		opts.InterfaceName = name
+	}
+
+	// The alias is descriptive only, a failure does not stop the device.
+	if opts.Alias != "" {
+		if err := set.SetInterfaceAlias(opts.InterfaceName, opts.Alias); err != nil {
+			logger.Errorf("Alias: %v", err)
+		}
+	}
+
+	var fileUAPI *os.File
+	if !opts.NoUAPI {
+		var err error
+		fileUAPI, err = ipc.UAPIOpen(opts.InterfaceName)
+		if err != nil {
+			tdev.Close()
+			return nil, help.Errorf(help.CodeDeviceFailed, "uAPI listen error: %v", err)
+		}
+	}
+
+	// Device started, the interface name and pid are fields of every record.
+	opts.Records.Info(
+		"device starting",
+		slog.String("version", version.String()),
+		slog.Int("mtu", opts.MTU),
+		slog.String("backend", help.Env_Awg_Type),
+		slog.String("uapi_socket", uapiSocket(opts)),
+	)
+
+	d := &Device{
+		Name:   opts.InterfaceName,
+		opts:   opts,
+		device: device.NewDevice(tdev, conn.NewStdNetBind(), logger),
+		done:   make(chan struct{}),
+	}
+	d.keys = middleware.NewKeyLogger(opts.Records, d.device.IpcGetOperation)
+
+	if err := d.configure(); err != nil {
+		d.device.Close()
+		if fileUAPI != nil {
+			fileUAPI.Close()
+		}
+		return nil, err
+	}
+	d.device.Up()
+
+	if fileUAPI != nil {
+		uapi, err := ipc.UAPIListen(opts.InterfaceName, fileUAPI)
+		if err != nil {
+			d.device.Close()
+			return nil, help.Errorf(help.CodeDeviceFailed, "failed to listen on uapi socket: %v", err)
+		}
+		d.uapi = uapi
+		go d.serveUAPI()
+		logger.Verbosef("UAPI listener started")
+	}
+
+	go func() {
+		<-d.device.Wait()
+		d.Stop()
+	}()
+
+	return d, nil
+}
+
+// Function returns the UAPI socket path of the device, "none" without one.
+func uapiSocket(opts Options) string {
+	if opts.NoUAPI {
+		return "none"
+	}
+	return handlers.SocketPath(handlers.AwgSocketDir, opts.InterfaceName)
+}
+
+// Method sets the private key, the listen port and the obfuscation
+// parameters of the options on the device. The key is written from a byte
+// buffer that is scrubbed afterwards, and errors are redacted.
+func (d *Device) configure() error {
+	key := wgtypes.Key{}
+	defer clear(key[:])
+
+	if d.opts.PrivateKey != nil {
+		key = *d.opts.PrivateKey
+	} else {
+		generated, err := get.GenerateKeyPair()
+		if err != nil {
+			return err
+		}
+		key = generated.Private
+		generated.Zero()
+	}
+
+	uapi := hex.AppendEncode([]byte("private_key="), key[:])
+	defer func() { clear(uapi) }()
+	uapi = append(uapi, '\n')
+
+	if d.opts.ListenPort != 0 {
+		uapi = append(uapi, "listen_port="+strconv.Itoa(d.opts.ListenPort)+"\n"...)
+	}
+	if d.opts.Obfuscation != (Obfuscation{}) {
+		uapi = append(uapi, d.opts.Obfuscation.uapi()...)
+	}
+
+	if err := d.device.IpcSetOperation(bytes.NewReader(uapi)); err != nil {
+		return help.Errorf(
+			help.CodeDeviceFailed,
+			"error: failed to configure device '%s': %s",
+			d.Name,
+			handlers.Redact(err.Error()),
+		)
+	}
+	d.keys.Check()
+	return nil
+}
+
+// Method serves the UAPI socket until it is closed, the device is closed
+// when the socket fails.
+func (d *Device) serveUAPI() {
+	for {
+		conn, err := d.uapi.Accept()
+		if err != nil {
+			d.device.Close()
+			return
+		}
+		go func() {
+			d.device.IpcHandle(conn)
+			d.keys.Check()
+		}()
+	}
+}
+
+// Method applies a UAPI configuration (e.g., "jc=4\n") to the device, like
+// a client of its UAPI socket.
+func (d *Device) IpcSet(config string) error {
+	err := d.device.IpcSet(config)
+	d.keys.Check()
+	return err
+}
+
+// Method returns the UAPI configuration of the device.
+func (d *Device) IpcGet() (string, error) {
+	return d.device.IpcGet()
+}
+
+// Method returns a channel closed once the device stopped.
+func (d *Device) Wait() <-chan struct{} {
+	return d.done
+}
+
+// Method stops the device: the UAPI socket and the device are closed, and
+// with Options.Cleanup the recorded rules and addresses are removed.
+// The cleanup errors are returned, the following calls return the same.
+func (d *Device) Stop() error {
+	d.stopOnce.Do(func() {
+		if d.uapi != nil {
+			d.uapi.Close()
+		}
+		d.device.Close()
+
+		if d.opts.Cleanup {
+			d.stopErr = errors.Join(set.CleanupInterface(d.Name)...)
+		}
+		close(d.done)
+	})
+
+	<-d.done
+	return d.stopErr
+}
+
+// Function starts a device with the options and runs it until SIGTERM or
+// an interrupt is received, or the device is closed, then stops it.
+func NewDevice(opts Options) error {
+	d, err := Start(opts)
+	if err != nil {
+		return err
+	}
+
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, unix.SIGTERM, os.Interrupt)
+	defer signal.Stop(term)
+
+	select {
+	case <-term:
+	case <-d.Wait():
+	}
+
+	return d.Stop()
+}
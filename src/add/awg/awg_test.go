@@ -0,0 +1,120 @@
+//go:build !windows
+
+package awg
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-go/tun/tuntest"
+)
+
+// Testing the validation of the obfuscation parameters.
+func TestObfuscationValidate(t *testing.T) {
+	type testCase struct {
+		name      string
+		params    Obfuscation
+		wantError string
+	}
+
+	tests := []testCase{
+		{name: "disabled", params: Obfuscation{}},
+		{
+			name:   "valid",
+			params: Obfuscation{Jc: 4, Jmin: 40, Jmax: 70, S1: 15, S2: 25, H1: 5, H2: 6, H3: 7, H4: 8},
+		},
+		{name: "equal junk sizes", params: Obfuscation{Jc: 3, Jmin: 50, Jmax: 50}},
+		{name: "default headers", params: Obfuscation{H1: 1, H2: 2, H3: 3, H4: 4}},
+		{name: "negative jc", params: Obfuscation{Jc: -1}, wantError: "(jc)"},
+		{name: "negative jmin", params: Obfuscation{Jmin: -1}, wantError: "(jmin)"},
+		{name: "jmax below jmin", params: Obfuscation{Jmin: 100, Jmax: 50}, wantError: "smaller than the minimum size"},
+		{name: "jmax too large", params: Obfuscation{Jmax: 65535}, wantError: "must be smaller than 65535"},
+		{name: "negative s1", params: Obfuscation{S1: -1}, wantError: "(s1)"},
+		{name: "s1 too large", params: Obfuscation{S1: 65387}, wantError: "(s1) 65387 is out of valid range (0-65386)"},
+		{name: "s2 too large", params: Obfuscation{S2: 65443}, wantError: "(s2) 65443 is out of valid range (0-65442)"},
+		{name: "same packet sizes", params: Obfuscation{S1: 10, S2: 66}, wantError: "same size"},
+		{name: "same headers", params: Obfuscation{H1: 5, H2: 6, H3: 5, H4: 8}, wantError: "magic headers must differ"},
+		{name: "header of a default type", params: Obfuscation{H1: 5, H2: 2, H3: 3, H4: 5}, wantError: "magic headers must differ"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.params.Validate()
+			if tc.wantError == "" {
+				if err != nil {
+					t.Errorf("error: unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("error: got %v, want an error containing %q", err, tc.wantError)
+			}
+		})
+	}
+}
+
+// Testing that Start rejects invalid options before creating the device.
+func TestStartOptions(t *testing.T) {
+	type testCase struct {
+		name      string
+		opts      Options
+		wantError string
+	}
+
+	tests := []testCase{
+		{name: "missing name", opts: Options{}, wantError: "network interface name is missing"},
+		{name: "mtu out of range", opts: Options{InterfaceName: "awg0", MTU: 9001}, wantError: "out of valid range (1280-9000)"},
+		{
+			name:      "invalid obfuscation",
+			opts:      Options{InterfaceName: "awg0", Obfuscation: Obfuscation{Jmin: 10, Jmax: 5}},
+			wantError: "(jmax)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dev, err := Start(tc.opts)
+			if err == nil {
+				dev.Stop()
+				t.Fatalf("error: expected an error containing %q", tc.wantError)
+			}
+			if !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("error: got %v, want an error containing %q", err, tc.wantError)
+			}
+		})
+	}
+}
+
+// Testing the lifecycle of an obfuscated device on a test TUN: a private key
+// is generated, the obfuscation parameters are set, and Stop closes it.
+func TestStartStop(t *testing.T) {
+	dev, err := Start(Options{
+		InterfaceName: "brgtest0",
+		Obfuscation:   Obfuscation{Jc: 4, Jmin: 40, Jmax: 70, S1: 15, S2: 25, H1: 5, H2: 6, H3: 7, H4: 8},
+		TUN:           tuntest.NewChannelTUN().TUN(),
+		NoUAPI:        true,
+	})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	config, err := dev.IpcGet()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for _, want := range []string{"private_key=", "jc=4\n", "s1=15\n", "h4=8\n"} {
+		if !strings.Contains(config, want) {
+			t.Errorf("error: %q missing from the device configuration", want)
+		}
+	}
+
+	if err := dev.Stop(); err != nil {
+		t.Errorf("error: unexpected error: %v", err)
+	}
+	select {
+	case <-dev.Wait():
+	case <-time.After(5 * time.Second):
+		t.Fatal("error: Wait is not closed after Stop")
+	}
+}
@@ -0,0 +1,461 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/reconcile"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Functions reading the system, replaced in tests.
+var (
+	listDevices      = get.GetWireGuardDevices
+	interfaceBackend = get.GetInterfaceBackend
+	interfaceExists  = get.GetExistInterface
+)
+
+// Function brings the system to the desired state (see reconcile.Apply),
+// replaced in tests.
+var applyState = func(desired reconcile.State, out io.Writer, held ...*oplock.Lock) error {
+	current, err := reconcile.Inspect(desired)
+	if err != nil {
+		return err
+	}
+	return reconcile.Apply(desired, reconcile.Diff(desired, current), out, held...)
+}
+
+// Function reads the network interfaces, every WireGuard and AmneziaWG
+// device when no name is given, into a Bundle.
+//
+// Usage example:
+//
+//	bundle, err := backup.Capture()
+//	if err != nil {
+//	    // Handle error
+//	}
+//	err = backup.Write("/root/vpn.backup", bundle, passphrase)
+func Capture(names ...string) (Bundle, error) {
+	bundle := Bundle{Version: Version, Created: time.Now().UTC(), Interfaces: []Interface{}}
+	bundle.Host, _ = os.Hostname()
+
+	if len(names) == 0 {
+		devices, err := listDevices()
+		if err != nil {
+			return Bundle{}, err
+		}
+		names = devices
+	}
+
+	for _, name := range names {
+		backend, err := interfaceBackend(name)
+		if err != nil {
+			return Bundle{}, err
+		}
+
+		var iface Interface
+		if backend.AmneziaWG() {
+			iface, err = captureAwg(name)
+		} else {
+			iface, err = captureWg(name)
+		}
+		if err != nil {
+			return Bundle{}, err
+		}
+
+		if iface.Addresses, err = addresses(name); err != nil {
+			return Bundle{}, err
+		}
+		meta, err := peermeta.Load(name)
+		if err != nil {
+			return Bundle{}, err
+		}
+		for i := range iface.Peers {
+			iface.Peers[i].Meta = meta[iface.Peers[i].PublicKey]
+		}
+
+		bundle.Interfaces = append(bundle.Interfaces, iface)
+	}
+	return bundle, nil
+}
+
+// Function reads a WireGuard device through wgctrl.
+func captureWg(name string) (Interface, error) {
+	client, err := handlers.InitWgCtlClient()
+	if err != nil {
+		return Interface{}, err
+	}
+	defer client.Close()
+
+	device, err := client.Device(name)
+	if err != nil {
+		return Interface{}, fmt.Errorf("error: failed to read network interface '%s', %v", name, err)
+	}
+
+	iface := Interface{
+		Name:         name,
+		Type:         reconcile.TypeWireGuard,
+		ListenPort:   device.ListenPort,
+		FirewallMark: device.FirewallMark,
+	}
+	if device.PrivateKey != (wgtypes.Key{}) {
+		iface.PrivateKey = handlers.NewSecretKey(device.PrivateKey)
+		iface.PublicKey = device.PublicKey.String()
+	}
+
+	for _, p := range device.Peers {
+		peer := Peer{
+			PublicKey:           p.PublicKey.String(),
+			PresharedKey:        p.PresharedKey != (wgtypes.Key{}),
+			AllowedIPs:          []string{},
+			PersistentKeepalive: int(p.PersistentKeepaliveInterval / time.Second),
+		}
+		if p.Endpoint != nil {
+			peer.Endpoint = p.Endpoint.String()
+		}
+		for _, ipNet := range p.AllowedIPs {
+			peer.AllowedIPs = append(peer.AllowedIPs, ipNet.String())
+		}
+		iface.Peers = append(iface.Peers, peer)
+	}
+	return iface, nil
+}
+
+// Function reads an AmneziaWG device with the awg tool, see
+// get.GetAwgPeerInfo.
+func captureAwg(name string) (Interface, error) {
+	info, err := get.GetAwgPeerInfo(name)
+	if err != nil {
+		return Interface{}, err
+	}
+
+	output, err := shell.DefaultRunner.Output(shell.FormatCmdAwgShowPrivateKey(name))
+	if err != nil {
+		return Interface{}, err
+	}
+	defer clear(output.Bytes())
+
+	iface := Interface{
+		Name:         name,
+		Type:         reconcile.TypeAmneziaWG,
+		PrivateKey:   handlers.NewSecretBytes(bytes.TrimSpace(output.Bytes())),
+		PublicKey:    info.PublicKey,
+		ListenPort:   info.ListenPort,
+		FirewallMark: info.FirewallMark,
+	}
+	if _, err := handlers.CheckPrivateKey(iface.PrivateKey); err != nil {
+		return Interface{}, fmt.Errorf("error: failed to read the private key of network interface '%s'", name)
+	}
+
+	for _, p := range info.Peers {
+		iface.Peers = append(iface.Peers, Peer{
+			PublicKey:           p.PublicKey,
+			PresharedKey:        p.PresharedKey,
+			AllowedIPs:          p.AllowedIPs,
+			Endpoint:            p.Endpoint,
+			PersistentKeepalive: p.PersistentKeepalive,
+		})
+	}
+	return iface, nil
+}
+
+// Function returns the IP addresses of the network interface, link-local
+// ones excepted.
+func addresses(name string) ([]string, error) {
+	show, err := get.GetIpShow(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var cidrs []string
+	for _, link := range show {
+		for _, addr := range link.AddrInfo {
+			if addr.Scope == "link" {
+				continue
+			}
+			cidrs = append(cidrs, fmt.Sprintf("%s/%d", addr.Local, addr.Prefixlen))
+		}
+	}
+	return cidrs, nil
+}
+
+// Function writes the bundle sealed with the passphrase (see Seal) to the
+// file, readable by its owner only. The file is written and synced under a
+// temporary name next to it and renamed into place, so a failed write
+// leaves a previous backup intact.
+func Write(path string, bundle Bundle, passphrase handlers.Secret) error {
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error: failed to encode the backup: %v", err)
+	}
+	defer clear(data)
+
+	sealed, err := Seal(data, passphrase)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return help.Errorf(help.CodeFileFailed, "error: failed to write backup file '%s': %v", path, err)
+	}
+	tmp := file.Name()
+	defer os.Remove(tmp)
+	defer file.Close()
+
+	if err := file.Chmod(0o600); err != nil {
+		return help.Errorf(help.CodeFileFailed, "error: failed to write backup file '%s': %v", path, err)
+	}
+	if _, err := file.Write(sealed); err != nil {
+		return help.Errorf(help.CodeFileFailed, "error: failed to write backup file '%s': %v", path, err)
+	}
+	if err := file.Sync(); err != nil {
+		return help.Errorf(help.CodeFileFailed, "error: failed to write backup file '%s': %v", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return help.Errorf(help.CodeFileFailed, "error: failed to write backup file '%s': %v", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return help.Errorf(help.CodeFileFailed, "error: failed to write backup file '%s': %v", path, err)
+	}
+	return nil
+}
+
+// Function reads and decrypts a backup file written by Write. A bundle of
+// a later format version is refused.
+func Read(path string, passphrase handlers.Secret) (Bundle, error) {
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		return Bundle{}, help.Errorf(help.CodeFileFailed, "error: failed to read backup file '%s': %v", path, err)
+	}
+
+	data, err := Open(sealed, passphrase)
+	if err != nil {
+		return Bundle{}, err
+	}
+	defer clear(data)
+
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return Bundle{}, help.Errorf(help.CodeInvalidFile, "error: invalid backup content: %v", err)
+	}
+	if header.Version < 1 || header.Version > Version {
+		return Bundle{}, help.Errorf(
+			help.CodeUnsupported,
+			"error: backup format version %d is not supported (up to %d), update brgnetuse",
+			header.Version, Version,
+		)
+	}
+
+	var bundle Bundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return Bundle{}, help.Errorf(help.CodeInvalidFile, "error: invalid backup content: %v", err)
+	}
+	return bundle, nil
+}
+
+// Function recreates the interfaces of the bundle: the missing interfaces,
+// addresses and peers through reconcile (see reconcile.Apply), then the
+// private key, the listen port, the firewall mark and the peer metadata.
+// Peers and addresses not in the bundle are removed, as reconcile does.
+//
+// An existing interface with another public key is refused before any
+// change, unless force is set: its key is then overwritten. The preshared
+// keys are not backed up, the peers which had one are reported to out.
+// The changes run under the operation lock held by the caller, if handed
+// (see oplock.Enter).
+//
+// Usage example:
+//
+//	bundle, err := backup.Read("/root/vpn.backup", passphrase)
+//	if err != nil {
+//	    // Handle error
+//	}
+//	err = backup.Restore(bundle, false, os.Stdout)
+func Restore(bundle Bundle, force bool, out io.Writer, held ...*oplock.Lock) error {
+	if len(bundle.Interfaces) == 0 {
+		return help.Errorf(help.CodeInvalidFile, "error: the backup holds no network interface")
+	}
+	if err := checkExisting(bundle, force); err != nil {
+		return err
+	}
+
+	desired, err := desiredState(bundle)
+	if err != nil {
+		return err
+	}
+	if err := applyState(desired, out, held...); err != nil {
+		return err
+	}
+
+	for _, iface := range bundle.Interfaces {
+		if err := restoreDevice(iface, held...); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "restored network interface '%s'\n", iface.Name)
+
+		if err := restoreMeta(iface); err != nil {
+			return err
+		}
+		for _, peer := range iface.Peers {
+			if peer.PresharedKey {
+				fmt.Fprintf(out, "peer '%s' of '%s' had a preshared key, set it again\n", peer.PublicKey, iface.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Function refuses the existing interfaces of the bundle with another
+// public key, unless force is set.
+func checkExisting(bundle Bundle, force bool) error {
+	var conflicts []string
+	for _, iface := range bundle.Interfaces {
+		exists, err := interfaceExists(iface.Name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		publicKey, err := livePublicKey(iface)
+		if err != nil {
+			return err
+		}
+		if publicKey != iface.PublicKey {
+			conflicts = append(conflicts, iface.Name)
+		}
+	}
+
+	if len(conflicts) > 0 && !force {
+		return help.Errorf(
+			help.CodeConflict,
+			"error: network interfaces exist with another key: %s, pass -force to overwrite them",
+			strings.Join(conflicts, ", "),
+		)
+	}
+	return nil
+}
+
+// Function returns the public key of an existing interface.
+func livePublicKey(iface Interface) (string, error) {
+	if iface.Type == reconcile.TypeAmneziaWG {
+		info, err := get.GetAwgPeerInfo(iface.Name)
+		return info.PublicKey, err
+	}
+
+	devices, err := get.GetPeer(iface.Name)
+	if err != nil {
+		return "", err
+	}
+	return devices[0].PublicKey.String(), nil
+}
+
+// Function returns the desired state of the bundle, validated like a state
+// file (see reconcile.Parse). The endpoint given by host name (see
+// peermeta.Meta) is preferred to the resolved one.
+func desiredState(bundle Bundle) (reconcile.State, error) {
+	var state reconcile.State
+	for _, iface := range bundle.Interfaces {
+		desired := reconcile.Interface{Name: iface.Name, Type: iface.Type, Addresses: iface.Addresses}
+		for _, peer := range iface.Peers {
+			endpoint := peer.Endpoint
+			if peer.Meta.Endpoint != "" {
+				endpoint = peer.Meta.Endpoint
+			}
+			desired.Peers = append(desired.Peers, reconcile.Peer{
+				PublicKey:           peer.PublicKey,
+				AllowedIPs:          peer.AllowedIPs,
+				Endpoint:            endpoint,
+				PersistentKeepalive: peer.PersistentKeepalive,
+			})
+		}
+		state.Interfaces = append(state.Interfaces, desired)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return reconcile.State{}, fmt.Errorf("error: failed to encode the desired state: %v", err)
+	}
+	return reconcile.Parse(data)
+}
+
+// Function sets the private key, the listen port and the firewall mark of
+// a restored interface.
+func restoreDevice(iface Interface, held ...*oplock.Lock) error {
+	if iface.Type == reconcile.TypeAmneziaWG {
+		var cmds []string
+		if !iface.PrivateKey.IsEmpty() {
+			cmds = append(cmds, shell.FormatCmdAwgUpdatePrivateKey(iface.Name, iface.PrivateKey.Reveal()))
+		}
+		if iface.ListenPort != 0 {
+			cmds = append(cmds, shell.FormatCmdAwgUpdatePort(iface.Name, strconv.Itoa(iface.ListenPort)))
+		}
+		if iface.FirewallMark != 0 {
+			cmds = append(cmds, shell.FormatCmdAwgUpdateFwMark(iface.Name, strconv.Itoa(iface.FirewallMark)))
+		}
+		for _, cmd := range cmds {
+			if err := shell.DefaultRunner.Run(cmd, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !iface.PrivateKey.IsEmpty() {
+		_, err := set.EnsurePrivateKey(set.UpdatePrivateKeyStructure{
+			InterfaceName: iface.Name,
+			Secret:        iface.PrivateKey,
+			Verify:        true,
+		}, held...)
+		if err != nil {
+			return err
+		}
+	}
+	if iface.ListenPort != 0 {
+		if _, err := set.EnsurePort(iface.Name, strconv.Itoa(iface.ListenPort), false, held...); err != nil {
+			return err
+		}
+	}
+	if iface.FirewallMark != 0 {
+		return set.UpdateFwMark(iface.Name, uint32(iface.FirewallMark), held...)
+	}
+	return nil
+}
+
+// Function writes the peer metadata of a restored interface.
+func restoreMeta(iface Interface) error {
+	changed := false
+	for _, peer := range iface.Peers {
+		changed = changed || !peer.Meta.IsZero()
+	}
+	if !changed {
+		return nil
+	}
+
+	return peermeta.Update(iface.Name, func(store peermeta.Store) bool {
+		for _, peer := range iface.Peers {
+			if !peer.Meta.IsZero() {
+				store[peer.PublicKey] = peer.Meta
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,406 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/internal/shellmock"
+	"github.com/AlexKira/brgnetuse/internal/wgmock"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/proc"
+	"github.com/AlexKira/brgnetuse/src/reconcile"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Passphrase of the tests.
+var passphrase = handlers.NewSecret("correct horse battery staple")
+
+// Function points the operation lock at a temporary file and lowers the
+// key derivation cost for the tests.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "brgnetuse-lock-")
+	if err != nil {
+		panic(err)
+	}
+	oplock.Path = filepath.Join(dir, "brgnetuse.lock")
+	kdfIterations = 1000
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Function points the system lookups and the stores of the package at test
+// doubles: the interfaces in exists exist, every interface is a kernel
+// WireGuard device, and the desired states given to reconcile are returned.
+func stubSystem(t *testing.T, exists ...string) *[]reconcile.State {
+	t.Helper()
+
+	prevDir, prevProc := peermeta.Dir, proc.ProcDir
+	peermeta.Dir, proc.ProcDir = t.TempDir(), t.TempDir()
+
+	prevBackend, prevExists, prevApply := interfaceBackend, interfaceExists, applyState
+	t.Cleanup(func() {
+		peermeta.Dir, proc.ProcDir = prevDir, prevProc
+		interfaceBackend, interfaceExists, applyState = prevBackend, prevExists, prevApply
+	})
+
+	interfaceBackend = func(name string) (get.Backend, error) { return get.BackendKernelWG, nil }
+	interfaceExists = func(name string) (bool, error) { return slices.Contains(exists, name), nil }
+
+	var applied []reconcile.State
+	applyState = func(desired reconcile.State, out io.Writer, _ ...*oplock.Lock) error {
+		applied = append(applied, desired)
+		return nil
+	}
+	return &applied
+}
+
+// Function returns a generated private key.
+func newKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// Testing the Seal and Open functions.
+func TestSealOpen(t *testing.T) {
+	plaintext := []byte(`{"version":1}`)
+
+	sealed, err := Seal(plaintext, passphrase)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Fatalf("error: the plaintext is readable in the sealed file")
+	}
+
+	again, err := Seal(plaintext, passphrase)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if bytes.Equal(sealed, again) {
+		t.Errorf("error: two seals are identical, the salt or nonce is not random")
+	}
+
+	damaged := bytes.Clone(sealed)
+	damaged[len(damaged)-1] ^= 1
+	header := bytes.Clone(sealed)
+	header[len(magic)+4] ^= 1
+	costly := bytes.Clone(sealed)
+	binary.BigEndian.PutUint32(costly[len(magic)+1:], 1<<32-1)
+
+	type testCase struct {
+		name       string
+		sealed     []byte
+		passphrase handlers.Secret
+		wantError  string
+	}
+
+	tests := []testCase{
+		{name: "valid", sealed: sealed, passphrase: passphrase},
+		{name: "wrong passphrase", sealed: sealed, passphrase: handlers.NewSecret("wrong"), wantError: "wrong passphrase"},
+		{name: "damaged ciphertext", sealed: damaged, passphrase: passphrase, wantError: "damaged file"},
+		{name: "damaged header", sealed: header, passphrase: passphrase, wantError: "damaged file"},
+		{name: "too many iterations", sealed: costly, passphrase: passphrase, wantError: "invalid backup key derivation"},
+		{name: "not a backup", sealed: []byte("[Interface]\nPrivateKey = x\n"), passphrase: passphrase, wantError: "not a brgnetuse backup"},
+		{name: "empty", sealed: nil, passphrase: passphrase, wantError: "not a brgnetuse backup"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Open(tc.sealed, tc.passphrase)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got error %v, want %q", err, tc.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("error: got %q, want %q", got, plaintext)
+			}
+		})
+	}
+
+	if _, err := Seal(plaintext, handlers.Secret{}); err == nil {
+		t.Errorf("error: an empty passphrase was accepted")
+	}
+}
+
+// Testing the Write function over an existing backup.
+func TestWriteReplace(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vpn.backup")
+	if err := os.WriteFile(path, []byte("previous"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Write(path, Bundle{Version: Version}, handlers.Secret{}); err == nil {
+		t.Fatalf("error: an empty passphrase was accepted")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "previous" {
+		t.Fatalf("error: a failed write changed the previous backup to %q", data)
+	}
+
+	if err := Write(path, Bundle{Version: Version}, passphrase); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if _, err := Read(path, passphrase); err != nil {
+		t.Errorf("error: unexpected error: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("error: got mode %o, want 600", info.Mode().Perm())
+	}
+
+	// A path which cannot be replaced leaves no temporary file behind.
+	target := filepath.Join(dir, "taken")
+	if err := os.Mkdir(target, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(target, Bundle{Version: Version}, passphrase); err == nil {
+		t.Errorf("error: a directory was replaced by the backup")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("error: got %d files, want the backup and the directory only", len(entries))
+	}
+}
+
+// Testing a backup written from one host and restored on a fresh one.
+func TestBackupRestore(t *testing.T) {
+	stubSystem(t)
+
+	key, peerKey, peerKey2 := newKey(t), newKey(t), newKey(t)
+	psk := newKey(t)
+	_, allowed, _ := net.ParseCIDR("10.10.10.2/32")
+	_, allowed2, _ := net.ParseCIDR("10.10.10.3/32")
+
+	wgmock.Install(t, &wgtypes.Device{
+		Name:         "wg0",
+		PrivateKey:   key,
+		PublicKey:    key.PublicKey(),
+		ListenPort:   51820,
+		FirewallMark: 51,
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey:                   peerKey.PublicKey(),
+				PresharedKey:                psk,
+				Endpoint:                    &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 51820},
+				AllowedIPs:                  []net.IPNet{*allowed},
+				PersistentKeepaliveInterval: 25 * time.Second,
+			},
+			{PublicKey: peerKey2.PublicKey(), AllowedIPs: []net.IPNet{*allowed2}},
+		},
+	})
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.FormatCmdIpShowJSON("wg0")] = `[{"ifname":"wg0","addr_info":[
+		{"family":"inet","local":"10.10.10.254","prefixlen":24,"scope":"global"},
+		{"family":"inet6","local":"fe80::1","prefixlen":64,"scope":"link"}]}]`
+
+	err := peermeta.Update("wg0", func(store peermeta.Store) bool {
+		store[peerKey2.PublicKey().String()] = peermeta.Meta{Name: "laptop", Endpoint: "vpn.example.com:51820"}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	bundle, err := Capture("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "vpn.backup")
+	if err := Write(path, bundle, passphrase); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("error: got mode %o, want 600", info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(key.String())) || bytes.Contains(data, []byte("laptop")) {
+		t.Fatalf("error: the backup file is not encrypted")
+	}
+	if _, err := Read(path, handlers.NewSecret("wrong")); err == nil {
+		t.Fatalf("error: the backup was read with a wrong passphrase")
+	}
+
+	// The fresh host: no interface, no metadata.
+	applied := stubSystem(t)
+	mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+	shellmock.Install(t)
+
+	restored, err := Read(path, passphrase)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	var out bytes.Buffer
+	if err := Restore(restored, false, &out); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	wantState := reconcile.State{Interfaces: []reconcile.Interface{{
+		Name:      "wg0",
+		Type:      reconcile.TypeWireGuard,
+		Addresses: []string{"10.10.10.254/24"},
+		Peers: []reconcile.Peer{
+			{PublicKey: peerKey.PublicKey().String(), AllowedIPs: []string{"10.10.10.2/32"},
+				Endpoint: "203.0.113.5:51820", PersistentKeepalive: 25},
+			{PublicKey: peerKey2.PublicKey().String(), AllowedIPs: []string{"10.10.10.3/32"},
+				Endpoint: "vpn.example.com:51820"},
+		},
+	}}}
+	if len(*applied) != 1 || !equalState((*applied)[0], wantState) {
+		t.Errorf("error: got desired states %+v, want %+v", *applied, wantState)
+	}
+
+	device, err := mock.Device("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if device.PrivateKey != key || device.ListenPort != 51820 || device.FirewallMark != 51 {
+		t.Errorf("error: got device key %v, port %d, fwmark %d", device.PublicKey, device.ListenPort, device.FirewallMark)
+	}
+
+	meta, err := peermeta.Load("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if got := meta[peerKey2.PublicKey().String()]; got.Name != "laptop" || got.Endpoint != "vpn.example.com:51820" {
+		t.Errorf("error: got peer metadata %+v", got)
+	}
+
+	if !strings.Contains(out.String(), peerKey.PublicKey().String()+"' of 'wg0' had a preshared key") {
+		t.Errorf("error: the preshared key is not reported:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), peerKey2.PublicKey().String()) {
+		t.Errorf("error: a peer without preshared key is reported:\n%s", out.String())
+	}
+}
+
+// Testing that Restore refuses an existing interface with another key
+// unless forced.
+func TestRestoreExisting(t *testing.T) {
+	key, other := newKey(t), newKey(t)
+	bundle := Bundle{Version: Version, Interfaces: []Interface{{
+		Name:       "wg0",
+		Type:       reconcile.TypeWireGuard,
+		PrivateKey: handlers.NewSecretKey(key),
+		PublicKey:  key.PublicKey().String(),
+	}}}
+
+	type testCase struct {
+		name      string
+		device    wgtypes.Key
+		force     bool
+		wantError string
+	}
+
+	tests := []testCase{
+		{name: "same key", device: key},
+		{name: "other key", device: other, wantError: "pass -force"},
+		{name: "other key forced", device: other, force: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			applied := stubSystem(t, "wg0")
+			mock := wgmock.Install(t, &wgtypes.Device{
+				Name: "wg0", PrivateKey: tc.device, PublicKey: tc.device.PublicKey(),
+			})
+			shellmock.Install(t)
+
+			err := Restore(bundle, tc.force, io.Discard)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got error %v, want %q", err, tc.wantError)
+				}
+				if len(*applied) != 0 || len(mock.Calls) != 0 {
+					t.Errorf("error: the system was changed before the refusal")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			device, err := mock.Device("wg0")
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if device.PrivateKey != key {
+				t.Errorf("error: the private key was not restored")
+			}
+		})
+	}
+}
+
+// Testing that Read refuses a bundle of a later format version.
+func TestReadVersion(t *testing.T) {
+	sealed, err := Seal([]byte(`{"version":99,"interfaces":[]}`), passphrase)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "vpn.backup")
+	if err := os.WriteFile(path, sealed, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Read(path, passphrase); err == nil || !strings.Contains(err.Error(), "version 99 is not supported") {
+		t.Errorf("error: got error %v, want a version refusal", err)
+	}
+}
+
+// Function compares two desired states.
+func equalState(a, b reconcile.State) bool {
+	if len(a.Interfaces) != len(b.Interfaces) {
+		return false
+	}
+	for i := range a.Interfaces {
+		x, y := a.Interfaces[i], b.Interfaces[i]
+		if x.Name != y.Name || x.Type != y.Type || !slices.Equal(x.Addresses, y.Addresses) || len(x.Peers) != len(y.Peers) {
+			return false
+		}
+		for j := range x.Peers {
+			p, q := x.Peers[j], y.Peers[j]
+			if p.PublicKey != q.PublicKey || !slices.Equal(p.AllowedIPs, q.AllowedIPs) ||
+				p.Endpoint != q.Endpoint || p.PersistentKeepalive != q.PersistentKeepalive {
+				return false
+			}
+		}
+	}
+	return true
+}
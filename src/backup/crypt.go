@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/help"
+)
+
+// Magic bytes starting a backup file, followed by the envelope version.
+const magic = "brgnetuse-backup"
+
+// Version of the encryption envelope (see Seal), independent of the Bundle
+// format version inside it.
+const envelopeVersion byte = 1
+
+// Sizes of the envelope fields, in bytes.
+const (
+	saltSize  = 16
+	nonceSize = 12
+	keySize   = 32
+)
+
+// PBKDF2 iterations of a sealed file, stored in its header so Open reads
+// files sealed with another count. Tests lower it.
+var kdfIterations uint32 = 600_000
+
+// Upper bound of the PBKDF2 iterations Open accepts. The count is read from
+// the file header, a crafted file must not pin the CPU.
+const maxKdfIterations = 10_000_000
+
+// Function encrypts the plaintext with the passphrase:
+//
+//	magic | version (1) | iterations (4, big endian) | salt (16) | nonce (12) | AES-256-GCM ciphertext
+//
+// The key is derived with PBKDF2-SHA256 from the passphrase and a random
+// salt; the header is authenticated as additional data.
+func Seal(plaintext []byte, passphrase handlers.Secret) ([]byte, error) {
+	if passphrase.IsEmpty() {
+		return nil, help.Errorf(help.CodeInvalidValue, "error: the backup passphrase is empty")
+	}
+
+	header := make([]byte, 0, len(magic)+1+4+saltSize+nonceSize)
+	header = append(header, magic...)
+	header = append(header, envelopeVersion)
+	header = binary.BigEndian.AppendUint32(header, kdfIterations)
+
+	random := make([]byte, saltSize+nonceSize)
+	if _, err := rand.Read(random); err != nil {
+		return nil, errors.New("error: failed to generate the backup salt")
+	}
+	header = append(header, random...)
+	salt, nonce := random[:saltSize], random[saltSize:]
+
+	aead, err := newAEAD(passphrase, salt, kdfIterations)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, plaintext, header), nil
+}
+
+// Function decrypts a file sealed by Seal. A wrong passphrase and a damaged
+// file cannot be told apart, both fail authentication.
+func Open(sealed []byte, passphrase handlers.Secret) ([]byte, error) {
+	headerSize := len(magic) + 1 + 4 + saltSize + nonceSize
+	if len(sealed) < headerSize || !bytes.HasPrefix(sealed, []byte(magic)) {
+		return nil, help.Errorf(help.CodeInvalidFile, "error: not a brgnetuse backup file")
+	}
+	if version := sealed[len(magic)]; version != envelopeVersion {
+		return nil, help.Errorf(help.CodeUnsupported, "error: unsupported backup file version, update brgnetuse")
+	}
+
+	offset := len(magic) + 1
+	iterations := binary.BigEndian.Uint32(sealed[offset:])
+	offset += 4
+	salt := sealed[offset : offset+saltSize]
+	nonce := sealed[offset+saltSize : headerSize]
+
+	aead, err := newAEAD(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, sealed[headerSize:], sealed[:headerSize])
+	if err != nil {
+		return nil, help.Errorf(help.CodeInvalidFile, "error: failed to decrypt the backup, wrong passphrase or damaged file")
+	}
+	return plaintext, nil
+}
+
+// Function returns the AES-256-GCM cipher of the key derived from the
+// passphrase.
+func newAEAD(passphrase handlers.Secret, salt []byte, iterations uint32) (cipher.AEAD, error) {
+	if iterations == 0 || iterations > maxKdfIterations {
+		return nil, errors.New("error: invalid backup key derivation parameters")
+	}
+
+	key, err := pbkdf2.Key(sha256.New, passphrase.Reveal(), salt, int(iterations), keySize)
+	if err != nil {
+		return nil, errors.New("error: failed to derive the backup key")
+	}
+	defer clear(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.New("error: failed to initialize the backup cipher")
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,106 @@
+// Package backup snapshots what is needed to rebuild the VPN identity of
+// a host (the private keys, ports, addresses, peers and peer metadata of
+// its WireGuard and AmneziaWG interfaces) into a passphrase encrypted file,
+// and restores it on a fresh host through the reconcile and set paths.
+//
+// The file holds a versioned JSON Bundle sealed with AES-256-GCM, the key
+// derived from the passphrase with PBKDF2-SHA256 (see Seal).
+package backup
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+)
+
+// Version of the Bundle format written by Capture. Open refuses a bundle of
+// a later version.
+const Version = 1
+
+// Bundle is the content of a backup file.
+type Bundle struct {
+	// Version is the format version, see Version.
+	Version int `json:"version"`
+
+	// Created is the moment (UTC) of the backup, Host the host name.
+	Created time.Time `json:"created"`
+	Host    string    `json:"host,omitempty"`
+
+	// Interfaces lists the backed up network interfaces.
+	Interfaces []Interface `json:"interfaces"`
+}
+
+// Interface is a backed up WireGuard or AmneziaWG network interface.
+type Interface struct {
+	// Name is the network interface name.
+	Name string `json:"name"`
+
+	// Type is reconcile.TypeWireGuard or reconcile.TypeAmneziaWG.
+	Type string `json:"type"`
+
+	// PrivateKey is the private key of the interface (base64 encoded),
+	// written in clear inside the sealed file only, see MarshalJSON.
+	// PublicKey is the one derived from it, compared with an existing
+	// interface on restore.
+	PrivateKey handlers.Secret `json:"-"`
+	PublicKey  string          `json:"public_key"`
+
+	// ListenPort and FirewallMark are the device settings, 0 if unset.
+	ListenPort   int `json:"listen_port"`
+	FirewallMark int `json:"firewall_mark,omitempty"`
+
+	// Addresses lists the IP addresses in CIDR notation, link-local ones
+	// excepted.
+	Addresses []string `json:"addresses,omitempty"`
+
+	// Peers lists the peers of the interface.
+	Peers []Peer `json:"peers,omitempty"`
+}
+
+// Peer is a backed up peer.
+type Peer struct {
+	// PublicKey is the public key of the peer (base64 encoded).
+	PublicKey string `json:"public_key"`
+
+	// PresharedKey reports whether a preshared key was set. The key itself
+	// is not backed up, it is set again by hand after a restore.
+	PresharedKey bool `json:"preshared_key,omitempty"`
+
+	// AllowedIPs lists the allowed IP networks in CIDR notation.
+	AllowedIPs []string `json:"allowed_ips"`
+
+	// Endpoint and PersistentKeepalive are the peer settings, empty and 0
+	// if unset.
+	Endpoint            string `json:"endpoint,omitempty"`
+	PersistentKeepalive int    `json:"persistent_keepalive,omitempty"`
+
+	// Meta is the peer metadata (name, note, expiry), see peermeta.Meta.
+	Meta peermeta.Meta `json:"meta,omitzero"`
+}
+
+// Method encodes the interface with its private key, which a Secret never
+// reveals in JSON.
+func (i Interface) MarshalJSON() ([]byte, error) {
+	type plain Interface
+	return json.Marshal(struct {
+		plain
+		PrivateKey string `json:"private_key"`
+	}{plain(i), i.PrivateKey.Reveal()})
+}
+
+// Method decodes the interface with its private key, see MarshalJSON.
+func (i *Interface) UnmarshalJSON(data []byte) error {
+	type plain Interface
+	var v struct {
+		plain
+		PrivateKey string `json:"private_key"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*i = Interface(v.plain)
+	i.PrivateKey = handlers.NewSecret(v.PrivateKey)
+	return nil
+}
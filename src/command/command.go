@@ -0,0 +1,158 @@
+// Package command runs the external commands of the module (ip, iptables,
+// tc, resolvectl, awg) in the system shell behind the Runner interface, so
+// callers can record or replace them. The errors never contain key
+// material, see Run and Output.
+package command
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+)
+
+// Runner executes commands in the system shell.
+//
+// The utilities and the get and set packages run every external command
+// through a Runner, so tests can replace it with a recording one and
+// assert the exact command sequence without touching the system.
+type Runner interface {
+	// Run executes the command, optionally attaching it to the process
+	// standard output and error (see Run).
+	Run(cmd string, shell bool) error
+
+	// Output executes the command and returns its combined output
+	// (see Output).
+	Output(cmd string) (*bytes.Buffer, error)
+}
+
+// SystemRunner executes commands with /bin/bash.
+type SystemRunner struct{}
+
+// Method executes the command in the system shell.
+func (SystemRunner) Run(cmd string, shell bool) error {
+	return Run(cmd, shell)
+}
+
+// Method executes the command in the system shell and returns its output.
+func (SystemRunner) Output(cmd string) (*bytes.Buffer, error) {
+	return Output(cmd)
+}
+
+// ErrOffline is returned by OfflineRunner for every command.
+var ErrOffline = errors.New("error: no command runs in offline mode")
+
+// OfflineRunner refuses every command, it is installed while a change is
+// only validated (e.g., `brgsetwg -validate`) so nothing reaches the system.
+// The refused commands are kept, redacted, in Refused.
+type OfflineRunner struct {
+	Refused []string
+}
+
+// Method refuses the command.
+func (p *OfflineRunner) Run(cmd string, shell bool) error {
+	return p.refuse(cmd)
+}
+
+// Method refuses the command.
+func (p *OfflineRunner) Output(cmd string) (*bytes.Buffer, error) {
+	return nil, p.refuse(cmd)
+}
+
+// Method records the refused command and returns ErrOffline.
+func (p *OfflineRunner) refuse(cmd string) error {
+	p.Refused = append(p.Refused, handlers.Redact(cmd))
+	return fmt.Errorf("%w: [%s]", ErrOffline, handlers.Redact(cmd))
+}
+
+// Function of executing commands in the system shell. With shell, the
+// command writes to the standard output and error of the process. Secrets
+// in the command are redacted from the error.
+func Run(cmd string, shell bool) error {
+	_, err := exec.LookPath(strings.Fields(cmd)[0])
+	if err != nil {
+		return fmt.Errorf("runtime error: [%s], %w", handlers.Redact(cmd), err)
+	}
+
+	run := exec.Command("/bin/bash", "-c", cmd)
+
+	if shell {
+		run.Stdout = os.Stdout
+		run.Stderr = os.Stderr
+	}
+
+	err = run.Start()
+	if err != nil {
+		return fmt.Errorf("runtime error: [%s], %v", handlers.Redact(cmd), err)
+	}
+
+	err = run.Wait()
+	if err != nil {
+		return fmt.Errorf("runtime error: [%s], %v", handlers.Redact(cmd), err)
+	}
+
+	return nil
+}
+
+// CommandError is returned by Output when the command ran and failed. The
+// output and the exit status let the callers tell the failures apart
+// (e.g., a missing network interface).
+type CommandError struct {
+	// Output is the combined output of the command, secrets redacted.
+	Output string
+
+	// ExitCode is the exit status of the command, -1 if it did not exit.
+	ExitCode int
+
+	// Err is the error returned by the command execution.
+	Err error
+}
+
+// Method describes the failure on a single line.
+func (e *CommandError) Error() string {
+	replacer := strings.NewReplacer("\n", "", ".", "")
+	return "runtime error: " + handlers.Redact(replacer.Replace(fmt.Sprintf("%s, %v", e.Output, e.Err)))
+}
+
+// Method returns the error of the command execution.
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Method returns the stable code of the error, see help.CodeCommandFailed.
+func (e *CommandError) ErrorCode() string {
+	return "BRG-E006"
+}
+
+// Function executes a command in the system shell and returns the
+// combined stdout and stderr output. A failed command returns a
+// *CommandError, a missing command an error matching exec.ErrNotFound.
+func Output(cmd string) (*bytes.Buffer, error) {
+	_, err := exec.LookPath(strings.Fields(cmd)[0])
+	if err != nil {
+		return nil, fmt.Errorf(
+			"runtime error: command '%s' not found: %w", strings.Fields(cmd)[0],
+			err,
+		)
+	}
+
+	output, err := exec.Command("/bin/bash", "-c", cmd).CombinedOutput()
+	if err != nil {
+		cmdErr := &CommandError{Output: handlers.Redact(string(output)), ExitCode: -1, Err: err}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			cmdErr.ExitCode = exitErr.ExitCode()
+		}
+		return nil, cmdErr
+	}
+
+	return bytes.NewBuffer(output), nil
+}
+
+// Function quotes the value as a single shell word.
+func Quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
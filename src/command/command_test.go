@@ -0,0 +1,67 @@
+package command
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Testing that a failing key update command does not leak the key.
+func TestRunRedaction(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("error: failed to generate key: %v", err)
+	}
+
+	// The binary does not exist, so the command fails before running.
+	cmd := "brgnetuse-missing-awg set wg0 private-key <(echo '" + key.String() + "')"
+
+	if err := Run(cmd, false); err == nil || strings.Contains(err.Error(), key.String()) {
+		t.Errorf("error: got %v", err)
+	}
+
+	// The command fails while running and echoes its arguments.
+	cmd = "false private-key " + key.String() + " || (echo private-key " + key.String() + "; exit 1)"
+	if _, err := Output(cmd); err == nil || strings.Contains(err.Error(), key.String()) {
+		t.Errorf("error: got %v", err)
+	}
+}
+
+// Testing that a failed command reports its output and exit status.
+func TestOutputError(t *testing.T) {
+	_, err := Output("printf 'Device \"qwerty\" does not exist.\\n'; exit 1")
+
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("error: got %v, want *CommandError", err)
+	}
+	if cmdErr.ExitCode != 1 || cmdErr.Output != "Device \"qwerty\" does not exist.\n" {
+		t.Errorf("error: got exit status %d and output %q", cmdErr.ExitCode, cmdErr.Output)
+	}
+	if err.Error() != `runtime error: Device "qwerty" does not exist, exit status 1` {
+		t.Errorf("error: got message %q", err.Error())
+	}
+
+	_, err = Output("brgnetuse-missing-command")
+	if !errors.Is(err, exec.ErrNotFound) || errors.As(err, &cmdErr) {
+		t.Errorf("error: got %v, want exec.ErrNotFound", err)
+	}
+}
+
+// Testing that the offline runner refuses and records every command.
+func TestOfflineRunner(t *testing.T) {
+	runner := &OfflineRunner{}
+
+	if err := runner.Run("ip link set wg0 up", false); !errors.Is(err, ErrOffline) {
+		t.Errorf("error: got %v, want ErrOffline", err)
+	}
+	if out, err := runner.Output("ip -j addr show wg0"); out != nil || !errors.Is(err, ErrOffline) {
+		t.Errorf("error: got %v %v, want ErrOffline", out, err)
+	}
+	if len(runner.Refused) != 2 || runner.Refused[0] != "ip link set wg0 up" {
+		t.Errorf("error: got refused %q", runner.Refused)
+	}
+}
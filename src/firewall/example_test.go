@@ -0,0 +1,65 @@
+package firewall_test
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/AlexKira/brgnetuse/src/command"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/proc"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/validate"
+)
+
+// Example of a control plane adding a peer to a running interface, the NAT
+// rule of its subnet and checking the health of the interface, with the
+// public packages only. It needs root and a WireGuard interface wg0.
+func Example_addPeerWithNat() {
+	const iface, uplink, subnet = "wg0", "eth0", "10.0.0.0/24"
+	allowedIPs := []string{"10.0.0.2/32"}
+
+	if err := validate.CheckInterfaceName(iface); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := validate.CheckAllowedIPs(allowedIPs); err != nil {
+		log.Fatal(err)
+	}
+
+	// A userspace device is served by a brgaddwg or brgaddawg process.
+	pid, wgType, err := proc.FindProcess(iface)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if pid != 0 {
+		fmt.Printf("%s is served by the %s process %d\n", iface, wgType, pid)
+	}
+
+	peer := set.SinglePeerStructure{
+		InterfaceName:               iface,
+		PublicKey:                   "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+		AllowedIPs:                  allowedIPs,
+		PersistentKeepaliveInterval: "25",
+	}
+	if err := peer.AddPeer(false); err != nil {
+		log.Fatal(err)
+	}
+
+	// The rule is tagged with the interface, see firewall.RuleTag.
+	if err := firewall.Nat(command.SystemRunner{}, firewall.Append, uplink, subnet, iface); err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := get.CheckHealth(get.HealthOptions{
+		Interface:    iface,
+		MaxHandshake: get.DefaultMaxHandshake,
+		MinPeers:     1,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, failure := range report.Failures {
+		fmt.Println(failure)
+	}
+	fmt.Println(report.Status)
+}
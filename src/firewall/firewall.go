@@ -0,0 +1,154 @@
+// Package firewall formats and applies the iptables rules of the WireGuard
+// and AmneziaWG interfaces: the INPUT rule of the listen port, the FORWARD
+// rules between the uplink and the interface, and the NAT rule of its
+// subnet. The rules are tagged with a comment naming their interface (see
+// RuleTag), so they can be found and removed with it.
+package firewall
+
+import (
+	"fmt"
+
+	"github.com/AlexKira/brgnetuse/src/command"
+)
+
+// Action of an iptables command: append or delete a rule.
+type Action string
+
+const (
+	Append Action = "A"
+	Delete Action = "D"
+)
+
+const (
+	// Command: iptables, the rules of the filter table.
+	CmdList string = "iptables -L -v -n"
+
+	// Command: iptables, the rules of the nat table.
+	CmdListNat string = "iptables -t nat -L -v"
+
+	// Command: iptables-save, the rules of all tables in the restore format.
+	CmdSave string = "iptables-save"
+)
+
+// Prefix of the comment tagging the iptables rules created by this tool,
+// see RuleTag.
+const RuleTagPrefix string = "brgnetuse"
+
+// Function returns the comment tagging an iptables rule created for the
+// network interface (e.g., "brgnetuse:wg0"), or "brgnetuse" for a rule not
+// bound to an interface (the INPUT port rule).
+func RuleTag(owner string) string {
+	if owner == "" {
+		return RuleTagPrefix
+	}
+	return RuleTagPrefix + ":" + owner
+}
+
+// Function generates the iptables match tagging a rule with RuleTag.
+func FormatRuleComment(owner string) string {
+	return fmt.Sprintf(`-m comment --comment "%s"`, RuleTag(owner))
+}
+
+// Function generates an iptables command to manage (add/remove) an INGRESS
+// rule for UDP traffic on the specified destination port.
+func FormatCmdPort(action Action, dport string) string {
+	return FormatCmdPortIn(action, dport, "")
+}
+
+// Function works like FormatCmdPort, the rule only accepting the traffic
+// arriving on the input interface in (e.g., the public uplink); an empty
+// in accepts it on any interface.
+func FormatCmdPortIn(action Action, dport, in string) string {
+	scope := ""
+	if in != "" {
+		scope = "-i " + in + " "
+	}
+	return fmt.Sprintf(
+		"iptables -%s INPUT %s-p udp --dport %s %s -j ACCEPT",
+		action, scope, dport, FormatRuleComment(""),
+	)
+}
+
+// Function generates the `iptables` command to manage the FORWARD rules
+// between the uplink and the WireGuard interface, in both directions. Both
+// rules are tagged with the WireGuard interface.
+func FormatCmdForward(action Action, osIface, wgIface string) string {
+	in := fmt.Sprintf(
+		"iptables -%s FORWARD -i %s -o %s %s -j ACCEPT",
+		action, osIface, wgIface, FormatRuleComment(wgIface),
+	)
+
+	out := fmt.Sprintf(
+		"iptables -%s FORWARD -i %s -o %s %s -j ACCEPT",
+		action, wgIface, osIface, FormatRuleComment(wgIface),
+	)
+	return fmt.Sprintf("%s && %s", in, out)
+}
+
+// Function generates the `iptables` command to manage the NAT rule of the
+// subnet leaving through the uplink. The rule is tagged with the WireGuard
+// interface.
+func FormatCmdNat(action Action, osIface, subnet, wgIface string) string {
+	return fmt.Sprintf(
+		"iptables -t nat -%s POSTROUTING -s %s -o %s %s -j MASQUERADE",
+		action, subnet, osIface, FormatRuleComment(wgIface),
+	)
+}
+
+// Function generates the `iptables` command to manage the hairpin NAT rule
+// of the subnet of the WireGuard interface: the traffic from the subnet to
+// the subnet leaving through the interface again, e.g., a client reaching
+// a service of another client through the public address of the gateway,
+// is masqueraded, so the replies return through the gateway. The rule is
+// tagged with the WireGuard interface.
+func FormatCmdHairpin(action Action, subnet, wgIface string) string {
+	return fmt.Sprintf(
+		"iptables -t nat -%s POSTROUTING -s %s -d %s -o %s %s -j MASQUERADE",
+		action, subnet, subnet, wgIface, FormatRuleComment(wgIface),
+	)
+}
+
+// Function generates the `iptables` command appending a rule to the table
+// (e.g., "filter", "nat") chain by its specification.
+func FormatCmdAppend(table, chain, spec string) string {
+	if table == "" || table == "filter" {
+		return fmt.Sprintf("iptables -A %s %s", chain, spec)
+	}
+	return fmt.Sprintf("iptables -t %s -A %s %s", table, chain, spec)
+}
+
+// Function generates the `iptables` command deleting a rule of the table
+// (e.g., "filter", "nat") chain by its specification.
+func FormatCmdDelete(table, chain, spec string) string {
+	if table == "" || table == "filter" {
+		return fmt.Sprintf("iptables -D %s %s", chain, spec)
+	}
+	return fmt.Sprintf("iptables -t %s -D %s %s", table, chain, spec)
+}
+
+// Function generates the `iptables-restore` command adding the rules of the
+// file to the current ones, without flushing the tables.
+func FormatCmdRestore(path string) string {
+	return fmt.Sprintf("iptables-restore --noflush < %s", command.Quote(path))
+}
+
+// Function adds (Append) or removes (Delete) the NAT rule of the subnet of
+// the WireGuard interface leaving through the uplink osIface.
+//
+// Usage example:
+//
+//	err := firewall.Nat(command.SystemRunner{}, firewall.Append, "eth0", "10.0.0.0/24", "wg0")
+func Nat(runner command.Runner, action Action, osIface, subnet, wgIface string) error {
+	return runner.Run(FormatCmdNat(action, osIface, subnet, wgIface), false)
+}
+
+// Function adds (Append) or removes (Delete) the FORWARD rules between the
+// uplink osIface and the WireGuard interface.
+func Forward(runner command.Runner, action Action, osIface, wgIface string) error {
+	return runner.Run(FormatCmdForward(action, osIface, wgIface), false)
+}
+
+// Function opens (Append) or closes (Delete) the UDP listen port.
+func Port(runner command.Runner, action Action, dport string) error {
+	return runner.Run(FormatCmdPort(action, dport), false)
+}
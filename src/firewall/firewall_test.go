@@ -0,0 +1,96 @@
+package firewall
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+// recordRunner records the commands instead of executing them.
+type recordRunner struct {
+	commands []string
+}
+
+func (r *recordRunner) Run(cmd string, shell bool) error {
+	r.commands = append(r.commands, cmd)
+	return nil
+}
+
+func (r *recordRunner) Output(cmd string) (*bytes.Buffer, error) {
+	r.commands = append(r.commands, cmd)
+	return &bytes.Buffer{}, nil
+}
+
+// Testing the commands of the tagged rules.
+func TestFormatCmd(t *testing.T) {
+	type testCase struct {
+		name string
+		got  string
+		want string
+	}
+
+	tests := []testCase{
+		{
+			name: "port",
+			got:  FormatCmdPort(Append, "51820"),
+			want: `iptables -A INPUT -p udp --dport 51820 -m comment --comment "brgnetuse" -j ACCEPT`,
+		},
+		{
+			name: "port in",
+			got:  FormatCmdPortIn(Delete, "51820", "eth0"),
+			want: `iptables -D INPUT -i eth0 -p udp --dport 51820 -m comment --comment "brgnetuse" -j ACCEPT`,
+		},
+		{name: "port any", got: FormatCmdPortIn(Append, "51820", ""), want: FormatCmdPort(Append, "51820")},
+		{
+			name: "forward",
+			got:  FormatCmdForward(Delete, "eth0", "wg0"),
+			want: `iptables -D FORWARD -i eth0 -o wg0 -m comment --comment "brgnetuse:wg0" -j ACCEPT && ` +
+				`iptables -D FORWARD -i wg0 -o eth0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`,
+		},
+		{
+			name: "nat",
+			got:  FormatCmdNat(Append, "eth0", "10.0.0.0/24", "wg0"),
+			want: `iptables -t nat -A POSTROUTING -s 10.0.0.0/24 -o eth0 -m comment --comment "brgnetuse:wg0" -j MASQUERADE`,
+		},
+		{
+			name: "hairpin",
+			got:  FormatCmdHairpin(Append, "10.10.10.0/24", "wg0"),
+			want: `iptables -t nat -A POSTROUTING -s 10.10.10.0/24 -d 10.10.10.0/24 -o wg0 -m comment --comment "brgnetuse:wg0" -j MASQUERADE`,
+		},
+		{name: "append filter", got: FormatCmdAppend("filter", "INPUT", "-j ACCEPT"), want: "iptables -A INPUT -j ACCEPT"},
+		{name: "delete nat", got: FormatCmdDelete("nat", "POSTROUTING", "-j MASQUERADE"), want: "iptables -t nat -D POSTROUTING -j MASQUERADE"},
+		{name: "restore", got: FormatCmdRestore("/etc/it's.rules"), want: `iptables-restore --noflush < '/etc/it'\''s.rules'`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.got != tc.want {
+				t.Errorf("error: got %q, want %q", tc.got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing that the rules are applied through the given runner.
+func TestApply(t *testing.T) {
+	runner := &recordRunner{}
+
+	if err := Nat(runner, Append, "eth0", "10.0.0.0/24", "wg0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Forward(runner, Delete, "eth0", "wg0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Port(runner, Append, "51820"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		FormatCmdNat(Append, "eth0", "10.0.0.0/24", "wg0"),
+		FormatCmdForward(Delete, "eth0", "wg0"),
+		FormatCmdPort(Append, "51820"),
+	}
+	if !slices.Equal(runner.commands, want) {
+		t.Errorf("error: got %q, want %q", runner.commands, want)
+	}
+}
@@ -0,0 +1,214 @@
+package get
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/src/proc"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// SysModuleDir is the sysfs directory of the loaded kernel modules, read
+// for the version of the kernel WireGuard and AmneziaWG modules. Tests
+// replace it with a directory of synthetic version files.
+var SysModuleDir = "/sys/module"
+
+// Backend is the implementation serving a WireGuard network interface,
+// which decides how the interface is configured: wgctrl for WireGuard,
+// the AmneziaWG UAPI socket or the awg tool for AmneziaWG.
+type Backend int
+
+// Backends detected by GetInterfaceBackend.
+const (
+	BackendUnknown Backend = iota
+	BackendKernelWG
+	BackendKernelAWG
+	BackendUserspaceWG
+	BackendUserspaceAWG
+)
+
+// Method describes the backend (e.g., "userspace AmneziaWG").
+func (b Backend) String() string {
+	switch b {
+	case BackendKernelWG:
+		return "kernel WireGuard"
+	case BackendKernelAWG:
+		return "kernel AmneziaWG"
+	case BackendUserspaceWG:
+		return "userspace WireGuard"
+	case BackendUserspaceAWG:
+		return "userspace AmneziaWG"
+	default:
+		return "unknown"
+	}
+}
+
+// Method reports whether the interface speaks the AmneziaWG protocol and
+// is not reachable through wgctrl.
+func (b Backend) AmneziaWG() bool {
+	return b == BackendKernelAWG || b == BackendUserspaceAWG
+}
+
+// Method reports whether a userspace process (e.g., brgaddwg, brgaddawg,
+// wireguard-go) serves the interface.
+func (b Backend) Userspace() bool {
+	return b == BackendUserspaceWG || b == BackendUserspaceAWG
+}
+
+// Function detects the backend of a network interface from, in order:
+//
+//   - the device type reported by wgctrl (a kernel WireGuard device);
+//   - the UAPI socket of the interface in the WireGuard or the AmneziaWG
+//     socket directory;
+//   - the type of the brgaddwg/brgaddawg process tagged with the interface,
+//     when both or neither socket exists;
+//   - the link kind ("wireguard", "amneziawg") of a kernel device.
+//
+// Interfaces created by other tools (wg-quick, a manually started
+// wireguard-go) are classified as well. BackendUnknown is returned for an
+// interface that is none of them.
+//
+// Usage example:
+//
+//	backend, err := get.GetInterfaceBackend("wg0")
+//	if err != nil {
+//	    // Handle error
+//	}
+//	if backend.AmneziaWG() {
+//	    // Configure with the awg tool
+//	}
+func GetInterfaceBackend(interfaceName string) (Backend, error) {
+	var device *wgtypes.Device
+	if client, err := handlers.InitWgCtlClient(); err == nil {
+		device, _ = client.Device(interfaceName)
+		client.Close()
+	}
+
+	return interfaceBackend(interfaceName, device)
+}
+
+// Function returns the backend of a device read by wgctrl from its type,
+// BackendUnknown for a type other than a Linux kernel or userspace device.
+func deviceBackend(device *wgtypes.Device) Backend {
+	switch device.Type {
+	case wgtypes.LinuxKernel:
+		return BackendKernelWG
+	case wgtypes.Userspace:
+		return BackendUserspaceWG
+	default:
+		return BackendUnknown
+	}
+}
+
+// Function detects the backend of a network interface with the device
+// read by wgctrl, nil when wgctrl does not know it.
+func interfaceBackend(interfaceName string, device *wgtypes.Device) (Backend, error) {
+	if device != nil && device.Type == wgtypes.LinuxKernel {
+		return BackendKernelWG, nil
+	}
+
+	// wgctrl reads the userspace devices through the WireGuard socket
+	// directory only.
+	wgSocket := socketExists(handlers.WgSocketDir, interfaceName) ||
+		(device != nil && device.Type == wgtypes.Userspace)
+	awgSocket := socketExists(handlers.AwgSocketDir, interfaceName)
+
+	switch {
+	case awgSocket && !wgSocket:
+		return BackendUserspaceAWG, nil
+	case wgSocket && !awgSocket:
+		return BackendUserspaceWG, nil
+	}
+
+	_, process, err := proc.FindProcess(interfaceName)
+	if err != nil {
+		return BackendUnknown, err
+	}
+	switch process {
+	case "awg":
+		return BackendUserspaceAWG, nil
+	case "wg":
+		return BackendUserspaceWG, nil
+	}
+
+	kind, err := GetLinkKind(interfaceName)
+	if err != nil {
+		return BackendUnknown, err
+	}
+	switch kind {
+	case "wireguard":
+		return BackendKernelWG, nil
+	case "amneziawg":
+		return BackendKernelAWG, nil
+	}
+	return BackendUnknown, nil
+}
+
+// Function reports whether the UAPI socket of the network interface
+// exists in dir.
+func socketExists(dir, interfaceName string) bool {
+	_, err := os.Stat(handlers.SocketPath(dir, interfaceName))
+	return err == nil
+}
+
+// Function describes the implementation serving a network interface and
+// its version:
+//
+//   - a kernel device, the module and the version it reports in
+//     SysModuleDir (e.g., "kernel module wireguard 1.0.0");
+//   - a userspace device, the implementation recorded by the
+//     brgaddwg/brgaddawg process at launch (e.g., "wireguard-go v0.0.0-...,
+//     brgnetuse 1.4.0"), see proc.Implementation.
+//
+// An empty string is returned when it cannot be told, e.g. for a device
+// started by another tool or an earlier release.
+//
+// Usage example:
+//
+//	implementation, err := get.GetInterfaceImplementation("wg0")
+//	if err != nil {
+//	    // Handle error
+//	}
+//	fmt.Println(implementation)
+func GetInterfaceImplementation(interfaceName string) (string, error) {
+	backend, err := GetInterfaceBackend(interfaceName)
+	if err != nil {
+		return "", err
+	}
+	return backendImplementation(interfaceName, backend), nil
+}
+
+// Function describes the implementation of a network interface served by
+// the backend, see GetInterfaceImplementation.
+func backendImplementation(interfaceName string, backend Backend) string {
+	switch backend {
+	case BackendKernelWG:
+		return moduleImplementation("wireguard")
+	case BackendKernelAWG:
+		return moduleImplementation("amneziawg")
+	case BackendUserspaceWG, BackendUserspaceAWG:
+		pid, _, err := proc.FindProcess(interfaceName)
+		if err != nil || pid == 0 {
+			return ""
+		}
+		return proc.ProcessImplementation(pid)
+	default:
+		return ""
+	}
+}
+
+// Function describes a kernel module with its version, the version is left
+// out when the module does not report one.
+func moduleImplementation(module string) string {
+	name := "kernel module " + module
+	data, err := os.ReadFile(filepath.Join(SysModuleDir, module, "version"))
+	if err != nil {
+		return name
+	}
+	if version := strings.TrimSpace(string(data)); version != "" {
+		return name + " " + version
+	}
+	return name
+}
@@ -0,0 +1,243 @@
+package get
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/internal/shellmock"
+	"github.com/AlexKira/brgnetuse/internal/wgmock"
+	"github.com/AlexKira/brgnetuse/src/proc"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Testing the GetInterfaceBackend function on every classification path:
+// the wgctrl device type, the UAPI sockets, the process tag and the link kind.
+func TestGetInterfaceBackend(t *testing.T) {
+	type testCase struct {
+		name    string
+		device  wgtypes.DeviceType // 0: not known to wgctrl.
+		sockets []string           // Socket directories holding wg0.sock.
+		process string             // Type of the process tagged with wg0.
+		kind    string             // Link kind of wg0.
+		want    Backend
+	}
+
+	tests := []testCase{
+		{name: "kernel_wgctrl", device: wgtypes.LinuxKernel, want: BackendKernelWG},
+		{name: "kernel_wgctrl_with_tag", device: wgtypes.LinuxKernel, process: "awg", want: BackendKernelWG},
+		{name: "userspace_wgctrl", device: wgtypes.Userspace, want: BackendUserspaceWG},
+		{name: "wg_socket", sockets: []string{"wg"}, want: BackendUserspaceWG},
+		{name: "awg_socket", sockets: []string{"awg"}, want: BackendUserspaceAWG},
+		{name: "awg_socket_wgquick", sockets: []string{"awg"}, kind: "tun", want: BackendUserspaceAWG},
+		{name: "both_sockets_awg_tag", sockets: []string{"wg", "awg"}, process: "awg", want: BackendUserspaceAWG},
+		{name: "both_sockets_wg_tag", sockets: []string{"wg", "awg"}, process: "wg", want: BackendUserspaceWG},
+		{name: "no_socket_awg_tag", process: "awg", want: BackendUserspaceAWG},
+		{name: "no_socket_wg_tag", process: "wg", want: BackendUserspaceWG},
+		{name: "kernel_awg_module", kind: "amneziawg", want: BackendKernelAWG},
+		{name: "kernel_link_kind", kind: "wireguard", want: BackendKernelWG},
+		{name: "tun_without_process", kind: "tun", want: BackendUnknown},
+		{name: "physical", want: BackendUnknown},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dirs := map[string]*string{"wg": &handlers.WgSocketDir, "awg": &handlers.AwgSocketDir}
+			prevWg, prevAwg, prevProc := handlers.WgSocketDir, handlers.AwgSocketDir, proc.ProcDir
+			handlers.WgSocketDir, handlers.AwgSocketDir, proc.ProcDir = t.TempDir(), t.TempDir(), t.TempDir()
+			t.Cleanup(func() {
+				handlers.WgSocketDir, handlers.AwgSocketDir, proc.ProcDir = prevWg, prevAwg, prevProc
+			})
+
+			for _, socket := range tc.sockets {
+				if err := os.WriteFile(handlers.SocketPath(*dirs[socket], "wg0"), nil, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.process != "" {
+				dir := filepath.Join(proc.ProcDir, "4242")
+				environ := proc.EnvFieldTag + "=wg0\x00" + proc.EnvFieldType + "=" + tc.process + "\x00"
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(dir, "environ"), []byte(environ), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var devices []*wgtypes.Device
+			if tc.device != 0 {
+				devices = append(devices, &wgtypes.Device{Name: "wg0", Type: tc.device})
+			}
+			wgmock.Install(t, devices...)
+
+			fake := shellmock.Install(t)
+			linkinfo := `{}`
+			if tc.kind != "" {
+				linkinfo = `{"info_kind":"` + tc.kind + `"}`
+			}
+			fake.Outputs[shell.FormatCmdIpLinkDetailJSON("wg0")] = `[{"ifname":"wg0","linkinfo":` + linkinfo + `}]`
+
+			got, err := GetInterfaceBackend("wg0")
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the Backend methods used to choose the configuration path.
+func TestBackendMethods(t *testing.T) {
+	type testCase struct {
+		backend   Backend
+		amneziaWG bool
+		userspace bool
+	}
+
+	tests := []testCase{
+		{backend: BackendUnknown},
+		{backend: BackendKernelWG},
+		{backend: BackendKernelAWG, amneziaWG: true},
+		{backend: BackendUserspaceWG, userspace: true},
+		{backend: BackendUserspaceAWG, amneziaWG: true, userspace: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.backend.String(), func(t *testing.T) {
+			if tc.backend.AmneziaWG() != tc.amneziaWG || tc.backend.Userspace() != tc.userspace {
+				t.Errorf("error: got AmneziaWG %v, Userspace %v", tc.backend.AmneziaWG(), tc.backend.Userspace())
+			}
+		})
+	}
+}
+
+// Testing that GetPeerInfo reads a userspace AmneziaWG device, unknown to
+// wgctrl, with awg.
+func TestGetPeerInfoAwg(t *testing.T) {
+	prevAwg, prevProc, prevMeta := handlers.AwgSocketDir, proc.ProcDir, peermeta.Dir
+	handlers.AwgSocketDir, proc.ProcDir, peermeta.Dir = t.TempDir(), t.TempDir(), t.TempDir()
+	t.Cleanup(func() {
+		handlers.AwgSocketDir, proc.ProcDir, peermeta.Dir = prevAwg, prevProc, prevMeta
+	})
+
+	wgmock.Install(t)
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.FormatCmdAwgShowDump("awg0")] = awgDump
+	fake.Outputs[shell.FormatCmdAwgShow("awg0")] = awgShow
+
+	// Not an AmneziaWG device: the wgctrl error is kept.
+	if _, err := GetPeerInfo("awg0"); err == nil {
+		t.Fatal("error: expected error for a device unknown to wgctrl, got none")
+	}
+
+	if err := os.WriteFile(handlers.SocketPath(handlers.AwgSocketDir, "awg0"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	devices, err := GetPeerInfo("awg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("error: got %d devices, want 1", len(devices))
+	}
+
+	device := devices[0]
+	if device.Backend != BackendUserspaceAWG.String() || device.ListenPort != 51820 || len(device.Peers) != 2 {
+		t.Errorf("error: unexpected device %+v", device)
+	}
+	if device.AwgParams == nil || device.AwgParams.Jc != 4 || device.AwgParams.H4 != "4" {
+		t.Errorf("error: got parameters %+v, want those of awg show", device.AwgParams)
+	}
+}
+
+// Testing the GetInterfaceImplementation function over synthetic module
+// version files and process environments.
+func TestGetInterfaceImplementation(t *testing.T) {
+	type testCase struct {
+		name    string
+		device  wgtypes.DeviceType // 0: not known to wgctrl.
+		kind    string             // Link kind of wg0.
+		modules map[string]string  // Module name to its version file content.
+		environ string             // Environment of the process tagged with wg0.
+		want    string
+	}
+
+	tagged := proc.EnvFieldTag + "=wg0\x00" + proc.EnvFieldType + "=wg\x00"
+	tests := []testCase{
+		{
+			name: "kernel_wg", device: wgtypes.LinuxKernel,
+			modules: map[string]string{"wireguard": "1.0.0\n"},
+			want:    "kernel module wireguard 1.0.0",
+		},
+		{name: "kernel_wg_no_version", device: wgtypes.LinuxKernel, want: "kernel module wireguard"},
+		{
+			name: "kernel_awg", kind: "amneziawg",
+			modules: map[string]string{"wireguard": "1.0.0\n", "amneziawg": "1.0.20240213\n"},
+			want:    "kernel module amneziawg 1.0.20240213",
+		},
+		{
+			name: "userspace_recorded", device: wgtypes.Userspace,
+			environ: tagged + proc.EnvFieldImplementation + "=wireguard-go v0.0.0-20231211153847-12269c276173, brgnetuse 1.4.0\x00",
+			want:    "wireguard-go v0.0.0-20231211153847-12269c276173, brgnetuse 1.4.0",
+		},
+		{name: "userspace_not_recorded", device: wgtypes.Userspace, environ: tagged, want: ""},
+		{name: "userspace_other_tool", device: wgtypes.Userspace, want: ""},
+		{name: "unknown", kind: "tun", want: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			prevWg, prevAwg, prevProc, prevSys := handlers.WgSocketDir, handlers.AwgSocketDir, proc.ProcDir, SysModuleDir
+			handlers.WgSocketDir, handlers.AwgSocketDir, proc.ProcDir, SysModuleDir = t.TempDir(), t.TempDir(), t.TempDir(), t.TempDir()
+			t.Cleanup(func() {
+				handlers.WgSocketDir, handlers.AwgSocketDir, proc.ProcDir, SysModuleDir = prevWg, prevAwg, prevProc, prevSys
+			})
+
+			for module, version := range tc.modules {
+				dir := filepath.Join(SysModuleDir, module)
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(dir, "version"), []byte(version), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.environ != "" {
+				dir := filepath.Join(proc.ProcDir, "4242")
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(dir, "environ"), []byte(tc.environ), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var devices []*wgtypes.Device
+			if tc.device != 0 {
+				devices = append(devices, &wgtypes.Device{Name: "wg0", Type: tc.device})
+			}
+			wgmock.Install(t, devices...)
+
+			fake := shellmock.Install(t)
+			linkinfo := `{}`
+			if tc.kind != "" {
+				linkinfo = `{"info_kind":"` + tc.kind + `"}`
+			}
+			fake.Outputs[shell.FormatCmdIpLinkDetailJSON("wg0")] = `[{"ifname":"wg0","linkinfo":` + linkinfo + `}]`
+
+			got, err := GetInterfaceImplementation("wg0")
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package get
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// RuleCounter is the traffic counted by a rule managed for a subnet or a
+// network interface: the MASQUERADE rule of a subnet, see NatCounters, or
+// a FORWARD rule of an interface, see ForwardCounters.
+type RuleCounter struct {
+	// Chain is the chain of the rule (e.g., POSTROUTING, FORWARD).
+	Chain string
+
+	// Rule is the rule with its packet and byte counters.
+	Rule IptablesRule
+}
+
+// Method returns the identity of the rule across samples. The rule number
+// is left out, it shifts when a rule before it is added or removed.
+func (c RuleCounter) key() string {
+	r := c.Rule
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s|%s",
+		c.Chain, r.Target, r.Prot, r.In, r.Out, r.Source, r.Destination, r.Comment)
+}
+
+// RuleRate is a RuleCounter with its rates between two samples.
+type RuleRate struct {
+	RuleCounter
+
+	// Packets and Bytes are the per-second rates, 0 for a rule missing
+	// from the previous sample.
+	Packets float64
+	Bytes   float64
+
+	// Delta reports whether the rates were measured, the rule was in the
+	// previous sample.
+	Delta bool
+}
+
+// Function returns the MASQUERADE rules of the POSTROUTING chain of the
+// NAT table whose source is the subnet (e.g., "10.10.10.0/24"), those
+// added by `brgsetwg -ip -a -n` with or without their tag.
+// Returns an error if cidr is not a valid prefix.
+//
+// Usage example:
+//
+//	nat, err := get.GetIptablesNAT()
+//	if err != nil {
+//	    // Handle error
+//	}
+//	counters, err := get.NatCounters(nat, "10.10.10.0/24")
+func NatCounters(nat IptablesOutput, cidr string) ([]RuleCounter, error) {
+	subnet, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid IP address format: %s", cidr)
+	}
+	subnet = subnet.Masked()
+
+	var counters []RuleCounter
+	for _, chain := range nat.Chains {
+		if chain.Name != "POSTROUTING" {
+			continue
+		}
+		for _, rule := range chain.Rules {
+			source, ok := parseRuleAddress(rule.Source)
+			if rule.Target == "MASQUERADE" && ok && source == subnet {
+				counters = append(counters, RuleCounter{Chain: chain.Name, Rule: rule})
+			}
+		}
+	}
+	return counters, nil
+}
+
+// Function returns the rules of the FORWARD chain of the network
+// interface: tagged with it (see firewall.RuleTag), or carrying it as the
+// input or output interface, as the untagged rules of older versions.
+func ForwardCounters(filter IptablesOutput, iface string) []RuleCounter {
+	var counters []RuleCounter
+	for _, chain := range filter.Chains {
+		if chain.Name != "FORWARD" {
+			continue
+		}
+		for _, rule := range chain.Rules {
+			owner, tagged := rule.Owner()
+			if (tagged && owner == iface) || rule.In == iface || rule.Out == iface {
+				counters = append(counters, RuleCounter{Chain: chain.Name, Rule: rule})
+			}
+		}
+	}
+	return counters
+}
+
+// Function compares two samples of the rule counters taken elapsed apart
+// and returns the rates of the rules of the current sample, see
+// CounterDelta. A nil previous sample yields no rates.
+func RuleRates(prev, cur []RuleCounter, elapsed time.Duration) []RuleRate {
+	before := make(map[string]IptablesRule, len(prev))
+	for _, c := range prev {
+		before[c.key()] = c.Rule
+	}
+
+	rates := make([]RuleRate, 0, len(cur))
+	for _, c := range cur {
+		rate := RuleRate{RuleCounter: c}
+		if old, ok := before[c.key()]; ok {
+			rate.Delta = true
+			rate.Packets = Rate(CounterDelta(old.Pkts, c.Rule.Pkts), elapsed)
+			rate.Bytes = Rate(CounterDelta(old.Bytes, c.Rule.Bytes), elapsed)
+		}
+		rates = append(rates, rate)
+	}
+	return rates
+}
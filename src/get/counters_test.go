@@ -0,0 +1,144 @@
+package get
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// Function returns the rules of the NAT and filter tables of the counter
+// tests: the tagged and untagged rules of wg0 and the rules of others.
+func counterTables(pkts int) (IptablesOutput, IptablesOutput) {
+	nat := IptablesOutput{Chains: []IptablesChain{
+		{Name: "PREROUTING"},
+		{Name: "POSTROUTING", Rules: []IptablesRule{
+			{Id: 1, Pkts: pkts, Bytes: pkts * 100, Target: "MASQUERADE", Out: "eth0", Source: "10.10.10.0/24", Comment: "brgnetuse:wg0"},
+			{Id: 2, Pkts: 7, Bytes: 700, Target: "MASQUERADE", Out: "wwan0", Source: "10.10.10.0/24"},
+			{Id: 3, Pkts: 9, Bytes: 900, Target: "MASQUERADE", Out: "eth0", Source: "10.10.0.0/16"},
+			{Id: 4, Pkts: 9, Bytes: 900, Target: "SNAT", Out: "eth0", Source: "10.10.10.0/24"},
+		}},
+	}}
+	filter := IptablesOutput{Chains: []IptablesChain{
+		{Name: "INPUT", Rules: []IptablesRule{
+			{Id: 1, Target: "ACCEPT", In: "wg0"},
+		}},
+		{Name: "FORWARD", Rules: []IptablesRule{
+			{Id: 1, Pkts: pkts, Bytes: pkts * 100, Target: "ACCEPT", In: "eth0", Out: "wg0", Comment: "brgnetuse:wg0"},
+			{Id: 2, Pkts: pkts, Bytes: pkts * 100, Target: "ACCEPT", In: "wg0", Out: "eth0", Comment: "brgnetuse:wg0"},
+			{Id: 3, Pkts: 5, Bytes: 500, Target: "ACCEPT", In: "eth0", Out: "wg1", Comment: "brgnetuse:wg1"},
+			{Id: 4, Pkts: 5, Bytes: 500, Target: "ACCEPT", In: "wg0", Out: "eth1"},
+		}},
+	}}
+	return nat, filter
+}
+
+// Function returns the rule numbers of the counters.
+func counterIds(counters []RuleCounter) []uint64 {
+	var ids []uint64
+	for _, c := range counters {
+		ids = append(ids, c.Rule.Id)
+	}
+	return ids
+}
+
+// Testing the identification of the MASQUERADE rules of a subnet.
+func TestNatCounters(t *testing.T) {
+	nat, _ := counterTables(10)
+
+	type testCase struct {
+		cidr      string
+		want      []uint64
+		wantError bool
+	}
+
+	tests := []testCase{
+		{cidr: "10.10.10.0/24", want: []uint64{1, 2}},
+		{cidr: "10.10.10.1/24", want: []uint64{1, 2}},
+		{cidr: "10.10.0.0/16", want: []uint64{3}},
+		{cidr: "10.20.0.0/24"},
+		{cidr: "10.10.10.0", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.cidr, func(t *testing.T) {
+			counters, err := NatCounters(nat, tc.cidr)
+			if (err != nil) != tc.wantError {
+				t.Fatalf("error: got error %v, want error %v", err, tc.wantError)
+			}
+			if got := counterIds(counters); !slices.Equal(got, tc.want) {
+				t.Errorf("error: got rules %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the identification of the FORWARD rules of a network interface,
+// by tag or by interface.
+func TestForwardCounters(t *testing.T) {
+	_, filter := counterTables(10)
+
+	if got := counterIds(ForwardCounters(filter, "wg0")); !slices.Equal(got, []uint64{1, 2, 4}) {
+		t.Errorf("error: got wg0 rules %v, want [1 2 4]", got)
+	}
+	if got := counterIds(ForwardCounters(filter, "wg9")); got != nil {
+		t.Errorf("error: got wg9 rules %v, want none", got)
+	}
+}
+
+// Testing the rates of two samples: a rule moved to another number keeps
+// its rate, a reset counter yields 0 and a new rule has none.
+func TestRuleRates(t *testing.T) {
+	nat, _ := counterTables(10)
+	prev, _ := NatCounters(nat, "10.10.10.0/24")
+
+	nat, _ = counterTables(30)
+	chain := &nat.Chains[1]
+	chain.Rules[1].Pkts = 2
+	chain.Rules[0].Id, chain.Rules[1].Id = 5, 6
+	chain.Rules = append(chain.Rules, IptablesRule{
+		Id: 7, Pkts: 4, Target: "MASQUERADE", Out: "eth1", Source: "10.10.10.0/24",
+	})
+	cur, _ := NatCounters(nat, "10.10.10.0/24")
+
+	rates := RuleRates(prev, cur, 2*time.Second)
+	if len(rates) != 3 {
+		t.Fatalf("error: got %d rates, want 3", len(rates))
+	}
+
+	type want struct {
+		delta          bool
+		packets, bytes float64
+	}
+	wants := []want{
+		{delta: true, packets: 10, bytes: 1000},
+		{delta: true},
+		{},
+	}
+	for i, w := range wants {
+		r := rates[i]
+		if r.Delta != w.delta || r.Packets != w.packets || r.Bytes != w.bytes {
+			t.Errorf("error: rate %d: got (%v, %v, %v), want (%v, %v, %v)",
+				i, r.Delta, r.Packets, r.Bytes, w.delta, w.packets, w.bytes)
+		}
+	}
+
+	if rates := RuleRates(nil, cur, time.Second); slices.ContainsFunc(rates, func(r RuleRate) bool { return r.Delta }) {
+		t.Errorf("error: got rates without a previous sample: %v", rates)
+	}
+}
+
+// Testing the counter increment and rate helpers.
+func TestCounterDelta(t *testing.T) {
+	if got := CounterDelta(10, 25); got != 15 {
+		t.Errorf("error: got delta %d, want 15", got)
+	}
+	if got := CounterDelta(int64(25), int64(10)); got != 0 {
+		t.Errorf("error: got delta %d after a reset, want 0", got)
+	}
+	if got := Rate(15, 3*time.Second); got != 5 {
+		t.Errorf("error: got rate %v, want 5", got)
+	}
+	if got := Rate(15, 0); got != 0 {
+		t.Errorf("error: got rate %v without elapsed time, want 0", got)
+	}
+}
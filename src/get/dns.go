@@ -0,0 +1,31 @@
+package get
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// Function parses `resolvectl dns <interface>` output into the server
+// addresses, for example:
+//
+//	Link 5 (wg0): 10.10.10.1 10.10.10.2#dns.example.com
+func parseResolvectlDNS(data string) []string {
+	var servers []string
+	for _, line := range strings.Split(data, "\n") {
+		_, list, ok := strings.Cut(line, "):")
+		if !ok {
+			continue
+		}
+		for _, field := range strings.Fields(list) {
+			// Drop the server name and port suffixes (`#name`, `:53`).
+			field, _, _ = strings.Cut(field, "#")
+			if addrPort, err := netip.ParseAddrPort(field); err == nil {
+				field = addrPort.Addr().String()
+			}
+			if _, err := netip.ParseAddr(field); err == nil {
+				servers = append(servers, field)
+			}
+		}
+	}
+	return servers
+}
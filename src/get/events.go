@@ -0,0 +1,203 @@
+package get
+
+import (
+	"time"
+)
+
+// Default silence after which a peer without persistent keepalive is
+// reported down by a PeerTracker.
+const DefaultPeerDownAfter time.Duration = 180 * time.Second
+
+// Number of persistent keepalive intervals without a handshake after which
+// a peer with persistent keepalive is reported down.
+const PeerDownKeepalives = 3
+
+// PeerEventType is the transition of a PeerEvent.
+type PeerEventType string
+
+const (
+	// PeerUp reports a peer coming online: its first handshake, or a
+	// handshake after a gap longer than the down threshold.
+	PeerUp PeerEventType = "up"
+
+	// PeerDown reports a peer going silent for longer than the down
+	// threshold, or removed from the device while online.
+	PeerDown PeerEventType = "down"
+)
+
+// PeerEvent is a connection transition of a peer reported by PeerTracker.
+type PeerEvent struct {
+	PeerID
+
+	// Type is PeerUp or PeerDown.
+	Type PeerEventType
+
+	// AllowedIPs lists the allowed IP networks of the peer in CIDR notation.
+	AllowedIPs []string
+
+	// Endpoint is the peer endpoint (host:port), empty if unknown.
+	Endpoint string
+
+	// Time is the sample time of the transition.
+	Time time.Time
+}
+
+// PeerTracker is the connection state machine of the peers: fed with the
+// samples of the devices (see Sampler), it reports every peer going up or
+// down. A peer is online while its latest handshake is more recent than
+// the down threshold: DownAfter when set, otherwise PeerDownKeepalives
+// times its persistent keepalive interval, or DefaultPeerDownAfter without
+// one. A change of state is reported once it held for Debounce, so a peer
+// flapping around the threshold is not reported on every sample.
+//
+// The first sample sets the initial state of the peers without events.
+// A PeerTracker is not safe for concurrent use.
+//
+// Usage example:
+//
+//	tracker := get.NewPeerTracker(0, 10*time.Second)
+//	err := get.Watch(ctx, 5*time.Second, func(context.Context) error {
+//	    devices, err := sampler.PeerInfo("wg0")
+//	    if err != nil {
+//	        return err
+//	    }
+//	    for _, event := range tracker.Update(devices, time.Now()) {
+//	        // Handle event
+//	    }
+//	    return nil
+//	})
+type PeerTracker struct {
+	// DownAfter is the down threshold, 0 derives it from the keepalive.
+	DownAfter time.Duration
+
+	// Debounce is the time a change of state must hold to be reported.
+	Debounce time.Duration
+
+	peers   map[PeerID]*peerState
+	started bool
+}
+
+// peerState is the tracked state of a peer.
+type peerState struct {
+	up bool
+
+	// pendingSince is the first sample of an unreported change of state,
+	// zero without one.
+	pendingSince time.Time
+}
+
+// Function returns a PeerTracker with the down threshold and the debounce
+// time, see PeerTracker.
+func NewPeerTracker(downAfter, debounce time.Duration) *PeerTracker {
+	return &PeerTracker{
+		DownAfter: downAfter,
+		Debounce:  debounce,
+		peers:     make(map[PeerID]*peerState),
+	}
+}
+
+// Method updates the state of the peers with a sample of the devices taken
+// at now and returns the transitions to report, in the order of the sample.
+func (t *PeerTracker) Update(devices []DeviceInfo, now time.Time) []PeerEvent {
+	if t.peers == nil {
+		t.peers = make(map[PeerID]*peerState)
+	}
+
+	var events []PeerEvent
+	seen := make(map[PeerID]bool)
+
+	for _, d := range devices {
+		for _, p := range d.Peers {
+			id := PeerID{d.Name, p.PublicKey}
+			seen[id] = true
+			up := t.online(p, now)
+
+			state, ok := t.peers[id]
+			if !ok {
+				// A new peer starts down, unless the tracker starts with it.
+				state = &peerState{up: up && !t.started}
+				t.peers[id] = state
+			}
+
+			if up == state.up {
+				state.pendingSince = time.Time{}
+				continue
+			}
+			if state.pendingSince.IsZero() {
+				state.pendingSince = now
+			}
+			if now.Sub(state.pendingSince) < t.Debounce {
+				continue
+			}
+
+			state.up = up
+			state.pendingSince = time.Time{}
+
+			eventType := PeerDown
+			if up {
+				eventType = PeerUp
+			}
+			events = append(events, PeerEvent{
+				PeerID:     id,
+				Type:       eventType,
+				AllowedIPs: p.AllowedIPs,
+				Endpoint:   p.Endpoint,
+				Time:       now,
+			})
+		}
+	}
+
+	// A removed peer is reported down at once when it was online.
+	for id, state := range t.peers {
+		if seen[id] {
+			continue
+		}
+		if state.up {
+			events = append(events, PeerEvent{PeerID: id, Type: PeerDown, Time: now})
+		}
+		delete(t.peers, id)
+	}
+
+	t.started = true
+	return events
+}
+
+// Method reports whether the peer is online, as last reported.
+func (t *PeerTracker) Up(id PeerID) bool {
+	state, ok := t.peers[id]
+	return ok && state.up
+}
+
+// Method returns the reported state of every tracked peer, true for the
+// online ones (e.g., for a peer_up gauge).
+func (t *PeerTracker) States() map[PeerID]bool {
+	states := make(map[PeerID]bool, len(t.peers))
+	for id, state := range t.peers {
+		states[id] = state.up
+	}
+	return states
+}
+
+// Method reports whether the latest handshake of the peer is more recent
+// than its down threshold.
+func (t *PeerTracker) online(p PeerInfo, now time.Time) bool {
+	if p.LastHandshake == "" {
+		return false
+	}
+	handshake, err := time.Parse(time.RFC3339, p.LastHandshake)
+	if err != nil {
+		return false
+	}
+	return now.Sub(handshake) <= t.downAfter(p)
+}
+
+// Method returns the down threshold of the peer.
+func (t *PeerTracker) downAfter(p PeerInfo) time.Duration {
+	switch {
+	case t.DownAfter > 0:
+		return t.DownAfter
+	case p.PersistentKeepalive > 0:
+		return PeerDownKeepalives * time.Duration(p.PersistentKeepalive) * time.Second
+	}
+	return DefaultPeerDownAfter
+}
@@ -0,0 +1,158 @@
+package get
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Function returns a device sample with one peer per key, handshaked at
+// the given times (zero for never).
+func eventSample(keepalive int, handshakes map[string]time.Time) []DeviceInfo {
+	device := DeviceInfo{Name: "wg0"}
+	for _, key := range slices.Sorted(maps.Keys(handshakes)) {
+		peer := PeerInfo{PublicKey: key, PersistentKeepalive: keepalive, AllowedIPs: []string{"10.0.0.2/32"}}
+		if at := handshakes[key]; !at.IsZero() {
+			peer.LastHandshake = at.Format(time.RFC3339)
+		}
+		device.Peers = append(device.Peers, peer)
+	}
+	return []DeviceInfo{device}
+}
+
+// Function formats the events as "key:type" for the comparisons.
+func eventStrings(events []PeerEvent) []string {
+	var out []string
+	for _, e := range events {
+		out = append(out, e.PublicKey+":"+string(e.Type))
+	}
+	return out
+}
+
+// Testing the transitions of the peers sample after sample.
+func TestPeerTracker(t *testing.T) {
+	start := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewPeerTracker(0, 0)
+
+	type step struct {
+		name       string
+		offset     time.Duration
+		keepalive  int
+		handshakes map[string]time.Duration // Handshake offsets, -1 for never.
+		want       []string
+	}
+
+	steps := []step{
+		{
+			name:       "initial state",
+			handshakes: map[string]time.Duration{"a": 0, "b": -1},
+		},
+		{
+			name:       "first handshake",
+			offset:     10 * time.Second,
+			handshakes: map[string]time.Duration{"a": 0, "b": 5 * time.Second},
+			want:       []string{"b:up"},
+		},
+		{
+			name:       "silent past the default threshold",
+			offset:     185 * time.Second,
+			handshakes: map[string]time.Duration{"a": 0, "b": 120 * time.Second},
+			want:       []string{"a:down"},
+		},
+		{
+			name:       "handshake after a gap",
+			offset:     200 * time.Second,
+			handshakes: map[string]time.Duration{"a": 195 * time.Second, "b": 120 * time.Second},
+			want:       []string{"a:up"},
+		},
+		{
+			name:       "keepalive threshold",
+			offset:     240 * time.Second,
+			keepalive:  10,
+			handshakes: map[string]time.Duration{"a": 195 * time.Second, "b": 235 * time.Second},
+			want:       []string{"a:down"},
+		},
+		{
+			name:       "new peer",
+			offset:     250 * time.Second,
+			keepalive:  10,
+			handshakes: map[string]time.Duration{"b": 235 * time.Second, "c": 245 * time.Second},
+			want:       []string{"c:up"},
+		},
+	}
+
+	for _, s := range steps {
+		handshakes := make(map[string]time.Time)
+		for key, offset := range s.handshakes {
+			if offset >= 0 {
+				handshakes[key] = start.Add(offset)
+			} else {
+				handshakes[key] = time.Time{}
+			}
+		}
+
+		got := eventStrings(tracker.Update(eventSample(s.keepalive, handshakes), start.Add(s.offset)))
+		if !slices.Equal(got, s.want) {
+			t.Fatalf("error: %s: got %q, want %q", s.name, got, s.want)
+		}
+	}
+
+	want := map[PeerID]bool{{"wg0", "b"}: true, {"wg0", "c"}: true}
+	if got := tracker.States(); len(got) != len(want) || !got[PeerID{"wg0", "b"}] || !got[PeerID{"wg0", "c"}] {
+		t.Errorf("error: got states %v, want %v", got, want)
+	}
+}
+
+// Testing that a change of state is reported once it held for the
+// debounce time.
+func TestPeerTrackerDebounce(t *testing.T) {
+	start := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewPeerTracker(60*time.Second, 20*time.Second)
+	id := PeerID{"wg0", "a"}
+
+	sample := func(handshake time.Duration) []DeviceInfo {
+		return eventSample(0, map[string]time.Time{"a": start.Add(handshake)})
+	}
+
+	tracker.Update(sample(0), start)
+	if !tracker.Up(id) {
+		t.Fatal("error: want the peer initially up")
+	}
+
+	// Silent past the threshold, then back before the debounce time.
+	if events := tracker.Update(sample(0), start.Add(70*time.Second)); len(events) != 0 {
+		t.Fatalf("error: got %v before the debounce time", events)
+	}
+	if events := tracker.Update(sample(75*time.Second), start.Add(80*time.Second)); len(events) != 0 {
+		t.Fatalf("error: got %v for a flap", events)
+	}
+
+	// Silent again, the change holds for the debounce time.
+	if events := tracker.Update(sample(75*time.Second), start.Add(140*time.Second)); len(events) != 0 {
+		t.Fatalf("error: got %v before the debounce time", events)
+	}
+	events := tracker.Update(sample(75*time.Second), start.Add(160*time.Second))
+	if got := eventStrings(events); !slices.Equal(got, []string{"a:down"}) {
+		t.Fatalf("error: got %q, want the peer down", got)
+	}
+	if tracker.Up(id) {
+		t.Error("error: want the peer down")
+	}
+}
+
+// Testing that a removed online peer is reported down.
+func TestPeerTrackerRemoved(t *testing.T) {
+	now := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewPeerTracker(0, 0)
+
+	tracker.Update(eventSample(0, map[string]time.Time{"a": now, "b": time.Time{}}), now)
+	events := tracker.Update(eventSample(0, map[string]time.Time{}), now.Add(time.Second))
+
+	if got := eventStrings(events); !slices.Equal(got, []string{"a:down"}) {
+		t.Errorf("error: got %q, want only the online peer down", got)
+	}
+	if len(tracker.States()) != 0 {
+		t.Errorf("error: got states %v, want none", tracker.States())
+	}
+}
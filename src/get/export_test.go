@@ -0,0 +1,15 @@
+package get
+
+import (
+	"io"
+	"testing"
+)
+
+// Function makes the generated keys read r until the test ends.
+func UseKeyRand(t *testing.T, r io.Reader) {
+	t.Helper()
+
+	prev := keyRand
+	keyRand = r
+	t.Cleanup(func() { keyRand = prev })
+}
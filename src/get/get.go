@@ -1,16 +1,29 @@
 // Package provides functions for retrieving information about the state of WireGuard nodes,
 // NAT, and Firewall network interfaces.
+//
+// The wgctrl based functions are portable. Functions relying on the ip, iptables
+// and sysctl tools are implemented in get_linux.go and return ErrUnsupported on
+// other platforms.
 package get
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/netip"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/AlexKira/brgnetuse/internal/handlers"
-	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/proc"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
@@ -24,36 +37,38 @@ import (
 // and source/destination addresses, and stores them in the
 // IptablesOutput structure.
 //
+// The lines it cannot interpret (e.g., a rule with too few fields, a rule
+// before any chain, a malformed counter) are left out and listed in the
+// Warnings of the result, see ParseWarning. The chains and their rules
+// are returned in a fixed order, see SortChains.
+//
 // Returns:
 //   - IptablesOutput: A structure representing the parsed iptables data.
 //   - error: An error if parsing fails, or nil if successful.
 func parseIptablesOutput(output string) (IptablesOutput, error) {
 	var result IptablesOutput
 
-	parseInt := func(s string) int {
-		var num int
-		_, err := fmt.Sscanf(s, "%d", &num)
-		if err != nil {
-			return 0
-		}
-		return num
-	}
-
 	lines := strings.Split(output, "\n")
 	var currentChain *IptablesChain
 
 	ruleIdCounter := uint64(1)
 
-	for _, line := range lines {
+	for indx, line := range lines {
 		line = strings.TrimSpace(line)
 
 		if line == "" || strings.HasPrefix(line, "pkts") {
 			continue
 		}
 
-		if strings.HasPrefix(line, "Chain ") {
+		// Function records the line as not interpreted.
+		skip := func(reason string) {
+			result.Warnings = append(result.Warnings, ParseWarning{Line: indx + 1, Text: line, Reason: reason})
+		}
+
+		if line == "Chain" || strings.HasPrefix(line, "Chain ") {
 			parts := strings.Fields(line)
 			if len(parts) < 2 {
+				skip("invalid chain header")
 				continue
 			}
 
@@ -61,49 +76,130 @@ func parseIptablesOutput(output string) (IptablesOutput, error) {
 			chain := IptablesChain{Name: chainName}
 
 			if len(parts) >= 7 && parts[2] == "(policy" {
+				packets, okPackets := parseCounter(parts[4])
+				bytes, okBytes := parseCounter(strings.TrimSuffix(parts[6], ")"))
+				if !okPackets || !okBytes {
+					skip("invalid counter")
+					currentChain = nil
+					continue
+				}
 				chain.Policy = parts[3]
-				chain.Packets = parseInt(parts[4])
-				chain.Bytes = parseInt(strings.TrimSuffix(parts[6], ")"))
-			} else if len(parts) >= 4 && strings.Contains(parts[2], "references") {
-				refStr := strings.TrimPrefix(parts[2], "(")
-				refStr = strings.TrimSuffix(refStr, "references)")
-				chain.References = parseInt(refStr)
+				chain.Packets, chain.Bytes = packets, bytes
+			} else if len(parts) >= 4 && parts[3] == "references)" {
+				// A user-defined chain: "Chain DOCKER (2 references)".
+				chain.References, _ = strconv.Atoi(strings.TrimPrefix(parts[2], "("))
 			}
 
 			result.Chains = append(result.Chains, chain)
 			currentChain = &result.Chains[len(result.Chains)-1]
-		} else if currentChain != nil {
-			parts := strings.Fields(line)
-			if len(parts) >= 8 {
-				rule := IptablesRule{
-					Id:          ruleIdCounter,
-					Pkts:        parseInt(parts[0]),
-					Bytes:       parseInt(parts[1]),
-					Target:      parts[2],
-					Prot:        parts[3],
-					Opt:         parts[4],
-					In:          parts[5],
-					Out:         parts[6],
-					Source:      parts[7],
-					Destination: parts[8],
-				}
+			continue
+		}
 
-				if len(parts) >= 9 {
-					rule.Options = strings.Join(parts[9:], " ")
-				}
+		if currentChain == nil {
+			skip("rule outside a chain")
+			continue
+		}
 
-				currentChain.Rules = append(currentChain.Rules, rule)
-				ruleIdCounter++
-			}
+		parts := strings.Fields(line)
+		if len(parts) < 9 {
+			skip("too few fields")
+			continue
 		}
+
+		pkts, okPkts := parseCounter(parts[0])
+		bytes, okBytes := parseCounter(parts[1])
+		if !okPkts || !okBytes {
+			skip("invalid counter")
+			continue
+		}
+
+		rule := IptablesRule{
+			Id:          ruleIdCounter,
+			Pkts:        pkts,
+			Bytes:       bytes,
+			Target:      parts[2],
+			Prot:        parts[3],
+			Opt:         parts[4],
+			In:          parts[5],
+			Out:         parts[6],
+			Source:      parts[7],
+			Destination: parts[8],
+		}
+
+		if len(parts) > 9 {
+			rule.Options = strings.Join(parts[9:], " ")
+			rule.Comment = parseRuleComment(rule.Options)
+		}
+
+		currentChain.Rules = append(currentChain.Rules, rule)
+		ruleIdCounter++
 	}
 
+	SortChains(result.Chains)
 	return result, nil
 }
 
+// Function parses a counter of an iptables listing. Without -x, iptables
+// abbreviates the large counters with a K, M, G or T suffix (powers of
+// 1000, e.g., "12K").
+func parseCounter(value string) (int, bool) {
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1_000
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1_000_000
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1_000_000_000
+	case strings.HasSuffix(value, "T"):
+		multiplier = 1_000_000_000_000
+	}
+	if multiplier != 1 {
+		value = value[:len(value)-1]
+	}
+
+	num, err := strconv.Atoi(value)
+	if err != nil || num < 0 {
+		return 0, false
+	}
+	return num * multiplier, true
+}
+
+// Function extracts the comment shown as "/* text */" in the options of a
+// listed rule.
+func parseRuleComment(options string) string {
+	_, after, ok := strings.Cut(options, "/* ")
+	if !ok {
+		return ""
+	}
+	comment, _, ok := strings.Cut(after, " */")
+	if !ok {
+		return ""
+	}
+	return comment
+}
+
+// Method returns the network interface of a rule tagged by this tool (see
+// firewall.RuleTag). A rule tagged without an interface (the INPUT port rule)
+// returns an empty name; untagged rules return false.
+func (r IptablesRule) Owner() (string, bool) {
+	if r.Comment == firewall.RuleTagPrefix {
+		return "", true
+	}
+	owner, ok := strings.CutPrefix(r.Comment, firewall.RuleTagPrefix+":")
+	if !ok {
+		return "", false
+	}
+	return owner, true
+}
+
 // Function for сhecking network interface.
 func GetExistInterface(name string) (bool, error) {
-	interfaceName, err := net.Interfaces()
+	var interfaceName []net.Interface
+	err := netns.Do(func() (err error) {
+		interfaceName, err = net.Interfaces()
+		return err
+	})
 	if err != nil {
 		return false, fmt.Errorf(
 			"error: failed to get network interfaces: %s",
@@ -119,125 +215,179 @@ func GetExistInterface(name string) (bool, error) {
 	return false, nil
 }
 
-// GetIpNetInterface finds the IP addresses of the network interface with the given name.
+// Function describes an existing network interface: its link kind and the
+// userspace process serving it. A missing interface yields Exists false.
 //
-// The 'name' argument is the interface name (e.g., "eth0").
+// Usage example:
 //
-// Returns:
-//   - The number of IP addresses found (int).
-//   - A slice of net.Addr containing the IP addresses.
-//   - An error (error) if a problem occurred or the interface was not found (nil on success).
-func GetIpNetInterface(name string) (int, []net.Addr, error) {
-	netIface, err := net.Interfaces()
-	if err != nil {
-		return -1, nil, fmt.Errorf(
-			"error: failed to get network interfaces. %v", err.Error())
+//	owner, err := get.GetInterfaceOwner("wg0")
+//	if err != nil {
+//	    // Handle error
+//	}
+//	if owner.Exists && !owner.Managed() {
+//	    fmt.Printf("wg0 is a %s\n", owner.Describe())
+//	}
+func GetInterfaceOwner(name string) (InterfaceOwner, error) {
+	owner := InterfaceOwner{Name: name}
+
+	exists, err := GetExistInterface(name)
+	if err != nil || !exists {
+		return owner, err
 	}
-	for _, iface := range netIface {
+	owner.Exists = true
 
-		ipSlice, err := iface.Addrs()
-		if err != nil {
-			return -1, nil, fmt.Errorf(
-				"error: failed to get IP address for interface '%s'. %s",
-				iface.Name,
-				err.Error(),
-			)
-		}
+	owner.Kind, err = GetLinkKind(name)
+	if err != nil {
+		return owner, err
+	}
 
-		if iface.Name == name {
-			return len(ipSlice), ipSlice, nil
-		}
+	owner.Pid, owner.Process, err = proc.FindProcess(name)
+	if err != nil {
+		return owner, err
 	}
 
-	return -1, nil, fmt.Errorf(
-		"error: network interface '%s' not found", name,
-	)
+	return owner, nil
 }
 
-// Function generates key pair (private and public).
-// It returns a map containing the keys, or an error if generation fails.
-// The map keys are "private" and "public".
-func GenerateKeys() (map[string]wgtypes.Key, error) {
-	privateKey, err := wgtypes.GeneratePrivateKey()
+// Method reports whether the interface is a WireGuard or AmneziaWG device
+// that may be recreated: one served by a brgaddwg/brgaddawg process, or a
+// kernel device.
+func (o InterfaceOwner) Managed() bool {
+	return o.Pid != 0 || o.Kind == "wireguard" || o.Kind == "amneziawg"
+}
 
-	keysMap := map[string]wgtypes.Key{
-		"private": privateKey,
-		"public":  privateKey.PublicKey(),
+// Method describes the interface type for messages
+// (e.g., "userspace WireGuard device, brgaddwg process 4242").
+func (o InterfaceOwner) Describe() string {
+	switch {
+	case o.Pid != 0 && o.Process == "awg":
+		return fmt.Sprintf("userspace AmneziaWG device, brgaddawg process %d", o.Pid)
+	case o.Pid != 0:
+		return fmt.Sprintf("userspace WireGuard device, brgaddwg process %d", o.Pid)
+	case o.Kind == "wireguard":
+		return "kernel WireGuard device"
+	case o.Kind == "amneziawg":
+		return "kernel AmneziaWG device"
+	case o.Kind == "tun":
+		return "TUN device without a brgnetuse process"
+	case o.Kind != "":
+		return o.Kind + " device"
+	default:
+		return "physical network interface"
 	}
-
-	return keysMap, err
 }
 
-// Function retrieves information about network interfaces and their IP addresses.
-// It executes the 'ip -j addr' command and returns a slice of IpInterfaceStructure.
-func GetIp() ([]IpInterfaceStructure, error) {
-	output, err := shell.ShellCommandOutput(shell.IpJSON)
-	if err != nil {
-		return nil, err
+// Function returns the addresses, flags and MTU of the network interface
+// with the given name (e.g., "eth0"). A missing interface returns an error
+// matching ErrInterfaceNotFound.
+func GetInterfaceAddrs(name string) (InterfaceAddrs, error) {
+	var iface *net.Interface
+	var addrs []net.Addr
+	err := netns.Do(func() (err error) {
+		if iface, err = net.InterfaceByName(name); err != nil {
+			return err
+		}
+		addrs, err = iface.Addrs()
+		if err != nil {
+			err = fmt.Errorf(
+				"error: failed to get IP address for interface '%s'. %v", name, err,
+			)
+		}
+		return err
+	})
+	if iface == nil {
+		// The net package does not export its lookup errors.
+		if name == "" || strings.Contains(err.Error(), "no such network interface") {
+			return InterfaceAddrs{}, &InterfaceNotFoundError{Name: name}
+		}
+		return InterfaceAddrs{}, fmt.Errorf(
+			"error: failed to get network interface '%s'. %v", name, err,
+		)
 	}
 
-	jsonData := output.Bytes()
-
-	var interfaces []IpInterfaceStructure
-	err = json.Unmarshal(jsonData, &interfaces)
 	if err != nil {
-		return nil, fmt.Errorf("error: failed to unmarshal JSON, %v", err)
+		return InterfaceAddrs{}, err
 	}
 
-	return interfaces, nil
-}
-
-// Function retrieves IP address information for a specific network interface.
-// It executes the 'ip -j link show' command and returns a slice of IpInterfaceStructure.
-func GetIpShow(interfaceName string) ([]IpInterfaceStructure, error) {
-	output, err := shell.ShellCommandOutput(shell.FormatCmdIpShowJSON(interfaceName))
-	if err != nil {
-		return nil, err
+	info := InterfaceAddrs{
+		Name:  iface.Name,
+		Index: iface.Index,
+		Flags: iface.Flags,
+		MTU:   iface.MTU,
+		Addrs: make([]net.IPNet, 0, len(addrs)),
 	}
-
-	jsonData := output.Bytes()
-
-	var interfaces []IpInterfaceStructure
-	err = json.Unmarshal(jsonData, &interfaces)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"error: failed to unmarshal JSON for interface '%s', %v",
-			interfaceName,
-			err,
-		)
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			info.Addrs = append(info.Addrs, *ipNet)
+		}
 	}
-
-	return interfaces, nil
+	return info, nil
 }
 
-// Function retrieves and parses the output of the iptables command.
-// It returns an IptablesOutput structure representing the firewall rules.
-func GetIptablesFirewall() (IptablesOutput, error) {
-	output, err := shell.ShellCommandOutput(shell.IptablesFirewall)
+// GetIpNetInterface finds the IP addresses of the network interface with the given name.
+//
+// The 'name' argument is the interface name (e.g., "eth0").
+//
+// Returns:
+//   - The number of IP addresses found (int).
+//   - A slice of net.Addr containing the IP addresses.
+//   - An error (error) if a problem occurred or the interface was not found (nil on success).
+//
+// Deprecated: Use GetInterfaceAddrs, which returns the parsed prefixes.
+func GetIpNetInterface(name string) (int, []net.Addr, error) {
+	info, err := GetInterfaceAddrs(name)
 	if err != nil {
-		return IptablesOutput{}, err
+		return -1, nil, err
 	}
 
-	iptablesOutput, err := parseIptablesOutput(output.String())
-	if err != nil {
-		return IptablesOutput{}, fmt.Errorf("error: %s", err.Error())
+	addrs := make([]net.Addr, len(info.Addrs))
+	for i := range info.Addrs {
+		addrs[i] = &info.Addrs[i]
 	}
-	return iptablesOutput, nil
+	return len(addrs), addrs, nil
 }
 
-// Function retrieves and parses the output of the iptables NAT table.
-// It returns an IptablesOutput structure representing the NAT rules.
-func GetIptablesNAT() (IptablesOutput, error) {
-	output, err := shell.ShellCommandOutput(shell.IptablesNat)
-	if err != nil {
-		return IptablesOutput{}, err
+// Source of randomness of the generated keys, crypto/rand. Replaced in
+// tests to generate known keys, see UseKeyRand in export_test.go.
+var keyRand io.Reader = rand.Reader
+
+// Function generates a key pair (private and public) from crypto/rand.
+// On failure it returns the zero KeyPair and the error, so a caller
+// ignoring the error has no key to distribute.
+//
+// Usage example:
+//
+//	keys, err := get.GenerateKeyPair()
+//	if err != nil {
+//	    // Handle error
+//	}
+//	defer keys.Zero()
+//	fmt.Println(keys.Public)
+func GenerateKeyPair() (KeyPair, error) {
+	var private wgtypes.Key
+	if _, err := io.ReadFull(keyRand, private[:]); err != nil {
+		clear(private[:])
+		return KeyPair{}, fmt.Errorf("error: failed to generate a private key: %v", err)
 	}
 
-	iptablesOutput, err := parseIptablesOutput(output.String())
+	// Clamped as wgtypes.GeneratePrivateKey does (RFC 7748).
+	private[0] &= 248
+	private[31] = (private[31] & 127) | 64
+
+	return KeyPair{Private: private, Public: private.PublicKey()}, nil
+}
+
+// Function generates key pair (private and public).
+// It returns a map containing the keys, or an error if generation fails.
+// The map keys are "private" and "public". On failure the map is nil.
+//
+// Deprecated: Use GenerateKeyPair, which returns a KeyPair.
+func GenerateKeys() (map[string]wgtypes.Key, error) {
+	keys, err := GenerateKeyPair()
 	if err != nil {
-		return IptablesOutput{}, fmt.Errorf("error: %s", err.Error())
+		return nil, err
 	}
-	return iptablesOutput, nil
+	return keys.Map(), nil
 }
 
 // FilterIptablesOutput is the top-level structure that encapsulates the parsed
@@ -249,63 +399,78 @@ type FilterIptablesOutput struct {
 }
 
 // Method retrieves a specific iptables rule by its ID.
-// It iterates through the chains and their rules, and if a rule with the given
-// ID is found, it returns a new IptablesOutput containing only that rule.
-// If no rule with the specified ID is found, it returns an empty IptablesOutput
-// and an error. The search for the rule is performed based on the 'Id' field of
-// the IptablesRule. The function operates on a copy of the FilterIptablesOutput
-// to avoid modifying the original data.
+// It searches every chain for a rule with the given ID and returns a new
+// IptablesOutput holding only the chain of that rule (header included) with
+// that single rule. If no rule with the specified ID is found, it returns an
+// empty IptablesOutput and an error. The search is performed on the 'Id'
+// field of the IptablesRule, so it does not depend on the order of the
+// chains or on empty chains. The original data is not modified.
 func (p *FilterIptablesOutput) GetRuleId(id int) (IptablesOutput, error) {
 
 	if id <= 0 {
 		return IptablesOutput{}, fmt.Errorf("error: rule 'id:%d' must be > 0", id)
 	}
 
-	copied := *p
-	copied.Rule.Chains = make([]IptablesChain, len(p.Rule.Chains))
-
-	var currentTableRules []IptablesRule
-	var foundChainIndex int = -1
-
-	for indx, value := range p.Rule.Chains {
-
-		if len(value.Rules) == 0 {
-			continue
+	for _, chain := range p.Rule.Chains {
+		for _, rule := range chain.Rules {
+			if rule.Id == uint64(id) {
+				found := chain
+				found.Rules = []IptablesRule{rule}
+				return IptablesOutput{Chains: []IptablesChain{found}}, nil
+			}
 		}
+	}
 
-		if uint64(id) <= p.Rule.Chains[indx].Rules[len(p.Rule.Chains[indx].Rules)-1].Id {
-			copied.Rule.Chains[indx].Name = value.Name
-			copied.Rule.Chains[indx].Bytes = value.Bytes
-			copied.Rule.Chains[indx].Policy = value.Policy
-			copied.Rule.Chains[indx].Packets = value.Packets
+	return IptablesOutput{}, fmt.Errorf("error: rule 'id:%d' not found", id)
+}
 
-			foundChainIndex = indx
-			currentTableRules = value.Rules
-			break
+// Method retrieves a chain by its name (e.g., FORWARD, POSTROUTING).
+// The returned chain is a copy, its rules may be empty.
+// Returns an error if no chain with the specified name exists.
+func (p *FilterIptablesOutput) GetChain(name string) (IptablesChain, error) {
+	for _, chain := range p.Rule.Chains {
+		if chain.Name == name {
+			chain.Rules = slices.Clone(chain.Rules)
+			return chain, nil
 		}
-
 	}
 
-	if foundChainIndex == -1 {
-		return IptablesOutput{}, fmt.Errorf("error: rule 'id:%d' not found", id)
-	}
+	return IptablesChain{}, fmt.Errorf("error: chain '%s' not found", name)
+}
 
-	for _, rule := range currentTableRules {
-		if rule.Id == uint64(id) {
-			copied.Rule.Chains[foundChainIndex].Rules = append(
-				copied.Rule.Chains[foundChainIndex].Rules, rule)
-		}
-	}
+// Method returns the rules with the specified target (e.g., ACCEPT,
+// MASQUERADE), keeping all chain headers. The comparison is case-sensitive,
+// as iptables targets and user-defined chains are.
+func (p *FilterIptablesOutput) FilterByTarget(target string) IptablesOutput {
+	return p.filterRules(func(rule IptablesRule) bool {
+		return rule.Target == target
+	})
+}
 
-	return copied.Rule, nil
+// Method returns the rules with the specified comment (e.g., a tag of
+// firewall.RuleTag), keeping all chain headers. An empty comment returns the
+// rules without a comment.
+func (p *FilterIptablesOutput) FilterByComment(comment string) IptablesOutput {
+	return p.filterRules(func(rule IptablesRule) bool {
+		return rule.Comment == comment
+	})
+}
 
+// Method returns the number of rules in all chains.
+func (p *FilterIptablesOutput) CountRules() int {
+	var count int
+	for _, chain := range p.Rule.Chains {
+		count += len(chain.Rules)
+	}
+	return count
 }
 
 // Method checks if an iptables rule with the specified input interface,
 // output interface, and source subnet exists within the FilterIptablesOutput.
 // It iterates over all chains and their rules, looking for a rule where the input
-// interface matches (or is "any"), the output interface matches, and the source subnet
-// matches (or is "0.0.0.0/0") the given parameters.
+// interface matches (or is "any", "*" in the verbose listing), the output
+// interface matches, and the source subnet matches (or is "0.0.0.0/0") the
+// given parameters.
 // Returns true if such a rule is found, false otherwise. Returns an error if the subnetCIDR is invalid.
 func (p *FilterIptablesOutput) GetExistingRules(inIface, outIface, subnetCIDR string) (bool, error) {
 
@@ -317,7 +482,7 @@ func (p *FilterIptablesOutput) GetExistingRules(inIface, outIface, subnetCIDR st
 	for _, chain := range p.Rule.Chains {
 		for _, existingRule := range chain.Rules {
 
-			inMatch := existingRule.In == inIface || existingRule.In == "any"
+			inMatch := existingRule.In == inIface || existingRule.In == "any" || existingRule.In == "*"
 			outMatch := existingRule.Out == outIface
 			subnetMatch := existingRule.Source == subnetCIDR || existingRule.Source == "0.0.0.0/0"
 
@@ -355,39 +520,115 @@ func (p *FilterIptablesOutput) GetExistingPort(port string) (bool, error) {
 	return false, nil
 }
 
-// Function retrieves the IPv4 and IPv6 forwarding status from sysctl.
-//
-// It executes sysctl commands to check the values of "net.ipv4.ip_forward" and
-// "net.ipv6.conf.all.forwarding". The function returns a map where the keys are
-// "ipv4" and "ipv6", and the values are integers representing the forwarding status
-// (1 for enabled, 0 for disabled). An error is returned if any issue occurs during
-// command execution or parsing of the output.
-func GetIPvForwarding() (map[string]int, error) {
-	sysctlMap := make(map[string]int)
-	cmdSlice := [2]string{shell.SysctlIpv4Check, shell.SysctlIpv6Check}
-
-	keys := []string{"ipv4", "ipv6"}
-
-	for i, cmd := range cmdSlice {
-		output, err := shell.ShellCommandOutput(cmd)
-		if err != nil {
-			return nil, err
-		}
+// Method returns the INPUT rules accepting the UDP port, see
+// firewall.FormatCmdPortIn, arriving on the input interface in. An empty
+// in selects the rules of any interface only, so the rule scoped to an
+// interface and the one of any interface are told apart for the same port.
+// An error is returned if the port is not a number.
+func (p *FilterIptablesOutput) GetPortRules(port, in string) ([]IptablesRule, error) {
+	if _, err := strconv.Atoi(port); err != nil {
+		return nil, fmt.Errorf("error: port must be a number, %v", err)
+	}
 
-		parts := strings.SplitN(strings.TrimSpace(output.String()), "=", 2)
-		if len(parts) != 2 {
-			return nil, fmt.Errorf("error: invalid sysctl output: %s", output.String())
+	if in == "" {
+		in = "*"
+	}
+	dport := "dpt:" + port
+
+	var rules []IptablesRule
+	for _, chain := range p.Rule.Chains {
+		if chain.Name != "INPUT" {
+			continue
+		}
+		for _, rule := range chain.Rules {
+			if rule.Target == "ACCEPT" && rule.Prot == "udp" && rule.In == in &&
+				slices.Contains(strings.Fields(rule.Options), dport) {
+				rules = append(rules, rule)
+			}
 		}
+	}
+	return rules, nil
+}
 
-		value, err := strconv.Atoi(strings.TrimSpace(parts[1]))
-		if err != nil {
-			return nil, fmt.Errorf("error: invalid sysctl value: %s", parts[1])
+// Method reports whether an INPUT rule accepts the UDP port arriving on
+// the input interface in, any interface when empty, see GetPortRules.
+func (p *FilterIptablesOutput) GetExistingPortIn(port, in string) (bool, error) {
+	rules, err := p.GetPortRules(port, in)
+	return len(rules) > 0, err
+}
+
+// Method returns the rules bound to a network interface, keeping all chain
+// headers. A rule matches when its input interface equals in or its output
+// interface equals out; an empty argument is not matched. Wildcard interfaces
+// ("*", "any") never match, as such rules are not specific to an interface.
+//
+// FORWARD rules carry both interfaces, while POSTROUTING (MASQUERADE) rules
+// only carry the output interface, so FilterByInterface("wg0", "wg0") covers
+// both the filter and the NAT table.
+func (p *FilterIptablesOutput) FilterByInterface(in, out string) IptablesOutput {
+	// Function checks a concrete (not wildcard) interface name.
+	concrete := func(name string) bool {
+		return name != "" && name != "*" && name != "any"
+	}
+
+	return p.filterRules(func(rule IptablesRule) bool {
+		return (concrete(in) && rule.In == in) || (concrete(out) && rule.Out == out)
+	})
+}
+
+// Method returns the rules whose source lies within the subnet, keeping all
+// chain headers. A host source (e.g., "10.10.10.5") is handled as a /32 or
+// /128 prefix, and rules with any source ("0.0.0.0/0", "anywhere") do not
+// match unless the subnet itself is the default route.
+// Returns an error if cidr is not a valid prefix.
+func (p *FilterIptablesOutput) FilterBySource(cidr string) (IptablesOutput, error) {
+	subnet, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return IptablesOutput{}, fmt.Errorf("error: invalid IP address format: %s", cidr)
+	}
+	subnet = subnet.Masked()
+
+	return p.filterRules(func(rule IptablesRule) bool {
+		source, ok := parseRuleAddress(rule.Source)
+		return ok && source.Bits() >= subnet.Bits() && subnet.Contains(source.Addr())
+	}), nil
+}
+
+// Method copies the chains with only the rules accepted by match, and the
+// parse warnings.
+func (p *FilterIptablesOutput) filterRules(match func(IptablesRule) bool) IptablesOutput {
+	result := IptablesOutput{
+		Chains:   make([]IptablesChain, 0, len(p.Rule.Chains)),
+		Warnings: p.Rule.Warnings,
+	}
+
+	for _, chain := range p.Rule.Chains {
+		filtered := chain
+		filtered.Rules = nil
+		for _, rule := range chain.Rules {
+			if match(rule) {
+				filtered.Rules = append(filtered.Rules, rule)
+			}
 		}
+		result.Chains = append(result.Chains, filtered)
+	}
 
-		sysctlMap[keys[i]] = value
+	return result
+}
+
+// Function parses an address of an iptables listing as a prefix.
+func parseRuleAddress(value string) (netip.Prefix, bool) {
+	if value == "anywhere" {
+		return netip.MustParsePrefix("0.0.0.0/0"), true
 	}
 
-	return sysctlMap, nil
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		return prefix.Masked(), true
+	}
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), true
+	}
+	return netip.Prefix{}, false
 }
 
 // Function retrieves WireGuard device information.
@@ -417,20 +658,322 @@ func GetPeer(interfaceName string) ([]*wgtypes.Device, error) {
 	}
 	defer newClient.Close()
 
-	var devices []*wgtypes.Device
+	return readDevices(newClient, interfaceName)
+}
 
+// Function reads one device, or every device when interfaceName is empty,
+// through an open wgctrl client.
+func readDevices(client handlers.WgClient, interfaceName string) ([]*wgtypes.Device, error) {
 	if interfaceName != "" {
-		device, err := newClient.Device(interfaceName)
-		if err != nil {
-			return nil, fmt.Errorf("error: failed to get device %q, %v", interfaceName, err)
+		device, err := client.Device(interfaceName)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, &InterfaceNotFoundError{Name: interfaceName}
 		}
-		devices = append(devices, device)
-	} else {
-		devices, err = newClient.Devices()
 		if err != nil {
-			return nil, fmt.Errorf("error: failed to get devices, %v", err)
+			return nil, &DeviceError{Interface: interfaceName, Op: "read", Err: err}
 		}
+		return []*wgtypes.Device{device}, nil
 	}
 
+	devices, err := client.Devices()
+	if err != nil {
+		return nil, fmt.Errorf("error: failed to get devices, %w", err)
+	}
 	return devices, nil
 }
+
+// Function retrieves the peer metadata (name, note) of the network interface,
+// keyed by the peer public key (base64 encoded). An interface without
+// metadata returns an empty map.
+//
+// Usage example:
+//
+//	meta, err := get.GetPeerMeta("wg0")
+//	if err != nil {
+//	    // Handle error
+//	}
+//
+//	fmt.Println(meta[peer.PublicKey.String()].Name)
+func GetPeerMeta(interfaceName string) (map[string]PeerMeta, error) {
+	if interfaceName == "" {
+		return nil, fmt.Errorf("error: failed to get Wireguard network interface name")
+	}
+
+	store, err := peermeta.Load(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Function retrieves the last change of the configuration of the network
+// interface made by brgsetwg. An interface without a recorded change
+// returns the zero value.
+//
+// Usage example:
+//
+//	change, err := get.GetInterfaceChange("wg0")
+//	if err != nil {
+//	    // Handle error
+//	}
+//
+//	fmt.Println("last modified", change)
+func GetInterfaceChange(interfaceName string) (InterfaceChange, error) {
+	if interfaceName == "" {
+		return InterfaceChange{}, fmt.Errorf("error: failed to get Wireguard network interface name")
+	}
+	return peermeta.LoadChange(interfaceName)
+}
+
+// Function converts a wgtypes.Device into its JSON friendly form.
+// The peer metadata fields are left empty, see GetPeerInfo.
+func FromWgDevice(d *wgtypes.Device) DeviceInfo {
+	info := DeviceInfo{
+		Name:         d.Name,
+		Type:         d.Type.String(),
+		PublicKey:    d.PublicKey.String(),
+		ListenPort:   d.ListenPort,
+		FirewallMark: d.FirewallMark,
+		Peers:        make([]PeerInfo, 0, len(d.Peers)),
+	}
+
+	for _, p := range d.Peers {
+		peer := PeerInfo{
+			PublicKey:           p.PublicKey.String(),
+			PresharedKey:        p.PresharedKey != (wgtypes.Key{}),
+			PersistentKeepalive: int(p.PersistentKeepaliveInterval.Seconds()),
+			ReceiveBytes:        p.ReceiveBytes,
+			TransmitBytes:       p.TransmitBytes,
+			AllowedIPs:          make([]string, 0, len(p.AllowedIPs)),
+			ProtocolVersion:     p.ProtocolVersion,
+		}
+
+		if p.Endpoint != nil {
+			peer.Endpoint = p.Endpoint.String()
+		}
+		if !p.LastHandshakeTime.IsZero() {
+			peer.LastHandshake = p.LastHandshakeTime.UTC().Format(time.RFC3339)
+		}
+		for _, ipn := range p.AllowedIPs {
+			peer.AllowedIPs = append(peer.AllowedIPs, ipn.String())
+		}
+
+		info.Peers = append(info.Peers, peer)
+	}
+
+	return info
+}
+
+// Function retrieves WireGuard device information in the JSON friendly form,
+// with the peer metadata (name, note, expiry) merged in.
+// If interfaceName is specified, only that interface is returned; a
+// userspace AmneziaWG interface, which wgctrl cannot read, is read with awg.
+//
+// Usage example:
+//
+//	devices, err := get.GetPeerInfo("wg0")
+//	if err != nil {
+//	    // Handle error
+//	}
+//
+//	data, _ := json.MarshalIndent(devices, "", "  ")
+//	fmt.Println(string(data))
+func GetPeerInfo(interfaceName string) ([]DeviceInfo, error) {
+	devices, err := GetPeer(interfaceName)
+	if err != nil {
+		if info, ok := awgPeerInfo(interfaceName); ok {
+			return []DeviceInfo{info}, nil
+		}
+		return nil, err
+	}
+
+	return deviceInfo(devices)
+}
+
+// Function reads a userspace AmneziaWG device, which wgctrl cannot read,
+// with awg (see GetAwgPeerInfo). It reports false for every other
+// interface, or when awg fails too.
+func awgPeerInfo(interfaceName string) (DeviceInfo, bool) {
+	if interfaceName == "" {
+		return DeviceInfo{}, false
+	}
+	backend, err := GetInterfaceBackend(interfaceName)
+	if err != nil || backend != BackendUserspaceAWG {
+		return DeviceInfo{}, false
+	}
+
+	info, err := GetAwgPeerInfo(interfaceName)
+	if err != nil {
+		return DeviceInfo{}, false
+	}
+	if change, err := GetInterfaceChange(interfaceName); err == nil && !change.IsZero() {
+		info.LastChange = &change
+	}
+	info.Backend = backend.String()
+	info.Implementation = backendImplementation(interfaceName, backend)
+	return info, true
+}
+
+// Function converts devices into their JSON friendly form and merges in
+// the peer metadata, the last change, the backend and its implementation.
+// An unreadable last change record is left out rather than failing the
+// listing. The devices and peers are ordered, see SortDevices.
+func deviceInfo(devices []*wgtypes.Device) ([]DeviceInfo, error) {
+	result := make([]DeviceInfo, 0, len(devices))
+	for _, d := range devices {
+		info := FromWgDevice(d)
+		if err := mergePeerMeta(&info); err != nil {
+			return nil, err
+		}
+		if change, err := GetInterfaceChange(d.Name); err == nil && !change.IsZero() {
+			info.LastChange = &change
+		}
+		if backend := deviceBackend(d); backend != BackendUnknown {
+			info.Backend = backend.String()
+			info.Implementation = backendImplementation(d.Name, backend)
+		}
+
+		result = append(result, info)
+	}
+
+	SortDevices(result)
+	return result, nil
+}
+
+// Function merges the peer metadata (name, note, expiry, quota) into the
+// device.
+func mergePeerMeta(info *DeviceInfo) error {
+	meta, err := GetPeerMeta(info.Name)
+	if err != nil {
+		return err
+	}
+
+	for i := range info.Peers {
+		entry := meta[info.Peers[i].PublicKey]
+		info.Peers[i].Name = entry.Name
+		info.Peers[i].Note = entry.Note
+		if !entry.Expires.IsZero() {
+			info.Peers[i].Expires = entry.Expires.Format(time.RFC3339)
+		}
+		if quota := entry.Quota; !quota.IsZero() {
+			info.Peers[i].Quota = &QuotaInfo{
+				Limit:   quota.Limit,
+				Used:    quota.Used,
+				Percent: quota.Percent(),
+				Period:  quota.Period,
+			}
+			if !quota.Reset.IsZero() {
+				info.Peers[i].Quota.Reset = quota.Reset.Format(time.RFC3339)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Function parses the output of `awg show <interface> dump` (the format of
+// `wg show <interface> dump`) into the JSON friendly form. The private
+// key on the interface line is skipped. awg lists the obfuscation
+// parameters between the listen port and the firewall mark, their number
+// depends on its version: the firewall mark is read from the last field
+// and the parameters are left to parseAwgShow. Peer metadata is not merged.
+func parseWgDump(interfaceName, output string) (DeviceInfo, error) {
+	info := DeviceInfo{Name: interfaceName, Type: "userspace", Peers: []PeerInfo{}}
+
+	for indx, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Split(line, "\t")
+
+		if indx == 0 {
+			if len(fields) < 4 {
+				return DeviceInfo{}, fmt.Errorf("error: invalid dump of interface '%s'", interfaceName)
+			}
+			info.PublicKey = fields[1]
+			info.ListenPort, _ = strconv.Atoi(fields[2])
+			info.FirewallMark = parseFirewallMark(fields[len(fields)-1])
+			continue
+		}
+
+		if len(fields) < 8 {
+			return DeviceInfo{}, fmt.Errorf(
+				"error: invalid peer line %d in dump of interface '%s'", indx, interfaceName,
+			)
+		}
+
+		peer := PeerInfo{
+			PublicKey:    fields[0],
+			PresharedKey: fields[1] != "(none)",
+			AllowedIPs:   []string{},
+		}
+		if fields[2] != "(none)" {
+			peer.Endpoint = fields[2]
+		}
+		if fields[3] != "(none)" {
+			peer.AllowedIPs = strings.Split(fields[3], ",")
+		}
+		if sec, err := strconv.ParseInt(fields[4], 10, 64); err == nil && sec > 0 {
+			peer.LastHandshake = time.Unix(sec, 0).UTC().Format(time.RFC3339)
+		}
+		peer.ReceiveBytes, _ = strconv.ParseInt(fields[5], 10, 64)
+		peer.TransmitBytes, _ = strconv.ParseInt(fields[6], 10, 64)
+		peer.PersistentKeepalive, _ = strconv.Atoi(fields[7])
+
+		info.Peers = append(info.Peers, peer)
+	}
+
+	return info, nil
+}
+
+// Function returns the firewall mark of a dump, "off" (0) or a decimal or
+// hexadecimal ("0x1") number.
+func parseFirewallMark(field string) int {
+	mark, err := strconv.ParseUint(field, 0, 32)
+	if err != nil {
+		return 0
+	}
+	return int(mark)
+}
+
+// Function parses the obfuscation parameters of the interface section of
+// `awg show <interface>` ("  jc: 4"), nil when none is listed (e.g., a
+// WireGuard device). Unknown keys are ignored.
+func parseAwgShow(output string) (*AwgParams, error) {
+	var params AwgParams
+	ints := map[string]*int{
+		"jc": &params.Jc, "jmin": &params.Jmin, "jmax": &params.Jmax,
+		"s1": &params.S1, "s2": &params.S2, "s3": &params.S3, "s4": &params.S4,
+	}
+	texts := map[string]*string{
+		"h1": &params.H1, "h2": &params.H2, "h3": &params.H3, "h4": &params.H4,
+		"i1": &params.I1, "i2": &params.I2, "i3": &params.I3, "i4": &params.I4, "i5": &params.I5,
+	}
+
+	found := false
+	for _, line := range strings.Split(output, "\n") {
+		// The peer sections follow the interface section.
+		if strings.HasPrefix(line, "peer:") {
+			break
+		}
+
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		if field, ok := ints[key]; ok {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("error: invalid AmneziaWG parameter %s '%s'", key, value)
+			}
+			*field, found = number, true
+		} else if field, ok := texts[key]; ok {
+			*field, found = value, true
+		}
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return &params, nil
+}
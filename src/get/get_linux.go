@@ -0,0 +1,342 @@
+package get
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/command"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/proc"
+)
+
+// Function retrieves information about network interfaces and their IP addresses.
+// It executes the 'ip -j addr' command and returns a slice of IpInterfaceStructure.
+func GetIp() ([]IpInterfaceStructure, error) {
+	output, err := shell.DefaultRunner.Output(shell.IpJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonData := output.Bytes()
+
+	var interfaces []IpInterfaceStructure
+	err = json.Unmarshal(jsonData, &interfaces)
+	if err != nil {
+		return nil, fmt.Errorf("error: failed to unmarshal JSON, %v", err)
+	}
+
+	return interfaces, nil
+}
+
+// Function retrieves IP address information for a specific network interface.
+// It executes the 'ip -j addr show' command and returns a slice of IpInterfaceStructure.
+//
+// An empty name lists all the network interfaces, as GetIp does. A missing
+// interface returns an error matching ErrInterfaceNotFound; a missing `ip`
+// an error matching exec.ErrNotFound, and any other failure of the command
+// a *command.CommandError.
+func GetIpShow(interfaceName string) ([]IpInterfaceStructure, error) {
+	if interfaceName == "" {
+		return GetIp()
+	}
+
+	output, err := shell.DefaultRunner.Output(shell.FormatCmdIpShowJSON(interfaceName))
+	if err != nil {
+		if deviceNotFound(err) {
+			return nil, &InterfaceNotFoundError{Name: interfaceName}
+		}
+		return nil, err
+	}
+
+	jsonData := output.Bytes()
+
+	var interfaces []IpInterfaceStructure
+	err = json.Unmarshal(jsonData, &interfaces)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"error: failed to unmarshal JSON for interface '%s', %v",
+			interfaceName,
+			err,
+		)
+	}
+
+	return interfaces, nil
+}
+
+// Function reports whether the failed `ip` command reported a missing
+// device (`Device "wg0" does not exist.`, exit status 1).
+func deviceNotFound(err error) bool {
+	var cmdErr *command.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.ExitCode == 1 &&
+		strings.Contains(cmdErr.Output, "does not exist")
+}
+
+// Function retrieves the link kind (e.g., "tun", "wireguard") of a network
+// interface. It executes the 'ip -d -j link show' command, an empty kind
+// is returned for a physical interface.
+func GetLinkKind(interfaceName string) (string, error) {
+	output, err := shell.DefaultRunner.Output(shell.FormatCmdIpLinkDetailJSON(interfaceName))
+	if err != nil {
+		return "", err
+	}
+
+	var links []LinkDetailStructure
+	if err := json.Unmarshal(output.Bytes(), &links); err != nil {
+		return "", fmt.Errorf(
+			"error: failed to unmarshal JSON for interface '%s', %v",
+			interfaceName,
+			err,
+		)
+	}
+	if len(links) == 0 {
+		return "", &InterfaceNotFoundError{Name: interfaceName}
+	}
+
+	return links[0].LinkInfo.Kind, nil
+}
+
+// Function lists the names of the WireGuard and AmneziaWG devices: kernel
+// devices and TUN devices served by a brgaddwg/brgaddawg process.
+func GetWireGuardDevices() ([]string, error) {
+	output, err := shell.DefaultRunner.Output(shell.IpLinkDetailJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []LinkDetailStructure
+	if err := json.Unmarshal(output.Bytes(), &links); err != nil {
+		return nil, fmt.Errorf("error: failed to unmarshal JSON of network interfaces, %v", err)
+	}
+
+	devices := []string{}
+	for _, link := range links {
+		switch link.LinkInfo.Kind {
+		case "wireguard", "amneziawg":
+			devices = append(devices, link.IfName)
+		case "tun":
+			pid, _, err := proc.FindProcess(link.IfName)
+			if err != nil {
+				return nil, err
+			}
+			if pid != 0 {
+				devices = append(devices, link.IfName)
+			}
+		}
+	}
+	return devices, nil
+}
+
+// Function retrieves the tc configuration of the per-peer rate limiting of
+// a network interface (see TrafficLimits). Classes and filters are only
+// read when the htb root qdisc of the rate limiting is installed.
+//
+// Usage example:
+//
+//	limits, err := get.GetTrafficLimits("wg0")
+//	if err != nil {
+//	    // Handle error
+//	}
+//	if class, ok := limits.PeerClass([]string{"10.0.0.2/32"}); ok {
+//	    fmt.Println(class.Rate)
+//	}
+func GetTrafficLimits(interfaceName string) (TrafficLimits, error) {
+	limits := TrafficLimits{Interface: interfaceName}
+
+	output, err := shell.DefaultRunner.Output(shell.FormatCmdTcQdiscShowJSON(interfaceName))
+	if err != nil {
+		return limits, err
+	}
+	if err := parseTcQdiscs(output.Bytes(), &limits); err != nil {
+		return limits, err
+	}
+
+	if limits.Installed() {
+		output, err = shell.DefaultRunner.Output(shell.FormatCmdTcClassShow(interfaceName))
+		if err != nil {
+			return limits, err
+		}
+		if err := parseTcClasses(output.String(), &limits); err != nil {
+			return limits, err
+		}
+
+		output, err = shell.DefaultRunner.Output(shell.FormatCmdTcFilterShowJSON(interfaceName, shell.TcRootHandle))
+		if err != nil {
+			return limits, err
+		}
+		if err := parseTcFilters(output.Bytes(), shell.TcRootHandle, &limits); err != nil {
+			return limits, err
+		}
+	}
+
+	if limits.Ingress {
+		output, err = shell.DefaultRunner.Output(shell.FormatCmdTcFilterShowJSON(interfaceName, shell.TcIngressHandle))
+		if err != nil {
+			return limits, err
+		}
+		if err := parseTcFilters(output.Bytes(), shell.TcIngressHandle, &limits); err != nil {
+			return limits, err
+		}
+	}
+
+	return limits, nil
+}
+
+// Function retrieves and parses the output of the iptables command.
+// It returns an IptablesOutput structure representing the firewall rules.
+func GetIptablesFirewall() (IptablesOutput, error) {
+	output, err := shell.DefaultRunner.Output(firewall.CmdList)
+	if err != nil {
+		return IptablesOutput{}, err
+	}
+
+	iptablesOutput, err := parseIptablesOutput(output.String())
+	if err != nil {
+		return IptablesOutput{}, fmt.Errorf("error: %s", err.Error())
+	}
+	return iptablesOutput, nil
+}
+
+// Function retrieves and parses the output of the iptables NAT table.
+// It returns an IptablesOutput structure representing the NAT rules.
+func GetIptablesNAT() (IptablesOutput, error) {
+	output, err := shell.DefaultRunner.Output(firewall.CmdListNat)
+	if err != nil {
+		return IptablesOutput{}, err
+	}
+
+	iptablesOutput, err := parseIptablesOutput(output.String())
+	if err != nil {
+		return IptablesOutput{}, fmt.Errorf("error: %s", err.Error())
+	}
+	return iptablesOutput, nil
+}
+
+// Function retrieves and parses the output of iptables-save.
+// It returns an IptablesSave structure with the rules of all tables.
+func GetIptablesSave() (IptablesSave, error) {
+	output, err := shell.DefaultRunner.Output(firewall.CmdSave)
+	if err != nil {
+		return IptablesSave{}, err
+	}
+	return ParseIptablesSave(output.String())
+}
+
+// Function retrieves the IPv4 and IPv6 forwarding status from sysctl.
+//
+// It executes sysctl commands to check the values of "net.ipv4.ip_forward" and
+// "net.ipv6.conf.all.forwarding". The function returns a map where the keys are
+// "ipv4" and "ipv6", and the values are integers representing the forwarding status
+// (1 for enabled, 0 for disabled). An error is returned if any issue occurs during
+// command execution or parsing of the output.
+func GetIPvForwarding() (map[string]int, error) {
+	sysctlMap := make(map[string]int)
+	cmdSlice := [2]string{shell.SysctlIpv4Check, shell.SysctlIpv6Check}
+
+	keys := []string{"ipv4", "ipv6"}
+
+	for i, cmd := range cmdSlice {
+		output, err := shell.DefaultRunner.Output(cmd)
+		if err != nil {
+			return nil, err
+		}
+
+		parts := strings.SplitN(strings.TrimSpace(output.String()), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("error: invalid sysctl output: %s", output.String())
+		}
+
+		value, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("error: invalid sysctl value: %s", parts[1])
+		}
+
+		sysctlMap[keys[i]] = value
+	}
+
+	return sysctlMap, nil
+}
+
+// Function retrieves the AmneziaWG device information in the JSON friendly
+// form. AmneziaWG devices are not visible to wgctrl, so it parses the output
+// of 'awg show <interface> dump', the obfuscation parameters of
+// 'awg show <interface>', and merges in the peer metadata.
+func GetAwgPeerInfo(interfaceName string) (DeviceInfo, error) {
+	output, err := shell.DefaultRunner.Output(shell.FormatCmdAwgShowDump(interfaceName))
+	if err != nil {
+		return DeviceInfo{}, err
+	}
+	defer clear(output.Bytes())
+
+	info, err := parseWgDump(interfaceName, output.String())
+	if err != nil {
+		return DeviceInfo{}, err
+	}
+
+	show, err := shell.DefaultRunner.Output(shell.FormatCmdAwgShow(interfaceName))
+	if err != nil {
+		return DeviceInfo{}, err
+	}
+	if info.AwgParams, err = parseAwgShow(show.String()); err != nil {
+		return DeviceInfo{}, err
+	}
+
+	if err := mergePeerMeta(&info); err != nil {
+		return DeviceInfo{}, err
+	}
+	sortPeersByKey(info.Peers)
+	return info, nil
+}
+
+// Function detects the DNS backend: systemd-resolved when `resolvectl` can
+// reach it, otherwise resolvconf when installed.
+func GetDNSBackend() (string, error) {
+	if _, err := shell.DefaultRunner.Output(shell.FormatCmdResolvectlDNS("", nil)); err == nil {
+		return DNSBackendResolved, nil
+	}
+	if _, err := shell.DefaultRunner.Output(shell.FormatCmdWhich("resolvconf")); err == nil {
+		return DNSBackendResolvconf, nil
+	}
+	return "", fmt.Errorf("error: no DNS backend found, install systemd-resolved (resolvectl) or resolvconf")
+}
+
+// Function retrieves the DNS servers of a network interface. With
+// systemd-resolved they are read from the link, resolvconf cannot list
+// them portably so the servers set by brgsetwg are reported.
+//
+// Usage example:
+//
+//	dns, err := get.GetInterfaceDNS("wg0")
+//	if err != nil {
+//	    // Handle error
+//	}
+//	fmt.Println(dns.Backend, dns.Servers)
+func GetInterfaceDNS(interfaceName string) (InterfaceDNS, error) {
+	dns := InterfaceDNS{Interface: interfaceName}
+
+	backend, err := GetDNSBackend()
+	if err != nil {
+		return dns, err
+	}
+	dns.Backend = backend
+
+	if backend == DNSBackendResolvconf {
+		meta, err := peermeta.LoadInterface(interfaceName)
+		if err != nil {
+			return dns, err
+		}
+		dns.Servers = meta.DNS
+		return dns, nil
+	}
+
+	output, err := shell.DefaultRunner.Output(shell.FormatCmdResolvectlDNS(interfaceName, nil))
+	if err != nil {
+		return dns, err
+	}
+	dns.Servers = parseResolvectlDNS(output.String())
+	return dns, nil
+}
@@ -0,0 +1,717 @@
+package get
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/internal/shellmock"
+	"github.com/AlexKira/brgnetuse/src/command"
+)
+
+// Testing the GetIp function.
+func TestGetIP(t *testing.T) {
+	t.Run("GetIp", func(t *testing.T) {
+		t.Log("--------------------------------------")
+		t.Log("Run test")
+
+		data, err := GetIp()
+		if err != nil {
+			t.Fatal("error GetIp: ", err)
+		}
+
+		for _, get := range data {
+			t.Logf("info: data on network interface '%s' received", get.IfName)
+		}
+
+		t.Log("End test")
+		t.Log("--------------------------------------")
+	})
+
+}
+
+// Testing that GetWireGuardDevices lists kernel devices and the TUN devices
+// served by a brgaddwg/brgaddawg process only.
+func TestGetWireGuardDevices(t *testing.T) {
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.IpLinkDetailJSON] = `[{"ifname":"lo","linkinfo":{}},
+		{"ifname":"wg0","linkinfo":{"info_kind":"wireguard"}},
+		{"ifname":"tun-brgnetuse-test","linkinfo":{"info_kind":"tun"}},
+		{"ifname":"awg0","linkinfo":{"info_kind":"amneziawg"}},
+		{"ifname":"veth0","linkinfo":{"info_kind":"veth"}}]`
+
+	devices, err := GetWireGuardDevices()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !slices.Equal(devices, []string{"wg0", "awg0"}) {
+		t.Errorf("error: got %v, want wg0 and awg0", devices)
+	}
+}
+
+// Testing that GetIpShow reports the alias of the network interface.
+func TestGetIpShowAlias(t *testing.T) {
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.FormatCmdIpShowJSON("wg0")] = `[{"ifindex":3,"ifname":"wg0","ifalias":"office vpn","addr_info":[]},
+		{"ifindex":4,"ifname":"wg1","addr_info":[]}]`
+
+	data, err := GetIpShow("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(data) != 2 || data[0].IfAlias != "office vpn" || data[1].IfAlias != "" {
+		t.Errorf("error: got %+v", data)
+	}
+}
+
+// Testing the results of GetIpShow: an existing interface, a missing one,
+// a failing `ip` and the empty name listing all the interfaces.
+func TestGetIpShowResults(t *testing.T) {
+	notFound := &command.CommandError{Output: "Device \"qwerty\" does not exist.\n", ExitCode: 1, Err: errors.New("exit status 1")}
+	failed := &command.CommandError{Output: "Error: Permission denied\n", ExitCode: 2, Err: errors.New("exit status 2")}
+
+	type testCase struct {
+		name     string
+		input    string
+		output   string
+		err      error
+		wantCmd  string
+		wantLen  int
+		wantErr  error
+		wantType bool
+	}
+
+	tests := []testCase{
+		{name: "existing", input: "wg0", output: `[{"ifname":"wg0"}]`, wantCmd: shell.FormatCmdIpShowJSON("wg0"), wantLen: 1},
+		{name: "empty name", input: "", output: `[{"ifname":"lo"},{"ifname":"wg0"}]`, wantCmd: shell.IpJSON, wantLen: 2},
+		{name: "missing", input: "qwerty", err: notFound, wantCmd: shell.FormatCmdIpShowJSON("qwerty"), wantErr: ErrInterfaceNotFound},
+		{name: "ip failure", input: "wg0", err: failed, wantCmd: shell.FormatCmdIpShowJSON("wg0"), wantType: true},
+		{
+			name: "ip absent", input: "wg0", err: fmt.Errorf("runtime error: command 'ip' not found: %w", exec.ErrNotFound),
+			wantCmd: shell.FormatCmdIpShowJSON("wg0"), wantErr: exec.ErrNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := shellmock.Install(t)
+			if tc.output != "" {
+				fake.Outputs[tc.wantCmd] = tc.output
+			}
+			if tc.err != nil {
+				fake.Errors[tc.wantCmd] = tc.err
+			}
+
+			data, err := GetIpShow(tc.input)
+			if !slices.Equal(fake.Commands, []string{tc.wantCmd}) {
+				t.Errorf("error: got commands %q, want %q", fake.Commands, tc.wantCmd)
+			}
+
+			switch {
+			case tc.wantErr != nil:
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("error: got %v, want %v", err, tc.wantErr)
+				}
+				if tc.wantErr != ErrInterfaceNotFound && errors.Is(err, ErrInterfaceNotFound) {
+					t.Fatalf("error: %v reported as a missing interface", err)
+				}
+			case tc.wantType:
+				var cmdErr *command.CommandError
+				if !errors.As(err, &cmdErr) || errors.Is(err, ErrInterfaceNotFound) {
+					t.Fatalf("error: got %v, want a command failure", err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				if len(data) != tc.wantLen {
+					t.Errorf("error: got %d interfaces, want %d", len(data), tc.wantLen)
+				}
+			}
+		})
+	}
+}
+
+// Testing the GetIpShow function.
+func TestGetIpShow(t *testing.T) {
+	type testCase struct {
+		input     string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{input: "lo", wantError: false},
+		{input: "", wantError: false},
+		{input: "qwerty", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			t.Log("--------------------------------------")
+			t.Logf("Run test: %s", tc.input)
+
+			data, err := GetIpShow(tc.input)
+
+			if tc.wantError {
+				if !errors.Is(err, ErrInterfaceNotFound) {
+					t.Errorf("expected ErrInterfaceNotFound for input '%s', got %v", tc.input, err)
+				} else {
+					t.Logf("expected error received for '%s': %v", tc.input, err)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("unexpected error for input '%s': %v", tc.input, err)
+				} else {
+					t.Logf("info: received %d data items for '%s'", len(data), tc.input)
+				}
+			}
+
+			t.Logf("End test: %s", tc.input)
+			t.Log("--------------------------------------")
+		})
+	}
+}
+
+// Testing the GetIptablesFirewall function.
+func TestGetIptablesFirewall(t *testing.T) {
+	t.Run("GetIptablesFirewall", func(t *testing.T) {
+		t.Log("--------------------------------------")
+		t.Log("Run test")
+
+		data, err := GetIptablesFirewall()
+		if err != nil {
+			t.Fatal("error GetIptablesFirewall: ", err)
+		}
+
+		t.Logf("info: %d firewall data received: ", len(data.Chains))
+
+		t.Log("End test")
+		t.Log("--------------------------------------")
+	})
+}
+
+// Testing the GetIptablesNA function.
+func TestGetIptablesNAT(t *testing.T) {
+	t.Run("GetIptablesNAT", func(t *testing.T) {
+		t.Log("--------------------------------------")
+		t.Log("Run test")
+
+		data, err := GetIptablesNAT()
+		if err != nil {
+			t.Fatal("error GetIptablesNAT: ", err)
+		}
+		t.Logf("info: received number of NAT rules: %d", len(data.Chains))
+
+		t.Log("End test")
+		t.Log("--------------------------------------")
+	})
+}
+
+// Testing the GetRuleId method of the firewall's FilterIptablesOutput structure.
+func TestFirewallGetRuleId(t *testing.T) {
+	type testCase struct {
+		name      string
+		input     int
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "func: GetRuleId", input: 12, wantError: true},
+		{name: "func: GetRuleId", input: 1, wantError: true},
+		{name: "func: GetRuleId", input: 7, wantError: true},
+		{name: "func: GetRuleId", input: 10, wantError: true},
+		{name: "func: GetRuleId", input: -100, wantError: true},
+		{name: "func: GetRuleId", input: 100, wantError: true},
+		{name: "func: GetRuleId", input: 0, wantError: true},
+		{name: "func: GetRuleId", input: 1000000000, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Log("--------------------------------------")
+			t.Logf("Run test: %s, input: %d", tc.name, tc.input)
+
+			getData, err := GetIptablesFirewall()
+			if err != nil {
+				t.Fatalf("error: GetIptablesFirewall failed during setup for input=%d: %v", tc.input, err)
+			}
+			if len(getData.Chains) == 0 {
+				t.Fatal("error: add rules to firewall table to start test")
+			}
+
+			obj := FilterIptablesOutput{getData}
+			data, err := obj.GetRuleId(tc.input)
+
+			if tc.wantError {
+				if err == nil {
+					t.Logf("info: received data: %v\n", data)
+				} else {
+					t.Logf("error: expected error %v\n", err)
+				}
+			} else {
+				if err == nil {
+					t.Logf("info: received data: %v\n", data)
+				} else {
+					t.Errorf("error: test failed, %v\n", err)
+				}
+			}
+
+			t.Logf("End test: %s, input: %d", tc.name, tc.input)
+			t.Log("--------------------------------------")
+		})
+	}
+}
+
+// Test function for testing the GetExistingRules function for firewall.
+func TestFirewallGetExistingRules(t *testing.T) {
+	type testCase struct {
+		inIface    string
+		outIface   string
+		subnetCIDR string
+		wantError  bool
+	}
+	tests := []testCase{
+		{inIface: "wg3", outIface: "enp0s3", subnetCIDR: "10.10.10.0/24", wantError: true}, // Rule added to Firewall table.
+		{inIface: "qwerty", outIface: "enp0s3", subnetCIDR: "10.10.10.0/24", wantError: true},
+		{inIface: "*", outIface: "lo", subnetCIDR: "0.0.0.0/0", wantError: false},
+		{inIface: "*", outIface: "*", subnetCIDR: "0.0.0.0/0", wantError: false},
+		{inIface: "lo", outIface: "lo", subnetCIDR: "0.0.0.0/0", wantError: true},
+		{inIface: "", outIface: "enp0s3", subnetCIDR: "10.10.10.0/24", wantError: true},
+		{inIface: "wg0", outIface: "", subnetCIDR: "10.10.10.0/24", wantError: true},
+		{inIface: "wg0", outIface: "enp0s3", subnetCIDR: "10.10.10.0", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run("Firewall", func(t *testing.T) {
+			t.Log("--------------------------------------")
+			t.Logf("Run test GetExistingRules: inIface=%q, outIface=%q, subnetCIDR=%q", tc.inIface, tc.outIface, tc.subnetCIDR)
+
+			getData, err := GetIptablesFirewall()
+			if err != nil {
+				t.Fatalf("error: failed to get iptables firewall data: %v", err)
+			}
+			if len(getData.Chains) == 0 {
+				t.Fatal("error: no chains found in firewall table; please add rules before running the test")
+			}
+
+			obj := FilterIptablesOutput{getData}
+			fwExist, err := obj.GetExistingRules(tc.inIface, tc.outIface, tc.subnetCIDR)
+			if tc.wantError {
+				if err == nil {
+					t.Logf("info: test passed, the port exists: %t", fwExist)
+				} else {
+					t.Logf("error: test failed, %v", err)
+				}
+			} else {
+				if err == nil {
+					t.Logf("info: test passed, the port exists: %t", fwExist)
+				} else {
+					t.Fatalf("error: test failed, %v", err)
+				}
+			}
+
+			t.Logf("End test GetExistingRules: inIface=%q, outIface=%q, subnetCIDR=%q", tc.inIface, tc.outIface, tc.subnetCIDR)
+			t.Log("--------------------------------------")
+		})
+	}
+}
+
+// Testing the GetRuleId method of the NAT's FilterIptablesOutput structure.
+func TestNATGetRuleId(t *testing.T) {
+	type testCase struct {
+		name      string
+		input     int
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "func: GetRuleId", input: 12, wantError: true},
+		{name: "func: GetRuleId", input: 1, wantError: true},
+		{name: "func: GetRuleId", input: 2, wantError: true},
+		{name: "func: GetRuleId", input: 3, wantError: true},
+		{name: "func: GetRuleId", input: -100, wantError: true},
+		{name: "func: GetRuleId", input: 100, wantError: true},
+		{name: "func: GetRuleId", input: 0, wantError: true},
+		{name: "func: GetRuleId", input: 1000000000, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Log("--------------------------------------")
+			t.Logf("Run test: %s, input: %d", tc.name, tc.input)
+
+			getData, err := GetIptablesNAT()
+			if err != nil {
+				t.Fatalf("error: GetIptablesNAT failed during setup for input=%d: %v", tc.input, err)
+			}
+			if len(getData.Chains) == 0 {
+				t.Fatal("error: add rules to nat table to start test")
+			}
+
+			obj := FilterIptablesOutput{getData}
+			data, err := obj.GetRuleId(tc.input)
+
+			if tc.wantError {
+				if err == nil {
+					t.Logf("info: received data: %v\n", data)
+				} else {
+					t.Logf("error: expected error %v\n", err)
+				}
+			} else {
+				if err == nil {
+					t.Logf("info: received data: %v\n", data)
+				} else {
+					t.Errorf("error: test failed, %v\n", err)
+				}
+			}
+
+			t.Logf("End test: %s, input: %d", tc.name, tc.input)
+			t.Log("--------------------------------------")
+		})
+	}
+}
+
+// Test function for testing the GetExistingRules function for NAT.
+func TestNatGetExistingRules(t *testing.T) {
+	type testCase struct {
+		inIface    string
+		outIface   string
+		subnetCIDR string
+		wantError  bool
+	}
+	tests := []testCase{
+		{
+			inIface: "wg0", outIface: "enp0s3", subnetCIDR: "10.10.10.0/24", wantError: true,
+		}, // Rule added to nat table.
+		{inIface: "qwerty", outIface: "enp0s3", subnetCIDR: "10.10.10.0/24", wantError: true},
+		{inIface: "wg0", outIface: "enp0s3", subnetCIDR: "101.0.0.0/24", wantError: true},
+		{inIface: "", outIface: "enp0s3", subnetCIDR: "10.10.10.0/24", wantError: true},
+		{inIface: "wg0", outIface: "", subnetCIDR: "10.10.10.0/24", wantError: true},
+		{inIface: "wg0", outIface: "enp0s3", subnetCIDR: "10.10.10.0", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run("NAT", func(t *testing.T) {
+			t.Log("--------------------------------------")
+			t.Logf("Run test GetExistingRules: inIface=%q, outIface=%q, subnetCIDR=%q", tc.inIface, tc.outIface, tc.subnetCIDR)
+
+			getData, err := GetIptablesNAT()
+			if err != nil {
+				t.Fatalf("error: failed to get iptables nat data: %v", err)
+			}
+			if len(getData.Chains) == 0 {
+				t.Fatal("error: no chains found in nat table; please add rules before running the test")
+			}
+
+			obj := FilterIptablesOutput{getData}
+			_, err = obj.GetExistingRules(tc.inIface, tc.outIface, tc.subnetCIDR)
+
+			if tc.wantError {
+				if err != nil {
+					t.Logf("info: expected error, test passed, %v", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("error: test failed, %v", err)
+				}
+			}
+
+			t.Log("info: test successful")
+
+			t.Logf("End test GetExistingRules: inIface=%q, outIface=%q, subnetCIDR=%q", tc.inIface, tc.outIface, tc.subnetCIDR)
+			t.Log("--------------------------------------")
+		})
+	}
+}
+
+// Test function for testing the GetExistingPort function for NAT.
+func TestGetExistingPort(t *testing.T) {
+	type testCase struct {
+		port      string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{port: "22", wantError: false},
+		{port: "80", wantError: false},
+		{port: "43601", wantError: true},
+		{port: "port", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run("NAT", func(t *testing.T) {
+			t.Log("--------------------------------------")
+			t.Logf("Run test GetExistingPort: %s", tc.port)
+
+			getData, err := GetIptablesFirewall()
+			if err != nil {
+				t.Fatalf("error: failed to get iptables nat data: %v", err)
+			}
+			if len(getData.Chains) == 0 {
+				t.Fatal(
+					"error: no chains found in nat table; please add rules before running the test",
+				)
+			}
+
+			obj := FilterIptablesOutput{getData}
+			portExist, err := obj.GetExistingPort(tc.port)
+
+			if tc.wantError {
+				if err == nil {
+					t.Logf("info: test passed, the port exists: %t", portExist)
+				} else {
+					t.Logf("error: test failed, %v", err)
+				}
+			} else {
+				if err == nil {
+					t.Logf("info: test passed, the port exists: %t", portExist)
+				} else {
+					t.Fatalf("error: test failed, %v", err)
+				}
+			}
+
+			t.Log("info: test successful")
+			t.Logf("End test GetExistingPort: %s", tc.port)
+			t.Log("--------------------------------------")
+		})
+	}
+
+}
+
+// Testing the GetIPvForwarding function.
+func TestGetIPvForwarding(t *testing.T) {
+	t.Run("GetIPvForwarding", func(t *testing.T) {
+		t.Log("--------------------------------------")
+		t.Log("Run test")
+
+		data, err := GetIPvForwarding()
+		if err != nil {
+			t.Fatal("error GetIp: ", err)
+		}
+
+		if len(data) == 0 {
+			t.Errorf("error: no IPv forwarding data received (length=0)")
+		} else {
+			t.Logf("info: received IPv forwarding data, length=%d", len(data))
+		}
+
+		t.Log("End test")
+		t.Log("--------------------------------------")
+	})
+
+}
+
+// Testing the GetLinkKind function against scripted `ip -d -j link` output.
+func TestGetLinkKind(t *testing.T) {
+	type testCase struct {
+		name      string
+		output    string
+		want      string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "tun", output: `[{"ifname":"wg0","linkinfo":{"info_kind":"tun","info_data":{"type":"tun"}}}]`, want: "tun"},
+		{name: "kernel", output: `[{"ifname":"wg0","linkinfo":{"info_kind":"wireguard"}}]`, want: "wireguard"},
+		{name: "physical", output: `[{"ifname":"wg0"}]`, want: ""},
+		{name: "empty", output: `[]`, wantError: true},
+		{name: "invalid", output: `qwerty`, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := shellmock.Install(t)
+			fake.Outputs[shell.FormatCmdIpLinkDetailJSON("wg0")] = tc.output
+
+			got, err := GetLinkKind("wg0")
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the GetTrafficLimits function.
+func TestGetTrafficLimits(t *testing.T) {
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.FormatCmdTcQdiscShowJSON("wg0")] = `[` +
+		`{"kind":"htb","handle":"1:","root":true,"refcnt":2,"options":{"r2q":10,"default":"0"}},` +
+		`{"kind":"ingress","handle":"ffff:","parent":"ffff:fff1","options":{}}]`
+	fake.Outputs[shell.FormatCmdTcClassShow("wg0")] = "" +
+		"class htb 1:10 root prio 0 rate 10Mbit ceil 10Mbit burst 1600b cburst 1600b \n" +
+		"class htb 1:11 root prio 0 rate 1500Kbit ceil 1500Kbit burst 1600b cburst 1600b \n"
+	fake.Outputs[shell.FormatCmdTcFilterShowJSON("wg0", shell.TcRootHandle)] = `[` +
+		`{"protocol":"ip","pref":1,"kind":"flower","chain":0},` +
+		`{"protocol":"ip","pref":1,"kind":"flower","chain":0,"options":{"handle":1,"classid":"1:10","keys":{"dst_ip":"10.0.0.2"}}},` +
+		`{"protocol":"ipv6","pref":2,"kind":"flower","chain":0,"options":{"handle":"0x2","classid":"1:11","keys":{"dst_ip":"fd00::3"}}}]`
+	fake.Outputs[shell.FormatCmdTcFilterShowJSON("wg0", shell.TcIngressHandle)] = `[` +
+		`{"protocol":"ip","pref":1,"kind":"flower","chain":0,"options":{"handle":1,"classid":"1:10","keys":{"src_ip":"10.0.0.2"}}}]`
+
+	limits, err := GetTrafficLimits("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !limits.Installed() || !limits.Ingress {
+		t.Fatalf("error: qdiscs not detected: %+v", limits)
+	}
+
+	class, ok := limits.PeerClass([]string{"10.0.0.2/32"})
+	if !ok || class.ClassID != "1:10" || class.Rate != 10_000_000 {
+		t.Errorf("error: got class %+v, want 1:10 at 10mbit", class)
+	}
+	class, ok = limits.PeerClass([]string{"fd00::3/128"})
+	if !ok || class.ClassID != "1:11" || class.Rate != 1_500_000 {
+		t.Errorf("error: got class %+v, want 1:11 at 1500kbit", class)
+	}
+	if _, ok := limits.PeerClass([]string{"10.0.0.9/32"}); ok {
+		t.Error("error: unexpected class of an unlimited peer")
+	}
+
+	filters := limits.ClassFilters("1:10")
+	if len(filters) != 2 || filters[1].Parent != shell.TcIngressHandle || filters[1].Handle != 1 {
+		t.Errorf("error: got filters %+v, want egress and ingress", filters)
+	}
+	if got := limits.NextClassID(); got != "1:12" {
+		t.Errorf("error: got next class %q, want %q", got, "1:12")
+	}
+}
+
+// Testing the GetTrafficLimits function without the rate limiting.
+func TestGetTrafficLimitsDefault(t *testing.T) {
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.FormatCmdTcQdiscShowJSON("wg0")] = `[{"kind":"noqueue","handle":"0:","root":true,"refcnt":2,"options":{}}]`
+
+	limits, err := GetTrafficLimits("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if limits.Installed() || limits.Ingress || limits.RootHandle != "0:" {
+		t.Errorf("error: got %+v, want the default root qdisc", limits)
+	}
+	if len(fake.Commands) != 1 {
+		t.Errorf("error: got commands %q, want the qdisc listing only", fake.Commands)
+	}
+	if got := limits.NextClassID(); got != "1:10" {
+		t.Errorf("error: got next class %q, want %q", got, "1:10")
+	}
+}
+
+// Testing the GetInterfaceDNS function with systemd-resolved.
+func TestGetInterfaceDNS(t *testing.T) {
+	type testCase struct {
+		name   string
+		output string
+		want   []string
+	}
+
+	tests := []testCase{
+		{name: "servers", output: "Link 5 (wg0): 10.10.10.1 fd00::1\n", want: []string{"10.10.10.1", "fd00::1"}},
+		{name: "server names", output: "Link 5 (wg0): 10.10.10.1#dns.example.com [fd00::1]:53\n", want: []string{"10.10.10.1", "fd00::1"}},
+		{name: "empty", output: "Link 5 (wg0):\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := shellmock.Install(t)
+			fake.Outputs[shell.FormatCmdResolvectlDNS("wg0", nil)] = tc.output
+
+			dns, err := GetInterfaceDNS("wg0")
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if dns.Backend != DNSBackendResolved || !slices.Equal(dns.Servers, tc.want) {
+				t.Errorf("error: got %+v, want %q through systemd-resolved", dns, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the operations inside a network namespace (-netns): a bridge is
+// created and addressed through the runner, found by the lookups of the
+// namespace, and the host namespace is left untouched. The namespace is
+// created with `ip netns add`, which requires root.
+func TestNetnsOperations(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating a network namespace requires root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("the ip command is not installed")
+	}
+
+	name := "brgnetuse-test-" + strconv.Itoa(os.Getpid())
+	if out, err := exec.Command("ip", "netns", "add", name).CombinedOutput(); err != nil {
+		t.Skipf("failed to create a network namespace: %v, %s", err, out)
+	}
+	t.Cleanup(func() { exec.Command("ip", "netns", "del", name).Run() })
+
+	prevName, prevMeta := netns.Name, peermeta.Dir
+	prevRunner, prevClient := shell.DefaultRunner, handlers.NewWgClient
+	restore := func() {
+		netns.Name, peermeta.Dir = prevName, prevMeta
+		shell.DefaultRunner, handlers.NewWgClient = prevRunner, prevClient
+	}
+	t.Cleanup(restore)
+
+	if err := shell.UseNamespace(name); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	const iface, addr = "brgns0", "10.99.0.1/24"
+	for _, cmd := range []string{
+		"ip link add " + iface + " type bridge",
+		shell.FormatCmdIpAddrDev(iface, addr, shell.IpAdd),
+	} {
+		if err := shell.DefaultRunner.Run(cmd, false); err != nil {
+			t.Skipf("failed to set up the namespace: %v", err)
+		}
+	}
+
+	exists, err := GetExistInterface(iface)
+	if err != nil || !exists {
+		t.Errorf("error: GetExistInterface in the namespace: %t, %v", exists, err)
+	}
+	info, err := GetInterfaceAddrs(iface)
+	if err != nil || len(info.Addrs) != 1 || info.Addrs[0].String() != addr {
+		t.Errorf("error: GetInterfaceAddrs in the namespace: %v, %v", info.Addrs, err)
+	}
+	data, err := GetIpShow(iface)
+	if err != nil || len(data) != 1 || len(data[0].AddrInfo) != 1 || data[0].AddrInfo[0].Local != "10.99.0.1" {
+		t.Errorf("error: GetIpShow in the namespace: %+v, %v", data, err)
+	}
+	owners, err := FindInterfacesByIP("10.99.0.1", false)
+	if err != nil || len(owners) != 1 || owners[0].Interface != iface {
+		t.Errorf("error: FindInterfacesByIP in the namespace: %v, %v", owners, err)
+	}
+	if exists, _ := GetExistInterface("eth0"); exists {
+		t.Error("error: the host interface eth0 is visible in the namespace")
+	}
+
+	// The host namespace is untouched.
+	restore()
+	if exists, err := GetExistInterface(iface); err != nil || exists {
+		t.Errorf("error: GetExistInterface on the host: %t, %v", exists, err)
+	}
+	if _, err := FindInterfacesByIP("10.99.0.1", false); err == nil {
+		t.Error("error: the address of the namespace is on the host")
+	}
+	if err := exec.Command("ip", "link", "show", iface).Run(); err == nil {
+		t.Errorf("error: %s was created on the host", iface)
+	}
+}
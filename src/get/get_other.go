@@ -0,0 +1,89 @@
+//go:build !linux
+
+package get
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// ErrUnsupported is returned by the functions that depend on Linux tools
+// (ip, iptables, sysctl, tc) when the package is built for another platform.
+var ErrUnsupported = fmt.Errorf(
+	"error: operation requires Linux (ip, iptables, sysctl, tc), not supported on %s, %w",
+	runtime.GOOS,
+	errors.ErrUnsupported,
+)
+
+// Function retrieves information about network interfaces and their IP addresses.
+// Not supported on this platform, it always returns ErrUnsupported.
+func GetIp() ([]IpInterfaceStructure, error) {
+	return nil, ErrUnsupported
+}
+
+// Function retrieves IP address information for a specific network interface.
+// Not supported on this platform, it always returns ErrUnsupported.
+func GetIpShow(interfaceName string) ([]IpInterfaceStructure, error) {
+	return nil, ErrUnsupported
+}
+
+// Function retrieves the link kind of a network interface.
+// Not supported on this platform, it always returns ErrUnsupported.
+func GetLinkKind(interfaceName string) (string, error) {
+	return "", ErrUnsupported
+}
+
+// Function lists the names of the WireGuard and AmneziaWG devices.
+// Not supported on this platform, it always returns ErrUnsupported.
+func GetWireGuardDevices() ([]string, error) {
+	return nil, ErrUnsupported
+}
+
+// Function retrieves the tc configuration of the per-peer rate limiting.
+// Not supported on this platform, it always returns ErrUnsupported.
+func GetTrafficLimits(interfaceName string) (TrafficLimits, error) {
+	return TrafficLimits{Interface: interfaceName}, ErrUnsupported
+}
+
+// Function detects the DNS backend.
+// Not supported on this platform, it always returns ErrUnsupported.
+func GetDNSBackend() (string, error) {
+	return "", ErrUnsupported
+}
+
+// Function retrieves the DNS servers of a network interface.
+// Not supported on this platform, it always returns ErrUnsupported.
+func GetInterfaceDNS(interfaceName string) (InterfaceDNS, error) {
+	return InterfaceDNS{Interface: interfaceName}, ErrUnsupported
+}
+
+// Function retrieves the firewall rules.
+// Not supported on this platform, it always returns ErrUnsupported.
+func GetIptablesFirewall() (IptablesOutput, error) {
+	return IptablesOutput{}, ErrUnsupported
+}
+
+// Function retrieves the NAT rules.
+// Not supported on this platform, it always returns ErrUnsupported.
+func GetIptablesNAT() (IptablesOutput, error) {
+	return IptablesOutput{}, ErrUnsupported
+}
+
+// Function retrieves the output of iptables-save.
+// Not supported on this platform, it always returns ErrUnsupported.
+func GetIptablesSave() (IptablesSave, error) {
+	return IptablesSave{}, ErrUnsupported
+}
+
+// Function retrieves the IPv4 and IPv6 forwarding status.
+// Not supported on this platform, it always returns ErrUnsupported.
+func GetIPvForwarding() (map[string]int, error) {
+	return nil, ErrUnsupported
+}
+
+// Function retrieves the AmneziaWG device information.
+// Not supported on this platform, it always returns ErrUnsupported.
+func GetAwgPeerInfo(interfaceName string) (DeviceInfo, error) {
+	return DeviceInfo{}, ErrUnsupported
+}
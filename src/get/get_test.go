@@ -1,8 +1,22 @@
 package get
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+
+	"github.com/AlexKira/brgnetuse/internal/wgmock"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 // Testing the GetExistInterface function.
@@ -43,46 +57,60 @@ func TestGetExistInterface(t *testing.T) {
 	}
 }
 
-// Testing the GetIpNetInterface function.
-func TestGetIpNetInterfase(t *testing.T) {
-	type testCase struct {
-		input     string
-		wantError bool
+// Testing the GetInterfaceAddrs function on the loopback interface.
+func TestGetInterfaceAddrs(t *testing.T) {
+	info, err := GetInterfaceAddrs("lo")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
 	}
 
-	tests := []testCase{
-		{input: "lo", wantError: false},
-		{input: "", wantError: true},
-		{input: "qwerty", wantError: true},
+	if info.Name != "lo" || info.Index <= 0 || info.MTU <= 0 {
+		t.Errorf("error: got %+v, want the loopback interface", info)
+	}
+	if info.Flags&net.FlagLoopback == 0 || info.Flags&net.FlagUp == 0 {
+		t.Errorf("error: got flags %v, want loopback and up", info.Flags)
 	}
 
-	for _, tc := range tests {
-		t.Run(tc.input, func(t *testing.T) {
-			t.Log("--------------------------------------")
-			t.Logf("Run test: %s", tc.input)
-
-			lenIp, ipNet, err := GetIpNetInterface(tc.input)
+	var prefixes []string
+	for _, addr := range info.Addrs {
+		prefixes = append(prefixes, addr.String())
+	}
+	if !slices.Contains(prefixes, "127.0.0.1/8") {
+		t.Errorf("error: got prefixes %v, want 127.0.0.1/8", prefixes)
+	}
+}
 
-			if tc.wantError {
-				if err == nil {
-					t.Errorf("expected error for network interface '%s', but got none", tc.input)
-				} else {
-					t.Logf("expected error received for '%s': %v", tc.input, err)
-				}
-			} else {
-				if err != nil {
-					t.Errorf("unexpected error for network interface '%s': %v", tc.input, err)
-				} else {
-					t.Logf("info: test '%s' passed successfully, lenIp=%d, ipNet=%v", tc.input, lenIp, ipNet)
-				}
+// Testing that GetInterfaceAddrs reports missing interfaces as not found.
+func TestGetInterfaceAddrsNotFound(t *testing.T) {
+	for _, name := range []string{"", "qwerty"} {
+		t.Run(name, func(t *testing.T) {
+			_, err := GetInterfaceAddrs(name)
+			if !errors.Is(err, ErrInterfaceNotFound) {
+				t.Errorf("error: got %v, want ErrInterfaceNotFound", err)
 			}
-
-			t.Logf("End test: %s", tc.input)
-			t.Log("--------------------------------------")
 		})
 	}
 }
 
+// Testing the deprecated GetIpNetInterface wrapper.
+func TestGetIpNetInterfase(t *testing.T) {
+	count, addrs, err := GetIpNetInterface("lo")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if count != len(addrs) || count == 0 {
+		t.Errorf("error: got count %d for %d addresses", count, len(addrs))
+	}
+	if _, ok := addrs[0].(*net.IPNet); !ok {
+		t.Errorf("error: got %T, want *net.IPNet", addrs[0])
+	}
+
+	count, _, err = GetIpNetInterface("qwerty")
+	if count != -1 || !errors.Is(err, ErrInterfaceNotFound) {
+		t.Errorf("error: got %d, %v, want -1 and ErrInterfaceNotFound", count, err)
+	}
+}
+
 // Testing the GenerateKeys function
 func TestGenerateKeys(t *testing.T) {
 
@@ -118,429 +146,1373 @@ func TestGenerateKeys(t *testing.T) {
 
 }
 
-// Testing the GetIp function.
-func TestGetIP(t *testing.T) {
-	t.Run("GetIp", func(t *testing.T) {
-		t.Log("--------------------------------------")
-		t.Log("Run test")
+// Testing the GenerateKeyPair function
+func TestGenerateKeyPair(t *testing.T) {
 
-		data, err := GetIp()
-		if err != nil {
-			t.Fatal("error GetIp: ", err)
-		}
+	var current_privkey string
+	var current_pubkey string
+	for i := 0; i < 10; i++ {
+		t.Run(fmt.Sprintf("GenerateKeyPair: %d", i), func(t *testing.T) {
+			t.Log("--------------------------------------")
+			t.Log("Run test: ", i)
+			keys, err := GenerateKeyPair()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pk := keys.Private
+			if current_privkey != "" && current_privkey == pk.String() {
+				t.Errorf("error: private key uniqueness violated: %s", current_privkey)
+			}
+			current_privkey = pk.String()
+			t.Logf("info: private key received: %s", pk.String())
 
-		for _, get := range data {
-			t.Logf("info: data on network interface '%s' received", get.IfName)
-		}
+			pb := keys.Public
+			if pb != pk.PublicKey() {
+				t.Errorf("error: public key %s does not match the private key", pb)
+			}
+			if current_pubkey != "" && current_pubkey == pb.String() {
+				t.Errorf("error: public key uniqueness violated: %s", current_pubkey)
+			}
+			current_pubkey = pb.String()
+			t.Logf("info: public key received: %s", pb.String())
 
-		t.Log("End test")
-		t.Log("--------------------------------------")
-	})
+			t.Log("End test: ", i)
+			t.Log("--------------------------------------")
+		})
+
+	}
 
 }
 
-// Testing the GetIpShow function.
-func TestGetIpShow(t *testing.T) {
+// Testing that GenerateKeyPair draws the key from its source, clamped, and
+// that on failure no key is returned, by the deprecated GenerateKeys too.
+func TestGenerateKeyPairRand(t *testing.T) {
+	UseKeyRand(t, bytes.NewReader(bytes.Repeat([]byte{0xff}, wgtypes.KeyLen)))
+	keys, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	want := wgtypes.Key(bytes.Repeat([]byte{0xff}, wgtypes.KeyLen))
+	want[0], want[31] = 0xf8, 0x7f
+	if keys.Private != want || keys.Public != want.PublicKey() {
+		t.Errorf("error: got private key %s, want %s", keys.Private, want)
+	}
+
+	// A source running short, e.g. a failing entropy source.
+	UseKeyRand(t, bytes.NewReader(make([]byte, wgtypes.KeyLen-1)))
+	keys, err = GenerateKeyPair()
+	if err == nil || !strings.Contains(err.Error(), "failed to generate a private key") {
+		t.Errorf("error: got %v, want a generation error", err)
+	}
+	if keys != (KeyPair{}) {
+		t.Errorf("error: got keys %+v with the error, want none", keys)
+	}
+
+	UseKeyRand(t, bytes.NewReader(nil))
+	if keysMap, err := GenerateKeys(); err == nil || keysMap != nil {
+		t.Errorf("error: got %v, %v, want no map and an error", keysMap, err)
+	}
+}
+
+// Testing the GetPeer function.
+func TestGetPeer(t *testing.T) {
 	type testCase struct {
 		input     string
 		wantError bool
 	}
 
 	tests := []testCase{
-		{input: "lo", wantError: false},
-		{input: "", wantError: false},
+		{input: "lo", wantError: true},
+		{input: "wg0", wantError: true},
 		{input: "qwerty", wantError: true},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.input, func(t *testing.T) {
 			t.Log("--------------------------------------")
-			t.Logf("Run test: %s", tc.input)
-
-			data, err := GetIpShow(tc.input)
+			t.Logf("Run test: interface=%q", tc.input)
 
+			devices, err := GetPeer(tc.input)
 			if tc.wantError {
-				if err == nil {
-					t.Errorf("expected error for input '%s', but got none", tc.input)
-				} else {
-					t.Logf("expected error received for '%s': %v", tc.input, err)
-				}
+				t.Logf("info: expected error received: %v", err)
 			} else {
-				if err != nil {
-					t.Errorf("unexpected error for input '%s': %v", tc.input, err)
-				} else {
-					t.Logf("info: received %d data items for '%s'", len(data), tc.input)
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			if len(devices) > 0 {
+				for _, val := range devices {
+					t.Logf("info: received data: %v", val)
 				}
 			}
 
-			t.Logf("End test: %s", tc.input)
+			t.Log("End test")
 			t.Log("--------------------------------------")
 		})
 	}
 }
 
-// Testing the GetIptablesFirewall function.
-func TestGetIptablesFirewall(t *testing.T) {
-	t.Run("GetIptablesFirewall", func(t *testing.T) {
-		t.Log("--------------------------------------")
-		t.Log("Run test")
+// Testing the GetPeer function against the mocked wgctrl layer.
+func TestGetPeerMock(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("error: failed to generate key: %v", err)
+	}
 
-		data, err := GetIptablesFirewall()
-		if err != nil {
-			t.Fatal("error GetIptablesFirewall: ", err)
-		}
+	wgmock.Install(t,
+		&wgtypes.Device{Name: "wg0", ListenPort: 51820, PublicKey: key.PublicKey()},
+		&wgtypes.Device{Name: "wg1", ListenPort: 51821},
+	)
+
+	type testCase struct {
+		input     string
+		wantCount int
+		wantError bool
+	}
 
-		t.Logf("info: %d firewall data received: ", len(data.Chains))
+	tests := []testCase{
+		{input: "", wantCount: 2, wantError: false},
+		{input: "wg0", wantCount: 1, wantError: false},
+		{input: "wg9", wantCount: 0, wantError: true},
+	}
 
-		t.Log("End test")
-		t.Log("--------------------------------------")
-	})
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			devices, err := GetPeer(tc.input)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error for interface %q", tc.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if len(devices) != tc.wantCount {
+				t.Fatalf("error: got %d devices, want %d", len(devices), tc.wantCount)
+			}
+			if tc.input != "" && devices[0].PublicKey != key.PublicKey() {
+				t.Errorf("error: unexpected public key %s", devices[0].PublicKey)
+			}
+		})
+	}
 }
 
-// Testing the GetIptablesNA function.
-func TestGetIptablesNAT(t *testing.T) {
-	t.Run("GetIptablesNAT", func(t *testing.T) {
-		t.Log("--------------------------------------")
-		t.Log("Run test")
+// Testing the FromWgDevice converter and the JSON encoding of its result.
+func TestFromWgDevice(t *testing.T) {
+	priv, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("error: failed to generate key: %v", err)
+	}
+	peerKey, _ := wgtypes.GeneratePrivateKey()
+	psk, _ := wgtypes.GenerateKey()
+
+	_, ipn, _ := net.ParseCIDR("10.0.0.2/32")
+	handshake := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	device := &wgtypes.Device{
+		Name:         "wg0",
+		Type:         wgtypes.Userspace,
+		PrivateKey:   priv,
+		PublicKey:    priv.PublicKey(),
+		ListenPort:   51820,
+		FirewallMark: 7,
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey:                   peerKey.PublicKey(),
+				PresharedKey:                psk,
+				Endpoint:                    &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51820},
+				PersistentKeepaliveInterval: 25 * time.Second,
+				LastHandshakeTime:           handshake,
+				ReceiveBytes:                1024,
+				TransmitBytes:               2048,
+				AllowedIPs:                  []net.IPNet{*ipn},
+				ProtocolVersion:             1,
+			},
+			{PublicKey: priv.PublicKey()},
+		},
+	}
 
-		data, err := GetIptablesNAT()
-		if err != nil {
-			t.Fatal("error GetIptablesNAT: ", err)
+	data, err := json.Marshal(FromWgDevice(device))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	var got DeviceInfo
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if got.Name != "wg0" || got.ListenPort != 51820 || got.FirewallMark != 7 {
+		t.Errorf("error: unexpected device %+v", got)
+	}
+	if got.PublicKey != priv.PublicKey().String() {
+		t.Errorf("error: got public key %s", got.PublicKey)
+	}
+	if len(got.Peers) != 2 {
+		t.Fatalf("error: got %d peers, want 2", len(got.Peers))
+	}
+
+	peer := got.Peers[0]
+	type testCase struct {
+		name string
+		got  any
+		want any
+	}
+
+	tests := []testCase{
+		{name: "public_key", got: peer.PublicKey, want: peerKey.PublicKey().String()},
+		{name: "preshared_key", got: peer.PresharedKey, want: true},
+		{name: "endpoint", got: peer.Endpoint, want: "192.0.2.1:51820"},
+		{name: "persistent_keepalive", got: peer.PersistentKeepalive, want: 25},
+		{name: "last_handshake", got: peer.LastHandshake, want: "2026-03-01T12:30:00Z"},
+		{name: "receive_bytes", got: peer.ReceiveBytes, want: int64(1024)},
+		{name: "transmit_bytes", got: peer.TransmitBytes, want: int64(2048)},
+		{name: "allowed_ips", got: fmt.Sprint(peer.AllowedIPs), want: "[10.0.0.2/32]"},
+		{name: "empty_handshake", got: got.Peers[1].LastHandshake, want: ""},
+		{name: "empty_endpoint", got: got.Peers[1].Endpoint, want: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.got != tc.want {
+				t.Errorf("error: got %v, want %v", tc.got, tc.want)
+			}
+		})
+	}
+
+	for _, secret := range []wgtypes.Key{priv, psk} {
+		if strings.Contains(string(data), secret.String()) {
+			t.Errorf("error: key material leaked into JSON")
 		}
-		t.Logf("info: received number of NAT rules: %d", len(data.Chains))
+	}
+}
+
+// Testing the GetPeerInfo function merges the peer metadata.
+func TestGetPeerInfo(t *testing.T) {
+	prev := peermeta.Dir
+	peermeta.Dir = t.TempDir()
+	t.Cleanup(func() { peermeta.Dir = prev })
 
-		t.Log("End test")
-		t.Log("--------------------------------------")
+	key, _ := wgtypes.GeneratePrivateKey()
+	peer := key.PublicKey()
+
+	wgmock.Install(t, &wgtypes.Device{
+		Name:  "wg0",
+		Peers: []wgtypes.Peer{{PublicKey: peer}},
 	})
+
+	expires := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := peermeta.Set("wg0", peer.String(), peermeta.Meta{Name: "alice", Expires: expires})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	devices, err := GetPeerInfo("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(devices) != 1 || len(devices[0].Peers) != 1 {
+		t.Fatalf("error: unexpected result %+v", devices)
+	}
+
+	got := devices[0].Peers[0]
+	if got.Name != "alice" || got.Expires != "2027-01-01T00:00:00Z" {
+		t.Errorf("error: metadata not merged: %+v", got)
+	}
+	if devices[0].LastChange != nil {
+		t.Errorf("error: got last change %+v, want none", devices[0].LastChange)
+	}
+
+	if err := peermeta.RecordChange("wg0", "peer-add"); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	devices, err = GetPeerInfo("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if change := devices[0].LastChange; change == nil || change.Operation != "peer-add" {
+		t.Errorf("error: got last change %+v, want peer-add", change)
+	}
 }
 
-// Testing the GetRuleId method of the firewall's FilterIptablesOutput structure.
-func TestFirewallGetRuleId(t *testing.T) {
+// Testing the FilterPeers function.
+func TestFilterPeers(t *testing.T) {
+	devices := []DeviceInfo{
+		{Name: "wg0", Peers: []PeerInfo{
+			{PublicKey: "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", Endpoint: "192.0.2.1:51820"},
+			{PublicKey: "xTIBcE7vQ0o4pLlV8rBqC2Kc6Jq1ZqWz0YHnYwVh3mA=", Endpoint: "192.0.2.1:51821"},
+			{PublicKey: "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=", Endpoint: "[2001:db8::1]:51820"},
+		}},
+		{Name: "wg1", Peers: []PeerInfo{
+			{PublicKey: "xTIB", Endpoint: ""},
+		}},
+	}
+
 	type testCase struct {
 		name      string
-		input     int
-		wantError bool
+		filter    PeerFilter
+		want      []string
+		wantError error
 	}
 
 	tests := []testCase{
-		{name: "func: GetRuleId", input: 12, wantError: true},
-		{name: "func: GetRuleId", input: 1, wantError: true},
-		{name: "func: GetRuleId", input: 7, wantError: true},
-		{name: "func: GetRuleId", input: 10, wantError: true},
-		{name: "func: GetRuleId", input: -100, wantError: true},
-		{name: "func: GetRuleId", input: 100, wantError: true},
-		{name: "func: GetRuleId", input: 0, wantError: true},
-		{name: "func: GetRuleId", input: 1000000000, wantError: true},
+		{name: "none", filter: PeerFilter{}, want: []string{"xTIBA", "xTIBc", "TrMvS", "xTIB"}},
+		{name: "full_key", filter: PeerFilter{Key: "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="}, want: []string{"TrMvS"}},
+		{name: "prefix", filter: PeerFilter{Key: "TrMv"}, want: []string{"TrMvS"}},
+		{name: "exact_wins", filter: PeerFilter{Key: "xTIB"}, want: []string{"xTIB"}},
+		{name: "unique_prefix", filter: PeerFilter{Key: "xTIBA"}, want: []string{"xTIBA"}},
+		{name: "ambiguous_prefix", filter: PeerFilter{Key: "xTI"}, wantError: ErrAmbiguousPeer},
+		{name: "no_match", filter: PeerFilter{Key: "zzz"}, wantError: ErrNoPeerMatch},
+		{name: "endpoint_ip", filter: PeerFilter{Endpoint: "192.0.2.1"}, want: []string{"xTIBA", "xTIBc"}},
+		{name: "endpoint_port", filter: PeerFilter{Endpoint: "192.0.2.1:51821"}, want: []string{"xTIBc"}},
+		{name: "endpoint_ipv6", filter: PeerFilter{Endpoint: "2001:db8::1"}, want: []string{"TrMvS"}},
+		{name: "endpoint_and_key", filter: PeerFilter{Endpoint: "192.0.2.1", Key: "xTI"}, wantError: ErrAmbiguousPeer},
+		{name: "endpoint_no_match", filter: PeerFilter{Endpoint: "198.51.100.1"}, wantError: ErrNoPeerMatch},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			t.Log("--------------------------------------")
-			t.Logf("Run test: %s, input: %d", tc.name, tc.input)
-
-			getData, err := GetIptablesFirewall()
+			result, err := FilterPeers(devices, tc.filter)
+			if tc.wantError != nil {
+				if !errors.Is(err, tc.wantError) {
+					t.Fatalf("error: got %v, want %v", err, tc.wantError)
+				}
+				return
+			}
 			if err != nil {
-				t.Fatalf("error: GetIptablesFirewall failed during setup for input=%d: %v", tc.input, err)
+				t.Fatalf("error: unexpected error: %v", err)
 			}
-			if len(getData.Chains) == 0 {
-				t.Fatal("error: add rules to firewall table to start test")
+
+			var got []string
+			for _, d := range result {
+				for _, p := range d.Peers {
+					got = append(got, p.PublicKey[:min(5, len(p.PublicKey))])
+				}
 			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("error: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := FilterPeers(devices, PeerFilter{Endpoint: "not-an-ip"}); err == nil {
+		t.Errorf("error: expected error for an invalid endpoint")
+	}
+}
+
+// Testing the SortPeers function.
+func TestSortPeers(t *testing.T) {
+	peers := []PeerInfo{
+		{PublicKey: "A", LastHandshake: "2026-03-01T12:00:00Z", ReceiveBytes: 10, TransmitBytes: 300, AllowedIPs: []string{"10.0.0.10/32"}},
+		{PublicKey: "B", ReceiveBytes: 30, TransmitBytes: 100, AllowedIPs: []string{"10.0.0.2/32"}},
+		{PublicKey: "C", LastHandshake: "2026-03-01T13:00:00Z", ReceiveBytes: 20, TransmitBytes: 200},
+		{PublicKey: "D", LastHandshake: "2026-03-01T12:00:00Z", ReceiveBytes: 30, TransmitBytes: 100, AllowedIPs: []string{"10.0.0.2/31"}},
+	}
+
+	type testCase struct {
+		by        string
+		reverse   bool
+		want      string
+		wantError bool
+	}
 
-			obj := FilterIptablesOutput{getData}
-			data, err := obj.GetRuleId(tc.input)
+	tests := []testCase{
+		{by: SortHandshake, want: "CADB"},
+		{by: SortHandshake, reverse: true, want: "ADCB"},
+		{by: SortReceive, want: "BDCA"},
+		{by: SortReceive, reverse: true, want: "ACBD"},
+		{by: SortTransmit, want: "ACBD"},
+		{by: SortIP, want: "DBAC"},
+		{by: SortIP, reverse: true, want: "ABDC"},
+		{by: SortKey, want: "ABCD"},
+		{by: SortKey, reverse: true, want: "DCBA"},
+		{by: "latency", wantError: true},
+	}
 
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("%s_%t", tc.by, tc.reverse), func(t *testing.T) {
+			sorted := slices.Clone(peers)
+			err := SortPeers(sorted, tc.by, tc.reverse)
 			if tc.wantError {
 				if err == nil {
-					t.Logf("info: received data: %v\n", data)
-				} else {
-					t.Logf("error: expected error %v\n", err)
-				}
-			} else {
-				if err == nil {
-					t.Logf("info: received data: %v\n", data)
-				} else {
-					t.Errorf("error: test failed, %v\n", err)
+					t.Fatalf("error: expected error for sort key %q", tc.by)
 				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
 			}
 
-			t.Logf("End test: %s, input: %d", tc.name, tc.input)
-			t.Log("--------------------------------------")
+			got := ""
+			for _, p := range sorted {
+				got += p.PublicKey
+			}
+			if got != tc.want {
+				t.Errorf("error: got %s, want %s", got, tc.want)
+			}
 		})
 	}
 }
 
-// Test function for testing the GetExistingRules function for firewall.
-func TestFirewallGetExistingRules(t *testing.T) {
+// Testing that the Sampler reuses a single wgctrl client.
+func TestSamplerReusesClient(t *testing.T) {
+	prev := peermeta.Dir
+	peermeta.Dir = t.TempDir()
+	t.Cleanup(func() { peermeta.Dir = prev })
+
+	mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+
+	sampler, err := NewSampler()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for range 3 {
+		if _, err := sampler.PeerInfo("wg0"); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	}
+	if err := sampler.Close(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if mock.Opens != 1 || mock.Closes != 1 {
+		t.Errorf("error: got %d opens and %d closes, want 1 and 1", mock.Opens, mock.Closes)
+	}
+}
+
+// Testing the PeerDeltas function.
+func TestPeerDeltas(t *testing.T) {
+	prev := []DeviceInfo{{Name: "wg0", Peers: []PeerInfo{
+		{PublicKey: "A", LastHandshake: "2026-03-01T12:00:00Z", ReceiveBytes: 100, TransmitBytes: 50},
+		{PublicKey: "B", ReceiveBytes: 10},
+		{PublicKey: "C", ReceiveBytes: 500},
+	}}}
+	cur := []DeviceInfo{{Name: "wg0", Peers: []PeerInfo{
+		{PublicKey: "A", LastHandshake: "2026-03-01T12:02:00Z", ReceiveBytes: 150, TransmitBytes: 50},
+		{PublicKey: "B", ReceiveBytes: 10},
+		{PublicKey: "C", ReceiveBytes: 20},
+		{PublicKey: "D"},
+	}}}
+
 	type testCase struct {
-		inIface    string
-		outIface   string
-		subnetCIDR string
-		wantError  bool
+		key  string
+		want PeerDelta
 	}
+
 	tests := []testCase{
-		{inIface: "wg3", outIface: "enp0s3", subnetCIDR: "10.10.10.0/24", wantError: true}, // Rule added to Firewall table.
-		{inIface: "qwerty", outIface: "enp0s3", subnetCIDR: "10.10.10.0/24", wantError: true},
-		{inIface: "*", outIface: "lo", subnetCIDR: "0.0.0.0/0", wantError: false},
-		{inIface: "*", outIface: "*", subnetCIDR: "0.0.0.0/0", wantError: false},
-		{inIface: "lo", outIface: "lo", subnetCIDR: "0.0.0.0/0", wantError: true},
-		{inIface: "", outIface: "enp0s3", subnetCIDR: "10.10.10.0/24", wantError: true},
-		{inIface: "wg0", outIface: "", subnetCIDR: "10.10.10.0/24", wantError: true},
-		{inIface: "wg0", outIface: "enp0s3", subnetCIDR: "10.10.10.0", wantError: true},
+		{key: "A", want: PeerDelta{Handshake: true, ReceiveBytes: 50}},
+		{key: "B", want: PeerDelta{}},
+		{key: "C", want: PeerDelta{}},
+		{key: "D", want: PeerDelta{New: true}},
 	}
 
+	deltas := PeerDeltas(prev, cur)
 	for _, tc := range tests {
-		t.Run("Firewall", func(t *testing.T) {
-			t.Log("--------------------------------------")
-			t.Logf("Run test GetExistingRules: inIface=%q, outIface=%q, subnetCIDR=%q", tc.inIface, tc.outIface, tc.subnetCIDR)
-
-			getData, err := GetIptablesFirewall()
-			if err != nil {
-				t.Fatalf("error: failed to get iptables firewall data: %v", err)
-			}
-			if len(getData.Chains) == 0 {
-				t.Fatal("error: no chains found in firewall table; please add rules before running the test")
+		t.Run(tc.key, func(t *testing.T) {
+			got := deltas[PeerID{Interface: "wg0", PublicKey: tc.key}]
+			if got != tc.want {
+				t.Errorf("error: got %+v, want %+v", got, tc.want)
 			}
+		})
+	}
 
-			obj := FilterIptablesOutput{getData}
-			fwExist, err := obj.GetExistingRules(tc.inIface, tc.outIface, tc.subnetCIDR)
-			if tc.wantError {
-				if err == nil {
-					t.Logf("info: test passed, the port exists: %t", fwExist)
-				} else {
-					t.Logf("error: test failed, %v", err)
-				}
-			} else {
-				if err == nil {
-					t.Logf("info: test passed, the port exists: %t", fwExist)
-				} else {
-					t.Fatalf("error: test failed, %v", err)
-				}
-			}
+	if len(PeerDeltas(nil, cur)) != 0 {
+		t.Errorf("error: expected no changes without a previous sample")
+	}
+}
 
-			t.Logf("End test GetExistingRules: inIface=%q, outIface=%q, subnetCIDR=%q", tc.inIface, tc.outIface, tc.subnetCIDR)
-			t.Log("--------------------------------------")
-		})
+// Testing the Watch loop stops cleanly on cancellation and on errors.
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+
+	err := Watch(ctx, time.Millisecond, func(context.Context) error {
+		calls++
+		if calls == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("error: got %v after %d calls, want nil after 3", err, calls)
+	}
+
+	wantErr := errors.New("error: sample failed")
+	err = Watch(context.Background(), time.Millisecond, func(context.Context) error {
+		return wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Errorf("error: got %v, want %v", err, wantErr)
+	}
+
+	if err := Watch(context.Background(), 0, nil); err == nil {
+		t.Errorf("error: expected error for a zero interval")
 	}
 }
 
-// Testing the GetRuleId method of the NAT's FilterIptablesOutput structure.
-func TestNATGetRuleId(t *testing.T) {
+// Testing the evaluateHealth function over synthetic states.
+func TestEvaluateHealth(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/24")
+	masquerade := IptablesOutput{Chains: []IptablesChain{{
+		Name:  "POSTROUTING",
+		Rules: []IptablesRule{{Id: 1, Target: "MASQUERADE", Source: "10.0.0.0/24", Out: "eth0"}},
+	}}}
+	forward := func() IptablesOutput {
+		return IptablesOutput{Chains: []IptablesChain{{
+			Name:   "FORWARD",
+			Policy: "DROP",
+			Rules: []IptablesRule{
+				{Id: 1, Target: "ACCEPT", In: "eth0", Out: "wg0", Comment: "brgnetuse:wg0"},
+				{Id: 2, Target: "ACCEPT", In: "wg0", Out: "eth0", Comment: "brgnetuse:wg0"},
+			},
+		}}}
+	}
+
+	healthy := func() healthState {
+		return healthState{
+			Exists: true,
+			Up:     true,
+			Device: DeviceInfo{Name: "wg0", Peers: []PeerInfo{
+				{PublicKey: "A", LastHandshake: "2026-03-01T11:59:00Z"},
+			}},
+			Subnets:    []*net.IPNet{subnet},
+			Forwarding: map[string]int{"ipv4": 1, "ipv6": 0},
+			Filter:     forward(),
+			NAT:        masquerade,
+			Now:        now,
+		}
+	}
+	opts := HealthOptions{Interface: "wg0", MaxHandshake: 3 * time.Minute, MinPeers: 1}
+
 	type testCase struct {
-		name      string
-		input     int
-		wantError bool
+		name       string
+		modify     func(*healthState, *HealthOptions)
+		wantStatus Severity
+		wantChecks []string
 	}
 
 	tests := []testCase{
-		{name: "func: GetRuleId", input: 12, wantError: true},
-		{name: "func: GetRuleId", input: 1, wantError: true},
-		{name: "func: GetRuleId", input: 2, wantError: true},
-		{name: "func: GetRuleId", input: 3, wantError: true},
-		{name: "func: GetRuleId", input: -100, wantError: true},
-		{name: "func: GetRuleId", input: 100, wantError: true},
-		{name: "func: GetRuleId", input: 0, wantError: true},
-		{name: "func: GetRuleId", input: 1000000000, wantError: true},
+		{name: "healthy", modify: func(*healthState, *HealthOptions) {}, wantStatus: Healthy},
+		{
+			name:       "missing",
+			modify:     func(s *healthState, _ *HealthOptions) { s.Exists = false },
+			wantStatus: Critical,
+			wantChecks: []string{"interface"},
+		},
+		{
+			name:       "down",
+			modify:     func(s *healthState, _ *HealthOptions) { s.Up = false },
+			wantStatus: Critical,
+			wantChecks: []string{"interface"},
+		},
+		{
+			name: "process_dead",
+			modify: func(s *healthState, _ *HealthOptions) {
+				s.DeviceErr = errors.New("error: failed to get device \"wg0\", file does not exist")
+			},
+			wantStatus: Critical,
+			wantChecks: []string{"device"},
+		},
+		{
+			name:       "too_few_peers",
+			modify:     func(_ *healthState, o *HealthOptions) { o.MinPeers = 2 },
+			wantStatus: Degraded,
+			wantChecks: []string{"peers"},
+		},
+		{
+			name: "stale_handshake",
+			modify: func(s *healthState, _ *HealthOptions) {
+				s.Device.Peers[0].LastHandshake = "2026-03-01T11:50:00Z"
+			},
+			wantStatus: Degraded,
+			wantChecks: []string{"handshake"},
+		},
+		{
+			name: "never_handshaked",
+			modify: func(s *healthState, _ *HealthOptions) {
+				s.Device.Peers = append(s.Device.Peers, PeerInfo{PublicKey: "B"})
+			},
+			wantStatus: Degraded,
+			wantChecks: []string{"handshake"},
+		},
+		{
+			name: "never_handshaked_ignored",
+			modify: func(s *healthState, o *HealthOptions) {
+				s.Device.Peers = append(s.Device.Peers, PeerInfo{PublicKey: "B"})
+				o.IgnoreNew = true
+			},
+			wantStatus: Healthy,
+		},
+		{
+			name:       "handshake_disabled",
+			modify:     func(s *healthState, o *HealthOptions) { o.MaxHandshake = 0; s.Now = now.Add(time.Hour) },
+			wantStatus: Healthy,
+		},
+		{
+			name:       "forwarding_disabled",
+			modify:     func(s *healthState, _ *HealthOptions) { s.Forwarding["ipv4"] = 0 },
+			wantStatus: Degraded,
+			wantChecks: []string{"forwarding"},
+		},
+		{
+			name:       "forward_missing",
+			modify:     func(s *healthState, _ *HealthOptions) { s.Filter = IptablesOutput{} },
+			wantStatus: Degraded,
+			wantChecks: []string{"forward", "forward"},
+		},
+		{
+			name: "forward_one_way",
+			modify: func(s *healthState, _ *HealthOptions) {
+				s.Filter.Chains[0].Rules = s.Filter.Chains[0].Rules[:1]
+			},
+			wantStatus: Degraded,
+			wantChecks: []string{"forward"},
+		},
+		{
+			name: "forward_untagged",
+			modify: func(s *healthState, _ *HealthOptions) {
+				for i := range s.Filter.Chains[0].Rules {
+					s.Filter.Chains[0].Rules[i].Comment = ""
+				}
+			},
+			wantStatus: Healthy,
+		},
+		{
+			name: "forward_policy_accept",
+			modify: func(s *healthState, _ *HealthOptions) {
+				s.Filter.Chains[0].Policy = "ACCEPT"
+				s.Filter.Chains[0].Rules = nil
+			},
+			wantStatus: Healthy,
+		},
+		{
+			name: "forward_other_interface",
+			modify: func(s *healthState, _ *HealthOptions) {
+				s.Filter.Chains[0].Rules[1].In = "wg1"
+			},
+			wantStatus: Degraded,
+			wantChecks: []string{"forward"},
+		},
+		{
+			name: "forward_unreadable",
+			modify: func(s *healthState, _ *HealthOptions) {
+				s.FilterErr = errors.New("error: failed to get iptables rules")
+			},
+			wantStatus: Degraded,
+			wantChecks: []string{"forward"},
+		},
+		{
+			name:       "nat_missing",
+			modify:     func(s *healthState, _ *HealthOptions) { s.NAT = IptablesOutput{} },
+			wantStatus: Degraded,
+			wantChecks: []string{"nat"},
+		},
+		{
+			name: "down_and_degraded",
+			modify: func(s *healthState, _ *HealthOptions) {
+				s.Up = false
+				s.NAT = IptablesOutput{}
+			},
+			wantStatus: Critical,
+			wantChecks: []string{"interface", "nat"},
+		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			t.Log("--------------------------------------")
-			t.Logf("Run test: %s, input: %d", tc.name, tc.input)
+			state, o := healthy(), opts
+			tc.modify(&state, &o)
 
-			getData, err := GetIptablesNAT()
-			if err != nil {
-				t.Fatalf("error: GetIptablesNAT failed during setup for input=%d: %v", tc.input, err)
+			report := evaluateHealth(state, o)
+			if report.Status != tc.wantStatus {
+				t.Errorf("error: got status %s, want %s: %v", report.Status, tc.wantStatus, report.Failures)
+			}
+
+			var checks []string
+			for _, f := range report.Failures {
+				checks = append(checks, f.Check)
 			}
-			if len(getData.Chains) == 0 {
-				t.Fatal("error: add rules to nat table to start test")
+			if strings.Join(checks, ",") != strings.Join(tc.wantChecks, ",") {
+				t.Errorf("error: got checks %v, want %v", checks, tc.wantChecks)
 			}
+		})
+	}
+}
 
-			obj := FilterIptablesOutput{getData}
-			data, err := obj.GetRuleId(tc.input)
+// Testing the parseWgDump function.
+func TestParseWgDump(t *testing.T) {
+	dump := "cHJpdmF0ZQ==\tcHVibGlj\t51820\toff\n" +
+		"QUFBQQ==\t(none)\t192.0.2.1:51820\t10.0.0.2/32,10.0.1.0/24\t1772366400\t1024\t2048\t25\n" +
+		"QkJCQg==\tcHNr\t(none)\t(none)\t0\t0\t0\toff\n"
 
-			if tc.wantError {
-				if err == nil {
-					t.Logf("info: received data: %v\n", data)
-				} else {
-					t.Logf("error: expected error %v\n", err)
-				}
-			} else {
-				if err == nil {
-					t.Logf("info: received data: %v\n", data)
-				} else {
-					t.Errorf("error: test failed, %v\n", err)
-				}
-			}
+	info, err := parseWgDump("awg0", dump)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
 
-			t.Logf("End test: %s, input: %d", tc.name, tc.input)
-			t.Log("--------------------------------------")
-		})
+	if info.PublicKey != "cHVibGlj" || info.ListenPort != 51820 || len(info.Peers) != 2 {
+		t.Fatalf("error: unexpected device %+v", info)
+	}
+	if strings.Contains(fmt.Sprint(info), "cHJpdmF0ZQ==") {
+		t.Errorf("error: private key kept in the device information")
+	}
+
+	first, second := info.Peers[0], info.Peers[1]
+	if first.Endpoint != "192.0.2.1:51820" || first.PresharedKey || len(first.AllowedIPs) != 2 ||
+		first.LastHandshake != "2026-03-01T12:00:00Z" || first.ReceiveBytes != 1024 ||
+		first.TransmitBytes != 2048 || first.PersistentKeepalive != 25 {
+		t.Errorf("error: unexpected peer %+v", first)
+	}
+	if second.Endpoint != "" || !second.PresharedKey || len(second.AllowedIPs) != 0 ||
+		second.LastHandshake != "" || second.PersistentKeepalive != 0 {
+		t.Errorf("error: unexpected peer %+v", second)
+	}
+
+	if _, err := parseWgDump("awg0", "cHJpdmF0ZQ==\tcHVibGlj\t51820\toff\nQUFBQQ==\t(none)\n"); err == nil {
+		t.Errorf("error: expected error for a truncated peer line")
+	}
+}
+
+// Captured `awg show awg0 dump` of amneziawg-tools 1.0: the obfuscation
+// parameters follow the listen port, the firewall mark is the last field.
+const awgDump = "Ac4FZQVU0pZ3eREDrvX+DHooSHGMZFk8Yff/fiyuAjw=\tDdHvHyE0KLV3buwHWzrTZClgcWj+jrjQbpUiDr3HQmg=\t51820\t4\t40\t70\t0\t0\t1\t2\t3\t4\t0xca6c\n" +
+	"e33lcEsfCxt3yDlWo0v33UYjs5u3mgd9WNgMH6md3fE=\t(none)\t198.51.100.7:40123\t10.8.0.2/32\t1772366400\t148\t92\toff\n" +
+	"HWjwR/xnHmY6NP9f1W/4oO1Y4RdP5pY9YMCJKyb/gHs=\t(none)\t(none)\t10.8.0.3/32,fd00::3/128\t0\t0\t0\t25\n"
+
+// Captured `awg show awg0` of amneziawg-tools 1.0.
+const awgShow = `interface: awg0
+  public key: DdHvHyE0KLV3buwHWzrTZClgcWj+jrjQbpUiDr3HQmg=
+  private key: (hidden)
+  listening port: 51820
+  fwmark: 0xca6c
+  jc: 4
+  jmin: 40
+  jmax: 70
+  s1: 0
+  s2: 0
+  h1: 1
+  h2: 2
+  h3: 3
+  h4: 4
+
+peer: e33lcEsfCxt3yDlWo0v33UYjs5u3mgd9WNgMH6md3fE=
+  endpoint: 198.51.100.7:40123
+  allowed ips: 10.8.0.2/32
+  latest handshake: 1 minute, 2 seconds ago
+  transfer: 148 B received, 92 B sent
+
+peer: HWjwR/xnHmY6NP9f1W/4oO1Y4RdP5pY9YMCJKyb/gHs=
+  allowed ips: 10.8.0.3/32, fd00::3/128
+  persistent keepalive: every 25 seconds
+`
+
+// Testing the parseWgDump function on a captured AmneziaWG dump, with a
+// peer with and a peer without endpoint.
+func TestParseAwgDump(t *testing.T) {
+	info, err := parseWgDump("awg0", awgDump)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	want := DeviceInfo{
+		Name:         "awg0",
+		Type:         "userspace",
+		PublicKey:    "DdHvHyE0KLV3buwHWzrTZClgcWj+jrjQbpUiDr3HQmg=",
+		ListenPort:   51820,
+		FirewallMark: 0xca6c,
+		Peers: []PeerInfo{
+			{
+				PublicKey:     "e33lcEsfCxt3yDlWo0v33UYjs5u3mgd9WNgMH6md3fE=",
+				Endpoint:      "198.51.100.7:40123",
+				LastHandshake: "2026-03-01T12:00:00Z",
+				ReceiveBytes:  148,
+				TransmitBytes: 92,
+				AllowedIPs:    []string{"10.8.0.2/32"},
+			},
+			{
+				PublicKey:           "HWjwR/xnHmY6NP9f1W/4oO1Y4RdP5pY9YMCJKyb/gHs=",
+				PersistentKeepalive: 25,
+				AllowedIPs:          []string{"10.8.0.3/32", "fd00::3/128"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("error: got\n%+v\nwant\n%+v", info, want)
 	}
 }
 
-// Test function for testing the GetExistingRules function for NAT.
-func TestNatGetExistingRules(t *testing.T) {
+// Testing the parseAwgShow function.
+func TestParseAwgShow(t *testing.T) {
 	type testCase struct {
-		inIface    string
-		outIface   string
-		subnetCIDR string
-		wantError  bool
+		name      string
+		output    string
+		want      *AwgParams
+		wantError bool
 	}
+
 	tests := []testCase{
 		{
-			inIface: "wg0", outIface: "enp0s3", subnetCIDR: "10.10.10.0/24", wantError: true,
-		}, // Rule added to nat table.
-		{inIface: "qwerty", outIface: "enp0s3", subnetCIDR: "10.10.10.0/24", wantError: true},
-		{inIface: "wg0", outIface: "enp0s3", subnetCIDR: "101.0.0.0/24", wantError: true},
-		{inIface: "", outIface: "enp0s3", subnetCIDR: "10.10.10.0/24", wantError: true},
-		{inIface: "wg0", outIface: "", subnetCIDR: "10.10.10.0/24", wantError: true},
-		{inIface: "wg0", outIface: "enp0s3", subnetCIDR: "10.10.10.0", wantError: true},
+			name:   "amneziawg 1.0",
+			output: awgShow,
+			want:   &AwgParams{Jc: 4, Jmin: 40, Jmax: 70, H1: "1", H2: "2", H3: "3", H4: "4"},
+		},
+		{
+			name: "amneziawg 1.5",
+			output: "interface: awg1\n  listening port: 51821\n  jc: 3\n  jmin: 10\n  jmax: 50\n" +
+				"  s1: 15\n  s2: 18\n  s3: 20\n  s4: 23\n  h1: 100-200\n  h2: 300-400\n  h3: 500-600\n  h4: 700-800\n" +
+				"  i1: <b 0xf6ab3267fa><r 16>\n",
+			want: &AwgParams{
+				Jc: 3, Jmin: 10, Jmax: 50, S1: 15, S2: 18, S3: 20, S4: 23,
+				H1: "100-200", H2: "300-400", H3: "500-600", H4: "700-800", I1: "<b 0xf6ab3267fa><r 16>",
+			},
+		},
+		{
+			name:   "wireguard",
+			output: "interface: wg0\n  public key: DdHvHyE0KLV3buwHWzrTZClgcWj+jrjQbpUiDr3HQmg=\n  listening port: 51820\n",
+		},
+		{name: "invalid", output: "interface: awg0\n  jc: four\n", wantError: true},
 	}
 
 	for _, tc := range tests {
-		t.Run("NAT", func(t *testing.T) {
-			t.Log("--------------------------------------")
-			t.Logf("Run test GetExistingRules: inIface=%q, outIface=%q, subnetCIDR=%q", tc.inIface, tc.outIface, tc.subnetCIDR)
-
-			getData, err := GetIptablesNAT()
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseAwgShow(tc.output)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
 			if err != nil {
-				t.Fatalf("error: failed to get iptables nat data: %v", err)
+				t.Fatalf("error: unexpected error: %v", err)
 			}
-			if len(getData.Chains) == 0 {
-				t.Fatal("error: no chains found in nat table; please add rules before running the test")
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("error: got %+v, want %+v", got, tc.want)
 			}
+		})
+	}
+}
 
-			obj := FilterIptablesOutput{getData}
-			_, err = obj.GetExistingRules(tc.inIface, tc.outIface, tc.subnetCIDR)
+// Testing the InterfaceOwner Managed and Describe methods.
+func TestInterfaceOwner(t *testing.T) {
+	type testCase struct {
+		name        string
+		owner       InterfaceOwner
+		wantManaged bool
+		wantDesc    string
+	}
 
-			if tc.wantError {
-				if err != nil {
-					t.Logf("info: expected error, test passed, %v", err)
-				}
-			} else {
-				if err != nil {
-					t.Fatalf("error: test failed, %v", err)
-				}
+	tests := []testCase{
+		{name: "brgaddwg", owner: InterfaceOwner{Kind: "tun", Pid: 42, Process: "wg"}, wantManaged: true, wantDesc: "userspace WireGuard device, brgaddwg process 42"},
+		{name: "brgaddawg", owner: InterfaceOwner{Kind: "tun", Pid: 42, Process: "awg"}, wantManaged: true, wantDesc: "userspace AmneziaWG device, brgaddawg process 42"},
+		{name: "kernel wireguard", owner: InterfaceOwner{Kind: "wireguard"}, wantManaged: true, wantDesc: "kernel WireGuard device"},
+		{name: "kernel amneziawg", owner: InterfaceOwner{Kind: "amneziawg"}, wantManaged: true, wantDesc: "kernel AmneziaWG device"},
+		{name: "foreign tun", owner: InterfaceOwner{Kind: "tun"}, wantDesc: "TUN device without a brgnetuse process"},
+		{name: "dummy", owner: InterfaceOwner{Kind: "dummy"}, wantDesc: "dummy device"},
+		{name: "physical", owner: InterfaceOwner{}, wantDesc: "physical network interface"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.owner.Managed(); got != tc.wantManaged {
+				t.Errorf("error: got managed %v, want %v", got, tc.wantManaged)
+			}
+			if got := tc.owner.Describe(); got != tc.wantDesc {
+				t.Errorf("error: got %q, want %q", got, tc.wantDesc)
 			}
+		})
+	}
+}
 
-			t.Log("info: test successful")
+// Testing the ReportedRate function.
+func TestReportedRate(t *testing.T) {
+	type testCase struct {
+		bits uint64
+		want uint64
+	}
 
-			t.Logf("End test GetExistingRules: inIface=%q, outIface=%q, subnetCIDR=%q", tc.inIface, tc.outIface, tc.subnetCIDR)
-			t.Log("--------------------------------------")
+	tests := []testCase{
+		{bits: 10_000_000, want: 10_000_000},
+		{bits: 1_500_000, want: 1_500_000},
+		{bits: 1_000_000_000, want: 1_000_000_000},
+		{bits: 10_000_001, want: 10_000_000},
+		{bits: 1_234_567, want: 1_234_000},
+		{bits: 800, want: 800},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprint(tc.bits), func(t *testing.T) {
+			if got := ReportedRate(tc.bits); got != tc.want {
+				t.Errorf("error: got %d, want %d", got, tc.want)
+			}
 		})
 	}
 }
 
-// Test function for testing the GetExistingPort function for NAT.
-func TestGetExistingPort(t *testing.T) {
+// Synthetic `iptables -L -v -n` listings of the filter and NAT tables.
+const (
+	testFilterListing = `Chain INPUT (policy ACCEPT 10 packets, 800 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    0     0 ACCEPT     udp  --  *      *       0.0.0.0/0            0.0.0.0/0            udp dpt:51820
+
+Chain FORWARD (policy DROP 5 packets, 300 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    1    60 ACCEPT     all  --  wg0    eth0    0.0.0.0/0            0.0.0.0/0
+    2   120 ACCEPT     all  --  eth0   wg0     0.0.0.0/0            0.0.0.0/0
+    3   180 ACCEPT     all  --  wg1    eth0    10.20.0.0/24         0.0.0.0/0
+    4   240 ACCEPT     all  --  *      *       0.0.0.0/0            0.0.0.0/0
+
+Chain OUTPUT (policy ACCEPT 0 packets, 0 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+`
+	testNATListing = `Chain PREROUTING (policy ACCEPT 0 packets, 0 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+
+Chain POSTROUTING (policy ACCEPT 7 packets, 420 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    5   300 MASQUERADE  all  --  *      eth0    10.10.10.0/24        0.0.0.0/0
+    6   360 MASQUERADE  all  --  *      eth0    10.20.0.0/24         0.0.0.0/0
+    7   420 MASQUERADE  all  --  *      wg0     10.10.10.5           0.0.0.0/0
+    8   480 MASQUERADE  all  --  *      eth1    0.0.0.0/0            0.0.0.0/0
+`
+)
+
+// Testing the FilterByInterface and FilterBySource methods of the
+// FilterIptablesOutput structure.
+func TestFilterIptablesRules(t *testing.T) {
+	filterRules, err := parseIptablesOutput(testFilterListing)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	natRules, err := parseIptablesOutput(testNATListing)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
 	type testCase struct {
-		port      string
+		name      string
+		rules     IptablesOutput
+		in        string
+		out       string
+		source    string
+		want      []int
 		wantError bool
 	}
 
 	tests := []testCase{
-		{port: "22", wantError: false},
-		{port: "80", wantError: false},
-		{port: "43601", wantError: true},
-		{port: "port", wantError: true},
+		{name: "forward_in_out", rules: filterRules, in: "wg0", out: "wg0", want: []int{1, 2}},
+		{name: "forward_in_only", rules: filterRules, in: "wg0", want: []int{1}},
+		{name: "forward_out_only", rules: filterRules, out: "wg0", want: []int{2}},
+		{name: "forward_no_wildcard", rules: filterRules, in: "*", out: "lo", want: nil},
+		{name: "forward_missing", rules: filterRules, in: "wg9", out: "wg9", want: nil},
+		{name: "forward_source", rules: filterRules, source: "10.20.0.0/16", want: []int{3}},
+		{name: "nat_out", rules: natRules, in: "eth0", out: "eth0", want: []int{5, 6}},
+		{name: "nat_wg_out", rules: natRules, in: "wg0", out: "wg0", want: []int{7}},
+		{name: "nat_source", rules: natRules, source: "10.10.10.0/24", want: []int{5, 7}},
+		{name: "nat_source_unmasked", rules: natRules, source: "10.10.10.1/24", want: []int{5, 7}},
+		{name: "nat_source_host", rules: natRules, source: "10.10.10.5/32", want: []int{7}},
+		{name: "nat_source_narrower", rules: natRules, source: "10.10.10.0/25", want: []int{7}},
+		{name: "nat_source_default", rules: natRules, source: "0.0.0.0/0", want: []int{5, 6, 7, 8}},
+		{name: "nat_source_ipv6", rules: natRules, source: "fd00::/64", want: nil},
+		{name: "nat_source_invalid", rules: natRules, source: "10.10.10.0", wantError: true},
 	}
 
 	for _, tc := range tests {
-		t.Run("NAT", func(t *testing.T) {
-			t.Log("--------------------------------------")
-			t.Logf("Run test GetExistingPort: %s", tc.port)
-
-			getData, err := GetIptablesFirewall()
-			if err != nil {
-				t.Fatalf("error: failed to get iptables nat data: %v", err)
-			}
-			if len(getData.Chains) == 0 {
-				t.Fatal(
-					"error: no chains found in nat table; please add rules before running the test",
-				)
+		t.Run(tc.name, func(t *testing.T) {
+			filter := FilterIptablesOutput{Rule: tc.rules}
+
+			var result IptablesOutput
+			if tc.source != "" {
+				result, err = filter.FilterBySource(tc.source)
+				if tc.wantError {
+					if err == nil {
+						t.Fatalf("error: expected error for %q", tc.source)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+			} else {
+				result = filter.FilterByInterface(tc.in, tc.out)
 			}
 
-			obj := FilterIptablesOutput{getData}
-			portExist, err := obj.GetExistingPort(tc.port)
+			if len(result.Chains) != len(tc.rules.Chains) {
+				t.Fatalf("error: got %d chains, want %d", len(result.Chains), len(tc.rules.Chains))
+			}
 
-			if tc.wantError {
-				if err == nil {
-					t.Logf("info: test passed, the port exists: %t", portExist)
-				} else {
-					t.Logf("error: test failed, %v", err)
+			var got []int
+			for indx, chain := range result.Chains {
+				header := tc.rules.Chains[indx]
+				if chain.Name != header.Name || chain.Policy != header.Policy ||
+					chain.Packets != header.Packets || chain.Bytes != header.Bytes {
+					t.Errorf("error: chain header %+v not preserved", chain)
 				}
-			} else {
-				if err == nil {
-					t.Logf("info: test passed, the port exists: %t", portExist)
-				} else {
-					t.Fatalf("error: test failed, %v", err)
+				for _, rule := range chain.Rules {
+					got = append(got, rule.Pkts)
 				}
 			}
-
-			t.Log("info: test successful")
-			t.Logf("End test GetExistingPort: %s", tc.port)
-			t.Log("--------------------------------------")
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("error: got rules %v, want %v", got, tc.want)
+			}
 		})
 	}
 
+	if len(filterRules.Chains[1].Rules) != 4 {
+		t.Errorf("error: the original rules were modified")
+	}
 }
 
-// Testing the GetIPvForwarding function.
-func TestGetIPvForwarding(t *testing.T) {
-	t.Run("GetIPvForwarding", func(t *testing.T) {
-		t.Log("--------------------------------------")
-		t.Log("Run test")
+// Testing the GetRuleId, GetChain, FilterByTarget and CountRules methods of
+// the FilterIptablesOutput structure.
+func TestFilterIptablesLookup(t *testing.T) {
+	rules := IptablesOutput{Chains: []IptablesChain{
+		{Name: "INPUT", Policy: "ACCEPT", Packets: 10, Bytes: 800},
+		{Name: "FORWARD", Policy: "DROP", Packets: 5, Bytes: 300, Rules: []IptablesRule{
+			{Id: 1, Target: "ACCEPT", In: "wg0", Out: "eth0"},
+			{Id: 2, Target: "DROP", In: "eth0", Out: "wg0"},
+		}},
+		{Name: "OUTPUT", Policy: "ACCEPT"},
+		{Name: "POSTROUTING", Policy: "ACCEPT", Rules: []IptablesRule{
+			{Id: 3, Target: "MASQUERADE", Out: "eth0", Source: "10.10.10.0/24"},
+			{Id: 4, Target: "ACCEPT", Out: "wg0"},
+		}},
+	}}
+	filter := FilterIptablesOutput{Rule: rules}
+
+	t.Run("rule_id", func(t *testing.T) {
+		type testCase struct {
+			id        int
+			wantChain string
+			wantError bool
+		}
+
+		tests := []testCase{
+			{id: 1, wantChain: "FORWARD"},
+			{id: 2, wantChain: "FORWARD"},
+			{id: 3, wantChain: "POSTROUTING"},
+			{id: 4, wantChain: "POSTROUTING"},
+			{id: 5, wantError: true},
+			{id: 0, wantError: true},
+			{id: -1, wantError: true},
+		}
 
-		data, err := GetIPvForwarding()
+		for _, tc := range tests {
+			result, err := filter.GetRuleId(tc.id)
+			if tc.wantError {
+				if err == nil {
+					t.Errorf("error: expected error for id %d, got %+v", tc.id, result)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error for id %d: %v", tc.id, err)
+			}
+			if len(result.Chains) != 1 || result.Chains[0].Name != tc.wantChain {
+				t.Fatalf("error: id %d got chains %+v, want %s", tc.id, result.Chains, tc.wantChain)
+			}
+			if rules := result.Chains[0].Rules; len(rules) != 1 || rules[0].Id != uint64(tc.id) {
+				t.Errorf("error: id %d got rules %+v", tc.id, rules)
+			}
+		}
+
+		// Ids are not contiguous in a filtered output.
+		sparse := FilterIptablesOutput{Rule: filter.FilterByTarget("ACCEPT")}
+		result, err := sparse.GetRuleId(4)
+		if err != nil || result.Chains[0].Name != "POSTROUTING" {
+			t.Errorf("error: got %+v, %v, want POSTROUTING", result, err)
+		}
+		if _, err := sparse.GetRuleId(2); err == nil {
+			t.Errorf("error: expected error for a filtered out rule")
+		}
+	})
+
+	t.Run("chain", func(t *testing.T) {
+		chain, err := filter.GetChain("FORWARD")
 		if err != nil {
-			t.Fatal("error GetIp: ", err)
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if chain.Policy != "DROP" || chain.Packets != 5 || len(chain.Rules) != 2 {
+			t.Errorf("error: got %+v", chain)
+		}
+		chain.Rules[0].Target = "REJECT"
+		if rules.Chains[1].Rules[0].Target != "ACCEPT" {
+			t.Errorf("error: the original rules were modified")
 		}
 
-		if len(data) == 0 {
-			t.Errorf("error: no IPv forwarding data received (length=0)")
-		} else {
-			t.Logf("info: received IPv forwarding data, length=%d", len(data))
+		empty, err := filter.GetChain("OUTPUT")
+		if err != nil || len(empty.Rules) != 0 {
+			t.Errorf("error: got %+v, %v, want an empty chain", empty, err)
 		}
 
-		t.Log("End test")
-		t.Log("--------------------------------------")
+		for _, name := range []string{"PREROUTING", "forward", ""} {
+			if _, err := filter.GetChain(name); err == nil {
+				t.Errorf("error: expected error for chain %q", name)
+			}
+		}
 	})
 
+	t.Run("target", func(t *testing.T) {
+		type testCase struct {
+			target string
+			want   []uint64
+		}
+
+		tests := []testCase{
+			{target: "ACCEPT", want: []uint64{1, 4}},
+			{target: "MASQUERADE", want: []uint64{3}},
+			{target: "accept", want: nil},
+			{target: "", want: nil},
+		}
+
+		for _, tc := range tests {
+			result := filter.FilterByTarget(tc.target)
+			if len(result.Chains) != len(rules.Chains) {
+				t.Fatalf("error: got %d chains, want %d", len(result.Chains), len(rules.Chains))
+			}
+
+			var got []uint64
+			for _, chain := range result.Chains {
+				for _, rule := range chain.Rules {
+					got = append(got, rule.Id)
+				}
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("error: target %q got %v, want %v", tc.target, got, tc.want)
+			}
+		}
+	})
+
+	t.Run("count", func(t *testing.T) {
+		if count := filter.CountRules(); count != 4 {
+			t.Errorf("error: got %d rules, want 4", count)
+		}
+		empty := FilterIptablesOutput{}
+		if count := empty.CountRules(); count != 0 {
+			t.Errorf("error: got %d rules, want 0", count)
+		}
+		masquerade := FilterIptablesOutput{Rule: filter.FilterByTarget("MASQUERADE")}
+		if count := masquerade.CountRules(); count != 1 {
+			t.Errorf("error: got %d rules, want 1", count)
+		}
+	})
 }
 
-// Testing the GetPeer function.
-func TestGetPeer(t *testing.T) {
+// Testing GetPortRules and GetExistingPortIn with the rule of an input
+// interface and the one of any interface opening the same port.
+func TestGetPortRules(t *testing.T) {
+	rules, err := parseIptablesOutput(`Chain INPUT (policy DROP 0 packets, 0 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    1     0 ACCEPT     udp  --  eth0   *       0.0.0.0/0            0.0.0.0/0            udp dpt:51820 /* brgnetuse */
+    2     0 ACCEPT     udp  --  *      *       0.0.0.0/0            0.0.0.0/0            udp dpt:51820 /* brgnetuse */
+    3     0 ACCEPT     udp  --  eth1   *       0.0.0.0/0            0.0.0.0/0            udp dpt:518200
+    4     0 ACCEPT     tcp  --  eth1   *       0.0.0.0/0            0.0.0.0/0            tcp dpt:51821
+
+Chain FORWARD (policy DROP 0 packets, 0 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    5     0 ACCEPT     udp  --  eth1   *       0.0.0.0/0            0.0.0.0/0            udp dpt:51821
+`)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	filter := FilterIptablesOutput{Rule: rules}
+
 	type testCase struct {
-		input     string
+		port      string
+		in        string
+		wantPkts  []int
 		wantError bool
 	}
 
 	tests := []testCase{
-		{input: "lo", wantError: true},
-		{input: "wg0", wantError: true},
-		{input: "qwerty", wantError: true},
+		{port: "51820", in: "eth0", wantPkts: []int{1}},
+		{port: "51820", in: "", wantPkts: []int{2}},
+		{port: "51820", in: "eth1"},
+		{port: "51821", in: "eth1"},
+		{port: "port", wantError: true},
 	}
 
 	for _, tc := range tests {
-		t.Run(tc.input, func(t *testing.T) {
-			t.Log("--------------------------------------")
-			t.Logf("Run test: interface=%q", tc.input)
-
-			devices, err := GetPeer(tc.input)
+		t.Run(tc.port+"_"+tc.in, func(t *testing.T) {
+			got, err := filter.GetPortRules(tc.port, tc.in)
 			if tc.wantError {
-				t.Logf("info: expected error received: %v", err)
-			} else {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
+			if err != nil {
 				t.Fatalf("error: unexpected error: %v", err)
 			}
 
-			if len(devices) > 0 {
-				for _, val := range devices {
-					t.Logf("info: received data: %v", val)
-				}
+			var pkts []int
+			for _, rule := range got {
+				pkts = append(pkts, rule.Pkts)
+			}
+			if !slices.Equal(pkts, tc.wantPkts) {
+				t.Errorf("error: got rules %v, want %v", pkts, tc.wantPkts)
 			}
 
-			t.Log("End test")
-			t.Log("--------------------------------------")
+			exists, _ := filter.GetExistingPortIn(tc.port, tc.in)
+			if exists != (len(tc.wantPkts) > 0) {
+				t.Errorf("error: got exists %t", exists)
+			}
 		})
 	}
 }
+
+// Testing the parsing of the rule comments and the Owner and
+// FilterByComment methods over a table of mixed ownership.
+func TestIptablesRuleComments(t *testing.T) {
+	rules, err := parseIptablesOutput(`Chain INPUT (policy ACCEPT 0 packets, 0 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    1     0 ACCEPT     udp  --  *      *       0.0.0.0/0            0.0.0.0/0            udp dpt:51820 /* brgnetuse */
+    2     0 ACCEPT     tcp  --  *      *       0.0.0.0/0            0.0.0.0/0            tcp dpt:22
+
+Chain FORWARD (policy DROP 0 packets, 0 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    3     0 ACCEPT     all  --  eth0   wg0     0.0.0.0/0            0.0.0.0/0            /* brgnetuse:wg0 */
+    4     0 ACCEPT     all  --  wg1    eth0    0.0.0.0/0            0.0.0.0/0            /* office link */
+    5     0 ACCEPT     all  --  wg2    eth0    0.0.0.0/0            0.0.0.0/0
+    6     0 ACCEPT     all  --  wg3    eth0    0.0.0.0/0            0.0.0.0/0            /* brgnetuseless */
+`)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	type testCase struct {
+		pkts        int
+		wantComment string
+		wantOwner   string
+		wantTagged  bool
+	}
+
+	tests := []testCase{
+		{pkts: 1, wantComment: "brgnetuse", wantOwner: "", wantTagged: true},
+		{pkts: 2, wantComment: ""},
+		{pkts: 3, wantComment: "brgnetuse:wg0", wantOwner: "wg0", wantTagged: true},
+		{pkts: 4, wantComment: "office link"},
+		{pkts: 5, wantComment: ""},
+		{pkts: 6, wantComment: "brgnetuseless"},
+	}
+
+	var all []IptablesRule
+	for _, chain := range rules.Chains {
+		all = append(all, chain.Rules...)
+	}
+	if len(all) != len(tests) {
+		t.Fatalf("error: got %d rules, want %d", len(all), len(tests))
+	}
+
+	for indx, tc := range tests {
+		rule := all[indx]
+		if rule.Pkts != tc.pkts || rule.Comment != tc.wantComment {
+			t.Errorf("error: rule %d got comment %q, want %q", tc.pkts, rule.Comment, tc.wantComment)
+		}
+		owner, tagged := rule.Owner()
+		if owner != tc.wantOwner || tagged != tc.wantTagged {
+			t.Errorf("error: rule %d got owner %q %t, want %q %t", tc.pkts, owner, tagged, tc.wantOwner, tc.wantTagged)
+		}
+	}
+
+	// The comment stays in the options.
+	if !strings.Contains(all[0].Options, "dpt:51820") || !strings.Contains(all[0].Options, "/* brgnetuse */") {
+		t.Errorf("error: got options %q", all[0].Options)
+	}
+
+	filter := FilterIptablesOutput{Rule: rules}
+	for comment, want := range map[string][]int{
+		"":              {2, 5},
+		"brgnetuse:wg0": {3},
+		"brgnetuse:wg9": nil,
+	} {
+		var got []int
+		for _, chain := range filter.FilterByComment(comment).Chains {
+			for _, rule := range chain.Rules {
+				got = append(got, rule.Pkts)
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("error: comment %q got rules %v, want %v", comment, got, want)
+		}
+	}
+}
+
+// Testing the parse warnings of a malformed listing: the lines the parser
+// cannot interpret are left out of the chains and listed, also in JSON.
+func TestParseIptablesWarnings(t *testing.T) {
+	const listing = `    9   540 ACCEPT     all  --  *      *       0.0.0.0/0            0.0.0.0/0
+Chain INPUT (policy ACCEPT 12K packets, 3M bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    0     0 ACCEPT     udp  --  *      *       0.0.0.0/0            0.0.0.0/0            udp dpt:51820
+    1    60 ACCEPT     all  --  wg0    eth0    0.0.0.0/0
+   x1    60 ACCEPT     all  --  wg0    eth0    0.0.0.0/0            0.0.0.0/0
+   2K  120K ACCEPT     all  --  eth0   wg0     0.0.0.0/0            0.0.0.0/0
+Chain
+
+Chain DOCKER (2 references)
+    3   180 ACCEPT     all  --  *      docker0 0.0.0.0/0            172.17.0.2
+`
+
+	result, err := parseIptablesOutput(listing)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	want := []ParseWarning{
+		{Line: 1, Reason: "rule outside a chain", Text: "9   540 ACCEPT     all  --  *      *       0.0.0.0/0            0.0.0.0/0"},
+		{Line: 5, Reason: "too few fields", Text: "1    60 ACCEPT     all  --  wg0    eth0    0.0.0.0/0"},
+		{Line: 6, Reason: "invalid counter", Text: "x1    60 ACCEPT     all  --  wg0    eth0    0.0.0.0/0            0.0.0.0/0"},
+		{Line: 8, Reason: "invalid chain header", Text: "Chain"},
+	}
+	if !slices.Equal(result.Warnings, want) {
+		t.Errorf("error: got warnings\n%v\nwant\n%v", result.Warnings, want)
+	}
+
+	if len(result.Chains) != 2 {
+		t.Fatalf("error: got %d chains, want 2", len(result.Chains))
+	}
+	input, docker := result.Chains[0], result.Chains[1]
+	if input.Packets != 12_000 || input.Bytes != 3_000_000 {
+		t.Errorf("error: got INPUT counters (%d, %d), want (12000, 3000000)", input.Packets, input.Bytes)
+	}
+	if len(input.Rules) != 2 || input.Rules[1].Pkts != 2_000 || input.Rules[1].Bytes != 120_000 || input.Rules[1].Id != 2 {
+		t.Errorf("error: got INPUT rules %+v", input.Rules)
+	}
+	if docker.References != 2 || len(docker.Rules) != 1 || docker.Rules[0].Id != 3 {
+		t.Errorf("error: got DOCKER chain %+v", docker)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Warnings []ParseWarning `json:"warnings"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(decoded.Warnings, want) {
+		t.Errorf("error: got JSON warnings %s", data)
+	}
+
+	// The filtered views keep the warnings.
+	filter := FilterIptablesOutput{Rule: result}
+	if got := filter.FilterByInterface("wg0", "wg0").Warnings; !slices.Equal(got, want) {
+		t.Errorf("error: got filtered warnings %v", got)
+	}
+}
+
+// Testing that a well-formed listing has no warnings.
+func TestParseIptablesComplete(t *testing.T) {
+	for _, listing := range []string{testFilterListing, testNATListing} {
+		result, err := parseIptablesOutput(listing)
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if len(result.Warnings) != 0 {
+			t.Errorf("error: got warnings %v", result.Warnings)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package get
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/src/proc"
+)
+
+// Severity is the outcome of a health check, ordered from best to worst.
+// Its value is the exit code of `brggetwg -check`.
+type Severity int
+
+const (
+	Healthy Severity = iota
+	Degraded
+	Critical
+)
+
+// Method returns the severity name.
+func (s Severity) String() string {
+	switch s {
+	case Healthy:
+		return "healthy"
+	case Degraded:
+		return "degraded"
+	default:
+		return "critical"
+	}
+}
+
+// Default thresholds of CheckHealth.
+const (
+	DefaultMaxHandshake time.Duration = 180 * time.Second
+	DefaultMinPeers     int           = 1
+)
+
+// HealthOptions configures CheckHealth.
+type HealthOptions struct {
+	// Interface is the WireGuard network interface name.
+	Interface string
+
+	// MaxHandshake is the maximum age of the latest peer handshake,
+	// 0 disables the check.
+	MaxHandshake time.Duration
+
+	// MinPeers is the minimum number of configured peers.
+	MinPeers int
+
+	// IgnoreNew skips peers that have never completed a handshake.
+	IgnoreNew bool
+}
+
+// HealthFailure is a single failed check.
+type HealthFailure struct {
+	// Check is the name of the check (e.g., "interface", "handshake").
+	Check string
+
+	// Severity is Degraded or Critical.
+	Severity Severity
+
+	// Message describes the failure.
+	Message string
+}
+
+// Method formats the failure as a single output line.
+func (f HealthFailure) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Severity, f.Check, f.Message)
+}
+
+// Report is the result of CheckHealth.
+type Report struct {
+	// Interface is the checked network interface name.
+	Interface string
+
+	// Status is the worst severity of the failures, Healthy without any.
+	Status Severity
+
+	// Failures lists the failed checks in evaluation order.
+	Failures []HealthFailure
+}
+
+// Method records a failed check and raises the report status.
+func (r *Report) fail(check string, severity Severity, format string, args ...any) {
+	r.Failures = append(r.Failures, HealthFailure{
+		Check:    check,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+	r.Status = max(r.Status, severity)
+}
+
+// healthState is the system state evaluated by CheckHealth. It is
+// collected separately so the evaluation can be tested on synthetic states.
+type healthState struct {
+	Exists bool
+	Up     bool
+
+	// Process is the type of the userspace process, "" when none runs.
+	Process string
+
+	Device    DeviceInfo
+	DeviceErr error
+
+	Subnets    []*net.IPNet
+	Forwarding map[string]int
+	ForwardErr error
+	Filter     IptablesOutput
+	FilterErr  error
+	NAT        IptablesOutput
+	NATErr     error
+
+	Now time.Time
+}
+
+// Function checks the health of a WireGuard network interface:
+//   - the interface exists and is up (critical);
+//   - the device is readable, i.e. the userspace process, if any, is alive (critical);
+//   - at least MinPeers peers are configured (degraded);
+//   - no peer handshake is older than MaxHandshake (degraded);
+//   - forwarding is enabled, the FORWARD chain accepts the traffic from
+//     and to the interface, and a MASQUERADE rule exists for every
+//     IPv4 subnet of the interface (degraded).
+//
+// An error is returned only when the state cannot be collected at all.
+//
+// Usage example:
+//
+//	report, err := get.CheckHealth(get.HealthOptions{
+//	    Interface:    "wg0",
+//	    MaxHandshake: get.DefaultMaxHandshake,
+//	    MinPeers:     get.DefaultMinPeers,
+//	})
+//	if err != nil {
+//	    // Handle error
+//	}
+//	os.Exit(int(report.Status))
+func CheckHealth(opts HealthOptions) (Report, error) {
+	if opts.Interface == "" {
+		return Report{}, fmt.Errorf("error: failed to get Wireguard network interface name")
+	}
+
+	state, err := collectHealth(opts.Interface)
+	if err != nil {
+		return Report{}, err
+	}
+
+	return evaluateHealth(state, opts), nil
+}
+
+// Function collects the system state of the network interface.
+func collectHealth(name string) (healthState, error) {
+	state := healthState{Now: time.Now()}
+
+	var iface *net.Interface
+	err := netns.Do(func() (err error) {
+		iface, err = net.InterfaceByName(name)
+		return err
+	})
+	if err != nil {
+		return state, nil
+	}
+	state.Exists = true
+	state.Up = iface.Flags&net.FlagUp != 0
+
+	state.Process, err = proc.ProcessType(name)
+	if err != nil {
+		return state, err
+	}
+
+	if state.Process == "awg" {
+		state.Device, state.DeviceErr = GetAwgPeerInfo(name)
+	} else if devices, err := GetPeerInfo(name); err != nil {
+		state.DeviceErr = err
+	} else {
+		state.Device = devices[0]
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return state, fmt.Errorf("error: failed to get IP address for interface '%s'. %v", name, err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			state.Subnets = append(state.Subnets, &net.IPNet{
+				IP:   ipNet.IP.Mask(ipNet.Mask),
+				Mask: ipNet.Mask,
+			})
+		}
+	}
+
+	state.Forwarding, state.ForwardErr = GetIPvForwarding()
+	state.Filter, state.FilterErr = GetIptablesFirewall()
+	state.NAT, state.NATErr = GetIptablesNAT()
+
+	return state, nil
+}
+
+// Function evaluates the collected state against the options.
+func evaluateHealth(state healthState, opts HealthOptions) Report {
+	report := Report{Interface: opts.Interface}
+
+	if !state.Exists {
+		report.fail("interface", Critical, "network interface `%s` not found", opts.Interface)
+		return report
+	}
+	if !state.Up {
+		report.fail("interface", Critical, "network interface `%s` is down", opts.Interface)
+	}
+
+	if state.DeviceErr != nil {
+		msg := errorMessage(state.DeviceErr)
+		if state.Process == "" {
+			msg = "no kernel device or userspace process, " + msg
+		}
+		report.fail("device", Critical, "%s", msg)
+	} else {
+		evaluatePeers(&report, state, opts)
+	}
+
+	evaluateRouting(&report, state)
+
+	return report
+}
+
+// Function evaluates the peer count and handshake age.
+func evaluatePeers(report *Report, state healthState, opts HealthOptions) {
+	peers := state.Device.Peers
+	if len(peers) < opts.MinPeers {
+		report.fail("peers", Degraded, "%d peers configured, expected at least %d", len(peers), opts.MinPeers)
+	}
+
+	if opts.MaxHandshake <= 0 {
+		return
+	}
+
+	for _, p := range peers {
+		label := p.PublicKey
+		if p.Name != "" {
+			label += " (" + p.Name + ")"
+		}
+
+		if p.LastHandshake == "" {
+			if !opts.IgnoreNew {
+				report.fail("handshake", Degraded, "peer %s has never completed a handshake", label)
+			}
+			continue
+		}
+
+		last, err := time.Parse(time.RFC3339, p.LastHandshake)
+		if err != nil {
+			report.fail("handshake", Degraded, "peer %s has an invalid handshake time", label)
+			continue
+		}
+		if age := state.Now.Sub(last); age > opts.MaxHandshake {
+			report.fail(
+				"handshake", Degraded, "peer %s latest handshake %s ago, threshold %s",
+				label, age.Truncate(time.Second), opts.MaxHandshake,
+			)
+		}
+	}
+}
+
+// Function evaluates the forwarding settings, the FORWARD rules of the
+// interface and the NAT rules of the subnets.
+func evaluateRouting(report *Report, state healthState) {
+	var v4, v6 []*net.IPNet
+	for _, subnet := range state.Subnets {
+		if subnet.IP.To4() != nil {
+			v4 = append(v4, subnet)
+		} else {
+			v6 = append(v6, subnet)
+		}
+	}
+
+	switch {
+	case state.ForwardErr != nil:
+		report.fail("forwarding", Degraded, "%s", errorMessage(state.ForwardErr))
+	default:
+		if len(v4) > 0 && state.Forwarding["ipv4"] != 1 {
+			report.fail("forwarding", Degraded, "net.ipv4.ip_forward is disabled")
+		}
+		if len(v6) > 0 && state.Forwarding["ipv6"] != 1 {
+			report.fail("forwarding", Degraded, "net.ipv6.conf.all.forwarding is disabled")
+		}
+	}
+
+	if len(v4) == 0 {
+		return
+	}
+
+	if state.FilterErr != nil {
+		report.fail("forward", Degraded, "%s", errorMessage(state.FilterErr))
+	} else {
+		if !hasForwardRule(state.Filter, report.Interface, true) {
+			report.fail("forward", Degraded, "no FORWARD rule accepting the traffic from %s", report.Interface)
+		}
+		if !hasForwardRule(state.Filter, report.Interface, false) {
+			report.fail("forward", Degraded, "no FORWARD rule accepting the traffic to %s", report.Interface)
+		}
+	}
+
+	if state.NATErr != nil {
+		report.fail("nat", Degraded, "%s", errorMessage(state.NATErr))
+		return
+	}
+
+	for _, subnet := range v4 {
+		if !hasMasquerade(state.NAT, subnet.String()) {
+			report.fail("nat", Degraded, "no MASQUERADE rule for subnet %s", subnet)
+		}
+	}
+}
+
+// Function reports whether the FORWARD chain accepts the traffic arriving
+// on the network interface, or with from false, the traffic leaving
+// through it: by its policy, or by an ACCEPT rule of the interface, tagged
+// (see firewall.RuleTag) or created before the rules were tagged.
+func hasForwardRule(filter IptablesOutput, iface string, from bool) bool {
+	for _, chain := range filter.Chains {
+		if chain.Name != "FORWARD" {
+			continue
+		}
+		if chain.Policy == "ACCEPT" {
+			return true
+		}
+		for _, rule := range chain.Rules {
+			if rule.Target != "ACCEPT" {
+				continue
+			}
+			if (from && rule.In == iface) || (!from && rule.Out == iface) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Function reports whether the NAT table masquerades the subnet.
+func hasMasquerade(nat IptablesOutput, subnet string) bool {
+	for _, chain := range nat.Chains {
+		if chain.Name != "POSTROUTING" {
+			continue
+		}
+		for _, rule := range chain.Rules {
+			if rule.Target == "MASQUERADE" && (rule.Source == subnet || rule.Source == "0.0.0.0/0") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Function returns the error message without the "error: " prefix.
+func errorMessage(err error) string {
+	return strings.TrimPrefix(err.Error(), "error: ")
+}
@@ -0,0 +1,179 @@
+package get
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// File names and permissions of a key pair written by WriteKeyPair.
+const (
+	PrivateKeyFile string = "privatekey"
+	PublicKeyFile  string = "publickey"
+
+	PrivateKeyPerm os.FileMode = 0o600
+	PublicKeyPerm  os.FileMode = 0o644
+)
+
+// Function generates a key pair and writes it to <dir>/privatekey (0600)
+// and <dir>/publickey (0644). Existing key files are never overwritten,
+// see WriteKeyPairForce.
+//
+// Returns the keys written, as GenerateKeyPair does.
+//
+// Usage example:
+//
+//	keys, err := get.WriteKeyPair("/etc/wireguard/wg0")
+//	if err != nil {
+//	    // Handle error
+//	}
+//	fmt.Println(keys.Public)
+func WriteKeyPair(dir string) (KeyPair, error) {
+	return writeKeyPair(dir, false)
+}
+
+// Function works like WriteKeyPair but replaces existing key files, once
+// both new ones are written: on failure the existing keys are kept.
+func WriteKeyPairForce(dir string) (KeyPair, error) {
+	return writeKeyPair(dir, true)
+}
+
+// Function generates and writes the key pair. The keys are written to
+// temporary files in dir first and only put in place once both are
+// written; the replaced private key is restored if the public key cannot
+// be put in place, so a failure leaves the existing key files as they were.
+func writeKeyPair(dir string, force bool) (KeyPair, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("error: failed to access key directory '%s': %w", dir, err)
+	}
+	if !info.IsDir() {
+		return KeyPair{}, fmt.Errorf("error: '%s' is not a directory", dir)
+	}
+
+	privPath := filepath.Join(dir, PrivateKeyFile)
+	pubPath := filepath.Join(dir, PublicKeyFile)
+
+	// Check both files first, so a refused call leaves nothing behind.
+	if !force {
+		for _, path := range []string{privPath, pubPath} {
+			if _, err := os.Lstat(path); err == nil {
+				return KeyPair{}, &ConflictError{Err: fmt.Errorf(
+					"error: key file '%s' already exists, use force to overwrite it", path,
+				)}
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return KeyPair{}, fmt.Errorf("error: failed to access key file '%s': %w", path, err)
+			}
+		}
+	}
+
+	keys, err := GenerateKeyPair()
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	privTmp, err := writeKeyFile(privPath, keys.Private, PrivateKeyPerm)
+	if err != nil {
+		keys.Zero()
+		return KeyPair{}, err
+	}
+	defer os.Remove(privTmp)
+
+	pubTmp, err := writeKeyFile(pubPath, keys.Public, PublicKeyPerm)
+	if err != nil {
+		keys.Zero()
+		return KeyPair{}, err
+	}
+	defer os.Remove(pubTmp)
+
+	// The existing private key is kept under a second name until the
+	// public key is in place, so a failed replacement of the public key
+	// puts it back instead of leaving a mismatched pair.
+	var privBackup string
+	if force {
+		backup := privTmp + ".old"
+		if err := os.Link(privPath, backup); err == nil {
+			privBackup = backup
+			defer os.Remove(backup)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			keys.Zero()
+			return KeyPair{}, fmt.Errorf("error: failed to keep key file '%s': %w", privPath, err)
+		}
+	}
+
+	if err := placeKeyFile(privTmp, privPath, force); err != nil {
+		keys.Zero()
+		return KeyPair{}, err
+	}
+	if err := placeKeyFile(pubTmp, pubPath, force); err != nil {
+		if privBackup != "" {
+			if restoreErr := os.Rename(privBackup, privPath); restoreErr != nil {
+				err = fmt.Errorf("%v, failed to restore key file '%s': %v", err, privPath, restoreErr)
+			}
+		} else {
+			os.Remove(privPath)
+		}
+		keys.Zero()
+		return KeyPair{}, err
+	}
+
+	return keys, nil
+}
+
+// Function writes the key to a temporary file next to path and returns
+// its name. The permissions are set explicitly, so the process umask
+// cannot loosen them, and the file is synced before it is put in place.
+func writeKeyFile(path string, key wgtypes.Key, perm os.FileMode) (string, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("error: failed to create key file '%s': %w", path, err)
+	}
+	tmp := file.Name()
+
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return "", fmt.Errorf("error: failed to set permissions of key file '%s': %w", path, err)
+	}
+
+	if _, err := file.WriteString(key.String() + "\n"); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return "", fmt.Errorf("error: failed to write key file '%s': %w", path, err)
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return "", fmt.Errorf("error: failed to write key file '%s': %w", path, err)
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("error: failed to write key file '%s': %w", path, err)
+	}
+	return tmp, nil
+}
+
+// Function puts the written temporary key file in place. With force it
+// replaces an existing file, otherwise it is linked, so a file created
+// meanwhile is never overwritten.
+func placeKeyFile(tmp, path string, force bool) error {
+	if force {
+		if err := os.Rename(tmp, path); err != nil {
+			return fmt.Errorf("error: failed to replace key file '%s': %w", path, err)
+		}
+		return nil
+	}
+
+	if err := os.Link(tmp, path); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return &ConflictError{Err: fmt.Errorf("error: key file '%s' already exists, use force to overwrite it", path)}
+		}
+		return fmt.Errorf("error: failed to create key file '%s': %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+//go:build !windows
+
+package get
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Testing the WriteKeyPair function: contents and permission bits.
+func TestWriteKeyPair(t *testing.T) {
+	// A permissive umask must not loosen the private key permissions.
+	prev := syscall.Umask(0)
+	t.Cleanup(func() { syscall.Umask(prev) })
+
+	dir := t.TempDir()
+	keys, err := WriteKeyPair(dir)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	type testCase struct {
+		file string
+		want string
+		perm os.FileMode
+	}
+
+	tests := []testCase{
+		{file: PrivateKeyFile, want: keys.Private.String(), perm: 0o600},
+		{file: PublicKeyFile, want: keys.Public.String(), perm: 0o644},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.file, func(t *testing.T) {
+			path := filepath.Join(dir, tc.file)
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if strings.TrimSpace(string(data)) != tc.want {
+				t.Errorf("error: unexpected content of %s", tc.file)
+			}
+
+			info, _ := os.Stat(path)
+			if info.Mode().Perm() != tc.perm {
+				t.Errorf("error: got mode %v, want %v", info.Mode().Perm(), tc.perm)
+			}
+		})
+	}
+}
+
+// Testing the overwrite guard of WriteKeyPair and WriteKeyPairForce.
+func TestWriteKeyPairOverwrite(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := WriteKeyPair(dir)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if _, err := WriteKeyPair(dir); err == nil {
+		t.Fatal("error: expected error for existing key files, got none")
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, PrivateKeyFile))
+	if strings.TrimSpace(string(data)) != first.Private.String() {
+		t.Fatal("error: refused call modified the private key file")
+	}
+
+	second, err := WriteKeyPairForce(dir)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	data, _ = os.ReadFile(filepath.Join(dir, PublicKeyFile))
+	if strings.TrimSpace(string(data)) != second.Public.String() {
+		t.Error("error: forced call did not replace the public key file")
+	}
+}
+
+// Testing that only the public key existing still blocks the write.
+func TestWriteKeyPairPartial(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, PublicKeyFile), []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := WriteKeyPair(dir); err == nil {
+		t.Fatal("error: expected error for an existing public key file, got none")
+	}
+	if _, err := os.Stat(filepath.Join(dir, PrivateKeyFile)); !os.IsNotExist(err) {
+		t.Error("error: private key file was written by a refused call")
+	}
+}
+
+// Testing that a forced write which fails keeps the existing key files.
+func TestWriteKeyPairForceFailure(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := WriteKeyPair(dir)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	UseKeyRand(t, strings.NewReader(""))
+
+	if _, err := WriteKeyPairForce(dir); err == nil {
+		t.Fatal("error: expected a generation error, got none")
+	}
+
+	for file, want := range map[string]string{
+		PrivateKeyFile: first.Private.String(),
+		PublicKeyFile:  first.Public.String(),
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil || strings.TrimSpace(string(data)) != want {
+			t.Errorf("error: %s was not kept (%v)", file, err)
+		}
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("error: got %d files, want the two key files only", len(entries))
+	}
+}
+
+// Testing that a failed replacement of the public key puts the replaced
+// private key back, or removes the new one when there was none.
+func TestWriteKeyPairForcePublicFailure(t *testing.T) {
+	for _, existing := range []bool{true, false} {
+		t.Run(fmt.Sprintf("existing %t", existing), func(t *testing.T) {
+			dir := t.TempDir()
+			privPath := filepath.Join(dir, PrivateKeyFile)
+
+			var old string
+			if existing {
+				key, err := wgtypes.GeneratePrivateKey()
+				if err != nil {
+					t.Fatal(err)
+				}
+				old = key.String() + "\n"
+				if err := os.WriteFile(privPath, []byte(old), PrivateKeyPerm); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// A non-empty directory cannot be replaced by the public key.
+			if err := os.MkdirAll(filepath.Join(dir, PublicKeyFile, "keep"), 0o700); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := WriteKeyPairForce(dir); err == nil {
+				t.Fatal("error: expected a replacement error, got none")
+			}
+
+			data, err := os.ReadFile(privPath)
+			switch {
+			case existing && (err != nil || string(data) != old):
+				t.Errorf("error: private key not restored, got %q (%v)", data, err)
+			case !existing && !errors.Is(err, os.ErrNotExist):
+				t.Errorf("error: new private key left without its public key (%v)", err)
+			}
+
+			want := 1
+			if existing {
+				want = 2
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != want {
+				t.Errorf("error: got files %v, want %d", entries, want)
+			}
+		})
+	}
+}
+
+// Testing that no key file is left when the keys cannot be generated.
+func TestWriteKeyPairRandFailure(t *testing.T) {
+	UseKeyRand(t, strings.NewReader("short"))
+
+	dir := t.TempDir()
+	if _, err := WriteKeyPair(dir); err == nil {
+		t.Fatal("error: expected a generation error, got none")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("error: got key files %v after the failure", entries)
+	}
+}
@@ -0,0 +1,204 @@
+package get
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/validate"
+)
+
+// Method reports whether the htb root qdisc of the rate limiting is installed.
+func (l TrafficLimits) Installed() bool {
+	return l.RootKind == "htb" && l.RootHandle == shell.TcRootHandle
+}
+
+// Method returns the class of the peer with the allowed IPs, found through
+// the egress filters matching one of its addresses.
+func (l TrafficLimits) PeerClass(allowedIPs []string) (TrafficClass, bool) {
+	prefixes := make([]string, 0, len(allowedIPs))
+	for _, ip := range allowedIPs {
+		if prefix, err := normalizePrefix(ip); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	for _, filter := range l.Filters {
+		if filter.Parent != shell.TcRootHandle || !slices.Contains(prefixes, filter.Prefix) {
+			continue
+		}
+		for _, class := range l.Classes {
+			if class.ClassID == filter.ClassID {
+				return class, true
+			}
+		}
+	}
+	return TrafficClass{}, false
+}
+
+// Method returns the egress and ingress filters of the class.
+func (l TrafficLimits) ClassFilters(classID string) []TrafficFilter {
+	var filters []TrafficFilter
+	for _, filter := range l.Filters {
+		if filter.ClassID == classID {
+			filters = append(filters, filter)
+		}
+	}
+	return filters
+}
+
+// Method returns the lowest free class identifier, starting at 1:10 so the
+// low minors stay available (tc minors are hexadecimal).
+func (l TrafficLimits) NextClassID() string {
+	used := make(map[uint64]bool, len(l.Classes))
+	for _, class := range l.Classes {
+		_, minor, _ := strings.Cut(class.ClassID, ":")
+		if n, err := strconv.ParseUint(minor, 16, 16); err == nil {
+			used[n] = true
+		}
+	}
+
+	minor := uint64(0x10)
+	for used[minor] {
+		minor++
+	}
+	return fmt.Sprintf("%s%x", shell.TcRootHandle, minor)
+}
+
+// Function parses `tc -j qdisc show` output into the root and ingress
+// qdisc fields of the limits.
+func parseTcQdiscs(data []byte, limits *TrafficLimits) error {
+	var qdiscs []struct {
+		Kind   string `json:"kind"`
+		Handle string `json:"handle"`
+		Root   bool   `json:"root"`
+	}
+	if err := json.Unmarshal(data, &qdiscs); err != nil {
+		return fmt.Errorf("error: failed to unmarshal tc qdisc JSON for interface '%s', %v", limits.Interface, err)
+	}
+
+	for _, qdisc := range qdiscs {
+		switch {
+		case qdisc.Root:
+			limits.RootKind, limits.RootHandle = qdisc.Kind, qdisc.Handle
+		case qdisc.Kind == "ingress":
+			limits.Ingress = true
+		}
+	}
+	return nil
+}
+
+// Function parses `tc class show` output into the htb classes of the
+// limits, for example:
+//
+//	class htb 1:10 root prio 0 rate 10Mbit ceil 10Mbit burst 1600b cburst 1600b
+func parseTcClasses(data string, limits *TrafficLimits) error {
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "class" || fields[1] != "htb" {
+			continue
+		}
+
+		indx := slices.Index(fields, "rate")
+		if indx < 0 || indx+1 >= len(fields) {
+			return fmt.Errorf("error: class '%s' of interface '%s' has no rate", fields[2], limits.Interface)
+		}
+		rate, err := validate.CheckRate(fields[indx+1])
+		if err != nil {
+			return fmt.Errorf("error: class '%s' of interface '%s', %v", fields[2], limits.Interface, err)
+		}
+
+		limits.Classes = append(limits.Classes, TrafficClass{ClassID: fields[2], Rate: rate})
+	}
+	return nil
+}
+
+// Function returns the rate in bits per second as tc reports it: htb keeps
+// whole bytes per second, and tc prints the rate in the largest unit that
+// keeps it whole, truncating once it is 1000 units or more.
+func ReportedRate(bits uint64) uint64 {
+	bits = bits / 8 * 8
+
+	factor := uint64(1)
+	for range 4 {
+		n := bits / factor
+		if n < 1000 || (n%1000 != 0 && n < 1000*1000) {
+			break
+		}
+		factor *= 1000
+	}
+	return bits / factor * factor
+}
+
+// Function parses `tc -j filter show` output of the parent qdisc into the
+// flower filters of the limits. Filters without a class or address are
+// not installed by the rate limiting and are skipped.
+func parseTcFilters(data []byte, parent string, limits *TrafficLimits) error {
+	var filters []struct {
+		Protocol string `json:"protocol"`
+		Pref     int    `json:"pref"`
+		Kind     string `json:"kind"`
+		Options  *struct {
+			// Handle is a number or a hexadecimal string, depending on
+			// the iproute2 version.
+			Handle  json.RawMessage   `json:"handle"`
+			ClassID string            `json:"classid"`
+			Keys    map[string]string `json:"keys"`
+		} `json:"options"`
+	}
+	if err := json.Unmarshal(data, &filters); err != nil {
+		return fmt.Errorf("error: failed to unmarshal tc filter JSON for interface '%s', %v", limits.Interface, err)
+	}
+
+	key := "dst_ip"
+	if parent == shell.TcIngressHandle {
+		key = "src_ip"
+	}
+
+	for _, filter := range filters {
+		if filter.Kind != "flower" || filter.Options == nil || filter.Options.ClassID == "" {
+			continue
+		}
+
+		prefix, err := normalizePrefix(filter.Options.Keys[key])
+		if err != nil {
+			continue
+		}
+		handle, err := strconv.ParseUint(strings.Trim(string(filter.Options.Handle), `"`), 0, 32)
+		if err != nil {
+			continue
+		}
+
+		limits.Filters = append(limits.Filters, TrafficFilter{
+			Parent:   parent,
+			Protocol: filter.Protocol,
+			Prio:     filter.Pref,
+			Handle:   uint32(handle),
+			ClassID:  filter.Options.ClassID,
+			Prefix:   prefix,
+		})
+	}
+	return nil
+}
+
+// Function returns the address or prefix in CIDR notation with the host
+// bits cleared; a bare address becomes a host prefix (/32, /128).
+func normalizePrefix(value string) (string, error) {
+	if !strings.Contains(value, "/") {
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return "", err
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()).String(), nil
+	}
+
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return "", err
+	}
+	return prefix.Masked().String(), nil
+}
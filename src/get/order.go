@@ -0,0 +1,163 @@
+package get
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Built-in iptables chains in the order a packet traverses them. They are
+// listed first, the user-defined chains (e.g., DOCKER) follow by name.
+var chainOrder = []string{"PREROUTING", "INPUT", "FORWARD", "OUTPUT", "POSTROUTING"}
+
+// Function orders the devices by name and their peers by public key, so a
+// listing is the same between invocations whatever the order the kernel
+// or the userspace device reports. A requested order is applied on top,
+// see SortPeers.
+func SortDevices(devices []DeviceInfo) {
+	slices.SortStableFunc(devices, func(a, b DeviceInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for i := range devices {
+		sortPeersByKey(devices[i].Peers)
+	}
+}
+
+// Function orders the peers by public key.
+func sortPeersByKey(peers []PeerInfo) {
+	slices.SortStableFunc(peers, func(a, b PeerInfo) int {
+		return strings.Compare(a.PublicKey, b.PublicKey)
+	})
+}
+
+// Function orders the chains in the canonical order (PREROUTING, INPUT,
+// FORWARD, OUTPUT, POSTROUTING, then the user-defined chains by name) and
+// the rules of each chain by their line in the listing (Id).
+func SortChains(chains []IptablesChain) {
+	rank := func(name string) int {
+		if i := slices.Index(chainOrder, name); i >= 0 {
+			return i
+		}
+		return len(chainOrder)
+	}
+
+	slices.SortStableFunc(chains, func(a, b IptablesChain) int {
+		if c := rank(a.Name) - rank(b.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	for i := range chains {
+		slices.SortStableFunc(chains[i].Rules, func(a, b IptablesRule) int {
+			switch {
+			case a.Id < b.Id:
+				return -1
+			case a.Id > b.Id:
+				return 1
+			}
+			return 0
+		})
+	}
+}
+
+// Page selects a window of a listing: the first Offset items are skipped,
+// then at most Limit items are kept, every remaining one when Limit is 0.
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// Method reports whether the page keeps the whole listing.
+func (p Page) IsZero() bool {
+	return p.Limit == 0 && p.Offset == 0
+}
+
+// Method returns the bounds of the window in a listing of total items.
+func (p Page) bounds(total int) (int, int) {
+	start := min(p.Offset, total)
+	end := total
+	if p.Limit > 0 {
+		end = min(start+p.Limit, total)
+	}
+	return start, end
+}
+
+// PageCount is the number of items a page shows out of the listing.
+type PageCount struct {
+	Shown int
+	Total int
+}
+
+// Method formats the footer of a paginated listing, e.g., "showing 10 of
+// 2000 peers".
+func (c PageCount) Format(noun string) string {
+	return fmt.Sprintf("showing %d of %d %s", c.Shown, c.Total, noun)
+}
+
+// Function returns the page of the peers of the devices, counted across
+// the devices in their order. Devices without a peer on the page are left
+// out.
+//
+// Usage example:
+//
+//	devices, _ := get.GetPeerInfo("wg0")
+//	devices, count := get.PagePeers(devices, get.Page{Limit: 50, Offset: 100})
+//	fmt.Println(count.Format("peers"))
+func PagePeers(devices []DeviceInfo, page Page) ([]DeviceInfo, PageCount) {
+	total := 0
+	for _, d := range devices {
+		total += len(d.Peers)
+	}
+	if page.IsZero() {
+		return devices, PageCount{Shown: total, Total: total}
+	}
+
+	start, end := page.bounds(total)
+	result := make([]DeviceInfo, 0, len(devices))
+	first := 0
+	for _, d := range devices {
+		lo, hi := max(start-first, 0), min(end-first, len(d.Peers))
+		first += len(d.Peers)
+		if lo >= hi {
+			continue
+		}
+		d.Peers = d.Peers[lo:hi]
+		result = append(result, d)
+	}
+
+	return result, PageCount{Shown: end - start, Total: total}
+}
+
+// Function returns the page of the rules, counted across the chains in
+// their order. Chains without a rule on the page are left out, the
+// warnings are kept.
+//
+// Usage example:
+//
+//	rules, _ := get.GetIptablesFirewall()
+//	rules, count := get.PageRules(rules, get.Page{Limit: 20})
+//	fmt.Println(count.Format("rules"))
+func PageRules(rules IptablesOutput, page Page) (IptablesOutput, PageCount) {
+	total := 0
+	for _, c := range rules.Chains {
+		total += len(c.Rules)
+	}
+	if page.IsZero() {
+		return rules, PageCount{Shown: total, Total: total}
+	}
+
+	start, end := page.bounds(total)
+	result := IptablesOutput{Chains: make([]IptablesChain, 0, len(rules.Chains)), Warnings: rules.Warnings}
+	first := 0
+	for _, c := range rules.Chains {
+		lo, hi := max(start-first, 0), min(end-first, len(c.Rules))
+		first += len(c.Rules)
+		if lo >= hi {
+			continue
+		}
+		c.Rules = c.Rules[lo:hi]
+		result.Chains = append(result.Chains, c)
+	}
+
+	return result, PageCount{Shown: end - start, Total: total}
+}
@@ -0,0 +1,192 @@
+package get
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// Testing that SortDevices orders shuffled devices and peers the same way
+// every time.
+func TestSortDevices(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	var want []string
+	var devices []DeviceInfo
+	for _, name := range []string{"wg0", "wg1", "wg2"} {
+		d := DeviceInfo{Name: name}
+		for i := range 50 {
+			key := fmt.Sprintf("%s-key%03d=", name, i)
+			want = append(want, key)
+			d.Peers = append(d.Peers, PeerInfo{PublicKey: key})
+		}
+		devices = append(devices, d)
+	}
+
+	for run := range 10 {
+		rng.Shuffle(len(devices), func(i, j int) { devices[i], devices[j] = devices[j], devices[i] })
+		for _, d := range devices {
+			rng.Shuffle(len(d.Peers), func(i, j int) { d.Peers[i], d.Peers[j] = d.Peers[j], d.Peers[i] })
+		}
+
+		SortDevices(devices)
+
+		var got []string
+		for _, d := range devices {
+			for _, p := range d.Peers {
+				got = append(got, p.PublicKey)
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("error: run %d: got peers %v, want %v", run, got, want)
+		}
+	}
+}
+
+// Testing that SortChains puts shuffled chains in the canonical order and
+// the rules of each chain by line.
+func TestSortChains(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+
+	names := []string{"PREROUTING", "INPUT", "FORWARD", "OUTPUT", "POSTROUTING", "DOCKER", "DOCKER-USER", "BRG"}
+	want := []string{"PREROUTING", "INPUT", "FORWARD", "OUTPUT", "POSTROUTING", "BRG", "DOCKER", "DOCKER-USER"}
+
+	var chains []IptablesChain
+	id := uint64(1)
+	for _, name := range names {
+		c := IptablesChain{Name: name}
+		for range 40 {
+			c.Rules = append(c.Rules, IptablesRule{Id: id})
+			id++
+		}
+		chains = append(chains, c)
+	}
+
+	for run := range 10 {
+		rng.Shuffle(len(chains), func(i, j int) { chains[i], chains[j] = chains[j], chains[i] })
+		for _, c := range chains {
+			rng.Shuffle(len(c.Rules), func(i, j int) { c.Rules[i], c.Rules[j] = c.Rules[j], c.Rules[i] })
+		}
+
+		SortChains(chains)
+
+		var got []string
+		for _, c := range chains {
+			got = append(got, c.Name)
+			if !slices.IsSortedFunc(c.Rules, func(a, b IptablesRule) int { return int(a.Id) - int(b.Id) }) {
+				t.Fatalf("error: run %d: rules of chain %s not ordered by line", run, c.Name)
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("error: run %d: got chains %v, want %v", run, got, want)
+		}
+	}
+}
+
+// Testing that the parsed listing is returned in the canonical chain order.
+func TestParseIptablesOrder(t *testing.T) {
+	output := `Chain DOCKER (1 references)
+ pkts bytes target     prot opt in     out     source               destination
+    0     0 ACCEPT     tcp  --  !docker0 docker0  0.0.0.0/0            172.17.0.2
+Chain OUTPUT (policy ACCEPT 0 packets, 0 bytes)
+Chain FORWARD (policy DROP 0 packets, 0 bytes)
+    0     0 ACCEPT     all  --  wg0    eth0    0.0.0.0/0            0.0.0.0/0
+Chain INPUT (policy ACCEPT 0 packets, 0 bytes)
+    0     0 ACCEPT     udp  --  *      *       0.0.0.0/0            0.0.0.0/0
+`
+	result, err := parseIptablesOutput(output)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, c := range result.Chains {
+		got = append(got, c.Name)
+	}
+	if want := []string{"INPUT", "FORWARD", "OUTPUT", "DOCKER"}; !slices.Equal(got, want) {
+		t.Errorf("error: got chains %v, want %v", got, want)
+	}
+}
+
+// Testing the PagePeers function across the peers of several devices.
+func TestPagePeers(t *testing.T) {
+	devices := []DeviceInfo{
+		{Name: "wg0", Peers: []PeerInfo{{PublicKey: "a"}, {PublicKey: "b"}, {PublicKey: "c"}}},
+		{Name: "wg1", Peers: []PeerInfo{{PublicKey: "d"}, {PublicKey: "e"}}},
+	}
+
+	type testCase struct {
+		name      string
+		page      Page
+		want      []string
+		wantCount PageCount
+	}
+
+	tests := []testCase{
+		{name: "all", page: Page{}, want: []string{"wg0:a", "wg0:b", "wg0:c", "wg1:d", "wg1:e"}, wantCount: PageCount{5, 5}},
+		{name: "limit", page: Page{Limit: 2}, want: []string{"wg0:a", "wg0:b"}, wantCount: PageCount{2, 5}},
+		{name: "across_devices", page: Page{Limit: 2, Offset: 2}, want: []string{"wg0:c", "wg1:d"}, wantCount: PageCount{2, 5}},
+		{name: "offset_only", page: Page{Offset: 3}, want: []string{"wg1:d", "wg1:e"}, wantCount: PageCount{2, 5}},
+		{name: "past_the_end", page: Page{Limit: 10, Offset: 4}, want: []string{"wg1:e"}, wantCount: PageCount{1, 5}},
+		{name: "beyond", page: Page{Offset: 9}, want: nil, wantCount: PageCount{0, 5}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, count := PagePeers(devices, tc.page)
+
+			var got []string
+			for _, d := range result {
+				for _, p := range d.Peers {
+					got = append(got, d.Name+":"+p.PublicKey)
+				}
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("error: got %v, want %v", got, tc.want)
+			}
+			if count != tc.wantCount {
+				t.Errorf("error: got count %+v, want %+v", count, tc.wantCount)
+			}
+		})
+	}
+}
+
+// Testing the PageRules function and the footer of the page.
+func TestPageRules(t *testing.T) {
+	rules := IptablesOutput{
+		Chains: []IptablesChain{
+			{Name: "INPUT", Rules: []IptablesRule{{Id: 1}, {Id: 2}}},
+			{Name: "FORWARD"},
+			{Name: "OUTPUT", Rules: []IptablesRule{{Id: 3}, {Id: 4}}},
+		},
+		Warnings: []ParseWarning{{Line: 9, Reason: "too few fields"}},
+	}
+
+	result, count := PageRules(rules, Page{Limit: 2, Offset: 1})
+
+	var got []uint64
+	var chains []string
+	for _, c := range result.Chains {
+		chains = append(chains, c.Name)
+		for _, r := range c.Rules {
+			got = append(got, r.Id)
+		}
+	}
+	if want := []uint64{2, 3}; !slices.Equal(got, want) {
+		t.Errorf("error: got rules %v, want %v", got, want)
+	}
+	if want := []string{"INPUT", "OUTPUT"}; !slices.Equal(chains, want) {
+		t.Errorf("error: got chains %v, want %v", chains, want)
+	}
+	if len(result.Warnings) != 1 {
+		t.Errorf("error: got %d warnings, want 1", len(result.Warnings))
+	}
+	if want := "showing 2 of 4 rules"; count.Format("rules") != want {
+		t.Errorf("error: got footer %q, want %q", count.Format("rules"), want)
+	}
+
+	if result, _ := PageRules(rules, Page{}); len(result.Chains) != 3 {
+		t.Errorf("error: got %d chains without a page, want 3", len(result.Chains))
+	}
+}
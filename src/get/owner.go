@@ -0,0 +1,99 @@
+package get
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/AlexKira/brgnetuse/internal/netns"
+)
+
+// ErrNotFound is matched (errors.Is) by the errors reporting that no network
+// interface has the address, see FindInterfacesByIP.
+var ErrNotFound = errors.New("error: no network interface has the address")
+
+// AddressOwner is a network interface holding an address.
+type AddressOwner struct {
+	Interface string    // Network interface name.
+	Prefix    net.IPNet // Address of the interface with its prefix length.
+}
+
+// Function returns the network interface holding the address ip (IPv4 or
+// IPv6, e.g., "10.10.10.254"), see FindInterfacesByIP. When several
+// interfaces match, the first one is returned.
+func FindInterfaceByIP(ip string, contains bool) (string, net.IPNet, error) {
+	owners, err := FindInterfacesByIP(ip, contains)
+	if err != nil {
+		return "", net.IPNet{}, err
+	}
+	return owners[0].Interface, owners[0].Prefix, nil
+}
+
+// Function returns every network interface holding the address ip, the same
+// address may be set on several interfaces (e.g., anycast). Without contains,
+// only the interfaces with exactly this address match. With contains, the
+// interfaces whose prefix contains the address match too, the most specific
+// prefix first. No match returns an error matching ErrNotFound.
+func FindInterfacesByIP(ip string, contains bool) ([]AddressOwner, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("error: invalid IP address '%s', example: 10.10.10.1", ip)
+	}
+
+	var all []AddressOwner
+	err := netns.Do(func() error {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return fmt.Errorf("error: failed to get network interfaces. %v", err)
+		}
+
+		for _, iface := range ifaces {
+			addrs, err := iface.Addrs()
+			if err != nil {
+				return fmt.Errorf(
+					"error: failed to get IP address for interface '%s'. %v", iface.Name, err,
+				)
+			}
+			for _, a := range addrs {
+				if ipNet, ok := a.(*net.IPNet); ok {
+					all = append(all, AddressOwner{Interface: iface.Name, Prefix: *ipNet})
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	owners := matchOwners(all, addr, contains)
+	if len(owners) == 0 {
+		return nil, fmt.Errorf("%w '%s'", ErrNotFound, ip)
+	}
+	return owners, nil
+}
+
+// Function returns the addresses matching ip, exactly or, with contains,
+// by their prefix. The exact matches come first, then the most specific
+// prefixes.
+func matchOwners(all []AddressOwner, ip net.IP, contains bool) []AddressOwner {
+	var owners []AddressOwner
+	for _, owner := range all {
+		if owner.Prefix.IP.Equal(ip) || contains && owner.Prefix.Contains(ip) {
+			owners = append(owners, owner)
+		}
+	}
+
+	rank := func(owner AddressOwner) int {
+		if owner.Prefix.IP.Equal(ip) {
+			return -1
+		}
+		ones, _ := owner.Prefix.Mask.Size()
+		return 128 - ones
+	}
+	slices.SortStableFunc(owners, func(a, b AddressOwner) int {
+		return rank(a) - rank(b)
+	})
+	return owners
+}
@@ -0,0 +1,128 @@
+package get
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// Testing the FindInterfaceByIP function with the loopback addresses.
+func TestFindInterfaceByIP(t *testing.T) {
+	type testCase struct {
+		name       string
+		ip         string
+		contains   bool
+		wantPrefix string
+		wantErr    error
+	}
+
+	tests := []testCase{
+		{name: "exact", ip: "127.0.0.1", wantPrefix: "127.0.0.1/8"},
+		{name: "exact contains", ip: "127.0.0.1", contains: true, wantPrefix: "127.0.0.1/8"},
+		{name: "in prefix", ip: "127.0.0.2", contains: true, wantPrefix: "127.0.0.1/8"},
+		{name: "in prefix not exact", ip: "127.0.0.2", wantErr: ErrNotFound},
+		{name: "unassigned", ip: "203.0.113.77", wantErr: ErrNotFound},
+		{name: "ipv6 unassigned", ip: "2001:db8::77", wantErr: ErrNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			name, prefix, err := FindInterfaceByIP(tc.ip, tc.contains)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("error: got %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if name != "lo" || prefix.String() != tc.wantPrefix {
+				t.Errorf("error: got %s %s, want lo %s", name, prefix.String(), tc.wantPrefix)
+			}
+		})
+	}
+}
+
+// Testing that FindInterfaceByIP finds the IPv6 loopback address.
+func TestFindInterfaceByIPv6(t *testing.T) {
+	info, err := GetInterfaceAddrs("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasIPv6 := false
+	for _, addr := range info.Addrs {
+		hasIPv6 = hasIPv6 || addr.IP.Equal(net.IPv6loopback)
+	}
+	if !hasIPv6 {
+		t.Skip("the loopback interface has no IPv6 address")
+	}
+
+	name, prefix, err := FindInterfaceByIP("::1", false)
+	if err != nil || name != "lo" || prefix.String() != "::1/128" {
+		t.Errorf("error: got %s %s %v, want lo ::1/128", name, prefix.String(), err)
+	}
+}
+
+// Testing that FindInterfaceByIP rejects an invalid address.
+func TestFindInterfaceByIPInvalid(t *testing.T) {
+	_, _, err := FindInterfaceByIP("10.10.10", false)
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("error: got %v, want an invalid address error", err)
+	}
+}
+
+// Testing the matching of several interfaces holding the same address.
+func TestMatchOwners(t *testing.T) {
+	prefix := func(cidr string) net.IPNet {
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ipNet.IP = ip
+		return *ipNet
+	}
+
+	all := []AddressOwner{
+		{Interface: "eth0", Prefix: prefix("10.10.0.1/16")},
+		{Interface: "wg0", Prefix: prefix("10.10.10.1/24")},
+		{Interface: "wg1", Prefix: prefix("10.10.10.254/24")},
+		{Interface: "wg2", Prefix: prefix("10.10.10.254/32")},
+		{Interface: "wg3", Prefix: prefix("fd00::254/64")},
+	}
+
+	type testCase struct {
+		name     string
+		ip       string
+		contains bool
+		want     []string
+	}
+
+	tests := []testCase{
+		{name: "anycast exact", ip: "10.10.10.254", want: []string{"wg1", "wg2"}},
+		{name: "anycast contains", ip: "10.10.10.254", contains: true, want: []string{"wg1", "wg2", "wg0", "eth0"}},
+		{name: "contains most specific", ip: "10.10.10.9", contains: true, want: []string{"wg0", "wg1", "eth0"}},
+		{name: "ipv6", ip: "fd00::1", contains: true, want: []string{"wg3"}},
+		{name: "none", ip: "10.20.0.1", contains: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			owners := matchOwners(all, net.ParseIP(tc.ip), tc.contains)
+
+			var got []string
+			for _, owner := range owners {
+				got = append(got, owner.Interface)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("error: got %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("error: got %v, want %v", got, tc.want)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package get
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Errors returned by FilterPeers. They are wrapped with the filter value,
+// use errors.Is to tell them apart.
+var (
+	ErrNoPeerMatch   = errors.New("error: no peer matches")
+	ErrAmbiguousPeer = errors.New("error: ambiguous peer key prefix")
+)
+
+// PeerFilter selects peers in FilterPeers. Empty fields match every peer.
+type PeerFilter struct {
+	// Key is a full public key (base64 encoded) or an unambiguous prefix.
+	Key string
+
+	// Endpoint is an IP address, matching any port, or an ip:port pair.
+	Endpoint string
+}
+
+// Method reports whether the filter selects every peer.
+func (f PeerFilter) IsZero() bool {
+	return f.Key == "" && f.Endpoint == ""
+}
+
+// Function returns the devices reduced to the peers selected by the filter.
+// Devices without a selected peer are left out.
+//
+// The key filter matches a single peer: a full key wins over prefix matches,
+// and a prefix matching several peers returns ErrAmbiguousPeer. When nothing
+// matches, ErrNoPeerMatch is returned.
+//
+// Usage example:
+//
+//	devices, _ := get.GetPeerInfo("wg0")
+//	devices, err := get.FilterPeers(devices, get.PeerFilter{Key: "xTIB"})
+//	if errors.Is(err, get.ErrNoPeerMatch) {
+//	    // Handle missing peer
+//	}
+func FilterPeers(devices []DeviceInfo, filter PeerFilter) ([]DeviceInfo, error) {
+	if filter.IsZero() {
+		return devices, nil
+	}
+
+	var matchEndpoint func(string) bool
+	if filter.Endpoint != "" {
+		match, err := endpointMatcher(filter.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		matchEndpoint = match
+	}
+
+	type match struct{ device, peer int }
+	var exact, prefix, selected []match
+
+	for i, d := range devices {
+		for j, p := range d.Peers {
+			if matchEndpoint != nil && !matchEndpoint(p.Endpoint) {
+				continue
+			}
+
+			switch {
+			case filter.Key == "":
+				selected = append(selected, match{i, j})
+			case p.PublicKey == filter.Key:
+				exact = append(exact, match{i, j})
+			case strings.HasPrefix(p.PublicKey, filter.Key):
+				prefix = append(prefix, match{i, j})
+			}
+		}
+	}
+
+	if filter.Key != "" {
+		switch {
+		case len(exact) > 0:
+			selected = exact
+		case len(prefix) > 1:
+			return nil, fmt.Errorf("%w: '%s' matches %d peers", ErrAmbiguousPeer, filter.Key, len(prefix))
+		default:
+			selected = prefix
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoPeerMatch, filter.describe())
+	}
+
+	result := make([]DeviceInfo, 0, len(devices))
+	for _, m := range selected {
+		if len(result) == 0 || result[len(result)-1].Name != devices[m.device].Name {
+			d := devices[m.device]
+			d.Peers = nil
+			result = append(result, d)
+		}
+		last := &result[len(result)-1]
+		last.Peers = append(last.Peers, devices[m.device].Peers[m.peer])
+	}
+
+	return result, nil
+}
+
+// Method describes the filter for error messages.
+func (f PeerFilter) describe() string {
+	parts := make([]string, 0, 2)
+	if f.Key != "" {
+		parts = append(parts, fmt.Sprintf("key '%s'", f.Key))
+	}
+	if f.Endpoint != "" {
+		parts = append(parts, fmt.Sprintf("endpoint '%s'", f.Endpoint))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// Function returns a matcher of peer endpoints for the filter value.
+// A bare IP address matches any port, an ip:port pair matches exactly.
+func endpointMatcher(value string) (func(string) bool, error) {
+	if addr, err := netip.ParseAddr(strings.Trim(value, "[]")); err == nil {
+		return func(endpoint string) bool {
+			host, _, err := net.SplitHostPort(endpoint)
+			if err != nil {
+				return false
+			}
+			peer, err := netip.ParseAddr(host)
+			return err == nil && peer.Unmap() == addr.Unmap()
+		}, nil
+	}
+
+	addrPort, err := netip.ParseAddrPort(value)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid endpoint '%s', expected ip or ip:port", value)
+	}
+	return func(endpoint string) bool {
+		peer, err := netip.ParseAddrPort(endpoint)
+		return err == nil &&
+			peer.Addr().Unmap() == addrPort.Addr().Unmap() &&
+			peer.Port() == addrPort.Port()
+	}, nil
+}
+
+// Sort keys accepted by SortPeers.
+const (
+	SortHandshake string = "handshake"
+	SortReceive   string = "rx"
+	SortTransmit  string = "tx"
+	SortIP        string = "ip"
+	SortKey       string = "key"
+)
+
+// Function sorts peers in place by the given key:
+//   - handshake: most recent first, peers that never handshaked last;
+//   - rx, tx: most bytes received or sent first;
+//   - ip: ascending by the first allowed IP, peers without one last;
+//   - key: ascending by public key.
+//
+// Ties are broken by public key, so the order is stable between
+// invocations. Reverse inverts the order of the sort key, peers that
+// never handshaked or have no allowed IP stay last.
+//
+// Usage example:
+//
+//	for i := range devices {
+//	    if err := get.SortPeers(devices[i].Peers, get.SortHandshake, false); err != nil {
+//	        // Handle error
+//	    }
+//	}
+func SortPeers(peers []PeerInfo, by string, reverse bool) error {
+	var compare func(a, b PeerInfo) (int, bool)
+
+	switch by {
+	case SortHandshake:
+		compare = func(a, b PeerInfo) (int, bool) {
+			if a.LastHandshake == "" || b.LastHandshake == "" {
+				return boolCompare(a.LastHandshake == "", b.LastHandshake == ""), true
+			}
+			ta, _ := time.Parse(time.RFC3339, a.LastHandshake)
+			tb, _ := time.Parse(time.RFC3339, b.LastHandshake)
+			return tb.Compare(ta), false
+		}
+	case SortReceive:
+		compare = func(a, b PeerInfo) (int, bool) {
+			return cmpDesc(a.ReceiveBytes, b.ReceiveBytes), false
+		}
+	case SortTransmit:
+		compare = func(a, b PeerInfo) (int, bool) {
+			return cmpDesc(a.TransmitBytes, b.TransmitBytes), false
+		}
+	case SortIP:
+		compare = func(a, b PeerInfo) (int, bool) {
+			pa, okA := firstPrefix(a)
+			pb, okB := firstPrefix(b)
+			if !okA || !okB {
+				return boolCompare(!okA, !okB), true
+			}
+			if c := pa.Addr().Compare(pb.Addr()); c != 0 {
+				return c, false
+			}
+			return pa.Bits() - pb.Bits(), false
+		}
+	case SortKey:
+		compare = func(a, b PeerInfo) (int, bool) {
+			return strings.Compare(a.PublicKey, b.PublicKey), false
+		}
+	default:
+		return fmt.Errorf(
+			"error: invalid sort key '%s', expected one of: %s, %s, %s, %s, %s",
+			by, SortHandshake, SortReceive, SortTransmit, SortIP, SortKey,
+		)
+	}
+
+	slices.SortStableFunc(peers, func(a, b PeerInfo) int {
+		c, fixed := compare(a, b)
+		if reverse && !fixed {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+		return strings.Compare(a.PublicKey, b.PublicKey)
+	})
+
+	return nil
+}
+
+// Function compares two values in descending order.
+func cmpDesc(a, b int64) int {
+	return cmp.Compare(b, a)
+}
+
+// Function orders false before true.
+func boolCompare(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// Function returns the first allowed IP of the peer.
+func firstPrefix(p PeerInfo) (netip.Prefix, bool) {
+	if len(p.AllowedIPs) == 0 {
+		return netip.Prefix{}, false
+	}
+	prefix, err := netip.ParsePrefix(p.AllowedIPs[0])
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	return prefix, true
+}
@@ -0,0 +1,228 @@
+package get
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/src/firewall"
+)
+
+// IptablesSave is the parsed output of iptables-save, the format read by
+// iptables-restore. Unlike the `iptables -L -v` listing, the rules keep
+// their exact specification, so they can be applied again.
+type IptablesSave struct {
+	// Tables lists the tables in the order of the output.
+	Tables []IptablesSaveTable
+}
+
+// IptablesSaveTable is a table (e.g., filter, nat) of the iptables-save output.
+type IptablesSaveTable struct {
+	// Name is the table name, the "*filter" line.
+	Name string
+
+	// Chains lists the chain declarations, the ":FORWARD DROP [0:0]" lines.
+	Chains []IptablesSaveChain
+
+	// Rules lists the rules, the "-A FORWARD ..." lines.
+	Rules []IptablesSaveRule
+}
+
+// IptablesSaveChain is a chain declaration of the iptables-save output.
+type IptablesSaveChain struct {
+	// Name is the chain name.
+	Name string
+
+	// Policy is the policy of a built-in chain, "-" for user-defined chains.
+	Policy string
+}
+
+// IptablesSaveRule is a rule of the iptables-save output.
+type IptablesSaveRule struct {
+	// Chain is the chain the rule is appended to.
+	Chain string
+
+	// Spec is the rule specification after the chain name
+	// (e.g., `-i wg0 -o eth0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`).
+	Spec string
+
+	// Comment is the value of the comment match, unquoted.
+	Comment string
+}
+
+// Function parses the output of iptables-save. Comment lines are skipped,
+// and the packet counters of `iptables-save -c` are dropped.
+// Returns an error for a line outside a table, an unknown line or a table
+// without COMMIT.
+//
+// Usage example:
+//
+//	saved, err := get.ParseIptablesSave(output)
+//	if err != nil {
+//	    // Handle error
+//	}
+//	fmt.Print(saved.Tagged().String())
+func ParseIptablesSave(output string) (IptablesSave, error) {
+	var result IptablesSave
+	var table *IptablesSaveTable
+
+	for number, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		invalid := fmt.Errorf("error: invalid iptables-save line %d: '%s'", number+1, line)
+
+		switch {
+		case strings.HasPrefix(line, "*"):
+			if table != nil {
+				return IptablesSave{}, fmt.Errorf("error: table '%s' is not committed", table.Name)
+			}
+			result.Tables = append(result.Tables, IptablesSaveTable{Name: line[1:]})
+			table = &result.Tables[len(result.Tables)-1]
+
+		case line == "COMMIT":
+			if table == nil {
+				return IptablesSave{}, invalid
+			}
+			table = nil
+
+		case strings.HasPrefix(line, ":"):
+			fields := strings.Fields(line[1:])
+			if table == nil || len(fields) < 2 {
+				return IptablesSave{}, invalid
+			}
+			table.Chains = append(table.Chains, IptablesSaveChain{Name: fields[0], Policy: fields[1]})
+
+		default:
+			// Counters of `iptables-save -c` (e.g., "[0:0] -A FORWARD ...").
+			if strings.HasPrefix(line, "[") {
+				if _, rest, ok := strings.Cut(line, "] "); ok {
+					line = strings.TrimSpace(rest)
+				}
+			}
+
+			rest, ok := strings.CutPrefix(line, "-A ")
+			if table == nil || !ok {
+				return IptablesSave{}, invalid
+			}
+			chain, spec, ok := strings.Cut(strings.TrimSpace(rest), " ")
+			if !ok {
+				return IptablesSave{}, invalid
+			}
+			spec = strings.TrimSpace(spec)
+
+			table.Rules = append(table.Rules, IptablesSaveRule{
+				Chain:   chain,
+				Spec:    spec,
+				Comment: specComment(spec),
+			})
+		}
+	}
+
+	if table != nil {
+		return IptablesSave{}, fmt.Errorf("error: table '%s' is not committed", table.Name)
+	}
+	return result, nil
+}
+
+// Method returns the tables with only the rules tagged by this tool
+// (see firewall.RuleTag), without the chain declarations: restored with
+// `iptables-restore --noflush`, they would reset the chain policies.
+// Tables without tagged rules are dropped.
+func (s IptablesSave) Tagged() IptablesSave {
+	var result IptablesSave
+	for _, table := range s.Tables {
+		tagged := IptablesSaveTable{Name: table.Name}
+		for _, rule := range table.Rules {
+			if rule.Comment == firewall.RuleTagPrefix || strings.HasPrefix(rule.Comment, firewall.RuleTagPrefix+":") {
+				tagged.Rules = append(tagged.Rules, rule)
+			}
+		}
+		if len(tagged.Rules) > 0 {
+			result.Tables = append(result.Tables, tagged)
+		}
+	}
+	return result
+}
+
+// Method returns the rules missing from the current tables, compared by
+// table, chain and specification.
+func (s IptablesSave) Missing(current IptablesSave) IptablesSave {
+	existing := make(map[[3]string]bool)
+	for _, table := range current.Tables {
+		for _, rule := range table.Rules {
+			existing[[3]string{table.Name, rule.Chain, rule.Spec}] = true
+		}
+	}
+
+	var result IptablesSave
+	for _, table := range s.Tables {
+		missing := IptablesSaveTable{Name: table.Name, Chains: table.Chains}
+		for _, rule := range table.Rules {
+			if !existing[[3]string{table.Name, rule.Chain, rule.Spec}] {
+				missing.Rules = append(missing.Rules, rule)
+			}
+		}
+		if len(missing.Rules) > 0 {
+			result.Tables = append(result.Tables, missing)
+		}
+	}
+	return result
+}
+
+// Method returns the number of rules in all tables.
+func (s IptablesSave) CountRules() int {
+	var count int
+	for _, table := range s.Tables {
+		count += len(table.Rules)
+	}
+	return count
+}
+
+// Method renders the tables in the iptables-save format.
+func (s IptablesSave) String() string {
+	var b strings.Builder
+	for _, table := range s.Tables {
+		fmt.Fprintf(&b, "*%s\n", table.Name)
+		for _, chain := range table.Chains {
+			fmt.Fprintf(&b, ":%s %s [0:0]\n", chain.Name, chain.Policy)
+		}
+		for _, rule := range table.Rules {
+			fmt.Fprintf(&b, "-A %s %s\n", rule.Chain, rule.Spec)
+		}
+		b.WriteString("COMMIT\n")
+	}
+	return b.String()
+}
+
+// Function returns the value of the comment match of a rule specification.
+// iptables-save quotes the value with double quotes and escapes the quotes
+// and backslashes inside it.
+func specComment(spec string) string {
+	_, after, ok := strings.Cut(spec, "--comment ")
+	if !ok {
+		return ""
+	}
+
+	if !strings.HasPrefix(after, `"`) {
+		value, _, _ := strings.Cut(after, " ")
+		return value
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(after); i++ {
+		switch after[i] {
+		case '\\':
+			if i+1 < len(after) {
+				i++
+				value.WriteByte(after[i])
+			}
+		case '"':
+			return value.String()
+		default:
+			value.WriteByte(after[i])
+		}
+	}
+	return value.String()
+}
@@ -0,0 +1,218 @@
+package get
+
+import (
+	"strings"
+	"testing"
+)
+
+// Output of `iptables-save -c` with brgnetuse, foreign and untagged rules.
+const testIptablesSave = `# Generated by iptables-save v1.8.7 on Mon Oct 12 10:00:00 2026
+*nat
+:PREROUTING ACCEPT [12:840]
+:INPUT ACCEPT [0:0]
+:OUTPUT ACCEPT [3:180]
+:POSTROUTING ACCEPT [0:0]
+[7:420] -A POSTROUTING -s 10.10.10.0/24 -o eth0 -m comment --comment "brgnetuse:wg0" -j MASQUERADE
+[0:0] -A POSTROUTING -s 10.20.0.0/24 -o eth0 -j MASQUERADE
+COMMIT
+# Completed on Mon Oct 12 10:00:00 2026
+*filter
+:INPUT ACCEPT [100:5000]
+:FORWARD DROP [0:0]
+:OUTPUT ACCEPT [90:4500]
+:DOCKER - [0:0]
+[1:60] -A INPUT -p udp -m udp --dport 51820 -m comment --comment brgnetuse -j ACCEPT
+[0:0] -A INPUT -p tcp -m tcp --dport 22 -m comment --comment "ssh \"admin\" access" -j ACCEPT
+[5:300] -A FORWARD -i eth0 -o wg0 -m comment --comment "brgnetuse:wg0" -j ACCEPT
+[5:300] -A FORWARD -i wg0 -o eth0 -m comment --comment "brgnetuse:wg0" -j ACCEPT
+[0:0] -A FORWARD -i wg3 -o eth0 -m comment --comment brgnetuseless -j ACCEPT
+[0:0] -A FORWARD -o docker0 -j DOCKER
+COMMIT
+`
+
+// Testing the ParseIptablesSave function: tables, chains, counters and comments.
+func TestParseIptablesSave(t *testing.T) {
+	saved, err := ParseIptablesSave(testIptablesSave)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if len(saved.Tables) != 2 || saved.Tables[0].Name != "nat" || saved.Tables[1].Name != "filter" {
+		t.Fatalf("error: got tables %+v, want nat and filter", saved.Tables)
+	}
+
+	filter := saved.Tables[1]
+	if len(filter.Chains) != 4 || filter.Chains[1] != (IptablesSaveChain{Name: "FORWARD", Policy: "DROP"}) ||
+		filter.Chains[3] != (IptablesSaveChain{Name: "DOCKER", Policy: "-"}) {
+		t.Errorf("error: got chains %+v", filter.Chains)
+	}
+
+	type testCase struct {
+		name        string
+		rule        IptablesSaveRule
+		wantChain   string
+		wantSpec    string
+		wantComment string
+	}
+
+	tests := []testCase{
+		{
+			name:        "quoted tag",
+			rule:        saved.Tables[0].Rules[0],
+			wantChain:   "POSTROUTING",
+			wantSpec:    `-s 10.10.10.0/24 -o eth0 -m comment --comment "brgnetuse:wg0" -j MASQUERADE`,
+			wantComment: "brgnetuse:wg0",
+		},
+		{
+			name:      "untagged",
+			rule:      saved.Tables[0].Rules[1],
+			wantChain: "POSTROUTING",
+			wantSpec:  "-s 10.20.0.0/24 -o eth0 -j MASQUERADE",
+		},
+		{
+			name:        "unquoted tag",
+			rule:        filter.Rules[0],
+			wantChain:   "INPUT",
+			wantSpec:    "-p udp -m udp --dport 51820 -m comment --comment brgnetuse -j ACCEPT",
+			wantComment: "brgnetuse",
+		},
+		{
+			name:        "escaped quotes",
+			rule:        filter.Rules[1],
+			wantChain:   "INPUT",
+			wantSpec:    `-p tcp -m tcp --dport 22 -m comment --comment "ssh \"admin\" access" -j ACCEPT`,
+			wantComment: `ssh "admin" access`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.rule.Chain != tc.wantChain || tc.rule.Spec != tc.wantSpec || tc.rule.Comment != tc.wantComment {
+				t.Errorf("error: got %+v, want chain %q spec %q comment %q",
+					tc.rule, tc.wantChain, tc.wantSpec, tc.wantComment)
+			}
+		})
+	}
+}
+
+// Testing the ParseIptablesSave function with invalid output.
+func TestParseIptablesSaveErrors(t *testing.T) {
+	type testCase struct {
+		name    string
+		output  string
+		wantErr string
+	}
+
+	tests := []testCase{
+		{
+			name:    "rule_outside_table",
+			output:  "-A INPUT -j ACCEPT\n",
+			wantErr: "error: invalid iptables-save line 1: '-A INPUT -j ACCEPT'",
+		},
+		{
+			name:    "missing_commit",
+			output:  "*filter\n-A INPUT -j ACCEPT\n",
+			wantErr: "error: table 'filter' is not committed",
+		},
+		{
+			name:    "nested_table",
+			output:  "*filter\n*nat\nCOMMIT\n",
+			wantErr: "error: table 'filter' is not committed",
+		},
+		{
+			name:    "unknown_line",
+			output:  "*filter\n-I INPUT -j ACCEPT\nCOMMIT\n",
+			wantErr: "error: invalid iptables-save line 2: '-I INPUT -j ACCEPT'",
+		},
+		{
+			name:    "rule_without_spec",
+			output:  "*filter\n-A INPUT\nCOMMIT\n",
+			wantErr: "error: invalid iptables-save line 2: '-A INPUT'",
+		},
+		{
+			name:    "stray_commit",
+			output:  "COMMIT\n",
+			wantErr: "error: invalid iptables-save line 1: 'COMMIT'",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseIptablesSave(tc.output)
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("error: got %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// Testing the Tagged and String methods against the expected restore file.
+func TestIptablesSaveTaggedGolden(t *testing.T) {
+	saved, err := ParseIptablesSave(testIptablesSave)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"*nat",
+		`-A POSTROUTING -s 10.10.10.0/24 -o eth0 -m comment --comment "brgnetuse:wg0" -j MASQUERADE`,
+		"COMMIT",
+		"*filter",
+		"-A INPUT -p udp -m udp --dport 51820 -m comment --comment brgnetuse -j ACCEPT",
+		`-A FORWARD -i eth0 -o wg0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`,
+		`-A FORWARD -i wg0 -o eth0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`,
+		"COMMIT",
+		"",
+	}, "\n")
+
+	tagged := saved.Tagged()
+	if got := tagged.String(); got != want {
+		t.Errorf("error: got\n%s\nwant\n%s", got, want)
+	}
+	if tagged.CountRules() != 4 {
+		t.Errorf("error: got %d rules, want 4", tagged.CountRules())
+	}
+
+	// The rendered rules parse back to the same rules.
+	again, err := ParseIptablesSave(tagged.String())
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if again.String() != want {
+		t.Errorf("error: round trip got\n%s", again.String())
+	}
+}
+
+// Testing the Missing method: rules already present are skipped.
+func TestIptablesSaveMissing(t *testing.T) {
+	saved, err := ParseIptablesSave(testIptablesSave)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	tagged := saved.Tagged()
+
+	current, err := ParseIptablesSave(strings.Join([]string{
+		"*filter",
+		":FORWARD DROP [0:0]",
+		`-A FORWARD -i eth0 -o wg0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`,
+		"COMMIT",
+		"*nat",
+		"-A OUTPUT -s 10.10.10.0/24 -o eth0 -m comment --comment \"brgnetuse:wg0\" -j MASQUERADE",
+		"COMMIT",
+	}, "\n"))
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	missing := tagged.Missing(current)
+	if missing.CountRules() != 3 {
+		t.Fatalf("error: got %d missing rules, want 3:\n%s", missing.CountRules(), missing.String())
+	}
+	if got := missing.Tables[1].Rules; len(got) != 2 || got[1].Spec != `-i wg0 -o eth0 -m comment --comment "brgnetuse:wg0" -j ACCEPT` {
+		t.Errorf("error: got filter rules %+v", got)
+	}
+
+	if tagged.Missing(tagged).CountRules() != 0 {
+		t.Errorf("error: rules missing from themselves")
+	}
+}
@@ -2,6 +2,24 @@
 
 package get
 
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// PeerMeta represents the human readable metadata (name, note) of a peer.
+type PeerMeta = peermeta.Meta
+
+// InterfaceChange represents the last change of the configuration of a
+// network interface (time, operation, user ID).
+type InterfaceChange = peermeta.Change
+
 // AddrInfoStructure represents information about an IP address.
 type AddrInfoStructure struct {
 	Family string `json:"family"`
@@ -28,9 +46,235 @@ type IpInterfaceStructure struct {
 	LinkType  string              `json:"link_type"`
 	Address   string              `json:"address"`
 	Broadcast string              `json:"broadcast"`
+	IfAlias   string              `json:"ifalias,omitempty"`
 	AddrInfo  []AddrInfoStructure `json:"addr_info"`
 }
 
+// LinkDetailStructure represents the link details of a network interface
+// (`ip -d -j link show`), reduced to the link kind.
+type LinkDetailStructure struct {
+	IfName   string `json:"ifname"`
+	LinkInfo struct {
+		// Kind is the link kind (e.g., "tun", "wireguard", "amneziawg", "dummy").
+		Kind string `json:"info_kind"`
+	} `json:"linkinfo"`
+}
+
+// InterfaceAddrs describes a network interface and its IP addresses,
+// see GetInterfaceAddrs.
+type InterfaceAddrs struct {
+	Name  string    // Network interface name.
+	Index int       // Network interface index.
+	Flags net.Flags // Network interface flags (e.g., net.FlagUp).
+	MTU   int
+	Addrs []net.IPNet // IP addresses with their prefix length.
+}
+
+// ErrInterfaceNotFound is matched (errors.Is) by the errors reporting a
+// missing network interface, see InterfaceNotFoundError.
+var ErrInterfaceNotFound = errors.New("error: network interface not found")
+
+// InterfaceNotFoundError reports a missing network interface, with the
+// existing WireGuard devices, when listed, to point out a mistyped name.
+type InterfaceNotFoundError struct {
+	// Name is the missing network interface name.
+	Name string
+
+	// Devices lists the existing WireGuard and AmneziaWG devices, nil when
+	// they were not listed.
+	Devices []string
+}
+
+// Method describes the missing interface and the existing devices.
+func (e *InterfaceNotFoundError) Error() string {
+	if e.Devices == nil {
+		return fmt.Sprintf("error: network interface '%s' not found", e.Name)
+	}
+
+	devices := "none"
+	if len(e.Devices) > 0 {
+		devices = strings.Join(e.Devices, ", ")
+	}
+	return fmt.Sprintf(
+		"error: network interface '%s' not found, existing WireGuard devices: %s",
+		e.Name, devices,
+	)
+}
+
+// Method returns the stable code of the error, see help.CodeInterfaceNotFound.
+func (e *InterfaceNotFoundError) ErrorCode() string {
+	return "BRG-E003"
+}
+
+// Method makes the error match ErrInterfaceNotFound.
+func (e *InterfaceNotFoundError) Is(target error) bool {
+	return target == ErrInterfaceNotFound
+}
+
+// PeerNotFoundError reports a peer missing from a network interface.
+type PeerNotFoundError struct {
+	// Interface is the network interface name.
+	Interface string
+
+	// Peer is the public key (base64 encoded) of the missing peer.
+	Peer string
+}
+
+// Method describes the missing peer.
+func (e *PeerNotFoundError) Error() string {
+	return fmt.Sprintf("error: peer '%s' not found on network interface '%s'", e.Peer, e.Interface)
+}
+
+// Method returns the stable code of the error, see help.CodePeerNotFound.
+func (e *PeerNotFoundError) ErrorCode() string {
+	return "BRG-E012"
+}
+
+// DeviceError reports a WireGuard device which could not be read or
+// configured through wgctrl.
+type DeviceError struct {
+	// Interface is the network interface name.
+	Interface string
+
+	// Op is the failed operation, e.g., "read" or "update".
+	Op string
+
+	// Err is the error of wgctrl.
+	Err error
+}
+
+// Method describes the failed operation.
+func (e *DeviceError) Error() string {
+	return fmt.Sprintf("error: failed to %s network interface '%s': %v", e.Op, e.Interface, e.Err)
+}
+
+// Method returns the error of wgctrl.
+func (e *DeviceError) Unwrap() error {
+	return e.Err
+}
+
+// Method returns the stable code of the error, see help.CodeDeviceFailed,
+// or help.CodeInterfaceNotFound when wgctrl found no such device.
+func (e *DeviceError) ErrorCode() string {
+	if errors.Is(e.Err, os.ErrNotExist) {
+		return "BRG-E003"
+	}
+	return "BRG-E015"
+}
+
+// ConflictError reports a port, an allowed IP, a file or a network
+// interface in use, or held by another owner. Where the message offers
+// force, it overrides the conflict.
+type ConflictError struct {
+	Err error
+}
+
+// Method returns the message of the wrapped error.
+func (e *ConflictError) Error() string {
+	return e.Err.Error()
+}
+
+// Method returns the wrapped error.
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// Method returns the stable code of the error, see help.CodeConflict.
+func (e *ConflictError) ErrorCode() string {
+	return "BRG-E014"
+}
+
+// InterfaceOwner describes an existing network interface and what serves it.
+type InterfaceOwner struct {
+	// Name is the network interface name.
+	Name string
+
+	// Exists reports whether the network interface exists.
+	Exists bool
+
+	// Kind is the link kind (e.g., "tun", "wireguard"), empty for a
+	// physical interface.
+	Kind string
+
+	// Pid is the userspace process tagged with the interface (brgaddwg,
+	// brgaddawg), 0 when none runs.
+	Pid int
+
+	// Process is the type ("wg", "awg") of the userspace process.
+	Process string
+}
+
+// DNS backends detected by GetDNSBackend.
+const (
+	DNSBackendResolved   = "systemd-resolved"
+	DNSBackendResolvconf = "resolvconf"
+)
+
+// InterfaceDNS represents the DNS servers of a network interface.
+type InterfaceDNS struct {
+	// Interface is the network interface name.
+	Interface string `json:"interface"`
+
+	// Backend is the DNS backend (DNSBackendResolved, DNSBackendResolvconf).
+	Backend string `json:"backend"`
+
+	// Servers lists the DNS server addresses of the interface.
+	Servers []string `json:"servers"`
+}
+
+// TrafficLimits represents the tc configuration of a network interface
+// used by the per-peer rate limiting (see set.SetPeerLimit): an htb root
+// qdisc with a class per limited peer, egress filters steering the traffic
+// sent to the peer into its class, and ingress filters policing the traffic
+// sent by the peer. Every filter carries the class of its peer.
+type TrafficLimits struct {
+	// Interface is the network interface name.
+	Interface string
+
+	// RootKind and RootHandle describe the root qdisc (e.g., "htb", "1:").
+	// A default root qdisc of the kernel has the handle "0:".
+	RootKind   string
+	RootHandle string
+
+	// Ingress reports whether the ingress qdisc is installed.
+	Ingress bool
+
+	// Classes lists the htb classes.
+	Classes []TrafficClass
+
+	// Filters lists the egress and ingress flower filters.
+	Filters []TrafficFilter
+}
+
+// TrafficClass is the htb class of a limited peer.
+type TrafficClass struct {
+	// ClassID is the class identifier (e.g., "1:10").
+	ClassID string
+
+	// Rate is the limit in bits per second.
+	Rate uint64
+}
+
+// TrafficFilter is a flower filter matching the address of a limited peer.
+type TrafficFilter struct {
+	// Parent is the qdisc of the filter, shell.TcRootHandle (egress) or
+	// shell.TcIngressHandle (ingress).
+	Parent string
+
+	// Protocol is "ip" or "ipv6".
+	Protocol string
+
+	// Prio and Handle identify the filter for deletion.
+	Prio   int
+	Handle uint32
+
+	// ClassID is the class of the peer.
+	ClassID string
+
+	// Prefix is the matched peer address (CIDR).
+	Prefix string
+}
+
 // IptablesRule represents a single rule within an iptables chain.
 //
 // It encapsulates the various fields associated with an iptables rule,
@@ -38,44 +282,48 @@ type IpInterfaceStructure struct {
 // input and output interfaces, and source/destination addresses.
 type IptablesRule struct {
 	// Identifier field in table rules.
-	Id uint64
+	Id uint64 `json:"id"`
 
 	// Pkts represents the number of packets that have matched this rule.
-	Pkts int
+	Pkts int `json:"pkts"`
 
 	// Bytes represents the total size (in bytes) of packets that have
 	// matched this rule.
-	Bytes int
+	Bytes int `json:"bytes"`
 
 	// Target specifies the action to take when a packet matches
 	// this rule (e.g., ACCEPT, DROP, REJECT).
-	Target string
+	Target string `json:"target"`
 
 	// Prot specifies the protocol that this rule applies to
 	// (e.g., tcp, udp, icmp).
-	Prot string
+	Prot string `json:"prot"`
 
 	// Opt specifies any additional options for the rule.
-	Opt string
+	Opt string `json:"opt"`
 
 	// In specifies the input interface that this rule applies to.
-	In string
+	In string `json:"in"`
 
 	// Out specifies the output interface that this rule applies to.
-	Out string
+	Out string `json:"out"`
 
 	// Source specifies the source address or network that this rule
 	// applies to.
-	Source string
+	Source string `json:"source"`
 
 	// Destination specifies the destination address or network that
 	// this rule applies to.
-	Destination string
+	Destination string `json:"destination"`
 
 	// Options specifies any additional match extensions or parameters for the rule,
 	// such as connection state (e.g., "ctstate RELATED,ESTABLISHED")
 	// or specific protocol options (e.g., "tcp dpt:22").
-	Options string
+	Options string `json:"options"`
+
+	// Comment is the text of the comment match of the rule, shown as
+	// "/* text */" in Options (e.g., "brgnetuse:wg0").
+	Comment string `json:"comment,omitempty"`
 }
 
 // IptablesChain represents an iptables chain, which is a collection of rules.
@@ -85,27 +333,27 @@ type IptablesRule struct {
 type IptablesChain struct {
 	// Name specifies the name of the iptables chain
 	// (e.g., INPUT, FORWARD, OUTPUT).
-	Name string
+	Name string `json:"name"`
 
 	// Policy specifies the default action to take when a packet
 	// does not match any rule in the chain.
-	Policy string
+	Policy string `json:"policy"`
 
 	// Packets represents the number of packets that have entered
 	// this chain.
-	Packets int
+	Packets int `json:"packets"`
 
 	// Bytes represents the total size (in bytes) of packets
 	// that have entered this chain.
-	Bytes int
+	Bytes int `json:"bytes"`
 
 	// References specifies the number of references to this chain.
 	// This field is populated for custom chains (e.g., DOCKER (2 references)).
-	References int
+	References int `json:"references"`
 
 	// Rules is a slice of IptablesRule structures representing
 	// the rules within this chain.
-	Rules []IptablesRule
+	Rules []IptablesRule `json:"rules"`
 }
 
 // IptablesOutput represents the complete output of an iptables command,
@@ -116,5 +364,171 @@ type IptablesChain struct {
 type IptablesOutput struct {
 	// Chains is a slice of IptablesChain structures, representing the
 	// different chains defined within the iptables firewall.
-	Chains []IptablesChain
+	Chains []IptablesChain `json:"chains"`
+
+	// Warnings lists the lines of the listing the parser could not
+	// interpret, left out of Chains. Empty for a complete view.
+	Warnings []ParseWarning `json:"warnings"`
+}
+
+// ParseWarning is a line of an iptables listing the parser could not
+// interpret.
+type ParseWarning struct {
+	// Line is the line number in the listing, starting at 1.
+	Line int `json:"line"`
+
+	// Text is the line as listed, without the surrounding spaces.
+	Text string `json:"text"`
+
+	// Reason describes why the line was skipped (e.g., "too few fields").
+	Reason string `json:"reason"`
+}
+
+// Method returns the warning as "line N: reason: text".
+func (w ParseWarning) String() string {
+	return fmt.Sprintf("line %d: %s: %s", w.Line, w.Reason, w.Text)
+}
+
+// DeviceInfo represents a WireGuard device in a JSON friendly form.
+//
+// Keys are base64 strings, times are RFC3339 and durations are seconds.
+// The private key of the device is never included.
+type DeviceInfo struct {
+	// Name is the network interface name.
+	Name string `json:"name"`
+
+	// Type is the device implementation (e.g., "Linux kernel", "userspace").
+	Type string `json:"type"`
+
+	// Backend describes the implementation serving the interface
+	// (see Backend), empty if it could not be detected.
+	Backend string `json:"backend,omitempty"`
+
+	// Implementation names the implementation and its version (e.g.,
+	// "kernel module wireguard 1.0.0"), empty if it could not be told, see
+	// GetInterfaceImplementation.
+	Implementation string `json:"implementation,omitempty"`
+
+	// PublicKey is the public key of the device (base64 encoded).
+	PublicKey string `json:"public_key"`
+
+	// ListenPort is the UDP port the device listens on.
+	ListenPort int `json:"listen_port"`
+
+	// FirewallMark is the fwmark applied to outgoing packets, 0 if unset.
+	FirewallMark int `json:"firewall_mark"`
+
+	// LastChange is the last change of the configuration made by brgsetwg,
+	// nil when none is recorded.
+	LastChange *InterfaceChange `json:"last_change,omitempty"`
+
+	// AwgParams holds the obfuscation parameters of an AmneziaWG device,
+	// nil for a WireGuard device.
+	AwgParams *AwgParams `json:"awg_params,omitempty"`
+
+	// Peers lists the peers of the device.
+	Peers []PeerInfo `json:"peers"`
+}
+
+// PeerInfo represents a WireGuard peer in a JSON friendly form.
+type PeerInfo struct {
+	// PublicKey is the public key of the peer (base64 encoded).
+	PublicKey string `json:"public_key"`
+
+	// Name, Note and Expires come from the peer metadata, when present.
+	Name    string `json:"name,omitempty"`
+	Note    string `json:"note,omitempty"`
+	Expires string `json:"expires,omitempty"`
+
+	// PresharedKey reports whether a preshared key is set. The key itself
+	// is never included.
+	PresharedKey bool `json:"preshared_key"`
+
+	// Endpoint is the peer endpoint (host:port), empty if unknown.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// PersistentKeepalive is the keepalive interval in seconds, 0 if disabled.
+	PersistentKeepalive int `json:"persistent_keepalive"`
+
+	// LastHandshake is the time of the last handshake (RFC3339), empty if none.
+	LastHandshake string `json:"last_handshake,omitempty"`
+
+	// ReceiveBytes and TransmitBytes are the transfer counters in bytes.
+	ReceiveBytes  int64 `json:"receive_bytes"`
+	TransmitBytes int64 `json:"transmit_bytes"`
+
+	// AllowedIPs lists the allowed IP networks in CIDR notation.
+	AllowedIPs []string `json:"allowed_ips"`
+
+	// ProtocolVersion is the WireGuard protocol version in use.
+	ProtocolVersion int `json:"protocol_version"`
+
+	// Quota is the transfer quota of the peer, from the peer metadata;
+	// nil without one.
+	Quota *QuotaInfo `json:"quota,omitempty"`
+}
+
+// QuotaInfo represents the transfer quota of a peer and its usage, as of
+// the last `brgsetwg -enforce-quotas` (see peermeta.Quota).
+type QuotaInfo struct {
+	// Limit is the number of bytes allowed per period, Used those
+	// transferred in the current one and Percent the usage of the limit.
+	Limit   uint64  `json:"limit"`
+	Used    uint64  `json:"used"`
+	Percent float64 `json:"percent"`
+
+	// Period is the length of a period in seconds, 0 if never reset.
+	Period int64 `json:"period,omitempty"`
+
+	// Reset is the start of the current period (RFC3339), empty before
+	// the first sample.
+	Reset string `json:"reset,omitempty"`
+}
+
+// AwgParams represents the obfuscation parameters of an AmneziaWG device,
+// as listed by `awg show <interface>`. The parameters a version of awg does
+// not list are left empty.
+type AwgParams struct {
+	// Jc is the number of junk packets sent before the handshake, Jmin
+	// and Jmax their size range in bytes.
+	Jc   int `json:"jc"`
+	Jmin int `json:"jmin"`
+	Jmax int `json:"jmax"`
+
+	// S1 to S4 are the junk sizes prepended to the handshake initiation,
+	// response, cookie and transport messages (S3 and S4 since AmneziaWG 1.5).
+	S1 int `json:"s1"`
+	S2 int `json:"s2"`
+	S3 int `json:"s3,omitempty"`
+	S4 int `json:"s4,omitempty"`
+
+	// H1 to H4 are the message type headers, a value or a range "min-max".
+	H1 string `json:"h1,omitempty"`
+	H2 string `json:"h2,omitempty"`
+	H3 string `json:"h3,omitempty"`
+	H4 string `json:"h4,omitempty"`
+
+	// I1 to I5 are the special junk packets (since AmneziaWG 1.5).
+	I1 string `json:"i1,omitempty"`
+	I2 string `json:"i2,omitempty"`
+	I3 string `json:"i3,omitempty"`
+	I4 string `json:"i4,omitempty"`
+	I5 string `json:"i5,omitempty"`
+}
+
+// KeyPair represents a WireGuard key pair, see GenerateKeyPair.
+type KeyPair struct {
+	Private wgtypes.Key
+	Public  wgtypes.Key
+}
+
+// Method returns the keys in the map of GenerateKeys, under "private" and
+// "public".
+func (k KeyPair) Map() map[string]wgtypes.Key {
+	return map[string]wgtypes.Key{"private": k.Private, "public": k.Public}
+}
+
+// Method overwrites the private key with zeros.
+func (k *KeyPair) Zero() {
+	clear(k.Private[:])
 }
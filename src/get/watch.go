@@ -0,0 +1,170 @@
+package get
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Sampler reads WireGuard devices repeatedly through a single wgctrl
+// client, so periodic views (watch mode, daemon, exporter) do not reopen
+// the client on every sample. Close releases the client.
+type Sampler struct {
+	client handlers.WgClient
+}
+
+// Function opens the wgctrl client of a Sampler.
+//
+// Usage example:
+//
+//	sampler, err := get.NewSampler()
+//	if err != nil {
+//	    // Handle error
+//	}
+//	defer sampler.Close()
+//
+//	devices, err := sampler.PeerInfo("wg0")
+func NewSampler() (*Sampler, error) {
+	client, err := handlers.InitWgCtlClient()
+	if err != nil {
+		return nil, fmt.Errorf("error: failed to open wgctrl, %v", err)
+	}
+	return &Sampler{client: client}, nil
+}
+
+// Method reads one device, or every device when interfaceName is empty.
+func (s *Sampler) Devices(interfaceName string) ([]*wgtypes.Device, error) {
+	return readDevices(s.client, interfaceName)
+}
+
+// Method reads devices in the JSON friendly form, see GetPeerInfo.
+func (s *Sampler) PeerInfo(interfaceName string) ([]DeviceInfo, error) {
+	devices, err := s.Devices(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+	return deviceInfo(devices)
+}
+
+// Method closes the wgctrl client.
+func (s *Sampler) Close() error {
+	return s.client.Close()
+}
+
+// PeerID identifies a peer across samples.
+type PeerID struct {
+	Interface string
+	PublicKey string
+}
+
+// PeerDelta describes how a peer changed between two samples.
+type PeerDelta struct {
+	// New reports a peer missing from the previous sample.
+	New bool
+
+	// Handshake reports a handshake newer than in the previous sample.
+	Handshake bool
+
+	// ReceiveBytes and TransmitBytes are the counter increments.
+	// A counter reset (e.g., the interface was recreated) yields 0.
+	ReceiveBytes  int64
+	TransmitBytes int64
+}
+
+// Method reports whether anything changed.
+func (d PeerDelta) Changed() bool {
+	return d.New || d.Handshake || d.ReceiveBytes != 0 || d.TransmitBytes != 0
+}
+
+// Function compares two samples and returns the changes of every peer in
+// the current sample. A nil previous sample yields no changes.
+func PeerDeltas(prev, cur []DeviceInfo) map[PeerID]PeerDelta {
+	deltas := make(map[PeerID]PeerDelta)
+	if prev == nil {
+		return deltas
+	}
+
+	before := make(map[PeerID]PeerInfo)
+	for _, d := range prev {
+		for _, p := range d.Peers {
+			before[PeerID{d.Name, p.PublicKey}] = p
+		}
+	}
+
+	for _, d := range cur {
+		for _, p := range d.Peers {
+			id := PeerID{d.Name, p.PublicKey}
+			old, ok := before[id]
+			if !ok {
+				deltas[id] = PeerDelta{New: true}
+				continue
+			}
+
+			deltas[id] = PeerDelta{
+				Handshake:     p.LastHandshake != old.LastHandshake && p.LastHandshake != "",
+				ReceiveBytes:  CounterDelta(old.ReceiveBytes, p.ReceiveBytes),
+				TransmitBytes: CounterDelta(old.TransmitBytes, p.TransmitBytes),
+			}
+		}
+	}
+
+	return deltas
+}
+
+// Function returns the increment of a counter between two samples. A
+// counter reset (e.g., the interface was recreated, the rules reloaded)
+// yields 0.
+func CounterDelta[T int | int64](prev, cur T) T {
+	return max(cur-prev, 0)
+}
+
+// Function returns the per-second rate of a counter increment over the
+// time between two samples, 0 without elapsed time.
+func Rate[T int | int64](delta T, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(delta) / elapsed.Seconds()
+}
+
+// Function calls fn immediately and then every interval until the context
+// is cancelled (e.g., on Ctrl-C via signal.NotifyContext). Cancellation
+// is a clean exit and returns nil, an error from fn stops the loop.
+//
+// Usage example:
+//
+//	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+//	defer stop()
+//
+//	err := get.Watch(ctx, 2*time.Second, func(ctx context.Context) error {
+//	    devices, err := sampler.PeerInfo("wg0")
+//	    // Render devices
+//	    return err
+//	})
+func Watch(ctx context.Context, interval time.Duration, fn func(context.Context) error) error {
+	if interval <= 0 {
+		return fmt.Errorf("error: invalid watch interval %s, must be positive", interval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := fn(ctx); err != nil {
+			if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Package proc finds the userspace WireGuard and AmneziaWG processes
+// (brgaddwg, brgaddawg) serving a network interface. The processes are
+// tagged with environment variables naming the interface, its type and the
+// implementation serving it, the proc filesystem is scanned for them.
+package proc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/version"
+)
+
+// Environment variables set on the userspace WireGuard processes
+// (brgaddwg, brgaddawg), used to find the process of a network interface.
+const (
+	EnvFieldType string = "ENV_PROTOCOL_TYPE"
+	EnvFieldTag  string = "ENV_PROTOCOL_TAG"
+)
+
+// Environment variable set on the supervisor of a userspace device
+// (-supervise). The supervisor is tagged like its device process and is
+// preferred by FindProcess, so stopping it stops both.
+const EnvFieldSupervisor string = "ENV_PROTOCOL_SUPERVISOR"
+
+// Environment variable recording the implementation of a userspace device
+// at launch (see Implementation), which the device cannot be asked for at
+// runtime.
+const EnvFieldImplementation string = "ENV_PROTOCOL_IMPLEMENTATION"
+
+// Module paths of the userspace implementations linked into the utilities.
+const (
+	modWireguardGo string = "golang.zx2c4.com/wireguard"
+	modAmneziawgGo string = "github.com/amnezia-vpn/amneziawg-go"
+)
+
+// Environment variable isolating independent deployments on one host:
+// the namespace is appended to the tag value of the started processes,
+// and only processes of the same namespace are found.
+const EnvNamespace string = "BRGNETUSE_NAMESPACE"
+
+// Namespace of the tagged processes. It is initialized from EnvNamespace
+// (empty by default) and may be replaced by callers and tests.
+var Namespace = os.Getenv(EnvNamespace)
+
+// ProcDir is the proc filesystem scanned by ProcessType.
+// Tests replace it with a directory of synthetic environ files.
+var ProcDir = "/proc"
+
+// Function returns the EnvFieldTag value of the network interface: the
+// interface name, followed by "@" and the namespace when one is set.
+func TagValue(iface string) string {
+	if Namespace == "" {
+		return iface
+	}
+	return iface + "@" + Namespace
+}
+
+// Function returns the environment of a process serving the network
+// interface: the environment of the calling process with the tag, type and
+// implementation of the interface, without the fields of another role
+// (e.g., a supervisor starting its device process).
+func ProcessEnv(wgType, iface string) []string {
+	var env []string
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		switch name {
+		case EnvFieldType, EnvFieldTag, EnvFieldSupervisor, EnvFieldImplementation:
+			continue
+		}
+		env = append(env, entry)
+	}
+
+	return append(
+		env,
+		fmt.Sprintf("%s=%s", EnvFieldType, wgType),
+		fmt.Sprintf("%s=%s", EnvFieldTag, TagValue(iface)),
+		fmt.Sprintf("%s=%s", EnvFieldImplementation, Implementation(wgType)),
+	)
+}
+
+// Function describes the userspace implementation of the type ("wg",
+// "awg") linked into the running binary and the brgnetuse version
+// (e.g., "wireguard-go v0.0.0-20231211153847-12269c276173, brgnetuse 1.4.0").
+// The dependency version is left out when it cannot be read.
+func Implementation(wgType string) string {
+	name, path := "wireguard-go", modWireguardGo
+	if wgType == "awg" {
+		name, path = "amneziawg-go", modAmneziawgGo
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range bi.Deps {
+			if dep.Path == path {
+				name += " " + dep.Version
+				break
+			}
+		}
+	}
+	return fmt.Sprintf("%s, brgnetuse %s", name, version.Get().Version)
+}
+
+// Function returns the implementation recorded in the environment of the
+// process at launch (see ProcessEnv), empty for a process started without
+// it (e.g., by an earlier release) or which cannot be read.
+func ProcessImplementation(pid int) string {
+	environ, err := os.ReadFile(filepath.Join(ProcDir, strconv.Itoa(pid), "environ"))
+	if err != nil {
+		return ""
+	}
+
+	prefix := []byte(EnvFieldImplementation + "=")
+	for _, entry := range bytes.Split(environ, []byte{0}) {
+		if value, ok := bytes.CutPrefix(entry, prefix); ok {
+			return string(value)
+		}
+	}
+	return ""
+}
+
+// Function returns the type ("wg", "awg") of the userspace process serving
+// the network interface, or an empty string when no such process runs
+// (e.g., a kernel interface or a crashed process).
+// An error is returned only if the proc directory cannot be read.
+func ProcessType(iface string) (string, error) {
+	_, wgType, err := FindProcess(iface)
+	return wgType, err
+}
+
+// Function finds the userspace process serving the network interface and
+// returns its pid and type ("wg", "awg"). The supervisor of a supervised
+// device is returned rather than the device process, processes of another
+// namespace and the processes with the pids exclude (e.g., the caller
+// itself) are ignored. The pid is 0 when no such process runs. An error is
+// returned only if the proc directory cannot be read.
+func FindProcess(iface string, exclude ...int) (int, string, error) {
+	dirs, err := os.ReadDir(ProcDir)
+	if err != nil {
+		return 0, "", fmt.Errorf("error: could not read directory %s: %w", ProcDir, err)
+	}
+
+	wantTag := []byte(EnvFieldTag + "=" + TagValue(iface))
+	typePrefix := []byte(EnvFieldType + "=")
+	supervisorTag := []byte(EnvFieldSupervisor + "=1")
+
+	var foundPid int
+	var foundType string
+	for _, subdir := range dirs {
+		pid, err := strconv.Atoi(subdir.Name())
+		if err != nil || slices.Contains(exclude, pid) {
+			continue
+		}
+
+		environ, err := os.ReadFile(filepath.Join(ProcDir, subdir.Name(), "environ"))
+		if err != nil {
+			continue
+		}
+
+		var tagged, supervisor bool
+		var wgType string
+		for _, entry := range bytes.Split(environ, []byte{0}) {
+			switch {
+			case bytes.Equal(entry, wantTag):
+				tagged = true
+			case bytes.Equal(entry, supervisorTag):
+				supervisor = true
+			case bytes.HasPrefix(entry, typePrefix):
+				wgType = string(entry[len(typePrefix):])
+			}
+		}
+
+		if !tagged || wgType == "" {
+			continue
+		}
+		if supervisor {
+			return pid, wgType, nil
+		}
+		if foundPid == 0 {
+			foundPid, foundType = pid, wgType
+		}
+	}
+
+	return foundPid, foundType, nil
+}
@@ -0,0 +1,253 @@
+package proc
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Testing the ProcessType function against a synthetic proc directory.
+func TestProcessType(t *testing.T) {
+	prev := ProcDir
+	ProcDir = t.TempDir()
+	t.Cleanup(func() { ProcDir = prev })
+
+	environ := map[string][]string{
+		"100":  {"PATH=/bin", EnvFieldTag + "=wg0", EnvFieldType + "=wg"},
+		"101":  {EnvFieldTag + "=awg01", EnvFieldType + "=awg"},
+		"102":  {EnvFieldType + "=awg", EnvFieldTag + "=awg0"},
+		"self": {EnvFieldTag + "=wg9", EnvFieldType + "=wg"},
+	}
+	for pid, env := range environ {
+		dir := filepath.Join(ProcDir, pid)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		data := []byte(strings.Join(env, "\x00") + "\x00")
+		if err := os.WriteFile(filepath.Join(dir, "environ"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type testCase struct {
+		iface string
+		want  string
+	}
+
+	tests := []testCase{
+		{iface: "wg0", want: "wg"},
+		{iface: "awg0", want: "awg"},
+		{iface: "awg", want: ""},
+		{iface: "wg9", want: ""},
+		{iface: "wg1", want: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.iface, func(t *testing.T) {
+			got, err := ProcessType(tc.iface)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing that FindProcess returns the pid of the tagged process.
+func TestFindProcess(t *testing.T) {
+	prev := ProcDir
+	ProcDir = t.TempDir()
+	t.Cleanup(func() { ProcDir = prev })
+
+	dir := filepath.Join(ProcDir, "4242")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	env := EnvFieldTag + "=wg0\x00" + EnvFieldType + "=wg\x00"
+	if err := os.WriteFile(filepath.Join(dir, "environ"), []byte(env), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	pid, wgType, err := FindProcess("wg0")
+	if err != nil || pid != 4242 || wgType != "wg" {
+		t.Errorf("error: got %d, %q, %v, want 4242, \"wg\", nil", pid, wgType, err)
+	}
+
+	pid, wgType, err = FindProcess("wg1")
+	if err != nil || pid != 0 || wgType != "" {
+		t.Errorf("error: got %d, %q, %v for a missing process", pid, wgType, err)
+	}
+}
+
+// Testing that tagged processes of independent namespaces are isolated.
+func TestProcessNamespace(t *testing.T) {
+	prevDir, prevNamespace := ProcDir, Namespace
+	ProcDir = t.TempDir()
+	t.Cleanup(func() { ProcDir, Namespace = prevDir, prevNamespace })
+
+	environ := map[string][]string{
+		"200": {EnvFieldTag + "=wg0", EnvFieldType + "=wg"},
+		"201": {EnvFieldTag + "=wg0@prod", EnvFieldType + "=awg"},
+		"202": {EnvFieldTag + "=wg1@test", EnvFieldType + "=wg"},
+		"203": {EnvFieldTag + "=awg0@prod", EnvFieldType + "=awg"},
+	}
+	for pid, env := range environ {
+		dir := filepath.Join(ProcDir, pid)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		data := []byte(strings.Join(env, "\x00") + "\x00")
+		if err := os.WriteFile(filepath.Join(dir, "environ"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type testCase struct {
+		namespace string
+		iface     string
+		wantPid   int
+		wantType  string
+		wantTag   string
+	}
+
+	tests := []testCase{
+		{namespace: "", iface: "wg0", wantPid: 200, wantType: "wg", wantTag: "wg0"},
+		{namespace: "", iface: "wg1", wantTag: "wg1"},
+		{namespace: "", iface: "awg0", wantTag: "awg0"},
+		{namespace: "prod", iface: "wg0", wantPid: 201, wantType: "awg", wantTag: "wg0@prod"},
+		{namespace: "prod", iface: "awg0", wantPid: 203, wantType: "awg", wantTag: "awg0@prod"},
+		{namespace: "prod", iface: "wg1", wantTag: "wg1@prod"},
+		{namespace: "test", iface: "wg1", wantPid: 202, wantType: "wg", wantTag: "wg1@test"},
+		{namespace: "test", iface: "wg0", wantTag: "wg0@test"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.namespace+"/"+tc.iface, func(t *testing.T) {
+			Namespace = tc.namespace
+
+			if got := TagValue(tc.iface); got != tc.wantTag {
+				t.Errorf("error: tag value got %q, want %q", got, tc.wantTag)
+			}
+
+			pid, wgType, err := FindProcess(tc.iface)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if pid != tc.wantPid || wgType != tc.wantType {
+				t.Errorf("error: got %d, %q, want %d, %q", pid, wgType, tc.wantPid, tc.wantType)
+			}
+		})
+	}
+}
+
+// Testing that FindProcess prefers the supervisor of a supervised device
+// and skips the excluded processes.
+func TestFindProcessSupervisor(t *testing.T) {
+	prev := ProcDir
+	ProcDir = t.TempDir()
+	t.Cleanup(func() { ProcDir = prev })
+
+	environ := map[int][]string{
+		4400:         {EnvFieldTag + "=wg0", EnvFieldType + "=wg"},
+		4500:         {EnvFieldTag + "=wg0", EnvFieldType + "=wg", EnvFieldSupervisor + "=1"},
+		os.Getppid(): {EnvFieldTag + "=wg1", EnvFieldType + "=wg", EnvFieldSupervisor + "=1"},
+	}
+	for pid, env := range environ {
+		dir := filepath.Join(ProcDir, strconv.Itoa(pid))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		data := []byte(strings.Join(env, "\x00") + "\x00")
+		if err := os.WriteFile(filepath.Join(dir, "environ"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pid, wgType, err := FindProcess("wg0")
+	if err != nil || pid != 4500 || wgType != "wg" {
+		t.Errorf("error: got %d, %q, %v, want the supervisor 4500", pid, wgType, err)
+	}
+
+	pid, _, err = FindProcess("wg1", os.Getppid())
+	if err != nil || pid != 0 {
+		t.Errorf("error: got pid %d, %v, want the excluded process skipped", pid, err)
+	}
+}
+
+// Testing that ProcessEnv replaces the fields of another role.
+func TestProcessEnv(t *testing.T) {
+	t.Setenv(EnvFieldTag, "wg9")
+	t.Setenv(EnvFieldType, "awg")
+	t.Setenv(EnvFieldSupervisor, "1")
+	t.Setenv(EnvFieldImplementation, "amneziawg-go v0.1.0, brgnetuse 0.9.0")
+
+	env := ProcessEnv("wg", "wg0")
+
+	var fields []string
+	for _, entry := range env {
+		if strings.HasPrefix(entry, "ENV_PROTOCOL_") {
+			fields = append(fields, entry)
+		}
+	}
+	want := []string{
+		EnvFieldType + "=wg",
+		EnvFieldTag + "=wg0",
+		EnvFieldImplementation + "=" + Implementation("wg"),
+	}
+	if strings.Join(fields, " ") != strings.Join(want, " ") {
+		t.Errorf("error: got %q, want %q", fields, want)
+	}
+}
+
+// Testing the implementation of a userspace type and its reading back
+// from a synthetic environ file.
+func TestProcessImplementation(t *testing.T) {
+	if got := Implementation("wg"); !strings.HasPrefix(got, "wireguard-go") || !strings.Contains(got, ", brgnetuse ") {
+		t.Errorf("error: got %q, want a wireguard-go implementation", got)
+	}
+	if got := Implementation("awg"); !strings.HasPrefix(got, "amneziawg-go") {
+		t.Errorf("error: got %q, want an amneziawg-go implementation", got)
+	}
+
+	prev := ProcDir
+	ProcDir = t.TempDir()
+	t.Cleanup(func() { ProcDir = prev })
+
+	environ := map[string][]string{
+		"100": {EnvFieldTag + "=wg0", EnvFieldType + "=wg", EnvFieldImplementation + "=wireguard-go v0.0.0-20231211153847-12269c276173, brgnetuse 1.4.0"},
+		"101": {EnvFieldTag + "=wg1", EnvFieldType + "=wg"},
+	}
+	for pid, env := range environ {
+		dir := filepath.Join(ProcDir, pid)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		data := []byte(strings.Join(env, "\x00") + "\x00")
+		if err := os.WriteFile(filepath.Join(dir, "environ"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type testCase struct {
+		pid  int
+		want string
+	}
+
+	tests := []testCase{
+		{pid: 100, want: "wireguard-go v0.0.0-20231211153847-12269c276173, brgnetuse 1.4.0"},
+		{pid: 101, want: ""},
+		{pid: 102, want: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(strconv.Itoa(tc.pid), func(t *testing.T) {
+			if got := ProcessImplementation(tc.pid); got != tc.want {
+				t.Errorf("error: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
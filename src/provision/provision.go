@@ -0,0 +1,403 @@
+// Package provision adds a client to a WireGuard network interface in a
+// single call: it generates the key pair of the client, picks a free
+// address in the subnet of the interface, adds the peer and renders the
+// client configuration (see the render package), as external tooling would
+// otherwise do in four steps.
+//
+// Usage example:
+//
+//	result, err := provision.CreatePeerWithAccess("wg0", provision.ProvisionOptions{
+//	    Name:     "alice",
+//	    Endpoint: "vpn.example.com",
+//	    DNS:      []string{"10.10.10.1"},
+//	})
+//	if err != nil {
+//	    // Handle error
+//	}
+//	fmt.Print(result.Config)
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/render"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/validate"
+)
+
+// Defaults of the options.
+const (
+	// Keepalive interval of the client in seconds: a client is usually
+	// behind a NAT, which forgets an idle mapping within a few minutes.
+	DefaultKeepalive = 25
+
+	// Format of the client configuration, see render.Formats.
+	DefaultFormat = render.FormatWgQuick
+)
+
+// Networks routed through the tunnel by default: all the traffic.
+var DefaultAllowedIPs = []string{"0.0.0.0/0", "::/0"}
+
+// Largest number of addresses of an IPv6 subnet tried for a free one.
+const maxCandidates = 1 << 16
+
+// ProvisionOptions holds the options of CreatePeerWithAccess. Only
+// Endpoint is mandatory.
+type ProvisionOptions struct {
+	// Name is the human readable name of the peer, stored in the peer
+	// metadata.
+	//
+	// Name is an optional field.
+	Name string
+
+	// Endpoint is the address of the server the client connects to,
+	// `host[:port]`. The port defaults to the listen port of the interface.
+	//
+	// Endpoint is a mandatory field.
+	Endpoint string
+
+	// DNS lists the DNS servers of the client.
+	//
+	// DNS is an optional field.
+	DNS []string
+
+	// AllowedIPs lists the networks the client routes through the tunnel,
+	// DefaultAllowedIPs when empty.
+	//
+	// AllowedIPs is an optional field.
+	AllowedIPs []string
+
+	// Keepalive is the keepalive interval of the client in seconds,
+	// DefaultKeepalive when zero. A negative value disables it.
+	//
+	// Keepalive is an optional field.
+	Keepalive int
+
+	// Expires is the moment after which the peer is removed by
+	// set.PruneExpiredPeers, as RFC3339 or YYYY-MM-DD (midnight UTC).
+	//
+	// Expires is an optional field.
+	Expires string
+
+	// Format is the built-in template of the client configuration (see
+	// render.Formats), DefaultFormat when empty.
+	//
+	// Format is an optional field.
+	Format string
+
+	// Template replaces the built-in template of Format, see render.Parse.
+	//
+	// Template is an optional field.
+	Template *template.Template
+
+	// QR requests the QR code of the client configuration (qrencode).
+	//
+	// QR is an optional field.
+	QR bool
+}
+
+// ProvisionResult is the client added by CreatePeerWithAccess.
+type ProvisionResult struct {
+	// Config is the rendered client configuration. It holds the private
+	// key of the client, which is kept nowhere else.
+	Config string
+
+	// QR is the QR code of Config as a PNG image, nil unless requested.
+	QR []byte
+
+	// Address is the address assigned to the client, in CIDR notation
+	// (e.g., "10.10.10.2/32"), the allowed IP of its peer.
+	Address string
+
+	// PublicKey is the public key of the client (base64), the key of its
+	// peer.
+	PublicKey string
+}
+
+// Method returns the options with the defaults of the empty fields.
+func (o ProvisionOptions) withDefaults() ProvisionOptions {
+	switch {
+	case o.Keepalive == 0:
+		o.Keepalive = DefaultKeepalive
+	case o.Keepalive < 0:
+		o.Keepalive = 0
+	}
+	if len(o.AllowedIPs) == 0 {
+		o.AllowedIPs = slices.Clone(DefaultAllowedIPs)
+	}
+	if o.Format == "" {
+		o.Format = DefaultFormat
+	}
+	if o.DNS == nil {
+		o.DNS = []string{}
+	}
+	return o
+}
+
+// Method checks the options before anything is changed.
+func (o ProvisionOptions) check() error {
+	if o.Endpoint == "" {
+		return help.Errorf(help.CodeInvalidArguments, "error: the client configuration requires the server endpoint")
+	}
+	if o.Keepalive > validate.MaxKeepalive {
+		return help.Errorf(
+			help.CodeInvalidValue,
+			"error: invalid keepalive %d, expected seconds in range 0-%d", o.Keepalive, validate.MaxKeepalive,
+		)
+	}
+	if len(o.DNS) > 0 {
+		if _, err := validate.CheckDNSServers(strings.Join(o.DNS, ",")); err != nil {
+			return err
+		}
+	}
+	if _, err := validate.CheckAllowedIPs(o.AllowedIPs); err != nil {
+		return err
+	}
+	if o.Expires != "" {
+		if _, err := validate.CheckExpiry(o.Expires); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function adds a client to the WireGuard network interface: it generates
+// a key pair, picks the first free address of the subnet of the interface
+// (IPv4 first), adds the peer with that address as its allowed IP, and
+// renders the client configuration, with its QR code when requested.
+//
+// The peer is removed again if the configuration (or the QR code) cannot
+// be rendered, so a failed call leaves no peer without a configuration.
+// The address is picked and the peer added under the operation lock, the
+// one held by the caller if handed (see oplock.Enter), so concurrent calls
+// never pick the same address.
+//
+// AmneziaWG interfaces are not supported.
+func CreatePeerWithAccess(iface string, opts ProvisionOptions, held ...*oplock.Lock) (ProvisionResult, error) {
+	opts = opts.withDefaults()
+	if err := validate.CheckInterfaceName(iface); err != nil {
+		return ProvisionResult{}, err
+	}
+	if err := opts.check(); err != nil {
+		return ProvisionResult{}, err
+	}
+
+	tmpl := opts.Template
+	if tmpl == nil {
+		var err error
+		if tmpl, err = render.Builtin(opts.Format); err != nil {
+			return ProvisionResult{}, err
+		}
+	}
+
+	lock, err := oplock.Enter(iface, held...)
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+	defer lock.Release()
+
+	return provisionPeer(iface, opts, tmpl, lock)
+}
+
+// Function adds the client under the operation lock, see
+// CreatePeerWithAccess.
+func provisionPeer(iface string, opts ProvisionOptions, tmpl *template.Template, lock *oplock.Lock) (ProvisionResult, error) {
+	device, err := serverDevice(iface)
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+	endpoint, err := serverEndpoint(opts.Endpoint, device.ListenPort)
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+
+	address, err := freeAddress(iface, device)
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+
+	keys, err := get.GenerateKeyPair()
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+	defer keys.Zero()
+
+	peer := set.SinglePeerStructure{
+		InterfaceName: iface,
+		PublicKey:     keys.Public.String(),
+		AllowedIPs:    []string{address.String()},
+		Name:          opts.Name,
+		Expires:       opts.Expires,
+		Verify:        true,
+	}
+	if err := peer.AddPeer(false, lock); err != nil {
+		return ProvisionResult{}, err
+	}
+
+	result := ProvisionResult{Address: address.String(), PublicKey: keys.Public.String()}
+	result.Config, err = render.Render(tmpl, render.Client{
+		Name:                iface,
+		ServerPublicKey:     device.PublicKey,
+		Endpoint:            endpoint,
+		PrivateKey:          keys.Private.String(),
+		PublicKey:           keys.Public.String(),
+		Address:             []string{address.String()},
+		DNS:                 opts.DNS,
+		AllowedIPs:          opts.AllowedIPs,
+		PersistentKeepalive: opts.Keepalive,
+	})
+	if err == nil && opts.QR {
+		result.QR, err = render.QRCode(result.Config, render.QRPNG)
+	}
+	if err != nil {
+		return ProvisionResult{}, rollback(peer, err, lock)
+	}
+	return result, nil
+}
+
+// Function removes the added peer after a failure, and returns the failure
+// with the one of the removal, if any.
+func rollback(peer set.SinglePeerStructure, err error, lock *oplock.Lock) error {
+	removal := set.SinglePeerStructure{InterfaceName: peer.InterfaceName, PublicKey: peer.PublicKey}
+	if removeErr := removal.RemovePeer(lock); removeErr != nil {
+		return errors.Join(err, fmt.Errorf(
+			"error: failed to remove peer '%s' again: %v", peer.PublicKey, removeErr,
+		))
+	}
+	return err
+}
+
+// Function returns the WireGuard device of the network interface.
+func serverDevice(iface string) (get.DeviceInfo, error) {
+	backend, err := get.GetInterfaceBackend(iface)
+	if err != nil {
+		return get.DeviceInfo{}, err
+	}
+	if backend.AmneziaWG() {
+		return get.DeviceInfo{}, help.Errorf(
+			help.CodeUnsupported,
+			"error: provisioning is not supported for AmneziaWG interface '%s'", iface,
+		)
+	}
+
+	devices, err := get.GetPeerInfo(iface)
+	if err != nil {
+		return get.DeviceInfo{}, err
+	}
+	if len(devices) != 1 {
+		return get.DeviceInfo{}, &get.InterfaceNotFoundError{Name: iface}
+	}
+	return devices[0], nil
+}
+
+// Function returns the endpoint of the client configuration, `host:port`:
+// the port defaults to the listen port of the server.
+func serverEndpoint(value string, listenPort int) (string, error) {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+		port = strconv.Itoa(listenPort)
+	}
+
+	number, err := validate.CheckPort(port)
+	if err == nil && (number < 1 || number > 65535) {
+		err = help.Errorf(help.CodeInvalidValue, "error: invalid endpoint port %d in '%s', expected range 1-65535", number, value)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := net.JoinHostPort(host, port)
+	if _, err := netip.ParseAddr(host); err != nil {
+		if _, _, err := validate.CheckEndpointHost(endpoint); err != nil {
+			return "", err
+		}
+	}
+	return endpoint, nil
+}
+
+// Function returns the first free host address of the subnets of the
+// network interface, as a single-address prefix: not an address of the
+// interface, nor covered by the allowed IPs of a peer. The IPv4 subnets
+// are tried first, link-local ones never.
+func freeAddress(iface string, device get.DeviceInfo) (netip.Prefix, error) {
+	show, err := get.GetIpShow(iface)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	var own []netip.Addr
+	var subnets []netip.Prefix
+	for _, link := range show {
+		for _, info := range link.AddrInfo {
+			prefix, err := netip.ParsePrefix(fmt.Sprintf("%s/%d", info.Local, info.Prefixlen))
+			if err != nil || info.Scope == "link" || prefix.Addr().IsLinkLocalUnicast() {
+				continue
+			}
+			own = append(own, prefix.Addr())
+			if subnet := prefix.Masked(); !slices.Contains(subnets, subnet) {
+				subnets = append(subnets, subnet)
+			}
+		}
+	}
+	if len(subnets) == 0 {
+		return netip.Prefix{}, help.Errorf(
+			help.CodeNotFound,
+			"error: network interface '%s' has no address to assign a client address from", iface,
+		)
+	}
+	slices.SortStableFunc(subnets, func(a, b netip.Prefix) int {
+		switch {
+		case a.Addr().Is4() == b.Addr().Is4():
+			return 0
+		case a.Addr().Is4():
+			return -1
+		}
+		return 1
+	})
+
+	var used []netip.Prefix
+	for _, peer := range device.Peers {
+		for _, allowed := range peer.AllowedIPs {
+			if prefix, err := netip.ParsePrefix(allowed); err == nil {
+				used = append(used, prefix.Masked())
+			}
+		}
+	}
+
+	for _, subnet := range subnets {
+		last := lastAddr(subnet)
+		candidate := subnet.Addr().Next()
+		for tried := 0; candidate.IsValid() && subnet.Contains(candidate) && tried < maxCandidates; tried++ {
+			free := !slices.Contains(own, candidate) &&
+				!slices.ContainsFunc(used, func(p netip.Prefix) bool { return p.Contains(candidate) }) &&
+				!(candidate.Is4() && candidate == last)
+			if free {
+				return netip.PrefixFrom(candidate, candidate.BitLen()), nil
+			}
+			candidate = candidate.Next()
+		}
+	}
+	return netip.Prefix{}, help.Errorf(help.CodeConflict, "error: no free client address left on network interface '%s'", iface)
+}
+
+// Function returns the last address of the prefix (the IPv4 broadcast
+// address).
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Masked().Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 0x80 >> (bit % 8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
@@ -0,0 +1,313 @@
+package provision
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/internal/shellmock"
+	"github.com/AlexKira/brgnetuse/internal/wgmock"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/render"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Function points the operation lock at a temporary file for the tests.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "brgnetuse-lock-")
+	if err != nil {
+		panic(err)
+	}
+	oplock.Path = filepath.Join(dir, "brgnetuse.lock")
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Addresses of wg0: an IPv4 and an IPv6 subnet, and a link-local address.
+const ipShowWg0 = `[{"ifindex":5,"ifname":"wg0","addr_info":[` +
+	`{"family":"inet6","local":"fd00::1","prefixlen":64,"scope":"global"},` +
+	`{"family":"inet","local":"10.10.10.1","prefixlen":24,"scope":"global"},` +
+	`{"family":"inet6","local":"fe80::1","prefixlen":64,"scope":"link"}]}]`
+
+// Function installs a kernel WireGuard device wg0 listening on 51820,
+// whose peer holds 10.10.10.2, the addresses of ipShowWg0 and the peer
+// metadata in a temporary directory.
+func useServer(t *testing.T) (*wgmock.Client, *shellmock.Runner, wgtypes.Key) {
+	t.Helper()
+
+	serverKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock := wgmock.Install(t, &wgtypes.Device{
+		Name:       "wg0",
+		Type:       wgtypes.LinuxKernel,
+		PrivateKey: serverKey,
+		PublicKey:  serverKey.PublicKey(),
+		ListenPort: 51820,
+		Peers: []wgtypes.Peer{{
+			PublicKey:  peerKey.PublicKey(),
+			AllowedIPs: []net.IPNet{{IP: net.IPv4(10, 10, 10, 2).To4(), Mask: net.CIDRMask(32, 32)}},
+		}},
+	})
+
+	prevDir := peermeta.Dir
+	peermeta.Dir = t.TempDir()
+	t.Cleanup(func() { peermeta.Dir = prevDir })
+
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.FormatCmdIpShowJSON("wg0")] = ipShowWg0
+	return mock, fake, serverKey
+}
+
+// Function returns the number of peers of wg0.
+func peerCount(t *testing.T, mock *wgmock.Client) int {
+	t.Helper()
+
+	device, err := mock.Device("wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(device.Peers)
+}
+
+// Testing the defaults of the options.
+func TestProvisionOptionsDefaults(t *testing.T) {
+	type testCase struct {
+		name string
+		opts ProvisionOptions
+		want ProvisionOptions
+	}
+
+	tests := []testCase{
+		{
+			name: "empty",
+			opts: ProvisionOptions{},
+			want: ProvisionOptions{
+				Keepalive: DefaultKeepalive, AllowedIPs: DefaultAllowedIPs, Format: DefaultFormat, DNS: []string{},
+			},
+		},
+		{
+			name: "given",
+			opts: ProvisionOptions{
+				Keepalive: 10, AllowedIPs: []string{"10.10.10.0/24"}, Format: render.FormatNM, DNS: []string{"10.10.10.1"},
+			},
+			want: ProvisionOptions{
+				Keepalive: 10, AllowedIPs: []string{"10.10.10.0/24"}, Format: render.FormatNM, DNS: []string{"10.10.10.1"},
+			},
+		},
+		{
+			name: "keepalive disabled",
+			opts: ProvisionOptions{Keepalive: -1},
+			want: ProvisionOptions{
+				Keepalive: 0, AllowedIPs: DefaultAllowedIPs, Format: DefaultFormat, DNS: []string{},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.opts.withDefaults(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("error: got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the free address: the addresses of the interface, the allowed
+// IPs of the peers and the broadcast address are skipped, IPv4 first.
+func TestFreeAddress(t *testing.T) {
+	type testCase struct {
+		name      string
+		show      string
+		allowed   []string
+		want      string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "first free", show: ipShowWg0, allowed: []string{"10.10.10.2/32"}, want: "10.10.10.3/32"},
+		{name: "no peers", show: ipShowWg0, want: "10.10.10.2/32"},
+		{name: "covered range", show: ipShowWg0, allowed: []string{"10.10.10.0/30", "10.10.10.5/32"}, want: "10.10.10.4/32"},
+		{
+			name:    "IPv4 full",
+			show:    ipShowWg0,
+			allowed: []string{"10.10.10.0/25", "10.10.10.128/26", "10.10.10.192/27", "10.10.10.224/28", "10.10.10.240/29", "10.10.10.248/30", "10.10.10.252/31", "10.10.10.254/32"},
+			want:    "fd00::2/128",
+		},
+		{
+			name:      "all taken",
+			show:      `[{"ifname":"wg0","addr_info":[{"family":"inet","local":"10.10.10.1","prefixlen":30,"scope":"global"}]}]`,
+			allowed:   []string{"10.10.10.2/32"},
+			wantError: true,
+		},
+		{
+			name:      "link-local only",
+			show:      `[{"ifname":"wg0","addr_info":[{"family":"inet6","local":"fe80::1","prefixlen":64,"scope":"link"}]}]`,
+			wantError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := shellmock.Install(t)
+			fake.Outputs[shell.FormatCmdIpShowJSON("wg0")] = tc.show
+
+			device := get.DeviceInfo{Peers: []get.PeerInfo{{AllowedIPs: tc.allowed}}}
+			got, err := freeAddress("wg0", device)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: got %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got.String() != tc.want {
+				t.Errorf("error: got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the provisioning of a client: the peer is added with the free
+// address and its metadata, and the configuration rendered.
+func TestCreatePeerWithAccess(t *testing.T) {
+	mock, fake, serverKey := useServer(t)
+	fake.Outputs["qrencode -t PNG"] = "\x89PNG"
+
+	result, err := CreatePeerWithAccess("wg0", ProvisionOptions{
+		Name:     "alice",
+		Endpoint: "vpn.example.com",
+		DNS:      []string{"10.10.10.1"},
+		Expires:  "2030-01-01",
+		QR:       true,
+	})
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if result.Address != "10.10.10.3/32" || string(result.QR) != "\x89PNG" {
+		t.Errorf("error: got %+v", result)
+	}
+	for _, want := range []string{
+		"Address = 10.10.10.3/32",
+		"DNS = 10.10.10.1",
+		"PublicKey = " + serverKey.PublicKey().String(),
+		"Endpoint = vpn.example.com:51820",
+		"AllowedIPs = 0.0.0.0/0, ::/0",
+		"PersistentKeepalive = 25",
+	} {
+		if !strings.Contains(result.Config, want) {
+			t.Errorf("error: configuration misses %q:\n%s", want, result.Config)
+		}
+	}
+
+	device, _ := mock.Device("wg0")
+	if len(device.Peers) != 2 || device.Peers[1].PublicKey.String() != result.PublicKey ||
+		device.Peers[1].AllowedIPs[0].String() != "10.10.10.3/32" {
+		t.Errorf("error: got peers %+v", device.Peers)
+	}
+
+	store, _ := peermeta.Load("wg0")
+	if meta := store[result.PublicKey]; meta.Name != "alice" || meta.Expires.IsZero() {
+		t.Errorf("error: got metadata %+v", meta)
+	}
+}
+
+// Testing that the peer is removed again when the configuration cannot be
+// rendered, and that nothing is added for invalid options.
+func TestCreatePeerWithAccessRollback(t *testing.T) {
+	broken, err := render.Parse("broken", "{{.Missing}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		name  string
+		opts  ProvisionOptions
+		qrErr error
+	}
+
+	tests := []testCase{
+		{name: "template", opts: ProvisionOptions{Name: "bob", Endpoint: "192.0.2.1", Template: broken}},
+		{name: "QR code", opts: ProvisionOptions{Name: "bob", Endpoint: "192.0.2.1", QR: true}, qrErr: errors.New("qrencode: not found")},
+		{name: "no endpoint", opts: ProvisionOptions{Name: "bob"}},
+		{name: "bad DNS", opts: ProvisionOptions{Endpoint: "192.0.2.1", DNS: []string{"dns.example.com"}}},
+		{name: "bad expiry", opts: ProvisionOptions{Endpoint: "192.0.2.1", Expires: "tomorrow"}},
+		{name: "bad endpoint port", opts: ProvisionOptions{Endpoint: "192.0.2.1:0"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mock, fake, _ := useServer(t)
+			if tc.qrErr != nil {
+				fake.Errors["qrencode -t PNG"] = tc.qrErr
+			}
+
+			if _, err := CreatePeerWithAccess("wg0", tc.opts); err == nil {
+				t.Fatalf("error: expected an error")
+			}
+			if got := peerCount(t, mock); got != 1 {
+				t.Errorf("error: got %d peers, want the existing one only", got)
+			}
+			if store, _ := peermeta.Load("wg0"); len(store) != 0 {
+				t.Errorf("error: metadata of the removed peer kept: %+v", store)
+			}
+		})
+	}
+}
+
+// Testing that a missing interface is refused.
+func TestCreatePeerWithAccessUnknownInterface(t *testing.T) {
+	useServer(t)
+	if _, err := CreatePeerWithAccess("wg1", ProvisionOptions{Endpoint: "192.0.2.1"}); err == nil {
+		t.Errorf("error: expected an error for a missing interface")
+	}
+}
+
+// Testing that concurrent calls pick different addresses, the address
+// being picked and the peer added under the operation lock.
+func TestCreatePeerWithAccessConcurrent(t *testing.T) {
+	mock, _, _ := useServer(t)
+
+	const calls = 4
+	results := make([]ProvisionResult, calls)
+	errs := make([]error, calls)
+	var wg sync.WaitGroup
+	for i := range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = CreatePeerWithAccess("wg0", ProvisionOptions{Endpoint: "192.0.2.1"})
+		}()
+	}
+	wg.Wait()
+
+	addresses := make(map[string]bool)
+	for i := range calls {
+		if errs[i] != nil {
+			t.Fatalf("error: unexpected error: %v", errs[i])
+		}
+		addresses[results[i].Address] = true
+	}
+	if len(addresses) != calls || peerCount(t, mock) != calls+1 {
+		t.Errorf("error: got addresses %v and %d peers", addresses, peerCount(t, mock))
+	}
+}
@@ -0,0 +1,187 @@
+package reconcile
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/set"
+)
+
+// Function checking for a network interface, replaced in tests.
+var interfaceExists = get.GetExistInterface
+
+// Time given to a started userspace device to create its interface,
+// replaced in tests.
+var interfaceWait = 5 * time.Second
+
+// Function applies the changes computed by Diff in order and reports each
+// applied change to out. The first failure stops the reconciliation, as
+// the following changes may depend on it (e.g., an address on a missing
+// interface). The changes run under the operation lock held by the
+// caller, if handed (see oplock.Enter).
+//
+// Usage example:
+//
+//	changes := reconcile.Diff(desired, current)
+//	if err := reconcile.Apply(desired, changes, os.Stdout); err != nil {
+//	    // Handle error
+//	}
+func Apply(desired State, changes []Change, out io.Writer, held ...*oplock.Lock) error {
+	for _, change := range changes {
+		if err := applyChange(desired, change, held...); err != nil {
+			return fmt.Errorf("error: failed to apply '%s', %w", change, err)
+		}
+		fmt.Fprintf(out, "applied %s\n", change)
+	}
+	return nil
+}
+
+// Function applies a single change.
+func applyChange(desired State, change Change, held ...*oplock.Lock) error {
+	iface := desiredInterface(desired, change.Interface)
+
+	switch change.Kind {
+	case KindForwarding:
+		return applyForwarding(change)
+
+	case KindInterface:
+		if err := shell.DefaultRunner.Run(shell.FormatCmdAddInterface(iface.Name, iface.Type == TypeAmneziaWG), false); err != nil {
+			return err
+		}
+		return waitInterface(iface.Name)
+
+	case KindAddress:
+		if change.Action == ActionRemove {
+			if err := shell.DefaultRunner.Run(shell.FormatCmdIpAddrDev(iface.Name, change.Target, shell.IpDel), false); err != nil {
+				return err
+			}
+			return set.ForgetApplied(iface.Name, nil, []string{change.Target}, held...)
+		}
+		if err := shell.DefaultRunner.Run(shell.FormatCmdIpAddrDev(iface.Name, change.Target, shell.IpAdd), false); err != nil {
+			return err
+		}
+		return set.RecordApplied(iface.Name, nil, []string{change.Target}, held...)
+
+	case KindPeer, KindAllowedIPs:
+		if change.Action == ActionRemove {
+			return removePeer(iface, change, held...)
+		}
+		return configurePeer(iface, change, held...)
+
+	case KindForward, KindNAT:
+		rule := change.rule()
+		if err := shell.DefaultRunner.Run(firewall.FormatCmdAppend(rule.Table, rule.Chain, rule.Spec), false); err != nil {
+			return err
+		}
+		return set.RecordApplied(iface.Name, []peermeta.Rule{rule}, nil, held...)
+	}
+
+	return fmt.Errorf("error: unknown change kind '%s'", change.Kind)
+}
+
+// Function enables or disables the IP forwarding of a family.
+func applyForwarding(change Change) error {
+	enable := slices.Contains(change.Desired, forwardingStatus(true))
+
+	cmd := map[string]map[bool]string{
+		"ipv4": {true: shell.SysctlIpv4Up, false: shell.SysctlIpv4Down},
+		"ipv6": {true: shell.SysctlIpv6Up, false: shell.SysctlIpv6Down},
+	}[change.Target][enable]
+	if cmd == "" {
+		return fmt.Errorf("error: unknown forwarding family '%s'", change.Target)
+	}
+	return shell.DefaultRunner.Run(cmd, false)
+}
+
+// Function adds the peer, or replaces the allowed IPs of an existing one,
+// with the settings of the desired state.
+func configurePeer(iface Interface, change Change, held ...*oplock.Lock) error {
+	var peer Peer
+	for _, p := range iface.Peers {
+		if p.PublicKey == change.Target {
+			peer = p
+		}
+	}
+
+	keepalive := ""
+	if peer.PersistentKeepalive > 0 {
+		keepalive = strconv.Itoa(peer.PersistentKeepalive)
+	}
+
+	if iface.Type == TypeAmneziaWG {
+		// `awg set ... allowed-ips` replaces the allowed IPs.
+		cmd := shell.FormatCmdAwgAddPeer(
+			iface.Name, peer.PublicKey, strings.Join(change.Desired, ", "), keepalive, peer.Endpoint,
+		)
+		return shell.DefaultRunner.Run(cmd, false)
+	}
+
+	obj := set.SinglePeerStructure{
+		InterfaceName:               iface.Name,
+		PublicKey:                   peer.PublicKey,
+		AllowedIPs:                  change.Desired,
+		EndpointHost:                peer.Endpoint,
+		PersistentKeepaliveInterval: keepalive,
+		ReplaceAllowedIPs:           change.Kind == KindAllowedIPs,
+	}
+	return obj.AddPeer(false, held...)
+}
+
+// Function removes the peer, its metadata and its rate limit.
+func removePeer(iface Interface, change Change, held ...*oplock.Lock) error {
+	if iface.Type == TypeAmneziaWG {
+		if err := shell.DefaultRunner.Run(shell.FormatCmdAwgDeletePeer(iface.Name, change.Target), false); err != nil {
+			return err
+		}
+		if err := set.RemovePeerMeta(iface.Name, change.Target, held...); err != nil {
+			return err
+		}
+	} else {
+		obj := set.SinglePeerStructure{InterfaceName: iface.Name, PublicKey: change.Target}
+		if err := obj.RemovePeer(held...); err != nil {
+			return err
+		}
+	}
+
+	if len(change.Current) == 0 {
+		return nil
+	}
+	return set.RemovePeerLimit(iface.Name, change.Current, held...)
+}
+
+// Function waits for a started userspace device to create its interface.
+func waitInterface(name string) error {
+	deadline := time.Now().Add(interfaceWait)
+	for {
+		exists, err := interfaceExists(name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("error: network interface '%s' did not appear within %s", name, interfaceWait)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Function returns the desired interface of the name.
+func desiredInterface(desired State, name string) Interface {
+	for _, iface := range desired.Interfaces {
+		if iface.Name == name {
+			return iface
+		}
+	}
+	return Interface{Name: name, Type: TypeWireGuard}
+}
@@ -0,0 +1,165 @@
+package reconcile
+
+import (
+	"fmt"
+
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/set"
+)
+
+// Function reads the live state of what the desired state describes:
+// forwarding, the desired interfaces (addresses, peers) and the FORWARD
+// and NAT rules.
+//
+// Usage example:
+//
+//	current, err := reconcile.Inspect(desired)
+//	if err != nil {
+//	    // Handle error
+//	}
+//	for _, change := range reconcile.Diff(desired, current) {
+//	    fmt.Println(change)
+//	}
+func Inspect(desired State) (Current, error) {
+	current := Current{Interfaces: make(map[string]CurrentInterface)}
+
+	if desired.Forwarding.IPv4 != nil || desired.Forwarding.IPv6 != nil {
+		forwarding, err := get.GetIPvForwarding()
+		if err != nil {
+			return current, err
+		}
+		current.Forwarding = forwarding
+	}
+
+	for _, iface := range desired.Interfaces {
+		owner, err := get.GetInterfaceOwner(iface.Name)
+		if err != nil {
+			return current, err
+		}
+		if !owner.Exists {
+			continue
+		}
+
+		live, err := inspectInterface(owner)
+		if err != nil {
+			return current, err
+		}
+		current.Interfaces[iface.Name] = live
+	}
+
+	for _, iface := range desired.Interfaces {
+		if len(iface.NAT) == 0 {
+			continue
+		}
+
+		rules, err := liveRules()
+		if err != nil {
+			return current, err
+		}
+		current.Rules = rules
+		break
+	}
+
+	return current, nil
+}
+
+// Function reads the addresses and peers of an existing network interface.
+func inspectInterface(owner get.InterfaceOwner) (CurrentInterface, error) {
+	live := CurrentInterface{Peers: make(map[string][]string)}
+
+	show, err := get.GetIpShow(owner.Name)
+	if err != nil {
+		return live, err
+	}
+	for _, link := range show {
+		for _, addr := range link.AddrInfo {
+			if addr.Scope == "link" {
+				continue
+			}
+			live.Addresses = append(live.Addresses, fmt.Sprintf("%s/%d", addr.Local, addr.Prefixlen))
+		}
+	}
+
+	var device get.DeviceInfo
+	if owner.Process == "awg" || owner.Kind == "amneziawg" {
+		device, err = get.GetAwgPeerInfo(owner.Name)
+		if err != nil {
+			return live, err
+		}
+	} else {
+		info, err := get.GetPeerInfo(owner.Name)
+		if err != nil {
+			return live, err
+		}
+		if len(info) > 0 {
+			device = info[0]
+		}
+	}
+
+	for _, peer := range device.Peers {
+		live.Peers[peer.PublicKey] = peer.AllowedIPs
+	}
+	return live, nil
+}
+
+// Function returns the FORWARD ACCEPT and POSTROUTING MASQUERADE rules in
+// the form of set.ForwardRule and set.NATRule. Only the rules tagged by this
+// tool are returned, and the untagged rules added before the rules were
+// tagged in the form of set.UntaggedRule; rules with other comments belong
+// to the administrator.
+func liveRules() ([]peermeta.Rule, error) {
+	var rules []peermeta.Rule
+
+	filter, err := get.GetIptablesFirewall()
+	if err != nil {
+		return nil, err
+	}
+	for _, chain := range filter.Chains {
+		if chain.Name != "FORWARD" {
+			continue
+		}
+		for _, rule := range chain.Rules {
+			if rule.Target != "ACCEPT" || !anyAddress(rule.Source) || anyInterface(rule.In) || anyInterface(rule.Out) {
+				continue
+			}
+			if owner, ok := rule.Owner(); ok && owner != "" {
+				rules = append(rules, set.ForwardRule(rule.In, rule.Out, owner))
+			} else if rule.Comment == "" {
+				rules = append(rules, set.UntaggedRule(set.ForwardRule(rule.In, rule.Out, "")))
+			}
+		}
+	}
+
+	nat, err := get.GetIptablesNAT()
+	if err != nil {
+		return nil, err
+	}
+	for _, chain := range nat.Chains {
+		if chain.Name != "POSTROUTING" {
+			continue
+		}
+		for _, rule := range chain.Rules {
+			if rule.Target != "MASQUERADE" || anyAddress(rule.Source) || anyInterface(rule.Out) {
+				continue
+			}
+			if owner, ok := rule.Owner(); ok && owner != "" {
+				rules = append(rules, set.NATRule(rule.Out, rule.Source, owner))
+			} else if rule.Comment == "" {
+				rules = append(rules, set.UntaggedRule(set.NATRule(rule.Out, rule.Source, "")))
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+// Function reports whether the iptables listing shows any interface.
+func anyInterface(value string) bool {
+	return value == "" || value == "*" || value == "any"
+}
+
+// Function reports whether the iptables listing shows any address.
+func anyAddress(value string) bool {
+	return value == "0.0.0.0/0" || value == "anywhere"
+}
@@ -0,0 +1,349 @@
+package reconcile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/spec"
+	"github.com/AlexKira/brgnetuse/src/validate"
+)
+
+// Function reads and validates the desired state file (see State).
+//
+// Usage example:
+//
+//	desired, err := reconcile.Load("/etc/brgnetuse/state.json")
+//	if err != nil {
+//	    // Handle error
+//	}
+func Load(path string) (State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return State{}, help.Errorf(help.CodeFileFailed, "error: failed to read state file '%s': %v", path, err)
+	}
+	return Parse(data)
+}
+
+// Function decodes and validates a desired state in JSON format. Unknown
+// fields are rejected, so a typo does not silently drop a setting. Every
+// problem of the state is reported, see Check.
+func Parse(data []byte) (State, error) {
+	state, problems := parse(data)
+	if len(problems) > 0 {
+		return State{}, help.WithCode(help.CodeInvalidFile, validate.JoinProblems(problems))
+	}
+	return state, nil
+}
+
+// Function decodes and checks a desired state in JSON format without
+// applying it, and returns every problem found with its line. It is the
+// validation of Parse, so a state file found valid is accepted by Load.
+func Check(data []byte) []validate.Problem {
+	_, problems := parse(data)
+	return problems
+}
+
+// Function returns the desired state of the interface of a spec (see
+// spec.Spec): its addresses, peers and NAT entries, and the forwarding of
+// its address families when the spec enables it.
+//
+// Usage example:
+//
+//	desired := reconcile.FromSpec(s)
+//	current, err := reconcile.Inspect(desired)
+func FromSpec(s spec.Spec) State {
+	state := State{Interfaces: []Interface{{
+		Name:      s.Name,
+		Type:      TypeWireGuard,
+		Addresses: s.Addresses,
+		Peers:     s.Peers,
+		NAT:       s.NAT,
+	}}}
+
+	if s.Forwarding {
+		enabled := true
+		state.Forwarding.IPv4 = &enabled
+		if s.HasIPv6() {
+			state.Forwarding.IPv6 = &enabled
+		}
+	}
+	return state
+}
+
+// Function decodes a desired state and returns it with its problems.
+func parse(data []byte) (State, []validate.Problem) {
+	var state State
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&state); err != nil {
+		offset := decoder.InputOffset()
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			offset = syntaxErr.Offset
+		}
+		return State{}, []validate.Problem{{
+			Line: bytes.Count(data[:min(int(offset), len(data))], []byte("\n")) + 1,
+			Err:  fmt.Errorf("error: invalid state file, %v", err),
+		}}
+	}
+
+	return state, state.validate(data)
+}
+
+// Method checks the desired state, fills the defaults and returns every
+// problem found. The line of a problem is the first line of data holding
+// the offending value, 0 when it is not found.
+func (s *State) validate(data []byte) []validate.Problem {
+	var problems []validate.Problem
+	report := func(value string, format string, args ...any) {
+		problems = append(problems, validate.Problem{
+			Line: spec.LineOf(data, value),
+			Err:  fmt.Errorf("error: "+format, args...),
+		})
+	}
+
+	var names []string
+	for indx := range s.Interfaces {
+		iface := &s.Interfaces[indx]
+
+		if iface.Name == "" {
+			report("", "interface %d of the state file has no name", indx+1)
+		} else if slices.Contains(names, iface.Name) {
+			report(iface.Name, "interface '%s' is listed twice in the state file", iface.Name)
+		}
+		names = append(names, iface.Name)
+
+		switch iface.Type {
+		case "":
+			iface.Type = TypeWireGuard
+		case TypeWireGuard, TypeAmneziaWG:
+		default:
+			report(iface.Type,
+				"interface '%s' has an invalid type '%s', expected %s or %s",
+				iface.Name, iface.Type, TypeWireGuard, TypeAmneziaWG,
+			)
+		}
+
+		problems = append(problems, spec.CheckMembers(data, iface.Name, iface.Addresses, iface.Peers, iface.NAT)...)
+	}
+	return problems
+}
+
+// Function computes the changes turning the current state into the desired
+// one, ordered so each change can rely on the previous ones: forwarding,
+// interfaces, addresses, peers, then FORWARD and NAT rules.
+//
+// Peers and addresses of a desired interface that are not in the desired
+// state are removed; rules are only added, as rules of other tools are not
+// told apart from stale ones.
+func Diff(desired State, current Current) []Change {
+	var changes []Change
+
+	families := []struct {
+		name string
+		want *bool
+	}{
+		{"ipv4", desired.Forwarding.IPv4},
+		{"ipv6", desired.Forwarding.IPv6},
+	}
+	for _, family := range families {
+		if family.want == nil {
+			continue
+		}
+		have, ok := current.Forwarding[family.name]
+		if ok && (have == 1) == *family.want {
+			continue
+		}
+		changes = append(changes, Change{
+			Kind:    KindForwarding,
+			Action:  ActionUpdate,
+			Target:  family.name,
+			Desired: []string{forwardingStatus(*family.want)},
+			Current: []string{forwardingStatus(have == 1)},
+		})
+	}
+
+	var addresses, peers, forwards, nats []Change
+	for _, iface := range desired.Interfaces {
+		live, exists := current.Interfaces[iface.Name]
+		if !exists {
+			changes = append(changes, Change{
+				Kind: KindInterface, Action: ActionAdd, Interface: iface.Name, Target: iface.Type,
+			})
+		}
+
+		addresses = append(addresses, diffAddresses(iface, live)...)
+		peers = append(peers, diffPeers(iface, live)...)
+
+		for _, nat := range iface.NAT {
+			for _, rule := range set.ForwardRules(nat.OutInterface, iface.Name) {
+				forwards = appendRule(forwards, current.Rules, KindForward, iface.Name, rule)
+			}
+			rule := set.NATRule(nat.OutInterface, netip.MustParsePrefix(nat.Subnet).Masked().String(), iface.Name)
+			nats = appendRule(nats, current.Rules, KindNAT, iface.Name, rule)
+		}
+	}
+
+	changes = append(changes, addresses...)
+	changes = append(changes, peers...)
+	changes = append(changes, forwards...)
+	return append(changes, nats...)
+}
+
+// Function appends the change adding the rule, unless the rule exists or
+// is already added (two NAT entries may share the FORWARD rules). A rule
+// added before the rules were tagged counts as existing.
+func appendRule(changes []Change, live []peermeta.Rule, kind, iface string, rule peermeta.Rule) []Change {
+	added := slices.ContainsFunc(changes, func(c Change) bool { return c.Target == rule.Spec })
+	if added || slices.Contains(live, rule) || slices.Contains(live, set.UntaggedRule(rule)) {
+		return changes
+	}
+	return append(changes, Change{Kind: kind, Action: ActionAdd, Interface: iface, Target: rule.Spec})
+}
+
+// Function returns the address changes of the interface: stale addresses
+// are removed before the missing ones are added.
+func diffAddresses(iface Interface, live CurrentInterface) []Change {
+	var removed, added []Change
+
+	want := make([]string, 0, len(iface.Addresses))
+	for _, addr := range iface.Addresses {
+		want = append(want, netip.MustParsePrefix(addr).String())
+	}
+
+	for _, addr := range live.Addresses {
+		if !slices.Contains(want, addr) {
+			removed = append(removed, Change{
+				Kind: KindAddress, Action: ActionRemove, Interface: iface.Name, Target: addr,
+			})
+		}
+	}
+	for _, addr := range want {
+		if !slices.Contains(live.Addresses, addr) {
+			added = append(added, Change{
+				Kind: KindAddress, Action: ActionAdd, Interface: iface.Name, Target: addr,
+			})
+		}
+	}
+	return append(removed, added...)
+}
+
+// Function returns the peer changes of the interface: stale peers are
+// removed first, so their allowed IPs are free for the added peers.
+func diffPeers(iface Interface, live CurrentInterface) []Change {
+	var removed, changed []Change
+
+	keys := make([]string, 0, len(live.Peers))
+	for key := range live.Peers {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		if !slices.ContainsFunc(iface.Peers, func(p Peer) bool { return p.PublicKey == key }) {
+			removed = append(removed, Change{
+				Kind: KindPeer, Action: ActionRemove, Interface: iface.Name, Target: key,
+				Current: live.Peers[key],
+			})
+		}
+	}
+
+	for _, peer := range iface.Peers {
+		want, _ := normalizePrefixes(peer.AllowedIPs)
+		have, exists := live.Peers[peer.PublicKey]
+		switch {
+		case !exists:
+			changed = append(changed, Change{
+				Kind: KindPeer, Action: ActionAdd, Interface: iface.Name, Target: peer.PublicKey,
+				Desired: want,
+			})
+		case !slices.Equal(want, normalizeLive(have)):
+			changed = append(changed, Change{
+				Kind: KindAllowedIPs, Action: ActionUpdate, Interface: iface.Name, Target: peer.PublicKey,
+				Desired: want, Current: have,
+			})
+		}
+	}
+	return append(removed, changed...)
+}
+
+// Method describes the change on a single line, prefixed with `+` (add),
+// `-` (remove) or `~` (update).
+func (c Change) String() string {
+	sign := map[string]string{ActionAdd: "+", ActionRemove: "-", ActionUpdate: "~"}[c.Action]
+
+	switch c.Kind {
+	case KindForwarding:
+		return fmt.Sprintf("%s forwarding %s: %s -> %s", sign, c.Target, strings.Join(c.Current, ","), strings.Join(c.Desired, ","))
+	case KindInterface:
+		return fmt.Sprintf("%s interface %s (%s)", sign, c.Interface, c.Target)
+	case KindAllowedIPs:
+		return fmt.Sprintf(
+			"%s allowed-ips %s %s: %s -> %s", sign, c.Interface, c.Target,
+			strings.Join(c.Current, ","), strings.Join(c.Desired, ","),
+		)
+	case KindPeer:
+		ips := c.Desired
+		if c.Action == ActionRemove {
+			ips = c.Current
+		}
+		return fmt.Sprintf("%s peer %s %s %s", sign, c.Interface, c.Target, strings.Join(ips, ","))
+	default:
+		return fmt.Sprintf("%s %s %s %s", sign, c.Kind, c.Interface, c.Target)
+	}
+}
+
+// Method returns the iptables rule of a KindForward or KindNAT change.
+func (c Change) rule() peermeta.Rule {
+	if c.Kind == KindNAT {
+		return peermeta.Rule{Table: "nat", Chain: "POSTROUTING", Spec: c.Target}
+	}
+	return peermeta.Rule{Table: "filter", Chain: "FORWARD", Spec: c.Target}
+}
+
+// Function returns the prefixes masked and sorted, the form compared by Diff.
+func normalizePrefixes(values []string) ([]string, error) {
+	prefixes := make([]string, 0, len(values))
+	for _, value := range values {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("error: invalid allowed IP '%s'", value)
+		}
+		if masked := prefix.Masked().String(); !slices.Contains(prefixes, masked) {
+			prefixes = append(prefixes, masked)
+		}
+	}
+	slices.Sort(prefixes)
+	return prefixes, nil
+}
+
+// Function normalizes prefixes read from the live system, invalid values
+// cannot occur there and are dropped.
+func normalizeLive(values []string) []string {
+	var prefixes []string
+	for _, value := range values {
+		if normalized, err := normalizePrefixes([]string{value}); err == nil {
+			prefixes = append(prefixes, normalized...)
+		}
+	}
+	slices.Sort(prefixes)
+	return slices.Compact(prefixes)
+}
+
+// Function returns the status of the forwarding shown in the changes.
+func forwardingStatus(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
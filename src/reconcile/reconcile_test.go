@@ -0,0 +1,620 @@
+package reconcile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/internal/shellmock"
+	"github.com/AlexKira/brgnetuse/internal/wgmock"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/set"
+	"github.com/AlexKira/brgnetuse/src/spec"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Function points the operation lock at a temporary file for the tests.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "brgnetuse-lock-")
+	if err != nil {
+		panic(err)
+	}
+	oplock.Path = filepath.Join(dir, "brgnetuse.lock")
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Function returns a valid public key made of the character, so the tests
+// know the order of the keys.
+func testKey(char string) string {
+	return strings.Repeat(char, 42) + "A="
+}
+
+// Function returns a pointer to the value.
+func ptr[T any](value T) *T {
+	return &value
+}
+
+// Testing the Parse function.
+func TestParse(t *testing.T) {
+	type testCase struct {
+		name      string
+		input     string
+		wantError string
+	}
+
+	testCases := []testCase{
+		{
+			name: "valid state",
+			input: fmt.Sprintf(`{"forwarding": {"ipv4": true}, "interfaces": [{"name": "wg0",
+				"addresses": ["10.10.10.254/24"],
+				"peers": [{"public_key": "%s", "allowed_ips": ["10.10.10.2/32"]}],
+				"nat": [{"subnet": "10.10.10.0/24", "out_interface": "eth0"}]}]}`, testKey("B")),
+		},
+		{name: "unknown field", input: `{"interfaces": [{"name": "wg0", "adresses": []}]}`, wantError: "unknown field"},
+		{name: "not json", input: `interfaces: []`, wantError: "invalid state file"},
+		{name: "no name", input: `{"interfaces": [{}]}`, wantError: "has no name"},
+		{name: "duplicate interface", input: `{"interfaces": [{"name": "wg0"}, {"name": "wg0"}]}`, wantError: "listed twice"},
+		{name: "invalid type", input: `{"interfaces": [{"name": "wg0", "type": "ipsec"}]}`, wantError: "invalid type"},
+		{name: "invalid address", input: `{"interfaces": [{"name": "wg0", "addresses": ["10.10.10.254"]}]}`, wantError: "invalid address"},
+		{
+			name:      "invalid key",
+			input:     `{"interfaces": [{"name": "wg0", "peers": [{"public_key": "abc", "allowed_ips": ["10.0.0.2/32"]}]}]}`,
+			wantError: "invalid public key",
+		},
+		{
+			name: "duplicate peer",
+			input: fmt.Sprintf(`{"interfaces": [{"name": "wg0", "peers": [
+				{"public_key": "%[1]s", "allowed_ips": ["10.0.0.2/32"]},
+				{"public_key": "%[1]s", "allowed_ips": ["10.0.0.3/32"]}]}]}`, testKey("B")),
+			wantError: "listed twice",
+		},
+		{
+			name:      "no allowed ips",
+			input:     fmt.Sprintf(`{"interfaces": [{"name": "wg0", "peers": [{"public_key": "%s"}]}]}`, testKey("B")),
+			wantError: "no allowed IPs",
+		},
+		{
+			name: "invalid allowed ip",
+			input: fmt.Sprintf(
+				`{"interfaces": [{"name": "wg0", "peers": [{"public_key": "%s", "allowed_ips": ["10.0.0.300/32"]}]}]}`,
+				testKey("B"),
+			),
+			wantError: "invalid allowed IP",
+		},
+		{
+			name: "keepalive out of range",
+			input: fmt.Sprintf(
+				`{"interfaces": [{"name": "wg0", "peers": [{"public_key": "%s", "allowed_ips": ["10.0.0.2/32"], "persistent_keepalive": 65536}]}]}`,
+				testKey("B"),
+			),
+			wantError: "invalid persistent_keepalive 65536",
+		},
+		{
+			name:      "invalid nat subnet",
+			input:     `{"interfaces": [{"name": "wg0", "nat": [{"subnet": "10.10.10.0", "out_interface": "eth0"}]}]}`,
+			wantError: "invalid NAT subnet",
+		},
+		{
+			name:      "nat without out interface",
+			input:     `{"interfaces": [{"name": "wg0", "nat": [{"subnet": "10.10.10.0/24"}]}]}`,
+			wantError: "no out_interface",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			state, err := Parse([]byte(tc.input))
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got %v, want error containing %q", err, tc.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if state.Interfaces[0].Type != TypeWireGuard {
+				t.Errorf("error: got type %q, want default %q", state.Interfaces[0].Type, TypeWireGuard)
+			}
+		})
+	}
+}
+
+// Testing that Check reports every problem of a state file with its line,
+// and that Parse refuses the same file.
+func TestCheck(t *testing.T) {
+	data := fmt.Sprintf(`{"interfaces": [
+  {"name": "wg0", "type": "ipsec",
+   "addresses": ["10.10.10.254"],
+   "peers": [
+     {"public_key": "abc", "allowed_ips": ["10.10.10.2/32"]},
+     {"public_key": "%s", "allowed_ips": ["10.10.10.0/24"], "endpoint": "89.89.89.1:0"}
+   ]},
+  {"name": "wg0"}
+]}`, testKey("B"))
+
+	var got []string
+	for _, problem := range Check([]byte(data)) {
+		got = append(got, problem.Format("state.json"))
+	}
+	want := []string{
+		"state.json:2: interface 'wg0' has an invalid type 'ipsec', expected wireguard or amneziawg",
+		"state.json:3: interface 'wg0' has an invalid address '10.10.10.254'",
+		"state.json:5: interface 'wg0', invalid public key 'abc'",
+		"state.json:6: interface 'wg0', allowed IP '10.10.10.0/24' of peer '" + testKey("B") +
+			"' conflicts with '10.10.10.2/32' of peer 'abc'",
+		"state.json:6: interface 'wg0', invalid endpoint port 0 in '89.89.89.1:0', the peer cannot be reached on it, " +
+			"expected range 1-65535",
+		"state.json:2: interface 'wg0' is listed twice in the state file",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("error: got problems\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, err := Parse([]byte(data)); err == nil || strings.Count(err.Error(), "\n") != len(want)-1 {
+		t.Errorf("error: got %v, want every problem", err)
+	}
+
+	problems := Check([]byte("{\n\"interfaces\": [\n}"))
+	if len(problems) != 1 || problems[0].Line != 3 {
+		t.Errorf("error: got %+v, want a syntax error on line 3", problems)
+	}
+}
+
+// Testing that FromSpec describes the interface of a spec, with the
+// forwarding of its address families.
+func TestFromSpec(t *testing.T) {
+	s := spec.Spec{
+		Name:       "wg0",
+		Addresses:  []string{"10.10.10.254/24"},
+		Forwarding: true,
+		Peers:      []spec.Peer{{PublicKey: testKey("B"), AllowedIPs: []string{"10.10.10.2/32"}}},
+		NAT:        []spec.NAT{{Subnet: "10.10.10.0/24", OutInterface: "eth0"}},
+	}
+
+	state := FromSpec(s)
+	if len(state.Interfaces) != 1 {
+		t.Fatalf("error: got interfaces %+v, want one", state.Interfaces)
+	}
+	iface := state.Interfaces[0]
+	if iface.Name != "wg0" || iface.Type != TypeWireGuard || len(iface.Peers) != 1 || len(iface.NAT) != 1 {
+		t.Errorf("error: got interface %+v", iface)
+	}
+	if state.Forwarding.IPv4 == nil || !*state.Forwarding.IPv4 || state.Forwarding.IPv6 != nil {
+		t.Errorf("error: got forwarding %+v, want IPv4 only", state.Forwarding)
+	}
+
+	s.Addresses = append(s.Addresses, "fd00::1/64")
+	if state := FromSpec(s); state.Forwarding.IPv6 == nil || !*state.Forwarding.IPv6 {
+		t.Errorf("error: got forwarding %+v, want IPv6 with an IPv6 address", state.Forwarding)
+	}
+
+	s.Forwarding = false
+	if state := FromSpec(s); state.Forwarding.IPv4 != nil || state.Forwarding.IPv6 != nil {
+		t.Errorf("error: got forwarding %+v, want none", state.Forwarding)
+	}
+}
+
+// Testing the Diff function over synthetic desired and current states.
+func TestDiff(t *testing.T) {
+	keyB, keyC, keyD := testKey("B"), testKey("C"), testKey("D")
+
+	desired := State{
+		Forwarding: Forwarding{IPv4: ptr(true)},
+		Interfaces: []Interface{{
+			Name:      "wg0",
+			Type:      TypeWireGuard,
+			Addresses: []string{"10.10.10.254/24"},
+			Peers: []Peer{
+				{PublicKey: keyB, AllowedIPs: []string{"10.10.10.2/32"}},
+				{PublicKey: keyC, AllowedIPs: []string{"10.10.10.3/32", "10.20.0.1/16"}},
+			},
+			NAT: []NAT{{Subnet: "10.10.10.0/24", OutInterface: "eth0"}},
+		}},
+	}
+
+	rules := append(set.ForwardRules("eth0", "wg0"), set.NATRule("eth0", "10.10.10.0/24", "wg0"))
+	inSync := Current{
+		Forwarding: map[string]int{"ipv4": 1, "ipv6": 0},
+		Interfaces: map[string]CurrentInterface{"wg0": {
+			Addresses: []string{"10.10.10.254/24"},
+			Peers: map[string][]string{
+				keyB: {"10.10.10.2/32"},
+				// Order and host bits do not matter.
+				keyC: {"10.20.0.0/16", "10.10.10.3/32"},
+			},
+		}},
+		Rules: rules,
+	}
+
+	type testCase struct {
+		name    string
+		desired func() State
+		current func() Current
+		want    []string
+	}
+
+	testCases := []testCase{
+		{
+			name:    "in sync",
+			desired: func() State { return desired },
+			current: func() Current { return inSync },
+		},
+		{
+			name:    "missing interface",
+			desired: func() State { return desired },
+			current: func() Current {
+				c := inSync
+				c.Interfaces = map[string]CurrentInterface{}
+				c.Rules = nil
+				return c
+			},
+			want: []string{
+				"+ interface wg0 (wireguard)",
+				"+ address wg0 10.10.10.254/24",
+				"+ peer wg0 " + keyB + " 10.10.10.2/32",
+				"+ peer wg0 " + keyC + " 10.10.10.3/32,10.20.0.0/16",
+				"+ forward wg0 -i eth0 -o wg0 -m comment --comment \"brgnetuse:wg0\" -j ACCEPT",
+				"+ forward wg0 -i wg0 -o eth0 -m comment --comment \"brgnetuse:wg0\" -j ACCEPT",
+				"+ nat wg0 -s 10.10.10.0/24 -o eth0 -m comment --comment \"brgnetuse:wg0\" -j MASQUERADE",
+			},
+		},
+		{
+			name:    "extra and missing peers",
+			desired: func() State { return desired },
+			current: func() Current {
+				c := inSync
+				c.Interfaces = map[string]CurrentInterface{"wg0": {
+					Addresses: []string{"10.10.10.254/24"},
+					Peers: map[string][]string{
+						keyD: {"10.10.10.4/32"},
+						keyB: {"10.10.10.2/32"},
+					},
+				}}
+				return c
+			},
+			want: []string{
+				"- peer wg0 " + keyD + " 10.10.10.4/32",
+				"+ peer wg0 " + keyC + " 10.10.10.3/32,10.20.0.0/16",
+			},
+		},
+		{
+			name:    "allowed ips mismatch",
+			desired: func() State { return desired },
+			current: func() Current {
+				c := inSync
+				c.Interfaces = map[string]CurrentInterface{"wg0": {
+					Addresses: []string{"10.10.10.254/24"},
+					Peers: map[string][]string{
+						keyB: {"10.10.10.2/32", "10.10.10.9/32"},
+						keyC: {"10.10.10.3/32", "10.20.0.0/16"},
+					},
+				}}
+				return c
+			},
+			want: []string{
+				"~ allowed-ips wg0 " + keyB + ": 10.10.10.2/32,10.10.10.9/32 -> 10.10.10.2/32",
+			},
+		},
+		{
+			name:    "address difference",
+			desired: func() State { return desired },
+			current: func() Current {
+				c := inSync
+				live := c.Interfaces["wg0"]
+				live.Addresses = []string{"10.10.20.254/24"}
+				c.Interfaces = map[string]CurrentInterface{"wg0": live}
+				return c
+			},
+			want: []string{
+				"- address wg0 10.10.20.254/24",
+				"+ address wg0 10.10.10.254/24",
+			},
+		},
+		{
+			name:    "absent rules",
+			desired: func() State { return desired },
+			current: func() Current {
+				c := inSync
+				c.Rules = rules[:1]
+				return c
+			},
+			want: []string{
+				"+ forward wg0 -i wg0 -o eth0 -m comment --comment \"brgnetuse:wg0\" -j ACCEPT",
+				"+ nat wg0 -s 10.10.10.0/24 -o eth0 -m comment --comment \"brgnetuse:wg0\" -j MASQUERADE",
+			},
+		},
+		{
+			name:    "untagged rules",
+			desired: func() State { return desired },
+			current: func() Current {
+				// Rules added before the rules were tagged are kept.
+				c := inSync
+				c.Rules = []peermeta.Rule{rules[0], set.UntaggedRule(rules[1]), set.UntaggedRule(rules[2])}
+				return c
+			},
+			want: nil,
+		},
+		{
+			name:    "rules of another interface",
+			desired: func() State { return desired },
+			current: func() Current {
+				c := inSync
+				c.Rules = []peermeta.Rule{
+					set.ForwardRule("eth0", "wg0", "wg0"),
+					set.ForwardRule("wg0", "eth0", "wg1"),
+					set.NATRule("eth0", "10.10.10.0/24", "wg1"),
+				}
+				return c
+			},
+			want: []string{
+				"+ forward wg0 -i wg0 -o eth0 -m comment --comment \"brgnetuse:wg0\" -j ACCEPT",
+				"+ nat wg0 -s 10.10.10.0/24 -o eth0 -m comment --comment \"brgnetuse:wg0\" -j MASQUERADE",
+			},
+		},
+		{
+			name: "shared forward rules",
+			desired: func() State {
+				d := desired
+				d.Interfaces = []Interface{d.Interfaces[0]}
+				d.Interfaces[0].NAT = []NAT{
+					{Subnet: "10.10.10.0/24", OutInterface: "eth0"},
+					{Subnet: "10.30.0.7/16", OutInterface: "eth0"},
+				}
+				return d
+			},
+			current: func() Current { return inSync },
+			want: []string{
+				"+ nat wg0 -s 10.30.0.0/16 -o eth0 -m comment --comment \"brgnetuse:wg0\" -j MASQUERADE",
+			},
+		},
+		{
+			name: "forwarding mismatch",
+			desired: func() State {
+				d := desired
+				d.Forwarding = Forwarding{IPv4: ptr(true), IPv6: ptr(true)}
+				return d
+			},
+			current: func() Current {
+				c := inSync
+				c.Forwarding = map[string]int{"ipv4": 0, "ipv6": 0}
+				return c
+			},
+			want: []string{
+				"~ forwarding ipv4: disabled -> enabled",
+				"~ forwarding ipv6: disabled -> enabled",
+			},
+		},
+		{
+			name: "unset forwarding ignored",
+			desired: func() State {
+				d := desired
+				d.Forwarding = Forwarding{}
+				return d
+			},
+			current: func() Current {
+				c := inSync
+				c.Forwarding = nil
+				return c
+			},
+		},
+		{
+			name: "dependency order across interfaces",
+			desired: func() State {
+				return State{
+					Forwarding: Forwarding{IPv4: ptr(true)},
+					Interfaces: []Interface{
+						{Name: "wg0", Type: TypeWireGuard, Addresses: []string{"10.10.10.254/24"},
+							NAT: []NAT{{Subnet: "10.10.10.0/24", OutInterface: "eth0"}}},
+						{Name: "awg0", Type: TypeAmneziaWG, Addresses: []string{"10.20.20.254/24"},
+							Peers: []Peer{{PublicKey: keyB, AllowedIPs: []string{"10.20.20.2/32"}}}},
+					},
+				}
+			},
+			current: func() Current {
+				return Current{
+					Forwarding: map[string]int{"ipv4": 0},
+					Interfaces: map[string]CurrentInterface{"wg0": {Addresses: []string{"10.10.10.254/24"}}},
+				}
+			},
+			want: []string{
+				"~ forwarding ipv4: disabled -> enabled",
+				"+ interface awg0 (amneziawg)",
+				"+ address awg0 10.20.20.254/24",
+				"+ peer awg0 " + keyB + " 10.20.20.2/32",
+				"+ forward wg0 -i eth0 -o wg0 -m comment --comment \"brgnetuse:wg0\" -j ACCEPT",
+				"+ forward wg0 -i wg0 -o eth0 -m comment --comment \"brgnetuse:wg0\" -j ACCEPT",
+				"+ nat wg0 -s 10.10.10.0/24 -o eth0 -m comment --comment \"brgnetuse:wg0\" -j MASQUERADE",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, change := range Diff(tc.desired(), tc.current()) {
+				got = append(got, change.String())
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("error: got changes\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
+
+// Testing the Apply function executes the changes in order.
+func TestApply(t *testing.T) {
+	prevDir := peermeta.Dir
+	peermeta.Dir = t.TempDir()
+	t.Cleanup(func() { peermeta.Dir = prevDir })
+
+	prevExists, prevWait := interfaceExists, interfaceWait
+	t.Cleanup(func() { interfaceExists, interfaceWait = prevExists, prevWait })
+	interfaceWait = time.Second
+
+	keyB, keyC, keyD := testKey("B"), testKey("C"), testKey("D")
+	mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.FormatCmdTcQdiscShowJSON("wg0")] = "[]"
+	fake.Outputs[shell.FormatCmdTcQdiscShowJSON("awg0")] = "[]"
+
+	created := false
+	fake.Hook = func(cmd string) {
+		if cmd == shell.FormatCmdAddInterface("awg0", true) {
+			created = true
+		}
+	}
+	interfaceExists = func(name string) (bool, error) { return created, nil }
+
+	desired := State{
+		Forwarding: Forwarding{IPv4: ptr(true)},
+		Interfaces: []Interface{
+			{Name: "wg0", Type: TypeWireGuard, Addresses: []string{"10.10.10.254/24"},
+				Peers: []Peer{{PublicKey: keyB, AllowedIPs: []string{"10.10.10.2/32"}, PersistentKeepalive: 25}},
+				NAT:   []NAT{{Subnet: "10.10.10.0/24", OutInterface: "eth0"}}},
+			{Name: "awg0", Type: TypeAmneziaWG, Addresses: []string{"10.20.20.254/24"},
+				Peers: []Peer{{PublicKey: keyC, AllowedIPs: []string{"10.20.20.2/32"}}}},
+		},
+	}
+	current := Current{
+		Forwarding: map[string]int{"ipv4": 0},
+		Interfaces: map[string]CurrentInterface{"wg0": {
+			Addresses: []string{"10.10.30.254/24"},
+			Peers:     map[string][]string{keyD: {"10.10.10.4/32"}},
+		}},
+	}
+	if err := mock.ConfigureDevice("wg0", wgtypes.Config{Peers: []wgtypes.PeerConfig{
+		{PublicKey: mustKey(t, keyD)},
+	}}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	changes := Diff(desired, current)
+	var out bytes.Buffer
+	if err := Apply(desired, changes, &out); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	wantCmds := []string{
+		shell.SysctlIpv4Up,
+		shell.FormatCmdAddInterface("awg0", true),
+		shell.FormatCmdIpAddrDev("wg0", "10.10.30.254/24", shell.IpDel),
+		shell.FormatCmdIpAddrDev("wg0", "10.10.10.254/24", shell.IpAdd),
+		shell.FormatCmdIpAddrDev("awg0", "10.20.20.254/24", shell.IpAdd),
+		shell.FormatCmdAwgAddPeer("awg0", keyC, "10.20.20.2/32", "", ""),
+		firewall.FormatCmdAppend("filter", "FORWARD", set.ForwardRule("eth0", "wg0", "wg0").Spec),
+		firewall.FormatCmdAppend("filter", "FORWARD", set.ForwardRule("wg0", "eth0", "wg0").Spec),
+		firewall.FormatCmdAppend("nat", "POSTROUTING", set.NATRule("eth0", "10.10.10.0/24", "wg0").Spec),
+	}
+	var gotCmds []string
+	for _, cmd := range fake.Commands {
+		if !strings.HasPrefix(cmd, "tc ") {
+			gotCmds = append(gotCmds, cmd)
+		}
+	}
+	if !slices.Equal(gotCmds, wantCmds) {
+		t.Errorf("error: got commands\n%s\nwant\n%s", strings.Join(gotCmds, "\n"), strings.Join(wantCmds, "\n"))
+	}
+
+	device, err := mock.Device("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(device.Peers) != 1 || device.Peers[0].PublicKey.String() != keyB {
+		t.Errorf("error: got peers %v, want only %s", device.Peers, keyB)
+	}
+	if device.Peers[0].PersistentKeepaliveInterval != 25*time.Second {
+		t.Errorf("error: got keepalive %s, want 25s", device.Peers[0].PersistentKeepaliveInterval)
+	}
+
+	meta, err := peermeta.LoadInterface("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(meta.Rules) != 3 || !slices.Equal(meta.Addresses, []string{"10.10.10.254/24"}) {
+		t.Errorf("error: got recorded rules %v and addresses %v", meta.Rules, meta.Addresses)
+	}
+
+	if lines := strings.Count(out.String(), "applied "); lines != len(changes) {
+		t.Errorf("error: got %d applied changes, want %d:\n%s", lines, len(changes), out.String())
+	}
+}
+
+// Testing that Apply stops at the first failing change.
+func TestApplyStopsOnError(t *testing.T) {
+	prevDir := peermeta.Dir
+	peermeta.Dir = t.TempDir()
+	t.Cleanup(func() { peermeta.Dir = prevDir })
+
+	prevExists, prevWait := interfaceExists, interfaceWait
+	t.Cleanup(func() { interfaceExists, interfaceWait = prevExists, prevWait })
+	interfaceExists = func(name string) (bool, error) { return false, nil }
+	interfaceWait = 200 * time.Millisecond
+
+	fake := shellmock.Install(t)
+
+	desired := State{Interfaces: []Interface{{Name: "wg0", Type: TypeWireGuard, Addresses: []string{"10.10.10.254/24"}}}}
+	err := Apply(desired, Diff(desired, Current{}), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "did not appear") {
+		t.Fatalf("error: got %v, want the interface wait error", err)
+	}
+	if slices.Contains(fake.Commands, shell.FormatCmdIpAddrDev("wg0", "10.10.10.254/24", shell.IpAdd)) {
+		t.Errorf("error: address applied after the failed interface: %v", fake.Commands)
+	}
+}
+
+// Function decodes a public key for the tests.
+func mustKey(t *testing.T, value string) wgtypes.Key {
+	t.Helper()
+
+	key, err := wgtypes.ParseKey(value)
+	if err != nil {
+		t.Fatalf("error: invalid key %q: %v", value, err)
+	}
+	return key
+}
+
+// Testing that liveRules reads the rules tagged by this tool and the
+// untagged ones, and ignores the rules commented by the administrator.
+func TestLiveRules(t *testing.T) {
+	fake := shellmock.Install(t)
+	fake.Outputs[firewall.CmdList] = `Chain FORWARD (policy DROP 0 packets, 0 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    0     0 ACCEPT     all  --  eth0   wg0     0.0.0.0/0            0.0.0.0/0            /* brgnetuse:wg0 */
+    0     0 ACCEPT     all  --  wg0    eth0    0.0.0.0/0            0.0.0.0/0
+    0     0 ACCEPT     all  --  wg1    eth0    0.0.0.0/0            0.0.0.0/0            /* office link */
+`
+	fake.Outputs[firewall.CmdListNat] = `Chain POSTROUTING (policy ACCEPT 0 packets, 0 bytes)
+ pkts bytes target     prot opt in     out     source               destination
+    0     0 MASQUERADE  all  --  any    eth0    10.10.10.0/24        anywhere             /* brgnetuse:wg0 */
+    0     0 MASQUERADE  all  --  any    eth0    10.20.0.0/24         anywhere
+    0     0 MASQUERADE  all  --  any    eth0    10.30.0.0/24         anywhere             /* admin */
+`
+
+	got, err := liveRules()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	want := []peermeta.Rule{
+		set.ForwardRule("eth0", "wg0", "wg0"),
+		set.UntaggedRule(set.ForwardRule("wg0", "eth0", "")),
+		set.NATRule("eth0", "10.10.10.0/24", "wg0"),
+		set.UntaggedRule(set.NATRule("eth0", "10.20.0.0/24", "")),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("error: got rules\n%+v\nwant\n%+v", got, want)
+	}
+}
@@ -0,0 +1,134 @@
+// Package contains the comparison of a desired state, kept in a JSON file,
+// with the live system and the changes reconciling them.
+package reconcile
+
+import (
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/src/spec"
+)
+
+// Interface types of the desired state.
+const (
+	TypeWireGuard = "wireguard"
+	TypeAmneziaWG = "amneziawg"
+)
+
+// Kinds of the changes, in the order they are applied.
+const (
+	KindForwarding = "forwarding"
+	KindInterface  = "interface"
+	KindAddress    = "address"
+	KindPeer       = "peer"
+	KindAllowedIPs = "allowed-ips"
+	KindForward    = "forward"
+	KindNAT        = "nat"
+)
+
+// Actions of the changes.
+const (
+	ActionAdd    = "add"
+	ActionRemove = "remove"
+	ActionUpdate = "update"
+)
+
+// State is the desired state of the system.
+//
+// Example:
+//
+//	{
+//	  "forwarding": {"ipv4": true},
+//	  "interfaces": [{
+//	    "name": "wg0",
+//	    "addresses": ["10.10.10.254/24"],
+//	    "peers": [{"public_key": "AAAA...=", "allowed_ips": ["10.10.10.2/32"]}],
+//	    "nat": [{"subnet": "10.10.10.0/24", "out_interface": "eth0"}]
+//	  }]
+//	}
+type State struct {
+	// Forwarding is the desired IP forwarding, unset families are ignored.
+	Forwarding Forwarding `json:"forwarding"`
+
+	// Interfaces lists the desired network interfaces. Interfaces not
+	// listed are ignored.
+	Interfaces []Interface `json:"interfaces"`
+}
+
+// Forwarding is the desired IPv4 and IPv6 forwarding (sysctl).
+type Forwarding struct {
+	IPv4 *bool `json:"ipv4,omitempty"`
+	IPv6 *bool `json:"ipv6,omitempty"`
+}
+
+// Interface is a desired WireGuard or AmneziaWG network interface.
+type Interface struct {
+	// Name is the network interface name.
+	Name string `json:"name"`
+
+	// Type is TypeWireGuard (default) or TypeAmneziaWG, it selects the
+	// utility creating a missing interface.
+	Type string `json:"type,omitempty"`
+
+	// Addresses lists the IP addresses in CIDR notation. Addresses of the
+	// interface not listed are removed, link-local ones excepted.
+	Addresses []string `json:"addresses,omitempty"`
+
+	// Peers lists the peers. Peers of the interface not listed are removed.
+	Peers []Peer `json:"peers,omitempty"`
+
+	// NAT lists the subnets masqueraded through an outgoing interface,
+	// with the FORWARD rules between the two interfaces.
+	NAT []NAT `json:"nat,omitempty"`
+}
+
+// Peer is a desired peer of a network interface, see spec.Peer.
+type Peer = spec.Peer
+
+// NAT is a subnet masqueraded through an outgoing network interface, see
+// spec.NAT.
+type NAT = spec.NAT
+
+// Current is the live state of the system, limited to what a State
+// describes (see Inspect).
+type Current struct {
+	// Forwarding maps "ipv4" and "ipv6" to the forwarding status (1 or 0).
+	Forwarding map[string]int
+
+	// Interfaces maps the names of the desired interfaces that exist to
+	// their live state.
+	Interfaces map[string]CurrentInterface
+
+	// Rules lists the FORWARD and POSTROUTING MASQUERADE rules, in the form
+	// of set.ForwardRules and set.NATRule.
+	Rules []peermeta.Rule
+}
+
+// CurrentInterface is the live state of a network interface.
+type CurrentInterface struct {
+	// Addresses lists the IP addresses in CIDR notation, link-local ones
+	// excepted.
+	Addresses []string
+
+	// Peers maps the public keys of the peers to their allowed IPs.
+	Peers map[string][]string
+}
+
+// Change is a difference between the desired and the live state, and the
+// action reconciling it.
+type Change struct {
+	// Kind is the changed object (e.g., KindPeer).
+	Kind string `json:"kind"`
+
+	// Action is ActionAdd, ActionRemove or ActionUpdate.
+	Action string `json:"action"`
+
+	// Interface is the network interface, empty for KindForwarding.
+	Interface string `json:"interface,omitempty"`
+
+	// Target identifies the object: an address, a public key, a rule
+	// specification or the forwarding family.
+	Target string `json:"target"`
+
+	// Desired and Current are the compared values, when relevant.
+	Desired []string `json:"desired,omitempty"`
+	Current []string `json:"current,omitempty"`
+}
@@ -0,0 +1,55 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/AlexKira/brgnetuse/internal/shell"
+)
+
+// Image types of QRCode, see `qrencode -t`.
+const (
+	QRPNG      string = "PNG"
+	QRTerminal string = "ansiutf8"
+)
+
+// Function returns the QR code of the rendered text made by qrencode, as a
+// PNG image or drawn for a terminal (see QRPNG and QRTerminal). The text
+// holds the private key of the client, so it is passed in a temporary file
+// readable by its owner only, removed on every path, rather than on the
+// command line.
+//
+// Usage example:
+//
+//	png, err := render.QRCode(text, render.QRPNG)
+//	if err != nil {
+//	    // Handle error
+//	}
+func QRCode(text, imageType string) ([]byte, error) {
+	file, err := os.CreateTemp("", "brgnetuse-client-*.conf")
+	if err != nil {
+		return nil, fmt.Errorf("error: failed to create temporary file, %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	err = file.Chmod(0o600)
+	if err == nil {
+		_, err = file.WriteString(text)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error: failed to write temporary file, %v", err)
+	}
+
+	output, err := shell.DefaultRunner.Output(shell.FormatCmdQrencode(imageType, file.Name()))
+	if err != nil {
+		return nil, err
+	}
+	if output.Len() == 0 {
+		return nil, errors.New("error: qrencode printed no QR code")
+	}
+	return output.Bytes(), nil
+}
@@ -0,0 +1,167 @@
+// Package render renders the configuration of a WireGuard client with
+// text/template, so the same client is given in the layout of its tool:
+// wg-quick, a NetworkManager keyfile or a MikroTik RouterOS script. The
+// built-in templates are selected by format (see Builtin), custom ones are
+// read from a file (see ParseFile). Every template is executed with a
+// Client, the data model documented below.
+//
+// Usage example:
+//
+//	tmpl, err := render.Builtin(render.FormatWgQuick)
+//	if err != nil {
+//	    // Handle error
+//	}
+//	text, err := render.Render(tmpl, client)
+package render
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+)
+
+// Formats of the built-in templates.
+const (
+	FormatWgQuick  string = "wgquick"
+	FormatNM       string = "nm"
+	FormatMikroTik string = "mikrotik"
+)
+
+// Client is the data model of the templates: the client side of a tunnel
+// to a server network interface. The lists are never nil.
+type Client struct {
+	// Name is the network interface (NetworkManager connection, MikroTik
+	// interface) of the client, the server interface name by default.
+	Name string
+
+	// ServerPublicKey is the public key of the server interface (base64).
+	ServerPublicKey string
+
+	// Endpoint is the address of the server, `host:port`.
+	Endpoint string
+
+	// PrivateKey and PublicKey are the key pair of the client (base64).
+	PrivateKey string
+	PublicKey  string
+
+	// Address lists the addresses of the client in CIDR notation.
+	Address []string
+
+	// DNS lists the DNS servers of the client, may be empty.
+	DNS []string
+
+	// AllowedIPs lists the networks routed through the tunnel.
+	AllowedIPs []string
+
+	// PersistentKeepalive is the keepalive interval in seconds, 0 disables
+	// it.
+	PersistentKeepalive int
+}
+
+// Functions available to the templates besides the text/template ones:
+//
+//	join    joins a list with a separator: {{join .DNS ", "}}
+//	ipv4    keeps the IPv4 addresses or networks of a list
+//	ipv6    keeps the IPv6 addresses or networks of a list
+//	host    the host of an endpoint: {{host .Endpoint}}
+//	port    the port of an endpoint: {{port .Endpoint}}
+//	inc     adds one to a number, e.g., the index of a range
+var funcs = template.FuncMap{
+	"inc":  func(n int) int { return n + 1 },
+	"join": func(list []string, sep string) string { return strings.Join(list, sep) },
+	"ipv4": func(list []string) []string { return filterFamily(list, false) },
+	"ipv6": func(list []string) []string { return filterFamily(list, true) },
+	"host": func(endpoint string) string {
+		host, _, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			return endpoint
+		}
+		return host
+	},
+	"port": func(endpoint string) string {
+		_, port, _ := net.SplitHostPort(endpoint)
+		return port
+	},
+}
+
+// Location of a text/template error: `template: NAME:LINE[:COL]: MESSAGE`.
+var errorLine = regexp.MustCompile(`^template: [^:]*:(\d+)(?::\d+)?: (.*)$`)
+
+// Function returns the names of the built-in formats.
+func Formats() []string {
+	return []string{FormatWgQuick, FormatNM, FormatMikroTik}
+}
+
+// Function returns the built-in template of the format (see Formats).
+func Builtin(format string) (*template.Template, error) {
+	text, ok := builtins[format]
+	if !ok {
+		return nil, help.Errorf(
+			help.CodeInvalidValue,
+			"error: unknown client configuration format '%s', expected one of: %s",
+			format,
+			strings.Join(Formats(), ", "),
+		)
+	}
+	return Parse(format, text)
+}
+
+// Function reads and parses a custom template file.
+func ParseFile(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, help.Errorf(help.CodeFileFailed, "error: failed to read template '%s': %v", path, err)
+	}
+	return Parse(filepath.Base(path), string(data))
+}
+
+// Function parses a template. A field missing from Client, or an error in
+// the template text, is reported with its line.
+func Parse(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, templateError(name, err)
+	}
+	return tmpl, nil
+}
+
+// Function executes the template with the client and returns the text.
+func Render(tmpl *template.Template, client Client) (string, error) {
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, client); err != nil {
+		return "", templateError(tmpl.Name(), err)
+	}
+	return out.String(), nil
+}
+
+// Function rewrites a text/template error to point at the template line:
+// `error: template 'NAME', line N: MESSAGE`.
+func templateError(name string, err error) error {
+	match := errorLine.FindStringSubmatch(err.Error())
+	if match == nil {
+		return help.Errorf(help.CodeInvalidFile, "error: template '%s': %v", name, err)
+	}
+	return help.Errorf(help.CodeInvalidFile, "error: template '%s', line %s: %s", name, match[1], match[2])
+}
+
+// Function keeps the addresses or networks of a list in one IP family.
+func filterFamily(list []string, v6 bool) []string {
+	kept := []string{}
+	for _, value := range list {
+		addr, err := netip.ParseAddr(value)
+		if prefix, perr := netip.ParsePrefix(value); perr == nil {
+			addr, err = prefix.Addr(), nil
+		}
+		if err == nil && addr.Unmap().Is6() == v6 {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,295 @@
+package render
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AlexKira/brgnetuse/internal/shellmock"
+)
+
+// Client of the golden outputs, dual stack.
+var testClient = Client{
+	Name:                "wg0",
+	ServerPublicKey:     "SERVERKEY=",
+	Endpoint:            "vpn.example.com:51820",
+	PrivateKey:          "CLIENTPRIVATE=",
+	PublicKey:           "CLIENTPUBLIC=",
+	Address:             []string{"10.10.10.2/32", "fd00::2/128"},
+	DNS:                 []string{"10.10.10.1", "fd00::1"},
+	AllowedIPs:          []string{"0.0.0.0/0", "::/0"},
+	PersistentKeepalive: 25,
+}
+
+// Testing the built-in templates against their golden outputs.
+func TestBuiltin(t *testing.T) {
+	ipv4Only := Client{
+		Name:            "office",
+		ServerPublicKey: "SERVERKEY=",
+		Endpoint:        "203.0.113.1:51821",
+		PrivateKey:      "CLIENTPRIVATE=",
+		Address:         []string{"10.10.10.3/32"},
+		DNS:             []string{},
+		AllowedIPs:      []string{"10.10.10.0/24"},
+	}
+
+	type testCase struct {
+		name   string
+		format string
+		client Client
+		want   string
+	}
+
+	tests := []testCase{
+		{
+			name:   "wgquick",
+			format: FormatWgQuick,
+			client: testClient,
+			want: "[Interface]\n" +
+				"PrivateKey = CLIENTPRIVATE=\n" +
+				"Address = 10.10.10.2/32, fd00::2/128\n" +
+				"DNS = 10.10.10.1, fd00::1\n" +
+				"\n" +
+				"[Peer]\n" +
+				"PublicKey = SERVERKEY=\n" +
+				"Endpoint = vpn.example.com:51820\n" +
+				"AllowedIPs = 0.0.0.0/0, ::/0\n" +
+				"PersistentKeepalive = 25\n",
+		},
+		{
+			name:   "wgquick_ipv4_only",
+			format: FormatWgQuick,
+			client: ipv4Only,
+			want: "[Interface]\n" +
+				"PrivateKey = CLIENTPRIVATE=\n" +
+				"Address = 10.10.10.3/32\n" +
+				"\n" +
+				"[Peer]\n" +
+				"PublicKey = SERVERKEY=\n" +
+				"Endpoint = 203.0.113.1:51821\n" +
+				"AllowedIPs = 10.10.10.0/24\n",
+		},
+		{
+			name:   "nm",
+			format: FormatNM,
+			client: testClient,
+			want: "[connection]\n" +
+				"id=wg0\n" +
+				"type=wireguard\n" +
+				"interface-name=wg0\n" +
+				"\n" +
+				"[wireguard]\n" +
+				"private-key=CLIENTPRIVATE=\n" +
+				"\n" +
+				"[wireguard-peer.SERVERKEY=]\n" +
+				"endpoint=vpn.example.com:51820\n" +
+				"allowed-ips=0.0.0.0/0;::/0;\n" +
+				"persistent-keepalive=25\n" +
+				"\n" +
+				"[ipv4]\n" +
+				"address1=10.10.10.2/32\n" +
+				"dns=10.10.10.1;\n" +
+				"method=manual\n" +
+				"\n" +
+				"[ipv6]\n" +
+				"address1=fd00::2/128\n" +
+				"dns=fd00::1;\n" +
+				"method=manual\n",
+		},
+		{
+			name:   "nm_ipv4_only",
+			format: FormatNM,
+			client: ipv4Only,
+			want: "[connection]\n" +
+				"id=office\n" +
+				"type=wireguard\n" +
+				"interface-name=office\n" +
+				"\n" +
+				"[wireguard]\n" +
+				"private-key=CLIENTPRIVATE=\n" +
+				"\n" +
+				"[wireguard-peer.SERVERKEY=]\n" +
+				"endpoint=203.0.113.1:51821\n" +
+				"allowed-ips=10.10.10.0/24;\n" +
+				"\n" +
+				"[ipv4]\n" +
+				"address1=10.10.10.3/32\n" +
+				"method=manual\n" +
+				"\n" +
+				"[ipv6]\n" +
+				"method=ignore\n",
+		},
+		{
+			name:   "mikrotik",
+			format: FormatMikroTik,
+			client: testClient,
+			want: "/interface wireguard\n" +
+				"add name=wg0 private-key=\"CLIENTPRIVATE=\"\n" +
+				"/interface wireguard peers\n" +
+				"add interface=wg0 public-key=\"SERVERKEY=\" endpoint-address=vpn.example.com endpoint-port=51820 " +
+				"allowed-address=0.0.0.0/0,::/0 persistent-keepalive=25s\n" +
+				"/ip address\n" +
+				"add address=10.10.10.2/32 interface=wg0\n" +
+				"/ipv6 address\n" +
+				"add address=fd00::2/128 interface=wg0 advertise=no\n" +
+				"/ip dns\n" +
+				"set servers=10.10.10.1,fd00::1\n",
+		},
+		{
+			name:   "mikrotik_ipv4_only",
+			format: FormatMikroTik,
+			client: ipv4Only,
+			want: "/interface wireguard\n" +
+				"add name=office private-key=\"CLIENTPRIVATE=\"\n" +
+				"/interface wireguard peers\n" +
+				"add interface=office public-key=\"SERVERKEY=\" endpoint-address=203.0.113.1 endpoint-port=51821 " +
+				"allowed-address=10.10.10.0/24\n" +
+				"/ip address\n" +
+				"add address=10.10.10.3/32 interface=office\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := Builtin(tc.format)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			got, err := Render(tmpl, tc.client)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := Builtin("ini"); err == nil || !strings.Contains(err.Error(), "wgquick, nm, mikrotik") {
+		t.Errorf("error: got %v, want the list of the formats", err)
+	}
+}
+
+// Testing that the errors of a custom template point at its line.
+func TestTemplateErrors(t *testing.T) {
+	type testCase struct {
+		name string
+		text string
+		want string
+	}
+
+	tests := []testCase{
+		{
+			name: "parse",
+			text: "[Interface]\n\nAddress = {{join .Address}\n",
+			want: "error: template 'custom.tmpl', line 3: bad character U+007D '}'",
+		},
+		{
+			name: "unknown_function",
+			text: "[Interface]\nDNS = {{dns .DNS}}\n",
+			want: "error: template 'custom.tmpl', line 2: function \"dns\" not defined",
+		},
+		{
+			name: "unknown_field",
+			text: "[Interface]\nPrivateKey = {{.PrivateKey}}\nMTU = {{.MTU}}\n",
+			want: "error: template 'custom.tmpl', line 3: executing \"custom.tmpl\" at <.MTU>: " +
+				"can't evaluate field MTU in type render.Client",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "custom.tmpl")
+			if err := os.WriteFile(path, []byte(tc.text), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			tmpl, err := ParseFile(path)
+			if err == nil {
+				_, err = Render(tmpl, testClient)
+			}
+			if err == nil || err.Error() != tc.want {
+				t.Errorf("error: got %v, want %q", err, tc.want)
+			}
+		})
+	}
+
+	if _, err := ParseFile(filepath.Join(t.TempDir(), "missing.tmpl")); err == nil {
+		t.Error("error: expected error for a missing template, got none")
+	}
+}
+
+// Testing the functions of the templates.
+func TestFuncs(t *testing.T) {
+	tmpl, err := Parse("funcs", `{{host .Endpoint}} {{port .Endpoint}} {{ipv4 .AllowedIPs}} {{ipv6 .DNS}} {{inc 1}}`)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	got, err := Render(tmpl, testClient)
+	if want := "vpn.example.com 51820 [0.0.0.0/0] [fd00::1] 2"; err != nil || got != want {
+		t.Errorf("error: got %q %v, want %q", got, err, want)
+	}
+
+	client := testClient
+	client.Endpoint = "[fd00::1]:51820"
+	got, err = Render(tmpl, client)
+	if want := "fd00::1 51820 [0.0.0.0/0] [fd00::1] 2"; err != nil || got != want {
+		t.Errorf("error: got %q %v, want %q", got, err, want)
+	}
+}
+
+// Testing that QRCode passes the text to qrencode in a file readable by its
+// owner only, removed after a success and a failure alike.
+func TestQRCode(t *testing.T) {
+	type testCase struct {
+		name      string
+		output    string
+		err       error
+		wantError string
+	}
+
+	tests := []testCase{
+		{name: "png", output: "\x89PNG"},
+		{name: "qrencode missing", err: errors.New("qrencode: not found"), wantError: "qrencode: not found"},
+		{name: "no output", wantError: "printed no QR code"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := shellmock.Install(t)
+			fake.Outputs["qrencode -t PNG"] = tc.output
+			if tc.err != nil {
+				fake.Errors["qrencode -t PNG"] = tc.err
+			}
+
+			var path, text string
+			var mode os.FileMode
+			fake.Hook = func(cmd string) {
+				path = strings.TrimPrefix(cmd, "qrencode -t PNG -o - -r ")
+				if info, err := os.Stat(path); err == nil {
+					mode = info.Mode().Perm()
+				}
+				data, _ := os.ReadFile(path)
+				text = string(data)
+			}
+
+			got, err := QRCode("PrivateKey = CLIENTPRIVATE=\n", QRPNG)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got error %v, want %q", err, tc.wantError)
+				}
+			} else if err != nil || string(got) != tc.output {
+				t.Fatalf("error: got %q, %v, want %q", got, err, tc.output)
+			}
+
+			if text != "PrivateKey = CLIENTPRIVATE=\n" || mode != 0o600 {
+				t.Errorf("error: qrencode read %q from a file of mode %o", text, mode)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("error: temporary file kept: %v", err)
+			}
+		})
+	}
+}
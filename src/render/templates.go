@@ -0,0 +1,87 @@
+package render
+
+// Built-in templates by format, see Builtin.
+var builtins = map[string]string{
+	FormatWgQuick:  wgQuickTemplate,
+	FormatNM:       nmTemplate,
+	FormatMikroTik: mikroTikTemplate,
+}
+
+// Configuration file of wg-quick (e.g., /etc/wireguard/wg0.conf), also
+// imported by the WireGuard applications.
+const wgQuickTemplate string = `[Interface]
+PrivateKey = {{.PrivateKey}}
+Address = {{join .Address ", "}}
+{{- if .DNS}}
+DNS = {{join .DNS ", "}}
+{{- end}}
+
+[Peer]
+PublicKey = {{.ServerPublicKey}}
+Endpoint = {{.Endpoint}}
+AllowedIPs = {{join .AllowedIPs ", "}}
+{{- if .PersistentKeepalive}}
+PersistentKeepalive = {{.PersistentKeepalive}}
+{{- end}}
+`
+
+// NetworkManager keyfile (e.g.,
+// /etc/NetworkManager/system-connections/wg0.nmconnection, mode 0600).
+const nmTemplate string = `[connection]
+id={{.Name}}
+type=wireguard
+interface-name={{.Name}}
+
+[wireguard]
+private-key={{.PrivateKey}}
+
+[wireguard-peer.{{.ServerPublicKey}}]
+endpoint={{.Endpoint}}
+allowed-ips={{join .AllowedIPs ";"}};
+{{- if .PersistentKeepalive}}
+persistent-keepalive={{.PersistentKeepalive}}
+{{- end}}
+
+[ipv4]
+{{- range $indx, $address := ipv4 .Address}}
+address{{inc $indx}}={{$address}}
+{{- end}}
+{{- with ipv4 .DNS}}
+dns={{join . ";"}};
+{{- end}}
+method={{if ipv4 .Address}}manual{{else}}disabled{{end}}
+
+[ipv6]
+{{- range $indx, $address := ipv6 .Address}}
+address{{inc $indx}}={{$address}}
+{{- end}}
+{{- with ipv6 .DNS}}
+dns={{join . ";"}};
+{{- end}}
+method={{if ipv6 .Address}}manual{{else}}ignore{{end}}
+`
+
+// MikroTik RouterOS script, pasted into the terminal or run with
+// /import.
+const mikroTikTemplate string = `/interface wireguard
+add name={{.Name}} private-key="{{.PrivateKey}}"
+/interface wireguard peers
+add interface={{.Name}} public-key="{{.ServerPublicKey}}" endpoint-address={{host .Endpoint}} endpoint-port={{port .Endpoint}} allowed-address={{join .AllowedIPs ","}}
+{{- if .PersistentKeepalive}} persistent-keepalive={{.PersistentKeepalive}}s{{end}}
+{{- with ipv4 .Address}}
+/ip address
+{{- range .}}
+add address={{.}} interface={{$.Name}}
+{{- end}}
+{{- end}}
+{{- with ipv6 .Address}}
+/ipv6 address
+{{- range .}}
+add address={{.}} interface={{$.Name}} advertise=no
+{{- end}}
+{{- end}}
+{{- if .DNS}}
+/ip dns
+set servers={{join .DNS ","}}
+{{- end}}
+`
@@ -0,0 +1,382 @@
+package set
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/get"
+)
+
+// Adoption is the plan of the adoption of a network interface created by
+// another tool (e.g., wg-quick), see PlanAdoption and Adopt.
+type Adoption struct {
+	// Interface is the adopted network interface.
+	Interface string
+
+	// Backend describes the implementation of the interface
+	// (e.g., "kernel WireGuard"), see get.Backend.
+	Backend string
+
+	// Peers is the number of peers of the device.
+	Peers int
+
+	// Addresses lists the addresses (CIDR) of the interface, link-local
+	// addresses excluded.
+	Addresses []string
+
+	// Rules lists the untagged rules attributed to the interface, which Adopt
+	// replaces with their tagged form.
+	Rules []AdoptedRule
+
+	// Managed lists the rules already tagged for the interface.
+	Managed []peermeta.Rule
+
+	// Foreign lists the rules naming the interface, its subnets or its
+	// listen port which are not in the form of a rule of this tool. They
+	// are left as they are, for the administrator to confirm.
+	Foreign []ForeignRule
+}
+
+// AdoptedRule is an untagged iptables rule attributed to the adopted
+// network interface.
+type AdoptedRule struct {
+	// Number is the position of the rule in its chain, starting at 1.
+	Number int
+
+	// Spec is the current specification of the rule, as listed by
+	// iptables-save.
+	Spec string
+
+	// Rule is the tagged rule replacing it. Its table and chain are the
+	// ones of the current rule.
+	Rule peermeta.Rule
+
+	// Recorded reports whether the rule is recorded in the interface
+	// metadata. The INPUT port rule is not bound to an interface, it is
+	// found by its tag.
+	Recorded bool
+}
+
+// Method describes the current rule (e.g., "nat POSTROUTING rule
+// '-s 10.0.0.0/24 -o eth0 -j MASQUERADE'").
+func (r AdoptedRule) String() string {
+	return fmt.Sprintf("%s %s rule '%s'", r.Rule.Table, r.Rule.Chain, r.Spec)
+}
+
+// ForeignRule is an iptables rule related to the adopted network interface
+// but not attributed to it.
+type ForeignRule struct {
+	Table string
+	Chain string
+	Spec  string
+}
+
+// Method describes the rule like AdoptedRule.String.
+func (r ForeignRule) String() string {
+	return fmt.Sprintf("%s %s rule '%s'", r.Table, r.Chain, r.Spec)
+}
+
+// Function inspects a running WireGuard or AmneziaWG network interface
+// created by another tool and returns the plan of its adoption: its
+// backend, peers and addresses, and the iptables rules attributed to it.
+// Nothing is changed, see Adopt.
+//
+// A rule is attributed to the interface only in the exact form of a rule of
+// this tool: the FORWARD rule between the interface and another one, the
+// MASQUERADE rule of a subnet of its addresses leaving through another
+// interface, its hairpin NAT rule, and the INPUT rule of its listen port.
+// Every other rule naming the interface, a subnet of its addresses or its
+// listen port (e.g., the `-A FORWARD -i wg0 -j ACCEPT` of a wg-quick
+// PostUp) is listed as foreign.
+//
+// Usage example:
+//
+//	plan, err := set.PlanAdoption("wg0")
+//	if err != nil {
+//	    // Handle error
+//	}
+//	for _, rule := range plan.Foreign {
+//	    // Confirm by hand
+//	}
+func PlanAdoption(interfaceName string) (Adoption, error) {
+	backend, err := get.GetInterfaceBackend(interfaceName)
+	if err != nil {
+		return Adoption{}, err
+	}
+	if backend == get.BackendUnknown {
+		return Adoption{}, fmt.Errorf(
+			"error: network interface '%s' is not a running WireGuard or AmneziaWG interface", interfaceName,
+		)
+	}
+
+	var device get.DeviceInfo
+	if backend.AmneziaWG() {
+		device, err = get.GetAwgPeerInfo(interfaceName)
+	} else {
+		var devices []get.DeviceInfo
+		devices, err = get.GetPeerInfo(interfaceName)
+		if err == nil && len(devices) == 1 {
+			device = devices[0]
+		}
+	}
+	if err != nil {
+		return Adoption{}, err
+	}
+
+	plan := Adoption{Interface: interfaceName, Backend: backend.String(), Peers: len(device.Peers)}
+
+	show, err := get.GetIpShow(interfaceName)
+	if err != nil {
+		return Adoption{}, err
+	}
+	var subnets []string
+	for _, link := range show {
+		for _, addr := range link.AddrInfo {
+			// Link-local addresses come with the link.
+			if addr.Scope == "link" {
+				continue
+			}
+			prefix, err := netip.ParsePrefix(fmt.Sprintf("%s/%d", addr.Local, addr.Prefixlen))
+			if err != nil {
+				continue
+			}
+			plan.Addresses = append(plan.Addresses, prefix.String())
+			if subnet := prefix.Masked().String(); !slices.Contains(subnets, subnet) {
+				subnets = append(subnets, subnet)
+			}
+		}
+	}
+
+	saved, err := get.GetIptablesSave()
+	if err != nil {
+		return Adoption{}, err
+	}
+	plan.Rules, plan.Managed, plan.Foreign = attributeRules(saved, interfaceName, subnets, device.ListenPort)
+
+	return plan, nil
+}
+
+// Function sorts the rules of the iptables-save output related to the
+// network interface into the untagged rules attributed to it, the rules
+// already tagged for it and the foreign rules, see PlanAdoption.
+func attributeRules(saved get.IptablesSave, iface string, subnets []string, port int) ([]AdoptedRule, []peermeta.Rule, []ForeignRule) {
+	var adopted []AdoptedRule
+	var managed []peermeta.Rule
+	var foreign []ForeignRule
+
+	dport := ""
+	if port != 0 {
+		dport = strconv.Itoa(port)
+	}
+
+	for _, table := range saved.Tables {
+		numbers := make(map[string]int)
+		for _, rule := range table.Rules {
+			numbers[rule.Chain]++
+
+			fields := strings.Fields(rule.Spec)
+			related := slices.Contains(fields, iface) ||
+				slices.ContainsFunc(subnets, func(s string) bool { return slices.Contains(fields, s) }) ||
+				(rule.Chain == "INPUT" && dport != "" && slices.Contains(fields, dport))
+
+			switch owner, tagged := (get.IptablesRule{Comment: rule.Comment}).Owner(); {
+			case tagged && owner == iface:
+				managed = append(managed, peermeta.Rule{Table: table.Name, Chain: rule.Chain, Spec: rule.Spec})
+				continue
+			case tagged || !related:
+				continue
+			}
+
+			if rule.Comment == "" {
+				if tagged, recorded, ok := adoptedForm(table.Name, rule.Chain, rule.Spec, iface, subnets, dport); ok {
+					adopted = append(adopted, AdoptedRule{
+						Number:   numbers[rule.Chain],
+						Spec:     rule.Spec,
+						Rule:     tagged,
+						Recorded: recorded,
+					})
+					continue
+				}
+			}
+			foreign = append(foreign, ForeignRule{Table: table.Name, Chain: rule.Chain, Spec: rule.Spec})
+		}
+	}
+	return adopted, managed, foreign
+}
+
+// Function returns the tagged form of an untagged rule in the exact form of
+// a rule of this tool for the network interface, and whether it is recorded
+// in the interface metadata. It reports false for any other rule.
+func adoptedForm(table, chain, spec, iface string, subnets []string, dport string) (peermeta.Rule, bool, bool) {
+	options, ok := specOptions(spec)
+	if !ok {
+		return peermeta.Rule{}, false, false
+	}
+	only := func(keys ...string) bool {
+		if len(options) != len(keys) {
+			return false
+		}
+		for _, key := range keys {
+			if _, ok := options[key]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	in, out, source, target := options["-i"], options["-o"], options["-s"], options["-j"]
+
+	switch {
+	case table == "filter" && chain == "FORWARD" && target == "ACCEPT" &&
+		only("-i", "-o", "-j") && (in == iface || out == iface):
+		return ForwardRule(in, out, iface), true, true
+
+	case table == "nat" && chain == "POSTROUTING" && target == "MASQUERADE" &&
+		only("-s", "-o", "-j") && out != iface && slices.Contains(subnets, source):
+		return NATRule(out, source, iface), true, true
+
+	case table == "nat" && chain == "POSTROUTING" && target == "MASQUERADE" &&
+		only("-s", "-d", "-o", "-j") && out == iface && options["-d"] == source && slices.Contains(subnets, source):
+		return HairpinRule(source, iface), true, true
+
+	case table == "filter" && chain == "INPUT" && target == "ACCEPT" && dport != "" &&
+		options["-p"] == "udp" && options["--dport"] == dport &&
+		(only("-p", "--dport", "-j") || only("-i", "-p", "--dport", "-j")):
+		cmd := firewall.FormatCmdPortIn(firewall.Append, dport, in)
+		return peermeta.Rule{Table: "filter", Chain: "INPUT", Spec: strings.TrimPrefix(cmd, "iptables -A INPUT ")}, false, true
+	}
+	return peermeta.Rule{}, false, false
+}
+
+// Function returns the options of an iptables-save rule specification by
+// name (e.g., "-o": "eth0"). The protocol match implied by the port options
+// (`-m udp`) is dropped. It reports false for a specification not made of
+// single-valued options, e.g., a negation or a repeated option.
+func specOptions(spec string) (map[string]string, bool) {
+	fields := strings.Fields(spec)
+	if len(fields)%2 != 0 {
+		return nil, false
+	}
+
+	options := make(map[string]string)
+	for i := 0; i < len(fields); i += 2 {
+		key, value := fields[i], fields[i+1]
+		if !strings.HasPrefix(key, "-") || value == "!" || key == "!" {
+			return nil, false
+		}
+		if key == "-m" && (value == "udp" || value == "tcp") {
+			continue
+		}
+		if _, ok := options[key]; ok {
+			return nil, false
+		}
+		options[key] = value
+	}
+	return options, true
+}
+
+// Function adopts a network interface as planned by PlanAdoption: the
+// attributed rules are replaced with their tagged form in place, in a single
+// `iptables-restore --noflush` transaction per table which fails if a rule
+// is no longer listed, and checked; then the
+// addresses, the rules and the backend of the interface are recorded in its
+// metadata. From then on, the interface is managed like one created by this
+// tool: its rules are found by their tag (e.g., by a purge).
+//
+// The foreign rules are left as they are.
+func Adopt(plan Adoption, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(plan.Interface, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	if len(plan.Rules) > 0 {
+		var content strings.Builder
+		for _, table := range []string{"filter", "nat"} {
+			// Each rule is deleted by its specification, not its position,
+			// and its tagged form inserted at the position. A rule another
+			// tool removed meanwhile fails its deletion, aborting the whole
+			// transaction, so no other rule is ever overwritten. The
+			// insertions follow the deletions in the order of the positions,
+			// which restores them.
+			var deletions, insertions []string
+			for _, rule := range plan.Rules {
+				if rule.Rule.Table != table {
+					continue
+				}
+				deletions = append(deletions, fmt.Sprintf("-D %s %s", rule.Rule.Chain, rule.Spec))
+				insertions = append(insertions, fmt.Sprintf("-I %s %d %s", rule.Rule.Chain, rule.Number, rule.Rule.Spec))
+			}
+			if lines := append(deletions, insertions...); len(lines) > 0 {
+				fmt.Fprintf(&content, "*%s\n%s\nCOMMIT\n", table, strings.Join(lines, "\n"))
+			}
+		}
+		if err := restoreNoflush(content.String()); err != nil {
+			return err
+		}
+		if err := verifyAdopted(plan); err != nil {
+			return err
+		}
+	}
+
+	return peermeta.UpdateInterface(plan.Interface, func(m *peermeta.InterfaceMeta) {
+		for _, rule := range plan.Rules {
+			if rule.Recorded {
+				m.AddRule(rule.Rule)
+			}
+		}
+		for _, addr := range plan.Addresses {
+			m.AddAddress(addr)
+		}
+		m.Backend = plan.Backend
+		m.Adopted = time.Now().UTC()
+	})
+}
+
+// Function checks that each adopted rule holds its tag at its position.
+func verifyAdopted(plan Adoption) error {
+	saved, err := get.GetIptablesSave()
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range plan.Rules {
+		want := firewall.RuleTag(plan.Interface)
+		if !rule.Recorded {
+			want = firewall.RuleTag("")
+		}
+
+		got := "missing"
+		for _, table := range saved.Tables {
+			if table.Name != rule.Rule.Table {
+				continue
+			}
+			number := 0
+			for _, listed := range table.Rules {
+				if listed.Chain != rule.Rule.Chain {
+					continue
+				}
+				if number++; number == rule.Number {
+					got = strconv.Quote(listed.Comment)
+				}
+			}
+		}
+
+		if got != strconv.Quote(want) {
+			return &VerificationError{
+				Interface: plan.Interface,
+				Field:     fmt.Sprintf("the tag of %s rule %d", rule.Rule.Table+" "+rule.Rule.Chain, rule.Number),
+				Want:      strconv.Quote(want),
+				Got:       got,
+			}
+		}
+	}
+	return nil
+}
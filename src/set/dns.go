@@ -0,0 +1,126 @@
+package set
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/get"
+)
+
+// Function sets DNS servers on the network interface through the detected
+// DNS backend (see get.GetDNSBackend), in addition to the servers already
+// set by brgsetwg. The servers are recorded in the interface metadata, as
+// are the servers the interface had before, restored by
+// RemoveInterfaceDNS.
+//
+// Usage example:
+//
+//	err := set.SetInterfaceDNS("wg0", []string{"10.10.10.1"})
+func SetInterfaceDNS(interfaceName string, servers []string, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	backend, err := get.GetDNSBackend()
+	if err != nil {
+		return err
+	}
+
+	meta, err := peermeta.LoadInterface(interfaceName)
+	if err != nil {
+		return err
+	}
+
+	previous := meta.PreviousDNS
+	if len(meta.DNS) == 0 && backend == get.DNSBackendResolved {
+		current, err := get.GetInterfaceDNS(interfaceName)
+		if err != nil {
+			return err
+		}
+		previous = current.Servers
+	}
+
+	pushed := slices.Clone(meta.DNS)
+	for _, server := range servers {
+		if !slices.Contains(pushed, server) {
+			pushed = append(pushed, server)
+		}
+	}
+
+	if err := applyDNS(interfaceName, backend, pushed, previous); err != nil {
+		return err
+	}
+
+	return peermeta.UpdateInterface(interfaceName, func(m *peermeta.InterfaceMeta) {
+		m.DNS = pushed
+		m.PreviousDNS = previous
+	})
+}
+
+// Function removes DNS servers set by SetInterfaceDNS from the network
+// interface. When none is left, the DNS state of the interface before the
+// first SetInterfaceDNS is restored.
+func RemoveInterfaceDNS(interfaceName string, servers []string, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	meta, err := peermeta.LoadInterface(interfaceName)
+	if err != nil {
+		return err
+	}
+
+	for _, server := range servers {
+		if !slices.Contains(meta.DNS, server) {
+			return fmt.Errorf(
+				"error: DNS server '%s' was not set on network interface '%s'",
+				server, interfaceName,
+			)
+		}
+	}
+
+	backend, err := get.GetDNSBackend()
+	if err != nil {
+		return err
+	}
+
+	remaining := slices.DeleteFunc(slices.Clone(meta.DNS), func(s string) bool {
+		return slices.Contains(servers, s)
+	})
+	if err := applyDNS(interfaceName, backend, remaining, meta.PreviousDNS); err != nil {
+		return err
+	}
+
+	return peermeta.UpdateInterface(interfaceName, func(m *peermeta.InterfaceMeta) {
+		m.DNS = remaining
+		if len(remaining) == 0 {
+			m.PreviousDNS = nil
+		}
+	})
+}
+
+// Function applies the DNS servers set by brgsetwg to the network
+// interface, or restores the previous ones when servers is empty.
+func applyDNS(interfaceName, backend string, servers, previous []string) error {
+	var cmd string
+	switch {
+	case backend == get.DNSBackendResolvconf && len(servers) > 0:
+		cmd = shell.FormatCmdResolvconfAdd(interfaceName, servers)
+	case backend == get.DNSBackendResolvconf:
+		cmd = shell.FormatCmdResolvconfDelete(interfaceName)
+	case len(servers) > 0:
+		cmd = shell.FormatCmdResolvectlDNS(interfaceName, servers)
+	case len(previous) > 0:
+		cmd = shell.FormatCmdResolvectlDNS(interfaceName, previous)
+	default:
+		cmd = shell.FormatCmdResolvectlRevert(interfaceName)
+	}
+	return shell.DefaultRunner.Run(cmd, false)
+}
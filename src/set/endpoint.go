@@ -0,0 +1,220 @@
+package set
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/validate"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Resolver resolves the host names of the peer endpoints, *net.Resolver
+// implements it.
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// Resolver of the endpoints given by host name, replaced in tests.
+var DefaultResolver Resolver = net.DefaultResolver
+
+// Time given to the resolution of an endpoint host name.
+const ResolveTimeout = 10 * time.Second
+
+// Function parses an endpoint given by IP address (`89.89.89.1:51820`,
+// see validate.CheckEndPoint) or by host name (`vpn.example.com:51820`,
+// see validate.CheckEndpointHost). A host name is resolved with the
+// resolver, an IPv4 address preferred like wg(8), and returned as the
+// endpoint to remember in the peer metadata; it is empty for an IP address.
+//
+// Usage example:
+//
+//	addr, host, err := set.ResolveEndpoint(ctx, set.DefaultResolver, "vpn.example.com:51820")
+func ResolveEndpoint(ctx context.Context, resolver Resolver, endpoint string) (*net.UDPAddr, string, error) {
+	if !validate.IsHostEndpoint(endpoint) {
+		addr, err := validate.CheckEndPoint(endpoint)
+		return addr, "", err
+	}
+
+	host, port, err := validate.CheckEndpointHost(endpoint)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ResolveTimeout)
+	defer cancel()
+
+	addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, "", fmt.Errorf("error: failed to resolve endpoint '%s': %v", endpoint, err)
+	}
+	if len(addrs) == 0 {
+		return nil, "", fmt.Errorf("error: failed to resolve endpoint '%s': no address", endpoint)
+	}
+
+	addr := addrs[0]
+	if indx := slices.IndexFunc(addrs, netip.Addr.Is4); indx >= 0 {
+		addr = addrs[indx]
+	}
+
+	return &net.UDPAddr{IP: addr.Unmap().AsSlice(), Port: port}, endpoint, nil
+}
+
+// Function resolves the endpoint with DefaultResolver, see ResolveEndpoint.
+func resolveEndpoint(endpoint string) (*net.UDPAddr, string, error) {
+	return ResolveEndpoint(context.Background(), DefaultResolver, endpoint)
+}
+
+// EndpointUpdate is the re-resolution of the endpoint host name of a peer
+// by RefreshEndpoints.
+type EndpointUpdate struct {
+	// PublicKey of the peer (base64 encoded).
+	PublicKey string
+
+	// Host is the endpoint host name and port (e.g., "vpn.example.com:51820").
+	Host string
+
+	// Previous is the endpoint of the peer before the refresh, empty
+	// without one.
+	Previous string
+
+	// Current is the endpoint of the peer after the refresh.
+	Current string
+
+	// Err is the resolution or update failure, the endpoint of the peer is
+	// then left as is.
+	Err error
+}
+
+// Method reports whether the endpoint of the peer was rewritten.
+func (u EndpointUpdate) Changed() bool {
+	return u.Err == nil && u.Current != u.Previous
+}
+
+// Function re-resolves the endpoint host names recorded in the peer
+// metadata of the network interface and rewrites the endpoint of each peer
+// whose address changed (e.g., a dynamic DNS record). It returns one
+// update per peer of the device with a host name, in the order of the
+// device; see EndpointUpdate.Changed. A failed resolution leaves the
+// endpoint of the peer as is and is reported in its update only.
+//
+// Usage example:
+//
+//	updates, err := set.RefreshEndpoints(ctx, "wg0", set.DefaultResolver)
+//	if err != nil {
+//	    // Handle error
+//	}
+func RefreshEndpoints(ctx context.Context, interfaceName string, resolver Resolver, held ...*oplock.Lock) ([]EndpointUpdate, error) {
+	if interfaceName == "" {
+		return nil, fmt.Errorf("error: failed to get Wireguard network interface name")
+	}
+
+	store, err := peermeta.Load(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := handlers.InitWgCtlClient()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	device, err := client.Device(interfaceName)
+	if err != nil {
+		return nil, &get.DeviceError{Interface: interfaceName, Op: "read", Err: err}
+	}
+
+	// The names are resolved first, without holding the operation lock.
+	var updates []EndpointUpdate
+	var resolved []*net.UDPAddr
+	for _, peer := range device.Peers {
+		host := store[peer.PublicKey.String()].Endpoint
+		if host == "" {
+			continue
+		}
+
+		update := EndpointUpdate{PublicKey: peer.PublicKey.String(), Host: host}
+		if peer.Endpoint != nil {
+			update.Previous = peer.Endpoint.String()
+		}
+		update.Current = update.Previous
+
+		addr, _, err := ResolveEndpoint(ctx, resolver, host)
+		if err != nil {
+			update.Err = err
+		} else {
+			update.Current = addr.String()
+		}
+		updates = append(updates, update)
+		resolved = append(resolved, addr)
+	}
+
+	if !slices.ContainsFunc(updates, EndpointUpdate.Changed) {
+		return updates, nil
+	}
+
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return updates, err
+	}
+	defer lock.Release()
+
+	for indx := range updates {
+		update := &updates[indx]
+		if !update.Changed() {
+			continue
+		}
+
+		pubKey, _ := wgtypes.ParseKey(update.PublicKey)
+		err := client.ConfigureDevice(interfaceName, wgtypes.Config{
+			Peers: []wgtypes.PeerConfig{{
+				PublicKey:  pubKey,
+				UpdateOnly: true,
+				Endpoint:   resolved[indx],
+			}},
+		})
+		if err != nil {
+			update.Current = update.Previous
+			update.Err = fmt.Errorf(
+				"error: failed to update endpoint of peer '%s': %v",
+				update.PublicKey, err,
+			)
+		}
+	}
+
+	return updates, nil
+}
+
+// Function drops the whole metadata of a peer (name, note, expiry and
+// endpoint host name), e.g., once the peer is removed from a device not
+// configured through wgctrl.
+//
+// Usage example:
+//
+//	err := set.RemovePeerMeta("wg0", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+func RemovePeerMeta(interfaceName, publicKey string, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	if interfaceName == "" {
+		return fmt.Errorf("error: failed to get Wireguard network interface name")
+	}
+
+	pubKey, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+
+	return peermeta.Remove(interfaceName, pubKey.String())
+}
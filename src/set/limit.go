@@ -0,0 +1,182 @@
+package set
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"slices"
+
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/get"
+)
+
+// Function limits the bandwidth of a peer, identified by its allowed IPs,
+// to the rate in bits per second in each direction: the traffic sent to the
+// peer is shaped by an htb class, the traffic sent by the peer is policed
+// on ingress (see get.TrafficLimits).
+//
+// The qdiscs are installed once per interface. The function is idempotent:
+// an existing class of the peer is updated, and its filters are synced with
+// the allowed IPs.
+//
+// Usage example:
+//
+//	rate, _ := validate.CheckRate("10mbit")
+//	err := set.SetPeerLimit("wg0", []string{"10.0.0.2/32"}, rate)
+func SetPeerLimit(interfaceName string, allowedIPs []string, rate uint64, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	prefixes, err := limitPrefixes(allowedIPs)
+	if err != nil {
+		return err
+	}
+
+	limits, err := get.GetTrafficLimits(interfaceName)
+	if err != nil {
+		return err
+	}
+
+	var cmds []string
+
+	switch {
+	case limits.Installed():
+	case limits.RootHandle == "" || limits.RootHandle == "0:":
+		// No root qdisc, or the default one of the kernel.
+		cmds = append(cmds, shell.FormatCmdTcRootAdd(interfaceName, limits.RootKind != ""))
+	default:
+		return &get.ConflictError{Err: fmt.Errorf(
+			"error: network interface '%s' has a root qdisc '%s %s' not installed by brgnetuse",
+			interfaceName, limits.RootKind, limits.RootHandle,
+		)}
+	}
+	if !limits.Ingress {
+		cmds = append(cmds, shell.FormatCmdTcIngressAdd(interfaceName))
+	}
+
+	// Compare the rates as tc reports them, so an unchanged limit is not
+	// updated on every call.
+	reported := get.ReportedRate(rate)
+
+	class, exists := limits.PeerClass(allowedIPs)
+	switch {
+	case !exists:
+		class = get.TrafficClass{ClassID: limits.NextClassID()}
+		cmds = append(cmds, shell.FormatCmdTcClass("add", interfaceName, class.ClassID, rate))
+	case class.Rate != reported:
+		cmds = append(cmds, shell.FormatCmdTcClass("change", interfaceName, class.ClassID, rate))
+	}
+
+	// Filters of addresses no longer allowed are removed, as are the
+	// ingress filters when the policing rate changes.
+	installed := map[string][]string{}
+	for _, filter := range limits.ClassFilters(class.ClassID) {
+		stale := !slices.Contains(prefixes, filter.Prefix) ||
+			(filter.Parent == shell.TcIngressHandle && class.Rate != reported)
+		if stale {
+			cmds = append(cmds, shell.FormatCmdTcFilterDelete(
+				interfaceName, filter.Parent, filter.Protocol, filter.Prio, filter.Handle,
+			))
+			continue
+		}
+		installed[filter.Parent] = append(installed[filter.Parent], filter.Prefix)
+	}
+
+	for _, prefix := range prefixes {
+		protocol, prio := limitProtocol(prefix)
+		if !slices.Contains(installed[shell.TcRootHandle], prefix) {
+			cmds = append(cmds, shell.FormatCmdTcFilterEgress(interfaceName, protocol, prio, prefix, class.ClassID))
+		}
+		if !slices.Contains(installed[shell.TcIngressHandle], prefix) {
+			cmds = append(cmds, shell.FormatCmdTcFilterIngress(interfaceName, protocol, prio, prefix, class.ClassID, rate))
+		}
+	}
+
+	for _, cmd := range cmds {
+		if err := shell.DefaultRunner.Run(cmd, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function removes the bandwidth limit of a peer, identified by its allowed
+// IPs: its filters and class. The qdiscs are removed with the last class.
+// A peer without a limit is not an error.
+func RemovePeerLimit(interfaceName string, allowedIPs []string, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	limits, err := get.GetTrafficLimits(interfaceName)
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, errors.ErrUnsupported) {
+		// Without tc no limit can be installed.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	class, exists := limits.PeerClass(allowedIPs)
+	if !limits.Installed() || !exists {
+		return nil
+	}
+
+	var cmds []string
+	// Filters first, a class referenced by a filter cannot be deleted.
+	for _, filter := range limits.ClassFilters(class.ClassID) {
+		cmds = append(cmds, shell.FormatCmdTcFilterDelete(
+			interfaceName, filter.Parent, filter.Protocol, filter.Prio, filter.Handle,
+		))
+	}
+	cmds = append(cmds, shell.FormatCmdTcClassDelete(interfaceName, class.ClassID))
+
+	if len(limits.Classes) == 1 {
+		cmds = append(cmds, shell.FormatCmdTcQdiscDelete(interfaceName, "root"))
+		if limits.Ingress {
+			cmds = append(cmds, shell.FormatCmdTcQdiscDelete(interfaceName, "ingress"))
+		}
+	}
+
+	for _, cmd := range cmds {
+		if err := shell.DefaultRunner.Run(cmd, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function returns the allowed IPs as masked prefixes, the form reported
+// by get.GetTrafficLimits.
+func limitPrefixes(allowedIPs []string) ([]string, error) {
+	if len(allowedIPs) == 0 {
+		return nil, fmt.Errorf("error: the peer has no allowed IPs to limit")
+	}
+
+	prefixes := make([]string, 0, len(allowedIPs))
+	for _, ip := range allowedIPs {
+		prefix, err := netip.ParsePrefix(ip)
+		if err != nil {
+			return nil, fmt.Errorf("error: invalid allowed IP '%s'", ip)
+		}
+		if masked := prefix.Masked().String(); !slices.Contains(prefixes, masked) {
+			prefixes = append(prefixes, masked)
+		}
+	}
+	return prefixes, nil
+}
+
+// Function returns the tc protocol and filter priority of the prefix family.
+func limitProtocol(prefix string) (string, int) {
+	if netip.MustParsePrefix(prefix).Addr().Is4() {
+		return "ip", 1
+	}
+	return "ipv6", 2
+}
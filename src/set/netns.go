@@ -0,0 +1,121 @@
+package set
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/command"
+	"github.com/AlexKira/brgnetuse/src/get"
+)
+
+// Function moves the network interface into another network namespace,
+// given by name (`ip netns add`) or by the ID of a process in it (e.g., a
+// container). A WireGuard device keeps its UDP socket, and so the routing,
+// of the namespace it was created in.
+//
+// The kernel sets the moved link down and drops its addresses, so they are
+// configured again in the target namespace, in order: the link is brought
+// back up if it was up, then the addresses are re-added. The metadata of
+// the interface follows a namespace given by name (see
+// peermeta.NamespaceDir).
+//
+// Usage example:
+//
+//	err := set.MoveInterface("wg0", "blue")
+func MoveInterface(interfaceName, target string, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	if err := netns.CheckTarget(target); err != nil {
+		return err
+	}
+
+	exists, err := get.GetExistInterface(interfaceName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return &get.InterfaceNotFoundError{Name: interfaceName}
+	}
+
+	snapshot, err := get.GetIpShow(interfaceName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := shell.DefaultRunner.Output(shell.FormatCmdIpLinkNetns(interfaceName, target)); err != nil {
+		return moveError(interfaceName, target, err)
+	}
+
+	runner := shell.NamespaceRunner{Name: target, Runner: shell.DefaultRunner}
+	restored := func(err error) error {
+		return fmt.Errorf(
+			"error: network interface '%s' moved to network namespace '%s', but failed to configure it there: %v",
+			interfaceName, target, err,
+		)
+	}
+
+	for _, iface := range snapshot {
+		if slices.Contains(iface.Flags, "UP") {
+			if err := runner.Run(shell.FormatCmdIpLinkSet(interfaceName, shell.IpUp), false); err != nil {
+				return restored(err)
+			}
+		}
+
+		for _, addr := range iface.AddrInfo {
+			// Link-local addresses are regenerated by the kernel.
+			if addr.Scope == "link" {
+				continue
+			}
+			cidr := fmt.Sprintf("%s/%d", addr.Local, addr.Prefixlen)
+			if err := runner.Run(shell.FormatCmdIpAddrDev(interfaceName, cidr, shell.IpAdd), false); err != nil {
+				return restored(err)
+			}
+		}
+	}
+
+	if !netns.IsPid(target) {
+		return peermeta.MoveTo(interfaceName, peermeta.NamespaceDir(target))
+	}
+	return nil
+}
+
+// Function describes the failure of the `ip link set netns` command: a
+// missing permission and a name taken in the target namespace are told
+// apart.
+func moveError(interfaceName, target string, err error) error {
+	var cmdErr *command.CommandError
+	if errors.As(err, &cmdErr) {
+		switch {
+		case strings.Contains(cmdErr.Output, "Operation not permitted"):
+			return fmt.Errorf(
+				"error: permission denied to move network interface '%s' to network namespace '%s', "+
+					"run as root (CAP_NET_ADMIN in both namespaces)",
+				interfaceName, target,
+			)
+		case strings.Contains(cmdErr.Output, "immutable"):
+			return fmt.Errorf(
+				"error: network interface '%s' cannot change network namespace, its link type is bound to it",
+				interfaceName,
+			)
+		case strings.Contains(cmdErr.Output, "File exists"):
+			return &get.ConflictError{Err: fmt.Errorf(
+				"error: network namespace '%s' already has a network interface named '%s'",
+				target, interfaceName,
+			)}
+		}
+	}
+	return fmt.Errorf(
+		"error: failed to move network interface '%s' to network namespace '%s': %v",
+		interfaceName, target, err,
+	)
+}
@@ -0,0 +1,212 @@
+package set
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/get"
+)
+
+// Default paths of the files written by SaveRules and WriteRestoreUnit.
+const (
+	DefaultRulesPath   string = "/etc/iptables/brgnetuse.rules"
+	DefaultRestoreUnit string = "/etc/systemd/system/brgnetuse-restore.service"
+)
+
+// First line of the files written by this package. A file without it was
+// not written by brgnetuse and is only replaced with force.
+const generatedHeader string = "# Generated by brgnetuse, do not edit."
+
+// Function saves the iptables rules tagged by brgnetuse (see firewall.RuleTag)
+// to the file, in the iptables-save format. The chain policies are not
+// saved. A file not written by brgnetuse is never overwritten without force.
+//
+// Returns the number of the saved rules.
+//
+// Usage example:
+//
+//	count, err := set.SaveRules(set.DefaultRulesPath, false)
+//	if err != nil {
+//	    // Handle error
+//	}
+func SaveRules(path string, force bool, held ...*oplock.Lock) (int, error) {
+	lock, err := oplock.Enter("", held...)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Release()
+
+	saved, err := get.GetIptablesSave()
+	if err != nil {
+		return 0, err
+	}
+	tagged := saved.Tagged()
+
+	content := generatedHeader + "\n" + tagged.String()
+	if err := writeGenerated(path, content, 0o600, force); err != nil {
+		return 0, err
+	}
+	return tagged.CountRules(), nil
+}
+
+// Function restores the rules saved by SaveRules with
+// `iptables-restore --noflush`. The rules already present are skipped,
+// so restoring twice does not duplicate them.
+//
+// Returns the number of the restored rules.
+func RestoreRules(path string, held ...*oplock.Lock) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("error: failed to read rules file '%s': %w", path, err)
+	}
+	return RestoreRulesContent(content, path, held...)
+}
+
+// Function restores the rules of the content in `iptables-save` format,
+// see RestoreRules. The source names the content in the errors (e.g., the
+// path, "stdin").
+func RestoreRulesContent(content []byte, source string, held ...*oplock.Lock) (int, error) {
+	lock, err := oplock.Enter("", held...)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Release()
+
+	saved, err := get.ParseIptablesSave(string(content))
+	if err != nil {
+		return 0, fmt.Errorf("error: rules file '%s': %v", source, strings.TrimPrefix(err.Error(), "error: "))
+	}
+
+	current, err := get.GetIptablesSave()
+	if err != nil {
+		return 0, err
+	}
+	missing := saved.Missing(current)
+	if missing.CountRules() == 0 {
+		return 0, nil
+	}
+
+	if err := restoreNoflush(missing.String()); err != nil {
+		return 0, err
+	}
+	return missing.CountRules(), nil
+}
+
+// Function applies the content in iptables-restore format on top of the
+// current rules with `iptables-restore --noflush`, through a temporary
+// file. Each table is committed at once.
+func restoreNoflush(content string) error {
+	tmp, err := os.CreateTemp("", "brgnetuse-*.rules")
+	if err != nil {
+		return fmt.Errorf("error: failed to restore rules: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error: failed to restore rules: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error: failed to restore rules: %v", err)
+	}
+
+	return shell.DefaultRunner.Run(firewall.FormatCmdRestore(tmp.Name()), false)
+}
+
+// Function writes a systemd oneshot unit restoring the rules file at boot
+// with `<exe> -fr -restore <rulesPath>`. The unit still has to be enabled
+// (`systemctl enable <unit>`). A file not written by brgnetuse is never
+// overwritten without force.
+func WriteRestoreUnit(unitPath, rulesPath, exe string, force bool) error {
+	unit := strings.Join([]string{
+		generatedHeader,
+		"[Unit]",
+		"Description=Restore the brgnetuse iptables rules",
+		"Wants=network-pre.target",
+		"After=network-pre.target",
+		"Before=network.target",
+		"",
+		"[Service]",
+		"Type=oneshot",
+		"RemainAfterExit=yes",
+		fmt.Sprintf("ExecStart=%s -fr -restore %s", exe, rulesPath),
+		"",
+		"[Install]",
+		"WantedBy=multi-user.target",
+		"",
+	}, "\n")
+
+	return writeGenerated(unitPath, unit, 0o644, force)
+}
+
+// Function writes the file atomically, creating its directory. An existing
+// file not starting with generatedHeader is only replaced with force.
+func writeGenerated(path, content string, perm os.FileMode, force bool) error {
+	if !force {
+		generated, err := isGenerated(path)
+		if err != nil {
+			return err
+		}
+		if !generated {
+			return &get.ConflictError{Err: fmt.Errorf(
+				"error: file '%s' was not written by brgnetuse, use force to overwrite it", path,
+			)}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("error: failed to write '%s': %w", path, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error: failed to write '%s': %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error: failed to write '%s': %w", path, err)
+	}
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error: failed to write '%s': %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error: failed to write '%s': %w", path, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error: failed to write '%s': %w", path, err)
+	}
+	return nil
+}
+
+// Function reports whether the file is missing, empty or starts with
+// generatedHeader.
+func isGenerated(path string) (bool, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error: failed to access '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return false, fmt.Errorf("error: failed to read '%s': %w", path, err)
+		}
+		return true, nil
+	}
+	return scanner.Text() == generatedHeader, nil
+}
@@ -0,0 +1,239 @@
+package set
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/validate"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// PortRuleUpdate reports the changes made by MovePortRule and
+// UpdatePortWithFirewall.
+type PortRuleUpdate struct {
+	// OldPort is the port before the update, Port the requested one.
+	OldPort int
+	Port    int
+
+	// PortChanged reports whether the listen port was set.
+	PortChanged bool
+
+	// RuleAdded reports whether an INPUT rule of Port was added, false
+	// when the rules were already in place.
+	RuleAdded bool
+
+	// OldRuleRemoved reports whether the INPUT rules of OldPort were
+	// removed. Only the rules tagged by this tool are removed.
+	OldRuleRemoved bool
+
+	// Warnings lists the conditions which did not fail the update, e.g.,
+	// the rule of Port already in place before the move (WarnRuleExists).
+	Warnings []Warning
+}
+
+// Function moves the listen port of a network interface from oldPort to
+// port together with its INPUT rules (see firewall.FormatCmdPortIn), so the
+// handshakes are accepted during the whole change:
+//
+//  1. the rules of the new port are added, unless they are in place;
+//  2. the port is changed with setPort;
+//  3. the rules of the old port are removed, if tagged by this tool.
+//
+// Each rule keeps the input interface (-in) of the rule of the old port it
+// replaces; without a rule of the old port, the rule of any interface is
+// added. A failed step undoes the previous ones: the removed rules are
+// added again, the old port is set again and the added rules are removed,
+// so the interface keeps listening on an open port. The added rules are
+// kept when the old port cannot be set again. An unchanged port only
+// ensures its rules.
+//
+// Usage example:
+//
+//	update, err := set.MovePortRule(51820, 51900, func(port int) error {
+//	    // Set the listen port of the interface
+//	})
+func MovePortRule(oldPort, port int, setPort func(port int) error, held ...*oplock.Lock) (PortRuleUpdate, error) {
+	update := PortRuleUpdate{OldPort: oldPort, Port: port}
+
+	lock, err := oplock.EnterAny(held...)
+	if err != nil {
+		return update, err
+	}
+	defer lock.Release()
+
+	if port < 1 || port > 65535 {
+		return update, fmt.Errorf("error: port %d is out of valid range (1-65535)", port)
+	}
+
+	rules, err := get.GetIptablesFirewall()
+	if err != nil {
+		return update, err
+	}
+
+	var oldScopes []string
+	if oldPort != 0 {
+		oldScopes = portRuleScopes(rules, oldPort)
+	}
+	scopes := oldScopes
+	if len(scopes) == 0 {
+		scopes = []string{""}
+	}
+
+	dport := strconv.Itoa(port)
+	var added, kept []string
+	// Function removes the rules added for the new port.
+	undoRules := func() {
+		for _, in := range added {
+			shell.DefaultRunner.Run(firewall.FormatCmdPortIn(firewall.Delete, dport, in), false)
+		}
+	}
+
+	for _, in := range scopes {
+		if portRuleListed(rules, port, in) {
+			kept = append(kept, in)
+			continue
+		}
+		if err := shell.DefaultRunner.Run(firewall.FormatCmdPortIn(firewall.Append, dport, in), false); err != nil {
+			undoRules()
+			return PortRuleUpdate{OldPort: oldPort, Port: port}, err
+		}
+		added = append(added, in)
+	}
+	update.RuleAdded = len(added) > 0
+	if oldPort == port {
+		return update, nil
+	}
+
+	// A rule of the new port in place before the move is left as is, and
+	// is removed by no undo.
+	for _, in := range kept {
+		warning := Warning{
+			Code:    WarnRuleExists,
+			Message: fmt.Sprintf("the INPUT rule of port %d is already in place, kept", port),
+			Fields:  map[string]string{"port": dport},
+		}
+		if in != "" {
+			warning.Message = fmt.Sprintf("the INPUT rule of port %d on '%s' is already in place, kept", port, in)
+			warning.Fields["in"] = in
+		}
+		update.Warnings = append(update.Warnings, warning)
+	}
+
+	if err := setPort(port); err != nil {
+		// The port may have changed before setPort failed (e.g., set but
+		// not verified), the old port is set again before the rules of the
+		// new one are removed. They are kept when it cannot be.
+		if oldPort == 0 {
+			return update, fmt.Errorf("%w, the INPUT rule of port %d is kept", err, port)
+		}
+		if undoErr := setPort(oldPort); undoErr != nil {
+			return update, fmt.Errorf(
+				"%w, failed to restore port %d: %v, the INPUT rule of port %d is kept",
+				err, oldPort, undoErr, port,
+			)
+		}
+		undoRules()
+		return PortRuleUpdate{OldPort: oldPort, Port: port}, err
+	}
+	update.PortChanged = true
+
+	if len(oldScopes) == 0 {
+		return update, nil
+	}
+	old := strconv.Itoa(oldPort)
+	for i, in := range oldScopes {
+		err := shell.DefaultRunner.Run(firewall.FormatCmdPortIn(firewall.Delete, old, in), false)
+		if err == nil {
+			continue
+		}
+
+		for _, removed := range oldScopes[:i] {
+			shell.DefaultRunner.Run(firewall.FormatCmdPortIn(firewall.Append, old, removed), false)
+		}
+		if undoErr := setPort(oldPort); undoErr != nil {
+			return update, fmt.Errorf(
+				"error: failed to remove the rule of port %d: %v, and to restore the port: %v",
+				oldPort, err, undoErr,
+			)
+		}
+		undoRules()
+		return PortRuleUpdate{OldPort: oldPort, Port: port}, err
+	}
+	update.OldRuleRemoved = true
+
+	return update, nil
+}
+
+// Function updates the listening port of a WireGuard network interface
+// like EnsurePort, moving its INPUT rule along with it (see MovePortRule).
+// The current port is read from the device first; a port used elsewhere
+// is refused unless force is set. Port 0 (a random port) cannot be opened
+// in advance and is refused.
+//
+// Usage example:
+//
+//	update, err := set.UpdatePortWithFirewall("wg0", "51900", false)
+//	if err != nil {
+//	    // Handle error
+//	}
+//	if update.OldRuleRemoved {
+//	    // Port 51820 closed
+//	}
+func UpdatePortWithFirewall(interfaceName string, port string, force bool, held ...*oplock.Lock) (PortRuleUpdate, error) {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return PortRuleUpdate{}, err
+	}
+	defer lock.Release()
+
+	portInt, err := validate.CheckPort(port)
+	if err != nil {
+		return PortRuleUpdate{}, err
+	}
+	if portInt == 0 {
+		return PortRuleUpdate{}, fmt.Errorf("error: a random port (0) cannot be opened in the firewall")
+	}
+
+	newClient, err := handlers.InitWgCtlClient()
+	if err != nil {
+		return PortRuleUpdate{}, err
+	}
+	defer newClient.Close()
+
+	device, err := newClient.Device(interfaceName)
+	if err != nil {
+		return PortRuleUpdate{}, &get.DeviceError{Interface: interfaceName, Op: "read the listen port of", Err: err}
+	}
+
+	var inUse []Warning
+	if device.ListenPort != portInt {
+		if err := checkListenPort(newClient, interfaceName, portInt); err != nil {
+			if !force {
+				return PortRuleUpdate{OldPort: device.ListenPort, Port: portInt}, err
+			}
+			inUse = append(inUse, portInUseWarning(interfaceName, portInt, err))
+		}
+	}
+
+	update, err := MovePortRule(device.ListenPort, portInt, func(port int) error {
+		err := newClient.ConfigureDevice(interfaceName, wgtypes.Config{ListenPort: &port})
+		if err != nil {
+			return &get.DeviceError{Interface: interfaceName, Op: "update", Err: err}
+		}
+		return verifyPort(newClient, interfaceName, port)
+	}, lock)
+	if err != nil {
+		return update, err
+	}
+
+	for i := range update.Warnings {
+		update.Warnings[i].Interface = interfaceName
+	}
+	update.Warnings = append(inUse, update.Warnings...)
+	return update, nil
+}
@@ -0,0 +1,138 @@
+package set
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Function sets the transfer quota of a peer in the peer metadata of the
+// network interface: limit bytes (received and sent) per period, a period
+// of 0 never resets it. The usage starts again from 0, and a limit of 0
+// removes the quota.
+//
+// Usage example:
+//
+//	err := set.SetPeerQuota("wg0", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", 50_000_000_000, 30*24*time.Hour)
+//	if err != nil {
+//	    // Handle error
+//	}
+func SetPeerQuota(interfaceName, publicKey string, limit uint64, period time.Duration, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	if interfaceName == "" {
+		return fmt.Errorf("error: failed to get Wireguard network interface name")
+	}
+
+	pubKey, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+
+	return peermeta.Modify(interfaceName, pubKey.String(), func(m *peermeta.Meta) {
+		m.Quota = peermeta.Quota{Limit: limit, Period: int64(period / time.Second)}
+	})
+}
+
+// Function accumulates the transfer counters of the peers (see
+// peermeta.Quota.Sample) into the quotas of the network interface, and
+// returns the public keys of the peers over their quota, in a stable
+// order. The peers without a quota are ignored. It runs under the
+// operation lock, like the changes of the quotas (see SetPeerQuota).
+func SampleQuotas(interfaceName string, peers []get.PeerInfo, now time.Time, held ...*oplock.Lock) ([]string, error) {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	keys := make([]string, 0)
+	err = peermeta.Update(interfaceName, func(store peermeta.Store) bool {
+		changed := false
+		for _, peer := range peers {
+			meta, ok := store[peer.PublicKey]
+			if !ok || meta.Quota.IsZero() {
+				continue
+			}
+
+			meta.Quota.Sample(uint64(max(peer.ReceiveBytes, 0)), uint64(max(peer.TransmitBytes, 0)), now)
+			if meta.Quota.Over() {
+				keys = append(keys, peer.PublicKey)
+			}
+			store[peer.PublicKey] = meta
+			changed = true
+		}
+		return changed
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// Function samples the transfer counters of the peers of the WireGuard
+// network interface into their quotas (see SampleQuotas) and removes the
+// peers over their quota, using MultiPeerStructure.RemovePeer, with their
+// metadata and rate limit. Returns the removed public keys.
+//
+// Usage example:
+//
+//	removed, err := set.EnforceQuotas("wg0")
+//	if err != nil {
+//	    // Handle error
+//	}
+func EnforceQuotas(interfaceName string, held ...*oplock.Lock) ([]string, error) {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	if interfaceName == "" {
+		return nil, fmt.Errorf("error: failed to get Wireguard network interface name")
+	}
+
+	info, err := get.GetPeerInfo(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+	var peers []get.PeerInfo
+	for _, device := range info {
+		peers = append(peers, device.Peers...)
+	}
+
+	keys, err := SampleQuotas(interfaceName, peers, time.Now(), lock)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	removal := MultiPeerStructure{
+		InterfaceName: interfaceName,
+		PublicKey:     keys,
+	}
+	if err := removal.RemovePeer(lock); err != nil {
+		return nil, err
+	}
+
+	for _, peer := range peers {
+		if !slices.Contains(keys, peer.PublicKey) {
+			continue
+		}
+		if err := RemovePeerLimit(interfaceName, peer.AllowedIPs, lock); err != nil {
+			return keys, err
+		}
+	}
+
+	return keys, nil
+}
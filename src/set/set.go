@@ -1,25 +1,43 @@
 // Package provides a set of ready-made functions for working with
 // the Wireguard network.
+//
+// The functions changing the system state run under the operation lock
+// (see oplock). Each takes the lock on its own, unless its caller hands it
+// the lock it holds as the last argument (held), so a command makes all
+// its changes under a single hold.
 
 package set
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/validate"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 // Method generates and sets a new private key for the specified
 // WireGuard network interface.
 //
-// If the PrivateKey field in the UpdatePrivateKeyStructure is empty,
-// a new private key is generated.
+// If the PrivateKey and Secret fields in the UpdatePrivateKeyStructure are
+// empty, a new private key is generated.
 //
-// Otherwise, the provided PrivateKey (base64 encoded) is parsed and used.
+// Otherwise, the provided key (base64 encoded) is parsed and used.
 //
 // Returns:
 //   - nil if the private key was successfully updated.
@@ -37,92 +55,410 @@ import (
 //	if err != nil {
 //	    // Handle error
 //	}
-func UpdatePrivateKey(args UpdatePrivateKeyStructure) error {
+func UpdatePrivateKey(args UpdatePrivateKeyStructure, held ...*oplock.Lock) error {
+	_, err := EnsurePrivateKey(args, held...)
+	return err
+}
+
+// Function sets the private key like UpdatePrivateKey and reports whether
+// it changed: the public keys are compared first, and a provided key the
+// interface already has is not set again. A generated key is always set.
+//
+// Usage example:
+//
+//	changed, err := set.EnsurePrivateKey(args)
+//	if err != nil {
+//	    // Handle error
+//	}
+func EnsurePrivateKey(args UpdatePrivateKeyStructure, held ...*oplock.Lock) (bool, error) {
+	lock, err := oplock.Enter(args.InterfaceName, held...)
+	if err != nil {
+		return false, err
+	}
+	defer lock.Release()
 
 	if args.InterfaceName == "" {
-		return fmt.Errorf("error: failed to get Wireguard network interface name")
+		return false, fmt.Errorf("error: failed to get Wireguard network interface name")
 	}
 
 	var pvKey wgtypes.Key
+	defer clear(pvKey[:])
 
-	if args.PrivateKey == "" {
-		key, err := wgtypes.GeneratePrivateKey()
+	secret := args.privateKey()
+	if secret.IsEmpty() {
+		keys, err := get.GenerateKeyPair()
 		if err != nil {
-			return fmt.Errorf(
-				"error: %v",
-				err,
-			)
+			return false, err
 		}
-		pvKey = key
+		pvKey = keys.Private
+		keys.Zero()
 	} else {
-		key, err := wgtypes.ParseKey(args.PrivateKey)
+		key, err := handlers.CheckPrivateKey(secret)
 		if err != nil {
-			return fmt.Errorf(
-				"error: %v",
-				err,
-			)
+			return false, err
 		}
 		pvKey = key
 	}
 
 	newClient, err := handlers.InitWgCtlClient()
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer newClient.Close()
 
+	device, err := newClient.Device(args.InterfaceName)
+	if err == nil && !secret.IsEmpty() && device.PublicKey == pvKey.PublicKey() {
+		return false, nil
+	}
+
 	config := wgtypes.Config{}
 	config.PrivateKey = &pvKey
 
 	err = newClient.ConfigureDevice(args.InterfaceName, config)
 	if err != nil {
-		return fmt.Errorf(
-			"error: failed to update network interface '%s': %v",
-			args.InterfaceName,
-			err,
-		)
+		return false, &get.DeviceError{
+			Interface: args.InterfaceName,
+			Op:        "update",
+			Err:       errors.New(handlers.Redact(err.Error(), secret)),
+		}
 	}
-	return nil
+
+	if args.Verify {
+		if err := verifyPrivateKey(newClient, args.InterfaceName, pvKey); err != nil {
+			return true, err
+		}
+	}
+
+	if device != nil && len(device.Peers) > 0 {
+		public := pvKey.PublicKey().String()
+		args.Warn.warn(Warning{
+			Code: WarnPeersKeepOldKey,
+			Message: fmt.Sprintf(
+				"the %d peers of network interface '%s' keep its old public key until updated with '%s'",
+				len(device.Peers), args.InterfaceName, public,
+			),
+			Interface: args.InterfaceName,
+			Fields: map[string]string{
+				"public_key": public,
+				"peers":      strconv.Itoa(len(device.Peers)),
+			},
+		})
+	}
+	return true, nil
 }
 
 // Method updates the listening port for the specified WireGuard network interface.
+// A port used by another WireGuard interface or by another UDP socket is
+// refused (see CheckListenPort) unless force is set. The device is read
+// back after the update, a port it did not take fails with a
+// VerificationError.
 //
 // **Parameters:**
 //
 //	interfaceName: The name of the WireGuard network interface.
 //	port: The new listening port number (as a string).
+//	force: Update the port even if it is already in use.
 //
 // **Returns:**
 //
 //	nil if the port was successfully updated.
-//	an error if the port is invalid or the update failed
-func UpdatePort(interfaceName string, port string) error {
+//	an error if the port is invalid, in use or the update failed
+func UpdatePort(interfaceName string, port string, force bool, held ...*oplock.Lock) error {
+	_, err := EnsurePort(interfaceName, port, force, held...)
+	return err
+}
+
+// Function sets the listening port like UpdatePort and reports whether it
+// changed: the port the interface already listens on is not set again.
+// Port 0 (a random port) is always set.
+//
+// Usage example:
+//
+//	changed, err := set.EnsurePort("wg0", "51820", false)
+//	if err != nil {
+//	    // Handle error
+//	}
+func EnsurePort(interfaceName string, port string, force bool, held ...*oplock.Lock) (bool, error) {
+	return EnsurePortWarn(interfaceName, port, force, nil, held...)
+}
 
-	portInt, err := handlers.CheckPort(port)
+// Function sets the listening port like EnsurePort. A port in use set with
+// force is reported to warn (see WarnPortInUse), nil discards it.
+//
+// Usage example:
+//
+//	changed, err := set.EnsurePortWarn("wg0", "51820", true, func(w set.Warning) {
+//	    log.Println(w)
+//	})
+func EnsurePortWarn(interfaceName string, port string, force bool, warn WarnFunc, held ...*oplock.Lock) (bool, error) {
+	lock, err := oplock.Enter(interfaceName, held...)
 	if err != nil {
-		return err
+		return false, err
+	}
+	defer lock.Release()
+
+	portInt, err := validate.CheckPort(port)
+	if err != nil {
+		return false, err
+	}
+
+	newClient, err := handlers.InitWgCtlClient()
+	if err != nil {
+		return false, err
+	}
+	defer newClient.Close()
+
+	if portInt != 0 {
+		device, err := newClient.Device(interfaceName)
+		if err == nil && device.ListenPort == portInt {
+			return false, nil
+		}
+	}
+
+	if err := checkListenPort(newClient, interfaceName, portInt); err != nil {
+		if !force {
+			return false, err
+		}
+		warn.warn(portInUseWarning(interfaceName, portInt, err))
 	}
 
 	config := wgtypes.Config{}
 	config.ListenPort = &portInt
 
+	err = newClient.ConfigureDevice(interfaceName, config)
+	if err != nil {
+		return false, &get.DeviceError{Interface: interfaceName, Op: "update", Err: err}
+	}
+	return true, verifyPort(newClient, interfaceName, portInt)
+}
+
+// Function checks that the listening port is free for the network
+// interface: no other WireGuard interface known to wgctrl uses it and no
+// other UDP socket is bound to it. The current port of the interface and
+// port 0 (a random port) are always accepted.
+//
+// Usage example:
+//
+//	if err := set.CheckListenPort("wg0", 51820); err != nil {
+//	    // Handle port in use
+//	}
+func CheckListenPort(interfaceName string, port int) error {
 	newClient, err := handlers.InitWgCtlClient()
 	if err != nil {
 		return err
 	}
 	defer newClient.Close()
 
-	err = newClient.ConfigureDevice(interfaceName, config)
+	return checkListenPort(newClient, interfaceName, port)
+}
+
+// Function checks the listening port through an open wgctrl client.
+func checkListenPort(client handlers.WgClient, interfaceName string, port int) error {
+	if port == 0 {
+		return nil
+	}
+
+	devices, err := client.Devices()
 	if err != nil {
-		return fmt.Errorf(
-			"error: failed to update network interface '%s': %v",
-			interfaceName,
-			err,
-		)
+		return fmt.Errorf("error: failed to list WireGuard interfaces: %v", err)
+	}
+	for _, device := range devices {
+		if device.ListenPort != port {
+			continue
+		}
+		if device.Name == interfaceName {
+			return nil
+		}
+		return &get.ConflictError{Err: fmt.Errorf(
+			"error: port %d is already used by WireGuard interface '%s', use force to update it anyway",
+			port, device.Name,
+		)}
+	}
+
+	inUse, err := handlers.UDPPortInUse(port)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return &get.ConflictError{Err: fmt.Errorf(
+			"error: UDP port %d is already in use by another process, use force to update it anyway",
+			port,
+		)}
+	}
+	return nil
+}
+
+// Function returns the warning of a listen port in use set with force,
+// err being the refusal of checkListenPort.
+func portInUseWarning(interfaceName string, port int, err error) Warning {
+	return Warning{
+		Code:      WarnPortInUse,
+		Message:   strings.TrimPrefix(strings.TrimSuffix(err.Error(), ", use force to update it anyway"), "error: ") + ", updated with force",
+		Interface: interfaceName,
+		Fields:    map[string]string{"port": strconv.Itoa(port)},
+	}
+}
+
+// Function opens the UDP listen port with the INPUT rule of
+// firewall.FormatCmdPort, unless the tagged rule is already in place, and
+// reports whether it added the rule.
+//
+// Usage example:
+//
+//	added, err := set.EnsurePortRule(51820)
+//	if err != nil {
+//	    // Handle error
+//	}
+func EnsurePortRule(port int, held ...*oplock.Lock) (bool, error) {
+	lock, err := oplock.EnterAny(held...)
+	if err != nil {
+		return false, err
+	}
+	defer lock.Release()
+
+	if port < 1 || port > 65535 {
+		return false, fmt.Errorf("error: port %d is out of valid range (1-65535)", port)
+	}
+	dport := strconv.Itoa(port)
+
+	rules, err := get.GetIptablesFirewall()
+	if err != nil {
+		return false, err
+	}
+
+	if portRuleListed(rules, port, "") {
+		return false, nil
+	}
+
+	if err := shell.DefaultRunner.Run(firewall.FormatCmdPort(firewall.Append, dport), false); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Function reports whether the INPUT chain of the filter table holds the
+// rule of firewall.FormatCmdPortIn accepting the UDP port on the input
+// interface in (any when empty), tagged by this tool. A rule of another
+// scope does not count, see get.FilterIptablesOutput.GetPortRules.
+func portRuleListed(rules get.IptablesOutput, port int, in string) bool {
+	filter := get.FilterIptablesOutput{Rule: rules}
+	matching, err := filter.GetPortRules(strconv.Itoa(port), in)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(matching, func(rule get.IptablesRule) bool {
+		return rule.Comment == firewall.RuleTag("")
+	})
+}
+
+// Function returns the input interfaces of the tagged INPUT rules of the
+// UDP port, an empty one for the rule of any interface (see
+// firewall.FormatCmdPortIn).
+func portRuleScopes(rules get.IptablesOutput, port int) []string {
+	filter := get.FilterIptablesOutput{Rule: rules}
+	chain, err := filter.GetChain("INPUT")
+	if err != nil {
+		return nil
+	}
+
+	var scopes []string
+	dport := "dpt:" + strconv.Itoa(port)
+	for _, rule := range chain.Rules {
+		if rule.Target != "ACCEPT" || rule.Prot != "udp" || rule.Comment != firewall.RuleTag("") ||
+			!slices.Contains(strings.Fields(rule.Options), dport) {
+			continue
+		}
+		in := rule.In
+		if in == "*" {
+			in = ""
+		}
+		if !slices.Contains(scopes, in) {
+			scopes = append(scopes, in)
+		}
+	}
+	return scopes
+}
+
+// Method updates the firewall mark of the packets sent by the specified
+// WireGuard network interface, 0 clears it. The mark lets policy routing
+// rules tell the tunnel traffic apart (e.g., for a full tunnel).
+//
+// Usage example:
+//
+//	if err := set.UpdateFwMark("wg0", 51820); err != nil {
+//	    // Handle error
+//	}
+func UpdateFwMark(interfaceName string, mark uint32, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	newClient, err := handlers.InitWgCtlClient()
+	if err != nil {
+		return err
+	}
+	defer newClient.Close()
+
+	fwMark := int(mark)
+	err = newClient.ConfigureDevice(interfaceName, wgtypes.Config{FirewallMark: &fwMark})
+	if err != nil {
+		return &get.DeviceError{Interface: interfaceName, Op: "update", Err: err}
 	}
 	return nil
 }
 
+// Method validates the peer fields and returns the peer configuration
+// applied by AddPeer. It lets the userspace devices configured without
+// wgctrl (e.g., AmneziaWG over UAPI) share the validation.
+func (p *SinglePeerStructure) PeerConfig() (wgtypes.PeerConfig, error) {
+	if p.PublicKey == "" {
+		return wgtypes.PeerConfig{}, fmt.Errorf("error: failed to get public key for peer")
+	}
+
+	var endpoint *net.UDPAddr
+	var duration time.Duration
+
+	// Check and parse EndpointHost (optional), a host name is resolved.
+	if p.EndpointHost != "" {
+		host, _, err := resolveEndpoint(p.EndpointHost)
+		if err != nil {
+			return wgtypes.PeerConfig{}, err
+		}
+		endpoint = host
+	}
+
+	// Check and parse PersistentKeepaliveInterval (optional).
+	if p.PersistentKeepaliveInterval != "" {
+		tm, err := validate.CheckKeepalive(p.PersistentKeepaliveInterval)
+		if err != nil {
+			return wgtypes.PeerConfig{}, err
+		}
+		duration = tm
+	}
+
+	// Parse PublicKey (mandatory).
+	pubKey, err := wgtypes.ParseKey(p.PublicKey)
+	if err != nil {
+		return wgtypes.PeerConfig{}, fmt.Errorf("error: %v", err)
+	}
+
+	// Parse AllowedIPs (optional).
+	alwIps, err := validate.CheckAllowedIPs(p.AllowedIPs)
+	if err != nil {
+		return wgtypes.PeerConfig{}, err
+	}
+
+	return wgtypes.PeerConfig{
+		PublicKey:                   pubKey,
+		ReplaceAllowedIPs:           p.ReplaceAllowedIPs,
+		AllowedIPs:                  alwIps,
+		Endpoint:                    endpoint,
+		PersistentKeepaliveInterval: &duration,
+	}, nil
+}
+
 // Method adds or replaces the WireGuard peer configuration.
 //
 // **Parameters:**
@@ -135,6 +471,7 @@ func UpdatePort(interfaceName string, port string) error {
 //	An error if the configuration cannot be applied, such as:
 //	  - Invalid interface name.
 //	  - Invalid public key or AllowedIPs.
+//	  - AllowedIPs overlapping those of another peer (see AllowConflicts).
 //	  - Insufficient permissions to execute 'wg set'.
 //	  - Error executing 'wg set'.
 //
@@ -163,90 +500,180 @@ func UpdatePort(interfaceName string, port string) error {
 //	}
 //
 // ````
-func (p *SinglePeerStructure) AddPeer(replace bool) error {
-	if p.InterfaceName == "" {
-		return fmt.Errorf("error: failed to get Wireguard network interface name")
+func (p *SinglePeerStructure) AddPeer(replace bool, held ...*oplock.Lock) error {
+	_, err := p.addPeer(replace, held...)
+	return err
+}
+
+// Method adds the peer like AddPeer(false) and reports whether anything
+// changed: a peer already configured identically (allowed IPs, endpoint,
+// keepalive and the given metadata) is left as is.
+//
+// Usage example:
+//
+//	changed, err := cfg.EnsurePeer()
+//	if err != nil {
+//	    // Handle error
+//	}
+func (p *SinglePeerStructure) EnsurePeer(held ...*oplock.Lock) (bool, error) {
+	return p.addPeer(false, held...)
+}
+
+// Method applies the peer, see AddPeer. Without replace, nothing is
+// written when the peer is already configured identically.
+func (p *SinglePeerStructure) addPeer(replace bool, held ...*oplock.Lock) (bool, error) {
+	lock, err := oplock.Enter(p.InterfaceName, held...)
+	if err != nil {
+		return false, err
 	}
+	defer lock.Release()
 
-	if p.PublicKey == "" {
-		return fmt.Errorf("error: failed to get public key for peer")
+	if p.InterfaceName == "" {
+		return false, fmt.Errorf("error: failed to get Wireguard network interface name")
 	}
 
-	var endpoint *net.UDPAddr
-	var duration time.Duration
+	peer, err := p.PeerConfig()
+	if err != nil {
+		return false, err
+	}
+	pubKey := peer.PublicKey
 
-	// Check and parse EndpointHost (optional).
-	if p.EndpointHost != "" {
-		host, err := handlers.CheckEndPoint(p.EndpointHost)
+	// Parse Expires (optional).
+	var expires time.Time
+	if p.Expires != "" {
+		expires, err = validate.CheckExpiry(p.Expires)
 		if err != nil {
-			return err
+			return false, err
 		}
-		endpoint = host
 	}
 
-	// Check and parse PersistentKeepaliveInterval (optional).
-	if p.PersistentKeepaliveInterval != "" {
-
-		num, err := strconv.Atoi(p.PersistentKeepaliveInterval)
+	config := wgtypes.Config{
+		ReplacePeers: replace,
+		Peers:        []wgtypes.PeerConfig{peer},
+	}
 
-		if err != nil {
-			return fmt.Errorf(
-				"error: unable to get KeepAlive interval value %v",
-				err,
-			)
-		}
+	// Apply configuration.
+	newClient, err := handlers.InitWgCtlClient()
+	if err != nil {
+		return false, err
+	}
+	defer newClient.Close()
 
-		if num < 0 {
-			num = 0
+	if !replace {
+		device, err := newClient.Device(p.InterfaceName)
+		if err == nil && PeerConfigured(device, peer) && p.metaConfigured(pubKey.String(), expires) {
+			return false, nil
 		}
+	}
 
-		tm, err := time.ParseDuration(fmt.Sprintf("%ds", num))
-		if err != nil {
-			return fmt.Errorf("error: %v", err)
+	if !p.AllowConflicts {
+		if err := checkConflicts(newClient, p.InterfaceName, config); err != nil {
+			return false, err
 		}
-		duration = tm
 	}
+	warnDuplicateEndpoints(p.Warn, p.InterfaceName, config)
+	warnCoveredAllowedIPs(p.Warn, p.InterfaceName, config)
 
-	// Parse PublicKey (mandatory).
-	pubKey, err := wgtypes.ParseKey(p.PublicKey)
+	err = newClient.ConfigureDevice(p.InterfaceName, config)
 	if err != nil {
-		return fmt.Errorf("error: %v", err)
+		return false, &get.DeviceError{Interface: p.InterfaceName, Op: "update", Err: err}
+	}
+	if p.Verify {
+		if err := verifyPeers(newClient, p.InterfaceName, config.Peers); err != nil {
+			return true, err
+		}
 	}
 
-	// Parse AllowedIPs (optional).
-	alwIps, err := handlers.CheckAllowedIPs(p.AllowedIPs)
-	if err != nil {
-		return err
+	// Keep the peer metadata in line with the device.
+	if replace {
+		if err := peermeta.Retain(p.InterfaceName, pubKey.String()); err != nil {
+			return true, err
+		}
+	}
+	if p.Name != "" || p.Note != "" || !expires.IsZero() || p.EndpointHost != "" {
+		return true, peermeta.Modify(p.InterfaceName, pubKey.String(), func(m *peermeta.Meta) {
+			if p.Name != "" {
+				m.Name = p.Name
+			}
+			if p.Note != "" {
+				m.Note = p.Note
+			}
+			if !expires.IsZero() {
+				m.Expires = expires
+			}
+			if p.EndpointHost != "" {
+				m.Endpoint = endpointHost(p.EndpointHost)
+			}
+		})
 	}
 
-	config := wgtypes.Config{
-		ReplacePeers: replace,
-		Peers: []wgtypes.PeerConfig{
-			{
-				PublicKey:                   pubKey,
-				AllowedIPs:                  alwIps,
-				Endpoint:                    endpoint,
-				PersistentKeepaliveInterval: &duration,
-			},
-		},
+	return true, nil
+}
+
+// Method reports whether the peer metadata already holds the given name,
+// note, expiry and endpoint host name. The fields not given are not
+// compared.
+func (p *SinglePeerStructure) metaConfigured(pubKey string, expires time.Time) bool {
+	if p.Name == "" && p.Note == "" && expires.IsZero() && endpointHost(p.EndpointHost) == "" {
+		return true
 	}
 
-	// Apply configuration.
-	newClient, err := handlers.InitWgCtlClient()
+	store, err := peermeta.Load(p.InterfaceName)
 	if err != nil {
-		return err
+		return false
 	}
-	defer newClient.Close()
+	meta := store[pubKey]
+	return (p.Name == "" || meta.Name == p.Name) &&
+		(p.Note == "" || meta.Note == p.Note) &&
+		(expires.IsZero() || meta.Expires.Equal(expires)) &&
+		meta.Endpoint == endpointHost(p.EndpointHost)
+}
 
-	err = newClient.ConfigureDevice(p.InterfaceName, config)
-	if err != nil {
-		return fmt.Errorf(
-			"error: failed to update network interface '%s': %v",
-			p.InterfaceName, err,
-		)
+// Function returns the endpoint to remember in the peer metadata: the
+// endpoint given by host name, empty for an IP address.
+func endpointHost(endpoint string) string {
+	if validate.IsHostEndpoint(endpoint) {
+		return endpoint
 	}
+	return ""
+}
 
-	return nil
+// Function reports whether the device already has the peer configuration:
+// the peer exists with every allowed IP (exactly these with
+// ReplaceAllowedIPs), the endpoint and the keepalive interval, when given.
+//
+// Usage example:
+//
+//	if set.PeerConfigured(device, peerConfig) {
+//	    // Nothing to do
+//	}
+func PeerConfigured(device *wgtypes.Device, peer wgtypes.PeerConfig) bool {
+	for _, current := range device.Peers {
+		if current.PublicKey != peer.PublicKey {
+			continue
+		}
+
+		if peer.Endpoint != nil &&
+			(current.Endpoint == nil || current.Endpoint.String() != peer.Endpoint.String()) {
+			return false
+		}
+		if peer.PersistentKeepaliveInterval != nil &&
+			current.PersistentKeepaliveInterval != *peer.PersistentKeepaliveInterval {
+			return false
+		}
+
+		var have []string
+		for _, ipNet := range current.AllowedIPs {
+			have = append(have, ipNet.String())
+		}
+		for _, ipNet := range peer.AllowedIPs {
+			if !slices.Contains(have, ipNet.String()) {
+				return false
+			}
+		}
+		return !peer.ReplaceAllowedIPs || len(have) == len(peer.AllowedIPs)
+	}
+	return false
 }
 
 // Method removes a WireGuard peer from the configuration using the 'wg set' command.
@@ -275,7 +702,13 @@ func (p *SinglePeerStructure) AddPeer(replace bool) error {
 //	}
 //
 // ````
-func (p *SinglePeerStructure) RemovePeer() error {
+func (p *SinglePeerStructure) RemovePeer(held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(p.InterfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
 	if p.InterfaceName == "" {
 		return fmt.Errorf("error: failed to get Wireguard network interface name")
 	}
@@ -308,13 +741,15 @@ func (p *SinglePeerStructure) RemovePeer() error {
 
 	err = newClient.ConfigureDevice(p.InterfaceName, config)
 	if err != nil {
-		return fmt.Errorf(
-			"error: failed to update network interface '%s': %v",
-			p.InterfaceName, err,
-		)
+		return &get.DeviceError{Interface: p.InterfaceName, Op: "update", Err: err}
+	}
+	if p.Verify {
+		if err := verifyPeers(newClient, p.InterfaceName, config.Peers); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return peermeta.Remove(p.InterfaceName, pubKey.String())
 }
 
 // Method adds or replaces WireGuard peer configurations.
@@ -341,6 +776,7 @@ func (p *SinglePeerStructure) RemovePeer() error {
 //   - The method handles slice length discrepancies by using the minimum length of `AllowedIPs` and `PublicKey`.
 //   - The method creates new `wgtypes.PeerConfig` instances for each peer, ensuring configuration isolation.
 //   - The method applies peer configurations using the WireGuard client created by the `__init__()` function.
+//   - The peers are sent in batches of `BatchSize` peers (`DefaultPeerBatchSize` when 0), see configureBatches.
 //
 // **Usage examples:**
 //
@@ -384,54 +820,64 @@ func (p *SinglePeerStructure) RemovePeer() error {
 //	}
 //
 // ```
-func (p *MultiPeerStructure) AddPeer(replace bool) error {
+func (p *MultiPeerStructure) AddPeer(replace bool, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(p.InterfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
 	// Check interface name.
 	if p.InterfaceName == "" {
 		return fmt.Errorf("error: failed to get Wireguard network interface name")
 	}
 
+	batchSize := p.BatchSize
+	if batchSize == 0 {
+		batchSize = DefaultPeerBatchSize
+	}
+	if batchSize < 0 {
+		return fmt.Errorf("error: invalid peer batch size %d, expected a positive number", batchSize)
+	}
+
 	// Determine loop length.
 	lenght := min(len(p.AllowedIPs), len(p.PublicKey))
 
 	// Create slice for peer configurations.
 	peerConfig := make([]wgtypes.PeerConfig, 0, lenght)
 
+	// The keepalive intervals are parsed once per distinct value, the peers
+	// share the durations (wgctrl only reads them).
+	keepalives := map[string]*time.Duration{"": new(time.Duration)}
+
 	// Add peer configurations.
 	for i := 0; i < lenght; i++ {
 		peer := wgtypes.PeerConfig{}
 
-		// Parse EndpointHost (optional).
+		// Parse EndpointHost (optional), a host name is resolved.
 		if len(p.EndpointHost) > i && p.EndpointHost[i] != "" {
-			endpoint, err := handlers.CheckEndPoint(p.EndpointHost[i])
+			endpoint, _, err := resolveEndpoint(p.EndpointHost[i])
 			if err != nil {
 				return err
 			}
 			peer.Endpoint = endpoint
 		}
 
-		// Parse PersistentKeepaliveInterval (optional).
-		if len(p.PersistentKeepaliveInterval) > i && p.PersistentKeepaliveInterval[i] != "" {
-
-			num, err := strconv.Atoi(p.PersistentKeepaliveInterval[i])
-			if err != nil {
-				return fmt.Errorf(
-					"error: unable to get KeepAlive interval value %v",
-					err,
-				)
-			}
-			if num < 0 {
-				num = 0
-			}
-
-			duration, err := time.ParseDuration(fmt.Sprintf("%ds", num))
+		// Parse PersistentKeepaliveInterval (optional), 0 when not set.
+		keepalive := ""
+		if len(p.PersistentKeepaliveInterval) > i {
+			keepalive = p.PersistentKeepaliveInterval[i]
+		}
+		duration, ok := keepalives[keepalive]
+		if !ok {
+			value, err := validate.CheckKeepalive(keepalive)
 			if err != nil {
-				return fmt.Errorf("error: %v", err)
+				return err
 			}
-			peer.PersistentKeepaliveInterval = &duration
-		} else {
-			duration, _ := time.ParseDuration("0s")
-			peer.PersistentKeepaliveInterval = &duration
+			duration = &value
+			keepalives[keepalive] = duration
 		}
+		peer.PersistentKeepaliveInterval = duration
 
 		// Parse PublicKey (mandatory).
 		pubKey, err := wgtypes.ParseKey(p.PublicKey[i])
@@ -441,7 +887,7 @@ func (p *MultiPeerStructure) AddPeer(replace bool) error {
 		peer.PublicKey = pubKey
 
 		// Parse AllowedIPs (mandatory).
-		alwIps, err := handlers.CheckAllowedIPs(p.AllowedIPs[i])
+		alwIps, err := validate.CheckAllowedIPs(p.AllowedIPs[i])
 		if err != nil {
 			return err
 		}
@@ -462,18 +908,82 @@ func (p *MultiPeerStructure) AddPeer(replace bool) error {
 		ReplacePeers: replace,
 		Peers:        peerConfig,
 	}
-	err = newClient.ConfigureDevice(p.InterfaceName, config)
-	if err != nil {
-		return fmt.Errorf(
-			"error: failed to update network interface '%s': %v",
-			p.InterfaceName,
-			err,
-		)
+	if !p.AllowConflicts {
+		if err := checkConflicts(newClient, p.InterfaceName, config); err != nil {
+			return err
+		}
+	}
+	warnDuplicateEndpoints(p.Warn, p.InterfaceName, config)
+	warnCoveredAllowedIPs(p.Warn, p.InterfaceName, config)
+	if err := configureBatches(newClient, p.InterfaceName, config, batchSize); err != nil {
+		return err
+	}
+	if p.Verify {
+		if err := verifyPeers(newClient, p.InterfaceName, config.Peers); err != nil {
+			return err
+		}
+	}
+
+	// Keep the peer metadata in line with the device.
+	if replace {
+		keys := make([]string, 0, len(peerConfig))
+		for _, peer := range peerConfig {
+			keys = append(keys, peer.PublicKey.String())
+		}
+		if err := peermeta.Retain(p.InterfaceName, keys...); err != nil {
+			return err
+		}
+	}
+	for indx, peer := range peerConfig {
+		if indx < len(p.Name) && p.Name[indx] != "" {
+			err := SetPeerMeta(p.InterfaceName, peer.PublicKey.String(), p.Name[indx], "", lock)
+			if err != nil {
+				return err
+			}
+		}
+		if indx < len(p.EndpointHost) && p.EndpointHost[indx] != "" {
+			host := endpointHost(p.EndpointHost[indx])
+			err := peermeta.Modify(p.InterfaceName, peer.PublicKey.String(), func(m *peermeta.Meta) {
+				m.Endpoint = host
+			})
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
+// Function applies the peers of the configuration in batches of batchSize
+// peers, one ConfigureDevice call each: a single message for thousands of
+// peers can exceed the netlink buffer of some kernels. The peers of the
+// device are replaced by the first batch only (ReplacePeers), the next
+// batches add to it, so the device ends up as with a single call. A
+// failed batch leaves the previous ones applied, which the error tells.
+func configureBatches(client handlers.WgClient, interfaceName string, config wgtypes.Config, batchSize int) error {
+	peers := config.Peers
+	for start := 0; start == 0 || start < len(peers); start += batchSize {
+		end := min(start+batchSize, len(peers))
+
+		batch := wgtypes.Config{
+			ReplacePeers: config.ReplacePeers && start == 0,
+			Peers:        peers[start:end],
+		}
+		if err := client.ConfigureDevice(interfaceName, batch); err != nil {
+			if start > 0 {
+				return &get.DeviceError{
+					Interface: interfaceName,
+					Op:        "update",
+					Err:       fmt.Errorf("after %d of %d peers: %w", start, len(peers), err),
+				}
+			}
+			return &get.DeviceError{Interface: interfaceName, Op: "update", Err: err}
+		}
+	}
+	return nil
+}
+
 // Method removes multiple WireGuard peers from the configuration.
 //
 // **Returns:**
@@ -498,7 +1008,13 @@ func (p *MultiPeerStructure) AddPeer(replace bool) error {
 //	}
 //
 // ```
-func (p *MultiPeerStructure) RemovePeer() error {
+func (p *MultiPeerStructure) RemovePeer(held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(p.InterfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
 	// Check interface name.
 	if p.InterfaceName == "" {
 		return fmt.Errorf("error: failed to get Wireguard network interface name")
@@ -537,11 +1053,707 @@ func (p *MultiPeerStructure) RemovePeer() error {
 	config := wgtypes.Config{Peers: peerConfig}
 	err = newClient.ConfigureDevice(p.InterfaceName, config)
 	if err != nil {
-		return fmt.Errorf(
-			"error: failed to update network interface '%s': %v",
-			p.InterfaceName, err,
+		return &get.DeviceError{Interface: p.InterfaceName, Op: "update", Err: err}
+	}
+	if p.Verify {
+		if err := verifyPeers(newClient, p.InterfaceName, config.Peers); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(peerConfig))
+	for _, peer := range peerConfig {
+		keys = append(keys, peer.PublicKey.String())
+	}
+
+	return peermeta.Remove(p.InterfaceName, keys...)
+}
+
+// Function sets the name and note of a WireGuard peer in the peer metadata
+// of the network interface (see internal/peermeta for the file location).
+// The peer expiry is kept. Metadata left empty is removed.
+//
+// Usage example:
+//
+//	err := set.SetPeerMeta("wg0", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "alice-laptop", "")
+//	if err != nil {
+//	    // Handle error
+//	}
+func SetPeerMeta(interfaceName, publicKey, name, note string, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	if interfaceName == "" {
+		return fmt.Errorf("error: failed to get Wireguard network interface name")
+	}
+
+	pubKey, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+
+	return peermeta.Modify(interfaceName, pubKey.String(), func(m *peermeta.Meta) {
+		m.Name = name
+		m.Note = note
+	})
+}
+
+// Function sets the expiry of a WireGuard peer in the peer metadata of the
+// network interface. The expiry is RFC3339 or YYYY-MM-DD (midnight UTC),
+// an empty value clears it.
+//
+// Usage example:
+//
+//	err := set.SetPeerExpiry("wg0", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "2025-07-01")
+//	if err != nil {
+//	    // Handle error
+//	}
+func SetPeerExpiry(interfaceName, publicKey, expires string, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	if interfaceName == "" {
+		return fmt.Errorf("error: failed to get Wireguard network interface name")
+	}
+
+	pubKey, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+
+	var expiry time.Time
+	if expires != "" {
+		expiry, err = validate.CheckExpiry(expires)
+		if err != nil {
+			return err
+		}
+	}
+
+	return peermeta.Modify(interfaceName, pubKey.String(), func(m *peermeta.Meta) {
+		m.Expires = expiry
+	})
+}
+
+// Function returns the public keys of the peers of the network interface
+// whose expiry has passed at the given moment, in a stable order.
+func ExpiredPeers(interfaceName string, now time.Time) ([]string, error) {
+	store, err := peermeta.Load(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0)
+	for key, meta := range store {
+		if meta.Expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	return keys, nil
+}
+
+// Function removes every peer of the network interface whose expiry has
+// passed, using MultiPeerStructure.RemovePeer, and returns the removed
+// public keys.
+//
+// The operation is idempotent: removing a peer that is already gone is not
+// an error, and the metadata of the removed peers is dropped under the
+// metadata lock, so it is safe to run concurrently with other invocations.
+//
+// Usage example:
+//
+//	removed, err := set.PruneExpiredPeers("wg0")
+//	if err != nil {
+//	    // Handle error
+//	}
+func PruneExpiredPeers(interfaceName string, held ...*oplock.Lock) ([]string, error) {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	if interfaceName == "" {
+		return nil, fmt.Errorf("error: failed to get Wireguard network interface name")
+	}
+
+	keys, err := ExpiredPeers(interfaceName, time.Now())
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	// The allowed IPs identify the rate limits of the peers, read them
+	// before the peers are gone.
+	info, err := get.GetPeerInfo(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := MultiPeerStructure{
+		InterfaceName: interfaceName,
+		PublicKey:     keys,
+	}
+	if err := peers.RemovePeer(lock); err != nil {
+		return nil, err
+	}
+
+	for _, device := range info {
+		for _, peer := range device.Peers {
+			if !slices.Contains(keys, peer.PublicKey) {
+				continue
+			}
+			if err := RemovePeerLimit(interfaceName, peer.AllowedIPs, lock); err != nil {
+				return keys, err
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// Function rotates the private key of the WireGuard network interface.
+//
+// The current device is read first, a new key is generated and applied via
+// UpdatePrivateKey, and the device is read back to confirm the change. The
+// rotation is recorded in the interface metadata. The operation refuses to
+// proceed when the device cannot be read.
+//
+// Returns the old and the new public keys, the new public key has to be
+// distributed to the peers.
+//
+// Usage example:
+//
+//	oldKey, newKey, err := set.RotatePrivateKey("wg0")
+//	if err != nil {
+//	    // Handle error
+//	}
+//	fmt.Println(oldKey, "->", newKey)
+func RotatePrivateKey(interfaceName string, held ...*oplock.Lock) (old, new wgtypes.Key, err error) {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return old, new, err
+	}
+	defer lock.Release()
+
+	if interfaceName == "" {
+		return old, new, fmt.Errorf("error: failed to get Wireguard network interface name")
+	}
+
+	before, err := readDevice(interfaceName)
+	if err != nil {
+		return old, new, err
+	}
+	old = before.PublicKey
+
+	keys, err := get.GenerateKeyPair()
+	if err != nil {
+		return old, new, err
+	}
+	key := keys.Private
+	keys.Zero()
+
+	secret := handlers.NewSecretKey(key)
+	defer secret.Zero()
+	defer clear(key[:])
+
+	err = UpdatePrivateKey(UpdatePrivateKeyStructure{
+		InterfaceName: interfaceName,
+		Secret:        secret,
+	}, lock)
+	if err != nil {
+		return old, new, err
+	}
+
+	after, err := readDevice(interfaceName)
+	if err != nil {
+		return old, new, err
+	}
+	if after.PublicKey != key.PublicKey() {
+		return old, new, fmt.Errorf(
+			"error: failed to confirm the new private key of network interface '%s'",
+			interfaceName,
 		)
 	}
+	new = after.PublicKey
+
+	return old, new, RecordKeyRotation(interfaceName, old, new, lock)
+}
+
+// Function records a private key rotation of the network interface in
+// the interface metadata (see internal/peermeta for the file location).
+func RecordKeyRotation(interfaceName string, old, new wgtypes.Key, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
 
+	return peermeta.UpdateInterface(interfaceName, func(m *peermeta.InterfaceMeta) {
+		m.KeyRotated = time.Now().UTC()
+		m.PublicKey = new.String()
+		m.PreviousPublicKey = old.String()
+	})
+}
+
+// Function returns the FORWARD rules added by firewall.FormatCmdForward,
+// as recorded in the interface metadata.
+func ForwardRules(osIface, wgIface string) []peermeta.Rule {
+	return []peermeta.Rule{
+		ForwardRule(osIface, wgIface, wgIface),
+		ForwardRule(wgIface, osIface, wgIface),
+	}
+}
+
+// Function returns a FORWARD ACCEPT rule between two network interfaces,
+// tagged with the owner (see firewall.RuleTag).
+func ForwardRule(inIface, outIface, owner string) peermeta.Rule {
+	return peermeta.Rule{
+		Table: "filter",
+		Chain: "FORWARD",
+		Spec:  fmt.Sprintf("-i %s -o %s %s -j ACCEPT", inIface, outIface, firewall.FormatRuleComment(owner)),
+	}
+}
+
+// Function returns the POSTROUTING rule added by firewall.FormatCmdNat,
+// as recorded in the interface metadata.
+func NATRule(osIface, subnet, wgIface string) peermeta.Rule {
+	return peermeta.Rule{
+		Table: "nat",
+		Chain: "POSTROUTING",
+		Spec:  fmt.Sprintf("-s %s -o %s %s -j MASQUERADE", subnet, osIface, firewall.FormatRuleComment(wgIface)),
+	}
+}
+
+// Function returns the POSTROUTING rule added by firewall.FormatCmdHairpin,
+// as recorded in the interface metadata.
+func HairpinRule(subnet, wgIface string) peermeta.Rule {
+	return peermeta.Rule{
+		Table: "nat",
+		Chain: "POSTROUTING",
+		Spec: fmt.Sprintf(
+			"-s %s -d %s -o %s %s -j MASQUERADE",
+			subnet, subnet, wgIface, firewall.FormatRuleComment(wgIface),
+		),
+	}
+}
+
+// Function returns the rule without its comment match, the form of the
+// rules added before the rules were tagged (see firewall.RuleTag).
+func UntaggedRule(rule peermeta.Rule) peermeta.Rule {
+	before, after, ok := strings.Cut(rule.Spec, " -m comment --comment ")
+	if !ok {
+		return rule
+	}
+	// The tag holds no spaces, see firewall.RuleTag.
+	_, rest, _ := strings.Cut(after, " ")
+	rule.Spec = before + " " + rest
+	return rule
+}
+
+// Function records rules and addresses applied for the network interface in
+// the interface metadata, so they can be removed by CleanupInterface.
+func RecordApplied(interfaceName string, rules []peermeta.Rule, addrs []string, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	return peermeta.UpdateInterface(interfaceName, func(m *peermeta.InterfaceMeta) {
+		for _, rule := range rules {
+			m.AddRule(rule)
+		}
+		for _, addr := range addrs {
+			m.AddAddress(addr)
+		}
+	})
+}
+
+// Function forgets rules and addresses removed from the network interface.
+func ForgetApplied(interfaceName string, rules []peermeta.Rule, addrs []string, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	return peermeta.UpdateInterface(interfaceName, func(m *peermeta.InterfaceMeta) {
+		for _, rule := range rules {
+			m.RemoveRule(rule)
+		}
+		for _, addr := range addrs {
+			m.RemoveAddress(addr)
+		}
+	})
+}
+
+// Function removes the rules and addresses recorded for the network
+// interface (see RecordApplied), e.g. when its userspace device shuts down.
+//
+// Every removal is attempted: the failed ones are returned and stay
+// recorded, the others are forgotten. Addresses are only removed while the
+// link exists, a deleted link takes its addresses with it.
+//
+// Usage example:
+//
+//	for _, err := range set.CleanupInterface("wg0") {
+//	    log.Println(err)
+//	}
+func CleanupInterface(interfaceName string, held ...*oplock.Lock) []error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return []error{err}
+	}
+	defer lock.Release()
+
+	meta, err := peermeta.LoadInterface(interfaceName)
+	if err != nil {
+		return []error{err}
+	}
+	if len(meta.Rules) == 0 && len(meta.Addresses) == 0 {
+		return nil
+	}
+
+	var errs []error
+	var rules []peermeta.Rule
+	var addrs []string
+
+	for _, rule := range meta.Rules {
+		cmd := firewall.FormatCmdDelete(rule.Table, rule.Chain, rule.Spec)
+		if err := shell.DefaultRunner.Run(cmd, false); err != nil {
+			errs = append(errs, fmt.Errorf(
+				"error: failed to remove %s %s rule '%s': %v",
+				rule.Table, rule.Chain, rule.Spec, err,
+			))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	linkErr := netns.Do(func() error {
+		_, err := net.InterfaceByName(interfaceName)
+		return err
+	})
+	for _, addr := range meta.Addresses {
+		if linkErr == nil {
+			cmd := shell.FormatCmdIpAddrDev(interfaceName, addr, shell.IpDel)
+			if err := shell.DefaultRunner.Run(cmd, false); err != nil {
+				errs = append(errs, fmt.Errorf("error: failed to remove address '%s': %v", addr, err))
+				continue
+			}
+		}
+		addrs = append(addrs, addr)
+	}
+
+	if err := ForgetApplied(interfaceName, rules, addrs, lock); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Function removes an existing WireGuard or AmneziaWG device so it can be
+// recreated: the userspace process serving it is terminated and given up
+// to wait to remove its TUN device, then the link is deleted if it is
+// still present.
+//
+// Usage example:
+//
+//	owner, _ := get.GetInterfaceOwner("wg0")
+//	if owner.Managed() {
+//	    err := set.RemoveInterface(owner, 3*time.Second)
+//	}
+func RemoveInterface(owner get.InterfaceOwner, wait time.Duration, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(owner.Name, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	if !owner.Managed() {
+		return &get.ConflictError{Err: fmt.Errorf(
+			"error: network interface '%s' is a %s, not managed by brgnetuse",
+			owner.Name, owner.Describe(),
+		)}
+	}
+
+	if owner.Pid != 0 {
+		if err := shell.DefaultRunner.Run(shell.FormatCmdKill(owner.Pid), false); err != nil {
+			return fmt.Errorf("error: failed to stop process %d: %v", owner.Pid, err)
+		}
+
+		deadline := time.Now().Add(wait)
+		for {
+			exists, err := get.GetExistInterface(owner.Name)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	return shell.DefaultRunner.Run(shell.FormatCmdIpLinkDelete(owner.Name), false)
+}
+
+// Function sets the alias (description) of the network interface, shown by
+// `ip link` and monitoring tools. Newlines are stripped, an empty alias
+// clears it.
+//
+// Usage example:
+//
+//	err := set.SetInterfaceAlias("wg0", "office vpn")
+func SetInterfaceAlias(interfaceName, alias string, held ...*oplock.Lock) error {
+	lock, err := oplock.Enter(interfaceName, held...)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	alias, err = validate.CheckAlias(alias)
+	if err != nil {
+		return err
+	}
+
+	if err := shell.DefaultRunner.Run(shell.FormatCmdIpLinkAlias(interfaceName, alias), false); err != nil {
+		return fmt.Errorf("error: failed to set alias of network interface '%s': %v", interfaceName, err)
+	}
+	return nil
+}
+
+// Function writes the private key (base64 encoded) to a file readable by
+// the owner only (0600). The file is replaced atomically.
+func WritePrivateKey(path string, key wgtypes.Key) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error: failed to write private key: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error: failed to write private key: %w", err)
+	}
+	secret := handlers.NewSecretKey(key)
+	defer secret.Zero()
+
+	// The key and the newline are written apart, appending the newline
+	// could copy the key to a buffer never zeroed.
+	if _, err := tmp.Write(secret.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error: failed to write private key: %w", err)
+	}
+	if _, err := tmp.Write([]byte{'\n'}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error: failed to write private key: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error: failed to write private key: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error: failed to write private key: %w", err)
+	}
+	return nil
+}
+
+// Function reads the WireGuard device of the network interface.
+func readDevice(interfaceName string) (*wgtypes.Device, error) {
+	newClient, err := handlers.InitWgCtlClient()
+	if err != nil {
+		return nil, err
+	}
+	defer newClient.Close()
+
+	device, err := newClient.Device(interfaceName)
+	if err != nil {
+		return nil, &get.DeviceError{Interface: interfaceName, Op: "read", Err: err}
+	}
+	return device, nil
+}
+
+// Function checks that the allowed IPs of the configured peers overlap no
+// allowed IP of another peer, either already on the device or configured
+// with them: WireGuard would silently move the prefix to the last peer.
+// The peers of the device are ignored when the configuration replaces
+// them, as are the allowed IPs a peer replaces.
+func checkConflicts(client handlers.WgClient, interfaceName string, config wgtypes.Config) error {
+	var device *wgtypes.Device
+	if !config.ReplacePeers {
+		var err error
+		device, err = client.Device(interfaceName)
+		if err != nil {
+			return &get.DeviceError{Interface: interfaceName, Op: "read", Err: err}
+		}
+	}
+
+	size := len(config.Peers)
+	if device != nil {
+		size += len(device.Peers)
+	}
+	taken := newPrefixOwners(size)
+
+	if device != nil {
+		replaced := make(map[wgtypes.Key]bool)
+		for _, peer := range config.Peers {
+			if peer.ReplaceAllowedIPs {
+				replaced[peer.PublicKey] = true
+			}
+		}
+		for _, peer := range device.Peers {
+			if replaced[peer.PublicKey] {
+				continue
+			}
+			for _, prefix := range peer.AllowedIPs {
+				taken.add(peer.PublicKey, prefix)
+			}
+		}
+	}
+
+	for _, peer := range config.Peers {
+		for _, prefix := range peer.AllowedIPs {
+			if other, ok := taken.conflict(peer.PublicKey, prefix); ok {
+				return &get.ConflictError{Err: fmt.Errorf(
+					"error: allowed IP '%s' of peer '%s' conflicts with '%s' of peer '%s', use force to add it anyway",
+					prefix.String(), peer.PublicKey, other.prefix, other.key,
+				)}
+			}
+		}
+		for _, prefix := range peer.AllowedIPs {
+			taken.add(peer.PublicKey, prefix)
+		}
+	}
 	return nil
 }
+
+// Allowed IP of a peer, see prefixOwners.
+type ownedPrefix struct {
+	key    wgtypes.Key
+	prefix netip.Prefix
+}
+
+// prefixOwners indexes the allowed IPs of peers by prefix, so checking the
+// allowed IPs of thousands of peers does not compare every pair: a prefix
+// overlaps the prefixes containing it, found by a lookup per prefix
+// length, and those it contains, scanned only when a longer prefix of the
+// family was added (e.g., a /16 against the /32 of the peers). A prefix
+// keeps its first owner: a second one is a conflict reported before it is
+// added, WireGuard itself never routes a prefix to two peers.
+type prefixOwners struct {
+	byPrefix map[netip.Prefix]ownedPrefix
+	all      []ownedPrefix
+	longest  map[int]int
+}
+
+// Function creates an empty index sized for about size allowed IPs.
+func newPrefixOwners(size int) *prefixOwners {
+	return &prefixOwners{
+		byPrefix: make(map[netip.Prefix]ownedPrefix, size),
+		all:      make([]ownedPrefix, 0, size),
+		longest:  make(map[int]int),
+	}
+}
+
+// Method adds an allowed IP of a peer. Malformed networks are ignored,
+// like validate.PrefixesOverlap does.
+func (o *prefixOwners) add(key wgtypes.Key, network net.IPNet) {
+	prefix, ok := validate.IPNetPrefix(network)
+	if !ok {
+		return
+	}
+
+	owned := ownedPrefix{key: key, prefix: prefix}
+	if _, ok := o.byPrefix[prefix]; !ok {
+		o.byPrefix[prefix] = owned
+	}
+	o.all = append(o.all, owned)
+	o.longest[prefix.Addr().BitLen()] = max(o.longest[prefix.Addr().BitLen()], prefix.Bits())
+}
+
+// Method returns an allowed IP of another peer overlapping the network.
+func (o *prefixOwners) conflict(key wgtypes.Key, network net.IPNet) (ownedPrefix, bool) {
+	prefix, ok := validate.IPNetPrefix(network)
+	if !ok {
+		return ownedPrefix{}, false
+	}
+
+	for bits := 0; bits <= prefix.Bits(); bits++ {
+		outer, _ := prefix.Addr().Prefix(bits)
+		if other, ok := o.byPrefix[outer]; ok && other.key != key {
+			return other, true
+		}
+	}
+
+	if prefix.Bits() < o.longest[prefix.Addr().BitLen()] {
+		for _, other := range o.all {
+			if other.key != key && other.prefix.Bits() > prefix.Bits() && prefix.Contains(other.prefix.Addr()) {
+				return other, true
+			}
+		}
+	}
+	return ownedPrefix{}, false
+}
+
+// Function warns about the configured peers whose endpoint is already used
+// by another peer, of the device or of the configuration, usually a
+// copy-paste error. The device is read with get.GetPeer; when it cannot be
+// read (e.g., during the initial provisioning) only the configuration is
+// checked.
+func warnDuplicateEndpoints(warn WarnFunc, interfaceName string, config wgtypes.Config) {
+	if warn == nil {
+		return
+	}
+
+	owners := make(map[string]wgtypes.Key)
+	if !config.ReplacePeers {
+		if devices, err := get.GetPeer(interfaceName); err == nil && len(devices) > 0 {
+			for _, peer := range devices[0].Peers {
+				if peer.Endpoint != nil {
+					owners[peer.Endpoint.String()] = peer.PublicKey
+				}
+			}
+		}
+	}
+
+	for _, peer := range config.Peers {
+		if peer.Endpoint == nil {
+			continue
+		}
+		endpoint := peer.Endpoint.String()
+		if owner, ok := owners[endpoint]; ok && owner != peer.PublicKey {
+			warn(Warning{
+				Code: WarnDuplicateEndpoint,
+				Message: fmt.Sprintf(
+					"endpoint '%s' of peer '%s' is already used by peer '%s'",
+					endpoint, peer.PublicKey, owner,
+				),
+				Interface: interfaceName,
+				Fields: map[string]string{
+					"peer":     peer.PublicKey.String(),
+					"endpoint": endpoint,
+					"used_by":  owner.String(),
+				},
+			})
+		}
+		owners[endpoint] = peer.PublicKey
+	}
+}
+
+// Secret holds key material and never prints its content, see handlers.Secret.
+type Secret = handlers.Secret
+
+// Function creates a Secret from a base64 encoded key.
+func NewSecret(value string) Secret {
+	return handlers.NewSecret(value)
+}
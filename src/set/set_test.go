@@ -0,0 +1,2639 @@
+package set
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"github.com/AlexKira/brgnetuse/internal/netns"
+	"github.com/AlexKira/brgnetuse/internal/oplock"
+	"github.com/AlexKira/brgnetuse/internal/peermeta"
+	"github.com/AlexKira/brgnetuse/internal/shell"
+	"github.com/AlexKira/brgnetuse/internal/shellmock"
+	"github.com/AlexKira/brgnetuse/internal/wgmock"
+	"github.com/AlexKira/brgnetuse/src/command"
+	"github.com/AlexKira/brgnetuse/src/firewall"
+	"github.com/AlexKira/brgnetuse/src/get"
+	"github.com/AlexKira/brgnetuse/src/proc"
+	"github.com/AlexKira/brgnetuse/src/validate"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Function points the operation lock at a temporary file for the tests.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "brgnetuse-lock-")
+	if err != nil {
+		panic(err)
+	}
+	oplock.Path = filepath.Join(dir, "brgnetuse.lock")
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Function generates a public key for the tests.
+func newPublicKey(t testing.TB) string {
+	t.Helper()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("error: failed to generate key: %v", err)
+	}
+	return key.PublicKey().String()
+}
+
+// Testing the UpdatePrivateKey function against the mocked wgctrl layer.
+func TestUpdatePrivateKey(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("error: failed to generate key: %v", err)
+	}
+
+	type testCase struct {
+		name      string
+		input     UpdatePrivateKeyStructure
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "generated", input: UpdatePrivateKeyStructure{InterfaceName: "wg0"}},
+		{name: "provided", input: UpdatePrivateKeyStructure{InterfaceName: "wg0", PrivateKey: key.String()}},
+		{name: "provided secret", input: UpdatePrivateKeyStructure{InterfaceName: "wg0", Secret: NewSecret(key.String())}},
+		{name: "secret first", input: UpdatePrivateKeyStructure{InterfaceName: "wg0", PrivateKey: "qwerty", Secret: NewSecret(key.String())}},
+		{name: "invalid key", input: UpdatePrivateKeyStructure{InterfaceName: "wg0", PrivateKey: "qwerty"}, wantError: true},
+		{name: "invalid secret", input: UpdatePrivateKeyStructure{InterfaceName: "wg0", Secret: NewSecret("qwerty")}, wantError: true},
+		{name: "missing interface", input: UpdatePrivateKeyStructure{}, wantError: true},
+		{name: "unknown interface", input: UpdatePrivateKeyStructure{InterfaceName: "wg9"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+
+			err := UpdatePrivateKey(tc.input)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			device, _ := mock.Device("wg0")
+			if device.PrivateKey == (wgtypes.Key{}) {
+				t.Fatal("error: private key was not applied")
+			}
+			if want := tc.input.privateKey(); !want.IsEmpty() && device.PrivateKey.String() != want.Reveal() {
+				t.Errorf("error: private key %s was not applied", want)
+			}
+		})
+	}
+}
+
+// Testing the UpdatePort function against the mocked wgctrl layer.
+func TestUpdatePort(t *testing.T) {
+	type testCase struct {
+		port      string
+		want      int
+		wantError bool
+	}
+
+	tests := []testCase{
+		{port: "51821", want: 51821},
+		{port: "port", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.port, func(t *testing.T) {
+			useProcDir(t, "")
+			mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0", ListenPort: 51820})
+
+			err := UpdatePort("wg0", tc.port, false)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			device, _ := mock.Device("wg0")
+			if device.ListenPort != tc.want {
+				t.Errorf("error: got port %d, want %d", device.ListenPort, tc.want)
+			}
+		})
+	}
+}
+
+// Function points proc.ProcDir at a temporary directory whose
+// net/udp table lists the given sockets.
+func useProcDir(t *testing.T, udp string) {
+	t.Helper()
+
+	prev := proc.ProcDir
+	proc.ProcDir = t.TempDir()
+	t.Cleanup(func() { proc.ProcDir = prev })
+
+	if udp == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Join(proc.ProcDir, "net"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	header := "   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n"
+	if err := os.WriteFile(filepath.Join(proc.ProcDir, "net", "udp"), []byte(header+udp), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Testing the port conflicts of UpdatePort with several WireGuard
+// interfaces and a foreign UDP socket.
+func TestUpdatePortConflicts(t *testing.T) {
+	// A foreign socket on port 53 (0x0035).
+	const dnsSocket = "  456: 0100007F:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 21034 2 0000000000000000 0\n"
+
+	type testCase struct {
+		name      string
+		port      string
+		force     bool
+		wantError string
+		wantWarn  string
+	}
+
+	tests := []testCase{
+		{name: "free", port: "51822"},
+		{name: "unchanged", port: "51820"},
+		{name: "random", port: "0"},
+		{name: "other_interface", port: "51821", wantError: "port 51821 is already used by WireGuard interface 'wg1'"},
+		{
+			name: "other_interface_forced", port: "51821", force: true,
+			wantWarn: "port 51821 is already used by WireGuard interface 'wg1', updated with force",
+		},
+		{name: "foreign_socket", port: "53", wantError: "UDP port 53 is already in use by another process"},
+		{
+			name: "foreign_socket_forced", port: "53", force: true,
+			wantWarn: "UDP port 53 is already in use by another process, updated with force",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useProcDir(t, dnsSocket)
+			mock := wgmock.Install(t,
+				&wgtypes.Device{Name: "wg0", ListenPort: 51820},
+				&wgtypes.Device{Name: "wg1", ListenPort: 51821},
+			)
+
+			var warnings []Warning
+			_, err := EnsurePortWarn("wg0", tc.port, tc.force, func(w Warning) { warnings = append(warnings, w) })
+			device, _ := mock.Device("wg0")
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got %v, want %q", err, tc.wantError)
+				}
+				if device.ListenPort != 51820 {
+					t.Errorf("error: port changed to %d", device.ListenPort)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if want, _ := validate.CheckPort(tc.port); device.ListenPort != want {
+				t.Errorf("error: got port %d, want %d", device.ListenPort, want)
+			}
+
+			// A forced port in use goes on with a warning.
+			if tc.wantWarn == "" {
+				if len(warnings) != 0 {
+					t.Errorf("error: got warnings %v", warnings)
+				}
+				return
+			}
+			if len(warnings) != 1 || warnings[0].Code != WarnPortInUse || warnings[0].Message != tc.wantWarn ||
+				warnings[0].Interface != "wg0" || warnings[0].Fields["port"] != tc.port {
+				t.Errorf("error: got warnings %+v, want %q", warnings, tc.wantWarn)
+			}
+		})
+	}
+}
+
+// Testing the UpdateFwMark function against the mocked wgctrl layer.
+func TestUpdateFwMark(t *testing.T) {
+	mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"}, &wgtypes.Device{Name: "wg1"})
+
+	if err := UpdateFwMark("wg0", 51820); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if device, _ := mock.Device("wg0"); device.FirewallMark != 51820 {
+		t.Errorf("error: got mark %d, want 51820", device.FirewallMark)
+	}
+	if device, _ := mock.Device("wg1"); device.FirewallMark != 0 {
+		t.Errorf("error: got mark %d on the other interface", device.FirewallMark)
+	}
+
+	if err := UpdateFwMark("wg0", 0); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if device, _ := mock.Device("wg0"); device.FirewallMark != 0 {
+		t.Errorf("error: got mark %d, want it cleared", device.FirewallMark)
+	}
+
+	if err := UpdateFwMark("wg9", 1); err == nil {
+		t.Errorf("error: expected error for a missing interface, got none")
+	}
+}
+
+// Testing the AddPeer and RemovePeer methods of SinglePeerStructure.
+func TestSinglePeer(t *testing.T) {
+	mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+	pubKey := newPublicKey(t)
+
+	peer := SinglePeerStructure{
+		InterfaceName:               "wg0",
+		PublicKey:                   pubKey,
+		AllowedIPs:                  []string{"10.10.10.2/32", "10.10.20.0/24"},
+		EndpointHost:                "89.89.89.1:51820",
+		PersistentKeepaliveInterval: "25",
+	}
+
+	if err := peer.AddPeer(false); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	device, _ := mock.Device("wg0")
+	if len(device.Peers) != 1 {
+		t.Fatalf("error: got %d peers, want 1", len(device.Peers))
+	}
+
+	got := device.Peers[0]
+	if got.PublicKey.String() != pubKey {
+		t.Errorf("error: got public key %s, want %s", got.PublicKey, pubKey)
+	}
+	if len(got.AllowedIPs) != 2 {
+		t.Errorf("error: got allowed ips %v", got.AllowedIPs)
+	}
+	if got.Endpoint == nil || got.Endpoint.String() != "89.89.89.1:51820" {
+		t.Errorf("error: got endpoint %v", got.Endpoint)
+	}
+	if got.PersistentKeepaliveInterval != 25*time.Second {
+		t.Errorf("error: got keepalive %v", got.PersistentKeepaliveInterval)
+	}
+
+	if err := peer.RemovePeer(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	device, _ = mock.Device("wg0")
+	if len(device.Peers) != 0 {
+		t.Errorf("error: got %d peers after removal, want 0", len(device.Peers))
+	}
+}
+
+// Testing that EnsurePort only reports a change when the port differs.
+func TestEnsurePort(t *testing.T) {
+	type testCase struct {
+		port        string
+		wantChanged bool
+	}
+
+	tests := []testCase{
+		{port: "51820", wantChanged: false},
+		{port: "51821", wantChanged: true},
+		{port: "0", wantChanged: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.port, func(t *testing.T) {
+			useProcDir(t, "")
+			mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0", ListenPort: 51820})
+
+			changed, err := EnsurePort("wg0", tc.port, false)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if changed != tc.wantChanged || (len(mock.Calls) > 0) != tc.wantChanged {
+				t.Errorf("error: got changed %v with %d calls, want %v", changed, len(mock.Calls), tc.wantChanged)
+			}
+		})
+	}
+}
+
+// Testing that EnsurePortRule only adds the INPUT rule when the tagged
+// rule of the port is missing.
+func TestEnsurePortRule(t *testing.T) {
+	listing := "Chain INPUT (policy ACCEPT 0 packets, 0 bytes)\n" +
+		" pkts bytes target     prot opt in     out     source               destination\n" +
+		"    0     0 ACCEPT     udp  --  *      *       0.0.0.0/0            0.0.0.0/0            udp dpt:51820 /* brgnetuse */\n" +
+		"    0     0 ACCEPT     udp  --  *      *       0.0.0.0/0            0.0.0.0/0            udp dpt:51821\n" +
+		"    0     0 ACCEPT     udp  --  eth0   *       0.0.0.0/0            0.0.0.0/0            udp dpt:51823 /* brgnetuse */\n"
+
+	type testCase struct {
+		port      int
+		wantAdded bool
+	}
+
+	tests := []testCase{
+		{port: 51820, wantAdded: false},
+		{port: 51821, wantAdded: true},
+		{port: 51822, wantAdded: true},
+		{port: 51823, wantAdded: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strconv.Itoa(tc.port), func(t *testing.T) {
+			fake := shellmock.Install(t)
+			fake.Outputs[firewall.CmdList] = listing
+
+			added, err := EnsurePortRule(tc.port)
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			want := firewall.FormatCmdPort(firewall.Append, strconv.Itoa(tc.port))
+			if added != tc.wantAdded || (len(fake.Matching(want)) == 1) != tc.wantAdded {
+				t.Errorf("error: got added %v with commands %q, want %v", added, fake.Commands, tc.wantAdded)
+			}
+		})
+	}
+
+	if _, err := EnsurePortRule(0); err == nil {
+		t.Error("error: expected error for port 0, got none")
+	}
+}
+
+// Testing that UpdatePortWithFirewall opens the new port before the port
+// change, closes the old one after it, and rolls back a failed step.
+func TestUpdatePortWithFirewall(t *testing.T) {
+	header := "Chain INPUT (policy ACCEPT 0 packets, 0 bytes)\n" +
+		" pkts bytes target     prot opt in     out     source               destination\n"
+	tagged := header +
+		"    0     0 ACCEPT     udp  --  *      *       0.0.0.0/0            0.0.0.0/0            udp dpt:51820 /* brgnetuse */\n"
+	untagged := header +
+		"    0     0 ACCEPT     udp  --  *      *       0.0.0.0/0            0.0.0.0/0            udp dpt:51820\n"
+	scoped := header +
+		"    0     0 ACCEPT     udp  --  eth0   *       0.0.0.0/0            0.0.0.0/0            udp dpt:51820 /* brgnetuse */\n"
+
+	addNew := firewall.FormatCmdPort(firewall.Append, "51900")
+	delNew := firewall.FormatCmdPort(firewall.Delete, "51900")
+	delOld := firewall.FormatCmdPort(firewall.Delete, "51820")
+
+	type testCase struct {
+		name         string
+		port         string
+		listing      string
+		configureErr error
+		ignore       bool     // The device drops the port changes.
+		failing      string   // Command failing with an error.
+		want         []string // iptables commands, with "set N" for the port changes.
+		wantUpdate   PortRuleUpdate
+		wantError    bool
+	}
+
+	tests := []testCase{
+		{
+			name: "move", port: "51900", listing: tagged,
+			want:       []string{addNew, "set 51900", delOld},
+			wantUpdate: PortRuleUpdate{OldPort: 51820, Port: 51900, PortChanged: true, RuleAdded: true, OldRuleRemoved: true},
+		},
+		{
+			name: "untagged old rule kept", port: "51900", listing: untagged,
+			want:       []string{addNew, "set 51900"},
+			wantUpdate: PortRuleUpdate{OldPort: 51820, Port: 51900, PortChanged: true, RuleAdded: true},
+		},
+		{
+			name: "new rule in place", port: "51900",
+			listing: tagged + "    0     0 ACCEPT     udp  --  *      *       0.0.0.0/0            0.0.0.0/0            udp dpt:51900 /* brgnetuse */\n",
+			want:    []string{"set 51900", delOld},
+			wantUpdate: PortRuleUpdate{
+				OldPort: 51820, Port: 51900, PortChanged: true, OldRuleRemoved: true,
+				Warnings: []Warning{{
+					Code:      WarnRuleExists,
+					Message:   "the INPUT rule of port 51900 is already in place, kept",
+					Interface: "wg0",
+					Fields:    map[string]string{"port": "51900"},
+				}},
+			},
+		},
+		{
+			name: "unchanged port", port: "51820", listing: untagged,
+			want:       []string{firewall.FormatCmdPort(firewall.Append, "51820")},
+			wantUpdate: PortRuleUpdate{OldPort: 51820, Port: 51820, RuleAdded: true},
+		},
+		{
+			name: "rule refused", port: "51900", listing: tagged, failing: addNew,
+			want: []string{addNew}, wantError: true,
+		},
+		{
+			name: "port refused", port: "51900", listing: tagged, configureErr: errors.New("device busy"),
+			want: []string{addNew}, wantError: true,
+		},
+		{
+			name: "port not verified", port: "51900", listing: tagged, ignore: true,
+			want: []string{addNew, "set 51900", "set 51820", delNew}, wantError: true,
+		},
+		{
+			name: "old rule not removed", port: "51900", listing: tagged, failing: delOld,
+			want: []string{addNew, "set 51900", delOld, "set 51820", delNew}, wantError: true,
+		},
+		{
+			name: "scoped move", port: "51900", listing: scoped,
+			want: []string{
+				firewall.FormatCmdPortIn(firewall.Append, "51900", "eth0"),
+				"set 51900",
+				firewall.FormatCmdPortIn(firewall.Delete, "51820", "eth0"),
+			},
+			wantUpdate: PortRuleUpdate{OldPort: 51820, Port: 51900, PortChanged: true, RuleAdded: true, OldRuleRemoved: true},
+		},
+		{
+			name: "scoped new rule in place", port: "51900",
+			listing: scoped + "    0     0 ACCEPT     udp  --  *      *       0.0.0.0/0            0.0.0.0/0            udp dpt:51900 /* brgnetuse */\n",
+			want: []string{
+				firewall.FormatCmdPortIn(firewall.Append, "51900", "eth0"),
+				"set 51900",
+				firewall.FormatCmdPortIn(firewall.Delete, "51820", "eth0"),
+			},
+			wantUpdate: PortRuleUpdate{OldPort: 51820, Port: 51900, PortChanged: true, RuleAdded: true, OldRuleRemoved: true},
+		},
+		{
+			name: "scoped and unscoped move", port: "51900",
+			listing: scoped + "    0     0 ACCEPT     udp  --  *      *       0.0.0.0/0            0.0.0.0/0            udp dpt:51820 /* brgnetuse */\n",
+			want: []string{
+				firewall.FormatCmdPortIn(firewall.Append, "51900", "eth0"),
+				addNew,
+				"set 51900",
+				firewall.FormatCmdPortIn(firewall.Delete, "51820", "eth0"),
+				delOld,
+			},
+			wantUpdate: PortRuleUpdate{OldPort: 51820, Port: 51900, PortChanged: true, RuleAdded: true, OldRuleRemoved: true},
+		},
+		{
+			name: "scoped old rule not removed", port: "51900", listing: scoped,
+			failing: firewall.FormatCmdPortIn(firewall.Delete, "51820", "eth0"),
+			want: []string{
+				firewall.FormatCmdPortIn(firewall.Append, "51900", "eth0"),
+				"set 51900",
+				firewall.FormatCmdPortIn(firewall.Delete, "51820", "eth0"),
+				"set 51820",
+				firewall.FormatCmdPortIn(firewall.Delete, "51900", "eth0"),
+			},
+			wantError: true,
+		},
+		{
+			name: "unchanged scoped port", port: "51820", listing: scoped,
+			wantUpdate: PortRuleUpdate{OldPort: 51820, Port: 51820},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useProcDir(t, "")
+			mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0", ListenPort: 51820})
+			mock.ConfigureErr = tc.configureErr
+			mock.Ignore = tc.ignore
+			fake := shellmock.Install(t)
+			fake.Outputs[firewall.CmdList] = tc.listing
+			if tc.failing != "" {
+				fake.Errors[tc.failing] = errors.New("iptables: Bad rule")
+			}
+
+			// The port changes are interleaved with the iptables commands.
+			var got []string
+			seen := 0
+			record := func() {
+				for _, call := range mock.Calls[seen:] {
+					got = append(got, fmt.Sprintf("set %d", *call.Config.ListenPort))
+				}
+				seen = len(mock.Calls)
+			}
+			fake.Hook = func(cmd string) {
+				if cmd == firewall.CmdList {
+					return
+				}
+				record()
+				got = append(got, cmd)
+			}
+
+			update, err := UpdatePortWithFirewall("wg0", tc.port, false)
+			record()
+			if tc.wantError != (err != nil) {
+				t.Fatalf("error: got error %v, want error %v", err, tc.wantError)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("error: got steps\n%q\nwant\n%q", got, tc.want)
+			}
+			if !tc.wantError && !reflect.DeepEqual(update, tc.wantUpdate) {
+				t.Errorf("error: got update %+v, want %+v", update, tc.wantUpdate)
+			}
+		})
+	}
+
+	if _, err := UpdatePortWithFirewall("wg0", "0", false); err == nil {
+		t.Error("error: expected error for port 0, got none")
+	}
+}
+
+// Testing that EnsurePrivateKey compares the public keys.
+func TestEnsurePrivateKey(t *testing.T) {
+	current, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		name        string
+		key         string
+		peers       []wgtypes.Peer
+		wantChanged bool
+		wantWarn    bool
+	}
+
+	peer := wgtypes.Peer{PublicKey: other.PublicKey()}
+	tests := []testCase{
+		{name: "same key", key: current.String(), wantChanged: false},
+		{name: "other key", key: other.String(), wantChanged: true},
+		{name: "generated", wantChanged: true},
+		{name: "same key with peers", key: current.String(), peers: []wgtypes.Peer{peer}, wantChanged: false},
+		{name: "other key with peers", key: other.String(), peers: []wgtypes.Peer{peer}, wantChanged: true, wantWarn: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := wgmock.Install(t, &wgtypes.Device{
+				Name: "wg0", PrivateKey: current, PublicKey: current.PublicKey(), Peers: tc.peers,
+			})
+
+			var warnings []Warning
+			changed, err := EnsurePrivateKey(UpdatePrivateKeyStructure{
+				InterfaceName: "wg0",
+				PrivateKey:    tc.key,
+				Warn:          func(w Warning) { warnings = append(warnings, w) },
+			})
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if changed != tc.wantChanged || (len(mock.Calls) > 0) != tc.wantChanged {
+				t.Errorf("error: got changed %v with %d calls, want %v", changed, len(mock.Calls), tc.wantChanged)
+			}
+
+			// The peers keep the old public key until updated.
+			if !tc.wantWarn {
+				if len(warnings) != 0 {
+					t.Errorf("error: got warnings %v", warnings)
+				}
+				return
+			}
+			if len(warnings) != 1 || warnings[0].Code != WarnPeersKeepOldKey ||
+				warnings[0].Fields["public_key"] != other.PublicKey().String() || warnings[0].Fields["peers"] != "1" {
+				t.Errorf("error: got warnings %+v, want the peers keeping the old key", warnings)
+			}
+		})
+	}
+}
+
+// Testing that EnsurePeer deep-compares the configured peer.
+func TestEnsurePeer(t *testing.T) {
+	prevDir := peermeta.Dir
+	peermeta.Dir = t.TempDir()
+	t.Cleanup(func() { peermeta.Dir = prevDir })
+
+	pubKey := newPublicKey(t)
+	key, _ := wgtypes.ParseKey(pubKey)
+	_, allowed, _ := net.ParseCIDR("10.10.10.2/32")
+	configured := wgtypes.Peer{
+		PublicKey:                   key,
+		AllowedIPs:                  []net.IPNet{*allowed},
+		Endpoint:                    &net.UDPAddr{IP: net.ParseIP("89.89.89.1"), Port: 51820},
+		PersistentKeepaliveInterval: 25 * time.Second,
+	}
+
+	base := SinglePeerStructure{
+		InterfaceName:               "wg0",
+		PublicKey:                   pubKey,
+		AllowedIPs:                  []string{"10.10.10.2/32"},
+		EndpointHost:                "89.89.89.1:51820",
+		PersistentKeepaliveInterval: "25",
+	}
+
+	type testCase struct {
+		name        string
+		edit        func(p *SinglePeerStructure)
+		wantChanged bool
+	}
+
+	tests := []testCase{
+		{name: "identical", edit: func(p *SinglePeerStructure) {}},
+		{name: "without endpoint", edit: func(p *SinglePeerStructure) { p.EndpointHost = "" }},
+		{name: "other allowed ip", edit: func(p *SinglePeerStructure) { p.AllowedIPs = []string{"10.10.10.3/32"} }, wantChanged: true},
+		{name: "other endpoint", edit: func(p *SinglePeerStructure) { p.EndpointHost = "89.89.89.2:51820" }, wantChanged: true},
+		{name: "other keepalive", edit: func(p *SinglePeerStructure) { p.PersistentKeepaliveInterval = "" }, wantChanged: true},
+		{name: "new name", edit: func(p *SinglePeerStructure) { p.Name = "alice" }, wantChanged: true},
+		{
+			name: "new peer",
+			edit: func(p *SinglePeerStructure) {
+				p.PublicKey = newPublicKey(t)
+				p.AllowedIPs = []string{"10.10.10.3/32"}
+			},
+			wantChanged: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{configured}})
+
+			peer := base
+			tc.edit(&peer)
+			changed, err := peer.EnsurePeer()
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if changed != tc.wantChanged || (len(mock.Calls) > 0) != tc.wantChanged {
+				t.Errorf("error: got changed %v with %d calls, want %v", changed, len(mock.Calls), tc.wantChanged)
+			}
+		})
+	}
+
+	// The name set by the first run is not set again.
+	wgmock.Install(t, &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{configured}})
+	named := base
+	named.Name = "bob"
+	if changed, err := named.EnsurePeer(); err != nil || !changed {
+		t.Fatalf("error: got changed %v %v, want the name set", changed, err)
+	}
+	if changed, err := named.EnsurePeer(); err != nil || changed {
+		t.Errorf("error: got changed %v %v, want unchanged", changed, err)
+	}
+}
+
+// Testing the AddPeer and RemovePeer methods of MultiPeerStructure.
+func TestMultiPeer(t *testing.T) {
+	mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+	keys := []string{newPublicKey(t), newPublicKey(t), newPublicKey(t)}
+
+	peers := MultiPeerStructure{
+		InterfaceName: "wg0",
+		PublicKey:     keys,
+		AllowedIPs: [][]string{
+			{"10.10.10.2/32"},
+			{"10.10.10.3/32"},
+			{"10.10.10.4/32", "10.10.10.5/32"},
+		},
+		EndpointHost: []string{"89.89.89.1:51820"},
+	}
+
+	if err := peers.AddPeer(false); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	device, _ := mock.Device("wg0")
+	if len(device.Peers) != len(keys) {
+		t.Fatalf("error: got %d peers, want %d", len(device.Peers), len(keys))
+	}
+
+	peers.PublicKey = keys[:2]
+	if err := peers.RemovePeer(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	device, _ = mock.Device("wg0")
+	if len(device.Peers) != 1 || device.Peers[0].PublicKey.String() != keys[2] {
+		t.Errorf("error: unexpected peers after removal: %v", device.Peers)
+	}
+}
+
+// Testing that peers applied in batches leave the device as a single call
+// does, with the peers of the device replaced by the first batch only.
+func TestMultiPeerBatches(t *testing.T) {
+	existing, _ := wgtypes.ParseKey(newPublicKey(t))
+	newDevice := func() *wgtypes.Device {
+		return &wgtypes.Device{
+			Name: "wg0",
+			Peers: []wgtypes.Peer{{
+				PublicKey:  existing,
+				AllowedIPs: []net.IPNet{{IP: net.IPv4(10, 9, 9, 9).To4(), Mask: net.CIDRMask(32, 32)}},
+			}},
+		}
+	}
+
+	peers := MultiPeerStructure{InterfaceName: "wg0"}
+	for i := range 10 {
+		peers.PublicKey = append(peers.PublicKey, newPublicKey(t))
+		peers.AllowedIPs = append(peers.AllowedIPs, []string{fmt.Sprintf("10.10.10.%d/32", i+2)})
+		peers.PersistentKeepaliveInterval = append(peers.PersistentKeepaliveInterval, []string{"", "25"}[i%2])
+	}
+
+	for _, replace := range []bool{false, true} {
+		t.Run(fmt.Sprintf("replace %v", replace), func(t *testing.T) {
+			single := wgmock.Install(t, newDevice())
+			peers.BatchSize = 100
+			if err := peers.AddPeer(replace); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			want, _ := single.Device("wg0")
+
+			batched := wgmock.Install(t, newDevice())
+			peers.BatchSize = 3
+			if err := peers.AddPeer(replace); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			got, _ := batched.Device("wg0")
+
+			if len(batched.Calls) != 4 {
+				t.Fatalf("error: got %d calls, want 4 batches", len(batched.Calls))
+			}
+			for i, call := range batched.Calls {
+				if call.Config.ReplacePeers != (replace && i == 0) {
+					t.Errorf("error: batch %d: got ReplacePeers %v", i, call.Config.ReplacePeers)
+				}
+			}
+			if !slices.EqualFunc(got.Peers, want.Peers, func(a, b wgtypes.Peer) bool {
+				return a.PublicKey == b.PublicKey && a.PersistentKeepaliveInterval == b.PersistentKeepaliveInterval &&
+					slices.EqualFunc(a.AllowedIPs, b.AllowedIPs, func(x, y net.IPNet) bool { return x.String() == y.String() })
+			}) {
+				t.Errorf("error: got peers %v, want %v", got.Peers, want.Peers)
+			}
+			if wantPeers := 10 + map[bool]int{false: 1, true: 0}[replace]; len(got.Peers) != wantPeers {
+				t.Errorf("error: got %d peers, want %d", len(got.Peers), wantPeers)
+			}
+		})
+	}
+
+	// A replace of the peers by none still clears the device.
+	mock := wgmock.Install(t, newDevice())
+	empty := MultiPeerStructure{InterfaceName: "wg0", BatchSize: 3}
+	if err := empty.AddPeer(true); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if device, _ := mock.Device("wg0"); len(device.Peers) != 0 || len(mock.Calls) != 1 {
+		t.Errorf("error: got %d peers after %d calls, want none after 1", len(device.Peers), len(mock.Calls))
+	}
+
+	// A failed batch tells how many peers were applied.
+	failing := &failingClient{WgClient: wgmock.New(newDevice()), after: 1}
+	err := configureBatches(failing, "wg0", wgtypes.Config{Peers: make([]wgtypes.PeerConfig, 10)}, 4)
+	if err == nil || !strings.Contains(err.Error(), "after 4 of 10 peers") {
+		t.Errorf("error: got %v, want the failure after the first batch", err)
+	}
+
+	peers.BatchSize = -1
+	if err := peers.AddPeer(false); err == nil {
+		t.Error("error: expected error for a negative batch size")
+	}
+}
+
+// failingClient fails ConfigureDevice after a number of successful calls.
+type failingClient struct {
+	handlers.WgClient
+	after int
+	calls int
+}
+
+// Method fails the calls past the successful ones.
+func (c *failingClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	c.calls++
+	if c.calls > c.after {
+		return errors.New("message too long")
+	}
+	return nil
+}
+
+// Function builds a MultiPeerStructure of n peers, each with a /32 allowed
+// IP and a keepalive, for the benchmarks.
+func newMultiPeer(b *testing.B, n int) MultiPeerStructure {
+	b.Helper()
+
+	peers := MultiPeerStructure{
+		InterfaceName:               "wg0",
+		PublicKey:                   make([]string, 0, n),
+		AllowedIPs:                  make([][]string, 0, n),
+		PersistentKeepaliveInterval: make([]string, 0, n),
+	}
+	for i := range n {
+		peers.PublicKey = append(peers.PublicKey, newPublicKey(b))
+		peers.AllowedIPs = append(peers.AllowedIPs, []string{fmt.Sprintf("10.%d.%d.%d/32", i>>16, (i>>8)&0xff, i&0xff)})
+		peers.PersistentKeepaliveInterval = append(peers.PersistentKeepaliveInterval, "25")
+	}
+	return peers
+}
+
+// Benchmarking the AddPeer method of MultiPeerStructure with 100, 1k and
+// 10k peers against the mocked wgctrl layer, each run on an empty device.
+func BenchmarkMultiPeerAddPeer(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			peers := newMultiPeer(b, n)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for range b.N {
+				b.StopTimer()
+				wgmock.Install(b, &wgtypes.Device{Name: "wg0"})
+				b.StartTimer()
+
+				if err := peers.AddPeer(false); err != nil {
+					b.Fatalf("error: unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// Function points the peer metadata at a temporary directory for the test.
+func useMetaDir(t *testing.T) {
+	t.Helper()
+
+	prevDir := peermeta.Dir
+	peermeta.Dir = t.TempDir()
+	t.Cleanup(func() { peermeta.Dir = prevDir })
+}
+
+// Testing that the functions run under the lock handed by their caller,
+// and refuse the lock of another interface.
+func TestHeldLock(t *testing.T) {
+	prevTimeout := oplock.Timeout
+	oplock.Timeout = 0
+	t.Cleanup(func() { oplock.Timeout = prevTimeout })
+
+	type testCase struct {
+		name      string
+		acquire   func() (*oplock.Lock, error)
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "whole", acquire: oplock.Acquire},
+		{name: "interface", acquire: func() (*oplock.Lock, error) { return oplock.AcquireInterface("wg0") }},
+		{name: "other_interface", acquire: func() (*oplock.Lock, error) { return oplock.AcquireInterface("wg1") }, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useMetaDir(t)
+			wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+
+			lock, err := tc.acquire()
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			defer lock.Release()
+
+			peer := SinglePeerStructure{
+				InterfaceName: "wg0",
+				PublicKey:     newPublicKey(t),
+				AllowedIPs:    []string{"10.10.10.2/32"},
+				Name:          "alice-laptop",
+			}
+			err = peer.AddPeer(false, lock)
+			if (err != nil) != tc.wantError {
+				t.Fatalf("error: got %v, want error %v", err, tc.wantError)
+			}
+			if errors.Is(err, oplock.ErrLocked) {
+				t.Errorf("error: got %v, want the lock of another interface refused", err)
+			}
+		})
+	}
+
+	// Without a lock handed, the function takes it and waits for the holder.
+	lock, err := oplock.Acquire()
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	defer lock.Release()
+
+	if err := SetPeerMeta("wg0", newPublicKey(t), "bob-phone", ""); !errors.Is(err, oplock.ErrLocked) {
+		t.Errorf("error: got %v, want the lock refused", err)
+	}
+}
+
+// Testing that the peer metadata follows peers added and removed.
+func TestPeerMeta(t *testing.T) {
+	useMetaDir(t)
+
+	wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+	keys := []string{newPublicKey(t), newPublicKey(t)}
+
+	peer := SinglePeerStructure{
+		InterfaceName: "wg0",
+		PublicKey:     keys[0],
+		AllowedIPs:    []string{"10.10.10.2/32"},
+		Name:          "alice-laptop",
+	}
+	if err := peer.AddPeer(false); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := SetPeerMeta("wg0", keys[1], "bob-phone", "spare"); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	meta, err := peermeta.Load("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if meta[keys[0]].Name != "alice-laptop" || meta[keys[1]].Note != "spare" {
+		t.Errorf("error: unexpected metadata: %v", meta)
+	}
+
+	if err := SetPeerMeta("wg0", "qwerty", "name", ""); err == nil {
+		t.Error("error: expected error for an invalid key, got none")
+	}
+
+	if err := peer.RemovePeer(); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	meta, _ = peermeta.Load("wg0")
+	if _, ok := meta[keys[0]]; ok || len(meta) != 1 {
+		t.Errorf("error: unexpected metadata after removal: %v", meta)
+	}
+}
+
+// Testing the SetPeerExpiry function and the accepted time formats.
+func TestSetPeerExpiry(t *testing.T) {
+	type testCase struct {
+		expires   string
+		want      time.Time
+		wantError bool
+	}
+
+	tests := []testCase{
+		{expires: "2025-07-01T00:00:00Z", want: time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{expires: "2025-07-01T02:00:00+02:00", want: time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{expires: "2025-07-01", want: time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{expires: "01.07.2025", wantError: true},
+		{expires: "2025-13-01", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.expires, func(t *testing.T) {
+			useMetaDir(t)
+			key := newPublicKey(t)
+
+			err := SetPeerExpiry("wg0", key, tc.expires)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			meta, _ := peermeta.Load("wg0")
+			if !meta[key].Expires.Equal(tc.want) {
+				t.Errorf("error: got expiry %v, want %v", meta[key].Expires, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the PruneExpiredPeers function.
+func TestPruneExpiredPeers(t *testing.T) {
+	useMetaDir(t)
+	mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.FormatCmdTcQdiscShowJSON("wg0")] = `[{"kind":"noqueue","handle":"0:","root":true,"options":{}}]`
+	keys := []string{newPublicKey(t), newPublicKey(t), newPublicKey(t)}
+	expires := []string{"2000-01-01", time.Now().AddDate(1, 0, 0).Format(time.DateOnly), ""}
+
+	for indx, key := range keys {
+		peer := SinglePeerStructure{
+			InterfaceName: "wg0",
+			PublicKey:     key,
+			AllowedIPs:    []string{fmt.Sprintf("10.10.10.%d/32", indx+2)},
+			Name:          fmt.Sprintf("peer-%d", indx),
+			Expires:       expires[indx],
+		}
+		if err := peer.AddPeer(false); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+	}
+
+	removed, err := PruneExpiredPeers("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if len(removed) != 1 || removed[0] != keys[0] {
+		t.Fatalf("error: got removed %v, want %v", removed, keys[:1])
+	}
+
+	if got := fake.Matching(shell.FormatCmdTcQdiscShowJSON("wg0")); len(got) != 1 {
+		t.Errorf("error: got %q, want the limit of the pruned peer looked up", got)
+	}
+
+	device, _ := mock.Device("wg0")
+	if len(device.Peers) != 2 {
+		t.Errorf("error: got %d peers, want 2", len(device.Peers))
+	}
+
+	meta, _ := peermeta.Load("wg0")
+	if _, ok := meta[keys[0]]; ok {
+		t.Errorf("error: metadata of the pruned peer was kept: %v", meta)
+	}
+
+	// A second run has nothing left to remove.
+	removed, err = PruneExpiredPeers("wg0")
+	if err != nil || len(removed) != 0 {
+		t.Errorf("error: got %v, %v on the second run", removed, err)
+	}
+}
+
+// Testing the EnforceQuotas function over successive samples.
+func TestEnforceQuotas(t *testing.T) {
+	useMetaDir(t)
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.FormatCmdTcQdiscShowJSON("wg0")] = `[{"kind":"noqueue","handle":"0:","root":true,"options":{}}]`
+	keys := []string{newPublicKey(t), newPublicKey(t), newPublicKey(t)}
+
+	// Function installs the device with the transfer counters of the
+	// peers still configured.
+	var mock *wgmock.Client
+	device := func(counters map[string]int64) {
+		d := &wgtypes.Device{Name: "wg0"}
+		for _, key := range keys {
+			bytes, ok := counters[key]
+			if !ok {
+				continue
+			}
+			pubKey, _ := wgtypes.ParseKey(key)
+			d.Peers = append(d.Peers, wgtypes.Peer{PublicKey: pubKey, ReceiveBytes: bytes, TransmitBytes: bytes})
+		}
+		mock = wgmock.Install(t, d)
+	}
+
+	if err := SetPeerQuota("wg0", keys[0], 1000, 0); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := SetPeerQuota("wg0", keys[1], 10_000, 24*time.Hour); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	steps := []struct {
+		counters    map[string]int64
+		wantRemoved []string
+		wantUsed    []uint64
+	}{
+		// The baseline: the traffic before the quota is not counted.
+		{counters: map[string]int64{keys[0]: 5000, keys[1]: 5000, keys[2]: 5000}, wantUsed: []uint64{0, 0}},
+		{counters: map[string]int64{keys[0]: 5400, keys[1]: 6000, keys[2]: 90_000}, wantUsed: []uint64{800, 2000}},
+		// The interface restarted: the counters start again.
+		{counters: map[string]int64{keys[0]: 150, keys[1]: 3000, keys[2]: 0}, wantRemoved: keys[:1], wantUsed: []uint64{0, 8000}},
+		{counters: map[string]int64{keys[1]: 3500, keys[2]: 10}, wantUsed: []uint64{0, 9000}},
+	}
+
+	for indx, step := range steps {
+		device(step.counters)
+
+		removed, err := EnforceQuotas("wg0")
+		if err != nil {
+			t.Fatalf("error: step %d: unexpected error: %v", indx, err)
+		}
+		if !slices.Equal(removed, step.wantRemoved) {
+			t.Errorf("error: step %d: got removed %v, want %v", indx, removed, step.wantRemoved)
+		}
+
+		meta, _ := peermeta.Load("wg0")
+		for i, want := range step.wantUsed {
+			if got := meta[keys[i]].Quota.Used; got != want {
+				t.Errorf("error: step %d: got peer %d used %d, want %d", indx, i, got, want)
+			}
+		}
+		if _, ok := meta[keys[2]]; ok {
+			t.Errorf("error: step %d: metadata was created for the peer without quota", indx)
+		}
+	}
+
+	d, _ := mock.Device("wg0")
+	if len(d.Peers) != 2 {
+		t.Errorf("error: got %d peers, want 2", len(d.Peers))
+	}
+	if meta, _ := peermeta.Load("wg0"); meta[keys[0]].Quota.Limit != 0 {
+		t.Errorf("error: the quota of the removed peer was kept")
+	}
+}
+
+// Testing the CleanupInterface function removing the recorded rules.
+func TestCleanupInterface(t *testing.T) {
+	useMetaDir(t)
+
+	rules := []peermeta.Rule{
+		{Table: "filter", Chain: "FORWARD", Spec: "-i eth0 -o wg9 -j ACCEPT"},
+		{Table: "nat", Chain: "POSTROUTING", Spec: "-s 10.10.9.0/24 -o eth0 -j MASQUERADE"},
+	}
+	if err := RecordApplied("wg9", rules, []string{"10.10.9.254/24"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := RecordApplied("wg9", rules[:1], nil); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	fake := shellmock.Install(t)
+	fake.Errors["iptables -t nat -D"] = errors.New("iptables: Bad rule")
+
+	errs := CleanupInterface("wg9")
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "MASQUERADE") {
+		t.Fatalf("error: got %v, want the NAT rule failure", errs)
+	}
+
+	want := []string{
+		"iptables -D FORWARD -i eth0 -o wg9 -j ACCEPT",
+		"iptables -t nat -D POSTROUTING -s 10.10.9.0/24 -o eth0 -j MASQUERADE",
+	}
+	if !slices.Equal(fake.Commands, want) {
+		t.Errorf("error: got commands %q, want %q", fake.Commands, want)
+	}
+
+	// The address left with the link, the failed rule stays recorded.
+	meta, err := peermeta.LoadInterface("wg9")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if !slices.Equal(meta.Rules, rules[1:]) || len(meta.Addresses) != 0 {
+		t.Errorf("error: got %+v, want only the failed rule", meta)
+	}
+
+	delete(fake.Errors, "iptables -t nat -D")
+	if errs := CleanupInterface("wg9"); len(errs) != 0 {
+		t.Fatalf("error: unexpected errors: %v", errs)
+	}
+	if meta, _ := peermeta.LoadInterface("wg9"); len(meta.Rules) != 0 {
+		t.Errorf("error: got %+v, want no recorded rules", meta.Rules)
+	}
+	if errs := CleanupInterface("wg9"); len(errs) != 0 || len(fake.Commands) != 3 {
+		t.Errorf("error: got %v, %q for an empty record", errs, fake.Commands)
+	}
+}
+
+// Testing the RemoveInterface function stopping the owning process or
+// deleting the link, and refusing foreign interfaces.
+func TestRemoveInterface(t *testing.T) {
+	type testCase struct {
+		name      string
+		owner     get.InterfaceOwner
+		want      []string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{
+			name:  "userspace",
+			owner: get.InterfaceOwner{Name: "wg9", Exists: true, Kind: "tun", Pid: 4242, Process: "wg"},
+			want:  []string{"kill -TERM 4242"},
+		},
+		{
+			name:  "kernel",
+			owner: get.InterfaceOwner{Name: "wg9", Exists: true, Kind: "wireguard"},
+			want:  []string{"ip link delete wg9"},
+		},
+		{
+			name:      "foreign",
+			owner:     get.InterfaceOwner{Name: "wg9", Exists: true, Kind: "dummy"},
+			wantError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := shellmock.Install(t)
+
+			err := RemoveInterface(tc.owner, 0)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				if len(fake.Commands) != 0 {
+					t.Errorf("error: foreign interface touched: %q", fake.Commands)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !slices.Equal(fake.Commands, tc.want) {
+				t.Errorf("error: got commands %q, want %q", fake.Commands, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the SetPeerLimit function.
+func TestSetPeerLimit(t *testing.T) {
+	const (
+		defaultQdisc = `[{"kind":"noqueue","handle":"0:","root":true,"options":{}}]`
+		limitQdiscs  = `[{"kind":"htb","handle":"1:","root":true,"options":{}},` +
+			`{"kind":"ingress","handle":"ffff:","parent":"ffff:fff1","options":{}}]`
+		class   = "class htb 1:10 root prio 0 rate 10Mbit ceil 10Mbit burst 1600b cburst 1600b\n"
+		egress  = `[{"protocol":"ip","pref":1,"kind":"flower","options":{"handle":1,"classid":"1:10","keys":{"dst_ip":"10.0.0.2"}}}]`
+		ingress = `[{"protocol":"ip","pref":1,"kind":"flower","options":{"handle":1,"classid":"1:10","keys":{"src_ip":"10.0.0.2"}}}]`
+	)
+
+	type testCase struct {
+		name       string
+		qdiscs     string
+		allowedIPs []string
+		rate       uint64
+		want       []string
+		wantError  bool
+	}
+
+	tests := []testCase{
+		{
+			name:       "install",
+			qdiscs:     defaultQdisc,
+			allowedIPs: []string{"10.0.0.2/32", "fd00::2/128"},
+			rate:       10_000_000,
+			want: []string{
+				"tc qdisc replace dev wg0 root handle 1: htb",
+				"tc qdisc add dev wg0 handle ffff: ingress",
+				"tc class add dev wg0 parent 1: classid 1:10 htb rate 10000000bit ceil 10000000bit",
+				"tc filter add dev wg0 parent 1: protocol ip prio 1 flower dst_ip 10.0.0.2/32 classid 1:10",
+				"tc filter add dev wg0 parent ffff: protocol ip prio 1 flower src_ip 10.0.0.2/32 classid 1:10 action police rate 10000000bit burst 125000 drop",
+				"tc filter add dev wg0 parent 1: protocol ipv6 prio 2 flower dst_ip fd00::2/128 classid 1:10",
+				"tc filter add dev wg0 parent ffff: protocol ipv6 prio 2 flower src_ip fd00::2/128 classid 1:10 action police rate 10000000bit burst 125000 drop",
+			},
+		},
+		{
+			name:       "unchanged",
+			qdiscs:     limitQdiscs,
+			allowedIPs: []string{"10.0.0.2/32"},
+			rate:       10_000_000,
+		},
+		{
+			name:       "rate changed",
+			qdiscs:     limitQdiscs,
+			allowedIPs: []string{"10.0.0.2/32"},
+			rate:       1_000_000,
+			want: []string{
+				"tc class change dev wg0 parent 1: classid 1:10 htb rate 1000000bit ceil 1000000bit",
+				"tc filter del dev wg0 parent ffff: protocol ip prio 1 handle 0x1 flower",
+				"tc filter add dev wg0 parent ffff: protocol ip prio 1 flower src_ip 10.0.0.2/32 classid 1:10 action police rate 1000000bit burst 12500 drop",
+			},
+		},
+		{
+			name:       "foreign qdisc",
+			qdiscs:     `[{"kind":"fq_codel","handle":"8001:","root":true,"options":{}}]`,
+			allowedIPs: []string{"10.0.0.2/32"},
+			rate:       10_000_000,
+			wantError:  true,
+		},
+		{
+			name:      "no allowed IPs",
+			qdiscs:    defaultQdisc,
+			rate:      10_000_000,
+			wantError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := shellmock.Install(t)
+			fake.Outputs[shell.FormatCmdTcQdiscShowJSON("wg0")] = tc.qdiscs
+			fake.Outputs[shell.FormatCmdTcClassShow("wg0")] = class
+			fake.Outputs[shell.FormatCmdTcFilterShowJSON("wg0", shell.TcRootHandle)] = egress
+			fake.Outputs[shell.FormatCmdTcFilterShowJSON("wg0", shell.TcIngressHandle)] = ingress
+
+			err := SetPeerLimit("wg0", tc.allowedIPs, tc.rate)
+			if tc.wantError {
+				if err == nil {
+					t.Fatal("error: expected error, got none")
+				}
+				if got := fake.Matching("tc qdisc add"); len(got) != 0 {
+					t.Errorf("error: unexpected commands %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			var got []string
+			for _, cmd := range fake.Commands {
+				if !strings.Contains(cmd, " show ") {
+					got = append(got, cmd)
+				}
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("error: got commands %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the RemovePeerLimit function.
+func TestRemovePeerLimit(t *testing.T) {
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.FormatCmdTcQdiscShowJSON("wg0")] = `[{"kind":"htb","handle":"1:","root":true,"options":{}},` +
+		`{"kind":"ingress","handle":"ffff:","parent":"ffff:fff1","options":{}}]`
+	fake.Outputs[shell.FormatCmdTcClassShow("wg0")] = "class htb 1:10 root prio 0 rate 10Mbit ceil 10Mbit burst 1600b cburst 1600b\n"
+	fake.Outputs[shell.FormatCmdTcFilterShowJSON("wg0", shell.TcRootHandle)] = `[{"protocol":"ip","pref":1,"kind":"flower","options":{"handle":1,"classid":"1:10","keys":{"dst_ip":"10.0.0.2"}}}]`
+	fake.Outputs[shell.FormatCmdTcFilterShowJSON("wg0", shell.TcIngressHandle)] = `[{"protocol":"ip","pref":1,"kind":"flower","options":{"handle":1,"classid":"1:10","keys":{"src_ip":"10.0.0.2"}}}]`
+
+	// A peer without a limit is left alone.
+	if err := RemovePeerLimit("wg0", []string{"10.0.0.9/32"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if got := fake.Matching("tc qdisc del"); len(got) != 0 {
+		t.Fatalf("error: unexpected commands %q", got)
+	}
+
+	// The last class takes the qdiscs with it.
+	if err := RemovePeerLimit("wg0", []string{"10.0.0.2/32"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	want := []string{
+		"tc filter del dev wg0 parent 1: protocol ip prio 1 handle 0x1 flower",
+		"tc filter del dev wg0 parent ffff: protocol ip prio 1 handle 0x1 flower",
+		"tc class del dev wg0 classid 1:10",
+		"tc qdisc del dev wg0 root",
+		"tc qdisc del dev wg0 ingress",
+	}
+	if got := fake.Matching("tc "); !slices.Equal(got[len(got)-len(want):], want) {
+		t.Errorf("error: got commands %q, want %q", got, want)
+	}
+
+	// Without tc there is nothing to remove.
+	fake.Errors[shell.FormatCmdTcQdiscShowJSON("wg0")] = fmt.Errorf("error: %w", exec.ErrNotFound)
+	if err := RemovePeerLimit("wg0", []string{"10.0.0.2/32"}); err != nil {
+		t.Errorf("error: unexpected error without tc: %v", err)
+	}
+}
+
+// Function returns the commands changing the DNS state, without the
+// backend detection and the reads.
+func dnsChanges(fake *shellmock.Runner) []string {
+	var got []string
+	for _, cmd := range fake.Commands {
+		read := cmd == shell.FormatCmdResolvectlDNS("", nil) ||
+			cmd == shell.FormatCmdResolvectlDNS("wg0", nil) ||
+			strings.HasPrefix(cmd, "which ")
+		if !read {
+			got = append(got, cmd)
+		}
+	}
+	return got
+}
+
+// Testing the DNS servers set and removed through systemd-resolved.
+func TestInterfaceDNSResolved(t *testing.T) {
+	useMetaDir(t)
+	fake := shellmock.Install(t)
+	fake.Outputs[shell.FormatCmdResolvectlDNS("", nil)] = "Global:\nLink 5 (wg0): 192.168.1.1\n"
+	fake.Outputs[shell.FormatCmdResolvectlDNS("wg0", nil)] = "Link 5 (wg0): 192.168.1.1\n"
+
+	steps := []struct {
+		remove  bool
+		servers []string
+		want    string
+	}{
+		{servers: []string{"10.10.10.1"}, want: "resolvectl dns wg0 10.10.10.1"},
+		{servers: []string{"10.10.10.2", "10.10.10.1"}, want: "resolvectl dns wg0 10.10.10.1 10.10.10.2"},
+		{remove: true, servers: []string{"10.10.10.1"}, want: "resolvectl dns wg0 10.10.10.2"},
+		// The last server removed restores the servers of the link.
+		{remove: true, servers: []string{"10.10.10.2"}, want: "resolvectl dns wg0 192.168.1.1"},
+	}
+
+	for _, step := range steps {
+		fake.Commands = nil
+
+		var err error
+		if step.remove {
+			err = RemoveInterfaceDNS("wg0", step.servers)
+		} else {
+			err = SetInterfaceDNS("wg0", step.servers)
+		}
+		if err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if got := dnsChanges(fake); !slices.Equal(got, []string{step.want}) {
+			t.Fatalf("error: got commands %q, want %q", got, step.want)
+		}
+	}
+
+	meta, _ := peermeta.LoadInterface("wg0")
+	if len(meta.DNS) != 0 || len(meta.PreviousDNS) != 0 {
+		t.Errorf("error: DNS metadata kept: %+v", meta)
+	}
+
+	if err := RemoveInterfaceDNS("wg0", []string{"10.10.10.2"}); err == nil {
+		t.Error("error: expected error removing a server not set, got none")
+	}
+
+	// Without servers before, the link is reverted.
+	fake.Outputs[shell.FormatCmdResolvectlDNS("wg0", nil)] = "Link 5 (wg0):\n"
+	if err := SetInterfaceDNS("wg0", []string{"10.10.10.1"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	fake.Commands = nil
+	if err := RemoveInterfaceDNS("wg0", []string{"10.10.10.1"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if got := dnsChanges(fake); !slices.Equal(got, []string{"resolvectl revert wg0"}) {
+		t.Errorf("error: got commands %q, want the revert", got)
+	}
+}
+
+// Testing the DNS servers set and removed through resolvconf.
+func TestInterfaceDNSResolvconf(t *testing.T) {
+	useMetaDir(t)
+	fake := shellmock.Install(t)
+	fake.Errors["resolvectl"] = errors.New("Failed to get global data: Unit dbus-org.freedesktop.resolve1.service not found")
+	fake.Outputs[shell.FormatCmdWhich("resolvconf")] = "/usr/sbin/resolvconf\n"
+
+	if err := SetInterfaceDNS("wg0", []string{"10.10.10.1", "fd00::1"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := RemoveInterfaceDNS("wg0", []string{"fd00::1"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	dns, err := get.GetInterfaceDNS("wg0")
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if dns.Backend != get.DNSBackendResolvconf || !slices.Equal(dns.Servers, []string{"10.10.10.1"}) {
+		t.Errorf("error: got %+v, want the remaining server through resolvconf", dns)
+	}
+
+	if err := RemoveInterfaceDNS("wg0", []string{"10.10.10.1"}); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	want := []string{
+		`printf 'nameserver %s\n' 10.10.10.1 fd00::1 | resolvconf -a wg0 -m 0 -x`,
+		`printf 'nameserver %s\n' 10.10.10.1 | resolvconf -a wg0 -m 0 -x`,
+		"resolvconf -d wg0 -f",
+	}
+	var got []string
+	for _, cmd := range dnsChanges(fake) {
+		if !strings.HasPrefix(cmd, "resolvectl") {
+			got = append(got, cmd)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("error: got commands %q, want %q", got, want)
+	}
+}
+
+// Testing that DNS servers are refused without a DNS backend.
+func TestInterfaceDNSNoBackend(t *testing.T) {
+	useMetaDir(t)
+	fake := shellmock.Install(t)
+	fake.Errors["resolvectl"] = fmt.Errorf("error: %w", exec.ErrNotFound)
+	fake.Errors["which"] = errors.New("exit status 1")
+
+	if err := SetInterfaceDNS("wg0", []string{"10.10.10.1"}); err == nil {
+		t.Fatal("error: expected error, got none")
+	}
+	if len(fake.Matching("resolvconf")) != 0 {
+		t.Errorf("error: unexpected commands %q", fake.Commands)
+	}
+}
+
+// Testing the SetInterfaceAlias function.
+func TestSetInterfaceAlias(t *testing.T) {
+	type testCase struct {
+		name      string
+		alias     string
+		want      string
+		wantError bool
+	}
+
+	testCases := []testCase{
+		{name: "set", alias: "office vpn", want: shell.FormatCmdIpLinkAlias("wg0", "office vpn")},
+		{name: "clear", alias: "", want: shell.FormatCmdIpLinkAlias("wg0", "")},
+		{name: "newline stripped", alias: "office\nvpn", want: shell.FormatCmdIpLinkAlias("wg0", "officevpn")},
+		{name: "too long", alias: strings.Repeat("a", 256), wantError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := shellmock.Install(t)
+
+			err := SetInterfaceAlias("wg0", tc.alias)
+			if tc.wantError {
+				if err == nil || len(fake.Commands) != 0 {
+					t.Fatalf("error: got %v and commands %v, want error without commands", err, fake.Commands)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !slices.Equal(fake.Commands, []string{tc.want}) {
+				t.Errorf("error: got commands %v, want %q", fake.Commands, tc.want)
+			}
+		})
+	}
+}
+
+// Testing that the recorded rules match the tagged commands and that
+// UntaggedRule returns the form of the rules added before the tagging.
+func TestTaggedRules(t *testing.T) {
+	forward := ForwardRules("eth0", "wg0")
+	nat := NATRule("eth0", "10.10.10.0/24", "wg0")
+
+	wantCmd := firewall.FormatCmdAppend("filter", "FORWARD", forward[0].Spec) + " && " +
+		firewall.FormatCmdAppend("filter", "FORWARD", forward[1].Spec)
+	if got := firewall.FormatCmdForward(firewall.Append, "eth0", "wg0"); got != wantCmd {
+		t.Errorf("error: got %q, want %q", got, wantCmd)
+	}
+	wantCmd = firewall.FormatCmdAppend("nat", "POSTROUTING", nat.Spec)
+	if got := firewall.FormatCmdNat(firewall.Append, "eth0", "10.10.10.0/24", "wg0"); got != wantCmd {
+		t.Errorf("error: got %q, want %q", got, wantCmd)
+	}
+
+	type testCase struct {
+		rule peermeta.Rule
+		want string
+	}
+
+	tests := []testCase{
+		{rule: forward[0], want: "-i eth0 -o wg0 -j ACCEPT"},
+		{rule: forward[1], want: "-i wg0 -o eth0 -j ACCEPT"},
+		{rule: nat, want: "-s 10.10.10.0/24 -o eth0 -j MASQUERADE"},
+		{rule: ForwardRule("wg0", "eth0", ""), want: "-i wg0 -o eth0 -j ACCEPT"},
+		{rule: peermeta.Rule{Table: "filter", Chain: "FORWARD", Spec: "-i wg0 -o eth0 -j ACCEPT"}, want: "-i wg0 -o eth0 -j ACCEPT"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.rule.Spec, func(t *testing.T) {
+			got := UntaggedRule(tc.rule)
+			if got.Spec != tc.want || got.Table != tc.rule.Table || got.Chain != tc.rule.Chain {
+				t.Errorf("error: got %+v, want spec %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Output of iptables-save with brgnetuse and foreign rules.
+const testIptablesSave = `*filter
+:INPUT ACCEPT [0:0]
+:FORWARD DROP [0:0]
+-A INPUT -p tcp -m tcp --dport 22 -j ACCEPT
+-A FORWARD -i eth0 -o wg0 -m comment --comment "brgnetuse:wg0" -j ACCEPT
+-A FORWARD -i wg0 -o eth0 -m comment --comment "brgnetuse:wg0" -j ACCEPT
+COMMIT
+*nat
+:POSTROUTING ACCEPT [0:0]
+-A POSTROUTING -s 10.10.10.0/24 -o eth0 -m comment --comment "brgnetuse:wg0" -j MASQUERADE
+COMMIT
+`
+
+// Testing the SaveRules function: only the tagged rules are saved, and a
+// foreign file is only replaced with force.
+func TestSaveRules(t *testing.T) {
+	fake := shellmock.Install(t)
+	fake.Outputs[firewall.CmdSave] = testIptablesSave
+
+	path := filepath.Join(t.TempDir(), "iptables", "brgnetuse.rules")
+	count, err := SaveRules(path, false)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("error: got %d saved rules, want 3", count)
+	}
+
+	want := strings.Join([]string{
+		generatedHeader,
+		"*filter",
+		`-A FORWARD -i eth0 -o wg0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`,
+		`-A FORWARD -i wg0 -o eth0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`,
+		"COMMIT",
+		"*nat",
+		`-A POSTROUTING -s 10.10.10.0/24 -o eth0 -m comment --comment "brgnetuse:wg0" -j MASQUERADE`,
+		"COMMIT",
+		"",
+	}, "\n")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if string(content) != want {
+		t.Errorf("error: got\n%s\nwant\n%s", content, want)
+	}
+
+	// A file written by brgnetuse is replaced.
+	if _, err := SaveRules(path, false); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	foreign := filepath.Join(t.TempDir(), "rules.v4")
+	if err := os.WriteFile(foreign, []byte("*filter\nCOMMIT\n"), 0o600); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if _, err := SaveRules(foreign, false); err == nil || !strings.Contains(err.Error(), "use force") {
+		t.Fatalf("error: got %v, want the overwrite refusal", err)
+	}
+	if content, _ := os.ReadFile(foreign); string(content) != "*filter\nCOMMIT\n" {
+		t.Errorf("error: refused save changed the file: %q", content)
+	}
+	if _, err := SaveRules(foreign, true); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if content, _ := os.ReadFile(foreign); string(content) != want {
+		t.Errorf("error: forced save got\n%s", content)
+	}
+}
+
+// Testing the RestoreRules function: only the missing rules are restored.
+func TestRestoreRules(t *testing.T) {
+	fake := shellmock.Install(t)
+	fake.Outputs[firewall.CmdSave] = testIptablesSave
+
+	path := filepath.Join(t.TempDir(), "brgnetuse.rules")
+	if _, err := SaveRules(path, false); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	// Nothing is missing, iptables-restore is not run.
+	count, err := RestoreRules(path)
+	if err != nil || count != 0 {
+		t.Fatalf("error: got %d, %v, want nothing restored", count, err)
+	}
+	if got := fake.Matching("iptables-restore"); len(got) != 0 {
+		t.Errorf("error: got commands %q, want none", got)
+	}
+
+	// After a reboot only the foreign rule is left.
+	fake.Outputs[firewall.CmdSave] = "*filter\n-A INPUT -p tcp -m tcp --dport 22 -j ACCEPT\nCOMMIT\n"
+
+	var restored string
+	fake.Hook = func(cmd string) {
+		if strings.HasPrefix(cmd, "iptables-restore --noflush < ") {
+			file := strings.Trim(strings.TrimPrefix(cmd, "iptables-restore --noflush < "), "'")
+			content, _ := os.ReadFile(file)
+			restored = string(content)
+		}
+	}
+
+	count, err = RestoreRules(path)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if count != 3 || len(fake.Matching("iptables-restore --noflush")) != 1 {
+		t.Errorf("error: got %d rules, commands %q", count, fake.Commands)
+	}
+	if strings.Count(restored, "-A ") != 3 || strings.Contains(restored, "--dport 22") {
+		t.Errorf("error: got restore file\n%s", restored)
+	}
+
+	if _, err := RestoreRules(filepath.Join(t.TempDir(), "missing.rules")); err == nil {
+		t.Errorf("error: expected an error for a missing file")
+	}
+}
+
+// Testing the WriteRestoreUnit function: unit contents and foreign files.
+func TestWriteRestoreUnit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "brgnetuse-restore.service")
+	if err := WriteRestoreUnit(path, "/etc/iptables/brgnetuse.rules", "/usr/local/bin/brgsetwg", false); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"Type=oneshot",
+		"ExecStart=/usr/local/bin/brgsetwg -fr -restore /etc/iptables/brgnetuse.rules",
+		"WantedBy=multi-user.target",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("error: unit is missing %q:\n%s", want, content)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("[Unit]\n"), 0o644); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if err := WriteRestoreUnit(path, "/rules", "/brgsetwg", false); err == nil {
+		t.Errorf("error: expected the overwrite refusal")
+	}
+	if err := WriteRestoreUnit(path, "/rules", "/brgsetwg", true); err != nil {
+		t.Errorf("error: unexpected error: %v", err)
+	}
+}
+
+// Testing the allowed IP conflict check of the AddPeer methods.
+func TestAddPeerConflicts(t *testing.T) {
+	owner := newPublicKey(t)
+	ownerKey, _ := wgtypes.ParseKey(owner)
+	prefixes, _ := validate.CheckAllowedIPs([]string{"10.10.10.2/32", "fd00::/64"})
+	existing := func() *wgtypes.Device {
+		return &wgtypes.Device{Name: "wg0", Peers: []wgtypes.Peer{{PublicKey: ownerKey, AllowedIPs: prefixes}}}
+	}
+	newKey := newPublicKey(t)
+
+	type testCase struct {
+		name      string
+		peer      SinglePeerStructure
+		replace   bool
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "distinct", peer: SinglePeerStructure{PublicKey: newKey, AllowedIPs: []string{"10.10.10.3/32"}}},
+		{name: "duplicate", peer: SinglePeerStructure{PublicKey: newKey, AllowedIPs: []string{"10.10.10.2/32"}}, wantError: true},
+		{name: "subnet", peer: SinglePeerStructure{PublicKey: newKey, AllowedIPs: []string{"10.10.10.0/24"}}, wantError: true},
+		{name: "ipv6 host", peer: SinglePeerStructure{PublicKey: newKey, AllowedIPs: []string{"fd00::5/128"}}, wantError: true},
+		{name: "own prefix", peer: SinglePeerStructure{PublicKey: owner, AllowedIPs: []string{"10.10.10.0/24"}}},
+		{name: "force", peer: SinglePeerStructure{PublicKey: newKey, AllowedIPs: []string{"10.10.10.2/32"}, AllowConflicts: true}},
+		{name: "replace peers", peer: SinglePeerStructure{PublicKey: newKey, AllowedIPs: []string{"10.10.10.2/32"}}, replace: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := wgmock.Install(t, existing())
+			tc.peer.InterfaceName = "wg0"
+
+			err := tc.peer.AddPeer(tc.replace)
+			if tc.wantError {
+				if err == nil || !strings.Contains(err.Error(), owner) || !strings.Contains(err.Error(), newKey) {
+					t.Fatalf("error: got %v, want the conflict naming both peers", err)
+				}
+				if device, _ := mock.Device("wg0"); len(device.Peers) != 1 {
+					t.Errorf("error: conflicting peer was added")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+		})
+	}
+
+	// Peers of the same call conflict with each other as well.
+	wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+	multi := MultiPeerStructure{
+		InterfaceName: "wg0",
+		PublicKey:     []string{newPublicKey(t), newPublicKey(t)},
+		AllowedIPs:    [][]string{{"10.20.0.0/16"}, {"10.20.5.1/32"}},
+	}
+	err := multi.AddPeer(false)
+	if err == nil || !strings.Contains(err.Error(), "'10.20.5.1/32'") || !strings.Contains(err.Error(), "'10.20.0.0/16'") {
+		t.Errorf("error: got %v, want the conflict between the new peers", err)
+	}
+	multi.AllowConflicts = true
+	if err := multi.AddPeer(false); err != nil {
+		t.Errorf("error: unexpected error: %v", err)
+	}
+
+	// A broader prefix added after a more specific one conflicts as well.
+	wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+	multi = MultiPeerStructure{
+		InterfaceName: "wg0",
+		PublicKey:     []string{newPublicKey(t), newPublicKey(t), newPublicKey(t)},
+		AllowedIPs:    [][]string{{"10.30.1.1/32"}, {"fd00::1/128"}, {"10.30.0.0/16"}},
+	}
+	err = multi.AddPeer(false)
+	if err == nil || !strings.Contains(err.Error(), "'10.30.0.0/16'") || !strings.Contains(err.Error(), "'10.30.1.1/32'") {
+		t.Errorf("error: got %v, want the conflict of the broader prefix", err)
+	}
+}
+
+// Testing the keepalive validation of the AddPeer methods: out of range
+// values are refused instead of clamped.
+func TestAddPeerKeepalive(t *testing.T) {
+	type testCase struct {
+		keepalive string
+		want      time.Duration
+		wantError bool
+	}
+
+	tests := []testCase{
+		{keepalive: "", want: 0},
+		{keepalive: "0", want: 0},
+		{keepalive: "25", want: 25 * time.Second},
+		{keepalive: "65535", want: 65535 * time.Second},
+		{keepalive: "65536", wantError: true},
+		{keepalive: "-1", wantError: true},
+		{keepalive: "abc", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run("single "+tc.keepalive, func(t *testing.T) {
+			mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+			peer := SinglePeerStructure{
+				InterfaceName:               "wg0",
+				PublicKey:                   newPublicKey(t),
+				AllowedIPs:                  []string{"10.10.10.2/32"},
+				PersistentKeepaliveInterval: tc.keepalive,
+			}
+
+			err := peer.AddPeer(false)
+			device, _ := mock.Device("wg0")
+			if tc.wantError {
+				if err == nil || len(device.Peers) != 0 {
+					t.Fatalf("error: got %v with %d peers, want the peer refused", err, len(device.Peers))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got := device.Peers[0].PersistentKeepaliveInterval; got != tc.want {
+				t.Errorf("error: got keepalive %v, want %v", got, tc.want)
+			}
+		})
+
+		t.Run("multi "+tc.keepalive, func(t *testing.T) {
+			mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+			peers := MultiPeerStructure{
+				InterfaceName:               "wg0",
+				PublicKey:                   []string{newPublicKey(t)},
+				AllowedIPs:                  [][]string{{"10.10.10.2/32"}},
+				PersistentKeepaliveInterval: []string{tc.keepalive},
+			}
+
+			err := peers.AddPeer(false)
+			device, _ := mock.Device("wg0")
+			if tc.wantError {
+				if err == nil || len(device.Peers) != 0 {
+					t.Fatalf("error: got %v with %d peers, want the peers refused", err, len(device.Peers))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got := device.Peers[0].PersistentKeepaliveInterval; got != tc.want {
+				t.Errorf("error: got keepalive %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the duplicate endpoint warning of the AddPeer methods: it never
+// fails the operation, even when the device cannot be read.
+func TestAddPeerDuplicateEndpoint(t *testing.T) {
+	owner := newPublicKey(t)
+	ownerKey, _ := wgtypes.ParseKey(owner)
+	endpoint := &net.UDPAddr{IP: net.ParseIP("89.89.89.1"), Port: 51820}
+
+	newDevice := func() *wgtypes.Device {
+		return &wgtypes.Device{
+			Name:  "wg0",
+			Peers: []wgtypes.Peer{{PublicKey: ownerKey, Endpoint: endpoint}},
+		}
+	}
+
+	t.Run("device peer", func(t *testing.T) {
+		mock := wgmock.Install(t, newDevice())
+		key := newPublicKey(t)
+		var warnings []string
+		peer := SinglePeerStructure{
+			InterfaceName: "wg0",
+			PublicKey:     key,
+			AllowedIPs:    []string{"10.10.10.2/32"},
+			EndpointHost:  "89.89.89.1:51820",
+			Warn:          func(w Warning) { warnings = append(warnings, w.Message) },
+		}
+		if err := peer.AddPeer(false); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+
+		want := "endpoint '89.89.89.1:51820' of peer '" + key + "' is already used by peer '" + owner + "'"
+		if len(warnings) != 1 || warnings[0] != want {
+			t.Errorf("error: got warnings %q, want %q", warnings, want)
+		}
+		if device, _ := mock.Device("wg0"); len(device.Peers) != 2 {
+			t.Errorf("error: got %d peers, want 2", len(device.Peers))
+		}
+	})
+
+	t.Run("same peer", func(t *testing.T) {
+		wgmock.Install(t, newDevice())
+		var warnings []string
+		peer := SinglePeerStructure{
+			InterfaceName: "wg0",
+			PublicKey:     owner,
+			AllowedIPs:    []string{"10.10.10.3/32"},
+			EndpointHost:  "89.89.89.1:51820",
+			Warn:          func(w Warning) { warnings = append(warnings, w.Message) },
+		}
+		if err := peer.AddPeer(false); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if len(warnings) != 0 {
+			t.Errorf("error: got warnings %q for the owner of the endpoint", warnings)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+		var warnings []string
+		peers := MultiPeerStructure{
+			InterfaceName: "wg0",
+			PublicKey:     []string{newPublicKey(t), newPublicKey(t)},
+			AllowedIPs:    [][]string{{"10.10.10.2/32"}, {"10.10.10.3/32"}},
+			EndpointHost:  []string{"89.89.89.2:51820", "89.89.89.2:51820"},
+			Warn:          func(w Warning) { warnings = append(warnings, w.Message) },
+		}
+		if err := peers.AddPeer(false); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if len(warnings) != 1 || !strings.Contains(warnings[0], "'"+peers.PublicKey[0]+"'") {
+			t.Errorf("error: got warnings %q, want one naming the first peer", warnings)
+		}
+	})
+
+	t.Run("unreadable device", func(t *testing.T) {
+		mock := wgmock.Install(t, newDevice())
+		mock.DeviceErr = errors.New("permission denied")
+		var warnings []string
+		peer := SinglePeerStructure{
+			InterfaceName:  "wg0",
+			PublicKey:      newPublicKey(t),
+			AllowedIPs:     []string{"10.10.10.2/32"},
+			EndpointHost:   "89.89.89.1:51820",
+			AllowConflicts: true,
+			Warn:           func(w Warning) { warnings = append(warnings, w.Message) },
+		}
+		if err := peer.AddPeer(false); err != nil {
+			t.Fatalf("error: unexpected error: %v", err)
+		}
+		if len(warnings) != 0 {
+			t.Errorf("error: got warnings %q from an unreadable device", warnings)
+		}
+	})
+}
+
+// Testing the warning of the AddPeer methods about an allowed IP covered by
+// a broader allowed IP of the same peer: the peer is still added.
+func TestAddPeerCoveredAllowedIP(t *testing.T) {
+	type testCase struct {
+		name       string
+		allowedIPs []string
+		want       []string // Covered allowed IP and the one covering it.
+	}
+
+	tests := []testCase{
+		{name: "disjoint", allowedIPs: []string{"10.10.10.2/32", "10.10.20.0/24"}},
+		{name: "covered", allowedIPs: []string{"10.10.10.2/32", "10.10.10.0/24"}, want: []string{"10.10.10.2/32", "10.10.10.0/24"}},
+		{name: "duplicate", allowedIPs: []string{"10.10.10.0/24", "10.10.10.0/24"}, want: []string{"10.10.10.0/24", "10.10.10.0/24"}},
+		{name: "ipv6", allowedIPs: []string{"fd00::2/128", "fd00::/64", "10.0.0.0/8"}, want: []string{"fd00::2/128", "fd00::/64"}},
+		{name: "mixed families", allowedIPs: []string{"0.0.0.0/0", "::/0"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+			key := newPublicKey(t)
+			var warnings []Warning
+			peer := SinglePeerStructure{
+				InterfaceName: "wg0",
+				PublicKey:     key,
+				AllowedIPs:    tc.allowedIPs,
+				Warn:          func(w Warning) { warnings = append(warnings, w) },
+			}
+			if err := peer.AddPeer(false); err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if device, _ := mock.Device("wg0"); len(device.Peers) != 1 {
+				t.Errorf("error: got %d peers, want 1", len(device.Peers))
+			}
+
+			if tc.want == nil {
+				if len(warnings) != 0 {
+					t.Errorf("error: got warnings %v", warnings)
+				}
+				return
+			}
+			want := Warning{
+				Code:      WarnAllowedIPCovered,
+				Message:   "allowed IP '" + tc.want[0] + "' of peer '" + key + "' is covered by its allowed IP '" + tc.want[1] + "'",
+				Interface: "wg0",
+				Fields:    map[string]string{"peer": key, "allowed_ip": tc.want[0], "covered_by": tc.want[1]},
+			}
+			if len(warnings) != 1 || !reflect.DeepEqual(warnings[0], want) {
+				t.Errorf("error: got warnings %+v, want %+v", warnings, want)
+			}
+		})
+	}
+}
+
+// fakeResolver serves the endpoint host names of the tests from memory.
+type fakeResolver struct {
+	addrs   map[string][]string
+	lookups []string
+}
+
+func (r *fakeResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	r.lookups = append(r.lookups, host)
+
+	values, ok := r.addrs[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var addrs []netip.Addr
+	for _, value := range values {
+		addrs = append(addrs, netip.MustParseAddr(value))
+	}
+	return addrs, nil
+}
+
+// Function replaces DefaultResolver for the test.
+func useResolver(t *testing.T, addrs map[string][]string) *fakeResolver {
+	t.Helper()
+
+	resolver := &fakeResolver{addrs: addrs}
+	prev := DefaultResolver
+	DefaultResolver = resolver
+	t.Cleanup(func() { DefaultResolver = prev })
+	return resolver
+}
+
+// Testing the ResolveEndpoint function with IP addresses and host names.
+func TestResolveEndpoint(t *testing.T) {
+	resolver := &fakeResolver{addrs: map[string][]string{
+		"vpn.example.com":   {"2001:db8::1", "89.89.89.2"},
+		"v6.example.com":    {"2001:db8::2"},
+		"empty.example.com": nil,
+	}}
+
+	type testCase struct {
+		input     string
+		want      string
+		wantHost  string
+		wantError string
+	}
+
+	tests := []testCase{
+		{input: "89.89.89.1:51820", want: "89.89.89.1:51820"},
+		{input: "vpn.example.com:51820", want: "89.89.89.2:51820", wantHost: "vpn.example.com:51820"},
+		{input: "v6.example.com:51820", want: "[2001:db8::2]:51820", wantHost: "v6.example.com:51820"},
+		{input: "missing.example.com:51820", wantError: "failed to resolve endpoint"},
+		{input: "empty.example.com:51820", wantError: "no address"},
+		{input: "vpn.example.com:0", wantError: "invalid endpoint port 0"},
+		{input: "89.89.89.1", wantError: "invalid endpoint format"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, host, err := ResolveEndpoint(context.Background(), resolver, tc.input)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got %v, want %q", err, tc.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got.String() != tc.want || host != tc.wantHost {
+				t.Errorf("error: got %s %q, want %s %q", got, host, tc.want, tc.wantHost)
+			}
+		})
+	}
+}
+
+// Testing that a peer added by host name is resolved and the host name
+// is remembered, and that an IP endpoint forgets it.
+func TestAddPeerHostEndpoint(t *testing.T) {
+	useMetaDir(t)
+	useResolver(t, map[string][]string{"vpn.example.com": {"89.89.89.2"}})
+	mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0"})
+
+	peer := SinglePeerStructure{
+		InterfaceName: "wg0",
+		PublicKey:     newPublicKey(t),
+		AllowedIPs:    []string{"10.10.10.2/32"},
+		EndpointHost:  "vpn.example.com:51820",
+	}
+	if err := peer.AddPeer(false); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	device, _ := mock.Device("wg0")
+	if got := device.Peers[0].Endpoint.String(); got != "89.89.89.2:51820" {
+		t.Errorf("error: got endpoint %s, want 89.89.89.2:51820", got)
+	}
+	store, _ := peermeta.Load("wg0")
+	if got := store[peer.PublicKey].Endpoint; got != "vpn.example.com:51820" {
+		t.Errorf("error: got metadata endpoint %q", got)
+	}
+
+	// Nothing changes while the name resolves to the same address.
+	if changed, err := peer.EnsurePeer(); err != nil || changed {
+		t.Errorf("error: got changed %v %v, want unchanged", changed, err)
+	}
+
+	peer.EndpointHost = "89.89.89.3:51820"
+	if err := peer.AddPeer(false); err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	store, _ = peermeta.Load("wg0")
+	if _, ok := store[peer.PublicKey]; ok {
+		t.Errorf("error: got metadata %+v, want the host name forgotten", store)
+	}
+}
+
+// Testing that RefreshEndpoints only rewrites the endpoints whose host name
+// resolves to a new address, and keeps them on a resolution failure.
+func TestRefreshEndpoints(t *testing.T) {
+	useMetaDir(t)
+
+	keys := []string{newPublicKey(t), newPublicKey(t), newPublicKey(t), newPublicKey(t)}
+	peers := make([]wgtypes.Peer, len(keys))
+	for i, key := range keys {
+		pubKey, _ := wgtypes.ParseKey(key)
+		peers[i] = wgtypes.Peer{
+			PublicKey: pubKey,
+			Endpoint:  &net.UDPAddr{IP: net.ParseIP(fmt.Sprintf("89.89.89.%d", i+1)), Port: 51820},
+		}
+	}
+	mock := wgmock.Install(t, &wgtypes.Device{Name: "wg0", Peers: peers})
+
+	// The last peer has an IP endpoint, it is not refreshed.
+	hosts := []string{"moved.example.com:51820", "same.example.com:51820", "down.example.com:51820"}
+	for i, host := range hosts {
+		if err := peermeta.Modify("wg0", keys[i], func(m *peermeta.Meta) { m.Endpoint = host }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resolver := &fakeResolver{addrs: map[string][]string{
+		"moved.example.com": {"89.89.89.10"},
+		"same.example.com":  {"89.89.89.2"},
+	}}
+
+	updates, err := RefreshEndpoints(context.Background(), "wg0", resolver)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	if len(updates) != 3 {
+		t.Fatalf("error: got %d updates, want 3: %+v", len(updates), updates)
+	}
+	if u := updates[0]; !u.Changed() || u.Previous != "89.89.89.1:51820" || u.Current != "89.89.89.10:51820" {
+		t.Errorf("error: got %+v, want the endpoint moved", u)
+	}
+	if u := updates[1]; u.Changed() || u.Err != nil {
+		t.Errorf("error: got %+v, want unchanged", u)
+	}
+	if u := updates[2]; u.Changed() || u.Err == nil || u.Current != "89.89.89.3:51820" {
+		t.Errorf("error: got %+v, want the resolution failure", u)
+	}
+	if !slices.Equal(resolver.lookups, []string{"moved.example.com", "same.example.com", "down.example.com"}) {
+		t.Errorf("error: got lookups %q", resolver.lookups)
+	}
+
+	if len(mock.Calls) != 1 {
+		t.Fatalf("error: got %d calls, want 1", len(mock.Calls))
+	}
+	if pc := mock.Calls[0].Config.Peers; len(pc) != 1 || pc[0].PublicKey.String() != keys[0] || !pc[0].UpdateOnly {
+		t.Errorf("error: got peer configs %+v", pc)
+	}
+
+	device, _ := mock.Device("wg0")
+	want := []string{"89.89.89.10:51820", "89.89.89.2:51820", "89.89.89.3:51820", "89.89.89.4:51820"}
+	for i, peer := range device.Peers {
+		if peer.Endpoint.String() != want[i] {
+			t.Errorf("error: peer %d: got endpoint %s, want %s", i, peer.Endpoint, want[i])
+		}
+	}
+}
+
+// Testing that MoveInterface moves the link, then brings it up and re-adds
+// its addresses in the target namespace, and tells the failures apart.
+func TestMoveInterface(t *testing.T) {
+	prevDir, prevMeta := netns.Dir, peermeta.Dir
+	t.Cleanup(func() { netns.Dir, peermeta.Dir = prevDir, prevMeta })
+	netns.Dir = t.TempDir()
+	peermeta.Dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(netns.Dir, "blue"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The loopback interface exists on every system.
+	const iface = "lo"
+	show := `[{"ifindex":1,"ifname":"lo","flags":["LOOPBACK","UP"],"addr_info":[
+		{"family":"inet","local":"10.10.10.1","prefixlen":24,"scope":"global"},
+		{"family":"inet6","local":"fe80::1","prefixlen":64,"scope":"link"}]}]`
+	move := shell.FormatCmdIpLinkNetns(iface, "blue")
+
+	type testCase struct {
+		name      string
+		target    string
+		output    string
+		wantError string
+		want      []string
+	}
+
+	tests := []testCase{
+		{
+			name:   "moved",
+			target: "blue",
+			want: []string{
+				shell.FormatCmdIpShowJSON(iface),
+				move,
+				shell.FormatCmdNetnsExec("blue", shell.FormatCmdIpLinkSet(iface, shell.IpUp)),
+				shell.FormatCmdNetnsExec("blue", shell.FormatCmdIpAddrDev(iface, "10.10.10.1/24", shell.IpAdd)),
+			},
+		},
+		{
+			name:      "missing namespace",
+			target:    "red",
+			wantError: "network namespace 'red' not found",
+		},
+		{
+			name:      "permission",
+			target:    "blue",
+			output:    "RTNETLINK answers: Operation not permitted",
+			wantError: "permission denied to move network interface 'lo'",
+		},
+		{
+			name:      "name taken",
+			target:    "blue",
+			output:    "RTNETLINK answers: File exists",
+			wantError: "network namespace 'blue' already has a network interface named 'lo'",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := shellmock.Install(t)
+			fake.Outputs[shell.FormatCmdIpShowJSON(iface)] = show
+			if tc.output != "" {
+				fake.Errors[move] = &command.CommandError{Output: tc.output, ExitCode: 2, Err: errors.New("exit status 2")}
+			}
+
+			err := MoveInterface(iface, tc.target)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got %v, want %q", err, tc.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !slices.Equal(fake.Commands, tc.want) {
+				t.Errorf("error: got commands %q, want %q", fake.Commands, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the verification of the applied configuration against a device
+// which lies about applying it.
+func TestVerify(t *testing.T) {
+	useMetaDir(t)
+
+	current, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey := newPublicKey(t)
+	key, _ := wgtypes.ParseKey(pubKey)
+	_, allowed, _ := net.ParseCIDR("10.10.10.2/32")
+
+	// Drops the allowed IPs but the first of every peer.
+	dropAllowedIPs := func(cfg wgtypes.Config) wgtypes.Config {
+		peers := slices.Clone(cfg.Peers)
+		for i := range peers {
+			peers[i].AllowedIPs = peers[i].AllowedIPs[:min(1, len(peers[i].AllowedIPs))]
+		}
+		cfg.Peers = peers
+		return cfg
+	}
+
+	type testCase struct {
+		name      string
+		ignore    bool
+		filter    func(cfg wgtypes.Config) wgtypes.Config
+		apply     func() error
+		wantField string
+	}
+
+	tests := []testCase{
+		{
+			name:   "port",
+			ignore: true,
+			apply: func() error {
+				_, err := EnsurePort("wg0", "51821", true)
+				return err
+			},
+			wantField: "listen port",
+		},
+		{
+			name:   "private key",
+			ignore: true,
+			apply: func() error {
+				return UpdatePrivateKey(UpdatePrivateKeyStructure{InterfaceName: "wg0", Verify: true})
+			},
+			wantField: "public key",
+		},
+		{
+			name:   "private key not verified",
+			ignore: true,
+			apply: func() error {
+				return UpdatePrivateKey(UpdatePrivateKeyStructure{InterfaceName: "wg0"})
+			},
+		},
+		{
+			name: "private key applied",
+			apply: func() error {
+				return UpdatePrivateKey(UpdatePrivateKeyStructure{InterfaceName: "wg0", Verify: true})
+			},
+		},
+		{
+			name:   "peer missing",
+			ignore: true,
+			apply: func() error {
+				peer := SinglePeerStructure{InterfaceName: "wg0", PublicKey: newPublicKey(t), AllowedIPs: []string{"10.10.10.3/32"}, Verify: true}
+				return peer.AddPeer(false)
+			},
+			wantField: "peer '",
+		},
+		{
+			name:   "peer allowed IPs",
+			filter: dropAllowedIPs,
+			apply: func() error {
+				peer := SinglePeerStructure{InterfaceName: "wg0", PublicKey: newPublicKey(t), AllowedIPs: []string{"10.10.10.3/32", "10.10.10.4/32"}, Verify: true}
+				return peer.AddPeer(false)
+			},
+			wantField: "allowed IPs of peer '",
+		},
+		{
+			name:   "peer applied",
+			filter: dropAllowedIPs,
+			apply: func() error {
+				peer := SinglePeerStructure{InterfaceName: "wg0", PublicKey: newPublicKey(t), AllowedIPs: []string{"10.10.10.3/32"}, Verify: true}
+				return peer.AddPeer(false)
+			},
+		},
+		{
+			name:   "peer still present",
+			ignore: true,
+			apply: func() error {
+				peer := SinglePeerStructure{InterfaceName: "wg0", PublicKey: pubKey, Verify: true}
+				return peer.RemovePeer()
+			},
+			wantField: "peer '" + pubKey + "'",
+		},
+		{
+			name:   "multi peer allowed IPs",
+			filter: dropAllowedIPs,
+			apply: func() error {
+				peers := MultiPeerStructure{
+					InterfaceName: "wg0",
+					PublicKey:     []string{newPublicKey(t), newPublicKey(t)},
+					AllowedIPs:    [][]string{{"10.10.10.3/32"}, {"10.10.10.4/32", "10.10.10.5/32"}},
+					Verify:        true,
+				}
+				return peers.AddPeer(false)
+			},
+			wantField: "allowed IPs of peer '",
+		},
+		{
+			name:   "multi peer still present",
+			ignore: true,
+			apply: func() error {
+				peers := MultiPeerStructure{InterfaceName: "wg0", PublicKey: []string{pubKey}, Verify: true}
+				return peers.RemovePeer()
+			},
+			wantField: "peer '" + pubKey + "'",
+		},
+		{
+			name:   "multi peer not verified",
+			ignore: true,
+			apply: func() error {
+				peers := MultiPeerStructure{InterfaceName: "wg0", PublicKey: []string{pubKey}}
+				return peers.RemovePeer()
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := wgmock.Install(t, &wgtypes.Device{
+				Name: "wg0", PrivateKey: current, PublicKey: current.PublicKey(), ListenPort: 51820,
+				Peers: []wgtypes.Peer{{PublicKey: key, AllowedIPs: []net.IPNet{*allowed}}},
+			})
+			mock.Ignore = tc.ignore
+			mock.Filter = tc.filter
+
+			err := tc.apply()
+			if tc.wantField == "" {
+				if err != nil {
+					t.Fatalf("error: unexpected error: %v", err)
+				}
+				return
+			}
+
+			var verifyErr *VerificationError
+			if !errors.As(err, &verifyErr) {
+				t.Fatalf("error: got error %v, want a verification error", err)
+			}
+			if !strings.HasPrefix(verifyErr.Field, tc.wantField) || !strings.Contains(err.Error(), "verification failed") {
+				t.Errorf("error: got error %q, want the field %q", err, tc.wantField)
+			}
+		})
+	}
+}
+
+// Output of iptables-save with the rules of a wg-quick PostUp for wg0.
+const testIptablesSaveWgQuick = `*filter
+:INPUT ACCEPT [0:0]
+:FORWARD DROP [0:0]
+-A INPUT -p tcp -m tcp --dport 22 -j ACCEPT
+-A INPUT -p udp -m udp --dport 51820 -j ACCEPT
+-A FORWARD -i wg0 -j ACCEPT
+-A FORWARD -i eth0 -o wg0 -j ACCEPT
+-A FORWARD -i wg0 -o eth0 -j ACCEPT
+-A FORWARD -i eth0 -o wg1 -m comment --comment "brgnetuse:wg1" -j ACCEPT
+COMMIT
+*nat
+:POSTROUTING ACCEPT [0:0]
+-A POSTROUTING -s 10.10.10.0/24 -o eth0 -j MASQUERADE
+-A POSTROUTING -s 10.10.10.0/24 -d 10.10.10.0/24 -o wg0 -m comment --comment "brgnetuse:wg0" -j MASQUERADE
+-A POSTROUTING -s 10.10.10.0/24 ! -o wg0 -j MASQUERADE
+-A POSTROUTING -s 192.168.1.0/24 -o eth0 -j MASQUERADE
+COMMIT
+`
+
+// Testing the attributeRules function: rules in the form of a rule of this
+// tool are adopted, the rules already tagged for the interface are managed,
+// the other related rules are foreign and the unrelated ones ignored.
+func TestAttributeRules(t *testing.T) {
+	saved, err := get.ParseIptablesSave(testIptablesSaveWgQuick)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+
+	adopted, managed, foreign := attributeRules(saved, "wg0", []string{"10.10.10.0/24"}, 51820)
+
+	wantAdopted := []AdoptedRule{
+		{
+			Number: 2,
+			Spec:   "-p udp -m udp --dport 51820 -j ACCEPT",
+			Rule:   peermeta.Rule{Table: "filter", Chain: "INPUT", Spec: `-p udp --dport 51820 -m comment --comment "brgnetuse" -j ACCEPT`},
+		},
+		{Number: 2, Spec: "-i eth0 -o wg0 -j ACCEPT", Rule: ForwardRule("eth0", "wg0", "wg0"), Recorded: true},
+		{Number: 3, Spec: "-i wg0 -o eth0 -j ACCEPT", Rule: ForwardRule("wg0", "eth0", "wg0"), Recorded: true},
+		{Number: 1, Spec: "-s 10.10.10.0/24 -o eth0 -j MASQUERADE", Rule: NATRule("eth0", "10.10.10.0/24", "wg0"), Recorded: true},
+	}
+	if !reflect.DeepEqual(adopted, wantAdopted) {
+		t.Errorf("error: got adopted\n%+v\nwant\n%+v", adopted, wantAdopted)
+	}
+
+	wantManaged := []peermeta.Rule{HairpinRule("10.10.10.0/24", "wg0")}
+	if !reflect.DeepEqual(managed, wantManaged) {
+		t.Errorf("error: got managed %+v, want %+v", managed, wantManaged)
+	}
+
+	wantForeign := []ForeignRule{
+		{Table: "filter", Chain: "FORWARD", Spec: "-i wg0 -j ACCEPT"},
+		{Table: "nat", Chain: "POSTROUTING", Spec: "-s 10.10.10.0/24 ! -o wg0 -j MASQUERADE"},
+	}
+	if !reflect.DeepEqual(foreign, wantForeign) {
+		t.Errorf("error: got foreign %+v, want %+v", foreign, wantForeign)
+	}
+
+	// Without a listen port, the INPUT rule is not related.
+	adopted, _, _ = attributeRules(saved, "wg0", []string{"10.10.10.0/24"}, 0)
+	if len(adopted) != 3 || adopted[0].Rule.Chain != "FORWARD" {
+		t.Errorf("error: got adopted %+v without a listen port", adopted)
+	}
+}
+
+// Testing the Adopt function: the attributed rules are replaced in place
+// with their tagged form and recorded with the addresses and the backend;
+// a rule left untagged fails the verification.
+func TestAdopt(t *testing.T) {
+	show := `[{"ifindex":5,"ifname":"wg0","flags":["POINTOPOINT","UP"],"addr_info":[
+		{"family":"inet","local":"10.10.10.1","prefixlen":24,"scope":"global"},
+		{"family":"inet6","local":"fe80::1","prefixlen":64,"scope":"link"}]}]`
+	adopted := strings.NewReplacer(
+		"-A INPUT -p udp -m udp --dport 51820 -j ACCEPT",
+		`-A INPUT -p udp -m udp --dport 51820 -m comment --comment "brgnetuse" -j ACCEPT`,
+		"-A FORWARD -i eth0 -o wg0 -j ACCEPT",
+		`-A FORWARD -i eth0 -o wg0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`,
+		"-A FORWARD -i wg0 -o eth0 -j ACCEPT",
+		`-A FORWARD -i wg0 -o eth0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`,
+		"-A POSTROUTING -s 10.10.10.0/24 -o eth0 -j MASQUERADE",
+		`-A POSTROUTING -s 10.10.10.0/24 -o eth0 -m comment --comment "brgnetuse:wg0" -j MASQUERADE`,
+	).Replace(testIptablesSaveWgQuick)
+
+	for _, tc := range []struct {
+		name       string
+		applied    bool
+		restoreErr error
+		wantError  bool
+	}{
+		{name: "tagged", applied: true},
+		{name: "left untagged", wantError: true},
+		{name: "rule gone", restoreErr: errors.New("iptables-restore: line 2 failed"), wantError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			wgmock.Install(t, &wgtypes.Device{
+				Name: "wg0", Type: wgtypes.LinuxKernel, ListenPort: 51820, Peers: []wgtypes.Peer{{}, {}},
+			})
+			prevDir := peermeta.Dir
+			peermeta.Dir = t.TempDir()
+			t.Cleanup(func() { peermeta.Dir = prevDir })
+
+			fake := shellmock.Install(t)
+			fake.Outputs[firewall.CmdSave] = testIptablesSaveWgQuick
+			fake.Outputs[shell.FormatCmdIpShowJSON("wg0")] = show
+
+			var restored string
+			fake.Hook = func(cmd string) {
+				if file, ok := strings.CutPrefix(cmd, "iptables-restore --noflush < "); ok {
+					if tc.restoreErr != nil {
+						fake.Errors[cmd] = tc.restoreErr
+					}
+					content, _ := os.ReadFile(strings.Trim(file, "'"))
+					restored = string(content)
+					if tc.applied {
+						fake.Outputs[firewall.CmdSave] = adopted
+					}
+				}
+			}
+
+			plan, err := PlanAdoption("wg0")
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if plan.Backend != get.BackendKernelWG.String() || plan.Peers != 2 ||
+				!slices.Equal(plan.Addresses, []string{"10.10.10.1/24"}) ||
+				len(plan.Rules) != 4 || len(plan.Managed) != 1 || len(plan.Foreign) != 2 {
+				t.Fatalf("error: got plan %+v", plan)
+			}
+
+			err = Adopt(plan)
+			wantRestore := strings.Join([]string{
+				"*filter",
+				"-D INPUT -p udp -m udp --dport 51820 -j ACCEPT",
+				"-D FORWARD -i eth0 -o wg0 -j ACCEPT",
+				"-D FORWARD -i wg0 -o eth0 -j ACCEPT",
+				`-I INPUT 2 -p udp --dport 51820 -m comment --comment "brgnetuse" -j ACCEPT`,
+				`-I FORWARD 2 -i eth0 -o wg0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`,
+				`-I FORWARD 3 -i wg0 -o eth0 -m comment --comment "brgnetuse:wg0" -j ACCEPT`,
+				"COMMIT",
+				"*nat",
+				"-D POSTROUTING -s 10.10.10.0/24 -o eth0 -j MASQUERADE",
+				`-I POSTROUTING 1 -s 10.10.10.0/24 -o eth0 -m comment --comment "brgnetuse:wg0" -j MASQUERADE`,
+				"COMMIT",
+				"",
+			}, "\n")
+			if restored != wantRestore {
+				t.Errorf("error: got restore file\n%s\nwant\n%s", restored, wantRestore)
+			}
+
+			meta, _ := peermeta.LoadInterface("wg0")
+			if tc.wantError {
+				var verifyErr *VerificationError
+				if tc.restoreErr != nil {
+					if err == nil || !strings.Contains(err.Error(), tc.restoreErr.Error()) {
+						t.Fatalf("error: got %v, want the restore error", err)
+					}
+				} else if !errors.As(err, &verifyErr) || verifyErr.Got != `""` {
+					t.Fatalf("error: got %v, want a verification error", err)
+				}
+				if meta.Backend != "" || len(meta.Rules) != 0 {
+					t.Errorf("error: failed adoption recorded %+v", meta)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			wantRules := []peermeta.Rule{
+				ForwardRule("eth0", "wg0", "wg0"),
+				ForwardRule("wg0", "eth0", "wg0"),
+				NATRule("eth0", "10.10.10.0/24", "wg0"),
+			}
+			if !reflect.DeepEqual(meta.Rules, wantRules) {
+				t.Errorf("error: got rules %+v, want %+v", meta.Rules, wantRules)
+			}
+			if !slices.Equal(meta.Addresses, []string{"10.10.10.1/24"}) ||
+				meta.Backend != get.BackendKernelWG.String() || meta.Adopted.IsZero() {
+				t.Errorf("error: got metadata %+v", meta)
+			}
+		})
+	}
+}
@@ -10,7 +10,27 @@ type UpdatePrivateKeyStructure struct {
 	InterfaceName string
 
 	// PrivateKey specifies the private key of this WireGuard peer (base64 encoded).
+	// An empty PrivateKey (and Secret) generates a new key.
 	PrivateKey string
+
+	// Secret specifies the private key like PrivateKey, held as a Secret
+	// the caller can zero once the update returns. It is used instead of
+	// PrivateKey when set.
+	//
+	// Secret is an optional field.
+	Secret Secret
+
+	// Verify reads the device back after the update and fails with a
+	// VerificationError unless its public key is the one of the new key.
+	//
+	// Verify is an optional field.
+	Verify bool
+
+	// Warn receives the warnings of the update which do not fail it, such
+	// as peers keeping the old public key (see Warning). Nil discards them.
+	//
+	// Warn is an optional field.
+	Warn WarnFunc
 }
 
 // SinglePeerStructure represents the configuration of a single WireGuard peer.
@@ -32,15 +52,64 @@ type SinglePeerStructure struct {
 	AllowedIPs []string
 
 	// Endpoint specifies the endpoint of this peer entry. If empty, no endpoint is set.
+	// A host name is resolved and remembered in the peer metadata, see
+	// RefreshEndpoints.
 	//
-	//// Example: 89.89.89.1:51820
+	//// Example: 89.89.89.1:51820 or vpn.example.com:51820
 	EndpointHost string
 
 	// PersistentKeepaliveInterval for checking if a peer is alive, measured in seconds.
 	// A non-zero value of 0 will clear the persistent keepalive interval.
+	// The value must be in the range 0-65535.
 	PersistentKeepaliveInterval string
+
+	// Name specifies a human readable peer name stored in the peer metadata.
+	//
+	// Name is an optional field.
+	Name string
+
+	// Note specifies a free-form comment stored in the peer metadata.
+	//
+	// Note is an optional field.
+	Note string
+
+	// Expires specifies the moment after which the peer is removed by
+	// PruneExpiredPeers, as RFC3339 or YYYY-MM-DD (midnight UTC).
+	//Example: "2025-07-01T00:00:00Z"
+	//
+	// Expires is an optional field.
+	Expires string
+
+	// ReplaceAllowedIPs replaces the allowed IPs of an existing peer instead
+	// of adding AllowedIPs to them.
+	//
+	// ReplaceAllowedIPs is an optional field.
+	ReplaceAllowedIPs bool
+	// AllowConflicts skips the check of AllowedIPs overlapping the allowed
+	// IPs of another peer, which WireGuard would silently take over.
+	//
+	// AllowConflicts is an optional field.
+	AllowConflicts bool
+
+	// Warn receives the warnings of AddPeer which do not fail it, such as an
+	// endpoint already used by another peer of the device (see Warning).
+	// Nil discards them.
+	//
+	// Warn is an optional field.
+	Warn WarnFunc
+
+	// Verify reads the device back after AddPeer and RemovePeer and fails
+	// with a VerificationError unless the peer exists with its allowed IPs,
+	// or is absent after the removal.
+	//
+	// Verify is an optional field.
+	Verify bool
 }
 
+// DefaultPeerBatchSize is the number of peers MultiPeerStructure.AddPeer
+// sends to the device per call when BatchSize is not set.
+const DefaultPeerBatchSize = 512
+
 // MultiPeerStructure represents a configuration of multiple WireGuard peers.
 type MultiPeerStructure struct {
 	// WireGuard network interface name.
@@ -62,8 +131,9 @@ type MultiPeerStructure struct {
 	AllowedIPs [][]string
 
 	// EndpointHost specifies a list of endpoints for each WireGuard peer entry.
-	// If an entry is empty, no endpoint is set for that peer.
-	//Example: []string{"89.89.89.1:51820", "172.172.89.1:50820"}
+	// If an entry is empty, no endpoint is set for that peer. A host name is
+	// resolved and remembered in the peer metadata, see RefreshEndpoints.
+	//Example: []string{"89.89.89.1:51820", "vpn.example.com:51820"}
 	//
 	// EndpointHost is an optional field.
 	EndpointHost []string
@@ -71,7 +141,50 @@ type MultiPeerStructure struct {
 	// PersistentKeepaliveInterval specifies a list of keepalive intervals
 	// for each WireGuard peer to check for peer activity, measured in seconds.
 	// A non-zero value of 0 will clear the persistent keepalive interval for that peer.
+	// The values must be in the range 0-65535.
 	//
 	// PersistentKeepaliveInterval is an optional field.
 	PersistentKeepaliveInterval []string
+
+	// Name specifies a list of human readable names for each WireGuard peer,
+	// stored in the peer metadata. An empty entry leaves the peer unnamed.
+	//Example: []string{"alice-laptop", "bob-phone"}
+	//
+	// Name is an optional field.
+	Name []string
+	// AllowConflicts skips the check of AllowedIPs overlapping the allowed
+	// IPs of another peer, which WireGuard would silently take over.
+	//
+	// AllowConflicts is an optional field.
+	AllowConflicts bool
+
+	// Warn receives the warnings of AddPeer which do not fail it, such as an
+	// endpoint already used by another peer of the device (see Warning).
+	// Nil discards them.
+	//
+	// Warn is an optional field.
+	Warn WarnFunc
+
+	// BatchSize is the number of peers sent to the device per call,
+	// DefaultPeerBatchSize when 0. A smaller batch suits kernels with a
+	// small netlink buffer, a larger one saves round trips.
+	//
+	// BatchSize is an optional field.
+	BatchSize int
+
+	// Verify reads the device back after AddPeer and RemovePeer and fails
+	// with a VerificationError unless every peer exists with its allowed
+	// IPs, or is absent after the removal.
+	//
+	// Verify is an optional field.
+	Verify bool
+}
+
+// Method returns the private key to set, Secret when set, otherwise
+// PrivateKey. It is empty when a new key is to be generated.
+func (a UpdatePrivateKeyStructure) privateKey() Secret {
+	if !a.Secret.IsEmpty() {
+		return a.Secret
+	}
+	return NewSecret(a.PrivateKey)
 }
@@ -0,0 +1,133 @@
+package set
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/handlers"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// VerificationError reports a field of the device which, read back after
+// ConfigureDevice succeeded, does not hold the requested value: the device
+// silently ignored part of the configuration (e.g., a kernel module older
+// than the wgctrl message).
+type VerificationError struct {
+	Interface string
+	Field     string
+	Want      string
+	Got       string
+}
+
+// Method formats the error, naming the field.
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf(
+		"error: verification failed for network interface '%s': %s is %s, expected %s",
+		e.Interface, e.Field, e.Got, e.Want,
+	)
+}
+
+// Method returns the stable code of the error, see help.CodeVerification.
+func (e *VerificationError) ErrorCode() string {
+	return "BRG-E007"
+}
+
+// Function reads the device back for a verification.
+func verifiedDevice(client handlers.WgClient, interfaceName string) (*wgtypes.Device, error) {
+	device, err := client.Device(interfaceName)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"error: verification failed for network interface '%s': failed to read it: %v",
+			interfaceName, err,
+		)
+	}
+	return device, nil
+}
+
+// Function checks that the device listens on the port. Port 0 lets the
+// device pick one, any is accepted.
+func verifyPort(client handlers.WgClient, interfaceName string, port int) error {
+	device, err := verifiedDevice(client, interfaceName)
+	if err != nil {
+		return err
+	}
+	if port != 0 && device.ListenPort != port {
+		return &VerificationError{
+			Interface: interfaceName,
+			Field:     "listen port",
+			Want:      strconv.Itoa(port),
+			Got:       strconv.Itoa(device.ListenPort),
+		}
+	}
+	return nil
+}
+
+// Function checks that the public key of the device is the one of the
+// private key.
+func verifyPrivateKey(client handlers.WgClient, interfaceName string, key wgtypes.Key) error {
+	device, err := verifiedDevice(client, interfaceName)
+	if err != nil {
+		return err
+	}
+	if want := key.PublicKey(); device.PublicKey != want {
+		return &VerificationError{
+			Interface: interfaceName,
+			Field:     "public key",
+			Want:      want.String(),
+			Got:       device.PublicKey.String(),
+		}
+	}
+	return nil
+}
+
+// Function checks the peers of the configuration on the device: a removed
+// peer is absent, any other one exists with every requested allowed IP
+// (exactly these with ReplaceAllowedIPs).
+func verifyPeers(client handlers.WgClient, interfaceName string, peers []wgtypes.PeerConfig) error {
+	device, err := verifiedDevice(client, interfaceName)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[wgtypes.Key]*wgtypes.Peer, len(device.Peers))
+	for i := range device.Peers {
+		current[device.Peers[i].PublicKey] = &device.Peers[i]
+	}
+
+	for _, peer := range peers {
+		have, ok := current[peer.PublicKey]
+		field := fmt.Sprintf("peer '%s'", peer.PublicKey)
+
+		if peer.Remove {
+			if ok {
+				return &VerificationError{Interface: interfaceName, Field: field, Want: "absent", Got: "present"}
+			}
+			continue
+		}
+		if !ok {
+			return &VerificationError{Interface: interfaceName, Field: field, Want: "present", Got: "absent"}
+		}
+
+		got := make([]string, 0, len(have.AllowedIPs))
+		for _, ipNet := range have.AllowedIPs {
+			got = append(got, ipNet.String())
+		}
+		want := make([]string, 0, len(peer.AllowedIPs))
+		for _, ipNet := range peer.AllowedIPs {
+			want = append(want, ipNet.String())
+		}
+
+		missing := slices.ContainsFunc(want, func(ipNet string) bool { return !slices.Contains(got, ipNet) })
+		if missing || (peer.ReplaceAllowedIPs && len(got) != len(want)) {
+			return &VerificationError{
+				Interface: interfaceName,
+				Field:     "allowed IPs of " + field,
+				Want:      "[" + strings.Join(want, ", ") + "]",
+				Got:       "[" + strings.Join(got, ", ") + "]",
+			}
+		}
+	}
+	return nil
+}
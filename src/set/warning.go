@@ -0,0 +1,118 @@
+package set
+
+import (
+	"fmt"
+	"net"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Codes of the warnings, listed with the error codes in the catalogue of
+// the utilities (see help.Catalogue). A code is never reused.
+const (
+	// The endpoint of a peer is already used by another peer.
+	WarnDuplicateEndpoint = "BRG-W001"
+
+	// An allowed IP of a peer is covered by a broader allowed IP of the
+	// same peer.
+	WarnAllowedIPCovered = "BRG-W002"
+
+	// The listen port was set with force while used by another WireGuard
+	// interface or UDP socket.
+	WarnPortInUse = "BRG-W003"
+
+	// The firewall rule to add was already in place, it was left as is.
+	WarnRuleExists = "BRG-W004"
+
+	// The private key changed while the interface has peers, which keep
+	// the old public key until updated.
+	WarnPeersKeepOldKey = "BRG-W005"
+
+	// The rule was added with force through a network interface without
+	// an address of its family (IPv4, IPv6), so it can never match.
+	WarnFamilyMismatch = "BRG-W006"
+)
+
+// Warning is a condition an operation reports without failing, e.g., an
+// endpoint shared by two peers.
+type Warning struct {
+	// Code is the stable code of the warning, e.g., WarnDuplicateEndpoint.
+	Code string `json:"code"`
+
+	// Message describes the warning.
+	Message string `json:"message"`
+
+	// Interface is the network interface of the operation.
+	Interface string `json:"interface,omitempty"`
+
+	// Fields holds the context of the warning (e.g., "peer", "endpoint").
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Method returns the message of the warning.
+func (w Warning) String() string {
+	return w.Message
+}
+
+// WarnFunc receives the warnings of an operation, which goes on. A nil
+// WarnFunc discards them.
+type WarnFunc func(Warning)
+
+// Method passes the warning on, unless f is nil.
+func (f WarnFunc) warn(w Warning) {
+	if f != nil {
+		f(w)
+	}
+}
+
+// Function warns about the allowed IPs of each configured peer covered by
+// a broader allowed IP of the same peer (e.g., 10.0.0.2/32 with
+// 10.0.0.0/24). WireGuard accepts them, but the narrower one is redundant,
+// usually a typo in the prefix length.
+func warnCoveredAllowedIPs(warn WarnFunc, interfaceName string, config wgtypes.Config) {
+	if warn == nil {
+		return
+	}
+
+	for _, peer := range config.Peers {
+		for i, narrow := range peer.AllowedIPs {
+			if broad, ok := coveringPrefix(peer.AllowedIPs, i); ok {
+				warn(Warning{
+					Code: WarnAllowedIPCovered,
+					Message: fmt.Sprintf(
+						"allowed IP '%s' of peer '%s' is covered by its allowed IP '%s'",
+						narrow.String(), peer.PublicKey, broad.String(),
+					),
+					Interface: interfaceName,
+					Fields: map[string]string{
+						"peer":       peer.PublicKey.String(),
+						"allowed_ip": narrow.String(),
+						"covered_by": broad.String(),
+					},
+				})
+			}
+		}
+	}
+}
+
+// Function returns the first prefix of the list, other than the one at
+// index, which contains it: a broader prefix, or the same one listed
+// earlier.
+func coveringPrefix(prefixes []net.IPNet, index int) (net.IPNet, bool) {
+	narrow := prefixes[index]
+	narrowOnes, narrowBits := narrow.Mask.Size()
+
+	for i, broad := range prefixes {
+		if i == index {
+			continue
+		}
+		ones, bits := broad.Mask.Size()
+		if bits != narrowBits || ones > narrowOnes || (ones == narrowOnes && i > index) {
+			continue
+		}
+		if broad.Contains(narrow.IP) {
+			return broad, true
+		}
+	}
+	return net.IPNet{}, false
+}
@@ -0,0 +1,279 @@
+// Package spec contains the declarative specification of a network
+// interface, brought up from a single file by brgaddwg -spec: the device
+// settings, its addresses, forwarding, baseline firewall rules and initial
+// peers. The peers and NAT entries are shared with the desired state of
+// the reconcile package.
+package spec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/AlexKira/brgnetuse/internal/help"
+	"github.com/AlexKira/brgnetuse/src/validate"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Spec is the specification of a network interface. The file is JSON,
+// which YAML 1.2 parsers also read, and the YAML names of the fields are
+// the JSON ones.
+//
+// Example:
+//
+//	{
+//	  "name": "wg0",
+//	  "mtu": 1420,
+//	  "listen_port": 51820,
+//	  "private_key_file": "wg0.key",
+//	  "addresses": ["10.10.10.254/24"],
+//	  "forwarding": true,
+//	  "open_port": true,
+//	  "nat": [{"subnet": "10.10.10.0/24", "out_interface": "eth0"}],
+//	  "peers": [{"public_key": "AAAA...=", "allowed_ips": ["10.10.10.2/32"]}]
+//	}
+type Spec struct {
+	// Name is the network interface name.
+	Name string `json:"name" yaml:"name"`
+
+	// MTU of the device, device.DefaultMTU when 0 (see validate.CheckMTU).
+	MTU int `json:"mtu,omitempty" yaml:"mtu,omitempty"`
+
+	// ListenPort is the UDP port of the device, a random one when 0.
+	ListenPort int `json:"listen_port,omitempty" yaml:"listen_port,omitempty"`
+
+	// PrivateKeyFile is the file holding the private key (base64 encoded),
+	// relative to the spec file. Without one, the device has no key until
+	// it is set (e.g., brgsetwg -u -pk).
+	PrivateKeyFile string `json:"private_key_file,omitempty" yaml:"private_key_file,omitempty"`
+
+	// Addresses lists the IP addresses in CIDR notation.
+	Addresses []string `json:"addresses,omitempty" yaml:"addresses,omitempty"`
+
+	// Forwarding enables the IPv4 forwarding, and the IPv6 one when an
+	// address is IPv6.
+	Forwarding bool `json:"forwarding,omitempty" yaml:"forwarding,omitempty"`
+
+	// OpenPort opens the listen port in the INPUT chain (brgaddwg -fr).
+	OpenPort bool `json:"open_port,omitempty" yaml:"open_port,omitempty"`
+
+	// NAT lists the subnets masqueraded through an outgoing interface,
+	// with the FORWARD rules between the two interfaces.
+	NAT []NAT `json:"nat,omitempty" yaml:"nat,omitempty"`
+
+	// Peers lists the initial peers.
+	Peers []Peer `json:"peers,omitempty" yaml:"peers,omitempty"`
+}
+
+// Peer is a peer of a network interface.
+type Peer struct {
+	// PublicKey is the public key of the peer (base64 encoded).
+	PublicKey string `json:"public_key" yaml:"public_key"`
+
+	// AllowedIPs lists the allowed IP networks in CIDR notation.
+	AllowedIPs []string `json:"allowed_ips" yaml:"allowed_ips"`
+
+	// Endpoint and PersistentKeepalive are set when the peer is added or
+	// updated, they are not compared.
+	Endpoint            string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	PersistentKeepalive int    `json:"persistent_keepalive,omitempty" yaml:"persistent_keepalive,omitempty"`
+}
+
+// NAT is a subnet masqueraded through an outgoing network interface.
+type NAT struct {
+	// Subnet is the source subnet in CIDR notation (e.g., "10.10.10.0/24").
+	Subnet string `json:"subnet" yaml:"subnet"`
+
+	// OutInterface is the outgoing network interface (e.g., "eth0").
+	OutInterface string `json:"out_interface" yaml:"out_interface"`
+}
+
+// Function reads and validates the spec file (see Spec). A relative
+// private key file is made relative to the directory of the spec file.
+//
+// Usage example:
+//
+//	s, err := spec.Load("/etc/brgnetuse/wg0.json")
+//	if err != nil {
+//	    // Handle error
+//	}
+func Load(path string) (Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Spec{}, help.Errorf(help.CodeFileFailed, "error: failed to read spec file '%s': %v", path, err)
+	}
+
+	s, err := Parse(data)
+	if err != nil {
+		return Spec{}, err
+	}
+	if s.PrivateKeyFile != "" && !filepath.IsAbs(s.PrivateKeyFile) {
+		s.PrivateKeyFile = filepath.Join(filepath.Dir(path), s.PrivateKeyFile)
+	}
+	return s, nil
+}
+
+// Function decodes and validates a spec in JSON format. Unknown fields are
+// rejected, so a typo does not silently drop a setting. Every problem of
+// the spec is reported, see Check.
+func Parse(data []byte) (Spec, error) {
+	s, problems := parse(data)
+	if len(problems) > 0 {
+		return Spec{}, help.WithCode(help.CodeInvalidFile, validate.JoinProblems(problems))
+	}
+	return s, nil
+}
+
+// Function decodes and checks a spec in JSON format without applying it,
+// and returns every problem found with its line.
+func Check(data []byte) []validate.Problem {
+	_, problems := parse(data)
+	return problems
+}
+
+// Function decodes a spec and returns it with its problems.
+func parse(data []byte) (Spec, []validate.Problem) {
+	var s Spec
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&s); err != nil {
+		offset := decoder.InputOffset()
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			offset = syntaxErr.Offset
+		}
+		return Spec{}, []validate.Problem{{
+			Line: bytes.Count(data[:min(int(offset), len(data))], []byte("\n")) + 1,
+			Err:  fmt.Errorf("error: invalid spec file, %v", err),
+		}}
+	}
+
+	return s, s.validate(data)
+}
+
+// Method checks the spec with the validators of the utilities and returns
+// every problem found.
+func (s *Spec) validate(data []byte) []validate.Problem {
+	var problems []validate.Problem
+	report := func(value string, err error) {
+		problems = append(problems, validate.Problem{Line: LineOf(data, value), Err: err})
+	}
+
+	if err := validate.CheckInterfaceName(s.Name); err != nil {
+		report(s.Name, err)
+	}
+	if s.MTU != 0 {
+		if err := validate.CheckMTU(s.MTU, false); err != nil {
+			report("mtu", err)
+		}
+	}
+	if s.ListenPort < 0 || s.ListenPort > 65535 {
+		report("listen_port", fmt.Errorf("error: listen port %d is out of valid range (0-65535)", s.ListenPort))
+	}
+	if s.OpenPort && s.ListenPort == 0 {
+		report("open_port", errors.New("error: open_port requires the listen_port"))
+	}
+
+	return append(problems, CheckMembers(data, s.Name, s.Addresses, s.Peers, s.NAT)...)
+}
+
+// Function checks the addresses, peers and NAT entries of a network
+// interface and returns every problem found. The line of a problem is the
+// first line of data holding the offending value, 0 when it is not found.
+func CheckMembers(data []byte, iface string, addresses []string, peers []Peer, nat []NAT) []validate.Problem {
+	var problems []validate.Problem
+	report := func(value string, format string, args ...any) {
+		problems = append(problems, validate.Problem{
+			Line: LineOf(data, value),
+			Err:  fmt.Errorf("error: "+format, args...),
+		})
+	}
+
+	for _, addr := range addresses {
+		if _, err := netip.ParsePrefix(addr); err != nil {
+			report(addr, "interface '%s' has an invalid address '%s'", iface, addr)
+		}
+	}
+
+	specs := make([]validate.PeerSpec, 0, len(peers))
+	for _, peer := range peers {
+		specs = append(specs, validate.PeerSpec{
+			Line:       LineOf(data, peer.PublicKey),
+			PublicKey:  peer.PublicKey,
+			AllowedIPs: peer.AllowedIPs,
+			Endpoint:   peer.Endpoint,
+		})
+		if peer.PersistentKeepalive < 0 || peer.PersistentKeepalive > validate.MaxKeepalive {
+			report(peer.PublicKey,
+				"peer '%s' on interface '%s' has an invalid persistent_keepalive %d, expected 0-%d",
+				peer.PublicKey, iface, peer.PersistentKeepalive, validate.MaxKeepalive,
+			)
+		}
+	}
+	for _, problem := range validate.CheckPeers(specs) {
+		problem.Err = fmt.Errorf("error: interface '%s', %s", iface, strings.TrimPrefix(problem.Err.Error(), "error: "))
+		problems = append(problems, problem)
+	}
+
+	for _, entry := range nat {
+		if _, err := netip.ParsePrefix(entry.Subnet); err != nil {
+			report(entry.Subnet, "interface '%s' has an invalid NAT subnet '%s'", iface, entry.Subnet)
+		}
+		if entry.OutInterface == "" {
+			report(entry.Subnet, "NAT of '%s' on interface '%s' has no out_interface", entry.Subnet, iface)
+		}
+	}
+	return problems
+}
+
+// Function returns the first line of data holding the value as a JSON
+// string, or as a JSON key, 0 when the value is empty or not found.
+func LineOf(data []byte, value string) int {
+	if value == "" {
+		return 0
+	}
+	quoted, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	indx := bytes.Index(data, quoted)
+	if indx < 0 {
+		return 0
+	}
+	return bytes.Count(data[:indx], []byte("\n")) + 1
+}
+
+// Method reads the private key of PrivateKeyFile, the zero key and false
+// without a file.
+func (s Spec) PrivateKey() (wgtypes.Key, bool, error) {
+	if s.PrivateKeyFile == "" {
+		return wgtypes.Key{}, false, nil
+	}
+
+	data, err := os.ReadFile(s.PrivateKeyFile)
+	if err != nil {
+		return wgtypes.Key{}, false, help.Errorf(help.CodeFileFailed, "error: failed to read private key file '%s': %v", s.PrivateKeyFile, err)
+	}
+	defer clear(data)
+
+	key, err := wgtypes.ParseKey(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return wgtypes.Key{}, false, help.Errorf(help.CodeInvalidFile, "error: invalid private key in file '%s'", s.PrivateKeyFile)
+	}
+	return key, true, nil
+}
+
+// Method reports whether an address of the spec is IPv6.
+func (s Spec) HasIPv6() bool {
+	return slices.ContainsFunc(s.Addresses, func(addr string) bool {
+		prefix, err := netip.ParsePrefix(addr)
+		return err == nil && prefix.Addr().Is6()
+	})
+}
@@ -0,0 +1,138 @@
+package spec
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Flag rewriting the golden files with the problems found.
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+// Testing the validation of the spec files of testdata against their golden
+// files: a `bad_` spec lists its problems in `<name>.golden`, one formatted
+// problem per line, any other spec must be valid.
+func TestCheckGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("error: no spec files in testdata")
+	}
+
+	for _, file := range files {
+		name := filepath.Base(file)
+		t.Run(strings.TrimSuffix(name, ".json"), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var lines []string
+			for _, problem := range Check(data) {
+				lines = append(lines, problem.Format(name))
+			}
+			got := strings.Join(lines, "\n")
+
+			if !strings.HasPrefix(name, "bad_") {
+				if got != "" {
+					t.Errorf("error: valid spec reported problems:\n%s", got)
+				}
+				return
+			}
+
+			golden := strings.TrimSuffix(file, ".json") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got+"\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == "" || got != strings.TrimSuffix(string(want), "\n") {
+				t.Errorf("error: got problems:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+// Testing that Parse returns the spec of a valid file and fails with every
+// problem of an invalid one.
+func TestParse(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "full.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Parse(data)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if s.Name != "wg0" || s.MTU != 1420 || s.ListenPort != 51820 || !s.OpenPort || !s.Forwarding {
+		t.Errorf("error: got device settings %+v", s)
+	}
+	if len(s.Peers) != 2 || s.Peers[0].PersistentKeepalive != 25 || len(s.NAT) != 1 {
+		t.Errorf("error: got peers %+v and NAT %+v", s.Peers, s.NAT)
+	}
+	if !s.HasIPv6() {
+		t.Error("error: expected an IPv6 address")
+	}
+
+	_, err = Parse([]byte(`{"name": "wg/0", "mtu": 1}`))
+	if err == nil || !strings.Contains(err.Error(), "invalid character '/'") || !strings.Contains(err.Error(), "MTU value 1") {
+		t.Errorf("error: got %v, want the name and MTU problems", err)
+	}
+}
+
+// Testing that Load makes the private key file relative to the spec file,
+// and that PrivateKey reads it.
+func TestLoadPrivateKey(t *testing.T) {
+	dir := t.TempDir()
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "wg0.json")
+	if err := os.WriteFile(path, []byte(`{"name": "wg0", "private_key_file": "wg0.key"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("error: unexpected error: %v", err)
+	}
+	if want := filepath.Join(dir, "wg0.key"); s.PrivateKeyFile != want {
+		t.Errorf("error: got key file %q, want %q", s.PrivateKeyFile, want)
+	}
+
+	if _, _, err := s.PrivateKey(); err == nil || !strings.Contains(err.Error(), "failed to read private key file") {
+		t.Errorf("error: got %v, want a read error", err)
+	}
+
+	if err := os.WriteFile(s.PrivateKeyFile, []byte(key.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := s.PrivateKey()
+	if err != nil || !ok || got != key {
+		t.Errorf("error: got key %v, %v, %v", ok, err, got == key)
+	}
+
+	if err := os.WriteFile(s.PrivateKeyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.PrivateKey(); err == nil || !strings.Contains(err.Error(), "invalid private key") {
+		t.Errorf("error: got %v, want an invalid key error", err)
+	}
+
+	if _, ok, err := (Spec{Name: "wg0"}).PrivateKey(); ok || err != nil {
+		t.Errorf("error: got %v, %v without a key file", ok, err)
+	}
+}
@@ -0,0 +1,274 @@
+package validate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Columns of a peer list in CSV format, see CheckPeerList. The columns
+// after the allowed IPs are optional.
+var PeerListColumns = []string{"public_key", "allowed_ips", "endpoint", "persistent_keepalive", "name", "expires"}
+
+// Function checks a peer list in CSV format and returns every problem
+// found with its line. The columns are PeerListColumns, the first line
+// may be their header; the allowed IPs are separated by spaces or
+// semicolons (or commas in a quoted field), lines starting with '#' are
+// comments. The peers are checked with CheckPeers.
+//
+// Example:
+//
+//	public_key,allowed_ips,endpoint,persistent_keepalive,name,expires
+//	xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=,10.10.10.2/32;fd00::2/128,,25,alice,2025-07-01
+func CheckPeerList(data []byte) []Problem {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	var problems []Problem
+	var peers []PeerSpec
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			problems = append(problems, Problem{
+				Line: parseErr.Line,
+				Err:  fmt.Errorf("error: invalid CSV, %v", parseErr.Err),
+			})
+			continue
+		}
+		if err != nil {
+			problems = append(problems, Problem{Err: fmt.Errorf("error: invalid CSV, %v", err)})
+			break
+		}
+
+		line, _ := reader.FieldPos(0)
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), PeerListColumns[0]) {
+			continue
+		}
+		if len(record) > len(PeerListColumns) {
+			problems = append(problems, Problem{Line: line, Err: fmt.Errorf(
+				"error: %d fields, expected at most %d: %s",
+				len(record), len(PeerListColumns), strings.Join(PeerListColumns, ","),
+			)})
+			continue
+		}
+
+		record = append(record, make([]string, len(PeerListColumns)-len(record))...)
+		peers = append(peers, PeerSpec{
+			Line:       line,
+			PublicKey:  strings.TrimSpace(record[0]),
+			AllowedIPs: splitList(record[1], " ;,"),
+			Endpoint:   strings.TrimSpace(record[2]),
+			Keepalive:  strings.TrimSpace(record[3]),
+			Expires:    strings.TrimSpace(record[5]),
+		})
+	}
+
+	return append(problems, CheckPeers(peers)...)
+}
+
+// Keys of the sections of a wg-quick configuration, mapped to their
+// check. A nil check accepts any value (e.g., the PostUp commands).
+var (
+	wgQuickInterfaceKeys = map[string]func(string) error{
+		"privatekey": checkPrivateKeyValue,
+		"listenport": checkListenPort,
+		"fwmark":     checkFwMark,
+		"address":    checkPrefixList,
+		"dns":        nil,
+		"mtu":        checkMTUValue,
+		"table":      nil,
+		"preup":      nil,
+		"postup":     nil,
+		"predown":    nil,
+		"postdown":   nil,
+		"saveconfig": nil,
+		// AmneziaWG obfuscation parameters.
+		"jc": checkNumber, "jmin": checkNumber, "jmax": checkNumber,
+		"s1": checkNumber, "s2": checkNumber,
+		"h1": checkNumber, "h2": checkNumber, "h3": checkNumber, "h4": checkNumber,
+	}
+	wgQuickPeerKeys = []string{"publickey", "presharedkey", "allowedips", "endpoint", "persistentkeepalive"}
+)
+
+// Function checks a wg-quick configuration (wg-quick(8), also the
+// AmneziaWG awg-quick format) and returns every problem found with its
+// line: the syntax, the keys and values of the [Interface] section, and
+// the [Peer] sections with CheckPeers.
+func CheckWgQuick(data []byte) []Problem {
+	var problems []Problem
+	var peers []PeerSpec
+	var peer *PeerSpec
+
+	report := func(line int, format string, args ...any) {
+		problems = append(problems, Problem{Line: line, Err: fmt.Errorf("error: "+format, args...)})
+	}
+
+	section := ""
+	interfaceLine, privateKey := 0, false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section = strings.ToLower(strings.TrimSpace(text[1 : len(text)-1]))
+			switch section {
+			case "interface":
+				if interfaceLine != 0 {
+					report(line, "section [Interface] is given twice (first on line %d)", interfaceLine)
+				}
+				interfaceLine = line
+			case "peer":
+				peers = append(peers, PeerSpec{Line: line})
+				peer = &peers[len(peers)-1]
+			default:
+				report(line, "unknown section %s, expected [Interface] or [Peer]", text)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			report(line, "invalid line '%s', expected `Key = Value`", text)
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+
+		switch section {
+		case "interface":
+			check, known := wgQuickInterfaceKeys[key]
+			if !known {
+				report(line, "unknown key '%s' in section [Interface]", strings.TrimSpace(text[:strings.Index(text, "=")]))
+				continue
+			}
+			if key == "privatekey" {
+				privateKey = true
+			}
+			if check != nil {
+				if err := check(value); err != nil {
+					problems = append(problems, Problem{Line: line, Err: err})
+				}
+			}
+		case "peer":
+			switch key {
+			case "publickey":
+				peer.PublicKey = value
+			case "presharedkey":
+				peer.PresharedKey = value
+			case "allowedips":
+				peer.AllowedIPs = append(peer.AllowedIPs, splitList(value, ",")...)
+			case "endpoint":
+				peer.Endpoint = value
+			case "persistentkeepalive":
+				if value != "off" {
+					peer.Keepalive = value
+				}
+			default:
+				report(line, "unknown key '%s' in section [Peer], expected one of: %s",
+					strings.TrimSpace(text[:strings.Index(text, "=")]), strings.Join(wgQuickPeerKeys, ", "))
+			}
+		case "":
+			report(line, "key outside of a section, expected [Interface] or [Peer] first")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		report(0, "failed to read the configuration, %v", err)
+	}
+
+	switch {
+	case interfaceLine == 0:
+		report(0, "section [Interface] is missing")
+	case !privateKey:
+		report(interfaceLine, "section [Interface] has no PrivateKey")
+	}
+
+	return append(problems, CheckPeers(peers)...)
+}
+
+// Function splits a list on any of the separators, the empty items dropped.
+func splitList(value, separators string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(separators, r) }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Function checks a private key. The error never contains the key.
+func checkPrivateKeyValue(value string) error {
+	if _, err := wgtypes.ParseKey(value); err != nil {
+		return fmt.Errorf("error: invalid private key, expected 44 base64 characters ending with '='")
+	}
+	return nil
+}
+
+// Function checks a listen port, 1-65535.
+func checkListenPort(value string) error {
+	port, err := CheckPort(value)
+	if err != nil {
+		return err
+	}
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("error: invalid listen port %d, expected range 1-65535", port)
+	}
+	return nil
+}
+
+// Function checks a firewall mark: a number, decimal or hexadecimal, or
+// "off".
+func checkFwMark(value string) error {
+	if value == "off" {
+		return nil
+	}
+	if _, err := strconv.ParseUint(value, 0, 32); err != nil {
+		return fmt.Errorf("error: invalid FwMark '%s', expected a 32-bit number or 'off'", value)
+	}
+	return nil
+}
+
+// Function checks a comma separated list of addresses in CIDR notation.
+func checkPrefixList(value string) error {
+	for _, item := range splitList(value, ",") {
+		if _, err := netip.ParsePrefix(item); err != nil {
+			return fmt.Errorf("error: invalid address '%s', expected CIDR notation (e.g., 10.10.10.1/24)", item)
+		}
+	}
+	return nil
+}
+
+// Function checks an MTU, within the range accepted with force (see
+// CheckMTU).
+func checkMTUValue(value string) error {
+	mtu, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("error: invalid MTU '%s', expected a number", value)
+	}
+	return CheckMTU(mtu, true)
+}
+
+// Function checks a non-negative number.
+func checkNumber(value string) error {
+	if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+		return fmt.Errorf("error: invalid value '%s', expected a number", value)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Problem is an error found in a file, at a line (1-based, 0 when the
+// line is not known).
+type Problem struct {
+	Line int
+	Err  error
+}
+
+// Method formats the problem with its location, `file:line: message`, the
+// "error: " prefix of the message dropped.
+func (p Problem) Format(file string) string {
+	message := strings.TrimPrefix(p.Err.Error(), "error: ")
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", file, p.Line, message)
+	}
+	return fmt.Sprintf("%s: %s", file, message)
+}
+
+// Function returns the errors of the problems joined, nil without problems.
+func JoinProblems(problems []Problem) error {
+	errs := make([]error, 0, len(problems))
+	for _, problem := range problems {
+		errs = append(errs, problem.Err)
+	}
+	return errors.Join(errs...)
+}
+
+// PeerSpec is a peer of a network interface as given in a file (state
+// file, peer list, wg-quick configuration), checked by CheckPeers. The
+// fields left empty are not checked, the allowed IPs excepted.
+type PeerSpec struct {
+	// Line is the line of the peer in its file, 0 when not known.
+	Line int
+
+	// PublicKey and PresharedKey are base64 encoded.
+	PublicKey    string
+	PresharedKey string
+
+	// AllowedIPs lists the allowed IP networks in CIDR notation.
+	AllowedIPs []string
+
+	// Endpoint is `ip:port` or `host-name:port`, see CheckEndpoint.
+	Endpoint string
+
+	// Keepalive is the persistent keepalive interval, see CheckKeepalive.
+	Keepalive string
+
+	// Expires is the peer expiry, see CheckExpiry.
+	Expires string
+}
+
+// Function reports whether the endpoint is given by host name rather than
+// by IP address (e.g., `vpn.example.com:51820`).
+func IsHostEndpoint(endpoint string) bool {
+	host, _, err := net.SplitHostPort(endpoint)
+	return err == nil && host != "" && net.ParseIP(host) == nil
+}
+
+// Function checks an endpoint given by IP address (see CheckEndPoint) or
+// by host name (see CheckEndpointHost). The host name is not resolved.
+func CheckEndpoint(endpoint string) error {
+	if IsHostEndpoint(endpoint) {
+		_, _, err := CheckEndpointHost(endpoint)
+		return err
+	}
+	_, err := CheckEndPoint(endpoint)
+	return err
+}
+
+// Function checks the peers of a network interface and returns every
+// problem found, not only the first: the keys, the allowed IPs, the
+// endpoint, the keepalive and the expiry of each peer, the peers listed
+// twice and the allowed IPs claimed by two peers (WireGuard routes an
+// address to a single peer).
+//
+// Usage example:
+//
+//	for _, problem := range validate.CheckPeers(peers) {
+//	    fmt.Println(problem.Format("peers.csv"))
+//	}
+func CheckPeers(peers []PeerSpec) []Problem {
+	type owned struct {
+		key    string
+		prefix netip.Prefix
+	}
+
+	var problems []Problem
+	var taken []owned
+	seen := make(map[string]int)
+
+	for _, peer := range peers {
+		report := func(format string, args ...any) {
+			problems = append(problems, Problem{Line: peer.Line, Err: fmt.Errorf("error: "+format, args...)})
+		}
+
+		if _, err := wgtypes.ParseKey(peer.PublicKey); err != nil {
+			report("invalid public key '%s'", peer.PublicKey)
+		} else if line, ok := seen[peer.PublicKey]; ok {
+			report("peer '%s' is listed twice%s", peer.PublicKey, lineSuffix(line))
+		} else {
+			seen[peer.PublicKey] = peer.Line
+		}
+
+		if peer.PresharedKey != "" {
+			if _, err := wgtypes.ParseKey(peer.PresharedKey); err != nil {
+				report("peer '%s' has an invalid preshared key", peer.PublicKey)
+			}
+		}
+
+		if len(peer.AllowedIPs) == 0 {
+			report("peer '%s' has no allowed IPs", peer.PublicKey)
+		}
+		var prefixes []netip.Prefix
+		for _, value := range peer.AllowedIPs {
+			prefix, err := netip.ParsePrefix(strings.TrimSpace(value))
+			if err != nil {
+				report("peer '%s' has an invalid allowed IP '%s', expected CIDR notation (e.g., 10.10.10.2/32)",
+					peer.PublicKey, value)
+				continue
+			}
+			prefixes = append(prefixes, prefix.Masked())
+		}
+		for _, prefix := range prefixes {
+			for _, other := range taken {
+				if other.key != peer.PublicKey && prefix.Overlaps(other.prefix) {
+					report("allowed IP '%s' of peer '%s' conflicts with '%s' of peer '%s'",
+						prefix, peer.PublicKey, other.prefix, other.key)
+				}
+			}
+		}
+		for _, prefix := range prefixes {
+			taken = append(taken, owned{key: peer.PublicKey, prefix: prefix})
+		}
+
+		for _, check := range []struct {
+			value string
+			fn    func(string) error
+		}{
+			{peer.Endpoint, CheckEndpoint},
+			{peer.Keepalive, func(v string) error { _, err := CheckKeepalive(v); return err }},
+			{peer.Expires, func(v string) error { _, err := CheckExpiry(v); return err }},
+		} {
+			if check.value == "" {
+				continue
+			}
+			if err := check.fn(check.value); err != nil {
+				problems = append(problems, Problem{Line: peer.Line, Err: err})
+			}
+		}
+	}
+	return problems
+}
+
+// Function returns ` (first on line N)` pointing at an earlier line, empty
+// when the line is not known.
+func lineSuffix(line int) string {
+	if line == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (first on line %d)", line)
+}
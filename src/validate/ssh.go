@@ -0,0 +1,61 @@
+package validate
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// Environment variables of an SSH session, set by sshd for the login shell.
+const (
+	// Client and server addresses and ports: "10.0.0.2 51234 10.0.0.1 22".
+	EnvSSHConnection string = "SSH_CONNECTION"
+
+	// Client address and port, and server port: "10.0.0.2 51234 22".
+	EnvSSHClient string = "SSH_CLIENT"
+)
+
+// Function returns the address of the SSH client of the session described
+// by the environment, from SSH_CONNECTION or else SSH_CLIENT. It reports
+// false outside an SSH session or when the variables are not understood.
+func SSHClientAddr(env map[string]string) (netip.Addr, bool) {
+	for _, name := range []string{EnvSSHConnection, EnvSSHClient} {
+		fields := strings.Fields(env[name])
+		if len(fields) < 3 {
+			continue
+		}
+
+		// A link-local client carries its zone (e.g., "fe80::1%eth0").
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			continue
+		}
+		return addr.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// Function reports whether the SSH session described by the environment
+// comes from one of the networks (e.g., the allowed IPs of the peers of a
+// WireGuard interface), so it is carried by that interface. It returns the
+// client address of a routed session. The check fails open: outside an SSH
+// session, or with unparsable variables, the session is not routed.
+//
+// Usage example:
+//
+//	if client, ok := validate.SSHSessionRouted(help.Environ(), prefixes); ok {
+//	    // Ask for confirmation
+//	}
+func SSHSessionRouted(env map[string]string, networks []netip.Prefix) (netip.Addr, bool) {
+	client, ok := SSHClientAddr(env)
+	if !ok {
+		return netip.Addr{}, false
+	}
+
+	addr := client.WithZone("")
+	for _, network := range networks {
+		if network.Contains(addr) {
+			return client, true
+		}
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,443 @@
+// Package validate checks the values given to the WireGuard and
+// AmneziaWG devices: ports, endpoints, allowed IPs, interface names, MTUs,
+// rates and DNS servers, and the SSH session carried by an interface. The
+// peers given in a file (peer list, wg-quick configuration) are checked
+// as a whole, every problem reported with its line (see CheckPeers). The
+// errors describe the invalid value and the expected format, they are
+// shown as is by the command line utilities.
+package validate
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Function converts a port string to an integer.
+// It returns an error if the string is not a valid number.
+func CheckPort(port string) (int, error) {
+
+	portInt, err := strconv.Atoi(port)
+	if err != nil {
+		return 0, fmt.Errorf(
+			"error: invalid port value, port must be a valid number, %w",
+			err,
+		)
+	}
+
+	return portInt, nil
+}
+
+// Upper bound of the persistent keepalive interval in seconds, WireGuard
+// keeps it in 16 bits.
+const MaxKeepalive int = 65535
+
+// Function converts a persistent keepalive interval in seconds to a
+// duration. The interval must be in the range 0-65535, 0 disables it.
+func CheckKeepalive(value string) (time.Duration, error) {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 || seconds > MaxKeepalive {
+		return 0, fmt.Errorf(
+			"error: invalid persistent keepalive interval '%s', expected seconds in range 0-%d",
+			value, MaxKeepalive,
+		)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// Function to check the endpoint IP address. The port must be in the
+// range 1-65535.
+func CheckEndPoint(host string) (*net.UDPAddr, error) {
+	data := strings.Split(host, ":")
+
+	if len(data) != 2 {
+		return nil, fmt.Errorf(
+			"error: invalid endpoint format '%s', expected format: "+
+				"`IP-address:port` (e.g., `89.89.89.1:51820`",
+			host,
+		)
+	}
+
+	port, err := CheckPort(data[1])
+	if err != nil {
+		return nil, err
+	}
+	if port == 0 {
+		return nil, fmt.Errorf(
+			"error: invalid endpoint port 0 in '%s', the peer cannot be reached on it, "+
+				"expected range 1-65535",
+			host,
+		)
+	}
+	if port < 0 || port > 65535 {
+		return nil, fmt.Errorf(
+			"error: invalid endpoint port %d in '%s', expected range 1-65535",
+			port, host,
+		)
+	}
+
+	ip := net.ParseIP(data[0])
+	if ip == nil {
+		return nil, fmt.Errorf(
+			"error: invalid IPv4 address: '%s' "+
+				"example: `192.168.1.1`", data[0])
+	}
+
+	return &net.UDPAddr{
+		IP:   ip,
+		Port: port,
+	}, nil
+}
+
+// Host names of the endpoints (RFC 1123): dot separated labels of
+// letters, digits and hyphens, the last one not all digits.
+var hostnamePattern = regexp.MustCompile(
+	`^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)*[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.?$`,
+)
+
+// Function checks an endpoint given by host name, `host-name:port` (e.g.,
+// `vpn.example.com:51820`), and returns the host name and the port. The
+// port must be in the range 1-65535. An IP address is not a host name,
+// see CheckEndPoint.
+func CheckEndpointHost(endpoint string) (string, int, error) {
+	host, value, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", 0, fmt.Errorf(
+			"error: invalid endpoint format '%s', expected format: "+
+				"`host-name:port` (e.g., `vpn.example.com:51820`)",
+			endpoint,
+		)
+	}
+
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	last := labels[len(labels)-1]
+	if len(host) > 253 || !hostnamePattern.MatchString(host) ||
+		strings.Trim(last, "0123456789") == "" {
+		return "", 0, fmt.Errorf(
+			"error: invalid endpoint host name '%s', expected letters, digits, "+
+				"hyphens and dots (e.g., `vpn.example.com`)",
+			host,
+		)
+	}
+
+	port, err := CheckPort(value)
+	if err != nil {
+		return "", 0, err
+	}
+	if port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf(
+			"error: invalid endpoint port %d in '%s', expected range 1-65535",
+			port, endpoint,
+		)
+	}
+
+	return host, port, nil
+}
+
+// Function to check allowed IP addresses.
+func CheckAllowedIPs(ipAddr []string) ([]net.IPNet, error) {
+	allowIps := make([]net.IPNet, 0, len(ipAddr))
+
+	for _, ips := range ipAddr {
+		_, ipnet, err := net.ParseCIDR(ips)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"error: invalid CIDR format for allowed IP address '%s' "+
+					"example: 10.10.10.1/32",
+				ips,
+			)
+		}
+		allowIps = append(allowIps, *ipnet)
+	}
+
+	return allowIps, nil
+}
+
+// Function reports whether the prefixes overlap: the network of one of them
+// contains the network of the other. Prefixes of different address families
+// never overlap.
+func PrefixesOverlap(a, b net.IPNet) bool {
+	prefixA, ok := IPNetPrefix(a)
+	if !ok {
+		return false
+	}
+	prefixB, ok := IPNetPrefix(b)
+	if !ok {
+		return false
+	}
+	return prefixA.Overlaps(prefixB)
+}
+
+// Function converts the network to a masked netip.Prefix, false for a
+// malformed network.
+func IPNetPrefix(network net.IPNet) (netip.Prefix, bool) {
+	addr, ok := netip.AddrFromSlice(network.IP)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	ones, bits := network.Mask.Size()
+	if bits == 0 {
+		return netip.Prefix{}, false
+	}
+	// An IPv4 address may be stored in 16 bytes, the mask tells the family.
+	if bits == 32 {
+		addr = addr.Unmap()
+	}
+	if addr.BitLen() != bits {
+		return netip.Prefix{}, false
+	}
+	return netip.PrefixFrom(addr, ones).Masked(), true
+}
+
+// Function parses a peer expiry given either as RFC3339 (e.g.,
+// `2025-07-01T00:00:00Z`) or as a date (e.g., `2025-07-01`, midnight UTC).
+// The result is returned in UTC.
+func CheckExpiry(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t.UTC(), nil
+	}
+
+	return time.Time{}, fmt.Errorf(
+		"error: invalid expiry '%s', expected format: "+
+			"`2025-07-01T00:00:00Z` or `2025-07-01`",
+		value,
+	)
+}
+
+// Units of the rates accepted by CheckRate, in bits per second.
+var rateUnits = []struct {
+	suffix string
+	factor uint64
+}{
+	{"gbit", 1_000_000_000},
+	{"mbit", 1_000_000},
+	{"kbit", 1_000},
+	{"bit", 1},
+}
+
+// Function parses a bandwidth rate in the tc notation (e.g., `10mbit`,
+// `512kbit`, `1gbit`) and returns it in bits per second.
+func CheckRate(value string) (uint64, error) {
+	lower := strings.ToLower(value)
+	for _, unit := range rateUnits {
+		number, ok := strings.CutSuffix(lower, unit.suffix)
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.ParseUint(number, 10, 64)
+		if err != nil || n == 0 || n > (1<<63)/unit.factor {
+			break
+		}
+		return n * unit.factor, nil
+	}
+
+	return 0, fmt.Errorf(
+		"error: invalid rate '%s', expected a positive number with a unit: "+
+			"bit, kbit, mbit, gbit (e.g., 10mbit)",
+		value,
+	)
+}
+
+// Function formats a rate in bits per second with the largest exact unit
+// (e.g., 10000000 as `10mbit`).
+func FormatRate(bits uint64) string {
+	for _, unit := range rateUnits {
+		if bits != 0 && bits%unit.factor == 0 {
+			return fmt.Sprintf("%d%s", bits/unit.factor, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dbit", bits)
+}
+
+// Units of the sizes accepted by CheckSize, in bytes. The longer suffixes
+// come first, so `gib` is not read as `b`.
+var sizeUnits = []struct {
+	suffix string
+	factor uint64
+}{
+	{"tib", 1 << 40},
+	{"gib", 1 << 30},
+	{"mib", 1 << 20},
+	{"kib", 1 << 10},
+	{"tb", 1_000_000_000_000},
+	{"gb", 1_000_000_000},
+	{"mb", 1_000_000},
+	{"kb", 1_000},
+	{"b", 1},
+}
+
+// Function parses an amount of data (e.g., `50gb`, `512MiB`, `1tb`) and
+// returns it in bytes. The unit is case insensitive: kb, mb, gb and tb are
+// powers of 1000, kib, mib, gib and tib powers of 1024.
+func CheckSize(value string) (uint64, error) {
+	lower := strings.ToLower(value)
+	for _, unit := range sizeUnits {
+		number, ok := strings.CutSuffix(lower, unit.suffix)
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.ParseUint(number, 10, 64)
+		if err != nil || n == 0 || n > ^uint64(0)/unit.factor {
+			break
+		}
+		return n * unit.factor, nil
+	}
+
+	return 0, fmt.Errorf(
+		"error: invalid size '%s', expected a positive number with a unit: "+
+			"b, kb, mb, gb, tb or kib, mib, gib, tib (e.g., 50gb)",
+		value,
+	)
+}
+
+// Function formats a size in bytes with the largest exact unit (e.g.,
+// 50000000000 as `50gb`).
+func FormatSize(bytes uint64) string {
+	best := sizeUnits[len(sizeUnits)-1]
+	for _, unit := range sizeUnits {
+		if bytes != 0 && bytes%unit.factor == 0 && unit.factor > best.factor {
+			best = unit
+		}
+	}
+	return fmt.Sprintf("%d%s", bytes/best.factor, best.suffix)
+}
+
+// Function parses a period given in days (e.g., `30d`) or as a Go duration
+// (e.g., `12h`), of at least a minute.
+func CheckPeriod(value string) (time.Duration, error) {
+	var period time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n > 0 && n <= 3650 {
+			period = time.Duration(n) * 24 * time.Hour
+		}
+	} else if d, err := time.ParseDuration(value); err == nil {
+		period = d
+	}
+
+	if period < time.Minute {
+		return 0, fmt.Errorf(
+			"error: invalid period '%s', expected days or a duration of at least a minute (e.g., 30d, 12h)",
+			value,
+		)
+	}
+	return period, nil
+}
+
+// Function parses a comma separated list of DNS server addresses (e.g.,
+// `10.10.10.1,10.10.10.2`) and returns them without duplicates.
+func CheckDNSServers(value string) ([]string, error) {
+	var servers []string
+	for _, field := range strings.Split(value, ",") {
+		addr, err := netip.ParseAddr(strings.TrimSpace(field))
+		if err != nil || addr.Zone() != "" {
+			return nil, fmt.Errorf(
+				"error: invalid DNS server '%s', expected an IP address (e.g., 10.10.10.1)",
+				field,
+			)
+		}
+		if server := addr.String(); !slices.Contains(servers, server) {
+			servers = append(servers, server)
+		}
+	}
+	return servers, nil
+}
+
+// Maximum length of a network interface name: the kernel limit IFNAMSIZ
+// (16) includes the terminating zero.
+const InterfaceNameMaxLength = 15
+
+// Function validates a network interface name against the kernel rules
+// (1 to InterfaceNameMaxLength bytes, not "." or "..") and an allowlist
+// of characters: ASCII letters, digits, '-', '_' and '.'. The allowlist
+// excludes '/', ':' and whitespace refused by the kernel, '@' separating
+// the namespace in process tags, and the characters of shell commands.
+func CheckInterfaceName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("error: network interface name is empty")
+	case len(name) > InterfaceNameMaxLength:
+		return fmt.Errorf(
+			"error: network interface name '%s' is %d bytes long, the maximum is %d",
+			name,
+			len(name),
+			InterfaceNameMaxLength,
+		)
+	case name == "." || name == "..":
+		return fmt.Errorf("error: network interface name '%s' is reserved", name)
+	}
+
+	for _, char := range name {
+		switch {
+		case 'a' <= char && char <= 'z', 'A' <= char && char <= 'Z', '0' <= char && char <= '9':
+		case char == '-', char == '_', char == '.':
+		default:
+			return fmt.Errorf(
+				"error: invalid character %q in network interface name '%s', "+
+					"allowed are letters, digits, '-', '_' and '.' (e.g. wg0, wg-office)",
+				char,
+				name,
+			)
+		}
+	}
+	return nil
+}
+
+// Maximum length of a network interface alias: the kernel limit IFALIASZ
+// (256) includes the terminating zero.
+const AliasMaxLength = 255
+
+// Function validates a network interface alias: newlines are stripped, as
+// monitoring tools show the alias on a single line, and the alias must fit
+// the kernel limit. An empty alias clears it.
+func CheckAlias(value string) (string, error) {
+	alias := strings.NewReplacer("\r", "", "\n", "").Replace(value)
+	if len(alias) > AliasMaxLength {
+		return "", fmt.Errorf(
+			"error: alias is %d bytes long, the maximum is %d", len(alias), AliasMaxLength,
+		)
+	}
+	return alias, nil
+}
+
+// MTU range of the devices: 1280 is the minimum MTU of IPv6 (RFC 8200),
+// 9000 is the MTU of common jumbo frame links.
+const (
+	MinMTU int = 1280
+	MaxMTU int = 9000
+)
+
+// MTU range of the devices when forced for exotic links: 68 is the minimum
+// MTU of IPv4 (RFC 791), 65535 the maximum MTU of a TUN device.
+const (
+	MinForcedMTU int = 68
+	MaxForcedMTU int = 65535
+)
+
+// Function validates the MTU of a device, within MinMTU and MaxMTU, or
+// within MinForcedMTU and MaxForcedMTU with force.
+func CheckMTU(mtu int, force bool) error {
+	minMTU, maxMTU := MinMTU, MaxMTU
+	if force {
+		minMTU, maxMTU = MinForcedMTU, MaxForcedMTU
+	}
+	if mtu < minMTU || mtu > maxMTU {
+		return fmt.Errorf(
+			"error: MTU value %d is out of valid range (%d-%d)",
+			mtu,
+			minMTU,
+			maxMTU,
+		)
+	}
+	return nil
+}
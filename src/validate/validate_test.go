@@ -0,0 +1,650 @@
+package validate
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Testing the CheckRate and FormatRate functions.
+func TestCheckRate(t *testing.T) {
+	type testCase struct {
+		input     string
+		want      uint64
+		format    string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{input: "10mbit", want: 10_000_000, format: "10mbit"},
+		{input: "10Mbit", want: 10_000_000, format: "10mbit"},
+		{input: "1500kbit", want: 1_500_000, format: "1500kbit"},
+		{input: "1gbit", want: 1_000_000_000, format: "1gbit"},
+		{input: "64000bit", want: 64_000, format: "64kbit"},
+		{input: "10", wantError: true},
+		{input: "0mbit", wantError: true},
+		{input: "-1mbit", wantError: true},
+		{input: "10mbps", wantError: true},
+		{input: "mbit", wantError: true},
+		{input: "off", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := CheckRate(tc.input)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error, got %d", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got %d, want %d", got, tc.want)
+			}
+			if format := FormatRate(got); format != tc.format {
+				t.Errorf("error: got %q, want %q", format, tc.format)
+			}
+		})
+	}
+}
+
+// Testing the CheckSize and FormatSize functions.
+func TestCheckSize(t *testing.T) {
+	type testCase struct {
+		input     string
+		want      uint64
+		format    string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{input: "50gb", want: 50_000_000_000, format: "50gb"},
+		{input: "50GB", want: 50_000_000_000, format: "50gb"},
+		{input: "512MiB", want: 512 << 20, format: "512mib"},
+		{input: "1tib", want: 1 << 40, format: "1tib"},
+		{input: "1500b", want: 1500, format: "1500b"},
+		{input: "2000kb", want: 2_000_000, format: "2mb"},
+		{input: "10", wantError: true},
+		{input: "0gb", wantError: true},
+		{input: "-1gb", wantError: true},
+		{input: "gb", wantError: true},
+		{input: "1.5gb", wantError: true},
+		{input: "99999999999tb", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := CheckSize(tc.input)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error, got %d", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got %d, want %d", got, tc.want)
+			}
+			if format := FormatSize(got); format != tc.format {
+				t.Errorf("error: got %q, want %q", format, tc.format)
+			}
+		})
+	}
+}
+
+// Testing the CheckPeriod function.
+func TestCheckPeriod(t *testing.T) {
+	type testCase struct {
+		input     string
+		want      time.Duration
+		wantError bool
+	}
+
+	tests := []testCase{
+		{input: "30d", want: 30 * 24 * time.Hour},
+		{input: "12h", want: 12 * time.Hour},
+		{input: "1m", want: time.Minute},
+		{input: "30s", wantError: true},
+		{input: "0d", wantError: true},
+		{input: "-1d", wantError: true},
+		{input: "month", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := CheckPeriod(tc.input)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the CheckDNSServers function.
+func TestCheckDNSServers(t *testing.T) {
+	type testCase struct {
+		input     string
+		want      []string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{input: "10.10.10.1", want: []string{"10.10.10.1"}},
+		{input: "10.10.10.1,10.10.10.2", want: []string{"10.10.10.1", "10.10.10.2"}},
+		{input: "10.10.10.1, fd00::1,10.10.10.1", want: []string{"10.10.10.1", "fd00::1"}},
+		{input: "", wantError: true},
+		{input: "10.10.10.1,", wantError: true},
+		{input: "dns.example.com", wantError: true},
+		{input: "10.10.10.0/24", wantError: true},
+		{input: "fe80::1%wg0", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := CheckDNSServers(tc.input)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("error: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the CheckAlias function.
+func TestCheckAlias(t *testing.T) {
+	type testCase struct {
+		name      string
+		input     string
+		want      string
+		wantError bool
+	}
+
+	tests := []testCase{
+		{name: "text", input: "office vpn", want: "office vpn"},
+		{name: "empty clears", input: "", want: ""},
+		{name: "newlines stripped", input: "office\nvpn\r\n", want: "officevpn"},
+		{name: "maximum length", input: strings.Repeat("a", AliasMaxLength), want: strings.Repeat("a", AliasMaxLength)},
+		{name: "too long", input: strings.Repeat("a", AliasMaxLength+1), wantError: true},
+		{name: "multibyte too long", input: strings.Repeat("я", 128), wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := CheckAlias(tc.input)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("error: expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the CheckInterfaceName function against each rule.
+func TestCheckInterfaceName(t *testing.T) {
+	type testCase struct {
+		name      string
+		input     string
+		wantError string
+	}
+
+	tests := []testCase{
+		{name: "short", input: "wg0"},
+		{name: "single byte", input: "w"},
+		{name: "dash", input: "wg-office"},
+		{name: "underscore", input: "wg_office"},
+		{name: "dot", input: "wg.10"},
+		{name: "upper case", input: "WG0"},
+		{name: "maximum length", input: strings.Repeat("a", 15)},
+		{name: "empty", input: "", wantError: "is empty"},
+		{name: "too long", input: strings.Repeat("a", 16), wantError: "is 16 bytes long, the maximum is 15"},
+		{name: "forty bytes", input: strings.Repeat("wg", 20), wantError: "the maximum is 15"},
+		{name: "dot", input: ".", wantError: "is reserved"},
+		{name: "dot dot", input: "..", wantError: "is reserved"},
+		{name: "slash", input: "wg/0", wantError: `invalid character '/'`},
+		{name: "colon", input: "wg0:1", wantError: `invalid character ':'`},
+		{name: "space", input: "wg 0", wantError: `invalid character ' '`},
+		{name: "tab", input: "wg\t0", wantError: `invalid character '\t'`},
+		{name: "newline", input: "wg0\n", wantError: `invalid character '\n'`},
+		{name: "namespace separator", input: "wg0@ns", wantError: `invalid character '@'`},
+		{name: "shell", input: "wg0;reboot", wantError: `invalid character ';'`},
+		{name: "quote", input: "wg'0", wantError: `invalid character '\''`},
+		{name: "non ascii", input: "wgя", wantError: `invalid character 'я'`},
+		{name: "control", input: "wg\x00", wantError: `invalid character '\x00'`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckInterfaceName(tc.input)
+			if tc.wantError == "" {
+				if err != nil {
+					t.Errorf("error: unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("error: got %v, want an error containing %q", err, tc.wantError)
+			}
+		})
+	}
+}
+
+// Testing the CheckMTU function at the boundaries, with and without force.
+func TestCheckMTU(t *testing.T) {
+	type testCase struct {
+		mtu       int
+		force     bool
+		wantError bool
+	}
+
+	tests := []testCase{
+		{mtu: 1279, wantError: true},
+		{mtu: 1280},
+		{mtu: 1420},
+		{mtu: 8920},
+		{mtu: 9000},
+		{mtu: 9001, wantError: true},
+		{mtu: 500, wantError: true},
+		{mtu: 67, force: true, wantError: true},
+		{mtu: 68, force: true},
+		{mtu: 500, force: true},
+		{mtu: 1420, force: true},
+		{mtu: 65535, force: true},
+		{mtu: 65536, force: true, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("%d force %t", tc.mtu, tc.force), func(t *testing.T) {
+			err := CheckMTU(tc.mtu, tc.force)
+			if tc.wantError != (err != nil) {
+				t.Errorf("error: got %v, want error %t", err, tc.wantError)
+			}
+		})
+	}
+}
+
+// Testing the PrefixesOverlap function in both directions.
+func TestPrefixesOverlap(t *testing.T) {
+	type testCase struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}
+
+	tests := []testCase{
+		{name: "ipv4 duplicate", a: "10.10.10.2/32", b: "10.10.10.2/32", want: true},
+		{name: "ipv4 host in subnet", a: "10.10.10.2/32", b: "10.10.10.0/24", want: true},
+		{name: "ipv4 subnet contains host", a: "10.10.10.0/24", b: "10.10.10.2/32", want: true},
+		{name: "ipv4 unmasked subnet", a: "10.10.10.7/24", b: "10.10.10.200/32", want: true},
+		{name: "ipv4 default route", a: "0.0.0.0/0", b: "192.168.1.0/24", want: true},
+		{name: "ipv4 adjacent subnets", a: "10.10.10.0/25", b: "10.10.10.128/25", want: false},
+		{name: "ipv4 distinct hosts", a: "10.10.10.2/32", b: "10.10.10.3/32", want: false},
+		{name: "ipv4 distinct subnets", a: "10.10.10.0/24", b: "10.10.11.0/24", want: false},
+		{name: "ipv6 duplicate", a: "fd00::2/128", b: "fd00::2/128", want: true},
+		{name: "ipv6 host in subnet", a: "fd00::2/128", b: "fd00::/64", want: true},
+		{name: "ipv6 subnet contains subnet", a: "fd00::/48", b: "fd00:0:0:5::/64", want: true},
+		{name: "ipv6 default route", a: "::/0", b: "2001:db8::1/128", want: true},
+		{name: "ipv6 distinct subnets", a: "fd00:0:0:1::/64", b: "fd00:0:0:2::/64", want: false},
+		{name: "ipv6 distinct hosts", a: "fd00::2/128", b: "fd00::3/128", want: false},
+		{name: "families never overlap", a: "0.0.0.0/0", b: "::/0", want: false},
+		{name: "ipv4 against ipv6 host", a: "10.0.0.0/8", b: "::ffff:10.0.0.1/128", want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := CheckAllowedIPs([]string{tc.a})
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			b, err := CheckAllowedIPs([]string{tc.b})
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+
+			if got := PrefixesOverlap(a[0], b[0]); got != tc.want {
+				t.Errorf("error: %s and %s got %t, want %t", tc.a, tc.b, got, tc.want)
+			}
+			if got := PrefixesOverlap(b[0], a[0]); got != tc.want {
+				t.Errorf("error: %s and %s got %t, want %t", tc.b, tc.a, got, tc.want)
+			}
+		})
+	}
+
+	// A 16 byte IPv4 address with an IPv4 mask, as returned by some kernels.
+	mapped := net.IPNet{IP: net.ParseIP("10.10.10.2"), Mask: net.CIDRMask(32, 32)}
+	subnet := net.IPNet{IP: net.ParseIP("10.10.10.0").To4(), Mask: net.CIDRMask(24, 32)}
+	if !PrefixesOverlap(mapped, subnet) {
+		t.Errorf("error: 16 byte IPv4 address does not overlap its subnet")
+	}
+	if PrefixesOverlap(net.IPNet{}, subnet) {
+		t.Errorf("error: empty network overlaps")
+	}
+}
+
+// Testing the CheckKeepalive function at the range boundaries.
+func TestCheckKeepalive(t *testing.T) {
+	type testCase struct {
+		input     string
+		want      time.Duration
+		wantError bool
+	}
+
+	tests := []testCase{
+		{input: "0", want: 0},
+		{input: "1", want: time.Second},
+		{input: "25", want: 25 * time.Second},
+		{input: "65535", want: 65535 * time.Second},
+		{input: "65536", wantError: true},
+		{input: "999999", wantError: true},
+		{input: "-1", wantError: true},
+		{input: "abc", wantError: true},
+		{input: "10s", wantError: true},
+		{input: "1.5", wantError: true},
+		{input: "", wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := CheckKeepalive(tc.input)
+			if tc.wantError {
+				if err == nil || !strings.Contains(err.Error(), "0-65535") {
+					t.Fatalf("error: got %v, want the range error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("error: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the CheckEndPoint function at the port range boundaries.
+func TestCheckEndPoint(t *testing.T) {
+	type testCase struct {
+		input     string
+		want      string
+		wantError string
+	}
+
+	tests := []testCase{
+		{input: "89.89.89.1:1", want: "89.89.89.1:1"},
+		{input: "89.89.89.1:51820", want: "89.89.89.1:51820"},
+		{input: "89.89.89.1:65535", want: "89.89.89.1:65535"},
+		{input: "89.89.89.1:0", wantError: "invalid endpoint port 0"},
+		{input: "89.89.89.1:65536", wantError: "invalid endpoint port 65536"},
+		{input: "89.89.89.1:-1", wantError: "invalid endpoint port -1"},
+		{input: "89.89.89.1:abc", wantError: "invalid port value"},
+		{input: "89.89.89.1", wantError: "invalid endpoint format"},
+		{input: "example:51820", wantError: "invalid IPv4 address"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := CheckEndPoint(tc.input)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got %v, want %q", err, tc.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if got.String() != tc.want {
+				t.Errorf("error: got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// Testing the CheckEndpointHost function, IP addresses are not host names.
+func TestCheckEndpointHost(t *testing.T) {
+	type testCase struct {
+		input     string
+		wantHost  string
+		wantPort  int
+		wantError string
+	}
+
+	tests := []testCase{
+		{input: "vpn.example.com:51820", wantHost: "vpn.example.com", wantPort: 51820},
+		{input: "gateway:1", wantHost: "gateway", wantPort: 1},
+		{input: "my-home.dyndns.org.:65535", wantHost: "my-home.dyndns.org.", wantPort: 65535},
+		{input: "vpn.example.com", wantError: "invalid endpoint format"},
+		{input: "vpn.example.com:0", wantError: "invalid endpoint port 0"},
+		{input: "vpn.example.com:65536", wantError: "invalid endpoint port 65536"},
+		{input: "vpn.example.com:abc", wantError: "invalid port value"},
+		{input: "-vpn.example.com:51820", wantError: "invalid endpoint host name"},
+		{input: "vpn..example.com:51820", wantError: "invalid endpoint host name"},
+		{input: "vpn_1.example.com:51820", wantError: "invalid endpoint host name"},
+		{input: "89.89.89.1:51820", wantError: "invalid endpoint host name"},
+		{input: ":51820", wantError: "invalid endpoint host name"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			host, port, err := CheckEndpointHost(tc.input)
+			if tc.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+					t.Fatalf("error: got %v, want %q", err, tc.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error: unexpected error: %v", err)
+			}
+			if host != tc.wantHost || port != tc.wantPort {
+				t.Errorf("error: got %s %d, want %s %d", host, port, tc.wantHost, tc.wantPort)
+			}
+		})
+	}
+}
+
+// Testing the detection of an SSH session carried by the networks of an
+// interface, which fails open without usable variables.
+func TestSSHSessionRouted(t *testing.T) {
+	networks := []netip.Prefix{
+		netip.MustParsePrefix("10.10.10.0/24"),
+		netip.MustParsePrefix("fd00::/64"),
+	}
+
+	type testCase struct {
+		name       string
+		env        map[string]string
+		wantClient string
+		wantRouted bool
+	}
+
+	tests := []testCase{
+		{
+			name:       "routed connection",
+			env:        map[string]string{EnvSSHConnection: "10.10.10.2 51234 10.10.10.1 22"},
+			wantClient: "10.10.10.2",
+			wantRouted: true,
+		},
+		{
+			name:       "routed client",
+			env:        map[string]string{EnvSSHClient: "fd00::2 51234 22"},
+			wantClient: "fd00::2",
+			wantRouted: true,
+		},
+		{
+			name:       "mapped ipv4",
+			env:        map[string]string{EnvSSHConnection: "::ffff:10.10.10.3 51234 ::ffff:10.10.10.1 22"},
+			wantClient: "10.10.10.3",
+			wantRouted: true,
+		},
+		{
+			name: "not routed",
+			env:  map[string]string{EnvSSHConnection: "192.0.2.7 51234 198.51.100.1 22"},
+		},
+		{
+			name: "connection wins",
+			env: map[string]string{
+				EnvSSHConnection: "192.0.2.7 51234 198.51.100.1 22",
+				EnvSSHClient:     "10.10.10.2 51234 22",
+			},
+		},
+		{name: "missing", env: map[string]string{}},
+		{name: "empty", env: map[string]string{EnvSSHConnection: ""}},
+		{name: "unparsable", env: map[string]string{EnvSSHConnection: "localhost 51234 10.10.10.1 22"}},
+		{name: "truncated", env: map[string]string{EnvSSHClient: "10.10.10.2"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client, routed := SSHSessionRouted(tc.env, networks)
+			if routed != tc.wantRouted {
+				t.Fatalf("error: got routed %t, want %t", routed, tc.wantRouted)
+			}
+			if routed && client.String() != tc.wantClient {
+				t.Errorf("error: got client %s, want %s", client, tc.wantClient)
+			}
+		})
+	}
+}
+
+// Function returns a valid public key made of the base64 character.
+func testKey(char string) string {
+	return strings.Repeat(char, 43) + "="
+}
+
+// Function returns the problems formatted, `file:line: message`.
+func formatProblems(problems []Problem) []string {
+	var lines []string
+	for _, problem := range problems {
+		lines = append(lines, problem.Format("f"))
+	}
+	return lines
+}
+
+// Testing that every problem of a peer list is reported with its line,
+// not only the first.
+func TestCheckPeerList(t *testing.T) {
+	data := strings.Join([]string{
+		"public_key,allowed_ips,endpoint,persistent_keepalive,name,expires",
+		"# comment",
+		testKey("A") + ",10.10.10.2/32;fd00::2/128,89.89.89.1:51820,25,alice,2025-07-01",
+		"abc,10.10.10.3/32",
+		testKey("E") + ",10.10.10.300/32,vpn.example.com:0,70000,bob,tomorrow",
+		testKey("A") + ",10.10.10.4/32",
+		testKey("I") + ",10.10.10.0/24 fd00::/64,vpn.example.com:51820",
+		testKey("M") + ",",
+		"a,b,c,d,e,f,g",
+		`"unterminated`,
+	}, "\n")
+
+	want := []string{
+		"f:9: 7 fields, expected at most 6: public_key,allowed_ips,endpoint,persistent_keepalive,name,expires",
+		"f:10: invalid CSV, extraneous or missing \" in quoted-field",
+		"f:4: invalid public key 'abc'",
+		"f:5: peer '" + testKey("E") + "' has an invalid allowed IP '10.10.10.300/32', expected CIDR notation (e.g., 10.10.10.2/32)",
+		"f:5: invalid endpoint port 0 in 'vpn.example.com:0', expected range 1-65535",
+		"f:5: invalid persistent keepalive interval '70000', expected seconds in range 0-65535",
+		"f:5: invalid expiry 'tomorrow', expected format: `2025-07-01T00:00:00Z` or `2025-07-01`",
+		"f:6: peer '" + testKey("A") + "' is listed twice (first on line 3)",
+		"f:7: allowed IP '10.10.10.0/24' of peer '" + testKey("I") + "' conflicts with '10.10.10.2/32' of peer '" + testKey("A") + "'",
+		"f:7: allowed IP '10.10.10.0/24' of peer '" + testKey("I") + "' conflicts with '10.10.10.3/32' of peer 'abc'",
+		"f:7: allowed IP '10.10.10.0/24' of peer '" + testKey("I") + "' conflicts with '10.10.10.4/32' of peer '" + testKey("A") + "'",
+		"f:7: allowed IP 'fd00::/64' of peer '" + testKey("I") + "' conflicts with 'fd00::2/128' of peer '" + testKey("A") + "'",
+		"f:8: peer '" + testKey("M") + "' has no allowed IPs",
+	}
+
+	got := formatProblems(CheckPeerList([]byte(data)))
+	if !slices.Equal(got, want) {
+		t.Errorf("error: got problems\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if problems := CheckPeerList([]byte(testKey("A") + ",10.10.10.2/32\n")); len(problems) != 0 {
+		t.Errorf("error: got problems %q for a valid list", formatProblems(problems))
+	}
+}
+
+// Testing that every problem of a wg-quick configuration is reported with
+// its line, not only the first.
+func TestCheckWgQuick(t *testing.T) {
+	data := strings.Join([]string{
+		"Address = 10.10.10.1/24",
+		"[Interface]",
+		"PrivateKey = not-a-key",
+		"ListenPort = 70000",
+		"Address = 10.10.10.1/24, fd00::1",
+		"MTU = 1420",
+		"PostUp = iptables -A FORWARD -i %i -j ACCEPT # comment",
+		"Colour = blue",
+		"",
+		"[Peer]",
+		"PublicKey = " + testKey("A"),
+		"AllowedIPs = 10.10.10.2/32",
+		"AllowedIPs = fd00::2/128",
+		"Endpoint = 89.89.89.1",
+		"PersistentKeepalive = off",
+		"",
+		"[Peer]",
+		"PublicKey = " + testKey("E"),
+		"PresharedKey = short",
+		"AllowedIPs = 10.10.10.0/24",
+		"garbage",
+		"[Peers]",
+	}, "\n")
+
+	want := []string{
+		"f:1: key outside of a section, expected [Interface] or [Peer] first",
+		"f:3: invalid private key, expected 44 base64 characters ending with '='",
+		"f:4: invalid listen port 70000, expected range 1-65535",
+		"f:5: invalid address 'fd00::1', expected CIDR notation (e.g., 10.10.10.1/24)",
+		"f:8: unknown key 'Colour' in section [Interface]",
+		"f:21: invalid line 'garbage', expected `Key = Value`",
+		"f:22: unknown section [Peers], expected [Interface] or [Peer]",
+		"f:10: invalid endpoint format '89.89.89.1', expected format: `IP-address:port` (e.g., `89.89.89.1:51820`",
+		"f:17: peer '" + testKey("E") + "' has an invalid preshared key",
+		"f:17: allowed IP '10.10.10.0/24' of peer '" + testKey("E") + "' conflicts with '10.10.10.2/32' of peer '" + testKey("A") + "'",
+	}
+
+	got := formatProblems(CheckWgQuick([]byte(data)))
+	if !slices.Equal(got, want) {
+		t.Errorf("error: got problems\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if got := formatProblems(CheckWgQuick([]byte("[Peer]\n"))); !slices.Contains(got, "f: section [Interface] is missing") {
+		t.Errorf("error: got problems %q, want the missing [Interface]", got)
+	}
+}